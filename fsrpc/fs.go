@@ -102,7 +102,7 @@ func (f fs) root(ctx context.Context) (keybase1.ListResult, error) {
 func (f *fs) List(ctx context.Context, arg keybase1.ListArg) (keybase1.ListResult, error) {
 	f.log.CDebugf(ctx, "Listing %q", arg.Path)
 
-	kbfsPath, err := NewPath(arg.Path)
+	kbfsPath, err := ParsePath(arg.Path)
 	if err != nil {
 		return keybase1.ListResult{}, err
 	}
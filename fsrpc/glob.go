@@ -0,0 +1,279 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package fsrpc
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// globStar is the pattern component that matches zero or more path
+// components.
+const globStar = "**"
+
+func hasMeta(component string) bool {
+	return strings.ContainsAny(component, `*?[\`)
+}
+
+// globMatcher tracks, for a path being walked, which components of
+// the pattern could match next.
+type globMatcher struct {
+	pattern []string
+}
+
+// closure adds the states reachable without consuming a component,
+// i.e. by matching a globStar against zero components.
+func (g globMatcher) closure(states []int) []int {
+	seen := make(map[int]bool, len(states))
+	var res []int
+	for _, i := range states {
+		for ; !seen[i]; i++ {
+			seen[i] = true
+			res = append(res, i)
+			if i >= len(g.pattern) || g.pattern[i] != globStar {
+				break
+			}
+		}
+	}
+	sort.Ints(res)
+	return res
+}
+
+func (g globMatcher) matchOne(component, name string) bool {
+	// Like shells, don't match hidden entries with wildcards.
+	if strings.HasPrefix(name, ".") && !strings.HasPrefix(component, ".") {
+		return false
+	}
+	if component == globStar {
+		return true
+	}
+	ok, _ := path.Match(component, name)
+	return ok
+}
+
+// advance returns the closed set of states after matching `name`.
+func (g globMatcher) advance(states []int, name string) []int {
+	var next []int
+	for _, i := range states {
+		if i >= len(g.pattern) || !g.matchOne(g.pattern[i], name) {
+			continue
+		}
+		if g.pattern[i] == globStar {
+			next = append(next, i)
+		} else {
+			next = append(next, i+1)
+		}
+	}
+	return g.closure(next)
+}
+
+func (g globMatcher) accepts(states []int) bool {
+	return len(states) > 0 && states[len(states)-1] == len(g.pattern)
+}
+
+// literals returns the exact child names that could match from
+// `states`, or false if a child listing is needed.
+func (g globMatcher) literals(states []int) ([]string, bool) {
+	var names []string
+	for _, i := range states {
+		if i >= len(g.pattern) {
+			continue
+		}
+		if hasMeta(g.pattern[i]) {
+			return nil, false
+		}
+		names = append(names, g.pattern[i])
+	}
+	sort.Strings(names)
+	return names, true
+}
+
+// compareComponents orders paths the same way globber walks them:
+// component by component, with parents before their children.
+func compareComponents(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := strings.Compare(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+var errGlobPageFull = errors.New("glob page is full")
+
+type globber struct {
+	config   libkbfs.Config
+	root     Path
+	matcher  globMatcher
+	after    []string
+	limit    int
+	matches  []Path
+	hasAfter bool
+}
+
+func (g *globber) emit(components []string) error {
+	if g.hasAfter && compareComponents(components, g.after) <= 0 {
+		return nil
+	}
+	if g.limit > 0 && len(g.matches) == g.limit {
+		return errGlobPageFull
+	}
+	p := g.root
+	p.TLFComponents = append([]string(nil), components...)
+	g.matches = append(g.matches, p)
+	return nil
+}
+
+// skip returns true if the subtree at `components` was fully
+// returned in an earlier page.
+func (g *globber) skip(components []string) bool {
+	if !g.hasAfter || compareComponents(components, g.after) >= 0 {
+		return false
+	}
+	if len(components) > len(g.after) {
+		return true
+	}
+	return compareComponents(components, g.after[:len(components)]) != 0
+}
+
+func (g *globber) walk(ctx context.Context, node libkbfs.Node,
+	components []string, states []int) error {
+	kbfsOps := g.config.KBFSOps()
+	var names []string
+	types := make(map[string]libkbfs.EntryType)
+	if literals, ok := g.matcher.literals(states); ok {
+		for _, name := range literals {
+			_, ei, err := kbfsOps.Lookup(ctx, node, name)
+			if _, ok := errors.Cause(err).(libkbfs.NoSuchNameError); ok {
+				continue
+			} else if err != nil {
+				return err
+			}
+			if _, ok := types[name]; !ok {
+				names = append(names, name)
+			}
+			types[name] = ei.Type
+		}
+	} else {
+		children, err := kbfsOps.GetDirChildren(ctx, node)
+		if err != nil {
+			return err
+		}
+		for name, ei := range children {
+			names = append(names, name)
+			types[name] = ei.Type
+		}
+		sort.Strings(names)
+	}
+
+	for _, name := range names {
+		childComponents := append(components[:len(components):len(components)], name)
+		if g.skip(childComponents) {
+			continue
+		}
+		childStates := g.matcher.advance(states, name)
+		if len(childStates) == 0 {
+			continue
+		}
+		if g.matcher.accepts(childStates) {
+			if err := g.emit(childComponents); err != nil {
+				return err
+			}
+		}
+		if types[name] != libkbfs.Dir {
+			continue
+		}
+		if len(childStates) == 1 && childStates[0] == len(g.matcher.pattern) {
+			// Nothing more to match below this directory.
+			continue
+		}
+		childNode, _, err := kbfsOps.Lookup(ctx, node, name)
+		if err != nil {
+			return err
+		}
+		err = g.walk(ctx, childNode, childComponents, childStates)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Glob returns the paths within a single TLF that match `pattern`,
+// which is a KBFS path or URL (see ParsePath) whose components after
+// the TLF name may use path.Match syntax.  A "**" component matches
+// zero or more components, and wildcards don't match names beginning
+// with a dot.  Matches are found by walking directory metadata in
+// the KBFS client, so callers don't need to list whole trees.
+//
+// Matches are returned in a stable order, at most `pageSize` at a
+// time (or all of them if `pageSize` is not positive).  If more
+// matches remain, a non-empty `next` token is returned, which should
+// be passed back in as `pageToken` to get the next page.
+func Glob(ctx context.Context, config libkbfs.Config, pattern string,
+	pageSize int, pageToken string) (matches []Path, next string, err error) {
+	p, err := ParsePath(pattern)
+	if err != nil {
+		return nil, "", err
+	}
+	if p.PathType != TLFPathType {
+		return nil, "", errors.Errorf("%s is not within a TLF", p)
+	}
+	if hasMeta(p.TLFName) {
+		return nil, "", errors.Errorf(
+			"Patterns are not supported in TLF names: %s", p.TLFName)
+	}
+	for _, component := range p.TLFComponents {
+		if _, err := path.Match(component, ""); err != nil {
+			return nil, "", errors.Wrapf(err, "bad pattern %q", component)
+		}
+	}
+
+	rp, err := Resolve(ctx, config, p.String())
+	if err != nil {
+		return nil, "", err
+	}
+	root := rp.Path
+	root.TLFComponents = nil
+	g := &globber{
+		config:  config,
+		root:    root,
+		matcher: globMatcher{p.TLFComponents},
+		limit:   pageSize,
+	}
+	if pageToken != "" {
+		after, err := ParsePath(pageToken)
+		if err != nil {
+			return nil, "", err
+		}
+		g.after = after.TLFComponents
+		g.hasAfter = true
+	}
+
+	rootNode, _, err := root.GetNode(ctx, config)
+	if err != nil {
+		return nil, "", err
+	}
+	states := g.matcher.closure([]int{0})
+	if g.matcher.accepts(states) {
+		if err := g.emit(nil); err != nil {
+			return nil, "", err
+		}
+	}
+	err = g.walk(ctx, rootNode, nil, states)
+	switch err {
+	case nil:
+		return g.matches, "", nil
+	case errGlobPageFull:
+		return g.matches, g.matches[len(g.matches)-1].String(), nil
+	default:
+		return nil, "", err
+	}
+}
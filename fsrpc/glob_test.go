@@ -0,0 +1,136 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package fsrpc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func makeGlobTestTree(
+	ctx context.Context, t *testing.T, config libkbfs.Config) {
+	kbfsOps := config.KBFSOps()
+	root := libkbfs.GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	for _, p := range []string{
+		"a/x.txt",
+		"a/b/x.txt",
+		"a/b/c/x.txt",
+		"a/.hidden/x.txt",
+		".top.txt",
+		"y.go",
+	} {
+		components := strings.Split(p, "/")
+		dir := root
+		for _, name := range components[:len(components)-1] {
+			child, _, err := kbfsOps.Lookup(ctx, dir, name)
+			if _, ok := err.(libkbfs.NoSuchNameError); ok {
+				child, _, err = kbfsOps.CreateDir(ctx, dir, name)
+			}
+			require.NoError(t, err)
+			dir = child
+		}
+		_, _, err := kbfsOps.CreateFile(
+			ctx, dir, components[len(components)-1], false, libkbfs.NoExcl)
+		require.NoError(t, err)
+	}
+	err := kbfsOps.SyncAll(ctx, root.GetFolderBranch())
+	require.NoError(t, err)
+}
+
+func globTestPaths(matches []Path) []string {
+	paths := make([]string, 0, len(matches))
+	for _, m := range matches {
+		paths = append(paths, strings.Join(m.TLFComponents, "/"))
+	}
+	return paths
+}
+
+func TestGlob(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	config := libkbfs.MakeTestConfigOrBust(t, "alice")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	makeGlobTestTree(ctx, t, config)
+
+	for _, test := range []struct {
+		pattern string
+		matches []string
+	}{
+		// "**" matches zero components...
+		{"a/**/x.txt", []string{"a/b/c/x.txt", "a/b/x.txt", "a/x.txt"}},
+		// ... and many, starting at the root.
+		{"**/x.txt", []string{"a/b/c/x.txt", "a/b/x.txt", "a/x.txt"}},
+		// A trailing "**" matches the directory itself too.
+		{"a/**", []string{
+			"a", "a/b", "a/b/c", "a/b/c/x.txt", "a/b/x.txt", "a/x.txt"}},
+		{"a/**/c", []string{"a/b/c"}},
+		// Wildcards skip hidden names, unless the pattern starts
+		// with a dot.
+		{"*", []string{"a", "y.go"}},
+		{".*", []string{".top.txt"}},
+		{"a/*/x.txt", []string{"a/b/x.txt"}},
+		{"a/.*/x.txt", []string{"a/.hidden/x.txt"}},
+		{"a/.hidden/*", []string{"a/.hidden/x.txt"}},
+		// Literal components are looked up directly.
+		{"a/b/x.txt", []string{"a/b/x.txt"}},
+		{"a/nope/x.txt", []string{}},
+		{"*.go", []string{"y.go"}},
+		{"a/?/x.txt", []string{"a/b/x.txt"}},
+	} {
+		t.Run(test.pattern, func(t *testing.T) {
+			matches, next, err := Glob(
+				ctx, config, "/keybase/private/alice/"+test.pattern, 0, "")
+			require.NoError(t, err)
+			require.Equal(t, "", next)
+			require.Equal(t, test.matches, globTestPaths(matches))
+		})
+	}
+}
+
+func TestGlobPages(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	config := libkbfs.MakeTestConfigOrBust(t, "alice")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	makeGlobTestTree(ctx, t, config)
+
+	all := []string{
+		"a", "a/b", "a/b/c", "a/b/c/x.txt", "a/b/x.txt", "a/x.txt"}
+	for _, pageSize := range []int{1, 2, 4, len(all), len(all) + 1} {
+		var paths []string
+		var pageToken string
+		for i := 0; ; i++ {
+			require.True(t, i <= len(all), "too many pages")
+			matches, next, err := Glob(ctx, config,
+				"/keybase/private/alice/a/**", pageSize, pageToken)
+			require.NoError(t, err)
+			require.True(t, len(matches) <= pageSize)
+			paths = append(paths, globTestPaths(matches)...)
+			if next == "" {
+				break
+			}
+			pageToken = next
+		}
+		require.Equal(t, all, paths, "page size %d", pageSize)
+	}
+
+	t.Log("Resume after a match that's the parent of later ones")
+	matches, next, err := Glob(ctx, config, "/keybase/private/alice/a/**",
+		2, "/keybase/private/alice/a/b")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a/b/c", "a/b/c/x.txt"}, globTestPaths(matches))
+	require.Equal(t, "/keybase/private/alice/a/b/c/x.txt", next)
+
+	t.Log("Resume after a token that's no longer a match")
+	matches, next, err = Glob(ctx, config, "/keybase/private/alice/a/**",
+		0, "/keybase/private/alice/a/b/bb")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a/b/c", "a/b/c/x.txt", "a/b/x.txt", "a/x.txt"},
+		globTestPaths(matches))
+	require.Equal(t, "", next)
+}
@@ -7,8 +7,10 @@ package fsrpc
 import (
 	"fmt"
 	"path/filepath"
+	"strconv"
 	"strings"
 
+	"github.com/keybase/kbfs/kbfsmd"
 	"github.com/keybase/kbfs/libkbfs"
 	"github.com/keybase/kbfs/tlf"
 	"github.com/pkg/errors"
@@ -21,6 +23,14 @@ const (
 	privateName = "private"
 )
 
+const (
+	// TLFIDURLPrefix starts a KBFS URL that names its TLF by ID, like
+	// kbfs://tlfid/<id>/x, or kbfs://tlfid/<id>@rev=42/x to read the
+	// TLF as of a revision.
+	TLFIDURLPrefix = "kbfs://tlfid/"
+	revisionSep    = "@rev="
+)
+
 // PathType describes the types for different paths
 type PathType int
 
@@ -43,6 +53,11 @@ type Path struct {
 	TLFType       tlf.Type
 	TLFName       string
 	TLFComponents []string
+	// TLFID is set for TLF paths parsed from a TLF ID URL, in which
+	// case TLFName may be empty.
+	TLFID tlf.ID
+	// Revision, if set, pins the path to that revision of its TLF.
+	Revision kbfsmd.Revision
 }
 
 func splitHelper(cleanPath string) []string {
@@ -122,10 +137,67 @@ func NewPath(pathStr string) (Path, error) {
 	return p, nil
 }
 
+// ParseTLFIDElem parses the TLF element of a TLF ID URL, which is a
+// TLF ID optionally followed by "@rev=" and a revision number.
+func ParseTLFIDElem(elem string) (tlf.ID, kbfsmd.Revision, error) {
+	idStr, revStr := elem, ""
+	i := strings.Index(elem, revisionSep)
+	if i >= 0 {
+		idStr, revStr = elem[:i], elem[i+len(revisionSep):]
+	}
+	id, err := tlf.ParseID(idStr)
+	if err != nil {
+		return tlf.NullID, kbfsmd.RevisionUninitialized, err
+	}
+	if i < 0 {
+		return id, kbfsmd.RevisionUninitialized, nil
+	}
+	rev, err := strconv.ParseInt(revStr, 10, 64)
+	if err != nil || kbfsmd.Revision(rev) < kbfsmd.RevisionInitial {
+		return tlf.NullID, kbfsmd.RevisionUninitialized,
+			errors.Errorf("Invalid revision %q", revStr)
+	}
+	return id, kbfsmd.Revision(rev), nil
+}
+
+func newTLFIDPath(pathStr string) (Path, error) {
+	components, err := split(
+		"/" + strings.TrimPrefix(pathStr, TLFIDURLPrefix))
+	if err != nil {
+		return Path{}, err
+	}
+	if len(components) == 0 {
+		return Path{}, InvalidPathErr{pathStr}
+	}
+	id, rev, err := ParseTLFIDElem(components[0])
+	if err != nil {
+		return Path{}, errors.Wrapf(err, "invalid kbfs path %s", pathStr)
+	}
+	return Path{
+		PathType:      TLFPathType,
+		TLFType:       id.Type(),
+		TLFComponents: components[1:],
+		TLFID:         id,
+		Revision:      rev,
+	}, nil
+}
+
+func (p Path) tlfIDElem() string {
+	if p.Revision == kbfsmd.RevisionUninitialized {
+		return p.TLFID.String()
+	}
+	return p.TLFID.String() + revisionSep + strconv.FormatInt(
+		int64(p.Revision), 10)
+}
+
 func (p Path) String() string {
 	if p.PathType < RootPathType || p.PathType > TLFPathType {
 		return ""
 	}
+	if p.PathType == TLFPathType && p.TLFID != tlf.NullID {
+		return TLFIDURLPrefix + strings.Join(
+			append([]string{p.tlfIDElem()}, p.TLFComponents...), "/")
+	}
 
 	var components []string
 	if p.PathType >= KeybasePathType && p.PathType <= TLFPathType {
@@ -175,19 +247,18 @@ func (p Path) DirAndBasename() (dir Path, basename string, err error) {
 
 	case TLFPathType:
 		len := len(p.TLFComponents)
-		if len == 0 {
+		if len == 0 && p.TLFID != tlf.NullID {
+			// TLFs named by ID have no parent directory.
+			break
+		} else if len == 0 {
 			dir = Path{
 				PathType: KeybaseChildPathType,
 				TLFType:  p.TLFType,
 			}
 			basename = p.TLFName
 		} else {
-			dir = Path{
-				PathType:      TLFPathType,
-				TLFType:       p.TLFType,
-				TLFName:       p.TLFName,
-				TLFComponents: p.TLFComponents[:len-1],
-			}
+			dir = p
+			dir.TLFComponents = p.TLFComponents[:len-1]
 			basename = p.TLFComponents[len-1]
 		}
 		return
@@ -214,6 +285,7 @@ func (p Path) Join(childName string) (childPath Path, err error) {
 	case KeybasePathType:
 		if childName != publicName && childName != privateName {
 			err = CannotJoinPathErr{p, childName}
+			return
 		}
 
 		childPath = Path{
@@ -231,12 +303,8 @@ func (p Path) Join(childName string) (childPath Path, err error) {
 		return
 
 	case TLFPathType:
-		childPath = Path{
-			PathType:      TLFPathType,
-			TLFType:       p.TLFType,
-			TLFName:       p.TLFName,
-			TLFComponents: append(p.TLFComponents, childName),
-		}
+		childPath = p
+		childPath.TLFComponents = append(p.TLFComponents, childName)
 		return
 	}
 
@@ -272,6 +340,30 @@ outer:
 	return tlfHandle, nil
 }
 
+// GetHandle returns the handle of the TLF that the path is in, found
+// by ID if the path has one, and by name otherwise.
+func (p Path) GetHandle(ctx context.Context, config libkbfs.Config) (
+	*libkbfs.TlfHandle, error) {
+	if p.PathType != TLFPathType {
+		return nil, errors.Errorf("%s is not within a TLF", p)
+	}
+	if p.TLFID != tlf.NullID {
+		return libkbfs.GetHandleFromTlfID(ctx, config.MDOps(), p.TLFID)
+	}
+	return ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), p.TLFName, p.TLFType)
+}
+
+// BranchName returns the branch that the path refers to: the
+// read-only branch of its revision if it has one, and the master
+// branch otherwise.
+func (p Path) BranchName() libkbfs.BranchName {
+	if p.Revision != kbfsmd.RevisionUninitialized {
+		return libkbfs.MakeRevBranchName(p.Revision)
+	}
+	return libkbfs.MasterBranch
+}
+
 // GetNode returns a node
 func (p Path) GetNode(ctx context.Context, config libkbfs.Config) (libkbfs.Node, libkbfs.EntryInfo, error) {
 	if p.PathType != TLFPathType {
@@ -281,13 +373,21 @@ func (p Path) GetNode(ctx context.Context, config libkbfs.Config) (libkbfs.Node,
 		return nil, entryInfo, nil
 	}
 
-	tlfHandle, err := ParseTlfHandle(
-		ctx, config.KBPKI(), config.MDOps(), p.TLFName, p.TLFType)
+	tlfHandle, err := p.GetHandle(ctx, config)
 	if err != nil {
 		return nil, libkbfs.EntryInfo{}, err
 	}
 
-	node, entryInfo, err := config.KBFSOps().GetOrCreateRootNode(ctx, tlfHandle, libkbfs.MasterBranch)
+	var node libkbfs.Node
+	var entryInfo libkbfs.EntryInfo
+	if p.Revision != kbfsmd.RevisionUninitialized {
+		// A past revision can't be created.
+		node, entryInfo, err = config.KBFSOps().GetRootNode(
+			ctx, tlfHandle, p.BranchName())
+	} else {
+		node, entryInfo, err = config.KBFSOps().GetOrCreateRootNode(
+			ctx, tlfHandle, libkbfs.MasterBranch)
+	}
 	if err != nil {
 		return nil, libkbfs.EntryInfo{}, err
 	}
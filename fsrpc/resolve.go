@@ -0,0 +1,124 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package fsrpc
+
+import (
+	"strings"
+
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// URLScheme is the scheme prefix accepted by ParsePath for KBFS
+// URLs, like keybase://private/alice,bob@twitter/x.
+const URLScheme = "keybase://"
+
+// ParsePath constructs a Path from either an absolute KBFS path (like
+// /keybase/private/alice/x) or a KBFS URL (like
+// keybase://private/alice/x, or kbfs://tlfid/<id>@rev=42/x; see
+// TLFIDURLPrefix).
+func ParsePath(pathStr string) (Path, error) {
+	if strings.HasPrefix(pathStr, TLFIDURLPrefix) {
+		return newTLFIDPath(pathStr)
+	}
+	if strings.HasPrefix(pathStr, URLScheme) {
+		rest := strings.TrimPrefix(pathStr, URLScheme)
+		if rest == "" {
+			return NewPath("/" + topName)
+		}
+		return NewPath("/" + topName + "/" + rest)
+	}
+	return NewPath(pathStr)
+}
+
+// ResolvedPath describes the TLF that a path refers to, as seen by
+// the current user.
+type ResolvedPath struct {
+	// Path is the path with its TLF name replaced by the canonical
+	// one.
+	Path Path
+	// Handle is the canonical handle of the TLF.
+	Handle *libkbfs.TlfHandle
+	// FullyResolved is true if every assertion in the TLF name
+	// resolved to a user.
+	FullyResolved bool
+	// UnresolvedWriters and UnresolvedReaders list the assertions
+	// that have not yet been proven by any user.
+	UnresolvedWriters []string `json:",omitempty"`
+	UnresolvedReaders []string `json:",omitempty"`
+	// Writable is true if the current user can write to the TLF.
+	Writable bool
+}
+
+// String implements the fmt.Stringer interface for ResolvedPath.
+func (rp ResolvedPath) String() string {
+	return rp.Path.String()
+}
+
+// Resolve parses `pathStr` (see ParsePath) and resolves the TLF it
+// refers to, without creating the TLF if it doesn't exist yet.  The
+// returned path uses the canonical TLF name, and keeps the TLF ID
+// and revision if `pathStr` had them.
+func Resolve(ctx context.Context, config libkbfs.Config, pathStr string) (
+	ResolvedPath, error) {
+	p, err := ParsePath(pathStr)
+	if err != nil {
+		return ResolvedPath{}, err
+	}
+	if p.PathType != TLFPathType {
+		return ResolvedPath{}, errors.Errorf("%s is not within a TLF", p)
+	}
+
+	h, err := p.GetHandle(ctx, config)
+	if err != nil {
+		return ResolvedPath{}, err
+	}
+	p.TLFType = h.Type()
+	p.TLFName = string(h.GetCanonicalName())
+	rp := ResolvedPath{Path: p, Handle: h}
+
+	for _, a := range h.UnresolvedWriters() {
+		rp.UnresolvedWriters = append(rp.UnresolvedWriters, a.String())
+	}
+	for _, a := range h.UnresolvedReaders() {
+		rp.UnresolvedReaders = append(rp.UnresolvedReaders, a.String())
+	}
+	rp.FullyResolved =
+		len(rp.UnresolvedWriters) == 0 && len(rp.UnresolvedReaders) == 0
+
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	switch errors.Cause(err).(type) {
+	case nil:
+	case libkbfs.NoCurrentSessionError:
+		// Logged-out users can't write anywhere.
+		return rp, nil
+	default:
+		return ResolvedPath{}, err
+	}
+	// Finalized and conflicted TLFs, and past revisions, are
+	// read-only.
+	if p.Revision != kbfsmd.RevisionUninitialized ||
+		h.IsFinal() || h.IsConflict() {
+		return rp, nil
+	}
+	if h.TypeForKeying() == tlf.TeamKeying {
+		// Team membership needs to be checked with the service.
+		tid, err := h.FirstResolvedWriter().AsTeam()
+		if err != nil {
+			return ResolvedPath{}, err
+		}
+		rp.Writable, err = config.KBPKI().IsTeamWriter(
+			ctx, tid, session.UID, session.VerifyingKey)
+		if err != nil {
+			return ResolvedPath{}, err
+		}
+		return rp, nil
+	}
+	rp.Writable = h.IsWriter(session.UID)
+	return rp, nil
+}
@@ -0,0 +1,180 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package fsrpc
+
+import (
+	"testing"
+
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestParsePath(t *testing.T) {
+	privateID := tlf.FakeID(1, tlf.Private)
+	teamID := tlf.FakeID(2, tlf.SingleTeam)
+	for _, test := range []struct {
+		pathStr  string
+		expected Path
+	}{
+		{"/", Path{PathType: RootPathType}},
+		{"/keybase", Path{PathType: KeybasePathType}},
+		{"/keybase/public", Path{
+			PathType: KeybaseChildPathType, TLFType: tlf.Public}},
+		{"/keybase/private/alice/a/b", Path{
+			PathType:      TLFPathType,
+			TLFType:       tlf.Private,
+			TLFName:       "alice",
+			TLFComponents: []string{"a", "b"},
+		}},
+		{"/keybase/public/alice", Path{
+			PathType:      TLFPathType,
+			TLFType:       tlf.Public,
+			TLFName:       "alice",
+			TLFComponents: []string{},
+		}},
+		{"keybase://private/alice,bob/x", Path{
+			PathType:      TLFPathType,
+			TLFType:       tlf.Private,
+			TLFName:       "alice,bob",
+			TLFComponents: []string{"x"},
+		}},
+		{"/keybase/private/alice (conflicted copy 2016-03-14 #2)/x", Path{
+			PathType:      TLFPathType,
+			TLFType:       tlf.Private,
+			TLFName:       "alice (conflicted copy 2016-03-14 #2)",
+			TLFComponents: []string{"x"},
+		}},
+		{"/keybase/private/alice (files before bob account reset " +
+			"2016-03-14)", Path{
+			PathType: TLFPathType,
+			TLFType:  tlf.Private,
+			TLFName: "alice (files before bob account reset " +
+				"2016-03-14)",
+			TLFComponents: []string{},
+		}},
+		{TLFIDURLPrefix + privateID.String() + "/x", Path{
+			PathType:      TLFPathType,
+			TLFType:       tlf.Private,
+			TLFComponents: []string{"x"},
+			TLFID:         privateID,
+		}},
+		{TLFIDURLPrefix + privateID.String() + "@rev=42/x", Path{
+			PathType:      TLFPathType,
+			TLFType:       tlf.Private,
+			TLFComponents: []string{"x"},
+			TLFID:         privateID,
+			Revision:      42,
+		}},
+		{TLFIDURLPrefix + teamID.String(), Path{
+			PathType:      TLFPathType,
+			TLFType:       tlf.SingleTeam,
+			TLFComponents: []string{},
+			TLFID:         teamID,
+		}},
+	} {
+		t.Run(test.pathStr, func(t *testing.T) {
+			p, err := ParsePath(test.pathStr)
+			require.NoError(t, err)
+			require.Equal(t, test.expected, p)
+		})
+	}
+
+	for _, pathStr := range []string{
+		"keybase/private/alice",
+		"/keybase/team/t1",
+		"/other/private/alice",
+		TLFIDURLPrefix + "nope",
+		TLFIDURLPrefix + privateID.String() + "@rev=0",
+		TLFIDURLPrefix + privateID.String() + "@rev=x",
+	} {
+		_, err := ParsePath(pathStr)
+		require.Error(t, err, pathStr)
+	}
+}
+
+func getTlfIDForTest(ctx context.Context, t *testing.T,
+	config libkbfs.Config, name string, ty tlf.Type) tlf.ID {
+	h, err := libkbfs.GetHandleFromFolderNameAndType(
+		ctx, config.KBPKI(), config.MDOps(), name, ty)
+	require.NoError(t, err)
+	rootNode, _, err := config.KBFSOps().GetOrCreateRootNode(
+		ctx, h, libkbfs.MasterBranch)
+	require.NoError(t, err)
+	return rootNode.GetFolderBranch().Tlf
+}
+
+func TestResolve(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	config := libkbfs.MakeTestConfigOrBust(t, "alice", "bob")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	configBob := libkbfs.ConfigAsUser(config, "bob")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, configBob)
+	alice, err := config.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+	bob, err := configBob.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+
+	// Alice writes to t1, and only reads t2.
+	teamInfos := libkbfs.AddEmptyTeamsForTestOrBust(t, config, "t1", "t2")
+	_ = libkbfs.AddEmptyTeamsForTestOrBust(t, configBob, "t1", "t2")
+	for _, c := range []libkbfs.Config{config, configBob} {
+		libkbfs.AddTeamWriterForTestOrBust(t, c, teamInfos[0].TID, alice.UID)
+		libkbfs.AddTeamWriterForTestOrBust(t, c, teamInfos[1].TID, bob.UID)
+		libkbfs.AddTeamReaderForTestOrBust(t, c, teamInfos[1].TID, alice.UID)
+	}
+
+	privateID := getTlfIDForTest(ctx, t, config, "alice", tlf.Private)
+	writerTeamID := getTlfIDForTest(ctx, t, config, "t1", tlf.SingleTeam)
+	readerTeamID := getTlfIDForTest(
+		ctx, t, configBob, "t2", tlf.SingleTeam)
+
+	for _, test := range []struct {
+		pathStr   string
+		canonical string
+		writable  bool
+		resolved  bool
+	}{
+		{"/keybase/private/alice/x", "/keybase/private/alice/x", true, true},
+		{"/keybase/public/alice", "/keybase/public/alice", true, true},
+		{"/keybase/public/bob", "/keybase/public/bob", false, true},
+		{"keybase://private/bob,alice", "/keybase/private/alice,bob",
+			true, true},
+		{"/keybase/private/alice#charlie@twitter",
+			"/keybase/private/alice#charlie@twitter", true, false},
+		{"/keybase/private/alice (conflicted copy 2016-03-14 #2)",
+			"/keybase/private/alice (conflicted copy 2016-03-14 #2)",
+			false, true},
+		{"/keybase/private/alice (files before bob account reset " +
+			"2016-03-14)", "/keybase/private/alice (files before bob " +
+			"account reset 2016-03-14)", false, true},
+		{TLFIDURLPrefix + privateID.String() + "/x",
+			TLFIDURLPrefix + privateID.String() + "/x", true, true},
+		{TLFIDURLPrefix + privateID.String() + "@rev=1",
+			TLFIDURLPrefix + privateID.String() + "@rev=1", false, true},
+		{TLFIDURLPrefix + writerTeamID.String(),
+			TLFIDURLPrefix + writerTeamID.String(), true, true},
+		{TLFIDURLPrefix + readerTeamID.String(),
+			TLFIDURLPrefix + readerTeamID.String(), false, true},
+	} {
+		t.Run(test.pathStr, func(t *testing.T) {
+			rp, err := Resolve(ctx, config, test.pathStr)
+			require.NoError(t, err)
+			require.Equal(t, test.canonical, rp.String())
+			require.Equal(t, test.writable, rp.Writable)
+			require.Equal(t, test.resolved, rp.FullyResolved)
+		})
+	}
+
+	t.Log("Revisions are kept")
+	rp, err := Resolve(
+		ctx, config, TLFIDURLPrefix+privateID.String()+"@rev=1")
+	require.NoError(t, err)
+	require.Equal(t, kbfsmd.Revision(1), rp.Path.Revision)
+	require.Equal(t, "alice", rp.Path.TLFName)
+}
@@ -219,6 +219,40 @@ func DecryptPrivateMetadata(
 		encryptedPrivateMetadata.encryptedData, key.Data(), nonce)
 }
 
+// EncryptedDropEntry is an encrypted entry of a drop folder.
+type EncryptedDropEntry struct {
+	encryptedData
+}
+
+// EncryptEncodedDropEntry encrypts an encoded drop folder entry with
+// its per-entry key.
+func EncryptEncodedDropEntry(encodedEntry []byte, key TLFCryptKey) (
+	EncryptedDropEntry, error) {
+	encryptedData, err := encryptData(encodedEntry, key.Data())
+	if err != nil {
+		return EncryptedDropEntry{}, err
+	}
+
+	return EncryptedDropEntry{encryptedData}, nil
+}
+
+// DecryptDropEntry decrypts a drop folder entry, but does not decode
+// it.
+func DecryptDropEntry(encryptedEntry EncryptedDropEntry, key TLFCryptKey) (
+	[]byte, error) {
+	if encryptedEntry.encryptedData.Version != EncryptionSecretbox {
+		return nil, errors.WithStack(InvalidEncryptionVer{
+			encryptedEntry.encryptedData.Version})
+	}
+
+	nonce, err := encryptedEntry.encryptedData.Nonce24()
+	if err != nil {
+		return nil, err
+	}
+
+	return decryptData(encryptedEntry.encryptedData, key.Data(), nonce)
+}
+
 // EncryptedBlock is an encrypted Block object.
 type EncryptedBlock struct {
 	encryptedData
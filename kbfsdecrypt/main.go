@@ -0,0 +1,115 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// kbfsdecrypt turns a folder exported with `kbfstool export -raw`
+// back into plaintext files, without Keybase or its servers.  It
+// needs the folder's keys, either from an escrow bundle written by
+// `kbfstool export -escrow-passphrase-file`, or from a keys file
+// written by `kbfsescrow -keys`.
+//
+// A paper key isn't enough on its own: it only unlocks the device's
+// half of each folder key, and the other half is kept by the Keybase
+// key server.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const usageStr = `Usage:
+  kbfsdecrypt -keys <file> <raw dir> <dir>
+  kbfsdecrypt -bundle <file> -passphrase-file <file> <raw dir> <dir>
+
+Decrypts the raw folder export in <raw dir> into <dir>, which must
+not exist yet.  Folders whose blocks were wrapped by a block
+transform, such as an organization's key management service, can't
+be decrypted.
+`
+
+var keysPath = flag.String("keys", "",
+	"Read the folder's keys from this JSON file.")
+var bundlePath = flag.String("bundle", "",
+	"Read the folder's keys from this escrow bundle.")
+var passphrasePath = flag.String("passphrase-file", "",
+	"Read the passphrase of the escrow bundle from this file.")
+
+func readKeys() (*libfs.EscrowKeys, error) {
+	switch {
+	case *keysPath != "" && *bundlePath != "":
+		return nil, errors.New("Only one of -keys and -bundle may be given")
+	case *keysPath != "":
+		data, err := ioutil.ReadFile(*keysPath)
+		if err != nil {
+			return nil, err
+		}
+		keys := &libfs.EscrowKeys{}
+		if err := json.Unmarshal(data, keys); err != nil {
+			return nil, err
+		}
+		return keys, nil
+	case *bundlePath != "":
+		if *passphrasePath == "" {
+			return nil, errors.New("-passphrase-file must be specified")
+		}
+		data, err := ioutil.ReadFile(*passphrasePath)
+		if err != nil {
+			return nil, err
+		}
+		passphrase := []byte(strings.TrimRight(string(data), "\r\n"))
+		bundle, err := os.Open(*bundlePath)
+		if err != nil {
+			return nil, err
+		}
+		defer bundle.Close()
+		return libfs.ReadEscrowKeys(bundle, passphrase)
+	default:
+		// Public folders don't need any keys.
+		return &libfs.EscrowKeys{}, nil
+	}
+}
+
+func run(rawDir, outDir string) error {
+	keys, err := readKeys()
+	if err != nil {
+		return err
+	}
+	info, err := libkbfs.DecryptRawFolder(
+		context.Background(), rawDir, keys.Keys, nil, outDir)
+	if err != nil {
+		return err
+	}
+	if keys.TlfID != info.TlfID && len(keys.Keys) > 0 {
+		fmt.Fprintf(os.Stderr,
+			"kbfsdecrypt: warning: keys are for %s, not %s\n",
+			keys.Folder, info.Folder)
+	}
+	fmt.Printf("Decrypted %s at revision %d\n", info.Folder, info.Revision)
+	return nil
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usageStr)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if err := run(flag.Arg(0), flag.Arg(1)); err != nil {
+		fmt.Fprintf(os.Stderr, "kbfsdecrypt: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -26,6 +26,8 @@ var version = flag.Bool("version", false, "Print version")
 var mountFlags = flag.Int64("mount-flags", int64(libdokan.DefaultMountFlags), "Dokan mount flags")
 var dokandll = flag.String("dokan-dll", "", "Absolute path of dokan dll to load")
 var servicemount = flag.Bool("mount-from-service", false, "get mount path from service")
+var symlinkEscape = flag.String("symlink-escape", "allow", "what to do with symlinks pointing outside their folder: allow, warn, deny")
+var namespaces = flag.String("namespaces", "", "expose only these namespaces and folders, separated by |, e.g. \"team/acme|private\" (default: everything)")
 
 const usageFormatStr = `Usage:
   kbfsdokan -version
@@ -34,6 +36,8 @@ To run against remote KBFS servers:
   kbfsdokan
     [-runtime-dir=path/to/dir] [-label=label] [-mount-type=force]
     [-mount-flags=n] [-dokan-dll=path/to/dokan.dll]
+    [-symlink-escape=allow|warn|deny]
+    [-namespaces=private|public|team|team/<name>...]
 %s
     -mount-from-service | /path/to/mountpoint
 
@@ -41,6 +45,8 @@ To run in a local testing environment:
   kbfsdokan
     [-runtime-dir=path/to/dir] [-label=label] [-mount-type=force]
     [-mount-flags=n] [-dokan-dll=path/to/dokan.dll]
+    [-symlink-escape=allow|warn|deny]
+    [-namespaces=private|public|team|team/<name>...]
 %s
     -mount-from-service | /path/to/mountpoint
 
@@ -87,6 +93,18 @@ func start() *libfs.Error {
 		return libfs.InitError("extra arguments specified (flags go before the first argument)")
 	}
 
+	symlinkEscapePolicy, err := libfs.ParseSymlinkEscapePolicy(*symlinkEscape)
+	if err != nil {
+		fmt.Print(getUsageString(ctx))
+		return libfs.InitError(err.Error())
+	}
+
+	namespaceFilter, err := libfs.ParseNamespaceFilter(*namespaces)
+	if err != nil {
+		fmt.Print(getUsageString(ctx))
+		return libfs.InitError(err.Error())
+	}
+
 	options := libdokan.StartOptions{
 		KbfsParams: *kbfsParams,
 		RuntimeDir: *runtimeDir,
@@ -98,6 +116,9 @@ func start() *libfs.Error {
 		ForceMount: *mountType == "force",
 		SkipMount:  *mountType == "none",
 		MountPoint: mountpoint,
+
+		SymlinkEscapePolicy: symlinkEscapePolicy,
+		NamespaceFilter:     namespaceFilter,
 	}
 
 	return libdokan.Start(options, ctx)
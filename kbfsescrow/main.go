@@ -0,0 +1,112 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// kbfsescrow opens an escrow bundle written by `kbfstool export
+// -escrow-passphrase-file`, without needing Keybase or its servers.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/keybase/kbfs/libfs"
+	billy "gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+)
+
+const usageStr = `Usage:
+  kbfsescrow -passphrase-file <file> [-keys <file>] <bundle> <dir>
+
+Restores the folder in <bundle> into the local directory <dir>.
+`
+
+var passphrasePath = flag.String("passphrase-file", "",
+	"Read the passphrase of the bundle from this file.")
+var keysPath = flag.String("keys", "",
+	"Also write the folder's keys, as JSON, to this file.")
+
+// dirFS is a local directory that can also change mtimes.
+type dirFS struct {
+	billy.Filesystem
+	dir string
+}
+
+var _ billy.Change = dirFS{}
+
+func (fs dirFS) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(filepath.Join(fs.dir, name), mode)
+}
+
+func (fs dirFS) Lchown(name string, uid, gid int) error {
+	return os.Lchown(filepath.Join(fs.dir, name), uid, gid)
+}
+
+func (fs dirFS) Chown(name string, uid, gid int) error {
+	return os.Chown(filepath.Join(fs.dir, name), uid, gid)
+}
+
+func (fs dirFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(filepath.Join(fs.dir, name), atime, mtime)
+}
+
+func run(bundlePath, dir string) error {
+	if *passphrasePath == "" {
+		return errors.New("-passphrase-file must be specified")
+	}
+	data, err := ioutil.ReadFile(*passphrasePath)
+	if err != nil {
+		return err
+	}
+	passphrase := []byte(strings.TrimRight(string(data), "\r\n"))
+
+	bundle, err := os.Open(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer bundle.Close()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	keys, manifest, err := libfs.ImportEscrowBundle(
+		bundle, passphrase, dirFS{osfs.New(dir), dir})
+	if err != nil {
+		return err
+	}
+	if *keysPath != "" {
+		data, err := json.MarshalIndent(keys, "", "  ")
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(*keysPath, data, 0600)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Restored %d entries from %s at revision %d\n",
+		len(manifest.Entries), manifest.Folder, manifest.Revision)
+	return nil
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usageStr)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if err := run(flag.Arg(0), flag.Arg(1)); err != nil {
+		fmt.Fprintf(os.Stderr, "kbfsescrow: %s\n", err)
+		os.Exit(1)
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"bazil.org/fuse"
 
@@ -18,12 +20,21 @@ import (
 	"github.com/keybase/kbfs/libfs"
 	"github.com/keybase/kbfs/libfuse"
 	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
 )
 
 var runtimeDir = flag.String("runtime-dir", os.Getenv("KEYBASE_RUNTIME_DIR"), "runtime directory")
 var label = flag.String("label", os.Getenv("KEYBASE_LABEL"), "label to help identify if running as a service")
 var mountType = flag.String("mount-type", defaultMountType, "mount type: default, force, none")
 var version = flag.Bool("version", false, "Print version")
+var symlinkEscape = flag.String("symlink-escape", "allow", "what to do with symlinks pointing outside the mount: allow, warn, deny")
+var fsyncMode = flag.String("fsync-mode", "journal", "what fsync waits for, unless a file is opened with O_SYNC: journal (durable locally), server (flushed to the servers)")
+var remoteChange = flag.String("remote-change", "warn", "what to do when a file that is open locally is changed elsewhere: warn (notify and set an xattr), deny (also fail writes to it)")
+var unlink = flag.String("unlink", "delete", "what to do with unlinked files: delete, folder (move them into the folder's .trash if its settings ask for it), trash (always move them into the folder's .trash)")
+var identifyMode = flag.String("identify-mode", "default", "how identifies behave for operations through the mount: default, strict (identify on every access and fail on broken proofs, without popups), cli (report failures as the command-line client does), none (skip identifies, logging each folder accessed without one to the IDAUDIT log)")
+var mountBeforeInit = flag.Bool("mount-before-init", false, "mount right away, and finish logging in in the background")
+var watchdog = flag.Bool(libfuse.WatchdogFlag, false, "run KBFS in a child process, and if it dies or its mount stops responding, clean up the dead mount and remount")
+var namespaces = flag.String("namespaces", "", "expose only these namespaces and folders, separated by |, e.g. \"team/acme|private\" (default: everything)")
 
 const usageFormatStr = `Usage:
   kbfsfuse -version
@@ -31,12 +42,18 @@ const usageFormatStr = `Usage:
 To run against remote KBFS servers:
   kbfsfuse
     [-runtime-dir=path/to/dir] [-label=label] [-mount-type=default|force|required|none]
+    [-symlink-escape=allow|warn|deny] [-remote-change=warn|deny]
+    [-unlink=delete|folder|trash] [-mount-before-init] [-watchdog]
+    [-namespaces=private|public|team|team/<name>...]
 %s
     %s[/path/to/mountpoint]
 
 To run in a local testing environment:
   kbfsfuse
     [-runtime-dir=path/to/dir] [-label=label] [-mount-type=default|force|required|none]
+    [-symlink-escape=allow|warn|deny] [-remote-change=warn|deny]
+    [-unlink=delete|folder|trash] [-mount-before-init] [-watchdog]
+    [-namespaces=private|public|team|team/<name>...]
 %s
     %s[/path/to/mountpoint]
 
@@ -86,6 +103,46 @@ func start() *libfs.Error {
 		return libfs.InitError("extra arguments specified (flags go before the first argument)")
 	}
 
+	if *watchdog {
+		return runWatchdog(mountDir)
+	}
+
+	symlinkEscapePolicy, err := libfs.ParseSymlinkEscapePolicy(*symlinkEscape)
+	if err != nil {
+		fmt.Print(getUsageString(ctx))
+		return libfs.InitError(err.Error())
+	}
+
+	fsyncModeValue, err := libfs.ParseFsyncMode(*fsyncMode)
+	if err != nil {
+		fmt.Print(getUsageString(ctx))
+		return libfs.InitError(err.Error())
+	}
+
+	remoteChangePolicy, err := libfs.ParseRemoteChangePolicy(*remoteChange)
+	if err != nil {
+		fmt.Print(getUsageString(ctx))
+		return libfs.InitError(err.Error())
+	}
+
+	unlinkPolicy, err := libfs.ParseUnlinkPolicy(*unlink)
+	if err != nil {
+		fmt.Print(getUsageString(ctx))
+		return libfs.InitError(err.Error())
+	}
+
+	identifyModeValue, err := libkbfs.ParseIdentifyMode(*identifyMode)
+	if err != nil {
+		fmt.Print(getUsageString(ctx))
+		return libfs.InitError(err.Error())
+	}
+
+	namespaceFilter, err := libfs.ParseNamespaceFilter(*namespaces)
+	if err != nil {
+		fmt.Print(getUsageString(ctx))
+		return libfs.InitError(err.Error())
+	}
+
 	if kbfsParams.Debug {
 		fuseLog := logger.NewWithCallDepth("FUSE", 1)
 		fuseLog.Configure("", true, "")
@@ -102,11 +159,45 @@ func start() *libfs.Error {
 		MountErrorIsFatal: *mountType == "required",
 		SkipMount:         *mountType == "none",
 		MountPoint:        mountDir,
+
+		SymlinkEscapePolicy: symlinkEscapePolicy,
+		FsyncMode:           fsyncModeValue,
+		RemoteChangePolicy:  remoteChangePolicy,
+		UnlinkPolicy:        unlinkPolicy,
+		IdentifyMode:        identifyModeValue,
+		MountBeforeInit:     *mountBeforeInit,
+		NamespaceFilter:     namespaceFilter,
 	}
 
 	return libfuse.Start(options, ctx)
 }
 
+// runWatchdog runs kbfsfuse, with the same arguments, as a child
+// that is restarted whenever it dies, until interrupted.
+func runWatchdog(mountDir string) *libfs.Error {
+	log := logger.New("WATCHDOG")
+	w, err := libfuse.NewWatchdog(
+		log, mountDir, libfuse.WatchdogChildArgs(os.Args[1:]))
+	if err != nil {
+		return libfs.InitError(err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	go func() {
+		select {
+		case <-sigCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	w.Run(ctx)
+	return nil
+}
+
 func main() {
 	err := start()
 	if err != nil {
@@ -0,0 +1,87 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package kbfsfuzz
+
+import (
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+// fuzzBlockCryptVersioner always encrypts blocks with the latest
+// encryption version.
+type fuzzBlockCryptVersioner struct{}
+
+func (fuzzBlockCryptVersioner) BlockCryptVersion() kbfscrypto.EncryptionVer {
+	return kbfscrypto.EncryptionSecretboxWithKeyNonce
+}
+
+var (
+	fuzzTLFCryptKey     = kbfscrypto.MakeTLFCryptKey([32]byte{0x1})
+	fuzzBlockServerHalf = kbfscrypto.MakeBlockCryptKeyServerHalf(
+		[32]byte{0x2})
+)
+
+func decryptFuzzBlock(crypto libkbfs.CryptoCommon,
+	encryptedBlock kbfscrypto.EncryptedBlock) bool {
+	ok := false
+	for _, block := range []libkbfs.Block{
+		libkbfs.NewFileBlock(), libkbfs.NewDirBlock()} {
+		err := crypto.DecryptBlock(
+			encryptedBlock, fuzzTLFCryptKey, fuzzBlockServerHalf, block)
+		if err != nil {
+			continue
+		}
+		ok = true
+		_ = block.GetEncodedSize()
+		if bwp, isBWP := block.(libkbfs.BlockWithPtrs); isBWP &&
+			bwp.IsIndirect() {
+			for i := 0; i < bwp.NumIndirectPtrs(); i++ {
+				_, _ = bwp.IndirectPtr(i)
+			}
+		}
+	}
+	return ok
+}
+
+// FuzzBlock runs `data` through the steps a block fetched from the
+// server goes through: verifying its ID, decoding the encrypted
+// block, decrypting it, and decoding the plaintext as a file or
+// directory block.  Since random data hardly ever decrypts, `data`
+// is also encrypted as if it were a padded, encoded block written by
+// another client, and then decrypted and decoded.
+func FuzzBlock(data []byte) int {
+	codec := makeCodec()
+	crypto := libkbfs.MakeCryptoCommon(codec, fuzzBlockCryptVersioner{})
+	ret := fuzzUninteresting
+
+	id, err := kbfsblock.MakePermanentID(
+		data, kbfscrypto.EncryptionSecretboxWithKeyNonce)
+	if err == nil {
+		_ = kbfsblock.VerifyID(data, id)
+	}
+
+	var encryptedBlock kbfscrypto.EncryptedBlock
+	if codec.Decode(data, &encryptedBlock) == nil {
+		ret = fuzzInteresting
+		_ = encryptedBlock.Version.ToHashType()
+		decryptFuzzBlock(crypto, encryptedBlock)
+	}
+
+	for _, ver := range []kbfscrypto.EncryptionVer{
+		kbfscrypto.EncryptionSecretbox,
+		kbfscrypto.EncryptionSecretboxWithKeyNonce,
+	} {
+		encryptedBlock, err := kbfscrypto.EncryptPaddedEncodedBlock(
+			data, fuzzTLFCryptKey, fuzzBlockServerHalf, ver)
+		if err != nil {
+			continue
+		}
+		if decryptFuzzBlock(crypto, encryptedBlock) {
+			ret = fuzzInteresting
+		}
+	}
+	return ret
+}
@@ -0,0 +1,37 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// Package kbfsfuzz contains fuzzing targets for the code that parses
+// data from servers and other clients: MD objects, blocks, and
+// paths.  Each target is a function in the form go-fuzz expects, and
+// can be built for go-fuzz or libFuzzer with the fuzz targets of the
+// top-level Makefile; "make fuzz FUZZ_TARGET=FuzzBlock" builds and
+// runs FuzzBlock under go-fuzz, keeping its corpus and crashers in
+// fuzz-work/FuzzBlock.
+//
+// The package builds and is tested without any fuzzing tools, so the
+// targets can't bit-rot.
+package kbfsfuzz
+
+import (
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+// The values a target returns, as go-fuzz expects.
+const (
+	// fuzzUninteresting means the input didn't get past the
+	// initial parsing.
+	fuzzUninteresting = 0
+	// fuzzInteresting means the input got past the initial
+	// parsing, so go-fuzz should prioritize mutating it.
+	fuzzInteresting = 1
+)
+
+// makeCodec returns a codec set up the way KBFS sets up its own.
+func makeCodec() kbfscodec.Codec {
+	codec := kbfscodec.NewMsgpack()
+	libkbfs.RegisterOps(codec)
+	return codec
+}
@@ -0,0 +1,102 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package kbfsfuzz
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func makeMDSeeds(t *testing.T) (seeds [][]byte) {
+	codec := makeCodec()
+	signer := kbfscrypto.SigningKeySigner{
+		Key: kbfscrypto.MakeFakeSigningKeyOrBust("fuzz"),
+	}
+	h, err := tlf.MakeHandle(
+		[]keybase1.UserOrTeamID{keybase1.MakeTestUID(1).AsUserOrTeam()},
+		[]keybase1.UserOrTeamID{keybase1.PublicUID.AsUserOrTeam()},
+		nil, nil, nil)
+	require.NoError(t, err)
+	for _, ver := range []kbfsmd.MetadataVer{
+		kbfsmd.InitialExtraMetadataVer, kbfsmd.SegregatedKeyBundlesVer} {
+		md, err := kbfsmd.MakeInitialRootMetadata(ver, fuzzTlfIDs[1], h)
+		require.NoError(t, err)
+		rmds, err := kbfsmd.SignRootMetadata(
+			context.Background(), codec, signer, signer, md)
+		require.NoError(t, err)
+		buf, err := kbfsmd.EncodeRootMetadataSigned(codec, rmds)
+		require.NoError(t, err)
+		seeds = append(seeds, buf)
+	}
+	return seeds
+}
+
+func makeBlockSeeds(t *testing.T) (seeds [][]byte) {
+	codec := makeCodec()
+	crypto := libkbfs.MakeCryptoCommon(codec, fuzzBlockCryptVersioner{})
+	fblock := libkbfs.NewFileBlock().(*libkbfs.FileBlock)
+	fblock.Contents = []byte("fuzz")
+	_, encryptedBlock, err := crypto.EncryptBlock(
+		fblock, fuzzTLFCryptKey, fuzzBlockServerHalf)
+	require.NoError(t, err)
+	buf, err := codec.Encode(encryptedBlock)
+	require.NoError(t, err)
+	return [][]byte{buf}
+}
+
+func mutate(r *rand.Rand, seed []byte) []byte {
+	data := append([]byte(nil), seed...)
+	if len(data) == 0 {
+		return data
+	}
+	for i := 0; i < 1+r.Intn(4); i++ {
+		data[r.Intn(len(data))] = byte(r.Intn(256))
+	}
+	return data[:r.Intn(len(data)+1)]
+}
+
+// testFuzzTarget runs a target on its seeds, which must be
+// interesting, and on random mutations of them, which must not
+// panic.
+func testFuzzTarget(
+	t *testing.T, target func([]byte) int, seeds [][]byte) {
+	for _, seed := range seeds {
+		require.Equal(t, fuzzInteresting, target(seed))
+	}
+	r := rand.New(rand.NewSource(1))
+	target(nil)
+	for i := 0; i < 200; i++ {
+		random := make([]byte, r.Intn(512))
+		r.Read(random)
+		target(random)
+		for _, seed := range seeds {
+			target(mutate(r, seed))
+		}
+	}
+}
+
+func TestFuzzMD(t *testing.T) {
+	testFuzzTarget(t, FuzzMD, makeMDSeeds(t))
+}
+
+func TestFuzzBlock(t *testing.T) {
+	testFuzzTarget(t, FuzzBlock, makeBlockSeeds(t))
+}
+
+func TestFuzzPath(t *testing.T) {
+	testFuzzTarget(t, FuzzPath, [][]byte{
+		[]byte("/keybase/private/alice,bob#charlie/dir/file"),
+		[]byte("/keybase/public/alice (conflicted copy 2017-01-01 #1)"),
+		[]byte("kbfs://tlfid/" + fuzzTlfIDs[0].String() + "@rev=42/x"),
+	})
+}
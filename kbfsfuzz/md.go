@@ -0,0 +1,76 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package kbfsfuzz
+
+import (
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+// noTeamMembershipChecker says no one is a member of any team.
+type noTeamMembershipChecker struct{}
+
+func (noTeamMembershipChecker) IsTeamWriter(
+	_ context.Context, _ keybase1.TeamID, _ keybase1.UID,
+	_ kbfscrypto.VerifyingKey) (bool, error) {
+	return false, nil
+}
+
+func (noTeamMembershipChecker) IsTeamReader(
+	_ context.Context, _ keybase1.TeamID, _ keybase1.UID) (bool, error) {
+	return false, nil
+}
+
+var fuzzTlfIDs = []tlf.ID{
+	tlf.FakeID(1, tlf.Private),
+	tlf.FakeID(2, tlf.Public),
+	tlf.FakeID(3, tlf.SingleTeam),
+}
+
+// FuzzMD decodes `data` as a signed MD object of every metadata
+// version, the way MD from the server is decoded, and checks its
+// validity and signatures.  It also decodes `data` as each kind of
+// key bundle.
+func FuzzMD(data []byte) int {
+	ctx := context.Background()
+	codec := makeCodec()
+	ret := fuzzUninteresting
+	for ver := kbfsmd.FirstValidMetadataVer; ver <= kbfsmd.ImplicitTeamsVer; ver++ {
+		for _, id := range fuzzTlfIDs {
+			rmds, err := kbfsmd.DecodeRootMetadataSigned(
+				codec, id, ver, kbfsmd.ImplicitTeamsVer, data)
+			if err != nil {
+				continue
+			}
+			ret = fuzzInteresting
+			_ = rmds.IsValidAndSigned(
+				ctx, codec, noTeamMembershipChecker{}, nil)
+			_, _ = rmds.MD.DeepCopy(codec)
+		}
+	}
+
+	var wkbV3 kbfsmd.TLFWriterKeyBundleV3
+	if codec.Decode(data, &wkbV3) == nil {
+		ret = fuzzInteresting
+		_, _ = kbfsmd.MakeTLFWriterKeyBundleID(codec, wkbV3)
+	}
+	var rkbV3 kbfsmd.TLFReaderKeyBundleV3
+	if codec.Decode(data, &rkbV3) == nil {
+		ret = fuzzInteresting
+		_, _ = kbfsmd.MakeTLFReaderKeyBundleID(codec, rkbV3)
+	}
+	var wkbsV2 kbfsmd.TLFWriterKeyGenerationsV2
+	if codec.Decode(data, &wkbsV2) == nil {
+		ret = fuzzInteresting
+	}
+	var rkbsV2 kbfsmd.TLFReaderKeyGenerationsV2
+	if codec.Decode(data, &rkbsV2) == nil {
+		ret = fuzzInteresting
+	}
+	return ret
+}
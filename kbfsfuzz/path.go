@@ -0,0 +1,41 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package kbfsfuzz
+
+import (
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/tlf"
+)
+
+// FuzzPath parses `data` as a KBFS path, and as a TLF name with its
+// extensions and type.  TLF names and paths come from other clients
+// through symlinks, favorites and edit notifications, as well as
+// from the user.
+func FuzzPath(data []byte) int {
+	s := string(data)
+	ret := fuzzUninteresting
+
+	p, err := fsrpc.NewPath(s)
+	if err == nil {
+		ret = fuzzInteresting
+		_ = p.String()
+		if dir, _, err := p.DirAndBasename(); err == nil {
+			_ = dir.String()
+		}
+		_, _ = p.Join("child")
+	}
+
+	_, _, _ = fsrpc.ParseTLFIDElem(s)
+	_, _ = tlf.ParseTlfTypeFromPath(s)
+
+	_, _, extensionSuffix, err := tlf.SplitName(s)
+	if err == nil {
+		ret = fuzzInteresting
+		if extensionSuffix != "" {
+			_, _ = tlf.ParseHandleExtensionSuffix(extensionSuffix)
+		}
+	}
+	return ret
+}
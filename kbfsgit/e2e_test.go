@@ -0,0 +1,562 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// These tests drive real git binaries against the remote helper.  The
+// test binary doubles as the helper: each test puts a symlink to it
+// named `git-remote-keybase` at the front of git's PATH, and when
+// it's run under that name, TestMain runs the same `Start()` as the
+// real helper does.  All helper processes for a test share one set
+// of on-disk KBFS servers, and act as a local user, so no Keybase
+// service is needed.
+//
+// By default the tests use the `git` in PATH.  To test several git
+// versions, list their binaries in KBFSGIT_E2E_GITS, separated by
+// the OS path list separator.  -kbfsgit-e2e-large adds a test with a
+// large repo.
+//
+// The helper doesn't support git-lfs, so there are no LFS tests.
+
+package kbfsgit
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/env"
+	"github.com/keybase/kbfs/libgit"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/stderrutils"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+var e2eLarge = flag.Bool("kbfsgit-e2e-large", false,
+	"Also run the end-to-end git tests against a large repo")
+
+const (
+	// e2eHelperName and e2eGCName are the names under which the
+	// test binary acts as the remote helper, or runs a repo GC.
+	e2eHelperName = "git-remote-keybase"
+	e2eGCName     = "kbfsgit-e2e-gc"
+
+	// envE2ERoot is the directory holding the shared on-disk
+	// servers and the helper log.
+	envE2ERoot = "KBFSGIT_E2E_ROOT"
+	// envE2EGits lists the git binaries to test against.
+	envE2EGits = "KBFSGIT_E2E_GITS"
+
+	// e2eUser must be one of the built-in local users.  Repos live
+	// in a TLF shared with another user, since lazily creating repos
+	// is only allowed in TLFs the app doesn't manage.
+	e2eUser = "strib"
+	e2eTlf  = "strib,max"
+)
+
+func TestMain(m *testing.M) {
+	switch filepath.Base(os.Args[0]) {
+	case e2eHelperName:
+		os.Exit(runE2EHelper(os.Args[1:]))
+	case e2eGCName:
+		os.Exit(runE2EGC(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+// e2eParams returns the KBFS params for a helper process, along with
+// the temporary storage directory the caller must remove.
+func e2eParams() (
+	kbCtx libkbfs.Context, params libkbfs.InitParams, storageRoot,
+	logPath string, err error) {
+	root := os.Getenv(envE2ERoot)
+	if root == "" {
+		return nil, libkbfs.InitParams{}, "", "",
+			fmt.Errorf("%s isn't set", envE2ERoot)
+	}
+	kbCtx = env.NewContext()
+	base := libkbfs.DefaultInitParams(kbCtx)
+	base.LocalUser = e2eUser
+	base.LocalFavoriteStorage = "memory"
+	base.BServerAddr = "dir:" + filepath.Join(root, "server")
+	base.MDServerAddr = base.BServerAddr
+	params, storageRoot, err = libgit.Params(
+		kbCtx, filepath.Join(root, "storage"), &base)
+	if err != nil {
+		return nil, libkbfs.InitParams{}, "", "", err
+	}
+	return kbCtx, params, storageRoot, filepath.Join(root, "git.log"), nil
+}
+
+// runE2EHelper is the test binary's stand-in for the main function
+// of git-remote-keybase.
+func runE2EHelper(args []string) int {
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s <remote> [<repo>]\n", e2eHelperName)
+		return 1
+	}
+	kbCtx, params, storageRoot, logPath, err := e2eParams()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s error: %+v\n", e2eHelperName, err)
+		return 1
+	}
+	defer os.RemoveAll(storageRoot)
+
+	// Logging to a file takes over stderr, so keep a copy for git.
+	stderrFile, err := stderrutils.DupStderr()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s error: %+v\n", e2eHelperName, err)
+		return 1
+	}
+	defer stderrFile.Close()
+
+	options := StartOptions{
+		KbfsParams: params,
+		Remote:     args[0],
+		GitDir:     filepath.FromSlash(os.Getenv("GIT_DIR")),
+	}
+	if len(args) > 1 {
+		options.Repo = args[1]
+	}
+	startErr := Start(context.Background(), options, kbCtx, logPath,
+		os.Stdin, os.Stdout, stderrFile)
+	if startErr != nil {
+		fmt.Fprintf(stderrFile, "%s error: (%d) %s\n",
+			e2eHelperName, startErr.Code, startErr.Message)
+		return startErr.Code
+	}
+	return 0
+}
+
+// runE2EGC garbage-collects the given repo, like `keybase git gc`
+// does, as aggressively as possible.
+func runE2EGC(args []string) (code int) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s <repo>\n", e2eGCName)
+		return 1
+	}
+	defer func() {
+		if code != 0 {
+			fmt.Fprintf(os.Stderr, "%s failed\n", e2eGCName)
+		}
+	}()
+	kbCtx, params, storageRoot, logPath, err := e2eParams()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s error: %+v\n", e2eGCName, err)
+		return 1
+	}
+	defer os.RemoveAll(storageRoot)
+
+	ctx, config, err := libgit.Init(
+		context.Background(), params, kbCtx, nil, logPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s error: %+v\n", e2eGCName, err)
+		return 1
+	}
+	defer config.Shutdown(ctx)
+	log := config.MakeLogger("")
+
+	h, err := libkbfs.GetHandleFromFolderNameAndType(
+		ctx, config.KBPKI(), config.MDOps(), e2eTlf, tlf.Private)
+	if err != nil {
+		log.CDebugf(ctx, "Couldn't get handle: %+v", err)
+		return 1
+	}
+	err = libgit.GCRepo(ctx, config, h, args[0], libgit.GCOptions{
+		MaxLooseRefs:         0,
+		PruneMinLooseObjects: 0,
+		PruneExpireTime:      time.Now(),
+		MaxObjectPacks:       0,
+	})
+	if err != nil {
+		log.CDebugf(ctx, "Couldn't GC: %+v", err)
+		return 1
+	}
+
+	rootNode, _, err := config.KBFSOps().GetOrCreateRootNode(
+		ctx, h, libkbfs.MasterBranch)
+	if err == nil {
+		err = config.KBFSOps().SyncAll(ctx, rootNode.GetFolderBranch())
+	}
+	if err == nil {
+		err = libkbfs.WaitForTLFJournal(
+			ctx, config, rootNode.GetFolderBranch().Tlf, log)
+	}
+	if err != nil {
+		log.CDebugf(ctx, "Couldn't flush the GC: %+v", err)
+		return 1
+	}
+	return 0
+}
+
+// e2eGits returns the git binaries to test against.
+func e2eGits(t *testing.T) []string {
+	if gits := os.Getenv(envE2EGits); gits != "" {
+		return filepath.SplitList(gits)
+	}
+	git, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("No git binary found")
+	}
+	return []string{git}
+}
+
+// testOverGits runs f once for each git binary to test against.
+func testOverGits(t *testing.T, f func(t *testing.T, e *e2eEnv)) {
+	if testing.Short() {
+		t.Skip("Skipping end-to-end git test in short mode")
+	}
+	for _, git := range e2eGits(t) {
+		out, err := exec.Command(git, "version").CombinedOutput()
+		require.NoError(t, err, "%s: %s", git, out)
+		version := strings.TrimPrefix(
+			strings.TrimSpace(string(out)), "git version ")
+		t.Run(version, func(t *testing.T) {
+			e := newE2EEnv(t, git)
+			defer e.cleanup()
+			f(t, e)
+		})
+	}
+}
+
+// e2eEnv is a sandbox for running git against KBFS-hosted repos.
+type e2eEnv struct {
+	t    *testing.T
+	git  string
+	root string
+	env  []string
+}
+
+func newE2EEnv(t *testing.T, git string) *e2eEnv {
+	root, err := ioutil.TempDir(os.TempDir(), "kbfsgit_e2e")
+	require.NoError(t, err)
+	e := &e2eEnv{t: t, git: git, root: root}
+	success := false
+	defer func() {
+		if !success {
+			e.cleanup()
+		}
+	}()
+
+	self, err := os.Executable()
+	require.NoError(t, err)
+	bin := filepath.Join(root, "bin")
+	home := filepath.Join(root, "home")
+	for _, dir := range []string{bin, home, filepath.Join(root, "storage")} {
+		require.NoError(t, os.MkdirAll(dir, 0700))
+	}
+	for _, name := range []string{e2eHelperName, e2eGCName} {
+		if err := os.Symlink(self, filepath.Join(bin, name)); err != nil {
+			t.Skipf("Can't link the test binary as %s: %+v", name, err)
+		}
+	}
+
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "GIT_") || strings.HasPrefix(kv, "PATH=") ||
+			strings.HasPrefix(kv, "HOME=") {
+			continue
+		}
+		e.env = append(e.env, kv)
+	}
+	e.env = append(e.env,
+		"PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"),
+		"HOME="+home,
+		"GIT_CONFIG_NOSYSTEM=1",
+		"GIT_AUTHOR_NAME=e2e", "GIT_AUTHOR_EMAIL=e2e@keybase.io",
+		"GIT_COMMITTER_NAME=e2e", "GIT_COMMITTER_EMAIL=e2e@keybase.io",
+		envE2ERoot+"="+root)
+	success = true
+	return e
+}
+
+func (e *e2eEnv) cleanup() {
+	os.RemoveAll(e.root)
+}
+
+// url returns the KBFS URL of the given repo.
+func (e *e2eEnv) url(repo string) string {
+	return "keybase://private/" + e2eTlf + "/" + repo
+}
+
+// dir returns the path of a local working directory.
+func (e *e2eEnv) dir(name string) string {
+	return filepath.Join(e.root, "work", name)
+}
+
+func (e *e2eEnv) run(dir, name string, args ...string) string {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	cmd.Env = e.env
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		logTail, _ := ioutil.ReadFile(filepath.Join(e.root, "git.log"))
+		if len(logTail) > 16*1024 {
+			logTail = logTail[len(logTail)-16*1024:]
+		}
+		e.t.Fatalf("%s %s failed: %+v\nstderr:\n%s\nhelper log tail:\n%s",
+			filepath.Base(name), strings.Join(args, " "), err, stderr.String(),
+			logTail)
+	}
+	return string(out)
+}
+
+// gitIn runs git in the given working directory, failing the test
+// on error, and returns its stdout.
+func (e *e2eEnv) gitIn(dir string, args ...string) string {
+	return e.run(dir, e.git, args...)
+}
+
+// gc runs a full GC of the given KBFS repo.
+func (e *e2eEnv) gc(repo string) {
+	e.run(e.root, filepath.Join(e.root, "bin", e2eGCName), repo)
+}
+
+// refs returns all the refs in the given working directory under
+// the given prefix, with the prefix stripped.
+func (e *e2eEnv) refs(dir, prefix string) map[string]string {
+	out := e.gitIn(dir, "for-each-ref", "--format=%(refname) %(objectname)",
+		prefix)
+	refs := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		name := strings.TrimPrefix(fields[0], prefix)
+		if name == "HEAD" {
+			continue
+		}
+		refs[name] = fields[1]
+	}
+	return refs
+}
+
+// remoteRefs returns the refs of the given KBFS repo, as listed by
+// `git ls-remote`.
+func (e *e2eEnv) remoteRefs(repo string) map[string]string {
+	out := e.gitIn(e.root, "ls-remote", e.url(repo))
+	refs := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[1] == "HEAD" {
+			continue
+		}
+		refs[fields[1]] = fields[0]
+	}
+	return refs
+}
+
+// requireSameRepo checks that clone has the same branches and tags
+// as source, as seen through the given remote, and that clone is
+// intact.
+func (e *e2eEnv) requireSameRepo(source, clone, remote string) {
+	require.Equal(e.t, e.refs(source, "refs/heads/"),
+		e.refs(clone, "refs/remotes/"+remote+"/"))
+	require.Equal(e.t, e.refs(source, "refs/tags/"),
+		e.refs(clone, "refs/tags/"))
+	e.gitIn(clone, "fsck", "--full", "--strict")
+}
+
+// e2eFixture describes a generated repo.
+type e2eFixture struct {
+	commits  int
+	files    int
+	fileSize int
+	// binaryEvery makes every nth commit add an incompressible
+	// file of binarySize bytes.
+	binaryEvery int
+	binarySize  int
+}
+
+var (
+	e2eSmallFixture = e2eFixture{
+		commits: 10, files: 20, fileSize: 1024,
+		binaryEvery: 5, binarySize: 64 * 1024,
+	}
+	e2eLargeFixture = e2eFixture{
+		commits: 300, files: 2000, fileSize: 4096,
+		binaryEvery: 25, binarySize: 4 * 1024 * 1024,
+	}
+)
+
+// makeRepo creates a local repo in dir with the given history on
+// master, plus a side branch and lightweight and annotated tags.
+// The contents are derived from seed.
+func (e *e2eEnv) makeRepo(dir string, f e2eFixture, seed int64) {
+	require.NoError(e.t, os.MkdirAll(dir, 0700))
+	e.gitIn(dir, "init", "-q")
+	// Don't depend on the default branch name of this git version.
+	e.gitIn(dir, "symbolic-ref", "HEAD", "refs/heads/master")
+	r := rand.New(rand.NewSource(seed))
+	for i := 0; i < f.commits; i++ {
+		e.commitRandom(dir, f, r, i)
+		if i == f.commits/2 {
+			e.gitIn(dir, "tag", "light")
+			e.gitIn(dir, "tag", "-a", "-m", "annotated", "annotated")
+			e.gitIn(dir, "branch", "side")
+		}
+	}
+	e.gitIn(dir, "checkout", "-q", "side")
+	e.commitRandom(dir, f, r, f.commits)
+	e.gitIn(dir, "checkout", "-q", "master")
+}
+
+// commitRandom rewrites a random subset of the fixture's files and
+// commits them.
+func (e *e2eEnv) commitRandom(
+	dir string, f e2eFixture, r *rand.Rand, i int) {
+	const letters = "abcdefghijklmnopqrstuvwxyz\n"
+	n := f.files / 10
+	if i == 0 || n == 0 {
+		n = f.files
+	}
+	for j := 0; j < n; j++ {
+		name := filepath.Join(dir,
+			fmt.Sprintf("d%d", r.Intn(10)), fmt.Sprintf("f%d", r.Intn(f.files)))
+		require.NoError(e.t, os.MkdirAll(filepath.Dir(name), 0700))
+		buf := make([]byte, f.fileSize)
+		for k := range buf {
+			buf[k] = letters[r.Intn(len(letters))]
+		}
+		require.NoError(e.t, ioutil.WriteFile(name, buf, 0600))
+	}
+	if f.binaryEvery > 0 && i%f.binaryEvery == 0 {
+		buf := make([]byte, f.binarySize)
+		_, err := r.Read(buf)
+		require.NoError(e.t, err)
+		require.NoError(e.t, ioutil.WriteFile(
+			filepath.Join(dir, fmt.Sprintf("bin%d", i)), buf, 0600))
+	}
+	e.gitIn(dir, "add", "-A")
+	e.gitIn(dir, "commit", "-q", "-m", fmt.Sprintf("commit %d", i))
+}
+
+func testE2EPushCloneFetch(t *testing.T, e *e2eEnv, f e2eFixture) {
+	src, clone := e.dir("src"), e.dir("clone")
+	e.makeRepo(src, f, 1)
+	e.gitIn(src, "remote", "add", "origin", e.url("repo"))
+	e.gitIn(src, "push", "-q", "origin", "--all")
+	e.gitIn(src, "push", "-q", "origin", "--tags")
+
+	e.gitIn(e.root, "clone", "-q", e.url("repo"), clone)
+	e.requireSameRepo(src, clone, "origin")
+	require.Equal(t, e.gitIn(src, "rev-parse", "HEAD^{tree}"),
+		e.gitIn(clone, "rev-parse", "HEAD^{tree}"))
+
+	t.Log("New commits show up in the clone with fetch and pull")
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 3; i++ {
+		e.commitRandom(src, f, r, f.commits+1+i)
+	}
+	e.gitIn(src, "push", "-q", "origin", "master")
+	e.gitIn(clone, "fetch", "-q", "origin")
+	e.requireSameRepo(src, clone, "origin")
+	e.gitIn(clone, "pull", "-q", "--ff-only", "origin", "master")
+	require.Equal(t, e.gitIn(src, "rev-parse", "HEAD"),
+		e.gitIn(clone, "rev-parse", "HEAD"))
+
+	t.Log("The clone can push back")
+	e.commitRandom(clone, f, r, f.commits+4)
+	e.gitIn(clone, "push", "-q", "origin", "master")
+	e.gitIn(src, "pull", "-q", "--ff-only", "origin", "master")
+	require.Equal(t, e.gitIn(clone, "rev-parse", "HEAD"),
+		e.gitIn(src, "rev-parse", "HEAD"))
+}
+
+func TestE2EPushCloneFetch(t *testing.T) {
+	testOverGits(t, func(t *testing.T, e *e2eEnv) {
+		testE2EPushCloneFetch(t, e, e2eSmallFixture)
+	})
+}
+
+func TestE2ELargeRepo(t *testing.T) {
+	if !*e2eLarge {
+		t.Skip("Run with -kbfsgit-e2e-large to test a large repo")
+	}
+	testOverGits(t, func(t *testing.T, e *e2eEnv) {
+		testE2EPushCloneFetch(t, e, e2eLargeFixture)
+	})
+}
+
+func TestE2EBranchesAndForcePush(t *testing.T) {
+	testOverGits(t, func(t *testing.T, e *e2eEnv) {
+		src := e.dir("src")
+		e.makeRepo(src, e2eSmallFixture, 1)
+		e.gitIn(src, "remote", "add", "origin", e.url("repo"))
+		e.gitIn(src, "push", "-q", "origin", "--all")
+		e.gitIn(src, "push", "-q", "origin", "--tags")
+		require.Contains(t, e.remoteRefs("repo"), "refs/heads/side")
+
+		t.Log("Delete a branch and a tag")
+		e.gitIn(src, "push", "-q", "origin", ":side", ":refs/tags/light")
+		refs := e.remoteRefs("repo")
+		require.NotContains(t, refs, "refs/heads/side")
+		require.NotContains(t, refs, "refs/tags/light")
+		require.Contains(t, refs, "refs/tags/annotated")
+
+		t.Log("Force-push a rewritten master")
+		e.gitIn(src, "reset", "-q", "--hard", "HEAD~3")
+		e.gitIn(src, "commit", "-q", "--allow-empty", "-m", "rewritten")
+		head := strings.TrimSpace(e.gitIn(src, "rev-parse", "HEAD"))
+		e.gitIn(src, "push", "-q", "-f", "origin", "master")
+		require.Equal(t, head, e.remoteRefs("repo")["refs/heads/master"])
+
+		clone := e.dir("clone")
+		e.gitIn(e.root, "clone", "-q", e.url("repo"), clone)
+		require.Equal(t, head,
+			strings.TrimSpace(e.gitIn(clone, "rev-parse", "HEAD")))
+		e.gitIn(clone, "fsck", "--full", "--strict")
+	})
+}
+
+func TestE2EGC(t *testing.T) {
+	testOverGits(t, func(t *testing.T, e *e2eEnv) {
+		src := e.dir("src")
+		e.makeRepo(src, e2eSmallFixture, 1)
+		e.gitIn(src, "remote", "add", "origin", e.url("repo"))
+
+		t.Log("Push in several batches, to get several packs and " +
+			"loose refs")
+		r := rand.New(rand.NewSource(2))
+		for i := 0; i < 5; i++ {
+			e.gitIn(src, "branch", fmt.Sprintf("b%d", i))
+			e.gitIn(src, "push", "-q", "origin", "--all")
+			e.commitRandom(src, e2eSmallFixture, r,
+				e2eSmallFixture.commits+1+i)
+		}
+		e.gitIn(src, "push", "-q", "origin", "--all")
+		e.gitIn(src, "push", "-q", "origin", "--tags")
+		t.Log("Leave some unreachable objects behind")
+		e.gitIn(src, "checkout", "-q", "-b", "doomed")
+		e.commitRandom(src, e2eSmallFixture, r, 2*e2eSmallFixture.commits)
+		e.gitIn(src, "push", "-q", "origin", "doomed")
+		e.gitIn(src, "checkout", "-q", "master")
+		e.gitIn(src, "branch", "-q", "-D", "doomed")
+		e.gitIn(src, "push", "-q", "origin", ":doomed")
+		before := e.remoteRefs("repo")
+
+		e.gc("repo")
+		require.Equal(t, before, e.remoteRefs("repo"))
+
+		clone := e.dir("clone")
+		e.gitIn(e.root, "clone", "-q", e.url("repo"), clone)
+		e.requireSameRepo(src, clone, "origin")
+
+		t.Log("Pushing and fetching still work after GC")
+		e.commitRandom(src, e2eSmallFixture, r, 2*e2eSmallFixture.commits+1)
+		e.gitIn(src, "push", "-q", "origin", "master")
+		e.gitIn(clone, "fetch", "-q", "origin")
+		e.requireSameRepo(src, clone, "origin")
+	})
+}
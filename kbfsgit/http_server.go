@@ -0,0 +1,403 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package kbfsgit
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libgit"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/pktline"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/server"
+	"gopkg.in/src-d/go-git.v4/storage"
+)
+
+const (
+	// ctxHTTPOpID is the display name for the unique operation
+	// HTTP git ID tag.
+	ctxHTTPOpID = "GITHTTPID"
+
+	httpInfoRefsSuffix     = "/info/refs"
+	httpUploadPackSuffix   = "/" + transport.UploadPackServiceName
+	httpReceivePackSuffix  = "/" + transport.ReceivePackServiceName
+	httpRepoDotGitSuffix   = ".git"
+	httpServiceQueryParam  = "service"
+	httpContentTypeAdvert  = "application/x-git-upload-pack-advertisement"
+	httpContentTypeResult  = "application/x-git-upload-pack-result"
+	httpContentTypeRequest = "application/x-git-upload-pack-request"
+)
+
+type ctxHTTPTagKey int
+
+const (
+	ctxHTTPIDKey ctxHTTPTagKey = iota
+)
+
+// HTTPHandler serves KBFS-hosted git repos over git's "smart" HTTP
+// protocol, so that tools which can't install the keybase remote
+// helper can still clone and fetch them, e.g.:
+//
+//	git clone http://git:<token>@localhost:8080/team/keybase/repo
+//
+// Request paths start with "/<tlf type>/<tlf name>/<repo>",
+// optionally followed by ".git".  Only the repos the handler was
+// created with are served, and only for reading; pushes are always
+// refused.  The repos are read with the credentials of the logged-in
+// user, so every request must present the handler's token, and name
+// one of its allowed hosts, to guard against DNS rebinding.
+type HTTPHandler struct {
+	config libkbfs.Config
+	log    logger.Logger
+	repos  map[string]bool
+	token  string
+	hosts  map[string]bool
+}
+
+// HTTPAuth says which requests an HTTPHandler accepts.
+type HTTPAuth struct {
+	// Token is the secret that every request must present, either
+	// as a bearer token or as the password for basic auth (under any
+	// user name), which is what git clients send.  It must not be
+	// empty.
+	Token string
+	// Hosts lists the host names, without ports, that the Host
+	// header of a request may name.  If empty, only loopback names
+	// are accepted.
+	Hosts []string
+}
+
+// httpDefaultHosts are the host names accepted when HTTPAuth.Hosts
+// is empty.
+var httpDefaultHosts = []string{"localhost", "127.0.0.1", "::1"}
+
+var _ http.Handler = (*HTTPHandler)(nil)
+
+// httpRepoKey returns the key under which the repo at the given
+// path is allowed.  Repo names are case-insensitive, and TLF names
+// are lower-case anyway.
+func httpRepoKey(repoPath string) string {
+	return strings.ToLower(strings.Trim(repoPath, "/"))
+}
+
+// NewHTTPHandler returns a new HTTPHandler that serves the given
+// repos, each written as "<tlf type>/<tlf name>/<repo>", like
+// "team/keybase/repo", to requests allowed by `auth`.
+func NewHTTPHandler(
+	config libkbfs.Config, repos []string, auth HTTPAuth) (
+	*HTTPHandler, error) {
+	if auth.Token == "" {
+		return nil, errors.New("An HTTP git token is required")
+	}
+	allowed := make(map[string]bool, len(repos))
+	for _, r := range repos {
+		if _, _, _, err := parseHTTPRepoPath(r); err != nil {
+			return nil, err
+		}
+		allowed[httpRepoKey(r)] = true
+	}
+	hostList := auth.Hosts
+	if len(hostList) == 0 {
+		hostList = httpDefaultHosts
+	}
+	hosts := make(map[string]bool, len(hostList))
+	for _, host := range hostList {
+		hosts[strings.ToLower(host)] = true
+	}
+	return &HTTPHandler{
+		config: config,
+		log:    config.MakeLogger("GITHTTP"),
+		repos:  allowed,
+		token:  auth.Token,
+		hosts:  hosts,
+	}, nil
+}
+
+// hostAllowed returns whether the Host header of `req` names one of
+// the allowed hosts.
+func (h *HTTPHandler) hostAllowed(req *http.Request) bool {
+	host := req.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return h.hosts[strings.ToLower(host)]
+}
+
+// authorized returns whether `req` presents the handler's token.
+func (h *HTTPHandler) authorized(req *http.Request) bool {
+	var token string
+	if _, password, ok := req.BasicAuth(); ok {
+		token = password
+	} else if a := req.Header.Get("Authorization"); strings.HasPrefix(
+		a, "Bearer ") {
+		token = strings.TrimPrefix(a, "Bearer ")
+	} else {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1
+}
+
+// parseHTTPRepoPath splits "<tlf type>/<tlf name>/<repo>" into its
+// parts.
+func parseHTTPRepoPath(repoPath string) (
+	tlfType tlf.Type, tlfName, repoName string, err error) {
+	fields := strings.Split(strings.Trim(repoPath, "/"), "/")
+	if len(fields) != 3 || fields[1] == "" || fields[2] == "" {
+		return tlf.Unknown, "", "", errors.Errorf(
+			"%q is not of the form <tlf type>/<tlf name>/<repo>", repoPath)
+	}
+	tlfType, err = tlf.ParseTlfTypeFromPath(fields[0])
+	if err != nil {
+		return tlf.Unknown, "", "", err
+	}
+	return tlfType, fields[1], fields[2], nil
+}
+
+// storerLoader is a server.Loader that always loads the same,
+// already-opened repo.
+type storerLoader struct {
+	s storer.Storer
+}
+
+func (sl storerLoader) Load(_ *transport.Endpoint) (storer.Storer, error) {
+	return sl.s, nil
+}
+
+// openRepo returns a read-only session for the repo at `repoPath`,
+// or `transport.ErrRepositoryNotFound` if it isn't being served or
+// doesn't exist.
+func (h *HTTPHandler) openRepo(ctx context.Context, repoPath string) (
+	transport.UploadPackSession, storer.Storer, error) {
+	if !h.repos[httpRepoKey(repoPath)] {
+		return nil, nil, transport.ErrRepositoryNotFound
+	}
+	tlfType, tlfName, repoName, err := parseHTTPRepoPath(repoPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlfHandle, err := libkbfs.GetHandleFromFolderNameAndType(
+		ctx, h.config.KBPKI(), h.config.MDOps(), tlfName, tlfType)
+	if err != nil {
+		return nil, nil, err
+	}
+	fs, _, err := libgit.GetRepoAndID(ctx, h.config, tlfHandle, repoName, "")
+	if _, noRepo := errors.Cause(err).(libkb.RepoDoesntExistError); noRepo {
+		return nil, nil, transport.ErrRepositoryNotFound
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	var storage storage.Storer
+	storage, err = libgit.NewGitConfigWithoutRemotesStorer(fs)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Like fetches through the remote helper, only read objects
+	// when they are packed, so big repos aren't read into memory
+	// all at once.
+	storage, err = libgit.NewOnDemandStorer(storage)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	session, err := server.NewServer(storerLoader{storage}).
+		NewUploadPackSession(&transport.Endpoint{Path: repoPath}, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return session, storage, nil
+}
+
+func (h *HTTPHandler) writeError(
+	ctx context.Context, w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if errors.Cause(err) == transport.ErrRepositoryNotFound {
+		status = http.StatusNotFound
+	}
+	h.log.CDebugf(ctx, "Returning %d: %+v", status, err)
+	http.Error(w, err.Error(), status)
+}
+
+// serveInfoRefs advertises the repo's references, prefixed by the
+// service announcement that smart HTTP clients expect.
+func (h *HTTPHandler) serveInfoRefs(
+	ctx context.Context, w http.ResponseWriter, repoPath string) {
+	session, _, err := h.openRepo(ctx, repoPath)
+	if err != nil {
+		h.writeError(ctx, w, err)
+		return
+	}
+	defer session.Close()
+	ar, err := session.AdvertisedReferences()
+	if err != nil {
+		h.writeError(ctx, w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", httpContentTypeAdvert)
+	w.Header().Set("Cache-Control", "no-cache")
+	e := pktline.NewEncoder(w)
+	err = e.Encodef("# service=%s\n", transport.UploadPackServiceName)
+	if err != nil {
+		h.log.CDebugf(ctx, "Couldn't write the service header: %+v", err)
+		return
+	}
+	if err := e.Flush(); err != nil {
+		h.log.CDebugf(ctx, "Couldn't write the service header: %+v", err)
+		return
+	}
+	if err := ar.Encode(w); err != nil {
+		h.log.CDebugf(ctx, "Couldn't write the references: %+v", err)
+	}
+}
+
+// decodeUploadPackRequest reads the wants, and then any haves, of
+// one round of negotiation.  `done` is true if the client is ready
+// for the pack.
+func decodeUploadPackRequest(r io.Reader) (
+	req *packp.UploadPackRequest, done bool, err error) {
+	req = packp.NewUploadPackRequest()
+	if err := req.UploadRequest.Decode(r); err != nil {
+		return nil, false, err
+	}
+
+	s := pktline.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSuffix(string(s.Bytes()), "\n")
+		switch {
+		case line == "":
+			// A flush between batches of haves.
+		case line == "done":
+			return req, true, nil
+		case strings.HasPrefix(line, "have "):
+			req.Haves = append(
+				req.Haves, plumbing.NewHash(strings.TrimPrefix(line, "have ")))
+		default:
+			return nil, false, errors.Errorf("Unexpected line %q", line)
+		}
+	}
+	return req, false, s.Err()
+}
+
+// serveUploadPack answers one round of negotiation, and sends the
+// pack once the client is done.  Since the server doesn't advertise
+// multi_ack, it never acknowledges common objects while
+// negotiating, and stateless clients don't repeat their haves in the
+// final round; so fetches get everything reachable from their wants.
+func (h *HTTPHandler) serveUploadPack(
+	ctx context.Context, w http.ResponseWriter, req *http.Request,
+	repoPath string) {
+	if ct := req.Header.Get("Content-Type"); ct != httpContentTypeRequest {
+		http.Error(w, fmt.Sprintf("Unexpected content type %q", ct),
+			http.StatusUnsupportedMediaType)
+		return
+	}
+	body := io.Reader(req.Body)
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+	upReq, done, err := decodeUploadPackRequest(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	session, storage, err := h.openRepo(ctx, repoPath)
+	if err != nil {
+		h.writeError(ctx, w, err)
+		return
+	}
+	defer session.Close()
+
+	w.Header().Set("Content-Type", httpContentTypeResult)
+	w.Header().Set("Cache-Control", "no-cache")
+	if !done {
+		err := pktline.NewEncoder(w).Encodef("NAK\n")
+		if err != nil {
+			h.log.CDebugf(ctx, "Couldn't write NAK: %+v", err)
+		}
+		return
+	}
+
+	// The client lists what it has without knowing what we have;
+	// drop anything we've never seen before walking the history.
+	haves := upReq.Haves[:0]
+	for _, have := range upReq.Haves {
+		if storage.HasEncodedObject(have) == nil {
+			haves = append(haves, have)
+		}
+	}
+	upReq.Haves = haves
+
+	resp, err := session.UploadPack(ctx, upReq)
+	if err != nil {
+		h.writeError(ctx, w, err)
+		return
+	}
+	if err := resp.Encode(w); err != nil {
+		h.log.CDebugf(ctx, "Couldn't write the pack: %+v", err)
+	}
+}
+
+// ServeHTTP implements the http.Handler interface for HTTPHandler.
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ctx := libkbfs.CtxWithRandomIDReplayable(
+		req.Context(), ctxHTTPIDKey, ctxHTTPOpID, h.log)
+	h.log.CDebugf(ctx, "%s %s from %q", req.Method, req.URL, req.UserAgent())
+
+	if !h.hostAllowed(req) {
+		h.log.CDebugf(ctx, "Refusing unexpected host %q", req.Host)
+		http.Error(w, "Unexpected host", http.StatusForbidden)
+		return
+	}
+	if !h.authorized(req) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="kbfs git"`)
+		http.Error(w, "A valid token is required", http.StatusUnauthorized)
+		return
+	}
+
+	p := req.URL.Path
+	switch {
+	case strings.HasSuffix(p, httpInfoRefsSuffix) && req.Method == "GET":
+		service := req.URL.Query().Get(httpServiceQueryParam)
+		if service != transport.UploadPackServiceName {
+			http.Error(w, "Only fetching is supported", http.StatusForbidden)
+			return
+		}
+		repoPath := strings.TrimSuffix(
+			strings.TrimSuffix(p, httpInfoRefsSuffix), httpRepoDotGitSuffix)
+		h.serveInfoRefs(ctx, w, repoPath)
+	case strings.HasSuffix(p, httpUploadPackSuffix) && req.Method == "POST":
+		repoPath := strings.TrimSuffix(
+			strings.TrimSuffix(p, httpUploadPackSuffix), httpRepoDotGitSuffix)
+		h.serveUploadPack(ctx, w, req, repoPath)
+	case strings.HasSuffix(p, httpReceivePackSuffix):
+		http.Error(w, "Only fetching is supported", http.StatusForbidden)
+	default:
+		http.NotFound(w, req)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package kbfsgit
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/keybase/kbfs/libgit"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPHandlerCloneFetch(t *testing.T) {
+	ctx, config, tempdir := initConfigForRunner(t)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	defer os.RemoveAll(tempdir)
+
+	git1, err := ioutil.TempDir(os.TempDir(), "kbfsgittest")
+	require.NoError(t, err)
+	defer os.RemoveAll(git1)
+
+	makeLocalRepoWithOneFile(t, git1, "foo", "hello", "")
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	_, err = libgit.CreateRepoAndID(ctx, config, h, "test")
+	require.NoError(t, err)
+	_, err = libgit.CreateRepoAndID(ctx, config, h, "secret")
+	require.NoError(t, err)
+
+	testPush(t, ctx, config, git1, "refs/heads/master:refs/heads/master")
+
+	const token = "s3cret"
+	handler, err := NewHTTPHandler(
+		config, []string{"private/user1/Test"}, HTTPAuth{Token: token})
+	require.NoError(t, err)
+	s := httptest.NewServer(handler)
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	require.NoError(t, err)
+	u.User = url.UserPassword("git", token)
+	authURL := u.String()
+
+	get := func(path string, setup func(req *http.Request)) int {
+		req, err := http.NewRequest("GET", s.URL+path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if setup != nil {
+			setup(req)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	git2, err := ioutil.TempDir(os.TempDir(), "kbfsgittest")
+	require.NoError(t, err)
+	defer os.RemoveAll(git2)
+
+	gitCmd := func(dir string, args ...string) error {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		t.Logf("git %v: %s", args, out)
+		return err
+	}
+
+	t.Log("Clone the repo over HTTP")
+	clone := filepath.Join(git2, "clone")
+	err = gitCmd(git2, "clone", authURL+"/private/user1/test.git", clone)
+	require.NoError(t, err)
+	data, err := ioutil.ReadFile(filepath.Join(clone, "foo"))
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+
+	t.Log("Fetch a new commit into the clone")
+	addOneFileToRepo(t, git1, "foo2", "hello2")
+	testPushWithTemplate(t, ctx, config, git1,
+		[]string{"refs/heads/master:refs/heads/master"}, "ok %s\n\n", "user1")
+	err = gitCmd(clone, "pull", "origin", "master")
+	require.NoError(t, err)
+	data, err = ioutil.ReadFile(filepath.Join(clone, "foo2"))
+	require.NoError(t, err)
+	require.Equal(t, "hello2", string(data))
+
+	t.Log("Pushes are refused")
+	err = gitCmd(clone, "push", "origin", "master:refs/heads/other")
+	require.Error(t, err)
+	require.Equal(t, http.StatusForbidden, get(
+		"/private/user1/test/info/refs?service=git-receive-pack", nil))
+
+	t.Log("Repos that weren't selected aren't served")
+	require.Equal(t, http.StatusNotFound, get(
+		"/private/user1/secret/info/refs?service=git-upload-pack", nil))
+
+	t.Log("Requests need the token")
+	const infoRefs = "/private/user1/test/info/refs?service=git-upload-pack"
+	require.Equal(t, http.StatusOK, get(infoRefs, nil))
+	require.Equal(t, http.StatusUnauthorized, get(
+		infoRefs, func(req *http.Request) { req.Header.Del("Authorization") }))
+	require.Equal(t, http.StatusUnauthorized, get(
+		infoRefs, func(req *http.Request) {
+			req.Header.Set("Authorization", "Bearer wrong")
+		}))
+	require.Equal(t, http.StatusOK, get(
+		infoRefs, func(req *http.Request) { req.SetBasicAuth("any", token) }))
+
+	t.Log("Requests for other hosts are refused")
+	require.Equal(t, http.StatusForbidden, get(
+		infoRefs, func(req *http.Request) { req.Host = "evil.example.com" }))
+
+	_, err = NewHTTPHandler(
+		config, []string{"user1/test"}, HTTPAuth{Token: token})
+	require.Error(t, err)
+	_, err = NewHTTPHandler(config, []string{"private/user1/test"}, HTTPAuth{})
+	require.Error(t, err)
+}
@@ -0,0 +1,233 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package kbfsgit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/keybase/kbfs/tlf"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/client"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/file"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+)
+
+// negotiationCacheDir is the name of the directory under the KBFS
+// storage root where the last-known refs of each repo are kept.
+const negotiationCacheDir = "kbfs_git_refs"
+
+// negotiationCache remembers, on local disk, which refs of each KBFS
+// repo a local clone had the last time it fetched from or pushed to
+// it.  On the next fetch, only the local refs pointing at those
+// commits (or at the repo's current refs) need to be offered as
+// haves.  Every other have would otherwise be looked up in, and
+// walked through, the KBFS repo.
+type negotiationCache struct {
+	dir string
+}
+
+// negotiationCacheKey identifies the clone in `gitDir` of the given
+// KBFS repo.
+func negotiationCacheKey(tlfID tlf.ID, repoName, gitDir string) string {
+	if absDir, err := filepath.Abs(gitDir); err == nil {
+		gitDir = absDir
+	}
+	// Repo names are case-insensitive.
+	sum := sha256.Sum256([]byte(
+		tlfID.String() + "/" + strings.ToLower(repoName) + "/" + gitDir))
+	return hex.EncodeToString(sum[:])
+}
+
+func (nc *negotiationCache) cachePath(key string) string {
+	return filepath.Join(nc.dir, key+".json")
+}
+
+// load returns the last-known refs for the given key, or an empty map
+// if there aren't any.
+func (nc *negotiationCache) load(key string) (
+	map[plumbing.ReferenceName]plumbing.Hash, error) {
+	refs := make(map[plumbing.ReferenceName]plumbing.Hash)
+	data, err := ioutil.ReadFile(nc.cachePath(key))
+	if os.IsNotExist(err) {
+		return refs, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var hexRefs map[string]string
+	err = json.Unmarshal(data, &hexRefs)
+	if err != nil {
+		return nil, err
+	}
+	for name, hexHash := range hexRefs {
+		refs[plumbing.ReferenceName(name)] = plumbing.NewHash(hexHash)
+	}
+	return refs, nil
+}
+
+// save replaces the last-known refs for the given key.
+func (nc *negotiationCache) save(
+	key string, refs map[plumbing.ReferenceName]plumbing.Hash) error {
+	hexRefs := make(map[string]string, len(refs))
+	for name, hash := range refs {
+		hexRefs[name.String()] = hash.String()
+	}
+	data, err := json.Marshal(hexRefs)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(nc.dir, 0700)
+	if err != nil {
+		return err
+	}
+	// Write to a temp file first, so concurrent runners never see a
+	// partial file.
+	f, err := ioutil.TempFile(nc.dir, key)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), nc.cachePath(key))
+}
+
+// seededHavesTransport wraps the transport to the local repo, so
+// that the only refs it advertises are those pointing at `seeds`.
+// Since go-git offers every advertised ref as a have, this limits the
+// haves to commits the KBFS repo is known to have.  Dropping a have
+// is always safe; at worst, objects the local repo already has are
+// sent again.
+type seededHavesTransport struct {
+	transport.Transport
+	seeds map[plumbing.Hash]bool
+}
+
+// NewReceivePackSession implements the transport.Transport interface
+// for seededHavesTransport.
+func (sht seededHavesTransport) NewReceivePackSession(
+	ep *transport.Endpoint, auth transport.AuthMethod) (
+	transport.ReceivePackSession, error) {
+	s, err := sht.Transport.NewReceivePackSession(ep, auth)
+	if err != nil {
+		return nil, err
+	}
+	return seededHavesSession{s, sht.seeds}, nil
+}
+
+type seededHavesSession struct {
+	transport.ReceivePackSession
+	seeds map[plumbing.Hash]bool
+}
+
+// AdvertisedReferences implements the transport.ReceivePackSession
+// interface for seededHavesSession.
+func (shs seededHavesSession) AdvertisedReferences() (*packp.AdvRefs, error) {
+	ar, err := shs.ReceivePackSession.AdvertisedReferences()
+	if err != nil {
+		return nil, err
+	}
+	for name, hash := range ar.References {
+		if !shs.seeds[hash] {
+			delete(ar.References, name)
+		}
+	}
+	for name := range ar.Peeled {
+		if _, ok := ar.References[name]; !ok {
+			delete(ar.Peeled, name)
+		}
+	}
+	return ar, nil
+}
+
+func (r *runner) negotiationCacheKey() string {
+	return negotiationCacheKey(r.h.TlfID(), r.repo, r.gitDir)
+}
+
+// seedHaves returns the commits that the local repo should offer as
+// haves when fetching, or nil if nothing is known about the local
+// repo yet.
+func (r *runner) seedHaves(ctx context.Context) map[plumbing.Hash]bool {
+	if r.negotiationCache == nil {
+		return nil
+	}
+	known, err := r.negotiationCache.load(r.negotiationCacheKey())
+	if err != nil {
+		r.log.CDebugf(ctx, "Couldn't load known refs: %+v", err)
+		return nil
+	}
+	if len(known) == 0 {
+		return nil
+	}
+	seeds := make(map[plumbing.Hash]bool, len(known)+len(r.listedRefs))
+	for _, hash := range known {
+		seeds[hash] = true
+	}
+	for _, hash := range r.listedRefs {
+		seeds[hash] = true
+	}
+	return seeds
+}
+
+// rememberKnownRefs records which of the given KBFS refs the local
+// repo now has.  Failures are only logged, since the cache is just an
+// optimization.
+func (r *runner) rememberKnownRefs(
+	ctx context.Context, refs map[plumbing.ReferenceName]plumbing.Hash) {
+	if r.negotiationCache == nil || len(refs) == 0 {
+		return
+	}
+	localStorer, err := filesystem.NewStorage(osfs.New(r.gitDir))
+	if err != nil {
+		r.log.CDebugf(ctx, "Couldn't open the local repo: %+v", err)
+		return
+	}
+	key := r.negotiationCacheKey()
+	known, err := r.negotiationCache.load(key)
+	if err != nil {
+		r.log.CDebugf(ctx, "Couldn't load known refs: %+v", err)
+		return
+	}
+	for name, hash := range refs {
+		if localStorer.HasEncodedObject(hash) == nil {
+			known[name] = hash
+		}
+	}
+	err = r.negotiationCache.save(key, known)
+	if err != nil {
+		r.log.CDebugf(ctx, "Couldn't save known refs: %+v", err)
+	}
+}
+
+// pushWithSeededHaves pushes from the KBFS repo into the local repo,
+// offering only `seeds` as haves if it's non-nil.
+func (r *runner) pushWithSeededHaves(ctx context.Context,
+	remote *gogit.Remote, o *gogit.PushOptions,
+	seeds map[plumbing.Hash]bool) error {
+	if seeds == nil {
+		return remote.PushContext(ctx, o)
+	}
+	r.log.CDebugf(ctx, "Seeding haves with %d known commits", len(seeds))
+	// The local repo is always reached through the file transport.
+	client.InstallProtocol(
+		"file", seededHavesTransport{file.DefaultClient, seeds})
+	defer client.InstallProtocol("file", file.DefaultClient)
+	return remote.PushContext(ctx, o)
+}
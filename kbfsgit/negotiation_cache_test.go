@@ -0,0 +1,160 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package kbfsgit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/keybase/kbfs/libgit"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/protocol/packp"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+)
+
+func TestNegotiationCache(t *testing.T) {
+	tempdir, err := ioutil.TempDir(os.TempDir(), "negotiation_cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	nc := &negotiationCache{filepath.Join(tempdir, negotiationCacheDir)}
+
+	tlfID := tlf.FakeID(1, tlf.Private)
+	key := negotiationCacheKey(tlfID, "Test", "/a/.git")
+	require.Equal(t, key, negotiationCacheKey(tlfID, "test", "/a/.git"))
+	require.NotEqual(t, key, negotiationCacheKey(tlfID, "test", "/b/.git"))
+
+	refs, err := nc.load(key)
+	require.NoError(t, err)
+	require.Len(t, refs, 0)
+
+	expected := map[plumbing.ReferenceName]plumbing.Hash{
+		"refs/heads/master": plumbing.NewHash(
+			"0123456789012345678901234567890123456789"),
+	}
+	require.NoError(t, nc.save(key, expected))
+	refs, err = nc.load(key)
+	require.NoError(t, err)
+	require.Equal(t, expected, refs)
+}
+
+type fixedAdvRefsSession struct {
+	transport.ReceivePackSession
+	ar *packp.AdvRefs
+}
+
+func (fars fixedAdvRefsSession) AdvertisedReferences() (
+	*packp.AdvRefs, error) {
+	return fars.ar, nil
+}
+
+func TestSeededHavesSession(t *testing.T) {
+	known := plumbing.NewHash("0123456789012345678901234567890123456789")
+	unknown := plumbing.NewHash("1111111111111111111111111111111111111111")
+	ar := packp.NewAdvRefs()
+	ar.References["refs/remotes/origin/master"] = known
+	ar.References["refs/heads/local"] = unknown
+	ar.References["refs/tags/v1"] = unknown
+	ar.Peeled["refs/tags/v1"] = known
+
+	s := seededHavesSession{
+		fixedAdvRefsSession{nil, ar}, map[plumbing.Hash]bool{known: true}}
+	ar, err := s.AdvertisedReferences()
+	require.NoError(t, err)
+	require.Equal(t, map[string]plumbing.Hash{
+		"refs/remotes/origin/master": known,
+	}, ar.References)
+	require.Len(t, ar.Peeled, 0)
+}
+
+func TestRunnerFetchWithSeededHaves(t *testing.T) {
+	ctx, config, tempdir := initConfigForRunner(t)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	defer os.RemoveAll(tempdir)
+	nc := &negotiationCache{filepath.Join(tempdir, negotiationCacheDir)}
+
+	git1, err := ioutil.TempDir(os.TempDir(), "kbfsgittest")
+	require.NoError(t, err)
+	defer os.RemoveAll(git1)
+	makeLocalRepoWithOneFile(t, git1, "foo", "hello", "")
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	_, err = libgit.CreateRepoAndID(ctx, config, h, "test")
+	require.NoError(t, err)
+	testPush(t, ctx, config, git1, "refs/heads/master:refs/heads/master")
+
+	git2, err := ioutil.TempDir(os.TempDir(), "kbfsgittest")
+	require.NoError(t, err)
+	defer os.RemoveAll(git2)
+	dotgit2 := filepath.Join(git2, ".git")
+	gitExec(t, dotgit2, git2, "init")
+
+	fetch := func(head string) {
+		inputReader, inputWriter := io.Pipe()
+		defer inputWriter.Close()
+		go func() {
+			inputWriter.Write([]byte(fmt.Sprintf(
+				"list\nfetch %s refs/heads/master\n\n\n", head)))
+		}()
+
+		var output bytes.Buffer
+		r, err := newRunner(ctx, config, "origin",
+			"keybase://private/user1/test", dotgit2, inputReader, &output,
+			testErrput{t})
+		require.NoError(t, err)
+		r.negotiationCache = nc
+		err = r.processCommands(ctx)
+		require.NoError(t, err)
+
+		// Do what the invoking git process would do with the
+		// fetched ref.
+		gitExec(t, dotgit2, git2, "update-ref",
+			"refs/remotes/origin/master", head)
+	}
+	known := func() map[plumbing.ReferenceName]plumbing.Hash {
+		refs, err := nc.load(negotiationCacheKey(h.TlfID(), "test", dotgit2))
+		require.NoError(t, err)
+		return refs
+	}
+
+	t.Log("The first fetch isn't seeded, but records the fetched ref")
+	heads := testListAndGetHeads(t, ctx, config, git2,
+		[]string{"refs/heads/master", "HEAD"})
+	fetch(heads[0])
+	require.Equal(t, map[plumbing.ReferenceName]plumbing.Hash{
+		"refs/heads/master": plumbing.NewHash(heads[0]),
+	}, known())
+
+	t.Log("A later fetch only offers the known refs as haves")
+	addOneFileToRepo(t, git1, "foo2", "hello2")
+	testPush(t, ctx, config, git1, "refs/heads/master:refs/heads/master")
+	err = ioutil.WriteFile(
+		filepath.Join(git2, "local"), []byte("local"), 0600)
+	require.NoError(t, err)
+	gitExec(t, dotgit2, git2, "checkout", "-b", "local", heads[0])
+	gitExec(t, dotgit2, git2, "add", "local")
+	gitExec(t, dotgit2, git2, "-c", "user.name=Foo",
+		"-c", "user.email=foo@foo.com", "commit", "-a", "-m", "local")
+	heads = testListAndGetHeads(t, ctx, config, git2,
+		[]string{"refs/heads/master", "HEAD"})
+	fetch(heads[0])
+	require.Equal(t, map[plumbing.ReferenceName]plumbing.Hash{
+		"refs/heads/master": plumbing.NewHash(heads[0]),
+	}, known())
+
+	gitExec(t, dotgit2, git2, "checkout", heads[0])
+	data, err := ioutil.ReadFile(filepath.Join(git2, "foo2"))
+	require.NoError(t, err)
+	require.Equal(t, "hello2", string(data))
+}
@@ -94,8 +94,23 @@ const (
 	gcPrintStatusThreshold          = time.Second
 
 	maxCommitsToVisitPerRef = 20
+
+	// pushObjectMemoryBudget caps how much object data a push may
+	// hold in memory before spilling to disk.
+	pushObjectMemoryBudget = 256 * 1024 * 1024
+	objectSpillDir         = "kbfs_git_spill"
+
+	// folderUpdatedTimeout bounds how long a push waits to tell the
+	// main KBFS process about the new revision.
+	folderUpdatedTimeout = 5 * time.Second
 )
 
+// folderUpdater is told about each revision written by a push.
+type folderUpdater interface {
+	FolderUpdated(ctx context.Context, tlfID tlf.ID,
+		rev kbfsmd.Revision) error
+}
+
 type ctxCommandTagKey int
 
 const (
@@ -115,6 +130,17 @@ type runner struct {
 	errput io.Writer
 	gcDone bool
 
+	// packIndexCache is nil if there's no local storage root.
+	packIndexCache *libgit.PackIndexCache
+	objectBudget   *libgit.ObjectMemoryBudget
+	// negotiationCache is nil if there's no local storage root.
+	negotiationCache *negotiationCache
+	// listedRefs holds the refs of the KBFS repo from the last list.
+	listedRefs map[plumbing.ReferenceName]plumbing.Hash
+	// folderUpdates is nil if there's no main KBFS process to tell
+	// about pushes.
+	folderUpdates folderUpdater
+
 	verbosity int64
 	progress  bool
 	cloning   bool
@@ -172,6 +198,18 @@ func newRunner(ctx context.Context, config libkbfs.Config,
 	}
 	uniqID := fmt.Sprintf("%s-%d", session.VerifyingKey.String(), os.Getpid())
 
+	var packIndexCache *libgit.PackIndexCache
+	var negCache *negotiationCache
+	spillRoot := os.TempDir()
+	if root := config.StorageRoot(); root != "" {
+		packIndexCache = libgit.NewPackIndexCache(
+			filepath.Join(root, libgit.PackIndexCacheDir))
+		negCache = &negotiationCache{filepath.Join(root, negotiationCacheDir)}
+		spillRoot = root
+	}
+	objectBudget := libgit.NewObjectMemoryBudget(
+		pushObjectMemoryBudget, filepath.Join(spillRoot, objectSpillDir))
+
 	return &runner{
 		config:    config,
 		log:       config.MakeLogger(""),
@@ -185,6 +223,10 @@ func newRunner(ctx context.Context, config libkbfs.Config,
 		errput:    errput,
 		verbosity: 1,
 		progress:  true,
+
+		packIndexCache:   packIndexCache,
+		objectBudget:     objectBudget,
+		negotiationCache: negCache,
 	}, nil
 }
 
@@ -312,7 +354,12 @@ func (r *runner) initRepoIfNeeded(ctx context.Context, forCmd string) (
 	// remotes anyway since they'll contain local paths and wouldn't
 	// make sense to other devices, plus that could leak local info.
 	var storage storage.Storer
-	storage, err = libgit.NewGitConfigWithoutRemotesStorer(fs)
+	if r.packIndexCache != nil {
+		storage, err = libgit.NewGitConfigWithoutRemotesStorerWithPackIndexCache(
+			fs, r.packIndexCache)
+	} else {
+		storage, err = libgit.NewGitConfigWithoutRemotesStorer(fs)
+	}
 	if err != nil {
 		return nil, nil, err
 	}
@@ -326,6 +373,14 @@ func (r *runner) initRepoIfNeeded(ctx context.Context, forCmd string) (
 			return nil, nil, err
 		}
 	}
+	if forCmd == gitCmdPush {
+		// Objects created during a push share one memory budget, and
+		// spill to local disk beyond it.
+		storage, err = libgit.NewMemoryBudgetStorer(storage, r.objectBudget)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
 
 	config, err := storage.Config()
 	if err != nil {
@@ -589,6 +644,31 @@ func (r *runner) waitForJournal(ctx context.Context) error {
 	return nil
 }
 
+// notifyFolderUpdated tells the main KBFS process on this device, if
+// any, about the revision just pushed, so that its views of the repo,
+// like the autogit ones, are refreshed right away instead of whenever
+// the server gets around to announcing the new revision.  It's best
+// effort, since that will happen eventually anyway.
+func (r *runner) notifyFolderUpdated(ctx context.Context, fs *libfs.FS) {
+	if r.folderUpdates == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, folderUpdatedTimeout)
+	defer cancel()
+
+	fb := fs.RootNode().GetFolderBranch()
+	status, _, err := r.config.KBFSOps().FolderStatus(ctx, fb)
+	if err != nil {
+		r.log.CDebugf(ctx, "Couldn't get folder status: %+v", err)
+		return
+	}
+	err = r.folderUpdates.FolderUpdated(ctx, fb.Tlf, status.Revision)
+	if err != nil {
+		r.log.CDebugf(ctx, "Couldn't tell KBFS about revision %d: %+v",
+			status.Revision, err)
+	}
+}
+
 // handleList: From https://git-scm.com/docs/git-remote-helpers
 //
 // Lists the refs, one per line, in the format "<value> <name> [<attr>
@@ -616,6 +696,7 @@ func (r *runner) handleList(ctx context.Context, args []string) (err error) {
 
 	var symRefs []string
 	hashesSeen := false
+	r.listedRefs = make(map[plumbing.ReferenceName]plumbing.Hash)
 	for {
 		ref, err := refs.Next()
 		if errors.Cause(err) == io.EOF {
@@ -630,6 +711,7 @@ func (r *runner) handleList(ctx context.Context, args []string) (err error) {
 		case plumbing.HashReference:
 			value = ref.Hash().String()
 			hashesSeen = true
+			r.listedRefs[ref.Name()] = ref.Hash()
 		case plumbing.SymbolicReference:
 			value = "@" + ref.Target().String()
 		default:
@@ -1211,7 +1293,7 @@ func (r *runner) handleFetchBatch(ctx context.Context, args [][]string) (
 	})
 
 	var refSpecs []gogitcfg.RefSpec
-	var deleteRefSpecs []gogitcfg.RefSpec
+	var tempRefs []plumbing.ReferenceName
 	for i, fetch := range args {
 		if len(fetch) != 2 {
 			return errors.Errorf("Bad fetch request: %v", fetch)
@@ -1228,8 +1310,8 @@ func (r *runner) handleFetchBatch(ctx context.Context, args [][]string) (
 		r.log.CDebugf(ctx, "Fetching %s", refSpec)
 
 		refSpecs = append(refSpecs, gogitcfg.RefSpec(refSpec))
-		deleteRefSpecs = append(deleteRefSpecs, gogitcfg.RefSpec(
-			fmt.Sprintf(":refs/remotes/%s/%s", r.remote, localTempRef)))
+		tempRefs = append(tempRefs, plumbing.ReferenceName(
+			fmt.Sprintf("refs/remotes/%s/%s", r.remote, localTempRef)))
 	}
 
 	var statusChan plumbing.StatusChan
@@ -1242,24 +1324,30 @@ func (r *runner) handleFetchBatch(ctx context.Context, args [][]string) (
 
 	// Now "push" into the local repo to get it to store objects
 	// from the KBFS bare repo.
-	err = remote.PushContext(ctx, &gogit.PushOptions{
+	err = r.pushWithSeededHaves(ctx, remote, &gogit.PushOptions{
 		RemoteName: localRepoRemoteName,
 		RefSpecs:   refSpecs,
 		StatusChan: statusChan,
-	})
+	}, r.seedHaves(ctx))
 	if err != nil && err != gogit.NoErrAlreadyUpToDate {
 		return err
 	}
+	r.rememberKnownRefs(ctx, r.listedRefs)
 
-	// Delete the temporary refspecs now that the objects are
-	// safely stored in the local repo.
-	err = remote.PushContext(ctx, &gogit.PushOptions{
-		RemoteName: localRepoRemoteName,
-		RefSpecs:   deleteRefSpecs,
-	})
-	if err != nil && err != gogit.NoErrAlreadyUpToDate {
+	// Delete the temporary refs now that the objects are safely
+	// stored in the local repo.  Do it directly rather than with a
+	// delete-only push, which go-git doesn't handle well: it sends
+	// a packfile that git-receive-pack never reads.
+	localStorer, err := filesystem.NewStorage(osfs.New(r.gitDir))
+	if err != nil {
 		return err
 	}
+	for _, ref := range tempRefs {
+		err = localStorer.RemoveReference(ref)
+		if err != nil {
+			return err
+		}
+	}
 
 	err = r.waitForJournal(ctx)
 	if err != nil {
@@ -1684,6 +1772,20 @@ func (r *runner) handlePushBatch(ctx context.Context, args [][]string) (
 		return nil, err
 	}
 	r.log.CDebugf(ctx, "Done waiting for journal")
+	r.notifyFolderUpdated(ctx, fs)
+
+	pushedRefs := make(map[plumbing.ReferenceName]plumbing.Hash, len(results))
+	for d, e := range results {
+		if e != nil {
+			continue
+		}
+		// Deleted refs won't be found.
+		ref, err := repo.Storer.Reference(plumbing.ReferenceName(d))
+		if err == nil && ref.Type() == plumbing.HashReference {
+			pushedRefs[ref.Name()] = ref.Hash()
+		}
+	}
+	r.rememberKnownRefs(ctx, pushedRefs)
 
 	for d, e := range results {
 		result := ""
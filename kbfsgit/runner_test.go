@@ -17,6 +17,7 @@ import (
 	"testing"
 
 	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsmd"
 	"github.com/keybase/kbfs/libfs"
 	"github.com/keybase/kbfs/libgit"
 	"github.com/keybase/kbfs/libkbfs"
@@ -1118,3 +1119,52 @@ func TestRunnerHandlePushBatch(t *testing.T) {
 	require.True(t, master.IsDelete)
 	require.Len(t, master.Commits, 0)
 }
+
+type testFolderUpdater struct {
+	tlfID tlf.ID
+	rev   kbfsmd.Revision
+}
+
+func (tfu *testFolderUpdater) FolderUpdated(
+	_ context.Context, tlfID tlf.ID, rev kbfsmd.Revision) error {
+	tfu.tlfID = tlfID
+	tfu.rev = rev
+	return nil
+}
+
+func TestRunnerPushNotifiesFolderUpdated(t *testing.T) {
+	ctx, config, tempdir := initConfigForRunner(t)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	defer os.RemoveAll(tempdir)
+
+	git, err := ioutil.TempDir(os.TempDir(), "kbfsgittest")
+	require.NoError(t, err)
+	defer os.RemoveAll(git)
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	_, err = libgit.CreateRepoAndID(ctx, config, h, "test")
+	require.NoError(t, err)
+
+	makeLocalRepoWithOneFile(t, git, "foo", "hello", "")
+	var input bytes.Buffer
+	var output bytes.Buffer
+	r, err := newRunner(ctx, config, "origin", "keybase://private/user1/test",
+		filepath.Join(git, ".git"), &input, &output, testErrput{t})
+	require.NoError(t, err)
+	tfu := &testFolderUpdater{}
+	r.folderUpdates = tfu
+	_, err = r.handlePushBatch(
+		ctx, [][]string{{"refs/heads/master:refs/heads/master"}})
+	require.NoError(t, err)
+
+	rootNode, _, err := config.KBFSOps().GetOrCreateRootNode(
+		ctx, h, libkbfs.MasterBranch)
+	require.NoError(t, err)
+	status, _, err := config.KBFSOps().FolderStatus(
+		ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+	require.Equal(t, rootNode.GetFolderBranch().Tlf, tfu.tlfID)
+	require.Equal(t, status.Revision, tfu.rev)
+}
@@ -42,6 +42,9 @@ func Start(ctx context.Context, options StartOptions,
 		return libfs.InitError(err.Error())
 	}
 	defer config.Shutdown(ctx)
+	// Let any rules fired by a push finish their actions before
+	// exiting.
+	defer config.EventRuleEngine().WaitForActions(ctx)
 
 	config.MakeLogger("").CDebugf(
 		ctx, "Running Git remote helper: remote=%s, repo=%s, storageRoot=%s",
@@ -54,6 +57,7 @@ func Start(ctx context.Context, options StartOptions,
 	if err != nil {
 		return libfs.InitError(err.Error())
 	}
+	r.folderUpdates = libkbfs.NewFolderUpdatesRemote(kbCtx, config)
 
 	errCh := make(chan error, 1)
 	go func() {
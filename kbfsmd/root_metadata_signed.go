@@ -279,6 +279,11 @@ func DecodeRootMetadataSigned(
 	if err := codec.Decode(buf, &rmds); err != nil {
 		return nil, err
 	}
+	if rmds.MD == nil {
+		// The encoded object had an explicitly nil MD.
+		return nil, errors.New(
+			"Decoded RootMetadataSigned unexpectedly has nil MD")
+	}
 	if ver < SegregatedKeyBundlesVer {
 		// For v2, the writer signature is in rmds.MD, so copy
 		// it out.
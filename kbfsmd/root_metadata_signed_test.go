@@ -71,9 +71,19 @@ func testRootMetadataSignedFinalVerify(t *testing.T, ver MetadataVer) {
 	require.NotNil(t, err)
 }
 
+func testDecodeRootMetadataSignedNilMD(t *testing.T, ver MetadataVer) {
+	codec := kbfscodec.NewMsgpack()
+	buf, err := codec.Encode(RootMetadataSigned{})
+	require.NoError(t, err)
+	_, err = DecodeRootMetadataSigned(
+		codec, tlf.FakeID(1, tlf.Private), ver, ver, buf)
+	require.Error(t, err)
+}
+
 func TestRootMetadataSigned(t *testing.T) {
 	tests := []func(*testing.T, MetadataVer){
 		testRootMetadataSignedFinalVerify,
+		testDecodeRootMetadataSignedNilMD,
 	}
 	runTestsOverMetadataVers(t, "testRootMetadataSigned", tests)
 }
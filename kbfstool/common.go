@@ -6,7 +6,9 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
 
 	"github.com/keybase/kbfs/libkbfs"
 	"github.com/keybase/kbfs/tlf"
@@ -30,3 +32,13 @@ func byteCountStr(n int) string {
 func printError(prefix string, err error) {
 	fmt.Fprintf(os.Stderr, "%s: %s\n", prefix, err)
 }
+
+// readPassphraseFile returns the contents of the file at `p`, without
+// any trailing newline.
+func readPassphraseFile(p string) ([]byte, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(string(data), "\r\n")), nil
+}
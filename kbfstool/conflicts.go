@@ -0,0 +1,145 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const conflictsUsageStr = `Usage:
+  kbfstool conflicts [flags] /keybase/{public,private,team}/name[/dir]
+
+Lists the conflicted copies of files under a directory, left behind
+when conflicting writes were resolved, next to the files they
+conflicted with, and which of each pair was modified last.  With
+-resolve, deals with all of them at once:
+
+  keep-newest	keep the most recently modified version under the
+		original name, and delete the others
+  keep-both	rename the copies so they no longer look conflicted
+  delete-copies	delete the copies, keeping the originals
+
+`
+
+func conflictsHelper(
+	ctx context.Context, config libkbfs.Config, args []string) error {
+	flags := flag.NewFlagSet("kbfs conflicts", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, conflictsUsageStr)
+		flags.PrintDefaults()
+	}
+	diff := flags.Bool("diff", false,
+		"Print the differences between each copy and its original.")
+	resolve := flags.String("resolve", "",
+		"Resolve the conflicted copies: keep-newest, keep-both or "+
+			"delete-copies.")
+	dryRun := flags.Bool("dry-run", false,
+		"With -resolve, only print what would be done.")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("a directory must be specified")
+	}
+
+	p, err := fsrpc.ParsePath(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if p.PathType != fsrpc.TLFPathType {
+		return fmt.Errorf("Cannot look for conflicts in %s", p)
+	}
+	dir, err := p.GetDirNode(ctx, config)
+	if err != nil {
+		return err
+	}
+	copies, err := libkbfs.FindConflictedCopies(ctx, config, dir)
+	if err != nil {
+		return err
+	}
+
+	if *resolve != "" {
+		resolution, err := libkbfs.ParseConflictResolution(*resolve)
+		if err != nil {
+			return err
+		}
+		actions, err := libkbfs.ResolveConflictedCopies(
+			ctx, config, dir, copies, resolution, *dryRun)
+		for _, a := range actions {
+			if a.NewPath == "" {
+				fmt.Printf("delete %s\n", a.Path)
+			} else {
+				fmt.Printf("move %s -> %s\n", a.Path, a.NewPath)
+			}
+		}
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "COPY MODIFIED\tORIGINAL MODIFIED\tNEWER\tCOPY")
+	for _, cc := range copies {
+		origTime, newer := "-", "copy"
+		switch {
+		case !cc.OriginalExists:
+			newer = "-"
+		case cc.Identical:
+			origTime = cc.OriginalModTime.Format(time.RFC3339)
+			newer = "same"
+		default:
+			origTime = cc.OriginalModTime.Format(time.RFC3339)
+			if !cc.ModTime.After(cc.OriginalModTime) {
+				newer = "original"
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			cc.ModTime.Format(time.RFC3339), origTime, newer, cc.Path)
+	}
+	err = w.Flush()
+	if err != nil || !*diff {
+		return err
+	}
+
+	for _, cc := range copies {
+		if cc.Identical {
+			continue
+		}
+		d, err := libkbfs.DiffConflictedCopy(ctx, config, dir, cc)
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsBinary:
+			fmt.Printf("\nBinary files %s and %s differ\n",
+				cc.Original, cc.Path)
+		case d.TooLarge:
+			fmt.Printf("\nFiles %s and %s are too large to compare\n",
+				cc.Original, cc.Path)
+		default:
+			fmt.Printf("\n%s", d.Patch)
+		}
+	}
+	return nil
+}
+
+func conflicts(
+	ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	err := conflictsHelper(ctx, config, args)
+	if err != nil {
+		printError("conflicts", err)
+		exitStatus = 1
+	}
+	return
+}
@@ -0,0 +1,130 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+func exportHelper(
+	ctx context.Context, config libkbfs.Config, args []string) (err error) {
+	flags := flag.NewFlagSet("kbfs export", flag.ContinueOnError)
+	manifestPath := flags.String("manifest", "",
+		"Also write a JSON manifest of writers and revisions to this file.")
+	passphrasePath := flags.String("escrow-passphrase-file", "",
+		"Write an escrow bundle, holding the folder's keys and sealed "+
+			"with the passphrase in this file, instead of a tar archive.")
+	rawDir := flags.String("raw", "",
+		"Instead, write the folder's encrypted metadata and blocks to "+
+			"this directory, for kbfsdecrypt.")
+	car := flags.Bool("car", false,
+		"Write a CARv1 archive of an IPFS UnixFS DAG, instead of a tar "+
+			"archive, and print its root CID to stderr.  Only for public "+
+			"folders.")
+	err = flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errExactlyOnePath
+	}
+
+	p, err := fsrpc.ParsePath(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if p.PathType != fsrpc.TLFPathType {
+		return fmt.Errorf("Cannot export %s", p)
+	}
+
+	tlfHandle, err := p.GetHandle(ctx, config)
+	if err != nil {
+		return err
+	}
+	if *car {
+		if tlfHandle.Type() != tlf.Public {
+			return errors.New(
+				"Only public folders can be exported to IPFS")
+		}
+		if *manifestPath != "" || *passphrasePath != "" || *rawDir != "" {
+			return errors.New("-car can't be used with -manifest, " +
+				"-escrow-passphrase-file or -raw")
+		}
+	}
+	if *rawDir != "" {
+		if len(p.TLFComponents) > 0 {
+			return errors.New("Only whole folders can be exported raw")
+		}
+		return libkbfs.ExportRawFolder(ctx, config, tlfHandle, *rawDir)
+	}
+	fs, err := libfs.NewFS(
+		ctx, config, tlfHandle, p.BranchName(), "", "",
+		keybase1.MDPriorityNormal)
+	if err != nil {
+		return err
+	}
+
+	root := path.Join(p.TLFComponents...)
+	if *car {
+		rootCID, err := libfs.ExportCAR(fs, root, os.Stdout)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, rootCID)
+		return nil
+	}
+	if *passphrasePath != "" {
+		if *manifestPath != "" {
+			return errors.New("An escrow bundle already has a manifest")
+		}
+		passphrase, err := readPassphraseFile(*passphrasePath)
+		if err != nil {
+			return err
+		}
+		return libfs.ExportWithKeys(fs, root, passphrase, os.Stdout)
+	}
+
+	var manifest *os.File
+	if *manifestPath != "" {
+		manifest, err = os.Create(*manifestPath)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			closeErr := manifest.Close()
+			if err == nil {
+				err = closeErr
+			}
+		}()
+	}
+
+	if manifest == nil {
+		// Don't pass along a typed nil as the manifest writer.
+		return libfs.Export(fs, root, os.Stdout, nil)
+	}
+	return libfs.Export(fs, root, os.Stdout, manifest)
+}
+
+func export(
+	ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	err := exportHelper(ctx, config, args)
+	if err != nil {
+		printError("export", err)
+		exitStatus = 1
+	}
+	return
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/keybase/kbfs/env"
+	"github.com/keybase/kbfs/libgit"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const gitCleanUsageStr = `Usage:
+  kbfstool git clean /keybase/tlf/path
+
+Removes the data of the repos in the folder that were deleted long
+enough ago.
+`
+
+func gitClean(ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	flags := flag.NewFlagSet("kbfs git clean", flag.ContinueOnError)
+	err := flags.Parse(args)
+	if err != nil {
+		printError("git clean", err)
+		return 1
+	}
+
+	inputs := flags.Args()
+	if len(inputs) != 1 {
+		fmt.Print(gitCleanUsageStr)
+		return 1
+	}
+
+	folder, err := gitFolderFromPath(inputs[0])
+	if err != nil {
+		printError("git clean", err)
+		return 1
+	}
+
+	kbfsCtx := env.NewContext()
+	rpcHandler, shutdown := libgit.NewRPCHandlerWithCtx(kbfsCtx, config, nil)
+	defer shutdown()
+
+	err = rpcHandler.CleanDeletedRepos(ctx, folder)
+	if err != nil {
+		printError("git clean", err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/keybase/kbfs/env"
+	"github.com/keybase/kbfs/libgit"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const gitDefaultsUsageStr = `Usage:
+  kbfstool git defaults [<options>] /keybase/team/name
+
+Shows the default settings for new git repos in a team folder, or
+changes the given ones.  Existing repos keep their settings.
+
+Options:
+  -max-size <bytes>         Cap the size of new repos (0 for no cap)
+  -protect <patterns>       Comma-separated patterns of protected branches
+  -lfs=<true|false>         Turn git LFS on or off
+  -archive-after-days <n>   Archive repos after n days without a push
+                            (0 for never)
+`
+
+func printRepoSettings(settings libgit.RepoSettings) {
+	fmt.Printf("max size: %d\n", settings.MaxRepoSizeBytes)
+	fmt.Printf("protected branches: %s\n",
+		strings.Join(settings.ProtectedBranches, ","))
+	fmt.Printf("lfs: %t\n", settings.LFSEnabled)
+	fmt.Printf("archive after days: %d\n", settings.ArchiveAfterDays)
+}
+
+func gitDefaults(ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	flags := flag.NewFlagSet("kbfs git defaults", flag.ContinueOnError)
+	maxSize := flags.Int64("max-size", 0, "Cap the size of new repos")
+	protect := flags.String("protect", "", "Protected branch patterns")
+	lfs := flags.Bool("lfs", false, "Turn git LFS on or off")
+	archiveAfterDays := flags.Int(
+		"archive-after-days", 0, "Archive repos after this many days")
+	err := flags.Parse(args)
+	if err != nil {
+		printError("git defaults", err)
+		return 1
+	}
+
+	inputs := flags.Args()
+	if len(inputs) != 1 {
+		fmt.Print(gitDefaultsUsageStr)
+		return 1
+	}
+
+	folder, err := gitFolderFromPath(inputs[0])
+	if err != nil {
+		printError("git defaults", err)
+		return 1
+	}
+
+	kbfsCtx := env.NewContext()
+	rpcHandler, shutdown := libgit.NewRPCHandlerWithCtx(kbfsCtx, config, nil)
+	defer shutdown()
+
+	settings, err := rpcHandler.GetTeamRepoDefaults(ctx, folder)
+	if err != nil {
+		printError("git defaults", err)
+		return 1
+	}
+
+	changed := false
+	flags.Visit(func(f *flag.Flag) {
+		changed = true
+		switch f.Name {
+		case "max-size":
+			settings.MaxRepoSizeBytes = *maxSize
+		case "protect":
+			settings.ProtectedBranches = nil
+			for _, pattern := range strings.Split(*protect, ",") {
+				if pattern != "" {
+					settings.ProtectedBranches = append(
+						settings.ProtectedBranches, pattern)
+				}
+			}
+		case "lfs":
+			settings.LFSEnabled = *lfs
+		case "archive-after-days":
+			settings.ArchiveAfterDays = *archiveAfterDays
+		}
+	})
+	if !changed {
+		printRepoSettings(settings)
+		return 0
+	}
+
+	err = rpcHandler.SetTeamRepoDefaults(ctx, folder, settings)
+	if err != nil {
+		printError("git defaults", err)
+		return 1
+	}
+	printRepoSettings(settings)
+	return 0
+}
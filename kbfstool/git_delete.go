@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/keybase/kbfs/env"
+	"github.com/keybase/kbfs/libgit"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const gitDeleteUsageStr = `Usage:
+  kbfstool git delete /keybase/tlf/path repoName
+
+The repo is removed from the folder and from the list of repos known
+to the service.  Its data is kept for a while so concurrent pushes can
+finish, and is then removed by "kbfstool git clean".
+`
+
+func gitDelete(ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	flags := flag.NewFlagSet("kbfs git delete", flag.ContinueOnError)
+	err := flags.Parse(args)
+	if err != nil {
+		printError("git delete", err)
+		return 1
+	}
+
+	inputs := flags.Args()
+	if len(inputs) != 2 {
+		fmt.Print(gitDeleteUsageStr)
+		return 1
+	}
+
+	folder, err := gitFolderFromPath(inputs[0])
+	if err != nil {
+		printError("git delete", err)
+		return 1
+	}
+
+	kbfsCtx := env.NewContext()
+	rpcHandler, shutdown := libgit.NewRPCHandlerWithCtx(kbfsCtx, config, nil)
+	defer shutdown()
+
+	err = rpcHandler.DeleteRepoAndMetadata(ctx, folder, inputs[1])
+	if err != nil {
+		printError("git delete", err)
+		return 1
+	}
+
+	return 0
+}
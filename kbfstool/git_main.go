@@ -3,6 +3,8 @@ package main
 import (
 	"fmt"
 
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/fsrpc"
 	"github.com/keybase/kbfs/libkbfs"
 	"golang.org/x/net/context"
 )
@@ -12,8 +14,31 @@ const gitUsageStr = `Usage:
 
 The possible subcommands are:
   rename	Rename a git repository
+  delete	Delete a git repository
+  clean		Remove the data of deleted git repositories
+  defaults	Show or set a team's default settings for new repos
+  serve-http	Serve repos read-only over HTTP
 `
 
+// gitFolderFromPath returns the folder for the TLF root path `tlfStr`.
+func gitFolderFromPath(tlfStr string) (keybase1.Folder, error) {
+	p, err := fsrpc.NewPath(tlfStr)
+	if err != nil {
+		return keybase1.Folder{}, err
+	}
+	if p.PathType != fsrpc.TLFPathType {
+		return keybase1.Folder{}, fmt.Errorf("%q is not a TLF path", tlfStr)
+	}
+	if len(p.TLFComponents) > 0 {
+		return keybase1.Folder{}, fmt.Errorf(
+			"%q is not the root path of a TLF", tlfStr)
+	}
+	return keybase1.Folder{
+		Name:       p.TLFName,
+		FolderType: p.TLFType.FolderType(),
+	}, nil
+}
+
 func gitMain(ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
 	if len(args) < 1 {
 		fmt.Print(gitUsageStr)
@@ -26,6 +51,14 @@ func gitMain(ctx context.Context, config libkbfs.Config, args []string) (exitSta
 	switch cmd {
 	case "rename":
 		return gitRename(ctx, config, args)
+	case "delete":
+		return gitDelete(ctx, config, args)
+	case "clean":
+		return gitClean(ctx, config, args)
+	case "defaults":
+		return gitDefaults(ctx, config, args)
+	case "serve-http":
+		return gitServeHTTP(ctx, config, args)
 	default:
 		printError("git", fmt.Errorf("unknown command %q", cmd))
 		return 1
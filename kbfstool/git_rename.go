@@ -4,9 +4,7 @@ import (
 	"flag"
 	"fmt"
 
-	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/kbfs/env"
-	"github.com/keybase/kbfs/fsrpc"
 	"github.com/keybase/kbfs/libgit"
 	"github.com/keybase/kbfs/libkbfs"
 	"golang.org/x/net/context"
@@ -18,20 +16,10 @@ const gitRenameUsageStr = `Usage:
 
 func doGitRename(ctx context.Context,
 	rpcHandler *libgit.RPCHandler, tlfStr, oldName, newName string) error {
-	p, err := fsrpc.NewPath(tlfStr)
+	folder, err := gitFolderFromPath(tlfStr)
 	if err != nil {
 		return err
 	}
-	if p.PathType != fsrpc.TLFPathType {
-		return fmt.Errorf("%q is not a TLF path", tlfStr)
-	}
-	if len(p.TLFComponents) > 0 {
-		return fmt.Errorf("%q is not the root path of a TLF", tlfStr)
-	}
-	folder := keybase1.Folder{
-		Name:       p.TLFName,
-		FolderType: p.TLFType.FolderType(),
-	}
 
 	return rpcHandler.RenameRepo(ctx, folder, oldName, newName)
 }
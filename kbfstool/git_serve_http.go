@@ -0,0 +1,120 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/keybase/kbfs/kbfsgit"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const gitServeHTTPUsageStr = `Usage:
+  kbfstool git serve-http [flags] <tlf type>/<tlf name>/<repo>...
+
+Serves the given repos read-only over git's smart HTTP protocol until
+interrupted, so they can be cloned and fetched without the keybase
+remote helper, e.g.:
+
+  kbfstool git serve-http -token <token> team/keybase/client
+  git clone http://git:<token>@localhost:8080/team/keybase/client
+
+Every request must present the token, either as a bearer token or as
+the basic auth password; if -token isn't given, a random one is made
+up and printed.  Only requests for localhost are accepted unless
+-hosts says otherwise.  Use -cert and -key to serve over HTTPS.
+
+`
+
+func gitServeHTTPHelper(
+	ctx context.Context, config libkbfs.Config, args []string) error {
+	flags := flag.NewFlagSet("kbfs git serve-http", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, gitServeHTTPUsageStr)
+		flags.PrintDefaults()
+	}
+	addr := flags.String("addr", "localhost:8080", "Listen on this address.")
+	certFile := flags.String("cert", "",
+		"Serve HTTPS, using the certificate in this file.")
+	keyFile := flags.String("key", "",
+		"Serve HTTPS, using the private key in this file.")
+	token := flags.String("token", "",
+		"Require this token from clients; made up if empty.")
+	hosts := flags.String("hosts", "",
+		"Comma-separated host names that clients may connect to; "+
+			"localhost if empty.")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("at least one repo must be specified")
+	}
+	if (*certFile == "") != (*keyFile == "") {
+		return errors.New("-cert and -key must be specified together")
+	}
+
+	auth := kbfsgit.HTTPAuth{Token: *token}
+	if auth.Token == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		auth.Token = hex.EncodeToString(b)
+		fmt.Printf("Using token %s\n", auth.Token)
+	}
+	if *hosts != "" {
+		auth.Hosts = strings.Split(*hosts, ",")
+	}
+	handler, err := kbfsgit.NewHTTPHandler(config, flags.Args(), auth)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Addr: *addr, Handler: handler}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	go func() {
+		select {
+		case <-sigCh:
+			server.Shutdown(context.Background())
+		case <-ctx.Done():
+			server.Close()
+		}
+	}()
+
+	fmt.Printf("Serving %d repos on %s\n", flags.NArg(), *addr)
+	if *certFile != "" {
+		err = server.ListenAndServeTLS(*certFile, *keyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func gitServeHTTP(
+	ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	err := gitServeHTTPHelper(ctx, config, args)
+	if err != nil {
+		printError("git serve-http", err)
+		exitStatus = 1
+	}
+	return
+}
@@ -0,0 +1,92 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+func importHelper(
+	ctx context.Context, config libkbfs.Config, args []string) (err error) {
+	flags := flag.NewFlagSet("kbfs import", flag.ContinueOnError)
+	passphrasePath := flags.String("passphrase-file", "",
+		"Read the passphrase of the escrow bundle from this file.")
+	err = flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 2 {
+		return errors.New("a bundle and a destination path must be specified")
+	}
+	if *passphrasePath == "" {
+		return errors.New("-passphrase-file must be specified")
+	}
+	passphrase, err := readPassphraseFile(*passphrasePath)
+	if err != nil {
+		return err
+	}
+
+	p, err := fsrpc.ParsePath(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	if p.PathType != fsrpc.TLFPathType {
+		return fmt.Errorf("Cannot import into %s", p)
+	}
+	tlfHandle, err := p.GetHandle(ctx, config)
+	if err != nil {
+		return err
+	}
+	fs, err := libfs.NewFS(
+		ctx, config, tlfHandle, libkbfs.MasterBranch, "", "",
+		keybase1.MDPriorityNormal)
+	if err != nil {
+		return err
+	}
+	if root := path.Join(p.TLFComponents...); root != "" {
+		err = fs.MkdirAll(root, 0755)
+		if err != nil {
+			return err
+		}
+		fs, err = fs.ChrootAsLibFS(root)
+		if err != nil {
+			return err
+		}
+	}
+
+	bundle, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer bundle.Close()
+	_, manifest, err := libfs.ImportEscrowBundle(bundle, passphrase, fs)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d entries from %s at revision %d\n",
+		len(manifest.Entries), manifest.Folder, manifest.Revision)
+	return nil
+}
+
+func importBundle(
+	ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	err := importHelper(ctx, config, args)
+	if err != nil {
+		printError("import", err)
+		exitStatus = 1
+	}
+	return
+}
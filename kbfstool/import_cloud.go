@@ -0,0 +1,138 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const importCloudUsageStr = `Usage:
+  kbfstool import-cloud [flags] <export dir> /keybase/{public,private,team}/name[/dir]
+
+Copies a local export of a Dropbox or Google Drive account into a
+folder, or a directory in it, keeping the original modification
+times.  If the export has a manifest (see -manifest), the times in it
+are used, and a Keybase folder or team is suggested for each folder
+that was shared, so that the same people keep access.  Sharing isn't
+changed automatically.
+
+`
+
+func importCloudHelper(
+	ctx context.Context, config libkbfs.Config, args []string) error {
+	flags := flag.NewFlagSet("kbfs import-cloud", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, importCloudUsageStr)
+		flags.PrintDefaults()
+	}
+	manifestPath := flags.String("manifest", "",
+		"Read the export's metadata from this file.  Defaults to "+
+			libfs.CloudExportManifestName+" in the export directory, "+
+			"if there is one.")
+	dryRun := flags.Bool("dry-run", false,
+		"Only print the sharing suggestions; don't copy anything.")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return errors.New("an export directory and a folder must be specified")
+	}
+
+	src, err := filepath.Abs(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	p, err := fsrpc.ParsePath(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	if p.PathType != fsrpc.TLFPathType {
+		return fmt.Errorf("Cannot import into %s", p)
+	}
+
+	var manifest libfs.CloudExportManifest
+	if *manifestPath == "" {
+		*manifestPath = filepath.Join(src, libfs.CloudExportManifestName)
+		if _, err := os.Stat(*manifestPath); os.IsNotExist(err) {
+			*manifestPath = ""
+		}
+	}
+	if *manifestPath != "" {
+		f, err := os.Open(*manifestPath)
+		if err != nil {
+			return err
+		}
+		manifest, err = libfs.ReadCloudExportManifest(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return err
+	}
+	for _, s := range libfs.SuggestCloudShares(
+		manifest, string(session.Name)) {
+		fmt.Printf("%s:\n", s.Path)
+		if s.Team != "" {
+			fmt.Printf("  create team %s (writers: %s; readers: %s)\n",
+				s.Team, strings.Join(s.Writers, ", "),
+				strings.Join(s.Readers, ", "))
+		}
+		fmt.Printf("  move to %s\n", s.Folder)
+		if len(s.Unmapped) > 0 {
+			fmt.Printf("  no Keybase account for: %s\n",
+				strings.Join(s.Unmapped, ", "))
+		}
+	}
+	if *dryRun {
+		return nil
+	}
+
+	tlfHandle, err := p.GetHandle(ctx, config)
+	if err != nil {
+		return err
+	}
+	fs, err := libfs.NewFS(
+		ctx, config, tlfHandle, p.BranchName(), "", "",
+		keybase1.MDPriorityNormal)
+	if err != nil {
+		return err
+	}
+	stats, err := libfs.ImportCloudExport(
+		ctx, fs, src, path.Join(p.TLFComponents...), manifest)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d files and %d directories (%d bytes)\n",
+		stats.Files, stats.Dirs, stats.Bytes)
+	return nil
+}
+
+func importCloud(
+	ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	err := importCloudHelper(ctx, config, args)
+	if err != nil {
+		printError("import-cloud", err)
+		exitStatus = 1
+	}
+	return
+}
@@ -0,0 +1,156 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const ingestUsageStr = `Usage:
+  kbfstool ingest [flags] <local dir> /keybase/{public,private,team}/name[/dir]
+
+Uploads the files in a local directory to a folder, or a directory in
+it, and keeps uploading new and changed files until interrupted.  The
+upload is one-way: changes made in the folder are never written back.
+
+`
+
+func ingestHelper(
+	ctx context.Context, config libkbfs.Config, args []string) error {
+	flags := flag.NewFlagSet("kbfs ingest", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, ingestUsageStr)
+		flags.PrintDefaults()
+	}
+	stateDir := flags.String("state", "",
+		"Keep the list of uploaded files in this directory.  Defaults to "+
+			"a hidden directory next to the local directory.")
+	moveTo := flags.String("move-to", "",
+		"Move uploaded files into this local directory, instead of "+
+			"keeping a list of them.")
+	settle := flags.Duration("settle", 10*time.Second,
+		"Only upload files that haven't been modified for this long.")
+	maxUnflushed := flags.Int64("max-unflushed", 0,
+		"Pause while the folder's journal holds more than this many "+
+			"unflushed bytes.  0 means no limit.")
+	interval := flags.Duration("interval", time.Minute,
+		"Check for new files this often.")
+	once := flags.Bool("once", false,
+		"Upload the files that are ready and exit.")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return errors.New("a local directory and a folder must be specified")
+	}
+
+	src, err := filepath.Abs(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	p, err := fsrpc.ParsePath(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	if p.PathType != fsrpc.TLFPathType {
+		return fmt.Errorf("Cannot ingest into %s", p)
+	}
+	if *moveTo != "" {
+		*moveTo, err = filepath.Abs(*moveTo)
+		if err != nil {
+			return err
+		}
+	} else if *stateDir == "" {
+		*stateDir = filepath.Join(
+			filepath.Dir(src), "."+filepath.Base(src)+".kbfs_ingest")
+	}
+
+	tlfHandle, err := p.GetHandle(ctx, config)
+	if err != nil {
+		return err
+	}
+	fs, err := libfs.NewFS(
+		ctx, config, tlfHandle, p.BranchName(), "", "",
+		keybase1.MDPriorityNormal)
+	if err != nil {
+		return err
+	}
+	in, err := libfs.NewIngester(fs, src, path.Join(p.TLFComponents...),
+		libfs.IngestOptions{
+			StateDir:          *stateDir,
+			MoveTo:            *moveTo,
+			SettleTime:        *settle,
+			MaxUnflushedBytes: *maxUnflushed,
+		})
+	if err != nil {
+		return err
+	}
+
+	if *once {
+		stats, err := in.ScanOnce(ctx)
+		if err != nil {
+			return err
+		}
+		printIngestStats(stats)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	go func() {
+		select {
+		case <-sigCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	err = in.Run(ctx, *interval, func(stats libfs.IngestStats, err error) {
+		if err != nil {
+			printError("ingest", err)
+			return
+		}
+		printIngestStats(stats)
+	})
+	if err == context.Canceled {
+		return nil
+	}
+	return err
+}
+
+func printIngestStats(stats libfs.IngestStats) {
+	if stats.Uploaded == 0 {
+		return
+	}
+	fmt.Printf("Uploaded %d files (%d bytes)\n", stats.Uploaded, stats.Bytes)
+}
+
+func ingest(
+	ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	err := ingestHelper(ctx, config, args)
+	if err != nil {
+		printError("ingest", err)
+		exitStatus = 1
+	}
+	return
+}
@@ -214,7 +214,7 @@ func ls(ctx context.Context, config libkbfs.Config, args []string) (exitStatus i
 
 	hasMultiple := len(nodePathStrs) > 1
 	for i, nodePathStr := range nodePathStrs {
-		p, err := fsrpc.NewPath(nodePathStr)
+		p, err := fsrpc.ParsePath(nodePathStr)
 		if err != nil {
 			printError("ls", err)
 			exitStatus = 1
@@ -38,10 +38,25 @@ The possible commands are:
   stat		Display file status
   ls		List directory contents
   mkdir		Make directories
+  resolve	Print the canonical form of paths or keybase:// URLs
+  rm		Move entries to the trash, printing undo tokens, or purge it
   read		Dump file to stdout
   write		Write stdin to file
+  export	Write a tar (or IPFS CAR) archive of a directory to stdout
+  import	Restore an escrow bundle into a directory
+  import-cloud	Copy a Dropbox or Google Drive export into a directory
+  mirror	Keep a local copy of a directory up to date
+  ingest	Upload new and changed local files to a directory
+  open-files	List files open through the mount, per folder
+  conflicts	List, compare and resolve conflicted copies of files
+  top		Show live per-folder activity of the mounted KBFS
   md            Operate on metadata objects
   git           Operate on git repositories
+  storage-format  Describe and validate the journal and cache formats
+
+Paths may be given as /keybase/... paths, as keybase:// URLs, or as
+kbfs://tlfid/<id>[@rev=<revision>]/... URLs, which name a folder by
+its ID (optionally as of a past revision) rather than by its users.
 
 `
 
@@ -70,6 +85,12 @@ func realMain() (exitStatus int) {
 		return 1
 	}
 
+	// This one works on directories on disk, and doesn't need
+	// the service.
+	if flag.Arg(0) == "storage-format" {
+		return storageFormatMain(flag.Args()[1:])
+	}
+
 	log := logger.New("")
 
 	// Turn these off to not interfere with a running kbfs daemon.
@@ -99,10 +120,30 @@ func realMain() (exitStatus int) {
 		return ls(ctx, config, args)
 	case "mkdir":
 		return mkdir(ctx, config, args)
+	case "resolve":
+		return resolve(ctx, config, args)
+	case "rm":
+		return rm(ctx, config, args)
 	case "read":
 		return read(ctx, config, args)
 	case "write":
 		return write(ctx, config, args)
+	case "export":
+		return export(ctx, config, args)
+	case "import":
+		return importBundle(ctx, config, args)
+	case "import-cloud":
+		return importCloud(ctx, config, args)
+	case "mirror":
+		return mirror(ctx, config, args)
+	case "ingest":
+		return ingest(ctx, config, args)
+	case "open-files":
+		return openFiles(ctx, config, args)
+	case "conflicts":
+		return conflicts(ctx, config, args)
+	case "top":
+		return top(ctx, config, args)
 	case "md":
 		return mdMain(ctx, config, args)
 	case "git":
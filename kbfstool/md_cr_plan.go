@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+const mdCRPlanUsageStr = `Usage:
+  kbfstool md cr-plan /keybase/[public|private]/user1,assertion2 <output file>
+
+Computes how conflict resolution would resolve this device's unmerged
+changes to the given folder, and writes the planned actions to the
+output file as JSON, without resolving anything.  Only unmerged
+changes that have been flushed to the server are taken into account.
+
+`
+
+func mdCRPlanOne(ctx context.Context, config libkbfs.Config,
+	tlfPath, outputPath string) error {
+	tlfID, err := getTlfID(ctx, config, tlfPath)
+	if err != nil {
+		return err
+	}
+	if tlfID == tlf.NullID {
+		return fmt.Errorf("%s has no folder ID yet", tlfPath)
+	}
+
+	plan, err := libkbfs.SimulateConflictResolution(
+		ctx, config, libkbfs.FolderBranch{
+			Tlf:    tlfID,
+			Branch: libkbfs.MasterBranch,
+		})
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(outputPath, append(data, '\n'), 0600)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Wrote a plan with %d unmerged and %d merged revisions "+
+		"and %d changed directories to %s\n", len(plan.UnmergedRevisions),
+		len(plan.MergedRevisions), len(plan.Entries), outputPath)
+	return nil
+}
+
+func mdCRPlan(ctx context.Context, config libkbfs.Config,
+	args []string) (exitStatus int) {
+	flags := flag.NewFlagSet("kbfs md cr-plan", flag.ContinueOnError)
+	err := flags.Parse(args)
+	if err != nil {
+		printError("md cr-plan", err)
+		return 1
+	}
+
+	inputs := flags.Args()
+	if len(inputs) != 2 {
+		fmt.Print(mdCRPlanUsageStr)
+		return 1
+	}
+
+	err = mdCRPlanOne(ctx, config, inputs[0], inputs[1])
+	if err != nil {
+		printError("md cr-plan", err)
+		return 1
+	}
+
+	return 0
+}
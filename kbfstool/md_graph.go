@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+// mdGraphNode is one MD object of an exported MD graph.  Its parent
+// is the node whose ID is its PrevRoot, so the first revision of an
+// unmerged branch points to the branch point on the master branch.
+type mdGraphNode struct {
+	ID       kbfsmd.ID
+	PrevRoot kbfsmd.ID
+	Revision kbfsmd.Revision
+	// BranchID is empty for the master branch.
+	BranchID string `json:",omitempty"`
+	Writer   string
+	Device   string
+	Time     time.Time
+	// Resolution is whether this is the result of conflict
+	// resolution, merging an unmerged branch back into the master
+	// branch.
+	Resolution bool
+	Ops        []string
+}
+
+// mdGraphData is the MD history of a TLF, suitable for encoding
+// directly as JSON.
+type mdGraphData struct {
+	Tlf   tlf.ID
+	Nodes []mdGraphNode
+}
+
+// mdGraphIsResolution guesses from its ops whether a merged MD object
+// is the result of conflict resolution.  Conflict resolution ends
+// with a resolution op, after the ops it replays, while batched
+// syncs start with one.  (Batches too big to embed their block
+// changes end with one too, so those are marked as well.)
+func mdGraphIsResolution(ops []string) bool {
+	return len(ops) > 0 && ops[len(ops)-1] == "resolution"
+}
+
+func mdGraphAddNodes(ctx context.Context, config libkbfs.Config,
+	replacements replacementMap, graph *mdGraphData,
+	branchID kbfsmd.BranchID, start, stop kbfsmd.Revision) error {
+	// TODO: Make maxChunkSize configurable, like for md dump.
+	const maxChunkSize = 100
+
+	for chunkStart := start; chunkStart <= stop; chunkStart += maxChunkSize {
+		chunkStop := chunkStart + maxChunkSize - 1
+		if chunkStop > stop {
+			chunkStop = stop
+		}
+		irmds, err := mdGet(
+			ctx, config, graph.Tlf, branchID, chunkStart, chunkStop)
+		if err != nil {
+			return err
+		}
+
+		for _, irmd := range irmds {
+			err := mdDumpFillReplacements(
+				ctx, config.Codec(), config.KeybaseService(), "md graph",
+				irmd.GetBareRootMetadata(), irmd.Extra(), replacements)
+			if err != nil {
+				printError("md graph", err)
+			}
+
+			node := mdGraphNode{
+				ID:       irmd.MdID(),
+				PrevRoot: irmd.PrevRoot(),
+				Revision: irmd.Revision(),
+				Writer: mdDumpReplaceAll(
+					irmd.LastModifyingWriter().String(), replacements),
+				Device: mdDumpReplaceAll(
+					irmd.LastModifyingWriterVerifyingKey().String(),
+					replacements),
+				Time: irmd.LocalTimestamp(),
+			}
+			for _, op := range irmd.Data().Changes.Ops {
+				node.Ops = append(node.Ops, op.String())
+			}
+			if irmd.BID() != kbfsmd.NullBranchID {
+				node.BranchID = irmd.BID().String()
+			} else {
+				node.Resolution = mdGraphIsResolution(node.Ops)
+			}
+			graph.Nodes = append(graph.Nodes, node)
+		}
+	}
+	return nil
+}
+
+func mdGraphGet(ctx context.Context, config libkbfs.Config,
+	input string) (graph mdGraphData, err error) {
+	tlfStr, branchStr, startStr, stopStr, err := mdSplitInput(input)
+	if err != nil {
+		return mdGraphData{}, err
+	}
+	if branchStr != "" {
+		return mdGraphData{}, fmt.Errorf(
+			"%q: the branches to export can't be specified", input)
+	}
+	if startStr == "" && stopStr == "" {
+		startStr = kbfsmd.RevisionInitial.String()
+		stopStr = "latest"
+	}
+
+	tlfID, _, start, stop, err := mdParseInput(
+		ctx, config, tlfStr, "master", startStr, stopStr)
+	if err != nil {
+		return mdGraphData{}, err
+	}
+	if start > stop {
+		start, stop = stop, start
+	}
+
+	graph.Tlf = tlfID
+	replacements := make(replacementMap)
+	err = mdGraphAddNodes(
+		ctx, config, replacements, &graph, kbfsmd.NullBranchID, start, stop)
+	if err != nil {
+		return mdGraphData{}, err
+	}
+
+	// The server only gives out the unmerged branch of the current
+	// device.
+	branchID, err := getBranchID(ctx, config, tlfID, "device")
+	if err != nil {
+		return mdGraphData{}, err
+	}
+	if branchID == kbfsmd.NullBranchID {
+		return graph, nil
+	}
+	unmergedStop, err := getRevision(ctx, config, tlfID, branchID, "latest")
+	if err != nil {
+		return mdGraphData{}, err
+	}
+	err = mdGraphAddNodes(
+		ctx, config, replacements, &graph, branchID, start, unmergedStop)
+	if err != nil {
+		return mdGraphData{}, err
+	}
+	return graph, nil
+}
+
+// mdGraphDOTQuote quotes a string as a DOT ID.
+func mdGraphDOTQuote(s string) string {
+	s = strings.Replace(s, `"`, `\"`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return `"` + s + `"`
+}
+
+func mdGraphEncodeDOT(graph mdGraphData) []byte {
+	var buf bytes.Buffer
+	ids := make(map[kbfsmd.ID]bool, len(graph.Nodes))
+	for _, node := range graph.Nodes {
+		ids[node.ID] = true
+	}
+
+	fmt.Fprintf(&buf, "digraph %s {\n", mdGraphDOTQuote(graph.Tlf.String()))
+	fmt.Fprint(&buf, "  rankdir=LR;\n  node [shape=box];\n")
+	for _, node := range graph.Nodes {
+		label := fmt.Sprintf("rev %s\n%s\n%s\n%s", node.Revision,
+			node.Writer, node.Device, node.Time.Format(time.RFC3339))
+		var attrs []string
+		if node.BranchID != "" {
+			label += "\nbranch " + node.BranchID
+			attrs = append(attrs, "style=dashed")
+		}
+		if node.Resolution {
+			label += "\nconflict resolution"
+			attrs = append(attrs, "peripheries=2")
+		}
+		attrs = append(attrs, "label="+mdGraphDOTQuote(label))
+		fmt.Fprintf(&buf, "  %s [%s];\n",
+			mdGraphDOTQuote(node.ID.String()), strings.Join(attrs, ", "))
+		// Edges to MD objects outside of the range are left out.
+		if ids[node.PrevRoot] {
+			fmt.Fprintf(&buf, "  %s -> %s;\n",
+				mdGraphDOTQuote(node.PrevRoot.String()),
+				mdGraphDOTQuote(node.ID.String()))
+		}
+	}
+	fmt.Fprint(&buf, "}\n")
+	return buf.Bytes()
+}
+
+const mdGraphUsageStr = `Usage:
+  kbfstool md graph [-f dot|json] input output
+
+Writes the MD history of a folder to the output file, as a graph of MD
+objects in either the DOT format of Graphviz, or as JSON.  Each MD
+object names its writer and device, and links to its predecessor, so
+that the unmerged branch of this device (if any) is shown branching off
+the master branch.  MD objects that resolve conflicts are marked.
+
+The input must be in the following format:
+
+  TLF
+  TLF^RevisionRange
+
+where TLF and RevisionRange are as for "kbfstool md dump", except that
+an omitted range exports the whole history.  The whole unmerged branch
+is exported, if the revision range includes its branch point.
+`
+
+func mdGraph(ctx context.Context, config libkbfs.Config,
+	args []string) (exitStatus int) {
+	flags := flag.NewFlagSet("kbfs md graph", flag.ContinueOnError)
+	format := flags.String("f", "dot", "Output format: dot or json.")
+	err := flags.Parse(args)
+	if err != nil {
+		printError("md graph", err)
+		return 1
+	}
+
+	inputs := flags.Args()
+	if len(inputs) != 2 {
+		fmt.Print(mdGraphUsageStr)
+		return 1
+	}
+	if *format != "dot" && *format != "json" {
+		printError("md graph", fmt.Errorf("unknown format %q", *format))
+		return 1
+	}
+
+	graph, err := mdGraphGet(ctx, config, inputs[0])
+	if err != nil {
+		printError("md graph", err)
+		return 1
+	}
+
+	var data []byte
+	if *format == "dot" {
+		data = mdGraphEncodeDOT(graph)
+	} else {
+		data, err = json.MarshalIndent(graph, "", "  ")
+		if err != nil {
+			printError("md graph", err)
+			return 1
+		}
+		data = append(data, '\n')
+	}
+	err = ioutil.WriteFile(inputs[1], data, 0600)
+	if err != nil {
+		printError("md graph", err)
+		return 1
+	}
+
+	fmt.Printf("Wrote %d MD objects to %s\n", len(graph.Nodes), inputs[1])
+	return 0
+}
@@ -0,0 +1,131 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMDGraphBranch(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	config1 := libkbfs.MakeTestConfigOrBust(t, "alice", "bob")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config1)
+	config2 := libkbfs.ConfigAsUser(config1, "bob")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config2)
+
+	const name = "alice,bob"
+	root1 := libkbfs.GetRootNodeOrBust(ctx, t, config1, name, tlf.Private)
+	kbfsOps1 := config1.KBFSOps()
+	_, _, err := kbfsOps1.CreateFile(ctx, root1, "a", false, libkbfs.NoExcl)
+	require.NoError(t, err)
+	fb := root1.GetFolderBranch()
+	require.NoError(t, kbfsOps1.SyncAll(ctx, fb))
+
+	t.Log("bob makes an unmerged branch of two revisions off of " +
+		"revision 2, while alice writes revision 3")
+	root2 := libkbfs.GetRootNodeOrBust(ctx, t, config2, name, tlf.Private)
+	kbfsOps2 := config2.KBFSOps()
+	c, err := libkbfs.DisableUpdatesForTesting(config2, fb)
+	require.NoError(t, err)
+	require.NoError(t, libkbfs.DisableCRForTesting(config2, fb))
+	_, _, err = kbfsOps1.CreateFile(ctx, root1, "b", false, libkbfs.NoExcl)
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps1.SyncAll(ctx, fb))
+	for _, name := range []string{"c", "d"} {
+		_, _, err = kbfsOps2.CreateFile(
+			ctx, root2, name, false, libkbfs.NoExcl)
+		require.NoError(t, err)
+		require.NoError(t, kbfsOps2.SyncAll(ctx, fb))
+	}
+
+	graph, err := mdGraphGet(ctx, config2, "/keybase/private/"+name)
+	require.NoError(t, err)
+	require.Equal(t, fb.Tlf, graph.Tlf)
+	require.Len(t, graph.Nodes, 5)
+	master, branch := graph.Nodes[:3], graph.Nodes[3:]
+	for i, node := range master {
+		require.Equal(t, kbfsmd.Revision(i+1), node.Revision)
+		require.Empty(t, node.BranchID)
+		require.False(t, node.Resolution)
+		if i > 0 {
+			require.Equal(t, master[i-1].ID, node.PrevRoot)
+		}
+	}
+	require.Contains(t, master[2].Writer, "alice")
+	require.NotEmpty(t, branch[0].BranchID)
+	require.Equal(t, kbfsmd.Revision(3), branch[0].Revision)
+	require.Equal(t, master[1].ID, branch[0].PrevRoot)
+	require.Contains(t, branch[0].Writer, "bob")
+	require.Equal(t, branch[0].BranchID, branch[1].BranchID)
+	require.Equal(t, kbfsmd.Revision(4), branch[1].Revision)
+	require.Equal(t, branch[0].ID, branch[1].PrevRoot)
+	require.False(t, branch[0].Resolution)
+	require.False(t, branch[1].Resolution)
+
+	t.Log("The DOT graph forks at the branch point")
+	dot := string(mdGraphEncodeDOT(graph))
+	edge := func(from, to mdGraphNode) string {
+		return mdGraphDOTQuote(from.ID.String()) + " -> " +
+			mdGraphDOTQuote(to.ID.String()) + ";"
+	}
+	require.Contains(t, dot, edge(master[1], master[2]))
+	require.Contains(t, dot, edge(master[1], branch[0]))
+	require.Contains(t, dot, edge(branch[0], branch[1]))
+	require.Equal(t, 2, strings.Count(dot, "style=dashed"))
+	require.NotContains(t, dot, "peripheries=2")
+	require.Equal(t, 4, strings.Count(dot, " -> "))
+
+	t.Log("A revision range leaves out the edges to older revisions")
+	graph, err = mdGraphGet(ctx, config2, "/keybase/private/"+name+"^2-3")
+	require.NoError(t, err)
+	require.Len(t, graph.Nodes, 4)
+	require.Equal(t, kbfsmd.Revision(2), graph.Nodes[0].Revision)
+	require.Equal(t, 3, strings.Count(
+		string(mdGraphEncodeDOT(graph)), " -> "))
+
+	close(c)
+	require.NoError(t, libkbfs.RestartCRForTesting(ctx, config2, fb))
+	require.NoError(t, kbfsOps2.SyncFromServer(ctx, fb, nil))
+
+	t.Log("Once the branch is resolved, only the merged resolution " +
+		"is left, and it's marked")
+	graph, err = mdGraphGet(ctx, config2, "/keybase/private/"+name)
+	require.NoError(t, err)
+	require.Len(t, graph.Nodes, 4)
+	for i, node := range graph.Nodes {
+		require.Equal(t, kbfsmd.Revision(i+1), node.Revision)
+		require.Empty(t, node.BranchID)
+		require.Equal(t, i == 3, node.Resolution)
+	}
+	require.Equal(t, master[2].ID, graph.Nodes[3].PrevRoot)
+	dot = string(mdGraphEncodeDOT(graph))
+	require.Equal(t, 1, strings.Count(dot, "peripheries=2"))
+	require.NotContains(t, dot, "style=dashed")
+}
+
+func TestMDGraphEncodeDOTResolution(t *testing.T) {
+	graph := mdGraphData{
+		Tlf: tlf.FakeID(1, tlf.Private),
+		Nodes: []mdGraphNode{
+			{ID: kbfsmd.FakeID(1), Revision: 1, Writer: "alice"},
+			{ID: kbfsmd.FakeID(2), PrevRoot: kbfsmd.FakeID(1), Revision: 2,
+				Writer: "bob", Resolution: true, Ops: []string{"resolution"}},
+		},
+	}
+	dot := string(mdGraphEncodeDOT(graph))
+	require.True(t, strings.HasPrefix(
+		dot, "digraph "+mdGraphDOTQuote(graph.Tlf.String())+" {\n"))
+	require.Contains(t, dot, mdGraphDOTQuote(kbfsmd.FakeID(1).String())+
+		" -> "+mdGraphDOTQuote(kbfsmd.FakeID(2).String())+";")
+	require.Equal(t, 1, strings.Count(dot, "peripheries=2"))
+	require.Contains(t, dot, `\nconflict resolution"`)
+	require.NotContains(t, dot, "style=dashed")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const mdLeaksUsageStr = `Usage:
+  kbfstool md leaks [-release] /keybase/[public|private]/user1,assertion2
+
+Cross-checks the block references recorded in the folder's history
+against the data reachable from its latest revision, and lists the
+ones that leaked.  With -release, removes them from the block server
+to reclaim their quota; only do that while no other device is
+writing to the folder, since the blocks of its writes are put before
+their metadata.
+
+`
+
+func mdLeaks(ctx context.Context, config libkbfs.Config,
+	args []string) (exitStatus int) {
+	flags := flag.NewFlagSet("kbfs md leaks", flag.ContinueOnError)
+	release := flags.Bool("release", false,
+		"Remove the leaked references from the block server.")
+	err := flags.Parse(args)
+	if err != nil {
+		printError("md leaks", err)
+		return 1
+	}
+
+	inputs := flags.Args()
+	if len(inputs) != 1 {
+		fmt.Print(mdLeaksUsageStr)
+		return 1
+	}
+
+	p, err := fsrpc.ParsePath(inputs[0])
+	if err != nil {
+		printError("md leaks", err)
+		return 1
+	}
+	if p.PathType != fsrpc.TLFPathType || len(p.TLFComponents) > 0 {
+		printError("md leaks", fmt.Errorf("%s is not a folder", p))
+		return 1
+	}
+	dir, err := p.GetDirNode(ctx, config)
+	if err != nil {
+		printError("md leaks", err)
+		return 1
+	}
+
+	report, err := config.KBFSOps().FindLeakedBlockRefs(
+		ctx, dir.GetFolderBranch(), *release)
+	if err != nil {
+		printError("md leaks", err)
+		return 1
+	}
+
+	fmt.Printf("Checked revision %d: %d reachable references\n",
+		report.Revision, report.Reachable)
+	for _, ptr := range report.Leaked {
+		fmt.Printf("leaked %v\n", ptr)
+	}
+	if report.Unlisted {
+		fmt.Print("The block server can't list its references, so " +
+			"references no revision mentions can't be found\n")
+	}
+	for _, ptr := range report.Unrecorded {
+		fmt.Printf("unrecorded %v\n", ptr)
+	}
+	if report.Released {
+		fmt.Printf("Released %d references\n",
+			len(report.Leaked)+len(report.Unrecorded))
+	}
+
+	return 0
+}
@@ -15,6 +15,9 @@ The possible subcommands are:
   check	      Check metadata objects and their associated blocks for errors
   reset	      Reset a broken top-level folder
   force-qr    Append a fake quota reclamation record to the folder history
+  cr-plan     Write out how conflict resolution would resolve a folder
+  graph       Write out the history of a folder as a graph
+  leaks       Find, and optionally release, leaked block references
 `
 
 func mdMain(ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
@@ -35,6 +38,12 @@ func mdMain(ctx context.Context, config libkbfs.Config, args []string) (exitStat
 		return mdReset(ctx, config, args)
 	case "force-qr":
 		return mdForceQR(ctx, config, args)
+	case "cr-plan":
+		return mdCRPlan(ctx, config, args)
+	case "graph":
+		return mdGraph(ctx, config, args)
+	case "leaks":
+		return mdLeaks(ctx, config, args)
 	default:
 		printError("md", fmt.Errorf("unknown command %q", cmd))
 		return 1
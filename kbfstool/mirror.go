@@ -0,0 +1,148 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const mirrorUsageStr = `Usage:
+  kbfstool mirror [flags] /keybase/{public,team}/name[/dir] <local dir>
+
+Copies a folder, or a directory in it, to a local directory, and keeps
+it up to date until interrupted.  The copy is one-way: local changes
+are overwritten or left alone, and are never written back.
+
+`
+
+func mirrorHelper(
+	ctx context.Context, config libkbfs.Config, args []string) error {
+	flags := flag.NewFlagSet("kbfs mirror", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, mirrorUsageStr)
+		flags.PrintDefaults()
+	}
+	stateDir := flags.String("state", "",
+		"Keep the mirror's state in this directory, which must be on the "+
+			"same file system as the local directory.  Defaults to a "+
+			"hidden directory next to it.")
+	deleteRemoved := flags.Bool("delete", false,
+		"Delete local copies of removed entries.")
+	bwLimit := flags.Int("bwlimit", 0,
+		"Copy at most this many KiB per second.  0 means no limit.")
+	interval := flags.Duration("interval", 5*time.Minute,
+		"Check for changes at least this often.")
+	once := flags.Bool("once", false,
+		"Bring the local directory up to date and exit.")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return errors.New("a folder and a local directory must be specified")
+	}
+
+	p, err := fsrpc.ParsePath(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if p.PathType != fsrpc.TLFPathType {
+		return fmt.Errorf("Cannot mirror %s", p)
+	}
+	dest, err := filepath.Abs(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	if *stateDir == "" {
+		*stateDir = filepath.Join(
+			filepath.Dir(dest), "."+filepath.Base(dest)+".kbfs_mirror")
+	}
+
+	tlfHandle, err := p.GetHandle(ctx, config)
+	if err != nil {
+		return err
+	}
+	fs, err := libfs.NewFS(
+		ctx, config, tlfHandle, p.BranchName(), "", "",
+		keybase1.MDPriorityNormal)
+	if err != nil {
+		return err
+	}
+	m, err := libfs.NewMirror(fs, path.Join(p.TLFComponents...), dest,
+		libfs.MirrorOptions{
+			StateDir:       *stateDir,
+			Delete:         *deleteRemoved,
+			BytesPerSecond: *bwLimit * 1024,
+		})
+	if err != nil {
+		return err
+	}
+
+	if *once {
+		stats, err := m.SyncOnce(ctx)
+		if err != nil {
+			return err
+		}
+		printMirrorStats(stats)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	go func() {
+		select {
+		case <-sigCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	err = m.Run(ctx, *interval, func(stats libfs.MirrorStats, err error) {
+		if err != nil {
+			printError("mirror", err)
+			return
+		}
+		printMirrorStats(stats)
+	})
+	if err == context.Canceled {
+		return nil
+	}
+	return err
+}
+
+func printMirrorStats(stats libfs.MirrorStats) {
+	if stats.Copied == 0 && stats.Deleted == 0 {
+		return
+	}
+	fmt.Printf("Mirrored revision %d: %d copied (%d bytes), %d deleted\n",
+		stats.Revision, stats.Copied, stats.Bytes, stats.Deleted)
+}
+
+func mirror(
+	ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	err := mirrorHelper(ctx, config, args)
+	if err != nil {
+		printError("mirror", err)
+		exitStatus = 1
+	}
+	return
+}
@@ -27,7 +27,7 @@ func createDir(ctx context.Context, kbfsOps libkbfs.KBFSOps, parentNode libkbfs.
 }
 
 func mkdirOne(ctx context.Context, config libkbfs.Config, dirPathStr string, createIntermediate, verbose bool) error {
-	p, err := fsrpc.NewPath(dirPathStr)
+	p, err := fsrpc.ParsePath(dirPathStr)
 	if err != nil {
 		return err
 	}
@@ -40,11 +40,8 @@ func mkdirOne(ctx context.Context, config libkbfs.Config, dirPathStr string, cre
 			return nil
 		}
 
-		tlfRoot := fsrpc.Path{
-			PathType: fsrpc.TLFPathType,
-			TLFType:  p.TLFType,
-			TLFName:  p.TLFName,
-		}
+		tlfRoot := p
+		tlfRoot.TLFComponents = nil
 		tlfNode, err := tlfRoot.GetDirNode(ctx, config)
 		if err != nil {
 			return err
@@ -0,0 +1,81 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+const openFilesUsageStr = `Usage:
+  kbfstool open-files [<mounted dir>...]
+
+Lists the files open through the local KBFS mount in the folders
+containing the given directories of the mount (by default, the
+current directory), with the process that opened each one where the
+platform reports it, how it was opened, and how many written bytes
+haven't been synced yet.  Use it to find what's keeping the mount
+busy or a folder's journal dirty.
+
+`
+
+func openFilesHelper(args []string) error {
+	flags := flag.NewFlagSet("kbfs open-files", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, openFilesUsageStr)
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	dirs := flags.Args()
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "PID\tMODE\tUNSYNCED\tPATH")
+	for _, dir := range dirs {
+		data, err := ioutil.ReadFile(
+			filepath.Join(dir, libfs.OpenFilesFileName))
+		if err != nil {
+			return err
+		}
+		var openFiles []libkbfs.OpenFile
+		err = json.Unmarshal(data, &openFiles)
+		if err != nil {
+			return err
+		}
+		for _, of := range openFiles {
+			pid := "-"
+			if of.Pid != 0 {
+				pid = fmt.Sprintf("%d", of.Pid)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\n",
+				pid, of.Mode, of.UnsyncedBytes, of.Path)
+		}
+	}
+	return w.Flush()
+}
+
+func openFiles(
+	ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	err := openFilesHelper(args)
+	if err != nil {
+		printError("open-files", err)
+		exitStatus = 1
+	}
+	return
+}
@@ -59,12 +59,12 @@ func readHelper(ctx context.Context, config libkbfs.Config, args []string) error
 	}
 
 	filePathStr := flags.Arg(0)
-	p, err := fsrpc.NewPath(filePathStr)
+	p, err := fsrpc.ParsePath(filePathStr)
 	if err != nil {
 		return err
 	}
 
-	if p.PathType != fsrpc.TLFPathType {
+	if p.PathType != fsrpc.TLFPathType || len(p.TLFComponents) == 0 {
 		return fmt.Errorf("Cannot read %s", p)
 	}
 
@@ -72,10 +72,30 @@ func readHelper(ctx context.Context, config libkbfs.Config, args []string) error
 		fmt.Fprintf(os.Stderr, "Looking up %s\n", p)
 	}
 
-	fileNode, err := p.GetFileNode(ctx, config)
+	// Small files can be looked up and read in one go.
+	dir, name, err := p.DirAndBasename()
 	if err != nil {
 		return err
 	}
+	dirNode, err := dir.GetDirNode(ctx, config)
+	if err != nil {
+		return err
+	}
+	fileNode, ei, data, err := config.KBFSOps().LookupAndReadSmall(
+		ctx, dirNode, name, libkbfs.SmallReadMaxSizeDefault)
+	if err != nil {
+		return err
+	}
+	if ei.Type != libkbfs.File && ei.Type != libkbfs.Exec {
+		return fmt.Errorf("openFile: %s is not a file, but a %s", p, ei.Type)
+	}
+	if data != nil {
+		if *verbose {
+			fmt.Fprintf(os.Stderr, "Read %s\n", byteCountStr(len(data)))
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	}
 
 	nr := nodeReader{
 		ctx:     ctx,
@@ -0,0 +1,65 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// resolveOutput is the JSON form of an fsrpc.ResolvedPath.
+type resolveOutput struct {
+	Input             string
+	Path              string
+	TlfID             string
+	FullyResolved     bool
+	UnresolvedWriters []string `json:",omitempty"`
+	UnresolvedReaders []string `json:",omitempty"`
+	Writable          bool
+}
+
+func resolve(ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	flags := flag.NewFlagSet("kbfs resolve", flag.ContinueOnError)
+	err := flags.Parse(args)
+	if err != nil {
+		printError("resolve", err)
+		return 1
+	}
+
+	nodePaths := flags.Args()
+	if len(nodePaths) == 0 {
+		printError("resolve", errAtLeastOnePath)
+		return 1
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, nodePath := range nodePaths {
+		rp, err := fsrpc.Resolve(ctx, config, nodePath)
+		if err != nil {
+			printError("resolve", err)
+			exitStatus = 1
+			continue
+		}
+		err = enc.Encode(resolveOutput{
+			Input:             nodePath,
+			Path:              rp.String(),
+			TlfID:             rp.Handle.TlfID().String(),
+			FullyResolved:     rp.FullyResolved,
+			UnresolvedWriters: rp.UnresolvedWriters,
+			UnresolvedReaders: rp.UnresolvedReaders,
+			Writable:          rp.Writable,
+		})
+		if err != nil {
+			printError("resolve", err)
+			return 1
+		}
+	}
+	return
+}
@@ -0,0 +1,323 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	stdpath "path"
+	"strings"
+	"time"
+
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// rmUndoToken records where a removed entry was moved, so the removal
+// can be undone before the token expires.
+type rmUndoToken struct {
+	Path string
+	// TrashPath is relative to the root of the TLF.
+	TrashPath string
+	Expires   time.Time
+}
+
+func (t rmUndoToken) String() string {
+	buf, err := json.Marshal(t)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func parseRmUndoToken(s string) (t rmUndoToken, err error) {
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return rmUndoToken{}, fmt.Errorf("invalid undo token: %v", err)
+	}
+	err = json.Unmarshal(buf, &t)
+	if err != nil {
+		return rmUndoToken{}, fmt.Errorf("invalid undo token: %v", err)
+	}
+	return t, nil
+}
+
+var errNotInTLF = errors.New("only entries within a TLF can be removed")
+
+func getTLFRootNode(ctx context.Context, config libkbfs.Config,
+	p fsrpc.Path) (libkbfs.Node, error) {
+	tlfRoot := p
+	tlfRoot.TLFComponents = nil
+	return tlfRoot.GetDirNode(ctx, config)
+}
+
+func rmOne(ctx context.Context, config libkbfs.Config, pathStr string,
+	retention time.Duration) (rmUndoToken, error) {
+	p, err := fsrpc.ParsePath(pathStr)
+	if err != nil {
+		return rmUndoToken{}, err
+	}
+	if p.PathType != fsrpc.TLFPathType || len(p.TLFComponents) == 0 {
+		return rmUndoToken{}, errNotInTLF
+	}
+	if p.TLFComponents[0] == libfs.TrashDirName {
+		return rmUndoToken{}, fmt.Errorf(
+			"%s is already in the trash", p)
+	}
+
+	parentDir, name, err := p.DirAndBasename()
+	if err != nil {
+		return rmUndoToken{}, err
+	}
+	parentNode, err := parentDir.GetDirNode(ctx, config)
+	if err != nil {
+		return rmUndoToken{}, err
+	}
+	rootNode, err := getTLFRootNode(ctx, config, p)
+	if err != nil {
+		return rmUndoToken{}, err
+	}
+
+	if retention == 0 {
+		settings, err := libkbfs.GetCachedTlfSettings(
+			ctx, config, rootNode.GetFolderBranch())
+		if err != nil {
+			return rmUndoToken{}, err
+		}
+		retention = settings.GetTrashRetention()
+	}
+
+	now := config.Clock().Now()
+	kbfsOps := config.KBFSOps()
+	trashPath, err := libfs.MoveToTrash(ctx, kbfsOps, config.Clock(),
+		rootNode, parentNode, stdpath.Join(parentDir.TLFComponents...), name)
+	if err != nil {
+		return rmUndoToken{}, err
+	}
+	err = kbfsOps.SyncAll(ctx, parentNode.GetFolderBranch())
+	if err != nil {
+		return rmUndoToken{}, err
+	}
+	token := rmUndoToken{
+		Path:      p.String(),
+		TrashPath: trashPath,
+	}
+	if retention != libkbfs.HistoryRetentionForever {
+		token.Expires = now.Add(retention)
+	}
+	return token, nil
+}
+
+// rmPurge permanently removes the entries in the trash of the TLF
+// containing `pathStr` that are past the TLF's trash retention.
+func rmPurge(ctx context.Context, config libkbfs.Config, pathStr string,
+	verbose bool) error {
+	p, err := fsrpc.ParsePath(pathStr)
+	if err != nil {
+		return err
+	}
+	if p.PathType != fsrpc.TLFPathType {
+		return fmt.Errorf("%s is not in a TLF", p)
+	}
+	rootNode, err := getTLFRootNode(ctx, config, p)
+	if err != nil {
+		return err
+	}
+	settings, err := libkbfs.GetCachedTlfSettings(
+		ctx, config, rootNode.GetFolderBranch())
+	if err != nil {
+		return err
+	}
+	purged, err := libfs.PurgeTrash(ctx, config.KBFSOps(), rootNode,
+		config.Clock().Now(), settings.GetTrashRetention())
+	if err != nil {
+		return err
+	}
+	if verbose {
+		fmt.Fprintf(os.Stderr, "rm: purged %d entries from the trash of %q\n",
+			purged, p.String())
+	}
+	return nil
+}
+
+// rmNow deletes the entry at `pathStr` permanently, along with
+// everything under it if it's a directory.
+func rmNow(ctx context.Context, config libkbfs.Config, pathStr string,
+	verbose bool) error {
+	p, err := fsrpc.ParsePath(pathStr)
+	if err != nil {
+		return err
+	}
+	if p.PathType != fsrpc.TLFPathType || len(p.TLFComponents) == 0 {
+		return errNotInTLF
+	}
+
+	parentDir, name, err := p.DirAndBasename()
+	if err != nil {
+		return err
+	}
+	parentNode, err := parentDir.GetDirNode(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	var progress func(libkbfs.RemoveTreeProgress)
+	if verbose {
+		progress = func(rp libkbfs.RemoveTreeProgress) {
+			fmt.Fprintf(os.Stderr, "rm: removed %d of %d entries of %q\n",
+				rp.EntriesRemoved, rp.EntriesTotal, p.String())
+		}
+	}
+	kbfsOps := config.KBFSOps()
+	err = kbfsOps.RemoveTree(ctx, parentNode, name, progress)
+	if err != nil {
+		return err
+	}
+	return kbfsOps.SyncAll(ctx, parentNode.GetFolderBranch())
+}
+
+func rmUndo(ctx context.Context, config libkbfs.Config, tokenStr string) error {
+	token, err := parseRmUndoToken(tokenStr)
+	if err != nil {
+		return err
+	}
+	if !token.Expires.IsZero() && config.Clock().Now().After(token.Expires) {
+		return fmt.Errorf("undo token for %s expired at %s",
+			token.Path, token.Expires)
+	}
+
+	p, err := fsrpc.ParsePath(token.Path)
+	if err != nil {
+		return err
+	}
+	if p.PathType != fsrpc.TLFPathType || len(p.TLFComponents) == 0 {
+		return errNotInTLF
+	}
+	parentDir, name, err := p.DirAndBasename()
+	if err != nil {
+		return err
+	}
+	parentNode, err := parentDir.GetDirNode(ctx, config)
+	if err != nil {
+		return err
+	}
+	if !libfs.InTrash(token.TrashPath) {
+		return fmt.Errorf("invalid undo token: %s is not in the trash",
+			token.TrashPath)
+	}
+	trashDir := p
+	trashDir.TLFComponents = strings.Split(stdpath.Dir(token.TrashPath), "/")
+	trashNode, err := trashDir.GetDirNode(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	kbfsOps := config.KBFSOps()
+	_, _, err = kbfsOps.Lookup(ctx, parentNode, name)
+	switch err.(type) {
+	case nil:
+		return libkbfs.NameExistsError{Name: p.String()}
+	case libkbfs.NoSuchNameError:
+	default:
+		return err
+	}
+	err = kbfsOps.Rename(
+		ctx, trashNode, stdpath.Base(token.TrashPath), parentNode, name)
+	if err != nil {
+		return err
+	}
+	return kbfsOps.SyncAll(ctx, parentNode.GetFolderBranch())
+}
+
+func rm(ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	flags := flag.NewFlagSet("kbfs rm", flag.ContinueOnError)
+	retention := flags.Duration("retention", 0,
+		"How long the printed undo tokens remain valid; defaults to "+
+			"the trash retention of each TLF.")
+	undo := flags.String("undo", "",
+		"Restore the entry described by this undo token.")
+	now := flags.Bool("now", false,
+		"Delete the entries permanently instead of moving them to the "+
+			"trash.  Directories are deleted along with everything "+
+			"under them.")
+	purge := flags.Bool("purge", false,
+		"Permanently delete the entries in the trash of the TLFs "+
+			"containing the given paths that are past the TLF's "+
+			"trash retention.")
+	verbose := flags.Bool("v", false, "Print extra status output.")
+	err := flags.Parse(args)
+	if err != nil {
+		printError("rm", err)
+		return 1
+	}
+
+	if *undo != "" {
+		if flags.NArg() != 0 {
+			printError("rm", errors.New("no paths may be given with -undo"))
+			return 1
+		}
+		err := rmUndo(ctx, config, *undo)
+		if err != nil {
+			printError("rm", err)
+			return 1
+		}
+		return 0
+	}
+
+	nodePaths := flags.Args()
+	if len(nodePaths) == 0 {
+		printError("rm", errAtLeastOnePath)
+		return 1
+	}
+
+	if *purge {
+		if *now {
+			printError("rm", errors.New("-purge and -now can't be combined"))
+			return 1
+		}
+		for _, nodePath := range nodePaths {
+			err := rmPurge(ctx, config, nodePath, *verbose)
+			if err != nil {
+				printError("rm", err)
+				exitStatus = 1
+			}
+		}
+		return
+	}
+
+	if *now {
+		for _, nodePath := range nodePaths {
+			err := rmNow(ctx, config, nodePath, *verbose)
+			if err != nil {
+				printError("rm", err)
+				exitStatus = 1
+			}
+		}
+		return
+	}
+
+	for _, nodePath := range nodePaths {
+		token, err := rmOne(ctx, config, nodePath, *retention)
+		if err != nil {
+			printError("rm", err)
+			exitStatus = 1
+			continue
+		}
+		if *verbose && token.Expires.IsZero() {
+			fmt.Fprintf(os.Stderr, "rm: moved %q to the trash\n", token.Path)
+		} else if *verbose {
+			fmt.Fprintf(os.Stderr, "rm: moved %q to the trash until %s\n",
+				token.Path, token.Expires.Format(time.RFC3339))
+		}
+		fmt.Printf("%s\t%s\n", token.Path, token)
+	}
+	return
+}
@@ -0,0 +1,146 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/fsrpc"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func makeRmTestTree(ctx context.Context, t *testing.T,
+	config libkbfs.Config) libkbfs.Node {
+	kbfsOps := config.KBFSOps()
+	root := libkbfs.GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	a, _, err := kbfsOps.CreateDir(ctx, root, "a")
+	require.NoError(t, err)
+	b, _, err := kbfsOps.CreateDir(ctx, a, "b")
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, b, "f", false, libkbfs.NoExcl)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, b, "g", false, libkbfs.NoExcl)
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps.SyncAll(ctx, root.GetFolderBranch()))
+	return root
+}
+
+func requireRmTestPath(ctx context.Context, t *testing.T,
+	config libkbfs.Config, pathStr string, exists bool) {
+	p, err := fsrpc.ParsePath(pathStr)
+	require.NoError(t, err)
+	_, _, err = p.GetNode(ctx, config)
+	if exists {
+		require.NoError(t, err, pathStr)
+	} else {
+		require.IsType(t, libkbfs.NoSuchNameError{}, err, pathStr)
+	}
+}
+
+func TestRmUndo(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	config := libkbfs.MakeTestConfigOrBust(t, "alice")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	clock := &libkbfs.TestClock{}
+	now := time.Unix(1500000000, 234)
+	clock.Set(now)
+	config.SetClock(clock)
+	makeRmTestTree(ctx, t, config)
+
+	t.Log("The trash mirrors the directory of the removed entry, and " +
+		"tokens last for the trash retention by default")
+	token, err := rmOne(ctx, config, "/keybase/private/alice/a/b/f", 0)
+	require.NoError(t, err)
+	require.Equal(t, "/keybase/private/alice/a/b/f", token.Path)
+	require.Equal(t, ".trash/a/b/f.1500000000000000234", token.TrashPath)
+	require.True(t, now.Add(libkbfs.DefaultTrashRetention).Equal(
+		token.Expires))
+	requireRmTestPath(ctx, t, config, "/keybase/private/alice/a/b/f", false)
+	requireRmTestPath(ctx, t, config,
+		"/keybase/private/alice/.trash/a/b/f.1500000000000000234", true)
+
+	t.Log("Restore the entry")
+	parsed, err := parseRmUndoToken(token.String())
+	require.NoError(t, err)
+	require.Equal(t, token.TrashPath, parsed.TrashPath)
+	require.NoError(t, rmUndo(ctx, config, token.String()))
+	requireRmTestPath(ctx, t, config, "/keybase/private/alice/a/b/f", true)
+	requireRmTestPath(ctx, t, config,
+		"/keybase/private/alice/.trash/a/b/f.1500000000000000234", false)
+
+	t.Log("Don't restore over a new entry with the same name")
+	token, err = rmOne(ctx, config, "/keybase/private/alice/a/b/f", 0)
+	require.NoError(t, err)
+	p, err := fsrpc.ParsePath("/keybase/private/alice/a/b")
+	require.NoError(t, err)
+	b, err := p.GetDirNode(ctx, config)
+	require.NoError(t, err)
+	_, _, err = config.KBFSOps().CreateFile(ctx, b, "f", false, libkbfs.NoExcl)
+	require.NoError(t, err)
+	err = rmUndo(ctx, config, token.String())
+	require.IsType(t, libkbfs.NameExistsError{}, err)
+
+	t.Log("Tokens expire after their retention")
+	token, err = rmOne(
+		ctx, config, "/keybase/private/alice/a/b/g", time.Minute)
+	require.NoError(t, err)
+	require.True(t, now.Add(time.Minute).Equal(token.Expires))
+	clock.Add(2 * time.Minute)
+	require.Error(t, rmUndo(ctx, config, token.String()))
+	requireRmTestPath(ctx, t, config, "/keybase/private/alice/a/b/g", false)
+}
+
+func TestRmPurge(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	config := libkbfs.MakeTestConfigOrBust(t, "alice")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	clock := &libkbfs.TestClock{}
+	now := time.Unix(1500000000, 234)
+	clock.Set(now)
+	config.SetClock(clock)
+	makeRmTestTree(ctx, t, config)
+
+	t.Log("Remove a whole directory and a file")
+	exitStatus := rm(ctx, config, []string{"/keybase/private/alice/a/b"})
+	require.Equal(t, 0, exitStatus)
+	requireRmTestPath(ctx, t, config,
+		"/keybase/private/alice/.trash/a/b.1500000000000000234/f", true)
+	clock.Add(time.Hour)
+	_, _, err := config.KBFSOps().CreateFile(ctx,
+		libkbfs.GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private),
+		"h", false, libkbfs.NoExcl)
+	require.NoError(t, err)
+	exitStatus = rm(ctx, config, []string{"/keybase/private/alice/h"})
+	require.Equal(t, 0, exitStatus)
+	hTrashPath := "/keybase/private/alice/.trash/" +
+		libfs.TrashName("h", clock.Now())
+
+	t.Log("Nothing is purged within the retention")
+	exitStatus = rm(ctx, config, []string{
+		"-purge", "/keybase/private/alice"})
+	require.Equal(t, 0, exitStatus)
+	requireRmTestPath(ctx, t, config,
+		"/keybase/private/alice/.trash/a/b.1500000000000000234", true)
+	requireRmTestPath(ctx, t, config, hTrashPath, true)
+
+	t.Log("Only the entries past the retention are purged, along " +
+		"with the directories left empty")
+	clock.Set(now.Add(libkbfs.DefaultTrashRetention + time.Minute))
+	exitStatus = rm(ctx, config, []string{
+		"-purge", "/keybase/private/alice/a"})
+	require.Equal(t, 0, exitStatus)
+	requireRmTestPath(ctx, t, config, "/keybase/private/alice/.trash/a", false)
+	requireRmTestPath(ctx, t, config, hTrashPath, true)
+
+	t.Log("-purge can't be combined with -now")
+	exitStatus = rm(ctx, config, []string{
+		"-purge", "-now", "/keybase/private/alice"})
+	require.Equal(t, 1, exitStatus)
+}
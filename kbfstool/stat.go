@@ -15,7 +15,7 @@ import (
 )
 
 func statNode(ctx context.Context, config libkbfs.Config, nodePathStr string) error {
-	p, err := fsrpc.NewPath(nodePathStr)
+	p, err := fsrpc.ParsePath(nodePathStr)
 	if err != nil {
 		return err
 	}
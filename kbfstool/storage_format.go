@@ -0,0 +1,114 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+const storageFormatUsageStr = `Usage:
+  kbfstool storage-format doc [-json]
+  kbfstool storage-format validate journal|cache <dir>
+
+doc prints a description of the on-disk formats of the journal and
+the disk block caches, generated from the code.
+
+validate checks a journal directory (kbfs_journal under the storage
+root) or a disk block cache directory (kbfs_block_cache or
+kbfs_sync_cache) against its format, and lists every problem it
+finds.  KBFS must not be using the directory, so validate a copy, or
+stop KBFS first.
+`
+
+func storageFormatDoc(args []string) (exitStatus int) {
+	flags := flag.NewFlagSet("kbfs storage-format doc", flag.ContinueOnError)
+	asJSON := flags.Bool("json", false, "Print the formats as JSON")
+	err := flags.Parse(args)
+	if err != nil {
+		printError("storage-format doc", err)
+		return 1
+	}
+	if len(flags.Args()) != 0 {
+		fmt.Print(storageFormatUsageStr)
+		return 1
+	}
+
+	formats := libkbfs.StorageFormats()
+	if *asJSON {
+		buf, err := json.MarshalIndent(formats, "", "  ")
+		if err != nil {
+			printError("storage-format doc", err)
+			return 1
+		}
+		fmt.Printf("%s\n", buf)
+		return 0
+	}
+	err = libkbfs.WriteStorageFormatDoc(os.Stdout, formats)
+	if err != nil {
+		printError("storage-format doc", err)
+		return 1
+	}
+	return 0
+}
+
+func storageFormatValidate(args []string) (exitStatus int) {
+	if len(args) != 2 {
+		fmt.Print(storageFormatUsageStr)
+		return 1
+	}
+
+	codec := kbfscodec.NewMsgpack()
+	var problems []libkbfs.StorageProblem
+	var err error
+	switch args[0] {
+	case "journal":
+		problems, err = libkbfs.ValidateJournalDir(codec, args[1])
+	case "cache":
+		problems, err = libkbfs.ValidateDiskCacheDir(codec, args[1])
+	default:
+		printError("storage-format validate",
+			fmt.Errorf("unknown directory kind %q", args[0]))
+		return 1
+	}
+	if err != nil {
+		printError("storage-format validate", err)
+		return 1
+	}
+
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(os.Stderr, "%d problem(s) found\n", len(problems))
+		return 1
+	}
+	return 0
+}
+
+func storageFormatMain(args []string) (exitStatus int) {
+	if len(args) < 1 {
+		fmt.Print(storageFormatUsageStr)
+		return 1
+	}
+
+	cmd := args[0]
+	args = args[1:]
+
+	switch cmd {
+	case "doc":
+		return storageFormatDoc(args)
+	case "validate":
+		return storageFormatValidate(args)
+	default:
+		printError("storage-format", fmt.Errorf("unknown command %q", cmd))
+		return 1
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+const topUsageStr = `Usage:
+  kbfstool top [flags] [<mounted dir>]
+
+Shows what the KBFS daemon behind the local mount (by default, the
+one the current directory is in) is doing, refreshed until
+interrupted: read and write throughput and journal backlog per
+folder, block cache hit rates, and the operations that are running.
+
+UPLOADED is the encrypted block and metadata data put to the servers
+for a folder, and AMP is its write amplification: UPLOADED divided by
+WRITTEN.  Padding, metadata and blocks that are put again when files
+change in place all make it bigger than 1.
+
+`
+
+// clearScreen moves the cursor home and clears the terminal.
+const clearScreen = "\033[H\033[2J"
+
+func readTopStatus(dir string) (libkbfs.TopStatus, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, libfs.TopStatusFileName))
+	if err != nil {
+		return libkbfs.TopStatus{}, err
+	}
+	var status libkbfs.TopStatus
+	err = json.Unmarshal(data, &status)
+	if err != nil {
+		return libkbfs.TopStatus{}, err
+	}
+	return status, nil
+}
+
+// formatBytes formats `n` bytes with a binary unit prefix.
+func formatBytes(n float64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%.0fB", n)
+	}
+	i := -1
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f%ciB", n, units[i])
+}
+
+// formatHitRate formats the hits out of all lookups between two
+// samples.
+func formatHitRate(prev, cur libkbfs.TopCacheStatus) string {
+	hits := cur.Hits - prev.Hits
+	total := hits + cur.Misses - prev.Misses
+	if total <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(hits)/float64(total))
+}
+
+// formatAmplification formats a write amplification ratio, or "-" if
+// nothing was written.
+func formatAmplification(amp float64) string {
+	if amp == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2fx", amp)
+}
+
+type topTlfRow struct {
+	name                  string
+	readRate, writeRate   float64
+	unflushed, revisions  int64
+	totalRead, totalWrite int64
+	uploaded              int64
+	amplification         float64
+}
+
+// writeTop writes a screen of `cur`, with rates computed since
+// `prev`.
+func writeTop(w io.Writer, prev, cur libkbfs.TopStatus) error {
+	elapsed := cur.Time.Sub(prev.Time).Seconds()
+	prevTlfs := make(map[tlf.ID]libkbfs.TopTlfStatus, len(prev.Tlfs))
+	for _, ts := range prev.Tlfs {
+		prevTlfs[ts.Tlf] = ts
+	}
+	rows := make([]topTlfRow, 0, len(cur.Tlfs))
+	for _, ts := range cur.Tlfs {
+		row := topTlfRow{
+			name:          ts.Name,
+			unflushed:     ts.JournalUnflushedBytes,
+			revisions:     ts.JournalUnflushedRevisions,
+			totalRead:     ts.BytesRead,
+			totalWrite:    ts.BytesWritten,
+			uploaded:      ts.BlockBytesUploaded + ts.MDBytesUploaded,
+			amplification: ts.WriteAmplification(),
+		}
+		if row.name == "" {
+			row.name = ts.Tlf.String()
+		}
+		if elapsed > 0 {
+			p := prevTlfs[ts.Tlf]
+			row.readRate = float64(ts.BytesRead-p.BytesRead) / elapsed
+			row.writeRate = float64(ts.BytesWritten-p.BytesWritten) / elapsed
+		}
+		rows = append(rows, row)
+	}
+	// The busiest folders first.
+	sort.Slice(rows, func(i, j int) bool {
+		ri := rows[i].readRate + rows[i].writeRate
+		rj := rows[j].readRate + rows[j].writeRate
+		if ri != rj {
+			return ri > rj
+		}
+		if rows[i].unflushed != rows[j].unflushed {
+			return rows[i].unflushed > rows[j].unflushed
+		}
+		return rows[i].name < rows[j].name
+	})
+
+	fmt.Fprintf(w, "kbfs top - %s\n", cur.Time.Format("15:04:05"))
+	fmt.Fprintf(w, "Block cache hits: %s memory, %s disk\n\n",
+		formatHitRate(prev.BlockCache, cur.BlockCache),
+		formatHitRate(prev.DiskCache, cur.DiskCache))
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "READ/s\tWRITE/s\tREAD\tWRITTEN\tUPLOADED\tAMP\t"+
+		"UNFLUSHED\tREVS\t\tFOLDER")
+	for _, row := range rows {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t\t%s\n",
+			formatBytes(row.readRate), formatBytes(row.writeRate),
+			formatBytes(float64(row.totalRead)),
+			formatBytes(float64(row.totalWrite)),
+			formatBytes(float64(row.uploaded)),
+			formatAmplification(row.amplification),
+			formatBytes(float64(row.unflushed)), row.revisions, row.name)
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	names := make(map[tlf.ID]string, len(rows))
+	for _, ts := range cur.Tlfs {
+		names[ts.Tlf] = ts.Name
+	}
+	fmt.Fprintf(w, "\n%d active operations\n", len(cur.ActiveOps))
+	tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	if len(cur.ActiveOps) > 0 {
+		fmt.Fprintln(tw, "AGE\tOPERATION\tFOLDER")
+	}
+	for _, op := range cur.ActiveOps {
+		name := names[op.Tlf]
+		if name == "" {
+			name = op.Tlf.String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n",
+			cur.Time.Sub(op.Started).Round(time.Millisecond), op.Name, name)
+	}
+	return tw.Flush()
+}
+
+func topHelper(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("kbfs top", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, topUsageStr)
+		flags.PrintDefaults()
+	}
+	interval := flags.Duration("interval", 2*time.Second,
+		"How often to refresh.")
+	iterations := flags.Int("n", 0,
+		"Exit after this many refreshes; 0 means run until interrupted.")
+	batch := flags.Bool("batch", false,
+		"Print each refresh after the last, rather than redrawing the "+
+			"screen, e.g. for logging to a file.")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return errors.New("at most one directory may be specified")
+	}
+	if *interval <= 0 {
+		return errors.New("the interval must be positive")
+	}
+	dir := "."
+	if flags.NArg() == 1 {
+		dir = flags.Arg(0)
+	}
+
+	prev, err := readTopStatus(dir)
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for i := 0; *iterations == 0 || i < *iterations; i++ {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		cur, err := readTopStatus(dir)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if *batch {
+			buf.WriteString("\n")
+		} else {
+			buf.WriteString(clearScreen)
+		}
+		err = writeTop(&buf, prev, cur)
+		if err != nil {
+			return err
+		}
+		_, err = buf.WriteTo(os.Stdout)
+		if err != nil {
+			return err
+		}
+		prev = cur
+	}
+	return nil
+}
+
+func top(
+	ctx context.Context, config libkbfs.Config, args []string) (exitStatus int) {
+	err := topHelper(ctx, args)
+	if err != nil {
+		printError("top", err)
+		exitStatus = 1
+	}
+	return
+}
@@ -60,7 +60,7 @@ func writeHelper(ctx context.Context, config libkbfs.Config, args []string) (err
 		}
 	}()
 
-	p, err := fsrpc.NewPath(filePathStr)
+	p, err := fsrpc.ParsePath(filePathStr)
 	if err != nil {
 		return
 	}
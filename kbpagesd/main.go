@@ -30,6 +30,8 @@ var (
 	fStathatEZKey  string
 	fStathatPrefix string
 	fBlacklist     string
+
+	fFolderLimits libkbfs.FolderLimits
 )
 
 func init() {
@@ -46,6 +48,13 @@ func init() {
 	// whitelist should be dynamically configurable.
 	flag.StringVar(&fBlacklist, "blacklist", "",
 		"a comma-separated list of domains to block")
+	flag.Float64Var(&fFolderLimits.OpsPerSecond, "folder-ops-per-second", 0,
+		"maximum rate of KBFS operations on each site's folder; 0 is unlimited")
+	flag.Int64Var(&fFolderLimits.BytesPerSecond, "folder-bytes-per-second", 0,
+		"maximum rate of bytes read from each site's folder; 0 is unlimited")
+	flag.IntVar(&fFolderLimits.MaxConcurrentOps, "folder-max-concurrent-ops", 0,
+		"maximum number of concurrent KBFS operations on each site's folder; "+
+			"0 is unlimited")
 }
 
 func newLogger(isCLI bool) (*zap.Logger, error) {
@@ -119,8 +128,10 @@ func main() {
 	params := libkbfs.DefaultInitParams(kbCtx)
 	params.EnableJournal = true
 	params.Debug = true
+	params.ClientID = "kbpagesd"
 	params.LogFileConfig.Path = fKBFSLogFile
 	params.LogFileConfig.MaxKeepFiles = 32
+	params.FolderLimits = fFolderLimits
 	// Enable simpleFS in case we need to debug.
 	shutdownGit := func() {}
 	createSimpleFS := func(
@@ -0,0 +1,35 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdokan
+
+import (
+	"strings"
+
+	"github.com/keybase/kbfs/dokan"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// AcknowledgeBrokenProofsFile represents a write-only file where
+// writing a username acknowledges that user's broken proofs, for an
+// identify policy that denies access to their folders until then.
+type AcknowledgeBrokenProofsFile struct {
+	fs *FS
+	specialWriteFile
+}
+
+// WriteFile implements writes for dokan.
+func (f *AcknowledgeBrokenProofsFile) WriteFile(ctx context.Context,
+	fi *dokan.FileInfo, bs []byte, offset int64) (n int, err error) {
+	f.fs.logEnter(ctx, "AcknowledgeBrokenProofsFile Write")
+	defer func() { f.fs.reportErr(ctx, libkbfs.WriteMode, err) }()
+	for _, username := range strings.Fields(string(bs)) {
+		err := libkbfs.AcknowledgeBrokenProofs(ctx, f.fs.config, username)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(bs), nil
+}
@@ -43,6 +43,10 @@ type Folder struct {
 	// FUSE Forget request.
 	nodes map[libkbfs.NodeID]dokan.File
 
+	// fileFlags caches the default flags of the files in this
+	// folder.
+	fileFlags libfs.FileFlagsCache
+
 	// Protects the updateChan.
 	updateMu sync.Mutex
 	// updateChan is non-nil when the user disables updates via the
@@ -109,6 +113,18 @@ func (f *Folder) getFolderBranch() libkbfs.FolderBranch {
 	return f.folderBranch
 }
 
+// getFileFlags returns the default flags that apply to the file
+// named `name` in this folder.
+func (f *Folder) getFileFlags(
+	ctx context.Context, name string) libkbfs.FileFlags {
+	f.handleMu.RLock()
+	h := f.h
+	f.handleMu.RUnlock()
+	flags := f.fileFlags.Get(
+		ctx, f.fs.config, h, f.getFolderBranch().Branch)
+	return flags.ForFile(name)
+}
+
 // forgetNode forgets a formerly active child with basename name.
 func (f *Folder) forgetNode(ctx context.Context, node libkbfs.Node) {
 	f.mu.Lock()
@@ -422,12 +438,14 @@ func openSymlink(ctx context.Context, oc *openContext, parent *Dir, rootDir *Dir
 	}
 	// reference symlink, symbolic links always use '/' instead of '\'.
 	if target == "" || target[0] == '/' {
+		parent.folder.fs.warnSymlinkEscape(ctx, origPath, target)
 		return nil, 0, dokan.ErrNotSupported
 	}
 
 	dst, err := resolveSymlinkPath(ctx, origPath, target)
 	parent.folder.fs.log.CDebugf(ctx, "openSymlink resolve returned %v,%v => %v,%v", origPath, target, dst, err)
 	if err != nil {
+		parent.folder.fs.warnSymlinkEscape(ctx, origPath, target)
 		return nil, 0, err
 	}
 	dst = append(dst, path[1:]...)
@@ -442,7 +460,9 @@ func (d *Dir) create(ctx context.Context, oc *openContext, name string) (f dokan
 	d.folder.fs.log.CDebugf(ctx, "Dir Create %s", name)
 	defer func() { d.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
 
-	isExec := false // Windows lacks executable modes.
+	// Windows lacks executable modes, but folders of scripts
+	// should keep working on other platforms.
+	isExec := d.folder.getFileFlags(ctx, name).Exec
 	excl := getExclFromOpenContext(oc)
 	newNode, _, err := d.folder.fs.config.KBFSOps().CreateFile(
 		ctx, d.node, name, isExec, excl)
@@ -26,12 +26,21 @@ func newFile(folder *Folder, node libkbfs.Node, name string, parent libkbfs.Node
 	return f
 }
 
+// isReadOnly returns whether the folder's file flags make this file
+// read-only.
+func (f *File) isReadOnly(ctx context.Context) bool {
+	return f.folder.getFileFlags(ctx, f.node.GetBasename()).ReadOnly
+}
+
 // GetFileInformation for dokan.
 func (f *File) GetFileInformation(ctx context.Context, fi *dokan.FileInfo) (a *dokan.Stat, err error) {
 	f.folder.fs.logEnter(ctx, "File GetFileInformation")
 	defer func() { f.folder.reportErr(ctx, libkbfs.ReadMode, err) }()
 
 	a, err = eiToStat(f.folder.fs.config.KBFSOps().Stat(ctx, f.node))
+	if a != nil && f.isReadOnly(ctx) {
+		addFileAttribute(a, dokan.FileAttributeReadonly)
+	}
 	if a != nil {
 		f.folder.fs.log.CDebugf(ctx, "File GetFileInformation node=%v => %v", f.node, *a)
 	} else {
@@ -113,6 +122,9 @@ func (f *File) SetEndOfFile(ctx context.Context, fi *dokan.FileInfo, length int6
 	f.folder.fs.logEnter(ctx, "File SetEndOfFile")
 	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
 
+	if f.isReadOnly(ctx) {
+		return dokan.ErrAccessDenied
+	}
 	return f.folder.fs.config.KBFSOps().Truncate(ctx, f.node, uint64(length))
 }
 
@@ -123,6 +135,9 @@ func (f *File) SetAllocationSize(ctx context.Context, fi *dokan.FileInfo, newSiz
 	f.folder.fs.logEnter(ctx, "File SetAllocationSize")
 	defer func() { f.folder.reportErr(ctx, libkbfs.WriteMode, err) }()
 
+	if f.isReadOnly(ctx) {
+		return dokan.ErrAccessDenied
+	}
 	ei, err := f.folder.fs.config.KBFSOps().Stat(ctx, f.node)
 	if err != nil {
 		return err
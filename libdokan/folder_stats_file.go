@@ -0,0 +1,24 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdokan
+
+import (
+	"time"
+
+	"github.com/keybase/kbfs/libfs"
+	"golang.org/x/net/context"
+)
+
+// NewFolderStatsFile returns a special read file that reports the
+// aggregate stats of the current TLF.
+func NewFolderStatsFile(folder *Folder) *SpecialReadFile {
+	return &SpecialReadFile{
+		read: func(ctx context.Context) ([]byte, time.Time, error) {
+			return libfs.GetEncodedFolderStats(
+				ctx, folder.fs.config, folder.getFolderBranch())
+		},
+		fs: folder.fs,
+	}
+}
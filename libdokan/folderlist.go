@@ -164,6 +164,13 @@ func (fl *FolderList) open(ctx context.Context, oc *openContext, path []string)
 			return nil, 0, err
 		}
 
+		if !fl.fs.namespaceFilter.AllowsFolder(
+			fl.tlfType, h.GetCanonicalName()) {
+			fl.fs.log.CDebugf(ctx, "FL Lookup hiding filtered folder %q",
+				h.GetCanonicalName())
+			return nil, 0, dokan.ErrObjectNameNotFound
+		}
+
 		fl.fs.log.CDebugf(ctx, "FL Lookup adding new child")
 		session, err := libkbfs.GetCurrentSessionIfPossible(ctx, fl.fs.config.KBPKI(), h.Type() == tlf.Public)
 		if err != nil {
@@ -204,6 +211,10 @@ func (fl *FolderList) FindFiles(ctx context.Context, fi *dokan.FileInfo, ignored
 		if fav.Type != fl.tlfType {
 			continue
 		}
+		if !fl.fs.namespaceFilter.AllowsFolder(
+			fav.Type, tlf.CanonicalName(fav.Name)) {
+			continue
+		}
 		pname, err := tlf.CanonicalToPreferredName(session.Name,
 			tlf.CanonicalName(fav.Name))
 		if err != nil {
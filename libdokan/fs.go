@@ -37,6 +37,15 @@ type FS struct {
 	remoteStatus libfs.RemoteStatus
 
 	quotaUsage *libkbfs.EventuallyConsistentQuotaUsage
+
+	// symlinkEscapePolicy says how to handle symlinks that point
+	// outside of their folder.  Dokan resolves symlinks itself,
+	// and can never follow those, so only the warning applies.
+	symlinkEscapePolicy libfs.SymlinkEscapePolicy
+
+	// namespaceFilter limits which namespaces and folders the
+	// mount exposes.
+	namespaceFilter libfs.NamespaceFilter
 }
 
 // DefaultMountFlags are the default mount flags for libdokan.
@@ -304,6 +313,8 @@ func (f *FS) open(ctx context.Context, oc *openContext, ps []string) (dokan.File
 		return nil, 0, dokan.ErrObjectNameNotFound
 	case psl == 1 && ps[0] == ``:
 		return oc.returnDirNoCleanup(f.root)
+	case f.namespaceFilter.HidesSpecialFile(ps[0]):
+		return nil, 0, dokan.ErrObjectNameNotFound
 
 		// This section is equivalent to
 		// handleCommonSpecialFile in libfuse.
@@ -325,6 +336,8 @@ func (f *FS) open(ctx context.Context, oc *openContext, ps []string) (dokan.File
 		// non-TLF directory.
 	case libfs.StatusFileName == ps[0]:
 		return oc.returnFileNoCleanup(NewNonTLFStatusFile(f.root.private.fs))
+	case libfs.StatusDirName == ps[0]:
+		return (StatusDir{fs: f}).open(ctx, oc, ps[1:])
 	case libfs.HumanErrorFileName == ps[0], libfs.HumanNoLoginFileName == ps[0]:
 		return oc.returnFileNoCleanup(&SpecialReadFile{
 			read: f.remoteStatus.NewSpecialReadFunc,
@@ -340,6 +353,10 @@ func (f *FS) open(ctx context.Context, oc *openContext, ps []string) (dokan.File
 			folder: &Folder{fs: f}, // fake Folder for logging, etc.
 			action: libfs.JournalDisableAuto,
 		})
+	case libfs.AcknowledgeBrokenProofsFileName == ps[0]:
+		return oc.returnFileNoCleanup(&AcknowledgeBrokenProofsFile{
+			fs: f.root.private.fs,
+		})
 	case libfs.EnableBlockPrefetchingFileName == ps[0]:
 		return oc.returnFileNoCleanup(&PrefetchFile{
 			fs:     f,
@@ -366,6 +383,9 @@ func (f *FS) open(ctx context.Context, oc *openContext, ps []string) (dokan.File
 		oc.isUppercasePath = true
 		fallthrough
 	case PublicName == ps[0]:
+		if !f.namespaceFilter.AllowsNamespace(tlf.Public) {
+			return nil, 0, dokan.ErrObjectNameNotFound
+		}
 		// Refuse private directories while we are in a a generic error state.
 		if f.remoteStatus.ExtraFileName() == libfs.HumanErrorFileName {
 			f.log.CWarningf(ctx, "Refusing access to public directory while errors are present!")
@@ -376,6 +396,9 @@ func (f *FS) open(ctx context.Context, oc *openContext, ps []string) (dokan.File
 		oc.isUppercasePath = true
 		fallthrough
 	case PrivateName == ps[0]:
+		if !f.namespaceFilter.AllowsNamespace(tlf.Private) {
+			return nil, 0, dokan.ErrObjectNameNotFound
+		}
 		// Refuse private directories while we are in a error state.
 		if f.remoteStatus.ExtraFileName() != "" {
 			f.log.CWarningf(ctx, "Refusing access to private directory while errors are present!")
@@ -386,6 +409,9 @@ func (f *FS) open(ctx context.Context, oc *openContext, ps []string) (dokan.File
 		oc.isUppercasePath = true
 		fallthrough
 	case TeamName == ps[0]:
+		if !f.namespaceFilter.AllowsNamespace(tlf.SingleTeam) {
+			return nil, 0, dokan.ErrObjectNameNotFound
+		}
 		// Refuse team directories while we are in a error state.
 		if f.remoteStatus.ExtraFileName() != "" {
 			f.log.CWarningf(ctx, "Refusing access to team directory while errors are present!")
@@ -662,24 +688,31 @@ func (r *Root) FindFiles(ctx context.Context, fi *dokan.FileInfo, ignored string
 	var err error
 	ns.FileAttributes = dokan.FileAttributeDirectory
 	ename, esize := r.private.fs.remoteStatus.ExtraFileNameAndSize()
+	filter := r.private.fs.namespaceFilter
 	switch ename {
 	case "":
-		ns.Name = PrivateName
-		err = callback(&ns)
-		if err != nil {
-			return err
+		if filter.AllowsNamespace(tlf.Private) {
+			ns.Name = PrivateName
+			err = callback(&ns)
+			if err != nil {
+				return err
+			}
 		}
-		ns.Name = TeamName
-		err = callback(&ns)
-		if err != nil {
-			return err
+		if filter.AllowsNamespace(tlf.SingleTeam) {
+			ns.Name = TeamName
+			err = callback(&ns)
+			if err != nil {
+				return err
+			}
 		}
 		fallthrough
 	case libfs.HumanNoLoginFileName:
-		ns.Name = PublicName
-		err = callback(&ns)
-		if err != nil {
-			return err
+		if filter.AllowsNamespace(tlf.Public) {
+			ns.Name = PublicName
+			err = callback(&ns)
+			if err != nil {
+				return err
+			}
 		}
 	}
 	if ename != "" {
@@ -20,6 +20,24 @@ func handleTLFSpecialFile(name string, folder *Folder) dokan.File {
 	case libfs.UpdateHistoryFileName:
 		return NewUpdateHistoryFile(folder)
 
+	case libfs.LegalHoldFileName:
+		return NewLegalHoldFile(folder)
+
+	case libfs.SyncCacheReportFileName:
+		return NewSyncCacheReportFile(folder)
+
+	case libfs.RecentFilesFileName:
+		return NewRecentFilesFile(folder)
+
+	case libfs.FolderStatsFileName:
+		return NewFolderStatsFile(folder)
+
+	case libfs.ExpiryReportFileName:
+		return NewExpiryReportFile(folder)
+
+	case libfs.TlfSettingsReportFileName:
+		return NewTlfSettingsReportFile(folder)
+
 	case libfs.EditHistoryName:
 		return NewTlfEditHistoryFile(folder)
 
@@ -78,6 +96,12 @@ func handleTLFSpecialFile(name string, folder *Folder) dokan.File {
 			action: libfs.JournalResumeBackgroundWork,
 		}
 
+	case libfs.ReleaseJournalQuarantineFileName:
+		return &JournalControlFile{
+			folder: folder,
+			action: libfs.JournalReleaseQuarantine,
+		}
+
 	case libfs.DisableJournalFileName:
 		return &JournalControlFile{
 			folder: folder,
@@ -95,6 +119,24 @@ func handleTLFSpecialFile(name string, folder *Folder) dokan.File {
 			folder: folder,
 			action: libfs.SyncDisable,
 		}
+
+	case libfs.RepairSyncFileName:
+		return &SyncControlFile{
+			folder: folder,
+			action: libfs.SyncRepair,
+		}
+
+	case libfs.EnableWriteThroughFileName:
+		return &SyncControlFile{
+			folder: folder,
+			action: libfs.SyncEnableWriteThrough,
+		}
+
+	case libfs.DisableWriteThroughFileName:
+		return &SyncControlFile{
+			folder: folder,
+			action: libfs.SyncDisableWriteThrough,
+		}
 	}
 
 	return nil
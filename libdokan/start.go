@@ -30,6 +30,12 @@ type StartOptions struct {
 	ForceMount  bool
 	SkipMount   bool
 	MountPoint  string
+	// SymlinkEscapePolicy says how to handle symlinks that point
+	// outside of their folder.
+	SymlinkEscapePolicy libfs.SymlinkEscapePolicy
+	// NamespaceFilter limits which namespaces and folders the
+	// mount exposes.
+	NamespaceFilter libfs.NamespaceFilter
 }
 
 func startMounting(options StartOptions,
@@ -121,6 +127,8 @@ func Start(options StartOptions, kbCtx libkbfs.Context) *libfs.Error {
 		if err != nil {
 			return libfs.InitError(err.Error())
 		}
+		fs.symlinkEscapePolicy = options.SymlinkEscapePolicy
+		fs.namespaceFilter = options.NamespaceFilter
 		options.DokanConfig.FileSystem = fs
 
 		if newFolderNameErr != nil {
@@ -0,0 +1,58 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdokan
+
+import (
+	"github.com/keybase/kbfs/dokan"
+	"github.com/keybase/kbfs/libfs"
+	"golang.org/x/net/context"
+)
+
+// StatusDir is a node for a directory within the status directory,
+// which lists status files as described in libfs.
+type StatusDir struct {
+	fs   *FS
+	path []string
+	emptyFile
+}
+
+// GetFileInformation for dokan.
+func (StatusDir) GetFileInformation(ctx context.Context, fi *dokan.FileInfo) (st *dokan.Stat, err error) {
+	return defaultDirectoryInformation()
+}
+
+// open tries to open a file.
+func (sd StatusDir) open(ctx context.Context, oc *openContext, path []string) (dokan.File, dokan.CreateStatus, error) {
+	fullPath := append(append([]string(nil), sd.path...), path...)
+	if _, ok := libfs.StatusDirChildren(ctx, sd.fs.config, fullPath); ok {
+		return oc.returnDirNoCleanup(StatusDir{fs: sd.fs, path: fullPath})
+	}
+	f := libfs.StatusDirFileGet(ctx, sd.fs.config, fullPath)
+	if f == nil {
+		return nil, 0, dokan.ErrObjectNameNotFound
+	}
+	return oc.returnFileNoCleanup(&SpecialReadFile{read: f, fs: sd.fs})
+}
+
+// FindFiles does readdir for dokan.
+func (sd StatusDir) FindFiles(ctx context.Context, fi *dokan.FileInfo, ignored string, callback func(*dokan.NamedStat) error) (err error) {
+	children, ok := libfs.StatusDirChildren(ctx, sd.fs.config, sd.path)
+	if !ok {
+		return dokan.ErrObjectNameNotFound
+	}
+	var ns dokan.NamedStat
+	for name, isDir := range children {
+		ns.Name = name
+		ns.FileAttributes = dokan.FileAttributeReadonly
+		if isDir {
+			ns.FileAttributes = dokan.FileAttributeDirectory
+		}
+		err := callback(&ns)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -5,7 +5,10 @@
 package libdokan
 
 import (
+	"strings"
+
 	"github.com/keybase/kbfs/dokan"
+	"github.com/keybase/kbfs/libfs"
 	"github.com/keybase/kbfs/libkbfs"
 	"golang.org/x/net/context"
 )
@@ -40,3 +43,15 @@ func (s *Symlink) GetFileInformation(ctx context.Context, fi *dokan.FileInfo) (a
 	}
 	return defaultSymlinkFileInformation()
 }
+
+// warnSymlinkEscape logs that the symlink at `linkPath` wasn't
+// followed to `target`, outside of its folder, if the mount's policy
+// asks for warnings.
+func (f *FS) warnSymlinkEscape(
+	ctx context.Context, linkPath []string, target string) {
+	if f.symlinkEscapePolicy != libfs.SymlinkEscapeWarn {
+		return
+	}
+	f.log.CWarningf(ctx, "Symlink %s points outside of its folder, to %s",
+		strings.Join(linkPath, `\`), target)
+}
@@ -0,0 +1,24 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libdokan
+
+import (
+	"time"
+
+	"github.com/keybase/kbfs/libfs"
+	"golang.org/x/net/context"
+)
+
+// NewTlfSettingsReportFile returns a special read file that reports
+// the settings in effect in the current TLF.
+func NewTlfSettingsReportFile(folder *Folder) *SpecialReadFile {
+	return &SpecialReadFile{
+		read: func(ctx context.Context) ([]byte, time.Time, error) {
+			return libfs.GetEncodedTlfSettingsReport(
+				ctx, folder.fs.config, folder.getFolderBranch(), folder.h)
+		},
+		fs: folder.fs,
+	}
+}
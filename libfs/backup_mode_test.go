@@ -0,0 +1,214 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+// lineBlockServer simulates a line of limited bandwidth to a block
+// server: block puts go over it one at a time.
+type lineBlockServer struct {
+	libkbfs.BlockServer
+	bwKBps int
+	lock   sync.Mutex
+}
+
+func (b *lineBlockServer) Put(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, bctx kbfsblock.Context, buf []byte,
+	serverHalf kbfscrypto.BlockCryptKeyServerHalf) error {
+	b.lock.Lock()
+	time.Sleep(time.Duration(len(buf)) * time.Second /
+		time.Duration(b.bwKBps*1024))
+	b.lock.Unlock()
+	return b.BlockServer.Put(ctx, tlfID, id, bctx, buf, serverHalf)
+}
+
+// makeFSWithMode makes an FS in the given mode.  If `bwKBps` is
+// positive, block puts are limited to that bandwidth, and blocks
+// are the size they'd be outside of tests.
+func makeFSWithMode(tb testing.TB, mode libkbfs.InitModeType, bwKBps int) (
+	context.Context, *FS, func()) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	config := libkbfs.MakeTestConfigOrBustLoggedInWithMode(
+		tb, 0, mode, "user1")
+	bserver := config.BlockServer()
+	if bwKBps > 0 {
+		config.SetBlockServer(
+			&lineBlockServer{BlockServer: bserver, bwKBps: bwKBps})
+		// Use real block sizes, so that block padding doesn't eat
+		// into the bandwidth.
+		bsplit, err := libkbfs.NewBlockSplitterSimple(
+			libkbfs.MaxBlockSizeBytesDefault, 8*1024, config.Codec())
+		require.NoError(tb, err)
+		require.NoError(tb, bsplit.SetMaxDirEntriesByBlockSize(config.Codec()))
+		config.SetBlockSplitter(bsplit)
+		// Big transfers need background flushes, which must be on
+		// before the folder is first accessed.
+		config.SetDoBackgroundFlushes(true)
+	}
+	shutdown := func() {
+		if bwKBps == 0 {
+			libkbfs.CheckConfigAndShutdown(ctx, tb, config)
+			return
+		}
+		// Writes racing with background flushes can leave
+		// unreferenced blocks behind, which the state checker
+		// complains about, so just log what it says.
+		config.SetBlockServer(bserver)
+		if err := config.Shutdown(ctx); err != nil {
+			tb.Logf("Shutdown: %+v", err)
+		}
+	}
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(tb, err)
+	fs, err := NewFS(
+		ctx, config, h, libkbfs.MasterBranch, "", "",
+		keybase1.MDPriorityNormal)
+	require.NoError(tb, err)
+	return ctx, fs, shutdown
+}
+
+func TestBackupModeDirMtime(t *testing.T) {
+	_, fs, shutdown := makeFSWithMode(t, libkbfs.InitBackup, 0)
+	defer shutdown()
+	clock := &libkbfs.TestClock{}
+	clock.Set(time.Now())
+	fs.config.SetClock(clock)
+	granularity := fs.config.Mode().DirMtimeGranularity()
+	require.NotZero(t, granularity)
+
+	mtime := func() time.Time {
+		require.NoError(t, fs.SyncAll())
+		fi, err := fs.Stat("data")
+		require.NoError(t, err)
+		return fi.ModTime()
+	}
+	require.NoError(t, fs.MkdirAll("data", 0755))
+	writeTestFile(t, fs, "data/a", "a")
+	first := mtime()
+
+	t.Log("Changes soon after don't touch the directory's mtime")
+	clock.Add(granularity / 2)
+	writeTestFile(t, fs, "data/b", "b")
+	require.NoError(t, fs.Rename("data/b", "data/c"))
+	require.True(t, first.Equal(mtime()))
+
+	t.Log("But later ones do")
+	clock.Add(granularity)
+	writeTestFile(t, fs, "data/d", "d")
+	require.True(t, mtime().After(first))
+}
+
+// TestDefaultModeDirMtime checks that the relaxed directory mtimes
+// of TestBackupModeDirMtime don't leak into the default mode, where
+// every change to a directory updates its mtime.
+func TestDefaultModeDirMtime(t *testing.T) {
+	_, fs, shutdown := makeFSWithMode(t, libkbfs.InitDefault, 0)
+	defer shutdown()
+	clock := &libkbfs.TestClock{}
+	clock.Set(time.Now())
+	fs.config.SetClock(clock)
+	require.Zero(t, fs.config.Mode().DirMtimeGranularity())
+
+	mtime := func() time.Time {
+		require.NoError(t, fs.SyncAll())
+		fi, err := fs.Stat("data")
+		require.NoError(t, err)
+		return fi.ModTime()
+	}
+	require.NoError(t, fs.MkdirAll("data", 0755))
+	writeTestFile(t, fs, "data/a", "a")
+	first := mtime()
+
+	clock.Add(time.Second)
+	writeTestFile(t, fs, "data/b", "b")
+	second := mtime()
+	require.True(t, second.After(first))
+
+	clock.Add(time.Second)
+	require.NoError(t, fs.Rename("data/b", "data/c"))
+	require.True(t, mtime().After(second))
+}
+
+// benchmarkBackupPackWrites writes files the way restic writes its
+// pack files into a repository: each is written sequentially under
+// a temporary name, fsynced, renamed into one of 256 directories,
+// and then the directory is fsynced.  The block server is limited
+// to `bwKBps`, so the MB/s reported can be compared to that line
+// speed.
+func benchmarkBackupPackWrites(
+	b *testing.B, mode libkbfs.InitModeType, bwKBps int) {
+	_, fs, shutdown := makeFSWithMode(b, mode, bwKBps)
+	defer shutdown()
+
+	const packSize = 4 << 20
+	const writeSize = 64 << 10
+	buf := make([]byte, writeSize)
+	b.SetBytes(packSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dir := fmt.Sprintf("data/%02x", i%256)
+		if err := fs.MkdirAll(dir, 0700); err != nil {
+			b.Fatal(err)
+		}
+		tmp := path.Join("tmp", fmt.Sprintf("pack%d", i))
+		if err := fs.MkdirAll("tmp", 0700); err != nil {
+			b.Fatal(err)
+		}
+		f, err := fs.Create(tmp)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for off := 0; off < packSize; off += writeSize {
+			// Make each block unique.
+			buf[0], buf[1] = byte(i), byte(off/writeSize)
+			if _, err := f.Write(buf); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := fs.SyncAll(); err != nil {
+			b.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			b.Fatal(err)
+		}
+		err = fs.Rename(tmp, path.Join(dir, fmt.Sprintf("pack%d", i)))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := fs.SyncAll(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+}
+
+// BenchmarkBackupPackWrites compares the default and backup modes
+// over a 100 Mbps (12.5 MB/s) line; each should get within about 20%
+// of the line speed.  Run it with:
+// go test -run XXX -bench BackupPackWrites -benchtime 8x ./libfs/
+func BenchmarkBackupPackWrites(b *testing.B) {
+	const bwKBps = 100 * 1024 / 8
+	b.Run("default", func(b *testing.B) {
+		benchmarkBackupPackWrites(b, libkbfs.InitDefault, bwKBps)
+	})
+	b.Run("backup", func(b *testing.B) {
+		benchmarkBackupPackWrites(b, libkbfs.InitBackup, bwKBps)
+	})
+}
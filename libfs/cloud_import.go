@@ -0,0 +1,332 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+)
+
+const (
+	// CloudExportManifestName is the name of the manifest at the top
+	// of a cloud export, which is never imported itself.
+	CloudExportManifestName = "kbfs_manifest.json"
+
+	// cloudShareTeamMinMembers is the smallest number of members
+	// of a shared folder for which a team is suggested, rather than
+	// a folder shared between the members directly.
+	cloudShareTeamMinMembers = 5
+	// cloudShareTeamNameMaxLen is the longest team name Keybase
+	// allows.
+	cloudShareTeamNameMaxLen = 16
+)
+
+// CloudExportManifest describes a local export of a Dropbox or Google
+// Drive account, as produced by an export tool: the metadata that
+// the files themselves don't carry.
+type CloudExportManifest struct {
+	// Source names the service the export came from, e.g. "dropbox"
+	// or "drive".  It's only informational.
+	Source string `json:"source"`
+	// Entries have the original timestamps of the exported files
+	// and directories.  Entries without one keep their local
+	// modification time.
+	Entries []CloudExportEntry `json:"entries"`
+	// SharedFolders lists the exported directories that were
+	// shared with other accounts.
+	SharedFolders []CloudExportSharedFolder `json:"shared_folders"`
+}
+
+// CloudExportEntry is the metadata of one file or directory of a
+// cloud export.
+type CloudExportEntry struct {
+	// Path is relative to the top of the export, with slashes.
+	Path     string    `json:"path"`
+	Modified time.Time `json:"modified"`
+}
+
+// CloudExportSharedFolder is a directory of a cloud export that was
+// shared with other accounts.
+type CloudExportSharedFolder struct {
+	Path    string              `json:"path"`
+	Members []CloudExportMember `json:"members"`
+}
+
+// CloudExportMember is an account a folder was shared with.
+type CloudExportMember struct {
+	// Account is an email address, a Keybase username, or a Keybase
+	// social assertion like "alice@twitter".
+	Account string `json:"account"`
+	// Role is "owner" or "editor" for members who could write to
+	// the folder, and anything else (e.g. "viewer") for those who
+	// could only read it.
+	Role string `json:"role"`
+}
+
+// ReadCloudExportManifest decodes a manifest written as JSON.
+func ReadCloudExportManifest(r io.Reader) (CloudExportManifest, error) {
+	var m CloudExportManifest
+	err := json.NewDecoder(r).Decode(&m)
+	if err != nil {
+		return CloudExportManifest{}, errors.WithStack(err)
+	}
+	return m, nil
+}
+
+// CloudImportStats counts what ImportCloudExport did.
+type CloudImportStats struct {
+	Files int
+	Dirs  int
+	Bytes int64
+}
+
+// ImportCloudExport copies the files and directories of the local
+// cloud export `src` into `dest` in `fs`, giving them the timestamps
+// in the manifest, or else their local ones.  Existing files are
+// overwritten.  The sharing lists in the manifest aren't applied;
+// see SuggestCloudShares.
+func ImportCloudExport(ctx context.Context, fs *FS, src, dest string,
+	manifest CloudExportManifest) (stats CloudImportStats, err error) {
+	modified := make(map[string]time.Time, len(manifest.Entries))
+	for _, e := range manifest.Entries {
+		modified[path.Clean(e.Path)] = e.Modified
+	}
+	imp := &cloudImporter{
+		fs:       fs,
+		src:      src,
+		dest:     path.Clean(dest),
+		modified: modified,
+	}
+	if err := fs.MkdirAll(imp.dest, 0755); err != nil {
+		return CloudImportStats{}, err
+	}
+	if err := imp.importDir(ctx, ""); err != nil {
+		return imp.stats, err
+	}
+	return imp.stats, fs.SyncAll()
+}
+
+type cloudImporter struct {
+	fs       *FS
+	src      string
+	dest     string
+	modified map[string]time.Time
+	stats    CloudImportStats
+}
+
+// mtime returns the time to give the entry at `rel`.
+func (imp *cloudImporter) mtime(rel string, fi os.FileInfo) time.Time {
+	if t, ok := imp.modified[rel]; ok && !t.IsZero() {
+		return t
+	}
+	return fi.ModTime()
+}
+
+func (imp *cloudImporter) importFile(rel string, fi os.FileInfo) error {
+	local, err := ioutil.OpenFile(
+		filepath.Join(imp.src, filepath.FromSlash(rel)), os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer local.Close()
+
+	p := path.Join(imp.dest, rel)
+	f, err := imp.fs.OpenFile(p, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, local)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if fi.Mode()&0100 != 0 {
+		if err := imp.fs.Chmod(p, 0755); err != nil {
+			return err
+		}
+	}
+	t := imp.mtime(rel, fi)
+	if err := imp.fs.Chtimes(p, t, t); err != nil {
+		return err
+	}
+	imp.stats.Files++
+	imp.stats.Bytes += n
+	return nil
+}
+
+func (imp *cloudImporter) importDir(ctx context.Context, rel string) error {
+	fis, err := ioutil.ReadDir(filepath.Join(imp.src, filepath.FromSlash(rel)))
+	if err != nil {
+		return err
+	}
+	sort.Slice(fis, func(i, j int) bool {
+		return fis[i].Name() < fis[j].Name()
+	})
+	for _, fi := range fis {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		childRel := path.Join(rel, fi.Name())
+		switch {
+		case childRel == CloudExportManifestName:
+			continue
+		case fi.IsDir():
+			p := path.Join(imp.dest, childRel)
+			if err := imp.fs.MkdirAll(p, 0755); err != nil {
+				return err
+			}
+			if err := imp.importDir(ctx, childRel); err != nil {
+				return err
+			}
+			// Set the time last, since importing the children
+			// changes it.
+			t := imp.mtime(childRel, fi)
+			if err := imp.fs.Chtimes(p, t, t); err != nil {
+				return err
+			}
+			imp.stats.Dirs++
+		case fi.Mode().IsRegular():
+			if err := imp.importFile(childRel, fi); err != nil {
+				return err
+			}
+		default:
+			imp.fs.log.CDebugf(ctx, "Skipping %s of type %s",
+				childRel, fi.Mode().Type())
+		}
+	}
+	return nil
+}
+
+// CloudShareSuggestion suggests where the contents of a shared
+// folder of a cloud export could go in KBFS, so that the same
+// accounts keep access.
+type CloudShareSuggestion struct {
+	// Path is the shared folder's path in the export.
+	Path string
+	// Folder is a suggested /keybase path: a private folder shared
+	// between the members directly, or the folder of a new team
+	// named Team.
+	Folder string
+	// Team, if set, is a suggested name for a new team for the
+	// members, with writers as team writers and readers as team
+	// readers.
+	Team    string
+	Writers []string
+	Readers []string
+	// Unmapped are the accounts that have no Keybase equivalent.
+	Unmapped []string
+}
+
+var (
+	keybaseUsernameRegexp  = regexp.MustCompile(`^[a-z0-9_]{2,16}$`)
+	socialAssertionRegexp  = regexp.MustCompile(`^[a-z0-9_.-]+@[a-z]+$`)
+	emailAddressRegexp     = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	teamNameInvalidRegexp  = regexp.MustCompile(`[^a-z0-9_]+`)
+	socialAssertionDomains = map[string]bool{
+		"twitter": true, "github": true, "reddit": true,
+		"hackernews": true, "facebook": true,
+	}
+)
+
+// cloudAccountAssertion returns the Keybase assertion for the given
+// account of a cloud service, if there is one.
+func cloudAccountAssertion(account string) (string, bool) {
+	a := strings.ToLower(strings.TrimSpace(account))
+	switch {
+	case keybaseUsernameRegexp.MatchString(a):
+		return a, true
+	case socialAssertionRegexp.MatchString(a) &&
+		socialAssertionDomains[a[strings.LastIndex(a, "@")+1:]]:
+		return a, true
+	case emailAddressRegexp.MatchString(a):
+		return "[" + a + "]@email", true
+	default:
+		return "", false
+	}
+}
+
+// cloudShareTeamName makes a team name out of a folder path.
+func cloudShareTeamName(p string) string {
+	name := strings.ToLower(path.Base(p))
+	name = strings.Trim(teamNameInvalidRegexp.ReplaceAllString(name, "_"), "_")
+	// Team names have to start with a letter.
+	for len(name) < 2 || !(name[0] >= 'a' && name[0] <= 'z') {
+		name = "t" + name
+	}
+	if len(name) > cloudShareTeamNameMaxLen {
+		name = strings.TrimRight(name[:cloudShareTeamNameMaxLen], "_")
+	}
+	return name
+}
+
+// SuggestCloudShares maps the sharing lists in a cloud export
+// manifest to Keybase folders.  `self` is the assertion of the user
+// doing the import, who is always a writer.  Shared folders with
+// many members get a team; others are shared privately between the
+// members.
+func SuggestCloudShares(
+	manifest CloudExportManifest, self string) []CloudShareSuggestion {
+	suggestions := make([]CloudShareSuggestion, 0, len(manifest.SharedFolders))
+	for _, sf := range manifest.SharedFolders {
+		s := CloudShareSuggestion{Path: path.Clean(sf.Path)}
+		writers := map[string]bool{self: true}
+		readers := make(map[string]bool)
+		for _, m := range sf.Members {
+			a, ok := cloudAccountAssertion(m.Account)
+			if !ok {
+				s.Unmapped = append(s.Unmapped, m.Account)
+				continue
+			}
+			switch strings.ToLower(m.Role) {
+			case "owner", "editor", "writer":
+				writers[a] = true
+				delete(readers, a)
+			default:
+				if !writers[a] {
+					readers[a] = true
+				}
+			}
+		}
+		for w := range writers {
+			s.Writers = append(s.Writers, w)
+		}
+		for r := range readers {
+			s.Readers = append(s.Readers, r)
+		}
+		sort.Strings(s.Writers)
+		sort.Strings(s.Readers)
+		sort.Strings(s.Unmapped)
+
+		if len(s.Writers)+len(s.Readers) >= cloudShareTeamMinMembers {
+			s.Team = cloudShareTeamName(s.Path)
+			s.Folder = libkbfs.BuildCanonicalPath(
+				libkbfs.SingleTeamPathType, s.Team)
+		} else {
+			name := strings.Join(s.Writers, ",")
+			if len(s.Readers) > 0 {
+				name += "#" + strings.Join(s.Readers, ",")
+			}
+			s.Folder = libkbfs.BuildCanonicalPath(
+				libkbfs.PrivatePathType, name)
+		}
+		suggestions = append(suggestions, s)
+	}
+	return suggestions
+}
@@ -0,0 +1,105 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportCloudExport(t *testing.T) {
+	ctx, _, fs := makeFS(t, "")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, fs.config)
+	src, err := ioutil.TempDir(os.TempDir(), "cloud_import")
+	require.NoError(t, err)
+	defer os.RemoveAll(src)
+
+	writeLocalTestFile(t, src, "docs/a.txt", "a")
+	writeLocalTestFile(t, src, "docs/old/b.txt", "bb")
+	writeLocalTestFile(t, src, "c.txt", "ccc")
+	writeLocalTestFile(t, src, CloudExportManifestName, "{}")
+	aTime := time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)
+	docsTime := time.Date(2016, 4, 2, 12, 0, 0, 0, time.UTC)
+	manifest, err := ReadCloudExportManifest(strings.NewReader(`{
+		"source": "dropbox",
+		"entries": [
+			{"path": "docs/a.txt", "modified": "2015-03-01T12:00:00Z"},
+			{"path": "docs", "modified": "2016-04-02T12:00:00Z"}
+		]
+	}`))
+	require.NoError(t, err)
+
+	stats, err := ImportCloudExport(ctx, fs, src, "imported", manifest)
+	require.NoError(t, err)
+	require.Equal(t, CloudImportStats{Files: 3, Dirs: 2, Bytes: 6}, stats)
+	require.Equal(t, "a", readTestFile(t, fs, "imported/docs/a.txt"))
+	require.Equal(t, "bb", readTestFile(t, fs, "imported/docs/old/b.txt"))
+	require.Equal(t, "ccc", readTestFile(t, fs, "imported/c.txt"))
+	_, err = fs.Stat("imported/" + CloudExportManifestName)
+	require.True(t, os.IsNotExist(err))
+
+	t.Log("Timestamps come from the manifest, or else the local files")
+	fi, err := fs.Stat("imported/docs/a.txt")
+	require.NoError(t, err)
+	require.True(t, aTime.Equal(fi.ModTime()))
+	fi, err = fs.Stat("imported/docs")
+	require.NoError(t, err)
+	require.True(t, docsTime.Equal(fi.ModTime()))
+	localFI, err := os.Stat(filepath.Join(src, "c.txt"))
+	require.NoError(t, err)
+	fi, err = fs.Stat("imported/c.txt")
+	require.NoError(t, err)
+	require.True(t, localFI.ModTime().Equal(fi.ModTime()))
+}
+
+func TestSuggestCloudShares(t *testing.T) {
+	manifest := CloudExportManifest{
+		SharedFolders: []CloudExportSharedFolder{
+			{
+				Path: "Project Plans/",
+				Members: []CloudExportMember{
+					{Account: "Bob@Example.com", Role: "editor"},
+					{Account: "charlie@twitter", Role: "viewer"},
+					{Account: "dave", Role: "commenter"},
+					{Account: "not an account", Role: "viewer"},
+				},
+			},
+			{
+				Path: "2018 Family Photos!",
+				Members: []CloudExportMember{
+					{Account: "bob", Role: "owner"},
+					{Account: "carol", Role: "editor"},
+					{Account: "dave", Role: "viewer"},
+					{Account: "eve", Role: "viewer"},
+					{Account: "eve", Role: "editor"},
+				},
+			},
+		},
+	}
+	suggestions := SuggestCloudShares(manifest, "alice")
+	require.Equal(t, []CloudShareSuggestion{
+		{
+			Path:     "Project Plans",
+			Folder:   "/keybase/private/[bob@example.com]@email,alice#charlie@twitter,dave",
+			Writers:  []string{"[bob@example.com]@email", "alice"},
+			Readers:  []string{"charlie@twitter", "dave"},
+			Unmapped: []string{"not an account"},
+		},
+		{
+			Path:    "2018 Family Photos!",
+			Folder:  "/keybase/team/t2018_family_pho",
+			Team:    "t2018_family_pho",
+			Writers: []string{"alice", "bob", "carol", "eve"},
+			Readers: []string{"dave"},
+		},
+	}, suggestions)
+}
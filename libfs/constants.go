@@ -57,6 +57,12 @@ const PauseJournalBackgroundWorkFileName = ".kbfs_pause_journal_background_work"
 // anywhere within a top-level folder.
 const ResumeJournalBackgroundWorkFileName = ".kbfs_resume_journal_background_work"
 
+// ReleaseJournalQuarantineFileName is the name of the file that
+// deletes the local copies of the files the content scanner rejected
+// in a top-level folder. It can be reached anywhere within a
+// top-level folder.
+const ReleaseJournalQuarantineFileName = ".kbfs_release_journal_quarantine"
+
 // DisableJournalFileName is the name of the journal-disabling
 // file. It can be reached anywhere within a top-level folder.
 const DisableJournalFileName = ".kbfs_disable_journal"
@@ -86,6 +92,11 @@ const EnableDebugServerFileName = ".kbfs_enable_debug_server"
 // debug HTTP server. It's accessible anywhere outside a TLF.
 const DisableDebugServerFileName = ".kbfs_disable_debug_server"
 
+// StatusDirName is the name of the KBFS status directory, which
+// holds JSON files describing the state of KBFS and of each open TLF
+// -- it can be reached anywhere outside a TLF.
+const StatusDirName = ".kbfs_dashboard"
+
 // EditHistoryName is the name of the KBFS TLF edit history file --
 // it can be reached anywhere within a top-level folder.
 const EditHistoryName = ".kbfs_edit_history"
@@ -94,6 +105,11 @@ const EditHistoryName = ".kbfs_edit_history"
 // can be reached anywhere within a top-level folder.
 const UpdateHistoryFileName = ".kbfs_update_history"
 
+// LegalHoldFileName is the name of the KBFS legal hold report, which
+// lists the revisions retained by a legal hold -- it can be reached
+// anywhere within a top-level folder.
+const LegalHoldFileName = ".kbfs_legal_hold"
+
 // FileInfoPrefix is the prefix of the per-file metadata files.
 const FileInfoPrefix = ".kbfs_fileinfo_"
 
@@ -105,6 +121,68 @@ const EnableSyncFileName = ".kbfs_enable_sync"
 // TLF. It can be reached anywhere within a TLF.
 const DisableSyncFileName = ".kbfs_disable_sync"
 
+// RepairSyncFileName is the name of the file to verify the sync cache
+// of a TLF against the server, and repair it. It can be reached
+// anywhere within a TLF.
+const RepairSyncFileName = ".kbfs_repair_sync"
+
+// EnableWriteThroughFileName is the name of the file to make writes
+// to a TLF skip the local journal and wait for the servers on sync.
+// It can be reached anywhere within a TLF.
+const EnableWriteThroughFileName = ".kbfs_enable_write_through"
+
+// DisableWriteThroughFileName is the name of the file to make writes
+// to a TLF go through the local journal again. It can be reached
+// anywhere within a TLF.
+const DisableWriteThroughFileName = ".kbfs_disable_write_through"
+
+// SyncCacheReportFileName is the name of the file that reports how
+// the sync cache of a TLF differs from the server's latest revision.
+// It can be reached anywhere within a TLF.
+const SyncCacheReportFileName = ".kbfs_sync_cache_report"
+
+// RecentFilesFileName is the name of the file that lists the files in
+// a TLF that were recently read or written on this device.  It can be
+// reached anywhere within a TLF.
+const RecentFilesFileName = ".kbfs_recent_files"
+
+// FolderStatsFileName is the name of the file that reports aggregate
+// stats about a TLF, like its file count and total size.  It can be
+// reached anywhere within a TLF.
+const FolderStatsFileName = ".kbfs_folder_stats"
+
+// ExpiryReportFileName is the name of the file that lists the files
+// in a TLF that its expiry policies would delete right now, without
+// deleting them.  It can be reached anywhere within a TLF.
+const ExpiryReportFileName = ".kbfs_expiry_report"
+
+// FsyncModeFileName is the name of the file that says what an fsync
+// waits for in a TLF.  It can be reached anywhere within a TLF.
+const FsyncModeFileName = ".kbfs_fsync_mode"
+
+// TlfSettingsReportFileName is the name of the file that reports the
+// settings in effect in a TLF, or why its settings file is being
+// ignored.  It can be reached anywhere within a TLF.
+const TlfSettingsReportFileName = ".kbfs_settings_report"
+
+// OpenFilesFileName is the name of the file that lists the handles
+// open through the mount for files in a TLF.  It can be reached
+// anywhere within a TLF.
+const OpenFilesFileName = ".kbfs_open_files"
+
+// TopStatusFileName is the name of the file that has a snapshot of
+// the per-TLF activity, journal backlogs, cache hits and running
+// operations of KBFS, for `kbfstool top`.  It can be reached
+// anywhere.
+const TopStatusFileName = ".kbfs_top"
+
+// AcknowledgeBrokenProofsFileName is the name of the file to which a
+// username can be written, to allow access to folders with that user
+// as a member despite their broken proofs, when the identify policy
+// asks for such acknowledgements.  It can be reached from the
+// top-level FS mount.
+const AcknowledgeBrokenProofsFileName = ".kbfs_acknowledge_broken_proofs"
+
 // ArchivedRevDirPrefix is the prefix to the directory at the root of a
 // TLF that exposes a version of that TLF at the specified revision.
 const ArchivedRevDirPrefix = ".kbfs_archived_rev="
@@ -0,0 +1,534 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"archive/tar"
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+// An escrow bundle is a folder exported together with its keys, so
+// that it can be recovered without the Keybase servers.  It's a tar
+// archive holding escrowKeysName, the folder's contents under
+// escrowContentDir, and escrowManifestName, sealed with a key derived
+// from a passphrase.
+//
+// The sealed format is escrowMagic, followed by the scrypt cost
+// parameters as three bytes (log2 N, r, p), which must be
+// escrowScryptLogN, escrowScryptR and escrowScryptP, a salt, and a
+// nonce prefix.  Then come chunks of at most escrowChunkSize bytes, each
+// written as a big-endian uint32 length followed by the
+// secretbox-sealed chunk.  A chunk's nonce is the nonce prefix
+// followed by its big-endian uint64 index, with the top bit set on
+// the last chunk so that truncation can be detected.
+const (
+	escrowMagic        = "KBFSESCROW\x01"
+	escrowKeysName     = "keys.json"
+	escrowManifestName = "manifest.json"
+	escrowContentDir   = "content"
+
+	escrowSaltSize        = 32
+	escrowNoncePrefixSize = 16
+	escrowHeaderSize      = len(escrowMagic) + 3 + escrowSaltSize +
+		escrowNoncePrefixSize
+	escrowChunkSize    = 64 * 1024
+	escrowLastChunkBit = 1 << 63
+
+	// Take about a tenth of a second to derive the key on a modern
+	// machine, using 32 MiB of memory.
+	escrowScryptLogN = 15
+	escrowScryptR    = 8
+	escrowScryptP    = 1
+)
+
+var errEscrowNoPassphrase = errors.New(
+	"A passphrase is needed to seal or open an escrow bundle")
+
+var errEscrowBadBundle = errors.New(
+	"Wrong passphrase, or not a valid escrow bundle")
+
+// EscrowKeys are the keys exported in an escrow bundle.  With them,
+// the private metadata of the folder, and the blocks it references,
+// can be decrypted once fetched from a backup of the servers.
+type EscrowKeys struct {
+	Folder string
+	TlfID  tlf.ID
+	// Keys are the crypt keys of the folder, in order of key
+	// generation, starting with kbfsmd.FirstValidKeyGen.
+	Keys []kbfscrypto.TLFCryptKey
+}
+
+// escrowKeysJSON is how EscrowKeys are stored in a bundle.  Crypt
+// keys refuse to be marshaled on their own, so that they don't end
+// up in logs by accident.
+type escrowKeysJSON struct {
+	Folder string
+	TlfID  tlf.ID
+	Keys   []string
+}
+
+// MarshalJSON implements the json.Marshaler interface for
+// EscrowKeys.  Keys are encoded as hex strings.
+func (ek EscrowKeys) MarshalJSON() ([]byte, error) {
+	ekj := escrowKeysJSON{Folder: ek.Folder, TlfID: ek.TlfID}
+	for _, k := range ek.Keys {
+		data := k.Data()
+		ekj.Keys = append(ekj.Keys, hex.EncodeToString(data[:]))
+	}
+	return json.Marshal(ekj)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface for
+// EscrowKeys.
+func (ek *EscrowKeys) UnmarshalJSON(b []byte) error {
+	var ekj escrowKeysJSON
+	if err := json.Unmarshal(b, &ekj); err != nil {
+		return err
+	}
+	ek.Folder = ekj.Folder
+	ek.TlfID = ekj.TlfID
+	ek.Keys = nil
+	for _, s := range ekj.Keys {
+		var data [32]byte
+		n, err := hex.Decode(data[:], []byte(s))
+		if err != nil {
+			return err
+		}
+		if n != len(data) {
+			return errors.Errorf("Invalid key length %d", n)
+		}
+		ek.Keys = append(ek.Keys, kbfscrypto.MakeTLFCryptKey(data))
+	}
+	return nil
+}
+
+func escrowKey(passphrase, salt []byte, logN, r, p byte) (
+	key [32]byte, err error) {
+	if len(passphrase) == 0 {
+		return key, errEscrowNoPassphrase
+	}
+	// The cost parameters come from the bundle header, which isn't
+	// authenticated, so only the ones this code writes are accepted.
+	// Otherwise a crafted bundle could make opening it take
+	// unbounded time and memory.
+	if logN != escrowScryptLogN || r != escrowScryptR ||
+		p != escrowScryptP {
+		return key, errEscrowBadBundle
+	}
+	k, err := scrypt.Key(passphrase, salt, 1<<logN, int(r), int(p), len(key))
+	if err != nil {
+		return key, err
+	}
+	copy(key[:], k)
+	return key, nil
+}
+
+// escrowWriter seals everything written to it, chunk by chunk.
+type escrowWriter struct {
+	w           io.Writer
+	key         [32]byte
+	noncePrefix [escrowNoncePrefixSize]byte
+	index       uint64
+	buf         []byte
+}
+
+func newEscrowWriter(w io.Writer, passphrase []byte) (*escrowWriter, error) {
+	var header [escrowHeaderSize]byte
+	n := copy(header[:], escrowMagic)
+	header[n] = escrowScryptLogN
+	header[n+1] = escrowScryptR
+	header[n+2] = escrowScryptP
+	salt := header[n+3 : n+3+escrowSaltSize]
+	noncePrefix := header[n+3+escrowSaltSize:]
+	if _, err := rand.Read(header[n+3:]); err != nil {
+		return nil, err
+	}
+	key, err := escrowKey(passphrase, salt,
+		escrowScryptLogN, escrowScryptR, escrowScryptP)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header[:]); err != nil {
+		return nil, err
+	}
+	ew := &escrowWriter{w: w, key: key}
+	copy(ew.noncePrefix[:], noncePrefix)
+	return ew, nil
+}
+
+func (ew *escrowWriter) nonce(last bool) *[24]byte {
+	var nonce [24]byte
+	copy(nonce[:], ew.noncePrefix[:])
+	index := ew.index
+	if last {
+		index |= escrowLastChunkBit
+	}
+	binary.BigEndian.PutUint64(nonce[escrowNoncePrefixSize:], index)
+	return &nonce
+}
+
+func (ew *escrowWriter) writeChunk(chunk []byte, last bool) error {
+	sealed := secretbox.Seal(nil, chunk, ew.nonce(last), &ew.key)
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(sealed)))
+	if _, err := ew.w.Write(size[:]); err != nil {
+		return err
+	}
+	if _, err := ew.w.Write(sealed); err != nil {
+		return err
+	}
+	ew.index++
+	return nil
+}
+
+func (ew *escrowWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		// A full chunk is only sealed once there's more data, since
+		// it might be the last.
+		if len(ew.buf) == escrowChunkSize {
+			if err := ew.writeChunk(ew.buf, false); err != nil {
+				return 0, err
+			}
+			ew.buf = ew.buf[:0]
+		}
+		n := escrowChunkSize - len(ew.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		ew.buf = append(ew.buf, p[:n]...)
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Close seals the last chunk.  It doesn't close the underlying
+// writer.
+func (ew *escrowWriter) Close() error {
+	return ew.writeChunk(ew.buf, true)
+}
+
+// escrowReader opens the chunks sealed by an escrowWriter.
+type escrowReader struct {
+	r           *bufio.Reader
+	key         [32]byte
+	noncePrefix [escrowNoncePrefixSize]byte
+	index       uint64
+	buf         []byte
+	done        bool
+}
+
+func newEscrowReader(r io.Reader, passphrase []byte) (*escrowReader, error) {
+	var header [escrowHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, errEscrowBadBundle
+	}
+	n := len(escrowMagic)
+	if string(header[:n]) != escrowMagic {
+		return nil, errEscrowBadBundle
+	}
+	key, err := escrowKey(passphrase, header[n+3:n+3+escrowSaltSize],
+		header[n], header[n+1], header[n+2])
+	if err != nil {
+		return nil, err
+	}
+	er := &escrowReader{r: bufio.NewReader(r), key: key}
+	copy(er.noncePrefix[:], header[n+3+escrowSaltSize:])
+	return er, nil
+}
+
+func (er *escrowReader) readChunk() error {
+	var size [4]byte
+	if _, err := io.ReadFull(er.r, size[:]); err != nil {
+		// The last chunk is missing.
+		return errEscrowBadBundle
+	}
+	sealedSize := binary.BigEndian.Uint32(size[:])
+	if sealedSize > escrowChunkSize+secretbox.Overhead {
+		return errEscrowBadBundle
+	}
+	sealed := make([]byte, sealedSize)
+	if _, err := io.ReadFull(er.r, sealed); err != nil {
+		return errEscrowBadBundle
+	}
+
+	var nonce [24]byte
+	copy(nonce[:], er.noncePrefix[:])
+	binary.BigEndian.PutUint64(nonce[escrowNoncePrefixSize:], er.index)
+	chunk, ok := secretbox.Open(nil, sealed, &nonce, &er.key)
+	if !ok {
+		binary.BigEndian.PutUint64(
+			nonce[escrowNoncePrefixSize:], er.index|escrowLastChunkBit)
+		chunk, ok = secretbox.Open(nil, sealed, &nonce, &er.key)
+		if !ok {
+			return errEscrowBadBundle
+		}
+		er.done = true
+	}
+	er.index++
+	er.buf = chunk
+	return nil
+}
+
+func (er *escrowReader) Read(p []byte) (int, error) {
+	for len(er.buf) == 0 {
+		if er.done {
+			return 0, io.EOF
+		}
+		if err := er.readChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, er.buf)
+	er.buf = er.buf[n:]
+	return n, nil
+}
+
+func writeEscrowJSON(tw *tar.Writer, name string, v interface{},
+	modTime time.Time) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     0600,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+		Format:   tar.FormatPAX,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return err
+}
+
+// ExportWithKeys writes an escrow bundle of the subtree rooted at
+// `root` in `fs` to `w`.  The bundle holds the contents of the
+// subtree, a manifest describing it, and every crypt key of the
+// folder, all sealed with a key derived from `passphrase`.  Anyone
+// with the bundle and the passphrase can read everything in it, with
+// or without a Keybase account, so both should be stored with care.
+func ExportWithKeys(
+	fs *FS, root string, passphrase []byte, w io.Writer) error {
+	keys, tlfID, err := fs.config.KBFSOps().GetTLFCryptKeys(fs.ctx, fs.h)
+	if err != nil {
+		return err
+	}
+	ew, err := newEscrowWriter(w, passphrase)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(ew)
+	e, err := newExporter(fs, root, tw, escrowContentDir, true)
+	if err != nil {
+		return err
+	}
+	err = writeEscrowJSON(tw, escrowKeysName, EscrowKeys{
+		Folder: e.manifest.Folder,
+		TlfID:  tlfID,
+		Keys:   keys,
+	}, e.manifest.ExportTime)
+	if err != nil {
+		return err
+	}
+	if err := e.exportRoot(root); err != nil {
+		return err
+	}
+	err = writeEscrowJSON(
+		tw, escrowManifestName, e.manifest, e.manifest.ExportTime)
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return ew.Close()
+}
+
+// ReadEscrowKeys returns the keys stored in an escrow bundle, without
+// restoring its contents.
+func ReadEscrowKeys(r io.Reader, passphrase []byte) (*EscrowKeys, error) {
+	er, err := newEscrowReader(r, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(er)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, errEscrowBadBundle
+		} else if err != nil {
+			return nil, err
+		}
+		if hdr.Name != escrowKeysName {
+			continue
+		}
+		keys := &EscrowKeys{}
+		if err := json.NewDecoder(tr).Decode(keys); err != nil {
+			return nil, err
+		}
+		return keys, nil
+	}
+}
+
+// underEscrowSymlink returns true if `name`, or one of its parent
+// directories, is one of `symlinks`.
+func underEscrowSymlink(symlinks map[string]bool, name string) bool {
+	for p := name; p != "." && p != "/"; p = path.Dir(p) {
+		if symlinks[p] {
+			return true
+		}
+	}
+	return false
+}
+
+type escrowTimes struct {
+	name  string
+	mtime time.Time
+}
+
+// ImportEscrowBundle opens an escrow bundle written by
+// ExportWithKeys, and recreates the exported subtree in `dest`.
+// `dest` can be another KBFS folder, or a local directory, which
+// needs no connection to Keybase at all.  Mtimes are restored if
+// `dest` implements billy.Change, and the import is flushed if `dest`
+// is a KBFS folder.  It returns the keys and manifest stored in the
+// bundle.
+func ImportEscrowBundle(r io.Reader, passphrase []byte,
+	dest billy.Filesystem) (*EscrowKeys, *ExportManifest, error) {
+	er, err := newEscrowReader(r, passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	var keys *EscrowKeys
+	var manifest *ExportManifest
+	var times []escrowTimes
+	// Nothing may be written through a symlink from the bundle,
+	// since it could point anywhere.
+	symlinks := make(map[string]bool)
+	tr := tar.NewReader(er)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		switch {
+		case hdr.Name == escrowKeysName:
+			keys = &EscrowKeys{}
+			if err := json.NewDecoder(tr).Decode(keys); err != nil {
+				return nil, nil, err
+			}
+			continue
+		case hdr.Name == escrowManifestName:
+			manifest = &ExportManifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		name := path.Clean(hdr.Name)
+		if !strings.HasPrefix(name, escrowContentDir+"/") {
+			return nil, nil, errors.Errorf(
+				"Unexpected entry %q in escrow bundle", hdr.Name)
+		}
+		name = strings.TrimPrefix(name, escrowContentDir+"/")
+		if name == ".." || strings.HasPrefix(name, "../") ||
+			underEscrowSymlink(symlinks, name) {
+			return nil, nil, errors.Errorf(
+				"Entry %q in escrow bundle is outside of its content",
+				hdr.Name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = dest.MkdirAll(name, 0755)
+		case tar.TypeSymlink:
+			err = dest.MkdirAll(path.Dir(name), 0755)
+			if err == nil {
+				err = dest.Symlink(hdr.Linkname, name)
+			}
+			symlinks[name] = true
+			// Setting the times would follow the link.
+			if err != nil {
+				return nil, nil, err
+			}
+			continue
+		case tar.TypeReg:
+			err = importEscrowFile(dest, name, os.FileMode(hdr.Mode), tr)
+		default:
+			err = errors.Errorf(
+				"Unexpected type %c for %q in escrow bundle",
+				hdr.Typeflag, hdr.Name)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		times = append(times, escrowTimes{name, hdr.ModTime})
+	}
+	if keys == nil || manifest == nil {
+		return nil, nil, errEscrowBadBundle
+	}
+
+	if change, ok := dest.(billy.Change); ok {
+		// Go in reverse, so that directory times are set after
+		// their children are written.
+		for i := len(times) - 1; i >= 0; i-- {
+			t := times[i]
+			if err := change.Chtimes(t.name, t.mtime, t.mtime); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if kbfs, ok := dest.(*FS); ok {
+		if err := kbfs.SyncAll(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return keys, manifest, nil
+}
+
+func importEscrowFile(
+	dest billy.Filesystem, name string, mode os.FileMode, r io.Reader) (
+	err error) {
+	if err := dest.MkdirAll(path.Dir(name), 0755); err != nil {
+		return err
+	}
+	f, err := dest.OpenFile(
+		name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode.Perm())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := f.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	_, err = io.Copy(f, r)
+	return err
+}
@@ -0,0 +1,54 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func sealEscrowForTest(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	ew, err := newEscrowWriter(&buf, []byte("passphrase"))
+	require.NoError(t, err)
+	_, err = ew.Write(data)
+	require.NoError(t, err)
+	err = ew.Close()
+	require.NoError(t, err)
+	return buf.Bytes()
+}
+
+func TestEscrowRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("escrow"), escrowChunkSize/3)
+	sealed := sealEscrowForTest(t, data)
+
+	er, err := newEscrowReader(bytes.NewReader(sealed), []byte("passphrase"))
+	require.NoError(t, err)
+	opened, err := ioutil.ReadAll(er)
+	require.NoError(t, err)
+	require.Equal(t, data, opened)
+
+	er, err = newEscrowReader(bytes.NewReader(sealed), []byte("wrong"))
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(er)
+	require.Equal(t, errEscrowBadBundle, err)
+}
+
+func TestEscrowRejectsOtherScryptParams(t *testing.T) {
+	sealed := sealEscrowForTest(t, []byte("data"))
+
+	// A bundle asking for much more expensive key derivation must be
+	// rejected before any is done.
+	for i, v := range []byte{30, 255, 255} {
+		tampered := append([]byte(nil), sealed...)
+		tampered[len(escrowMagic)+i] = v
+		_, err := newEscrowReader(
+			bytes.NewReader(tampered), []byte("passphrase"))
+		require.Equal(t, errEscrowBadBundle, err)
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// GetEncodedExpiryReport returns a JSON-encoded list of the files in
+// a TLF that have outlived the expiry policies covering them, as a
+// dry run of what the background enforcer would delete.
+func GetEncodedExpiryReport(
+	ctx context.Context, config libkbfs.Config,
+	folderBranch libkbfs.FolderBranch, h *libkbfs.TlfHandle) (
+	data []byte, t time.Time, err error) {
+	rootNode, _, err := config.KBFSOps().GetRootNode(
+		ctx, h, folderBranch.Branch)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	expired, err := libkbfs.ExpireEntries(ctx, config, rootNode, true)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	data, err = PrettyJSON(expired)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return data, time.Time{}, nil
+}
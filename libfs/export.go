@@ -0,0 +1,221 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+)
+
+// ExportManifestEntry describes one exported entry in an
+// ExportManifest.
+type ExportManifestEntry struct {
+	Path       string
+	Type       libkbfs.EntryType
+	Size       int64
+	Mtime      time.Time
+	LastWriter string `json:",omitempty"`
+	// PrevRevisions lists the earlier revisions of the folder in
+	// which this entry was changed, if known.
+	PrevRevisions []kbfsmd.Revision `json:",omitempty"`
+}
+
+// ExportManifest is the sidecar that can accompany an exported
+// archive, describing who last wrote each entry and which revision
+// of the folder the export reflects.
+type ExportManifest struct {
+	Folder     string
+	Revision   kbfsmd.Revision
+	ExportTime time.Time
+	Entries    []ExportManifestEntry
+}
+
+type exporter struct {
+	fs *FS
+	tw *tar.Writer
+	// prefix is prepended to every name in the archive, but not in
+	// the manifest.
+	prefix   string
+	manifest *ExportManifest
+}
+
+func (e *exporter) header(p, name string, fi os.FileInfo) (
+	*tar.Header, error) {
+	hdr := &tar.Header{
+		Name:    path.Join(e.prefix, name),
+		ModTime: fi.ModTime(),
+		Format:  tar.FormatPAX,
+	}
+	sys, ok := fi.Sys().(fileInfoSys)
+	if !ok {
+		return nil, errors.Errorf("Unexpected file info for %s", p)
+	}
+	switch sys.EntryInfo().Type {
+	case libkbfs.Dir:
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+		hdr.Mode = 0755
+	case libkbfs.Sym:
+		target, err := e.fs.Readlink(p)
+		if err != nil {
+			return nil, err
+		}
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = target
+		hdr.Mode = 0777
+	case libkbfs.Exec:
+		hdr.Typeflag = tar.TypeReg
+		hdr.Mode = 0755
+		hdr.Size = fi.Size()
+	default:
+		hdr.Typeflag = tar.TypeReg
+		hdr.Mode = 0644
+		hdr.Size = fi.Size()
+	}
+	return hdr, nil
+}
+
+func (e *exporter) addToManifest(name string, fi os.FileInfo) error {
+	if e.manifest == nil {
+		return nil
+	}
+	sys := fi.Sys().(fileInfoSys)
+	entry := ExportManifestEntry{
+		Path:  name,
+		Type:  sys.EntryInfo().Type,
+		Size:  fi.Size(),
+		Mtime: fi.ModTime(),
+	}
+	writer, err := sys.LastWriter()
+	if err != nil {
+		return err
+	}
+	entry.LastWriter = writer.Username
+	for _, prc := range sys.PrevRevisions() {
+		entry.PrevRevisions = append(entry.PrevRevisions, prc.Revision)
+	}
+	e.manifest.Entries = append(e.manifest.Entries, entry)
+	return nil
+}
+
+// export writes the entry at path `p` in the FS to the archive under
+// `name`, recursing into directories.
+func (e *exporter) export(p, name string, fi os.FileInfo) error {
+	hdr, err := e.header(p, name, fi)
+	if err != nil {
+		return err
+	}
+	if err := e.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if err := e.addToManifest(name, fi); err != nil {
+		return err
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeReg:
+		f, err := e.fs.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(e.tw, f)
+		return err
+	case tar.TypeDir:
+		fis, err := e.fs.ReadDir(p)
+		if err != nil {
+			return err
+		}
+		return e.exportChildren(p, name, fis)
+	}
+	return nil
+}
+
+func (e *exporter) exportChildren(
+	p, name string, fis []os.FileInfo) error {
+	sort.Slice(fis, func(i, j int) bool {
+		return fis[i].Name() < fis[j].Name()
+	})
+	for _, child := range fis {
+		err := e.export(path.Join(p, child.Name()),
+			path.Join(name, child.Name()), child)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newExporter returns an exporter that writes to `tw`, and that
+// builds a manifest if `withManifest` is true.
+func newExporter(fs *FS, root string, tw *tar.Writer, prefix string,
+	withManifest bool) (*exporter, error) {
+	e := &exporter{fs: fs, tw: tw, prefix: prefix}
+	if !withManifest {
+		return e, nil
+	}
+	status, _, err := fs.config.KBFSOps().FolderStatus(
+		fs.ctx, fs.RootNode().GetFolderBranch())
+	if err != nil {
+		return nil, err
+	}
+	e.manifest = &ExportManifest{
+		Folder:     path.Join(fs.Root(), root),
+		Revision:   status.Revision,
+		ExportTime: fs.config.Clock().Now(),
+	}
+	return e, nil
+}
+
+// exportRoot writes the subtree rooted at `root` to the archive,
+// without closing it.
+func (e *exporter) exportRoot(root string) error {
+	fi, err := e.fs.Lstat(root)
+	if err != nil {
+		return err
+	}
+	root = path.Clean(root)
+	if !fi.IsDir() {
+		return e.export(root, path.Base(root), fi)
+	}
+	// Export the children directly, so the archive paths are
+	// relative to `root`.
+	fis, err := e.fs.ReadDir(root)
+	if err != nil {
+		return err
+	}
+	return e.exportChildren(root, "", fis)
+}
+
+// Export streams a POSIX tar archive of the subtree rooted at `root`
+// in `fs` to `w`, preserving mtimes, symlinks and exec bits.  If
+// `manifest` is non-nil, a JSON-encoded ExportManifest is written to
+// it once the archive is complete.
+func Export(fs *FS, root string, w io.Writer, manifest io.Writer) error {
+	e, err := newExporter(fs, root, tar.NewWriter(w), "", manifest != nil)
+	if err != nil {
+		return err
+	}
+	if err := e.exportRoot(root); err != nil {
+		return err
+	}
+	if err := e.tw.Close(); err != nil {
+		return err
+	}
+
+	if e.manifest == nil {
+		return nil
+	}
+	return json.NewEncoder(manifest).Encode(e.manifest)
+}
@@ -0,0 +1,394 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+)
+
+// The CAR export lays out files the same way `ipfs add
+// --cid-version=1` does by default, so that content mirrored onto
+// IPFS gets the same CIDs it would have if it had been added
+// directly: files are split into 256 KiB chunks stored as raw
+// leaves, under a balanced tree of dag-pb nodes with at most 174
+// links each.  Directories are plain (unsharded) UnixFS directories,
+// and since UnixFS has no exec bit by default, executables are
+// exported as regular files.
+const (
+	carDefaultChunkSize = 256 * 1024
+	carDefaultMaxLinks  = 174
+
+	// Multicodec codes.
+	carCodecRaw    = 0x55
+	carCodecDagPB  = 0x70
+	carHashSHA2256 = 0x12
+
+	// UnixFS data types.
+	unixfsDirectory = 1
+	unixfsFile      = 2
+	unixfsSymlink   = 4
+)
+
+var carBase32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// carCIDString returns the canonical string form of a binary CIDv1,
+// i.e., lower-case base32 with a "b" multibase prefix.
+func carCIDString(cid []byte) string {
+	return "b" + strings.ToLower(carBase32.EncodeToString(cid))
+}
+
+func makeCARCID(codec uint64, data []byte) []byte {
+	hash := sha256.Sum256(data)
+	cid := make([]byte, 0, 4+len(hash))
+	cid = appendUvarint(cid, 1)
+	cid = appendUvarint(cid, codec)
+	cid = appendUvarint(cid, carHashSHA2256)
+	cid = appendUvarint(cid, uint64(len(hash)))
+	return append(cid, hash[:]...)
+}
+
+func appendUvarint(b []byte, x uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], x)
+	return append(b, buf[:n]...)
+}
+
+// Minimal protobuf encoding, for the dag-pb and UnixFS messages.
+
+func appendPBVarint(b []byte, field int, x uint64) []byte {
+	b = appendUvarint(b, uint64(field)<<3)
+	return appendUvarint(b, x)
+}
+
+func appendPBBytes(b []byte, field int, data []byte) []byte {
+	b = appendUvarint(b, uint64(field)<<3|2)
+	b = appendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// carNode is a node of the DAG, as seen by its parents.
+type carNode struct {
+	cid []byte
+	// tsize is the total size of the serialized node and of all
+	// the nodes below it.
+	tsize uint64
+	// fileSize is the number of file bytes below the node, for
+	// file nodes.
+	fileSize uint64
+}
+
+type carLink struct {
+	name string
+	node carNode
+}
+
+// encodeDagPB encodes a dag-pb node.  As in every other dag-pb
+// encoder, the links come before the data.
+func encodeDagPB(links []carLink, data []byte) []byte {
+	var b []byte
+	for _, l := range links {
+		var pbLink []byte
+		pbLink = appendPBBytes(pbLink, 1, l.node.cid)
+		pbLink = appendPBBytes(pbLink, 2, []byte(l.name))
+		pbLink = appendPBVarint(pbLink, 3, l.node.tsize)
+		b = appendPBBytes(b, 2, pbLink)
+	}
+	return appendPBBytes(b, 1, data)
+}
+
+// carChunker splits a file into chunks, and can tell if there are
+// any chunks left before the next one is taken.
+type carChunker struct {
+	r    io.Reader
+	size int
+	next []byte
+	eof  bool
+	err  error
+}
+
+func (c *carChunker) prepare() {
+	if c.next != nil || c.eof {
+		return
+	}
+	buf := make([]byte, c.size)
+	n, err := io.ReadFull(c.r, buf)
+	switch err {
+	case nil, io.ErrUnexpectedEOF:
+		c.next = buf[:n]
+	case io.EOF:
+		c.eof = true
+	default:
+		c.err = err
+		c.eof = true
+	}
+}
+
+func (c *carChunker) done() bool {
+	c.prepare()
+	return c.next == nil
+}
+
+func (c *carChunker) take() []byte {
+	c.prepare()
+	next := c.next
+	c.next = nil
+	return next
+}
+
+type carExporter struct {
+	fs        *FS
+	chunkSize int
+	maxLinks  int
+	// blocks receives the CAR sections of every block, in the
+	// order they're made.
+	blocks io.Writer
+	seen   map[string]bool
+}
+
+// put writes a block to the archive, unless it's already there.
+func (e *carExporter) put(codec uint64, data []byte) ([]byte, error) {
+	cid := makeCARCID(codec, data)
+	if e.seen[string(cid)] {
+		return cid, nil
+	}
+	e.seen[string(cid)] = true
+	section := appendUvarint(nil, uint64(len(cid)+len(data)))
+	section = append(section, cid...)
+	if _, err := e.blocks.Write(section); err != nil {
+		return nil, err
+	}
+	if _, err := e.blocks.Write(data); err != nil {
+		return nil, err
+	}
+	return cid, nil
+}
+
+func (e *carExporter) putDagPB(links []carLink, unixfsData []byte) (
+	carNode, error) {
+	data := encodeDagPB(links, unixfsData)
+	cid, err := e.put(carCodecDagPB, data)
+	if err != nil {
+		return carNode{}, err
+	}
+	tsize := uint64(len(data))
+	for _, l := range links {
+		tsize += l.node.tsize
+	}
+	return carNode{cid: cid, tsize: tsize}, nil
+}
+
+func (e *carExporter) leaf(chunk []byte) (carNode, error) {
+	cid, err := e.put(carCodecRaw, chunk)
+	if err != nil {
+		return carNode{}, err
+	}
+	size := uint64(len(chunk))
+	return carNode{cid: cid, tsize: size, fileSize: size}, nil
+}
+
+func (e *carExporter) fileNode(children []carNode) (carNode, error) {
+	links := make([]carLink, len(children))
+	var fileSize uint64
+	for i, child := range children {
+		links[i] = carLink{node: child}
+		fileSize += child.fileSize
+	}
+	data := appendPBVarint(nil, 1, unixfsFile)
+	data = appendPBVarint(data, 3, fileSize)
+	for _, child := range children {
+		data = appendPBVarint(data, 4, child.fileSize)
+	}
+	n, err := e.putDagPB(links, data)
+	if err != nil {
+		return carNode{}, err
+	}
+	n.fileSize = fileSize
+	return n, nil
+}
+
+// fillFileNode adds subtrees of the given depth to `children` until
+// the node is full or the file runs out, and returns the new node.
+func (e *carExporter) fillFileNode(
+	children []carNode, ch *carChunker, depth int) (carNode, error) {
+	for len(children) < e.maxLinks && !ch.done() {
+		var child carNode
+		var err error
+		if depth == 1 {
+			child, err = e.leaf(ch.take())
+		} else {
+			child, err = e.fillFileNode(nil, ch, depth-1)
+		}
+		if err != nil {
+			return carNode{}, err
+		}
+		children = append(children, child)
+	}
+	return e.fileNode(children)
+}
+
+func (e *carExporter) exportFile(p string) (carNode, error) {
+	f, err := e.fs.Open(p)
+	if err != nil {
+		return carNode{}, err
+	}
+	defer f.Close()
+
+	ch := &carChunker{r: f, size: e.chunkSize}
+	// A file with at most one chunk is just a raw leaf.
+	root, err := e.leaf(ch.take())
+	if err != nil {
+		return carNode{}, err
+	}
+	// Otherwise, put the tree built so far under a new root, and
+	// fill that root in, one level deeper each time.
+	for depth := 1; !ch.done(); depth++ {
+		root, err = e.fillFileNode([]carNode{root}, ch, depth)
+		if err != nil {
+			return carNode{}, err
+		}
+	}
+	if ch.err != nil {
+		return carNode{}, ch.err
+	}
+	return root, nil
+}
+
+func (e *carExporter) exportSymlink(p string) (carNode, error) {
+	target, err := e.fs.Readlink(p)
+	if err != nil {
+		return carNode{}, err
+	}
+	data := appendPBVarint(nil, 1, unixfsSymlink)
+	data = appendPBBytes(data, 2, []byte(target))
+	return e.putDagPB(nil, data)
+}
+
+func (e *carExporter) exportDir(p string) (carNode, error) {
+	fis, err := e.fs.ReadDir(p)
+	if err != nil {
+		return carNode{}, err
+	}
+	sort.Slice(fis, func(i, j int) bool {
+		return fis[i].Name() < fis[j].Name()
+	})
+	links := make([]carLink, 0, len(fis))
+	for _, fi := range fis {
+		child, err := e.export(path.Join(p, fi.Name()), fi)
+		if err != nil {
+			return carNode{}, err
+		}
+		links = append(links, carLink{name: fi.Name(), node: child})
+	}
+	return e.putDagPB(links, appendPBVarint(nil, 1, unixfsDirectory))
+}
+
+// export adds the entry at path `p` in the FS to the DAG, recursing
+// into directories.
+func (e *carExporter) export(p string, fi os.FileInfo) (carNode, error) {
+	sys, ok := fi.Sys().(fileInfoSys)
+	if !ok {
+		return carNode{}, errors.Errorf("Unexpected file info for %s", p)
+	}
+	switch sys.EntryInfo().Type {
+	case libkbfs.Dir:
+		return e.exportDir(p)
+	case libkbfs.Sym:
+		return e.exportSymlink(p)
+	default:
+		return e.exportFile(p)
+	}
+}
+
+// writeCARHeader writes a CARv1 header naming `root` as the only
+// root.  The header is DAG-CBOR, which is simple enough to write out
+// by hand for this one shape.
+func writeCARHeader(w io.Writer, root []byte) error {
+	cidBytes := append([]byte{0}, root...) // The multibase identity prefix.
+	var header []byte
+	header = append(header, 0xa2) // A map with 2 entries.
+	header = append(header, 0x65)
+	header = append(header, "roots"...)
+	header = append(header, 0x81)       // An array with 1 entry.
+	header = append(header, 0xd8, 0x2a) // Tag 42, for a CID.
+	header = append(header, 0x58, byte(len(cidBytes)))
+	header = append(header, cidBytes...)
+	header = append(header, 0x67)
+	header = append(header, "version"...)
+	header = append(header, 0x01)
+	if _, err := w.Write(appendUvarint(nil, uint64(len(header)))); err != nil {
+		return err
+	}
+	_, err := w.Write(header)
+	return err
+}
+
+func exportCAR(fs *FS, root string, w io.Writer, chunkSize, maxLinks int) (
+	rootCID string, err error) {
+	fi, err := fs.Lstat(root)
+	if err != nil {
+		return "", err
+	}
+
+	// The header, which names the root, comes first, so spool the
+	// blocks until the root is known.
+	spool, err := ioutil.TempFile("", "kbfs_car_export")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		closeErr := spool.Close()
+		if err == nil {
+			err = closeErr
+		}
+		removeErr := os.Remove(spool.Name())
+		if err == nil {
+			err = removeErr
+		}
+	}()
+
+	e := &carExporter{
+		fs:        fs,
+		chunkSize: chunkSize,
+		maxLinks:  maxLinks,
+		blocks:    spool,
+		seen:      make(map[string]bool),
+	}
+	n, err := e.export(path.Clean(root), fi)
+	if err != nil {
+		return "", err
+	}
+
+	if err := writeCARHeader(w, n.cid); err != nil {
+		return "", err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(w, spool); err != nil {
+		return "", err
+	}
+	return carCIDString(n.cid), nil
+}
+
+// ExportCAR writes the subtree rooted at `root` in `fs` to `w` as a
+// CARv1 archive of an IPFS UnixFS DAG, and returns the CID of the
+// DAG's root.  The DAG is laid out like `ipfs add --cid-version=1`
+// would lay it out, so it gets the same CIDs.  Since the archive can
+// only be written once the root is known, the blocks are spooled to
+// a temporary file first.
+func ExportCAR(fs *FS, root string, w io.Writer) (rootCID string, err error) {
+	return exportCAR(fs, root, w, carDefaultChunkSize, carDefaultMaxLinks)
+}
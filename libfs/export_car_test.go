@@ -0,0 +1,257 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/stretchr/testify/require"
+)
+
+// readCAR parses a CARv1 archive, checking that every block matches
+// its CID, and returns the root and the blocks by CID.
+func readCAR(t *testing.T, car []byte) (
+	root string, blocks map[string][]byte, order []string) {
+	r := bufio.NewReader(bytes.NewReader(car))
+	headerLen, err := binary.ReadUvarint(r)
+	require.NoError(t, err)
+	header := make([]byte, headerLen)
+	_, err = io.ReadFull(r, header)
+	require.NoError(t, err)
+	// The root CID sits between the fixed parts of the header.
+	prefix := append([]byte{0xa2, 0x65}, "roots"...)
+	prefix = append(prefix, 0x81, 0xd8, 0x2a, 0x58, 37, 0)
+	require.True(t, bytes.HasPrefix(header, prefix))
+	rootBytes := header[len(prefix) : len(prefix)+36]
+	require.Equal(t, append([]byte{0x67}, "version\x01"...),
+		header[len(prefix)+36:])
+
+	blocks = make(map[string][]byte)
+	for {
+		sectionLen, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		section := make([]byte, sectionLen)
+		_, err = io.ReadFull(r, section)
+		require.NoError(t, err)
+		cid, data := section[:36], section[36:]
+		require.Equal(t, makeCARCID(uint64(cid[1]), data), cid)
+		c := carCIDString(cid)
+		_, dup := blocks[c]
+		require.False(t, dup, "Duplicate block %s", c)
+		blocks[c] = data
+		order = append(order, c)
+	}
+	return carCIDString(rootBytes), blocks, order
+}
+
+type pbField struct {
+	num   int
+	value uint64
+	bytes []byte
+}
+
+func decodePB(t *testing.T, b []byte) (fields []pbField) {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		require.True(t, n > 0)
+		b = b[n:]
+		f := pbField{num: int(key >> 3)}
+		x, n := binary.Uvarint(b)
+		require.True(t, n > 0)
+		b = b[n:]
+		switch key & 7 {
+		case 0:
+			f.value = x
+		case 2:
+			f.bytes, b = b[:x], b[x:]
+		default:
+			t.Fatalf("Unexpected wire type in %d", key)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// carEntry is an entry rebuilt from the exported DAG.
+type carEntry struct {
+	typ      string
+	data     string
+	children map[string]*carEntry
+}
+
+// rebuild walks the DAG below `cid`, checking its sizes, and returns
+// the entry it holds and the DAG's total size.
+func rebuild(t *testing.T, blocks map[string][]byte, cid string) (
+	*carEntry, uint64) {
+	block, ok := blocks[cid]
+	require.True(t, ok, "Missing block %s", cid)
+	if cid[:4] == "bafk" { // A raw leaf.
+		return &carEntry{typ: "file", data: string(block)},
+			uint64(len(block))
+	}
+
+	type link struct {
+		name  string
+		cid   string
+		tsize uint64
+	}
+	var links []link
+	var unixfs []byte
+	for _, f := range decodePB(t, block) {
+		switch f.num {
+		case 1:
+			unixfs = f.bytes
+		case 2:
+			var l link
+			for _, lf := range decodePB(t, f.bytes) {
+				switch lf.num {
+				case 1:
+					l.cid = carCIDString(lf.bytes)
+				case 2:
+					l.name = string(lf.bytes)
+				case 3:
+					l.tsize = lf.value
+				}
+			}
+			links = append(links, l)
+		}
+	}
+
+	tsize := uint64(len(block))
+	children := make(map[string]*carEntry)
+	var data bytes.Buffer
+	for _, l := range links {
+		child, childSize := rebuild(t, blocks, l.cid)
+		require.Equal(t, l.tsize, childSize)
+		tsize += childSize
+		children[l.name] = child
+		data.WriteString(child.data)
+	}
+
+	e := &carEntry{}
+	var fileSize uint64
+	var blockSizes []uint64
+	for _, f := range decodePB(t, unixfs) {
+		switch f.num {
+		case 1:
+			switch f.value {
+			case unixfsDirectory:
+				e.typ = "dir"
+				e.children = children
+			case unixfsFile:
+				e.typ = "file"
+				e.data = data.String()
+			case unixfsSymlink:
+				e.typ = "sym"
+			}
+		case 2:
+			e.data = string(f.bytes)
+		case 3:
+			fileSize = f.value
+		case 4:
+			blockSizes = append(blockSizes, f.value)
+		}
+	}
+	if e.typ == "file" {
+		require.Equal(t, uint64(len(e.data)), fileSize)
+		require.Len(t, blockSizes, len(links))
+	}
+	return e, tsize
+}
+
+func TestExportCAREmptyDir(t *testing.T) {
+	ctx, _, fs := makeFS(t, "")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, fs.config)
+
+	err := fs.MkdirAll("a", 0755)
+	require.NoError(t, err)
+	var car bytes.Buffer
+	root, err := ExportCAR(fs, "a", &car)
+	require.NoError(t, err)
+
+	// The well-known CID of an empty UnixFS directory.
+	require.Equal(t,
+		"bafybeiczsscdsbs7ffqz55asqdf3smv6klcw3gofszvwlyarci47bgf354", root)
+	carRoot, blocks, _ := readCAR(t, car.Bytes())
+	require.Equal(t, root, carRoot)
+	require.Len(t, blocks, 1)
+}
+
+func TestExportCAR(t *testing.T) {
+	ctx, _, fs := makeFS(t, "")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, fs.config)
+
+	writeFile := func(name, data string) {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(data))
+		require.NoError(t, err)
+		err = f.Close()
+		require.NoError(t, err)
+	}
+	err := fs.MkdirAll("a/b", 0755)
+	require.NoError(t, err)
+	writeFile("a/hello", "hello world")
+	writeFile("a/b/hello", "hello world")
+	writeFile("a/b/empty", "")
+	// With 4-byte chunks and 3 links per node, this takes a tree
+	// three levels deep.
+	long := "0123456789abcdefghijklmnopqrstuvwxyz0123456789"
+	writeFile("a/b/long", long)
+	writeFile("a/b/exact", "01234567")
+	err = fs.Symlink("b/long", "a/link")
+	require.NoError(t, err)
+	err = fs.SyncAll()
+	require.NoError(t, err)
+
+	var car bytes.Buffer
+	root, err := exportCAR(fs, "a", &car, 4, 3)
+	require.NoError(t, err)
+	carRoot, blocks, order := readCAR(t, car.Bytes())
+	require.Equal(t, root, carRoot)
+
+	t.Log("Every block comes before its parent")
+	require.Equal(t, root, order[len(order)-1])
+
+	a, _ := rebuild(t, blocks, root)
+	require.Equal(t, "dir", a.typ)
+	require.Len(t, a.children, 3)
+	require.Equal(t, &carEntry{typ: "file", data: "hello world"},
+		a.children["hello"])
+	require.Equal(t, &carEntry{typ: "sym", data: "b/long"},
+		a.children["link"])
+	b := a.children["b"]
+	require.Equal(t, "dir", b.typ)
+	require.Len(t, b.children, 4)
+	require.Equal(t, "", b.children["empty"].data)
+	require.Equal(t, long, b.children["long"].data)
+	require.Equal(t, "01234567", b.children["exact"].data)
+
+	t.Log("Small files are single raw leaves")
+	var hello bytes.Buffer
+	helloCID, err := exportCAR(fs, "a/hello", &hello, 4, 3)
+	require.NoError(t, err)
+	_, helloBlocks, _ := readCAR(t, hello.Bytes())
+	// "hell", "o wo", "rld" and their parent.
+	require.Len(t, helloBlocks, 4)
+	helloCID, err = ExportCAR(fs, "a/hello", &hello)
+	require.NoError(t, err)
+	require.Equal(t,
+		"bafkreifzjut3te2nhyekklss27nh3k72ysco7y32koao5eei66wof36n5e",
+		helloCID)
+	emptyCID, err := ExportCAR(fs, "a/b/empty", &hello)
+	require.NoError(t, err)
+	require.Equal(t,
+		"bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku",
+		emptyCID)
+}
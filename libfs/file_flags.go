@@ -0,0 +1,103 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+)
+
+const (
+	// fileFlagsCacheTime is how long the flags of a TLF are used
+	// before the flags file is read again.
+	fileFlagsCacheTime = 10 * time.Second
+	// maxFileFlagsSize caps how much of a flags file is read.
+	maxFileFlagsSize = 4 * 1024
+)
+
+// FileFlagsCache remembers the file flags of a single TLF for a short
+// while, so they can be applied to every attribute request without
+// reading the flags file each time.  The zero value is ready to use.
+type FileFlagsCache struct {
+	lock    sync.Mutex
+	flags   libkbfs.FileFlags
+	fetched time.Time
+}
+
+// Get returns the file flags of the TLF described by `h`.  Errors
+// are only logged, and mean no flags apply, so that a missing or
+// malformed flags file never makes files inaccessible.
+func (ffc *FileFlagsCache) Get(
+	ctx context.Context, config libkbfs.Config, h *libkbfs.TlfHandle,
+	branch libkbfs.BranchName) libkbfs.FileFlags {
+	now := config.Clock().Now()
+	ffc.lock.Lock()
+	defer ffc.lock.Unlock()
+	if !ffc.fetched.IsZero() && now.Sub(ffc.fetched) < fileFlagsCacheTime {
+		return ffc.flags
+	}
+
+	flags, err := readFileFlags(ctx, config, h, branch)
+	if err != nil {
+		config.MakeLogger("").CDebugf(
+			ctx, "Ignoring %s: %+v", libkbfs.FileFlagsFileName, err)
+	}
+	ffc.flags = flags
+	ffc.fetched = now
+	return flags
+}
+
+func readFileFlags(
+	ctx context.Context, config libkbfs.Config, h *libkbfs.TlfHandle,
+	branch libkbfs.BranchName) (libkbfs.FileFlags, error) {
+	kbfsOps := config.KBFSOps()
+	rootNode, _, err := kbfsOps.GetRootNode(ctx, h, branch)
+	if err != nil {
+		return libkbfs.FileFlags{}, err
+	}
+	if rootNode == nil {
+		return libkbfs.FileFlags{}, nil
+	}
+	// Invalid settings shouldn't keep the flags file from applying.
+	settings, err := libkbfs.ReadTlfSettings(ctx, kbfsOps, rootNode)
+	if _, ok := errors.Cause(err).(libkbfs.InvalidTlfSettingsError); ok {
+		settings = libkbfs.TlfSettings{}
+	} else if err != nil {
+		return libkbfs.FileFlags{}, err
+	}
+	settingsFlags := settings.GetFileFlags()
+
+	node, ei, err := kbfsOps.Lookup(ctx, rootNode, libkbfs.FileFlagsFileName)
+	if _, ok := errors.Cause(err).(libkbfs.NoSuchNameError); ok {
+		return settingsFlags, nil
+	} else if err != nil {
+		return libkbfs.FileFlags{}, err
+	}
+	if ei.Type == libkbfs.Dir || ei.Type == libkbfs.Sym {
+		return settingsFlags, nil
+	}
+
+	size := ei.Size
+	if size > maxFileFlagsSize {
+		size = maxFileFlagsSize
+	}
+	buf := make([]byte, size)
+	n, err := kbfsOps.Read(libkbfs.CtxSkipRecentFiles(ctx), node, buf, 0)
+	if err != nil {
+		return libkbfs.FileFlags{}, err
+	}
+	flags, err := libkbfs.ParseFileFlags(buf[:n])
+	if err != nil {
+		return libkbfs.FileFlags{}, err
+	}
+	return libkbfs.FileFlags{
+		ReadOnly: flags.ReadOnly || settingsFlags.ReadOnly,
+		Exec:     flags.Exec || settingsFlags.Exec,
+	}, nil
+}
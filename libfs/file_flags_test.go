@@ -0,0 +1,51 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileFlagsCache(t *testing.T) {
+	ctx, h, fs := makeFS(t, "")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, fs.config)
+
+	clock := &libkbfs.TestClock{}
+	clock.Set(time.Now())
+	fs.config.SetClock(clock)
+
+	var ffc FileFlagsCache
+	require.Equal(t, libkbfs.FileFlags{},
+		ffc.Get(ctx, fs.config, h, libkbfs.MasterBranch))
+
+	writeFlags := func(data string) {
+		f, err := fs.OpenFile(
+			libkbfs.FileFlagsFileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(data))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		require.NoError(t, fs.SyncAll())
+	}
+	writeFlags("readonly\n")
+
+	t.Log("The old flags are used until the cache expires")
+	require.Equal(t, libkbfs.FileFlags{},
+		ffc.Get(ctx, fs.config, h, libkbfs.MasterBranch))
+	clock.Add(fileFlagsCacheTime)
+	require.Equal(t, libkbfs.FileFlags{ReadOnly: true},
+		ffc.Get(ctx, fs.config, h, libkbfs.MasterBranch))
+
+	t.Log("A malformed file means no flags")
+	writeFlags("readonly\nbogus\n")
+	clock.Add(fileFlagsCacheTime)
+	require.Equal(t, libkbfs.FileFlags{},
+		ffc.Get(ctx, fs.config, h, libkbfs.MasterBranch))
+}
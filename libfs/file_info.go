@@ -9,7 +9,9 @@ import (
 	"time"
 
 	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
 )
 
 // FileInfo is a wrapper around libkbfs.EntryInfo that implements the
@@ -78,6 +80,12 @@ type PrevRevisionsGetter interface {
 	PrevRevisions() libkbfs.PrevRevisions
 }
 
+// BlockIDGetter is an interface for something that can return the ID
+// of the top block of an entry's contents.
+type BlockIDGetter interface {
+	BlockID() (kbfsblock.ID, error)
+}
+
 type fileInfoSys struct {
 	fi *FileInfo
 }
@@ -124,6 +132,20 @@ func (fis fileInfoSys) PrevRevisions() (revs libkbfs.PrevRevisions) {
 	return fis.fi.ei.PrevRevisions
 }
 
+var _ BlockIDGetter = fileInfoSys{}
+
+func (fis fileInfoSys) BlockID() (kbfsblock.ID, error) {
+	if fis.fi.node == nil {
+		return kbfsblock.ID{}, errors.New("No node for entry")
+	}
+	md, err := fis.fi.fs.config.KBFSOps().GetNodeMetadata(
+		fis.fi.fs.ctx, fis.fi.node)
+	if err != nil {
+		return kbfsblock.ID{}, err
+	}
+	return md.BlockInfo.ID, nil
+}
+
 func (fis fileInfoSys) EntryInfo() libkbfs.EntryInfo {
 	return fis.fi.ei
 }
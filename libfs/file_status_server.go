@@ -0,0 +1,369 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+)
+
+const (
+	// FileStatusSocketName is the name of the unix socket, in the
+	// KBFS runtime directory, on which file status queries are
+	// answered.
+	FileStatusSocketName = "kbfs.filestatus.sock"
+
+	// folderSyncStateCacheTime is how long the sync state of a
+	// folder is reused for before it is recomputed.  Editors tend
+	// to ask about many files of the same folder at once.
+	folderSyncStateCacheTime = time.Second
+	// fileStatusTimeout bounds how long a single query may take,
+	// e.g. when it is the first one to touch a folder.
+	fileStatusTimeout = 10 * time.Second
+	// incompleteUnflushedPath matches the marker that the journal
+	// adds to its unflushed paths when it hasn't finished listing
+	// them.
+	incompleteUnflushedPath = "..."
+
+	// Debug tag ID for an individual file status query
+	ctxFileStatusOpID = "FSID"
+)
+
+type ctxFileStatusTagKey int
+
+const (
+	ctxFileStatusIDKey ctxFileStatusTagKey = iota
+)
+
+// FileStatus is the answer to a file status query.
+type FileStatus struct {
+	// InKBFS is true if the path is within a KBFS folder.  None of
+	// the other fields are set otherwise.
+	InKBFS bool
+	// Exists is true if the path names an existing file or
+	// directory.
+	Exists bool
+	// Synced is true if all local changes to the path have been
+	// flushed to the server.
+	Synced bool
+	// LastWriter is the user who last modified the path.
+	LastWriter string `json:",omitempty"`
+	// Conflicted is true if the path has local changes that
+	// conflict with the server's, or is in a conflicted folder.
+	Conflicted bool
+	// Error describes why the status couldn't be found, if it
+	// couldn't.
+	Error string `json:",omitempty"`
+}
+
+// folderSyncState is a summary of the status of a folder, indexed
+// by the paths within it.
+type folderSyncState struct {
+	// unsynced holds the dirty and unflushed paths.
+	unsynced map[string]bool
+	// allUnsynced is set if the journal didn't list all of its
+	// unflushed paths.
+	allUnsynced bool
+	// conflicted holds the paths with unmerged changes.
+	conflicted map[string]bool
+	computed   time.Time
+}
+
+// FileStatusServer answers lightweight status queries about local
+// paths, for editor and IDE plugins that want to annotate files
+// without making full RPCs.  A client writes one path per line to
+// the socket, and gets back one line of JSON-encoded FileStatus for
+// each.  Folder handles and sync states are cached in memory, so
+// that repeated queries about the same folder don't need to touch
+// the rest of KBFS.
+type FileStatusServer struct {
+	config     libkbfs.Config
+	mountPoint string
+	log        logger.Logger
+
+	lock       sync.Mutex
+	handles    map[string]*libkbfs.TlfHandle
+	syncStates map[tlf.ID]folderSyncState
+	listener   net.Listener
+}
+
+// NewFileStatusServer returns a new FileStatusServer for paths
+// under `mountPoint`.  Canonical paths, under /keybase, are answered
+// as well.
+func NewFileStatusServer(
+	config libkbfs.Config, mountPoint string) *FileStatusServer {
+	return &FileStatusServer{
+		config:     config,
+		mountPoint: filepath.Clean(mountPoint),
+		log:        config.MakeLogger("FSS"),
+		handles:    make(map[string]*libkbfs.TlfHandle),
+		syncStates: make(map[tlf.ID]folderSyncState),
+	}
+}
+
+// splitPath returns the folder list, TLF name, and the path within
+// the TLF of `p`, or ok=false if `p` isn't in a TLF.
+func (fss *FileStatusServer) splitPath(p string) (
+	tlfType tlf.Type, tlfName, rest string, ok bool) {
+	p = filepath.Clean(p)
+	var rel string
+	switch {
+	case fss.mountPoint != "." && strings.HasPrefix(
+		p, fss.mountPoint+string(filepath.Separator)):
+		rel = strings.TrimPrefix(p, fss.mountPoint)
+	case strings.HasPrefix(p, "/keybase/"):
+		rel = strings.TrimPrefix(p, "/keybase")
+	default:
+		return tlf.Unknown, "", "", false
+	}
+	parts := strings.SplitN(
+		strings.TrimPrefix(filepath.ToSlash(rel), "/"), "/", 3)
+	if len(parts) < 2 {
+		return tlf.Unknown, "", "", false
+	}
+	tlfType, err := tlf.ParseTlfTypeFromPath(parts[0])
+	if err != nil {
+		return tlf.Unknown, "", "", false
+	}
+	if len(parts) == 3 {
+		rest = parts[2]
+	}
+	return tlfType, parts[1], rest, true
+}
+
+func (fss *FileStatusServer) getHandle(
+	ctx context.Context, tlfType tlf.Type, tlfName string) (
+	*libkbfs.TlfHandle, error) {
+	key := tlfType.String() + "/" + tlfName
+	fss.lock.Lock()
+	h, ok := fss.handles[key]
+	fss.lock.Unlock()
+	if ok {
+		return h, nil
+	}
+
+	h, err := libkbfs.GetHandleFromFolderNameAndType(
+		ctx, fss.config.KBPKI(), fss.config.MDOps(), tlfName, tlfType)
+	if err != nil {
+		return nil, err
+	}
+	fss.lock.Lock()
+	defer fss.lock.Unlock()
+	fss.handles[key] = h
+	return h, nil
+}
+
+// pathInTlf strips the first `n` components of `p`, which should
+// leave the path within the TLF.
+func pathInTlf(p string, n int) string {
+	parts := strings.SplitN(strings.TrimPrefix(p, "/"), "/", n+1)
+	if len(parts) <= n {
+		return ""
+	}
+	return parts[n]
+}
+
+func (fss *FileStatusServer) getSyncState(
+	ctx context.Context, fb libkbfs.FolderBranch) (folderSyncState, error) {
+	now := fss.config.Clock().Now()
+	fss.lock.Lock()
+	state, ok := fss.syncStates[fb.Tlf]
+	fss.lock.Unlock()
+	if ok && now.Sub(state.computed) < folderSyncStateCacheTime {
+		return state, nil
+	}
+
+	status, _, err := fss.config.KBFSOps().FolderStatus(ctx, fb)
+	if err != nil {
+		return folderSyncState{}, err
+	}
+	state = folderSyncState{
+		unsynced:   make(map[string]bool),
+		conflicted: make(map[string]bool),
+		computed:   now,
+	}
+	// Dirty paths start with the TLF name.
+	for _, p := range status.DirtyPaths {
+		state.unsynced[pathInTlf(p, 1)] = true
+	}
+	// Unflushed paths are canonical, like /keybase/private/name/x.
+	if status.Journal != nil {
+		for _, p := range status.Journal.UnflushedPaths {
+			if p == incompleteUnflushedPath {
+				state.allUnsynced = true
+				continue
+			}
+			state.unsynced[pathInTlf(p, 3)] = true
+		}
+	}
+	if status.Staged {
+		for _, summary := range status.Unmerged {
+			state.conflicted[pathInTlf(summary.Path, 1)] = true
+		}
+	}
+
+	fss.lock.Lock()
+	defer fss.lock.Unlock()
+	fss.syncStates[fb.Tlf] = state
+	return state, nil
+}
+
+func (fss *FileStatusServer) lookup(
+	ctx context.Context, h *libkbfs.TlfHandle, rest string) (
+	libkbfs.Node, error) {
+	node, _, err := fss.config.KBFSOps().GetRootNode(
+		ctx, h, libkbfs.MasterBranch)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, nil
+	}
+	for _, name := range strings.Split(rest, "/") {
+		if name == "" {
+			continue
+		}
+		node, _, err = fss.config.KBFSOps().Lookup(ctx, node, name)
+		if _, ok := errors.Cause(err).(libkbfs.NoSuchNameError); ok {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// Status returns the status of the local path `p`.
+func (fss *FileStatusServer) Status(
+	ctx context.Context, p string) (status FileStatus) {
+	tlfType, tlfName, rest, ok := fss.splitPath(p)
+	if !ok {
+		return FileStatus{}
+	}
+	status.InKBFS = true
+	defer func() {
+		if status.Error != "" {
+			fss.log.CDebugf(ctx, "Couldn't get the status of %s: %s",
+				p, status.Error)
+		}
+	}()
+
+	h, err := fss.getHandle(ctx, tlfType, tlfName)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	node, err := fss.lookup(ctx, h, rest)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	if node == nil {
+		return status
+	}
+	status.Exists = true
+
+	md, err := fss.config.KBFSOps().GetNodeMetadata(ctx, node)
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.LastWriter = md.LastWriterUnverified.String()
+
+	state, err := fss.getSyncState(ctx, node.GetFolderBranch())
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	rest = path.Clean("/" + rest)[1:]
+	status.Synced = !state.allUnsynced && !state.unsynced[rest]
+	status.Conflicted = h.IsConflict() || state.conflicted[rest]
+	return status
+}
+
+func (fss *FileStatusServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	encoder := json.NewEncoder(conn)
+	for scanner.Scan() {
+		ctx, cancel := context.WithTimeout(
+			libkbfs.CtxWithRandomIDReplayable(
+				context.Background(), ctxFileStatusIDKey, ctxFileStatusOpID,
+				fss.log),
+			fileStatusTimeout)
+		status := fss.Status(ctx, scanner.Text())
+		cancel()
+		err := encoder.Encode(status)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Serve answers queries from connections to `l`, until `l` is
+// closed.
+func (fss *FileStatusServer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go fss.serveConn(conn)
+	}
+}
+
+// ListenAndServe answers queries on a new unix socket at
+// `socketPath`, replacing any stale one, in the background.  The
+// socket is only accessible by the current user.
+func (fss *FileStatusServer) ListenAndServe(socketPath string) error {
+	err := os.Remove(socketPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(socketPath, 0600)
+	if err != nil {
+		l.Close()
+		return err
+	}
+
+	fss.lock.Lock()
+	fss.listener = l
+	fss.lock.Unlock()
+	go func() {
+		err := fss.Serve(l)
+		fss.log.CDebugf(
+			context.Background(), "Stopped serving file status: %+v", err)
+	}()
+	return nil
+}
+
+// Shutdown stops serving queries.
+func (fss *FileStatusServer) Shutdown() error {
+	fss.lock.Lock()
+	defer fss.lock.Unlock()
+	if fss.listener == nil {
+		return nil
+	}
+	err := fss.listener.Close()
+	fss.listener = nil
+	return err
+}
@@ -0,0 +1,89 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStatusServer(t *testing.T) {
+	ctx, _, fs, shutdown := makeFSWithJournal(t, "")
+	defer shutdown()
+
+	clock := &libkbfs.TestClock{}
+	clock.Set(time.Now())
+	fs.config.SetClock(clock)
+
+	fss := NewFileStatusServer(fs.config, "/mnt/kb")
+	require.Equal(t, FileStatus{}, fss.Status(ctx, "/home/user1/foo"))
+	require.Equal(t, FileStatus{}, fss.Status(ctx, "/mnt/kb/private"))
+
+	t.Log("A missing file")
+	require.Equal(t, FileStatus{InKBFS: true},
+		fss.Status(ctx, "/mnt/kb/private/user1/foo"))
+
+	t.Log("A dirty file")
+	f, err := fs.Create("foo")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+	dirty := FileStatus{InKBFS: true, Exists: true, LastWriter: "user1"}
+	require.Equal(t, dirty, fss.Status(ctx, "/mnt/kb/private/user1/foo"))
+	require.Equal(t, dirty, fss.Status(ctx, "/keybase/private/user1/foo"))
+
+	t.Log("A synced file that is still in the journal")
+	err = f.Close()
+	require.NoError(t, err)
+	err = fs.SyncAll()
+	require.NoError(t, err)
+	clock.Add(folderSyncStateCacheTime)
+	require.Equal(t, dirty, fss.Status(ctx, "/mnt/kb/private/user1/foo"))
+
+	t.Log("A flushed file")
+	jServer, err := libkbfs.GetJournalServer(fs.config)
+	require.NoError(t, err)
+	err = jServer.FinishSingleOp(ctx,
+		fs.root.GetFolderBranch().Tlf, nil, keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+	clock.Add(folderSyncStateCacheTime)
+	synced := FileStatus{
+		InKBFS: true, Exists: true, Synced: true, LastWriter: "user1"}
+	require.Equal(t, synced, fss.Status(ctx, "/mnt/kb/private/user1/foo"))
+
+	t.Log("Queries over the socket")
+	tempdir, err := ioutil.TempDir(os.TempDir(), "file_status_server")
+	require.NoError(t, err)
+	defer ioutil.RemoveAll(tempdir)
+	socketPath := filepath.Join(tempdir, FileStatusSocketName)
+	err = fss.ListenAndServe(socketPath)
+	require.NoError(t, err)
+	defer fss.Shutdown()
+
+	conn, err := net.Dial("unix", socketPath)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte(
+		"/mnt/kb/private/user1/foo\n/home/user1/foo\n"))
+	require.NoError(t, err)
+	scanner := bufio.NewScanner(conn)
+	for _, expected := range []FileStatus{synced, {}} {
+		require.True(t, scanner.Scan())
+		var status FileStatus
+		err = json.Unmarshal(scanner.Bytes(), &status)
+		require.NoError(t, err)
+		require.Equal(t, expected, status)
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// GetEncodedFolderStats returns the JSON-encoded aggregate stats of a
+// TLF.
+func GetEncodedFolderStats(
+	ctx context.Context, config libkbfs.Config,
+	folderBranch libkbfs.FolderBranch) (
+	data []byte, t time.Time, err error) {
+	stats, err := config.KBFSOps().GetFolderStats(ctx, folderBranch)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	data, err = PrettyJSON(stats)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return data, time.Time{}, nil
+}
@@ -572,6 +572,25 @@ func (fs *FS) Remove(filename string) (err error) {
 	return fs.config.KBFSOps().RemoveEntry(fs.ctx, parent, base)
 }
 
+// RemoveTree removes the given file, or the given directory and
+// everything under it, in a few batches.  `progress`, if non-nil, is
+// called after each batch.
+func (fs *FS) RemoveTree(
+	filename string, progress func(libkbfs.RemoveTreeProgress)) (err error) {
+	fs.log.CDebugf(fs.ctx, "RemoveTree %s", filename)
+	defer func() {
+		fs.deferLog.CDebugf(fs.ctx, "RemoveTree done: %+v", err)
+		err = translateErr(err)
+	}()
+
+	parent, _, base, err := fs.lookupParent(filename)
+	if err != nil {
+		return err
+	}
+
+	return fs.config.KBFSOps().RemoveTree(fs.ctx, parent, base, progress)
+}
+
 // Join implements the billy.Filesystem interface for FS.
 func (fs *FS) Join(elem ...string) string {
 	return path.Clean(path.Join(elem...))
@@ -593,27 +612,39 @@ func (fs *FS) TempFile(dir, prefix string) (billy.File, error) {
 		os.O_CREATE|os.O_EXCL, 0600)
 }
 
-func (fs *FS) readDir(n libkbfs.Node) (fis []os.FileInfo, err error) {
-	children, err := fs.config.KBFSOps().GetDirChildren(fs.ctx, n)
-	if err != nil {
-		return nil, err
-	}
+// readDirPageSize is the number of entries readDir fetches at a time.
+const readDirPageSize = 1000
 
-	fis = make([]os.FileInfo, 0, len(children))
-	for name, ei := range children {
-		child, _, err := fs.config.KBFSOps().Lookup(fs.ctx, n, name)
+// readDir returns the entries of the directory sorted by name, like
+// ioutil.ReadDir, so that the order is the same every time.
+func (fs *FS) readDir(n libkbfs.Node) (fis []os.FileInfo, err error) {
+	fis = []os.FileInfo{}
+	after := ""
+	for {
+		children, err := fs.config.KBFSOps().GetDirChildrenPage(
+			fs.ctx, n, after, readDirPageSize)
 		if err != nil {
 			return nil, err
 		}
 
-		fis = append(fis, &FileInfo{
-			fs:   fs,
-			ei:   ei,
-			node: child,
-			name: name,
-		})
+		for _, c := range children {
+			child, _, err := fs.config.KBFSOps().Lookup(fs.ctx, n, c.Name)
+			if err != nil {
+				return nil, err
+			}
+
+			fis = append(fis, &FileInfo{
+				fs:   fs,
+				ei:   c.EntryInfo,
+				node: child,
+				name: c.Name,
+			})
+		}
+		if len(children) < readDirPageSize {
+			return fis, nil
+		}
+		after = children[len(children)-1].Name
 	}
-	return fis, nil
 }
 
 // ReadDir implements the billy.Filesystem interface for FS.
@@ -5,10 +5,14 @@
 package libfs
 
 import (
+	"archive/tar"
 	"bytes"
 	"context"
+	"encoding/json"
+	"io"
 	"os"
 	"path"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -115,6 +119,43 @@ func testCreateFile(
 	require.NoError(t, err)
 }
 
+// writeTestFile writes `data` to the file at `p` in `fs`.
+func writeTestFile(t *testing.T, fs *FS, p, data string) {
+	f, err := fs.Create(p)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.Write([]byte(data))
+	require.NoError(t, err)
+}
+
+// readTestFile returns the contents of the file at `p` in `fs`.
+func readTestFile(t *testing.T, fs *FS, p string) string {
+	f, err := fs.Open(p)
+	require.NoError(t, err)
+	defer f.Close()
+	buf, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	return string(buf)
+}
+
+// writeLocalTestFile writes `data` to the local file at the
+// slash-separated path `rel` under `dir`, with a modification time
+// far enough in the past that the file looks settled.
+func writeLocalTestFile(t *testing.T, dir, rel, data string) {
+	p := filepath.Join(dir, filepath.FromSlash(rel))
+	require.NoError(t, ioutil.MkdirAll(filepath.Dir(p), 0700))
+	require.NoError(t, ioutil.WriteFile(p, []byte(data), 0600))
+	past := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(p, past, past))
+}
+
+// readLocalTestFile returns the contents of the local file at `p`.
+func readLocalTestFile(t *testing.T, p string) string {
+	buf, err := ioutil.ReadFile(p)
+	require.NoError(t, err)
+	return string(buf)
+}
+
 func TestCreateFileInRoot(t *testing.T) {
 	ctx, h, fs := makeFS(t, "")
 	defer libkbfs.CheckConfigAndShutdown(ctx, t, fs.config)
@@ -398,6 +439,27 @@ func TestReadDir(t *testing.T) {
 	require.Len(t, expectedNames, 0)
 }
 
+func TestReadDirSorted(t *testing.T) {
+	ctx, _, fs := makeFS(t, "")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, fs.config)
+
+	names := []string{"m", "b", "z", "a", "c"}
+	for _, name := range names {
+		f, err := fs.Create(name)
+		require.NoError(t, err)
+		err = f.Close()
+		require.NoError(t, err)
+	}
+
+	fis, err := fs.ReadDir("")
+	require.NoError(t, err)
+	var readNames []string
+	for _, fi := range fis {
+		readNames = append(readNames, fi.Name())
+	}
+	require.Equal(t, []string{"a", "b", "c", "m", "z"}, readNames)
+}
+
 func TestMkdirAll(t *testing.T) {
 	ctx, _, fs := makeFS(t, "")
 	defer libkbfs.CheckConfigAndShutdown(ctx, t, fs.config)
@@ -701,3 +763,134 @@ func TestArchivedByRevision(t *testing.T) {
 	require.NoError(t, err)
 	require.Len(t, fis, 0)
 }
+
+func TestExport(t *testing.T) {
+	ctx, _, fs := makeFS(t, "")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, fs.config)
+
+	err := fs.MkdirAll("a/b", 0755)
+	require.NoError(t, err)
+	f, err := fs.Create("a/b/run.sh")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("#!/bin/sh\n"))
+	require.NoError(t, err)
+	err = f.Close()
+	require.NoError(t, err)
+	err = fs.Chmod("a/b/run.sh", 0755)
+	require.NoError(t, err)
+	mtime := time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)
+	err = fs.Chtimes("a/b/run.sh", time.Now(), mtime)
+	require.NoError(t, err)
+	err = fs.Symlink("b/run.sh", "a/link")
+	require.NoError(t, err)
+	err = fs.SyncAll()
+	require.NoError(t, err)
+
+	var archive, manifest bytes.Buffer
+	err = Export(fs, "a", &archive, &manifest)
+	require.NoError(t, err)
+
+	tr := tar.NewReader(&archive)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+		switch hdr.Name {
+		case "b/run.sh":
+			require.Equal(t, int64(0755), hdr.Mode)
+			require.True(t, mtime.Equal(hdr.ModTime))
+			data, err := ioutil.ReadAll(tr)
+			require.NoError(t, err)
+			require.Equal(t, "#!/bin/sh\n", string(data))
+		case "link":
+			require.Equal(t, byte(tar.TypeSymlink), hdr.Typeflag)
+			require.Equal(t, "b/run.sh", hdr.Linkname)
+		}
+	}
+	require.Equal(t, []string{"b/", "b/run.sh", "link"}, names)
+
+	var m ExportManifest
+	err = json.Unmarshal(manifest.Bytes(), &m)
+	require.NoError(t, err)
+	require.Equal(t, "/keybase/private/user1/a", m.Folder)
+	require.Len(t, m.Entries, 3)
+	require.Equal(t, "user1", m.Entries[1].LastWriter)
+}
+
+func TestExportWithKeys(t *testing.T) {
+	ctx, _, fs := makeFS(t, "")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, fs.config)
+
+	err := fs.MkdirAll("a/b", 0755)
+	require.NoError(t, err)
+	f, err := fs.OpenFile("a/b/run.sh", os.O_CREATE|os.O_WRONLY, 0755)
+	require.NoError(t, err)
+	// Span a few chunks of the sealed bundle.
+	data := bytes.Repeat([]byte("#!/bin/sh\n"), 20000)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	err = f.Close()
+	require.NoError(t, err)
+	mtime := time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)
+	err = fs.Chtimes("a/b/run.sh", time.Now(), mtime)
+	require.NoError(t, err)
+	err = fs.Symlink("b/run.sh", "a/link")
+	require.NoError(t, err)
+	err = fs.SyncAll()
+	require.NoError(t, err)
+
+	passphrase := []byte("correct horse battery staple")
+	var bundle bytes.Buffer
+	err = ExportWithKeys(fs, "a", passphrase, &bundle)
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(bundle.Bytes(), data[:100]))
+
+	t.Log("The wrong passphrase can't open the bundle")
+	dest, err := fs.ChrootAsLibFS("restored")
+	require.Error(t, err)
+	err = fs.MkdirAll("restored", 0755)
+	require.NoError(t, err)
+	dest, err = fs.ChrootAsLibFS("restored")
+	require.NoError(t, err)
+	_, _, err = ImportEscrowBundle(
+		bytes.NewReader(bundle.Bytes()), []byte("wrong"), dest)
+	require.Equal(t, errEscrowBadBundle, err)
+
+	t.Log("Nor can a truncated one be opened")
+	truncated := bundle.Bytes()[:bundle.Len()-10]
+	_, _, err = ImportEscrowBundle(
+		bytes.NewReader(truncated), passphrase, dest)
+	require.Error(t, err)
+
+	keys, manifest, err := ImportEscrowBundle(
+		bytes.NewReader(bundle.Bytes()), passphrase, dest)
+	require.NoError(t, err)
+	expectedKeys, tlfID, err := fs.config.KBFSOps().GetTLFCryptKeys(ctx, fs.h)
+	require.NoError(t, err)
+	require.Equal(t, tlfID, keys.TlfID)
+	require.Equal(t, expectedKeys, keys.Keys)
+	keysOnly, err := ReadEscrowKeys(
+		bytes.NewReader(bundle.Bytes()), passphrase)
+	require.NoError(t, err)
+	require.Equal(t, keys, keysOnly)
+	require.Equal(t, "/keybase/private/user1/a", manifest.Folder)
+	require.Len(t, manifest.Entries, 3)
+
+	fi, err := dest.Stat("b/run.sh")
+	require.NoError(t, err)
+	require.NotZero(t, fi.Mode()&0100)
+	require.True(t, mtime.Equal(fi.ModTime()))
+	f, err = dest.Open("b/run.sh")
+	require.NoError(t, err)
+	defer f.Close()
+	restored, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	require.Equal(t, data, restored)
+	target, err := dest.Readlink("link")
+	require.NoError(t, err)
+	require.Equal(t, "b/run.sh", target)
+}
@@ -0,0 +1,113 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// FsyncMode says what an fsync waits for before returning, so that
+// applications like databases can pick how durable their writes
+// are.
+type FsyncMode int
+
+const (
+	// FsyncJournal waits until the writes are durable in the local
+	// journal of the TLF.  They reach the servers later, in the
+	// background.
+	FsyncJournal FsyncMode = iota
+	// FsyncServer waits until the writes have been flushed to the
+	// servers.
+	FsyncServer
+)
+
+// String implements the fmt.Stringer interface for FsyncMode.
+func (m FsyncMode) String() string {
+	switch m {
+	case FsyncJournal:
+		return "journal"
+	case FsyncServer:
+		return "server"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseFsyncMode parses the name of a mode, as returned by
+// FsyncMode.String.
+func ParseFsyncMode(s string) (FsyncMode, error) {
+	for _, m := range []FsyncMode{FsyncJournal, FsyncServer} {
+		if s == m.String() {
+			return m, nil
+		}
+	}
+	return FsyncJournal, errors.Errorf(
+		"Unknown fsync mode %q; must be journal or server", s)
+}
+
+// EffectiveFsyncMode returns what an fsync in the given TLF really
+// waits for under `mode`.  Without a journal, writes always go
+// straight to the servers.
+func EffectiveFsyncMode(
+	config libkbfs.Config, tlfID tlf.ID, mode FsyncMode) FsyncMode {
+	if !libkbfs.TLFJournalEnabled(config, tlfID) {
+		return FsyncServer
+	}
+	return mode
+}
+
+// Fsync syncs all the dirty data of the given folder branch, and
+// waits for it to be as durable as `mode` says.
+func Fsync(ctx context.Context, config libkbfs.Config,
+	folderBranch libkbfs.FolderBranch, mode FsyncMode) error {
+	err := config.KBFSOps().SyncAll(ctx, folderBranch)
+	if err != nil {
+		return err
+	}
+	if mode != FsyncServer {
+		return nil
+	}
+	jServer, err := libkbfs.GetJournalServer(config)
+	if err != nil {
+		// No journal, so the data is already on the servers.
+		return nil
+	}
+	// Flush rather than wait, in case the journal's background
+	// work is paused.
+	return jServer.Flush(ctx, folderBranch.Tlf)
+}
+
+// FsyncModeReport describes what an fsync waits for in a TLF.
+type FsyncModeReport struct {
+	// Mode applies to files that weren't opened for synchronous
+	// I/O.
+	Mode string
+	// SyncMode applies to files opened for synchronous I/O
+	// (e.g., with O_SYNC).
+	SyncMode       string
+	JournalEnabled bool
+}
+
+// GetEncodedFsyncMode returns a JSON-encoded FsyncModeReport for the
+// given TLF, under the mount-wide `mode`.
+func GetEncodedFsyncMode(
+	config libkbfs.Config, tlfID tlf.ID, mode FsyncMode) (
+	data []byte, t time.Time, err error) {
+	report := FsyncModeReport{
+		Mode:           EffectiveFsyncMode(config, tlfID, mode).String(),
+		SyncMode:       FsyncServer.String(),
+		JournalEnabled: libkbfs.TLFJournalEnabled(config, tlfID),
+	}
+	data, err = PrettyJSON(report)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return data, time.Time{}, nil
+}
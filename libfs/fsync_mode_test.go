@@ -0,0 +1,112 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFsyncMode(t *testing.T) {
+	for _, m := range []FsyncMode{FsyncJournal, FsyncServer} {
+		parsed, err := ParseFsyncMode(m.String())
+		require.NoError(t, err)
+		require.Equal(t, m, parsed)
+	}
+	_, err := ParseFsyncMode("disk")
+	require.Error(t, err)
+}
+
+func TestFsyncModeWithoutJournal(t *testing.T) {
+	ctx, h, fs := makeFS(t, "")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, fs.config)
+
+	t.Log("Without a journal, fsyncs always wait for the servers")
+	tlfID := h.TlfID()
+	require.Equal(t, FsyncServer,
+		EffectiveFsyncMode(fs.config, tlfID, FsyncJournal))
+
+	data, _, err := GetEncodedFsyncMode(fs.config, tlfID, FsyncJournal)
+	require.NoError(t, err)
+	var report FsyncModeReport
+	err = json.Unmarshal(data, &report)
+	require.NoError(t, err)
+	require.Equal(t, FsyncModeReport{
+		Mode:     "server",
+		SyncMode: "server",
+	}, report)
+
+	f, err := fs.Create("a")
+	require.NoError(t, err)
+	_, err = f.Write([]byte{1})
+	require.NoError(t, err)
+	err = Fsync(ctx, fs.config, fs.root.GetFolderBranch(), FsyncJournal)
+	require.NoError(t, err)
+	err = f.Close()
+	require.NoError(t, err)
+}
+
+func TestFsyncModeWithJournal(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	config := libkbfs.MakeTestConfigOrBust(t, "user1")
+	tempdir, err := ioutil.TempDir(os.TempDir(), "journal_server")
+	require.NoError(t, err)
+	defer func() {
+		libkbfs.CheckConfigAndShutdown(ctx, t, config)
+		os.RemoveAll(tempdir)
+	}()
+	err = config.EnableDiskLimiter(tempdir)
+	require.NoError(t, err)
+	// With the background work paused, only an explicit flush
+	// gets anything to the servers.
+	err = config.EnableJournaling(
+		ctx, tempdir, libkbfs.TLFJournalBackgroundWorkPaused)
+	require.NoError(t, err)
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	fs, err := NewFS(ctx, config, h, libkbfs.MasterBranch, "", "",
+		keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+
+	f, err := fs.Create("a")
+	require.NoError(t, err)
+	_, err = f.Write([]byte{1})
+	require.NoError(t, err)
+
+	tlfID := h.TlfID()
+	require.True(t, libkbfs.TLFJournalEnabled(fs.config, tlfID))
+	require.Equal(t, FsyncJournal,
+		EffectiveFsyncMode(fs.config, tlfID, FsyncJournal))
+	require.Equal(t, FsyncServer,
+		EffectiveFsyncMode(fs.config, tlfID, FsyncServer))
+
+	t.Log("A journal fsync leaves the writes in the journal")
+	fb := fs.root.GetFolderBranch()
+	err = Fsync(ctx, fs.config, fb, FsyncJournal)
+	require.NoError(t, err)
+	jServer, err := libkbfs.GetJournalServer(fs.config)
+	require.NoError(t, err)
+	status, err := jServer.JournalStatus(tlfID)
+	require.NoError(t, err)
+	require.NotZero(t, status.RevisionEnd)
+
+	t.Log("A server fsync flushes them")
+	err = Fsync(ctx, fs.config, fb, FsyncServer)
+	require.NoError(t, err)
+	status, err = jServer.JournalStatus(tlfID)
+	require.NoError(t, err)
+	require.Zero(t, status.RevisionEnd)
+	err = f.Close()
+	require.NoError(t, err)
+}
@@ -0,0 +1,317 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+)
+
+const (
+	ingestStateFileName = "state.json"
+	// ingestSaveEvery is how many uploads go by between saves of
+	// the ingest state.
+	ingestSaveEvery = 100
+)
+
+// ingestJournalPollInterval is how often a paused ingest checks
+// whether the journal has flushed enough to go on.
+var ingestJournalPollInterval = time.Second
+
+// IngestOptions configures an Ingester.
+type IngestOptions struct {
+	// StateDir is where the ingester remembers which files it has
+	// uploaded.  It's only needed if MoveTo isn't set.
+	StateDir string
+	// MoveTo, if set, is a local directory that files are moved
+	// into once they've been uploaded, under the same relative
+	// path.  Otherwise files are left in place, and uploaded
+	// again whenever they change.
+	MoveTo string
+	// SettleTime is how long a file must go unmodified before it's
+	// uploaded, so that files still being written aren't.
+	SettleTime time.Duration
+	// MaxUnflushedBytes, if positive, pauses uploads while the
+	// folder's journal holds more than this many bytes that haven't
+	// been flushed to the servers yet.
+	MaxUnflushedBytes int64
+}
+
+// IngestStats counts what a single pass of an Ingester did.
+type IngestStats struct {
+	Uploaded int
+	Bytes    int64
+}
+
+// ingestEntry is the state of a local file when it was uploaded.
+type ingestEntry struct {
+	Size  int64
+	Mtime time.Time
+}
+
+type ingestState struct {
+	// Entries are the uploaded files, keyed by their path relative
+	// to the local directory.
+	Entries map[string]ingestEntry
+}
+
+// Ingester uploads new and changed files from a local directory into
+// a directory of a KBFS folder, one way.
+type Ingester struct {
+	fs   *FS
+	src  string
+	dest string
+	opts IngestOptions
+
+	state ingestState
+	seen  map[string]bool
+	stats IngestStats
+	dirty int
+}
+
+// NewIngester returns an Ingester of the local directory `src` into
+// the directory `dest` in `fs`, loading any state left by an earlier
+// ingester with the same options.StateDir.
+func NewIngester(fs *FS, src, dest string, options IngestOptions) (
+	*Ingester, error) {
+	if options.MoveTo == "" && options.StateDir == "" {
+		return nil, errors.New(
+			"The ingester needs a state directory or a directory to " +
+				"move uploaded files to")
+	}
+	if options.MoveTo != "" {
+		rel, err := filepath.Rel(src, options.MoveTo)
+		if err == nil && rel != ".." &&
+			!strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, errors.Errorf(
+				"Uploaded files can't be moved into %s", src)
+		}
+	}
+	in := &Ingester{
+		fs:    fs,
+		src:   src,
+		dest:  path.Clean(dest),
+		opts:  options,
+		state: ingestState{Entries: make(map[string]ingestEntry)},
+	}
+	if options.StateDir != "" {
+		err := ioutil.DeserializeFromJSONFile(
+			filepath.Join(options.StateDir, ingestStateFileName), &in.state)
+		if err != nil && !ioutil.IsNotExist(err) {
+			return nil, err
+		}
+		if in.state.Entries == nil {
+			in.state.Entries = make(map[string]ingestEntry)
+		}
+	}
+	return in, nil
+}
+
+func (in *Ingester) saveState() error {
+	in.dirty = 0
+	if in.opts.StateDir == "" {
+		return nil
+	}
+	return writeStateJSON(
+		in.state, filepath.Join(in.opts.StateDir, ingestStateFileName))
+}
+
+// waitForJournal blocks while the folder's journal holds more than
+// the allowed number of unflushed bytes.  Folders without a journal
+// never wait.
+func (in *Ingester) waitForJournal(ctx context.Context) error {
+	if in.opts.MaxUnflushedBytes <= 0 {
+		return nil
+	}
+	jServer, err := libkbfs.GetJournalServer(in.fs.config)
+	if err != nil {
+		return nil
+	}
+	tlfID := in.fs.RootNode().GetFolderBranch().Tlf
+	for {
+		status, err := jServer.JournalStatus(tlfID)
+		if err != nil ||
+			status.UnflushedBytes <= in.opts.MaxUnflushedBytes {
+			return nil
+		}
+		in.fs.log.CDebugf(ctx, "Pausing ingest while %d bytes are unflushed",
+			status.UnflushedBytes)
+		select {
+		case <-time.After(ingestJournalPollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// upload copies the local file at `rel` into the folder, under a
+// temporary name that's renamed into place once the copy is synced,
+// so readers never see a partial file.
+func (in *Ingester) upload(fs *FS, rel string, fi os.FileInfo) error {
+	local, err := ioutil.OpenFile(
+		filepath.Join(in.src, filepath.FromSlash(rel)), os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer local.Close()
+
+	p := path.Join(in.dest, rel)
+	dir := path.Dir(p)
+	if err := fs.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// Use a plain create rather than fs.TempFile's exclusive one,
+	// which can fail in a directory made since the last sync.
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return errors.WithStack(err)
+	}
+	tmp, err := fs.Create(path.Join(
+		dir, ".ingest-"+base64.URLEncoding.EncodeToString(b)))
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(tmp, local)
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = fs.Remove(tmp.Name())
+		return err
+	}
+	if fi.Mode()&0100 != 0 {
+		if err := fs.Chmod(tmp.Name(), 0755); err != nil {
+			return err
+		}
+	}
+	if err := fs.Chtimes(tmp.Name(), fi.ModTime(), fi.ModTime()); err != nil {
+		return err
+	}
+	if err := fs.Rename(tmp.Name(), p); err != nil {
+		return err
+	}
+	if err := fs.SyncAll(); err != nil {
+		return err
+	}
+	in.stats.Uploaded++
+	in.stats.Bytes += n
+	return nil
+}
+
+// uploaded records that the local file at `rel` is in the folder,
+// moving it away if asked to.
+func (in *Ingester) uploaded(rel string, fi os.FileInfo) error {
+	if in.opts.MoveTo != "" {
+		to := filepath.Join(in.opts.MoveTo, filepath.FromSlash(rel))
+		if err := ioutil.MkdirAll(filepath.Dir(to), 0755); err != nil {
+			return err
+		}
+		return ioutil.Rename(
+			filepath.Join(in.src, filepath.FromSlash(rel)), to)
+	}
+	in.state.Entries[rel] = ingestEntry{fi.Size(), fi.ModTime().UTC()}
+	in.dirty++
+	if in.dirty >= ingestSaveEvery {
+		return in.saveState()
+	}
+	return nil
+}
+
+func (in *Ingester) ingestDir(ctx context.Context, fs *FS, rel string) error {
+	fis, err := ioutil.ReadDir(filepath.Join(in.src, filepath.FromSlash(rel)))
+	if err != nil {
+		return err
+	}
+	sort.Slice(fis, func(i, j int) bool {
+		return fis[i].Name() < fis[j].Name()
+	})
+	now := in.fs.config.Clock().Now()
+	for _, fi := range fis {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		childRel := path.Join(rel, fi.Name())
+		switch {
+		case fi.IsDir():
+			if err := in.ingestDir(ctx, fs, childRel); err != nil {
+				return err
+			}
+			continue
+		case !fi.Mode().IsRegular():
+			// Symlinks, devices and the like aren't uploaded.
+			continue
+		}
+		in.seen[childRel] = true
+		if now.Sub(fi.ModTime()) < in.opts.SettleTime {
+			continue
+		}
+		if e, ok := in.state.Entries[childRel]; ok &&
+			e.Size == fi.Size() && e.Mtime.Equal(fi.ModTime()) {
+			continue
+		}
+		if err := in.waitForJournal(ctx); err != nil {
+			return err
+		}
+		if err := in.upload(fs, childRel, fi); err != nil {
+			return err
+		}
+		if err := in.uploaded(childRel, fi); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ScanOnce uploads the files in the local directory that are new or
+// changed since they were last uploaded, and that have settled.
+func (in *Ingester) ScanOnce(ctx context.Context) (IngestStats, error) {
+	in.stats = IngestStats{}
+	in.seen = make(map[string]bool)
+	err := in.ingestDir(ctx, in.fs.WithContext(ctx), "")
+	if err == nil {
+		// Forget the files that are gone.
+		for rel := range in.state.Entries {
+			if !in.seen[rel] {
+				delete(in.state.Entries, rel)
+			}
+		}
+	}
+	if saveErr := in.saveState(); err == nil {
+		err = saveErr
+	}
+	return in.stats, err
+}
+
+// Run scans the local directory every `interval` until `ctx` is
+// canceled.  `report` is called after each scan.  Failed uploads are
+// retried on the next scan.
+func (in *Ingester) Run(ctx context.Context, interval time.Duration,
+	report func(IngestStats, error)) error {
+	for {
+		stats, err := in.ScanOnce(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		report(stats, err)
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
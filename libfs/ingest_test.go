@@ -0,0 +1,135 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIngest(t *testing.T) {
+	ctx, _, fs := makeFS(t, "")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, fs.config)
+	tempdir, err := ioutil.TempDir(os.TempDir(), "ingest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	src := filepath.Join(tempdir, "src")
+	options := IngestOptions{
+		StateDir:   filepath.Join(tempdir, "state"),
+		SettleTime: time.Minute,
+	}
+
+	writeLocalTestFile(t, src, "a.jpg", "a")
+	writeLocalTestFile(t, src, "logs/b.log", "b")
+	in, err := NewIngester(fs, src, "uploads", options)
+	require.NoError(t, err)
+	stats, err := in.ScanOnce(ctx)
+	require.NoError(t, err)
+	require.Equal(t, IngestStats{Uploaded: 2, Bytes: 2}, stats)
+	require.Equal(t, "a", readTestFile(t, fs, "uploads/a.jpg"))
+	require.Equal(t, "b", readTestFile(t, fs, "uploads/logs/b.log"))
+	fis, err := fs.ReadDir("uploads")
+	require.NoError(t, err)
+	require.Len(t, fis, 2)
+
+	t.Log("Only changed files are uploaded again, even by a new ingester")
+	writeLocalTestFile(t, src, "logs/b.log", "bb")
+	in, err = NewIngester(fs, src, "uploads", options)
+	require.NoError(t, err)
+	stats, err = in.ScanOnce(ctx)
+	require.NoError(t, err)
+	require.Equal(t, IngestStats{Uploaded: 1, Bytes: 2}, stats)
+	require.Equal(t, "bb", readTestFile(t, fs, "uploads/logs/b.log"))
+
+	t.Log("Files that are still being written wait")
+	p := filepath.Join(src, "c.jpg")
+	require.NoError(t, ioutil.WriteFile(p, []byte("c"), 0600))
+	stats, err = in.ScanOnce(ctx)
+	require.NoError(t, err)
+	require.Equal(t, IngestStats{}, stats)
+	_, err = fs.Stat("uploads/c.jpg")
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestIngestMoveAfterUpload(t *testing.T) {
+	ctx, _, fs := makeFS(t, "")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, fs.config)
+	tempdir, err := ioutil.TempDir(os.TempDir(), "ingest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	src := filepath.Join(tempdir, "src")
+	done := filepath.Join(tempdir, "done")
+
+	_, err = NewIngester(fs, src, "", IngestOptions{
+		MoveTo: filepath.Join(src, "done")})
+	require.Error(t, err)
+
+	writeLocalTestFile(t, src, "day1/a.jpg", "a")
+	in, err := NewIngester(fs, src, "", IngestOptions{MoveTo: done})
+	require.NoError(t, err)
+	stats, err := in.ScanOnce(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.Uploaded)
+	require.Equal(t, "a", readTestFile(t, fs, "day1/a.jpg"))
+	_, err = os.Stat(filepath.Join(src, "day1/a.jpg"))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(done, "day1/a.jpg"))
+	require.NoError(t, err)
+}
+
+func TestIngestJournalBackpressure(t *testing.T) {
+	defer func(d time.Duration) {
+		ingestJournalPollInterval = d
+	}(ingestJournalPollInterval)
+	ingestJournalPollInterval = time.Millisecond
+
+	ctx, _, fs, shutdown := makeFSWithJournal(t, "")
+	defer shutdown()
+	tempdir, err := ioutil.TempDir(os.TempDir(), "ingest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	src := filepath.Join(tempdir, "src")
+
+	jServer, err := libkbfs.GetJournalServer(fs.config)
+	require.NoError(t, err)
+	tlfID := fs.RootNode().GetFolderBranch().Tlf
+	jServer.PauseBackgroundWork(ctx, tlfID)
+
+	status, err := jServer.JournalStatus(tlfID)
+	require.NoError(t, err)
+
+	writeLocalTestFile(t, src, "a", "a")
+	writeLocalTestFile(t, src, "b", "b")
+	in, err := NewIngester(fs, src, "", IngestOptions{
+		StateDir:          filepath.Join(tempdir, "state"),
+		MaxUnflushedBytes: status.UnflushedBytes,
+	})
+	require.NoError(t, err)
+
+	t.Log("The second upload waits for the first one to flush")
+	pausedCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	stats, err := in.ScanOnce(pausedCtx)
+	require.Equal(t, context.DeadlineExceeded, err)
+	require.Equal(t, 1, stats.Uploaded)
+
+	jServer.ResumeBackgroundWork(ctx, tlfID)
+	stats, err = in.ScanOnce(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.Uploaded)
+	require.Equal(t, "b", readTestFile(t, fs, "b"))
+
+	// Flush the journal so the folder is clean.
+	err = jServer.FinishSingleOp(ctx, tlfID, nil, keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+}
@@ -34,6 +34,9 @@ const (
 	JournalEnableAuto
 	// JournalDisableAuto is to turn off automatic journaling for new TLFs.
 	JournalDisableAuto
+	// JournalReleaseQuarantine is to delete the local copies of
+	// files rejected by the content scanner.
+	JournalReleaseQuarantine
 )
 
 func (a JournalAction) String() string {
@@ -52,6 +55,8 @@ func (a JournalAction) String() string {
 		return "Enable auto-journals"
 	case JournalDisableAuto:
 		return "Disable auto-journals"
+	case JournalReleaseQuarantine:
+		return "Release content quarantine"
 	}
 	return fmt.Sprintf("JournalAction(%d)", int(a))
 }
@@ -94,6 +99,12 @@ func (a JournalAction) Execute(
 	case JournalResumeBackgroundWork:
 		jServer.ResumeBackgroundWork(ctx, tlfID)
 
+	case JournalReleaseQuarantine:
+		err := jServer.ReleaseQuarantine(ctx, tlfID)
+		if err != nil {
+			return err
+		}
+
 	case JournalDisable:
 		_, err := jServer.Disable(ctx, tlfID)
 		if err != nil {
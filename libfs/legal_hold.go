@@ -0,0 +1,36 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// GetEncodedHeldRevisions returns a JSON-encoded list of the TLF's
+// revisions that are retained by a legal hold.
+func GetEncodedHeldRevisions(
+	ctx context.Context, config libkbfs.Config,
+	folderBranch libkbfs.FolderBranch) (
+	data []byte, t time.Time, err error) {
+	held, err := config.KBFSOps().GetHeldRevisions(ctx, folderBranch)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if held == nil {
+		held = []libkbfs.HeldRevision{}
+	}
+
+	data, err = json.MarshalIndent(held, "", "  ")
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	data = append(data, '\n')
+	return data, time.Time{}, nil
+}
@@ -0,0 +1,504 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+)
+
+const (
+	mirrorStateFileName   = "state.json"
+	mirrorPartialFileName = "partial.json"
+	mirrorPartialDataName = "partial"
+
+	// mirrorSaveEvery is how many copied entries go by between
+	// saves of the mirror state, so that an interrupted mirror
+	// doesn't start over.
+	mirrorSaveEvery = 100
+	// mirrorMaxChunk caps the size of each rate-limited write.
+	mirrorMaxChunk = 32 * 1024
+)
+
+// MirrorOptions configures a Mirror.
+type MirrorOptions struct {
+	// StateDir is where the mirror keeps what it has copied, and
+	// the partial copy of the file it's working on.  It must be
+	// on the same file system as the destination, so finished
+	// files can be moved into place.
+	StateDir string
+	// Delete removes local entries whose source was removed.
+	// Only entries that the mirror itself copied are removed.
+	Delete bool
+	// BytesPerSecond, if positive, limits how fast file contents
+	// are copied.
+	BytesPerSecond int
+}
+
+// MirrorStats counts what a single pass of a Mirror did.
+type MirrorStats struct {
+	Revision kbfsmd.Revision
+	Copied   int
+	Deleted  int
+	Bytes    int64
+}
+
+// mirrorEntry is the state of an entry that's been copied.
+type mirrorEntry struct {
+	Type   libkbfs.EntryType
+	Size   int64     `json:",omitempty"`
+	Mtime  time.Time `json:",omitempty"`
+	Target string    `json:",omitempty"`
+}
+
+func (e mirrorEntry) matches(o mirrorEntry) bool {
+	return e.Type == o.Type && e.Size == o.Size &&
+		e.Mtime.Equal(o.Mtime) && e.Target == o.Target
+}
+
+type mirrorState struct {
+	// Revision is the revision of the folder that was last
+	// mirrored completely, or 0.
+	Revision kbfsmd.Revision
+	// Entries are the copied entries, keyed by their path relative
+	// to the mirrored directory.
+	Entries map[string]mirrorEntry
+}
+
+// mirrorPartial describes the file whose copy is in progress.  A
+// copy that's interrupted resumes where it left off, if the source
+// hasn't changed since.
+type mirrorPartial struct {
+	Path  string
+	Entry mirrorEntry
+}
+
+// Mirror copies a directory of a KBFS folder to a local directory,
+// one way.  Each pass only copies what changed since the previous
+// one, even across restarts.
+type Mirror struct {
+	fs      *FS
+	src     string
+	dest    string
+	opts    MirrorOptions
+	limiter *rate.Limiter
+
+	state mirrorState
+	seen  map[string]bool
+	stats MirrorStats
+	dirty int
+}
+
+// NewMirror returns a Mirror of the directory `src` in `fs` to the
+// local directory `dest`, loading any state left by an earlier
+// mirror with the same options.StateDir.
+func NewMirror(fs *FS, src, dest string, options MirrorOptions) (
+	*Mirror, error) {
+	if options.StateDir == "" {
+		return nil, errors.New("The mirror needs a state directory")
+	}
+	m := &Mirror{fs: fs, src: path.Clean(src), dest: dest, opts: options}
+	if options.BytesPerSecond > 0 {
+		burst := options.BytesPerSecond
+		if burst > mirrorMaxChunk {
+			burst = mirrorMaxChunk
+		}
+		m.limiter = rate.NewLimiter(
+			rate.Limit(options.BytesPerSecond), burst)
+	}
+	err := ioutil.DeserializeFromJSONFile(
+		m.statePath(mirrorStateFileName), &m.state)
+	if err != nil && !ioutil.IsNotExist(err) {
+		return nil, err
+	}
+	if m.state.Entries == nil {
+		m.state.Entries = make(map[string]mirrorEntry)
+	}
+	return m, nil
+}
+
+func (m *Mirror) statePath(name string) string {
+	return filepath.Join(m.opts.StateDir, name)
+}
+
+func (m *Mirror) localPath(p string) string {
+	return filepath.Join(m.dest, filepath.FromSlash(p))
+}
+
+// writeStateJSON atomically replaces the JSON file at `p` with `obj`.
+func writeStateJSON(obj interface{}, p string) error {
+	buf, err := json.Marshal(obj)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err := ioutil.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteSerializedFile(p, buf, 0600)
+}
+
+func (m *Mirror) saveState() error {
+	m.dirty = 0
+	return writeStateJSON(m.state, m.statePath(mirrorStateFileName))
+}
+
+// copied records that the entry at `p` is now up to date.
+func (m *Mirror) copied(p string, e mirrorEntry) error {
+	m.state.Entries[p] = e
+	m.stats.Copied++
+	m.dirty++
+	if m.dirty >= mirrorSaveEvery {
+		return m.saveState()
+	}
+	return nil
+}
+
+// rateLimitedWriter waits for the mirror's limiter before each
+// chunk it writes.
+type rateLimitedWriter struct {
+	ctx     context.Context
+	w       io.Writer
+	limiter *rate.Limiter
+}
+
+func (w rateLimitedWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		chunk := len(p)
+		if chunk > w.limiter.Burst() {
+			chunk = w.limiter.Burst()
+		}
+		if err := w.limiter.WaitN(w.ctx, chunk); err != nil {
+			return n, err
+		}
+		written, err := w.w.Write(p[:chunk])
+		n += written
+		if err != nil {
+			return n, err
+		}
+		p = p[chunk:]
+	}
+	return n, nil
+}
+
+// copyFile copies the file at `p` to the partial file, resuming an
+// earlier copy of the same file contents if there is one, and then
+// moves it into place at `rel` in the local directory.
+func (m *Mirror) copyFile(
+	ctx context.Context, fs *FS, p, rel string, e mirrorEntry) error {
+	partialPath := m.statePath(mirrorPartialFileName)
+	dataPath := m.statePath(mirrorPartialDataName)
+	var offset int64
+	var partial mirrorPartial
+	err := ioutil.DeserializeFromJSONFile(partialPath, &partial)
+	switch {
+	case err == nil && partial.Path == rel && partial.Entry.matches(e):
+		fi, err := ioutil.Stat(dataPath)
+		if err == nil && fi.Size() <= e.Size {
+			offset = fi.Size()
+		}
+	case err != nil && !ioutil.IsNotExist(err):
+		return err
+	}
+	if offset == 0 {
+		err = writeStateJSON(mirrorPartial{rel, e}, partialPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	out, err := ioutil.OpenFile(dataPath, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if err := out.Truncate(offset); err != nil {
+		return errors.WithStack(err)
+	}
+	if _, err := out.Seek(offset, io.SeekStart); err != nil {
+		return errors.WithStack(err)
+	}
+	in, err := fs.Open(p)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if _, err := in.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	var w io.Writer = out
+	if m.limiter != nil {
+		w = rateLimitedWriter{ctx, out, m.limiter}
+	}
+	n, err := io.CopyBuffer(w, in, make([]byte, mirrorMaxChunk))
+	m.stats.Bytes += n
+	if err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	mode := os.FileMode(0644)
+	if e.Type == libkbfs.Exec {
+		mode = 0755
+	}
+	if err := os.Chmod(dataPath, mode); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.Chtimes(dataPath, e.Mtime, e.Mtime); err != nil {
+		return errors.WithStack(err)
+	}
+	local := m.localPath(rel)
+	if fi, err := ioutil.Lstat(local); err == nil && fi.IsDir() {
+		if err := ioutil.RemoveAll(local); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.Rename(dataPath, local); err != nil {
+		return err
+	}
+	return ioutil.Remove(partialPath)
+}
+
+// mirrorEntryFor returns the state of the source entry at `p`.
+func mirrorEntryFor(fs *FS, p string, fi os.FileInfo) (mirrorEntry, error) {
+	sys, ok := fi.Sys().(fileInfoSys)
+	if !ok {
+		return mirrorEntry{}, errors.Errorf("Unexpected file info for %s", p)
+	}
+	e := mirrorEntry{Type: sys.EntryInfo().Type}
+	switch e.Type {
+	case libkbfs.Dir:
+	case libkbfs.Sym:
+		target, err := fs.Readlink(p)
+		if err != nil {
+			return mirrorEntry{}, err
+		}
+		e.Target = target
+	default:
+		e.Size = fi.Size()
+		e.Mtime = fi.ModTime().UTC()
+	}
+	return e, nil
+}
+
+// upToDate returns true if the local copy of the entry at `p` was
+// made from the same version of the entry as `e`, and is still there.
+func (m *Mirror) upToDate(p string, e mirrorEntry) bool {
+	old, ok := m.state.Entries[p]
+	if !ok || !old.matches(e) {
+		return false
+	}
+	fi, err := ioutil.Lstat(m.localPath(p))
+	if err != nil {
+		return false
+	}
+	switch e.Type {
+	case libkbfs.Dir:
+		return fi.IsDir()
+	case libkbfs.Sym:
+		return fi.Mode()&os.ModeSymlink != 0
+	default:
+		return fi.Mode().IsRegular() && fi.Size() == e.Size
+	}
+}
+
+func (m *Mirror) mirrorEntry(
+	ctx context.Context, fs *FS, p string, fi os.FileInfo) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	rel := ""
+	switch {
+	case p == m.src:
+	case m.src == ".":
+		rel = p
+	default:
+		rel = p[len(m.src)+1:]
+	}
+	e, err := mirrorEntryFor(fs, p, fi)
+	if err != nil {
+		return err
+	}
+	if rel != "" {
+		m.seen[rel] = true
+	}
+	local := m.localPath(rel)
+
+	switch e.Type {
+	case libkbfs.Dir:
+		if lfi, err := ioutil.Lstat(local); err == nil && !lfi.IsDir() {
+			if err := ioutil.Remove(local); err != nil {
+				return err
+			}
+		}
+		if err := ioutil.MkdirAll(local, 0755); err != nil {
+			return err
+		}
+		if rel != "" {
+			m.state.Entries[rel] = e
+		}
+		fis, err := fs.ReadDir(p)
+		if err != nil {
+			return err
+		}
+		sort.Slice(fis, func(i, j int) bool {
+			return fis[i].Name() < fis[j].Name()
+		})
+		for _, child := range fis {
+			err := m.mirrorEntry(ctx, fs, path.Join(p, child.Name()), child)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case libkbfs.Sym:
+		if m.upToDate(rel, e) {
+			return nil
+		}
+		if err := ioutil.RemoveAll(local); err != nil {
+			return err
+		}
+		if err := os.Symlink(e.Target, local); err != nil {
+			return errors.WithStack(err)
+		}
+	default:
+		if m.upToDate(rel, e) {
+			return nil
+		}
+		if err := m.copyFile(ctx, fs, p, rel, e); err != nil {
+			return err
+		}
+	}
+	return m.copied(rel, e)
+}
+
+// deleteUnseen forgets the entries that weren't seen in this pass,
+// removing their local copies if the mirror deletes.
+func (m *Mirror) deleteUnseen() error {
+	var gone []string
+	for p := range m.state.Entries {
+		if !m.seen[p] {
+			gone = append(gone, p)
+		}
+	}
+	// Remove children before their parents.
+	sort.Sort(sort.Reverse(sort.StringSlice(gone)))
+	for _, p := range gone {
+		if m.opts.Delete {
+			err := ioutil.RemoveAll(m.localPath(p))
+			if err != nil {
+				return err
+			}
+			m.stats.Deleted++
+		}
+		delete(m.state.Entries, p)
+	}
+	return nil
+}
+
+// SyncOnce brings the local directory up to date with the latest
+// revision of the folder known to this device.  If it's interrupted,
+// the next pass picks up where it left off.
+func (m *Mirror) SyncOnce(ctx context.Context) (MirrorStats, error) {
+	fs := m.fs.WithContext(ctx)
+	status, _, err := fs.config.KBFSOps().FolderStatus(
+		ctx, fs.RootNode().GetFolderBranch())
+	if err != nil {
+		return MirrorStats{}, err
+	}
+	m.stats = MirrorStats{Revision: status.Revision}
+	if status.Revision == m.state.Revision {
+		return m.stats, nil
+	}
+
+	fi, err := fs.Stat(m.src)
+	if err != nil {
+		return MirrorStats{}, err
+	}
+	if !fi.IsDir() {
+		return MirrorStats{}, errors.Errorf("%s is not a directory", m.src)
+	}
+	m.seen = make(map[string]bool)
+	err = m.mirrorEntry(ctx, fs, m.src, fi)
+	if err == nil {
+		err = m.deleteUnseen()
+	}
+	if err != nil {
+		// Keep what's been copied so far.
+		if saveErr := m.saveState(); saveErr != nil {
+			fs.log.CDebugf(ctx, "Couldn't save mirror state: %+v", saveErr)
+		}
+		return m.stats, err
+	}
+	m.state.Revision = status.Revision
+	return m.stats, m.saveState()
+}
+
+// mirrorChangeObserver signals a channel when the mirrored folder
+// changes.
+type mirrorChangeObserver chan<- struct{}
+
+func (mirrorChangeObserver) LocalChange(
+	context.Context, libkbfs.Node, libkbfs.WriteRange) {
+}
+func (o mirrorChangeObserver) BatchChanges(
+	context.Context, []libkbfs.NodeChange, []libkbfs.NodeID) {
+	select {
+	case o <- struct{}{}:
+	default:
+	}
+}
+func (mirrorChangeObserver) TlfHandleChange(
+	context.Context, *libkbfs.TlfHandle) {
+}
+
+// Run mirrors the folder until `ctx` is canceled, making a new pass
+// whenever the folder changes, and at least every `interval` in case
+// a change notification is missed.  `report` is called after each
+// pass.  Failed passes are retried on the next change or interval.
+func (m *Mirror) Run(ctx context.Context, interval time.Duration,
+	report func(MirrorStats, error)) error {
+	changeCh := make(chan struct{}, 1)
+	fbs := []libkbfs.FolderBranch{m.fs.RootNode().GetFolderBranch()}
+	obs := mirrorChangeObserver(changeCh)
+	notifier := m.fs.config.Notifier()
+	if err := notifier.RegisterForChanges(fbs, obs); err != nil {
+		return err
+	}
+	defer func() {
+		_ = notifier.UnregisterFromChanges(fbs, obs)
+	}()
+
+	for {
+		stats, err := m.SyncOnce(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		report(stats, err)
+		select {
+		case <-changeCh:
+		case <-time.After(interval):
+			err := m.fs.config.KBFSOps().SyncFromServer(ctx, fbs[0], nil)
+			if err != nil && ctx.Err() == nil {
+				report(MirrorStats{}, err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
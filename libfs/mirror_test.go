@@ -0,0 +1,111 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirror(t *testing.T) {
+	ctx, _, fs := makeFS(t, "")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, fs.config)
+	tempdir, err := ioutil.TempDir(os.TempDir(), "mirror")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	dest := filepath.Join(tempdir, "site")
+	options := MirrorOptions{
+		StateDir: filepath.Join(tempdir, "state"),
+		Delete:   true,
+	}
+
+	require.NoError(t, fs.MkdirAll("site/a", 0755))
+	writeTestFile(t, fs, "site/index.html", "hello")
+	writeTestFile(t, fs, "site/a/b.txt", "b")
+	writeTestFile(t, fs, "site/run.sh", "#!/bin/sh")
+	require.NoError(t, fs.Chmod("site/run.sh", 0755))
+	require.NoError(t, fs.Symlink("a/b.txt", "site/link"))
+	writeTestFile(t, fs, "other", "not mirrored")
+	require.NoError(t, fs.SyncAll())
+
+	m, err := NewMirror(fs, "site", dest, options)
+	require.NoError(t, err)
+	stats, err := m.SyncOnce(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 4, stats.Copied)
+	require.Equal(t, int64(len("hellob#!/bin/sh")), stats.Bytes)
+	require.Equal(t, "hello",
+		readLocalTestFile(t, filepath.Join(dest, "index.html")))
+	require.Equal(t, "b", readLocalTestFile(t, filepath.Join(dest, "link")))
+	fi, err := os.Stat(filepath.Join(dest, "run.sh"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0755), fi.Mode().Perm())
+	_, err = os.Stat(filepath.Join(dest, "other"))
+	require.True(t, os.IsNotExist(err))
+
+	t.Log("Only changes are copied, even by a new mirror")
+	writeTestFile(t, fs, "site/a/b.txt", "bb")
+	require.NoError(t, fs.Remove("site/index.html"))
+	require.NoError(t, fs.SyncAll())
+	m, err = NewMirror(fs, "site", dest, options)
+	require.NoError(t, err)
+	stats, err = m.SyncOnce(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.Copied)
+	require.Equal(t, 1, stats.Deleted)
+	require.Equal(t, "bb", readLocalTestFile(t, filepath.Join(dest, "a/b.txt")))
+	_, err = os.Stat(filepath.Join(dest, "index.html"))
+	require.True(t, os.IsNotExist(err))
+
+	t.Log("Nothing happens without a new revision")
+	stats, err = m.SyncOnce(ctx)
+	require.NoError(t, err)
+	require.Equal(t, MirrorStats{Revision: stats.Revision}, stats)
+}
+
+func TestMirrorResume(t *testing.T) {
+	ctx, _, fs := makeFS(t, "")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, fs.config)
+	tempdir, err := ioutil.TempDir(os.TempDir(), "mirror")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	dest := filepath.Join(tempdir, "site")
+	options := MirrorOptions{
+		StateDir:       filepath.Join(tempdir, "state"),
+		BytesPerSecond: 1 << 20,
+	}
+
+	writeTestFile(t, fs, "big", "0123456789")
+	require.NoError(t, fs.SyncAll())
+	fi, err := fs.Stat("big")
+	require.NoError(t, err)
+	e, err := mirrorEntryFor(fs, "big", fi)
+	require.NoError(t, err)
+
+	t.Log("Pretend an earlier copy was interrupted halfway")
+	err = writeStateJSON(mirrorPartial{"big", e},
+		filepath.Join(options.StateDir, mirrorPartialFileName))
+	require.NoError(t, err)
+	err = ioutil.WriteFile(
+		filepath.Join(options.StateDir, mirrorPartialDataName),
+		[]byte("01234"), 0600)
+	require.NoError(t, err)
+
+	m, err := NewMirror(fs, "", dest, options)
+	require.NoError(t, err)
+	stats, err := m.SyncOnce(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.Copied)
+	require.Equal(t, int64(5), stats.Bytes)
+	require.Equal(t, "0123456789",
+		readLocalTestFile(t, filepath.Join(dest, "big")))
+	_, err = os.Stat(filepath.Join(options.StateDir, mirrorPartialFileName))
+	require.True(t, os.IsNotExist(err))
+}
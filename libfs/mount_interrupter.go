@@ -57,6 +57,24 @@ func (mi *MountInterrupter) MountAndSetUnmount(mounter Mounter) error {
 	return nil
 }
 
+// Unmount runs the unmounter set by MountAndSetUnmount, if any, and
+// forgets it if it succeeds, without signaling Wait.  This lets the
+// filesystem be mounted again later by the same process.
+func (mi *MountInterrupter) Unmount() error {
+	mi.Lock()
+	defer mi.Unlock()
+	if mi.fun == nil {
+		return nil
+	}
+	err := mi.fun()
+	if err != nil {
+		return err
+	}
+	mi.fun = nil
+	mi.log.Info("Unmounted the filesystem")
+	return nil
+}
+
 // Done signals Wait and runs the unmounter if set by MountAndSetUnmount.
 // It can be called multiple times with no harm. Each call triggers a call to
 // the unmounter.
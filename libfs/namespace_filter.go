@@ -0,0 +1,146 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+)
+
+// NamespaceFilter limits which top-level namespaces (private, public
+// and team) a mount exposes, and optionally which folders in them,
+// e.g. to expose only /keybase/team/acme on a shared machine.  The
+// zero value exposes everything.
+type NamespaceFilter struct {
+	// folders maps each exposed namespace to its exposed folders,
+	// by canonical name; a nil set exposes every folder in the
+	// namespace.  A nil map exposes every namespace.
+	folders map[tlf.Type]map[string]bool
+}
+
+// ParseNamespaceFilter parses a list of namespaces and folders,
+// separated by "|".  Each entry is either a namespace, like
+// "private", or a folder in one, like "team/acme" or
+// "private/alice,bob", optionally starting with "/keybase/".  Folders
+// must be given by their canonical names.  An empty string exposes
+// everything.
+func ParseNamespaceFilter(s string) (NamespaceFilter, error) {
+	if strings.TrimSpace(s) == "" {
+		return NamespaceFilter{}, nil
+	}
+	folders := make(map[tlf.Type]map[string]bool)
+	for _, entry := range strings.Split(s, "|") {
+		entry = strings.TrimSpace(entry)
+		entry = strings.TrimPrefix(entry, "/keybase/")
+		entry = strings.Trim(entry, "/")
+		parts := strings.Split(entry, "/")
+		if len(parts) > 2 {
+			return NamespaceFilter{}, errors.Errorf(
+				"%q is not a namespace or a top-level folder", entry)
+		}
+		t, err := tlf.ParseTlfTypeFromPath(parts[0])
+		if err != nil {
+			return NamespaceFilter{}, errors.Errorf(
+				"Unknown namespace %q; must be private, public or team",
+				parts[0])
+		}
+		namespaceFolders, ok := folders[t]
+		if len(parts) == 1 {
+			// The whole namespace.
+			folders[t] = nil
+			continue
+		}
+		if parts[1] == "" {
+			return NamespaceFilter{}, errors.Errorf(
+				"Empty folder name in %q", entry)
+		}
+		if ok && namespaceFolders == nil {
+			// The whole namespace is already exposed.
+			continue
+		}
+		if namespaceFolders == nil {
+			namespaceFolders = make(map[string]bool)
+			folders[t] = namespaceFolders
+		}
+		namespaceFolders[parts[1]] = true
+	}
+	return NamespaceFilter{folders}, nil
+}
+
+// IsEmpty returns true if the filter exposes everything.
+func (f NamespaceFilter) IsEmpty() bool {
+	return f.folders == nil
+}
+
+// AllowsNamespace returns true if the filter exposes the namespace
+// for TLFs of type `t`, or any of its folders.
+func (f NamespaceFilter) AllowsNamespace(t tlf.Type) bool {
+	if f.folders == nil {
+		return true
+	}
+	_, ok := f.folders[t]
+	return ok
+}
+
+// AllowsFolder returns true if the filter exposes the folder of type
+// `t` with the canonical name `name`.
+func (f NamespaceFilter) AllowsFolder(t tlf.Type, name tlf.CanonicalName) bool {
+	if f.folders == nil {
+		return true
+	}
+	namespaceFolders, ok := f.folders[t]
+	if !ok {
+		return false
+	}
+	return namespaceFolders == nil || namespaceFolders[string(name)]
+}
+
+// crossFolderFileNames are the special files that report on many
+// folders at once.
+var crossFolderFileNames = map[string]bool{
+	EditHistoryName:   true,
+	TopStatusFileName: true,
+	StatusDirName:     true,
+}
+
+// HidesSpecialFile returns true if the filter hides the special file
+// `name`, because it could reveal folders that the filter hides.
+func (f NamespaceFilter) HidesSpecialFile(name string) bool {
+	return !f.IsEmpty() && crossFolderFileNames[name]
+}
+
+func namespaceName(t tlf.Type) string {
+	switch t {
+	case tlf.Private:
+		return "private"
+	case tlf.Public:
+		return "public"
+	case tlf.SingleTeam:
+		return "team"
+	default:
+		return t.String()
+	}
+}
+
+// String implements the fmt.Stringer interface for NamespaceFilter,
+// in the form parsed by ParseNamespaceFilter.
+func (f NamespaceFilter) String() string {
+	var entries []string
+	for t, namespaceFolders := range f.folders {
+		namespace := namespaceName(t)
+		if namespaceFolders == nil {
+			entries = append(entries, namespace)
+			continue
+		}
+		for name := range namespaceFolders {
+			entries = append(entries, namespace+"/"+name)
+		}
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, "|")
+}
@@ -0,0 +1,58 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"testing"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamespaceFilter(t *testing.T) {
+	f, err := ParseNamespaceFilter("")
+	require.NoError(t, err)
+	require.True(t, f.IsEmpty())
+	require.True(t, f.AllowsNamespace(tlf.Private))
+	require.True(t, f.AllowsFolder(tlf.SingleTeam, "acme"))
+	require.False(t, f.HidesSpecialFile(EditHistoryName))
+
+	f, err = ParseNamespaceFilter(
+		"/keybase/team/acme/ | team/acme.ops|public|private/alice,bob")
+	require.NoError(t, err)
+	require.False(t, f.IsEmpty())
+	require.Equal(t,
+		"private/alice,bob|public|team/acme|team/acme.ops", f.String())
+
+	require.True(t, f.AllowsNamespace(tlf.SingleTeam))
+	require.True(t, f.AllowsFolder(tlf.SingleTeam, "acme"))
+	require.True(t, f.AllowsFolder(tlf.SingleTeam, "acme.ops"))
+	require.False(t, f.AllowsFolder(tlf.SingleTeam, "acme.secret"))
+
+	require.True(t, f.AllowsFolder(tlf.Public, "anyone"))
+
+	require.True(t, f.AllowsNamespace(tlf.Private))
+	require.True(t, f.AllowsFolder(tlf.Private, "alice,bob"))
+	require.False(t, f.AllowsFolder(tlf.Private, "alice"))
+
+	require.True(t, f.HidesSpecialFile(EditHistoryName))
+	require.True(t, f.HidesSpecialFile(StatusDirName))
+	require.False(t, f.HidesSpecialFile(StatusFileName))
+
+	t.Log("A whole namespace overrides its folders, in any order.")
+	f, err = ParseNamespaceFilter("team/acme|team|team/other")
+	require.NoError(t, err)
+	require.Equal(t, "team", f.String())
+	require.False(t, f.AllowsNamespace(tlf.Private))
+	require.False(t, f.AllowsFolder(tlf.Public, "alice"))
+	require.True(t, f.AllowsFolder(tlf.SingleTeam, "anything"))
+
+	for _, s := range []string{
+		"shared", "team/acme/dir", "private||public",
+	} {
+		_, err := ParseNamespaceFilter(s)
+		require.Error(t, err, s)
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// GetEncodedRecentFiles returns a JSON-encoded list of the files in a
+// TLF that were recently read or written on this device, most recent
+// first.
+func GetEncodedRecentFiles(
+	ctx context.Context, config libkbfs.Config,
+	folderBranch libkbfs.FolderBranch) (
+	data []byte, t time.Time, err error) {
+	files, err := config.KBFSOps().GetRecentFiles(ctx, folderBranch, "", 0)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	data, err = PrettyJSON(files)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return data, time.Time{}, nil
+}
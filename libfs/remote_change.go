@@ -0,0 +1,75 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// RemoteChangePolicy says what a mount does when a file that is open
+// locally is changed elsewhere, such as on another device.  Saving
+// such a file usually ends up making a conflicted copy, or silently
+// overwriting the other change, so users are better off knowing
+// before they save.
+type RemoteChangePolicy int
+
+const (
+	// RemoteChangeWarn sends a notification, and marks the file with
+	// the XattrChangedSinceOpen extended attribute, but lets writes
+	// through.
+	RemoteChangeWarn RemoteChangePolicy = iota
+	// RemoteChangeDeny warns like RemoteChangeWarn, and also fails
+	// writes to the file with EPERM until it's closed by everyone.
+	RemoteChangeDeny
+)
+
+// String implements the fmt.Stringer interface for RemoteChangePolicy.
+func (p RemoteChangePolicy) String() string {
+	switch p {
+	case RemoteChangeWarn:
+		return "warn"
+	case RemoteChangeDeny:
+		return "deny"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseRemoteChangePolicy parses the name of a policy, as returned by
+// RemoteChangePolicy.String.
+func ParseRemoteChangePolicy(s string) (RemoteChangePolicy, error) {
+	for _, p := range []RemoteChangePolicy{
+		RemoteChangeWarn, RemoteChangeDeny} {
+		if s == p.String() {
+			return p, nil
+		}
+	}
+	return RemoteChangeWarn, errors.Errorf(
+		"Unknown remote change policy %q; must be warn or deny", s)
+}
+
+// notificationParamOpenLocally marks a file modification notification
+// as a warning about a file that is open locally.
+const notificationParamOpenLocally = "openLocally"
+
+// NotifyOpenFileChanged warns the user that `node`, which is open
+// locally, was changed elsewhere.
+func NotifyOpenFileChanged(
+	ctx context.Context, config libkbfs.Config, node libkbfs.Node) error {
+	p, err := config.KBFSOps().GetCanonicalPath(ctx, node)
+	if err != nil {
+		return err
+	}
+	config.Reporter().Notify(ctx, &keybase1.FSNotification{
+		Filename:         p,
+		StatusCode:       keybase1.FSStatusCode_FINISH,
+		NotificationType: keybase1.FSNotificationType_FILE_MODIFIED,
+		Params:           map[string]string{notificationParamOpenLocally: "true"},
+	})
+	return nil
+}
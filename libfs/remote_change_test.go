@@ -0,0 +1,22 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRemoteChangePolicy(t *testing.T) {
+	for _, p := range []RemoteChangePolicy{
+		RemoteChangeWarn, RemoteChangeDeny} {
+		parsed, err := ParseRemoteChangePolicy(p.String())
+		require.NoError(t, err)
+		require.Equal(t, p, parsed)
+	}
+	_, err := ParseRemoteChangePolicy("ignore")
+	require.Error(t, err)
+}
@@ -19,9 +19,12 @@ import (
 
 // Special files in root directory.
 const (
-	HumanErrorFileName      = "kbfs.error.txt"
-	HumanNoLoginFileName    = "kbfs.nologin.txt"
-	failureDisplayThreshold = 5 * time.Second
+	HumanErrorFileName   = "kbfs.error.txt"
+	HumanNoLoginFileName = "kbfs.nologin.txt"
+	// HumanInitializingFileName is only shown while a mount is
+	// waiting for KBFS to finish starting up.
+	HumanInitializingFileName = "kbfs.initializing.txt"
+	failureDisplayThreshold   = 5 * time.Second
 )
 
 // RemoteStatusUpdater has callbacks that will be called from libfs
@@ -0,0 +1,151 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+// The status directory lays out its files like this, so that they
+// can be inspected with tools like cat, watch and jq:
+//
+//   .kbfs_dashboard/caches             CacheStatus
+//   .kbfs_dashboard/errors             recently reported errors
+//   .kbfs_dashboard/journal            the journal server status
+//   .kbfs_dashboard/<type>/<tlf name>  the FolderBranchStatus of
+//                                      each open TLF
+//
+// where <type> is one of private, public or team.  Paths passed to
+// the functions below are relative to the status directory.
+
+const (
+	statusDirCachesFileName  = "caches"
+	statusDirErrorsFileName  = "errors"
+	statusDirJournalFileName = "journal"
+)
+
+// statusDirTlfTypes maps the names of the per-type directories to
+// their TLF types, matching the names used under /keybase.
+var statusDirTlfTypes = map[string]tlf.Type{
+	"private": tlf.Private,
+	"public":  tlf.Public,
+	"team":    tlf.SingleTeam,
+}
+
+// CacheStatus describes the block caches of this device.  It is
+// suitable for encoding directly as JSON.
+type CacheStatus struct {
+	CleanBytesCapacity uint64
+	DiskCacheStatus    map[string]libkbfs.DiskBlockCacheStatus `json:",omitempty"`
+}
+
+// GetEncodedCacheStatus returns serialized JSON containing a
+// CacheStatus.
+func GetEncodedCacheStatus(ctx context.Context, config libkbfs.Config) (
+	data []byte, t time.Time, err error) {
+	status := CacheStatus{
+		CleanBytesCapacity: config.BlockCache().GetCleanBytesCapacity(),
+	}
+	if dbc := config.DiskBlockCache(); dbc != nil {
+		status.DiskCacheStatus = dbc.Status(ctx)
+	}
+	data, err = PrettyJSON(status)
+	return data, time.Time{}, err
+}
+
+// GetEncodedJournalServerStatus returns serialized JSON containing
+// the status of the journal server, including the unflushed paths of
+// each journal, or null if journaling isn't enabled.
+func GetEncodedJournalServerStatus(
+	ctx context.Context, config libkbfs.Config) (
+	data []byte, t time.Time, err error) {
+	var status *libkbfs.JournalServerStatus
+	if jServer, jErr := libkbfs.GetJournalServer(config); jErr == nil {
+		jStatus, tlfIDs := jServer.Status(ctx)
+		err := libkbfs.FillInJournalStatusUnflushedPaths(
+			ctx, config, &jStatus, tlfIDs)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		status = &jStatus
+	}
+	data, err = PrettyJSON(status)
+	return data, time.Time{}, err
+}
+
+// StatusDirChildren returns the names of the entries of the directory
+// at `path` within the status directory, mapped to whether each one
+// is itself a directory.  It returns false if there is no such
+// directory.
+func StatusDirChildren(ctx context.Context, config libkbfs.Config,
+	path []string) (children map[string]bool, ok bool) {
+	switch len(path) {
+	case 0:
+		children = map[string]bool{
+			statusDirCachesFileName:  false,
+			statusDirErrorsFileName:  false,
+			statusDirJournalFileName: false,
+		}
+		for name := range statusDirTlfTypes {
+			children[name] = true
+		}
+		return children, true
+	case 1:
+		t, ok := statusDirTlfTypes[path[0]]
+		if !ok {
+			return nil, false
+		}
+		children = make(map[string]bool)
+		for fav := range config.KBFSOps().GetOpenFolders(ctx) {
+			if fav.Type == t {
+				children[fav.Name] = false
+			}
+		}
+		return children, true
+	default:
+		return nil, false
+	}
+}
+
+// StatusDirFileGet returns the read function for the file at `path`
+// within the status directory, or nil if there is no such file.
+func StatusDirFileGet(ctx context.Context, config libkbfs.Config,
+	path []string) func(context.Context) ([]byte, time.Time, error) {
+	switch len(path) {
+	case 1:
+		switch path[0] {
+		case statusDirCachesFileName:
+			return func(ctx context.Context) ([]byte, time.Time, error) {
+				return GetEncodedCacheStatus(ctx, config)
+			}
+		case statusDirErrorsFileName:
+			return GetEncodedErrors(config)
+		case statusDirJournalFileName:
+			return func(ctx context.Context) ([]byte, time.Time, error) {
+				return GetEncodedJournalServerStatus(ctx, config)
+			}
+		}
+	case 2:
+		t, ok := statusDirTlfTypes[path[0]]
+		if !ok {
+			return nil
+		}
+		fb, ok := config.KBFSOps().GetOpenFolders(ctx)[libkbfs.Favorite{
+			Name: path[1],
+			Type: t,
+		}]
+		if !ok {
+			return nil
+		}
+		return func(ctx context.Context) ([]byte, time.Time, error) {
+			return GetEncodedFolderStatus(ctx, config, fb)
+		}
+	}
+	return nil
+}
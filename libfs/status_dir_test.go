@@ -0,0 +1,71 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusDir(t *testing.T) {
+	ctx, h, fs, shutdown := makeFSWithJournal(t, "")
+	defer shutdown()
+
+	children, ok := StatusDirChildren(ctx, fs.config, nil)
+	require.True(t, ok)
+	require.Equal(t, map[string]bool{
+		"caches":  false,
+		"errors":  false,
+		"journal": false,
+		"private": true,
+		"public":  true,
+		"team":    true,
+	}, children)
+
+	t.Log("Each open TLF has a status file")
+	children, ok = StatusDirChildren(ctx, fs.config, []string{"private"})
+	require.True(t, ok)
+	require.Equal(t, map[string]bool{"user1": false}, children)
+	children, ok = StatusDirChildren(ctx, fs.config, []string{"public"})
+	require.True(t, ok)
+	require.Len(t, children, 0)
+	_, ok = StatusDirChildren(ctx, fs.config, []string{"private", "user1"})
+	require.False(t, ok)
+
+	read := StatusDirFileGet(ctx, fs.config, []string{"private", "user1"})
+	require.NotNil(t, read)
+	data, _, err := read(ctx)
+	require.NoError(t, err)
+	var status libkbfs.FolderBranchStatus
+	err = json.Unmarshal(data, &status)
+	require.NoError(t, err)
+	require.Equal(t, h.TlfID().String(), status.FolderID)
+
+	t.Log("The journal and cache files describe the whole device")
+	read = StatusDirFileGet(ctx, fs.config, []string{"journal"})
+	require.NotNil(t, read)
+	data, _, err = read(ctx)
+	require.NoError(t, err)
+	var jStatus libkbfs.JournalServerStatus
+	err = json.Unmarshal(data, &jStatus)
+	require.NoError(t, err)
+	require.Equal(t, 1, jStatus.JournalCount)
+	read = StatusDirFileGet(ctx, fs.config, []string{"caches"})
+	require.NotNil(t, read)
+	data, _, err = read(ctx)
+	require.NoError(t, err)
+	var cStatus CacheStatus
+	err = json.Unmarshal(data, &cStatus)
+	require.NoError(t, err)
+	require.Equal(t,
+		fs.config.BlockCache().GetCleanBytesCapacity(),
+		cStatus.CleanBytesCapacity)
+
+	require.Nil(t, StatusDirFileGet(ctx, fs.config, []string{"private", "user2"}))
+	require.Nil(t, StatusDirFileGet(ctx, fs.config, []string{"nope"}))
+}
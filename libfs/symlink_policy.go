@@ -0,0 +1,75 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SymlinkEscapePolicy says what a mount does with symlinks whose
+// targets are outside of the KBFS namespace.  Local tools that follow
+// such links could otherwise be tricked into reading or writing local
+// files that another folder member chose.
+type SymlinkEscapePolicy int
+
+const (
+	// SymlinkEscapeAllow resolves escaping symlinks like any
+	// other.
+	SymlinkEscapeAllow SymlinkEscapePolicy = iota
+	// SymlinkEscapeWarn resolves escaping symlinks, but logs a
+	// warning each time.
+	SymlinkEscapeWarn
+	// SymlinkEscapeDeny refuses to resolve escaping symlinks.
+	SymlinkEscapeDeny
+)
+
+// String implements the fmt.Stringer interface for SymlinkEscapePolicy.
+func (p SymlinkEscapePolicy) String() string {
+	switch p {
+	case SymlinkEscapeAllow:
+		return "allow"
+	case SymlinkEscapeWarn:
+		return "warn"
+	case SymlinkEscapeDeny:
+		return "deny"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseSymlinkEscapePolicy parses the name of a policy, as returned by
+// SymlinkEscapePolicy.String.
+func ParseSymlinkEscapePolicy(s string) (SymlinkEscapePolicy, error) {
+	for _, p := range []SymlinkEscapePolicy{
+		SymlinkEscapeAllow, SymlinkEscapeWarn, SymlinkEscapeDeny} {
+		if s == p.String() {
+			return p, nil
+		}
+	}
+	return SymlinkEscapeAllow, errors.Errorf(
+		"Unknown symlink escape policy %q; must be allow, warn or deny", s)
+}
+
+// SymlinkEscapes returns true if `target`, the target of the symlink
+// at the canonical KBFS path `linkPath` (starting with /keybase),
+// resolves to somewhere outside of the KBFS mount at `mountPoint`.
+func SymlinkEscapes(mountPoint, linkPath, target string) bool {
+	const root = "/keybase"
+	if filepath.IsAbs(target) {
+		if mountPoint == "" {
+			return true
+		}
+		mountPoint = filepath.Clean(mountPoint)
+		target = filepath.Clean(target)
+		return target != mountPoint &&
+			!strings.HasPrefix(target, mountPoint+string(filepath.Separator))
+	}
+	resolved := path.Join(path.Dir(linkPath), filepath.ToSlash(target))
+	return resolved != root && !strings.HasPrefix(resolved, root+"/")
+}
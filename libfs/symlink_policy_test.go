@@ -0,0 +1,42 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSymlinkEscapes(t *testing.T) {
+	const link = "/keybase/private/jdoe/dir/link"
+	for target, escapes := range map[string]bool{
+		"file":                           false,
+		"../other/file":                  false,
+		"../../../public/jdoe/file":      false,
+		"../../../..":                    true,
+		"../../../../etc/passwd":         true,
+		"/mnt/kb/private/jdoe/file":      false,
+		"/mnt/kb":                        false,
+		"/mnt/kbx/file":                  true,
+		"/etc/passwd":                    true,
+		"a/../../../../../../etc/passwd": true,
+	} {
+		require.Equal(t, escapes, SymlinkEscapes("/mnt/kb", link, target),
+			target)
+	}
+	require.True(t, SymlinkEscapes("", link, "/keybase/private/jdoe"))
+}
+
+func TestParseSymlinkEscapePolicy(t *testing.T) {
+	for _, p := range []SymlinkEscapePolicy{
+		SymlinkEscapeAllow, SymlinkEscapeWarn, SymlinkEscapeDeny} {
+		parsed, err := ParseSymlinkEscapePolicy(p.String())
+		require.NoError(t, err)
+		require.Equal(t, p, parsed)
+	}
+	_, err := ParseSymlinkEscapePolicy("follow")
+	require.Error(t, err)
+}
@@ -0,0 +1,31 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// GetEncodedSyncCacheReport returns a JSON-encoded report of how the
+// sync cache for a TLF differs from the TLF's latest revision,
+// without repairing anything.
+func GetEncodedSyncCacheReport(
+	ctx context.Context, config libkbfs.Config,
+	folderBranch libkbfs.FolderBranch) (
+	data []byte, t time.Time, err error) {
+	report, err := config.KBFSOps().VerifySyncCache(ctx, folderBranch, false)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	data, err = PrettyJSON(report)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return data, time.Time{}, nil
+}
@@ -20,6 +20,14 @@ const (
 	SyncEnable SyncAction = iota
 	// SyncDisable is to disable syncing for a TLF.
 	SyncDisable
+	// SyncRepair is to verify and repair the sync cache for a TLF.
+	SyncRepair
+	// SyncEnableWriteThrough is to make writes to a TLF skip the
+	// local journal.
+	SyncEnableWriteThrough
+	// SyncDisableWriteThrough is to make writes to a TLF go through
+	// the local journal again.
+	SyncDisableWriteThrough
 )
 
 func (a SyncAction) String() string {
@@ -28,6 +36,12 @@ func (a SyncAction) String() string {
 		return "Enable syncing"
 	case SyncDisable:
 		return "Disable syncing"
+	case SyncRepair:
+		return "Repair sync cache"
+	case SyncEnableWriteThrough:
+		return "Enable write-through"
+	case SyncDisableWriteThrough:
+		return "Disable write-through"
 	}
 	return fmt.Sprintf("SyncAction(%d)", int(a))
 }
@@ -48,6 +62,15 @@ func (a SyncAction) Execute(
 	case SyncDisable:
 		err = c.SetTlfSyncState(fb.Tlf, false)
 
+	case SyncRepair:
+		_, err = c.KBFSOps().VerifySyncCache(ctx, fb, true)
+
+	case SyncEnableWriteThrough:
+		err = libkbfs.SetTlfWriteThrough(ctx, c, h, true)
+
+	case SyncDisableWriteThrough:
+		err = libkbfs.SetTlfWriteThrough(ctx, c, h, false)
+
 	default:
 		return fmt.Errorf("Unknown action %s", a)
 	}
@@ -0,0 +1,53 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// TlfSettingsReport describes the settings in effect in a TLF.
+type TlfSettingsReport struct {
+	// Settings are the TLF's settings, if it has a valid settings
+	// file.
+	Settings *libkbfs.TlfSettings `json:",omitempty"`
+	// Error says why the TLF's settings file is being ignored.
+	Error string `json:",omitempty"`
+}
+
+// GetEncodedTlfSettingsReport returns a JSON-encoded
+// TlfSettingsReport for the given TLF.
+func GetEncodedTlfSettingsReport(
+	ctx context.Context, config libkbfs.Config,
+	folderBranch libkbfs.FolderBranch, h *libkbfs.TlfHandle) (
+	data []byte, t time.Time, err error) {
+	rootNode, _, err := config.KBFSOps().GetRootNode(
+		ctx, h, folderBranch.Branch)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var report TlfSettingsReport
+	settings, err := libkbfs.ReadTlfSettings(ctx, config.KBFSOps(), rootNode)
+	switch errors.Cause(err).(type) {
+	case nil:
+		if settings.Version != 0 {
+			report.Settings = &settings
+		}
+	case libkbfs.InvalidTlfSettingsError:
+		report.Error = errors.Cause(err).Error()
+	default:
+		return nil, time.Time{}, err
+	}
+
+	data, err = PrettyJSON(report)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return data, time.Time{}, nil
+}
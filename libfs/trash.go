@@ -0,0 +1,227 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// TrashDirName is the directory, at the root of each TLF, that
+// trashed entries are moved into instead of being removed.
+const TrashDirName = ".trash"
+
+// TrashName returns the name that the entry `name` gets in the trash
+// when it's trashed at time `t`, so that entries with the same name
+// don't collide there.
+func TrashName(name string, t time.Time) string {
+	return fmt.Sprintf("%s.%d", name, t.UnixNano())
+}
+
+// trashNameMinDigits is the number of digits of the timestamps of
+// names returned by TrashName since 2001, so that other names that
+// happen to end in a number aren't mistaken for trashed entries.
+const trashNameMinDigits = 19
+
+// parseTrashName returns the time at which the entry with the trash
+// name `name` was trashed, if it's a name returned by TrashName.
+func parseTrashName(name string) (time.Time, bool) {
+	i := strings.LastIndex(name, ".")
+	if i <= 0 || len(name)-i-1 < trashNameMinDigits {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(name[i+1:], 10, 64)
+	if err != nil || nanos < 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
+// InTrash returns true if `p`, relative to the root of its TLF, is the
+// trash directory or is within it.
+func InTrash(p string) bool {
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	return p == TrashDirName || strings.HasPrefix(p, TrashDirName+"/")
+}
+
+// UnlinkPolicy says what a mount does with files that are unlinked
+// through it.
+type UnlinkPolicy int
+
+const (
+	// UnlinkDelete removes unlinked files, like a local disk does.
+	UnlinkDelete UnlinkPolicy = iota
+	// UnlinkTrashIfEnabled moves unlinked files into the trash of
+	// TLFs whose settings ask for it, and removes them otherwise.
+	UnlinkTrashIfEnabled
+	// UnlinkTrash moves unlinked files into the trash of every TLF.
+	UnlinkTrash
+)
+
+// String implements the fmt.Stringer interface for UnlinkPolicy.
+func (p UnlinkPolicy) String() string {
+	switch p {
+	case UnlinkDelete:
+		return "delete"
+	case UnlinkTrashIfEnabled:
+		return "folder"
+	case UnlinkTrash:
+		return "trash"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseUnlinkPolicy parses the name of a policy, as returned by
+// UnlinkPolicy.String.
+func ParseUnlinkPolicy(s string) (UnlinkPolicy, error) {
+	for _, p := range []UnlinkPolicy{
+		UnlinkDelete, UnlinkTrashIfEnabled, UnlinkTrash} {
+		if s == p.String() {
+			return p, nil
+		}
+	}
+	return UnlinkDelete, errors.Errorf(
+		"Unknown unlink policy %q; must be delete, folder or trash", s)
+}
+
+// ShouldTrashUnlink returns true if, under `policy`, a file unlinked
+// from the TLF `folderBranch` should be moved into its trash.  The
+// settings of the TLF are cached, so this is cheap enough to call on
+// every unlink.  An invalid settings file counts as not asking for
+// the trash, and if the settings can't be read, false is returned
+// along with the error, so that the file can still be removed.
+func ShouldTrashUnlink(ctx context.Context, config libkbfs.Config,
+	policy UnlinkPolicy, folderBranch libkbfs.FolderBranch) (bool, error) {
+	switch policy {
+	case UnlinkTrash:
+		return true, nil
+	case UnlinkTrashIfEnabled:
+		settings, err := libkbfs.GetCachedTlfSettings(
+			ctx, config, folderBranch)
+		if err != nil {
+			return false, err
+		}
+		return settings.TrashUnlinks, nil
+	default:
+		return false, nil
+	}
+}
+
+// MoveToTrash moves the entry `name` out of `dir`, which is at `dirPath`
+// relative to the TLF root `rootNode`, into the same directory under
+// the TLF's trash, creating any missing directories on the way.  It
+// returns the entry's new path, relative to the TLF root.
+func MoveToTrash(ctx context.Context, kbfsOps libkbfs.KBFSOps,
+	clock libkbfs.Clock, rootNode, dir libkbfs.Node,
+	dirPath, name string) (string, error) {
+	trashDirPath := path.Join(TrashDirName, dirPath)
+	trashDir := rootNode
+	for _, c := range strings.Split(trashDirPath, "/") {
+		next, _, err := kbfsOps.Lookup(ctx, trashDir, c)
+		if _, ok := errors.Cause(err).(libkbfs.NoSuchNameError); ok {
+			next, _, err = kbfsOps.CreateDir(ctx, trashDir, c)
+		}
+		if err != nil {
+			return "", err
+		}
+		trashDir = next
+	}
+
+	trashName := TrashName(name, clock.Now())
+	err := kbfsOps.Rename(ctx, dir, name, trashDir, trashName)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(trashDirPath, trashName), nil
+}
+
+// PurgeTrash permanently removes the entries in the trash of the TLF
+// with root `rootNode` that were trashed more than `retention` before
+// `now`, along with any directories of the trash that are left empty.
+// Entries that weren't trashed with a name from TrashName are kept.
+// It returns the number of entries removed.
+func PurgeTrash(ctx context.Context, kbfsOps libkbfs.KBFSOps,
+	rootNode libkbfs.Node, now time.Time, retention time.Duration) (
+	int, error) {
+	if retention == libkbfs.HistoryRetentionForever {
+		return 0, nil
+	}
+	trashDir, _, err := kbfsOps.Lookup(ctx, rootNode, TrashDirName)
+	if _, ok := errors.Cause(err).(libkbfs.NoSuchNameError); ok {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	purged, _, err := purgeTrashDir(
+		ctx, kbfsOps, trashDir, now.Add(-retention))
+	if err != nil {
+		return purged, err
+	}
+	if purged == 0 {
+		return 0, nil
+	}
+	return purged, kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+}
+
+// purgeTrashDir removes the entries of `dir`, within the trash, that
+// were trashed before `cutoff`, and the subdirectories left empty.
+// Entries with names from TrashName, including whole directories,
+// were trashed; the other directories mirror the ones trashed
+// entries were in.  It returns the number of entries removed, and
+// whether `dir` is now empty.
+func purgeTrashDir(ctx context.Context, kbfsOps libkbfs.KBFSOps,
+	dir libkbfs.Node, cutoff time.Time) (
+	purged int, empty bool, err error) {
+	children, err := kbfsOps.GetDirChildren(ctx, dir)
+	if err != nil {
+		return 0, false, err
+	}
+	empty = true
+	for name, ei := range children {
+		if t, ok := parseTrashName(name); ok {
+			if !t.Before(cutoff) {
+				empty = false
+				continue
+			}
+			err := kbfsOps.RemoveTree(ctx, dir, name, nil)
+			if err != nil {
+				return purged, false, err
+			}
+			purged++
+			continue
+		}
+		if ei.Type != libkbfs.Dir {
+			empty = false
+			continue
+		}
+
+		child, _, err := kbfsOps.Lookup(ctx, dir, name)
+		if err != nil {
+			return purged, false, err
+		}
+		n, childEmpty, err := purgeTrashDir(ctx, kbfsOps, child, cutoff)
+		purged += n
+		if err != nil {
+			return purged, false, err
+		}
+		if !childEmpty {
+			empty = false
+			continue
+		}
+		err = kbfsOps.RemoveDir(ctx, dir, name)
+		if err != nil {
+			return purged, false, err
+		}
+	}
+	return purged, empty, nil
+}
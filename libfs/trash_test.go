@@ -0,0 +1,142 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUnlinkPolicy(t *testing.T) {
+	for _, p := range []UnlinkPolicy{
+		UnlinkDelete, UnlinkTrashIfEnabled, UnlinkTrash} {
+		parsed, err := ParseUnlinkPolicy(p.String())
+		require.NoError(t, err)
+		require.Equal(t, p, parsed)
+	}
+	_, err := ParseUnlinkPolicy("recycle")
+	require.Error(t, err)
+}
+
+func TestInTrash(t *testing.T) {
+	for p, in := range map[string]bool{
+		".trash":          true,
+		".trash/a":        true,
+		"/.trash/a/b":     true,
+		".trash/../a":     false,
+		".trashes/a":      false,
+		"a/.trash":        false,
+		"":                false,
+		".trash.12345678": false,
+	} {
+		require.Equal(t, in, InTrash(p), p)
+	}
+}
+
+func TestMoveToTrash(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	config := libkbfs.MakeTestConfigOrBust(t, "alice")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	clock := &libkbfs.TestClock{}
+	now := time.Unix(1500000000, 234)
+	clock.Set(now)
+	config.SetClock(clock)
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "alice", tlf.Private)
+	require.NoError(t, err)
+	kbfsOps := config.KBFSOps()
+	root, _, err := kbfsOps.GetOrCreateRootNode(ctx, h, libkbfs.MasterBranch)
+	require.NoError(t, err)
+	a, _, err := kbfsOps.CreateDir(ctx, root, "a")
+	require.NoError(t, err)
+	b, _, err := kbfsOps.CreateDir(ctx, a, "b")
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, b, "f", false, libkbfs.NoExcl)
+	require.NoError(t, err)
+
+	t.Log("Only trash if the policy and settings say to")
+	fb := root.GetFolderBranch()
+	trash, err := ShouldTrashUnlink(ctx, config, UnlinkDelete, fb)
+	require.NoError(t, err)
+	require.False(t, trash)
+	trash, err = ShouldTrashUnlink(ctx, config, UnlinkTrashIfEnabled, fb)
+	require.NoError(t, err)
+	require.False(t, trash)
+	trash, err = ShouldTrashUnlink(ctx, config, UnlinkTrash, fb)
+	require.NoError(t, err)
+	require.True(t, trash)
+	err = libkbfs.WriteTlfSettings(ctx, kbfsOps, root, libkbfs.TlfSettings{
+		Version:      libkbfs.TlfSettingsVersion,
+		TrashUnlinks: true,
+	})
+	require.NoError(t, err)
+	trash, err = ShouldTrashUnlink(ctx, config, UnlinkTrashIfEnabled, fb)
+	require.NoError(t, err)
+	require.True(t, trash)
+
+	t.Log("The trash mirrors the directory the file was in")
+	p, err := MoveToTrash(ctx, kbfsOps, clock, root, b, "a/b", "f")
+	require.NoError(t, err)
+	require.Equal(t, ".trash/a/b/f.1500000000000000234", p)
+	_, _, err = kbfsOps.Lookup(ctx, b, "f")
+	require.IsType(t, libkbfs.NoSuchNameError{}, err)
+	trashDir, _, err := kbfsOps.Lookup(ctx, root, TrashDirName)
+	require.NoError(t, err)
+	trashA, _, err := kbfsOps.Lookup(ctx, trashDir, "a")
+	require.NoError(t, err)
+	trashB, _, err := kbfsOps.Lookup(ctx, trashA, "b")
+	require.NoError(t, err)
+	_, ei, err := kbfsOps.Lookup(ctx, trashB, "f.1500000000000000234")
+	require.NoError(t, err)
+	require.Equal(t, libkbfs.File, ei.Type)
+
+	t.Log("Files at the root go to the root of the trash")
+	_, _, err = kbfsOps.CreateFile(ctx, root, "g", false, libkbfs.NoExcl)
+	require.NoError(t, err)
+	p, err = MoveToTrash(ctx, kbfsOps, clock, root, root, "", "g")
+	require.NoError(t, err)
+	require.Equal(t, ".trash/g.1500000000000000234", p)
+	_, _, err = kbfsOps.Lookup(ctx, trashDir, "g.1500000000000000234")
+	require.NoError(t, err)
+
+	err = kbfsOps.SyncAll(ctx, root.GetFolderBranch())
+	require.NoError(t, err)
+
+	t.Log("Only entries past their retention are purged")
+	_, _, err = kbfsOps.CreateFile(ctx, root, "h", false, libkbfs.NoExcl)
+	require.NoError(t, err)
+	clock.Add(time.Hour)
+	_, err = MoveToTrash(ctx, kbfsOps, clock, root, root, "", "h")
+	require.NoError(t, err)
+	purged, err := PurgeTrash(
+		ctx, kbfsOps, root, clock.Now(), 30*time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, 2, purged)
+	children, err := kbfsOps.GetDirChildren(ctx, trashDir)
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	require.Contains(t, children, TrashName("h", clock.Now()))
+
+	purged, err = PurgeTrash(ctx, kbfsOps, root, clock.Now(),
+		libkbfs.HistoryRetentionForever)
+	require.NoError(t, err)
+	require.Equal(t, 0, purged)
+}
+
+func TestParseTrashName(t *testing.T) {
+	now := time.Unix(1500000000, 234)
+	parsed, ok := parseTrashName(TrashName("a.txt", now))
+	require.True(t, ok)
+	require.True(t, now.Equal(parsed))
+	for _, name := range []string{"a", "v1.2", ".1500000000000000234", "a.b"} {
+		_, ok := parseTrashName(name)
+		require.False(t, ok, name)
+	}
+}
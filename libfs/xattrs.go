@@ -0,0 +1,90 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"strings"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// xattrPrefix is the namespace of the extended attributes KBFS
+// exposes.  Linux only lets unprivileged users get attributes in the
+// "user" namespace.
+const xattrPrefix = "user.kbfs."
+
+// The read-only extended attributes of every file and directory in a
+// TLF, which describe where its contents came from.
+const (
+	// XattrTlfID is the ID of the TLF.
+	XattrTlfID = xattrPrefix + "tlf_id"
+	// XattrRevision is the current revision of the TLF.
+	XattrRevision = xattrPrefix + "revision"
+	// XattrLastWriter is the last user to write to the entry.
+	XattrLastWriter = xattrPrefix + "last_writer"
+	// XattrLastDevice is the device that made the current revision
+	// of the TLF.  Devices aren't recorded per entry.
+	XattrLastDevice = xattrPrefix + "last_device"
+	// XattrSyncStatus is "Disabled" if the TLF isn't synced, and
+	// otherwise the prefetch status of the entry.
+	XattrSyncStatus = xattrPrefix + "sync_status"
+)
+
+// XattrChangedSinceOpen is "1" for a file that was changed elsewhere
+// since it was opened locally, and "0" otherwise.  Unlike the other
+// attributes, it depends on the state of the mount, so mounts serve it
+// themselves, for files only.
+const XattrChangedSinceOpen = xattrPrefix + "changed_since_open"
+
+// XattrNames lists the names of the extended attributes KBFS
+// exposes, in the order they should be listed.
+var XattrNames = []string{
+	XattrTlfID,
+	XattrRevision,
+	XattrLastWriter,
+	XattrLastDevice,
+	XattrSyncStatus,
+}
+
+// GetXattr returns the value of the named KBFS extended attribute of
+// `node`, and false if there is no such attribute.  Names outside of
+// the KBFS namespace are rejected without doing any work, since
+// kernels ask for attributes like "security.capability" on every
+// write.
+func GetXattr(ctx context.Context, config libkbfs.Config,
+	node libkbfs.Node, name string) (value []byte, ok bool, err error) {
+	if !strings.HasPrefix(name, xattrPrefix) {
+		return nil, false, nil
+	}
+
+	fb := node.GetFolderBranch()
+	switch name {
+	case XattrTlfID:
+		return []byte(fb.Tlf.String()), true, nil
+	case XattrRevision, XattrLastDevice:
+		status, _, err := config.KBFSOps().FolderStatus(ctx, fb)
+		if err != nil {
+			return nil, false, err
+		}
+		if name == XattrRevision {
+			return []byte(status.Revision.String()), true, nil
+		}
+		return []byte(status.HeadDevice), true, nil
+	case XattrLastWriter, XattrSyncStatus:
+		nmd, err := config.KBFSOps().GetNodeMetadata(ctx, node)
+		if err != nil {
+			return nil, false, err
+		}
+		if name == XattrLastWriter {
+			return []byte(nmd.LastWriterUnverified), true, nil
+		}
+		if !config.IsSyncedTlf(fb.Tlf) {
+			return []byte("Disabled"), true, nil
+		}
+		return []byte(nmd.PrefetchStatus), true, nil
+	}
+	return nil, false, nil
+}
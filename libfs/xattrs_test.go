@@ -0,0 +1,56 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfs
+
+import (
+	"testing"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetXattr(t *testing.T) {
+	ctx, h, fs := makeFS(t, "")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, fs.config)
+
+	f, err := fs.Create("a")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, fs.SyncAll())
+
+	node, _, err := fs.config.KBFSOps().Lookup(ctx, fs.root, "a")
+	require.NoError(t, err)
+	status, _, err := fs.config.KBFSOps().FolderStatus(
+		ctx, node.GetFolderBranch())
+	require.NoError(t, err)
+	require.Equal(t, "dev1", status.HeadDevice)
+
+	for _, n := range []libkbfs.Node{fs.root, node} {
+		values := make(map[string]string)
+		for _, name := range XattrNames {
+			value, ok, err := GetXattr(ctx, fs.config, n, name)
+			require.NoError(t, err)
+			require.True(t, ok, name)
+			values[name] = string(value)
+		}
+		require.Equal(t, map[string]string{
+			XattrTlfID:      h.TlfID().String(),
+			XattrRevision:   status.Revision.String(),
+			XattrLastWriter: "user1",
+			XattrLastDevice: status.HeadDevice,
+			XattrSyncStatus: "Disabled",
+		}, values)
+	}
+
+	t.Log("Other attributes don't exist")
+	for _, name := range []string{
+		"security.capability", "user.kbfs.bogus", "com.apple.FinderInfo"} {
+		_, ok, err := GetXattr(ctx, fs.config, node, name)
+		require.NoError(t, err)
+		require.False(t, ok, name)
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"strings"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// AcknowledgeBrokenProofsFile represents a write-only file where
+// writing a username acknowledges that user's broken proofs, for an
+// identify policy that denies access to their folders until then.
+type AcknowledgeBrokenProofsFile struct {
+	fs *FS
+}
+
+var _ fs.Node = (*AcknowledgeBrokenProofsFile)(nil)
+
+// Attr implements the fs.Node interface for AcknowledgeBrokenProofsFile.
+func (f *AcknowledgeBrokenProofsFile) Attr(
+	ctx context.Context, a *fuse.Attr) error {
+	a.Size = 0
+	a.Mode = 0222
+	return nil
+}
+
+var _ fs.Handle = (*AcknowledgeBrokenProofsFile)(nil)
+
+var _ fs.HandleWriter = (*AcknowledgeBrokenProofsFile)(nil)
+
+// Write implements the fs.HandleWriter interface for
+// AcknowledgeBrokenProofsFile.
+func (f *AcknowledgeBrokenProofsFile) Write(ctx context.Context,
+	req *fuse.WriteRequest, resp *fuse.WriteResponse) (err error) {
+	f.fs.log.CDebugf(ctx, "AcknowledgeBrokenProofsFile Write")
+	defer func() { err = f.fs.processError(ctx, libkbfs.WriteMode, err) }()
+	for _, username := range strings.Fields(string(req.Data)) {
+		err := libkbfs.AcknowledgeBrokenProofs(ctx, f.fs.config, username)
+		if err != nil {
+			return err
+		}
+	}
+	resp.Size = len(req.Data)
+	return nil
+}
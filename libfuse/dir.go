@@ -10,11 +10,13 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/keybase/kbfs/libfs"
 	"github.com/keybase/kbfs/libkbfs"
 	"github.com/keybase/kbfs/sysutils"
@@ -47,6 +49,21 @@ type Folder struct {
 	// FUSE Forget request.
 	nodes map[libkbfs.NodeID]fs.Node
 
+	// fileFlags caches the default flags of the files in this
+	// folder.
+	fileFlags libfs.FileFlagsCache
+
+	// readdirCursors maps a readdirCursorKey to the name of the
+	// entry just before that readdir offset, so a new handle can
+	// resume a listing where another one left off without starting
+	// over.
+	readdirCursors *lru.Cache
+	// readdirGen is bumped whenever the entries of any directory in
+	// this folder change, since that can shift the offsets of the
+	// entries after the change.  Cursors recorded under an older
+	// generation are ignored.
+	readdirGen uint64
+
 	// Protects the updateChan.
 	updateMu sync.Mutex
 	// updateChan is non-nil when the user disables updates via the
@@ -64,9 +81,18 @@ func newFolder(fl *FolderList, h *libkbfs.TlfHandle,
 		hPreferredName: hPreferredName,
 		nodes:          map[libkbfs.NodeID]fs.Node{},
 	}
+	cursors, err := lru.New(readdirCursorCacheSize)
+	if err != nil {
+		panic(err.Error())
+	}
+	f.readdirCursors = cursors
 	return f
 }
 
+func (f *Folder) getReaddirGen() uint64 {
+	return atomic.LoadUint64(&f.readdirGen)
+}
+
 func (f *Folder) name() tlf.CanonicalName {
 	f.handleMu.RLock()
 	defer f.handleMu.RUnlock()
@@ -80,6 +106,7 @@ func (f *Folder) processError(ctx context.Context,
 		return nil
 	}
 
+	err = libkbfs.ClassifyOpTimeout(ctx, f.fs.config, err)
 	f.fs.config.Reporter().ReportErr(ctx, f.name(), f.list.tlfType, mode, err)
 	// We just log the error as debug, rather than error, because it
 	// might just indicate an expected error such as an ENOENT.
@@ -241,9 +268,13 @@ func (f *Folder) localChangeInvalidate(ctx context.Context, node libkbfs.Node,
 // other hosts.
 func (f *Folder) BatchChanges(
 	ctx context.Context, changes []libkbfs.NodeChange, _ []libkbfs.NodeID) {
-	if !f.fs.conn.Protocol().HasInvalidate() {
-		// OSXFUSE 2.x does not support notifications
-		return
+	// Readdir offsets are invalidated by every change, including
+	// ones made through this mount.
+	for _, v := range changes {
+		if len(v.DirUpdated) > 0 {
+			atomic.AddUint64(&f.readdirGen, 1)
+			break
+		}
 	}
 	if origin, ok := ctx.Value(libfs.CtxAppIDKey).(*FS); ok && origin == f.fs {
 		return
@@ -251,12 +282,51 @@ func (f *Folder) BatchChanges(
 	if v := ctx.Value(libkbfs.CtxBackgroundSyncKey); v != nil {
 		return
 	}
+	f.noteRemoteChanges(ctx, changes)
+	if !f.fs.conn.Protocol().HasInvalidate() {
+		// OSXFUSE 2.x does not support notifications
+		return
+	}
 
 	// Handle in the background because we shouldn't lock during the
 	// notification.
 	f.fs.queueNotification(func() { f.batchChangesInvalidate(ctx, changes) })
 }
 
+// noteRemoteChanges marks the open files among `changes` as changed
+// since they were opened, and warns the user about each the first
+// time it happens.
+func (f *Folder) noteRemoteChanges(
+	ctx context.Context, changes []libkbfs.NodeChange) {
+	var changed []libkbfs.Node
+	for _, v := range changes {
+		if len(v.FileUpdated) == 0 {
+			continue
+		}
+		f.nodesMu.Lock()
+		n := f.nodes[v.Node.GetID()]
+		f.nodesMu.Unlock()
+		if file, ok := n.(*File); ok && file.noteRemoteChange() {
+			changed = append(changed, v.Node)
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+
+	// Notify in the background, like for invalidations, since
+	// getting the paths takes locks.
+	f.fs.queueNotification(func() {
+		for _, node := range changed {
+			err := libfs.NotifyOpenFileChanged(ctx, f.fs.config, node)
+			if err != nil {
+				f.fs.log.CDebugf(ctx, "Couldn't notify about a change "+
+					"to open file %s: %+v", node.GetBasename(), err)
+			}
+		}
+	})
+}
+
 func (f *Folder) batchChangesInvalidate(ctx context.Context,
 	changes []libkbfs.NodeChange) {
 	for _, v := range changes {
@@ -303,6 +373,39 @@ func (f *Folder) batchChangesInvalidate(ctx context.Context,
 	}
 }
 
+var _ libkbfs.NodeEvictionObserver = (*Folder)(nil)
+
+// EvictNodes implements the libkbfs.NodeEvictionObserver interface
+// for Folder, by asking the kernel to forget the given entries.  The
+// kernel then forgets their nodes once they're no longer open, which
+// releases them.
+func (f *Folder) EvictNodes(
+	ctx context.Context, changes []libkbfs.NodeChange) {
+	if !f.fs.conn.Protocol().HasInvalidate() {
+		// OSXFUSE 2.x does not support notifications
+		return
+	}
+
+	// Handle in the background because we shouldn't lock during the
+	// notification.
+	f.fs.queueNotification(func() {
+		for _, v := range changes {
+			f.nodesMu.Lock()
+			n, ok := f.nodes[v.Node.GetID()]
+			f.nodesMu.Unlock()
+			if !ok {
+				continue
+			}
+			for _, name := range v.DirUpdated {
+				err := f.fs.fuse.InvalidateEntry(n, name)
+				if err != nil && err != fuse.ErrNotCached {
+					f.fs.log.CDebugf(ctx, "FUSE invalidate error: %v", err)
+				}
+			}
+		}
+	})
+}
+
 // TlfHandleChange is called when the name of a folder changes.
 // Note that newHandle may be nil. Then the handle in the folder is used.
 // This is used on e.g. logout/login.
@@ -380,6 +483,18 @@ func (f *Folder) fillAttrWithUIDAndWritePerm(
 	return nil
 }
 
+// getFileFlags returns the default flags that apply to the file
+// named `name` in this folder.
+func (f *Folder) getFileFlags(
+	ctx context.Context, name string) libkbfs.FileFlags {
+	f.handleMu.RLock()
+	h := f.h
+	f.handleMu.RUnlock()
+	flags := f.fileFlags.Get(
+		ctx, f.fs.config, h, f.getFolderBranch().Branch)
+	return flags.ForFile(name)
+}
+
 func (f *Folder) isWriter(ctx context.Context) (bool, error) {
 	f.handleMu.RLock()
 	defer f.handleMu.RUnlock()
@@ -413,6 +528,22 @@ func (f *Folder) access(ctx context.Context, r *fuse.AccessRequest) error {
 	return nil
 }
 
+// getxattr serves the KBFS extended attributes of `node`, for both
+// Dir and File.  Unknown attributes aren't reported as errors, since
+// the kernel asks for some of them on every write.
+func (f *Folder) getxattr(ctx context.Context, node libkbfs.Node,
+	req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	value, ok, err := libfs.GetXattr(ctx, f.fs.config, node, req.Name)
+	if err != nil {
+		return f.processError(ctx, libkbfs.ReadMode, err)
+	}
+	if !ok {
+		return fuse.ErrNoXattr
+	}
+	resp.Xattr = value
+	return nil
+}
+
 // TODO: Expire TLF nodes periodically. See
 // https://keybase.atlassian.net/browse/KBFS-59 .
 
@@ -427,11 +558,12 @@ type DirInterface interface {
 	fs.NodeSymlinker
 	fs.NodeRenamer
 	fs.NodeRemover
-	fs.Handle
-	fs.HandleReadDirAller
+	fs.NodeOpener
 	fs.NodeForgetter
 	fs.NodeSetattrer
 	fs.NodeFsyncer
+	fs.NodeGetxattrer
+	fs.NodeListxattrer
 }
 
 // Dir represents a subdirectory of a KBFS top-level folder (including
@@ -451,8 +583,10 @@ func newDirWithInode(folder *Folder, node libkbfs.Node, inode uint64) *Dir {
 	return d
 }
 
-func newDir(folder *Folder, node libkbfs.Node) *Dir {
-	return newDirWithInode(folder, node, folder.fs.assignInode())
+// childInode returns the inode of this directory's entry `name`, with
+// block reference `ref`.
+func (d *Dir) childInode(ref libkbfs.BlockRef, name string) uint64 {
+	return stableInode(d.node.GetFolderBranch(), ref, d.inode, name)
 }
 
 var _ DirInterface = (*Dir)(nil)
@@ -562,24 +696,24 @@ func (d *Dir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.Lo
 		child := &File{
 			folder: d.folder,
 			node:   newNode,
-			inode:  d.folder.fs.assignInode(),
+			inode:  d.childInode(libkbfs.InitialNodeRef(newNode), req.Name),
 		}
 		d.folder.nodes[newNode.GetID()] = child
 		return child, nil
 
 	case libkbfs.Dir:
-		child := newDir(d.folder, newNode)
+		child := newDirWithInode(d.folder, newNode,
+			d.childInode(libkbfs.InitialNodeRef(newNode), req.Name))
 		d.folder.nodes[newNode.GetID()] = child
 		return child, nil
 
 	case libkbfs.Sym:
-		// Give each symlink instance a unique inode.  We don't get
-		// enough information about remote renames of syminks to be
-		// able to attach a constant inode to a given symlink.
+		// Symlinks have no block of their own, so their inode
+		// comes from their parent and name.
 		child := &Symlink{
 			parent: d,
 			name:   req.Name,
-			inode:  d.folder.fs.assignInode(),
+			inode:  d.childInode(libkbfs.BlockRef{}, req.Name),
 		}
 		// A Symlink is never included in Folder.nodes, as it doesn't
 		// have a libkbfs.Node to keep track of renames.
@@ -600,7 +734,8 @@ func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.Cr
 	d.folder.fs.log.CDebugf(ctx, "Dir Create %s", req.Name)
 	defer func() { err = d.folder.processError(ctx, libkbfs.WriteMode, err) }()
 
-	isExec := (req.Mode.Perm() & 0100) != 0
+	isExec := (req.Mode.Perm()&0100) != 0 ||
+		d.folder.getFileFlags(ctx, req.Name).Exec
 	excl := getEXCLFromCreateRequest(req)
 	newNode, ei, err := d.folder.fs.config.KBFSOps().CreateFile(
 		ctx, d.node, req.Name, isExec, excl)
@@ -611,7 +746,7 @@ func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.Cr
 	child := &File{
 		folder: d.folder,
 		node:   newNode,
-		inode:  d.folder.fs.assignInode(),
+		inode:  d.childInode(libkbfs.InitialNodeRef(newNode), req.Name),
 	}
 
 	// Create is normally followed an Attr call. Fuse uses the same context for
@@ -623,6 +758,7 @@ func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.Cr
 	if reqID, ok := ctx.Value(CtxIDKey).(string); ok {
 		child.eiCache.set(reqID, ei)
 	}
+	child.noteOpen(ctx, req.Pid, req.Flags)
 
 	d.folder.nodesMu.Lock()
 	d.folder.nodes[newNode.GetID()] = child
@@ -653,7 +789,8 @@ func (d *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (
 		return nil, err
 	}
 
-	child := newDir(d.folder, newNode)
+	child := newDirWithInode(d.folder, newNode,
+		d.childInode(libkbfs.InitialNodeRef(newNode), req.Name))
 	d.folder.nodesMu.Lock()
 	d.folder.nodes[newNode.GetID()] = child
 	d.folder.nodesMu.Unlock()
@@ -687,7 +824,7 @@ func (d *Dir) Symlink(ctx context.Context, req *fuse.SymlinkRequest) (
 	child := &Symlink{
 		parent: d,
 		name:   req.NewName,
-		inode:  d.folder.fs.assignInode(),
+		inode:  d.childInode(libkbfs.BlockRef{}, req.NewName),
 	}
 	return child, nil
 }
@@ -768,6 +905,11 @@ func (d *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) (err error) {
 	if req.Dir {
 		err = d.folder.fs.config.KBFSOps().RemoveDir(ctx, d.node, req.Name)
 	} else {
+		var trashed bool
+		trashed, err = d.maybeTrash(ctx, req.Name)
+		if err != nil || trashed {
+			return err
+		}
 		err = d.folder.fs.config.KBFSOps().RemoveEntry(ctx, d.node, req.Name)
 	}
 	if err != nil {
@@ -777,42 +919,67 @@ func (d *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) (err error) {
 	return nil
 }
 
-// ReadDirAll implements the fs.NodeReadDirAller interface for Dir.
-func (d *Dir) ReadDirAll(ctx context.Context) (res []fuse.Dirent, err error) {
-	ctx = d.folder.fs.config.MaybeStartTrace(
-		ctx, "Dir.ReadDirAll", d.node.GetBasename())
-	defer func() { d.folder.fs.config.MaybeFinishTrace(ctx, err) }()
-
-	d.folder.fs.log.CDebugf(ctx, "Dir ReadDirAll")
-	defer func() { err = d.folder.processError(ctx, libkbfs.ReadMode, err) }()
+// maybeTrash moves the file `name` into the trash of the TLF, if the
+// unlink policy says to, and returns true if it did.  Files already
+// in the trash are never trashed again, so the trash can be emptied
+// through the mount.  If the settings of the TLF can't be read, the
+// file is removed as usual.
+func (d *Dir) maybeTrash(ctx context.Context, name string) (bool, error) {
+	if d.folder.fs.unlinkPolicy == libfs.UnlinkDelete {
+		return false, nil
+	}
+	config := d.folder.fs.config
+	fb := d.folder.getFolderBranch()
+	trash, err := libfs.ShouldTrashUnlink(
+		ctx, config, d.folder.fs.unlinkPolicy, fb)
+	if err != nil {
+		d.folder.fs.log.CDebugf(ctx,
+			"Couldn't read the folder settings; removing %s: %+v", name, err)
+		return false, nil
+	}
+	if !trash {
+		return false, nil
+	}
 
-	children, err := d.folder.fs.config.KBFSOps().GetDirChildren(ctx, d.node)
+	kbfsOps := config.KBFSOps()
+	d.folder.handleMu.RLock()
+	h := d.folder.h
+	d.folder.handleMu.RUnlock()
+	rootNode, _, err := kbfsOps.GetRootNode(ctx, h, fb.Branch)
 	if err != nil {
-		return nil, err
+		return false, err
+	}
+	rootPath, err := kbfsOps.GetCanonicalPath(ctx, rootNode)
+	if err != nil {
+		return false, err
+	}
+	dirPath, err := kbfsOps.GetCanonicalPath(ctx, d.node)
+	if err != nil {
+		return false, err
+	}
+	dirPath = strings.TrimPrefix(strings.TrimPrefix(dirPath, rootPath), "/")
+	if libfs.InTrash(dirPath + "/" + name) {
+		return false, nil
 	}
 
-	for name, ei := range children {
-		fde := fuse.Dirent{
-			Name: name,
-			// Technically we should be setting the inode here, but
-			// since we don't have a proper node for each of these
-			// entries yet we can't generate one, because we don't
-			// have anywhere to save it.  So bazil.org/fuse will
-			// generate a random one for each entry, but doesn't store
-			// it anywhere, so it's safe.
-		}
-		switch ei.Type {
-		case libkbfs.File, libkbfs.Exec:
-			fde.Type = fuse.DT_File
-		case libkbfs.Dir:
-			fde.Type = fuse.DT_Dir
-		case libkbfs.Sym:
-			fde.Type = fuse.DT_Link
-		}
-		res = append(res, fde)
+	trashPath, err := libfs.MoveToTrash(ctx, kbfsOps,
+		config.Clock(), rootNode, d.node, dirPath, name)
+	if err != nil {
+		return false, err
 	}
-	d.folder.fs.log.CDebugf(ctx, "Returning %d entries", len(res))
-	return res, nil
+	d.folder.fs.log.CDebugf(ctx, "Moved %s to %s", name, trashPath)
+	d.folder.fs.maybePurgeTrash(rootNode)
+	return true, nil
+}
+
+// Open implements the fs.NodeOpener interface for Dir.  Each handle
+// streams the entries of the directory separately.
+func (d *Dir) Open(ctx context.Context, req *fuse.OpenRequest,
+	resp *fuse.OpenResponse) (fs.Handle, error) {
+	// Keep the node in the cache while it's open; see
+	// dirHandle.Release.
+	d.folder.fs.config.KBFSOps().PinNode(ctx, d.node)
+	return newDirHandle(d), nil
 }
 
 // Forget kernel reference to this node.
@@ -894,7 +1061,27 @@ func (d *Dir) Fsync(ctx context.Context, req *fuse.FsyncRequest) (err error) {
 		return err
 	}
 
-	return d.folder.fs.config.KBFSOps().SyncAll(ctx, d.node.GetFolderBranch())
+	// A directory fsync makes renames of fsynced files as durable
+	// as the files themselves, which backup tools rely on.
+	return libfs.Fsync(ctx, d.folder.fs.config, d.node.GetFolderBranch(),
+		d.folder.fs.fsyncMode)
+}
+
+// Getxattr implements the fs.NodeGetxattrer interface for Dir.
+func (d *Dir) Getxattr(ctx context.Context, req *fuse.GetxattrRequest,
+	resp *fuse.GetxattrResponse) (err error) {
+	ctx = d.folder.fs.config.MaybeStartTrace(ctx, "Dir.Getxattr",
+		fmt.Sprintf("%s %s", d.node.GetBasename(), req.Name))
+	defer func() { d.folder.fs.config.MaybeFinishTrace(ctx, err) }()
+
+	return d.folder.getxattr(ctx, d.node, req, resp)
+}
+
+// Listxattr implements the fs.NodeListxattrer interface for Dir.
+func (d *Dir) Listxattr(ctx context.Context, req *fuse.ListxattrRequest,
+	resp *fuse.ListxattrResponse) error {
+	resp.Append(libfs.XattrNames...)
+	return nil
 }
 
 // isNoSuchNameError checks for libkbfs.NoSuchNameError.
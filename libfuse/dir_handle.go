@@ -0,0 +1,248 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// dirPageSize is the number of entries a dirHandle fetches from KBFS
+// at a time.
+const dirPageSize = 500
+
+// direntOffPos is the position of the offset field within an encoded
+// FUSE dirent, after the inode.
+const direntOffPos = 8
+
+// readdirCursorCacheSize is the number of readdir offsets each Folder
+// remembers the position of.
+const readdirCursorCacheSize = 1000
+
+// readdirCursorKey identifies a readdir offset in a directory.
+type readdirCursorKey struct {
+	node libkbfs.NodeID
+	off  int64
+}
+
+// readdirCursor is the position a readdir offset stands for: just
+// after the entry named `after`, in the listing of generation `gen`
+// of the folder.
+type readdirCursor struct {
+	after string
+	gen   uint64
+}
+
+// dirHandle is an open handle to a Dir, which streams the entries of
+// the directory to readdir a page at a time, rather than building
+// the whole listing up front like fs.HandleReadDirAller does.
+//
+// Entries are always listed in name order, and the offset of an
+// entry, which the kernel passes back to ask for the entries that
+// follow it, is its index in the listing plus one.  So as long as a
+// directory doesn't change, its offsets stay the same across handles
+// and across restarts, which NFS re-exports and resumable scans rely
+// on.
+//
+// Only the entries fetched but not yet returned are kept, along with
+// the name of the last entry fetched, which is where the next page
+// starts.  Since pages are keyed by name rather than position,
+// entries added or removed while the directory is being listed don't
+// cause other entries to be skipped or repeated.  The name before
+// each offset returned is also remembered by the Folder, so that a
+// new handle seeking to it (like an NFS server does for every
+// request) picks up from there, rather than paging through the
+// directory from the start.  Those names are only used while no
+// directory in the folder has changed since they were remembered, so
+// that the same offset always means the same index in the current
+// listing, whether or not it's remembered: once a directory changes,
+// an offset from before the change picks up at that index of the new
+// listing, which may skip or repeat the entries around the change.
+type dirHandle struct {
+	d *Dir
+
+	lock sync.Mutex
+	// pending holds the entries fetched but not yet returned, and
+	// start is the index of pending[0] in the listing.
+	pending []libkbfs.DirChild
+	start   int64
+	after   string
+	done    bool
+	// gen is the folder's readdir generation when the listing that
+	// start counts from began.
+	gen uint64
+}
+
+func newDirHandle(d *Dir) *dirHandle {
+	return &dirHandle{d: d, gen: d.folder.getReaddirGen()}
+}
+
+var _ fs.HandleReleaser = (*dirHandle)(nil)
+
+// Release implements the fs.HandleReleaser interface for dirHandle.
+func (dh *dirHandle) Release(
+	ctx context.Context, req *fuse.ReleaseRequest) error {
+	dh.d.folder.fs.config.KBFSOps().UnpinNode(ctx, dh.d.node)
+	return nil
+}
+
+var _ fs.HandleReader = (*dirHandle)(nil)
+
+func (dh *dirHandle) resetLocked() {
+	dh.pending = nil
+	dh.start = 0
+	dh.after = ""
+	dh.done = false
+	dh.gen = dh.d.folder.getReaddirGen()
+}
+
+// fetchLocked appends the next page of entries to pending.
+func (dh *dirHandle) fetchLocked(ctx context.Context) error {
+	children, err := dh.d.folder.fs.config.KBFSOps().GetDirChildrenPage(
+		ctx, dh.d.node, dh.after, dirPageSize)
+	if err != nil {
+		return err
+	}
+	dh.pending = append(dh.pending, children...)
+	if len(children) > 0 {
+		dh.after = children[len(children)-1].Name
+	}
+	if len(children) < dirPageSize {
+		dh.done = true
+	}
+	return nil
+}
+
+func (dh *dirHandle) cursorKey(off int64) readdirCursorKey {
+	return readdirCursorKey{dh.d.node.GetID(), off}
+}
+
+// seekLocked drops the pending entries before index `off`, fetching
+// more entries as needed to get there.
+func (dh *dirHandle) seekLocked(ctx context.Context, off int64) error {
+	if off == 0 {
+		// A rewinddir(3).
+		dh.resetLocked()
+		return nil
+	}
+	if off < dh.start || off > dh.start+int64(len(dh.pending)) {
+		key := dh.cursorKey(off)
+		if c, ok := dh.d.folder.readdirCursors.Get(key); ok {
+			cursor := c.(readdirCursor)
+			if cursor.gen == dh.d.folder.getReaddirGen() {
+				dh.pending = nil
+				dh.start = off
+				dh.after = cursor.after
+				dh.done = false
+				dh.gen = cursor.gen
+				return nil
+			}
+			// The directory may have changed since, so the name
+			// might not be at this offset anymore.
+			dh.d.folder.readdirCursors.Remove(key)
+		}
+		if off < dh.start {
+			// The entry was already dropped, so start over.
+			dh.resetLocked()
+		}
+	}
+	for {
+		skip := off - dh.start
+		if skip > int64(len(dh.pending)) {
+			skip = int64(len(dh.pending))
+		}
+		dh.pending = dh.pending[skip:]
+		dh.start += skip
+		if dh.start == off || dh.done {
+			return nil
+		}
+		err := dh.fetchLocked(ctx)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (dh *dirHandle) makeDirent(c libkbfs.DirChild) fuse.Dirent {
+	fde := fuse.Dirent{
+		Name:  c.Name,
+		Inode: dh.d.childInode(c.Ref, c.Name),
+	}
+	switch c.Type {
+	case libkbfs.File, libkbfs.Exec:
+		fde.Type = fuse.DT_File
+	case libkbfs.Dir:
+		fde.Type = fuse.DT_Dir
+	case libkbfs.Sym:
+		fde.Type = fuse.DT_Link
+	}
+	return fde
+}
+
+// Read implements the fs.HandleReader interface for dirHandle.  It's
+// only called for readdir.
+func (dh *dirHandle) Read(ctx context.Context, req *fuse.ReadRequest,
+	resp *fuse.ReadResponse) (err error) {
+	d := dh.d
+	ctx = d.folder.fs.config.MaybeStartTrace(
+		ctx, "Dir.Readdir", d.node.GetBasename())
+	defer func() { d.folder.fs.config.MaybeFinishTrace(ctx, err) }()
+
+	d.folder.fs.log.CDebugf(ctx, "Dir Readdir off=%d size=%d",
+		req.Offset, req.Size)
+	defer func() { err = d.folder.processError(ctx, libkbfs.ReadMode, err) }()
+
+	if !req.Dir {
+		return fuse.ENOTSUP
+	}
+
+	dh.lock.Lock()
+	defer dh.lock.Unlock()
+	err = dh.seekLocked(ctx, req.Offset)
+	if err != nil {
+		return err
+	}
+
+	data := resp.Data[:0]
+	i := 0
+	for {
+		if i == len(dh.pending) {
+			if dh.done {
+				break
+			}
+			err := dh.fetchLocked(ctx)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		pos := len(data)
+		data = fuse.AppendDirent(data, dh.makeDirent(dh.pending[i]))
+		if len(data) > req.Size {
+			data = data[:pos]
+			break
+		}
+		i++
+		// Replace the byte offset bazil.org/fuse sets with the index
+		// of the next entry.  All the platforms FUSE runs KBFS on
+		// are little-endian.
+		binary.LittleEndian.PutUint64(
+			data[pos+direntOffPos:], uint64(dh.start)+uint64(i))
+	}
+	resp.Data = data
+	// Only remember where this offset is if the listing it counts
+	// from is still current.
+	if i > 0 && dh.gen == d.folder.getReaddirGen() {
+		d.folder.readdirCursors.Add(dh.cursorKey(dh.start+int64(i)),
+			readdirCursor{dh.pending[i-1].Name, dh.gen})
+	}
+	d.folder.fs.log.CDebugf(ctx, "Returning %d entries", i)
+	return nil
+}
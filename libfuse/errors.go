@@ -13,6 +13,7 @@ import (
 	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfsmd"
 	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
 )
 
 type errorWithErrno struct {
@@ -27,9 +28,19 @@ func (e errorWithErrno) Errno() fuse.Errno {
 }
 
 func filterError(err error) error {
+	if errors.Cause(err) == context.Canceled {
+		// The fuse library only answers an interrupted request with
+		// EINTR if the handler returns exactly context.Canceled, so
+		// strip any wrapping.  Otherwise the interrupted process
+		// would get EIO instead.
+		return context.Canceled
+	}
+
 	switch errors.Cause(err).(type) {
 	case kbfsblock.ServerErrorUnauthorized:
 		return errorWithErrno{err, syscall.EACCES}
+	case kbfsblock.ServerErrorOverQuota:
+		return errorWithErrno{err, syscall.EDQUOT}
 
 	case kbfsmd.ServerErrorUnauthorized:
 		return errorWithErrno{err, syscall.EACCES}
@@ -52,6 +63,10 @@ func filterError(err error) error {
 		return errorWithErrno{err, syscall.ENOENT}
 	case libkbfs.WriteToReadonlyNodeError:
 		return errorWithErrno{err, syscall.EACCES}
+	case libkbfs.FolderFrozenError:
+		return errorWithErrno{err, syscall.EACCES}
+	case libkbfs.WritesFrozenError:
+		return errorWithErrno{err, syscall.EAGAIN}
 	case libkbfs.UnsupportedOpInUnlinkedDirError:
 		return errorWithErrno{err, syscall.ENOENT}
 	case libkbfs.NeedSelfRekeyError:
@@ -62,6 +77,8 @@ func filterError(err error) error {
 		return errorWithErrno{err, syscall.EINVAL}
 	case libkbfs.NameTooLongError:
 		return errorWithErrno{err, syscall.ENAMETOOLONG}
+	case libkbfs.FileTooBigError:
+		return errorWithErrno{err, syscall.EFBIG}
 	case libkbfs.NoCurrentSessionError:
 		return errorWithErrno{err, syscall.EACCES}
 	case libkbfs.NoSuchFolderListError:
@@ -72,6 +89,10 @@ func filterError(err error) error {
 		return errorWithErrno{err, syscall.ENOSPC}
 	case libkbfs.RevGarbageCollectedError:
 		return errorWithErrno{err, syscall.ENOENT}
+	case libkbfs.ServerSlowError:
+		return errorWithErrno{err, syscall.EAGAIN}
+	case libkbfs.OfflineError:
+		return errorWithErrno{err, syscall.EIO}
 	}
 	return err
 }
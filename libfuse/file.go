@@ -7,10 +7,13 @@ package libfuse
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"sync"
+	"sync/atomic"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libfs"
 	"github.com/keybase/kbfs/libkbfs"
 	"golang.org/x/net/context"
 )
@@ -60,6 +63,15 @@ type File struct {
 	inode  uint64
 
 	eiCache eiCacheHolder
+
+	// syncOpens counts the open handles of this file that asked
+	// for synchronous I/O.  Accessed atomically.
+	syncOpens int32
+	// opens counts all the open handles of this file, and
+	// changedSinceOpen is 1 if the file was changed elsewhere while
+	// it was open.  Both are accessed atomically.
+	opens            int32
+	changedSinceOpen int32
 }
 
 var _ fs.Node = (*File)(nil)
@@ -71,9 +83,13 @@ func (f *File) fillAttrWithMode(
 		return err
 	}
 	a.Mode |= 0400
-	if ei.Type == libkbfs.Exec {
+	flags := f.folder.getFileFlags(ctx, f.node.GetBasename())
+	if ei.Type == libkbfs.Exec || flags.Exec {
 		a.Mode |= 0100
 	}
+	if flags.ReadOnly {
+		a.Mode &^= 0222
+	}
 
 	a.Inode = f.inode
 	return nil
@@ -152,12 +168,16 @@ func (f *File) Access(ctx context.Context, r *fuse.AccessRequest) (err error) {
 			}
 			return err
 		}
-		if ei.Type != libkbfs.Exec {
+		if ei.Type != libkbfs.Exec &&
+			!f.folder.getFileFlags(ctx, f.node.GetBasename()).Exec {
 			return fuse.EPERM
 		}
 	}
 
 	if r.Mask&02 != 0 {
+		if f.folder.getFileFlags(ctx, f.node.GetBasename()).ReadOnly {
+			return fuse.EPERM
+		}
 		iw, err := f.folder.isWriter(ctx)
 		if err != nil {
 			return err
@@ -172,13 +192,64 @@ func (f *File) Access(ctx context.Context, r *fuse.AccessRequest) (err error) {
 
 var _ fs.NodeFsyncer = (*File)(nil)
 
-func (f *File) sync(ctx context.Context) error {
+func (f *File) sync(ctx context.Context, mode libfs.FsyncMode) error {
 	f.eiCache.destroy()
-	err := f.folder.fs.config.KBFSOps().SyncAll(ctx, f.node.GetFolderBranch())
-	if err != nil {
-		return err
+	return libfs.Fsync(
+		ctx, f.folder.fs.config, f.node.GetFolderBranch(), mode)
+}
+
+// fsyncMode returns what an fsync of this file waits for.  As long
+// as any handle opened it for synchronous I/O, fsyncs wait for the
+// servers.
+func (f *File) fsyncMode() libfs.FsyncMode {
+	if atomic.LoadInt32(&f.syncOpens) > 0 {
+		return libfs.FsyncServer
 	}
+	return f.folder.fs.fsyncMode
+}
+
+// noteOpen records a new handle opened by process `pid` with `flags`.
+// The node is kept in the cache while it's open; see Release.
+func (f *File) noteOpen(
+	ctx context.Context, pid uint32, flags fuse.OpenFlags) {
+	if flags&fuse.OpenSync != 0 {
+		atomic.AddInt32(&f.syncOpens, 1)
+	}
+	atomic.AddInt32(&f.opens, 1)
+	f.folder.fs.noteHandleOpened(f, pid, flags)
+	f.folder.fs.config.KBFSOps().PinNode(ctx, f.node)
+}
+
+// noteRemoteChange records that the file was changed elsewhere, and
+// returns true if it's open and wasn't already known to be changed.
+func (f *File) noteRemoteChange() bool {
+	if atomic.LoadInt32(&f.opens) == 0 {
+		return false
+	}
+	return atomic.CompareAndSwapInt32(&f.changedSinceOpen, 0, 1)
+}
+
+var _ fs.NodeOpener = (*File)(nil)
+
+// Open implements the fs.NodeOpener interface for File.
+func (f *File) Open(ctx context.Context, req *fuse.OpenRequest,
+	resp *fuse.OpenResponse) (fs.Handle, error) {
+	f.noteOpen(ctx, req.Pid, req.Flags)
+	return f, nil
+}
+
+var _ fs.HandleReleaser = (*File)(nil)
 
+// Release implements the fs.HandleReleaser interface for File.
+func (f *File) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	if req.Flags&fuse.OpenSync != 0 {
+		atomic.AddInt32(&f.syncOpens, -1)
+	}
+	if atomic.AddInt32(&f.opens, -1) == 0 {
+		atomic.StoreInt32(&f.changedSinceOpen, 0)
+	}
+	f.folder.fs.noteHandleReleased(f, req.Flags)
+	f.folder.fs.config.KBFSOps().UnpinNode(ctx, f.node)
 	return nil
 }
 
@@ -198,7 +269,9 @@ func (f *File) Fsync(ctx context.Context, req *fuse.FsyncRequest) (err error) {
 		return err
 	}
 
-	return f.sync(ctx)
+	mode := f.fsyncMode()
+	f.folder.fs.log.CDebugf(ctx, "Fsync mode: %s", mode)
+	return f.sync(ctx, mode)
 }
 
 var _ fs.Handle = (*File)(nil)
@@ -239,6 +312,13 @@ func (f *File) Write(ctx context.Context, req *fuse.WriteRequest,
 	f.folder.fs.log.CDebugf(ctx, "File Write sz=%d ", sz)
 	defer func() { err = f.folder.processError(ctx, libkbfs.WriteMode, err) }()
 
+	if f.folder.fs.remoteChangePolicy == libfs.RemoteChangeDeny &&
+		atomic.LoadInt32(&f.changedSinceOpen) != 0 {
+		f.folder.fs.log.CWarningf(ctx, "Denying write to %s, which was "+
+			"changed elsewhere since it was opened", f.node.GetBasename())
+		return fuse.EPERM
+	}
+
 	f.eiCache.destroy()
 	if err := f.folder.fs.config.KBFSOps().Write(
 		ctx, f.node, req.Data, req.Offset); err != nil {
@@ -263,6 +343,11 @@ func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest,
 
 	f.eiCache.destroy()
 
+	flags := f.folder.getFileFlags(ctx, f.node.GetBasename())
+	if flags.ReadOnly && (valid.Size() || valid.Mode() || valid.Mtime()) {
+		return fuse.EPERM
+	}
+
 	if valid.Size() {
 		if err := f.folder.fs.config.KBFSOps().Truncate(
 			ctx, f.node, req.Size); err != nil {
@@ -273,7 +358,8 @@ func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest,
 
 	if valid.Mode() {
 		// Unix has 3 exec bits, KBFS has one; we follow the user-exec bit.
-		exec := req.Mode&0100 != 0
+		// Folders of scripts keep their exec bits set.
+		exec := req.Mode&0100 != 0 || flags.Exec
 		err := f.folder.fs.config.KBFSOps().SetEx(
 			ctx, f.node, exec)
 		if err != nil {
@@ -320,6 +406,33 @@ func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest,
 	return f.attr(ctx, &resp.Attr)
 }
 
+var _ fs.NodeGetxattrer = (*File)(nil)
+
+// Getxattr implements the fs.NodeGetxattrer interface for File.
+func (f *File) Getxattr(ctx context.Context, req *fuse.GetxattrRequest,
+	resp *fuse.GetxattrResponse) (err error) {
+	ctx = f.folder.fs.config.MaybeStartTrace(ctx, "File.Getxattr",
+		fmt.Sprintf("%s %s", f.node.GetBasename(), req.Name))
+	defer func() { f.folder.fs.config.MaybeFinishTrace(ctx, err) }()
+
+	if req.Name == libfs.XattrChangedSinceOpen {
+		resp.Xattr = []byte(strconv.Itoa(
+			int(atomic.LoadInt32(&f.changedSinceOpen))))
+		return nil
+	}
+	return f.folder.getxattr(ctx, f.node, req, resp)
+}
+
+var _ fs.NodeListxattrer = (*File)(nil)
+
+// Listxattr implements the fs.NodeListxattrer interface for File.
+func (f *File) Listxattr(ctx context.Context, req *fuse.ListxattrRequest,
+	resp *fuse.ListxattrResponse) error {
+	resp.Append(libfs.XattrNames...)
+	resp.Append(libfs.XattrChangedSinceOpen)
+	return nil
+}
+
 var _ fs.NodeForgetter = (*File)(nil)
 
 // Forget kernel reference to this node.
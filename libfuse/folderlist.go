@@ -214,12 +214,19 @@ func (fl *FolderList) Lookup(ctx context.Context, req *fuse.LookupRequest, resp
 		return nil, err
 	}
 
+	if !fl.fs.namespaceFilter.AllowsFolder(fl.tlfType, h.GetCanonicalName()) {
+		fl.fs.log.CDebugf(ctx, "FL Folder %q is filtered out of the mount",
+			h.GetCanonicalName())
+		return nil, fuse.ENOENT
+	}
+
 	session, err := libkbfs.GetCurrentSessionIfPossible(
 		ctx, fl.fs.config.KBPKI(), h.Type() == tlf.Public)
 	if err != nil {
 		return nil, err
 	}
-	child := newTLF(fl, h, h.GetPreferredFormat(session.Name))
+	child := newTLF(
+		fl, h, h.GetPreferredFormat(session.Name), libkbfs.MasterBranch)
 	fl.folders[req.Name] = child
 	return child, nil
 }
@@ -260,6 +267,10 @@ func (fl *FolderList) ReadDirAll(ctx context.Context) (res []fuse.Dirent, err er
 		if fav.Type != fl.tlfType {
 			continue
 		}
+		if !fl.fs.namespaceFilter.AllowsFolder(
+			fl.tlfType, tlf.CanonicalName(fav.Name)) {
+			continue
+		}
 		pname, err := tlf.CanonicalToPreferredName(
 			session.Name, tlf.CanonicalName(fav.Name))
 		if err != nil {
@@ -5,11 +5,14 @@
 package libfuse
 
 import (
+	"encoding/binary"
+	"hash/fnv"
 	"net"
 	"net/http"
 	"net/http/pprof"
 	"os"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -58,8 +61,41 @@ type FS struct {
 
 	quotaUsage *libkbfs.EventuallyConsistentQuotaUsage
 
-	inodeLock sync.Mutex
-	nextInode uint64
+
+	// openFiles holds the open handles of each file, so they can be
+	// listed before unmounting.
+	openFilesLock sync.Mutex
+	openFiles     map[*File][]openHandle
+
+	// symlinkEscapePolicy says how to handle symlinks that point
+	// outside of `mountPoint`.
+	symlinkEscapePolicy libfs.SymlinkEscapePolicy
+	mountPoint          string
+
+	// fsyncMode says what an fsync waits for, unless the file was
+	// opened for synchronous I/O.
+	fsyncMode libfs.FsyncMode
+
+	// remoteChangePolicy says what to do about open files that are
+	// changed elsewhere.
+	remoteChangePolicy libfs.RemoteChangePolicy
+
+	// unlinkPolicy says whether unlinked files are moved into the
+	// trash of their TLF, rather than removed.
+	unlinkPolicy libfs.UnlinkPolicy
+
+	// lastTrashPurge holds when the trash of each TLF was last
+	// purged of the entries past their retention.
+	trashPurgeLock sync.Mutex
+	lastTrashPurge map[tlf.ID]time.Time
+
+	// identifyMode says how identifies behave for operations
+	// through this mount.
+	identifyMode libkbfs.IdentifyMode
+
+	// namespaceFilter limits the namespaces and folders this
+	// mount exposes.
+	namespaceFilter libfs.NamespaceFilter
 }
 
 func makeTraceHandler(renderFn func(http.ResponseWriter, *http.Request, bool)) func(http.ResponseWriter, *http.Request) {
@@ -120,25 +156,28 @@ func NewFS(config libkbfs.Config, conn *fuse.Conn, debug bool, platformParams Pl
 		notifications:  libfs.NewFSNotifications(log),
 		platformParams: platformParams,
 		quotaUsage:     libkbfs.NewEventuallyConsistentQuotaUsage(config, "FS"),
-		nextInode:      2, // root is 1
+		openFiles:      make(map[*File][]openHandle),
 	}
 	fs.root.private = &FolderList{
 		fs:      fs,
 		tlfType: tlf.Private,
 		folders: make(map[string]*TLF),
-		inode:   fs.assignInode(),
+		inode: stableInode(
+			libkbfs.FolderBranch{}, libkbfs.BlockRef{}, 1, PrivateName),
 	}
 	fs.root.public = &FolderList{
 		fs:      fs,
 		tlfType: tlf.Public,
 		folders: make(map[string]*TLF),
-		inode:   fs.assignInode(),
+		inode: stableInode(
+			libkbfs.FolderBranch{}, libkbfs.BlockRef{}, 1, PublicName),
 	}
 	fs.root.team = &FolderList{
 		fs:      fs,
 		tlfType: tlf.SingleTeam,
 		folders: make(map[string]*TLF),
-		inode:   fs.assignInode(),
+		inode: stableInode(
+			libkbfs.FolderBranch{}, libkbfs.BlockRef{}, 1, TeamName),
 	}
 	fs.execAfterDelay = func(d time.Duration, f func()) {
 		time.AfterFunc(d, f)
@@ -146,12 +185,179 @@ func NewFS(config libkbfs.Config, conn *fuse.Conn, debug bool, platformParams Pl
 	return fs
 }
 
-func (f *FS) assignInode() uint64 {
-	f.inodeLock.Lock()
-	defer f.inodeLock.Unlock()
-	next := f.nextInode
-	f.nextInode++
-	return next
+// stableInodeBit is set in every stable inode, keeping them apart
+// from the root's inode, 1.
+const stableInodeBit = 1 << 63
+
+// stableInode returns the inode of an entry in the folder branch `fb`
+// (the zero value for entries outside of any TLF).  It's a hash of
+// the TLF ID, the branch (which tells archived revisions of a TLF
+// apart) and the entry's block reference `ref`, so it doesn't depend
+// on where the entry is, and is the same across remounts and on every
+// device until the entry's contents change.  Callers should pass the
+// reference the entry had when its node was created
+// (libkbfs.InitialNodeRef), so a node keeps its inode while it's
+// being written.  Entries without blocks of their own (like
+// symlinks, TLF roots and the top-level folder lists) pass a zero
+// `ref`, and are hashed by the inode of their parent and their name
+// instead.  Lookups and readdir must use this same function, so
+// st_ino and d_ino agree.
+func stableInode(fb libkbfs.FolderBranch, ref libkbfs.BlockRef,
+	parent uint64, name string) uint64 {
+	var buf [8]byte
+	h := fnv.New64a()
+	h.Write(fb.Tlf.Bytes())
+	h.Write([]byte(fb.Branch))
+	h.Write([]byte{0})
+	if ref.IsValid() {
+		h.Write(ref.ID.Bytes())
+		h.Write(ref.RefNonce[:])
+	} else {
+		binary.BigEndian.PutUint64(buf[:], parent)
+		h.Write(buf[:])
+		h.Write([]byte(name))
+	}
+	return h.Sum64() | stableInodeBit
+}
+
+// trashPurgeInterval is how often the trash of a TLF is purged, at
+// most, when files are trashed through the mount.
+const trashPurgeInterval = time.Hour
+
+// maybePurgeTrash purges, in the background, the entries of the trash
+// of the TLF with root `rootNode` that are past the TLF's trash
+// retention, unless that was done recently.
+func (f *FS) maybePurgeTrash(rootNode libkbfs.Node) {
+	fb := rootNode.GetFolderBranch()
+	now := f.config.Clock().Now()
+	f.trashPurgeLock.Lock()
+	defer f.trashPurgeLock.Unlock()
+	if last, ok := f.lastTrashPurge[fb.Tlf]; ok &&
+		now.Sub(last) < trashPurgeInterval {
+		return
+	}
+	if f.lastTrashPurge == nil {
+		f.lastTrashPurge = make(map[tlf.ID]time.Time)
+	}
+	f.lastTrashPurge[fb.Tlf] = now
+
+	go func() {
+		ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+		defer libkbfs.CleanupCancellationDelayer(ctx)
+		settings, err := libkbfs.GetCachedTlfSettings(ctx, f.config, fb)
+		if err != nil {
+			f.log.CDebugf(ctx, "Couldn't read the settings of %s to "+
+				"purge its trash: %+v", fb.Tlf, err)
+			return
+		}
+		purged, err := libfs.PurgeTrash(ctx, f.config.KBFSOps(), rootNode,
+			now, settings.GetTrashRetention())
+		if err != nil {
+			f.log.CDebugf(ctx, "Couldn't purge the trash of %s: %+v",
+				fb.Tlf, err)
+			return
+		}
+		if purged > 0 {
+			f.log.CDebugf(ctx, "Purged %d entries from the trash of %s",
+				purged, fb.Tlf)
+		}
+	}()
+}
+
+// openHandle describes an open handle of a File.
+type openHandle struct {
+	pid   uint32
+	flags fuse.OpenFlags
+}
+
+// mode returns the access mode of the handle, as in
+// libkbfs.OpenFile.
+func (h openHandle) mode() string {
+	switch {
+	case h.flags.IsReadWrite():
+		return "rw"
+	case h.flags.IsWriteOnly():
+		return "w"
+	default:
+		return "r"
+	}
+}
+
+// noteHandleOpened records a new handle of `file`.
+func (f *FS) noteHandleOpened(file *File, pid uint32, flags fuse.OpenFlags) {
+	f.openFilesLock.Lock()
+	defer f.openFilesLock.Unlock()
+	f.openFiles[file] = append(f.openFiles[file], openHandle{pid, flags})
+}
+
+// noteHandleReleased forgets a handle of `file`.  The kernel doesn't
+// say which process is releasing it, so the first handle with the
+// same flags is forgotten.
+func (f *FS) noteHandleReleased(file *File, flags fuse.OpenFlags) {
+	f.openFilesLock.Lock()
+	defer f.openFilesLock.Unlock()
+	handles := f.openFiles[file]
+	for i, h := range handles {
+		if h.flags == flags {
+			handles = append(handles[:i], handles[i+1:]...)
+			break
+		}
+	}
+	if len(handles) == 0 {
+		delete(f.openFiles, file)
+	} else {
+		f.openFiles[file] = handles
+	}
+}
+
+// openFileHandles returns every open handle, sorted by path.
+func (f *FS) openFileHandles(ctx context.Context) []libkbfs.OpenFile {
+	f.openFilesLock.Lock()
+	openFiles := make(map[*File][]openHandle, len(f.openFiles))
+	for file, handles := range f.openFiles {
+		openFiles[file] = append([]openHandle(nil), handles...)
+	}
+	f.openFilesLock.Unlock()
+
+	var result []libkbfs.OpenFile
+	for file, handles := range openFiles {
+		p, err := f.config.KBFSOps().GetCanonicalPath(ctx, file.node)
+		if err != nil {
+			f.log.CDebugf(ctx, "Couldn't get the path of open file %s: %+v",
+				file.node.GetBasename(), err)
+			p = file.node.GetBasename()
+		}
+		unsynced, err := libkbfs.GetUnsyncedBytes(ctx, f.config, file.node)
+		if err != nil {
+			f.log.CDebugf(ctx, "Couldn't get the unsynced bytes of %s: %+v",
+				p, err)
+		}
+		for _, h := range handles {
+			result = append(result, libkbfs.OpenFile{
+				Path:          p,
+				Tlf:           file.node.GetFolderBranch().Tlf,
+				Pid:           int(h.pid),
+				Mode:          h.mode(),
+				UnsyncedBytes: unsynced,
+			})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+	return result
+}
+
+// openFilePaths returns the canonical paths of the files with open
+// handles, sorted.
+func (f *FS) openFilePaths(ctx context.Context) []string {
+	var paths []string
+	for _, of := range f.openFileHandles(ctx) {
+		if len(paths) == 0 || paths[len(paths)-1] != of.Path {
+			paths = append(paths, of.Path)
+		}
+	}
+	return paths
 }
 
 // tcpKeepAliveListener is copied from net/http/server.go, since it is
@@ -282,6 +488,7 @@ func (f *FS) WithContext(ctx context.Context) context.Context {
 	ctx, err := libkbfs.NewContextWithCancellationDelayer(
 		libkbfs.NewContextReplayable(ctx, func(ctx context.Context) context.Context {
 			ctx = context.WithValue(ctx, libfs.CtxAppIDKey, f)
+			ctx = libkbfs.WithIdentifyMode(ctx, f.identifyMode)
 			logTags := make(logger.CtxLogTags)
 			logTags[CtxIDKey] = CtxOpID
 			ctx = logger.NewContextWithLogTags(ctx, logTags)
@@ -315,12 +522,55 @@ func (f *FS) WithContext(ctx context.Context) context.Context {
 	return ctx
 }
 
+// opClassForRequest returns the class of operation that `req` is,
+// and whether it has one at all.  Requests that only tear down
+// kernel state, like forgetting a node, never time out.
+func opClassForRequest(req fuse.Request) (libkbfs.OpClass, bool) {
+	switch req.(type) {
+	case *fuse.LookupRequest, *fuse.GetattrRequest, *fuse.ReadRequest,
+		*fuse.OpenRequest, *fuse.AccessRequest, *fuse.ReadlinkRequest,
+		*fuse.GetxattrRequest, *fuse.ListxattrRequest, *fuse.StatfsRequest:
+		return libkbfs.OpClassRead, true
+	case *fuse.WriteRequest, *fuse.CreateRequest, *fuse.MkdirRequest,
+		*fuse.RemoveRequest, *fuse.RenameRequest, *fuse.SetattrRequest,
+		*fuse.SymlinkRequest, *fuse.LinkRequest, *fuse.FsyncRequest,
+		*fuse.FlushRequest:
+		return libkbfs.OpClassWrite, true
+	}
+	return 0, false
+}
+
+// withOpTimeout limits how long `req` may take, according to the
+// configured operation timeouts.  The caller must call the returned
+// CancelFunc once the request is done.
+func (f *FS) withOpTimeout(ctx context.Context, req fuse.Request) (
+	context.Context, context.CancelFunc) {
+	class, ok := opClassForRequest(req)
+	if !ok {
+		return ctx, func() {}
+	}
+	return f.config.OpTimeouts().WithTimeout(ctx, class)
+}
+
+// withRequestContext is the fs.Config.WithContext hook for `req`.
+// The request is only served after this returns, so the op timeout
+// is released once the serve loop cancels `ctx`, which it does when
+// the request completes.
+func (f *FS) withRequestContext(
+	ctx context.Context, req fuse.Request) context.Context {
+	reqCtx := ctx
+	ctx, cancel := f.withOpTimeout(f.WithContext(ctx), req)
+	go func() {
+		defer cancel()
+		<-reqCtx.Done()
+	}()
+	return ctx
+}
+
 // Serve FS. Will block.
 func (f *FS) Serve(ctx context.Context) error {
 	srv := fs.New(f.conn, &fs.Config{
-		WithContext: func(ctx context.Context, _ fuse.Request) context.Context {
-			return f.WithContext(ctx)
-		},
+		WithContext: f.withRequestContext,
 	})
 	f.fuse = srv
 
@@ -351,6 +601,7 @@ func (f *FS) processError(ctx context.Context,
 		return nil
 	}
 
+	err = libkbfs.ClassifyOpTimeout(ctx, f.config, err)
 	f.config.Reporter().ReportErr(ctx, "", tlf.Private, mode, err)
 	// We just log the error as debug, rather than error, because it
 	// might just indicate an expected error such as an ENOENT.
@@ -464,14 +715,16 @@ func (r *Root) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.L
 		return platformNode, err
 	}
 
-	switch req.Name {
-	case PrivateName:
-		return r.private, nil
-	case PublicName:
+	publicOnly := r.private.fs.config.PublicReadOnly()
+	filter := r.private.fs.namespaceFilter
+	switch {
+	case req.Name == PublicName && filter.AllowsNamespace(tlf.Public):
 		return r.public, nil
-	}
-
-	if req.Name == TeamName {
+	case req.Name == PrivateName && !publicOnly &&
+		filter.AllowsNamespace(tlf.Private):
+		return r.private, nil
+	case req.Name == TeamName && !publicOnly &&
+		filter.AllowsNamespace(tlf.SingleTeam):
 		return r.team, nil
 	}
 
@@ -521,19 +774,37 @@ var _ fs.HandleReadDirAller = (*Root)(nil)
 func (r *Root) ReadDirAll(ctx context.Context) (res []fuse.Dirent, err error) {
 	r.log().CDebugf(ctx, "FS ReadDirAll")
 	defer func() { err = r.private.fs.processError(ctx, libkbfs.ReadMode, err) }()
-	res = []fuse.Dirent{
-		{
-			Type: fuse.DT_Dir,
-			Name: PrivateName,
-		},
-		{
-			Type: fuse.DT_Dir,
-			Name: PublicName,
-		},
-		fuse.Dirent{
-			Type: fuse.DT_Dir,
-			Name: TeamName,
-		},
+	if r.private.fs.config.PublicReadOnly() {
+		res = []fuse.Dirent{
+			{
+				Type: fuse.DT_Dir,
+				Name: PublicName,
+			},
+		}
+	} else {
+		res = []fuse.Dirent{
+			{
+				Type: fuse.DT_Dir,
+				Name: PrivateName,
+			},
+			{
+				Type: fuse.DT_Dir,
+				Name: PublicName,
+			},
+			fuse.Dirent{
+				Type: fuse.DT_Dir,
+				Name: TeamName,
+			},
+		}
+	}
+	if filter := r.private.fs.namespaceFilter; !filter.IsEmpty() {
+		allowed := res[:0]
+		for _, d := range res {
+			if filter.AllowsNamespace(rootDirTlfType(d.Name)) {
+				allowed = append(allowed, d)
+			}
+		}
+		res = allowed
 	}
 	if r.private.fs.platformParams.shouldAppendPlatformRootDirs() {
 		res = append(res, platformRootDirs...)
@@ -545,6 +816,21 @@ func (r *Root) ReadDirAll(ctx context.Context) (res []fuse.Dirent, err error) {
 	return res, nil
 }
 
+// rootDirTlfType returns the type of the TLFs in the root directory
+// `name`.
+func rootDirTlfType(name string) tlf.Type {
+	switch name {
+	case PrivateName:
+		return tlf.Private
+	case PublicName:
+		return tlf.Public
+	case TeamName:
+		return tlf.SingleTeam
+	default:
+		return tlf.Unknown
+	}
+}
+
 func (r *Root) log() logger.Logger {
 	return r.private.fs.log
 }
@@ -0,0 +1,247 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libfs"
+	"golang.org/x/net/context"
+)
+
+const initializingFileContents = "KBFS is still starting up. Your " +
+	"folders will appear here as soon as it has connected to the " +
+	"Keybase service and logged in.\n"
+
+// LazyFS serves a mount before KBFS has finished initializing, so
+// that the mount is available right away at login, even if the
+// Keybase service isn't up yet.  Until SetFS is called, the root
+// lists the usual folder lists next to a placeholder file explaining
+// what's going on, and any lookup waits for initialization to
+// finish.  Afterwards, every request is passed on to the real FS.
+type LazyFS struct {
+	conn *fuse.Conn
+	log  logger.Logger
+	root lazyRoot
+
+	// Protects everything below.
+	lock    sync.RWMutex
+	fuse    *fs.Server
+	fs      *FS
+	initErr error
+	// readyCh is closed once either fs or initErr is set.
+	readyCh chan struct{}
+	started time.Time
+}
+
+// NewLazyFS returns a new LazyFS serving the mount on `conn`.
+func NewLazyFS(conn *fuse.Conn, log logger.Logger) *LazyFS {
+	l := &LazyFS{
+		conn:    conn,
+		log:     log,
+		readyCh: make(chan struct{}),
+		started: time.Now(),
+	}
+	l.root.l = l
+	return l
+}
+
+var _ fs.FS = (*LazyFS)(nil)
+
+var _ fs.FSStatfser = (*LazyFS)(nil)
+
+// getFS returns the real FS, or nil if it isn't ready yet.
+func (l *LazyFS) getFS() *FS {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	return l.fs
+}
+
+// waitForFS waits until the real FS is ready, or initialization
+// has failed.
+func (l *LazyFS) waitForFS(ctx context.Context) (*FS, error) {
+	select {
+	case <-l.readyCh:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if l.initErr != nil {
+		return nil, fuse.EIO
+	}
+	return l.fs, nil
+}
+
+// SetFS makes `f` serve all further requests.  `f` must have been
+// created with the same fuse connection as `l`.
+func (l *LazyFS) SetFS(ctx context.Context, f *FS) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	f.SetFuseConn(l.fuse, l.conn)
+	f.LaunchNotificationProcessor(ctx)
+	f.remoteStatus.Init(ctx, f.log, f.config, f)
+	l.fs = f
+	close(l.readyCh)
+	l.log.CDebugf(ctx, "Initialized after %s", time.Since(l.started))
+
+	// Make the kernel forget the placeholder.
+	srv := l.fuse
+	if srv == nil {
+		return
+	}
+	go func() {
+		_ = srv.InvalidateEntry(&l.root, libfs.HumanInitializingFileName)
+		_ = srv.InvalidateNodeData(&l.root)
+	}()
+}
+
+// InitFailed makes all further requests fail with an I/O error.
+func (l *LazyFS) InitFailed(err error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.initErr = err
+	close(l.readyCh)
+}
+
+// Serve serves the mount until it's unmounted.  Will block.
+func (l *LazyFS) Serve(ctx context.Context) error {
+	srv := fs.New(l.conn, &fs.Config{
+		WithContext: func(ctx context.Context, req fuse.Request) context.Context {
+			if f := l.getFS(); f != nil {
+				return f.withRequestContext(ctx, req)
+			}
+			return ctx
+		},
+	})
+	l.lock.Lock()
+	l.fuse = srv
+	if l.fs != nil {
+		l.fs.SetFuseConn(srv, l.conn)
+	}
+	l.lock.Unlock()
+	return srv.Serve(l)
+}
+
+// withFSContext adds the real FS's values to `ctx`, for requests that
+// arrived before it was ready.
+func withFSContext(ctx context.Context, f *FS) context.Context {
+	if ctx.Value(libfs.CtxAppIDKey) != nil {
+		return ctx
+	}
+	return f.WithContext(ctx)
+}
+
+// Root implements the fs.FS interface for LazyFS.
+func (l *LazyFS) Root() (fs.Node, error) {
+	return &l.root, nil
+}
+
+// Statfs implements the fs.FSStatfser interface for LazyFS.
+func (l *LazyFS) Statfs(ctx context.Context, req *fuse.StatfsRequest,
+	resp *fuse.StatfsResponse) error {
+	if f := l.getFS(); f != nil {
+		return f.Statfs(ctx, req, resp)
+	}
+	*resp = fuse.StatfsResponse{
+		Bsize:   fuseBlockSize,
+		Namelen: ^uint32(0),
+		Frsize:  fuseBlockSize,
+	}
+	return nil
+}
+
+// lazyRoot is the root of a LazyFS.  The kernel keeps the root node
+// for the life of the mount, so it stays in place after the real FS
+// is ready, and passes everything on to the real root.
+type lazyRoot struct {
+	l *LazyFS
+}
+
+var _ fs.Node = (*lazyRoot)(nil)
+
+// Attr implements the fs.Node interface for lazyRoot.
+func (r *lazyRoot) Attr(ctx context.Context, a *fuse.Attr) error {
+	if f := r.l.getFS(); f != nil {
+		return f.root.Attr(ctx, a)
+	}
+	a.Mode = os.ModeDir | 0500
+	a.Inode = 1
+	return nil
+}
+
+var _ fs.NodeAccesser = (*lazyRoot)(nil)
+
+// Access implements the fs.NodeAccesser interface for lazyRoot.
+func (r *lazyRoot) Access(ctx context.Context, req *fuse.AccessRequest) error {
+	// Root's access checks don't depend on its state.
+	return (*Root)(nil).Access(ctx, req)
+}
+
+var _ fs.NodeRequestLookuper = (*lazyRoot)(nil)
+
+// Lookup implements the fs.NodeRequestLookuper interface for lazyRoot.
+func (r *lazyRoot) Lookup(ctx context.Context, req *fuse.LookupRequest,
+	resp *fuse.LookupResponse) (fs.Node, error) {
+	if req.Name == libfs.HumanInitializingFileName && r.l.getFS() == nil {
+		resp.EntryValid = 0
+		return &SpecialReadFile{read: r.l.readInitializingFile}, nil
+	}
+	f, err := r.l.waitForFS(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return f.root.Lookup(withFSContext(ctx, f), req, resp)
+}
+
+var _ fs.NodeCreater = (*lazyRoot)(nil)
+
+// Create implements the fs.NodeCreater interface for lazyRoot.
+func (r *lazyRoot) Create(ctx context.Context, req *fuse.CreateRequest,
+	resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
+	f, err := r.l.waitForFS(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f.root.Create(withFSContext(ctx, f), req, resp)
+}
+
+var _ fs.NodeMkdirer = (*lazyRoot)(nil)
+
+// Mkdir implements the fs.NodeMkdirer interface for lazyRoot.
+func (r *lazyRoot) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (
+	fs.Node, error) {
+	f, err := r.l.waitForFS(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return f.root.Mkdir(withFSContext(ctx, f), req)
+}
+
+var _ fs.HandleReadDirAller = (*lazyRoot)(nil)
+
+// ReadDirAll implements the fs.HandleReadDirAller interface for
+// lazyRoot.
+func (r *lazyRoot) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	if f := r.l.getFS(); f != nil {
+		return f.root.ReadDirAll(withFSContext(ctx, f))
+	}
+	return []fuse.Dirent{
+		{Type: fuse.DT_Dir, Name: PrivateName},
+		{Type: fuse.DT_Dir, Name: PublicName},
+		{Type: fuse.DT_Dir, Name: TeamName},
+		{Type: fuse.DT_File, Name: libfs.HumanInitializingFileName},
+	}, nil
+}
+
+func (l *LazyFS) readInitializingFile(
+	ctx context.Context) ([]byte, time.Time, error) {
+	return []byte(initializingFileContents), l.started, nil
+}
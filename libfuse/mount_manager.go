@@ -0,0 +1,199 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// mountManager mounts and serves the file system, and implements
+// libkbfs.MountManager so that it can be unmounted and mounted again
+// through SimpleFS while the process keeps running.
+type mountManager struct {
+	kbCtx   libkbfs.Context
+	options StartOptions
+	log     logger.Logger
+	mi      *libfs.MountInterrupter
+
+	lock sync.Mutex
+	// config is nil until KBFS is initialized.
+	config libkbfs.Config
+	// mounter is nil while nothing is mounted, and fs is nil while
+	// the mount is served by a LazyFS without a real FS yet.
+	mounter *mounter
+	fs      *FS
+	// serveErr is the first error from serving a mount, if
+	// MountErrorIsFatal is set.
+	serveErr error
+}
+
+var _ libkbfs.MountManager = (*mountManager)(nil)
+
+func newMountManager(kbCtx libkbfs.Context, options StartOptions,
+	log logger.Logger, mi *libfs.MountInterrupter) *mountManager {
+	return &mountManager{
+		kbCtx:   kbCtx,
+		options: options,
+		log:     log,
+		mi:      mi,
+	}
+}
+
+// serveLocked calls `serve` in the background with the mount of `m`,
+// and forgets the mount once it returns.  mm.lock must be held.
+func (mm *mountManager) serveLocked(ctx context.Context, m *mounter,
+	serve func(context.Context) error) {
+	mm.mounter = m
+	ctx, cancel := context.WithCancel(ctx)
+	watchMountReady(ctx, m.c, mm.log, cancel)
+	go func() {
+		defer cancel()
+		mm.log.CDebugf(ctx, "Serving filesystem")
+		err := serve(ctx)
+		mm.log.CDebugf(ctx, "Ending: %+v", err)
+
+		mm.lock.Lock()
+		defer mm.lock.Unlock()
+		if mm.mounter == m {
+			mm.mounter = nil
+			mm.fs = nil
+		}
+		if err != nil && mm.options.MountErrorIsFatal {
+			if mm.serveErr == nil {
+				mm.serveErr = err
+			}
+			go mm.mi.Done()
+		}
+	}()
+}
+
+// mountLazily mounts the file system before KBFS is initialized, and
+// serves it in the background until it's unmounted.  The real FS is
+// handed to the returned LazyFS by `initialized`.
+func (mm *mountManager) mountLazily(ctx context.Context) (*LazyFS, error) {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+	m, err := mount(ctx, mm.kbCtx, mm.options, mm.log, mm.mi)
+	if err != nil {
+		return nil, err
+	}
+	lfs := NewLazyFS(m.c, mm.log)
+	mm.serveLocked(ctx, m, lfs.Serve)
+	return lfs, nil
+}
+
+// initialized records the config of the initialized KBFS, and hands
+// a real FS to `lfs`, if it's non-nil.
+func (mm *mountManager) initialized(ctx context.Context,
+	config libkbfs.Config, lfs *LazyFS) {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+	mm.config = config
+	if lfs == nil {
+		return
+	}
+	fs := newFSFromOptions(config, lfs.conn, mm.options)
+	lfs.SetFS(context.WithValue(ctx, libfs.CtxAppIDKey, fs), fs)
+	if mm.mounter != nil && mm.mounter.c == lfs.conn {
+		mm.fs = fs
+	}
+}
+
+// fatalError returns the error that ended serving a mount, if
+// MountErrorIsFatal is set.
+func (mm *mountManager) fatalError() error {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+	return mm.serveErr
+}
+
+// MountStatus implements the libkbfs.MountManager interface for
+// mountManager.
+func (mm *mountManager) MountStatus(ctx context.Context) (
+	libkbfs.MountStatus, error) {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+	status := libkbfs.MountStatus{
+		Mounted:    mm.mounter != nil,
+		MountPoint: mm.options.MountPoint,
+	}
+	if mm.fs != nil {
+		status.OpenFiles = mm.fs.openFilePaths(ctx)
+	}
+	return status, nil
+}
+
+// OpenFiles implements the libkbfs.MountManager interface for
+// mountManager.
+func (mm *mountManager) OpenFiles(ctx context.Context) (
+	[]libkbfs.OpenFile, error) {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+	if mm.fs == nil {
+		return nil, nil
+	}
+	return mm.fs.openFileHandles(ctx), nil
+}
+
+// Mount implements the libkbfs.MountManager interface for
+// mountManager.
+func (mm *mountManager) Mount(ctx context.Context) error {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+	if mm.mounter != nil {
+		return nil
+	}
+	if mm.config == nil {
+		return errors.New("KBFS isn't initialized yet")
+	}
+	m, err := mount(ctx, mm.kbCtx, mm.options, mm.log, mm.mi)
+	if err != nil {
+		return err
+	}
+
+	mm.log.CDebugf(ctx, "Creating filesystem")
+	fs := newFSFromOptions(mm.config, m.c, mm.options)
+	mm.fs = fs
+	mm.serveLocked(context.WithValue(
+		context.Background(), libfs.CtxAppIDKey, fs), m, fs.Serve)
+	return nil
+}
+
+// Unmount implements the libkbfs.MountManager interface for
+// mountManager.
+func (mm *mountManager) Unmount(ctx context.Context, force bool) error {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+	if mm.mounter == nil {
+		return nil
+	}
+	if mm.fs != nil && !force {
+		openFiles := mm.fs.openFilePaths(ctx)
+		if len(openFiles) > 0 {
+			return libkbfs.MountBusyError{
+				MountPoint: mm.options.MountPoint,
+				OpenFiles:  openFiles,
+			}
+		}
+	}
+
+	mm.log.CDebugf(ctx, "Unmounting: %q (force=%t)",
+		mm.options.MountPoint, force)
+	mm.mounter.forceUnmount = force
+	err := mm.mi.Unmount()
+	mm.mounter.forceUnmount = false
+	if err != nil {
+		return err
+	}
+	mm.mounter = nil
+	mm.fs = nil
+	return nil
+}
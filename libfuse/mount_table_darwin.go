@@ -0,0 +1,51 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build darwin
+
+package libfuse
+
+import (
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// darwinMntNoWait asks getfsstat for the cached mount list, rather
+// than asking each file system, which a hung FUSE mount never
+// answers.
+const darwinMntNoWait = 2
+
+func int8sToString(chars []int8) string {
+	b := make([]byte, 0, len(chars))
+	for _, c := range chars {
+		if c == 0 {
+			break
+		}
+		b = append(b, byte(c))
+	}
+	return string(b)
+}
+
+// isFUSEMounted returns whether the mount table lists a FUSE mount
+// at `dir`.
+func isFUSEMounted(dir string) (bool, error) {
+	n, err := syscall.Getfsstat(nil, darwinMntNoWait)
+	if err != nil {
+		return false, err
+	}
+	stats := make([]syscall.Statfs_t, n)
+	n, err = syscall.Getfsstat(stats, darwinMntNoWait)
+	if err != nil {
+		return false, err
+	}
+	dir = filepath.Clean(dir)
+	for _, stat := range stats[:n] {
+		if filepath.Clean(int8sToString(stat.Mntonname[:])) == dir &&
+			strings.Contains(int8sToString(stat.Fstypename[:]), "fuse") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,24 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package libfuse
+
+import "os"
+
+// procMountsPath lists what's mounted, without touching any of the
+// mounts themselves.
+const procMountsPath = "/proc/self/mounts"
+
+// isFUSEMounted returns whether the mount table lists a FUSE mount
+// at `dir`.
+func isFUSEMounted(dir string) (bool, error) {
+	f, err := os.Open(procMountsPath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return isFUSEMountedIn(f, dir)
+}
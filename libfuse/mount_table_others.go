@@ -0,0 +1,16 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build !linux,!darwin
+
+package libfuse
+
+import "errors"
+
+// isFUSEMounted isn't supported on platforms where we don't know
+// how to read the mount table without touching the mounts.
+func isFUSEMounted(dir string) (bool, error) {
+	return false, errors.New("reading the mount table isn't supported " +
+		"on this platform")
+}
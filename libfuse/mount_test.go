@@ -6,6 +6,7 @@ package libfuse
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -15,6 +16,7 @@ import (
 	"reflect"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
@@ -26,6 +28,7 @@ import (
 	"github.com/keybase/client/go/logger"
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/libfs"
 	"github.com/keybase/kbfs/libkbfs"
 	"github.com/keybase/kbfs/tlf"
@@ -47,6 +50,7 @@ func makeFS(t testing.TB, ctx context.Context, config *libkbfs.ConfigLocal) (
 		errLog:        log,
 		notifications: libfs.NewFSNotifications(log),
 		quotaUsage:    libkbfs.NewEventuallyConsistentQuotaUsage(config, "FSTest"),
+		openFiles:     make(map[*File][]openHandle),
 	}
 	filesys.root.private = &FolderList{
 		fs:      filesys,
@@ -481,6 +485,68 @@ func (k kbserviceBrokenIdentify) Identify(ctx context.Context, assertion,
 		errors.New("Fake identify error")
 }
 
+type kbserviceCountingIdentify struct {
+	libkbfs.KeybaseService
+	calls *int32
+}
+
+func (k kbserviceCountingIdentify) Identify(ctx context.Context, assertion,
+	reason string) (kbname.NormalizedUsername, keybase1.UserOrTeamID, error) {
+	atomic.AddInt32(k.calls, 1)
+	return k.KeybaseService.Identify(ctx, assertion, reason)
+}
+
+// Test that under the strict identify mode, every operation coming
+// through the mount runs a fresh identify, while the default mode
+// reuses the folder's earlier one.
+func TestStrictIdentifyModeIdentifiesEveryOp(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	defer libkbfs.CleanupCancellationDelayer(ctx)
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	rootNode := libkbfs.GetRootNodeOrBust(ctx, t, config, "jdoe", tlf.Private)
+	kbfsOps := config.KBFSOps()
+	_, _, err := kbfsOps.CreateFile(
+		ctx, rootNode, "a", false, libkbfs.NoExcl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch()); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int32
+	config.SetKeybaseService(kbserviceCountingIdentify{
+		KeybaseService: config.KeybaseService(),
+		calls:          &calls,
+	})
+	lookups := func(mode libkbfs.IdentifyMode) int32 {
+		filesys := &FS{
+			config:       config,
+			log:          logger.NewTestLogger(t),
+			identifyMode: mode,
+		}
+		before := atomic.LoadInt32(&calls)
+		for i := 0; i < 3; i++ {
+			opCtx := filesys.WithContext(context.Background())
+			_, _, err := kbfsOps.Lookup(opCtx, rootNode, "a")
+			libkbfs.CleanupCancellationDelayer(opCtx)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return atomic.LoadInt32(&calls) - before
+	}
+
+	if n := lookups(libkbfs.IdentifyModeDefault); n != 0 {
+		t.Fatalf("Default mode identified %d times, not 0", n)
+	}
+	if n := lookups(libkbfs.IdentifyModeStrict); n != 3 {
+		t.Fatalf("Strict mode identified %d times, not 3", n)
+	}
+}
+
 // Regression test for KBFS-772 on OSX.  (There's a bug where ls only
 // respects errors from Open, not from ReadDirAll.)
 func TestReaddirPublicFailedIdentifyViaOSCall(t *testing.T) {
@@ -842,6 +908,48 @@ func TestSymlink(t *testing.T) {
 	}()
 }
 
+func TestSymlinkEscapePolicy(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	defer libkbfs.CleanupCancellationDelayer(ctx)
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	mnt, filesys, cancelFn := makeFS(t, ctx, config)
+	defer mnt.Close()
+	defer cancelFn()
+	filesys.symlinkEscapePolicy = libfs.SymlinkEscapeDeny
+	filesys.mountPoint = mnt.Dir
+
+	dir := path.Join(mnt.Dir, PrivateName, "jdoe")
+	targets := map[string]bool{
+		"myfile":                            false,
+		"../jdoe/myfile":                    false,
+		path.Join(dir, "myfile"):            false,
+		"/etc/passwd":                       true,
+		"../../../etc/passwd":               true,
+		"../../../../" + path.Base(mnt.Dir): true,
+	}
+	i := 0
+	for target, escapes := range targets {
+		p := path.Join(dir, fmt.Sprintf("link%d", i))
+		i++
+		if err := os.Symlink(target, p); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.Readlink(p)
+		if escapes {
+			if !os.IsPermission(err) {
+				t.Errorf("%q: expected a permission error, got %v", target, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", target, err)
+		} else if got != target {
+			t.Errorf("bad symlink target: %q != %q", got, target)
+		}
+	}
+}
+
 func TestRename(t *testing.T) {
 	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
 	defer libkbfs.CleanupCancellationDelayer(ctx)
@@ -1168,6 +1276,56 @@ func TestRemoveFile(t *testing.T) {
 	}
 }
 
+func TestRemoveFileToTrash(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	defer libkbfs.CleanupCancellationDelayer(ctx)
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	mnt, filesys, cancelFn := makeFS(t, ctx, config)
+	defer mnt.Close()
+	defer cancelFn()
+	filesys.unlinkPolicy = libfs.UnlinkTrash
+
+	dir := path.Join(mnt.Dir, PrivateName, "jdoe")
+	if err := ioutil.Mkdir(path.Join(dir, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	p := path.Join(dir, "a", "myfile")
+	const input = "hello, world\n"
+	if err := ioutil.WriteFile(p, []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+	syncFilename(t, p)
+
+	if err := ioutil.Remove(p); err != nil {
+		t.Fatal(err)
+	}
+	checkDir(t, path.Join(dir, "a"), map[string]fileInfoCheck{})
+
+	trashDir := path.Join(dir, libfs.TrashDirName, "a")
+	fis, err := ioutil.ReadDir(trashDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 1 || !strings.HasPrefix(fis[0].Name(), "myfile.") {
+		t.Fatalf("Unexpected trash contents: %v", fis)
+	}
+	trashed := path.Join(trashDir, fis[0].Name())
+	buf, err := ioutil.ReadFile(trashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, e := string(buf), input; g != e {
+		t.Errorf("wrong content: %q != %q", g, e)
+	}
+
+	// Removing from the trash really removes.
+	if err := ioutil.Remove(trashed); err != nil {
+		t.Fatal(err)
+	}
+	checkDir(t, trashDir, map[string]fileInfoCheck{})
+}
+
 func TestRemoveTLF(t *testing.T) {
 	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
 	defer libkbfs.CleanupCancellationDelayer(ctx)
@@ -2518,6 +2676,57 @@ func TestInvalidateDataOnWrite(t *testing.T) {
 	}
 }
 
+func TestRemoteChangeDeniesWrite(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	defer libkbfs.CleanupCancellationDelayer(ctx)
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+	mnt1, _, cancelFn1 := makeFS(t, ctx, config)
+	defer mnt1.Close()
+	defer cancelFn1()
+	mnt2, fs2, cancelFn2 := makeFS(t, ctx, config)
+	defer mnt2.Close()
+	defer cancelFn2()
+	fs2.remoteChangePolicy = libfs.RemoteChangeDeny
+
+	p1 := path.Join(mnt1.Dir, PrivateName, "jdoe", "myfile")
+	if err := ioutil.WriteFile(p1, []byte("one"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	syncFilename(t, p1)
+
+	p2 := path.Join(mnt2.Dir, PrivateName, "jdoe", "myfile")
+	f, err := os.OpenFile(p2, os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("two"), 0); err != nil {
+		t.Fatalf("Write before the remote change failed: %v", err)
+	}
+
+	// Change the file through the other mount.
+	if err := ioutil.WriteFile(p1, []byte("three"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	syncFilename(t, p1)
+	syncFolderToServer(t, "jdoe", fs2)
+
+	_, err = f.WriteAt([]byte("four"), 0)
+	if !os.IsPermission(err) {
+		t.Fatalf("Write after the remote change didn't fail with EPERM: %v",
+			err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopening the file clears the warning.
+	if err := ioutil.WriteFile(p2, []byte("five"), 0644); err != nil {
+		t.Fatalf("Write after reopening failed: %v", err)
+	}
+	syncFilename(t, p2)
+}
+
 func TestInvalidatePublicDataOnWrite(t *testing.T) {
 	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
 	defer libkbfs.CleanupCancellationDelayer(ctx)
@@ -3947,3 +4156,535 @@ func TestInodes(t *testing.T) {
 		t.Fatal("New and old files have the same inode")
 	}
 }
+
+func TestInodesStableAcrossMounts(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	defer libkbfs.CleanupCancellationDelayer(ctx)
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	// Write the entries from a device without a mount, since a
+	// mount that makes an entry keeps its pre-sync inode until the
+	// kernel forgets it.
+	rootNode := libkbfs.GetRootNodeOrBust(ctx, t, config, "jdoe", tlf.Private)
+	kbfsOps := config.KBFSOps()
+	dirNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileNode, _, err := kbfsOps.CreateFile(
+		ctx, dirNode, "myfile", false, libkbfs.NoExcl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kbfsOps.Write(ctx, fileNode, []byte("data"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch()); err != nil {
+		t.Fatal(err)
+	}
+
+	config1 := libkbfs.ConfigAsUser(config, "jdoe")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config1)
+	mnt1, _, cancelFn1 := makeFS(t, ctx, config1)
+	defer mnt1.Close()
+	defer cancelFn1()
+
+	config2 := libkbfs.ConfigAsUser(config, "jdoe")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config2)
+	mnt2, _, cancelFn2 := makeFS(t, ctx, config2)
+	defer mnt2.Close()
+	defer cancelFn2()
+
+	p1 := path.Join(mnt1.Dir, PrivateName, "jdoe", "dir", "myfile")
+	getInode := func(p string) uint64 {
+		fi, err := ioutil.Lstat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stat, ok := fi.Sys().(*syscall.Stat_t)
+		if !ok {
+			t.Fatalf("Not a syscall.Stat_t")
+		}
+		return stat.Ino
+	}
+
+	p2 := path.Join(mnt2.Dir, PrivateName, "jdoe", "dir", "myfile")
+	for _, rel := range []string{"", "dir", path.Join("dir", "myfile")} {
+		i1 := getInode(path.Join(path.Dir(path.Dir(p1)), rel))
+		i2 := getInode(path.Join(path.Dir(path.Dir(p2)), rel))
+		if i1 != i2 {
+			t.Errorf("Inode for %q differs between mounts: %d vs %d",
+				rel, i1, i2)
+		}
+	}
+}
+
+func TestStableInodeSeeds(t *testing.T) {
+	tlfID := tlf.FakeID(1, tlf.Private)
+	master := libkbfs.FolderBranch{Tlf: tlfID, Branch: libkbfs.MasterBranch}
+	archived := libkbfs.FolderBranch{
+		Tlf: tlfID, Branch: libkbfs.MakeRevBranchName(2)}
+	other := libkbfs.FolderBranch{
+		Tlf: tlf.FakeID(2, tlf.Private), Branch: libkbfs.MasterBranch}
+	ref := libkbfs.BlockRef{ID: kbfsblock.FakeID(1)}
+
+	inode := stableInode(master, ref, 5, "a")
+	if inode2 := stableInode(master, ref, 6, "b"); inode2 != inode {
+		t.Fatalf("Inode depends on the path: %d vs %d", inode, inode2)
+	}
+	for _, fb := range []libkbfs.FolderBranch{archived, other} {
+		if i := stableInode(fb, ref, 5, "a"); i == inode {
+			t.Errorf("Inode for %s matches the master branch", fb)
+		}
+	}
+	ref2 := ref
+	ref2.RefNonce = kbfsblock.RefNonce{1}
+	if i := stableInode(master, ref2, 5, "a"); i == inode {
+		t.Error("Inode doesn't depend on the ref nonce")
+	}
+
+	t.Log("Entries without refs are hashed by their path")
+	noRef := stableInode(master, libkbfs.BlockRef{}, 5, "a")
+	if noRef == inode || noRef != stableInode(
+		master, libkbfs.BlockRef{}, 5, "a") {
+		t.Fatalf("Unexpected inode for an entry without a ref: %d", noRef)
+	}
+	if stableInode(master, libkbfs.BlockRef{}, 5, "b") == noRef {
+		t.Error("Entries without refs share an inode")
+	}
+}
+
+func TestInodesFromRefs(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	defer libkbfs.CleanupCancellationDelayer(ctx)
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	t.Log("Write the entries from a separate device, so both readers " +
+		"only see their synced refs")
+	rootNode := libkbfs.GetRootNodeOrBust(ctx, t, config, "jdoe", tlf.Private)
+	kbfsOps := config.KBFSOps()
+	dirNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = kbfsOps.CreateFile(ctx, dirNode, "f", false, libkbfs.NoExcl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch()); err != nil {
+		t.Fatal(err)
+	}
+
+	config1 := libkbfs.ConfigAsUser(config, "jdoe")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config1)
+	root1, shutdown1 := makeRootDirForTest(ctx, t, config1, "jdoe")
+	defer shutdown1()
+	config2 := libkbfs.ConfigAsUser(config, "jdoe")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config2)
+	root2, shutdown2 := makeRootDirForTest(ctx, t, config2, "jdoe")
+	defer shutdown2()
+
+	lookup := func(d *Dir, name string) (fs.Node, uint64) {
+		n, err := d.Lookup(ctx, &fuse.LookupRequest{Name: name},
+			&fuse.LookupResponse{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var a fuse.Attr
+		if err := n.Attr(ctx, &a); err != nil {
+			t.Fatal(err)
+		}
+		return n, a.Inode
+	}
+	checkReaddir := func(d *Dir, name string, inode uint64) {
+		names, _, inodes := readdirForTest(ctx, t, newDirHandle(d), 0, 4096)
+		for i, n := range names {
+			if n == name {
+				if inodes[i] != inode {
+					t.Fatalf("Readdir inode of %s is %d, not %d",
+						name, inodes[i], inode)
+				}
+				return
+			}
+		}
+		t.Fatalf("%s not found in %v", name, names)
+	}
+
+	dir1, dirInode := lookup(root1, "dir")
+	_, fInode := lookup(dir1.(*Dir), "f")
+	checkReaddir(root1, "dir", dirInode)
+	checkReaddir(dir1.(*Dir), "f", fInode)
+	dir2, dirInode2 := lookup(root2, "dir")
+	if dirInode2 != dirInode {
+		t.Fatalf("Inode differs between devices: %d vs %d",
+			dirInode, dirInode2)
+	}
+
+	t.Log("Renaming and writing keep the inodes of live nodes")
+	kbfsOps1 := config1.KBFSOps()
+	fNode, _, err := kbfsOps1.Lookup(ctx, dir1.(*Dir).node, "f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kbfsOps1.Write(ctx, fNode, []byte("data"), 0); err != nil {
+		t.Fatal(err)
+	}
+	err = kbfsOps1.Rename(ctx, root1.node, "dir", root1.node, "dir2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb := root1.node.GetFolderBranch()
+	if err := kbfsOps1.SyncAll(ctx, fb); err != nil {
+		t.Fatal(err)
+	}
+	checkReaddir(root1, "dir2", dirInode)
+	checkReaddir(dir1.(*Dir), "f", fInode)
+
+	t.Log("The other device keeps them too")
+	if err := config2.KBFSOps().SyncFromServer(ctx, fb, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkReaddir(root2, "dir2", dirInode)
+	var a fuse.Attr
+	if err := dir2.Attr(ctx, &a); err != nil {
+		t.Fatal(err)
+	}
+	if a.Inode != dirInode {
+		t.Fatalf("Inode changed after a remote rename: %d vs %d",
+			dirInode, a.Inode)
+	}
+}
+
+func makeTestWatchdog(t *testing.T, runChild func(ctx context.Context) error,
+	isStale func() bool) (w *Watchdog, cleanUps *int) {
+	cleanUps = new(int)
+	return &Watchdog{
+		mountPoint:      "/keybase",
+		log:             logger.NewTestLogger(t),
+		runChild:        runChild,
+		isStale:         isStale,
+		cleanUp:         func() { *cleanUps++ },
+		checkInterval:   time.Millisecond,
+		checkTimeout:    10 * time.Millisecond,
+		minRestartDelay: time.Millisecond,
+		maxRestartDelay: 4 * time.Millisecond,
+		healthyRunTime:  time.Minute,
+	}, cleanUps
+}
+
+func TestWatchdogRestartsDeadChild(t *testing.T) {
+	runs := 0
+	w, cleanUps := makeTestWatchdog(t, func(ctx context.Context) error {
+		runs++
+		if runs < 3 {
+			return errors.New("crashed")
+		}
+		return nil
+	}, func() bool { return false })
+	w.Run(context.Background())
+	if runs != 3 {
+		t.Fatalf("Unexpected number of runs: %d", runs)
+	}
+	if *cleanUps != 2 {
+		t.Fatalf("Unexpected number of clean-ups: %d", *cleanUps)
+	}
+}
+
+func TestWatchdogRemountsDeadMount(t *testing.T) {
+	runs := 0
+	stale := true
+	w, cleanUps := makeTestWatchdog(t, func(ctx context.Context) error {
+		runs++
+		if runs > 1 {
+			return nil
+		}
+		// The first child hangs on a dead mount until it's stopped.
+		<-ctx.Done()
+		return ctx.Err()
+	}, func() bool {
+		wasStale := stale
+		stale = false
+		return wasStale
+	})
+	w.Run(context.Background())
+	if runs != 2 {
+		t.Fatalf("Unexpected number of runs: %d", runs)
+	}
+	if *cleanUps != 1 {
+		t.Fatalf("Unexpected number of clean-ups: %d", *cleanUps)
+	}
+}
+
+func TestWatchdogRemountsHungMount(t *testing.T) {
+	runs := 0
+	hang := make(chan struct{})
+	defer close(hang)
+	var checks int32
+	w, cleanUps := makeTestWatchdog(t, func(ctx context.Context) error {
+		runs++
+		if runs > 1 {
+			return nil
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	}, func() bool {
+		if atomic.AddInt32(&checks, 1) == 1 {
+			// Like a stat of a hung FUSE mount.
+			<-hang
+		}
+		return false
+	})
+	w.Run(context.Background())
+	if runs != 2 {
+		t.Fatalf("Unexpected number of runs: %d", runs)
+	}
+	if *cleanUps != 1 {
+		t.Fatalf("Unexpected number of clean-ups: %d", *cleanUps)
+	}
+}
+
+func TestWatchdogKillsStuckChild(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		// The child ignores interrupts.
+		errCh <- runWatchdogChild(ctx, "/bin/sh",
+			[]string{"-c", "trap '' INT; exec sleep 60"}, 10*time.Millisecond)
+	}()
+	// Give the shell time to set up its trap.
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Fatal("Killed child exited cleanly")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Stuck child wasn't killed")
+	}
+}
+
+func TestWatchdogGivesUpOnUnreapableChild(t *testing.T) {
+	// The child never exits, like one stuck on its own hung mount.
+	done := make(chan error)
+	interrupts, kills := 0, 0
+	err := stopWatchdogChild(done, func() error {
+		interrupts++
+		return nil
+	}, func() error {
+		kills++
+		return nil
+	}, time.Millisecond)
+	if err != errChildNotReaped {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if interrupts != 1 || kills != 1 {
+		t.Fatalf("Unexpected interrupts=%d, kills=%d", interrupts, kills)
+	}
+}
+
+func TestIsFUSEMountedIn(t *testing.T) {
+	mounts := `proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0
+/dev/sda1 /home ext4 rw,relatime 0 0
+/dev/fuse /keybase fuse rw,nosuid,nodev,relatime,user_id=1000 0 0
+kbfs /home/alice/My\040Files fuse.kbfs rw,nosuid,nodev 0 0
+`
+	for dir, expected := range map[string]bool{
+		"/keybase":                 true,
+		"/keybase/":                true,
+		"/home/alice/My Files":     true,
+		"/home":                    false,
+		"/proc":                    false,
+		"/home/alice/My\\040Files": false,
+		"/mnt":                     false,
+	} {
+		mounted, err := isFUSEMountedIn(strings.NewReader(mounts), dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mounted != expected {
+			t.Errorf("Unexpected mounted=%t for %s", mounted, dir)
+		}
+	}
+}
+
+func TestWatchdogChildArgs(t *testing.T) {
+	args := []string{"-debug", "-watchdog", "--watchdog=true",
+		"-label=watchdog", "/keybase"}
+	childArgs := WatchdogChildArgs(args)
+	expected := []string{"-debug", "-label=watchdog", "/keybase"}
+	if !reflect.DeepEqual(childArgs, expected) {
+		t.Fatalf("Unexpected child args: %v", childArgs)
+	}
+}
+
+func TestLazyFSMountBeforeInit(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	defer libkbfs.CleanupCancellationDelayer(ctx)
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	log := logger.NewTestLogger(t)
+	var lfs *LazyFS
+	fn := func(mnt *fstestutil.Mount) fs.FS {
+		lfs = NewLazyFS(mnt.Conn, log)
+		lfs.fuse = mnt.Server
+		return lfs
+	}
+	mnt, err := fstestutil.MountedFuncT(t, fn, &fs.Config{
+		WithContext: func(ctx context.Context, req fuse.Request) context.Context {
+			if f := lfs.getFS(); f != nil {
+				return f.WithContext(ctx)
+			}
+			return ctx
+		},
+	}, GetPlatformSpecificMountOptionsForTest()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mnt.Close()
+
+	t.Log("The placeholder root is there right away")
+	checkDir(t, mnt.Dir, map[string]fileInfoCheck{
+		PrivateName: mustBeDir,
+		PublicName:  mustBeDir,
+		TeamName:    mustBeDir,
+		libfs.HumanInitializingFileName: func(fi os.FileInfo) error {
+			return mustBeFileWithSize(
+				fi, int64(len(initializingFileContents)))
+		},
+	})
+
+	t.Log("Lookups wait for initialization")
+	p := path.Join(mnt.Dir, PrivateName, "jdoe")
+	statErrCh := make(chan error, 1)
+	go func() {
+		_, err := ioutil.Lstat(p)
+		statErrCh <- err
+	}()
+	select {
+	case err := <-statErrCh:
+		t.Fatalf("Lookup finished before initialization: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	filesys := NewFS(config, mnt.Conn, false, PlatformParams{})
+	ctx, cancelFn := context.WithCancel(
+		context.WithValue(ctx, libfs.CtxAppIDKey, filesys))
+	defer cancelFn()
+	lfs.SetFS(ctx, filesys)
+	if err := <-statErrCh; err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("The placeholder is gone afterwards")
+	if _, err := ioutil.Lstat(path.Join(
+		mnt.Dir, libfs.HumanInitializingFileName)); !ioutil.IsNotExist(err) {
+		t.Fatalf("Placeholder still exists: %v", err)
+	}
+}
+
+// readdirForTest returns the names and offsets of the entries that
+// `dh` returns for a readdir at `off`, that fit in `size` bytes.
+func readdirForTest(ctx context.Context, t *testing.T, dh *dirHandle,
+	off int64, size int) (names []string, offs []int64, inodes []uint64) {
+	req := &fuse.ReadRequest{Dir: true, Offset: off, Size: size}
+	resp := &fuse.ReadResponse{Data: make([]byte, 0, size)}
+	if err := dh.Read(ctx, req, resp); err != nil {
+		t.Fatal(err)
+	}
+	// Each dirent is an inode, an offset, the name length and the
+	// type, followed by the name, padded to 8 bytes.
+	data := resp.Data
+	for len(data) > 0 {
+		nameLen := int(binary.LittleEndian.Uint32(data[16:]))
+		inodes = append(inodes, binary.LittleEndian.Uint64(data))
+		offs = append(offs, int64(binary.LittleEndian.Uint64(data[8:])))
+		names = append(names, string(data[24:24+nameLen]))
+		data = data[(24+nameLen+7)&^7:]
+	}
+	return names, offs, inodes
+}
+
+// makeRootDirForTest returns a Dir for the root of the private TLF
+// of `user`, without mounting it.
+func makeRootDirForTest(ctx context.Context, t *testing.T,
+	config libkbfs.Config, user string) (d *Dir, shutdown func()) {
+	log := logger.NewTestLogger(t)
+	filesys := &FS{
+		config: config,
+		conn:   &fuse.Conn{},
+		log:    log,
+		errLog: log,
+	}
+	fl := &FolderList{
+		fs:      filesys,
+		tlfType: tlf.Private,
+		folders: make(map[string]*TLF),
+	}
+	rootNode := libkbfs.GetRootNodeOrBust(ctx, t, config, user, tlf.Private)
+	h, err := config.KBFSOps().GetTLFHandle(ctx, rootNode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	folder := newFolder(fl, h, tlf.PreferredName(user))
+	if err := folder.setFolderBranch(rootNode.GetFolderBranch()); err != nil {
+		t.Fatal(err)
+	}
+	return newDirWithInode(folder, rootNode, 1), func() {
+		folder.unsetFolderBranch(ctx)
+	}
+}
+
+func TestDirHandleOffsetsAfterChange(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	defer libkbfs.CleanupCancellationDelayer(ctx)
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	d, shutdown := makeRootDirForTest(ctx, t, config, "jdoe")
+	defer shutdown()
+	rootNode, folder := d.node, d.folder
+	kbfsOps := config.KBFSOps()
+	for _, name := range []string{"a", "b", "c", "d"} {
+		_, _, err := kbfsOps.CreateFile(
+			ctx, rootNode, name, false, libkbfs.NoExcl)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Two entries with one-letter names fit in 64 bytes.
+	names, offs, _ := readdirForTest(ctx, t, newDirHandle(d), 0, 64)
+	if !reflect.DeepEqual(names, []string{"a", "b"}) ||
+		!reflect.DeepEqual(offs, []int64{1, 2}) {
+		t.Fatalf("Unexpected first page: %v %v", names, offs)
+	}
+	t.Log("A new handle picks up from the remembered offset")
+	names, offs, _ = readdirForTest(ctx, t, newDirHandle(d), 2, 1024)
+	if !reflect.DeepEqual(names, []string{"c", "d"}) ||
+		!reflect.DeepEqual(offs, []int64{3, 4}) {
+		t.Fatalf("Unexpected resumed page: %v %v", names, offs)
+	}
+
+	t.Log("After a change, the offset is an index into the new " +
+		"listing, the same as if it had never been remembered")
+	_, _, err := kbfsOps.CreateFile(
+		ctx, rootNode, "0", false, libkbfs.NoExcl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, offs, _ = readdirForTest(ctx, t, newDirHandle(d), 2, 1024)
+	if !reflect.DeepEqual(names, []string{"b", "c", "d"}) ||
+		!reflect.DeepEqual(offs, []int64{3, 4, 5}) {
+		t.Fatalf("Unexpected page after change: %v %v", names, offs)
+	}
+	folder.readdirCursors.Purge()
+	names2, offs2, _ := readdirForTest(ctx, t, newDirHandle(d), 2, 1024)
+	if !reflect.DeepEqual(names, names2) || !reflect.DeepEqual(offs, offs2) {
+		t.Fatalf("Uncached page %v %v doesn't match cached page %v %v",
+			names2, offs2, names, offs)
+	}
+}
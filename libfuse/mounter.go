@@ -5,17 +5,24 @@
 package libfuse
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"bazil.org/fuse"
 	"github.com/keybase/client/go/kbconst"
 	"github.com/keybase/client/go/logger"
+	"golang.org/x/net/context"
 )
 
 type mounter struct {
@@ -23,12 +30,161 @@ type mounter struct {
 	c       *fuse.Conn
 	log     logger.Logger
 	runMode kbconst.RunMode
+	// forceUnmount forces the next unmount even without ForceMount,
+	// cutting off any open files.
+	forceUnmount bool
+}
+
+// isStaleMountError returns true if the given error, from a stat of
+// a mountpoint, indicates that the mountpoint is a leftover FUSE
+// mount whose serving process has gone away.
+func isStaleMountError(err error) bool {
+	pathErr, ok := err.(*os.PathError)
+	if !ok {
+		return false
+	}
+	switch pathErr.Err {
+	case syscall.ENOTCONN, syscall.ECONNABORTED, syscall.ENXIO:
+		return true
+	default:
+		return false
+	}
+}
+
+// staleMountCheckTimeout bounds how long checking on, or detaching,
+// a FUSE mount may take; a hung mount never answers.
+const staleMountCheckTimeout = 10 * time.Second
+
+var errStatTimedOut = errors.New("timed out waiting for the mount")
+
+// statWithTimeout stats `dir`, giving up after `timeout`.  A stat
+// stuck on a hung mount is left behind in its own goroutine.
+func statWithTimeout(dir string, timeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := os.Stat(dir)
+		errCh <- err
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(timeout):
+		return errStatTimedOut
+	}
+}
+
+// unescapeMountsField undoes the octal escaping of spaces, tabs,
+// newlines and backslashes in a field of /proc/mounts.
+func unescapeMountsField(field string) string {
+	if !strings.Contains(field, "\\") {
+		return field
+	}
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+4 <= len(field) {
+			if c, err := strconv.ParseUint(field[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(field[i])
+	}
+	return b.String()
+}
+
+// isFUSEMountedIn returns whether `mounts`, in the format of
+// /proc/mounts, lists a FUSE mount at `dir`.
+func isFUSEMountedIn(mounts io.Reader, dir string) (bool, error) {
+	dir = filepath.Clean(dir)
+	scanner := bufio.NewScanner(mounts)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		fsType := fields[2]
+		if fsType != "fuse" && !strings.HasPrefix(fsType, "fuse.") {
+			continue
+		}
+		if filepath.Clean(unescapeMountsField(fields[1])) == dir {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// cleanUpStaleMount detaches the mountpoint if it's a dead FUSE
+// mount left behind by a previous process (e.g., one that crashed),
+// which would otherwise make every access to it hang or fail.  The
+// mountpoint itself is only looked at if the mount table lists a
+// FUSE mount there, and a mount that doesn't answer within
+// staleMountCheckTimeout counts as dead.
+func (m *mounter) cleanUpStaleMount() {
+	dir := m.options.MountPoint
+	mounted, err := isFUSEMounted(dir)
+	if err != nil {
+		m.log.Debug("Couldn't read the mount table: %+v", err)
+		return
+	}
+	if !mounted {
+		return
+	}
+	err = statWithTimeout(dir, staleMountCheckTimeout)
+	if err != errStatTimedOut && !isStaleMountError(err) {
+		return
+	}
+	m.log.Warning("Detected a stale mount at %s (%v); cleaning it up "+
+		"before remounting", dir, err)
+	m.detachMount()
+}
+
+// cleanUpDeadMount detaches whatever FUSE mount the mount table
+// lists at the mountpoint, without looking at the mount itself.  It's
+// for when the process that served the mount is known to be gone.
+func (m *mounter) cleanUpDeadMount() {
+	dir := m.options.MountPoint
+	mounted, err := isFUSEMounted(dir)
+	if err != nil {
+		m.log.Debug("Couldn't read the mount table: %+v", err)
+		return
+	}
+	if !mounted {
+		return
+	}
+	m.log.Warning("Cleaning up the dead mount at %s", dir)
+	m.detachMount()
+}
+
+// detachMount lazily unmounts the mountpoint, which doesn't need the
+// mount to answer, giving up after staleMountCheckTimeout.
+func (m *mounter) detachMount() {
+	dir := m.options.MountPoint
+	ctx, cancel := context.WithTimeout(
+		context.Background(), staleMountCheckTimeout)
+	defer cancel()
+	var err error
+	switch runtime.GOOS {
+	case "darwin":
+		_, err = exec.CommandContext(ctx, "/sbin/umount", "-f", dir).Output()
+	case "linux":
+		_, err = exec.CommandContext(ctx, "fusermount", "-uz", dir).Output()
+	default:
+		err = fuse.Unmount(dir)
+	}
+	if err != nil {
+		m.log.Warning("Couldn't clean up stale mount at %s: %+v", dir, err)
+		return
+	}
+	m.log.Info("Cleaned up stale mount at %s", dir)
 }
 
 // fuseMount tries to mount the mountpoint.
 // On a force mount then unmount, re-mount if unsuccessful
 func (m *mounter) Mount() (err error) {
-	m.c, err = fuseMountDir(m.options.MountPoint, m.options.PlatformParams)
+	m.cleanUpStaleMount()
+	m.c, err = fuseMountDir(m.options.MountPoint, m.options.PlatformParams,
+		m.options.KbfsParams.PublicReadOnly)
 	// Exit if we were succesful or we are not a force mounting on error.
 	// Otherwise, try unmounting and mounting again.
 	if err == nil || !m.options.ForceMount {
@@ -44,12 +200,14 @@ func (m *mounter) Mount() (err error) {
 	// where /keybase gets created and owned by root after Keybase app is
 	// started, and `kbfs` later fails to mount because of a permission error.
 	m.reinstallMountDirIfPossible()
-	m.c, err = fuseMountDir(m.options.MountPoint, m.options.PlatformParams)
+	m.c, err = fuseMountDir(m.options.MountPoint, m.options.PlatformParams,
+		m.options.KbfsParams.PublicReadOnly)
 
 	return err
 }
 
-func fuseMountDir(dir string, platformParams PlatformParams) (*fuse.Conn, error) {
+func fuseMountDir(dir string, platformParams PlatformParams,
+	readOnly bool) (*fuse.Conn, error) {
 	fi, err := os.Stat(dir)
 	if err != nil {
 		return nil, err
@@ -61,6 +219,9 @@ func fuseMountDir(dir string, platformParams PlatformParams) (*fuse.Conn, error)
 	if err != nil {
 		return nil, err
 	}
+	if readOnly {
+		options = append(options, fuse.ReadOnly())
+	}
 	c, err := fuse.Mount(dir, options...)
 	if err != nil {
 		err = translatePlatformSpecificError(err, platformParams)
@@ -80,7 +241,7 @@ func (m *mounter) Unmount() (err error) {
 	default:
 		err = fuse.Unmount(dir)
 	}
-	if err != nil && m.options.ForceMount {
+	if err != nil && (m.options.ForceMount || m.forceUnmount) {
 		// Unmount failed, so let's try and force it.
 		switch runtime.GOOS {
 		case "darwin":
@@ -0,0 +1,36 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"time"
+
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// NewOpenFilesFile returns a special read file that lists the handles
+// open through the mount for files in the current TLF.
+func NewOpenFilesFile(
+	folder *Folder, entryValid *time.Duration) *SpecialReadFile {
+	*entryValid = 0
+	return &SpecialReadFile{
+		read: func(ctx context.Context) ([]byte, time.Time, error) {
+			tlfID := folder.getFolderBranch().Tlf
+			openFiles := []libkbfs.OpenFile{}
+			for _, of := range folder.fs.openFileHandles(ctx) {
+				if of.Tlf == tlfID {
+					openFiles = append(openFiles, of)
+				}
+			}
+			data, err := libfs.PrettyJSON(openFiles)
+			if err != nil {
+				return nil, time.Time{}, err
+			}
+			return data, time.Time{}, nil
+		},
+	}
+}
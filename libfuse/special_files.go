@@ -16,11 +16,17 @@ import (
 // within a TLF and outside a TLF.
 func handleCommonSpecialFile(
 	name string, fs *FS, entryValid *time.Duration) fs.Node {
+	if fs.namespaceFilter.HidesSpecialFile(name) {
+		return nil
+	}
+
 	switch name {
 	case libkbfs.ErrorFile:
 		return NewErrorFile(fs, entryValid)
 	case libfs.MetricsFileName:
 		return NewMetricsFile(fs, entryValid)
+	case libfs.TopStatusFileName:
+		return NewTopStatusFile(fs, entryValid)
 	case libfs.ProfileListDirName:
 		return ProfileList{}
 	case libfs.ResetCachesFileName:
@@ -38,10 +44,15 @@ func handleNonTLFSpecialFile(
 	if specialNode != nil {
 		return specialNode
 	}
+	if fs.namespaceFilter.HidesSpecialFile(name) {
+		return nil
+	}
 
 	switch name {
 	case libfs.StatusFileName:
 		return NewNonTLFStatusFile(fs, entryValid)
+	case libfs.StatusDirName:
+		return StatusDir{fs: fs}
 	case libfs.HumanErrorFileName, libfs.HumanNoLoginFileName:
 		*entryValid = 0
 		return &SpecialReadFile{fs.remoteStatus.NewSpecialReadFunc}
@@ -55,6 +66,8 @@ func handleNonTLFSpecialFile(
 			folder: &Folder{fs: fs}, // fake Folder for logging, etc.
 			action: libfs.JournalDisableAuto,
 		}
+	case libfs.AcknowledgeBrokenProofsFileName:
+		return &AcknowledgeBrokenProofsFile{fs: fs}
 	case libfs.EnableBlockPrefetchingFileName:
 		return &PrefetchFile{fs: fs, enable: true}
 	case libfs.DisableBlockPrefetchingFileName:
@@ -87,6 +100,30 @@ func handleTLFSpecialFile(
 	case libfs.UpdateHistoryFileName:
 		return NewUpdateHistoryFile(folder, entryValid)
 
+	case libfs.LegalHoldFileName:
+		return NewLegalHoldFile(folder, entryValid)
+
+	case libfs.SyncCacheReportFileName:
+		return NewSyncCacheReportFile(folder, entryValid)
+
+	case libfs.RecentFilesFileName:
+		return NewRecentFilesFile(folder, entryValid)
+
+	case libfs.FolderStatsFileName:
+		return NewFolderStatsFile(folder, entryValid)
+
+	case libfs.ExpiryReportFileName:
+		return NewExpiryReportFile(folder, entryValid)
+
+	case libfs.FsyncModeFileName:
+		return NewFsyncModeFile(folder, entryValid)
+
+	case libfs.TlfSettingsReportFileName:
+		return NewTlfSettingsReportFile(folder, entryValid)
+
+	case libfs.OpenFilesFileName:
+		return NewOpenFilesFile(folder, entryValid)
+
 	case libfs.EditHistoryName:
 		return NewTlfEditHistoryFile(folder, entryValid)
 
@@ -149,6 +186,12 @@ func handleTLFSpecialFile(
 			action: libfs.JournalResumeBackgroundWork,
 		}
 
+	case libfs.ReleaseJournalQuarantineFileName:
+		return &JournalControlFile{
+			folder: folder,
+			action: libfs.JournalReleaseQuarantine,
+		}
+
 	case libfs.DisableJournalFileName:
 		return &JournalControlFile{
 			folder: folder,
@@ -166,6 +209,24 @@ func handleTLFSpecialFile(
 			folder: folder,
 			action: libfs.SyncDisable,
 		}
+
+	case libfs.RepairSyncFileName:
+		return &SyncControlFile{
+			folder: folder,
+			action: libfs.SyncRepair,
+		}
+
+	case libfs.EnableWriteThroughFileName:
+		return &SyncControlFile{
+			folder: folder,
+			action: libfs.SyncEnableWriteThrough,
+		}
+
+	case libfs.DisableWriteThroughFileName:
+		return &SyncControlFile{
+			folder: folder,
+			action: libfs.SyncDisableWriteThrough,
+		}
 	}
 
 	return nil
@@ -8,6 +8,7 @@ import (
 	"os"
 	"path"
 
+	"bazil.org/fuse"
 	"github.com/keybase/client/go/libkb"
 	"github.com/keybase/client/go/logger"
 	"github.com/keybase/client/go/protocol/keybase1"
@@ -30,11 +31,35 @@ type StartOptions struct {
 	MountErrorIsFatal bool
 	SkipMount         bool
 	MountPoint        string
+	// SymlinkEscapePolicy says how to handle symlinks that point
+	// outside of the mount.
+	SymlinkEscapePolicy libfs.SymlinkEscapePolicy
+	// FsyncMode says what an fsync waits for, for files that
+	// weren't opened for synchronous I/O.
+	FsyncMode libfs.FsyncMode
+	// RemoteChangePolicy says what to do about open files that are
+	// changed elsewhere.
+	RemoteChangePolicy libfs.RemoteChangePolicy
+	// UnlinkPolicy says whether unlinked files are moved into the
+	// trash of their TLF, rather than removed.
+	UnlinkPolicy libfs.UnlinkPolicy
+	// IdentifyMode says how identifies behave for operations
+	// through the mount, e.g. to avoid popups when it's used by
+	// automated jobs.
+	IdentifyMode libkbfs.IdentifyMode
+	// MountBeforeInit mounts the file system before connecting to
+	// the Keybase service and logging in, rather than after, with
+	// a placeholder root until that's done.
+	MountBeforeInit bool
+	// NamespaceFilter limits the namespaces and folders the mount
+	// exposes.
+	NamespaceFilter libfs.NamespaceFilter
 }
 
-func startMounting(ctx context.Context,
-	kbCtx libkbfs.Context, config libkbfs.Config, options StartOptions,
-	log logger.Logger, mi *libfs.MountInterrupter) error {
+// mount mounts the file system at the configured mount point, and
+// returns the mounter holding the new connection.
+func mount(ctx context.Context, kbCtx libkbfs.Context, options StartOptions,
+	log logger.Logger, mi *libfs.MountInterrupter) (*mounter, error) {
 	log.CDebugf(ctx, "Mounting: %q", options.MountPoint)
 
 	var mounter = &mounter{
@@ -44,18 +69,17 @@ func startMounting(ctx context.Context,
 	}
 	err := mi.MountAndSetUnmount(mounter)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return mounter, nil
+}
 
-	log.CDebugf(ctx, "Creating filesystem")
-	fs := NewFS(config, mounter.c, options.KbfsParams.Debug, options.PlatformParams)
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-	ctx = context.WithValue(ctx, libfs.CtxAppIDKey, fs)
-
+// watchMountReady calls `cancel` if the mount on `c` fails.
+func watchMountReady(ctx context.Context, c *fuse.Conn, log logger.Logger,
+	cancel context.CancelFunc) {
 	go func() {
 		select {
-		case <-mounter.c.Ready:
+		case <-c.Ready:
 			// We wait for the mounter to finish asynchronously with
 			// calling fs.Serve() below, for the rare osxfuse case
 			// where `mount(2)` makes a blocking STATFS call before
@@ -63,7 +87,7 @@ func startMounting(ctx context.Context,
 			// when this happens, there will be a deadlock, and the
 			// mount will silently fail after two minutes.  See
 			// KBFS-2409.
-			err = mounter.c.MountError
+			err := c.MountError
 			if err != nil {
 				log.CWarningf(ctx, "Mount error: %+v", err)
 				cancel()
@@ -73,14 +97,19 @@ func startMounting(ctx context.Context,
 		case <-ctx.Done():
 		}
 	}()
+}
 
-	log.CDebugf(ctx, "Serving filesystem")
-	if err = fs.Serve(ctx); err != nil {
-		return err
-	}
-
-	log.CDebugf(ctx, "Ending")
-	return nil
+func newFSFromOptions(
+	config libkbfs.Config, c *fuse.Conn, options StartOptions) *FS {
+	fs := NewFS(config, c, options.KbfsParams.Debug, options.PlatformParams)
+	fs.symlinkEscapePolicy = options.SymlinkEscapePolicy
+	fs.mountPoint = options.MountPoint
+	fs.fsyncMode = options.FsyncMode
+	fs.remoteChangePolicy = options.RemoteChangePolicy
+	fs.unlinkPolicy = options.UnlinkPolicy
+	fs.identifyMode = options.IdentifyMode
+	fs.namespaceFilter = options.NamespaceFilter
+	return fs
 }
 
 // Start the filesystem
@@ -126,15 +155,36 @@ func Start(options StartOptions, kbCtx libkbfs.Context) *libfs.Error {
 		}
 	}
 
-	log.Debug("Initializing")
 	mi := libfs.NewMountInterrupter(log)
+	mm := newMountManager(kbCtx, options, log, mi)
 	ctx := context.Background()
+	var lfs *LazyFS
+	if options.MountBeforeInit && !options.SkipMount {
+		lfs, err = mm.mountLazily(ctx)
+		if err != nil {
+			if options.MountErrorIsFatal {
+				mi.Done()
+				return libfs.MountError(err.Error())
+			}
+			// Try again once we're initialized.
+			log.Warning("Couldn't mount before initializing: %+v", err)
+			lfs = nil
+		}
+	}
+
+	log.Debug("Initializing")
 	config, err := libkbfs.Init(
 		ctx, kbCtx, options.KbfsParams, nil, mi.Done, log)
 	if err != nil {
+		if lfs != nil {
+			lfs.InitFailed(err)
+			mi.Done()
+		}
 		return libfs.InitError(err.Error())
 	}
 	defer libkbfs.Shutdown()
+	mm.initialized(ctx, config, lfs)
+	config.SetMountManager(mm)
 
 	// Report "startup successful" to the supervisor (currently just systemd on
 	// Linux). This isn't necessary for correctness, but it allows commands
@@ -143,10 +193,25 @@ func Start(options StartOptions, kbCtx libkbfs.Context) *libfs.Error {
 	// (Type=notify, in systemd lingo).
 	systemd.NotifyStartupFinished()
 
+	if options.RuntimeDir != "" && !options.SkipMount {
+		// Let editor plugins ask about the status of files under
+		// the mount, without going through the service.
+		fss := libfs.NewFileStatusServer(config, options.MountPoint)
+		err := fss.ListenAndServe(
+			path.Join(options.RuntimeDir, libfs.FileStatusSocketName))
+		if err != nil {
+			log.Warning("Couldn't serve file statuses: %+v", err)
+		} else {
+			defer fss.Shutdown()
+		}
+	}
+
 	if options.SkipMount {
 		log.Debug("Skipping mounting filesystem")
+	} else if lfs != nil {
+		log.Debug("Handed the mount over to the initialized filesystem")
 	} else {
-		err = startMounting(ctx, kbCtx, config, options, log, mi)
+		err = mm.Mount(ctx)
 		if err != nil {
 			// Abort on error if we were force mounting, otherwise continue.
 			if options.MountErrorIsFatal {
@@ -157,5 +222,8 @@ func Start(options StartOptions, kbCtx libkbfs.Context) *libfs.Error {
 		}
 	}
 	mi.Wait()
+	if err := mm.fatalError(); err != nil {
+		return libfs.MountError(err.Error())
+	}
 	return nil
 }
@@ -0,0 +1,79 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"os"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libfs"
+	"golang.org/x/net/context"
+)
+
+// StatusDir is a node for a directory within the status directory,
+// which lists status files as described in libfs.
+type StatusDir struct {
+	fs   *FS
+	path []string
+}
+
+var _ fs.Node = StatusDir{}
+
+// Attr implements the fs.Node interface.
+func (StatusDir) Attr(_ context.Context, a *fuse.Attr) error {
+	a.Mode = os.ModeDir | 0555
+	return nil
+}
+
+var _ fs.NodeRequestLookuper = StatusDir{}
+
+// Lookup implements the fs.NodeRequestLookuper interface.
+func (sd StatusDir) Lookup(ctx context.Context, req *fuse.LookupRequest,
+	resp *fuse.LookupResponse) (node fs.Node, err error) {
+	// The set of open TLFs changes, so don't cache any entries.
+	resp.EntryValid = 0
+	path := append(append([]string(nil), sd.path...), req.Name)
+	if _, ok := libfs.StatusDirChildren(ctx, sd.fs.config, path); ok {
+		return StatusDir{fs: sd.fs, path: path}, nil
+	}
+	f := libfs.StatusDirFileGet(ctx, sd.fs.config, path)
+	if f == nil {
+		return nil, fuse.ENOENT
+	}
+	return &SpecialReadFile{read: f}, nil
+}
+
+var _ fs.Handle = StatusDir{}
+
+var _ fs.HandleReadDirAller = StatusDir{}
+
+// ReadDirAll implements the ReadDirAll interface.
+func (sd StatusDir) ReadDirAll(ctx context.Context) (
+	res []fuse.Dirent, err error) {
+	children, ok := libfs.StatusDirChildren(ctx, sd.fs.config, sd.path)
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+	res = make([]fuse.Dirent, 0, len(children))
+	for name, isDir := range children {
+		de := fuse.Dirent{
+			Type: fuse.DT_File,
+			Name: name,
+		}
+		if isDir {
+			de.Type = fuse.DT_Dir
+		}
+		res = append(res, de)
+	}
+	return res, nil
+}
+
+var _ fs.NodeRemover = StatusDir{}
+
+// Remove implements the fs.NodeRemover interface for StatusDir.
+func (StatusDir) Remove(_ context.Context, req *fuse.RemoveRequest) (err error) {
+	return fuse.EPERM
+}
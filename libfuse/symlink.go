@@ -6,10 +6,12 @@ package libfuse
 
 import (
 	"os"
+	"path"
 	"syscall"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
+	"github.com/keybase/kbfs/libfs"
 	"github.com/keybase/kbfs/libkbfs"
 	"golang.org/x/net/context"
 )
@@ -63,5 +65,37 @@ func (s *Symlink) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (link
 	if de.Type != libkbfs.Sym {
 		return "", fuse.Errno(syscall.EINVAL)
 	}
+	err = s.checkEscape(ctx, de.SymPath)
+	if err != nil {
+		return "", err
+	}
 	return de.SymPath, nil
 }
+
+// checkEscape applies the symlink escape policy of the mount to
+// `target`.
+func (s *Symlink) checkEscape(ctx context.Context, target string) error {
+	fs := s.parent.folder.fs
+	if fs.symlinkEscapePolicy == libfs.SymlinkEscapeAllow {
+		return nil
+	}
+	parentPath, err := fs.config.KBFSOps().GetCanonicalPath(
+		ctx, s.parent.node)
+	if err != nil {
+		return err
+	}
+	linkPath := path.Join(parentPath, s.name)
+	if !libfs.SymlinkEscapes(fs.mountPoint, linkPath, target) {
+		return nil
+	}
+	switch fs.symlinkEscapePolicy {
+	case libfs.SymlinkEscapeWarn:
+		fs.log.CWarningf(ctx, "Symlink %s points outside of KBFS, to %s",
+			linkPath, target)
+		return nil
+	default:
+		fs.log.CDebugf(ctx, "Refusing to resolve symlink %s to %s",
+			linkPath, target)
+		return fuse.Errno(syscall.EACCES)
+	}
+}
@@ -29,11 +29,13 @@ type TLF struct {
 }
 
 func newTLF(fl *FolderList, h *libkbfs.TlfHandle,
-	name tlf.PreferredName) *TLF {
+	name tlf.PreferredName, branch libkbfs.BranchName) *TLF {
 	folder := newFolder(fl, h, name)
+	fb := libkbfs.FolderBranch{Tlf: h.TlfID(), Branch: branch}
 	tlf := &TLF{
 		folder: folder,
-		inode:  fl.fs.assignInode(),
+		// A TLF root is identified by the TLF ID and branch alone.
+		inode: stableInode(fb, libkbfs.BlockRef{}, 0, ""),
 	}
 	return tlf
 }
@@ -203,8 +205,8 @@ func (tlf *TLF) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.
 
 	branch, isArchivedBranch := libfs.BranchNameFromArchiveRefDir(req.Name)
 	if isArchivedBranch {
-		archivedTLF := newTLF(
-			tlf.folder.list, tlf.folder.h, tlf.folder.hPreferredName)
+		archivedTLF := newTLF(tlf.folder.list, tlf.folder.h,
+			tlf.folder.hPreferredName, branch)
 		_, _, err := archivedTLF.loadArchivedDir(ctx, branch)
 		if err != nil {
 			return nil, err
@@ -285,12 +287,10 @@ func (tlf *TLF) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
 }
 
 // ReadDirAll implements the fs.NodeReadDirAller interface for TLF.
+// Open only returns the TLF itself as a handle when the folder
+// doesn't exist yet, so it's always empty.
 func (tlf *TLF) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
-	dir, exitEarly, err := tlf.loadDirAllowNonexistent(ctx)
-	if err != nil || exitEarly {
-		return nil, err
-	}
-	return dir.ReadDirAll(ctx)
+	return nil, nil
 }
 
 // Forget kernel reference to this node.
@@ -321,7 +321,29 @@ func (tlf *TLF) Fsync(ctx context.Context, req *fuse.FsyncRequest) (err error) {
 	return dir.Fsync(ctx, req)
 }
 
-var _ fs.Handle = (*TLF)(nil)
+// Getxattr implements the fs.NodeGetxattrer interface for TLF.  Like
+// Attr, it doesn't load the TLF, since Finder gets attributes of
+// every TLF it lists.
+func (tlf *TLF) Getxattr(ctx context.Context, req *fuse.GetxattrRequest,
+	resp *fuse.GetxattrResponse) error {
+	dir := tlf.getStoredDir()
+	if dir == nil {
+		return fuse.ErrNoXattr
+	}
+	return dir.Getxattr(ctx, req, resp)
+}
+
+// Listxattr implements the fs.NodeListxattrer interface for TLF.
+func (tlf *TLF) Listxattr(ctx context.Context, req *fuse.ListxattrRequest,
+	resp *fuse.ListxattrResponse) error {
+	dir := tlf.getStoredDir()
+	if dir == nil {
+		return nil
+	}
+	return dir.Listxattr(ctx, req, resp)
+}
+
+var _ fs.HandleReadDirAller = (*TLF)(nil)
 
 var _ fs.NodeOpener = (*TLF)(nil)
 
@@ -330,10 +352,13 @@ func (tlf *TLF) Open(ctx context.Context, req *fuse.OpenRequest,
 	resp *fuse.OpenResponse) (fs.Handle, error) {
 	// Explicitly load the directory when a TLF is opened, because
 	// some OSX programs like ls have a bug that doesn't report errors
-	// on a ReadDirAll.
-	_, _, err := tlf.loadDirAllowNonexistent(ctx)
+	// on a readdir.
+	dir, exitEarly, err := tlf.loadDirAllowNonexistent(ctx)
 	if err != nil {
 		return nil, err
 	}
-	return tlf, nil
+	if exitEarly {
+		return tlf, nil
+	}
+	return dir.Open(ctx, req, resp)
 }
@@ -0,0 +1,32 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"time"
+
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// NewTopStatusFile returns a special read file that contains a
+// snapshot of what KBFS is doing, as JSON.
+func NewTopStatusFile(fs *FS, entryValid *time.Duration) *SpecialReadFile {
+	*entryValid = 0
+	return &SpecialReadFile{
+		read: func(ctx context.Context) ([]byte, time.Time, error) {
+			status, err := libkbfs.GetTopStatus(ctx, fs.config)
+			if err != nil {
+				return nil, time.Time{}, err
+			}
+			data, err := libfs.PrettyJSON(status)
+			if err != nil {
+				return nil, time.Time{}, err
+			}
+			return data, status.Time, nil
+		},
+	}
+}
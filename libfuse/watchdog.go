@@ -0,0 +1,239 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libfuse
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"golang.org/x/net/context"
+)
+
+const (
+	// watchdogCheckInterval is how often the watchdog checks whether
+	// the mount is still alive.
+	watchdogCheckInterval = 30 * time.Second
+	// watchdogMinRestartDelay and watchdogMaxRestartDelay bound the
+	// exponential backoff between restarts.
+	watchdogMinRestartDelay = 1 * time.Second
+	watchdogMaxRestartDelay = 5 * time.Minute
+	// watchdogHealthyRunTime is how long a child has to run before
+	// the backoff is reset.
+	watchdogHealthyRunTime = 10 * time.Minute
+	// watchdogStaleCheckTimeout is how long a check of the mount may
+	// take before the mount is considered dead; a hung FUSE mount
+	// blocks the check forever.
+	watchdogStaleCheckTimeout = 10 * time.Second
+	// watchdogStopGracePeriod is how long a child has to exit after
+	// being interrupted, before it's killed.
+	watchdogStopGracePeriod = 30 * time.Second
+	// WatchdogFlag is the command-line flag that runs kbfsfuse
+	// under a watchdog.
+	WatchdogFlag = "watchdog"
+)
+
+// Watchdog keeps a KBFS mount alive.  It runs the process that
+// serves the mount as a child, and if the child dies, or the mount
+// stops responding while the child is still running, it cleans up
+// the dead mount (like `fusermount -uz`) and starts a new child.
+type Watchdog struct {
+	mountPoint string
+	log        logger.Logger
+
+	// runChild runs a child until it exits, or until `ctx` is
+	// canceled, in which case the child is asked to stop.
+	runChild func(ctx context.Context) error
+	// isStale returns whether the mount is dead.  It may block
+	// forever on a hung mount.
+	isStale func() bool
+	// cleanUp detaches a dead mount, once the child that served it
+	// is gone.
+	cleanUp func()
+
+	checkInterval   time.Duration
+	checkTimeout    time.Duration
+	minRestartDelay time.Duration
+	maxRestartDelay time.Duration
+	healthyRunTime  time.Duration
+}
+
+// NewWatchdog returns a new Watchdog for the mount at `mountPoint`,
+// which runs the current executable with `args` as its child.
+func NewWatchdog(
+	log logger.Logger, mountPoint string, args []string) (*Watchdog, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	m := &mounter{options: StartOptions{MountPoint: mountPoint}, log: log}
+	return &Watchdog{
+		mountPoint: mountPoint,
+		log:        log,
+		runChild: func(ctx context.Context) error {
+			return runWatchdogChild(ctx, exe, args, watchdogStopGracePeriod)
+		},
+		isStale: func() bool {
+			_, err := os.Stat(mountPoint)
+			return isStaleMountError(err)
+		},
+		cleanUp:         m.cleanUpDeadMount,
+		checkInterval:   watchdogCheckInterval,
+		checkTimeout:    watchdogStaleCheckTimeout,
+		minRestartDelay: watchdogMinRestartDelay,
+		maxRestartDelay: watchdogMaxRestartDelay,
+		healthyRunTime:  watchdogHealthyRunTime,
+	}, nil
+}
+
+// WatchdogChildArgs returns `args` without the watchdog flag, so
+// they can be passed to the child.
+func WatchdogChildArgs(args []string) []string {
+	var childArgs []string
+	for _, arg := range args {
+		name := strings.TrimLeft(arg, "-")
+		if arg != name && (name == WatchdogFlag ||
+			strings.HasPrefix(name, WatchdogFlag+"=")) {
+			continue
+		}
+		childArgs = append(childArgs, arg)
+	}
+	return childArgs
+}
+
+var errChildNotReaped = errors.New(
+	"the child didn't exit after being killed")
+
+// runWatchdogChild runs `exe` until it exits, interrupting it if
+// `ctx` is canceled so that it can unmount cleanly, and killing it if
+// it hasn't exited `grace` after that.
+func runWatchdogChild(ctx context.Context, exe string, args []string,
+	grace time.Duration) error {
+	cmd := exec.Command(exe, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+	return stopWatchdogChild(done, func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}, cmd.Process.Kill, grace)
+}
+
+// stopWatchdogChild interrupts a child, kills it if it hasn't exited
+// (as reported on `done`) `grace` after that, and returns its exit
+// error.  A child stuck in the kernel, e.g. on its own hung mount,
+// can't exit until the mount is detached, so if it still hasn't
+// exited `grace` after being killed, this gives up on it and returns
+// errChildNotReaped; the caller's clean-up of the mount lets it go.
+func stopWatchdogChild(done <-chan error, interrupt, kill func() error,
+	grace time.Duration) error {
+	_ = interrupt()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(grace):
+		_ = kill()
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(grace):
+		return errChildNotReaped
+	}
+}
+
+// checkStale returns whether the mount is dead, treating a check
+// that doesn't finish within the check timeout as a dead mount.
+func (w *Watchdog) checkStale() bool {
+	staleCh := make(chan bool, 1)
+	go func() { staleCh <- w.isStale() }()
+	select {
+	case stale := <-staleCh:
+		return stale
+	case <-time.After(w.checkTimeout):
+		w.log.Warning("Checking the mount at %s timed out", w.mountPoint)
+		return true
+	}
+}
+
+var errDeadMount = errors.New("the mount stopped responding")
+
+// runOnce runs one child, and returns its error, or an error saying
+// the mount died if the watchdog had to stop it.
+func (w *Watchdog) runOnce(ctx context.Context) error {
+	childCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	staleCh := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(w.checkInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if w.checkStale() {
+					close(staleCh)
+					cancel()
+					return
+				}
+			case <-childCtx.Done():
+				return
+			}
+		}
+	}()
+	err := w.runChild(childCtx)
+	select {
+	case <-staleCh:
+		return errDeadMount
+	default:
+		return err
+	}
+}
+
+// Run keeps the mount alive until the child exits cleanly, or `ctx`
+// is canceled.
+func (w *Watchdog) Run(ctx context.Context) {
+	delay := w.minRestartDelay
+	for {
+		started := time.Now()
+		err := w.runOnce(ctx)
+		if ctx.Err() != nil {
+			w.cleanUp()
+			return
+		}
+		if err == nil {
+			w.log.Info("KBFS at %s exited cleanly", w.mountPoint)
+			return
+		}
+
+		if time.Since(started) >= w.healthyRunTime {
+			delay = w.minRestartDelay
+		}
+		w.log.Warning("KBFS at %s died (%v); cleaning up the mount and "+
+			"remounting in %s", w.mountPoint, err, delay)
+		w.cleanUp()
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay *= 2
+		if delay > w.maxRestartDelay {
+			delay = w.maxRestartDelay
+		}
+		w.log.Info("Remounting KBFS at %s", w.mountPoint)
+	}
+}
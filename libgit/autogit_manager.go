@@ -17,6 +17,7 @@ import (
 	"github.com/keybase/kbfs/tlf"
 	"github.com/pkg/errors"
 	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/cache"
 )
 
 type getNewConfigFn func(context.Context) (
@@ -25,6 +26,10 @@ type getNewConfigFn func(context.Context) (
 const (
 	// Debug tag ID for an individual autogit operation
 	ctxAutogitOpID = "AGID"
+
+	// autogitContentCacheSize is the budget for the contents of
+	// files opened through autogit, shared by all the repos.
+	autogitContentCacheSize = 64 * cache.MiByte
 )
 
 type ctxAutogitTagKey int
@@ -64,6 +69,11 @@ type AutogitManager struct {
 
 	browserLock  sync.Mutex
 	browserCache *lru.Cache
+	// browserGen is bumped whenever cached browsers are invalidated,
+	// so that a browser made concurrently from older repo data isn't
+	// cached.
+	browserGen   uint64
+	contentCache cache.Object
 
 	doRemoveSelfCheckouts sync.Once
 }
@@ -85,6 +95,7 @@ func NewAutogitManager(
 		repoNodesForWatchedIDs: make(map[libkbfs.NodeID]*repoDirNode),
 		deleteCancels:          make(map[string]context.CancelFunc),
 		browserCache:           browserCache,
+		contentCache:           cache.NewObjectLRU(autogitContentCacheSize),
 	}
 }
 
@@ -201,6 +212,9 @@ func (am *AutogitManager) registerRepoNode(
 	go am.removeOldCheckouts(rdn)
 	am.doRemoveSelfCheckouts.Do(func() { go am.removeSelfCheckouts() })
 	am.watchedNodes = append(am.watchedNodes, nodeToWatch)
+	// Keep the watched node's entry in its folder's node cache, even
+	// when the cache is over its limit.
+	am.config.KBFSOps().PinNode(context.Background(), nodeToWatch)
 	err := am.config.Notifier().RegisterForChanges(
 		[]libkbfs.FolderBranch{fb}, am)
 	if err != nil {
@@ -233,8 +247,13 @@ func (am *AutogitManager) getNodesToInvalidate(
 
 func (am *AutogitManager) clearInvalidatedBrowsers(
 	repoNodeIDs []libkbfs.NodeID) {
+	if len(repoNodeIDs) == 0 {
+		return
+	}
+
 	am.browserLock.Lock()
 	defer am.browserLock.Unlock()
+	am.browserGen++
 
 	keys := am.browserCache.Keys()
 	for _, k := range keys {
@@ -263,10 +282,52 @@ func (am *AutogitManager) clearInvalidatedBrowsers(
 	}
 }
 
+type repoDirEntry struct {
+	gitRootID libkbfs.NodeID
+	repoName  string
+}
+
+// clearRemovedRepoBrowsers clears the cached browsers for any repos
+// whose directory was removed or renamed, e.g. by deleting or
+// renaming the repo, so that the autogit view doesn't keep showing
+// the old repo contents.
+func (am *AutogitManager) clearRemovedRepoBrowsers(
+	updated map[repoDirEntry]bool) {
+	if len(updated) == 0 {
+		return
+	}
+
+	am.browserLock.Lock()
+	defer am.browserLock.Unlock()
+	am.browserGen++
+
+	for _, k := range am.browserCache.Keys() {
+		key, ok := k.(browserCacheKey)
+		if !ok {
+			continue
+		}
+		entry := repoDirEntry{key.fs.RootNode().GetID(), key.repoName}
+		if updated[entry] {
+			am.log.CDebugf(
+				nil, "Invalidating browser for updated repo %s", key.repoName)
+			am.browserCache.Remove(k)
+		}
+	}
+}
+
 // BatchChanges implements the libkbfs.Observer interface for AutogitManager.
 func (am *AutogitManager) BatchChanges(
-	ctx context.Context, _ []libkbfs.NodeChange,
+	ctx context.Context, changes []libkbfs.NodeChange,
 	affectedNodeIDs []libkbfs.NodeID) {
+	// Nodes can't be held past the end of the notification, so only
+	// collect their IDs here.
+	updated := make(map[repoDirEntry]bool)
+	for _, change := range changes {
+		for _, name := range change.DirUpdated {
+			updated[repoDirEntry{change.Node.GetID(), name}] = true
+		}
+	}
+	go am.clearRemovedRepoBrowsers(updated)
 	nodes, repoNodeIDs := am.getNodesToInvalidate(affectedNodeIDs)
 	go am.clearInvalidatedBrowsers(repoNodeIDs)
 	for _, node := range nodes {
@@ -286,31 +347,57 @@ func (am *AutogitManager) TlfHandleChange(
 	// Do nothing.
 }
 
-func (am *AutogitManager) getBrowserForRepoLocked(
+func (am *AutogitManager) getCachedBrowser(key browserCacheKey) (
+	repoFS *libfs.FS, browser *Browser, gen uint64, err error) {
+	am.browserLock.Lock()
+	defer am.browserLock.Unlock()
+	tmp, ok := am.browserCache.Get(key)
+	if !ok {
+		return nil, nil, am.browserGen, nil
+	}
+	b, ok := tmp.(browserCacheValue)
+	if !ok {
+		return nil, nil, 0, errors.Errorf("Bad browser in cache: %T", tmp)
+	}
+	return b.repoFS, b.browser, am.browserGen, nil
+}
+
+func (am *AutogitManager) cacheBrowser(
+	key browserCacheKey, gen uint64, repoFS *libfs.FS, browser *Browser) {
+	am.browserLock.Lock()
+	defer am.browserLock.Unlock()
+	if am.browserGen != gen {
+		// The repo may have changed since we started reading it.
+		am.log.CDebugf(nil, "Not caching browser for invalidated repo %s",
+			key.repoName)
+		return
+	}
+	am.browserCache.Add(key, browserCacheValue{repoFS, browser})
+}
+
+// GetBrowserForRepo returns the root FS for the specified repo and a
+// `Browser` for the branch and subdir.  The browser lock isn't held
+// while a new browser is made, so slow repo reads don't hold up the
+// invalidations that follow a push.
+func (am *AutogitManager) GetBrowserForRepo(
 	ctx context.Context, gitFS *libfs.FS, repoName string,
 	branch plumbing.ReferenceName, subdir string) (*libfs.FS, *Browser, error) {
 	repoName = normalizeRepoName(repoName)
 	key := browserCacheKey{gitFS, repoName, branch, subdir}
-	tmp, ok := am.browserCache.Get(key)
-	if ok {
-		b, ok := tmp.(browserCacheValue)
-		if !ok {
-			return nil, nil, errors.Errorf("Bad browser in cache: %T", tmp)
-		}
-		return b.repoFS, b.browser, nil
+	repoFS, browser, gen, err := am.getCachedBrowser(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if browser != nil {
+		return repoFS, browser, nil
 	}
-
-	// It's kind of dumb to hold the browser lock through all of this,
-	// but it doesn't seem worthwhile to build the whole
-	// channel/notification system that would be needed to manage
-	// multiple concurrent requests for the same repo node.
 
 	am.log.CDebugf(ctx, "Making browser for repo=%s, branch=%s, subdir=%s",
 		repoName, branch, subdir)
 
 	// Recurse to get the root browser, and then chroot to the subdir.
 	if subdir != "" {
-		repoFS, rootB, err := am.getBrowserForRepoLocked(
+		repoFS, rootB, err := am.GetBrowserForRepo(
 			ctx, gitFS, repoName, branch, "")
 		if err != nil {
 			return nil, nil, err
@@ -324,7 +411,7 @@ func (am *AutogitManager) getBrowserForRepoLocked(
 		if !ok {
 			return nil, nil, errors.Errorf("Bad browser type: %T", b)
 		}
-		am.browserCache.Add(key, browserCacheValue{repoFS, browser})
+		am.cacheBrowser(key, gen, repoFS, browser)
 		return repoFS, browser, nil
 	}
 
@@ -332,25 +419,16 @@ func (am *AutogitManager) getBrowserForRepoLocked(
 	if err != nil {
 		return nil, nil, err
 	}
-	repoFS := billyFS.(*libfs.FS)
-	browser, err := NewBrowser(repoFS, am.config.Clock(), branch)
+	repoFS = billyFS.(*libfs.FS)
+	browser, err = NewBrowser(
+		repoFS, am.config.Clock(), branch, am.contentCache)
 	if err != nil {
 		return nil, nil, err
 	}
-	am.browserCache.Add(key, browserCacheValue{repoFS, browser})
+	am.cacheBrowser(key, gen, repoFS, browser)
 	return repoFS, browser, nil
 }
 
-// GetBrowserForRepo returns the root FS for the specified repo and a
-// `Browser` for the branch and subdir.
-func (am *AutogitManager) GetBrowserForRepo(
-	ctx context.Context, gitFS *libfs.FS, repoName string,
-	branch plumbing.ReferenceName, subdir string) (*libfs.FS, *Browser, error) {
-	am.browserLock.Lock()
-	defer am.browserLock.Unlock()
-	return am.getBrowserForRepoLocked(ctx, gitFS, repoName, branch, subdir)
-}
-
 // StartAutogit launches autogit, and returns a function that should
 // be called on shutdown.
 func StartAutogit(config libkbfs.Config, browserCacheSize int) func() {
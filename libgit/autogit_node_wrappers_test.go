@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/kbfs/libfs"
@@ -251,3 +252,53 @@ func TestAutogitRepoNodeReadonly(t *testing.T) {
 
 	checkAutogitTwoFiles(t, rootFS2)
 }
+
+func TestAutogitRepoNodeDeleted(t *testing.T) {
+	ctx, config, cancel, tempdir := initConfigForAutogit(t)
+	defer cancel()
+	defer os.RemoveAll(tempdir)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	am := NewAutogitManager(config, 25)
+	defer am.Shutdown()
+	rw := rootWrapper{am}
+	config.AddRootNodeWrapper(rw.wrap)
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	rootFS, err := libfs.NewFS(
+		ctx, config, h, libkbfs.MasterBranch, "", "", keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+
+	t.Log("Init a new repo directly into KBFS.")
+	dotgitFS, _, err := GetOrCreateRepoAndID(ctx, config, h, "test", "")
+	require.NoError(t, err)
+	err = rootFS.MkdirAll("worktree", 0600)
+	require.NoError(t, err)
+	worktreeFS, err := rootFS.Chroot("worktree")
+	require.NoError(t, err)
+	dotgitStorage, err := NewGitConfigWithoutRemotesStorer(dotgitFS)
+	require.NoError(t, err)
+	repo, err := gogit.Init(dotgitStorage, worktreeFS)
+	require.NoError(t, err)
+	addFileToWorktreeAndCommit(
+		t, ctx, config, h, repo, worktreeFS, "foo", "hello")
+	checkAutogitOneFile(t, rootFS)
+	require.NotEqual(t, 0, am.browserCache.Len())
+
+	t.Log("Deleting the repo clears its cached browser")
+	err = DeleteRepo(ctx, config, h, "test")
+	require.NoError(t, err)
+	err = rootFS.SyncAll()
+	require.NoError(t, err)
+	for am.browserCache.Len() != 0 {
+		select {
+		case <-ctx.Done():
+			t.Fatal(ctx.Err())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	_, err = rootFS.ReadDir(".kbfs_autogit/test")
+	require.Error(t, err)
+}
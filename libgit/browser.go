@@ -17,6 +17,7 @@ import (
 	billy "gopkg.in/src-d/go-billy.v4"
 	gogit "gopkg.in/src-d/go-git.v4"
 	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/cache"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
 	"gopkg.in/src-d/go-git.v4/storage"
 )
@@ -34,11 +35,15 @@ func translateGitError(err *error) {
 }
 
 // Browser presents the contents of a git repo as a read-only file
-// system, using only the dotgit directory of the repo.
+// system, using only the dotgit directory of the repo.  Nothing is
+// checked out: directories are listed from the tree objects as they
+// are visited, and file contents are only read once a file is
+// opened.
 type Browser struct {
-	tree  *object.Tree
-	root  string
-	mtime time.Time
+	tree         *object.Tree
+	root         string
+	mtime        time.Time
+	contentCache cache.Object
 }
 
 var _ billy.Filesystem = (*Browser)(nil)
@@ -47,24 +52,14 @@ var _ billy.Filesystem = (*Browser)(nil)
 // of the given repo.  If `gitBranchName` is empty,
 // "refs/heads/master" is used.  If `gitBranchName` is not empty, but
 // it doesn't begin with "refs/", then "refs/heads/" is prepended to
-// it.
+// it.  If `contentCache` is non-nil, the contents of opened files
+// are kept in it, within its size budget, so they can be read again
+// without going back to the git objects.
 func NewBrowser(
 	repoFS *libfs.FS, clock libkbfs.Clock,
-	gitBranchName plumbing.ReferenceName) (*Browser, error) {
-	var storage storage.Storer
-	storage, err := NewGitConfigWithoutRemotesStorer(repoFS)
-	if err != nil {
-		return nil, err
-	}
-
-	repo, err := gogit.Open(storage, nil)
-	if errors.Cause(err) == gogit.ErrWorktreeNotProvided {
-		// This is not a bare repo (it might be for a test).  So we
-		// need to pass in a working tree, but since `Browser` is
-		// read-only and doesn't even use the worktree, it doesn't
-		// matter what we pass in.
-		repo, err = gogit.Open(storage, repoFS)
-	}
+	gitBranchName plumbing.ReferenceName,
+	contentCache cache.Object) (*Browser, error) {
+	repo, err := openRepoForReading(repoFS)
 	if err != nil {
 		return nil, err
 	}
@@ -94,12 +89,41 @@ func NewBrowser(
 	}
 
 	return &Browser{
-		tree:  tree,
-		root:  string(gitBranchName),
-		mtime: c.Author.When,
+		tree:         tree,
+		root:         string(gitBranchName),
+		mtime:        c.Author.When,
+		contentCache: contentCache,
 	}, nil
 }
 
+// openRepoForReading opens the repo in `repoFS`, without a worktree.
+func openRepoForReading(repoFS *libfs.FS) (*gogit.Repository, error) {
+	var storage storage.Storer
+	storage, err := NewGitConfigWithoutRemotesStorer(repoFS)
+	if err != nil {
+		return nil, err
+	}
+	// Only read objects from disk when their data is needed, so
+	// that listing a directory doesn't read the files in it.
+	storage, err = NewOnDemandStorer(storage)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := gogit.Open(storage, nil)
+	if errors.Cause(err) == gogit.ErrWorktreeNotProvided {
+		// This is not a bare repo (it might be for a test).  So we
+		// need to pass in a working tree, but since the repo is only
+		// read and the worktree is never used, it doesn't matter
+		// what we pass in.
+		repo, err = gogit.Open(storage, repoFS)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
 ///// Read-only functions:
 
 const (
@@ -161,7 +185,7 @@ func (b *Browser) Open(filename string) (f billy.File, err error) {
 			if err != nil {
 				return nil, err
 			}
-			return newBrowserFile(f)
+			return newBrowserFile(f, b.contentCache)
 		}
 
 		filename, err = b.followSymlink(filename)
@@ -240,11 +264,12 @@ func (b *Browser) ReadDir(p string) (fis []os.FileInfo, err error) {
 	}
 
 	for _, e := range dirTree.Entries {
-		fi, err := b.Lstat(e.Name)
+		e := e
+		size, err := dirTree.Size(e.Name)
 		if err != nil {
 			return nil, err
 		}
-		fis = append(fis, fi)
+		fis = append(fis, &browserFileInfo{&e, size, b.mtime})
 	}
 	return fis, nil
 }
@@ -272,9 +297,10 @@ func (b *Browser) Chroot(p string) (newFS billy.Filesystem, err error) {
 		return nil, err
 	}
 	return &Browser{
-		tree:  newTree,
-		root:  b.Join(b.root, p),
-		mtime: b.mtime,
+		tree:         newTree,
+		root:         b.Join(b.root, p),
+		mtime:        b.mtime,
+		contentCache: b.contentCache,
 	}, nil
 }
 
@@ -5,10 +5,14 @@
 package libgit
 
 import (
+	"bytes"
 	"io"
+	"io/ioutil"
 
 	"github.com/pkg/errors"
 	billy "gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/cache"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
 )
 
@@ -20,11 +24,66 @@ type browserFile struct {
 	f          *object.File
 	r          io.ReadCloser
 	maxBufSize int64
+	// data holds the whole file contents, if they were cached.
+	data *bytes.Reader
 }
 
 var _ billy.File = (*browserFile)(nil)
 
-func newBrowserFile(f *object.File) (*browserFile, error) {
+// cachedContents returns the contents of `f` from `contentCache`,
+// reading them into the cache first if needed.  It returns nil if
+// the file is too big to be cached.
+func cachedContents(
+	f *object.File, contentCache cache.Object) ([]byte, error) {
+	if contentCache == nil || f.Size > browserFileDefaultMaxBufSize {
+		return nil, nil
+	}
+	o, cached := contentCache.Get(f.Hash)
+	var r io.ReadCloser
+	var err error
+	if cached {
+		r, err = o.Reader()
+	} else {
+		r, err = f.Reader()
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if cached {
+		return data, nil
+	}
+
+	mo := &plumbing.MemoryObject{}
+	mo.SetType(plumbing.BlobObject)
+	_, err = mo.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	contentCache.Put(mo)
+	return data, nil
+}
+
+func newBrowserFile(
+	f *object.File, contentCache cache.Object) (*browserFile, error) {
+	data, err := cachedContents(f, contentCache)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		r := bytes.NewReader(data)
+		return &browserFile{
+			f:          f,
+			r:          ioutil.NopCloser(r),
+			maxBufSize: browserFileDefaultMaxBufSize,
+			data:       r,
+		}, nil
+	}
+
 	r, err := f.Reader()
 	if err != nil {
 		return nil, err
@@ -49,6 +108,16 @@ func (bf *browserFile) Read(p []byte) (n int, err error) {
 }
 
 func (bf *browserFile) ReadAt(p []byte, off int64) (n int, err error) {
+	if bf.data != nil {
+		n, err = bf.data.ReadAt(p, off)
+		if err == io.EOF && n > 0 {
+			// Match the streaming reader below, which only returns
+			// EOF once there's no data left.
+			err = nil
+		}
+		return n, err
+	}
+
 	// Sadly go-git doesn't expose a `ReadAt` or `Seek` interface for
 	// this, but we can probably implement it if needed.  Instead, use
 	// a new Reader object and just scan starting from the beginning.
@@ -86,6 +155,10 @@ func (bf *browserFile) ReadAt(p []byte, off int64) (n int, err error) {
 }
 
 func (bf *browserFile) Seek(offset int64, whence int) (int64, error) {
+	if bf.data != nil {
+		return bf.data.Seek(offset, whence)
+	}
+
 	// TODO if needed: we'd have to track the offset of `bf.r`
 	// manually, the same way we do in `libfs.File`.
 	return 0, errors.New("browser files can't seek")
@@ -5,6 +5,7 @@
 package libgit
 
 import (
+	"io"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -16,6 +17,7 @@ import (
 	"github.com/keybase/kbfs/tlf"
 	"github.com/stretchr/testify/require"
 	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/cache"
 	"gopkg.in/src-d/go-git.v4/plumbing/object"
 )
 
@@ -47,7 +49,7 @@ func TestBrowser(t *testing.T) {
 		t, ctx, config, h, repo, worktreeFS, "foo", "hello")
 
 	t.Log("Browse the repo and verify the data.")
-	b, err := NewBrowser(dotgitFS, config.Clock(), "")
+	b, err := NewBrowser(dotgitFS, config.Clock(), "", nil)
 	require.NoError(t, err)
 	fis, err := b.ReadDir("")
 	require.NoError(t, err)
@@ -84,7 +86,7 @@ func TestBrowser(t *testing.T) {
 			},
 		})
 		require.NoError(t, err)
-		b, err = NewBrowser(dotgitFS, config.Clock(), "")
+		b, err = NewBrowser(dotgitFS, config.Clock(), "", nil)
 		require.NoError(t, err)
 		fi, err := b.Lstat(link)
 		require.NoError(t, err)
@@ -110,3 +112,71 @@ func TestBrowser(t *testing.T) {
 	require.NoError(t, err)
 	addSymlink("../symfoo", "dir/symfoo")
 }
+
+func TestBrowserContentCache(t *testing.T) {
+	ctx, config, cancel, tempdir := initConfigForAutogit(t)
+	defer cancel()
+	defer os.RemoveAll(tempdir)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	rootFS, err := libfs.NewFS(
+		ctx, config, h, libkbfs.MasterBranch, "", "", keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+
+	dotgitFS, _, err := GetOrCreateRepoAndID(ctx, config, h, "test", "")
+	require.NoError(t, err)
+	err = rootFS.MkdirAll("worktree/dir", 0600)
+	require.NoError(t, err)
+	worktreeFS, err := rootFS.Chroot("worktree")
+	require.NoError(t, err)
+	dotgitStorage, err := NewGitConfigWithoutRemotesStorer(dotgitFS)
+	require.NoError(t, err)
+	repo, err := gogit.Init(dotgitStorage, worktreeFS)
+	require.NoError(t, err)
+	addFileToWorktree(t, repo, worktreeFS, "foo", "hello")
+	addFileToWorktreeAndCommit(
+		t, ctx, config, h, repo, worktreeFS, "dir/bar", "hello bar")
+
+	contentCache := cache.NewObjectLRU(cache.MiByte)
+	b, err := NewBrowser(dotgitFS, config.Clock(), "", contentCache)
+	require.NoError(t, err)
+
+	t.Log("Subdirectories are listed from their own tree.")
+	fis, err := b.ReadDir("dir")
+	require.NoError(t, err)
+	require.Len(t, fis, 1)
+	require.Equal(t, "bar", fis[0].Name())
+	require.Equal(t, int64(len("hello bar")), fis[0].Size())
+
+	t.Log("Nothing is cached until a file is opened.")
+	fooFile, err := b.tree.File("foo")
+	require.NoError(t, err)
+	_, ok := contentCache.Get(fooFile.Hash)
+	require.False(t, ok)
+
+	f, err := b.Open("foo")
+	require.NoError(t, err)
+	defer f.Close()
+	_, ok = contentCache.Get(fooFile.Hash)
+	require.True(t, ok)
+	buf := make([]byte, 10)
+	n, err := f.ReadAt(buf, 1)
+	require.NoError(t, err)
+	require.Equal(t, "ello", string(buf[:n]))
+	_, err = f.Seek(2, io.SeekStart)
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	require.Equal(t, "llo", string(data))
+
+	t.Log("Reopening the file uses the cached contents.")
+	f2, err := b.Open("foo")
+	require.NoError(t, err)
+	defer f2.Close()
+	data, err = ioutil.ReadAll(f2)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+// syncChunkSize is the number of bytes that may be written to a new
+// git object or packfile before they are synced out of memory.
+const syncChunkSize = 4 * libkbfs.MaxBlockSizeBytesDefault
+
+// chunkSyncingFS syncs the temp files that go-git streams new objects
+// and packfiles into every `chunkSize` bytes.  Otherwise KBFS would
+// keep the whole file in dirty blocks until the next full sync, so a
+// large blob could only be stored by holding it all in memory.
+type chunkSyncingFS struct {
+	billy.Filesystem
+	sync      func() error
+	chunkSize int64
+}
+
+// newChunkSyncingFS streams new objects and packfiles written to `fs`
+// into KBFS a few blocks at a time.
+func newChunkSyncingFS(fs *libfs.FS) chunkSyncingFS {
+	return chunkSyncingFS{fs, fs.SyncAll, syncChunkSize}
+}
+
+// TempFile implements the billy.Filesystem interface for
+// chunkSyncingFS.
+func (fs chunkSyncingFS) TempFile(dir, prefix string) (billy.File, error) {
+	f, err := fs.Filesystem.TempFile(dir, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkSyncingFile{File: f, fs: fs}, nil
+}
+
+type chunkSyncingFile struct {
+	billy.File
+	fs       chunkSyncingFS
+	unsynced int64
+}
+
+// Write implements the billy.File interface for chunkSyncingFile.
+func (f *chunkSyncingFile) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		toWrite := p
+		if room := f.fs.chunkSize - f.unsynced; int64(len(toWrite)) > room {
+			toWrite = toWrite[:room]
+		}
+		written, err := f.File.Write(toWrite)
+		n += written
+		f.unsynced += int64(written)
+		if err != nil {
+			return n, err
+		}
+		p = p[written:]
+
+		if f.unsynced >= f.fs.chunkSize {
+			err := f.fs.sync()
+			if err != nil {
+				return n, err
+			}
+			f.unsynced = 0
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+)
+
+func TestChunkSyncingFS(t *testing.T) {
+	syncs := 0
+	fs := chunkSyncingFS{memfs.New(), func() error {
+		syncs++
+		return nil
+	}, 4}
+
+	t.Log("Temp files are synced every chunk")
+	f, err := fs.TempFile("", "tmp_")
+	require.NoError(t, err)
+	n, err := f.Write([]byte("0123456789"))
+	require.NoError(t, err)
+	require.Equal(t, 10, n)
+	require.Equal(t, 2, syncs)
+	_, err = f.Write([]byte("ab"))
+	require.NoError(t, err)
+	require.Equal(t, 3, syncs)
+	require.NoError(t, f.Close())
+	f, err = fs.Open(f.Name())
+	require.NoError(t, err)
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	require.Equal(t, "0123456789ab", string(data))
+
+	t.Log("Objects stored in the repo stream through synced chunks")
+	syncs = 0
+	s, err := filesystem.NewStorage(fs)
+	require.NoError(t, err)
+	content := bytes.Repeat([]byte("a"), 1000)
+	o := s.NewEncodedObject()
+	o.SetType(plumbing.BlobObject)
+	w, err := o.Writer()
+	require.NoError(t, err)
+	_, err = w.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	h, err := s.SetEncodedObject(o)
+	require.NoError(t, err)
+	require.NotZero(t, syncs)
+	stored, err := s.EncodedObject(plumbing.BlobObject, h)
+	require.NoError(t, err)
+	r, err := stored.Reader()
+	require.NoError(t, err)
+	defer r.Close()
+	data, err = ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, content, data)
+}
@@ -4,7 +4,12 @@
 
 package libgit
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	billy "gopkg.in/src-d/go-billy.v4"
+)
 
 // Config is a KBFS git repo config file.
 type Config struct {
@@ -12,6 +17,12 @@ type Config struct {
 	Name       string // the original user-supplied format of the name
 	CreatorUID string
 	Ctime      int64 // create time in unix nanoseconds, by creator's clock
+	// Settings are copied from the team's defaults when the repo is
+	// created in a team folder.
+	Settings *RepoSettings `json:",omitempty"`
+	// HideNames is set if the names of the repo and its refs are
+	// encrypted in the metadata sent to the Keybase service.
+	HideNames bool `json:",omitempty"`
 }
 
 func configFromBytes(buf []byte) (*Config, error) {
@@ -26,3 +37,17 @@ func configFromBytes(buf []byte) (*Config, error) {
 func (c *Config) toBytes() ([]byte, error) {
 	return json.MarshalIndent(c, "", " ")
 }
+
+// readRepoConfig reads the config file of the repo rooted at `fs`.
+func readRepoConfig(fs billy.Filesystem) (*Config, error) {
+	f, err := fs.Open(kbfsConfigName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return configFromBytes(buf)
+}
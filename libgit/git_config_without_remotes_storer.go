@@ -6,6 +6,7 @@ package libgit
 
 import (
 	"github.com/keybase/kbfs/libfs"
+	billy "gopkg.in/src-d/go-billy.v4"
 	gogitcfg "gopkg.in/src-d/go-git.v4/config"
 	format "gopkg.in/src-d/go-git.v4/plumbing/format/config"
 	"gopkg.in/src-d/go-git.v4/plumbing/storer"
@@ -27,6 +28,21 @@ type GitConfigWithoutRemotesStorer struct {
 // implementation that strips remotes from the config before writing
 // them to disk.
 func NewGitConfigWithoutRemotesStorer(fs *libfs.FS) (
+	*GitConfigWithoutRemotesStorer, error) {
+	return newGitConfigWithoutRemotesStorer(newChunkSyncingFS(fs))
+}
+
+// NewGitConfigWithoutRemotesStorerWithPackIndexCache is like
+// `NewGitConfigWithoutRemotesStorer`, but reads the indices of the
+// repo's packfiles through `cache`.
+func NewGitConfigWithoutRemotesStorerWithPackIndexCache(
+	fs *libfs.FS, cache *PackIndexCache) (
+	*GitConfigWithoutRemotesStorer, error) {
+	return newGitConfigWithoutRemotesStorer(
+		packIndexCachingFS{newChunkSyncingFS(fs), cache})
+}
+
+func newGitConfigWithoutRemotesStorer(fs billy.Filesystem) (
 	*GitConfigWithoutRemotesStorer, error) {
 	fsStorer, err := filesystem.NewStorage(fs)
 	if err != nil {
@@ -0,0 +1,348 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"context"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/utils/merkletrie"
+)
+
+const (
+	// lastCommitsMaxCommits is how many commits are searched for the
+	// last changes to the entries of a directory.
+	lastCommitsMaxCommits = 1000
+	// diffMaxPatchSize is the largest file size for which a diff
+	// includes the text patch.
+	diffMaxPatchSize = 1 << 20
+)
+
+// CommitInfo describes a git commit.
+type CommitInfo struct {
+	Hash        plumbing.Hash
+	Message     string
+	AuthorName  string
+	AuthorEmail string
+	AuthorTime  time.Time
+}
+
+func makeCommitInfo(c *object.Commit) *CommitInfo {
+	return &CommitInfo{
+		Hash:        c.Hash,
+		Message:     c.Message,
+		AuthorName:  c.Author.Name,
+		AuthorEmail: c.Author.Email,
+		AuthorTime:  c.Author.When,
+	}
+}
+
+// EntryLastCommit describes the last commit that changed an entry of
+// a directory.
+type EntryLastCommit struct {
+	Name string
+	// Commit is nil if the change wasn't found within the searched
+	// history.
+	Commit *CommitInfo
+}
+
+// DiffAction is the kind of change made to a file by a diff.
+type DiffAction string
+
+const (
+	// DiffAdded means the file was added.
+	DiffAdded DiffAction = "added"
+	// DiffDeleted means the file was deleted.
+	DiffDeleted DiffAction = "deleted"
+	// DiffModified means the file contents or mode changed.
+	DiffModified DiffAction = "modified"
+)
+
+// FileDiff describes how one file differs between two revisions.
+type FileDiff struct {
+	// FromPath is empty for added files.
+	FromPath string
+	// ToPath is empty for deleted files.
+	ToPath    string
+	Action    DiffAction
+	Additions int
+	Deletions int
+	IsBinary  bool
+	// Patch is the diff in unified format.  It's empty for binary
+	// files and files that are too big.
+	Patch string
+}
+
+// ParseAutogitPath splits `p`, a path relative to a TLF root, into
+// the name of the repo and the branch and subdirectory of the repo
+// named by the path.  It returns false if `p` isn't under
+// `AutogitRoot`.
+func ParseAutogitPath(p string) (
+	repoName string, branch plumbing.ReferenceName, subdir string,
+	ok bool) {
+	parts := strings.Split(path.Clean(strings.Trim(p, "/")), "/")
+	if len(parts) < 2 || parts[0] != AutogitRoot || parts[1] == "" {
+		return "", "", "", false
+	}
+	repoName = parts[1]
+	parts = parts[2:]
+	for len(parts) > 0 && strings.HasPrefix(parts[0], AutogitBranchPrefix) {
+		branchPart := strings.TrimPrefix(parts[0], AutogitBranchPrefix)
+		branch = plumbing.ReferenceName(path.Join(
+			string(branch), strings.Replace(branchPart, branchSlash, "/", -1)))
+		parts = parts[1:]
+	}
+	return repoName, branch, path.Join(parts...), true
+}
+
+func openRepoForHistory(
+	ctx context.Context, config libkbfs.Config, tlfHandle *libkbfs.TlfHandle,
+	repoName string) (*gogit.Repository, error) {
+	fs, _, err := GetRepoAndID(ctx, config, tlfHandle, repoName, "")
+	if err != nil {
+		return nil, err
+	}
+	return openRepoForReading(fs)
+}
+
+// resolveCommit returns the commit named by `rev`, which may be a
+// branch, a tag, or a commit hash.  An empty `rev` means the master
+// branch.
+func resolveCommit(repo *gogit.Repository, rev string) (
+	*object.Commit, error) {
+	if rev == "" {
+		rev = "master"
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't resolve %q", rev)
+	}
+	return repo.CommitObject(*hash)
+}
+
+func entryHash(c *object.Commit, p string) (plumbing.Hash, error) {
+	tree, err := c.Tree()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	entry, err := tree.FindEntry(p)
+	if err == object.ErrEntryNotFound || err == object.ErrDirectoryNotFound {
+		return plumbing.ZeroHash, nil
+	} else if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return entry.Hash, nil
+}
+
+// LastCommitsForDir returns, for each entry of the directory `dir` of
+// the given repo as of revision `rev`, the most recent commit that
+// changed it.  Only the last `lastCommitsMaxCommits` commits are
+// searched.
+func LastCommitsForDir(
+	ctx context.Context, config libkbfs.Config, tlfHandle *libkbfs.TlfHandle,
+	repoName, rev, dir string) ([]EntryLastCommit, error) {
+	repo, err := openRepoForHistory(ctx, config, tlfHandle, repoName)
+	if err != nil {
+		return nil, err
+	}
+	head, err := resolveCommit(repo, rev)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := head.Tree()
+	if err != nil {
+		return nil, err
+	}
+	if dir != "" && dir != "." {
+		tree, err = tree.Tree(dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Map each entry's path to its current hash, until its last
+	// change is found.
+	pending := make(map[string]plumbing.Hash, len(tree.Entries))
+	found := make(map[string]*CommitInfo, len(tree.Entries))
+	for _, e := range tree.Entries {
+		pending[path.Join(dir, e.Name)] = e.Hash
+	}
+
+	iter := object.NewCommitIterCTime(head, nil, nil)
+	defer iter.Close()
+	for i := 0; i < lastCommitsMaxCommits && len(pending) > 0; i++ {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		c, err := iter.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		var parents []*object.Commit
+		err = c.Parents().ForEach(func(p *object.Commit) error {
+			parents = append(parents, p)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for p, hash := range pending {
+			h, err := entryHash(c, p)
+			if err != nil {
+				return nil, err
+			}
+			if h != hash {
+				continue
+			}
+			// The entry matches in this commit; if no parent has
+			// the same version, this commit is where it came from.
+			fromParent := false
+			for _, parent := range parents {
+				ph, err := entryHash(parent, p)
+				if err != nil {
+					return nil, err
+				}
+				if ph == hash {
+					fromParent = true
+					break
+				}
+			}
+			if !fromParent {
+				found[p] = makeCommitInfo(c)
+				delete(pending, p)
+			}
+		}
+	}
+
+	lastCommits := make([]EntryLastCommit, 0, len(tree.Entries))
+	for _, e := range tree.Entries {
+		lastCommits = append(lastCommits, EntryLastCommit{
+			Name:   e.Name,
+			Commit: found[path.Join(dir, e.Name)],
+		})
+	}
+	sort.Slice(lastCommits, func(i, j int) bool {
+		return lastCommits[i].Name < lastCommits[j].Name
+	})
+	return lastCommits, nil
+}
+
+func makeFileDiff(ctx context.Context, change *object.Change) (
+	FileDiff, error) {
+	action, err := change.Action()
+	if err != nil {
+		return FileDiff{}, err
+	}
+	fd := FileDiff{
+		FromPath: change.From.Name,
+		ToPath:   change.To.Name,
+	}
+	switch action {
+	case merkletrie.Insert:
+		fd.Action = DiffAdded
+	case merkletrie.Delete:
+		fd.Action = DiffDeleted
+	default:
+		fd.Action = DiffModified
+	}
+
+	from, to, err := change.Files()
+	if err != nil {
+		return FileDiff{}, err
+	}
+	for _, f := range []*object.File{from, to} {
+		if f == nil {
+			continue
+		}
+		if f.Size > diffMaxPatchSize {
+			return fd, nil
+		}
+		isBinary, err := f.IsBinary()
+		if err != nil {
+			return FileDiff{}, err
+		}
+		if isBinary {
+			fd.IsBinary = true
+			return fd, nil
+		}
+	}
+
+	patch, err := change.PatchContext(ctx)
+	if err != nil {
+		return FileDiff{}, err
+	}
+	for _, stat := range patch.Stats() {
+		fd.Additions += stat.Addition
+		fd.Deletions += stat.Deletion
+	}
+	fd.Patch = patch.String()
+	return fd, nil
+}
+
+// DiffRevisions returns the files that differ between revisions
+// `fromRev` and `toRev` of the given repo, sorted by path.
+func DiffRevisions(
+	ctx context.Context, config libkbfs.Config, tlfHandle *libkbfs.TlfHandle,
+	repoName, fromRev, toRev string) ([]FileDiff, error) {
+	repo, err := openRepoForHistory(ctx, config, tlfHandle, repoName)
+	if err != nil {
+		return nil, err
+	}
+	from, err := resolveCommit(repo, fromRev)
+	if err != nil {
+		return nil, err
+	}
+	to, err := resolveCommit(repo, toRev)
+	if err != nil {
+		return nil, err
+	}
+	fromTree, err := from.Tree()
+	if err != nil {
+		return nil, err
+	}
+	toTree, err := to.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := object.DiffTreeContext(ctx, fromTree, toTree)
+	if err != nil {
+		return nil, err
+	}
+	diffs := make([]FileDiff, 0, len(changes))
+	for _, change := range changes {
+		fd, err := makeFileDiff(ctx, change)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, fd)
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		pi, pj := diffs[i].ToPath, diffs[j].ToPath
+		if pi == "" {
+			pi = diffs[i].FromPath
+		}
+		if pj == "" {
+			pj = diffs[j].FromPath
+		}
+		return pi < pj
+	})
+	return diffs, nil
+}
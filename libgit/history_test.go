@@ -0,0 +1,118 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"os"
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+func TestParseAutogitPath(t *testing.T) {
+	repoName, branch, subdir, ok := ParseAutogitPath(
+		".kbfs_autogit/test/.kbfs_autogit_branch_refs^heads^dev/a/b")
+	require.True(t, ok)
+	require.Equal(t, "test", repoName)
+	require.Equal(t, plumbing.ReferenceName("refs/heads/dev"), branch)
+	require.Equal(t, "a/b", subdir)
+
+	repoName, branch, subdir, ok = ParseAutogitPath("/.kbfs_autogit/test")
+	require.True(t, ok)
+	require.Equal(t, "test", repoName)
+	require.Equal(t, plumbing.ReferenceName(""), branch)
+	require.Equal(t, "", subdir)
+
+	_, _, _, ok = ParseAutogitPath("worktree/.kbfs_autogit/test")
+	require.False(t, ok)
+	_, _, _, ok = ParseAutogitPath(".kbfs_autogit")
+	require.False(t, ok)
+}
+
+func TestHistory(t *testing.T) {
+	ctx, config, cancel, tempdir := initConfigForAutogit(t)
+	defer cancel()
+	defer os.RemoveAll(tempdir)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	rootFS, err := libfs.NewFS(
+		ctx, config, h, libkbfs.MasterBranch, "", "", keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+
+	dotgitFS, _, err := GetOrCreateRepoAndID(ctx, config, h, "test", "")
+	require.NoError(t, err)
+	err = rootFS.MkdirAll("worktree/dir", 0600)
+	require.NoError(t, err)
+	worktreeFS, err := rootFS.Chroot("worktree")
+	require.NoError(t, err)
+	dotgitStorage, err := NewGitConfigWithoutRemotesStorer(dotgitFS)
+	require.NoError(t, err)
+	repo, err := gogit.Init(dotgitStorage, worktreeFS)
+	require.NoError(t, err)
+	head := func() plumbing.Hash {
+		ref, err := repo.Head()
+		require.NoError(t, err)
+		return ref.Hash()
+	}
+
+	addFileToWorktree(t, repo, worktreeFS, "foo", "hello\n")
+	first := head()
+	addFileToWorktree(t, repo, worktreeFS, "dir/bar", "bar\n")
+	second := head()
+	addFileToWorktreeAndCommit(
+		t, ctx, config, h, repo, worktreeFS, "foo", "hello\nworld\n")
+	third := head()
+
+	t.Log("Last commits for the root directory")
+	lastCommits, err := LastCommitsForDir(ctx, config, h, "test", "", "")
+	require.NoError(t, err)
+	require.Len(t, lastCommits, 2)
+	require.Equal(t, "dir", lastCommits[0].Name)
+	require.NotNil(t, lastCommits[0].Commit)
+	require.Equal(t, second, lastCommits[0].Commit.Hash)
+	require.Equal(t, "foo", lastCommits[1].Name)
+	require.NotNil(t, lastCommits[1].Commit)
+	require.Equal(t, third, lastCommits[1].Commit.Hash)
+	require.Equal(t, "me", lastCommits[1].Commit.AuthorName)
+
+	t.Log("Last commits for a subdirectory, as of an older commit")
+	lastCommits, err = LastCommitsForDir(
+		ctx, config, h, "test", second.String(), "dir")
+	require.NoError(t, err)
+	require.Len(t, lastCommits, 1)
+	require.Equal(t, "bar", lastCommits[0].Name)
+	require.Equal(t, second, lastCommits[0].Commit.Hash)
+
+	t.Log("Diff the first commit against master")
+	diffs, err := DiffRevisions(ctx, config, h, "test", first.String(), "")
+	require.NoError(t, err)
+	require.Len(t, diffs, 2)
+	require.Equal(t, FileDiff{
+		ToPath:    "dir/bar",
+		Action:    DiffAdded,
+		Additions: 1,
+		Patch:     diffs[0].Patch,
+	}, diffs[0])
+	require.Contains(t, diffs[0].Patch, "+bar")
+	require.Equal(t, "foo", diffs[1].FromPath)
+	require.Equal(t, "foo", diffs[1].ToPath)
+	require.Equal(t, DiffModified, diffs[1].Action)
+	require.Equal(t, 1, diffs[1].Additions)
+	require.Equal(t, 0, diffs[1].Deletions)
+	require.Contains(t, diffs[1].Patch, "+world")
+
+	t.Log("Unknown revisions are an error")
+	_, err = DiffRevisions(ctx, config, h, "test", "nope", "")
+	require.Error(t, err)
+}
@@ -8,6 +8,7 @@ import (
 	"context"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 
 	"github.com/keybase/client/go/logger"
 	"github.com/keybase/client/go/protocol/keybase1"
@@ -102,6 +103,19 @@ func Init(ctx context.Context, gitKBFSParams libkbfs.InitParams,
 		return ctx, nil, err
 	}
 
+	// The storage root is a temp dir under the real one (see
+	// `Params`), which is where the user's event rules live.
+	rules, err := libkbfs.LoadEventRules(
+		filepath.Dir(gitKBFSParams.StorageRoot))
+	if err != nil {
+		log.CDebugf(ctx, "Couldn't load event rules: %+v", err)
+	} else if len(rules) > 0 {
+		err = config.SetEventRules(rules)
+		if err != nil {
+			log.CDebugf(ctx, "Couldn't set event rules: %+v", err)
+		}
+	}
+
 	// Make any blocks written by via this config charged to the git
 	// quota.
 	config.SetDefaultBlockType(keybase1.BlockType_GIT)
@@ -0,0 +1,258 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/storer"
+	"gopkg.in/src-d/go-git.v4/storage"
+)
+
+// ObjectMemoryBudget is a hard limit on the number of bytes that
+// in-flight git objects may hold in memory at once.  Objects that
+// don't fit spill their contents to temp files in a local directory.
+type ObjectMemoryBudget struct {
+	limit    int64
+	spillDir string
+
+	lock sync.Mutex
+	used int64
+}
+
+// NewObjectMemoryBudget returns a budget allowing `limit` bytes of
+// object data in memory, spilling the rest into `spillDir`, which is
+// created as needed.
+func NewObjectMemoryBudget(limit int64, spillDir string) *ObjectMemoryBudget {
+	return &ObjectMemoryBudget{limit: limit, spillDir: spillDir}
+}
+
+// reserve tries to charge `n` more bytes to the budget.
+func (omb *ObjectMemoryBudget) reserve(n int64) bool {
+	omb.lock.Lock()
+	defer omb.lock.Unlock()
+	if omb.used+n > omb.limit {
+		return false
+	}
+	omb.used += n
+	return true
+}
+
+func (omb *ObjectMemoryBudget) release(n int64) {
+	omb.lock.Lock()
+	defer omb.lock.Unlock()
+	omb.used -= n
+}
+
+// Used returns the number of bytes currently charged to the budget.
+func (omb *ObjectMemoryBudget) Used() int64 {
+	omb.lock.Lock()
+	defer omb.lock.Unlock()
+	return omb.used
+}
+
+// budgetedObject is an encoded git object whose contents are kept in
+// memory while they fit in the budget, and in a temp file otherwise.
+type budgetedObject struct {
+	budget *ObjectMemoryBudget
+	t      plumbing.ObjectType
+	h      plumbing.Hash
+	sz     int64
+
+	// Until the object is stored, exactly one of `cont` and `spill`
+	// holds the data written so far.
+	cont    []byte
+	spill   *os.File
+	written int64
+	// storedIn is set once the object is stored, after which its
+	// data is read back from there.
+	storedIn storage.Storer
+}
+
+var _ plumbing.EncodedObject = (*budgetedObject)(nil)
+
+func (bo *budgetedObject) Hash() plumbing.Hash {
+	if bo.storedIn != nil {
+		return bo.h
+	}
+	// More data might still be written, so don't cache the hash.
+	r, err := bo.Reader()
+	if err != nil {
+		return plumbing.ZeroHash
+	}
+	defer r.Close()
+	hasher := plumbing.NewHasher(bo.t, bo.sz)
+	if _, err := io.Copy(hasher, r); err != nil {
+		return plumbing.ZeroHash
+	}
+	return hasher.Sum()
+}
+
+func (bo *budgetedObject) Type() plumbing.ObjectType { return bo.t }
+
+func (bo *budgetedObject) SetType(t plumbing.ObjectType) { bo.t = t }
+
+func (bo *budgetedObject) Size() int64 { return bo.sz }
+
+func (bo *budgetedObject) SetSize(s int64) { bo.sz = s }
+
+func (bo *budgetedObject) Reader() (io.ReadCloser, error) {
+	if bo.storedIn != nil {
+		o, err := bo.storedIn.EncodedObject(bo.t, bo.h)
+		if err != nil {
+			return nil, err
+		}
+		return o.Reader()
+	}
+	if bo.spill == nil {
+		return ioutil.NopCloser(bytes.NewReader(bo.cont)), nil
+	}
+	f, err := os.Open(bo.spill.Name())
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (bo *budgetedObject) Writer() (io.WriteCloser, error) {
+	return bo, nil
+}
+
+// spillToDisk moves the in-memory contents into a temp file, and
+// returns their memory to the budget.
+func (bo *budgetedObject) spillToDisk() error {
+	err := os.MkdirAll(bo.budget.spillDir, 0700)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(bo.budget.spillDir, "object_")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(bo.cont); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	bo.budget.release(int64(len(bo.cont)))
+	bo.cont = nil
+	bo.spill = f
+	return nil
+}
+
+func (bo *budgetedObject) Write(p []byte) (n int, err error) {
+	if bo.spill == nil && !bo.budget.reserve(int64(len(p))) {
+		err := bo.spillToDisk()
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+	}
+	if bo.spill != nil {
+		n, err = bo.spill.Write(p)
+	} else {
+		bo.cont = append(bo.cont, p...)
+		n = len(p)
+	}
+	// Like `plumbing.MemoryObject`, the size is whatever has been
+	// written.
+	bo.written += int64(n)
+	bo.sz = bo.written
+	return n, err
+}
+
+func (bo *budgetedObject) Close() error { return nil }
+
+// free gives back the object's memory, and removes any temp file.
+// The object can't be written afterward.
+func (bo *budgetedObject) free() error {
+	if bo.spill == nil {
+		bo.budget.release(int64(len(bo.cont)))
+		bo.cont = nil
+		return nil
+	}
+	closeErr := bo.spill.Close()
+	err := os.Remove(bo.spill.Name())
+	bo.spill = nil
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// MemoryBudgetStorer is a wrapper around a storage.Storer that
+// charges the contents of the objects it creates against an
+// `ObjectMemoryBudget` until they are stored, so that a large batch
+// of new objects can't exhaust memory.  The underlying storage must
+// copy the contents of the objects it stores, as the filesystem
+// storage does.
+type MemoryBudgetStorer struct {
+	storage.Storer
+	initer   storer.Initializer
+	pfWriter storer.PackfileWriter
+	budget   *ObjectMemoryBudget
+}
+
+var _ storage.Storer = (*MemoryBudgetStorer)(nil)
+var _ storer.Initializer = (*MemoryBudgetStorer)(nil)
+var _ storer.PackfileWriter = (*MemoryBudgetStorer)(nil)
+
+// NewMemoryBudgetStorer constructs a budgeted storage layer on top of
+// an existing `Storer`, which must also be able to initialize itself
+// and write packfiles.
+func NewMemoryBudgetStorer(
+	s storage.Storer, budget *ObjectMemoryBudget) (
+	*MemoryBudgetStorer, error) {
+	initer, ok := s.(storer.Initializer)
+	if !ok {
+		return nil, errors.New("Underlying storage can't be initialized")
+	}
+	pfWriter, ok := s.(storer.PackfileWriter)
+	if !ok {
+		return nil, errors.New("Underlying storage can't write packfiles")
+	}
+	return &MemoryBudgetStorer{s, initer, pfWriter, budget}, nil
+}
+
+// Init implements the `storer.Initializer` interface for
+// MemoryBudgetStorer.
+func (mbs *MemoryBudgetStorer) Init() error {
+	return mbs.initer.Init()
+}
+
+// NewEncodedObject implements the storage.Storer interface for
+// MemoryBudgetStorer.
+func (mbs *MemoryBudgetStorer) NewEncodedObject() plumbing.EncodedObject {
+	return &budgetedObject{budget: mbs.budget}
+}
+
+// SetEncodedObject implements the storage.Storer interface for
+// MemoryBudgetStorer.  Once an object is stored, its data no longer
+// counts against the budget.
+func (mbs *MemoryBudgetStorer) SetEncodedObject(
+	o plumbing.EncodedObject) (plumbing.Hash, error) {
+	h, err := mbs.Storer.SetEncodedObject(o)
+	if bo, ok := o.(*budgetedObject); ok && err == nil {
+		// Remember where the object went, since the contents are
+		// going away.
+		bo.h = h
+		bo.storedIn = mbs.Storer
+		err = bo.free()
+	}
+	return h, err
+}
+
+// PackfileWriter implements the storer.PackfileWriter interface for
+// MemoryBudgetStorer, so that packfiles are still streamed straight
+// into the underlying storage rather than decoded into objects.
+func (mbs *MemoryBudgetStorer) PackfileWriter(
+	statusChan plumbing.StatusChan) (io.WriteCloser, error) {
+	return mbs.pfWriter.PackfileWriter(statusChan)
+}
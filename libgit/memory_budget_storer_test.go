@@ -0,0 +1,84 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/storage/filesystem"
+)
+
+func TestMemoryBudgetStorer(t *testing.T) {
+	tempdir, err := ioutil.TempDir(os.TempDir(), "memory_budget")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+
+	budget := NewObjectMemoryBudget(10, tempdir)
+	fsStorage, err := filesystem.NewStorage(memfs.New())
+	require.NoError(t, err)
+	s, err := NewMemoryBudgetStorer(fsStorage, budget)
+	require.NoError(t, err)
+
+	writeObject := func(data string) plumbing.EncodedObject {
+		o := s.NewEncodedObject()
+		o.SetType(plumbing.BlobObject)
+		o.SetSize(int64(len(data)))
+		w, err := o.Writer()
+		require.NoError(t, err)
+		_, err = w.Write([]byte(data))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		require.Equal(t, int64(len(data)), o.Size())
+		require.Equal(t,
+			plumbing.ComputeHash(plumbing.BlobObject, []byte(data)), o.Hash())
+		return o
+	}
+	readObject := func(o plumbing.EncodedObject) string {
+		r, err := o.Reader()
+		require.NoError(t, err)
+		defer r.Close()
+		data, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		return string(data)
+	}
+	spilled := func() int {
+		files, err := ioutil.ReadDir(tempdir)
+		require.NoError(t, err)
+		return len(files)
+	}
+
+	t.Log("A small object stays in memory")
+	small := writeObject("hello")
+	require.Equal(t, int64(5), budget.Used())
+	require.Equal(t, 0, spilled())
+
+	t.Log("An object over the budget spills to disk")
+	big := writeObject("this is more than ten bytes")
+	require.Equal(t, int64(5), budget.Used())
+	require.Equal(t, 1, spilled())
+	require.Equal(t, "this is more than ten bytes", readObject(big))
+
+	t.Log("Storing objects frees their memory and temp files")
+	h, err := s.SetEncodedObject(small)
+	require.NoError(t, err)
+	require.Equal(t, small.Hash(), h)
+	h, err = s.SetEncodedObject(big)
+	require.NoError(t, err)
+	require.Equal(t, big.Hash(), h)
+	require.Equal(t, int64(0), budget.Used())
+	require.Equal(t, 0, spilled())
+
+	t.Log("Stored objects can still be read")
+	require.Equal(t, "hello", readObject(small))
+	require.Equal(t, "this is more than ten bytes", readObject(big))
+	o, err := s.EncodedObject(plumbing.BlobObject, big.Hash())
+	require.NoError(t, err)
+	require.Equal(t, "this is more than ten bytes", readObject(o))
+}
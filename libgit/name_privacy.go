@@ -0,0 +1,216 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+const (
+	// hideGitNamesEnv, if set in the environment of the process that
+	// creates a repo, makes the repo hide its names from the
+	// server, even without a team default asking for it.
+	hideGitNamesEnv = "KBFS_GIT_HIDE_NAMES"
+	// hiddenGitNamePrefix starts every hidden name.  It's followed
+	// by the key generation, a dash, and the base64-encoded
+	// encrypted name, so that hidden names are valid repo names.
+	hiddenGitNamePrefix = "kbfsenc-"
+	// hiddenGitRefPrefix replaces the whole name of a hidden ref,
+	// so that the server can't tell branches from tags.
+	hiddenGitRefPrefix = "refs/"
+	// gitNameNonceKeyContext and gitNameEncryptionKeyContext
+	// separate the keys for hiding names from each other, and from
+	// every other key derived from a TLF crypt key.
+	gitNameNonceKeyContext      = "Keybase-KBFS-Git-Name-Hiding-Nonce-1"
+	gitNameEncryptionKeyContext = "Keybase-KBFS-Git-Name-Hiding-Encryption-1"
+)
+
+// shouldHideNames returns true if new repos in the given TLF, with
+// the given settings, should hide their names from the server.
+func shouldHideNames(settings *RepoSettings) bool {
+	return (settings != nil && settings.HideNames) ||
+		os.Getenv(hideGitNamesEnv) != ""
+}
+
+// gitNameHider encrypts names deterministically with keys derived
+// from a TLF crypt key: the nonce is a MAC of the name, under a key
+// separate from the encryption key, so that the same name always
+// looks the same to the server, which can then still match up
+// pushes and renames of a repo, without learning the name.
+type gitNameHider struct {
+	keyGen   kbfsmd.KeyGen
+	nonceKey [32]byte
+	key      [32]byte
+}
+
+func deriveGitNameKey(
+	tlfKey kbfscrypto.TLFCryptKey, context string) (key [32]byte) {
+	tlfKeyData := tlfKey.Data()
+	mac := hmac.New(sha256.New, tlfKeyData[:])
+	mac.Write([]byte(context))
+	copy(key[:], mac.Sum(nil))
+	return key
+}
+
+func makeGitNameHider(
+	keyGen kbfsmd.KeyGen, tlfKey kbfscrypto.TLFCryptKey) gitNameHider {
+	return gitNameHider{
+		keyGen:   keyGen,
+		nonceKey: deriveGitNameKey(tlfKey, gitNameNonceKeyContext),
+		key:      deriveGitNameKey(tlfKey, gitNameEncryptionKeyContext),
+	}
+}
+
+func (h gitNameHider) hide(name string) string {
+	mac := hmac.New(sha256.New, h.nonceKey[:])
+	mac.Write([]byte(name))
+	var nonce [24]byte
+	copy(nonce[:], mac.Sum(nil))
+	sealed := secretbox.Seal(nonce[:], []byte(name), &nonce, &h.key)
+	return fmt.Sprintf("%s%d-%s", hiddenGitNamePrefix, h.keyGen,
+		base64.RawURLEncoding.EncodeToString(sealed))
+}
+
+func (h gitNameHider) hideRef(name string) string {
+	return hiddenGitRefPrefix + h.hide(name)
+}
+
+func (h gitNameHider) reveal(sealed []byte) (string, error) {
+	if len(sealed) < 24 {
+		return "", errors.New("Hidden name is too short")
+	}
+	var nonce [24]byte
+	copy(nonce[:], sealed)
+	name, ok := secretbox.Open(nil, sealed[24:], &nonce, &h.key)
+	if !ok {
+		return "", errors.New("Couldn't decrypt hidden name")
+	}
+	return string(name), nil
+}
+
+// getGitNameHider returns the hider for the first key generation of
+// the given TLF.  Using the first generation, rather than the
+// latest, keeps hidden names stable across rekeys, so the server can
+// keep matching up a repo's pushes after a member is removed.
+func getGitNameHider(
+	ctx context.Context, config libkbfs.Config,
+	tlfHandle *libkbfs.TlfHandle) (gitNameHider, error) {
+	keys, _, err := config.KBFSOps().GetTLFCryptKeys(ctx, tlfHandle)
+	if err != nil {
+		return gitNameHider{}, err
+	}
+	if len(keys) == 0 {
+		return gitNameHider{}, errors.Errorf(
+			"No keys for %s", tlfHandle.GetCanonicalPath())
+	}
+	return makeGitNameHider(kbfsmd.FirstValidKeyGen, keys[0]), nil
+}
+
+// hideGitLocalMetadata replaces the repo and ref names in `md` with
+// their hidden forms.  Names in public folders aren't secret, so
+// they're left alone.
+func hideGitLocalMetadata(
+	ctx context.Context, config libkbfs.Config,
+	tlfHandle *libkbfs.TlfHandle, md *keybase1.GitLocalMetadata) error {
+	if tlfHandle.Type() == tlf.Public {
+		return nil
+	}
+	h, err := getGitNameHider(ctx, config, tlfHandle)
+	if err != nil {
+		return err
+	}
+	md.RepoName = keybase1.GitRepoName(h.hide(string(md.RepoName)))
+	if md.PreviousRepoName != "" {
+		md.PreviousRepoName = keybase1.GitRepoName(
+			h.hide(string(md.PreviousRepoName)))
+	}
+	for i := range md.Refs {
+		md.Refs[i].RefName = h.hideRef(md.Refs[i].RefName)
+	}
+	return nil
+}
+
+// serverRepoName returns the name of the given repo as the Keybase
+// service knows it.
+func serverRepoName(
+	ctx context.Context, config libkbfs.Config,
+	tlfHandle *libkbfs.TlfHandle, repoName string) (
+	keybase1.GitRepoName, error) {
+	fs, _, err := GetRepoAndID(ctx, config, tlfHandle, repoName, "")
+	if err != nil {
+		return "", err
+	}
+	c, err := readRepoConfig(fs)
+	if err != nil {
+		return "", err
+	}
+	if !c.HideNames {
+		return keybase1.GitRepoName(repoName), nil
+	}
+	md := keybase1.GitLocalMetadata{RepoName: keybase1.GitRepoName(c.Name)}
+	err = hideGitLocalMetadata(ctx, config, tlfHandle, &md)
+	if err != nil {
+		return "", err
+	}
+	return md.RepoName, nil
+}
+
+// IsHiddenGitName returns true if `name` is a repo or ref name
+// hidden from the server.
+func IsHiddenGitName(name string) bool {
+	return strings.HasPrefix(
+		strings.TrimPrefix(name, hiddenGitRefPrefix), hiddenGitNamePrefix)
+}
+
+// RevealGitName returns the original form of a repo or ref name of
+// a repo in the given TLF that was hidden from the server.  Names
+// that aren't hidden are returned as is.
+func RevealGitName(
+	ctx context.Context, config libkbfs.Config,
+	tlfHandle *libkbfs.TlfHandle, name string) (string, error) {
+	if !IsHiddenGitName(name) {
+		return name, nil
+	}
+	hidden := strings.TrimPrefix(
+		strings.TrimPrefix(name, hiddenGitRefPrefix), hiddenGitNamePrefix)
+	parts := strings.SplitN(hidden, "-", 2)
+	if len(parts) != 2 {
+		return "", errors.Errorf("Bad hidden name %q", name)
+	}
+	keyGen, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return "", errors.Errorf("Bad key generation in hidden name %q", name)
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errors.Wrapf(err, "Bad hidden name %q", name)
+	}
+
+	keys, _, err := config.KBFSOps().GetTLFCryptKeys(ctx, tlfHandle)
+	if err != nil {
+		return "", err
+	}
+	i := keyGen - int(kbfsmd.FirstValidKeyGen)
+	if i < 0 || i >= len(keys) {
+		return "", errors.Errorf(
+			"Unknown key generation %d in hidden name %q", keyGen, name)
+	}
+	return makeGitNameHider(kbfsmd.KeyGen(keyGen), keys[i]).reveal(sealed)
+}
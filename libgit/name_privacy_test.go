@@ -0,0 +1,109 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"encoding/base64"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHideGitNames(t *testing.T) {
+	ctx, cancel, config, tempdir := initConfig(t)
+	defer cancel()
+	defer os.RemoveAll(tempdir)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+
+	os.Setenv(hideGitNamesEnv, "1")
+	defer os.Unsetenv(hideGitNamesEnv)
+	fs, _, err := GetOrCreateRepoAndID(ctx, config, h, "Repo1", "")
+	require.NoError(t, err)
+	c, err := readRepoConfig(fs)
+	require.NoError(t, err)
+	require.True(t, c.HideNames)
+
+	md := keybase1.GitLocalMetadata{
+		RepoName:         "Repo1",
+		PreviousRepoName: "Repo0",
+		Refs: []keybase1.GitRefMetadata{
+			{RefName: "refs/heads/master"},
+		},
+	}
+	err = hideGitLocalMetadata(ctx, config, h, &md)
+	require.NoError(t, err)
+	require.True(t, IsHiddenGitName(string(md.RepoName)))
+	require.True(t, repoNameRE.MatchString(string(md.RepoName)))
+	require.True(t, IsHiddenGitName(md.Refs[0].RefName))
+	require.NotContains(t, md.Refs[0].RefName, "master")
+
+	t.Log("Hiding is deterministic, so the server can match names up.")
+	serverName, err := serverRepoName(ctx, config, h, "repo1")
+	require.NoError(t, err)
+	require.Equal(t, md.RepoName, serverName)
+
+	for hidden, name := range map[string]string{
+		string(md.RepoName):         "Repo1",
+		string(md.PreviousRepoName): "Repo0",
+		md.Refs[0].RefName:          "refs/heads/master",
+		"refs/heads/master":         "refs/heads/master",
+	} {
+		revealed, err := RevealGitName(ctx, config, h, hidden)
+		require.NoError(t, err)
+		require.Equal(t, name, revealed)
+	}
+
+	t.Log("Repo names can't look like hidden names.")
+	_, _, err = GetOrCreateRepoAndID(ctx, config, h, "kbfsenc-1-abc", "")
+	require.IsType(t, libkb.InvalidRepoNameError{}, errors.Cause(err))
+
+	err = fs.SyncAll()
+	require.NoError(t, err)
+	rootNode, _, err := config.KBFSOps().GetOrCreateRootNode(
+		ctx, h, libkbfs.MasterBranch)
+	require.NoError(t, err)
+	jServer, err := libkbfs.GetJournalServer(config)
+	require.NoError(t, err)
+	err = jServer.FinishSingleOp(ctx, rootNode.GetFolderBranch().Tlf,
+		nil, keybase1.MDPriorityGit)
+	require.NoError(t, err)
+}
+
+func TestGitNameHiderKeys(t *testing.T) {
+	key, err := kbfscrypto.MakeRandomTLFCryptKey()
+	require.NoError(t, err)
+	h := makeGitNameHider(kbfsmd.FirstValidKeyGen, key)
+	require.NotEqual(t, h.nonceKey, h.key)
+
+	hidden := h.hide("repo")
+	require.Equal(t, hidden, makeGitNameHider(
+		kbfsmd.FirstValidKeyGen, key).hide("repo"))
+	require.NotEqual(t, hidden, h.hide("repo2"))
+
+	sealed, err := base64.RawURLEncoding.DecodeString(
+		strings.TrimPrefix(hidden, hiddenGitNamePrefix+"1-"))
+	require.NoError(t, err)
+	name, err := h.reveal(sealed)
+	require.NoError(t, err)
+	require.Equal(t, "repo", name)
+
+	otherKey, err := kbfscrypto.MakeRandomTLFCryptKey()
+	require.NoError(t, err)
+	_, err = makeGitNameHider(kbfsmd.FirstValidKeyGen, otherKey).reveal(sealed)
+	require.Error(t, err)
+}
@@ -0,0 +1,194 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	billy "gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+const (
+	// PackIndexCacheDir is the name of the directory under the KBFS
+	// storage root where packfile indices are cached.
+	PackIndexCacheDir = "kbfs_git_pack_idx"
+
+	packIndexPrefix = "pack-"
+	packIndexSuffix = ".idx"
+)
+
+// PackIndexCache keeps copies of the packfile indices of KBFS-hosted
+// repos on local disk, keyed by the checksum of the pack they index.
+// Since a pack's contents never change, neither does its index, so
+// later fetches can read it without fetching its blocks from KBFS.
+type PackIndexCache struct {
+	dir string
+}
+
+// NewPackIndexCache returns a cache that stores indices in `dir`,
+// which is created as needed.
+func NewPackIndexCache(dir string) *PackIndexCache {
+	return &PackIndexCache{dir: dir}
+}
+
+func (c *PackIndexCache) cachePath(packHash plumbing.Hash) string {
+	return filepath.Join(c.dir, packHash.String()+packIndexSuffix)
+}
+
+// checkPackIndex returns an error if `data` isn't a complete index
+// for the pack with checksum `packHash`.  The last 40 bytes of an
+// index are the pack's checksum, followed by the checksum of the
+// index itself.
+func checkPackIndex(packHash plumbing.Hash, data []byte) error {
+	if len(data) < 2*sha1.Size {
+		return errors.Errorf("Index for pack %s is too short", packHash)
+	}
+	trailer := data[len(data)-2*sha1.Size:]
+	if !bytes.Equal(trailer[:sha1.Size], packHash[:]) {
+		return errors.Errorf("Index doesn't match pack %s", packHash)
+	}
+	sum := sha1.Sum(data[:len(data)-sha1.Size])
+	if !bytes.Equal(trailer[sha1.Size:], sum[:]) {
+		return errors.Errorf("Bad checksum for index of pack %s", packHash)
+	}
+	return nil
+}
+
+// get returns the cached index of the given pack, if there is a valid
+// one.
+func (c *PackIndexCache) get(packHash plumbing.Hash) ([]byte, bool) {
+	data, err := ioutil.ReadFile(c.cachePath(packHash))
+	if err != nil {
+		return nil, false
+	}
+	if checkPackIndex(packHash, data) != nil {
+		// Don't let a corrupt entry stick around.
+		_ = os.Remove(c.cachePath(packHash))
+		return nil, false
+	}
+	return data, true
+}
+
+// put caches `data` as the index of the given pack.
+func (c *PackIndexCache) put(packHash plumbing.Hash, data []byte) error {
+	err := checkPackIndex(packHash, data)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(c.dir, 0700)
+	if err != nil {
+		return err
+	}
+	// Write to a temp file first, so concurrent readers never see a
+	// partial index.
+	f, err := ioutil.TempFile(c.dir, packHash.String())
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.cachePath(packHash))
+}
+
+// packHashFromIndexPath returns the checksum of the pack whose index
+// is at path `p` of a .git directory.
+func packHashFromIndexPath(p string) (plumbing.Hash, bool) {
+	dir, file := path.Split(path.Clean(filepath.ToSlash(p)))
+	if path.Clean(dir) != "objects/pack" ||
+		!strings.HasPrefix(file, packIndexPrefix) ||
+		!strings.HasSuffix(file, packIndexSuffix) {
+		return plumbing.ZeroHash, false
+	}
+	hexHash := strings.TrimSuffix(
+		strings.TrimPrefix(file, packIndexPrefix), packIndexSuffix)
+	if len(hexHash) != 2*sha1.Size {
+		return plumbing.ZeroHash, false
+	}
+	packHash := plumbing.NewHash(hexHash)
+	if packHash.String() != strings.ToLower(hexHash) {
+		return plumbing.ZeroHash, false
+	}
+	return packHash, true
+}
+
+// packIndexFile is a read-only, in-memory copy of a packfile index.
+type packIndexFile struct {
+	*bytes.Reader
+	name string
+}
+
+var _ billy.File = packIndexFile{}
+
+func (pif packIndexFile) Name() string {
+	return pif.name
+}
+
+func (pif packIndexFile) Write(_ []byte) (int, error) {
+	return 0, errors.New("Pack indices are read-only")
+}
+
+func (pif packIndexFile) Close() error {
+	return nil
+}
+
+func (pif packIndexFile) Lock() error {
+	return nil
+}
+
+func (pif packIndexFile) Unlock() error {
+	return nil
+}
+
+func (pif packIndexFile) Truncate(_ int64) error {
+	return errors.New("Pack indices are read-only")
+}
+
+// packIndexCachingFS serves the packfile indices of a .git directory
+// from a `PackIndexCache`, filling the cache from the underlying
+// filesystem on a miss.
+type packIndexCachingFS struct {
+	billy.Filesystem
+	cache *PackIndexCache
+}
+
+// Open implements the billy.Filesystem interface for
+// packIndexCachingFS.
+func (fs packIndexCachingFS) Open(filename string) (billy.File, error) {
+	packHash, ok := packHashFromIndexPath(filename)
+	if !ok {
+		return fs.Filesystem.Open(filename)
+	}
+	if data, ok := fs.cache.get(packHash); ok {
+		return packIndexFile{bytes.NewReader(data), filename}, nil
+	}
+
+	f, err := fs.Filesystem.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	// A failure to cache the index (for example if it's still
+	// being written) shouldn't fail the read.
+	_ = fs.cache.put(packHash, data)
+	return packIndexFile{bytes.NewReader(data), filename}, nil
+}
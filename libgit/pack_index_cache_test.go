@@ -0,0 +1,93 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/idxfile"
+)
+
+func makeTestPackIndex(t *testing.T, packHash plumbing.Hash) []byte {
+	w := new(idxfile.Writer)
+	require.NoError(t, w.OnHeader(1))
+	require.NoError(t, w.OnInflatedObjectContent(
+		plumbing.NewHash("0123456789012345678901234567890123456789"),
+		12, 0, nil))
+	require.NoError(t, w.OnFooter(packHash))
+	idx, err := w.Index()
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	_, err = idxfile.NewEncoder(&buf).Encode(idx, nil)
+	require.NoError(t, err)
+	return buf.Bytes()
+}
+
+func TestPackIndexCache(t *testing.T) {
+	tempdir, err := ioutil.TempDir(os.TempDir(), "pack_idx_cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	cache := NewPackIndexCache(filepath.Join(tempdir, PackIndexCacheDir))
+
+	packHash := plumbing.NewHash("abcdefabcdefabcdefabcdefabcdefabcdefabcd")
+	idxData := makeTestPackIndex(t, packHash)
+	idxPath := "objects/pack/pack-" + packHash.String() + ".idx"
+	dotgit := memfs.New()
+	err = dotgit.MkdirAll("objects/pack", 0700)
+	require.NoError(t, err)
+	f, err := dotgit.Create(idxPath)
+	require.NoError(t, err)
+	_, err = f.Write(idxData)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	fs := packIndexCachingFS{dotgit, cache}
+
+	readIdx := func() []byte {
+		f, err := fs.Open(idxPath)
+		require.NoError(t, err)
+		defer f.Close()
+		data, err := ioutil.ReadAll(f)
+		require.NoError(t, err)
+		return data
+	}
+
+	t.Log("The first read fills the cache")
+	require.Equal(t, idxData, readIdx())
+	cached, ok := cache.get(packHash)
+	require.True(t, ok)
+	require.Equal(t, idxData, cached)
+
+	t.Log("Later reads don't need the underlying file")
+	require.NoError(t, dotgit.Remove(idxPath))
+	require.Equal(t, idxData, readIdx())
+
+	t.Log("A corrupt entry is dropped")
+	corrupt := append([]byte(nil), idxData...)
+	corrupt[10]++
+	err = ioutil.WriteFile(cache.cachePath(packHash), corrupt, 0600)
+	require.NoError(t, err)
+	_, err = fs.Open(idxPath)
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(cache.cachePath(packHash))
+	require.True(t, os.IsNotExist(err))
+
+	t.Log("An index for a different pack isn't cached")
+	otherHash := plumbing.NewHash("1111111111111111111111111111111111111111")
+	require.Error(t, cache.put(otherHash, idxData))
+
+	t.Log("Other files aren't affected")
+	_, ok = packHashFromIndexPath("objects/pack/pack-" +
+		packHash.String() + ".pack")
+	require.False(t, ok)
+	_, ok = packHashFromIndexPath("objects/pack/pack-nothex.idx")
+	require.False(t, ok)
+}
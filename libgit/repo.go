@@ -13,7 +13,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -23,6 +25,7 @@ import (
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/kbfs/libfs"
 	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
 	"github.com/pkg/errors"
 	billy "gopkg.in/src-d/go-billy.v4"
 	gogit "gopkg.in/src-d/go-git.v4"
@@ -72,6 +75,7 @@ type RefDataByName map[plumbing.ReferenceName]*RefData
 func checkValidRepoName(repoName string, config libkbfs.Config) bool {
 	return len(repoName) >= 1 &&
 		uint32(len(repoName)) <= config.MaxNameBytes() &&
+		!IsHiddenGitName(repoName) &&
 		(os.Getenv("KBFS_GIT_REPONAME_SKIP_CHECK") != "" ||
 			repoNameRE.MatchString(repoName))
 }
@@ -216,16 +220,7 @@ func UpdateRepoMD(ctx context.Context, config libkbfs.Config,
 	folder := tlfHandle.ToFavorite().ToKBFolder(false)
 
 	// Get the user-formatted repo name.
-	f, err := fs.Open(kbfsConfigName)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	buf, err := ioutil.ReadAll(f)
-	if err != nil {
-		return err
-	}
-	c, err := configFromBytes(buf)
+	c, err := readRepoConfig(fs)
 	if err != nil {
 		return err
 	}
@@ -257,21 +252,43 @@ func UpdateRepoMD(ctx context.Context, config libkbfs.Config,
 			IsDelete:             refData.IsDelete,
 		})
 	}
+	md := keybase1.GitLocalMetadata{
+		RepoName:         keybase1.GitRepoName(c.Name),
+		Refs:             gitRefMetadata,
+		PushType:         pushType,
+		PreviousRepoName: keybase1.GitRepoName(oldRepoName),
+	}
+	if c.HideNames {
+		// Don't fall back to sending the names in the clear.
+		err = hideGitLocalMetadata(ctx, config, tlfHandle, &md)
+		if err != nil {
+			return err
+		}
+	}
 	log := config.MakeLogger("")
 	log.CDebugf(ctx, "Putting git MD update")
 	err = config.KBPKI().PutGitMetadata(
-		ctx, folder, keybase1.RepoID(c.ID.String()),
-		keybase1.GitLocalMetadata{
-			RepoName:         keybase1.GitRepoName(c.Name),
-			Refs:             gitRefMetadata,
-			PushType:         pushType,
-			PreviousRepoName: keybase1.GitRepoName(oldRepoName),
-		})
+		ctx, folder, keybase1.RepoID(c.ID.String()), md)
 	if err != nil {
 		// Just log the put error, it shouldn't block the success of
 		// the overall git operation.
 		log.CDebugf(ctx, "Failed to put git metadata: %+v", err)
 	}
+
+	if pushType == keybase1.GitPushType_DEFAULT {
+		session, err := config.KBPKI().GetCurrentSession(ctx)
+		if err != nil {
+			log.CDebugf(ctx, "Couldn't get session for push event: %+v", err)
+			return nil
+		}
+		refs := make([]string, 0, len(refDataByName))
+		for refName := range refDataByName {
+			refs = append(refs, refName.Short())
+		}
+		sort.Strings(refs)
+		config.EventRuleEngine().RepoPushed(
+			ctx, tlfHandle, c.Name, string(session.Name), refs)
+	}
 	return nil
 }
 
@@ -395,6 +412,16 @@ func createNewRepoAndID(
 		CreatorUID: session.UID.String(),
 		Ctime:      config.Clock().Now().UnixNano(),
 	}
+	if tlfHandle.Type() == tlf.SingleTeam {
+		defaults, err := GetTeamRepoDefaults(ctx, config, tlfHandle)
+		if err != nil {
+			return NullID, err
+		}
+		if !reflect.DeepEqual(defaults, RepoSettings{}) {
+			c.Settings = &defaults
+		}
+	}
+	c.HideNames = shouldHideNames(c.Settings)
 	buf, err := c.toBytes()
 	if err != nil {
 		return NullID, err
@@ -976,6 +1003,37 @@ func canDoGC(
 		repoGCInProgressFileName, gcTimeLimit, log)
 }
 
+// hasAnnotatedTags returns true if any reference of the repo in
+// `storage` points directly at an annotated tag object.
+func hasAnnotatedTags(storage storage.Storer) (bool, error) {
+	refs, err := storage.IterReferences()
+	if err != nil {
+		return false, err
+	}
+	defer refs.Close()
+	found := false
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		obj, err := storage.EncodedObject(plumbing.AnyObject, ref.Hash())
+		if err == plumbing.ErrObjectNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if obj.Type() == plumbing.TagObject {
+			found = true
+			return storer.ErrStop
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return found, nil
+}
+
 // GCRepo runs garbage collection on the specified repo, if it exceeds
 // any of the thresholds provided in `options`.
 func GCRepo(
@@ -1074,6 +1132,22 @@ func GCRepo(
 		}
 	}
 
+	if doPruneLoose || doObjectRepack {
+		// The object walker that go-git uses to find reachable
+		// objects can't follow annotated tags, and would fail.
+		// TODO: remove this once our go-git fork handles them.
+		annotated, err := hasAnnotatedTags(storage)
+		if err != nil {
+			return err
+		}
+		if annotated {
+			log.CDebugf(ctx, "Not pruning or re-packing objects of a "+
+				"repo with annotated tags")
+			doPruneLoose = false
+			doObjectRepack = false
+		}
+	}
+
 	if doPruneLoose {
 		repo, err := gogit.Open(storage, nil)
 		if err != nil {
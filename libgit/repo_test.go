@@ -8,6 +8,7 @@ import (
 	"context"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -17,6 +18,9 @@ import (
 	"github.com/keybase/kbfs/tlf"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"gopkg.in/src-d/go-git.v4/storage/memory"
 )
 
 func initConfig(t *testing.T) (
@@ -102,6 +106,52 @@ func TestGetOrCreateRepoAndID(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestUpdateRepoMDFiresRepoPushed(t *testing.T) {
+	ctx, cancel, config, tempdir := initConfig(t)
+	defer cancel()
+	defer os.RemoveAll(tempdir)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	out := filepath.Join(tempdir, "pushes")
+	err := config.SetEventRules(libkbfs.EventRules{{
+		Name: "pushes",
+		On:   libkbfs.EventRepoPushed,
+		Actions: []libkbfs.EventRuleAction{{Command: []string{
+			"sh", "-c", `echo "{{.Path}} {{.Writer}} $KBFS_EVENT_REFS" > "$0"`,
+			out}}},
+	}})
+	require.NoError(t, err)
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	fs, _, err := GetOrCreateRepoAndID(ctx, config, h, "Repo1", "")
+	require.NoError(t, err)
+
+	err = UpdateRepoMD(ctx, config, h, fs, keybase1.GitPushType_DEFAULT, "",
+		RefDataByName{
+			"refs/heads/master": &RefData{},
+			"refs/tags/v1":      &RefData{},
+		})
+	require.NoError(t, err)
+	err = config.EventRuleEngine().WaitForActions(ctx)
+	require.NoError(t, err)
+	buf, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	require.Equal(t,
+		"/keybase/private/user1/Repo1 user1 master v1\n", string(buf))
+
+	fs.SyncAll()
+	rootNode, _, err := config.KBFSOps().GetOrCreateRootNode(
+		ctx, h, libkbfs.MasterBranch)
+	require.NoError(t, err)
+	jServer, err := libkbfs.GetJournalServer(config)
+	require.NoError(t, err)
+	err = jServer.FinishSingleOp(ctx, rootNode.GetFolderBranch().Tlf,
+		nil, keybase1.MDPriorityGit)
+	require.NoError(t, err)
+}
+
 func TestCreateRepoAndID(t *testing.T) {
 	ctx, cancel, config, tempdir := initConfig(t)
 	defer cancel()
@@ -372,3 +422,35 @@ func TestRepoRename(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, id5, id7)
 }
+
+func TestHasAnnotatedTags(t *testing.T) {
+	storage := memory.NewStorage()
+	blob := storage.NewEncodedObject()
+	blob.SetType(plumbing.BlobObject)
+	blobHash, err := storage.SetEncodedObject(blob)
+	require.NoError(t, err)
+	err = storage.SetReference(plumbing.NewHashReference(
+		"refs/tags/light", blobHash))
+	require.NoError(t, err)
+	annotated, err := hasAnnotatedTags(storage)
+	require.NoError(t, err)
+	require.False(t, annotated)
+
+	tag := &object.Tag{
+		Name:       "annotated",
+		Message:    "annotated",
+		TargetType: plumbing.BlobObject,
+		Target:     blobHash,
+	}
+	tagObj := storage.NewEncodedObject()
+	err = tag.Encode(tagObj)
+	require.NoError(t, err)
+	tagHash, err := storage.SetEncodedObject(tagObj)
+	require.NoError(t, err)
+	err = storage.SetReference(plumbing.NewHashReference(
+		"refs/tags/annotated", tagHash))
+	require.NoError(t, err)
+	annotated, err = hasAnnotatedTags(storage)
+	require.NoError(t, err)
+	require.True(t, annotated)
+}
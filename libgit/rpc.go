@@ -208,11 +208,11 @@ func (rh *RPCHandler) scheduleCleaning(folder keybase1.Folder) {
 	})
 }
 
-// DeleteRepo implements keybase1.KBFSGitInterface for KeybaseServiceBase.
-func (rh *RPCHandler) DeleteRepo(
-	ctx context.Context, arg keybase1.DeleteRepoArg) (err error) {
+func (rh *RPCHandler) deleteRepo(
+	ctx context.Context, folder keybase1.Folder,
+	name keybase1.GitRepoName) (serverName keybase1.GitRepoName, err error) {
 	rh.log.CDebugf(ctx, "Deleting repo %s from folder %s/%s",
-		arg.Name, arg.Folder.FolderType, arg.Folder.Name)
+		name, folder.FolderType, folder.Name)
 	defer func() {
 		rh.log.CDebugf(ctx, "Done deleting repo: %+v", err)
 	}()
@@ -220,9 +220,9 @@ func (rh *RPCHandler) DeleteRepo(
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	ctx, gitConfig, tlfHandle, tempDir, err := rh.getHandleAndConfig(
-		ctx, arg.Folder)
+		ctx, folder)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer func() {
 		rmErr := os.RemoveAll(tempDir)
@@ -233,12 +233,33 @@ func (rh *RPCHandler) DeleteRepo(
 	}()
 	defer gitConfig.Shutdown(ctx)
 
-	err = DeleteRepo(ctx, gitConfig, tlfHandle, string(arg.Name))
+	// The service only knows the hidden name of a repo that hides
+	// its names.
+	repoName, err := RevealGitName(ctx, gitConfig, tlfHandle, string(name))
 	if err != nil {
-		return err
+		return "", err
+	}
+	serverName, err = serverRepoName(ctx, gitConfig, tlfHandle, repoName)
+	if err != nil {
+		return "", err
+	}
+
+	err = DeleteRepo(ctx, gitConfig, tlfHandle, repoName)
+	if err != nil {
+		return "", err
 	}
 
 	err = rh.waitForJournal(ctx, gitConfig, tlfHandle)
+	if err != nil {
+		return "", err
+	}
+	return serverName, nil
+}
+
+// DeleteRepo implements keybase1.KBFSGitInterface for KeybaseServiceBase.
+func (rh *RPCHandler) DeleteRepo(
+	ctx context.Context, arg keybase1.DeleteRepoArg) (err error) {
+	_, err = rh.deleteRepo(ctx, arg.Folder, arg.Name)
 	if err != nil {
 		return err
 	}
@@ -247,6 +268,114 @@ func (rh *RPCHandler) DeleteRepo(
 	return nil
 }
 
+// DeleteRepoAndMetadata deletes an existing git repository, and
+// removes it from the service's registry of repos.  The service
+// updates its registry itself before calling `DeleteRepo`, so this
+// is for other callers, like command-line tools.  The deleted repo
+// data isn't cleaned up right away, so that concurrent pushes can
+// finish; see `CleanDeletedRepos`.
+func (rh *RPCHandler) DeleteRepoAndMetadata(
+	ctx context.Context, folder keybase1.Folder, name string) error {
+	serverName, err := rh.deleteRepo(ctx, folder, keybase1.GitRepoName(name))
+	if err != nil {
+		return err
+	}
+	return rh.config.KBPKI().DeleteGitMetadata(ctx, folder, serverName)
+}
+
+// CleanDeletedRepos completely removes the data of the repos in the
+// given folder that have been deleted for long enough.
+func (rh *RPCHandler) CleanDeletedRepos(
+	ctx context.Context, folder keybase1.Folder) (err error) {
+	rh.log.CDebugf(ctx, "Cleaning deleted repos in folder %s/%s",
+		folder.FolderType, folder.Name)
+	defer func() {
+		rh.log.CDebugf(ctx, "Done cleaning deleted repos: %+v", err)
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ctx, gitConfig, tlfHandle, tempDir, err := rh.getHandleAndConfig(
+		ctx, folder)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		rmErr := os.RemoveAll(tempDir)
+		if rmErr != nil {
+			rh.log.CDebugf(
+				ctx, "Error cleaning storage dir %s: %+v\n", tempDir, rmErr)
+		}
+	}()
+	defer gitConfig.Shutdown(ctx)
+
+	err = CleanOldDeletedRepos(ctx, gitConfig, tlfHandle)
+	if err != nil {
+		return err
+	}
+
+	return rh.waitForJournal(ctx, gitConfig, tlfHandle)
+}
+
+// GetTeamRepoDefaults returns the default settings for new repos in
+// the given team folder.
+func (rh *RPCHandler) GetTeamRepoDefaults(
+	ctx context.Context, folder keybase1.Folder) (
+	settings RepoSettings, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ctx, gitConfig, tlfHandle, tempDir, err := rh.getHandleAndConfig(
+		ctx, folder)
+	if err != nil {
+		return RepoSettings{}, err
+	}
+	defer func() {
+		rmErr := os.RemoveAll(tempDir)
+		if rmErr != nil {
+			rh.log.CDebugf(
+				ctx, "Error cleaning storage dir %s: %+v\n", tempDir, rmErr)
+		}
+	}()
+	defer gitConfig.Shutdown(ctx)
+
+	return GetTeamRepoDefaults(ctx, gitConfig, tlfHandle)
+}
+
+// SetTeamRepoDefaults sets the default settings for new repos in the
+// given team folder.
+func (rh *RPCHandler) SetTeamRepoDefaults(
+	ctx context.Context, folder keybase1.Folder,
+	settings RepoSettings) (err error) {
+	rh.log.CDebugf(ctx, "Setting repo defaults for folder %s/%s: %+v",
+		folder.FolderType, folder.Name, settings)
+	defer func() {
+		rh.log.CDebugf(ctx, "Done setting repo defaults: %+v", err)
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ctx, gitConfig, tlfHandle, tempDir, err := rh.getHandleAndConfig(
+		ctx, folder)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		rmErr := os.RemoveAll(tempDir)
+		if rmErr != nil {
+			rh.log.CDebugf(
+				ctx, "Error cleaning storage dir %s: %+v\n", tempDir, rmErr)
+		}
+	}()
+	defer gitConfig.Shutdown(ctx)
+
+	err = SetTeamRepoDefaults(ctx, gitConfig, tlfHandle, settings)
+	if err != nil {
+		return err
+	}
+
+	return rh.waitForJournal(ctx, gitConfig, tlfHandle)
+}
+
 // Gc implements keybase1.KBFSGitInterface for KeybaseServiceBase.
 func (rh *RPCHandler) Gc(
 	ctx context.Context, arg keybase1.GcArg) (err error) {
@@ -286,7 +415,8 @@ func (rh *RPCHandler) Gc(
 	return rh.waitForJournal(ctx, gitConfig, tlfHandle)
 }
 
-// RenameRepo renames an existing git repository.
+// RenameRepo renames an existing git repository.  The service's
+// registry of repos is updated along with the repo's config.
 //
 // TODO: Hook this up to an RPC.
 func (rh *RPCHandler) RenameRepo(ctx context.Context,
@@ -312,6 +442,10 @@ func (rh *RPCHandler) RenameRepo(ctx context.Context,
 	}()
 	defer gitConfig.Shutdown(ctx)
 
+	oldName, err = RevealGitName(ctx, gitConfig, tlfHandle, oldName)
+	if err != nil {
+		return err
+	}
 	err = RenameRepo(ctx, gitConfig, tlfHandle, oldName, newName)
 	if err != nil {
 		return err
@@ -0,0 +1,219 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"context"
+	"encoding/hex"
+	"sort"
+
+	kbname "github.com/keybase/client/go/kbun"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// SignatureVerification describes whether a signed git object was
+// signed by a member of the folder that hosts its repo.
+type SignatureVerification struct {
+	// Signed is true if the object carries a PGP signature.
+	Signed bool
+	// Verified is true if the signature was made by one of the PGP
+	// keys that a folder member has proven on Keybase.
+	Verified bool
+	// Signer and Fingerprint identify the verifying member and key,
+	// and are empty unless `Verified` is true.
+	Signer      kbname.NormalizedUsername
+	Fingerprint string
+}
+
+// RefInfo describes a branch or tag of a repo, along with the
+// verification status of its signature.  For annotated tags, the tag
+// object's signature is verified; otherwise, the signature of the
+// commit the ref points to is verified.
+type RefInfo struct {
+	Name plumbing.ReferenceName
+	// Hash is the commit the ref points to.
+	Hash         plumbing.Hash
+	Verification SignatureVerification
+}
+
+// memberPGPKey is a PGP key proven by a folder member.
+type memberPGPKey struct {
+	name    kbname.NormalizedUsername
+	armored string
+}
+
+// folderMemberUIDs returns the UIDs of all the users who can read the
+// folder given by `tlfHandle`, including the members of any teams
+// that back it.
+func folderMemberUIDs(
+	ctx context.Context, config libkbfs.Config,
+	tlfHandle *libkbfs.TlfHandle) ([]keybase1.UID, error) {
+	seen := make(map[keybase1.UID]bool)
+	var uids []keybase1.UID
+	add := func(uid keybase1.UID) {
+		if uid == keybase1.PUBLIC_UID || seen[uid] {
+			return
+		}
+		seen[uid] = true
+		uids = append(uids, uid)
+	}
+
+	ids := append(tlfHandle.ResolvedWriters(), tlfHandle.ResolvedReaders()...)
+	for _, id := range ids {
+		if id.IsUser() {
+			add(id.AsUserOrBust())
+			continue
+		}
+		teamInfo, err := config.KeybaseService().LoadTeamPlusKeys(
+			ctx, id.AsTeamOrBust(), tlfHandle.Type(), kbfsmd.UnspecifiedKeyGen,
+			keybase1.UserVersion{}, kbfscrypto.VerifyingKey{},
+			keybase1.TeamRole_NONE)
+		if err != nil {
+			return nil, err
+		}
+		for uid := range teamInfo.Writers {
+			add(uid)
+		}
+		for uid := range teamInfo.Readers {
+			add(uid)
+		}
+	}
+	return uids, nil
+}
+
+// loadMemberPGPKeys returns the PGP keys proven by all the members of
+// the folder given by `tlfHandle`.
+func loadMemberPGPKeys(
+	ctx context.Context, config libkbfs.Config,
+	tlfHandle *libkbfs.TlfHandle) ([]memberPGPKey, error) {
+	uids, err := folderMemberUIDs(ctx, config, tlfHandle)
+	if err != nil {
+		return nil, err
+	}
+	var keys []memberPGPKey
+	for _, uid := range uids {
+		armoredKeys, err := config.KeybaseService().LoadPGPPublicKeys(ctx, uid)
+		if err != nil {
+			return nil, err
+		}
+		if len(armoredKeys) == 0 {
+			continue
+		}
+		name, err := config.KBPKI().GetNormalizedUsername(
+			ctx, uid.AsUserOrTeam())
+		if err != nil {
+			return nil, err
+		}
+		for _, armored := range armoredKeys {
+			keys = append(keys, memberPGPKey{name, armored})
+		}
+	}
+	return keys, nil
+}
+
+// verifySignature checks an object's PGP signature against each of
+// the given member keys, using `verify` (e.g., `object.Commit.Verify`
+// or `object.Tag.Verify`).
+func verifySignature(
+	signature string, verify func(string) (*openpgp.Entity, error),
+	keys []memberPGPKey) SignatureVerification {
+	if signature == "" {
+		return SignatureVerification{}
+	}
+	for _, key := range keys {
+		entity, err := verify(key.armored)
+		if err != nil {
+			continue
+		}
+		return SignatureVerification{
+			Signed:      true,
+			Verified:    true,
+			Signer:      key.name,
+			Fingerprint: hex.EncodeToString(entity.PrimaryKey.Fingerprint[:]),
+		}
+	}
+	return SignatureVerification{Signed: true}
+}
+
+// ListRefsWithVerification returns all the branches and tags of the
+// given repo, sorted by name, along with whether each one was signed
+// by a PGP key proven by a member of the folder.
+func ListRefsWithVerification(
+	ctx context.Context, config libkbfs.Config, tlfHandle *libkbfs.TlfHandle,
+	repoName string) ([]RefInfo, error) {
+	repo, err := openRepoForHistory(ctx, config, tlfHandle, repoName)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := loadMemberPGPKeys(ctx, config, tlfHandle)
+	if err != nil {
+		return nil, err
+	}
+
+	refIter, err := repo.References()
+	if err != nil {
+		return nil, err
+	}
+	var refs []RefInfo
+	err = refIter.ForEach(func(ref *plumbing.Reference) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		name := ref.Name()
+		if ref.Type() != plumbing.HashReference ||
+			!(name.IsBranch() || name.IsTag()) {
+			return nil
+		}
+		info := RefInfo{Name: name, Hash: ref.Hash()}
+		if name.IsTag() {
+			tag, err := repo.TagObject(ref.Hash())
+			switch errors.Cause(err) {
+			case nil:
+				// An annotated tag carries its own signature.
+				info.Verification = verifySignature(
+					tag.PGPSignature, tag.Verify, keys)
+				c, err := tag.Commit()
+				if err == object.ErrUnsupportedObject {
+					// The tag doesn't point at a commit.
+					refs = append(refs, info)
+					return nil
+				} else if err != nil {
+					return err
+				}
+				info.Hash = c.Hash
+				refs = append(refs, info)
+				return nil
+			case plumbing.ErrObjectNotFound:
+				// A lightweight tag points directly at a commit.
+			default:
+				return err
+			}
+		}
+		c, err := repo.CommitObject(ref.Hash())
+		if err != nil {
+			return err
+		}
+		info.Verification = verifySignature(c.PGPSignature, c.Verify, keys)
+		refs = append(refs, info)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].Name < refs[j].Name
+	})
+	return refs, nil
+}
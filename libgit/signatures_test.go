@@ -0,0 +1,138 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	gogit "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// pgpKeysService serves a fixed set of proven PGP keys.
+type pgpKeysService struct {
+	libkbfs.KeybaseService
+	keys map[keybase1.UID][]string
+}
+
+func (s pgpKeysService) LoadPGPPublicKeys(
+	_ context.Context, uid keybase1.UID) ([]string, error) {
+	return s.keys[uid], nil
+}
+
+func makeTestPGPEntity(t *testing.T, name string) (
+	*openpgp.Entity, string) {
+	entity, err := openpgp.NewEntity(name, "", name+"@keyba.se", nil)
+	require.NoError(t, err)
+	// Serializing the private key signs the identities and subkeys.
+	require.NoError(t, entity.SerializePrivate(ioutil.Discard, nil))
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.Serialize(w))
+	require.NoError(t, w.Close())
+	return entity, buf.String()
+}
+
+func TestListRefsWithVerification(t *testing.T) {
+	ctx, config, cancel, tempdir := initConfigForAutogit(t)
+	defer cancel()
+	defer os.RemoveAll(tempdir)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	memberKey, memberArmored := makeTestPGPEntity(t, "user1")
+	strangerKey, _ := makeTestPGPEntity(t, "stranger")
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+	config.SetKeybaseService(pgpKeysService{
+		config.KeybaseService(),
+		map[keybase1.UID][]string{session.UID: {memberArmored}},
+	})
+
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	rootFS, err := libfs.NewFS(
+		ctx, config, h, libkbfs.MasterBranch, "", "", keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+	dotgitFS, _, err := GetOrCreateRepoAndID(ctx, config, h, "test", "")
+	require.NoError(t, err)
+	err = rootFS.MkdirAll("worktree", 0600)
+	require.NoError(t, err)
+	worktreeFS, err := rootFS.Chroot("worktree")
+	require.NoError(t, err)
+	dotgitStorage, err := NewGitConfigWithoutRemotesStorer(dotgitFS)
+	require.NoError(t, err)
+	repo, err := gogit.Init(dotgitStorage, worktreeFS)
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+
+	addFileToWorktree(t, repo, worktreeFS, "foo", "hello")
+	ref, err := repo.Head()
+	require.NoError(t, err)
+	unsigned := ref.Hash()
+	signedCommit := func(key *openpgp.Entity) plumbing.Hash {
+		hash, err := wt.Commit("signed commit", &gogit.CommitOptions{
+			Author: &object.Signature{
+				Name:  "me",
+				Email: "me@keyba.se",
+				When:  time.Now(),
+			},
+			SignKey: key,
+		})
+		require.NoError(t, err)
+		return hash
+	}
+	stranger := signedCommit(strangerKey)
+	member := signedCommit(memberKey)
+	commitWorktree(t, ctx, config, h, worktreeFS)
+
+	err = repo.Storer.SetReference(plumbing.NewHashReference(
+		"refs/heads/stranger", stranger))
+	require.NoError(t, err)
+	err = repo.Storer.SetReference(plumbing.NewHashReference(
+		"refs/tags/v1", unsigned))
+	require.NoError(t, err)
+
+	refs, err := ListRefsWithVerification(ctx, config, h, "test")
+	require.NoError(t, err)
+	require.Equal(t, []RefInfo{
+		{
+			Name: "refs/heads/master",
+			Hash: member,
+			Verification: SignatureVerification{
+				Signed:   true,
+				Verified: true,
+				Signer:   "user1",
+				Fingerprint: hex.EncodeToString(
+					memberKey.PrimaryKey.Fingerprint[:]),
+			},
+		},
+		{
+			Name:         "refs/heads/stranger",
+			Hash:         stranger,
+			Verification: SignatureVerification{Signed: true},
+		},
+		{
+			Name: "refs/tags/v1",
+			Hash: unsigned,
+		},
+	}, refs)
+}
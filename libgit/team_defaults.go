@@ -0,0 +1,168 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+)
+
+// teamRepoDefaultsName is the name of the file, in the repo directory
+// of a team folder, holding the team's default repo settings.  Repo
+// names can't start with a ".", so it can't collide with a repo.
+const teamRepoDefaultsName = ".team_repo_defaults"
+
+// RepoSettings are the settings of a repo that a team can choose
+// defaults for.  The zero value means no limits or special handling.
+type RepoSettings struct {
+	// MaxRepoSizeBytes caps the size of the repo, if positive.
+	MaxRepoSizeBytes int64 `json:",omitempty"`
+	// ProtectedBranches are patterns, in the syntax of `path.Match`,
+	// for branches that can't be force-pushed or deleted.
+	ProtectedBranches []string `json:",omitempty"`
+	// LFSEnabled turns on storing large files with git LFS.
+	LFSEnabled bool `json:",omitempty"`
+	// ArchiveAfterDays is how many days without a push until the
+	// repo is archived.  Zero means never.
+	ArchiveAfterDays int `json:",omitempty"`
+	// HideNames encrypts the names of new repos and of their refs
+	// in the metadata sent to the Keybase service, with the
+	// team's key, so that the server can't learn them.  Hidden
+	// names change when the team's key is rotated.
+	HideNames bool `json:",omitempty"`
+}
+
+// Validate returns an error if the settings can't be applied to a
+// repo.
+func (s RepoSettings) Validate() error {
+	if s.MaxRepoSizeBytes < 0 {
+		return errors.Errorf("Negative max repo size: %d", s.MaxRepoSizeBytes)
+	}
+	for _, pattern := range s.ProtectedBranches {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Errorf("Bad protected branch pattern %q", pattern)
+		}
+	}
+	if s.ArchiveAfterDays < 0 {
+		return errors.Errorf(
+			"Negative archive policy: %d days", s.ArchiveAfterDays)
+	}
+	return nil
+}
+
+func checkTeamFolder(tlfHandle *libkbfs.TlfHandle) error {
+	if tlfHandle.Type() != tlf.SingleTeam {
+		return errors.Errorf("%s is not a team folder",
+			tlfHandle.GetCanonicalPath())
+	}
+	return nil
+}
+
+// GetTeamRepoDefaults returns the default settings for new repos in
+// the given team folder.
+func GetTeamRepoDefaults(
+	ctx context.Context, config libkbfs.Config,
+	tlfHandle *libkbfs.TlfHandle) (settings RepoSettings, err error) {
+	err = checkTeamFolder(tlfHandle)
+	if err != nil {
+		return RepoSettings{}, err
+	}
+
+	rootNode, _, err := config.KBFSOps().GetOrCreateRootNode(
+		ctx, tlfHandle, libkbfs.MasterBranch)
+	if err != nil {
+		return RepoSettings{}, err
+	}
+	_, _, err = config.KBFSOps().Lookup(ctx, rootNode, kbfsRepoDir)
+	if _, ok := errors.Cause(err).(libkbfs.NoSuchNameError); ok {
+		return RepoSettings{}, nil
+	} else if err != nil {
+		return RepoSettings{}, err
+	}
+
+	fs, err := libfs.NewFS(
+		ctx, config, tlfHandle, libkbfs.MasterBranch, kbfsRepoDir, "",
+		keybase1.MDPriorityGit)
+	if err != nil {
+		return RepoSettings{}, err
+	}
+	f, err := fs.Open(teamRepoDefaultsName)
+	if os.IsNotExist(err) {
+		return RepoSettings{}, nil
+	} else if err != nil {
+		return RepoSettings{}, err
+	}
+	defer f.Close()
+	buf, err := ioutil.ReadAll(f)
+	if err != nil {
+		return RepoSettings{}, err
+	}
+	err = json.Unmarshal(buf, &settings)
+	if err != nil {
+		return RepoSettings{}, err
+	}
+	return settings, nil
+}
+
+// SetTeamRepoDefaults sets the default settings for new repos in the
+// given team folder.  Existing repos keep their settings.  The
+// caller is responsible for flushing the journal, if desired.
+func SetTeamRepoDefaults(
+	ctx context.Context, config libkbfs.Config,
+	tlfHandle *libkbfs.TlfHandle, settings RepoSettings) error {
+	err := checkTeamFolder(tlfHandle)
+	if err != nil {
+		return err
+	}
+	err = settings.Validate()
+	if err != nil {
+		return err
+	}
+
+	rootNode, _, err := config.KBFSOps().GetOrCreateRootNode(
+		ctx, tlfHandle, libkbfs.MasterBranch)
+	if err != nil {
+		return err
+	}
+	_, err = lookupOrCreateDir(
+		context.WithValue(ctx, libkbfs.CtxAllowNameKey, kbfsRepoDir),
+		config, rootNode, kbfsRepoDir)
+	if err != nil {
+		return err
+	}
+
+	fs, err := libfs.NewFS(
+		ctx, config, tlfHandle, libkbfs.MasterBranch, kbfsRepoDir, "",
+		keybase1.MDPriorityGit)
+	if err != nil {
+		return err
+	}
+	buf, err := json.MarshalIndent(settings, "", " ")
+	if err != nil {
+		return err
+	}
+	f, err := fs.OpenFile(
+		teamRepoDefaultsName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return fs.SyncAll()
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libgit
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTeamRepoDefaults(t *testing.T) {
+	ctx, cancel, config, tempdir := initConfig(t)
+	defer cancel()
+	defer os.RemoveAll(tempdir)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	teamInfos := libkbfs.AddEmptyTeamsForTestOrBust(t, config, "t1")
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+	libkbfs.AddTeamWriterForTestOrBust(
+		t, config, teamInfos[0].TID, session.UID)
+	h, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "t1", tlf.SingleTeam)
+	require.NoError(t, err)
+
+	t.Log("No defaults are set at first")
+	settings, err := GetTeamRepoDefaults(ctx, config, h)
+	require.NoError(t, err)
+	require.Equal(t, RepoSettings{}, settings)
+
+	t.Log("Bad settings are rejected")
+	err = SetTeamRepoDefaults(ctx, config, h, RepoSettings{
+		ProtectedBranches: []string{"release/["},
+	})
+	require.Error(t, err)
+
+	expected := RepoSettings{
+		MaxRepoSizeBytes:  1 << 30,
+		ProtectedBranches: []string{"master", "release/*"},
+		LFSEnabled:        true,
+		ArchiveAfterDays:  90,
+	}
+	err = SetTeamRepoDefaults(ctx, config, h, expected)
+	require.NoError(t, err)
+	settings, err = GetTeamRepoDefaults(ctx, config, h)
+	require.NoError(t, err)
+	require.Equal(t, expected, settings)
+
+	t.Log("New repos get the defaults")
+	_, err = CreateRepoAndID(ctx, config, h, "repo1")
+	require.NoError(t, err)
+	fs, _, err := GetRepoAndID(ctx, config, h, "repo1", "")
+	require.NoError(t, err)
+	f, err := fs.Open(kbfsConfigName)
+	require.NoError(t, err)
+	defer f.Close()
+	buf, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	c, err := configFromBytes(buf)
+	require.NoError(t, err)
+	require.Equal(t, &expected, c.Settings)
+
+	t.Log("Only team folders have defaults")
+	privateHandle, err := libkbfs.ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "user1", tlf.Private)
+	require.NoError(t, err)
+	err = SetTeamRepoDefaults(ctx, config, privateHandle, expected)
+	require.Error(t, err)
+	_, err = CreateRepoAndID(ctx, config, privateHandle, "repo1")
+	require.NoError(t, err)
+	fs, _, err = GetRepoAndID(ctx, config, privateHandle, "repo1", "")
+	require.NoError(t, err)
+	f2, err := fs.Open(kbfsConfigName)
+	require.NoError(t, err)
+	defer f2.Close()
+	buf, err = ioutil.ReadAll(f2)
+	require.NoError(t, err)
+	c, err = configFromBytes(buf)
+	require.NoError(t, err)
+	require.Nil(t, c.Settings)
+}
@@ -5,10 +5,98 @@
 package libhttpserver
 
 import (
+	"io"
+	"mime"
 	"net/http"
+	"os"
+	"path"
 	"strings"
+	"unicode/utf8"
+
+	"github.com/keybase/kbfs/libfs"
 )
 
+// sniffLen is the number of bytes http.DetectContentType looks at.
+const sniffLen = 512
+
+func isTextualType(ty string) bool {
+	return strings.HasPrefix(ty, "text/") ||
+		strings.Contains(ty, "json") ||
+		strings.Contains(ty, "xml") ||
+		strings.Contains(ty, "javascript")
+}
+
+// detectContentType picks a MIME type for a file, based on both its
+// name and the first bytes of its contents.  The contents win, unless
+// sniffing gives only a generic answer that the extension can refine.
+func detectContentType(name string, head []byte) string {
+	sniffed := http.DetectContentType(head)
+	if strings.HasPrefix(sniffed, "text/") &&
+		strings.Contains(sniffed, "charset=utf-8") {
+		// Don't count a multi-byte rune cut off at the end of `head`.
+		trimmed := head
+		for i := 0; i < utf8.UTFMax && len(trimmed) > 0 &&
+			!utf8.Valid(trimmed); i++ {
+			trimmed = trimmed[:len(trimmed)-1]
+		}
+		if !utf8.Valid(trimmed) {
+			sniffed = strings.Split(sniffed, ";")[0]
+		}
+	}
+
+	byExt := mime.TypeByExtension(path.Ext(name))
+	switch {
+	case byExt == "":
+		return sniffed
+	case sniffed == "application/octet-stream":
+		// Sniffing couldn't tell, so trust the extension.
+		return byExt
+	case strings.HasPrefix(sniffed, "text/plain") && isTextualType(byExt):
+		// Plain text is what most textual formats sniff as, so let
+		// the extension be more specific, but keep the charset.
+		mediaType := strings.Split(byExt, ";")[0]
+		if i := strings.Index(sniffed, ";"); i >= 0 {
+			return mediaType + sniffed[i:]
+		}
+		return mediaType
+	default:
+		return sniffed
+	}
+}
+
+// getContentType returns the MIME type of the file at `p` in `fs`.
+// Only the first bytes of the file are read, and the results are
+// cached by the file's block ID, so repeated requests (like a HEAD
+// followed by a GET) don't fetch the file again.
+func (s *Server) getContentType(fs *libfs.FS, p string, fi os.FileInfo) (
+	string, error) {
+	var key interface{}
+	if bidg, ok := fi.Sys().(libfs.BlockIDGetter); ok {
+		if id, err := bidg.BlockID(); err == nil {
+			key = id
+			if ty, ok := s.contentTypes.Get(key); ok {
+				return ty.(string), nil
+			}
+		}
+	}
+
+	f, err := fs.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	ty := detectContentType(p, head[:n])
+	if key != nil {
+		s.contentTypes.Add(key, ty)
+	}
+	return ty, nil
+}
+
 type contentTypeOverridingResponseWriter struct {
 	original http.ResponseWriter
 }
@@ -27,15 +115,24 @@ func (w *contentTypeOverridingResponseWriter) calculateOverride(
 	// Send text/plain for all HTML and JS files to avoid them being executed
 	// by the frontend WebView.
 	ty := strings.ToLower(mimeType)
+	textPlain := "text/plain"
+	if mediaType, params, err := mime.ParseMediaType(ty); err == nil {
+		ty = mediaType
+		if params["charset"] != "" {
+			// Keep the charset, so text renders correctly.
+			textPlain = mime.FormatMediaType(
+				textPlain, map[string]string{"charset": params["charset"]})
+		}
+	}
 	switch {
 	// First anything textual as text/plain, also javascript.
 	case strings.HasPrefix(ty, "text/") ||
 		ty == "application/javascript":
-		return "text/plain", "inline"
+		return textPlain, "inline"
 	// Rest of html, xml. (note that the type may be e.g. application/xhtml+xml)
 	case strings.Contains(ty, "xml") ||
 		strings.Contains(ty, "html"):
-		return "text/plain", "attachment"
+		return textPlain, "attachment"
 	// Pass multimedia types through, and pdf too.
 	case strings.HasPrefix(ty, "audio/") ||
 		strings.HasPrefix(ty, "image/") ||
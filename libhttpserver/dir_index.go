@@ -0,0 +1,232 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libhttpserver
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/keybase/kbfs/libfs"
+)
+
+const (
+	dirIndexDefaultPerPage = 100
+	dirIndexMaxPerPage     = 1000
+)
+
+// dirIndexEntry is a single child in a directory index.
+type dirIndexEntry struct {
+	Name  string
+	IsDir bool
+	Size  int64
+	Mtime time.Time
+	// Dirty is true if the entry has local changes that haven't been
+	// flushed to the server yet.
+	Dirty bool `json:",omitempty"`
+}
+
+// dirIndex is one page of a directory listing.
+type dirIndex struct {
+	Path           string
+	SyncEnabled    bool
+	PrefetchStatus string
+	Sort           string
+	Desc           bool
+	Page           int
+	PerPage        int
+	TotalEntries   int
+	TotalPages     int
+	Entries        []dirIndexEntry
+
+	// Only used by the HTML template.
+	token string
+}
+
+func (di dirIndex) EntryLink(e dirIndexEntry) string {
+	name := url.PathEscape(e.Name)
+	if e.IsDir {
+		name += "/"
+	}
+	return name + "?" + url.Values{"token": {di.token}}.Encode()
+}
+
+func (di dirIndex) pageLink(page int) string {
+	v := url.Values{
+		"token":    {di.token},
+		"sort":     {di.Sort},
+		"page":     {strconv.Itoa(page)},
+		"per_page": {strconv.Itoa(di.PerPage)},
+	}
+	if di.Desc {
+		v.Set("order", "desc")
+	}
+	return "?" + v.Encode()
+}
+
+func (di dirIndex) PrevLink() string {
+	if di.Page <= 1 {
+		return ""
+	}
+	return di.pageLink(di.Page - 1)
+}
+
+func (di dirIndex) NextLink() string {
+	if di.Page >= di.TotalPages {
+		return ""
+	}
+	return di.pageLink(di.Page + 1)
+}
+
+var dirIndexTemplate = template.Must(template.New("index").Parse(`<html>
+    <head>
+        <title>Index of {{.Path}}</title>
+    </head>
+    <body>
+        <h1>Index of {{.Path}}</h1>
+        <p>{{.TotalEntries}} entries; page {{.Page}} of {{.TotalPages}}{{if .SyncEnabled}}; synced for offline use ({{.PrefetchStatus}}){{end}}</p>
+        <table>
+            <tr><th>Name</th><th>Size</th><th>Modified</th><th></th></tr>
+{{- range .Entries}}
+            <tr><td><a href="{{$.EntryLink .}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td><td>{{if not .IsDir}}{{.Size}}{{end}}</td><td>{{.Mtime.Format "2006-01-02 15:04:05"}}</td><td>{{if .Dirty}}not yet uploaded{{end}}</td></tr>
+{{- end}}
+        </table>
+        <p>{{with .PrevLink}}<a href="{{.}}">previous</a>{{end}} {{with .NextLink}}<a href="{{.}}">next</a>{{end}}</p>
+    </body>
+</html>
+`))
+
+func sortDirIndexEntries(fis []os.FileInfo, by string, desc bool) {
+	less := func(i, j int) bool {
+		switch by {
+		case "size":
+			if fis[i].Size() != fis[j].Size() {
+				return fis[i].Size() < fis[j].Size()
+			}
+		case "mtime":
+			if !fis[i].ModTime().Equal(fis[j].ModTime()) {
+				return fis[i].ModTime().Before(fis[j].ModTime())
+			}
+		}
+		return fis[i].Name() < fis[j].Name()
+	}
+	sort.SliceStable(fis, func(i, j int) bool {
+		if desc {
+			return less(j, i)
+		}
+		return less(i, j)
+	})
+}
+
+func queryInt(q url.Values, key string, def int) int {
+	v, err := strconv.Atoi(q.Get(key))
+	if err != nil || v < 1 {
+		return def
+	}
+	return v
+}
+
+// makeDirIndex builds the requested page of the listing of `dir`
+// within `fs`, based on the "sort", "order", "page" and "per_page"
+// query parameters.
+func (s *Server) makeDirIndex(ctx context.Context,
+	fs *libfs.FS, dir string, q url.Values) (dirIndex, error) {
+	di := dirIndex{
+		Path:    path.Join(fs.Root(), dir),
+		Sort:    q.Get("sort"),
+		Desc:    q.Get("order") == "desc",
+		Page:    queryInt(q, "page", 1),
+		PerPage: queryInt(q, "per_page", dirIndexDefaultPerPage),
+	}
+	switch di.Sort {
+	case "name", "size", "mtime":
+	default:
+		di.Sort = "name"
+	}
+	if di.PerPage > dirIndexMaxPerPage {
+		di.PerPage = dirIndexMaxPerPage
+	}
+
+	fis, err := fs.WithContext(ctx).ReadDir(dir)
+	if err != nil {
+		return dirIndex{}, err
+	}
+	sortDirIndexEntries(fis, di.Sort, di.Desc)
+	di.TotalEntries = len(fis)
+	di.TotalPages = (len(fis) + di.PerPage - 1) / di.PerPage
+	if di.TotalPages == 0 {
+		di.TotalPages = 1
+	}
+	start := (di.Page - 1) * di.PerPage
+	if start > len(fis) {
+		start = len(fis)
+	}
+	end := start + di.PerPage
+	if end > len(fis) {
+		end = len(fis)
+	}
+
+	status, _, err := s.config.KBFSOps().FolderStatus(
+		ctx, fs.RootNode().GetFolderBranch())
+	if err != nil {
+		return dirIndex{}, err
+	}
+	di.SyncEnabled = status.SyncEnabled
+	di.PrefetchStatus = status.PrefetchStatus
+	dirty := make(map[string]bool)
+	for _, p := range status.DirtyPaths {
+		dirty[p] = true
+	}
+	if status.Journal != nil {
+		for _, p := range status.Journal.UnflushedPaths {
+			dirty[p] = true
+		}
+	}
+
+	di.Entries = make([]dirIndexEntry, 0, end-start)
+	for _, fi := range fis[start:end] {
+		di.Entries = append(di.Entries, dirIndexEntry{
+			Name:  fi.Name(),
+			IsDir: fi.IsDir(),
+			Size:  fi.Size(),
+			Mtime: fi.ModTime(),
+			Dirty: dirty[path.Join(di.Path, fi.Name())],
+		})
+	}
+	return di, nil
+}
+
+// serveDirIndex writes a page of the listing of `dir` as HTML, or as
+// JSON if the "format=json" query parameter is given.
+func (s *Server) serveDirIndex(
+	w http.ResponseWriter, req *http.Request, fs *libfs.FS, dir string) {
+	q := req.URL.Query()
+	di, err := s.makeDirIndex(req.Context(), fs, dir, q)
+	if err != nil {
+		s.logger.Warning("Couldn't list %s; error=%v", dir, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if q.Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(di)
+	} else {
+		di.token = q.Get("token")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = dirIndexTemplate.Execute(w, di)
+	}
+	if err != nil {
+		s.logger.Warning("Couldn't write index for %s; error=%v", dir, err)
+	}
+}
@@ -0,0 +1,408 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libhttpserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	// Register the decoders for the default image previews.
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/pkg/errors"
+)
+
+const (
+	previewDefaultSize = 256
+	previewMaxSize     = 1024
+	// previewMaxInput caps how much of a file is read to make a
+	// preview, so huge files aren't fetched just to be browsed.
+	previewMaxInput = 64 << 20
+	// previewMaxPixels caps the size of the images that are decoded,
+	// since a small compressed file can expand into a huge image.
+	previewMaxPixels = 50 << 20
+	// previewCacheSize is how many previews are kept in memory.
+	previewCacheSize = 256
+	// previewMaxConcurrentGenerations is how many previews can be
+	// generated at once.
+	previewMaxConcurrentGenerations = 2
+)
+
+// previewTooLargeError means an image has too many pixels to be
+// decoded for a preview.
+type previewTooLargeError struct {
+	width, height int
+}
+
+func (e previewTooLargeError) Error() string {
+	return fmt.Sprintf("A %dx%d image is too large to preview",
+		e.width, e.height)
+}
+
+func isPreviewTooLarge(err error) bool {
+	_, ok := errors.Cause(err).(previewTooLargeError)
+	return ok
+}
+
+// decodePreviewImage decodes an image in any format registered with
+// the image package, after checking that its header doesn't ask for
+// more than previewMaxPixels.
+func decodePreviewImage(r io.Reader) (image.Image, error) {
+	var buf bytes.Buffer
+	_, err := buf.ReadFrom(io.LimitReader(r, previewMaxInput))
+	if err != nil {
+		return nil, err
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > previewMaxPixels {
+		return nil, previewTooLargeError{cfg.Width, cfg.Height}
+	}
+	img, _, err := image.Decode(&buf)
+	return img, err
+}
+
+// PreviewGenerator renders a preview image for the contents of a
+// file.
+type PreviewGenerator interface {
+	GeneratePreview(ctx context.Context, r io.Reader) (image.Image, error)
+}
+
+// imagePreviewGenerator decodes any image format registered with the
+// image package.
+type imagePreviewGenerator struct{}
+
+func (imagePreviewGenerator) GeneratePreview(
+	_ context.Context, r io.Reader) (image.Image, error) {
+	return decodePreviewImage(r)
+}
+
+// CommandPreviewGenerator renders previews by piping the file
+// contents into an external command, which must write an image in a
+// format registered with the image package to stdout.  This can be
+// used for video frames or PDF pages, e.g. with
+// `pdftoppm -png -singlefile -f 1 -l 1 - -`.
+type CommandPreviewGenerator struct {
+	Command []string
+}
+
+// GeneratePreview implements the PreviewGenerator interface for
+// CommandPreviewGenerator.
+func (g CommandPreviewGenerator) GeneratePreview(
+	ctx context.Context, r io.Reader) (image.Image, error) {
+	if len(g.Command) == 0 {
+		return nil, errors.New("No preview command")
+	}
+	cmd := exec.CommandContext(ctx, g.Command[0], g.Command[1:]...)
+	cmd.Stdin = r
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, errors.Wrapf(err, "%s failed", g.Command[0])
+	}
+	img, err := decodePreviewImage(out)
+	// Stop reading, so that a command that writes more than
+	// previewMaxInput fails rather than blocking on a full pipe.
+	out.Close()
+	if waitErr := cmd.Wait(); waitErr != nil && err == nil {
+		err = errors.Wrapf(waitErr, "%s failed", g.Command[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// scaleImage shrinks `img` with a box filter so that neither
+// dimension exceeds `maxDim`.
+func scaleImage(img image.Image, maxDim int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxDim && h <= maxDim {
+		return img
+	}
+	nw, nh := maxDim, maxDim
+	if w > h {
+		nh = h * maxDim / w
+	} else {
+		nw = w * maxDim / h
+	}
+	if nw < 1 {
+		nw = 1
+	}
+	if nh < 1 {
+		nh = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, nw, nh))
+	for y := 0; y < nh; y++ {
+		y0, y1 := b.Min.Y+y*h/nh, b.Min.Y+(y+1)*h/nh
+		for x := 0; x < nw; x++ {
+			x0, x1 := b.Min.X+x*w/nw, b.Min.X+(x+1)*w/nw
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg),
+						bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(bl / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
+
+// previewCache generates previews and keeps the most recent ones in
+// memory, keyed by the ID of the top block of each file, so that a
+// preview is recomputed only when the file contents change.
+// Previews of private files are never written to disk, and they're
+// all dropped as soon as the user logs out or changes.
+type previewCache struct {
+	getUID     func(ctx context.Context) (keybase1.UID, error)
+	generators map[string]PreviewGenerator
+	cache      *lru.Cache
+	// genSem bounds the CPU and memory used for generating
+	// previews, e.g. while browsing a large folder.
+	genSem chan struct{}
+
+	lock     sync.Mutex
+	uid      keybase1.UID
+	inFlight map[string]*previewCall
+}
+
+// previewCall is a preview generation that other requests for the
+// same preview can wait on.
+type previewCall struct {
+	done chan struct{}
+	buf  []byte
+	err  error
+}
+
+func newPreviewCache(
+	getUID func(ctx context.Context) (keybase1.UID, error),
+	generators map[string]PreviewGenerator) (*previewCache, error) {
+	gens := map[string]PreviewGenerator{
+		"image/gif":  imagePreviewGenerator{},
+		"image/jpeg": imagePreviewGenerator{},
+		"image/png":  imagePreviewGenerator{},
+	}
+	for mimeType, g := range generators {
+		gens[mimeType] = g
+	}
+	cache, err := lru.New(previewCacheSize)
+	if err != nil {
+		return nil, err
+	}
+	return &previewCache{
+		getUID:     getUID,
+		generators: gens,
+		cache:      cache,
+		genSem:     make(chan struct{}, previewMaxConcurrentGenerations),
+		inFlight:   make(map[string]*previewCall),
+	}, nil
+}
+
+func (pc *previewCache) generatorFor(name string) (PreviewGenerator, bool) {
+	mimeType := mime.TypeByExtension(path.Ext(name))
+	if i := strings.Index(mimeType, ";"); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+	g, ok := pc.generators[mimeType]
+	return g, ok
+}
+
+// checkUser drops all the cached previews if the current user isn't
+// the one they were made for, and returns the current user.
+func (pc *previewCache) checkUser(
+	ctx context.Context) (keybase1.UID, error) {
+	uid, err := pc.getUID(ctx)
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+	if err != nil || uid != pc.uid {
+		pc.cache.Purge()
+		pc.uid = ""
+	}
+	if err != nil {
+		return "", err
+	}
+	pc.uid = uid
+	return uid, nil
+}
+
+// get returns the JPEG-encoded preview of the file at `p` in `fs`, no
+// larger than `size` in either dimension.
+func (pc *previewCache) get(
+	ctx context.Context, fs *libfs.FS, p string, size int) ([]byte, error) {
+	g, ok := pc.generatorFor(p)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	uid, err := pc.checkUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := fs.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, os.ErrNotExist
+	}
+	bidg, ok := fi.Sys().(libfs.BlockIDGetter)
+	if !ok {
+		return nil, errors.Errorf("Can't get the block ID of %s", p)
+	}
+	id, err := bidg.BlockID()
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%s-%d", id, size)
+	if buf, ok := pc.cache.Get(key); ok {
+		return buf.([]byte), nil
+	}
+
+	// Only one request generates each preview; the others wait for
+	// it.
+	pc.lock.Lock()
+	call, ok := pc.inFlight[key]
+	if ok {
+		pc.lock.Unlock()
+		select {
+		case <-call.done:
+			return call.buf, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	call = &previewCall{done: make(chan struct{})}
+	pc.inFlight[key] = call
+	pc.lock.Unlock()
+
+	call.buf, call.err = pc.generate(ctx, fs, g, p, size)
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+	delete(pc.inFlight, key)
+	if call.err == nil && pc.uid == uid {
+		pc.cache.Add(key, call.buf)
+	}
+	close(call.done)
+	return call.buf, call.err
+}
+
+func (pc *previewCache) generate(
+	ctx context.Context, fs *libfs.FS, g PreviewGenerator, p string,
+	size int) ([]byte, error) {
+	select {
+	case pc.genSem <- struct{}{}:
+		defer func() { <-pc.genSem }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	f, err := fs.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, err := g.GeneratePreview(ctx, io.LimitReader(f, previewMaxInput))
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	err = jpeg.Encode(&out, scaleImage(img, size), nil)
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// EnablePreviews turns on preview generation for requests with a
+// "preview" query parameter.  Images are supported by default;
+// `generators` can add support for more MIME types, or override the
+// defaults.  New calls it when the KBFS_HTTP_PREVIEWS environment
+// variable is set.
+func (s *Server) EnablePreviews(
+	generators map[string]PreviewGenerator) error {
+	pc, err := newPreviewCache(func(ctx context.Context) (keybase1.UID, error) {
+		session, err := s.config.KBPKI().GetCurrentSession(ctx)
+		if err != nil {
+			return "", err
+		}
+		return session.UID, nil
+	}, generators)
+	if err != nil {
+		return err
+	}
+	s.previewLock.Lock()
+	defer s.previewLock.Unlock()
+	s.previews = pc
+	return nil
+}
+
+func (s *Server) getPreviewCache() *previewCache {
+	s.previewLock.RLock()
+	defer s.previewLock.RUnlock()
+	return s.previews
+}
+
+// servePreview accepts "preview=<max dimension>", defaulting to
+// previewDefaultSize if the size isn't a positive number.
+func (s *Server) servePreview(
+	w http.ResponseWriter, req *http.Request, fs *libfs.FS, p string) {
+	pc := s.getPreviewCache()
+	if pc == nil {
+		http.Error(w, "previews are not enabled", http.StatusNotFound)
+		return
+	}
+	size, err := strconv.Atoi(req.URL.Query().Get("preview"))
+	if err != nil || size < 1 {
+		size = previewDefaultSize
+	} else if size > previewMaxSize {
+		size = previewMaxSize
+	}
+
+	buf, err := pc.get(req.Context(), fs.WithContext(req.Context()), p, size)
+	switch {
+	case ioutil.IsNotExist(err):
+		http.Error(w, "no preview available", http.StatusNotFound)
+		return
+	case isPreviewTooLarge(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		s.logger.Warning("Couldn't make preview for %s; error=%v", p, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(buf)
+}
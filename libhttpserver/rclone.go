@@ -0,0 +1,361 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libhttpserver
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+// The rclone API is a small, stable JSON API for programs that sync
+// files to and from KBFS, like an rclone backend, without going
+// through a mount.  Requests look like
+// "/rclone/<tlf type>/<tlf name>/<path>?token=<token>&op=<op>", where
+// the token comes from `Server.NewToken`, and `op` is one of:
+//
+//   GET                    the file's contents; honors Range headers.
+//   GET op=stat            the entry as a JSON `dirIndexEntry`.
+//   GET op=list            a page of the directory's children, sorted
+//                          by name, as a JSON `rcloneListing`.  Pass
+//                          the returned `NextCursor` as `cursor` to
+//                          get the next page, and `limit` to size the
+//                          page.
+//   GET op=hash            {"Hash": "<hex>"} of the file's contents,
+//                          for `type` "md5", "sha1" or "sha256".
+//   PUT op=upload          writes the request body to the file,
+//                          starting at byte `offset` (default 0),
+//                          creating it if needed and dropping anything
+//                          past `offset` first.  To resume an upload,
+//                          stat the file and continue from its size.
+//                          Takes an optional `mtime`, and returns the
+//                          new entry.
+//   POST op=setmtime       sets the mtime to `mtime`, and returns the
+//                          new entry.
+//   POST op=mkdir          creates the directory and its parents, and
+//                          returns the new entry.
+//   DELETE                 removes the file or empty directory.
+//
+// Times are in RFC 3339 format, with nanoseconds.
+const rcloneRequestPathRoot = "/rclone/"
+
+const (
+	rcloneListDefaultLimit = 1000
+	rcloneListMaxLimit     = 10000
+)
+
+// rcloneListing is one page of a directory listing.
+type rcloneListing struct {
+	Entries []dirIndexEntry
+	// NextCursor is empty on the last page.
+	NextCursor string `json:",omitempty"`
+}
+
+func rcloneEntry(fi os.FileInfo) dirIndexEntry {
+	return dirIndexEntry{
+		Name:  fi.Name(),
+		IsDir: fi.IsDir(),
+		Size:  fi.Size(),
+		Mtime: fi.ModTime(),
+	}
+}
+
+func rcloneHash(hashType string) hash.Hash {
+	switch hashType {
+	case "md5":
+		return md5.New()
+	case "sha1":
+		return sha1.New()
+	case "sha256":
+		return sha256.New()
+	default:
+		return nil
+	}
+}
+
+// rcloneList returns up to `limit` children of `dir` whose names sort
+// after `cursor`.  Paging by name, rather than by offset, keeps the
+// pages consistent while the directory changes.
+func rcloneList(fs *libfs.FS, dir, cursor string, limit int) (
+	rcloneListing, error) {
+	fis, err := fs.ReadDir(dir)
+	if err != nil {
+		return rcloneListing{}, err
+	}
+	sort.Slice(fis, func(i, j int) bool {
+		return fis[i].Name() < fis[j].Name()
+	})
+	start := sort.Search(len(fis), func(i int) bool {
+		return fis[i].Name() > cursor
+	})
+	fis = fis[start:]
+
+	var l rcloneListing
+	if len(fis) > limit {
+		fis = fis[:limit]
+		l.NextCursor = fis[limit-1].Name()
+	}
+	l.Entries = make([]dirIndexEntry, 0, len(fis))
+	for _, fi := range fis {
+		l.Entries = append(l.Entries, rcloneEntry(fi))
+	}
+	return l, nil
+}
+
+// rcloneUpload writes `r` to `p` from `offset` on, and returns the
+// file's new entry.
+func rcloneUpload(fs *libfs.FS, p string, offset int64, r io.Reader,
+	mtime *time.Time) (dirIndexEntry, error) {
+	fi, err := fs.Stat(p)
+	switch {
+	case os.IsNotExist(err):
+		if offset != 0 {
+			return dirIndexEntry{}, errRcloneBadOffset
+		}
+	case err != nil:
+		return dirIndexEntry{}, err
+	case offset > fi.Size():
+		return dirIndexEntry{}, errRcloneBadOffset
+	}
+
+	f, err := fs.OpenFile(p, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return dirIndexEntry{}, err
+	}
+	defer f.Close()
+	if err := f.Truncate(offset); err != nil {
+		return dirIndexEntry{}, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return dirIndexEntry{}, err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		return dirIndexEntry{}, err
+	}
+	if mtime != nil {
+		if err := fs.Chtimes(p, *mtime, *mtime); err != nil {
+			return dirIndexEntry{}, err
+		}
+	}
+	// Make sure what's been written so far survives a restart, so
+	// the upload can resume from the size reported here.
+	if err := fs.SyncAll(); err != nil {
+		return dirIndexEntry{}, err
+	}
+	fi, err = fs.Stat(p)
+	if err != nil {
+		return dirIndexEntry{}, err
+	}
+	return rcloneEntry(fi), nil
+}
+
+type rcloneError struct {
+	msg    string
+	status int
+}
+
+func (e rcloneError) Error() string {
+	return e.msg
+}
+
+var (
+	errRcloneBadOffset = rcloneError{
+		"offset is past the end of the file", http.StatusConflict}
+	errRcloneBadOp = rcloneError{
+		"unknown op for this method", http.StatusBadRequest}
+	errRcloneBadHashType = rcloneError{
+		"unknown hash type", http.StatusBadRequest}
+	errRcloneBadOffsetFormat = rcloneError{
+		"offset must be a non-negative integer", http.StatusBadRequest}
+)
+
+func rcloneStatus(err error) int {
+	switch e := err.(type) {
+	case rcloneError:
+		return e.status
+	case *time.ParseError:
+		return http.StatusBadRequest
+	}
+	switch {
+	case os.IsNotExist(err):
+		return http.StatusNotFound
+	case os.IsExist(err):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func parseRcloneMtime(q map[string][]string) (*time.Time, error) {
+	v, ok := q["mtime"]
+	if !ok {
+		return nil, nil
+	}
+	mtime, err := time.Parse(time.RFC3339Nano, v[0])
+	if err != nil {
+		return nil, err
+	}
+	return &mtime, nil
+}
+
+func rcloneStat(fs *libfs.FS, p string) (interface{}, error) {
+	fi, err := fs.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	return rcloneEntry(fi), nil
+}
+
+// handleRclone runs one rclone API request on `fsPath` within `fs`,
+// and returns what to send back as JSON, or nil if it's already
+// written the response itself.
+func (s *Server) handleRclone(w http.ResponseWriter, req *http.Request,
+	fs *libfs.FS, fsPath string) (interface{}, error) {
+	q := req.URL.Query()
+	switch req.Method + " " + q.Get("op") {
+	case "GET ", "HEAD ":
+		fi, err := fs.Stat(fsPath)
+		if err != nil {
+			return nil, err
+		}
+		if fi.IsDir() {
+			return nil, rcloneError{
+				fsPath + " is a directory", http.StatusBadRequest}
+		}
+		f, err := fs.Open(fsPath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, req, fi.Name(), fi.ModTime(), f)
+		return nil, nil
+	case "GET stat":
+		return rcloneStat(fs, fsPath)
+	case "GET list":
+		limit := queryInt(q, "limit", rcloneListDefaultLimit)
+		if limit > rcloneListMaxLimit {
+			limit = rcloneListMaxLimit
+		}
+		return rcloneList(fs, fsPath, q.Get("cursor"), limit)
+	case "GET hash":
+		h := rcloneHash(q.Get("type"))
+		if h == nil {
+			return nil, errRcloneBadHashType
+		}
+		f, err := fs.Open(fsPath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if _, err := io.Copy(h, f); err != nil {
+			return nil, err
+		}
+		return struct{ Hash string }{hex.EncodeToString(h.Sum(nil))}, nil
+	case "PUT upload":
+		var offset int64
+		if v := q.Get("offset"); v != "" {
+			var err error
+			offset, err = strconv.ParseInt(v, 10, 64)
+			if err != nil || offset < 0 {
+				return nil, errRcloneBadOffsetFormat
+			}
+		}
+		mtime, err := parseRcloneMtime(q)
+		if err != nil {
+			return nil, err
+		}
+		return rcloneUpload(fs, fsPath, offset, req.Body, mtime)
+	case "POST setmtime":
+		mtime, err := parseRcloneMtime(q)
+		if err != nil {
+			return nil, err
+		}
+		if mtime == nil {
+			return nil, rcloneError{"mtime is required", http.StatusBadRequest}
+		}
+		if err := fs.Chtimes(fsPath, *mtime, *mtime); err != nil {
+			return nil, err
+		}
+		return rcloneStat(fs, fsPath)
+	case "POST mkdir":
+		if err := fs.MkdirAll(fsPath, 0700); err != nil {
+			return nil, err
+		}
+		return rcloneStat(fs, fsPath)
+	case "DELETE ":
+		if err := fs.Remove(fsPath); err != nil {
+			return nil, err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil, nil
+	default:
+		return nil, errRcloneBadOp
+	}
+}
+
+// serveRclone accepts "/<fs path>?token=<token>&op=<op>"; see
+// `rcloneRequestPathRoot` for the details.
+func (s *Server) serveRclone(w http.ResponseWriter, req *http.Request) {
+	s.logger.Debug("Incoming rclone request from %q: %s %s",
+		req.UserAgent(), req.Method, req.URL)
+	token := req.URL.Query().Get("token")
+	if len(token) == 0 || !s.tokens.Contains(token) {
+		s.logger.Info("Invalid token %q", token)
+		s.handleInvalidToken(w)
+		return
+	}
+	toStrip, fs, err := s.getFS(req.Context(), req.URL.Path)
+	if err != nil {
+		s.logger.Warning("Bad request; error=%v", err)
+		s.handleBadRequest(w)
+		return
+	}
+	// Not every `libfs.FS` method takes absolute paths, so make it
+	// relative to the TLF root.
+	fsPath := strings.TrimPrefix(
+		path.Clean("/"+strings.TrimPrefix(req.URL.Path, toStrip)), "/")
+	// Writes need to be able to sync, which needs a context that
+	// can delay its cancellation.
+	ctx, err := libkbfs.NewContextWithCancellationDelayer(
+		libkbfs.NewContextReplayable(req.Context(),
+			func(c context.Context) context.Context { return c }))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer libkbfs.CleanupCancellationDelayer(ctx)
+	fs = fs.WithContext(ctx)
+
+	res, err := s.handleRclone(w, req, fs, fsPath)
+	if err != nil {
+		s.logger.Debug("rclone %s %s failed; error=%v",
+			req.Method, req.URL.Path, err)
+		http.Error(w, err.Error(), rcloneStatus(err))
+		return
+	}
+	if res == nil {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		s.logger.Warning("Couldn't write rclone response; error=%v", err)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"errors"
 	"io"
 	"net/http"
+	"os"
 	"path"
 	"strings"
 	"sync"
@@ -26,8 +27,13 @@ import (
 	"github.com/keybase/kbfs/tlf"
 )
 
+// previewsEnvVar turns on previews (see Server.EnablePreviews) when
+// set to a true value.
+const previewsEnvVar = "KBFS_HTTP_PREVIEWS"
+
 const tokenCacheSize = 64
 const fsCacheSize = 64
+const contentTypeCacheSize = 1024
 
 // Server is a local HTTP server for serving KBFS content over HTTP.
 type Server struct {
@@ -36,11 +42,15 @@ type Server struct {
 	appStateUpdater env.AppStateUpdater
 	cancel          func()
 
-	tokens *lru.Cache
-	fs     *lru.Cache
+	tokens       *lru.Cache
+	fs           *lru.Cache
+	contentTypes *lru.Cache
 
 	serverLock sync.RWMutex
 	server     *kbhttp.Srv
+
+	previewLock sync.RWMutex
+	previews    *previewCache
 }
 
 const tokenByteSize = 16
@@ -89,8 +99,8 @@ func (e obsoleteTrackingFS) isObsolete() bool {
 	}
 }
 
-func (s *Server) getHTTPFileSystem(ctx context.Context, requestPath string) (
-	toStrip string, fs http.FileSystem, err error) {
+func (s *Server) getFS(ctx context.Context, requestPath string) (
+	toStrip string, fs *libfs.FS, err error) {
 	fields := strings.Split(requestPath, "/")
 	if len(fields) < 2 {
 		return "", nil, errors.New("bad path")
@@ -106,7 +116,7 @@ func (s *Server) getHTTPFileSystem(ctx context.Context, requestPath string) (
 	if fsCached, ok := s.fs.Get(toStrip); ok {
 		if fsCachedTyped, ok := fsCached.(obsoleteTrackingFS); ok {
 			if !fsCachedTyped.isObsolete() {
-				return toStrip, fsCachedTyped.fs.ToHTTPFileSystem(ctx), nil
+				return toStrip, fsCachedTyped.fs, nil
 			}
 		}
 	}
@@ -131,7 +141,7 @@ func (s *Server) getHTTPFileSystem(ctx context.Context, requestPath string) (
 
 	s.fs.Add(toStrip, obsoleteTrackingFS{fs: tlfFS, ch: fsLifeCh})
 
-	return toStrip, tlfFS.ToHTTPFileSystem(ctx), nil
+	return toStrip, tlfFS, nil
 }
 
 // serve accepts "/<fs path>?token=<token>"
@@ -145,13 +155,50 @@ func (s *Server) serve(w http.ResponseWriter, req *http.Request) {
 		s.handleInvalidToken(w)
 		return
 	}
-	toStrip, fs, err := s.getHTTPFileSystem(req.Context(), req.URL.Path)
+	toStrip, fs, err := s.getFS(req.Context(), req.URL.Path)
 	if err != nil {
 		s.logger.Warning("Bad request; error=%v", err)
 		s.handleBadRequest(w)
 		return
 	}
-	http.StripPrefix(toStrip, http.FileServer(fs)).ServeHTTP(
+
+	// Serve our own paginated index for directories, rather than
+	// letting http.FileServer list everything at once.
+	fsPath := path.Clean("/" + strings.TrimPrefix(req.URL.Path, toStrip))
+	fs = fs.WithContext(req.Context())
+	fi, err := fs.Stat(fsPath)
+	if err == nil && fi.IsDir() {
+		if !strings.HasSuffix(req.URL.Path, "/") {
+			// Make relative links in the index work.  Set the
+			// location directly, since `http.Redirect` can't handle
+			// the stripped request path.
+			w.Header().Set("Location",
+				path.Base(req.URL.Path)+"/?"+req.URL.RawQuery)
+			w.WriteHeader(http.StatusMovedPermanently)
+			return
+		}
+		s.serveDirIndex(w, req, fs, fsPath)
+		return
+	}
+	if _, ok := req.URL.Query()["preview"]; ok {
+		s.servePreview(w, req, fs, fsPath)
+		return
+	}
+	if err == nil {
+		// Set the type up front, so http.FileServer doesn't go by
+		// the extension alone.  For HEAD requests, this is the only
+		// part of the file that's read.
+		ty, err := s.getContentType(fs, fsPath, fi)
+		if err != nil {
+			s.logger.Warning(
+				"Couldn't detect content type of %s; error=%v", fsPath, err)
+		} else {
+			w.Header().Set("Content-Type", ty)
+		}
+	}
+
+	http.StripPrefix(toStrip, http.FileServer(
+		fs.ToHTTPFileSystem(req.Context()))).ServeHTTP(
 		newContentTypeOverridingResponseWriter(w), req)
 }
 
@@ -180,6 +227,8 @@ func (s *Server) restart() (err error) {
 	// Have to start this first to populate the ServeMux object.
 	s.server.Handle(requestPathRoot,
 		http.StripPrefix(requestPathRoot, http.HandlerFunc(s.serve)))
+	s.server.Handle(rcloneRequestPathRoot, http.StripPrefix(
+		rcloneRequestPathRoot, http.HandlerFunc(s.serveRclone)))
 	return nil
 }
 
@@ -224,6 +273,14 @@ func New(appStateUpdater env.AppStateUpdater, config libkbfs.Config) (
 	if s.fs, err = lru.New(fsCacheSize); err != nil {
 		return nil, err
 	}
+	if s.contentTypes, err = lru.New(contentTypeCacheSize); err != nil {
+		return nil, err
+	}
+	if libkbfs.BoolForString(os.Getenv(previewsEnvVar)) {
+		if err = s.EnablePreviews(nil); err != nil {
+			return nil, err
+		}
+	}
 	if err = s.restart(); err != nil {
 		return nil, err
 	}
@@ -247,4 +304,7 @@ func (s *Server) Shutdown() {
 	defer s.serverLock.Unlock()
 	s.server.Stop()
 	s.cancel()
+	s.previewLock.Lock()
+	defer s.previewLock.Unlock()
+	s.previews = nil
 }
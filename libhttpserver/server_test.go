@@ -5,11 +5,22 @@
 package libhttpserver
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
 	"net/http"
+	"net/url"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/kbfs/env"
 	"github.com/keybase/kbfs/ioutil"
 	"github.com/keybase/kbfs/libkbfs"
@@ -91,3 +102,356 @@ func TestServerDefault(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
 }
+
+func TestServerDirIndex(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	kbfsConfig := libkbfs.MakeTestConfigOrBust(t, "alice")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, kbfsConfig)
+
+	h, err := libkbfs.ParseTlfHandle(ctx, kbfsConfig.KBPKI(),
+		kbfsConfig.MDOps(), "alice", tlf.Private)
+	require.NoError(t, err)
+	root, _, err := kbfsConfig.KBFSOps().GetOrCreateRootNode(
+		ctx, h, libkbfs.MasterBranch)
+	require.NoError(t, err)
+	dir, _, err := kbfsConfig.KBFSOps().CreateDir(ctx, root, "dir")
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		f, _, err := kbfsConfig.KBFSOps().CreateFile(
+			ctx, dir, fmt.Sprintf("f%d", i), false, false)
+		require.NoError(t, err)
+		err = kbfsConfig.KBFSOps().Write(ctx, f, make([]byte, 5-i), 0)
+		require.NoError(t, err)
+	}
+	err = kbfsConfig.KBFSOps().SyncAll(ctx, root.GetFolderBranch())
+	require.NoError(t, err)
+
+	s, err := New(env.EmptyAppStateUpdater{}, kbfsConfig)
+	require.NoError(t, err)
+	defer s.Shutdown()
+	addr, err := s.Address()
+	require.NoError(t, err)
+	token, err := s.NewToken()
+	require.NoError(t, err)
+
+	getIndex := func(query string) dirIndex {
+		resp, err := http.Get(fmt.Sprintf(
+			"http://%s/files/private/alice/dir?token=%s&format=json%s",
+			addr, token, query))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var di dirIndex
+		err = json.NewDecoder(resp.Body).Decode(&di)
+		require.NoError(t, err)
+		return di
+	}
+
+	di := getIndex("&per_page=2")
+	require.Equal(t, 5, di.TotalEntries)
+	require.Equal(t, 3, di.TotalPages)
+	require.Len(t, di.Entries, 2)
+	require.Equal(t, "f0", di.Entries[0].Name)
+	require.Equal(t, "f1", di.Entries[1].Name)
+
+	di = getIndex("&per_page=2&page=3")
+	require.Len(t, di.Entries, 1)
+	require.Equal(t, "f4", di.Entries[0].Name)
+
+	di = getIndex("&sort=size")
+	require.Len(t, di.Entries, 5)
+	require.Equal(t, "f4", di.Entries[0].Name)
+	require.Equal(t, int64(1), di.Entries[0].Size)
+
+	di = getIndex("&sort=name&order=desc")
+	require.Equal(t, "f4", di.Entries[0].Name)
+
+	resp, err := http.Get(fmt.Sprintf(
+		"http://%s/files/private/alice/?token=%s", addr, token))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), `href="dir/?token=`+token)
+}
+
+func TestServerPreview(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	kbfsConfig := libkbfs.MakeTestConfigOrBust(t, "alice")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, kbfsConfig)
+
+	img := image.NewRGBA(image.Rect(0, 0, 100, 50))
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	require.NoError(t, err)
+
+	h, err := libkbfs.ParseTlfHandle(ctx, kbfsConfig.KBPKI(),
+		kbfsConfig.MDOps(), "alice", tlf.Private)
+	require.NoError(t, err)
+	root, _, err := kbfsConfig.KBFSOps().GetOrCreateRootNode(
+		ctx, h, libkbfs.MasterBranch)
+	require.NoError(t, err)
+	f, _, err := kbfsConfig.KBFSOps().CreateFile(
+		ctx, root, "a.png", false, false)
+	require.NoError(t, err)
+	err = kbfsConfig.KBFSOps().Write(ctx, f, buf.Bytes(), 0)
+	require.NoError(t, err)
+	err = kbfsConfig.KBFSOps().SyncAll(ctx, root.GetFolderBranch())
+	require.NoError(t, err)
+
+	s, err := New(env.EmptyAppStateUpdater{}, kbfsConfig)
+	require.NoError(t, err)
+	defer s.Shutdown()
+	addr, err := s.Address()
+	require.NoError(t, err)
+	token, err := s.NewToken()
+	require.NoError(t, err)
+	previewURL := fmt.Sprintf(
+		"http://%s/files/private/alice/a.png?token=%s&preview=20", addr, token)
+
+	t.Log("Previews are off by default")
+	resp, err := http.Get(previewURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	err = s.EnablePreviews(nil)
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		resp, err = http.Get(previewURL)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "image/jpeg", resp.Header.Get("Content-Type"))
+		preview, err := jpeg.Decode(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		require.Equal(t, image.Rect(0, 0, 20, 10), preview.Bounds())
+	}
+	pc := s.getPreviewCache()
+	require.Equal(t, 1, pc.cache.Len())
+
+	t.Log("Previews are dropped when the user changes")
+	pc.getUID = func(context.Context) (keybase1.UID, error) {
+		return keybase1.MakeTestUID(99), nil
+	}
+	_, err = pc.checkUser(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, pc.cache.Len())
+}
+
+func TestDecodePreviewImageTooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1, 1)))
+	require.NoError(t, err)
+	img, err := decodePreviewImage(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, image.Rect(0, 0, 1, 1), img.Bounds())
+
+	// Claim a huge size in the IHDR chunk, and fix up its CRC, to
+	// make a tiny file that would decode into a huge image.
+	b := buf.Bytes()
+	binary.BigEndian.PutUint32(b[16:20], 1<<16)
+	binary.BigEndian.PutUint32(b[20:24], 1<<16)
+	binary.BigEndian.PutUint32(b[29:33], crc32.ChecksumIEEE(b[12:29]))
+	_, err = decodePreviewImage(bytes.NewReader(b))
+	require.True(t, isPreviewTooLarge(err), "%+v", err)
+}
+
+func TestDetectContentType(t *testing.T) {
+	var pngBuf bytes.Buffer
+	err := png.Encode(&pngBuf, image.NewRGBA(image.Rect(0, 0, 1, 1)))
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name     string
+		head     []byte
+		expected string
+	}{
+		{"a.png", pngBuf.Bytes(), "image/png"},
+		// The contents beat a misleading extension.
+		{"a.txt", pngBuf.Bytes(), "image/png"},
+		{"a.png", []byte("<html><body>hi</body></html>"),
+			"text/html; charset=utf-8"},
+		// A more specific textual extension refines plain text.
+		{"a.css", []byte("a { color: red }"), "text/css; charset=utf-8"},
+		{"a", []byte("hello"), "text/plain; charset=utf-8"},
+		// Don't claim UTF-8 for Latin-1 text.
+		{"a.txt", []byte("caf\xe9 au lait"), "text/plain"},
+		// A rune cut off at the end of the sniffed bytes is fine.
+		{"a.txt", []byte("caf\xc3"), "text/plain; charset=utf-8"},
+	} {
+		require.Equal(t, tc.expected, detectContentType(tc.name, tc.head),
+			"%s: %q", tc.name, tc.head)
+	}
+}
+
+func TestServerContentType(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	kbfsConfig := libkbfs.MakeTestConfigOrBust(t, "alice")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, kbfsConfig)
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 10, 10)))
+	require.NoError(t, err)
+
+	h, err := libkbfs.ParseTlfHandle(ctx, kbfsConfig.KBPKI(),
+		kbfsConfig.MDOps(), "alice", tlf.Private)
+	require.NoError(t, err)
+	root, _, err := kbfsConfig.KBFSOps().GetOrCreateRootNode(
+		ctx, h, libkbfs.MasterBranch)
+	require.NoError(t, err)
+	f, _, err := kbfsConfig.KBFSOps().CreateFile(
+		ctx, root, "image.txt", false, false)
+	require.NoError(t, err)
+	err = kbfsConfig.KBFSOps().Write(ctx, f, buf.Bytes(), 0)
+	require.NoError(t, err)
+	err = kbfsConfig.KBFSOps().SyncAll(ctx, root.GetFolderBranch())
+	require.NoError(t, err)
+
+	s, err := New(env.EmptyAppStateUpdater{}, kbfsConfig)
+	require.NoError(t, err)
+	defer s.Shutdown()
+	addr, err := s.Address()
+	require.NoError(t, err)
+	token, err := s.NewToken()
+	require.NoError(t, err)
+	url := fmt.Sprintf(
+		"http://%s/files/private/alice/image.txt?token=%s", addr, token)
+
+	resp, err := http.Head(url)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+	require.Equal(t, "inline", resp.Header.Get("Content-Disposition"))
+	require.Equal(t, fmt.Sprintf("%d", buf.Len()),
+		resp.Header.Get("Content-Length"))
+	require.Equal(t, 1, s.contentTypes.Len())
+
+	resp, err = http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "image/png", resp.Header.Get("Content-Type"))
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, buf.Bytes(), body)
+}
+
+func TestServerRclone(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	kbfsConfig := libkbfs.MakeTestConfigOrBust(t, "alice")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, kbfsConfig)
+
+	s, err := New(env.EmptyAppStateUpdater{}, kbfsConfig)
+	require.NoError(t, err)
+	defer s.Shutdown()
+	addr, err := s.Address()
+	require.NoError(t, err)
+	token, err := s.NewToken()
+	require.NoError(t, err)
+
+	// Don't reuse connections to earlier tests' servers on this port.
+	client := &http.Client{Transport: &http.Transport{}}
+	do := func(method, p, query string, body string,
+		header http.Header) *http.Response {
+		req, err := http.NewRequest(method, fmt.Sprintf(
+			"http://%s/rclone/private/alice/%s?token=%s%s",
+			addr, p, token, query), bytes.NewBufferString(body))
+		require.NoError(t, err)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+	doJSON := func(method, p, query, body string, res interface{}) {
+		resp := do(method, p, query, body, nil)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		err := json.NewDecoder(resp.Body).Decode(res)
+		require.NoError(t, err)
+	}
+
+	t.Log("Make a directory and upload a file in two parts")
+	var e dirIndexEntry
+	doJSON("POST", "dir/sub", "&op=mkdir", "", &e)
+	require.True(t, e.IsDir)
+	doJSON("PUT", "dir/f", "&op=upload", "hello ", &e)
+	require.Equal(t, int64(6), e.Size)
+	mtime := time.Date(2018, 5, 1, 12, 0, 0, 123, time.UTC)
+	doJSON("PUT", "dir/f", "&op=upload&offset=6&mtime="+
+		url.QueryEscape(mtime.Format(time.RFC3339Nano)), "world", &e)
+	require.Equal(t, int64(11), e.Size)
+	require.True(t, mtime.Equal(e.Mtime))
+
+	resp := do("PUT", "dir/f", "&op=upload&offset=12", "!", nil)
+	require.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp = do("PUT", "dir/f", "&op=upload&offset=-1", "!", nil)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	t.Log("Resuming from an earlier offset drops the rest")
+	doJSON("PUT", "dir/g", "&op=upload", "abcdef", &e)
+	doJSON("PUT", "dir/g", "&op=upload&offset=3", "XY", &e)
+	require.Equal(t, int64(5), e.Size)
+
+	t.Log("Ranged reads")
+	resp = do("GET", "dir/f", "", "", http.Header{"Range": {"bytes=2-7"}})
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "llo wo", string(body))
+	resp = do("GET", "dir/g", "", "", nil)
+	body, err = ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "abcXY", string(body))
+	resp = do("GET", "dir/none", "", "", nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	t.Log("Hashes")
+	var h struct{ Hash string }
+	doJSON("GET", "dir/f", "&op=hash&type=md5", "", &h)
+	require.Equal(t, "5eb63bbbe01eeed093cb22bb8f5acdc3", h.Hash)
+	doJSON("GET", "dir/f", "&op=hash&type=sha1", "", &h)
+	require.Equal(t, "2aae6c35c94fcfb415dbe95f408b9ce91ee846ed", h.Hash)
+	resp = do("GET", "dir/f", "&op=hash&type=crc", "", nil)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	t.Log("Set the mtime")
+	mtime = mtime.Add(time.Hour)
+	doJSON("POST", "dir/g", "&op=setmtime&mtime="+
+		url.QueryEscape(mtime.Format(time.RFC3339Nano)), "", &e)
+	require.True(t, mtime.Equal(e.Mtime))
+	doJSON("GET", "dir/g", "&op=stat", "", &e)
+	require.Equal(t, "g", e.Name)
+	require.True(t, mtime.Equal(e.Mtime))
+
+	t.Log("Paginated listing")
+	var names []string
+	cursor := ""
+	pages := 0
+	for {
+		var l rcloneListing
+		doJSON("GET", "dir", "&op=list&limit=2&cursor="+cursor, "", &l)
+		pages++
+		for _, e := range l.Entries {
+			names = append(names, e.Name)
+		}
+		if l.NextCursor == "" {
+			break
+		}
+		cursor = url.QueryEscape(l.NextCursor)
+	}
+	require.Equal(t, []string{"f", "g", "sub"}, names)
+	require.Equal(t, 2, pages)
+
+	t.Log("Delete")
+	resp = do("DELETE", "dir/g", "", "", nil)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp = do("GET", "dir/g", "&op=stat", "", nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = do("PATCH", "dir/f", "", "", nil)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,383 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"encoding/binary"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	kbname "github.com/keybase/client/go/kbun"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"golang.org/x/net/context"
+)
+
+const (
+	accessLogFolderName = "kbfs_access_log"
+
+	// accessLogRetention is how long logged reads are kept.
+	accessLogRetention = 90 * 24 * time.Hour
+	// accessLogDedupeWindow is how long after a read of a file is
+	// logged that further reads of it aren't, so that a file read
+	// in many chunks is only logged once.
+	accessLogDedupeWindow = 10 * time.Second
+	// maxAccessLogDedupePaths bounds how many recently-logged paths
+	// are remembered per TLF.
+	maxAccessLogDedupePaths = 1000
+)
+
+// AccessLogEntry records one read of a file.
+type AccessLogEntry struct {
+	Time   time.Time
+	User   kbname.NormalizedUsername
+	Device string
+	// Path is relative to the root of the TLF.
+	Path string
+}
+
+// accessLogStoreGetter is implemented by configs that can log the
+// reads of TLFs that turn on read access logging.
+type accessLogStoreGetter interface {
+	// accessLogStore returns nil if reads aren't logged.
+	accessLogStore() *diskAccessLog
+}
+
+// getAccessLogStore returns the access log store of `owner`, or nil
+// if it doesn't have one.
+func getAccessLogStore(owner interface{}) *diskAccessLog {
+	alsg, ok := owner.(accessLogStoreGetter)
+	if !ok {
+		return nil
+	}
+	return alsg.accessLogStore()
+}
+
+// diskAccessLog keeps the logged reads of TLFs in a store in the
+// storage root.  It's local to the device: nothing in it is ever
+// written to a TLF, so the reads of a TLF don't make new revisions
+// of it, and the log can't be edited by the TLF's other writers.
+// It also records which team TLFs the user opted in to logging on
+// this device.
+//
+// Entries are keyed by TLF and time, so that they can be listed and
+// pruned in order.
+type diskAccessLog struct {
+	codec kbfscodec.Codec
+	// seq breaks ties between entries logged at the same time.
+	seq uint32
+
+	lock sync.RWMutex
+	db   *levelDb // nil after shutdown
+}
+
+func newDiskAccessLog(codec kbfscodec.Codec, db *levelDb) *diskAccessLog {
+	return &diskAccessLog{
+		codec: codec,
+		db:    db,
+	}
+}
+
+func accessLogEntryPrefix(tlfID tlf.ID) []byte {
+	return []byte("entry/" + tlfID.String() + "/")
+}
+
+func accessLogEntryKey(tlfID tlf.ID, t time.Time, seq uint32) []byte {
+	key := accessLogEntryPrefix(tlfID)
+	var buf [12]byte
+	binary.BigEndian.PutUint64(buf[:8], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint32(buf[8:], seq)
+	return append(key, buf[:]...)
+}
+
+func accessLogOptInKey(tlfID tlf.ID) []byte {
+	return []byte("optin/" + tlfID.String())
+}
+
+// put logs `e` as a read of a file in the given TLF.
+func (l *diskAccessLog) put(tlfID tlf.ID, e AccessLogEntry) error {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if l.db == nil {
+		return nil
+	}
+	buf, err := l.codec.Encode(e)
+	if err != nil {
+		return err
+	}
+	seq := atomic.AddUint32(&l.seq, 1)
+	return l.db.Put(accessLogEntryKey(tlfID, e.Time, seq), buf, nil)
+}
+
+// list returns the logged reads of files in the given TLF whose
+// paths contain `filter`, most recent first.
+func (l *diskAccessLog) list(tlfID tlf.ID, filter string) (
+	entries []AccessLogEntry, err error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if l.db == nil {
+		return nil, nil
+	}
+	iter := l.db.NewIterator(
+		util.BytesPrefix(accessLogEntryPrefix(tlfID)), nil)
+	defer iter.Release()
+	for ok := iter.Last(); ok; ok = iter.Prev() {
+		var e AccessLogEntry
+		err := l.codec.Decode(iter.Value(), &e)
+		if err != nil {
+			return nil, err
+		}
+		if filter != "" && !strings.Contains(e.Path, filter) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return entries, nil
+}
+
+// prune forgets the logged reads of the given TLF from before
+// `before`.
+func (l *diskAccessLog) prune(tlfID tlf.ID, before time.Time) error {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if l.db == nil {
+		return nil
+	}
+	iter := l.db.NewIterator(&util.Range{
+		Start: accessLogEntryPrefix(tlfID),
+		Limit: accessLogEntryKey(tlfID, before, 0),
+	}, nil)
+	defer iter.Release()
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		batch.Delete(append([]byte(nil), iter.Key()...))
+	}
+	if err := iter.Error(); err != nil {
+		return errors.WithStack(err)
+	}
+	if batch.Len() == 0 {
+		return nil
+	}
+	return errors.WithStack(l.db.Write(batch, nil))
+}
+
+// isOptedIn returns whether the user opted in to logging their reads
+// of the given team TLF on this device.
+func (l *diskAccessLog) isOptedIn(tlfID tlf.ID) (bool, error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if l.db == nil {
+		return false, nil
+	}
+	_, err := l.db.Get(accessLogOptInKey(tlfID), nil)
+	if errors.Cause(err) == leveldb.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (l *diskAccessLog) setOptIn(tlfID tlf.ID, optIn bool) error {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if l.db == nil {
+		return nil
+	}
+	if optIn {
+		return l.db.Put(accessLogOptInKey(tlfID), []byte{1}, nil)
+	}
+	return errors.WithStack(l.db.Delete(accessLogOptInKey(tlfID), nil))
+}
+
+// shutdown closes the store.  After it's called, nothing is logged.
+func (l *diskAccessLog) shutdown() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.db == nil {
+		return
+	}
+	_ = l.db.Close()
+	l.db = nil
+}
+
+// accessLog holds the per-TLF state of read access logging.
+type accessLog struct {
+	lock sync.Mutex
+	// lastLogged maps recently-logged paths to when they were
+	// logged.
+	lastLogged map[string]time.Time
+	// deviceNames caches the names of this user's devices.
+	deviceNames map[keybase1.KID]string
+	pruned      bool
+}
+
+// shouldLog returns whether a read of `path` at `now` should be
+// logged, and whether old entries should be pruned first.
+func (al *accessLog) shouldLog(path string, now time.Time) (
+	doLog, prune bool) {
+	al.lock.Lock()
+	defer al.lock.Unlock()
+	if last, ok := al.lastLogged[path]; ok &&
+		now.Sub(last) < accessLogDedupeWindow {
+		return false, false
+	}
+	if al.lastLogged == nil ||
+		len(al.lastLogged) >= maxAccessLogDedupePaths {
+		al.lastLogged = make(map[string]time.Time)
+	}
+	al.lastLogged[path] = now
+	prune = !al.pruned
+	al.pruned = true
+	return true, prune
+}
+
+func (fbo *folderBranchOps) accessLogDeviceName(
+	ctx context.Context, session SessionInfo) string {
+	kid := session.VerifyingKey.KID()
+	fbo.accessLog.lock.Lock()
+	name, ok := fbo.accessLog.deviceNames[kid]
+	fbo.accessLog.lock.Unlock()
+	if ok {
+		return name
+	}
+
+	name = kid.String()
+	ui, err := fbo.config.KeybaseService().LoadUserPlusKeys(
+		ctx, session.UID, "")
+	if err != nil {
+		// Try again next time.
+		return name
+	}
+	if ui.KIDNames[kid] != "" {
+		name = ui.KIDNames[kid]
+	}
+	fbo.accessLog.lock.Lock()
+	defer fbo.accessLog.lock.Unlock()
+	if fbo.accessLog.deviceNames == nil {
+		fbo.accessLog.deviceNames = make(map[keybase1.KID]string)
+	}
+	fbo.accessLog.deviceNames[kid] = name
+	return name
+}
+
+// accessLogEnabled returns whether this device should log reads of
+// this TLF: the TLF must turn logging on in its settings, and in a
+// team TLF, the user must also have opted in on this device.  In
+// other TLFs, only the reads of writers are logged.  Public TLFs are
+// never logged.
+func (fbo *folderBranchOps) accessLogEnabled(ctx context.Context,
+	store *diskAccessLog, session SessionInfo) (bool, error) {
+	if fbo.id().Type() == tlf.Public {
+		return false, nil
+	}
+	settings, loaded := fbo.peekTlfSettings()
+	if !loaded {
+		var err error
+		settings, err = fbo.getCachedTlfSettings(ctx)
+		if err != nil {
+			return false, err
+		}
+	}
+	if !settings.AccessLog {
+		return false, nil
+	}
+	if fbo.id().Type() == tlf.SingleTeam {
+		return store.isOptedIn(fbo.id())
+	}
+	_, _, handle, err := fbo.getRootNode(ctx)
+	if err != nil {
+		return false, err
+	}
+	return handle.IsWriter(session.UID), nil
+}
+
+// recordAccess logs that `file` was just read, if the TLF has read
+// access logging turned on.
+func (fbo *folderBranchOps) recordAccess(ctx context.Context, file Node) {
+	if ctx.Value(ctxSkipRecentFilesKey) != nil {
+		return
+	}
+	store := getAccessLogStore(fbo.config)
+	if store == nil {
+		return
+	}
+	session, err := fbo.config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return
+	}
+	enabled, err := fbo.accessLogEnabled(ctx, store, session)
+	if err != nil {
+		fbo.log.CDebugf(ctx, "Couldn't check the access log settings: %+v",
+			err)
+		return
+	}
+	if !enabled {
+		return
+	}
+	p, err := fbo.pathFromNodeForRead(file)
+	if err != nil || !p.isValid() {
+		return
+	}
+	names := make([]string, 0, len(p.path)-1)
+	for _, pn := range p.path[1:] {
+		names = append(names, pn.Name)
+	}
+	path := strings.Join(names, "/")
+	now := fbo.config.Clock().Now()
+	doLog, prune := fbo.accessLog.shouldLog(path, now)
+	if !doLog {
+		return
+	}
+	if prune {
+		err := store.prune(fbo.id(), now.Add(-accessLogRetention))
+		if err != nil {
+			fbo.log.CDebugf(ctx, "Couldn't prune the access log: %+v", err)
+		}
+	}
+	err = store.put(fbo.id(), AccessLogEntry{
+		Time:   now,
+		User:   session.Name,
+		Device: fbo.accessLogDeviceName(ctx, session),
+		Path:   path,
+	})
+	if err != nil {
+		fbo.log.CDebugf(ctx, "Couldn't log a read of %s: %+v", path, err)
+	}
+}
+
+// GetAccessLog returns the reads of files under the TLF with root
+// `rootNode` that this device logged, whose paths contain `filter`,
+// most recent first.
+func GetAccessLog(ctx context.Context, config Config, rootNode Node,
+	filter string) ([]AccessLogEntry, error) {
+	store := getAccessLogStore(config)
+	if store == nil {
+		return nil, nil
+	}
+	return store.list(rootNode.GetFolderBranch().Tlf, filter)
+}
+
+// SetAccessLogOptIn sets whether this device logs the user's reads of
+// the given team TLF, when the TLF's settings turn read access
+// logging on.  It has no effect on other TLFs, whose writers' reads
+// are always logged when logging is on.
+func SetAccessLogOptIn(
+	ctx context.Context, config Config, tlfID tlf.ID, optIn bool) error {
+	store := getAccessLogStore(config)
+	if store == nil {
+		return errors.New("Read access logging isn't enabled on this device")
+	}
+	return store.setOptIn(tlfID, optIn)
+}
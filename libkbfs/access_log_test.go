@@ -0,0 +1,143 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	kbname "github.com/keybase/client/go/kbun"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestAccessLog(t *testing.T) {
+	var u1 kbname.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	clock, _ := newTestClockAndTimeNow()
+	config.SetClock(clock)
+	ldb, err := openLevelDB(storage.NewMemStorage())
+	require.NoError(t, err)
+	config.diskAccessLog = newDiskAccessLog(config.Codec(), ldb)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps()
+	dir, _, err := kbfsOps.CreateDir(ctx, rootNode, "finance")
+	require.NoError(t, err)
+	f, _, err := kbfsOps.CreateFile(ctx, dir, "payroll.xls", false, NoExcl)
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps.Write(ctx, f, []byte("salaries"), 0))
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+	buf := make([]byte, 4)
+
+	t.Log("The log can't be stored in the TLF")
+	_, _, err = kbfsOps.CreateDir(ctx, rootNode, ".kbfs_access_log")
+	require.IsType(t, DisallowedPrefixError{}, errors.Cause(err))
+
+	t.Log("Reads aren't logged until the settings turn logging on")
+	_, err = kbfsOps.Read(ctx, f, buf, 0)
+	require.NoError(t, err)
+	entries, err := GetAccessLog(ctx, config, rootNode, "")
+	require.NoError(t, err)
+	require.Len(t, entries, 0)
+
+	t.Log("Once they do, a file read in chunks is logged once")
+	err = WriteTlfSettings(ctx, kbfsOps, rootNode, TlfSettings{
+		Version:   TlfSettingsVersion,
+		AccessLog: true,
+	})
+	require.NoError(t, err)
+	status, _, err := kbfsOps.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+	rev := status.Revision
+	_, err = kbfsOps.Read(ctx, f, buf, 0)
+	require.NoError(t, err)
+	_, err = kbfsOps.Read(ctx, f, buf, 4)
+	require.NoError(t, err)
+	entries, err = GetAccessLog(ctx, config, rootNode, "payroll")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "finance/payroll.xls", entries[0].Path)
+	require.Equal(t, u1, entries[0].User)
+	require.NotEmpty(t, entries[0].Device)
+
+	t.Log("Later reads are logged too, without changing the TLF")
+	clock.Add(accessLogDedupeWindow)
+	_, err = kbfsOps.Read(ctx, f, buf, 0)
+	require.NoError(t, err)
+	entries, err = GetAccessLog(ctx, config, rootNode, "")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.True(t, entries[0].Time.After(entries[1].Time))
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+	status, _, err = kbfsOps.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, rev, status.Revision)
+
+	entries, err = GetAccessLog(ctx, config, rootNode, "nothing")
+	require.NoError(t, err)
+	require.Len(t, entries, 0)
+}
+
+func TestDiskAccessLog(t *testing.T) {
+	ldb, err := openLevelDB(storage.NewMemStorage())
+	require.NoError(t, err)
+	store := newDiskAccessLog(kbfscodec.NewMsgpack(), ldb)
+	defer store.shutdown()
+
+	id1 := tlf.FakeID(1, tlf.Private)
+	id2 := tlf.FakeID(2, tlf.SingleTeam)
+	start := time.Unix(1000, 0)
+	for i, p := range []string{"a", "b", "a/c"} {
+		err := store.put(id1, AccessLogEntry{
+			Time: start.Add(time.Duration(i) * time.Hour),
+			Path: p,
+		})
+		require.NoError(t, err)
+	}
+	err = store.put(id2, AccessLogEntry{Time: start, Path: "a"})
+	require.NoError(t, err)
+
+	t.Log("Entries are listed per TLF, most recent first")
+	entries, err := store.list(id1, "a")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "a/c", entries[0].Path)
+	require.Equal(t, "a", entries[1].Path)
+	entries, err = store.list(id2, "")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	t.Log("Pruning only drops the older entries of one TLF")
+	require.NoError(t, store.prune(id1, start.Add(90*time.Minute)))
+	entries, err = store.list(id1, "")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "a/c", entries[0].Path)
+	entries, err = store.list(id2, "")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	t.Log("Team members opt in per TLF")
+	optedIn, err := store.isOptedIn(id2)
+	require.NoError(t, err)
+	require.False(t, optedIn)
+	require.NoError(t, store.setOptIn(id2, true))
+	optedIn, err = store.isOptedIn(id2)
+	require.NoError(t, err)
+	require.True(t, optedIn)
+	optedIn, err = store.isOptedIn(id1)
+	require.NoError(t, err)
+	require.False(t, optedIn)
+	require.NoError(t, store.setOptIn(id2, false))
+	optedIn, err = store.isOptedIn(id2)
+	require.NoError(t, err)
+	require.False(t, optedIn)
+}
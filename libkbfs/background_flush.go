@@ -0,0 +1,62 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"runtime"
+
+	"github.com/keybase/kbfs/kbfssync"
+	"golang.org/x/net/context"
+)
+
+// backgroundFlushPutsPerCPU is how many block puts the background
+// journal flushes of all TLFs may have in flight at once, per CPU.
+// Each put reads, encodes and sends a block, so this bounds how much
+// of the machine flushing can use, leaving the rest for interactive
+// operations.
+const backgroundFlushPutsPerCPU = 2
+
+type ctxBackgroundFlushKeyType int
+
+const ctxBackgroundFlushKey ctxBackgroundFlushKeyType = iota
+
+// makeBackgroundFlushBudget returns the semaphore shared by the
+// background flushes of all TLF journals.
+func makeBackgroundFlushBudget() *kbfssync.Semaphore {
+	budget := kbfssync.NewSemaphore()
+	budget.Release(int64(runtime.NumCPU() * backgroundFlushPutsPerCPU))
+	return budget
+}
+
+// withBackgroundFlush marks `ctx` as belonging to a background journal
+// flush.  Block puts made under it go over their own block server
+// connection, so they don't queue up in front of interactive writes,
+// and each takes a unit of `budget` (if non-nil) while in flight.
+func withBackgroundFlush(
+	ctx context.Context, budget *kbfssync.Semaphore) context.Context {
+	return context.WithValue(ctx, ctxBackgroundFlushKey, budget)
+}
+
+// isBackgroundFlush returns whether `ctx` belongs to a background
+// journal flush.
+func isBackgroundFlush(ctx context.Context) bool {
+	_, ok := ctx.Value(ctxBackgroundFlushKey).(*kbfssync.Semaphore)
+	return ok
+}
+
+// acquireBackgroundFlushBudget waits for a unit of the background
+// flush budget, if `ctx` belongs to a background flush that has one.
+// The returned function gives it back.
+func acquireBackgroundFlushBudget(ctx context.Context) (func(), error) {
+	budget, _ := ctx.Value(ctxBackgroundFlushKey).(*kbfssync.Semaphore)
+	if budget == nil {
+		return func() {}, nil
+	}
+	_, err := budget.Acquire(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+	return func() { budget.Release(1) }, nil
+}
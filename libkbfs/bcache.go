@@ -31,6 +31,11 @@ type idCacheKey struct {
 // internally by just their block ID (since blocks are immutable and
 // content-addressable).
 type BlockCacheStandard struct {
+	// hits and misses count lookups; they're first so they're
+	// 64-bit aligned for atomic access.
+	hits   uint64
+	misses uint64
+
 	cleanBytesCapacity uint64
 
 	ids *lru.Cache
@@ -82,6 +87,7 @@ func (b *BlockCacheStandard) GetWithPrefetch(ptr BlockPointer) (
 			if !ok {
 				return nil, NoPrefetch, NoCacheEntry, BadDataError{ptr.ID}
 			}
+			atomic.AddUint64(&b.hits, 1)
 			return bc.block, bc.prefetchStatus, TransientEntry, nil
 		}
 	}
@@ -96,12 +102,20 @@ func (b *BlockCacheStandard) GetWithPrefetch(ptr BlockPointer) (
 		// write. Since the client is writing, it knows what goes into it,
 		// including any potential directory entries or indirect blocks.
 		// Thus, it is treated as having triggered a prefetch.
+		atomic.AddUint64(&b.hits, 1)
 		return block, TriggeredPrefetch, PermanentEntry, nil
 	}
 
+	atomic.AddUint64(&b.misses, 1)
 	return nil, NoPrefetch, NoCacheEntry, NoSuchBlockError{ptr.ID}
 }
 
+// lookupCounts returns how many blocks were found in the cache, and
+// how many weren't.
+func (b *BlockCacheStandard) lookupCounts() (hits, misses uint64) {
+	return atomic.LoadUint64(&b.hits), atomic.LoadUint64(&b.misses)
+}
+
 // Get implements the BlockCache interface for BlockCacheStandard.
 func (b *BlockCacheStandard) Get(ptr BlockPointer) (Block, error) {
 	block, _, _, err := b.GetWithPrefetch(ptr)
@@ -168,6 +182,18 @@ func (b *BlockCacheStandard) CheckForKnownPtr(tlf tlf.ID, block *FileBlock) (
 	return ptr, nil
 }
 
+// purgeTransient drops all the transient clean blocks and known
+// pointers from the cache, releasing their memory.  Permanent blocks
+// are kept, since they can't be re-fetched from the server.
+func (b *BlockCacheStandard) purgeTransient() {
+	if b.cleanTransient != nil {
+		b.cleanTransient.Purge()
+	}
+	if b.ids != nil {
+		b.ids.Purge()
+	}
+}
+
 // SetCleanBytesCapacity implements the BlockCache interface for
 // BlockCacheStandard.
 func (b *BlockCacheStandard) SetCleanBytesCapacity(capacity uint64) {
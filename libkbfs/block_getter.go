@@ -42,12 +42,13 @@ func (bg *realBlockGetter) getBlock(ctx context.Context, kmd KeyMetadata, blockP
 
 	return assembleBlock(
 		ctx, bg.config.keyGetter(), bg.config.Codec(), bg.config.cryptoPure(),
-		kmd, blockPtr, block, buf, blockServerHalf)
+		bg.config.BlockTransform(), kmd, blockPtr, block, buf, blockServerHalf)
 }
 
 func (bg *realBlockGetter) assembleBlock(ctx context.Context,
 	kmd KeyMetadata, ptr BlockPointer, block Block, buf []byte,
 	serverHalf kbfscrypto.BlockCryptKeyServerHalf) error {
 	return assembleBlock(ctx, bg.config.keyGetter(), bg.config.Codec(),
-		bg.config.cryptoPure(), kmd, ptr, block, buf, serverHalf)
+		bg.config.cryptoPure(), bg.config.BlockTransform(), kmd, ptr, block,
+		buf, serverHalf)
 }
@@ -621,13 +621,16 @@ func (be blockEntriesToFlush) clearFlushingBlockIDs(ids map[kbfsblock.ID]bool) {
 }
 
 // Only entries with ordinals less than the given ordinal (assumed to
-// be <= latest ordinal + 1) are returned.  Also returns the maximum
+// be <= latest ordinal + 1) are returned, and no more than
+// `maxToFlush` of them; the batch also ends after the first put that
+// brings the total data to at least `maxBytesToFlush`.  Also returns the maximum
 // MD revision that can be merged after the returned entries are
 // successfully flushed; if no entries are returned (i.e., the block
 // journal is empty) then any MD revision may be flushed even when
 // kbfsmd.RevisionUninitialized is returned.
 func (j *blockJournal) getNextEntriesToFlush(
-	ctx context.Context, end journalOrdinal, maxToFlush int) (
+	ctx context.Context, end journalOrdinal, maxToFlush int,
+	maxBytesToFlush int64) (
 	entries blockEntriesToFlush, bytesToFlush int64,
 	maxMDRevToFlush kbfsmd.Revision, err error) {
 	first, err := j.j.readEarliestOrdinal()
@@ -705,6 +708,12 @@ func (j *blockJournal) getNextEntriesToFlush(
 				nil, /* only used by folderBranchOps */
 				ReadyBlockData{data, serverHalf}, nil)
 
+			// Always flush at least one put, even if it's bigger
+			// than the byte limit.
+			if bytesToFlush >= maxBytesToFlush {
+				loopEnd = ordinal + 1
+			}
+
 		case addRefOp:
 			id, bctx, err := entry.getSingleContext()
 			if err != nil {
@@ -454,13 +454,13 @@ func TestBlockJournalFlush(t *testing.T) {
 		var rev kbfsmd.Revision
 		if end > firstValidJournalOrdinal+1 {
 			partialEntries, _, rev, err = j.getNextEntriesToFlush(
-				ctx, end-1, maxJournalBlockFlushBatchSize)
+				ctx, end-1, maxJournalBlockFlushBatchSize, maxJournalBlockFlushBatchBytes)
 			require.NoError(t, err)
 			require.Equal(t, rev, kbfsmd.RevisionUninitialized)
 		}
 
 		entries, b, rev, err := j.getNextEntriesToFlush(ctx, end,
-			maxJournalBlockFlushBatchSize)
+			maxJournalBlockFlushBatchSize, maxJournalBlockFlushBatchBytes)
 		require.NoError(t, err)
 		require.Equal(t, partialEntries.length()+1, entries.length())
 		require.Equal(t, rev, kbfsmd.RevisionUninitialized)
@@ -539,7 +539,7 @@ func flushBlockJournalOne(ctx context.Context, t *testing.T,
 	first, err := j.j.readEarliestOrdinal()
 	require.NoError(t, err)
 	entries, b, _, err := j.getNextEntriesToFlush(ctx, first+1,
-		maxJournalBlockFlushBatchSize)
+		maxJournalBlockFlushBatchSize, maxJournalBlockFlushBatchBytes)
 	require.NoError(t, err)
 	require.Equal(t, 1, entries.length())
 	err = flushBlockEntries(ctx, j.log, j.deferLog, blockServer,
@@ -686,7 +686,7 @@ func TestBlockJournalFlushInterleaved(t *testing.T) {
 	end, err := j.end()
 	require.NoError(t, err)
 	entries, b, _, err := j.getNextEntriesToFlush(ctx, end,
-		maxJournalBlockFlushBatchSize)
+		maxJournalBlockFlushBatchSize, maxJournalBlockFlushBatchBytes)
 	require.NoError(t, err)
 	require.Equal(t, 0, entries.length())
 	require.Equal(t, int64(0), b)
@@ -719,7 +719,7 @@ func TestBlockJournalFlushMDRevMarker(t *testing.T) {
 	last, err := j.j.readLatestOrdinal()
 	require.NoError(t, err)
 	entries, b, gotRev, err := j.getNextEntriesToFlush(ctx, last+1,
-		maxJournalBlockFlushBatchSize)
+		maxJournalBlockFlushBatchSize, maxJournalBlockFlushBatchBytes)
 	require.NoError(t, err)
 	require.Equal(t, rev, gotRev)
 	require.Equal(t, 2, entries.length())
@@ -740,6 +740,46 @@ func TestBlockJournalFlushMDRevMarker(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestBlockJournalFlushByteLimit(t *testing.T) {
+	ctx, cancel, tempdir, log, j := setupBlockJournalTest(t)
+	defer teardownBlockJournalTest(t, ctx, cancel, tempdir, j)
+
+	// Put three blocks.
+	for i := byte(0); i < 3; i++ {
+		putBlockData(ctx, t, j, []byte{i, 2, 3, 4})
+	}
+
+	blockServer := NewBlockServerMemory(log)
+	tlfID := tlf.FakeID(1, tlf.Private)
+	bcache := NewBlockCacheStandard(0, 0)
+	reporter := NewReporterSimple(nil, 0)
+
+	// A batch ends with the put that reaches the byte limit, so the
+	// first one only holds two blocks.
+	last, err := j.j.readLatestOrdinal()
+	require.NoError(t, err)
+	entries, b, _, err := j.getNextEntriesToFlush(ctx, last+1,
+		maxJournalBlockFlushBatchSize, 6)
+	require.NoError(t, err)
+	require.Equal(t, 2, entries.length())
+	require.Equal(t, int64(8), b)
+	err = flushBlockEntries(ctx, j.log, j.deferLog, blockServer,
+		bcache, reporter, tlfID, tlf.CanonicalName("fake TLF"),
+		entries)
+	require.NoError(t, err)
+	_, err = j.removeFlushedEntries(ctx, entries, tlfID, reporter)
+	require.NoError(t, err)
+
+	// The next batch picks up where the first one left off, and
+	// always includes at least one put.
+	entries, b, _, err = j.getNextEntriesToFlush(ctx, last+1,
+		maxJournalBlockFlushBatchSize, 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, entries.length())
+	require.Equal(t, int64(4), b)
+	require.Equal(t, last, entries.first)
+}
+
 func TestBlockJournalFlushMDRevMarkerForPendingLocalSquash(t *testing.T) {
 	ctx, cancel, tempdir, log, j := setupBlockJournalTest(t)
 	defer teardownBlockJournalTest(t, ctx, cancel, tempdir, j)
@@ -782,7 +822,7 @@ func TestBlockJournalFlushMDRevMarkerForPendingLocalSquash(t *testing.T) {
 	last, err := j.j.readLatestOrdinal()
 	require.NoError(t, err)
 	entries, b, gotRev, err := j.getNextEntriesToFlush(ctx, last+1,
-		maxJournalBlockFlushBatchSize)
+		maxJournalBlockFlushBatchSize, maxJournalBlockFlushBatchBytes)
 	require.NoError(t, err)
 	require.Equal(t, rev-1, gotRev)
 	require.Equal(t, 6, entries.length())
@@ -856,7 +896,7 @@ func TestBlockJournalIgnoreBlocks(t *testing.T) {
 	last, err := j.j.readLatestOrdinal()
 	require.NoError(t, err)
 	entries, b, gotRev, err := j.getNextEntriesToFlush(ctx, last+1,
-		maxJournalBlockFlushBatchSize)
+		maxJournalBlockFlushBatchSize, maxJournalBlockFlushBatchBytes)
 	require.NoError(t, err)
 	require.Equal(t, kbfsmd.RevisionUninitialized, gotRev)
 	require.Equal(t, 7, entries.length())
@@ -923,7 +963,7 @@ func TestBlockJournalSaveUntilMDFlush(t *testing.T) {
 		last, err := j.j.readLatestOrdinal()
 		require.NoError(t, err)
 		entries, b, _, err := j.getNextEntriesToFlush(ctx, last+1,
-			maxJournalBlockFlushBatchSize)
+			maxJournalBlockFlushBatchSize, maxJournalBlockFlushBatchBytes)
 		require.NoError(t, err)
 		err = flushBlockEntries(ctx, j.log, j.deferLog, blockServer,
 			bcache, reporter, tlfID, tlf.CanonicalName("fake TLF"),
@@ -950,7 +990,7 @@ func TestBlockJournalSaveUntilMDFlush(t *testing.T) {
 	end, err := j.end()
 	require.NoError(t, err)
 	entries, b, gotRev, err := j.getNextEntriesToFlush(ctx, end,
-		maxJournalBlockFlushBatchSize)
+		maxJournalBlockFlushBatchSize, maxJournalBlockFlushBatchBytes)
 	require.NoError(t, err)
 	require.Equal(t, 0, entries.length())
 	require.Equal(t, kbfsmd.RevisionUninitialized, gotRev)
@@ -23,6 +23,8 @@ type blockOpsConfig interface {
 	syncedTlfGetterSetter
 	initModeGetter
 	blockCryptVersioner
+	blockTransformGetter
+	clockGetter
 }
 
 // BlockOpsStandard implements the BlockOps interface by relaying
@@ -66,7 +68,8 @@ func (b *BlockOpsStandard) Get(ctx context.Context, kmd KeyMetadata,
 		if found {
 			return assembleBlock(
 				ctx, b.config.keyGetter(), b.config.Codec(),
-				b.config.cryptoPure(), kmd, blockPtr, block, data, serverHalf)
+				b.config.cryptoPure(), b.config.BlockTransform(), kmd,
+				blockPtr, block, data, serverHalf)
 		}
 	}
 
@@ -74,7 +77,7 @@ func (b *BlockOpsStandard) Get(ctx context.Context, kmd KeyMetadata,
 
 	errCh := b.queue.Request(ctx, defaultOnDemandRequestPriority, kmd,
 		blockPtr, block, lifetime)
-	err := <-errCh
+	err := b.queue.waitForRequest(ctx, errCh)
 
 	b.log.LazyTrace(ctx, "BOps: Request fulfilled for %s (err=%v)", blockPtr.ID, err)
 
@@ -138,6 +141,11 @@ func (b *BlockOpsStandard) Ready(ctx context.Context, kmd KeyMetadata,
 	if err != nil {
 		return
 	}
+	buf, err = wrapBlockPayload(
+		ctx, b.config.BlockTransform(), kmd.TlfID(), buf)
+	if err != nil {
+		return
+	}
 
 	readyBlockData = ReadyBlockData{
 		buf:        buf,
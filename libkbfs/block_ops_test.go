@@ -87,6 +87,7 @@ type testBlockOpsConfig struct {
 	diskBlockCacheGetter
 	*testSyncedTlfGetterSetter
 	initModeGetter
+	transform BlockTransform
 }
 
 var _ blockOpsConfig = (*testBlockOpsConfig)(nil)
@@ -95,6 +96,10 @@ func (config testBlockOpsConfig) BlockServer() BlockServer {
 	return config.bserver
 }
 
+func (config testBlockOpsConfig) Clock() Clock {
+	return wallClock{}
+}
+
 func (config testBlockOpsConfig) cryptoPure() cryptoPure {
 	return config.cp
 }
@@ -115,6 +120,10 @@ func (config testBlockOpsConfig) BlockCryptVersion() kbfscrypto.EncryptionVer {
 	return kbfscrypto.EncryptionSecretbox
 }
 
+func (config testBlockOpsConfig) BlockTransform() BlockTransform {
+	return config.transform
+}
+
 func makeTestBlockOpsConfig(t *testing.T) testBlockOpsConfig {
 	lm := newTestLogMaker(t)
 	codecGetter := newTestCodecGetter()
@@ -124,7 +133,7 @@ func makeTestBlockOpsConfig(t *testing.T) testBlockOpsConfig {
 	dbcg := newTestDiskBlockCacheGetter(t, nil)
 	stgs := newTestSyncedTlfGetterSetter()
 	return testBlockOpsConfig{codecGetter, lm, bserver, crypto, cache, dbcg,
-		stgs, testInitModeGetter{InitDefault}}
+		stgs, testInitModeGetter{InitDefault}, nil}
 }
 
 // TestBlockOpsReadySuccess checks that BlockOpsStandard.Ready()
@@ -344,6 +353,85 @@ func TestBlockOpsGetSuccess(t *testing.T) {
 	require.Equal(t, block, decryptedBlock)
 }
 
+// xorBlockTransform is a BlockTransform that XORs payloads with the
+// byte its key ID names.
+type xorBlockTransform struct {
+	keyID string
+}
+
+func (t xorBlockTransform) xor(keyID string, buf []byte) []byte {
+	res := make([]byte, len(buf))
+	for i, b := range buf {
+		res[i] = b ^ keyID[0]
+	}
+	return res
+}
+
+func (t xorBlockTransform) WrapBlock(
+	_ context.Context, _ tlf.ID, payload []byte) ([]byte, string, error) {
+	return t.xor(t.keyID, payload), t.keyID, nil
+}
+
+func (t xorBlockTransform) UnwrapBlock(
+	_ context.Context, _ tlf.ID, keyID string, wrapped []byte) (
+	[]byte, error) {
+	return t.xor(keyID, wrapped), nil
+}
+
+// TestBlockOpsTransform checks that blocks put by BlockOpsStandard
+// with a block transform are wrapped, and can be read back even
+// after the transform's key changes.
+func TestBlockOpsTransform(t *testing.T) {
+	config := makeTestBlockOpsConfig(t)
+	config.transform = xorBlockTransform{"a"}
+	bops := NewBlockOpsStandard(config, testBlockRetrievalWorkerQueueSize,
+		testPrefetchWorkerQueueSize)
+	defer bops.Shutdown()
+
+	tlfID := tlf.FakeID(0, tlf.Private)
+	var keyGen kbfsmd.KeyGen = 3
+	kmd := makeFakeKeyMetadata(tlfID, keyGen)
+
+	block := &FileBlock{
+		Contents: []byte{1, 2, 3, 4, 5},
+	}
+
+	ctx := context.Background()
+	id, _, readyBlockData, err := bops.Ready(ctx, kmd, block)
+	require.NoError(t, err)
+	err = kbfsblock.VerifyID(readyBlockData.buf, id)
+	require.NoError(t, err)
+	var encryptedBlock kbfscrypto.EncryptedBlock
+	err = config.Codec().Decode(readyBlockData.buf, &encryptedBlock)
+	require.Error(t, err, "The stored block isn't a plain encrypted block")
+
+	bCtx := kbfsblock.MakeFirstContext(
+		keybase1.MakeTestUID(1).AsUserOrTeam(), keybase1.BlockType_DATA)
+	err = config.bserver.Put(ctx, tlfID, id, bCtx,
+		readyBlockData.buf, readyBlockData.serverHalf)
+	require.NoError(t, err)
+	ptr := BlockPointer{ID: id, DataVer: FirstValidDataVer,
+		KeyGen: keyGen, Context: bCtx}
+
+	t.Log("The key ID recorded with the block is used to unwrap it.")
+	config.transform = xorBlockTransform{"b"}
+	bops2 := NewBlockOpsStandard(config, testBlockRetrievalWorkerQueueSize,
+		testPrefetchWorkerQueueSize)
+	defer bops2.Shutdown()
+	decryptedBlock := &FileBlock{}
+	err = bops2.Get(ctx, kmd, ptr, decryptedBlock, NoCacheEntry)
+	require.NoError(t, err)
+	require.Equal(t, block, decryptedBlock)
+
+	t.Log("Without a transform, the block can't be read.")
+	config.transform = nil
+	bops3 := NewBlockOpsStandard(config, testBlockRetrievalWorkerQueueSize,
+		testPrefetchWorkerQueueSize)
+	defer bops3.Shutdown()
+	err = bops3.Get(ctx, kmd, ptr, &FileBlock{}, NoCacheEntry)
+	require.Error(t, err)
+}
+
 // TestBlockOpsReadySuccess checks that BlockOpsStandard.Get() fails
 // if it can't retrieve the block from the server.
 func TestBlockOpsGetFailServerGet(t *testing.T) {
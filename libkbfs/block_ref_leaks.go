@@ -0,0 +1,274 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// BlockRefLeakReport summarizes how the block references of a TLF
+// compare with the data reachable from its latest revision.
+type BlockRefLeakReport struct {
+	Revision kbfsmd.Revision
+	// Reachable is the number of distinct block references
+	// reachable from the root of Revision.
+	Reachable int
+	// Leaked lists references that the MD history says are still
+	// live, but that aren't reachable from Revision.  Since no
+	// revision will ever unreference them, quota reclamation never
+	// releases them either.
+	Leaked []BlockPointer `json:",omitempty"`
+	// Unrecorded lists live references on the block server that no
+	// revision mentions at all, e.g. because the client crashed
+	// between putting the blocks of a sync and putting its MD.  It
+	// is only filled in for block servers that can list their
+	// references.
+	Unrecorded []BlockPointer `json:",omitempty"`
+	// Unlisted is true if the block server can't list its
+	// references, so Unrecorded couldn't be computed.
+	Unlisted bool
+	// Released is true if the references above have been removed
+	// from the block server.
+	Released bool
+}
+
+// blockRefLeakFinder replays the MD history of a TLF to figure out
+// which block references it should have.
+type blockRefLeakFinder struct {
+	fbo *folderBranchOps
+	// mentioned holds every reference any revision refs or unrefs.
+	mentioned map[BlockRef]bool
+	// live holds the references that have been refed, but not
+	// unrefed yet.
+	live map[BlockRef]BlockPointer
+	// reachable holds the references reachable from the head.
+	reachable map[BlockRef]bool
+}
+
+func (f *blockRefLeakFinder) ref(ptr BlockPointer) {
+	if ptr == zeroPtr {
+		return
+	}
+	f.mentioned[ptr.Ref()] = true
+	f.live[ptr.Ref()] = ptr
+}
+
+func (f *blockRefLeakFinder) unref(ptr BlockPointer) {
+	if ptr == zeroPtr {
+		return
+	}
+	f.mentioned[ptr.Ref()] = true
+	delete(f.live, ptr.Ref())
+}
+
+// replay records the references made and removed by `rmds`, which
+// must be the complete merged history of the TLF, in order.
+func (f *blockRefLeakFinder) replay(rmds []ImmutableRootMetadata) {
+	for _, rmd := range rmds {
+		// Copies repeat the ops of the revision they copy.
+		if rmd.IsWriterMetadataCopiedSet() {
+			continue
+		}
+		if info := rmd.data.cachedChanges.Info; info.BlockPointer != zeroPtr {
+			f.mentioned[info.BlockPointer.Ref()] = true
+		}
+		for _, op := range rmd.data.Changes.Ops {
+			for _, ptr := range op.Refs() {
+				f.ref(ptr)
+			}
+			// The unrefs of a GC op were already unrefed by the
+			// revisions it collected.
+			if _, isGCOp := op.(*GCOp); !isGCOp {
+				for _, ptr := range op.Unrefs() {
+					f.unref(ptr)
+				}
+			}
+			for _, update := range op.allUpdates() {
+				if update.Ref == update.Unref {
+					continue
+				}
+				f.unref(update.Unref)
+				f.ref(update.Ref)
+			}
+		}
+	}
+}
+
+// walk records every reference reachable from `rootPtr`, which
+// points to a directory.
+func (f *blockRefLeakFinder) walk(ctx context.Context, kmd KeyMetadata,
+	rootPtr BlockPointer) error {
+	type pending struct {
+		ptr   BlockPointer
+		isDir bool
+	}
+	stack := []pending{{rootPtr, true}}
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if f.reachable[p.ptr.Ref()] {
+			continue
+		}
+		f.reachable[p.ptr.Ref()] = true
+
+		var block BlockWithPtrs
+		if p.isDir {
+			block = NewDirBlockWithPtrs(false)
+		} else {
+			block = NewFileBlockWithPtrs(false)
+		}
+		err := f.fbo.config.BlockOps().Get(
+			ctx, kmd, p.ptr, block, TransientEntry)
+		if err != nil {
+			return err
+		}
+
+		if block.IsIndirect() {
+			for i := 0; i < block.NumIndirectPtrs(); i++ {
+				info, _ := block.IndirectPtr(i)
+				stack = append(stack, pending{info.BlockPointer, p.isDir})
+			}
+			continue
+		}
+		if db, ok := block.(*DirBlock); ok {
+			for _, de := range db.Children {
+				if de.Type == Sym {
+					continue
+				}
+				stack = append(stack, pending{de.BlockPointer, de.Type == Dir})
+			}
+		}
+	}
+	return nil
+}
+
+// listServerRefs returns the live references the block server has
+// for this TLF, or false if it can't list them.  Only block servers
+// that store their data locally can; the remote protocol has no way
+// to do it.
+func (f *blockRefLeakFinder) listServerRefs(ctx context.Context) (
+	[]BlockPointer, bool, error) {
+	bserver := f.fbo.config.BlockServer()
+	if jbs, ok := bserver.(journalBlockServer); ok {
+		bserver = jbs.BlockServer
+	}
+	local, ok := bserver.(blockServerLocal)
+	if !ok {
+		return nil, false, nil
+	}
+	refs, err := local.getAllRefsForTest(ctx, f.fbo.id())
+	if err != nil {
+		return nil, false, err
+	}
+	var ptrs []BlockPointer
+	for id, refMap := range refs {
+		for _, entry := range refMap {
+			if entry.Status != liveBlockRef {
+				continue
+			}
+			ptrs = append(ptrs, BlockPointer{ID: id, Context: entry.Context})
+		}
+	}
+	return ptrs, true, nil
+}
+
+// FindLeakedBlockRefs cross-checks the block references recorded in
+// the MD history of this TLF against the data reachable from its
+// latest merged revision.  If `release` is true, the leaked
+// references are removed from the block server to reclaim their
+// quota.  Since the blocks of a write are put before its MD, this
+// must only be done while no other device is writing to the TLF.
+func (fbo *folderBranchOps) FindLeakedBlockRefs(
+	ctx context.Context, folderBranch FolderBranch, release bool) (
+	report BlockRefLeakReport, err error) {
+	fbo.log.CDebugf(ctx, "FindLeakedBlockRefs release=%t", release)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "FindLeakedBlockRefs done: %+v", err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return BlockRefLeakReport{}, WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+
+	// Make sure all our own writes, and the deletions of the blocks
+	// of any failed ones, have made it to the server.
+	err = fbo.SyncFromServer(ctx, folderBranch, nil)
+	if err != nil {
+		return BlockRefLeakReport{}, err
+	}
+	err = fbo.fbm.waitForDeletingBlocks(ctx)
+	if err != nil {
+		return BlockRefLeakReport{}, err
+	}
+	lState := makeFBOLockState()
+	if fbo.isUnmerged(lState) {
+		return BlockRefLeakReport{}, errors.New(
+			"Can't check block references while unmerged")
+	}
+	head := fbo.getTrustedHead(lState)
+	if head == (ImmutableRootMetadata{}) {
+		return BlockRefLeakReport{}, errors.New("No head to check against")
+	}
+
+	rmds, err := getMergedMDUpdatesWithEnd(ctx, fbo.config, fbo.id(),
+		kbfsmd.RevisionInitial, head.Revision(), nil)
+	if err != nil {
+		return BlockRefLeakReport{}, err
+	}
+
+	f := &blockRefLeakFinder{
+		fbo:       fbo,
+		mentioned: make(map[BlockRef]bool),
+		live:      make(map[BlockRef]BlockPointer),
+		reachable: make(map[BlockRef]bool),
+	}
+	f.replay(rmds)
+	err = f.walk(ctx, head, head.data.Dir.BlockPointer)
+	if err != nil {
+		return BlockRefLeakReport{}, err
+	}
+	report.Revision = head.Revision()
+	report.Reachable = len(f.reachable)
+
+	for ref, ptr := range f.live {
+		// Unembedded block changes are MD blocks, which are never
+		// reachable from the root, and quota reclamation takes
+		// care of them separately.
+		if f.reachable[ref] ||
+			ptr.GetBlockType() == keybase1.BlockType_MD {
+			continue
+		}
+		report.Leaked = append(report.Leaked, ptr)
+	}
+
+	serverPtrs, listed, err := f.listServerRefs(ctx)
+	if err != nil {
+		return BlockRefLeakReport{}, err
+	}
+	report.Unlisted = !listed
+	for _, ptr := range serverPtrs {
+		if !f.mentioned[ptr.Ref()] && !f.reachable[ptr.Ref()] {
+			report.Unrecorded = append(report.Unrecorded, ptr)
+		}
+	}
+
+	fbo.log.CDebugf(ctx, "Block refs for revision %d: %d reachable, "+
+		"%d leaked, %d unrecorded", report.Revision, report.Reachable,
+		len(report.Leaked), len(report.Unrecorded))
+
+	toRelease := append(append([]BlockPointer(nil),
+		report.Leaked...), report.Unrecorded...)
+	if release && len(toRelease) > 0 {
+		_, err := fbo.config.BlockOps().Delete(ctx, fbo.id(), toRelease)
+		if err != nil {
+			return BlockRefLeakReport{}, err
+		}
+	}
+	report.Released = release
+	return report, nil
+}
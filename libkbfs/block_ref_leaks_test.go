@@ -0,0 +1,73 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindLeakedBlockRefs(t *testing.T) {
+	config, uid, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps()
+	dir, _, err := kbfsOps.CreateDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	file, _, err := kbfsOps.CreateFile(ctx, dir, "b", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, file, []byte("hello"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	t.Log("A clean folder has no leaks.")
+	report, err := kbfsOps.FindLeakedBlockRefs(ctx, fb, false)
+	require.NoError(t, err)
+	require.Equal(t, 3, report.Reachable)
+	require.Len(t, report.Leaked, 0)
+	require.Len(t, report.Unrecorded, 0)
+	require.False(t, report.Unlisted)
+
+	t.Log("Put a block that no MD refers to, as if the client had " +
+		"crashed before putting the MD of a sync.")
+	data := []byte{1, 2, 3}
+	id, err := kbfsblock.MakePermanentID(data, kbfscrypto.EncryptionSecretbox)
+	require.NoError(t, err)
+	bCtx := kbfsblock.MakeFirstContext(
+		uid.AsUserOrTeam(), keybase1.BlockType_DATA)
+	serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+	require.NoError(t, err)
+	err = config.BlockServer().Put(
+		ctx, fb.Tlf, id, bCtx, data, serverHalf)
+	require.NoError(t, err)
+
+	report, err = kbfsOps.FindLeakedBlockRefs(ctx, fb, false)
+	require.NoError(t, err)
+	require.Len(t, report.Unrecorded, 1)
+	require.Equal(t, id, report.Unrecorded[0].ID)
+	require.False(t, report.Released)
+
+	t.Log("Releasing the leak removes it from the server.")
+	report, err = kbfsOps.FindLeakedBlockRefs(ctx, fb, true)
+	require.NoError(t, err)
+	require.Len(t, report.Unrecorded, 1)
+	require.True(t, report.Released)
+	refs, err := config.BlockServer().(*BlockServerMemory).getAllRefsForTest(
+		ctx, fb.Tlf)
+	require.NoError(t, err)
+	require.False(t, refs[id].hasNonArchivedRef())
+
+	report, err = kbfsOps.FindLeakedBlockRefs(ctx, fb, false)
+	require.NoError(t, err)
+	require.Len(t, report.Unrecorded, 0)
+}
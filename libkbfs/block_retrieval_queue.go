@@ -13,6 +13,7 @@ import (
 	"github.com/keybase/client/go/logger"
 	"github.com/keybase/kbfs/tlf"
 	"github.com/pkg/errors"
+	metrics "github.com/rcrowley/go-metrics"
 	"golang.org/x/net/context"
 )
 
@@ -36,6 +37,7 @@ type blockRetrievalPartialConfig interface {
 	diskBlockCacheGetter
 	syncedTlfGetterSetter
 	initModeGetter
+	clockGetter
 }
 
 type blockRetrievalConfig interface {
@@ -52,6 +54,12 @@ func (c *realBlockRetrievalConfig) blockGetter() blockGetter {
 	return c.bg
 }
 
+// metricsRegistryGetter is implemented by block retrieval configs that
+// can record metrics.
+type metricsRegistryGetter interface {
+	MetricsRegistry() metrics.Registry
+}
+
 // blockRetrievalRequest represents one consumer's request for a block.
 type blockRetrievalRequest struct {
 	block  Block
@@ -106,7 +114,7 @@ type blockRetrievalQueue struct {
 	config blockRetrievalConfig
 	log    logger.Logger
 	// protects ptrs, insertionCount, and the heap
-	mtx sync.RWMutex
+	mtx orderCheckedRWMutex
 	// queued or in progress retrievals
 	ptrs map[blockPtrLookup]*blockRetrieval
 	// global counter of insertions to queue
@@ -128,6 +136,20 @@ type blockRetrievalQueue struct {
 	prefetchMtx sync.RWMutex
 	// prefetcher for handling prefetching scenarios
 	prefetcher Prefetcher
+
+	// orphanedRequests counts requests whose requester gave up (e.g.,
+	// because its context was canceled) before the block arrived.
+	orphanedRequests metrics.Counter
+	// orphanedRetrievals counts retrievals that ended after all of
+	// their requesters had given up.
+	orphanedRetrievals metrics.Counter
+	// inFlightRetrievals is the number of retrievals currently being
+	// fetched by a worker.
+	inFlightRetrievals metrics.Counter
+	// dedupedRequests counts requests that joined a retrieval of the
+	// same block that was already queued or in flight, rather than
+	// fetching it again.
+	dedupedRequests metrics.Counter
 }
 
 var _ BlockRetriever = (*blockRetrievalQueue)(nil)
@@ -140,6 +162,7 @@ func newBlockRetrievalQueue(numWorkers int, numPrefetchWorkers int,
 	workerCh := make(chan struct{}, workerQueueSize)
 	prefetchWorkerCh := make(chan struct{}, workerQueueSize)
 	q := &blockRetrievalQueue{
+		mtx:              orderCheckedRWMutex{name: "blockRetrievalQueue.mtx"},
 		config:           config,
 		log:              config.MakeLogger(""),
 		ptrs:             make(map[blockPtrLookup]*blockRetrieval),
@@ -150,6 +173,25 @@ func newBlockRetrievalQueue(numWorkers int, numPrefetchWorkers int,
 		workers: make([]*blockRetrievalWorker, 0,
 			numWorkers+numPrefetchWorkers),
 	}
+	var registry metrics.Registry
+	if mrg, ok := config.(metricsRegistryGetter); ok {
+		registry = mrg.MetricsRegistry()
+	}
+	if registry != nil {
+		q.orphanedRequests = metrics.GetOrRegisterCounter(
+			"BlockRetrieval.OrphanedRequests", registry)
+		q.orphanedRetrievals = metrics.GetOrRegisterCounter(
+			"BlockRetrieval.OrphanedRetrievals", registry)
+		q.inFlightRetrievals = metrics.GetOrRegisterCounter(
+			"BlockRetrieval.InFlight", registry)
+		q.dedupedRequests = metrics.GetOrRegisterCounter(
+			"BlockRetrieval.DedupedRequests", registry)
+	} else {
+		q.orphanedRequests = metrics.NewCounter()
+		q.orphanedRetrievals = metrics.NewCounter()
+		q.inFlightRetrievals = metrics.NewCounter()
+		q.dedupedRequests = metrics.NewCounter()
+	}
 	q.prefetcher = newBlockPrefetcher(q, config, nil)
 	for i := 0; i < numWorkers; i++ {
 		q.workers = append(q.workers, newBlockRetrievalWorker(
@@ -359,6 +401,7 @@ func (brq *blockRetrievalQueue) request(ctx context.Context,
 				delete(brq.ptrs, bpLookup)
 				continue
 			}
+			brq.dedupedRequests.Inc(1)
 		}
 		break
 	}
@@ -408,6 +451,22 @@ func (brq *blockRetrievalQueue) RequestNoPrefetch(ctx context.Context,
 	return brq.request(ctx, priority, kmd, ptr, block, lifetime, false)
 }
 
+// waitForRequest waits for the result of a request made with
+// `Request` or `RequestNoPrefetch`, or until `ctx` is done.  A
+// requester that gives up doesn't stop the retrieval by itself; the
+// retrieval's context is only canceled once every requester of the
+// block has given up.
+func (brq *blockRetrievalQueue) waitForRequest(
+	ctx context.Context, ch <-chan error) error {
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		brq.orphanedRequests.Inc(1)
+		return errors.WithStack(ctx.Err())
+	}
+}
+
 // FinalizeRequest is the last step of a retrieval request once a block has
 // been obtained. It removes the request from the blockRetrievalQueue,
 // preventing more requests from mutating the retrieval, then notifies all
@@ -420,6 +479,9 @@ func (brq *blockRetrievalQueue) FinalizeRequest(
 	bpLookup := blockPtrLookup{retrieval.blockPtr, reflect.TypeOf(block)}
 	delete(brq.ptrs, bpLookup)
 	brq.mtx.Unlock()
+	// If every requester has given up, there's nobody left to trigger
+	// prefetches on behalf of.
+	orphaned := retrieval.ctx.Err() != nil
 	defer retrieval.cancelFunc()
 
 	// This is a lock that exists for the race detector, since there
@@ -434,8 +496,23 @@ func (brq *blockRetrievalQueue) FinalizeRequest(
 	retrieval.reqMtx.RLock()
 	defer retrieval.reqMtx.RUnlock()
 
+	if orphaned {
+		brq.orphanedRetrievals.Inc(1)
+	}
+
 	// Cache the block and trigger prefetches if there is no error.
-	if err == nil {
+	switch {
+	case err == nil && orphaned:
+		brq.log.CDebugf(retrieval.ctx, "Block %s retrieved after all its "+
+			"requests were canceled; not prefetching", retrieval.blockPtr.ID)
+		brq.Prefetcher().CancelPrefetch(retrieval.blockPtr.ID)
+		cacheErr := brq.PutInCaches(context.Background(), retrieval.blockPtr,
+			retrieval.kmd.TlfID(), block, retrieval.cacheLifetime, NoPrefetch)
+		if cacheErr != nil {
+			brq.log.CDebugf(retrieval.ctx, "Couldn't cache orphaned block "+
+				"%s: %+v", retrieval.blockPtr.ID, cacheErr)
+		}
+	case err == nil:
 		// We treat this request as not having been prefetched, because the
 		// only way to get here is if the request wasn't already cached.
 		// Need to call with context.Background() because the retrieval's
@@ -443,7 +520,7 @@ func (brq *blockRetrievalQueue) FinalizeRequest(
 		brq.Prefetcher().ProcessBlockForPrefetch(context.Background(),
 			retrieval.blockPtr, block, retrieval.kmd, retrieval.priority,
 			retrieval.cacheLifetime, NoPrefetch)
-	} else {
+	default:
 		brq.Prefetcher().CancelPrefetch(retrieval.blockPtr.ID)
 	}
 
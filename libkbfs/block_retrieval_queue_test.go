@@ -42,6 +42,10 @@ func (c *testBlockRetrievalConfig) BlockCache() BlockCache {
 	return c.testCache
 }
 
+func (c testBlockRetrievalConfig) Clock() Clock {
+	return wallClock{}
+}
+
 func (c testBlockRetrievalConfig) DataVersion() DataVer {
 	return ChildHolesDataVer
 }
@@ -203,6 +207,7 @@ func TestBlockRetrievalQueueMultipleRequestsSameBlock(t *testing.T) {
 	require.Len(t, *q.heap, 0)
 	require.Equal(t, block, br.requests[0].block)
 	require.Equal(t, block, br.requests[1].block)
+	require.Equal(t, int64(1), q.dedupedRequests.Count())
 }
 
 func TestBlockRetrievalQueueElevatePriorityExistingRequest(t *testing.T) {
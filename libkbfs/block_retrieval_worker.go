@@ -77,6 +77,10 @@ func (brw *blockRetrievalWorker) HandleRequest() (err error) {
 		block = retrieval.requests[0].block.NewEmpty()
 	}()
 
+	// The fetch is abandoned as soon as every requester gives up,
+	// since `retrieval.ctx` is canceled then.
+	brw.queue.inFlightRetrievals.Inc(1)
+	defer brw.queue.inFlightRetrievals.Dec(1)
 	return brw.getBlock(retrieval.ctx, retrieval.kmd, retrieval.blockPtr, block)
 }
 
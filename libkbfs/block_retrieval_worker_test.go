@@ -12,6 +12,7 @@ import (
 
 	"github.com/keybase/kbfs/kbfscodec"
 	"github.com/keybase/kbfs/kbfscrypto"
+	pkgerrors "github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/net/context"
 )
@@ -248,6 +249,56 @@ func TestBlockRetrievalWorkerCancel(t *testing.T) {
 	require.EqualError(t, err, context.Canceled.Error())
 }
 
+func TestBlockRetrievalWorkerCancelInFlight(t *testing.T) {
+	t.Log("Test that an in-flight retrieval is abandoned once all its " +
+		"requesters give up.")
+	bg := newFakeBlockGetter(true)
+	q := newBlockRetrievalQueue(0, 1, newTestBlockRetrievalConfig(t, bg, nil))
+	require.NotNil(t, q)
+	defer q.Shutdown()
+
+	ptr1, ptr2 := makeRandomBlockPointer(t), makeRandomBlockPointer(t)
+	block1, block2 := makeFakeFileBlock(t, false), makeFakeFileBlock(t, false)
+	startCh1, continueCh1 := bg.setBlockToReturn(ptr1, block1)
+	startCh2, _ := bg.setBlockToReturn(ptr2, block2)
+
+	// Use a priority too low to trigger prefetches, which would tie
+	// up the only worker.
+	t.Log("One requester gives up; the other still gets the block.")
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	req1Ch := q.Request(ctx1, 0, makeKMD(), ptr1, &FileBlock{}, NoCacheEntry)
+	block := &FileBlock{}
+	req2Ch := q.Request(ctx2, 0, makeKMD(), ptr1, block, NoCacheEntry)
+	<-startCh1
+	require.Equal(t, int64(1), q.inFlightRetrievals.Count())
+	cancel1()
+	err := q.waitForRequest(ctx1, req1Ch)
+	require.Equal(t, context.Canceled, pkgerrors.Cause(err))
+	continueCh1 <- nil
+	err = <-req2Ch
+	require.NoError(t, err)
+	require.Equal(t, block1, block)
+	require.Equal(t, int64(1), q.orphanedRequests.Count())
+	require.Equal(t, int64(0), q.orphanedRetrievals.Count())
+
+	t.Log("The only requester gives up, so the fetch stops.")
+	ctx3, cancel3 := context.WithCancel(context.Background())
+	defer cancel3()
+	req3Ch := q.Request(ctx3, 0, makeKMD(), ptr2, &FileBlock{}, NoCacheEntry)
+	<-startCh2
+	cancel3()
+	err = q.waitForRequest(ctx3, req3Ch)
+	require.Equal(t, context.Canceled, pkgerrors.Cause(err))
+	err = <-req3Ch
+	require.Equal(t, context.Canceled, err)
+	require.Equal(t, int64(2), q.orphanedRequests.Count())
+	require.Equal(t, int64(1), q.orphanedRetrievals.Count())
+	require.Equal(t, int64(0), q.inFlightRetrievals.Count())
+}
+
 func TestBlockRetrievalWorkerShutdown(t *testing.T) {
 	t.Log("Test that worker shutdown works.")
 	bg := newFakeBlockGetter(false)
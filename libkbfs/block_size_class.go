@@ -0,0 +1,181 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	stdpath "path"
+	"strings"
+
+	"github.com/keybase/kbfs/kbfscodec"
+)
+
+// BlockSizeClass says how big the blocks of a file should be.  It's
+// chosen when a file is created, and stored in the top block of the
+// file, so that every writer splits the file the same way.  Readers
+// don't need it, since the indirect pointers of a file carry the
+// offsets of its blocks, whatever their sizes; so files written
+// before the class changed, or by clients that don't know about
+// classes, just end up with blocks of mixed sizes.
+type BlockSizeClass byte
+
+const (
+	// BlockSizeClassDefault splits files into blocks of
+	// MaxBlockSizeBytesDefault bytes.
+	BlockSizeClassDefault BlockSizeClass = 0
+	// BlockSizeClassSmall splits files into small blocks, so
+	// that small changes to files that are modified in place,
+	// like databases, re-upload less data.
+	BlockSizeClassSmall BlockSizeClass = 1
+	// BlockSizeClassLarge splits files into the largest blocks
+	// allowed, so that big files like videos and archives need as
+	// few blocks as possible.  That's currently
+	// MaxBlockSizeBytesDefault, the same as the default class, but
+	// it marks the files that would benefit if the maximum block
+	// size is ever raised.
+	BlockSizeClassLarge BlockSizeClass = 2
+)
+
+// blockSizeClassSizes are the desired block sizes of the non-default
+// size classes.  None of them may be bigger than
+// MaxBlockSizeBytesDefault, which is what the servers accept, and
+// what the dirty-byte thresholds, cache capacities and journal flush
+// batches are sized by.
+var blockSizeClassSizes = map[BlockSizeClass]int64{
+	BlockSizeClassSmall: 64 << 10,
+	BlockSizeClassLarge: MaxBlockSizeBytesDefault,
+}
+
+func (c BlockSizeClass) String() string {
+	switch c {
+	case BlockSizeClassDefault:
+		return "default"
+	case BlockSizeClassSmall:
+		return "small"
+	case BlockSizeClassLarge:
+		return "large"
+	default:
+		return fmt.Sprintf("BlockSizeClass(%d)", byte(c))
+	}
+}
+
+// blockSizeClassesByExtension are the size classes of new files, by
+// their lower-cased extensions.  Other files get the default class.
+var blockSizeClassesByExtension = map[string]BlockSizeClass{
+	// Databases, which are modified in place.
+	".db":      BlockSizeClassSmall,
+	".db3":     BlockSizeClassSmall,
+	".sqlite":  BlockSizeClassSmall,
+	".sqlite3": BlockSizeClassSmall,
+	".ldb":     BlockSizeClassSmall,
+	".mdb":     BlockSizeClassSmall,
+	".accdb":   BlockSizeClassSmall,
+	".kdbx":    BlockSizeClassSmall,
+
+	// Media, disk images and archives, which are big and usually
+	// written once.
+	".mp4":   BlockSizeClassLarge,
+	".m4v":   BlockSizeClassLarge,
+	".mov":   BlockSizeClassLarge,
+	".mkv":   BlockSizeClassLarge,
+	".avi":   BlockSizeClassLarge,
+	".webm":  BlockSizeClassLarge,
+	".wmv":   BlockSizeClassLarge,
+	".flac":  BlockSizeClassLarge,
+	".wav":   BlockSizeClassLarge,
+	".iso":   BlockSizeClassLarge,
+	".dmg":   BlockSizeClassLarge,
+	".img":   BlockSizeClassLarge,
+	".vmdk":  BlockSizeClassLarge,
+	".qcow2": BlockSizeClassLarge,
+	".zip":   BlockSizeClassLarge,
+	".tar":   BlockSizeClassLarge,
+	".gz":    BlockSizeClassLarge,
+	".tgz":   BlockSizeClassLarge,
+	".bz2":   BlockSizeClassLarge,
+	".xz":    BlockSizeClassLarge,
+	".7z":    BlockSizeClassLarge,
+	".rar":   BlockSizeClassLarge,
+}
+
+// BlockSizeClassForName returns the size class for a new file named
+// `name`, based on its extension.
+func BlockSizeClassForName(name string) BlockSizeClass {
+	return blockSizeClassesByExtension[strings.ToLower(stdpath.Ext(name))]
+}
+
+// blockSplitterWithSizeClasses is a BlockSplitter that splits the
+// files of each size class into blocks of the class's size.  Its
+// embedded splitter is for the default class, and makes all the
+// other decisions.
+type blockSplitterWithSizeClasses struct {
+	*BlockSplitterSimple
+	classes map[BlockSizeClass]*BlockSplitterSimple
+}
+
+var _ BlockSplitter = (*blockSplitterWithSizeClasses)(nil)
+
+// NewBlockSplitterWithSizeClasses returns a BlockSplitter that
+// splits files of the default size class like `bsplit`, and the files
+// of the other classes into blocks of their own sizes.
+func NewBlockSplitterWithSizeClasses(bsplit *BlockSplitterSimple,
+	blockChangeEmbedMaxSize uint64, codec kbfscodec.Codec) (
+	BlockSplitter, error) {
+	classes := make(map[BlockSizeClass]*BlockSplitterSimple)
+	for class, size := range blockSizeClassSizes {
+		classSplit, err := newBlockSplitterSimpleForSizeClass(
+			size, blockChangeEmbedMaxSize, codec, class)
+		if err != nil {
+			return nil, err
+		}
+		classSplit.maxDirEntriesPerBlock = bsplit.maxDirEntriesPerBlock
+		classes[class] = classSplit
+	}
+	return &blockSplitterWithSizeClasses{bsplit, classes}, nil
+}
+
+// blockSplitterForSizeClass returns the splitter for the blocks of
+// files in `class`.  If `bsplit` doesn't know about size classes, or
+// the class is unknown, that's `bsplit` itself.
+func blockSplitterForSizeClass(
+	bsplit BlockSplitter, class BlockSizeClass) BlockSplitter {
+	withClasses, ok := bsplit.(*blockSplitterWithSizeClasses)
+	if !ok {
+		return bsplit
+	}
+	if classSplit, ok := withClasses.classes[class]; ok {
+		return classSplit
+	}
+	return bsplit
+}
+
+// newFileBlockSizeClass returns the size class for a new file named
+// `name`, if `bsplit` supports size classes.
+func newFileBlockSizeClass(
+	bsplit BlockSplitter, name string) BlockSizeClass {
+	if _, ok := bsplit.(*blockSplitterWithSizeClasses); !ok {
+		return BlockSizeClassDefault
+	}
+	return BlockSizeClassForName(name)
+}
+
+// adaptBlockSizeClass updates the size class of a file about to be
+// written at `off`, based on the write pattern.  A file that's still
+// a single block, and is being overwritten in place rather than
+// appended to, gets the small class, since that's how databases and
+// similar files are usually changed.  It returns true if the class
+// changed.
+func adaptBlockSizeClass(
+	bsplit BlockSplitter, topBlock *FileBlock, off Int64Offset) bool {
+	if _, ok := bsplit.(*blockSplitterWithSizeClasses); !ok {
+		return false
+	}
+	if topBlock.IsInd || topBlock.SizeClass != BlockSizeClassDefault ||
+		off >= Int64Offset(len(topBlock.Contents)) {
+		return false
+	}
+	topBlock.SizeClass = BlockSizeClassSmall
+	return true
+}
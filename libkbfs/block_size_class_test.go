@@ -0,0 +1,126 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestBlockSizeClassForName(t *testing.T) {
+	require.Equal(t, BlockSizeClassSmall, BlockSizeClassForName("app.sqlite"))
+	require.Equal(t, BlockSizeClassLarge, BlockSizeClassForName("movie.MKV"))
+	require.Equal(t, BlockSizeClassDefault, BlockSizeClassForName("notes.txt"))
+	require.Equal(t, BlockSizeClassDefault, BlockSizeClassForName("Makefile"))
+}
+
+func TestBlockSplitterWithSizeClasses(t *testing.T) {
+	codec := kbfscodec.NewMsgpack()
+	bsplit, err := NewBlockSplitterSimple(
+		MaxBlockSizeBytesDefault, 8*1024, codec)
+	require.NoError(t, err)
+	withClasses, err := NewBlockSplitterWithSizeClasses(bsplit, 8*1024, codec)
+	require.NoError(t, err)
+
+	require.Equal(t, BlockSplitter(bsplit),
+		blockSplitterForSizeClass(bsplit, BlockSizeClassSmall))
+	require.Equal(t, withClasses,
+		blockSplitterForSizeClass(withClasses, BlockSizeClassDefault))
+
+	t.Log("A full top block of each class, including the class, fits " +
+		"into the class's size before padding, which is no bigger " +
+		"than the maximum block size.")
+	for class, size := range blockSizeClassSizes {
+		require.True(t, size <= MaxBlockSizeBytesDefault, "%s", class)
+		classSplit, ok := blockSplitterForSizeClass(
+			withClasses, class).(*BlockSplitterSimple)
+		require.True(t, ok)
+		block := &FileBlock{
+			Contents:  make([]byte, classSplit.maxSize),
+			SizeClass: class,
+		}
+		for i := range block.Contents {
+			block.Contents[i] = byte(i)
+		}
+		buf, err := codec.Encode(block)
+		require.NoError(t, err)
+		require.Equal(t, size-1, int64(len(buf)), "%s", class)
+	}
+}
+
+func setupFileDataSizeClassTest(t *testing.T) (
+	*fileData, BlockCache, DirtyBlockCache, *dirtyFile) {
+	fd, cleanBcache, dirtyBcache, df := setupFileDataTest(t, 10, 4)
+	fd.bsplit = &blockSplitterWithSizeClasses{
+		&BlockSplitterSimple{10, 4, 10, 0},
+		map[BlockSizeClass]*BlockSplitterSimple{
+			BlockSizeClassSmall: {4, 4, 10, 0},
+		},
+	}
+	fd.tree.bsplit = fd.bsplit
+	return fd, cleanBcache, dirtyBcache, df
+}
+
+func TestFileDataWriteSizeClass(t *testing.T) {
+	fd, cleanBcache, dirtyBcache, df := setupFileDataSizeClassTest(t)
+	topBlock := &FileBlock{SizeClass: BlockSizeClassSmall}
+	cleanBcache.Put(
+		fd.rootBlockPointer(), fd.tree.file.Tlf, topBlock, TransientEntry)
+
+	ctx := context.Background()
+	data := []byte("0123456789ab")
+	_, _, _, _, _, err := fd.write(ctx, data, 0, topBlock, DirEntry{}, df)
+	require.NoError(t, err)
+
+	t.Log("The new top block keeps the class, and the leaves have " +
+		"the class's size.")
+	block, err := dirtyBcache.Get(
+		fd.tree.file.Tlf, fd.rootBlockPointer(), MasterBranch)
+	require.NoError(t, err)
+	newTopBlock := block.(*FileBlock)
+	require.True(t, newTopBlock.IsInd)
+	require.Equal(t, BlockSizeClassSmall, newTopBlock.SizeClass)
+	var offs []Int64Offset
+	for _, iptr := range newTopBlock.IPtrs {
+		offs = append(offs, iptr.Off)
+	}
+	require.Equal(t, []Int64Offset{0, 4, 8}, offs)
+
+	gotData := make([]byte, len(data))
+	n, err := fd.read(ctx, gotData, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), n)
+	require.Equal(t, data, gotData)
+}
+
+func TestFileDataWriteAdaptsSizeClass(t *testing.T) {
+	fd, cleanBcache, _, df := setupFileDataSizeClassTest(t)
+	topBlock := NewFileBlock().(*FileBlock)
+	topBlock.Contents = []byte("01234567")
+	cleanBcache.Put(
+		fd.rootBlockPointer(), fd.tree.file.Tlf, topBlock, TransientEntry)
+	de := DirEntry{EntryInfo: EntryInfo{Size: 8}}
+
+	ctx := context.Background()
+	t.Log("Appending keeps the default class.")
+	_, _, _, _, _, err := fd.write(ctx, []byte("8"), 8, topBlock, de, df)
+	require.NoError(t, err)
+	require.Equal(t, BlockSizeClassDefault, topBlock.SizeClass)
+
+	t.Log("Overwriting in place switches to the small class.")
+	de.Size = 9
+	_, _, _, _, _, err = fd.write(ctx, []byte("xy"), 2, topBlock, de, df)
+	require.NoError(t, err)
+	require.Equal(t, BlockSizeClassSmall, topBlock.SizeClass)
+
+	gotData := make([]byte, 9)
+	n, err := fd.read(ctx, gotData, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(9), n)
+	require.Equal(t, []byte("01xy45678"), gotData)
+}
@@ -0,0 +1,68 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// blockTransformMagic starts the payload of every block wrapped by a
+// BlockTransform.  Encoded blocks always start with a map header, so
+// they can't be mistaken for wrapped ones.
+var blockTransformMagic = []byte("KBTW")
+
+// maxBlockTransformKeyIDLen is the longest key ID that can be
+// recorded in a wrapped block.
+const maxBlockTransformKeyIDLen = 255
+
+// wrapBlockPayload wraps the encoded, encrypted payload of a block of
+// the given TLF with `transform`, if there is one, tagging the result
+// with the ID of the key used.
+func wrapBlockPayload(ctx context.Context, transform BlockTransform,
+	tlfID tlf.ID, buf []byte) ([]byte, error) {
+	if transform == nil {
+		return buf, nil
+	}
+	wrapped, keyID, err := transform.WrapBlock(ctx, tlfID, buf)
+	if err != nil {
+		return nil, err
+	}
+	if len(keyID) > maxBlockTransformKeyIDLen {
+		return nil, errors.Errorf(
+			"Block transform key ID %q is longer than %d bytes",
+			keyID, maxBlockTransformKeyIDLen)
+	}
+	res := make([]byte, 0,
+		len(blockTransformMagic)+1+len(keyID)+len(wrapped))
+	res = append(res, blockTransformMagic...)
+	res = append(res, byte(len(keyID)))
+	res = append(res, keyID...)
+	return append(res, wrapped...), nil
+}
+
+// unwrapBlockPayload undoes wrapBlockPayload.  Blocks that weren't
+// wrapped, e.g. because they were put before a transform was
+// configured, are returned as is.
+func unwrapBlockPayload(ctx context.Context, transform BlockTransform,
+	tlfID tlf.ID, buf []byte) ([]byte, error) {
+	if !bytes.HasPrefix(buf, blockTransformMagic) {
+		return buf, nil
+	}
+	rest := buf[len(blockTransformMagic):]
+	if len(rest) == 0 || len(rest) < 1+int(rest[0]) {
+		return nil, errors.New("Truncated block transform header")
+	}
+	keyIDEnd := 1 + int(rest[0])
+	keyID := string(rest[1:keyIDEnd])
+	if transform == nil {
+		return nil, errors.Errorf("Block was wrapped with key %q, but no "+
+			"block transform is configured", keyID)
+	}
+	return transform.UnwrapBlock(ctx, tlfID, keyID, rest[keyIDEnd:])
+}
@@ -7,6 +7,7 @@ package libkbfs
 import (
 	"context"
 	"fmt"
+	"runtime"
 
 	"github.com/keybase/client/go/logger"
 	"github.com/keybase/client/go/protocol/keybase1"
@@ -867,25 +868,36 @@ func (bt *blockTree) readyHelper(
 	// the root block though; the folderUpdatePrepper code will do
 	// that.
 	for level := len(pathsFromRoot[0]) - 1; level > 0; level-- {
+		// Collect the distinct blocks at this level first, since
+		// several paths can share the same indirect parent.
+		var toReady []int
+		seen := make(map[BlockPointer]bool)
 		for i := 0; i < len(pathsFromRoot); i++ {
-			// Ready the dirty block.
-			pb := pathsFromRoot[i][level]
-
-			parentPB := pathsFromRoot[i][level-1]
-			ptr := parentPB.childBlockPtr()
+			ptr := pathsFromRoot[i][level-1].childBlockPtr()
 			// If this is already a new pointer, skip it.
-			if newPtrs[ptr] {
+			if newPtrs[ptr] || seen[ptr] {
 				continue
 			}
+			seen[ptr] = true
+			toReady = append(toReady, i)
+		}
 
-			newInfo, _, readyBlockData, err := ReadyBlock(
-				ctx, bcache, bops, bt.crypto, bt.kmd, pb.pblock,
-				bt.chargedTo, bt.rootBlockPointer().GetBlockType())
-			if err != nil {
-				return nil, err
-			}
+		results, err := bt.readyBlocksInParallel(
+			ctx, bcache, bops, pathsFromRoot, level, toReady)
+		if err != nil {
+			return nil, err
+		}
 
-			err = bcache.Put(
+		// Apply the results in path order, so the put state and
+		// parent blocks look the same as if they were readied
+		// serially.
+		for j, i := range toReady {
+			pb := pathsFromRoot[i][level]
+			parentPB := pathsFromRoot[i][level-1]
+			ptr := parentPB.childBlockPtr()
+			newInfo := results[j].info
+
+			err := bcache.Put(
 				newInfo.BlockPointer, id, pb.pblock, PermanentEntry)
 			if err != nil {
 				return nil, err
@@ -898,7 +910,8 @@ func (bt *blockTree) readyHelper(
 			}
 
 			bps.addNewBlock(
-				newInfo.BlockPointer, pb.pblock, readyBlockData, syncFunc)
+				newInfo.BlockPointer, pb.pblock, results[j].readyBlockData,
+				syncFunc)
 			bps.saveOldPtr(ptr)
 
 			parentPB.setChildBlockInfo(newInfo)
@@ -909,6 +922,60 @@ func (bt *blockTree) readyHelper(
 	return oldPtrs, nil
 }
 
+type readyBlockResult struct {
+	info           BlockInfo
+	readyBlockData ReadyBlockData
+}
+
+// readyBlocksInParallel hashes and encrypts the blocks at the given
+// level of the given paths, using one worker per CPU, since readying
+// a large file one block at a time only uses a single core.
+// The i-th result corresponds to the path at index `indices[i]`.
+func (bt *blockTree) readyBlocksInParallel(
+	ctx context.Context, bcache BlockCache, bops BlockOps,
+	pathsFromRoot [][]parentBlockAndChildIndex, level int, indices []int) (
+	[]readyBlockResult, error) {
+	results := make([]readyBlockResult, len(indices))
+	if len(indices) == 0 {
+		return results, nil
+	}
+
+	eg, groupCtx := errgroup.WithContext(ctx)
+	jobs := make(chan int, len(indices))
+
+	numWorkers := len(indices)
+	if numCPU := runtime.NumCPU(); numWorkers > numCPU {
+		numWorkers = numCPU
+	}
+	worker := func() error {
+		for j := range jobs {
+			pb := pathsFromRoot[indices[j]][level]
+			info, _, readyBlockData, err := ReadyBlock(
+				groupCtx, bcache, bops, bt.crypto, bt.kmd, pb.pblock,
+				bt.chargedTo, bt.rootBlockPointer().GetBlockType())
+			if err != nil {
+				return err
+			}
+			results[j] = readyBlockResult{info, readyBlockData}
+		}
+		return nil
+	}
+	for i := 0; i < numWorkers; i++ {
+		eg.Go(worker)
+	}
+
+	for j := range indices {
+		jobs <- j
+	}
+	close(jobs)
+
+	err := eg.Wait()
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
 // ready, if given an indirect top-block, readies all the dirty child
 // blocks, and updates their block IDs in their parent block's list of
 // indirect pointers.  It returns a map pointing from the new block
@@ -404,6 +404,9 @@ type FileBlock struct {
 	Contents []byte `codec:"c,omitempty"`
 	// if indirect, contains the indirect pointers to the next level of blocks
 	IPtrs []IndirectFilePtr `codec:"i,omitempty"`
+	// SizeClass, set only in the top block of a file, says how
+	// big the blocks of the file should be when it's written.
+	SizeClass BlockSizeClass `codec:"z,omitempty"`
 
 	// this is used for caching plaintext (block.Contents) hash. It is used by
 	// only direct blocks.
@@ -497,6 +500,7 @@ func (fb *FileBlock) Set(other Block) {
 	fbCopy := otherFb.DeepCopy()
 	fb.Contents = fbCopy.Contents
 	fb.IPtrs = fbCopy.IPtrs
+	fb.SizeClass = fbCopy.SizeClass
 	fb.ToCommonBlock().Set(fbCopy.ToCommonBlock())
 	// Ensure that the Set is complete from Go's perspective by calculating the
 	// hash on the new FileBlock if the old one has been set. This is mainly so
@@ -522,6 +526,7 @@ func (fb *FileBlock) DeepCopy() *FileBlock {
 		CommonBlock: fb.CommonBlock.DeepCopy(),
 		Contents:    contentsCopy,
 		IPtrs:       iptrsCopy,
+		SizeClass:   fb.SizeClass,
 	}
 }
 
@@ -231,6 +231,7 @@ func makeFakeFileBlockFuture(t *testing.T) *fileBlockFuture {
 			},
 			[]byte{0xa, 0xb},
 			nil,
+			BlockSizeClassDefault,
 			nil,
 		},
 		[]indirectFilePtrFuture{
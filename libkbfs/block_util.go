@@ -118,8 +118,13 @@ func doBlockPuts(ctx context.Context, bserv BlockServer, bcache BlockCache,
 
 	worker := func() error {
 		for blockState := range blocks {
-			err := doOneBlockPut(groupCtx, bserv, reporter, tlfID,
+			release, err := acquireBackgroundFlushBudget(groupCtx)
+			if err != nil {
+				return err
+			}
+			err = doOneBlockPut(groupCtx, bserv, reporter, tlfID,
 				tlfName, blockState, blocksToRemoveChan)
+			release()
 			if err != nil {
 				return err
 			}
@@ -165,13 +170,18 @@ func doBlockPuts(ctx context.Context, bserv BlockServer, bcache BlockCache,
 }
 
 func assembleBlock(ctx context.Context, keyGetter blockKeyGetter,
-	codec kbfscodec.Codec, cryptoPure cryptoPure, kmd KeyMetadata,
-	blockPtr BlockPointer, block Block, buf []byte,
+	codec kbfscodec.Codec, cryptoPure cryptoPure, transform BlockTransform,
+	kmd KeyMetadata, blockPtr BlockPointer, block Block, buf []byte,
 	blockServerHalf kbfscrypto.BlockCryptKeyServerHalf) error {
 	if err := kbfsblock.VerifyID(buf, blockPtr.ID); err != nil {
 		return err
 	}
 
+	payload, err := unwrapBlockPayload(ctx, transform, kmd.TlfID(), buf)
+	if err != nil {
+		return err
+	}
+
 	tlfCryptKey, err := keyGetter.GetTLFCryptKeyForBlockDecryption(
 		ctx, kmd, blockPtr)
 	if err != nil {
@@ -179,7 +189,7 @@ func assembleBlock(ctx context.Context, keyGetter blockKeyGetter,
 	}
 
 	var encryptedBlock kbfscrypto.EncryptedBlock
-	err = codec.Decode(buf, &encryptedBlock)
+	err = codec.Decode(payload, &encryptedBlock)
 	if err != nil {
 		return err
 	}
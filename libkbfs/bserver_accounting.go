@@ -0,0 +1,223 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	metrics "github.com/rcrowley/go-metrics"
+	"golang.org/x/net/context"
+)
+
+// blockServerAccounting delegates to another BlockServer, and tells
+// the configured StorageAccountant, if any, about the block data
+// written to and deleted from it.  Written bytes are also counted
+// per TLF for GetTopStatus.  The sizes of deleted blocks have
+// to be looked up before their references are removed, so that's
+// only done while there is an accountant.
+type blockServerAccounting struct {
+	delegate BlockServer
+	config   storageAccountantGetter
+	log      logger.Logger
+
+	// These are nil if there's no metrics registry.  Deleted bytes
+	// are only measured while there is an accountant.
+	bytesWrittenMeter metrics.Meter
+	bytesDeletedMeter metrics.Meter
+}
+
+var _ BlockServer = (*blockServerAccounting)(nil)
+
+// newBlockServerAccounting returns a new blockServerAccounting with
+// the given delegate.  If `r` is non-nil, the totals are also
+// recorded there.
+func newBlockServerAccounting(delegate BlockServer,
+	config storageAccountantGetter, log logger.Logger,
+	r metrics.Registry) *blockServerAccounting {
+	b := &blockServerAccounting{
+		delegate: delegate,
+		config:   config,
+		log:      log,
+	}
+	if r != nil {
+		b.bytesWrittenMeter = metrics.GetOrRegisterMeter(
+			"BlockServer.BytesWritten", r)
+		b.bytesDeletedMeter = metrics.GetOrRegisterMeter(
+			"BlockServer.BytesDeleted", r)
+	}
+	return b
+}
+
+func (b *blockServerAccounting) written(
+	ctx context.Context, tlfID tlf.ID, bytes int64) {
+	if b.bytesWrittenMeter != nil {
+		b.bytesWrittenMeter.Mark(bytes)
+	}
+	if sa := b.config.StorageAccountant(); sa != nil {
+		sa.BytesWritten(ctx, tlfID, bytes)
+	}
+	if g, ok := b.config.(kbfsOpsGetter); ok {
+		recordUploaded(g, tlfID, bytes, 0)
+	}
+}
+
+// putSucceeded returns whether a put that returned `err` stored the
+// block anyway.
+func putSucceeded(err error) bool {
+	if err == nil {
+		return true
+	}
+	qe, ok := errors.Cause(err).(kbfsblock.ServerErrorOverQuota)
+	return ok && !qe.Throttled
+}
+
+// Get implements the BlockServer interface for blockServerAccounting.
+func (b *blockServerAccounting) Get(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, context kbfsblock.Context) (
+	[]byte, kbfscrypto.BlockCryptKeyServerHalf, error) {
+	return b.delegate.Get(ctx, tlfID, id, context)
+}
+
+// GetEncodedSize implements the BlockServer interface for
+// blockServerAccounting.
+func (b *blockServerAccounting) GetEncodedSize(
+	ctx context.Context, tlfID tlf.ID, id kbfsblock.ID,
+	context kbfsblock.Context) (uint32, keybase1.BlockStatus, error) {
+	return b.delegate.GetEncodedSize(ctx, tlfID, id, context)
+}
+
+// Put implements the BlockServer interface for blockServerAccounting.
+func (b *blockServerAccounting) Put(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, context kbfsblock.Context, buf []byte,
+	serverHalf kbfscrypto.BlockCryptKeyServerHalf) error {
+	err := b.delegate.Put(ctx, tlfID, id, context, buf, serverHalf)
+	if putSucceeded(err) {
+		b.written(ctx, tlfID, int64(len(buf)))
+	}
+	return err
+}
+
+// PutAgain implements the BlockServer interface for
+// blockServerAccounting.
+func (b *blockServerAccounting) PutAgain(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, context kbfsblock.Context, buf []byte,
+	serverHalf kbfscrypto.BlockCryptKeyServerHalf) error {
+	err := b.delegate.PutAgain(ctx, tlfID, id, context, buf, serverHalf)
+	if putSucceeded(err) {
+		b.written(ctx, tlfID, int64(len(buf)))
+	}
+	return err
+}
+
+// AddBlockReference implements the BlockServer interface for
+// blockServerAccounting.  New references don't store any new data.
+func (b *blockServerAccounting) AddBlockReference(ctx context.Context,
+	tlfID tlf.ID, id kbfsblock.ID, context kbfsblock.Context) error {
+	return b.delegate.AddBlockReference(ctx, tlfID, id, context)
+}
+
+// RemoveBlockReferences implements the BlockServer interface for
+// blockServerAccounting.
+func (b *blockServerAccounting) RemoveBlockReferences(ctx context.Context,
+	tlfID tlf.ID, contexts kbfsblock.ContextMap) (
+	liveCounts map[kbfsblock.ID]int, err error) {
+	sa := b.config.StorageAccountant()
+	if sa == nil {
+		return b.delegate.RemoveBlockReferences(ctx, tlfID, contexts)
+	}
+
+	// Look up the sizes first, since the blocks may be gone after.
+	sizes := make(map[kbfsblock.ID]uint32, len(contexts))
+	for id, idContexts := range contexts {
+		if len(idContexts) == 0 {
+			continue
+		}
+		size, _, err := b.delegate.GetEncodedSize(
+			ctx, tlfID, id, idContexts[0])
+		if err != nil {
+			// The block is probably already deleted.
+			b.log.CDebugf(ctx, "Couldn't get the size of block %s "+
+				"before removing references: %+v", id, err)
+			continue
+		}
+		sizes[id] = size
+	}
+
+	liveCounts, err = b.delegate.RemoveBlockReferences(ctx, tlfID, contexts)
+	if err != nil {
+		return liveCounts, err
+	}
+	var deleted int64
+	for id, count := range liveCounts {
+		if count == 0 {
+			deleted += int64(sizes[id])
+		}
+	}
+	if deleted > 0 {
+		if b.bytesDeletedMeter != nil {
+			b.bytesDeletedMeter.Mark(deleted)
+		}
+		sa.BytesDeleted(ctx, tlfID, deleted)
+	}
+	return liveCounts, nil
+}
+
+// ArchiveBlockReferences implements the BlockServer interface for
+// blockServerAccounting.  Archived blocks still count against quota.
+func (b *blockServerAccounting) ArchiveBlockReferences(ctx context.Context,
+	tlfID tlf.ID, contexts kbfsblock.ContextMap) error {
+	return b.delegate.ArchiveBlockReferences(ctx, tlfID, contexts)
+}
+
+// IsUnflushed implements the BlockServer interface for
+// blockServerAccounting.
+func (b *blockServerAccounting) IsUnflushed(ctx context.Context,
+	tlfID tlf.ID, id kbfsblock.ID) (bool, error) {
+	return b.delegate.IsUnflushed(ctx, tlfID, id)
+}
+
+// Shutdown implements the BlockServer interface for
+// blockServerAccounting.
+func (b *blockServerAccounting) Shutdown(ctx context.Context) {
+	b.delegate.Shutdown(ctx)
+}
+
+// RefreshAuthToken implements the BlockServer interface for
+// blockServerAccounting.
+func (b *blockServerAccounting) RefreshAuthToken(ctx context.Context) {
+	b.delegate.RefreshAuthToken(ctx)
+}
+
+// GetUserQuotaInfo implements the BlockServer interface for
+// blockServerAccounting.
+func (b *blockServerAccounting) GetUserQuotaInfo(ctx context.Context) (
+	*kbfsblock.QuotaInfo, error) {
+	return b.delegate.GetUserQuotaInfo(ctx)
+}
+
+// GetTeamQuotaInfo implements the BlockServer interface for
+// blockServerAccounting.
+func (b *blockServerAccounting) GetTeamQuotaInfo(
+	ctx context.Context, tid keybase1.TeamID) (*kbfsblock.QuotaInfo, error) {
+	return b.delegate.GetTeamQuotaInfo(ctx, tid)
+}
+
+// getAllRefsForTest implements the blockServerLocal interface for
+// blockServerAccounting, if its delegate implements it too.
+func (b *blockServerAccounting) getAllRefsForTest(
+	ctx context.Context, tlfID tlf.ID) (map[kbfsblock.ID]blockRefMap, error) {
+	local, ok := b.delegate.(blockServerLocal)
+	if !ok {
+		return nil, fmt.Errorf(
+			"Block server %T can't list its refs", b.delegate)
+	}
+	return local.getAllRefsForTest(ctx, tlfID)
+}
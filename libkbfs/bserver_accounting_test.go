@@ -0,0 +1,101 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/tlf"
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+type testStorageAccountant struct {
+	lock    sync.Mutex
+	written map[tlf.ID]int64
+	deleted map[tlf.ID]int64
+}
+
+func (sa *testStorageAccountant) BytesWritten(
+	_ context.Context, tlfID tlf.ID, bytes int64) {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	sa.written[tlfID] += bytes
+}
+
+func (sa *testStorageAccountant) BytesDeleted(
+	_ context.Context, tlfID tlf.ID, bytes int64) {
+	sa.lock.Lock()
+	defer sa.lock.Unlock()
+	sa.deleted[tlfID] += bytes
+}
+
+type testStorageAccountantGetter struct {
+	sa StorageAccountant
+}
+
+func (g *testStorageAccountantGetter) StorageAccountant() StorageAccountant {
+	return g.sa
+}
+
+func TestBlockServerAccounting(t *testing.T) {
+	ctx := context.Background()
+	getter := &testStorageAccountantGetter{}
+	registry := metrics.NewRegistry()
+	log := logger.NewTestLogger(t)
+	b := newBlockServerAccounting(
+		NewBlockServerMemory(log), getter, log, registry)
+	tlfID1 := tlf.FakeID(1, tlf.Private)
+	tlfID2 := tlf.FakeID(2, tlf.Private)
+
+	t.Log("Without an accountant, only the metrics are updated")
+	bID1, bCtx1, data1, serverHalf1 := makeTestShapedBlock(t, 100)
+	err := b.Put(ctx, tlfID1, bID1, bCtx1, data1, serverHalf1)
+	require.NoError(t, err)
+	written := metrics.GetOrRegisterMeter("BlockServer.BytesWritten", registry)
+	require.Equal(t, int64(100), written.Count())
+
+	sa := &testStorageAccountant{
+		written: make(map[tlf.ID]int64),
+		deleted: make(map[tlf.ID]int64),
+	}
+	getter.sa = sa
+
+	t.Log("Puts are accounted to their TLF; new references are free")
+	bID2, bCtx2, data2, serverHalf2 := makeTestShapedBlock(t, 200)
+	err = b.Put(ctx, tlfID1, bID2, bCtx2, data2, serverHalf2)
+	require.NoError(t, err)
+	bID3, bCtx3, data3, serverHalf3 := makeTestShapedBlock(t, 300)
+	err = b.Put(ctx, tlfID2, bID3, bCtx3, data3, serverHalf3)
+	require.NoError(t, err)
+	nonce, err := kbfsblock.MakeRefNonce()
+	require.NoError(t, err)
+	bCtx2b := kbfsblock.MakeContext(
+		bCtx2.GetCreator(), keybase1.MakeTestUID(1).AsUserOrTeam(), nonce,
+		keybase1.BlockType_DATA)
+	err = b.AddBlockReference(ctx, tlfID1, bID2, bCtx2b)
+	require.NoError(t, err)
+	require.Equal(t, map[tlf.ID]int64{tlfID1: 200, tlfID2: 300}, sa.written)
+
+	t.Log("Blocks are only deleted with their last reference")
+	_, err = b.RemoveBlockReferences(ctx, tlfID1, kbfsblock.ContextMap{
+		bID1: {bCtx1},
+		bID2: {bCtx2},
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[tlf.ID]int64{tlfID1: 100}, sa.deleted)
+	_, err = b.RemoveBlockReferences(ctx, tlfID1, kbfsblock.ContextMap{
+		bID2: {bCtx2b},
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[tlf.ID]int64{tlfID1: 300}, sa.deleted)
+	deleted := metrics.GetOrRegisterMeter("BlockServer.BytesDeleted", registry)
+	require.Equal(t, int64(300), deleted.Count())
+}
@@ -473,7 +473,10 @@ func (b *BlockServerDisk) GetUserQuotaInfo(ctx context.Context) (info *kbfsblock
 	}
 
 	// Return a dummy value here.
-	return &kbfsblock.QuotaInfo{Limit: math.MaxInt64}, nil
+	return &kbfsblock.QuotaInfo{
+		Limit:    math.MaxInt64,
+		GitLimit: math.MaxInt64,
+	}, nil
 }
 
 // GetTeamQuotaInfo implements the BlockServer interface for BlockServerDisk.
@@ -487,5 +490,8 @@ func (b *BlockServerDisk) GetTeamQuotaInfo(
 	// TODO: check team membership and return error if not a reader?
 
 	// Return a dummy value here.
-	return &kbfsblock.QuotaInfo{Limit: math.MaxInt64}, nil
+	return &kbfsblock.QuotaInfo{
+		Limit:    math.MaxInt64,
+		GitLimit: math.MaxInt64,
+	}, nil
 }
@@ -0,0 +1,347 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/go-framed-msgpack-rpc/rpc"
+	metrics "github.com/rcrowley/go-metrics"
+	"golang.org/x/net/context"
+)
+
+const (
+	// bserverEndpointProbeInterval is how often every block server
+	// endpoint is probed.
+	bserverEndpointProbeInterval = 1 * time.Minute
+	// bserverEndpointProbeTimeout is how long a probe may take
+	// before the endpoint is considered down.
+	bserverEndpointProbeTimeout = 10 * time.Second
+	// bserverEndpointRetryAfter is how long a failed endpoint is
+	// avoided, unless a connection to it succeeds before then.  A
+	// successful probe isn't enough, since an endpoint can accept
+	// connections and still stall.
+	bserverEndpointRetryAfter = 5 * time.Minute
+)
+
+type bserverEndpoint struct {
+	addr     string
+	priority int
+	// latency is a moving average of the probe latencies, or zero
+	// if no probe has succeeded yet.
+	latency time.Duration
+	// lastFailure is zero if the endpoint is healthy.
+	lastFailure time.Time
+	timer       metrics.Timer
+}
+
+func (e *bserverEndpoint) isHealthy(now time.Time) bool {
+	return e.lastFailure.IsZero() ||
+		now.Sub(e.lastFailure) >= bserverEndpointRetryAfter
+}
+
+// bserverEndpoints keeps track of the health and latency of every
+// address of the block server, so that connections go to the
+// fastest healthy endpoint, and move away from endpoints that fail
+// or stall.  The addresses are given in the prioritized round-robin
+// format (see rpc.ParsePrioritizedRoundRobinRemote): the healthy
+// endpoints of the first group are preferred over those of later
+// groups, and within a group the endpoint with the lowest probed
+// latency wins.
+type bserverEndpoints struct {
+	log   logger.Logger
+	clock Clock
+	str   string
+	probe func(ctx context.Context, addr string) error
+
+	lock      sync.Mutex
+	endpoints []*bserverEndpoint
+	cancel    context.CancelFunc
+}
+
+// newBServerEndpoints returns a new bserverEndpoints for all the
+// addresses of `remote`.  Failures are timed by `clock`.  If
+// `registry` is non-nil, the probe latencies of each endpoint are
+// recorded in it.
+func newBServerEndpoints(remote rpc.Remote, log logger.Logger,
+	clock Clock, registry metrics.Registry) *bserverEndpoints {
+	be := &bserverEndpoints{
+		log:   log,
+		clock: clock,
+		str:   remote.String(),
+		probe: probeBServerEndpoint,
+	}
+	seen := make(map[string]bool)
+	for i, group := range strings.Split(be.str, ";") {
+		for _, addr := range strings.Split(group, ",") {
+			addr = strings.ToLower(strings.TrimSpace(addr))
+			if addr == "" || seen[addr] {
+				continue
+			}
+			seen[addr] = true
+			e := &bserverEndpoint{addr: addr, priority: i}
+			if registry != nil {
+				e.timer = metrics.GetOrRegisterTimer(
+					"BlockServer.Endpoint."+addr+".Latency", registry)
+			}
+			be.endpoints = append(be.endpoints, e)
+		}
+	}
+	return be
+}
+
+func probeBServerEndpoint(ctx context.Context, addr string) error {
+	dialer := net.Dialer{Timeout: bserverEndpointProbeTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (be *bserverEndpoints) findLocked(addr string) *bserverEndpoint {
+	for _, e := range be.endpoints {
+		if e.addr == addr {
+			return e
+		}
+	}
+	return nil
+}
+
+// best returns the best endpoint that isn't in `skip`, or the empty
+// string if there is none.
+func (be *bserverEndpoints) best(skip map[string]bool) string {
+	be.lock.Lock()
+	defer be.lock.Unlock()
+	candidates := make([]*bserverEndpoint, 0, len(be.endpoints))
+	for _, i := range rand.Perm(len(be.endpoints)) {
+		if e := be.endpoints[i]; !skip[e.addr] {
+			candidates = append(candidates, e)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	now := be.clock.Now()
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		aHealthy, bHealthy := a.isHealthy(now), b.isHealthy(now)
+		if aHealthy != bHealthy {
+			return aHealthy
+		}
+		if a.priority != b.priority {
+			return a.priority < b.priority
+		}
+		// Endpoints with unknown latency go last, in random order.
+		if (a.latency == 0) != (b.latency == 0) {
+			return b.latency == 0
+		}
+		return a.latency < b.latency
+	})
+	return candidates[0].addr
+}
+
+func (be *bserverEndpoints) markFailed(addr string) {
+	be.lock.Lock()
+	defer be.lock.Unlock()
+	e := be.findLocked(addr)
+	if e == nil {
+		return
+	}
+	if e.lastFailure.IsZero() {
+		be.log.CDebugf(context.TODO(), "Block server endpoint %s failed", addr)
+	}
+	e.lastFailure = be.clock.Now()
+}
+
+func (be *bserverEndpoints) markHealthy(addr string) {
+	be.lock.Lock()
+	defer be.lock.Unlock()
+	e := be.findLocked(addr)
+	if e == nil {
+		return
+	}
+	if !e.lastFailure.IsZero() {
+		be.log.CDebugf(context.TODO(), "Block server endpoint %s recovered",
+			addr)
+	}
+	e.lastFailure = time.Time{}
+}
+
+func (be *bserverEndpoints) recordLatency(addr string, latency time.Duration) {
+	be.lock.Lock()
+	defer be.lock.Unlock()
+	e := be.findLocked(addr)
+	if e == nil {
+		return
+	}
+	if e.latency == 0 {
+		e.latency = latency
+	} else {
+		e.latency = (3*e.latency + latency) / 4
+	}
+	if e.timer != nil {
+		e.timer.Update(latency)
+	}
+}
+
+// probeAll probes all the endpoints at once, and waits for them.
+func (be *bserverEndpoints) probeAll(ctx context.Context) {
+	be.lock.Lock()
+	addrs := make([]string, 0, len(be.endpoints))
+	for _, e := range be.endpoints {
+		addrs = append(addrs, e.addr)
+	}
+	be.lock.Unlock()
+
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(
+				ctx, bserverEndpointProbeTimeout)
+			defer cancel()
+			start := time.Now()
+			err := be.probe(ctx, addr)
+			if err != nil {
+				be.log.CDebugf(ctx, "Probing block server endpoint %s "+
+					"failed: %+v", addr, err)
+				be.markFailed(addr)
+				return
+			}
+			be.recordLatency(addr, time.Since(start))
+		}(addr)
+	}
+	wg.Wait()
+}
+
+// startProbing probes the endpoints right away and then
+// periodically, until shutdown is called.  Probing a single endpoint
+// would be pointless, so it's skipped.
+func (be *bserverEndpoints) startProbing() {
+	be.lock.Lock()
+	defer be.lock.Unlock()
+	if len(be.endpoints) < 2 || be.cancel != nil {
+		return
+	}
+	var ctx context.Context
+	ctx, be.cancel = context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(bserverEndpointProbeInterval)
+		defer ticker.Stop()
+		for {
+			be.probeAll(ctx)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (be *bserverEndpoints) shutdown() {
+	be.lock.Lock()
+	defer be.lock.Unlock()
+	if be.cancel != nil {
+		be.cancel()
+		be.cancel = nil
+	}
+}
+
+// newRemote returns an rpc.Remote for a single connection that
+// chooses among the endpoints.
+func (be *bserverEndpoints) newRemote() *bserverEndpointsRemote {
+	return &bserverEndpointsRemote{
+		endpoints: be,
+		tried:     make(map[string]bool),
+	}
+}
+
+// bserverEndpointsRemote is the rpc.Remote of a single connection.
+// Each dial attempt gets the best endpoint that the connection hasn't
+// tried since it last connected successfully, so that a failing
+// endpoint isn't retried until all the others have been.
+type bserverEndpointsRemote struct {
+	endpoints *bserverEndpoints
+
+	lock    sync.Mutex
+	tried   map[string]bool
+	peeked  string
+	current string
+}
+
+var _ rpc.Remote = (*bserverEndpointsRemote)(nil)
+
+func (r *bserverEndpointsRemote) peekLocked() string {
+	if r.peeked != "" {
+		return r.peeked
+	}
+	addr := r.endpoints.best(r.tried)
+	if addr == "" {
+		// Everything has been tried; start over.
+		r.tried = make(map[string]bool)
+		addr = r.endpoints.best(r.tried)
+	}
+	r.peeked = addr
+	return addr
+}
+
+// GetAddress implements the rpc.Remote interface for
+// bserverEndpointsRemote.
+func (r *bserverEndpointsRemote) GetAddress() string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	addr := r.peekLocked()
+	r.peeked = ""
+	r.tried[addr] = true
+	r.current = addr
+	return addr
+}
+
+// Peek implements the rpc.Remote interface for bserverEndpointsRemote.
+func (r *bserverEndpointsRemote) Peek() string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.peekLocked()
+}
+
+// Reset implements the rpc.Remote interface for
+// bserverEndpointsRemote.  The rpc package calls it after each
+// successful connection, so it also marks the current endpoint as
+// healthy.
+func (r *bserverEndpointsRemote) Reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.tried = make(map[string]bool)
+	r.peeked = ""
+	if r.current != "" {
+		r.endpoints.markHealthy(r.current)
+	}
+}
+
+// String implements the rpc.Remote interface for
+// bserverEndpointsRemote.
+func (r *bserverEndpointsRemote) String() string {
+	return r.endpoints.str
+}
+
+// failCurrent marks the endpoint of the last dial attempt as failed,
+// and returns it.
+func (r *bserverEndpointsRemote) failCurrent() string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.current != "" {
+		r.endpoints.markFailed(r.current)
+	}
+	return r.current
+}
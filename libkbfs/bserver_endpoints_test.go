@@ -0,0 +1,82 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/go-framed-msgpack-rpc/rpc"
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func makeTestBServerEndpoints(t *testing.T, addrs string,
+	registry metrics.Registry) (*bserverEndpoints, *TestClock) {
+	remote, err := rpc.ParsePrioritizedRoundRobinRemote(addrs)
+	require.NoError(t, err)
+	clock := newTestClockNow()
+	return newBServerEndpoints(
+		remote, logger.NewTestLogger(t), clock, registry), clock
+}
+
+func TestBServerEndpointsFailover(t *testing.T) {
+	be, _ := makeTestBServerEndpoints(t, "a:443,b:443;c:443", nil)
+	be.recordLatency("a:443", 100*time.Millisecond)
+	be.recordLatency("b:443", 10*time.Millisecond)
+	be.recordLatency("c:443", 1*time.Millisecond)
+
+	t.Log("The fastest endpoint of the first group wins")
+	r := be.newRemote()
+	require.Equal(t, "b:443", r.Peek())
+	require.Equal(t, "b:443", r.GetAddress())
+
+	t.Log("Failed endpoints are tried last")
+	require.Equal(t, "b:443", r.failCurrent())
+	r2 := be.newRemote()
+	require.Equal(t, "a:443", r2.GetAddress())
+	r2.failCurrent()
+	require.Equal(t, "c:443", r2.GetAddress())
+
+	t.Log("Each endpoint is tried once before starting over")
+	require.Equal(t, "b:443", r2.GetAddress())
+	require.Equal(t, "c:443", r2.GetAddress())
+
+	t.Log("A successful connection makes an endpoint healthy again")
+	r3 := be.newRemote()
+	require.Equal(t, "c:443", r3.GetAddress())
+	r3.GetAddress()
+	require.Equal(t, "a:443", r3.GetAddress())
+	r3.Reset()
+	require.Equal(t, "a:443", r3.GetAddress())
+}
+
+func TestBServerEndpointsProbe(t *testing.T) {
+	registry := metrics.NewRegistry()
+	be, clock := makeTestBServerEndpoints(t, "a:443,b:443", registry)
+	be.probe = func(ctx context.Context, addr string) error {
+		if addr == "a:443" {
+			return errors.New("unreachable")
+		}
+		return nil
+	}
+	be.probeAll(context.Background())
+
+	require.Equal(t, "b:443", be.best(nil))
+	timer := registry.Get("BlockServer.Endpoint.b:443.Latency").(metrics.Timer)
+	require.Equal(t, int64(1), timer.Count())
+	timer = registry.Get("BlockServer.Endpoint.a:443.Latency").(metrics.Timer)
+	require.Equal(t, int64(0), timer.Count())
+
+	t.Log("Endpoints are avoided only for a while after failing")
+	clock.Add(bserverEndpointRetryAfter - time.Second)
+	be.markFailed("b:443")
+	require.Equal(t, "b:443", be.best(nil))
+	clock.Add(time.Second)
+	require.Equal(t, "a:443", be.best(nil))
+}
@@ -460,7 +460,10 @@ func (b *BlockServerMemory) GetUserQuotaInfo(ctx context.Context) (info *kbfsblo
 	}
 
 	// Return a dummy value here.
-	return &kbfsblock.QuotaInfo{Limit: math.MaxInt64}, nil
+	return &kbfsblock.QuotaInfo{
+		Limit:    math.MaxInt64,
+		GitLimit: math.MaxInt64,
+	}, nil
 }
 
 // GetTeamQuotaInfo implements the BlockServer interface for BlockServerMemory.
@@ -474,5 +477,8 @@ func (b *BlockServerMemory) GetTeamQuotaInfo(
 	// TODO: check team membership and return error if not a reader?
 
 	// Return a dummy value here.
-	return &kbfsblock.QuotaInfo{Limit: math.MaxInt64}, nil
+	return &kbfsblock.QuotaInfo{
+		Limit:    math.MaxInt64,
+		GitLimit: math.MaxInt64,
+	}, nil
 }
@@ -0,0 +1,413 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	metrics "github.com/rcrowley/go-metrics"
+	"golang.org/x/net/context"
+)
+
+const (
+	// tlfDataRegionsFileName is the name of the file, under the
+	// storage root, where the data regions of TLFs are persisted,
+	// so that right after a restart, blocks can be read from the
+	// right server, including the ones holding the settings of
+	// each TLF.
+	tlfDataRegionsFileName = "tlf_data_regions.json"
+)
+
+// dataRegionRegexp matches the allowed names of data regions.
+var dataRegionRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// CheckDataRegion returns an error if `region` isn't a valid data
+// region name, i.e., up to 32 lowercase letters, digits and dashes.
+func CheckDataRegion(region string) error {
+	if !dataRegionRegexp.MatchString(region) {
+		return errors.Errorf("Invalid data region %q", region)
+	}
+	return nil
+}
+
+// ParseBServerRegions parses a "|"-separated list of data regions,
+// each like "region=addr", where addr is a block server address in
+// the format of the -bserver flag, e.g.
+// "eu=bserver-0.eu.example.com:443,bserver-1.eu.example.com:443".
+func ParseBServerRegions(s string) (map[string]string, error) {
+	regions := make(map[string]string)
+	for _, field := range strings.Split(s, "|") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		i := strings.Index(field, "=")
+		if i < 0 {
+			return nil, errors.Errorf(
+				"Bad block server region %q; it should look like "+
+					"region=host:port", field)
+		}
+		region, addr := field[:i], strings.TrimSpace(field[i+1:])
+		if err := CheckDataRegion(region); err != nil {
+			return nil, err
+		}
+		if addr == "" {
+			return nil, errors.Errorf(
+				"Empty block server address for region %q", region)
+		}
+		if _, ok := regions[region]; ok {
+			return nil, errors.Errorf("Duplicate data region %q", region)
+		}
+		regions[region] = addr
+	}
+	return regions, nil
+}
+
+type blockServerRegionsConfig interface {
+	logMaker
+	tlfDataRegionGetter
+}
+
+type bserverRegionMetrics struct {
+	puts       metrics.Meter
+	putBytes   metrics.Meter
+	refused    metrics.Meter
+	outOfPlace metrics.Meter
+}
+
+func newBServerRegionMetrics(
+	region string, registry metrics.Registry) bserverRegionMetrics {
+	if registry == nil {
+		return bserverRegionMetrics{
+			puts:       metrics.NilMeter{},
+			putBytes:   metrics.NilMeter{},
+			refused:    metrics.NilMeter{},
+			outOfPlace: metrics.NilMeter{},
+		}
+	}
+	prefix := "BlockServer.Region." + region + "."
+	return bserverRegionMetrics{
+		puts:       metrics.GetOrRegisterMeter(prefix+"Puts", registry),
+		putBytes:   metrics.GetOrRegisterMeter(prefix+"PutBytes", registry),
+		refused:    metrics.GetOrRegisterMeter(prefix+"RefusedPuts", registry),
+		outOfPlace: metrics.GetOrRegisterMeter(prefix+"OutOfRegion", registry),
+	}
+}
+
+// BlockServerRegions routes the blocks of each TLF to the block
+// server of the data region the TLF is constrained to (see
+// TlfSettings.DataRegion), and the blocks of all other TLFs to a
+// default block server.  It's for teams that must keep their data
+// in a given region, where the block service is deployed in that
+// region.
+//
+// Residency is enforced by the client: a block of a TLF is only put
+// once its region has been read from the TLF's settings, a block of
+// a constrained TLF is never put to any server but its region's, and
+// if this client doesn't know the region's server, the put fails
+// with a DataRegionUnavailableError rather than falling back to the
+// default server.  Blocks that were put before a TLF was constrained
+// stay where they are; they're read from the default server, and any
+// new references to them are added there, but each such access is
+// counted in the OutOfRegion meter of the region so that the data
+// can be found and migrated.
+type BlockServerRegions struct {
+	config   blockServerRegionsConfig
+	log      logger.Logger
+	delegate BlockServer
+	regions  map[string]BlockServer
+	metrics  map[string]bserverRegionMetrics
+	// unknown counts the refused puts of regions without a server.
+	unknown bserverRegionMetrics
+}
+
+var _ BlockServer = (*BlockServerRegions)(nil)
+
+// NewBlockServerRegions creates a new BlockServerRegions that sends
+// the blocks of unconstrained TLFs to `delegate`, and those of the
+// TLFs constrained to each region in `regions` to that region's
+// server.  If `registry` is non-nil, the puts of each region are
+// recorded in it.
+func NewBlockServerRegions(config blockServerRegionsConfig,
+	delegate BlockServer, regions map[string]BlockServer,
+	registry metrics.Registry) *BlockServerRegions {
+	m := make(map[string]bserverRegionMetrics, len(regions))
+	for region := range regions {
+		m[region] = newBServerRegionMetrics(region, registry)
+	}
+	return &BlockServerRegions{
+		config:   config,
+		log:      config.MakeLogger(""),
+		delegate: delegate,
+		regions:  regions,
+		metrics:  m,
+		unknown:  newBServerRegionMetrics("unknown", registry),
+	}
+}
+
+// Regions returns the names of the data regions this block server
+// can put blocks to, in sorted order.
+func (b *BlockServerRegions) Regions() []string {
+	regions := make([]string, 0, len(b.regions))
+	for region := range b.regions {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	return regions
+}
+
+// serverForRegion returns the server that holds the new blocks of
+// TLFs in the given data region, or nil if the region has no known
+// server.
+func (b *BlockServerRegions) serverForRegion(region string) BlockServer {
+	if region == "" {
+		return b.delegate
+	}
+	return b.regions[region]
+}
+
+// serverFor returns the name of the data region of the given TLF, as
+// last known, and the server that holds its new blocks, or nil if the
+// TLF is constrained to a region without a known server.
+func (b *BlockServerRegions) serverFor(tlfID tlf.ID) (string, BlockServer) {
+	region, _ := b.config.TlfDataRegion(tlfID)
+	if region == "" {
+		return "", b.delegate
+	}
+	return region, b.regions[region]
+}
+
+func (b *BlockServerRegions) metricsFor(region string) bserverRegionMetrics {
+	if m, ok := b.metrics[region]; ok {
+		return m
+	}
+	return b.unknown
+}
+
+// putServer returns the server to put a new block of the given TLF
+// to.  It waits until the TLF's data region has been read from its
+// settings, so that no block is put to the wrong server in the
+// meantime.
+func (b *BlockServerRegions) putServer(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, size int) (BlockServer, error) {
+	region, err := b.config.WaitForTlfDataRegion(ctx, tlfID)
+	if err != nil {
+		return nil, err
+	}
+	server := b.serverForRegion(region)
+	if region == "" {
+		return server, nil
+	}
+	m := b.metricsFor(region)
+	if server == nil {
+		m.refused.Mark(1)
+		b.log.CWarningf(ctx, "Refusing to put block %s of TLF %s, which "+
+			"must stay in data region %s, since no block server is "+
+			"configured for that region", id, tlfID, region)
+		return nil, DataRegionUnavailableError{tlfID, region}
+	}
+	m.puts.Mark(1)
+	m.putBytes.Mark(int64(size))
+	return server, nil
+}
+
+// isBlockNonExistentError returns true if `err` means that the block
+// isn't on the server that was asked for it.
+func isBlockNonExistentError(err error) bool {
+	_, ok := errors.Cause(err).(kbfsblock.ServerErrorBlockNonExistent)
+	return ok
+}
+
+// withFallback calls `f` with the server of the given TLF's region,
+// and then, if the block isn't there, with the default server.
+func (b *BlockServerRegions) withFallback(ctx context.Context,
+	tlfID tlf.ID, id kbfsblock.ID, f func(server BlockServer) error) error {
+	region, server := b.serverFor(tlfID)
+	if server == nil || server == b.delegate {
+		return f(b.delegate)
+	}
+	err := f(server)
+	if !isBlockNonExistentError(err) {
+		return err
+	}
+	b.log.CDebugf(ctx, "Block %s of TLF %s isn't in data region %s; "+
+		"trying the default block server", id, tlfID, region)
+	err = f(b.delegate)
+	if err == nil {
+		b.metricsFor(region).outOfPlace.Mark(1)
+	}
+	return err
+}
+
+// Get implements the BlockServer interface for BlockServerRegions.
+func (b *BlockServerRegions) Get(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, context kbfsblock.Context) (
+	buf []byte, serverHalf kbfscrypto.BlockCryptKeyServerHalf, err error) {
+	err = b.withFallback(ctx, tlfID, id, func(server BlockServer) error {
+		buf, serverHalf, err = server.Get(ctx, tlfID, id, context)
+		return err
+	})
+	if err != nil {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+	}
+	return buf, serverHalf, nil
+}
+
+// GetEncodedSize implements the BlockServer interface for
+// BlockServerRegions.
+func (b *BlockServerRegions) GetEncodedSize(
+	ctx context.Context, tlfID tlf.ID, id kbfsblock.ID,
+	context kbfsblock.Context) (
+	size uint32, status keybase1.BlockStatus, err error) {
+	err = b.withFallback(ctx, tlfID, id, func(server BlockServer) error {
+		size, status, err = server.GetEncodedSize(ctx, tlfID, id, context)
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return size, status, nil
+}
+
+// Put implements the BlockServer interface for BlockServerRegions.
+func (b *BlockServerRegions) Put(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, context kbfsblock.Context, buf []byte,
+	serverHalf kbfscrypto.BlockCryptKeyServerHalf) error {
+	server, err := b.putServer(ctx, tlfID, id, len(buf))
+	if err != nil {
+		return err
+	}
+	return server.Put(ctx, tlfID, id, context, buf, serverHalf)
+}
+
+// PutAgain implements the BlockServer interface for BlockServerRegions.
+func (b *BlockServerRegions) PutAgain(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, context kbfsblock.Context, buf []byte,
+	serverHalf kbfscrypto.BlockCryptKeyServerHalf) error {
+	server, err := b.putServer(ctx, tlfID, id, len(buf))
+	if err != nil {
+		return err
+	}
+	return server.PutAgain(ctx, tlfID, id, context, buf, serverHalf)
+}
+
+// AddBlockReference implements the BlockServer interface for
+// BlockServerRegions.
+func (b *BlockServerRegions) AddBlockReference(ctx context.Context,
+	tlfID tlf.ID, id kbfsblock.ID, context kbfsblock.Context) error {
+	return b.withFallback(ctx, tlfID, id, func(server BlockServer) error {
+		return server.AddBlockReference(ctx, tlfID, id, context)
+	})
+}
+
+// RemoveBlockReferences implements the BlockServer interface for
+// BlockServerRegions.  Since removing references to a block that
+// isn't on a server is a no-op, the references are removed from both
+// the region's server and the default one.
+func (b *BlockServerRegions) RemoveBlockReferences(ctx context.Context,
+	tlfID tlf.ID, contexts kbfsblock.ContextMap) (
+	liveCounts map[kbfsblock.ID]int, err error) {
+	_, server := b.serverFor(tlfID)
+	liveCounts, err = b.delegate.RemoveBlockReferences(ctx, tlfID, contexts)
+	if err != nil || server == nil || server == b.delegate {
+		return liveCounts, err
+	}
+	regionCounts, err := server.RemoveBlockReferences(ctx, tlfID, contexts)
+	if err != nil {
+		return nil, err
+	}
+	for id, count := range regionCounts {
+		liveCounts[id] += count
+	}
+	return liveCounts, nil
+}
+
+// ArchiveBlockReferences implements the BlockServer interface for
+// BlockServerRegions.
+func (b *BlockServerRegions) ArchiveBlockReferences(ctx context.Context,
+	tlfID tlf.ID, contexts kbfsblock.ContextMap) error {
+	_, server := b.serverFor(tlfID)
+	if server == nil || server == b.delegate {
+		return b.delegate.ArchiveBlockReferences(ctx, tlfID, contexts)
+	}
+	err := server.ArchiveBlockReferences(ctx, tlfID, contexts)
+	if !isBlockNonExistentError(err) {
+		return err
+	}
+	// Some of the blocks must be on the default server; archive
+	// them one by one.
+	for id, idContexts := range contexts {
+		err := b.withFallback(ctx, tlfID, id, func(server BlockServer) error {
+			return server.ArchiveBlockReferences(
+				ctx, tlfID, kbfsblock.ContextMap{id: idContexts})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsUnflushed implements the BlockServer interface for
+// BlockServerRegions.
+func (b *BlockServerRegions) IsUnflushed(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID) (bool, error) {
+	return b.delegate.IsUnflushed(ctx, tlfID, id)
+}
+
+// Shutdown implements the BlockServer interface for
+// BlockServerRegions.
+func (b *BlockServerRegions) Shutdown(ctx context.Context) {
+	b.delegate.Shutdown(ctx)
+	for _, server := range b.regions {
+		server.Shutdown(ctx)
+	}
+}
+
+// RefreshAuthToken implements the BlockServer interface for
+// BlockServerRegions.
+func (b *BlockServerRegions) RefreshAuthToken(ctx context.Context) {
+	b.delegate.RefreshAuthToken(ctx)
+	for _, server := range b.regions {
+		server.RefreshAuthToken(ctx)
+	}
+}
+
+// GetUserQuotaInfo implements the BlockServer interface for
+// BlockServerRegions.
+func (b *BlockServerRegions) GetUserQuotaInfo(ctx context.Context) (
+	info *kbfsblock.QuotaInfo, err error) {
+	return b.delegate.GetUserQuotaInfo(ctx)
+}
+
+// GetTeamQuotaInfo implements the BlockServer interface for
+// BlockServerRegions.
+func (b *BlockServerRegions) GetTeamQuotaInfo(
+	ctx context.Context, tid keybase1.TeamID) (
+	info *kbfsblock.QuotaInfo, err error) {
+	return b.delegate.GetTeamQuotaInfo(ctx, tid)
+}
+
+// ensureDataRegionLoaded reads the data region of the TLF from its
+// settings, if that hasn't been done yet in this process, so that
+// the blocks about to be put go to the right block server.  Later
+// changes are picked up by tlfSettingsLoop.
+func (fbo *folderBranchOps) ensureDataRegionLoaded(ctx context.Context) error {
+	if !fbo.config.DataRegionsEnforced() {
+		return nil
+	}
+	if _, loaded := fbo.config.TlfDataRegion(fbo.id()); loaded {
+		return nil
+	}
+	return fbo.reloadTlfSettings(ctx)
+}
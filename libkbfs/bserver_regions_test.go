@@ -0,0 +1,188 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+type testBServerRegionsConfig struct {
+	testLogMaker
+	regions map[tlf.ID]string
+	// loading holds channels that are closed once the regions of
+	// the TLFs are loaded; all other regions are already loaded.
+	loading map[tlf.ID]chan struct{}
+}
+
+func (c testBServerRegionsConfig) TlfDataRegion(tlfID tlf.ID) (
+	string, bool) {
+	return c.regions[tlfID], true
+}
+
+func (c testBServerRegionsConfig) WaitForTlfDataRegion(
+	ctx context.Context, tlfID tlf.ID) (string, error) {
+	if ch, ok := c.loading[tlfID]; ok {
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	return c.regions[tlfID], nil
+}
+
+func TestParseBServerRegions(t *testing.T) {
+	regions, err := ParseBServerRegions(
+		"eu=bserver-0.eu:443,bserver-1.eu:443;bserver-2.eu:443 | us=memory")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"eu": "bserver-0.eu:443,bserver-1.eu:443;bserver-2.eu:443",
+		"us": "memory",
+	}, regions)
+
+	regions, err = ParseBServerRegions("")
+	require.NoError(t, err)
+	require.Len(t, regions, 0)
+
+	for _, s := range []string{
+		"eu", "EU=memory", "eu=", "eu=memory|eu=memory", "=memory",
+	} {
+		_, err = ParseBServerRegions(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestBlockServerRegions(t *testing.T) {
+	ctx := context.Background()
+	log := logger.NewTestLogger(t)
+	def := NewBlockServerMemory(log)
+	eu := NewBlockServerMemory(log)
+	unconstrained := tlf.FakeID(1, tlf.Private)
+	euTlf := tlf.FakeID(2, tlf.Private)
+	usTlf := tlf.FakeID(3, tlf.Private)
+	loadingTlf := tlf.FakeID(4, tlf.Private)
+	loaded := make(chan struct{})
+	config := testBServerRegionsConfig{
+		newTestLogMaker(t), map[tlf.ID]string{},
+		map[tlf.ID]chan struct{}{loadingTlf: loaded}}
+	b := NewBlockServerRegions(
+		config, def, map[string]BlockServer{"eu": eu}, nil)
+	require.Equal(t, []string{"eu"}, b.Regions())
+
+	t.Log("Blocks of unconstrained TLFs go to the default server.")
+	bID, bCtx, data, serverHalf := makeTestShapedBlock(t, 100)
+	err := b.Put(ctx, unconstrained, bID, bCtx, data, serverHalf)
+	require.NoError(t, err)
+	_, _, err = def.Get(ctx, unconstrained, bID, bCtx)
+	require.NoError(t, err)
+
+	t.Log("Blocks put before a TLF was constrained stay readable.")
+	oldID, oldCtx, oldData, oldServerHalf := makeTestShapedBlock(t, 100)
+	err = b.Put(ctx, euTlf, oldID, oldCtx, oldData, oldServerHalf)
+	require.NoError(t, err)
+	config.regions[euTlf] = "eu"
+	buf, _, err := b.Get(ctx, euTlf, oldID, oldCtx)
+	require.NoError(t, err)
+	require.Equal(t, oldData, buf)
+
+	t.Log("New blocks of constrained TLFs only go to their region.")
+	bID, bCtx, data, serverHalf = makeTestShapedBlock(t, 100)
+	err = b.Put(ctx, euTlf, bID, bCtx, data, serverHalf)
+	require.NoError(t, err)
+	_, _, err = eu.Get(ctx, euTlf, bID, bCtx)
+	require.NoError(t, err)
+	_, _, err = def.Get(ctx, euTlf, bID, bCtx)
+	require.Error(t, err)
+	buf, _, err = b.Get(ctx, euTlf, bID, bCtx)
+	require.NoError(t, err)
+	require.Equal(t, data, buf)
+
+	t.Log("References are removed wherever the blocks are.")
+	liveCounts, err := b.RemoveBlockReferences(ctx, euTlf,
+		kbfsblock.ContextMap{bID: {bCtx}, oldID: {oldCtx}})
+	require.NoError(t, err)
+	require.Equal(t, 0, liveCounts[bID])
+	require.Equal(t, 0, liveCounts[oldID])
+
+	t.Log("Blocks of TLFs constrained to an unknown region are refused.")
+	config.regions[usTlf] = "us"
+	bID, bCtx, data, serverHalf = makeTestShapedBlock(t, 100)
+	err = b.Put(ctx, usTlf, bID, bCtx, data, serverHalf)
+	require.IsType(t, DataRegionUnavailableError{}, errors.Cause(err))
+	_, _, err = def.Get(ctx, usTlf, bID, bCtx)
+	require.Error(t, err)
+
+	t.Log("Puts wait until the region of the TLF is loaded.")
+	bID, bCtx, data, serverHalf = makeTestShapedBlock(t, 100)
+	putErr := make(chan error, 1)
+	go func() {
+		putErr <- b.Put(ctx, loadingTlf, bID, bCtx, data, serverHalf)
+	}()
+	select {
+	case err := <-putErr:
+		t.Fatalf("Put didn't wait for the region: %+v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	config.regions[loadingTlf] = "eu"
+	close(loaded)
+	err = <-putErr
+	require.NoError(t, err)
+	_, _, err = eu.Get(ctx, loadingTlf, bID, bCtx)
+	require.NoError(t, err)
+}
+
+func TestBlockServerRegionsFolderSettings(t *testing.T) {
+	config1 := MakeTestConfigOrBust(t, "alice")
+	ctx := BackgroundContextWithCancellationDelayer()
+	defer CleanupCancellationDelayer(ctx)
+	defer CheckConfigAndShutdown(ctx, t, config1)
+	def := config1.BlockServer()
+	eu := NewBlockServerMemory(config1.MakeLogger(""))
+	regions := map[string]BlockServer{"eu": eu}
+	config1.SetBlockServer(NewBlockServerRegions(config1, def, regions, nil))
+	config1.enableDataRegions()
+	// Avoid checking state, since blocks are in more than one server.
+	defer config1.MDServer().Shutdown()
+
+	rootNode := GetRootNodeOrBust(ctx, t, config1, "alice", tlf.Private)
+	tlfID := rootNode.GetFolderBranch().Tlf
+	err := WriteTlfSettings(ctx, config1.KBFSOps(), rootNode, TlfSettings{
+		Version:    TlfSettingsVersion,
+		DataRegion: "eu",
+	})
+	require.NoError(t, err)
+
+	t.Log("Another device reads the region before its first put.")
+	config2 := ConfigAsUser(config1, "alice")
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	config2.SetBlockServer(NewBlockServerRegions(config2, def, regions, nil))
+	config2.enableDataRegions()
+	defer config2.MDServer().Shutdown()
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, "alice", tlf.Private)
+	kbfsOps2 := config2.KBFSOps()
+	n, _, err := kbfsOps2.CreateFile(ctx, rootNode2, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps2.Write(ctx, n, []byte("hello"), 0)
+	require.NoError(t, err)
+	err = kbfsOps2.SyncAll(ctx, rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+	region, loaded := config2.TlfDataRegion(tlfID)
+	require.True(t, loaded)
+	require.Equal(t, "eu", region)
+
+	ptr := getOps(config2, tlfID).nodeCache.PathFromNode(n).tailPointer()
+	_, _, err = eu.Get(ctx, tlfID, ptr.ID, ptr.Context)
+	require.NoError(t, err)
+	_, _, err = def.Get(ctx, tlfID, ptr.ID, ptr.Context)
+	require.Error(t, err)
+}
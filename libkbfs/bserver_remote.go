@@ -16,6 +16,7 @@ import (
 	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfscrypto"
 	"github.com/keybase/kbfs/tlf"
+	metrics "github.com/rcrowley/go-metrics"
 	"golang.org/x/net/context"
 )
 
@@ -36,10 +37,12 @@ type blockServerRemoteClientHandler struct {
 	deferLog      logger.Logger
 	csg           CurrentSessionGetter
 	authToken     *kbfscrypto.AuthToken
-	srvRemote     rpc.Remote
+	srvRemote     *bserverEndpointsRemote
 	connOpts      rpc.ConnectionOpts
 	rpcLogFactory rpc.LogFactory
 	pinger        pinger
+	clientID      string
+	registry      metrics.Registry
 
 	connMu sync.RWMutex
 	conn   *rpc.Connection
@@ -47,11 +50,14 @@ type blockServerRemoteClientHandler struct {
 }
 
 func newBlockServerRemoteClientHandler(name string, log logger.Logger,
-	signer kbfscrypto.Signer, csg CurrentSessionGetter, srvRemote rpc.Remote,
-	rpcLogFactory rpc.LogFactory) *blockServerRemoteClientHandler {
+	signer kbfscrypto.Signer, csg CurrentSessionGetter,
+	srvRemote *bserverEndpointsRemote, rpcLogFactory rpc.LogFactory,
+	clientID string, registry metrics.Registry) *blockServerRemoteClientHandler {
 	deferLog := log.CloneWithAddedDepth(1)
 	b := &blockServerRemoteClientHandler{
 		name:          name,
+		clientID:      clientID,
+		registry:      registry,
 		log:           log,
 		deferLog:      deferLog,
 		csg:           csg,
@@ -68,7 +74,8 @@ func newBlockServerRemoteClientHandler(name string, log logger.Logger,
 
 	b.authToken = kbfscrypto.NewAuthToken(
 		signer, kbfsblock.ServerTokenServer, kbfsblock.ServerTokenExpireIn,
-		"libkbfs_bserver_remote", VersionString(), b)
+		authTokenSubmoduleName("libkbfs_bserver_remote", clientID),
+		VersionString(), b)
 
 	constBackoff := backoff.NewConstantBackOff(RPCReconnectInterval)
 	b.connOpts = rpc.ConnectionOpts{
@@ -97,7 +104,8 @@ func (b *blockServerRemoteClientHandler) initNewConnection() {
 		kbfsblock.ServerErrorUnwrapper{}, b, b.rpcLogFactory,
 		logger.LogOutputWithDepthAdder{Logger: b.log},
 		rpc.DefaultMaxFrameLength, b.connOpts)
-	b.client = keybase1.BlockClient{Cli: b.conn.GetClient()}
+	b.client = keybase1.BlockClient{Cli: wrapClientWithID(
+		b.conn.GetClient(), b.clientID, "BlockServer", b.registry)}
 }
 
 func (b *blockServerRemoteClientHandler) reconnect() error {
@@ -132,6 +140,16 @@ func (b *blockServerRemoteClientHandler) shutdown() {
 	b.pinger.cancelTicker()
 }
 
+// disconnect closes the connection, and replaces it with one that
+// connects again on demand.
+func (b *blockServerRemoteClientHandler) disconnect() {
+	if b.getConn() == nil {
+		return
+	}
+	b.pinger.cancelTicker()
+	b.initNewConnection()
+}
+
 func (b *blockServerRemoteClientHandler) getConn() *rpc.Connection {
 	b.connMu.RLock()
 	defer b.connMu.RUnlock()
@@ -210,7 +228,8 @@ func (b *blockServerRemoteClientHandler) HandlerName() string {
 func (b *blockServerRemoteClientHandler) OnConnect(ctx context.Context,
 	conn *rpc.Connection, client rpc.GenericClient, _ *rpc.Server) error {
 	// reset auth -- using client here would cause problematic recursion.
-	c := keybase1.BlockClient{Cli: client}
+	c := keybase1.BlockClient{Cli: wrapClientWithID(
+		client, b.clientID, "BlockServer", b.registry)}
 	err := b.resetAuth(ctx, c)
 	if err != nil {
 		return err
@@ -223,7 +242,9 @@ func (b *blockServerRemoteClientHandler) OnConnect(ctx context.Context,
 
 // OnConnectError implements the ConnectionHandler interface.
 func (b *blockServerRemoteClientHandler) OnConnectError(err error, wait time.Duration) {
-	b.log.Warning("%s: connection error: %v; retrying in %s", b.name, err, wait)
+	addr := b.srvRemote.failCurrent()
+	b.log.Warning("%s: connection error to %s: %v; retrying in %s",
+		b.name, addr, err, wait)
 	if b.authToken != nil {
 		b.authToken.Shutdown()
 	}
@@ -273,8 +294,9 @@ var _ rpc.ConnectionHandler = (*blockServerRemoteClientHandler)(nil)
 func (b *blockServerRemoteClientHandler) pingOnce(ctx context.Context) {
 	_, err := b.getClient().BlockPing(ctx)
 	if err == context.DeadlineExceeded {
-		b.log.CDebugf(
-			ctx, "%s: Ping timeout -- reinitializing connection", b.name)
+		addr := b.srvRemote.failCurrent()
+		b.log.CDebugf(ctx, "%s: Ping timeout to %s -- reinitializing "+
+			"connection", b.name, addr)
 		if err = b.reconnect(); err != nil {
 			b.log.CDebugf(ctx, "reconnect error: %v", err)
 		}
@@ -289,6 +311,9 @@ type blockServerRemoteConfig interface {
 	signerGetter
 	currentSessionGetterGetter
 	logMaker
+	clientIDGetter
+	clockGetter
+	MetricsRegistry() metrics.Registry
 }
 
 // BlockServerRemote implements the BlockServer interface and
@@ -299,9 +324,14 @@ type BlockServerRemote struct {
 	log          traceLogger
 	deferLog     traceLogger
 	blkSrvRemote rpc.Remote
+	endpoints    *bserverEndpoints
 
 	putConn *blockServerRemoteClientHandler
 	getConn *blockServerRemoteClientHandler
+	// flushConn carries the writes of background journal flushes,
+	// so that a big flush doesn't hold up interactive writes (e.g.,
+	// of folders that aren't journaled) on putConn.
+	flushConn *blockServerRemoteClientHandler
 }
 
 // Test that BlockServerRemote fully implements the BlockServer interface.
@@ -318,6 +348,8 @@ func NewBlockServerRemote(config blockServerRemoteConfig,
 		log:          traceLogger{log},
 		deferLog:     traceLogger{deferLog},
 		blkSrvRemote: blkSrvRemote,
+		endpoints: newBServerEndpoints(
+			blkSrvRemote, log, config.Clock(), config.MetricsRegistry()),
 	}
 	// Use two separate auth clients -- one for writes and one for
 	// reads.  This allows small reads to avoid getting trapped behind
@@ -325,14 +357,23 @@ func NewBlockServerRemote(config blockServerRemoteConfig,
 	// achieve better prioritization within the actual network.
 	bs.putConn = newBlockServerRemoteClientHandler(
 		"BlockServerRemotePut", log, config.Signer(),
-		config.CurrentSessionGetter(), blkSrvRemote, rpcLogFactory)
+		config.CurrentSessionGetter(), bs.endpoints.newRemote(),
+		rpcLogFactory, config.ClientID(), config.MetricsRegistry())
 	bs.getConn = newBlockServerRemoteClientHandler(
 		"BlockServerRemoteGet", log, config.Signer(),
-		config.CurrentSessionGetter(), blkSrvRemote, rpcLogFactory)
+		config.CurrentSessionGetter(), bs.endpoints.newRemote(),
+		rpcLogFactory, config.ClientID(), config.MetricsRegistry())
+	bs.flushConn = newBlockServerRemoteClientHandler(
+		"BlockServerRemoteFlush", log, config.Signer(),
+		config.CurrentSessionGetter(), bs.endpoints.newRemote(),
+		rpcLogFactory, config.ClientID(), config.MetricsRegistry())
+	bs.endpoints.startProbing()
 
 	bs.shutdownFn = func() {
+		bs.endpoints.shutdown()
 		bs.putConn.shutdown()
 		bs.getConn.shutdown()
+		bs.flushConn.shutdown()
 	}
 	return bs
 }
@@ -356,6 +397,11 @@ func newBlockServerRemoteWithClient(config blockServerRemoteConfig,
 			deferLog: deferLog,
 			client:   client,
 		},
+		flushConn: &blockServerRemoteClientHandler{
+			log:      log,
+			deferLog: deferLog,
+			client:   client,
+		},
 	}
 	return bs
 }
@@ -369,6 +415,16 @@ func (b *BlockServerRemote) RemoteAddress() string {
 func (b *BlockServerRemote) RefreshAuthToken(ctx context.Context) {
 	b.putConn.RefreshAuthToken(ctx)
 	b.getConn.RefreshAuthToken(ctx)
+	b.flushConn.RefreshAuthToken(ctx)
+}
+
+// putClient returns the client to use for writes made under `ctx`.
+func (b *BlockServerRemote) putClient(
+	ctx context.Context) keybase1.BlockInterface {
+	if isBackgroundFlush(ctx) {
+		return b.flushConn.getClient()
+	}
+	return b.putConn.getClient()
 }
 
 // Get implements the BlockServer interface for BlockServerRemote.
@@ -458,7 +514,7 @@ func (b *BlockServerRemote) Put(ctx context.Context, tlfID tlf.ID, id kbfsblock.
 
 	arg := kbfsblock.MakePutBlockArg(tlfID, id, bContext, buf, serverHalf)
 	// Handle OverQuota errors at the caller
-	return b.putConn.getClient().PutBlock(ctx, arg)
+	return b.putClient(ctx).PutBlock(ctx, arg)
 }
 
 // PutAgain implements the BlockServer interface for BlockServerRemote
@@ -486,7 +542,7 @@ func (b *BlockServerRemote) PutAgain(ctx context.Context, tlfID tlf.ID, id kbfsb
 
 	arg := kbfsblock.MakePutBlockAgainArg(tlfID, id, bContext, buf, serverHalf)
 	// Handle OverQuota errors at the caller
-	return b.putConn.getClient().PutBlockAgain(ctx, arg)
+	return b.putClient(ctx).PutBlockAgain(ctx, arg)
 }
 
 // AddBlockReference implements the BlockServer interface for BlockServerRemote
@@ -509,7 +565,7 @@ func (b *BlockServerRemote) AddBlockReference(ctx context.Context, tlfID tlf.ID,
 
 	arg := kbfsblock.MakeAddReferenceArg(tlfID, id, context)
 	// Handle OverQuota errors at the caller
-	return b.putConn.getClient().AddReference(ctx, arg)
+	return b.putClient(ctx).AddReference(ctx, arg)
 }
 
 // RemoveBlockReferences implements the BlockServer interface for
@@ -527,7 +583,7 @@ func (b *BlockServerRemote) RemoveBlockReferences(ctx context.Context,
 			b.deferLog.CDebugf(ctx, "RemoveBlockReferences batch size=%d", len(contexts))
 		}
 	}()
-	doneRefs, err := kbfsblock.BatchDowngradeReferences(ctx, b.log, tlfID, contexts, false, b.putConn.getClient())
+	doneRefs, err := kbfsblock.BatchDowngradeReferences(ctx, b.log, tlfID, contexts, false, b.putClient(ctx))
 	return kbfsblock.GetLiveCounts(doneRefs), err
 }
 
@@ -545,7 +601,7 @@ func (b *BlockServerRemote) ArchiveBlockReferences(ctx context.Context,
 			b.deferLog.CDebugf(ctx, "ArchiveBlockReferences batch size=%d", len(contexts))
 		}
 	}()
-	_, err = kbfsblock.BatchDowngradeReferences(ctx, b.log, tlfID, contexts, true, b.putConn.getClient())
+	_, err = kbfsblock.BatchDowngradeReferences(ctx, b.log, tlfID, contexts, true, b.putClient(ctx))
 	return err
 }
 
@@ -580,6 +636,15 @@ func (b *BlockServerRemote) GetTeamQuotaInfo(
 	return kbfsblock.ParseGetQuotaInfoRes(b.config.Codec(), res, err)
 }
 
+// dropIdleConnections closes the connections used for reads and
+// interactive writes; they reconnect on the next request.  The
+// connection for background journal flushes is left alone, since
+// flushes can go on while the user is idle.
+func (b *BlockServerRemote) dropIdleConnections() {
+	b.getConn.disconnect()
+	b.putConn.disconnect()
+}
+
 // Shutdown implements the BlockServer interface for BlockServerRemote.
 func (b *BlockServerRemote) Shutdown(ctx context.Context) {
 	if b.shutdownFn != nil {
@@ -587,4 +652,5 @@ func (b *BlockServerRemote) Shutdown(ctx context.Context) {
 	}
 	b.getConn.shutdown()
 	b.putConn.shutdown()
+	b.flushConn.shutdown()
 }
@@ -12,6 +12,7 @@ import (
 	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfscrypto"
 	"github.com/keybase/kbfs/tlf"
+	metrics "github.com/rcrowley/go-metrics"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/net/context"
 )
@@ -87,6 +88,18 @@ func (c testBlockServerRemoteConfig) DiskBlockCache() DiskBlockCache {
 	return c.diskBlockCache
 }
 
+func (c testBlockServerRemoteConfig) MetricsRegistry() metrics.Registry {
+	return nil
+}
+
+func (c testBlockServerRemoteConfig) ClientID() string {
+	return ""
+}
+
+func (c testBlockServerRemoteConfig) Clock() Clock {
+	return wallClock{}
+}
+
 // Test that putting a block, and getting it back, works
 func TestBServerRemotePutAndGet(t *testing.T) {
 	currentUID := keybase1.MakeTestUID(1)
@@ -153,3 +166,41 @@ func TestBServerRemotePutCanceled(t *testing.T) {
 	}
 	testRPCWithCanceledContext(t, serverConn, f)
 }
+
+// Test that the writes of background journal flushes go over their
+// own connection.
+func TestBServerRemoteBackgroundFlushConn(t *testing.T) {
+	currentUID := keybase1.MakeTestUID(1)
+	fc := fakeBServerClient{
+		entries: make(map[keybase1.BlockIdCombo]fakeBlockEntry),
+	}
+	flushFC := fakeBServerClient{
+		entries: make(map[keybase1.BlockIdCombo]fakeBlockEntry),
+	}
+	config := testBlockServerRemoteConfig{newTestCodecGetter(),
+		newTestLogMaker(t), nil, nil, nil}
+	b := newBlockServerRemoteWithClient(config, &fc)
+	b.flushConn.client = &flushFC
+
+	tlfID := tlf.FakeID(2, tlf.Private)
+	bCtx := kbfsblock.MakeFirstContext(
+		currentUID.AsUserOrTeam(), keybase1.BlockType_DATA)
+	serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+	require.NoError(t, err)
+	put := func(ctx context.Context, data []byte) {
+		bID, err := kbfsblock.MakePermanentID(
+			data, kbfscrypto.EncryptionSecretbox)
+		require.NoError(t, err)
+		err = b.Put(ctx, tlfID, bID, bCtx, data, serverHalf)
+		require.NoError(t, err)
+	}
+
+	ctx := context.Background()
+	put(ctx, []byte{1})
+	require.Len(t, fc.entries, 1)
+	require.Len(t, flushFC.entries, 0)
+
+	put(withBackgroundFlush(ctx, nil), []byte{2})
+	require.Len(t, fc.entries, 1)
+	require.Len(t, flushFC.entries, 1)
+}
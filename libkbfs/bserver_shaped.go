@@ -0,0 +1,380 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+// LatencyDistribution draws the latency of a single block server
+// call.
+type LatencyDistribution interface {
+	// Sample returns a latency, using r as its only source of
+	// randomness.
+	Sample(r *rand.Rand) time.Duration
+}
+
+// ConstantLatency is a LatencyDistribution that always returns the
+// same latency.
+type ConstantLatency time.Duration
+
+// Sample implements the LatencyDistribution interface for
+// ConstantLatency.
+func (l ConstantLatency) Sample(_ *rand.Rand) time.Duration {
+	return time.Duration(l)
+}
+
+// UniformLatency is a LatencyDistribution that draws latencies
+// uniformly from [Min, Max).
+type UniformLatency struct {
+	Min, Max time.Duration
+}
+
+// Sample implements the LatencyDistribution interface for
+// UniformLatency.
+func (l UniformLatency) Sample(r *rand.Rand) time.Duration {
+	if l.Max <= l.Min {
+		return l.Min
+	}
+	return l.Min + time.Duration(r.Int63n(int64(l.Max-l.Min)))
+}
+
+// NormalLatency is a LatencyDistribution that draws latencies from a
+// normal distribution, clamped at zero.
+type NormalLatency struct {
+	Mean, StdDev time.Duration
+}
+
+// Sample implements the LatencyDistribution interface for
+// NormalLatency.
+func (l NormalLatency) Sample(r *rand.Rand) time.Duration {
+	d := l.Mean + time.Duration(r.NormFloat64()*float64(l.StdDev))
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// LogNormalLatency is a LatencyDistribution that draws latencies
+// from a log-normal distribution with the given median.  Its long
+// tail models the occasional very slow request of real networks;
+// Sigma, the standard deviation of the latency's logarithm, sets how
+// long that tail is.
+type LogNormalLatency struct {
+	Median time.Duration
+	Sigma  float64
+}
+
+// Sample implements the LatencyDistribution interface for
+// LogNormalLatency.
+func (l LogNormalLatency) Sample(r *rand.Rand) time.Duration {
+	return time.Duration(
+		float64(l.Median) * math.Exp(r.NormFloat64()*l.Sigma))
+}
+
+// NetworkProfile describes the network between a client and the
+// block server, for BlockServerShaped.
+type NetworkProfile struct {
+	// Latency is the distribution of the round-trip time added to
+	// every call.  If nil, calls have no added latency.
+	Latency LatencyDistribution
+	// DownBytesPerSec and UpBytesPerSec cap the rate at which block
+	// data is fetched from and put to the server, respectively.
+	// Concurrent calls share the cap.  Zero means no cap.
+	DownBytesPerSec int64
+	UpBytesPerSec   int64
+	// ErrorRate is the probability that a call fails with a
+	// throttle error, without reaching the server.
+	ErrorRate float64
+}
+
+// networkProfiles are the standard profiles that can be looked up by
+// name with NetworkProfileByName.
+var networkProfiles = map[string]NetworkProfile{
+	"lan": {
+		Latency: NormalLatency{
+			Mean: time.Millisecond, StdDev: 200 * time.Microsecond},
+		DownBytesPerSec: 100 * 1024 * 1024,
+		UpBytesPerSec:   100 * 1024 * 1024,
+	},
+	"broadband": {
+		Latency:         LogNormalLatency{Median: 30 * time.Millisecond, Sigma: 0.3},
+		DownBytesPerSec: 12 * 1024 * 1024,
+		UpBytesPerSec:   1024 * 1024,
+	},
+	"mobile": {
+		Latency:         LogNormalLatency{Median: 100 * time.Millisecond, Sigma: 0.6},
+		DownBytesPerSec: 1024 * 1024,
+		UpBytesPerSec:   256 * 1024,
+		ErrorRate:       0.01,
+	},
+}
+
+// NetworkProfileByName returns the standard network profile with the
+// given name: "lan", "broadband" or "mobile".
+func NetworkProfileByName(name string) (NetworkProfile, error) {
+	profile, ok := networkProfiles[strings.ToLower(name)]
+	if !ok {
+		names := make([]string, 0, len(networkProfiles))
+		for n := range networkProfiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return NetworkProfile{}, fmt.Errorf(
+			"Unknown network profile %q; must be one of %s",
+			name, strings.Join(names, ", "))
+	}
+	return profile, nil
+}
+
+// BlockServerShaped delegates to another BlockServer instance, but
+// first makes each call wait as if it went over the network
+// described by its NetworkProfile, and fails some of them.  It's
+// meant for evaluating performance work against realistic networks,
+// on top of a local block server.
+//
+// All of its randomness comes from the seed it's made with, so a run
+// that makes its calls in the same order sees the same latencies and
+// errors.
+type BlockServerShaped struct {
+	delegate BlockServer
+	profile  NetworkProfile
+
+	lock sync.Mutex
+	r    *rand.Rand
+	// downIdle and upIdle are when the downlink and uplink finish
+	// sending all the data queued on them so far.
+	downIdle time.Time
+	upIdle   time.Time
+}
+
+var _ blockServerLocal = (*BlockServerShaped)(nil)
+
+// NewBlockServerShaped creates and returns a new BlockServerShaped
+// instance with the given delegate and network profile, drawing its
+// randomness from the given seed.
+func NewBlockServerShaped(delegate BlockServer, profile NetworkProfile,
+	seed int64) *BlockServerShaped {
+	return &BlockServerShaped{
+		delegate: delegate,
+		profile:  profile,
+		r:        rand.New(rand.NewSource(seed)),
+	}
+}
+
+// reserveLocked queues size bytes on the link that's next idle at
+// *idle, and returns how long from now they'll take to get through.
+func (b *BlockServerShaped) reserveLocked(
+	now time.Time, idle *time.Time, bytesPerSec int64, size int) time.Duration {
+	if bytesPerSec <= 0 || size == 0 {
+		return 0
+	}
+	start := *idle
+	if start.Before(now) {
+		start = now
+	}
+	*idle = start.Add(
+		time.Duration(int64(size) * int64(time.Second) / bytesPerSec))
+	return idle.Sub(now)
+}
+
+// shape waits out the latency of a call, and the time it takes to
+// send upSize bytes, and returns an error if the call should fail.
+func (b *BlockServerShaped) shape(
+	ctx context.Context, name string, upSize int) error {
+	b.lock.Lock()
+	var delay time.Duration
+	if b.profile.Latency != nil {
+		delay = b.profile.Latency.Sample(b.r)
+	}
+	fail := b.profile.ErrorRate > 0 && b.r.Float64() < b.profile.ErrorRate
+	upDelay := b.reserveLocked(
+		time.Now(), &b.upIdle, b.profile.UpBytesPerSec, upSize)
+	b.lock.Unlock()
+
+	if upDelay > delay {
+		delay = upDelay
+	}
+	if err := sleepWithContext(ctx, delay); err != nil {
+		return err
+	}
+	if fail {
+		return kbfsblock.ServerErrorThrottle{
+			Msg: fmt.Sprintf("%s failed by BlockServerShaped", name)}
+	}
+	return nil
+}
+
+// shapeDown waits out the time it takes to receive downSize bytes.
+func (b *BlockServerShaped) shapeDown(
+	ctx context.Context, downSize int) error {
+	b.lock.Lock()
+	delay := b.reserveLocked(
+		time.Now(), &b.downIdle, b.profile.DownBytesPerSec, downSize)
+	b.lock.Unlock()
+	return sleepWithContext(ctx, delay)
+}
+
+func sleepWithContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Get implements the BlockServer interface for BlockServerShaped.
+func (b *BlockServerShaped) Get(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, context kbfsblock.Context) (
+	buf []byte, serverHalf kbfscrypto.BlockCryptKeyServerHalf, err error) {
+	if err := b.shape(ctx, "Get", 0); err != nil {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+	}
+	buf, serverHalf, err = b.delegate.Get(ctx, tlfID, id, context)
+	if err != nil {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+	}
+	if err := b.shapeDown(ctx, len(buf)); err != nil {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+	}
+	return buf, serverHalf, nil
+}
+
+// GetEncodedSize implements the BlockServer interface for
+// BlockServerShaped.
+func (b *BlockServerShaped) GetEncodedSize(
+	ctx context.Context, tlfID tlf.ID, id kbfsblock.ID,
+	context kbfsblock.Context) (
+	size uint32, status keybase1.BlockStatus, err error) {
+	if err := b.shape(ctx, "GetEncodedSize", 0); err != nil {
+		return 0, 0, err
+	}
+	return b.delegate.GetEncodedSize(ctx, tlfID, id, context)
+}
+
+// Put implements the BlockServer interface for BlockServerShaped.
+func (b *BlockServerShaped) Put(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, context kbfsblock.Context, buf []byte,
+	serverHalf kbfscrypto.BlockCryptKeyServerHalf) error {
+	if err := b.shape(ctx, "Put", len(buf)); err != nil {
+		return err
+	}
+	return b.delegate.Put(ctx, tlfID, id, context, buf, serverHalf)
+}
+
+// PutAgain implements the BlockServer interface for BlockServerShaped.
+func (b *BlockServerShaped) PutAgain(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID, context kbfsblock.Context, buf []byte,
+	serverHalf kbfscrypto.BlockCryptKeyServerHalf) error {
+	if err := b.shape(ctx, "PutAgain", len(buf)); err != nil {
+		return err
+	}
+	return b.delegate.PutAgain(ctx, tlfID, id, context, buf, serverHalf)
+}
+
+// AddBlockReference implements the BlockServer interface for
+// BlockServerShaped.
+func (b *BlockServerShaped) AddBlockReference(ctx context.Context,
+	tlfID tlf.ID, id kbfsblock.ID, context kbfsblock.Context) error {
+	if err := b.shape(ctx, "AddBlockReference", 0); err != nil {
+		return err
+	}
+	return b.delegate.AddBlockReference(ctx, tlfID, id, context)
+}
+
+// RemoveBlockReferences implements the BlockServer interface for
+// BlockServerShaped.
+func (b *BlockServerShaped) RemoveBlockReferences(ctx context.Context,
+	tlfID tlf.ID, contexts kbfsblock.ContextMap) (
+	liveCounts map[kbfsblock.ID]int, err error) {
+	if err := b.shape(ctx, "RemoveBlockReferences", 0); err != nil {
+		return nil, err
+	}
+	return b.delegate.RemoveBlockReferences(ctx, tlfID, contexts)
+}
+
+// ArchiveBlockReferences implements the BlockServer interface for
+// BlockServerShaped.
+func (b *BlockServerShaped) ArchiveBlockReferences(ctx context.Context,
+	tlfID tlf.ID, contexts kbfsblock.ContextMap) error {
+	if err := b.shape(ctx, "ArchiveBlockReferences", 0); err != nil {
+		return err
+	}
+	return b.delegate.ArchiveBlockReferences(ctx, tlfID, contexts)
+}
+
+// IsUnflushed implements the BlockServer interface for
+// BlockServerShaped.
+func (b *BlockServerShaped) IsUnflushed(ctx context.Context, tlfID tlf.ID,
+	id kbfsblock.ID) (bool, error) {
+	if err := b.shape(ctx, "IsUnflushed", 0); err != nil {
+		return false, err
+	}
+	return b.delegate.IsUnflushed(ctx, tlfID, id)
+}
+
+// Shutdown implements the BlockServer interface for
+// BlockServerShaped.
+func (b *BlockServerShaped) Shutdown(ctx context.Context) {
+	b.delegate.Shutdown(ctx)
+}
+
+// RefreshAuthToken implements the BlockServer interface for
+// BlockServerShaped.
+func (b *BlockServerShaped) RefreshAuthToken(ctx context.Context) {
+	b.delegate.RefreshAuthToken(ctx)
+}
+
+// GetUserQuotaInfo implements the BlockServer interface for
+// BlockServerShaped.
+func (b *BlockServerShaped) GetUserQuotaInfo(ctx context.Context) (
+	info *kbfsblock.QuotaInfo, err error) {
+	if err := b.shape(ctx, "GetUserQuotaInfo", 0); err != nil {
+		return nil, err
+	}
+	return b.delegate.GetUserQuotaInfo(ctx)
+}
+
+// GetTeamQuotaInfo implements the BlockServer interface for
+// BlockServerShaped.
+func (b *BlockServerShaped) GetTeamQuotaInfo(
+	ctx context.Context, tid keybase1.TeamID) (
+	info *kbfsblock.QuotaInfo, err error) {
+	if err := b.shape(ctx, "GetTeamQuotaInfo", 0); err != nil {
+		return nil, err
+	}
+	return b.delegate.GetTeamQuotaInfo(ctx, tid)
+}
+
+// getAllRefsForTest implements the blockServerLocal interface for
+// BlockServerShaped, if its delegate implements it too.  It isn't
+// shaped, so that checks of the server's state don't fail randomly.
+func (b *BlockServerShaped) getAllRefsForTest(
+	ctx context.Context, tlfID tlf.ID) (map[kbfsblock.ID]blockRefMap, error) {
+	local, ok := b.delegate.(blockServerLocal)
+	if !ok {
+		return nil, fmt.Errorf(
+			"Block server %T can't list its refs", b.delegate)
+	}
+	return local.getAllRefsForTest(ctx, tlfID)
+}
@@ -0,0 +1,176 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func makeTestShapedBlock(t *testing.T, size int) (
+	kbfsblock.ID, kbfsblock.Context, []byte,
+	kbfscrypto.BlockCryptKeyServerHalf) {
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	bID, err := kbfsblock.MakePermanentID(data, kbfscrypto.EncryptionSecretbox)
+	require.NoError(t, err)
+	uid := keybase1.MakeTestUID(1)
+	bCtx := kbfsblock.MakeFirstContext(
+		uid.AsUserOrTeam(), keybase1.BlockType_DATA)
+	serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+	require.NoError(t, err)
+	return bID, bCtx, data, serverHalf
+}
+
+func TestBlockServerShapedLatency(t *testing.T) {
+	ctx := context.Background()
+	latency := 20 * time.Millisecond
+	b := NewBlockServerShaped(NewBlockServerMemory(logger.NewTestLogger(t)),
+		NetworkProfile{Latency: ConstantLatency(latency)}, 1)
+	tlfID := tlf.FakeID(1, tlf.Private)
+	bID, bCtx, data, serverHalf := makeTestShapedBlock(t, 100)
+
+	start := time.Now()
+	err := b.Put(ctx, tlfID, bID, bCtx, data, serverHalf)
+	require.NoError(t, err)
+	require.True(t, time.Since(start) >= latency)
+
+	start = time.Now()
+	buf, gotServerHalf, err := b.Get(ctx, tlfID, bID, bCtx)
+	require.NoError(t, err)
+	require.True(t, time.Since(start) >= latency)
+	require.Equal(t, data, buf)
+	require.Equal(t, serverHalf, gotServerHalf)
+}
+
+func TestBlockServerShapedBandwidth(t *testing.T) {
+	ctx := context.Background()
+	b := NewBlockServerShaped(NewBlockServerMemory(logger.NewTestLogger(t)),
+		NetworkProfile{UpBytesPerSec: 100 * 1024, DownBytesPerSec: 200 * 1024},
+		1)
+	tlfID := tlf.FakeID(1, tlf.Private)
+
+	t.Log("Concurrent puts share the uplink")
+	start := time.Now()
+	ids := make([]kbfsblock.ID, 2)
+	ctxs := make([]kbfsblock.Context, 2)
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i := range ids {
+		var data []byte
+		var serverHalf kbfscrypto.BlockCryptKeyServerHalf
+		ids[i], ctxs[i], data, serverHalf = makeTestShapedBlock(t, 5*1024)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- b.Put(ctx, tlfID, ids[i], ctxs[i], data, serverHalf)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.True(t, time.Since(start) >= 100*time.Millisecond)
+
+	t.Log("Gets are capped by the downlink")
+	start = time.Now()
+	for i := range ids {
+		_, _, err := b.Get(ctx, tlfID, ids[i], ctxs[i])
+		require.NoError(t, err)
+	}
+	require.True(t, time.Since(start) >= 50*time.Millisecond)
+}
+
+func TestBlockServerShapedErrors(t *testing.T) {
+	ctx := context.Background()
+	tlfID := tlf.FakeID(1, tlf.Private)
+	bID, bCtx, data, serverHalf := makeTestShapedBlock(t, 100)
+
+	t.Log("A failed put doesn't reach the server")
+	bserver := NewBlockServerMemory(logger.NewTestLogger(t))
+	b := NewBlockServerShaped(bserver, NetworkProfile{ErrorRate: 1}, 1)
+	err := b.Put(ctx, tlfID, bID, bCtx, data, serverHalf)
+	require.True(t, kbfsblock.IsThrottleError(err))
+	_, _, err = bserver.Get(ctx, tlfID, bID, bCtx)
+	require.IsType(t, kbfsblock.ServerErrorBlockNonExistent{}, err)
+
+	t.Log("The same seed fails the same calls")
+	failures := func(seed int64) (failed []int) {
+		b := NewBlockServerShaped(bserver, NetworkProfile{ErrorRate: 0.5}, seed)
+		for i := 0; i < 20; i++ {
+			if _, err := b.IsUnflushed(ctx, tlfID, bID); err != nil {
+				require.True(t, kbfsblock.IsThrottleError(err))
+				failed = append(failed, i)
+			}
+		}
+		return failed
+	}
+	failed := failures(1)
+	require.NotEmpty(t, failed)
+	require.NotEqual(t, 20, len(failed))
+	require.Equal(t, failed, failures(1))
+}
+
+func TestBlockServerShapedCanceled(t *testing.T) {
+	b := NewBlockServerShaped(NewBlockServerMemory(logger.NewTestLogger(t)),
+		NetworkProfile{Latency: ConstantLatency(time.Hour)}, 1)
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := b.GetUserQuotaInfo(ctx)
+	require.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestLatencyDistributions(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	const n = 1001
+	samples := func(l LatencyDistribution) []time.Duration {
+		s := make([]time.Duration, n)
+		for i := range s {
+			s[i] = l.Sample(r)
+		}
+		sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+		return s
+	}
+
+	s := samples(ConstantLatency(time.Second))
+	require.Equal(t, time.Second, s[0])
+	require.Equal(t, time.Second, s[n-1])
+
+	s = samples(UniformLatency{Min: time.Second, Max: 2 * time.Second})
+	require.True(t, s[0] >= time.Second)
+	require.True(t, s[n-1] < 2*time.Second)
+
+	s = samples(NormalLatency{Mean: time.Millisecond, StdDev: time.Second})
+	require.Equal(t, time.Duration(0), s[0])
+
+	s = samples(LogNormalLatency{Median: time.Second, Sigma: 0.5})
+	require.True(t, s[0] > 0)
+	require.InEpsilon(t, float64(time.Second), float64(s[n/2]), 0.1)
+	require.True(t, s[n-1] > 2*time.Second)
+}
+
+func TestNetworkProfileByName(t *testing.T) {
+	for _, name := range []string{"lan", "broadband", "Mobile"} {
+		profile, err := NetworkProfileByName(name)
+		require.NoError(t, err)
+		require.NotNil(t, profile.Latency)
+	}
+	_, err := NetworkProfileByName("dialup")
+	require.Error(t, err)
+}
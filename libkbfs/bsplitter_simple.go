@@ -42,6 +42,16 @@ func getMaxDirEntriesPerBlock() (int, error) {
 func NewBlockSplitterSimple(desiredBlockSize int64,
 	blockChangeEmbedMaxSize uint64, codec kbfscodec.Codec) (
 	*BlockSplitterSimple, error) {
+	return newBlockSplitterSimpleForSizeClass(desiredBlockSize,
+		blockChangeEmbedMaxSize, codec, BlockSizeClassDefault)
+}
+
+// newBlockSplitterSimpleForSizeClass is like NewBlockSplitterSimple,
+// but for the files of the given size class, whose top blocks are a
+// bit bigger when encoded.
+func newBlockSplitterSimpleForSizeClass(desiredBlockSize int64,
+	blockChangeEmbedMaxSize uint64, codec kbfscodec.Codec,
+	class BlockSizeClass) (*BlockSplitterSimple, error) {
 	// If the desired block size is exactly a power of 2, subtract one
 	// from it to account for the padding we will do, which rounds up
 	// when the encoded size is exactly a power of 2.
@@ -52,6 +62,7 @@ func NewBlockSplitterSimple(desiredBlockSize int64,
 	// Make a FileBlock of the expected size to see what the encoded
 	// overhead is.
 	block := NewFileBlock().(*FileBlock)
+	block.SizeClass = class
 	fullData := make([]byte, desiredBlockSize)
 	// Fill in the block with varying data to make sure not to trigger
 	// any encoding optimizations.
@@ -0,0 +1,88 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"regexp"
+
+	"github.com/keybase/go-framed-msgpack-rpc/rpc"
+	"github.com/pkg/errors"
+	metrics "github.com/rcrowley/go-metrics"
+	"golang.org/x/net/context"
+)
+
+// ClientIDRPCTag is the RPC tag carrying the client ID on each
+// request to the block and MD servers, so that server-side logs can
+// attribute traffic to the application embedding KBFS.
+const ClientIDRPCTag = "kbfs-client-id"
+
+var clientIDRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,63}$`)
+
+// CheckClientID returns an error if id can't be used as a client
+// ID.  An empty ID is fine, and means no client ID is sent.
+func CheckClientID(id string) error {
+	if id == "" || clientIDRegexp.MatchString(id) {
+		return nil
+	}
+	return errors.Errorf("Invalid client ID %q: it must be at most 64 "+
+		"letters, digits, '.', '_' or '-', starting with a letter or digit",
+		id)
+}
+
+// authTokenSubmoduleName returns the submodule name to put in server
+// auth tokens, including the client ID if there is one.
+func authTokenSubmoduleName(submodule, clientID string) string {
+	if clientID == "" {
+		return submodule
+	}
+	return submodule + ":" + clientID
+}
+
+// clientIDClient tags every request made through it with a client
+// ID, and counts them in the local metrics.
+type clientIDClient struct {
+	client   rpc.GenericClient
+	clientID string
+	requests metrics.Counter
+}
+
+var _ rpc.GenericClient = clientIDClient{}
+
+// wrapClientWithID returns a client that tags every request made
+// through `client` with `clientID`, and counts them under
+// "<serverName>.ClientID.<clientID>.Requests" in `registry`, if it's
+// non-nil.  It returns `client` itself if `clientID` is empty.
+func wrapClientWithID(client rpc.GenericClient, clientID, serverName string,
+	registry metrics.Registry) rpc.GenericClient {
+	if clientID == "" {
+		return client
+	}
+	c := clientIDClient{client: client, clientID: clientID}
+	if registry != nil {
+		c.requests = metrics.GetOrRegisterCounter(
+			serverName+".ClientID."+clientID+".Requests", registry)
+	} else {
+		c.requests = metrics.NewCounter()
+	}
+	return c
+}
+
+func (c clientIDClient) tag(ctx context.Context) context.Context {
+	c.requests.Inc(1)
+	return rpc.AddRpcTagsToContext(
+		ctx, rpc.CtxRpcTags{ClientIDRPCTag: c.clientID})
+}
+
+// Call implements the rpc.GenericClient interface for clientIDClient.
+func (c clientIDClient) Call(ctx context.Context, method string,
+	arg interface{}, res interface{}) error {
+	return c.client.Call(c.tag(ctx), method, arg, res)
+}
+
+// Notify implements the rpc.GenericClient interface for clientIDClient.
+func (c clientIDClient) Notify(
+	ctx context.Context, method string, arg interface{}) error {
+	return c.client.Notify(c.tag(ctx), method, arg)
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/keybase/go-framed-msgpack-rpc/rpc"
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestCheckClientID(t *testing.T) {
+	for _, id := range []string{
+		"", "kbpagesd", "csi-driver", "gw_1.2", strings.Repeat("a", 64),
+	} {
+		require.NoError(t, CheckClientID(id), id)
+	}
+	for _, id := range []string{
+		"-csi", "csi driver", "csi/driver", "gw:1", strings.Repeat("a", 65),
+	} {
+		require.Error(t, CheckClientID(id), id)
+	}
+}
+
+type tagRecordingClient struct {
+	tags []rpc.CtxRpcTags
+}
+
+func (c *tagRecordingClient) record(ctx context.Context) {
+	tags, _ := rpc.RpcTagsFromContext(ctx)
+	c.tags = append(c.tags, tags)
+}
+
+func (c *tagRecordingClient) Call(ctx context.Context, method string,
+	arg interface{}, res interface{}) error {
+	c.record(ctx)
+	return nil
+}
+
+func (c *tagRecordingClient) Notify(
+	ctx context.Context, method string, arg interface{}) error {
+	c.record(ctx)
+	return nil
+}
+
+func TestWrapClientWithID(t *testing.T) {
+	ctx := context.Background()
+	inner := &tagRecordingClient{}
+
+	t.Log("Without a client ID, the client isn't wrapped")
+	require.Equal(t, rpc.GenericClient(inner),
+		wrapClientWithID(inner, "", "BlockServer", nil))
+
+	t.Log("Requests are tagged and counted")
+	registry := metrics.NewRegistry()
+	c := wrapClientWithID(inner, "csi-driver", "BlockServer", registry)
+	ctx = rpc.AddRpcTagsToContext(ctx, rpc.CtxRpcTags{"other": "tag"})
+	require.NoError(t, c.Call(ctx, "m", nil, nil))
+	require.NoError(t, c.Notify(ctx, "m", nil))
+	require.Len(t, inner.tags, 2)
+	for _, tags := range inner.tags {
+		require.Equal(t, rpc.CtxRpcTags{
+			ClientIDRPCTag: "csi-driver",
+			"other":        "tag",
+		}, tags)
+	}
+	counter, ok := registry.Get(
+		"BlockServer.ClientID.csi-driver.Requests").(metrics.Counter)
+	require.True(t, ok)
+	require.Equal(t, int64(2), counter.Count())
+
+	t.Log("The caller's tags are left alone")
+	tags, _ := rpc.RpcTagsFromContext(ctx)
+	require.Equal(t, rpc.CtxRpcTags{"other": "tag"}, tags)
+}
+
+func TestAuthTokenSubmoduleName(t *testing.T) {
+	require.Equal(t, "libkbfs_bserver_remote",
+		authTokenSubmoduleName("libkbfs_bserver_remote", ""))
+	require.Equal(t, "libkbfs_bserver_remote:kbpagesd",
+		authTokenSubmoduleName("libkbfs_bserver_remote", "kbpagesd"))
+}
@@ -0,0 +1,209 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sort"
+	"time"
+
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+const (
+	// cloneChunkSize is how much file data is copied per read/write.
+	cloneChunkSize = 1 << 20
+	// cloneSyncBytes is how much data can be written to the clone
+	// before it's flushed, to keep the dirty data bounded.
+	cloneSyncBytes = 64 << 20
+)
+
+// CloneStats summarizes a CloneFolder call.
+type CloneStats struct {
+	// Revision is the revision of the source that was cloned.
+	Revision kbfsmd.Revision
+	Dirs     int
+	Files    int
+	Symlinks int
+	// Bytes is the total size of the cloned files.
+	Bytes uint64
+}
+
+type folderCloner struct {
+	config    Config
+	dst       FolderBranch
+	stats     CloneStats
+	unsynced  uint64
+	dirMtimes []clonedDirMtime
+}
+
+type clonedDirMtime struct {
+	node  Node
+	mtime time.Time
+}
+
+func (c *folderCloner) maybeSync(ctx context.Context, n uint64) error {
+	c.unsynced += n
+	if c.unsynced < cloneSyncBytes {
+		return nil
+	}
+	c.unsynced = 0
+	return c.config.KBFSOps().SyncAll(ctx, c.dst)
+}
+
+func (c *folderCloner) copyFile(
+	ctx context.Context, src, dstDir Node, name string, ei EntryInfo) error {
+	kbfsOps := c.config.KBFSOps()
+	// `dst` is known to be empty, so there's no need for exclusive
+	// creates, each of which would be synced as its own revision.
+	dst, _, err := kbfsOps.CreateFile(ctx, dstDir, name, ei.Type == Exec, NoExcl)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, cloneChunkSize)
+	for off := int64(0); off < int64(ei.Size); {
+		n, err := kbfsOps.Read(CtxSkipRecentFiles(ctx), src, buf, off)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		err = kbfsOps.Write(CtxSkipRecentFiles(ctx), dst, buf[:n], off)
+		if err != nil {
+			return err
+		}
+		off += n
+		if err := c.maybeSync(ctx, uint64(n)); err != nil {
+			return err
+		}
+	}
+	mtime := time.Unix(0, ei.Mtime)
+	if err := kbfsOps.SetMtime(ctx, dst, &mtime); err != nil {
+		return err
+	}
+	c.stats.Files++
+	c.stats.Bytes += ei.Size
+	return nil
+}
+
+func (c *folderCloner) copyDir(ctx context.Context, src, dst Node) error {
+	kbfsOps := c.config.KBFSOps()
+	children, err := kbfsOps.GetDirChildren(ctx, src)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(children))
+	for name := range children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		ei := children[name]
+		if ei.Type == Sym {
+			_, err := kbfsOps.CreateLink(ctx, dst, name, ei.SymPath)
+			if err != nil {
+				return err
+			}
+			c.stats.Symlinks++
+			continue
+		}
+
+		srcChild, _, err := kbfsOps.Lookup(ctx, src, name)
+		if err != nil {
+			return err
+		}
+		if ei.Type != Dir {
+			err = c.copyFile(ctx, srcChild, dst, name, ei)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		dstChild, _, err := kbfsOps.CreateDir(ctx, dst, name)
+		if err != nil {
+			return err
+		}
+		err = c.copyDir(ctx, srcChild, dstChild)
+		if err != nil {
+			return err
+		}
+		// Creating the children bumps the directory's mtime, so
+		// restore it only once they're all in place.
+		c.dirMtimes = append(c.dirMtimes,
+			clonedDirMtime{dstChild, time.Unix(0, ei.Mtime)})
+		c.stats.Dirs++
+	}
+	return nil
+}
+
+// CloneFolder copies the TLF `src`, as of revision `rev`, into `dst`,
+// which must be an empty directory.  If `rev` is
+// kbfsmd.RevisionUninitialized, the current head of `src` is cloned.
+//
+// The file data is read from the snapshot and written to `dst` like
+// any other write, so all of it is re-encrypted and uploaded again,
+// even when `dst` is in the same TLF as `src`.  The clone is synced
+// after every cloneSyncBytes of data, and once more at the end.
+func CloneFolder(ctx context.Context, config Config, src *TlfHandle,
+	rev kbfsmd.Revision, dst Node) (stats CloneStats, err error) {
+	kbfsOps := config.KBFSOps()
+	if rev == kbfsmd.RevisionUninitialized {
+		head, err := config.MDOps().GetForTLF(ctx, src.TlfID(), nil)
+		if err != nil {
+			return CloneStats{}, err
+		}
+		if head == (ImmutableRootMetadata{}) {
+			return CloneStats{}, errors.Errorf(
+				"%s has no revisions to clone", src.GetCanonicalPath())
+		}
+		rev = head.Revision()
+	}
+
+	// Always read from a fixed revision, so that the snapshot stays
+	// consistent even if `dst` is inside `src`.
+	srcRoot, _, err := kbfsOps.GetRootNode(ctx, src, MakeRevBranchName(rev))
+	if err != nil {
+		return CloneStats{}, err
+	}
+	children, err := kbfsOps.GetDirChildren(ctx, dst)
+	if err != nil {
+		return CloneStats{}, err
+	}
+	if len(children) > 0 {
+		return CloneStats{}, errors.New("The clone destination isn't empty")
+	}
+
+	c := &folderCloner{
+		config: config,
+		dst:    dst.GetFolderBranch(),
+		stats:  CloneStats{Revision: rev},
+	}
+	err = c.copyDir(ctx, srcRoot, dst)
+	if err != nil {
+		return CloneStats{}, err
+	}
+	// Subdirectories were recorded before their parents.
+	for _, dm := range c.dirMtimes {
+		mtime := dm.mtime
+		err := kbfsOps.SetMtime(ctx, dm.node, &mtime)
+		if err != nil {
+			return CloneStats{}, err
+		}
+	}
+	err = kbfsOps.SyncAll(ctx, c.dst)
+	if err != nil {
+		return CloneStats{}, err
+	}
+	return c.stats, nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloneFolder(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice", "bob")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps()
+	data := make([]byte, 300*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	dir, _, err := kbfsOps.CreateDir(ctx, rootNode, "data")
+	require.NoError(t, err)
+	f, _, err := kbfsOps.CreateFile(ctx, dir, "big", false, NoExcl)
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps.Write(ctx, f, data, 0))
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "run", true, NoExcl)
+	require.NoError(t, err)
+	_, err = kbfsOps.CreateLink(ctx, rootNode, "link", "data/big")
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+
+	status, _, err := kbfsOps.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+	rev := status.Revision
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "later", false, NoExcl)
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+
+	h, err := ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "alice", tlf.Private)
+	require.NoError(t, err)
+	checkClone := func(root Node) {
+		children, err := kbfsOps.GetDirChildren(ctx, root)
+		require.NoError(t, err)
+		require.Len(t, children, 3)
+		require.Equal(t, Exec, children["run"].Type)
+		require.Equal(t, "data/big", children["link"].SymPath)
+		d, _, err := kbfsOps.Lookup(ctx, root, "data")
+		require.NoError(t, err)
+		n, ei, err := kbfsOps.Lookup(ctx, d, "big")
+		require.NoError(t, err)
+		buf := make([]byte, ei.Size)
+		nRead, err := kbfsOps.Read(ctx, n, buf, 0)
+		require.NoError(t, err)
+		require.Equal(t, data, buf[:nRead])
+	}
+
+	t.Log("Clone the old revision into a directory of the same folder")
+	archive, _, err := kbfsOps.CreateDir(ctx, rootNode, "2024-archive")
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+	status, _, err = kbfsOps.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+	prevRev := status.Revision
+	stats, err := CloneFolder(ctx, config, h, rev, archive)
+	require.NoError(t, err)
+	require.Equal(t, rev, stats.Revision)
+	require.Equal(t, 1, stats.Dirs)
+	require.Equal(t, 2, stats.Files)
+	require.Equal(t, 1, stats.Symlinks)
+	require.Equal(t, uint64(len(data)), stats.Bytes)
+	checkClone(archive)
+	// The whole clone fits in one sync, rather than a revision per
+	// created file.
+	status, _, err = kbfsOps.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, prevRev+1, status.Revision)
+
+	t.Log("The destination must be empty")
+	_, err = CloneFolder(ctx, config, h, rev, archive)
+	require.Error(t, err)
+
+	t.Log("Clone the head into a new shared folder")
+	sharedRoot := GetRootNodeOrBust(
+		ctx, t, config, "alice,bob", tlf.Private)
+	stats, err = CloneFolder(
+		ctx, config, h, kbfsmd.RevisionUninitialized, sharedRoot)
+	require.NoError(t, err)
+	require.True(t, stats.Revision > rev)
+	_, _, err = kbfsOps.Lookup(ctx, sharedRoot, "later")
+	require.NoError(t, err)
+	_, _, err = kbfsOps.Lookup(ctx, sharedRoot, "2024-archive")
+	require.NoError(t, err)
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/kbfs/cache"
 	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfscodec"
 	"github.com/keybase/kbfs/kbfscrypto"
 	"github.com/keybase/kbfs/kbfsedits"
@@ -75,6 +76,9 @@ type ConfigLocal struct {
 	rep              Reporter
 	kcache           KeyCache
 	kbcache          kbfsmd.KeyBundleCache
+	diskKBCache      *diskKeyBundleCache
+	diskResolver     *diskResolverCache
+	diskAccessLog    *diskAccessLog
 	bcache           BlockCache
 	dirtyBcache      DirtyBlockCache
 	diskBlockCache   DiskBlockCache
@@ -101,17 +105,45 @@ type ConfigLocal struct {
 	rwpWaitTime      time.Duration
 	diskLimiter      DiskLimiter
 	syncedTlfs       map[tlf.ID]bool
-	defaultBlockType keybase1.BlockType
-	kbfsService      *KBFSService
-	kbCtx            Context
-	rootNodeWrappers []func(Node) Node
+	writeThroughTlfs map[tlf.ID]bool
+	tlfDataRegions   map[tlf.ID]string
+	// tlfDataRegionsLoaded holds a channel per TLF, closed once its
+	// data region has been read from its settings.
+	tlfDataRegionsLoaded map[tlf.ID]chan struct{}
+	defaultBlockType     keybase1.BlockType
+	kbfsService          *KBFSService
+	kbCtx                Context
+	rootNodeWrappers     []func(Node) Node
 
 	maxNameBytes           uint32
 	rekeyQueue             RekeyQueue
 	storageRoot            string
+	releaseStorageRoot     func()
 	diskCacheMode          DiskCacheMode
+	sharedDiskCacheDir     string
+	publicReadOnly         bool
+	noSiblingPrefetch      bool // logic opposite so the default is on
+	clientID               string
+	opTimeouts             OpTimeouts
 	diskBlockCacheFraction float64
 	syncBlockCacheFraction float64
+	deviceConstraints      *deviceConstraintsMonitor
+	expiryEnforcer         *expiryEnforcer
+	stateDumper            *stateDumpHandler
+	syncSchedules          SyncSchedules
+	contentScanner         ContentScanner
+	blockTransform         BlockTransform
+	identifyPolicy         IdentifyPolicy
+	storageAccountant      StorageAccountant
+	mountManager           MountManager
+	webhooks               Webhooks
+	eventRules             EventRules
+	eventRuleEngine        *EventRuleEngine
+	teamRenames            TeamRenames
+	kbfsIgnores            map[tlf.ID]*KBFSIgnore
+	sparseSyncs            map[tlf.ID]*KBFSIgnore
+	sparseSyncedBlocks     map[tlf.ID]map[kbfsblock.ID]bool
+	dataRegionsEnforced    bool
 
 	traceLock    sync.RWMutex
 	traceEnabled bool
@@ -133,9 +165,16 @@ type ConfigLocal struct {
 	// before syncing a set of changes to the servers.
 	bgFlushPeriod time.Duration
 
+	// dirTimesBatchPeriod indicates how long a directory's mtime
+	// and ctime may lag behind changes to its entries.
+	dirTimesBatchPeriod time.Duration
+
 	// metadataVersion is the version to use when creating new metadata.
 	metadataVersion kbfsmd.MetadataVer
 
+	// dataVersion is the newest data version this client can read.
+	dataVersion DataVer
+
 	// blockCryptVersion is the version to use when encrypting blocks.
 	blockCryptVersion kbfscrypto.EncryptionVer
 
@@ -422,6 +461,11 @@ func NewConfigLocal(mode InitMode,
 	if diskCacheMode == DiskCacheModeLocal {
 		config.loadSyncedTlfsLocked()
 	}
+	config.loadSyncSchedulesLocked()
+	config.loadWebhooksLocked()
+	config.loadEventRulesLocked()
+	config.loadTlfDataRegionsLocked()
+	config.loadTeamRenamesLocked()
 	config.SetClock(wallClock{})
 	config.SetReporter(NewReporterSimple(config.Clock(), 10))
 	config.SetConflictRenamer(WriterDeviceDateConflictRenamer{config})
@@ -443,9 +487,11 @@ func NewConfigLocal(mode InitMode,
 	}
 
 	config.tlfValidDuration = tlfValidDurationDefault
-	config.bgFlushDirOpBatchSize = bgFlushDirOpBatchSizeDefault
-	config.bgFlushPeriod = bgFlushPeriodDefault
+	config.bgFlushDirOpBatchSize = config.Mode().BackgroundFlushDirOpBatchSize()
+	config.bgFlushPeriod = config.Mode().BackgroundFlushPeriod()
+	config.dirTimesBatchPeriod = config.Mode().DirMtimeGranularity()
 	config.metadataVersion = defaultClientMetadataVer
+	config.dataVersion = defaultClientDataVer
 	config.defaultBlockType = defaultBlockTypeDefault
 	config.quotaUsage =
 		make(map[keybase1.UserOrTeamID]*EventuallyConsistentQuotaUsage)
@@ -454,6 +500,7 @@ func NewConfigLocal(mode InitMode,
 	config.syncBlockCacheFraction = defaultSyncBlockCacheFraction
 
 	config.blockCryptVersion = defaultBlockCryptVersion
+	config.eventRuleEngine = NewEventRuleEngine(config)
 
 	return config
 }
@@ -598,6 +645,61 @@ func (c *ConfigLocal) SetDiskBlockCacheFraction(fraction float64) {
 	c.diskBlockCacheFraction = fraction
 }
 
+// SetSharedDiskCacheDir sets the directory of a disk cache for public
+// folder blocks that is shared with other KBFS instances on this
+// machine.  It must be called before the disk block cache is made.
+func (c *ConfigLocal) SetSharedDiskCacheDir(dir string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.sharedDiskCacheDir = dir
+}
+
+// PublicReadOnly implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) PublicReadOnly() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.publicReadOnly
+}
+
+// SetPublicReadOnly sets whether KBFS serves only public folders,
+// read-only.  The caller is responsible for making sure KBPKI never
+// reports a session in that case; see NewKBPKIAnonymous.
+func (c *ConfigLocal) SetPublicReadOnly(publicReadOnly bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.publicReadOnly = publicReadOnly
+}
+
+// ClientID implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) ClientID() string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.clientID
+}
+
+// SetClientID sets the identifier of the application embedding KBFS.
+// It must be called before the block and MD servers are made.
+func (c *ConfigLocal) SetClientID(clientID string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.clientID = clientID
+}
+
+// OpTimeouts implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) OpTimeouts() OpTimeouts {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.opTimeouts
+}
+
+// SetOpTimeouts sets how long each class of operation coming from a
+// file system interface may take.
+func (c *ConfigLocal) SetOpTimeouts(timeouts OpTimeouts) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.opTimeouts = timeouts
+}
+
 // SetSyncBlockCacheFraction implements the Config interface for ConfigLocal.
 func (c *ConfigLocal) SetSyncBlockCacheFraction(fraction float64) {
 	c.lock.Lock()
@@ -867,7 +969,18 @@ func (c *ConfigLocal) SetMetadataVersion(mdVer kbfsmd.MetadataVer) {
 
 // DataVersion implements the Config interface for ConfigLocal.
 func (c *ConfigLocal) DataVersion() DataVer {
-	return IndirectDirsDataVer
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.dataVersion
+}
+
+// SetDataVersion sets the newest data version this client can read,
+// and the version it uses for new entries.  It only exists to let
+// tests act like older clients.
+func (c *ConfigLocal) SetDataVersion(dataVer DataVer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.dataVersion = dataVer
 }
 
 // BlockCryptVersion implements the Config interface for ConfigLocal.
@@ -916,6 +1029,26 @@ func (c *ConfigLocal) SetDoBackgroundFlushes(doBGFlush bool) {
 	c.noBGFlush = !doBGFlush
 }
 
+// SiblingPrefetchEnabled implements the Config interface for
+// ConfigLocal.
+func (c *ConfigLocal) SiblingPrefetchEnabled() bool {
+	if c.Mode().PrefetchWorkers() == 0 {
+		return false
+	}
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return !c.noSiblingPrefetch
+}
+
+// SetSiblingPrefetchEnabled implements the Config interface for
+// ConfigLocal.
+func (c *ConfigLocal) SetSiblingPrefetchEnabled(enabled bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.noSiblingPrefetch = !enabled
+}
+
 // RekeyWithPromptWaitTime implements the Config interface for
 // ConfigLocal.
 func (c *ConfigLocal) RekeyWithPromptWaitTime() time.Duration {
@@ -1143,6 +1276,20 @@ func (c *ConfigLocal) BGFlushPeriod() time.Duration {
 	return c.bgFlushPeriod
 }
 
+// SetDirTimesBatchPeriod implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetDirTimesBatchPeriod(p time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.dirTimesBatchPeriod = p
+}
+
+// DirTimesBatchPeriod implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) DirTimesBatchPeriod() time.Duration {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.dirTimesBatchPeriod
+}
+
 // Shutdown implements the Config interface for ConfigLocal.
 func (c *ConfigLocal) Shutdown(ctx context.Context) error {
 	c.RekeyQueue().Shutdown()
@@ -1187,6 +1334,22 @@ func (c *ConfigLocal) Shutdown(ctx context.Context) error {
 		}
 	}
 
+	c.lock.RLock()
+	dcm := c.deviceConstraints
+	ee := c.expiryEnforcer
+	sdh := c.stateDumper
+	c.lock.RUnlock()
+	if dcm != nil {
+		// Stop before the journal server it controls shuts down.
+		dcm.shutdown()
+	}
+	if ee != nil {
+		ee.shutdown()
+	}
+	if sdh != nil {
+		sdh.shutdown()
+	}
+
 	var errorList []error
 	err := c.KBFSOps().Shutdown(ctx)
 	if err != nil {
@@ -1212,10 +1375,32 @@ func (c *ConfigLocal) Shutdown(ctx context.Context) error {
 	if dbc != nil {
 		dbc.Shutdown(ctx)
 	}
+	c.lock.RLock()
+	dkbc := c.diskKBCache
+	c.lock.RUnlock()
+	if dkbc != nil {
+		dkbc.shutdown()
+	}
+	c.lock.RLock()
+	drc := c.diskResolver
+	c.lock.RUnlock()
+	if drc != nil {
+		drc.shutdown()
+	}
+	c.lock.RLock()
+	dal := c.diskAccessLog
+	c.lock.RUnlock()
+	if dal != nil {
+		dal.shutdown()
+	}
 	kbfsServ := c.kbfsService
 	if kbfsServ != nil {
 		kbfsServ.Shutdown()
 	}
+	if c.releaseStorageRoot != nil {
+		// Only let go once nothing else will touch the storage root.
+		c.releaseStorageRoot()
+	}
 
 	if len(errorList) == 1 {
 		return errorList[0]
@@ -1336,6 +1521,7 @@ func (c *ConfigLocal) EnableJournaling(
 
 	c.SetBlockServer(jServer.blockServer())
 	c.SetMDOps(jServer.mdOps())
+	go jServer.syncScheduleLoop()
 
 	bcacheErr := c.journalizeBcaches(jServer)
 	enableErr := func() error {
@@ -1370,7 +1556,8 @@ func (c *ConfigLocal) EnableJournaling(
 }
 
 func (c *ConfigLocal) resetDiskBlockCacheLocked() error {
-	dbc, err := newDiskBlockCacheWrapped(c, c.storageRoot)
+	dbc, err := newDiskBlockCacheWrapped(
+		c, c.storageRoot, c.sharedDiskCacheDir)
 	if err != nil {
 		return err
 	}
@@ -1402,6 +1589,85 @@ func (c *ConfigLocal) MakeDiskBlockCacheIfNotExists() error {
 	return nil
 }
 
+// EnableDiskKeyBundleCache backs the key bundle cache with a store
+// in the storage root, where the key bundles of synced TLFs are
+// kept.
+func (c *ConfigLocal) EnableDiskKeyBundleCache() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.diskKBCache != nil {
+		return nil
+	}
+	if c.storageRoot == "" {
+		return errors.New("empty storageRoot specified for disk " +
+			"key bundle cache")
+	}
+	ldb, err := c.openConfigLevelDB(keyBundleCacheFolderName)
+	if err != nil {
+		return err
+	}
+	c.diskKBCache = newDiskKeyBundleCache(c.kbcache, c.codec, ldb)
+	c.kbcache = c.diskKBCache
+	return nil
+}
+
+// EnableDiskResolverCache persists the resolutions of the
+// assertions in folder handles in the storage root, so that folders
+// can be opened while the service is unreachable.
+func (c *ConfigLocal) EnableDiskResolverCache() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.diskResolver != nil {
+		return nil
+	}
+	if c.storageRoot == "" {
+		return errors.New("empty storageRoot specified for disk " +
+			"resolver cache")
+	}
+	ldb, err := c.openConfigLevelDB(resolverCacheFolderName)
+	if err != nil {
+		return err
+	}
+	c.diskResolver = newDiskResolverCache(c.codec, c, ldb)
+	return nil
+}
+
+// resolverCache implements the resolverCacheGetter interface for
+// ConfigLocal.
+func (c *ConfigLocal) resolverCache() *diskResolverCache {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.diskResolver
+}
+
+// EnableDiskAccessLog keeps a log of the reads of TLFs that turn on
+// read access logging in the storage root.
+func (c *ConfigLocal) EnableDiskAccessLog() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.diskAccessLog != nil {
+		return nil
+	}
+	if c.storageRoot == "" {
+		return errors.New("empty storageRoot specified for disk " +
+			"access log")
+	}
+	ldb, err := c.openConfigLevelDB(accessLogFolderName)
+	if err != nil {
+		return err
+	}
+	c.diskAccessLog = newDiskAccessLog(c.codec, ldb)
+	return nil
+}
+
+// accessLogStore implements the accessLogStoreGetter interface for
+// ConfigLocal.
+func (c *ConfigLocal) accessLogStore() *diskAccessLog {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.diskAccessLog
+}
+
 func (c *ConfigLocal) openConfigLevelDB(configName string) (*levelDb, error) {
 	dbPath := filepath.Join(c.storageRoot, configName)
 	stor, err := storage.OpenFile(dbPath, false)
@@ -1445,6 +1711,293 @@ func (c *ConfigLocal) loadSyncedTlfsLocked() (err error) {
 	return ldb.Write(deleteBatch, nil)
 }
 
+// loadSettingLocked reads the device-local setting persisted as
+// JSON in `fileName` under the storage root into `v`, and returns
+// whether it could be read.  `desc` names the setting in warnings.
+func (c *ConfigLocal) loadSettingLocked(
+	fileName, desc string, v interface{}) bool {
+	if c.IsTestMode() || c.storageRoot == "" {
+		return false
+	}
+	err := ioutil.DeserializeFromJSONFile(
+		filepath.Join(c.storageRoot, fileName), v)
+	if err != nil {
+		if !ioutil.IsNotExist(err) {
+			c.MakeLogger("").Warning("Couldn't load %s: %+v", desc, err)
+		}
+		return false
+	}
+	return true
+}
+
+// saveSettingLocked persists `v` as JSON in `fileName` under the
+// storage root, as the device-local setting that loadSettingLocked
+// reads back.  Nothing is persisted in test mode.
+func (c *ConfigLocal) saveSettingLocked(fileName string, v interface{}) error {
+	if c.IsTestMode() {
+		return nil
+	}
+	if c.storageRoot == "" {
+		return errors.New("empty storageRoot specified for non-test run")
+	}
+	return ioutil.SerializeToJSONFile(
+		v, filepath.Join(c.storageRoot, fileName))
+}
+
+func (c *ConfigLocal) loadSyncSchedulesLocked() {
+	var schedules SyncSchedules
+	if c.loadSettingLocked(
+		syncSchedulesFileName, "sync schedules", &schedules) {
+		c.syncSchedules = schedules
+	}
+}
+
+// ContentScanner implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) ContentScanner() ContentScanner {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.contentScanner
+}
+
+// SetContentScanner implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetContentScanner(cs ContentScanner) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.contentScanner = cs
+}
+
+// BlockTransform implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) BlockTransform() BlockTransform {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.blockTransform
+}
+
+// SetBlockTransform implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetBlockTransform(bt BlockTransform) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.blockTransform = bt
+}
+
+// IdentifyPolicy implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) IdentifyPolicy() IdentifyPolicy {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.identifyPolicy
+}
+
+// SetIdentifyPolicy implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetIdentifyPolicy(ip IdentifyPolicy) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.identifyPolicy = ip
+}
+
+// StorageAccountant implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) StorageAccountant() StorageAccountant {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.storageAccountant
+}
+
+// SetStorageAccountant implements the Config interface for
+// ConfigLocal.
+func (c *ConfigLocal) SetStorageAccountant(sa StorageAccountant) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.storageAccountant = sa
+}
+
+// MountManager implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) MountManager() MountManager {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.mountManager
+}
+
+// SetMountManager implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetMountManager(mm MountManager) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.mountManager = mm
+}
+
+func (c *ConfigLocal) loadWebhooksLocked() {
+	var webhooks Webhooks
+	if c.loadSettingLocked(webhooksFileName, "webhooks", &webhooks) {
+		c.webhooks = webhooks
+	}
+}
+
+// Webhooks implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) Webhooks() Webhooks {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.webhooks
+}
+
+// SetWebhooks implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetWebhooks(webhooks Webhooks) error {
+	for _, hooks := range webhooks {
+		for _, w := range hooks {
+			if err := w.Validate(); err != nil {
+				return err
+			}
+		}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.saveSettingLocked(webhooksFileName, webhooks); err != nil {
+		return err
+	}
+	c.webhooks = webhooks
+	return nil
+}
+
+func (c *ConfigLocal) loadEventRulesLocked() {
+	if c.IsTestMode() || c.storageRoot == "" {
+		return
+	}
+	rules, err := LoadEventRules(c.storageRoot)
+	if err != nil {
+		c.MakeLogger("").Warning("Couldn't load event rules: %+v", err)
+		return
+	}
+	c.eventRules = rules
+}
+
+// EventRules implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) EventRules() EventRules {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.eventRules
+}
+
+// SetEventRules implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetEventRules(rules EventRules) error {
+	if err := rules.Validate(); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.saveSettingLocked(eventRulesFileName, rules); err != nil {
+		return err
+	}
+	c.eventRules = rules
+	return nil
+}
+
+// EventRuleEngine implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) EventRuleEngine() *EventRuleEngine {
+	return c.eventRuleEngine
+}
+
+func (c *ConfigLocal) loadTeamRenamesLocked() {
+	var renames TeamRenames
+	if c.loadSettingLocked(teamRenamesFileName, "team renames", &renames) {
+		c.teamRenames = renames
+	}
+}
+
+// TeamRenames implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) TeamRenames() TeamRenames {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.teamRenames
+}
+
+// SetTeamRenames implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetTeamRenames(renames TeamRenames) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := c.saveSettingLocked(teamRenamesFileName, renames); err != nil {
+		return err
+	}
+	c.teamRenames = renames
+	return nil
+}
+
+// SyncSchedules implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SyncSchedules() SyncSchedules {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.syncSchedules
+}
+
+// SetSyncSchedules implements the Config interface for ConfigLocal.
+func (c *ConfigLocal) SetSyncSchedules(
+	ctx context.Context, schedules SyncSchedules) error {
+	err := func() error {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		err := c.saveSettingLocked(syncSchedulesFileName, schedules)
+		if err != nil {
+			return err
+		}
+		c.syncSchedules = schedules
+		return nil
+	}()
+	if err != nil {
+		return err
+	}
+
+	if jServer, err := GetJournalServer(c); err == nil {
+		jServer.applySyncSchedules(ctx)
+	}
+	return nil
+}
+
+// startDeviceConstraintsMonitor makes this config pause background
+// work according to `mode` while the device is on battery power or a
+// metered network.
+func (c *ConfigLocal) startDeviceConstraintsMonitor(
+	mode DeviceConstraintsMode) {
+	dcm := newDeviceConstraintsMonitor(c, mode)
+	c.lock.Lock()
+	c.deviceConstraints = dcm
+	c.lock.Unlock()
+	go dcm.loop()
+}
+
+// startExpiryEnforcer makes this config apply the expiry policies
+// naming this device as their enforcer every `period`.
+func (c *ConfigLocal) startExpiryEnforcer(period time.Duration) {
+	ee := newExpiryEnforcer(c, period)
+	c.lock.Lock()
+	c.expiryEnforcer = ee
+	c.lock.Unlock()
+	go ee.loop()
+}
+
+// startStateDumpHandler makes this config write a state dump into
+// `dir` every time the process receives one of `stateDumpSignals`,
+// until it's shut down.
+func (c *ConfigLocal) startStateDumpHandler(dir string, log logger.Logger) {
+	if len(stateDumpSignals) == 0 {
+		return
+	}
+	sdh := newStateDumpHandler(c, dir, log)
+	c.lock.Lock()
+	c.stateDumper = sdh
+	c.lock.Unlock()
+	sdh.start()
+}
+
+// DeviceConstraintsStatus implements the Config interface for
+// ConfigLocal.
+func (c *ConfigLocal) DeviceConstraintsStatus() DeviceConstraintsStatus {
+	c.lock.RLock()
+	dcm := c.deviceConstraints
+	c.lock.RUnlock()
+	if dcm == nil {
+		return DeviceConstraintsStatus{
+			Mode: DeviceConstraintsModeIgnore.String(),
+		}
+	}
+	return dcm.status()
+}
+
 // IsSyncedTlf implements the isSyncedTlfGetter interface for ConfigLocal.
 func (c *ConfigLocal) IsSyncedTlf(tlfID tlf.ID) bool {
 	c.lock.RLock()
@@ -1488,11 +2041,220 @@ func (c *ConfigLocal) SetTlfSyncState(tlfID tlf.ID, isSynced bool) error {
 			return err
 		}
 	}
+	if c.syncedTlfs == nil {
+		c.syncedTlfs = make(map[tlf.ID]bool)
+	}
 	c.syncedTlfs[tlfID] = isSynced
 	<-c.bops.TogglePrefetcher(true)
 	return nil
 }
 
+// IsWriteThroughTlf implements the writeThroughTlfGetterSetter
+// interface for ConfigLocal.
+func (c *ConfigLocal) IsWriteThroughTlf(tlfID tlf.ID) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.writeThroughTlfs[tlfID]
+}
+
+// SetTlfWriteThroughState implements the writeThroughTlfGetterSetter
+// interface for ConfigLocal.
+func (c *ConfigLocal) SetTlfWriteThroughState(
+	tlfID tlf.ID, writeThrough bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !writeThrough {
+		delete(c.writeThroughTlfs, tlfID)
+		return
+	}
+	if c.writeThroughTlfs == nil {
+		c.writeThroughTlfs = make(map[tlf.ID]bool)
+	}
+	c.writeThroughTlfs[tlfID] = true
+}
+
+func (c *ConfigLocal) loadTlfDataRegionsLocked() {
+	var regions map[tlf.ID]string
+	if c.loadSettingLocked(
+		tlfDataRegionsFileName, "TLF data regions", &regions) {
+		c.tlfDataRegions = regions
+	}
+}
+
+// DataRegionsEnforced implements the tlfDataRegionGetterSetter
+// interface for ConfigLocal.
+func (c *ConfigLocal) DataRegionsEnforced() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.dataRegionsEnforced
+}
+
+// enableDataRegions records that the block server routes blocks by
+// the data regions of their TLFs, which should then be kept up to
+// date.
+func (c *ConfigLocal) enableDataRegions() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.dataRegionsEnforced = true
+}
+
+// tlfDataRegionLoadedChLocked returns the channel that's closed
+// once the data region of the given TLF has been loaded.
+func (c *ConfigLocal) tlfDataRegionLoadedChLocked(tlfID tlf.ID) chan struct{} {
+	ch, ok := c.tlfDataRegionsLoaded[tlfID]
+	if !ok {
+		if c.tlfDataRegionsLoaded == nil {
+			c.tlfDataRegionsLoaded = make(map[tlf.ID]chan struct{})
+		}
+		ch = make(chan struct{})
+		c.tlfDataRegionsLoaded[tlfID] = ch
+	}
+	return ch
+}
+
+// TlfDataRegion implements the tlfDataRegionGetterSetter interface
+// for ConfigLocal.
+func (c *ConfigLocal) TlfDataRegion(tlfID tlf.ID) (
+	region string, loaded bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	region = c.tlfDataRegions[tlfID]
+	if ch, ok := c.tlfDataRegionsLoaded[tlfID]; ok {
+		select {
+		case <-ch:
+			return region, true
+		default:
+		}
+	}
+	return region, false
+}
+
+// WaitForTlfDataRegion implements the tlfDataRegionGetterSetter
+// interface for ConfigLocal.
+func (c *ConfigLocal) WaitForTlfDataRegion(
+	ctx context.Context, tlfID tlf.ID) (string, error) {
+	c.lock.Lock()
+	ch := c.tlfDataRegionLoadedChLocked(tlfID)
+	c.lock.Unlock()
+	select {
+	case <-ch:
+	case <-ctx.Done():
+		return "", errors.WithStack(ctx.Err())
+	}
+	region, _ := c.TlfDataRegion(tlfID)
+	return region, nil
+}
+
+// SetTlfDataRegion implements the tlfDataRegionGetterSetter interface
+// for ConfigLocal.
+func (c *ConfigLocal) SetTlfDataRegion(tlfID tlf.ID, region string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.tlfDataRegions[tlfID] != region {
+		regions := make(map[tlf.ID]string, len(c.tlfDataRegions)+1)
+		for id, r := range c.tlfDataRegions {
+			if id != tlfID {
+				regions[id] = r
+			}
+		}
+		if region != "" {
+			regions[tlfID] = region
+		}
+		err := c.saveSettingLocked(tlfDataRegionsFileName, regions)
+		if err != nil {
+			return err
+		}
+		c.tlfDataRegions = regions
+	}
+	ch := c.tlfDataRegionLoadedChLocked(tlfID)
+	select {
+	case <-ch:
+	default:
+		close(ch)
+	}
+	return nil
+}
+
+// KBFSIgnore implements the kbfsIgnoreGetter interface for
+// ConfigLocal.
+func (c *ConfigLocal) KBFSIgnore(tlfID tlf.ID) *KBFSIgnore {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.kbfsIgnores[tlfID]
+}
+
+// SetKBFSIgnore implements the kbfsIgnoreSetter interface for
+// ConfigLocal.
+func (c *ConfigLocal) SetKBFSIgnore(tlfID tlf.ID, ki *KBFSIgnore) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if ki == nil {
+		delete(c.kbfsIgnores, tlfID)
+		return
+	}
+	if c.kbfsIgnores == nil {
+		c.kbfsIgnores = make(map[tlf.ID]*KBFSIgnore)
+	}
+	c.kbfsIgnores[tlfID] = ki
+}
+
+// SparseSync implements the sparseSyncGetter interface for
+// ConfigLocal.
+func (c *ConfigLocal) SparseSync(tlfID tlf.ID) *KBFSIgnore {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.sparseSyncs[tlfID]
+}
+
+// SetSparseSync implements the sparseSyncSetter interface for
+// ConfigLocal.
+func (c *ConfigLocal) SetSparseSync(tlfID tlf.ID, rules *KBFSIgnore) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.sparseSyncedBlocks, tlfID)
+	if rules == nil {
+		delete(c.sparseSyncs, tlfID)
+		return
+	}
+	if c.sparseSyncs == nil {
+		c.sparseSyncs = make(map[tlf.ID]*KBFSIgnore)
+	}
+	c.sparseSyncs[tlfID] = rules
+}
+
+// isSparseSyncedBlock implements the sparseSyncGetter interface for
+// ConfigLocal.
+func (c *ConfigLocal) isSparseSyncedBlock(
+	tlfID tlf.ID, id kbfsblock.ID) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.sparseSyncedBlocks[tlfID][id]
+}
+
+// addSparseSyncedBlocks implements the sparseSyncGetter interface
+// for ConfigLocal.
+func (c *ConfigLocal) addSparseSyncedBlocks(
+	tlfID tlf.ID, ids ...kbfsblock.ID) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.sparseSyncs[tlfID] == nil {
+		// The rules have been cleared since the caller matched
+		// against them.
+		return
+	}
+	if c.sparseSyncedBlocks == nil {
+		c.sparseSyncedBlocks = make(map[tlf.ID]map[kbfsblock.ID]bool)
+	}
+	blocks := c.sparseSyncedBlocks[tlfID]
+	if blocks == nil {
+		blocks = make(map[kbfsblock.ID]bool)
+		c.sparseSyncedBlocks[tlfID] = blocks
+	}
+	for _, id := range ids {
+		blocks[id] = true
+	}
+}
+
 // PrefetchStatus implements the Config interface for ConfigLocal.
 func (c *ConfigLocal) PrefetchStatus(ctx context.Context, tlfID tlf.ID,
 	ptr BlockPointer) PrefetchStatus {
@@ -133,6 +133,7 @@ func NewConfigMock(c *gomock.Controller, ctr *SafeTestReporter) *ConfigMock {
 	config.rwpWaitTime = rekeyWithPromptWaitTimeDefault
 
 	config.SetMetadataVersion(defaultClientMetadataVer)
+	config.SetDataVersion(defaultClientDataVer)
 	config.mode = modeTest{NewInitModeFromType(InitDefault)}
 
 	return config
@@ -0,0 +1,315 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"fmt"
+	stdpath "path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// ConflictMergeRename records an entry of a conflicted folder that
+// was copied into the canonical folder under a new name, because an
+// entry with a different content already had its name.
+type ConflictMergeRename struct {
+	Path    string
+	NewName string
+}
+
+// ConflictMergeReport summarizes a MergeConflictedFolder call.  All
+// paths are relative to the folder roots.
+type ConflictMergeReport struct {
+	// Canonical is the canonical path of the folder merged into.
+	Canonical string
+	// Copied lists the entries that only existed in the conflicted
+	// folder.  A copied directory is listed without its children.
+	Copied []string
+	// Identical lists the files and symlinks that already had the
+	// same content in the canonical folder.
+	Identical []string
+	// Renamed lists the entries that were copied under a new name.
+	Renamed []ConflictMergeRename
+}
+
+// Conflicts returns true if any entry had to be renamed.
+func (r ConflictMergeReport) Conflicts() bool {
+	return len(r.Renamed) > 0
+}
+
+// conflictMergeName returns the name a conflicting entry gets when
+// it's merged from a folder with the given conflict info.  `n` is
+// used to disambiguate if that name is taken too.
+func conflictMergeName(
+	original string, ci tlf.HandleExtension, n int) string {
+	base, ext := splitExtension(original)
+	label := strings.Trim(ci.String(), "()")
+	if n > 1 {
+		label = fmt.Sprintf("%s #%d", label, n)
+	}
+	return fmt.Sprintf("%s.conflicted (%s)%s", base, label, ext)
+}
+
+type conflictMerger struct {
+	*folderCloner
+	conflictInfo tlf.HandleExtension
+	dryRun       bool
+	report       ConflictMergeReport
+}
+
+func (m *conflictMerger) sameFileContents(
+	ctx context.Context, src, dst Node, size uint64) (bool, error) {
+	kbfsOps := m.config.KBFSOps()
+	srcBuf := make([]byte, cloneChunkSize)
+	dstBuf := make([]byte, cloneChunkSize)
+	for off := int64(0); off < int64(size); {
+		n, err := kbfsOps.Read(CtxSkipRecentFiles(ctx), src, srcBuf, off)
+		if err != nil {
+			return false, err
+		}
+		if n == 0 {
+			break
+		}
+		dstN, err := kbfsOps.Read(
+			CtxSkipRecentFiles(ctx), dst, dstBuf[:n], off)
+		if err != nil {
+			return false, err
+		}
+		if dstN != n || !bytes.Equal(srcBuf[:n], dstBuf[:n]) {
+			return false, nil
+		}
+		off += n
+	}
+	return true, nil
+}
+
+// sameEntry returns true if the entries named `name` in `src` and
+// `dst` are the same file or symlink.
+func (m *conflictMerger) sameEntry(
+	ctx context.Context, src, dst Node, name string,
+	srcEI, dstEI EntryInfo) (bool, error) {
+	if srcEI.Type != dstEI.Type {
+		return false, nil
+	}
+	if srcEI.Type == Sym {
+		return srcEI.SymPath == dstEI.SymPath, nil
+	}
+	if srcEI.Size != dstEI.Size {
+		return false, nil
+	}
+	kbfsOps := m.config.KBFSOps()
+	srcChild, _, err := kbfsOps.Lookup(ctx, src, name)
+	if err != nil {
+		return false, err
+	}
+	dstChild, _, err := kbfsOps.Lookup(ctx, dst, name)
+	if err != nil {
+		return false, err
+	}
+	return m.sameFileContents(ctx, srcChild, dstChild, srcEI.Size)
+}
+
+// copyEntry copies the entry `name` of `src` into `dst` as `newName`.
+func (m *conflictMerger) copyEntry(
+	ctx context.Context, src, dst Node, name, newName string,
+	ei EntryInfo) error {
+	if m.dryRun {
+		return nil
+	}
+	kbfsOps := m.config.KBFSOps()
+	if ei.Type == Sym {
+		_, err := kbfsOps.CreateLink(ctx, dst, newName, ei.SymPath)
+		return err
+	}
+	srcChild, _, err := kbfsOps.Lookup(ctx, src, name)
+	if err != nil {
+		return err
+	}
+	if ei.Type != Dir {
+		return m.copyFile(ctx, srcChild, dst, newName, ei)
+	}
+	dstChild, _, err := kbfsOps.CreateDir(ctx, dst, newName)
+	if err != nil {
+		return err
+	}
+	err = m.copyDir(ctx, srcChild, dstChild)
+	if err != nil {
+		return err
+	}
+	m.dirMtimes = append(m.dirMtimes,
+		clonedDirMtime{dstChild, time.Unix(0, ei.Mtime)})
+	return nil
+}
+
+func (m *conflictMerger) mergeDir(
+	ctx context.Context, src, dst Node, dir string) error {
+	kbfsOps := m.config.KBFSOps()
+	srcChildren, err := kbfsOps.GetDirChildren(ctx, src)
+	if err != nil {
+		return err
+	}
+	dstChildren, err := kbfsOps.GetDirChildren(ctx, dst)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(srcChildren))
+	for name := range srcChildren {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		p := stdpath.Join(dir, name)
+		srcEI := srcChildren[name]
+		dstEI, ok := dstChildren[name]
+		if !ok {
+			err := m.copyEntry(ctx, src, dst, name, name, srcEI)
+			if err != nil {
+				return err
+			}
+			m.report.Copied = append(m.report.Copied, p)
+			continue
+		}
+
+		if srcEI.Type == Dir && dstEI.Type == Dir {
+			srcChild, _, err := kbfsOps.Lookup(ctx, src, name)
+			if err != nil {
+				return err
+			}
+			dstChild, _, err := kbfsOps.Lookup(ctx, dst, name)
+			if err != nil {
+				return err
+			}
+			err = m.mergeDir(ctx, srcChild, dstChild, p)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		same, err := m.sameEntry(ctx, src, dst, name, srcEI, dstEI)
+		if err != nil {
+			return err
+		}
+		if same {
+			m.report.Identical = append(m.report.Identical, p)
+			continue
+		}
+
+		newName := conflictMergeName(name, m.conflictInfo, 1)
+		for i := 2; ; i++ {
+			if _, ok := dstChildren[newName]; !ok {
+				break
+			}
+			newName = conflictMergeName(name, m.conflictInfo, i)
+		}
+		err = m.copyEntry(ctx, src, dst, name, newName, srcEI)
+		if err != nil {
+			return err
+		}
+		dstChildren[newName] = srcEI
+		m.report.Renamed = append(
+			m.report.Renamed, ConflictMergeRename{p, newName})
+	}
+	return nil
+}
+
+// CanonicalHandleForConflict returns the handle of the folder that
+// the conflicted folder `conflicted` was split from, i.e. the folder
+// with the same name but without the conflict suffix.
+func CanonicalHandleForConflict(
+	ctx context.Context, config Config, conflicted *TlfHandle) (
+	*TlfHandle, error) {
+	if conflicted.ConflictInfo() == nil {
+		return nil, errors.Errorf(
+			"%s is not a conflicted folder", conflicted.GetCanonicalPath())
+	}
+	name := strings.SplitN(
+		string(conflicted.GetCanonicalName()), tlf.HandleExtensionSep, 2)[0]
+	return GetHandleFromFolderNameAndType(
+		ctx, config.KBPKI(), config.MDOps(), name, conflicted.Type())
+}
+
+// MergeConflictedFolder copies the contents of the conflicted folder
+// `conflicted` into the canonical folder it was split from, which is
+// created if needed.  Entries that are missing from the canonical
+// folder are copied over, and those that already exist with the same
+// contents are skipped.  Files and symlinks that differ are copied
+// next to the existing ones, under a name marked with the conflict
+// suffix, and directories are merged recursively.  The conflicted
+// folder itself is left as it is.
+//
+// If `dryRun` is true, nothing is written, and the report lists the
+// changes that would have been made.
+func MergeConflictedFolder(
+	ctx context.Context, config Config, conflicted *TlfHandle,
+	dryRun bool) (ConflictMergeReport, error) {
+	canonical, err := CanonicalHandleForConflict(ctx, config, conflicted)
+	if err != nil {
+		return ConflictMergeReport{}, err
+	}
+	if canonical.ConflictInfo() != nil {
+		return ConflictMergeReport{}, errors.Errorf(
+			"%s is itself a conflicted folder", canonical.GetCanonicalPath())
+	}
+
+	kbfsOps := config.KBFSOps()
+	srcRoot, _, err := kbfsOps.GetRootNode(ctx, conflicted, MasterBranch)
+	if err != nil {
+		return ConflictMergeReport{}, err
+	}
+	if srcRoot == nil {
+		return ConflictMergeReport{}, errors.Errorf(
+			"%s has no contents to merge", conflicted.GetCanonicalPath())
+	}
+	dstRoot, _, err := kbfsOps.GetOrCreateRootNode(
+		ctx, canonical, MasterBranch)
+	if err != nil {
+		return ConflictMergeReport{}, err
+	}
+
+	m := &conflictMerger{
+		folderCloner: &folderCloner{
+			config: config,
+			dst:    dstRoot.GetFolderBranch(),
+		},
+		conflictInfo: *conflicted.ConflictInfo(),
+		dryRun:       dryRun,
+		report: ConflictMergeReport{
+			Canonical: canonical.GetCanonicalPath(),
+		},
+	}
+	err = m.mergeDir(ctx, srcRoot, dstRoot, "")
+	if err != nil {
+		return ConflictMergeReport{}, err
+	}
+	if dryRun {
+		return m.report, nil
+	}
+	// Subdirectories were recorded before their parents.
+	for _, dm := range m.dirMtimes {
+		mtime := dm.mtime
+		err := kbfsOps.SetMtime(ctx, dm.node, &mtime)
+		if err != nil {
+			return ConflictMergeReport{}, err
+		}
+	}
+	err = kbfsOps.SyncAll(ctx, m.dst)
+	if err != nil {
+		return ConflictMergeReport{}, err
+	}
+	return m.report, nil
+}
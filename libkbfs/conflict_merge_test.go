@@ -0,0 +1,108 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeConflictedFolder(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice", "bob")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	kbfsOps := config.KBFSOps()
+
+	writeFile := func(dir Node, name, contents string) {
+		n, _, err := kbfsOps.CreateFile(ctx, dir, name, false, NoExcl)
+		require.NoError(t, err)
+		require.NoError(t, kbfsOps.Write(ctx, n, []byte(contents), 0))
+	}
+	readFile := func(dir Node, name string) string {
+		n, ei, err := kbfsOps.Lookup(ctx, dir, name)
+		require.NoError(t, err)
+		buf := make([]byte, ei.Size)
+		nRead, err := kbfsOps.Read(ctx, n, buf, 0)
+		require.NoError(t, err)
+		return string(buf[:nRead])
+	}
+
+	canonicalRoot := GetRootNodeOrBust(
+		ctx, t, config, "alice,bob", tlf.Private)
+	writeFile(canonicalRoot, "same", "same")
+	writeFile(canonicalRoot, "differs.txt", "canonical")
+	dir, _, err := kbfsOps.CreateDir(ctx, canonicalRoot, "dir")
+	require.NoError(t, err)
+	writeFile(dir, "a", "a")
+	require.NoError(t, kbfsOps.SyncAll(ctx, canonicalRoot.GetFolderBranch()))
+
+	h, err := ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "alice,bob", tlf.Private)
+	require.NoError(t, err)
+	ci, err := tlf.NewTestHandleExtensionStaticTime(
+		tlf.HandleExtensionConflict, 1, "")
+	require.NoError(t, err)
+	conflicted, err := ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "alice,bob "+ci.String(),
+		tlf.Private)
+	require.NoError(t, err)
+	require.NotEqual(t, h.TlfID(), conflicted.TlfID())
+	conflictedRoot, _, err := kbfsOps.GetOrCreateRootNode(
+		ctx, conflicted, MasterBranch)
+	require.NoError(t, err)
+	writeFile(conflictedRoot, "same", "same")
+	writeFile(conflictedRoot, "differs.txt", "conflicted")
+	writeFile(conflictedRoot, "new", "new")
+	dir, _, err = kbfsOps.CreateDir(ctx, conflictedRoot, "dir")
+	require.NoError(t, err)
+	writeFile(dir, "b", "b")
+	require.NoError(t, kbfsOps.SyncAll(ctx, conflictedRoot.GetFolderBranch()))
+
+	renamed := "differs.conflicted (conflicted copy 2016-03-14).txt"
+	expected := ConflictMergeReport{
+		Canonical: "/keybase/private/alice,bob",
+		Copied:    []string{"dir/b", "new"},
+		Identical: []string{"same"},
+		Renamed:   []ConflictMergeRename{{"differs.txt", renamed}},
+	}
+
+	t.Log("A dry run only reports the changes")
+	report, err := MergeConflictedFolder(ctx, config, conflicted, true)
+	require.NoError(t, err)
+	require.Equal(t, expected, report)
+	require.True(t, report.Conflicts())
+	children, err := kbfsOps.GetDirChildren(ctx, canonicalRoot)
+	require.NoError(t, err)
+	require.Len(t, children, 3)
+
+	t.Log("Merge the conflicted folder")
+	report, err = MergeConflictedFolder(ctx, config, conflicted, false)
+	require.NoError(t, err)
+	require.Equal(t, expected, report)
+	children, err = kbfsOps.GetDirChildren(ctx, canonicalRoot)
+	require.NoError(t, err)
+	require.Len(t, children, 5)
+	require.Equal(t, "canonical", readFile(canonicalRoot, "differs.txt"))
+	require.Equal(t, "conflicted", readFile(canonicalRoot, renamed))
+	require.Equal(t, "new", readFile(canonicalRoot, "new"))
+	dir, _, err = kbfsOps.Lookup(ctx, canonicalRoot, "dir")
+	require.NoError(t, err)
+	require.Equal(t, "a", readFile(dir, "a"))
+	require.Equal(t, "b", readFile(dir, "b"))
+
+	t.Log("Merging again finds everything already there")
+	report, err = MergeConflictedFolder(ctx, config, conflicted, false)
+	require.NoError(t, err)
+	require.Empty(t, report.Copied)
+	require.Equal(t, []string{"dir/b", "new", "same"}, report.Identical)
+	require.Equal(t, []ConflictMergeRename{{
+		"differs.txt", "differs.conflicted (conflicted copy 2016-03-14 #2).txt",
+	}}, report.Renamed)
+
+	t.Log("A folder that isn't conflicted can't be merged")
+	_, err = MergeConflictedFolder(ctx, config, h, false)
+	require.Error(t, err)
+}
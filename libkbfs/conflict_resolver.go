@@ -7,6 +7,7 @@ package libkbfs
 import (
 	"encoding/json"
 	"fmt"
+	stdpath "path"
 	"sort"
 	"strings"
 	"sync"
@@ -1226,10 +1227,27 @@ func (cr *ConflictResolver) buildChainsAndPaths(
 		return nil, nil, nil, nil, nil, nil, merged, err
 	}
 
+	unmergedChains, mergedChains, unmergedPaths, mergedPaths, recreateOps,
+		err = cr.buildChainsAndPathsFromMDs(ctx, lState, unmerged, merged)
+	if err != nil {
+		return nil, nil, nil, nil, nil, nil, merged, err
+	}
+	return unmergedChains, mergedChains, unmergedPaths, mergedPaths,
+		recreateOps, unmerged, merged, nil
+}
+
+// buildChainsAndPathsFromMDs does the work of buildChainsAndPaths
+// for the given non-empty list of unmerged MDs, and the merged MDs
+// that follow their branch point.
+func (cr *ConflictResolver) buildChainsAndPathsFromMDs(
+	ctx context.Context, lState *lockState,
+	unmerged, merged []ImmutableRootMetadata) (
+	unmergedChains, mergedChains *crChains, unmergedPaths []path,
+	mergedPaths map[BlockPointer]path, recreateOps []*createOp, err error) {
 	// Make the chains
 	unmergedChains, mergedChains, err = cr.makeChains(ctx, unmerged, merged)
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, merged, err
+		return nil, nil, nil, nil, nil, err
 	}
 
 	// TODO: if the root node didn't change in either chain, we can
@@ -1242,14 +1260,14 @@ func (cr *ConflictResolver) buildChainsAndPaths(
 	unmergedPaths, err = unmergedChains.getPaths(ctx, &cr.fbo.blocks,
 		cr.log, cr.fbo.nodeCache, false, cr.config.Mode().IsTestMode())
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, merged, err
+		return nil, nil, nil, nil, nil, err
 	}
 
 	// Add in any directory paths that were created in both branches.
 	newUnmergedPaths, err := cr.findCreatedDirsToMerge(ctx, unmergedPaths,
 		unmergedChains, mergedChains)
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, merged, err
+		return nil, nil, nil, nil, nil, err
 	}
 	unmergedPaths = append(unmergedPaths, newUnmergedPaths...)
 	if len(newUnmergedPaths) > 0 {
@@ -1260,7 +1278,7 @@ func (cr *ConflictResolver) buildChainsAndPaths(
 	kbpki := cr.config.KBPKI()
 	session, err := kbpki.GetCurrentSession(ctx)
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, merged, err
+		return nil, nil, nil, nil, nil, err
 	}
 
 	currUnmergedWriterInfo := newWriterInfo(session.UID,
@@ -1273,7 +1291,7 @@ func (cr *ConflictResolver) buildChainsAndPaths(
 		ctx, lState, unmergedPaths, unmergedChains, mergedChains,
 		currUnmergedWriterInfo)
 	if err != nil {
-		return nil, nil, nil, nil, nil, nil, merged, err
+		return nil, nil, nil, nil, nil, err
 	}
 	unmergedPaths = append(unmergedPaths, newUnmergedPaths...)
 	if len(newUnmergedPaths) > 0 {
@@ -1281,7 +1299,7 @@ func (cr *ConflictResolver) buildChainsAndPaths(
 	}
 
 	return unmergedChains, mergedChains, unmergedPaths, mergedPaths,
-		recreateOps, unmerged, merged, nil
+		recreateOps, nil
 }
 
 // addRecreateOpsToUnmergedChains inserts each recreateOp, into its
@@ -2223,7 +2241,8 @@ func (cr *ConflictResolver) computeActions(ctx context.Context,
 type fileBlockMap map[BlockPointer]map[string]*FileBlock
 
 func (cr *ConflictResolver) makeFileBlockDeepCopy(ctx context.Context,
-	lState *lockState, chains *crChains, mergedMostRecent BlockPointer,
+	lState *lockState, chains, otherChains *crChains,
+	mergedMostRecent BlockPointer,
 	parentPath path, name string, ptr BlockPointer, blocks fileBlockMap,
 	dirtyBcache DirtyBlockCache) (BlockPointer, error) {
 	kmd := chains.mostRecentChainMDInfo
@@ -2266,6 +2285,16 @@ func (cr *ConflictResolver) makeFileBlockDeepCopy(ctx context.Context,
 		for _, oldInfo := range oldInfos {
 			chains.toUnrefPointers[oldInfo.BlockPointer] = true
 		}
+	} else {
+		// Otherwise only the child blocks made within this branch
+		// are replaced by the copy, unless the other branch made
+		// them too (e.g., after a canceled sync that succeeded).
+		for _, oldInfo := range oldInfos {
+			if chains.createdOriginals[oldInfo.BlockPointer] &&
+				!otherChains.createdOriginals[oldInfo.BlockPointer] {
+				chains.toUnrefPointers[oldInfo.BlockPointer] = true
+			}
+		}
 	}
 
 	if _, ok := blocks[mergedMostRecent]; !ok {
@@ -2365,13 +2394,13 @@ func (cr *ConflictResolver) doOneAction(
 		unmergedFetcher := func(ctx context.Context, name string,
 			ptr BlockPointer) (BlockPointer, error) {
 			return cr.makeFileBlockDeepCopy(ctx, lState, unmergedChains,
-				mergedPath.tailPointer(), unmergedPath, name, ptr,
-				newFileBlocks, dirtyBcache)
+				mergedChains, mergedPath.tailPointer(), unmergedPath, name,
+				ptr, newFileBlocks, dirtyBcache)
 		}
 		mergedFetcher := func(ctx context.Context, name string,
 			ptr BlockPointer) (BlockPointer, error) {
 			return cr.makeFileBlockDeepCopy(ctx, lState, mergedChains,
-				mergedPath.tailPointer(), mergedPath, name,
+				unmergedChains, mergedPath.tailPointer(), mergedPath, name,
 				ptr, newFileBlocks, dirtyBcache)
 		}
 
@@ -3117,6 +3146,11 @@ func (cr *ConflictResolver) completeResolution(ctx context.Context,
 		return err
 	}
 
+	err = cr.fbo.ensureDataRegionLoaded(ctx)
+	if err != nil {
+		return err
+	}
+
 	// Put all the blocks.  TODO: deal with recoverable block errors?
 	_, err = doBlockPuts(ctx, cr.config.BlockServer(), cr.config.BlockCache(),
 		cr.config.Reporter(), cr.log, cr.deferLog, md.TlfID(),
@@ -3188,6 +3222,35 @@ func (e CRWrapError) Error() string {
 	return "Conflict resolution error: " + e.err.Error()
 }
 
+// reportConflictedCopies tells the event rule engine about the
+// conflicted copies made by the actions in `actionMap`.
+func (cr *ConflictResolver) reportConflictedCopies(ctx context.Context,
+	mergedPaths map[BlockPointer]path,
+	actionMap map[BlockPointer]crActionList) {
+	for ptr, actions := range actionMap {
+		p, ok := mergedPaths[ptr]
+		if !ok {
+			continue
+		}
+		for _, action := range actions {
+			var fromName, toName string
+			switch a := action.(type) {
+			case *renameUnmergedAction:
+				fromName, toName = a.fromName, a.toName
+			case *renameMergedAction:
+				fromName, toName = a.fromName, a.toName
+			default:
+				continue
+			}
+			if fromName == toName {
+				continue
+			}
+			cr.config.EventRuleEngine().conflictedCopyMade(
+				ctx, stdpath.Join(p.CanonicalPathString(), toName))
+		}
+	}
+}
+
 func (cr *ConflictResolver) doResolve(ctx context.Context, ci conflictInput) {
 	var err error
 	ctx = cr.config.MaybeStartTrace(ctx, "CR.doResolve",
@@ -3428,6 +3491,7 @@ func (cr *ConflictResolver) doResolve(ctx context.Context, ci conflictInput) {
 	if err != nil {
 		return
 	}
+	cr.reportConflictedCopies(ctx, mergedPaths, actionMap)
 
 	// TODO: If conflict resolution fails after some blocks were put,
 	// remember these and include them in the later resolution so they
@@ -0,0 +1,466 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"fmt"
+	stdpath "path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	"golang.org/x/net/context"
+)
+
+// conflictDiffMaxSize is the largest file that DiffConflictedCopy
+// makes a patch for.
+const conflictDiffMaxSize = 1 << 20
+
+// conflictedCopyRegexp matches the names given by
+// WriterDeviceDateConflictRenamer.
+var conflictedCopyRegexp = regexp.MustCompile(
+	`^(.*)\.conflicted \((.+)'s (.+) copy (\d{4}-\d{2}-\d{2})\)(.*)$`)
+
+// ConflictedCopy is a file renamed by conflict resolution, paired
+// with the file it conflicted with.  Paths are relative to the
+// directory that was searched.
+type ConflictedCopy struct {
+	Path     string
+	Original string
+	// Writer and Device made the conflicting write, on Date
+	// (YYYY-MM-DD).
+	Writer  string
+	Device  string
+	Date    string
+	Size    uint64
+	ModTime time.Time
+	// OriginalExists is false if the original has since been
+	// removed or renamed, in which case the other original fields
+	// are zero.
+	OriginalExists  bool
+	OriginalSize    uint64
+	OriginalModTime time.Time
+	// Identical is true if the copy has the same contents as the
+	// original.
+	Identical bool
+}
+
+// ParseConflictedCopyName returns the name of the original file,
+// and who made the conflicting write when, if `name` is the name of
+// a conflicted copy.
+func ParseConflictedCopyName(name string) (
+	original, writer, device, date string, ok bool) {
+	m := conflictedCopyRegexp.FindStringSubmatch(name)
+	if m == nil {
+		return "", "", "", "", false
+	}
+	return m[1] + m[5], m[2], m[3], m[4], true
+}
+
+// lookupPath returns the node at the slash-separated path `p` under
+// `dir`.
+func lookupPath(ctx context.Context, kbfsOps KBFSOps, dir Node, p string) (
+	Node, EntryInfo, error) {
+	n := dir
+	var ei EntryInfo
+	for _, name := range strings.Split(p, "/") {
+		if name == "" || name == "." {
+			continue
+		}
+		var err error
+		n, ei, err = kbfsOps.Lookup(ctx, n, name)
+		if err != nil {
+			return nil, EntryInfo{}, err
+		}
+	}
+	return n, ei, nil
+}
+
+// readWholeFile returns the contents of the file at `n`, which is
+// `size` bytes long.
+func readWholeFile(ctx context.Context, kbfsOps KBFSOps, n Node,
+	size uint64) ([]byte, error) {
+	buf := make([]byte, size)
+	var off int64
+	for off < int64(size) {
+		nRead, err := kbfsOps.Read(ctx, n, buf[off:], off)
+		if err != nil {
+			return nil, err
+		}
+		if nRead == 0 {
+			break
+		}
+		off += nRead
+	}
+	return buf[:off], nil
+}
+
+type conflictedCopyFinder struct {
+	kbfsOps KBFSOps
+	copies  []ConflictedCopy
+}
+
+func (f *conflictedCopyFinder) walk(
+	ctx context.Context, dir Node, dirPath string) error {
+	children, err := f.kbfsOps.GetDirChildren(ctx, dir)
+	if err != nil {
+		return err
+	}
+	for name, ei := range children {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		p := stdpath.Join(dirPath, name)
+		if ei.Type == Dir {
+			child, _, err := f.kbfsOps.Lookup(ctx, dir, name)
+			if err != nil {
+				return err
+			}
+			err = f.walk(ctx, child, p)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if ei.Type != File && ei.Type != Exec {
+			continue
+		}
+		original, writer, device, date, ok := ParseConflictedCopyName(name)
+		if !ok {
+			continue
+		}
+
+		cc := ConflictedCopy{
+			Path:     p,
+			Original: stdpath.Join(dirPath, original),
+			Writer:   writer,
+			Device:   device,
+			Date:     date,
+			Size:     ei.Size,
+			ModTime:  time.Unix(0, ei.Mtime),
+		}
+		if oei, ok := children[original]; ok &&
+			(oei.Type == File || oei.Type == Exec) {
+			cc.OriginalExists = true
+			cc.OriginalSize = oei.Size
+			cc.OriginalModTime = time.Unix(0, oei.Mtime)
+			if oei.Size == ei.Size {
+				cc.Identical, err = f.sameContents(
+					ctx, dir, name, original, ei.Size)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		f.copies = append(f.copies, cc)
+	}
+	return nil
+}
+
+func (f *conflictedCopyFinder) sameContents(ctx context.Context, dir Node,
+	name1, name2 string, size uint64) (bool, error) {
+	// Don't let the scan push everything else out of the recent
+	// files list.
+	ctx = CtxSkipRecentFiles(ctx)
+	var contents [2][]byte
+	for i, name := range []string{name1, name2} {
+		n, _, err := f.kbfsOps.Lookup(ctx, dir, name)
+		if err != nil {
+			return false, err
+		}
+		contents[i], err = readWholeFile(ctx, f.kbfsOps, n, size)
+		if err != nil {
+			return false, err
+		}
+	}
+	return bytes.Equal(contents[0], contents[1]), nil
+}
+
+// FindConflictedCopies returns the conflicted copies of files under
+// `dir`, sorted by path.  Conflicted copies of directories and
+// symlinks aren't included.
+func FindConflictedCopies(ctx context.Context, config Config, dir Node) (
+	[]ConflictedCopy, error) {
+	f := &conflictedCopyFinder{kbfsOps: config.KBFSOps()}
+	err := f.walk(ctx, dir, "")
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(f.copies, func(i, j int) bool {
+		return f.copies[i].Path < f.copies[j].Path
+	})
+	return f.copies, nil
+}
+
+// ConflictedCopyDiff is the difference between a conflicted copy and
+// its original.
+type ConflictedCopyDiff struct {
+	// Patch is a unified diff from the original to the copy.  It's
+	// empty if either file is binary or too large.
+	Patch    string
+	IsBinary bool
+	TooLarge bool
+}
+
+// DiffConflictedCopy returns the difference between the contents of
+// `cc`, found under `dir`, and its original.  A missing original is
+// treated as empty.
+func DiffConflictedCopy(ctx context.Context, config Config, dir Node,
+	cc ConflictedCopy) (ConflictedCopyDiff, error) {
+	if cc.Size > conflictDiffMaxSize ||
+		cc.OriginalSize > conflictDiffMaxSize {
+		return ConflictedCopyDiff{TooLarge: true}, nil
+	}
+	kbfsOps := config.KBFSOps()
+	ctx = CtxSkipRecentFiles(ctx)
+	var contents [2][]byte
+	for i, p := range []string{cc.Original, cc.Path} {
+		if i == 0 && !cc.OriginalExists {
+			continue
+		}
+		n, ei, err := lookupPath(ctx, kbfsOps, dir, p)
+		if err != nil {
+			return ConflictedCopyDiff{}, err
+		}
+		contents[i], err = readWholeFile(ctx, kbfsOps, n, ei.Size)
+		if err != nil {
+			return ConflictedCopyDiff{}, err
+		}
+		if bytes.IndexByte(contents[i], 0) >= 0 {
+			return ConflictedCopyDiff{IsBinary: true}, nil
+		}
+	}
+
+	patch, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(contents[0])),
+		B:        difflib.SplitLines(string(contents[1])),
+		FromFile: cc.Original,
+		ToFile:   cc.Path,
+		Context:  3,
+	})
+	if err != nil {
+		return ConflictedCopyDiff{}, errors.WithStack(err)
+	}
+	return ConflictedCopyDiff{Patch: patch}, nil
+}
+
+// ConflictResolution says how ResolveConflictedCopies deals with
+// conflicted copies.
+type ConflictResolution int
+
+const (
+	// ConflictKeepNewest keeps whichever of the original and its
+	// copies was modified last, under the original's name, and
+	// deletes the rest.
+	ConflictKeepNewest ConflictResolution = iota
+	// ConflictKeepBoth keeps every copy, renamed to a friendlier
+	// name that no longer marks it as conflicted.
+	ConflictKeepBoth
+	// ConflictDeleteCopies deletes the copies, keeping the
+	// originals.
+	ConflictDeleteCopies
+)
+
+func (r ConflictResolution) String() string {
+	switch r {
+	case ConflictKeepNewest:
+		return "keep-newest"
+	case ConflictKeepBoth:
+		return "keep-both"
+	case ConflictDeleteCopies:
+		return "delete-copies"
+	default:
+		return fmt.Sprintf("ConflictResolution(%d)", int(r))
+	}
+}
+
+// ParseConflictResolution parses the String() form of a
+// ConflictResolution.
+func ParseConflictResolution(s string) (ConflictResolution, error) {
+	for _, r := range []ConflictResolution{
+		ConflictKeepNewest, ConflictKeepBoth, ConflictDeleteCopies} {
+		if s == r.String() {
+			return r, nil
+		}
+	}
+	return 0, errors.Errorf("Unknown conflict resolution %q", s)
+}
+
+// ConflictedCopyAction is what ResolveConflictedCopies did, or would
+// do, with a file.
+type ConflictedCopyAction struct {
+	Path string
+	// NewPath is where the file was moved, or empty if it was
+	// deleted.
+	NewPath string
+}
+
+type conflictResolver struct {
+	kbfsOps KBFSOps
+	dir     Node
+	dryRun  bool
+	// taken holds the paths that renames in a dry run would create.
+	taken   map[string]bool
+	actions []ConflictedCopyAction
+}
+
+func (r *conflictResolver) exists(ctx context.Context, p string) (
+	bool, error) {
+	if r.taken[p] {
+		return true, nil
+	}
+	_, _, err := lookupPath(ctx, r.kbfsOps, r.dir, p)
+	switch errors.Cause(err).(type) {
+	case nil:
+		return true, nil
+	case NoSuchNameError:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func (r *conflictResolver) move(ctx context.Context, from, to string) error {
+	r.actions = append(r.actions, ConflictedCopyAction{from, to})
+	if r.dryRun {
+		r.taken[to] = true
+		return nil
+	}
+	fromDir, _, err := lookupPath(ctx, r.kbfsOps, r.dir, stdpath.Dir(from))
+	if err != nil {
+		return err
+	}
+	toDir, _, err := lookupPath(ctx, r.kbfsOps, r.dir, stdpath.Dir(to))
+	if err != nil {
+		return err
+	}
+	return r.kbfsOps.Rename(
+		ctx, fromDir, stdpath.Base(from), toDir, stdpath.Base(to))
+}
+
+func (r *conflictResolver) remove(ctx context.Context, p string) error {
+	r.actions = append(r.actions, ConflictedCopyAction{Path: p})
+	if r.dryRun {
+		return nil
+	}
+	dir, _, err := lookupPath(ctx, r.kbfsOps, r.dir, stdpath.Dir(p))
+	if err != nil {
+		return err
+	}
+	return r.kbfsOps.RemoveEntry(ctx, dir, stdpath.Base(p))
+}
+
+// keepBothName returns an unused name for `cc` that doesn't mark it
+// as conflicted.
+func (r *conflictResolver) keepBothName(
+	ctx context.Context, cc ConflictedCopy) (string, error) {
+	base, ext := splitExtension(cc.Original)
+	for i := 1; ; i++ {
+		suffix := ""
+		if i > 1 {
+			suffix = fmt.Sprintf(" %d", i)
+		}
+		p := fmt.Sprintf("%s (%s's copy%s)%s", base, cc.Writer, suffix, ext)
+		exists, err := r.exists(ctx, p)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return p, nil
+		}
+	}
+}
+
+// keepNewest resolves the copies of one original.
+func (r *conflictResolver) keepNewest(
+	ctx context.Context, copies []ConflictedCopy) error {
+	newest := -1
+	var newestTime time.Time
+	if copies[0].OriginalExists {
+		newestTime = copies[0].OriginalModTime
+	}
+	for i, cc := range copies {
+		if newest == -1 && !copies[0].OriginalExists ||
+			cc.ModTime.After(newestTime) {
+			newest = i
+			newestTime = cc.ModTime
+		}
+	}
+	for i, cc := range copies {
+		if i != newest {
+			err := r.remove(ctx, cc.Path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if newest >= 0 {
+		return r.move(ctx, copies[newest].Path, copies[newest].Original)
+	}
+	return nil
+}
+
+// ResolveConflictedCopies deals with `copies`, as returned by
+// FindConflictedCopies for `dir`, according to `resolution`, and
+// returns what it did.  With `dryRun`, nothing is changed, and the
+// returned actions preview the resolution.
+func ResolveConflictedCopies(ctx context.Context, config Config, dir Node,
+	copies []ConflictedCopy, resolution ConflictResolution, dryRun bool) (
+	[]ConflictedCopyAction, error) {
+	r := &conflictResolver{
+		kbfsOps: config.KBFSOps(),
+		dir:     dir,
+		dryRun:  dryRun,
+		taken:   make(map[string]bool),
+	}
+	switch resolution {
+	case ConflictKeepNewest:
+		byOriginal := make(map[string][]ConflictedCopy)
+		var originals []string
+		for _, cc := range copies {
+			if _, ok := byOriginal[cc.Original]; !ok {
+				originals = append(originals, cc.Original)
+			}
+			byOriginal[cc.Original] = append(byOriginal[cc.Original], cc)
+		}
+		for _, original := range originals {
+			err := r.keepNewest(ctx, byOriginal[original])
+			if err != nil {
+				return r.actions, err
+			}
+		}
+	case ConflictKeepBoth:
+		for _, cc := range copies {
+			p, err := r.keepBothName(ctx, cc)
+			if err != nil {
+				return r.actions, err
+			}
+			err = r.move(ctx, cc.Path, p)
+			if err != nil {
+				return r.actions, err
+			}
+		}
+	case ConflictDeleteCopies:
+		for _, cc := range copies {
+			err := r.remove(ctx, cc.Path)
+			if err != nil {
+				return r.actions, err
+			}
+		}
+	default:
+		return nil, errors.Errorf("Unknown conflict resolution %s", resolution)
+	}
+	if dryRun || len(r.actions) == 0 {
+		return r.actions, nil
+	}
+	return r.actions, r.kbfsOps.SyncAll(ctx, dir.GetFolderBranch())
+}
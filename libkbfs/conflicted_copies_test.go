@@ -0,0 +1,155 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConflictedCopyName(t *testing.T) {
+	original, writer, device, date, ok := ParseConflictedCopyName(
+		"notes.conflicted (bob's laptop copy 2018-03-04).tar.gz")
+	require.True(t, ok)
+	require.Equal(t, "notes.tar.gz", original)
+	require.Equal(t, "bob", writer)
+	require.Equal(t, "laptop", device)
+	require.Equal(t, "2018-03-04", date)
+
+	_, _, _, _, ok = ParseConflictedCopyName("notes.tar.gz")
+	require.False(t, ok)
+}
+
+func TestConflictedCopies(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	kbfsOps := config.KBFSOps()
+	now := time.Date(2018, 3, 4, 12, 0, 0, 0, time.UTC)
+	createFile := func(dir Node, name, data string, age time.Duration) {
+		n, _, err := kbfsOps.CreateFile(ctx, dir, name, false, NoExcl)
+		require.NoError(t, err)
+		err = kbfsOps.Write(ctx, n, []byte(data), 0)
+		require.NoError(t, err)
+		mtime := now.Add(-age)
+		err = kbfsOps.SetMtime(ctx, n, &mtime)
+		require.NoError(t, err)
+	}
+	copyName := func(name, writer string) string {
+		base, ext := splitExtension(name)
+		return base + ".conflicted (" + writer +
+			"'s laptop copy 2018-03-04)" + ext
+	}
+
+	dir, _, err := kbfsOps.CreateDir(ctx, rootNode, "dir")
+	require.NoError(t, err)
+	createFile(rootNode, "a.txt", "one\ntwo\n", time.Hour)
+	createFile(rootNode, copyName("a.txt", "bob"), "one\nthree\n", time.Minute)
+	createFile(dir, "b", "same", 0)
+	createFile(dir, copyName("b", "bob"), "same", time.Hour)
+	createFile(dir, copyName("b", "carol"), "diff", 2*time.Hour)
+	createFile(rootNode, copyName("gone.txt", "bob"), "x", 0)
+	require.NoError(t, kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch()))
+
+	copies, err := FindConflictedCopies(ctx, config, rootNode)
+	require.NoError(t, err)
+	require.Len(t, copies, 4)
+	require.Equal(t, copyName("a.txt", "bob"), copies[0].Path)
+	require.Equal(t, "a.txt", copies[0].Original)
+	require.Equal(t, "bob", copies[0].Writer)
+	require.Equal(t, "laptop", copies[0].Device)
+	require.True(t, copies[0].OriginalExists)
+	require.True(t, now.Add(-time.Minute).Equal(copies[0].ModTime))
+	require.True(t, now.Add(-time.Hour).Equal(copies[0].OriginalModTime))
+	require.False(t, copies[0].Identical)
+	require.Equal(t, "dir/"+copyName("b", "bob"), copies[1].Path)
+	require.True(t, copies[1].Identical)
+	require.Equal(t, "dir/"+copyName("b", "carol"), copies[2].Path)
+	require.False(t, copies[2].Identical)
+	require.Equal(t, "gone.txt", copies[3].Original)
+	require.False(t, copies[3].OriginalExists)
+
+	diff, err := DiffConflictedCopy(ctx, config, rootNode, copies[0])
+	require.NoError(t, err)
+	require.False(t, diff.IsBinary)
+	require.True(t, strings.Contains(diff.Patch, "-two\n+three\n"), diff.Patch)
+
+	t.Log("Dry runs only preview the resolution")
+	actions, err := ResolveConflictedCopies(
+		ctx, config, rootNode, copies, ConflictKeepBoth, true)
+	require.NoError(t, err)
+	require.Equal(t, []ConflictedCopyAction{
+		{copies[0].Path, "a (bob's copy).txt"},
+		{copies[1].Path, "dir/b (bob's copy)"},
+		{copies[2].Path, "dir/b (carol's copy)"},
+		{copies[3].Path, "gone (bob's copy).txt"},
+	}, actions)
+	again, err := FindConflictedCopies(ctx, config, rootNode)
+	require.NoError(t, err)
+	require.Equal(t, copies, again)
+
+	t.Log("Keeping the newest replaces older originals and drops the rest")
+	actions, err = ResolveConflictedCopies(
+		ctx, config, rootNode, copies, ConflictKeepNewest, false)
+	require.NoError(t, err)
+	require.Equal(t, []ConflictedCopyAction{
+		{copies[0].Path, "a.txt"},
+		{Path: copies[1].Path},
+		{Path: copies[2].Path},
+		{copies[3].Path, "gone.txt"},
+	}, actions)
+	copies, err = FindConflictedCopies(ctx, config, rootNode)
+	require.NoError(t, err)
+	require.Len(t, copies, 0)
+	n, ei, err := kbfsOps.Lookup(ctx, rootNode, "a.txt")
+	require.NoError(t, err)
+	data, err := readWholeFile(ctx, kbfsOps, n, ei.Size)
+	require.NoError(t, err)
+	require.Equal(t, "one\nthree\n", string(data))
+	_, _, err = kbfsOps.Lookup(ctx, rootNode, "gone.txt")
+	require.NoError(t, err)
+}
+
+func TestConflictedCopiesDeleteAndKeepBoth(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	kbfsOps := config.KBFSOps()
+	for _, name := range []string{
+		"a", "a.conflicted (bob's laptop copy 2018-03-04)",
+		"a (bob's copy)", "b", "b.conflicted (bob's phone copy 2018-03-04)",
+	} {
+		_, _, err := kbfsOps.CreateFile(ctx, rootNode, name, false, NoExcl)
+		require.NoError(t, err)
+	}
+	copies, err := FindConflictedCopies(ctx, config, rootNode)
+	require.NoError(t, err)
+	require.Len(t, copies, 2)
+
+	t.Log("Kept copies get names that aren't taken yet")
+	actions, err := ResolveConflictedCopies(
+		ctx, config, rootNode, copies[:1], ConflictKeepBoth, false)
+	require.NoError(t, err)
+	require.Equal(t, []ConflictedCopyAction{
+		{copies[0].Path, "a (bob's copy 2)"},
+	}, actions)
+	_, _, err = kbfsOps.Lookup(ctx, rootNode, "a (bob's copy 2)")
+	require.NoError(t, err)
+
+	_, err = ResolveConflictedCopies(
+		ctx, config, rootNode, copies[1:], ConflictDeleteCopies, false)
+	require.NoError(t, err)
+	children, err := kbfsOps.GetDirChildren(ctx, rootNode)
+	require.NoError(t, err)
+	require.Len(t, children, 4)
+	_, ok := children["b"]
+	require.True(t, ok)
+}
@@ -0,0 +1,209 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// fileContentsReader reads the current (possibly dirty) plaintext
+// contents of a file, starting at `off` and stopping at `end`, or at
+// the end of the file if `end` is negative.
+type fileContentsReader struct {
+	ctx    context.Context
+	lState *lockState
+	blocks *folderBlockOps
+	kmd    KeyMetadata
+	file   Node
+	off    int64
+	end    int64
+}
+
+var _ io.Reader = (*fileContentsReader)(nil)
+
+func (r *fileContentsReader) Read(p []byte) (int, error) {
+	if r.end >= 0 && int64(len(p)) > r.end-r.off {
+		p = p[:r.end-r.off]
+	}
+	if len(p) == 0 {
+		return 0, io.EOF
+	}
+	n, err := r.blocks.Read(r.ctx, r.lState, r.kmd, r.file, p, r.off)
+	if err != nil {
+		return int(n), err
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	r.off += n
+	return int(n), nil
+}
+
+// contentQuarantineDirName is the directory, under the storage root,
+// where the contents of rejected files are kept.
+const contentQuarantineDirName = "kbfs_quarantine"
+
+// QuarantinedFile describes a file rejected by the content scanner,
+// whose contents were moved out of KBFS into a local copy.
+type QuarantinedFile struct {
+	// Path is the canonical KBFS path of the file.
+	Path   string
+	Reason string
+	Time   time.Time
+	// LocalCopy is where the rejected contents of the file are
+	// kept on this device.
+	LocalCopy string
+}
+
+func contentQuarantineDir(config Config, tlfID tlf.ID) string {
+	root := config.StorageRoot()
+	if root == "" {
+		return ""
+	}
+	return filepath.Join(root, contentQuarantineDirName, tlfID.String())
+}
+
+// ContentQuarantine returns the files of the given TLF that were
+// quarantined on this device, oldest first.
+func ContentQuarantine(config Config, tlfID tlf.ID) (
+	[]QuarantinedFile, error) {
+	dir := contentQuarantineDir(config, tlfID)
+	if dir == "" {
+		return nil, nil
+	}
+	fileInfos, err := ioutil.ReadDir(dir)
+	if ioutil.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var files []QuarantinedFile
+	for _, fi := range fileInfos {
+		if !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+		var qf QuarantinedFile
+		err := ioutil.DeserializeFromJSONFile(
+			filepath.Join(dir, fi.Name()), &qf)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, qf)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Time.Before(files[j].Time)
+	})
+	return files, nil
+}
+
+// ReleaseContentQuarantine deletes the local copies of the files of
+// the given TLF that were quarantined on this device, e.g. once
+// they've been inspected.
+func ReleaseContentQuarantine(config Config, tlfID tlf.ID) error {
+	dir := contentQuarantineDir(config, tlfID)
+	if dir == "" {
+		return nil
+	}
+	return ioutil.RemoveAll(dir)
+}
+
+// quarantineFileLocked moves the contents of the rejected `file` into
+// a local copy in the TLF's quarantine directory, and truncates the
+// file, so that its contents are never put to the servers or to the
+// journal.
+func (fbo *folderBranchOps) quarantineFileLocked(
+	ctx context.Context, lState *lockState,
+	kmd KeyMetadataWithRootDirEntry, file Node, p, reason string) error {
+	fbo.mdWriterLock.AssertLocked(lState)
+	dir := contentQuarantineDir(fbo.config, fbo.id())
+	if dir == "" {
+		return ContentScanRejectedError{p, reason}
+	}
+	err := ioutil.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	now := fbo.config.Clock().Now()
+	name := fmt.Sprintf("%d", now.UnixNano())
+	qf := QuarantinedFile{
+		Path:      p,
+		Reason:    reason,
+		Time:      now,
+		LocalCopy: filepath.Join(dir, name+".data"),
+	}
+	f, err := ioutil.OpenFile(
+		qf.LocalCopy, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	r := &fileContentsReader{ctx, lState, &fbo.blocks, kmd, file, 0, -1}
+	_, err = io.Copy(f, r)
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return errors.WithStack(closeErr)
+	}
+	err = ioutil.SerializeToJSONFile(qf, filepath.Join(dir, name+".json"))
+	if err != nil {
+		return err
+	}
+
+	return fbo.blocks.Truncate(ctx, lState, kmd, file, 0)
+}
+
+// scanDirtyFilesLocked runs the configured content scanner, if any,
+// over the full contents of each of the given dirty files, before
+// any of their blocks are put.  Scanning only the written ranges
+// would miss anything that spans a write and older data.  The contents of rejected files are
+// quarantined on this device, and the files are truncated, so that
+// the rest of the TLF can still be synced.  If a file can't be
+// quarantined, the rejection is returned as an error.
+func (fbo *folderBranchOps) scanDirtyFilesLocked(
+	ctx context.Context, lState *lockState,
+	kmd KeyMetadataWithRootDirEntry, dirtyFiles []BlockRef) error {
+	fbo.mdWriterLock.AssertLocked(lState)
+	scanner := fbo.config.ContentScanner()
+	if scanner == nil {
+		return nil
+	}
+
+	for _, ref := range dirtyFiles {
+		node := fbo.nodeCache.Get(ref)
+		if node == nil || fbo.nodeCache.IsUnlinked(node) {
+			// Unlinked files won't be visible to anyone else.
+			continue
+		}
+		p := fbo.nodeCache.PathFromNode(node).CanonicalPathString()
+		r := &fileContentsReader{ctx, lState, &fbo.blocks, kmd, node, 0, -1}
+		verdict, reason, err := scanner.ScanFile(ctx, p, r)
+		if err != nil {
+			return err
+		}
+		if verdict == ContentScanClean {
+			continue
+		}
+
+		fbo.log.CWarningf(ctx, "Content scan rejected %s: %s", p, reason)
+		err = fbo.quarantineFileLocked(ctx, lState, kmd, node, p, reason)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
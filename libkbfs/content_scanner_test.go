@@ -0,0 +1,152 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+// testContentScanner rejects any file containing "EICAR".
+type testContentScanner struct {
+	scanned []string
+}
+
+func (tcs *testContentScanner) ScanFile(
+	_ context.Context, path string, contents io.Reader) (
+	ContentScanVerdict, string, error) {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(contents); err != nil {
+		return ContentScanClean, "", err
+	}
+	tcs.scanned = append(tcs.scanned,
+		fmt.Sprintf("%s:%s", path, buf.Bytes()))
+	if bytes.Contains(buf.Bytes(), []byte("EICAR")) {
+		return ContentScanRejected, "test signature", nil
+	}
+	return ContentScanClean, "", nil
+}
+
+func TestContentScannerRejectsWithoutQuarantine(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	scanner := &testContentScanner{}
+	config.SetContentScanner(scanner)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	kbfsOps := config.KBFSOps()
+	clean, _, err := kbfsOps.CreateFile(ctx, rootNode, "clean", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, clean, []byte("hello"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+
+	t.Log("The whole file is scanned again after each change")
+	err = kbfsOps.Write(ctx, clean, []byte(" world EIC"), 5)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"/keybase/private/alice/clean:hello",
+		"/keybase/private/alice/clean:hello world EIC",
+	}, scanner.scanned)
+
+	t.Log("A signature completed by a later write is caught")
+	err = kbfsOps.Write(ctx, clean, []byte("AR"), 15)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.IsType(t, ContentScanRejectedError{}, err)
+	err = kbfsOps.Truncate(ctx, clean, 5)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+
+	t.Log("Without a storage root, a rejected file can't be synced")
+	bad, _, err := kbfsOps.CreateFile(ctx, rootNode, "bad", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, bad, []byte("xxEICARxx"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.IsType(t, ContentScanRejectedError{}, err)
+
+	// Once the file is gone, syncing works again.
+	err = kbfsOps.RemoveEntry(ctx, rootNode, "bad")
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+}
+
+func TestContentScannerQuarantine(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	tempdir, err := ioutil.TempDir(os.TempDir(), "content_scan")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		assert.NoError(t, err)
+	}()
+	config.storageRoot = tempdir
+	err = config.EnableDiskLimiter(tempdir)
+	require.NoError(t, err)
+	err = config.EnableJournaling(
+		ctx, tempdir, TLFJournalBackgroundWorkEnabled)
+	require.NoError(t, err)
+	jServer, err := GetJournalServer(config)
+	require.NoError(t, err)
+	err = jServer.EnableAuto(ctx)
+	require.NoError(t, err)
+	config.SetContentScanner(&testContentScanner{})
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	tlfID := rootNode.GetFolderBranch().Tlf
+	kbfsOps := config.KBFSOps()
+	bad, _, err := kbfsOps.CreateFile(ctx, rootNode, "bad", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, bad, []byte("EICAR"), 0)
+	require.NoError(t, err)
+	clean, _, err := kbfsOps.CreateFile(ctx, rootNode, "clean", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, clean, []byte("hello"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+
+	t.Log("The rest of the folder flushes as usual")
+	err = jServer.Wait(ctx, tlfID)
+	require.NoError(t, err)
+	ei, err := kbfsOps.Stat(ctx, clean)
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), ei.Size)
+
+	t.Log("The rejected contents only exist in the local quarantine")
+	ei, err = kbfsOps.Stat(ctx, bad)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), ei.Size)
+	files, err := ContentQuarantine(config, tlfID)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.Equal(t, "/keybase/private/alice/bad", files[0].Path)
+	require.Equal(t, "test signature", files[0].Reason)
+	data, err := ioutil.ReadFile(files[0].LocalCopy)
+	require.NoError(t, err)
+	require.Equal(t, []byte("EICAR"), data)
+
+	err = jServer.ReleaseQuarantine(ctx, tlfID)
+	require.NoError(t, err)
+	files, err = ContentQuarantine(config, tlfID)
+	require.NoError(t, err)
+	require.Len(t, files, 0)
+}
@@ -0,0 +1,134 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sort"
+
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// CRPlanEntry lists the actions conflict resolution would take on
+// one directory of the merged branch.
+type CRPlanEntry struct {
+	Path    string
+	Actions []string
+}
+
+// CRPlan describes how conflict resolution would resolve the
+// unmerged changes this device made to a TLF, without the plan
+// having been applied.  It is suitable for encoding directly as
+// JSON.
+type CRPlan struct {
+	BranchID string
+	// UnmergedRevisions are the revisions on this device's unmerged
+	// branch, and MergedRevisions are the revisions made on the
+	// merged branch since the unmerged branch was created.
+	UnmergedRevisions []kbfsmd.Revision
+	MergedRevisions   []kbfsmd.Revision
+	// RecreateOps create the directories, modified in the unmerged
+	// branch but removed in the merged branch, that would be
+	// recreated to hold the unmerged changes.
+	RecreateOps []string `json:",omitempty"`
+	// Entries, sorted by path, are the actions that would apply the
+	// unmerged changes to the merged branch.  Conflicts show up as
+	// actions that rename an entry to a conflicted copy.
+	Entries []CRPlanEntry `json:",omitempty"`
+}
+
+// SimulateConflictResolution returns the plan conflict resolution
+// would carry out for the given folder, as of the MDs currently on
+// the server, without applying it.  To keep the folder unresolved
+// while it's being inspected, conflict resolution of the folder is
+// stopped for the rest of the life of this process, so this is meant
+// for use by debugging tools.
+func SimulateConflictResolution(ctx context.Context, config Config,
+	folderBranch FolderBranch) (CRPlan, error) {
+	kbfsOps, ok := config.KBFSOps().(*KBFSOpsStandard)
+	if !ok {
+		return CRPlan{}, errors.New("Unexpected KBFSOps type")
+	}
+
+	ops := kbfsOps.getOpsNoAdd(ctx, folderBranch)
+	// Pause before the head is first loaded, since loading an
+	// unmerged head kicks off a resolution.
+	ops.cr.Pause()
+	lState := makeFBOLockState()
+	_, err := ops.getMDForReadNeedIdentify(ctx, lState)
+	if err != nil {
+		return CRPlan{}, err
+	}
+	return ops.cr.makePlan(ctx, lState)
+}
+
+// makePlan computes the plan for the current unmerged changes of
+// the folder, like doResolve does up to the point where it starts
+// applying the actions.
+func (cr *ConflictResolver) makePlan(
+	ctx context.Context, lState *lockState) (CRPlan, error) {
+	unmerged, merged, err := cr.getMDs(ctx, lState, false)
+	if err != nil {
+		return CRPlan{}, err
+	}
+	if len(unmerged) == 0 {
+		return CRPlan{}, errors.Errorf(
+			"%s has no unmerged changes", cr.fbo.folderBranch)
+	}
+
+	plan := CRPlan{BranchID: unmerged[0].BID().String()}
+	for _, md := range unmerged {
+		plan.UnmergedRevisions = append(plan.UnmergedRevisions, md.Revision())
+	}
+	for _, md := range merged {
+		plan.MergedRevisions = append(plan.MergedRevisions, md.Revision())
+	}
+
+	unmergedChains, mergedChains, unmergedPaths, mergedPaths, recOps, err :=
+		cr.buildChainsAndPathsFromMDs(ctx, lState, unmerged, merged)
+	if err != nil {
+		return CRPlan{}, err
+	}
+	if len(mergedPaths) == 0 || len(merged) == 0 {
+		// The unmerged branch would just be fast-forwarded onto the
+		// merged branch.
+		return plan, nil
+	}
+	for _, op := range recOps {
+		plan.RecreateOps = append(plan.RecreateOps, op.String())
+	}
+
+	mostRecentMergedMD := merged[len(merged)-1]
+	mostRecentMergedWriterInfo := newWriterInfo(
+		mostRecentMergedMD.LastModifyingWriter(),
+		mostRecentMergedMD.LastModifyingWriterVerifyingKey(),
+		mostRecentMergedMD.Revision())
+	actionMap, _, err := cr.computeActions(
+		ctx, unmergedChains, mergedChains, unmergedPaths, mergedPaths,
+		recOps, mostRecentMergedWriterInfo)
+	if err != nil {
+		return CRPlan{}, err
+	}
+
+	seen := make(map[BlockPointer]bool)
+	for _, p := range mergedPaths {
+		ptr := p.tailPointer()
+		actions := actionMap[ptr]
+		if seen[ptr] || len(actions) == 0 {
+			continue
+		}
+		seen[ptr] = true
+		entry := CRPlanEntry{Path: p.String()}
+		for _, action := range actions {
+			entry.Actions = append(entry.Actions, action.String())
+		}
+		plan.Entries = append(plan.Entries, entry)
+	}
+	sort.Slice(plan.Entries, func(i, j int) bool {
+		return plan.Entries[i].Path < plan.Entries[j].Path
+	})
+	return plan, nil
+}
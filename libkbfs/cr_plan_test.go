@@ -0,0 +1,72 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	kbname "github.com/keybase/client/go/kbun"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimulateConflictResolution(t *testing.T) {
+	var userName1, userName2 kbname.NormalizedUsername = "u1", "u2"
+	config1, uid1, ctx, cancel := kbfsOpsConcurInit(t, userName1, userName2)
+	defer kbfsConcurTestShutdown(t, config1, ctx, cancel)
+
+	config2 := ConfigAsUser(config1, userName2)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	session2, err := config2.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+	uid2 := session2.UID
+
+	clock, now := newTestClockAndTimeNow()
+	config2.SetClock(clock)
+
+	name := userName1.String() + "," + userName2.String()
+	configs := map[keybase1.UID]Config{uid1: config1, uid2: config2}
+	nodesRoot := testCRSharedFolderForUsers(
+		t, ctx, name, uid1, configs, []string{"root"})
+	dirRoot1 := nodesRoot[uid1]
+	dirRoot2 := nodesRoot[uid2]
+	fb := dirRoot1.GetFolderBranch()
+
+	t.Log("Without unmerged changes, there's nothing to plan")
+	_, err = SimulateConflictResolution(ctx, config2, fb)
+	require.Error(t, err)
+
+	_, err = DisableUpdatesForTesting(config2, fb)
+	require.NoError(t, err)
+
+	t.Log("Both users create the same file")
+	_, _, err = config1.KBFSOps().CreateFile(
+		ctx, dirRoot1, "file1", false, NoExcl)
+	require.NoError(t, err)
+	err = config1.KBFSOps().SyncAll(ctx, fb)
+	require.NoError(t, err)
+	_, _, err = config2.KBFSOps().CreateFile(
+		ctx, dirRoot2, "file1", true, NoExcl)
+	require.NoError(t, err)
+	err = config2.KBFSOps().SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	t.Log("The plan renames user 2's file to a conflicted copy")
+	plan, err := SimulateConflictResolution(ctx, config2, fb)
+	require.NoError(t, err)
+	require.Len(t, plan.UnmergedRevisions, 1)
+	require.Len(t, plan.MergedRevisions, 1)
+	require.Len(t, plan.Entries, 1)
+	require.Equal(t, name+"/root", plan.Entries[0].Path)
+	cre := WriterDeviceDateConflictRenamer{}
+	require.Equal(t, []string{(&renameUnmergedAction{
+		fromName: "file1",
+		toName:   cre.ConflictRenameHelper(now, "u2", "dev1", "file1"),
+	}).String()}, plan.Entries[0].Actions)
+
+	t.Log("The conflict is still unresolved")
+	ops := getOps(config2, fb.Tlf)
+	require.True(t, ops.isUnmerged(makeFBOLockState()))
+}
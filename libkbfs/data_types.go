@@ -104,6 +104,9 @@ type TeamInfo struct {
 
 	Writers map[keybase1.UID]bool
 	Readers map[keybase1.UID]bool
+	// Admins are the owners and admins of the team.  It's only
+	// filled in when the info is loaded for TeamRole_ADMIN.
+	Admins map[keybase1.UID]bool
 
 	// Last writers map a KID to the last time the writer associated
 	// with that KID trasitioned from writer to non-writer.
@@ -195,6 +198,10 @@ const (
 	IndirectDirsDataVer DataVer = 4
 )
 
+const (
+	defaultClientDataVer DataVer = IndirectDirsDataVer
+)
+
 // BlockRef is a block ID/ref nonce pair, which defines a unique
 // reference to a block.
 type BlockRef struct {
@@ -666,6 +673,18 @@ func (ei EntryInfo) Eq(other EntryInfo) bool {
 	return true
 }
 
+// DirChild is a named child of a directory, as listed by
+// KBFSOps.GetDirChildrenPage.
+type DirChild struct {
+	Name string
+	EntryInfo
+	// Ref identifies the entry across renames: it's the
+	// InitialNodeRef of the entry's node, if it has one, or its
+	// current block reference otherwise.  It's zero for entries
+	// without blocks of their own, like symlinks.
+	Ref BlockRef
+}
+
 // ReportedError represents an error reported by KBFS.
 type ReportedError struct {
 	Time  time.Time
@@ -789,6 +808,12 @@ const (
 	// InitConstrained is a mode where KBFS reads and writes data, but
 	// constrains itself to using fewer resources (e.g. on mobile).
 	InitConstrained
+	// InitBackup is a mode tuned for backup tools that mostly write
+	// large, append-only files: no read-ahead, bigger write batches,
+	// and coarser directory mtimes.  Unlike the other modes, it
+	// doesn't give directories POSIX times, so tools like make
+	// shouldn't be run on it.
+	InitBackup
 )
 
 func (im InitModeType) String() string {
@@ -801,6 +826,8 @@ func (im InitModeType) String() string {
 		return InitSingleOpString
 	case InitConstrained:
 		return InitConstrainedString
+	case InitBackup:
+		return InitBackupString
 	default:
 		return "unknown"
 	}
@@ -0,0 +1,205 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"flag"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"golang.org/x/net/context"
+)
+
+// deviceConstraintsPollPeriod is how often the OS is asked whether
+// the device is on battery power or a metered network.
+const deviceConstraintsPollPeriod = time.Minute
+
+// DeviceConstraints describes conditions on the local device under
+// which KBFS should hold back on network activity that isn't urgent.
+type DeviceConstraints struct {
+	OnBattery      bool
+	MeteredNetwork bool
+}
+
+func (dc DeviceConstraints) isConstrained() bool {
+	return dc.OnBattery || dc.MeteredNetwork
+}
+
+// DeviceConstraintsMode indicates which background work KBFS should
+// pause while the device is constrained.
+type DeviceConstraintsMode int
+
+var _ flag.Value = (*DeviceConstraintsMode)(nil)
+
+const (
+	// DeviceConstraintsModeIgnore indicates that KBFS should behave
+	// the same regardless of device constraints.
+	DeviceConstraintsModeIgnore DeviceConstraintsMode = iota
+	// DeviceConstraintsModePausePrefetch indicates that deep
+	// prefetching of synced TLFs should be paused.
+	DeviceConstraintsModePausePrefetch
+	// DeviceConstraintsModePauseAll indicates that, in addition to
+	// deep prefetching, background journal flushes should be paused
+	// for all TLFs except synced ones, which are treated as high
+	// priority.
+	DeviceConstraintsModePauseAll
+)
+
+// String outputs a human-readable description of this
+// DeviceConstraintsMode.
+func (m DeviceConstraintsMode) String() string {
+	switch m {
+	case DeviceConstraintsModeIgnore:
+		return "ignore"
+	case DeviceConstraintsModePausePrefetch:
+		return "pause-prefetch"
+	case DeviceConstraintsModePauseAll:
+		return "pause-all"
+	}
+	return "unknown"
+}
+
+// Set parses a string representing a device constraints mode, and
+// outputs the mode value corresponding to that string.  Defaults to
+// DeviceConstraintsModeIgnore.
+func (m *DeviceConstraintsMode) Set(s string) error {
+	*m = DeviceConstraintsModeIgnore
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "pause-prefetch":
+		*m = DeviceConstraintsModePausePrefetch
+	case "pause-all":
+		*m = DeviceConstraintsModePauseAll
+	}
+	return nil
+}
+
+// DeviceConstraintsStatus represents the current device constraints,
+// and what KBFS is doing about them, for display in diagnostics.  It
+// is suitable for encoding directly as JSON.
+type DeviceConstraintsStatus struct {
+	DeviceConstraints
+	Mode           string
+	PrefetchPaused bool
+	JournalsPaused bool
+}
+
+// CtxDeviceConstraintsTagKey is the type used for unique context
+// tags within deviceConstraintsMonitor.
+type CtxDeviceConstraintsTagKey int
+
+const (
+	// CtxDeviceConstraintsIDKey is the type of the tag for unique
+	// operation IDs within deviceConstraintsMonitor.
+	CtxDeviceConstraintsIDKey CtxDeviceConstraintsTagKey = iota
+)
+
+// CtxDeviceConstraintsOpID is the display name for the unique
+// operation deviceConstraintsMonitor ID tag.
+const CtxDeviceConstraintsOpID = "DCMID"
+
+// deviceConstraintsMonitor periodically polls the OS for the device
+// constraints, and pauses or resumes background work as they change.
+// Polling runs a helper command (busctl or pmset) each time, so the
+// monitor is only started when a mode other than
+// DeviceConstraintsModeIgnore is asked for.
+type deviceConstraintsMonitor struct {
+	config Config
+	log    logger.Logger
+	mode   DeviceConstraintsMode
+	probe  func(ctx context.Context) (DeviceConstraints, error)
+
+	lock    sync.RWMutex
+	current DeviceConstraints
+
+	shutdownCh chan struct{}
+	doneCh     chan struct{}
+}
+
+func newDeviceConstraintsMonitor(
+	config Config, mode DeviceConstraintsMode) *deviceConstraintsMonitor {
+	return &deviceConstraintsMonitor{
+		config:     config,
+		log:        config.MakeLogger("DCM"),
+		mode:       mode,
+		probe:      getDeviceConstraints,
+		shutdownCh: make(chan struct{}),
+		doneCh:     make(chan struct{}),
+	}
+}
+
+func (dcm *deviceConstraintsMonitor) statusLocked() DeviceConstraintsStatus {
+	constrained := dcm.current.isConstrained()
+	return DeviceConstraintsStatus{
+		DeviceConstraints: dcm.current,
+		Mode:              dcm.mode.String(),
+		PrefetchPaused: constrained &&
+			dcm.mode >= DeviceConstraintsModePausePrefetch,
+		JournalsPaused: constrained &&
+			dcm.mode >= DeviceConstraintsModePauseAll,
+	}
+}
+
+func (dcm *deviceConstraintsMonitor) status() DeviceConstraintsStatus {
+	dcm.lock.RLock()
+	defer dcm.lock.RUnlock()
+	return dcm.statusLocked()
+}
+
+// update polls the current device constraints, and applies them.
+func (dcm *deviceConstraintsMonitor) update(ctx context.Context) {
+	constraints, err := dcm.probe(ctx)
+	if err != nil {
+		dcm.log.CDebugf(ctx, "Couldn't get device constraints: %+v", err)
+		return
+	}
+
+	dcm.lock.Lock()
+	oldStatus := dcm.statusLocked()
+	dcm.current = constraints
+	newStatus := dcm.statusLocked()
+	dcm.lock.Unlock()
+
+	if oldStatus != newStatus {
+		dcm.log.CDebugf(ctx, "Device constraints changed: %+v", newStatus)
+	}
+	if oldStatus.PrefetchPaused && !newStatus.PrefetchPaused {
+		// Deep prefetches were downgraded to regular ones while
+		// paused, and nothing else will upgrade them again until the
+		// blocks are requested once more.
+		if kbfsOps, ok := dcm.config.KBFSOps().(*KBFSOpsStandard); ok {
+			kbfsOps.restartDeepPrefetches(ctx)
+		}
+	}
+	// Always tell the journal server, in case it was enabled since
+	// the last poll; this is a no-op if nothing has changed.
+	if jServer, err := GetJournalServer(dcm.config); err == nil {
+		jServer.setDeviceConstrained(ctx, newStatus.JournalsPaused)
+	}
+}
+
+func (dcm *deviceConstraintsMonitor) loop() {
+	defer close(dcm.doneCh)
+	ctx := CtxWithRandomIDReplayable(
+		context.Background(), CtxDeviceConstraintsIDKey,
+		CtxDeviceConstraintsOpID, dcm.log)
+	dcm.update(ctx)
+	ticker := time.NewTicker(deviceConstraintsPollPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			dcm.update(ctx)
+		case <-dcm.shutdownCh:
+			return
+		}
+	}
+}
+
+func (dcm *deviceConstraintsMonitor) shutdown() {
+	close(dcm.shutdownCh)
+	<-dcm.doneCh
+}
@@ -0,0 +1,28 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build darwin
+
+package libkbfs
+
+import (
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// getDeviceConstraints asks pmset which power source is in use.
+// macOS doesn't expose whether the network is metered.
+func getDeviceConstraints(ctx context.Context) (DeviceConstraints, error) {
+	out, err := exec.CommandContext(ctx, "/usr/bin/pmset", "-g", "batt").Output()
+	if err != nil {
+		return DeviceConstraints{}, errors.WithStack(err)
+	}
+	// The first line looks like "Now drawing from 'Battery Power'".
+	return DeviceConstraints{
+		OnBattery: strings.Contains(string(out), "'Battery Power'"),
+	}, nil
+}
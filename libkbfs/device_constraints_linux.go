@@ -0,0 +1,76 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package libkbfs
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/keybase/kbfs/ioutil"
+	"golang.org/x/net/context"
+)
+
+const linuxPowerSupplyDir = "/sys/class/power_supply"
+
+func readPowerSupplyAttr(supplyDir, attr string) string {
+	buf, err := ioutil.ReadFile(filepath.Join(supplyDir, attr))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(buf))
+}
+
+// isOnBatteryLinux returns true if the machine has a battery, and no
+// online mains power supply.
+func isOnBatteryLinux() (bool, error) {
+	supplies, err := filepath.Glob(filepath.Join(linuxPowerSupplyDir, "*"))
+	if err != nil {
+		return false, err
+	}
+	hasBattery := false
+	for _, supply := range supplies {
+		switch readPowerSupplyAttr(supply, "type") {
+		case "Mains":
+			if readPowerSupplyAttr(supply, "online") == "1" {
+				return false, nil
+			}
+		case "Battery":
+			hasBattery = true
+		}
+	}
+	return hasBattery, nil
+}
+
+// isMeteredLinux asks NetworkManager, if it's running, whether the
+// primary connection is metered.
+func isMeteredLinux(ctx context.Context) bool {
+	out, err := exec.CommandContext(ctx, "busctl", "get-property",
+		"org.freedesktop.NetworkManager", "/org/freedesktop/NetworkManager",
+		"org.freedesktop.NetworkManager", "Metered").Output()
+	if err != nil {
+		return false
+	}
+	// The output looks like "u 4".  NMMetered values 1 and 3 mean
+	// "yes" and "guessed yes", respectively.
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 {
+		return false
+	}
+	return fields[1] == "1" || fields[1] == "3"
+}
+
+func getDeviceConstraints(ctx context.Context) (DeviceConstraints, error) {
+	onBattery, err := isOnBatteryLinux()
+	if err != nil {
+		return DeviceConstraints{}, err
+	}
+	return DeviceConstraints{
+		OnBattery:      onBattery,
+		MeteredNetwork: isMeteredLinux(ctx),
+	}, nil
+}
@@ -0,0 +1,15 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build !linux,!darwin
+
+package libkbfs
+
+import "golang.org/x/net/context"
+
+// getDeviceConstraints reports no constraints on platforms where we
+// don't know how to ask the OS.
+func getDeviceConstraints(_ context.Context) (DeviceConstraints, error) {
+	return DeviceConstraints{}, nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestDeviceConstraintsMonitorPausesJournals(t *testing.T) {
+	tempdir, ctx, cancel, config, _, jServer := setupJournalServerTest(t)
+	defer teardownJournalServerTest(t, tempdir, ctx, cancel, config)
+
+	tlfID := tlf.FakeID(2, tlf.Private)
+	err := jServer.Enable(ctx, tlfID, nil, TLFJournalBackgroundWorkEnabled)
+	require.NoError(t, err)
+	tj, ok := jServer.getTLFJournal(tlfID, nil)
+	require.True(t, ok)
+	getPauseType := func(tj *tlfJournal) tlfJournalPauseType {
+		tj.pauseLock.Lock()
+		defer tj.pauseLock.Unlock()
+		return tj.pauseType
+	}
+
+	var constraints DeviceConstraints
+	dcm := newDeviceConstraintsMonitor(config, DeviceConstraintsModePauseAll)
+	dcm.probe = func(_ context.Context) (DeviceConstraints, error) {
+		return constraints, nil
+	}
+
+	t.Log("Unconstrained device")
+	dcm.update(ctx)
+	status := dcm.status()
+	require.False(t, status.PrefetchPaused)
+	require.False(t, status.JournalsPaused)
+	require.Equal(t, tlfJournalPauseType(0), getPauseType(tj))
+
+	t.Log("On battery, which pauses existing and new journals")
+	constraints.OnBattery = true
+	dcm.update(ctx)
+	status = dcm.status()
+	require.True(t, status.OnBattery)
+	require.True(t, status.PrefetchPaused)
+	require.True(t, status.JournalsPaused)
+	require.Equal(t, journalPauseDeviceConstraints, getPauseType(tj))
+
+	tlfID2 := tlf.FakeID(3, tlf.Private)
+	err = jServer.Enable(ctx, tlfID2, nil, TLFJournalBackgroundWorkEnabled)
+	require.NoError(t, err)
+	tj2, ok := jServer.getTLFJournal(tlfID2, nil)
+	require.True(t, ok)
+	require.Equal(t, journalPauseDeviceConstraints, getPauseType(tj2))
+
+	t.Log("A user pause survives the device becoming unconstrained")
+	jServer.PauseBackgroundWork(ctx, tlfID)
+	constraints.OnBattery = false
+	dcm.update(ctx)
+	require.False(t, dcm.status().JournalsPaused)
+	require.Equal(t, journalPauseCommand, getPauseType(tj))
+	require.Equal(t, tlfJournalPauseType(0), getPauseType(tj2))
+
+	t.Log("Prefetch-only mode leaves journals alone")
+	dcm.mode = DeviceConstraintsModePausePrefetch
+	constraints.MeteredNetwork = true
+	dcm.update(ctx)
+	status = dcm.status()
+	require.True(t, status.PrefetchPaused)
+	require.False(t, status.JournalsPaused)
+	require.Equal(t, tlfJournalPauseType(0), getPauseType(tj2))
+}
+
+type blockOpsRecordingRequests struct {
+	BlockOps
+	requests chan BlockPointer
+}
+
+func (b *blockOpsRecordingRequests) BlockRetriever() BlockRetriever {
+	return &blockRetrieverRecordingRequests{
+		b.BlockOps.BlockRetriever(), b.requests}
+}
+
+type blockRetrieverRecordingRequests struct {
+	BlockRetriever
+	requests chan BlockPointer
+}
+
+func (b *blockRetrieverRecordingRequests) Request(
+	ctx context.Context, priority int, kmd KeyMetadata, ptr BlockPointer,
+	block Block, lifetime BlockCacheLifetime) <-chan error {
+	select {
+	case b.requests <- ptr:
+	default:
+	}
+	return b.BlockRetriever.Request(ctx, priority, kmd, ptr, block, lifetime)
+}
+
+func TestDeviceConstraintsMonitorRestartsDeepPrefetch(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	tempdir, err := ioutil.TempDir(os.TempDir(), "device_constraints")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		assert.NoError(t, err)
+	}()
+	err = config.EnableDiskLimiter(tempdir)
+	require.NoError(t, err)
+	config.diskCacheMode = DiskCacheModeLocal
+	err = config.MakeDiskBlockCacheIfNotExists()
+	require.NoError(t, err)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	err = config.SetTlfSyncState(fb.Tlf, true)
+	require.NoError(t, err)
+	publicRootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Public)
+	require.False(t, config.IsSyncedTlf(
+		publicRootNode.GetFolderBranch().Tlf))
+	md, err := config.KBFSOps().(*KBFSOpsStandard).getOpsNoAdd(ctx, fb).
+		getMDForReadNeedIdentify(ctx, makeFBOLockState())
+	require.NoError(t, err)
+
+	requests := make(chan BlockPointer, 10)
+	config.SetBlockOps(&blockOpsRecordingRequests{config.BlockOps(), requests})
+
+	var constraints DeviceConstraints
+	dcm := newDeviceConstraintsMonitor(
+		config, DeviceConstraintsModePausePrefetch)
+	dcm.probe = func(_ context.Context) (DeviceConstraints, error) {
+		return constraints, nil
+	}
+
+	t.Log("Becoming constrained doesn't request anything")
+	constraints.MeteredNetwork = true
+	dcm.update(ctx)
+	require.True(t, dcm.status().PrefetchPaused)
+	require.Len(t, requests, 0)
+
+	t.Log("Staying constrained doesn't either")
+	dcm.update(ctx)
+	require.Len(t, requests, 0)
+
+	t.Log("Once unconstrained, only the synced TLF's root is requested again")
+	constraints.MeteredNetwork = false
+	dcm.update(ctx)
+	require.False(t, dcm.status().PrefetchPaused)
+	require.Len(t, requests, 1)
+	require.Equal(t, md.data.Dir.BlockPointer, <-requests)
+
+	t.Log("Nothing more while unconstrained")
+	dcm.update(ctx)
+	require.Len(t, requests, 0)
+}
@@ -0,0 +1,281 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// primingPollPeriod is how often a DevicePrimer checks on the deep
+// prefetch of the folder it's streaming.
+const primingPollPeriod = 1 * time.Second
+
+// CtxDPTagKey is the type used for unique context tags within
+// DevicePrimer.
+type CtxDPTagKey int
+
+const (
+	// CtxDPIDKey is the type of the tag for unique operation IDs
+	// within DevicePrimer.
+	CtxDPIDKey CtxDPTagKey = iota
+)
+
+// CtxDPOpID is the display name for the unique operation
+// DevicePrimer ID tag.
+const CtxDPOpID = "DPID"
+
+// PrimingPhase is how far along priming a folder is.
+type PrimingPhase int
+
+const (
+	// PrimingQueued means nothing has been fetched for the folder
+	// yet.
+	PrimingQueued PrimingPhase = iota
+	// PrimingMetadata means the folder's metadata and key bundles
+	// are being fetched.
+	PrimingMetadata
+	// PrimingWaiting means the folder's metadata has been fetched,
+	// and its content will be fetched after that of the folders
+	// before it.
+	PrimingWaiting
+	// PrimingContent means the folder's content is being fetched
+	// into the sync cache.
+	PrimingContent
+	// PrimingDone means all of the folder's content is in the sync
+	// cache.
+	PrimingDone
+	// PrimingFailed means the folder couldn't be primed.
+	PrimingFailed
+)
+
+func (p PrimingPhase) String() string {
+	switch p {
+	case PrimingQueued:
+		return "Queued"
+	case PrimingMetadata:
+		return "Metadata"
+	case PrimingWaiting:
+		return "Waiting"
+	case PrimingContent:
+		return "Content"
+	case PrimingDone:
+		return "Done"
+	case PrimingFailed:
+		return "Failed"
+	}
+	return "Unknown"
+}
+
+// PrimingFolderStatus describes the progress of priming one folder.
+// It is suitable for encoding directly as JSON.
+type PrimingFolderStatus struct {
+	Folder   Favorite
+	FolderID string `json:",omitempty"`
+	// EstimatedBytes is the total size of the folder's blocks, as
+	// of its latest revision.  It is 0 until the folder's metadata
+	// has been fetched.
+	EstimatedBytes uint64
+	Phase          string
+	Err            string `json:",omitempty"`
+}
+
+// DevicePrimer fills the caches of a device with the contents of a
+// list of folders, so that they're ready for use, rather than being
+// fetched bit by bit as they're first accessed.  It works in two
+// passes.  First it fetches the metadata and key bundles of every
+// folder, which makes their sizes known.  Then, one folder at a time
+// in the order given, it enables syncing of the folder, and waits for
+// the deep prefetch of its content into the sync cache to finish.
+// Syncing stays enabled for the folders afterwards, so that they're
+// kept up to date.
+type DevicePrimer struct {
+	config  Config
+	log     logger.Logger
+	folders []Favorite
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	lock     sync.RWMutex
+	statuses []PrimingFolderStatus
+	err      error
+}
+
+// StartPriming starts priming the given folders in the background,
+// and returns the DevicePrimer doing it.
+func StartPriming(config Config, folders []Favorite) *DevicePrimer {
+	ctx, cancel := context.WithCancel(context.Background())
+	dp := &DevicePrimer{
+		config:   config,
+		log:      config.MakeLogger("DP"),
+		folders:  folders,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		statuses: make([]PrimingFolderStatus, len(folders)),
+	}
+	for i, f := range folders {
+		dp.statuses[i] = PrimingFolderStatus{
+			Folder: f,
+			Phase:  PrimingQueued.String(),
+		}
+	}
+	go dp.run(ctx)
+	return dp
+}
+
+// Status returns the progress of each folder, in the order they were
+// given.
+func (dp *DevicePrimer) Status() []PrimingFolderStatus {
+	dp.lock.RLock()
+	defer dp.lock.RUnlock()
+	return append([]PrimingFolderStatus(nil), dp.statuses...)
+}
+
+// Wait waits for priming to end, and returns an error if it was
+// canceled.  Folders that failed are reported in Status.
+func (dp *DevicePrimer) Wait(ctx context.Context) error {
+	select {
+	case <-dp.done:
+		dp.lock.RLock()
+		defer dp.lock.RUnlock()
+		return dp.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Cancel stops priming.  Folders already being synced stay synced.
+func (dp *DevicePrimer) Cancel() {
+	dp.cancel()
+}
+
+func (dp *DevicePrimer) setStatus(
+	i int, phase PrimingPhase, update func(*PrimingFolderStatus)) {
+	dp.lock.Lock()
+	defer dp.lock.Unlock()
+	dp.statuses[i].Phase = phase.String()
+	if update != nil {
+		update(&dp.statuses[i])
+	}
+}
+
+func (dp *DevicePrimer) setFailed(ctx context.Context, i int, err error) {
+	dp.log.CDebugf(ctx, "Couldn't prime %s: %+v", dp.folders[i].Name, err)
+	dp.setStatus(i, PrimingFailed, func(s *PrimingFolderStatus) {
+		s.Err = err.Error()
+	})
+}
+
+// getRootNode fetches the metadata of folder `i`.
+func (dp *DevicePrimer) getRootNode(ctx context.Context, i int) (
+	Node, error) {
+	f := dp.folders[i]
+	h, err := GetHandleFromFolderNameAndType(
+		ctx, dp.config.KBPKI(), dp.config.MDOps(), f.Name, f.Type)
+	if err != nil {
+		return nil, err
+	}
+	rootNode, _, err := dp.config.KBFSOps().GetRootNode(ctx, h, MasterBranch)
+	if err != nil {
+		return nil, err
+	}
+	if rootNode == nil {
+		return nil, errors.Errorf("%s doesn't exist", f.Name)
+	}
+	return rootNode, nil
+}
+
+func (dp *DevicePrimer) fetchMetadata(ctx context.Context, i int) (
+	FolderBranch, error) {
+	dp.setStatus(i, PrimingMetadata, nil)
+	rootNode, err := dp.getRootNode(ctx, i)
+	if err != nil {
+		return FolderBranch{}, err
+	}
+	fb := rootNode.GetFolderBranch()
+	status, _, err := dp.config.KBFSOps().FolderStatus(ctx, fb)
+	if err != nil {
+		return FolderBranch{}, err
+	}
+	dp.setStatus(i, PrimingWaiting, func(s *PrimingFolderStatus) {
+		s.FolderID = fb.Tlf.String()
+		s.EstimatedBytes = status.DiskUsage
+	})
+	return fb, nil
+}
+
+func (dp *DevicePrimer) fetchContent(
+	ctx context.Context, i int, fb FolderBranch) error {
+	dp.setStatus(i, PrimingContent, nil)
+	err := dp.config.SetTlfSyncState(fb.Tlf, true)
+	if err != nil {
+		return err
+	}
+	// Fetching the root block again kicks off the deep prefetch,
+	// now that the TLF is synced.
+	kbfsOps, ok := dp.config.KBFSOps().(*KBFSOpsStandard)
+	if !ok {
+		return errors.New("Unexpected KBFSOps type")
+	}
+	ops := kbfsOps.getOpsNoAdd(ctx, fb)
+	md, err := ops.getMDForReadNeedIdentify(ctx, makeFBOLockState())
+	if err != nil {
+		return err
+	}
+	ops.kickOffRootBlockFetch(ctx, md)
+
+	ticker := time.NewTicker(primingPollPeriod)
+	defer ticker.Stop()
+	for {
+		status, _, err := dp.config.KBFSOps().FolderStatus(ctx, fb)
+		if err != nil {
+			return err
+		}
+		if status.PrefetchStatus == FinishedPrefetch.String() {
+			dp.setStatus(i, PrimingDone, nil)
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (dp *DevicePrimer) run(ctx context.Context) {
+	defer close(dp.done)
+	ctx = CtxWithRandomIDReplayable(ctx, CtxDPIDKey, CtxDPOpID, dp.log)
+
+	fbs := make([]FolderBranch, len(dp.folders))
+	for i := range dp.folders {
+		fb, err := dp.fetchMetadata(ctx, i)
+		if err != nil {
+			dp.setFailed(ctx, i, err)
+			continue
+		}
+		fbs[i] = fb
+	}
+
+	for i, fb := range fbs {
+		if fb == (FolderBranch{}) {
+			continue
+		}
+		err := dp.fetchContent(ctx, i, fb)
+		if errors.Cause(err) == context.Canceled {
+			dp.lock.Lock()
+			defer dp.lock.Unlock()
+			dp.err = err
+			return
+		} else if err != nil {
+			dp.setFailed(ctx, i, err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestDevicePrimer(t *testing.T) {
+	config1, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config1, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config1, "alice", tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps1 := config1.KBFSOps()
+	file, _, err := kbfsOps1.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps1.Write(ctx, file, []byte("hello"), 0)
+	require.NoError(t, err)
+	err = kbfsOps1.SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	t.Log("Prime a new device with the folder, and one that doesn't exist.")
+	config2 := ConfigAsUser(config1, "alice")
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	tempdir, err := ioutil.TempDir(os.TempDir(), "device_primer")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		assert.NoError(t, err)
+	}()
+	err = config2.EnableDiskLimiter(tempdir)
+	require.NoError(t, err)
+	config2.diskCacheMode = DiskCacheModeLocal
+	err = config2.MakeDiskBlockCacheIfNotExists()
+	require.NoError(t, err)
+
+	folders := []Favorite{
+		{Name: "alice", Type: tlf.Private},
+		{Name: "alice", Type: tlf.Public},
+	}
+	dp := StartPriming(config2, folders)
+	defer dp.Cancel()
+
+	// The in-memory block server doesn't fill the disk cache, so the
+	// deep prefetch never finishes; just wait for it to start.
+	var statuses []PrimingFolderStatus
+	for {
+		statuses = dp.Status()
+		if statuses[0].Phase == PrimingContent.String() &&
+			config2.IsSyncedTlf(fb.Tlf) {
+			break
+		}
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-ctx.Done():
+			t.Fatalf("Priming didn't start fetching content: %+v", statuses)
+		}
+	}
+	require.Equal(t, fb.Tlf.String(), statuses[0].FolderID)
+	status, _, err := kbfsOps1.FolderStatus(ctx, fb)
+	require.NoError(t, err)
+	require.NotZero(t, statuses[0].EstimatedBytes)
+	require.Equal(t, status.DiskUsage, statuses[0].EstimatedBytes)
+	require.Equal(t, PrimingFailed.String(), statuses[1].Phase)
+	require.NotEmpty(t, statuses[1].Err)
+
+	t.Log("Canceling stops priming, but leaves the folder synced.")
+	dp.Cancel()
+	err = dp.Wait(ctx)
+	require.Equal(t, context.Canceled, errors.Cause(err))
+	require.True(t, config2.IsSyncedTlf(fb.Tlf))
+}
@@ -5,6 +5,9 @@
 package libkbfs
 
 import (
+	"sort"
+
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/keybase/client/go/logger"
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/kbfs/kbfsblock"
@@ -12,6 +15,51 @@ import (
 	"golang.org/x/net/context"
 )
 
+// sortedDirNamesCacheSize is how many directory blocks' worth of
+// sorted entry names are kept for paged listings.
+const sortedDirNamesCacheSize = 16
+
+// sortedDirNamesCache holds the sorted entry names of clean directory
+// blocks, so that paging through a big block doesn't sort all of its
+// names again for every page.  It's keyed by block ID, and since
+// block IDs are hashes of the contents, entries never go stale.
+// Dirty blocks can change in place, so they are never cached.
+type sortedDirNamesCache struct {
+	cache *lru.Cache
+}
+
+func newSortedDirNamesCache() *sortedDirNamesCache {
+	cache, err := lru.New(sortedDirNamesCacheSize)
+	if err != nil {
+		panic(err)
+	}
+	return &sortedDirNamesCache{cache: cache}
+}
+
+func sortDirBlockNames(dblock *DirBlock) []string {
+	names := make([]string, 0, len(dblock.Children))
+	for k := range dblock.Children {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// get returns the sorted names of `dblock`, which lives at `ptr`.
+// The result must not be modified.  A nil cache sorts every time.
+func (sdnc *sortedDirNamesCache) get(
+	ptr BlockPointer, dblock *DirBlock, wasDirty bool) []string {
+	if sdnc == nil || wasDirty || !ptr.IsValid() {
+		return sortDirBlockNames(dblock)
+	}
+	if names, ok := sdnc.cache.Get(ptr.ID); ok {
+		return names.([]string)
+	}
+	names := sortDirBlockNames(dblock)
+	sdnc.cache.Add(ptr.ID, names)
+	return names
+}
+
 // dirBlockGetter is a function that gets a block suitable for
 // reading or writing, and also returns whether the block was already
 // dirty.  It may be called from new goroutines, and must handle any
@@ -25,6 +73,8 @@ type dirBlockGetter func(context.Context, KeyMetadata, BlockPointer,
 type dirData struct {
 	getter dirBlockGetter
 	tree   *blockTree
+	// sortedNames is optional, and speeds up paged listings.
+	sortedNames *sortedDirNamesCache
 }
 
 func newDirData(dir path, chargedTo keybase1.UserOrTeamID,
@@ -103,6 +153,53 @@ func (dd *dirData) getChildren(ctx context.Context) (
 	return children, nil
 }
 
+// getChildrenPage returns up to `max` children, sorted by name, with
+// names that sort after `after`.  Only the leaf blocks holding the
+// page are fetched, so listing a large directory one page at a time
+// doesn't need all of its entries in memory at once.  A page with
+// fewer than `max` children is the last one.
+func (dd *dirData) getChildrenPage(
+	ctx context.Context, after string, max int) (
+	children []DirChild, err error) {
+	topBlock, err := dd.getTopBlock(ctx, blockRead)
+	if err != nil {
+		return nil, err
+	}
+
+	afterOff := StringOffset(after)
+	var off Offset = &afterOff
+	for off != nil {
+		ptr, _, block, nextBlockStartOff, _, wasDirty, err :=
+			dd.tree.getBlockAtOffset(ctx, topBlock, off, blockRead)
+		if err != nil {
+			return nil, err
+		}
+
+		dblock := block.(*DirBlock)
+		names := dd.sortedNames.get(ptr, dblock, wasDirty)
+		i := sort.SearchStrings(names, after)
+		if i < len(names) && names[i] == after {
+			i++
+		}
+		for _, name := range names[i:] {
+			if hiddenEntries[name] {
+				continue
+			}
+			de := dblock.Children[name]
+			children = append(children, DirChild{
+				Name:      name,
+				EntryInfo: de.EntryInfo,
+				Ref:       de.Ref(),
+			})
+			if len(children) == max {
+				return children, nil
+			}
+		}
+		off = nextBlockStartOff
+	}
+	return children, nil
+}
+
 func (dd *dirData) getEntries(ctx context.Context) (
 	children map[string]DirEntry, err error) {
 	topBlock, err := dd.getTopBlock(ctx, blockRead)
@@ -137,6 +137,94 @@ func TestDirDataGetChildren(t *testing.T) {
 
 }
 
+func TestDirDataGetChildrenPage(t *testing.T) {
+	dd, cleanBcache, _ := setupDirDataTest(t, 2, 2)
+	ctx := context.Background()
+	topBlock := NewDirBlock().(*DirBlock)
+	cleanBcache.Put(
+		dd.rootBlockPointer(), dd.tree.file.Tlf, topBlock, TransientEntry)
+
+	t.Log("No entries")
+	children, err := dd.getChildrenPage(ctx, "", 3)
+	require.NoError(t, err)
+	require.Len(t, children, 0)
+
+	t.Log("Many entries, spread over several levels of blocks")
+	var names []string
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("f%02d", i)
+		names = append(names, name)
+		addFakeDirDataEntry(t, ctx, dd, name, uint64(i))
+	}
+	addFakeDirDataEntry(t, ctx, dd, ".kbfs_git", 100)
+	topBlock, err = dd.getTopBlock(ctx, blockRead)
+	require.NoError(t, err)
+	require.True(t, topBlock.IsInd)
+
+	var pagedNames []string
+	after := ""
+	for {
+		children, err := dd.getChildrenPage(ctx, after, 3)
+		require.NoError(t, err)
+		for _, c := range children {
+			require.Equal(t, fmt.Sprintf("f%02d", c.Size), c.Name)
+			pagedNames = append(pagedNames, c.Name)
+		}
+		if len(children) < 3 {
+			break
+		}
+		after = children[len(children)-1].Name
+	}
+	require.Equal(t, names, pagedNames)
+
+	t.Log("Start in the middle of a block")
+	children, err = dd.getChildrenPage(ctx, "f04a", 2)
+	require.NoError(t, err)
+	require.Len(t, children, 2)
+	require.Equal(t, "f05", children[0].Name)
+	require.Equal(t, "f06", children[1].Name)
+}
+
+func TestDirDataGetChildrenPageLargeBlock(t *testing.T) {
+	dd, cleanBcache, _ := setupDirDataTest(t, 2, 2)
+	dd.sortedNames = newSortedDirNamesCache()
+	ctx := context.Background()
+	topBlock := NewDirBlock().(*DirBlock)
+	const numEntries = 5000
+	var names []string
+	for i := 0; i < numEntries; i++ {
+		name := fmt.Sprintf("f%05d", i)
+		names = append(names, name)
+		addFakeDirDataEntryToBlock(topBlock, name, uint64(i))
+	}
+	addFakeDirDataEntryToBlock(topBlock, ".kbfs_git", 100)
+	cleanBcache.Put(
+		dd.rootBlockPointer(), dd.tree.file.Tlf, topBlock, TransientEntry)
+
+	t.Log("A single clean block is paged through in order and in full")
+	var pagedNames []string
+	after := ""
+	for {
+		children, err := dd.getChildrenPage(ctx, after, 500)
+		require.NoError(t, err)
+		for _, c := range children {
+			require.Equal(t, fmt.Sprintf("f%05d", c.Size), c.Name)
+			pagedNames = append(pagedNames, c.Name)
+		}
+		if len(children) < 500 {
+			break
+		}
+		after = children[len(children)-1].Name
+	}
+	require.Equal(t, names, pagedNames)
+
+	t.Log("The block's names were sorted once, and reused for every page")
+	require.Equal(t, 1, dd.sortedNames.cache.Len())
+	cached, ok := dd.sortedNames.cache.Get(dd.rootBlockPointer().ID)
+	require.True(t, ok)
+	require.Len(t, cached, numEntries+1)
+}
+
 func testDirDataCheckLookup(
 	t *testing.T, ctx context.Context, dd *dirData, name string, size uint64) {
 	de, err := dd.lookup(ctx, name)
@@ -110,6 +110,14 @@ func (df *dirtyFile) updateNotYetSyncingBytes(newBytes int64) {
 	df.dirtyBcache.UpdateUnsyncedBytes(df.path.Tlf, newBytes, false)
 }
 
+// unsyncedBytes returns the number of bytes written to the file that
+// haven't finished syncing yet.
+func (df *dirtyFile) unsyncedBytes() int64 {
+	df.lock.Lock()
+	defer df.lock.Unlock()
+	return df.notYetSyncingBytes + df.totalSyncBytes
+}
+
 // setBlockDirty transitions a block to a dirty state, and returns
 // whether or not the block needs to be put in the dirty cache
 // (because it isn't yet), and whether or not the block is currently
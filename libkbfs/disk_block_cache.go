@@ -820,6 +820,34 @@ func (cache *DiskBlockCacheLocal) evictFromTLFLocked(ctx context.Context,
 	return cache.evictSomeBlocks(ctx, numBlocks, blockIDs)
 }
 
+// getBlockIDsForTLF returns the IDs of all the blocks cached for the
+// given TLF.
+func (cache *DiskBlockCacheLocal) getBlockIDsForTLF(
+	ctx context.Context, tlfID tlf.ID) ([]kbfsblock.ID, error) {
+	cache.lock.RLock()
+	defer cache.lock.RUnlock()
+	err := cache.checkCacheLocked("getBlockIDsForTLF")
+	if err != nil {
+		return nil, err
+	}
+
+	tlfBytes := tlfID.Bytes()
+	iter := cache.tlfDb.NewIterator(util.BytesPrefix(tlfBytes), nil)
+	defer iter.Release()
+	blockIDs := make([]kbfsblock.ID, 0, cache.tlfCounts[tlfID])
+	for iter.Next() {
+		blockIDBytes := iter.Key()[len(tlfBytes):]
+		blockID, err := kbfsblock.IDFromBytes(blockIDBytes)
+		if err != nil {
+			cache.log.CWarningf(ctx, "Error decoding block ID %x",
+				blockIDBytes)
+			continue
+		}
+		blockIDs = append(blockIDs, blockID)
+	}
+	return blockIDs, iter.Error()
+}
+
 // evictLocked evicts a number of blocks from the cache.  We choose a pivot
 // variable b randomly. Then begin an iterator into cache.metaDb.Range(b,
 // MaxBlockID) and iterate from there to get numBlocks *
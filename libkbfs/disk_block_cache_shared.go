@@ -0,0 +1,345 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+const (
+	// 5 GB maximum storage by default
+	defaultSharedDiskBlockCacheMaxBytes int64  = 5 * (1 << 30)
+	sharedCacheName                     string = "SharedBlockCache"
+	sharedCacheVersionFolderName        string = "v1"
+)
+
+// diskBlockCacheShared is a disk cache of public folder blocks that
+// can be shared by several KBFS instances on the same machine, e.g.
+// one per logged-in account, so that they don't each download and
+// store the same public blocks.
+//
+// Since other instances (and possibly other local users) can write
+// to it, the cache never holds anything but blocks of public TLFs,
+// whose keys are public anyway, and it trusts nothing it reads: each
+// block is stored in a file named after its ID, and is only returned
+// if its contents hash to that ID.  A bad key server half just makes
+// the block fail to decrypt, after which it's fetched from the
+// server as usual.
+//
+// The directory layout splays the block files the same way as
+// blockDiskStore:
+//
+// dir/v1/0100/0...01
+// ...
+// dir/v1/01ff/f...ff
+//
+// The size limit is enforced by each instance separately, based on
+// its own view of the directory, so it is only approximate when
+// several instances write at once.
+type diskBlockCacheShared struct {
+	config   diskBlockCacheConfig
+	log      logger.Logger
+	dir      string
+	maxBytes int64
+
+	hitMeter        *CountMeter
+	missMeter       *CountMeter
+	putMeter        *CountMeter
+	evictCountMeter *CountMeter
+	evictSizeMeter  *CountMeter
+
+	// Protects the counts below.
+	lock      sync.Mutex
+	numBlocks int
+	currBytes int64
+}
+
+// newDiskBlockCacheShared makes a shared disk block cache in `dir`,
+// which is created if needed, and counts the blocks already in it.
+func newDiskBlockCacheShared(config diskBlockCacheConfig, dir string,
+	maxBytes int64) (*diskBlockCacheShared, error) {
+	dir = filepath.Join(dir, sharedCacheVersionFolderName)
+	// The directory may be used by other local users, so leave its
+	// permissions up to the umask.
+	err := ioutil.MkdirAll(dir, 0777)
+	if err != nil {
+		return nil, err
+	}
+	cache := &diskBlockCacheShared{
+		config:          config,
+		log:             config.MakeLogger("SBC"),
+		dir:             dir,
+		maxBytes:        maxBytes,
+		hitMeter:        NewCountMeter(),
+		missMeter:       NewCountMeter(),
+		putMeter:        NewCountMeter(),
+		evictCountMeter: NewCountMeter(),
+		evictSizeMeter:  NewCountMeter(),
+	}
+	err = filepath.Walk(dir, func(
+		p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			cache.numBlocks++
+			cache.currBytes += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return cache, nil
+}
+
+func (cache *diskBlockCacheShared) blockPath(id kbfsblock.ID) string {
+	idStr := id.String()
+	return filepath.Join(cache.dir, idStr[:4], idStr[4:])
+}
+
+func (cache *diskBlockCacheShared) checkTlf(
+	method string, tlfID tlf.ID) error {
+	if tlfID.Type() != tlf.Public {
+		return errors.Errorf(
+			"Shared disk cache %s called for non-public TLF %s",
+			method, tlfID)
+	}
+	return nil
+}
+
+func (cache *diskBlockCacheShared) removeBlockFile(p string, size int64) {
+	err := ioutil.Remove(p)
+	if err != nil {
+		if !ioutil.IsNotExist(err) {
+			cache.log.Debug("Couldn't remove %s: %+v", p, err)
+		}
+		return
+	}
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	cache.numBlocks--
+	cache.currBytes -= size
+}
+
+// has returns true if the shared cache seems to have the given block.
+func (cache *diskBlockCacheShared) has(blockID kbfsblock.ID) bool {
+	_, err := ioutil.Stat(cache.blockPath(blockID))
+	return err == nil
+}
+
+// Get gets a public block from the shared cache.
+func (cache *diskBlockCacheShared) Get(ctx context.Context, tlfID tlf.ID,
+	blockID kbfsblock.ID) (
+	buf []byte, serverHalf kbfscrypto.BlockCryptKeyServerHalf, err error) {
+	err = cache.checkTlf("Get", tlfID)
+	if err != nil {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+	}
+	p := cache.blockPath(blockID)
+	entry, size, err := cache.readBlockFile(p, blockID)
+	if ioutil.IsNotExist(err) {
+		cache.missMeter.Mark(1)
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{},
+			NoSuchBlockError{blockID}
+	} else if err != nil {
+		// A partial write, or junk from someone else; either way
+		// it's useless.
+		cache.log.CDebugf(ctx, "Dropping bad shared cache entry for %s: %+v",
+			blockID, err)
+		cache.removeBlockFile(p, size)
+		cache.missMeter.Mark(1)
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{},
+			NoSuchBlockError{blockID}
+	}
+	cache.hitMeter.Mark(1)
+	return entry.Buf, entry.ServerHalf, nil
+}
+
+// readBlockFile reads and decodes the block file at `p`, and checks
+// that its contents hash to `blockID`.  It also returns the size of
+// the file, if it could be read.
+func (cache *diskBlockCacheShared) readBlockFile(
+	p string, blockID kbfsblock.ID) (
+	entry diskBlockCacheEntry, size int64, err error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return diskBlockCacheEntry{}, 0, err
+	}
+	size = int64(len(data))
+	err = cache.config.Codec().Decode(data, &entry)
+	if err != nil {
+		return diskBlockCacheEntry{}, size, err
+	}
+	err = kbfsblock.VerifyID(entry.Buf, blockID)
+	if err != nil {
+		return diskBlockCacheEntry{}, size, err
+	}
+	return entry, size, nil
+}
+
+// Put puts a public block into the shared cache, making room for it
+// if needed.
+func (cache *diskBlockCacheShared) Put(ctx context.Context, tlfID tlf.ID,
+	blockID kbfsblock.ID, buf []byte,
+	serverHalf kbfscrypto.BlockCryptKeyServerHalf) error {
+	err := cache.checkTlf("Put", tlfID)
+	if err != nil {
+		return err
+	}
+	p := cache.blockPath(blockID)
+	// Don't trust an existing file just because it's there: it may
+	// be a partial write, or junk (or a bad key server half) from
+	// someone else, in which case it's replaced.
+	existing, size, err := cache.readBlockFile(p, blockID)
+	switch {
+	case err == nil && bytes.Equal(existing.Buf, buf) &&
+		existing.ServerHalf == serverHalf:
+		return nil
+	case err == nil || !ioutil.IsNotExist(err):
+		cache.log.CDebugf(ctx, "Replacing bad shared cache entry for %s",
+			blockID)
+		cache.removeBlockFile(p, size)
+	}
+
+	data, err := cache.config.Codec().Encode(&diskBlockCacheEntry{
+		Buf:        buf,
+		ServerHalf: serverHalf,
+	})
+	if err != nil {
+		return err
+	}
+	err = cache.evictUntilBytesAvailable(ctx, int64(len(data)))
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.MkdirAll(filepath.Dir(p), 0777)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first, so other instances never see
+	// a partial block.
+	tmpPath := fmt.Sprintf("%s.%d.tmp", p, rand.Int63())
+	err = ioutil.WriteFile(tmpPath, data, 0666)
+	if err != nil {
+		return err
+	}
+	err = ioutil.Rename(tmpPath, p)
+	if err != nil {
+		_ = ioutil.Remove(tmpPath)
+		return err
+	}
+
+	cache.putMeter.Mark(1)
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	cache.numBlocks++
+	cache.currBytes += int64(len(data))
+	return nil
+}
+
+func (cache *diskBlockCacheShared) getCurrBytes() int64 {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	return cache.currBytes
+}
+
+// evictUntilBytesAvailable removes the least recently written blocks
+// of randomly-chosen splay directories until `n` more bytes fit.
+func (cache *diskBlockCacheShared) evictUntilBytesAvailable(
+	ctx context.Context, n int64) error {
+	if n > cache.maxBytes {
+		return errors.Errorf("Block of %d bytes doesn't fit in the shared "+
+			"disk cache", n)
+	}
+	if cache.getCurrBytes()+n <= cache.maxBytes {
+		return nil
+	}
+	dirs, err := ioutil.ReadDir(cache.dir)
+	if err != nil {
+		return err
+	}
+	start := 0
+	if len(dirs) > 0 {
+		start = rand.Intn(len(dirs))
+	}
+	for i := range dirs {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		dir := filepath.Join(cache.dir, dirs[(start+i)%len(dirs)].Name())
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].ModTime().Before(files[j].ModTime())
+		})
+		for _, f := range files {
+			if cache.getCurrBytes()+n <= cache.maxBytes {
+				return nil
+			}
+			cache.removeBlockFile(filepath.Join(dir, f.Name()), f.Size())
+			cache.evictCountMeter.Mark(1)
+			cache.evictSizeMeter.Mark(f.Size())
+		}
+	}
+	if cache.getCurrBytes()+n > cache.maxBytes {
+		// Everything we could find is gone, so other instances
+		// must have removed blocks that we were still counting.
+		cache.lock.Lock()
+		defer cache.lock.Unlock()
+		cache.numBlocks = 0
+		cache.currBytes = 0
+	}
+	return nil
+}
+
+// Status returns the status of the shared cache.
+func (cache *diskBlockCacheShared) Status(
+	ctx context.Context) map[string]DiskBlockCacheStatus {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	return map[string]DiskBlockCacheStatus{
+		sharedCacheName: {
+			StartState:    DiskBlockCacheStartStateStarted,
+			NumBlocks:     uint64(cache.numBlocks),
+			BlockBytes:    uint64(cache.currBytes),
+			CurrByteLimit: uint64(cache.maxBytes),
+			Hits:          rateMeterToStatus(cache.hitMeter),
+			Misses:        rateMeterToStatus(cache.missMeter),
+			Puts:          rateMeterToStatus(cache.putMeter),
+			NumEvicted:    rateMeterToStatus(cache.evictCountMeter),
+			SizeEvicted:   rateMeterToStatus(cache.evictSizeMeter),
+		},
+	}
+}
+
+// Shutdown stops the shared cache's meters.  The blocks stay on disk
+// for the other instances.
+func (cache *diskBlockCacheShared) Shutdown(ctx context.Context) {
+	cache.hitMeter.Shutdown()
+	cache.missMeter.Shutdown()
+	cache.putMeter.Shutdown()
+	cache.evictCountMeter.Shutdown()
+	cache.evictSizeMeter.Shutdown()
+}
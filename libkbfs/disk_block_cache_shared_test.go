@@ -0,0 +1,202 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func makeSharedCacheTestBlock(t *testing.T, config diskBlockCacheConfig,
+	contents string) (kbfsblock.ID, []byte, kbfscrypto.BlockCryptKeyServerHalf) {
+	block := NewFileBlock().(*FileBlock)
+	block.Contents = []byte(contents)
+	buf, serverHalf := setupRealBlockForDiskCache(
+		t, BlockPointer{}, block, config)
+	id, err := kbfsblock.MakePermanentID(buf, kbfscrypto.EncryptionSecretbox)
+	require.NoError(t, err)
+	return id, buf, serverHalf
+}
+
+func TestDiskBlockCacheSharedBetweenInstances(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir(os.TempDir(), "disk_block_cache_shared")
+	require.NoError(t, err)
+	defer ioutil.RemoveAll(dir)
+
+	config := newTestDiskBlockCacheConfig(t)
+	cache1, err := newDiskBlockCacheShared(config, dir, 1<<20)
+	require.NoError(t, err)
+	defer cache1.Shutdown(ctx)
+
+	publicID := tlf.FakeID(1, tlf.Public)
+	id, buf, serverHalf := makeSharedCacheTestBlock(t, config, "public")
+	err = cache1.Put(ctx, publicID, id, buf, serverHalf)
+	require.NoError(t, err)
+
+	t.Log("Another instance can read the block")
+	cache2, err := newDiskBlockCacheShared(config, dir, 1<<20)
+	require.NoError(t, err)
+	defer cache2.Shutdown(ctx)
+	require.Equal(t, 1, cache2.numBlocks)
+	gotBuf, gotServerHalf, err := cache2.Get(ctx, publicID, id)
+	require.NoError(t, err)
+	require.Equal(t, buf, gotBuf)
+	require.Equal(t, serverHalf, gotServerHalf)
+
+	t.Log("Private and team blocks are refused")
+	for _, tlfID := range []tlf.ID{
+		tlf.FakeID(2, tlf.Private), tlf.FakeID(3, tlf.SingleTeam)} {
+		id, buf, serverHalf := makeSharedCacheTestBlock(t, config, "secret")
+		err = cache1.Put(ctx, tlfID, id, buf, serverHalf)
+		require.Error(t, err)
+		require.False(t, cache1.has(id))
+		_, _, err = cache1.Get(ctx, tlfID, id)
+		require.Error(t, err)
+	}
+
+	t.Log("Blocks that don't match their IDs are dropped")
+	badID, _, _ := makeSharedCacheTestBlock(t, config, "bad")
+	err = cache1.Put(ctx, publicID, badID, buf, serverHalf)
+	require.NoError(t, err)
+	_, _, err = cache2.Get(ctx, publicID, badID)
+	require.IsType(t, NoSuchBlockError{}, err)
+	require.False(t, cache2.has(badID))
+}
+
+func TestDiskBlockCacheSharedPutReplacesBadEntries(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir(os.TempDir(), "disk_block_cache_shared")
+	require.NoError(t, err)
+	defer ioutil.RemoveAll(dir)
+
+	config := newTestDiskBlockCacheConfig(t)
+	cache, err := newDiskBlockCacheShared(config, dir, 1<<20)
+	require.NoError(t, err)
+	defer cache.Shutdown(ctx)
+
+	publicID := tlf.FakeID(1, tlf.Public)
+	id, buf, serverHalf := makeSharedCacheTestBlock(t, config, "public")
+
+	t.Log("Junk left by someone else is overwritten")
+	p := cache.blockPath(id)
+	err = ioutil.MkdirAll(filepath.Dir(p), 0777)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(p, []byte("junk"), 0666)
+	require.NoError(t, err)
+	cache.numBlocks++
+	cache.currBytes += 4
+	err = cache.Put(ctx, publicID, id, buf, serverHalf)
+	require.NoError(t, err)
+	gotBuf, gotServerHalf, err := cache.Get(ctx, publicID, id)
+	require.NoError(t, err)
+	require.Equal(t, buf, gotBuf)
+	require.Equal(t, serverHalf, gotServerHalf)
+	require.Equal(t, 1, cache.numBlocks)
+
+	t.Log("So is a good block with the wrong server half")
+	badServerHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+	require.NoError(t, err)
+	data, err := config.Codec().Encode(&diskBlockCacheEntry{
+		Buf:        buf,
+		ServerHalf: badServerHalf,
+	})
+	require.NoError(t, err)
+	err = ioutil.WriteFile(p, data, 0666)
+	require.NoError(t, err)
+	err = cache.Put(ctx, publicID, id, buf, serverHalf)
+	require.NoError(t, err)
+	_, gotServerHalf, err = cache.Get(ctx, publicID, id)
+	require.NoError(t, err)
+	require.Equal(t, serverHalf, gotServerHalf)
+	require.Equal(t, 1, cache.numBlocks)
+	require.Equal(t, int64(len(data)), cache.currBytes)
+}
+
+func TestDiskBlockCacheSharedEviction(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir(os.TempDir(), "disk_block_cache_shared")
+	require.NoError(t, err)
+	defer ioutil.RemoveAll(dir)
+
+	config := newTestDiskBlockCacheConfig(t)
+	publicID := tlf.FakeID(1, tlf.Public)
+	id1, buf1, serverHalf1 := makeSharedCacheTestBlock(t, config, "one")
+	entry, err := config.Codec().Encode(&diskBlockCacheEntry{
+		Buf:        buf1,
+		ServerHalf: serverHalf1,
+	})
+	require.NoError(t, err)
+
+	// Only room for a single block.
+	cache, err := newDiskBlockCacheShared(config, dir, int64(len(entry)))
+	require.NoError(t, err)
+	defer cache.Shutdown(ctx)
+	err = cache.Put(ctx, publicID, id1, buf1, serverHalf1)
+	require.NoError(t, err)
+
+	id2, buf2, serverHalf2 := makeSharedCacheTestBlock(t, config, "two")
+	err = cache.Put(ctx, publicID, id2, buf2, serverHalf2)
+	require.NoError(t, err)
+	require.False(t, cache.has(id1))
+	require.True(t, cache.has(id2))
+	require.Equal(t, 1, cache.numBlocks)
+	require.Equal(t, int64(len(entry)), cache.currBytes)
+}
+
+func TestDiskBlockCacheWrappedSharesPublicBlocks(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir(os.TempDir(), "disk_block_cache_shared")
+	require.NoError(t, err)
+	defer ioutil.RemoveAll(dir)
+
+	cache, config := initDiskBlockCacheTest(t)
+	defer shutdownDiskBlockCacheTest(cache)
+	cache.sharedCache, err = newDiskBlockCacheShared(config, dir, 1<<20)
+	require.NoError(t, err)
+
+	t.Log("Public blocks go to the shared cache only")
+	publicID := tlf.FakeID(1, tlf.Public)
+	id, buf, serverHalf := makeSharedCacheTestBlock(t, config, "public")
+	err = cache.Put(ctx, publicID, id, buf, serverHalf)
+	require.NoError(t, err)
+	require.True(t, cache.sharedCache.has(id))
+	_, _, _, err = cache.workingSetCache.Get(ctx, publicID, id)
+	require.IsType(t, NoSuchBlockError{}, err)
+	gotBuf, _, _, err := cache.Get(ctx, publicID, id)
+	require.NoError(t, err)
+	require.Equal(t, buf, gotBuf)
+	err = cache.UpdateMetadata(ctx, id, FinishedPrefetch)
+	require.NoError(t, err)
+
+	t.Log("Private blocks stay in the working set cache")
+	privateID := tlf.FakeID(2, tlf.Private)
+	id, buf, serverHalf = makeSharedCacheTestBlock(t, config, "private")
+	err = cache.Put(ctx, privateID, id, buf, serverHalf)
+	require.NoError(t, err)
+	require.False(t, cache.sharedCache.has(id))
+	gotBuf, _, _, err = cache.workingSetCache.Get(ctx, privateID, id)
+	require.NoError(t, err)
+	require.Equal(t, buf, gotBuf)
+
+	t.Log("Synced public blocks stay in the sync cache")
+	syncedID := tlf.FakeID(3, tlf.Public)
+	err = config.SetTlfSyncState(syncedID, true)
+	require.NoError(t, err)
+	id, buf, serverHalf = makeSharedCacheTestBlock(t, config, "synced")
+	err = cache.Put(ctx, syncedID, id, buf, serverHalf)
+	require.NoError(t, err)
+	require.False(t, cache.sharedCache.has(id))
+	_, _, _, err = cache.syncCache.Get(ctx, syncedID, id)
+	require.NoError(t, err)
+}
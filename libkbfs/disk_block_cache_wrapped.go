@@ -41,6 +41,9 @@ type diskBlockCacheWrapped struct {
 	mtx             sync.RWMutex
 	workingSetCache *DiskBlockCacheLocal
 	syncCache       *DiskBlockCacheLocal
+	// sharedCache, if non-nil, holds the blocks of unsynced public
+	// TLFs in place of the working set cache.
+	sharedCache *diskBlockCacheShared
 }
 
 var _ DiskBlockCache = (*diskBlockCacheWrapped)(nil)
@@ -75,7 +78,7 @@ func (cache *diskBlockCacheWrapped) enableCache(
 }
 
 func newDiskBlockCacheWrapped(config diskBlockCacheConfig,
-	storageRoot string) (cache *diskBlockCacheWrapped, err error) {
+	storageRoot, sharedDir string) (cache *diskBlockCacheWrapped, err error) {
 	cache = &diskBlockCacheWrapped{
 		config:      config,
 		storageRoot: storageRoot,
@@ -93,6 +96,17 @@ func newDiskBlockCacheWrapped(config diskBlockCacheConfig,
 		// We still return success because the working set cache successfully
 		// initialized.
 	}
+	if sharedDir != "" {
+		cache.sharedCache, err = newDiskBlockCacheShared(
+			config, sharedDir, defaultSharedDiskBlockCacheMaxBytes)
+		if err != nil {
+			log := config.MakeLogger("KBC")
+			log.Warning("Could not initialize shared block cache in %s: %+v",
+				sharedDir, err)
+			// Public blocks just go into the working set cache instead.
+			cache.sharedCache = nil
+		}
+	}
 	return cache, nil
 }
 
@@ -113,6 +127,21 @@ func (cache *diskBlockCacheWrapped) IsSyncCacheEnabled() bool {
 	return cache.syncCache != nil
 }
 
+func (cache *diskBlockCacheWrapped) getSyncCache() *DiskBlockCacheLocal {
+	cache.mtx.RLock()
+	defer cache.mtx.RUnlock()
+	return cache.syncCache
+}
+
+// useSharedCacheLocked returns true if blocks of the given TLF belong
+// in the shared cache.  Only public TLFs are ever shared, and synced
+// TLFs keep their blocks in the sync cache, where they can't be
+// evicted by other instances.
+func (cache *diskBlockCacheWrapped) useSharedCacheLocked(tlfID tlf.ID) bool {
+	return cache.sharedCache != nil && tlfID.Type() == tlf.Public &&
+		!cache.config.IsSyncedTlf(tlfID)
+}
+
 // Get implements the DiskBlockCache interface for diskBlockCacheWrapped.
 func (cache *diskBlockCacheWrapped) Get(ctx context.Context, tlfID tlf.ID,
 	blockID kbfsblock.ID) (
@@ -130,7 +159,14 @@ func (cache *diskBlockCacheWrapped) Get(ctx context.Context, tlfID tlf.ID,
 		primaryCache.Get(ctx, tlfID, blockID)
 	if _, isNoSuchBlockError := err.(NoSuchBlockError); isNoSuchBlockError &&
 		secondaryCache != nil {
-		return secondaryCache.Get(ctx, tlfID, blockID)
+		buf, serverHalf, prefetchStatus, err =
+			secondaryCache.Get(ctx, tlfID, blockID)
+	}
+	if _, isNoSuchBlockError := err.(NoSuchBlockError); isNoSuchBlockError &&
+		cache.useSharedCacheLocked(tlfID) {
+		// The shared cache doesn't track prefetches.
+		buf, serverHalf, err = cache.sharedCache.Get(ctx, tlfID, blockID)
+		return buf, serverHalf, NoPrefetch, err
 	}
 	return buf, serverHalf, prefetchStatus, err
 }
@@ -170,6 +206,14 @@ func (cache *diskBlockCacheWrapped) Put(ctx context.Context, tlfID tlf.ID,
 			return nil
 		}
 		// Otherwise drop through and put it into the working set cache.
+	} else if cache.useSharedCacheLocked(tlfID) {
+		err := cache.sharedCache.Put(ctx, tlfID, blockID, buf, serverHalf)
+		if err == nil {
+			return nil
+		}
+		cache.sharedCache.log.CDebugf(ctx, "Couldn't put block %s into the "+
+			"shared cache: %+v", blockID, err)
+		// Otherwise drop through and put it into the working set cache.
 	}
 	// TODO: Allow more intelligent transitioning from the sync cache to
 	// the working set cache.
@@ -212,7 +256,14 @@ func (cache *diskBlockCacheWrapped) UpdateMetadata(ctx context.Context,
 			return err
 		}
 	}
-	return cache.workingSetCache.UpdateMetadata(ctx, blockID, prefetchStatus)
+	err := cache.workingSetCache.UpdateMetadata(ctx, blockID, prefetchStatus)
+	if _, isNoSuchBlockError := err.(NoSuchBlockError); isNoSuchBlockError &&
+		cache.sharedCache != nil && cache.sharedCache.has(blockID) {
+		// The shared cache doesn't track prefetches, so there's
+		// nothing to update.
+		return nil
+	}
+	return err
 }
 
 // Status implements the DiskBlockCache interface for diskBlockCacheWrapped.
@@ -222,17 +273,21 @@ func (cache *diskBlockCacheWrapped) Status(
 	// caches. So we use a read lock.
 	cache.mtx.RLock()
 	defer cache.mtx.RUnlock()
-	statuses := make(map[string]DiskBlockCacheStatus, 2)
+	statuses := make(map[string]DiskBlockCacheStatus, 3)
 	if cache.workingSetCache != nil {
 		for name, status := range cache.workingSetCache.Status(ctx) {
 			statuses[name] = status
 		}
 	}
-	if cache.syncCache == nil {
-		return statuses
+	if cache.syncCache != nil {
+		for name, status := range cache.syncCache.Status(ctx) {
+			statuses[name] = status
+		}
 	}
-	for name, status := range cache.syncCache.Status(ctx) {
-		statuses[name] = status
+	if cache.sharedCache != nil {
+		for name, status := range cache.sharedCache.Status(ctx) {
+			statuses[name] = status
+		}
 	}
 	return statuses
 }
@@ -245,4 +300,36 @@ func (cache *diskBlockCacheWrapped) Shutdown(ctx context.Context) {
 	if cache.syncCache != nil {
 		cache.syncCache.Shutdown(ctx)
 	}
+	if cache.sharedCache != nil {
+		cache.sharedCache.Shutdown(ctx)
+	}
+}
+
+// deleteTLFBlocks removes every block cached for the given TLF, from
+// both the working set and sync caches.
+func (cache *diskBlockCacheWrapped) deleteTLFBlocks(
+	ctx context.Context, tlfID tlf.ID) (
+	numRemoved int, sizeRemoved int64, err error) {
+	cache.mtx.RLock()
+	defer cache.mtx.RUnlock()
+	for _, c := range []*DiskBlockCacheLocal{
+		cache.workingSetCache, cache.syncCache} {
+		if c == nil {
+			continue
+		}
+		ids, err := c.getBlockIDsForTLF(ctx, tlfID)
+		if err != nil {
+			return numRemoved, sizeRemoved, err
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		n, size, err := c.Delete(ctx, ids)
+		numRemoved += n
+		sizeRemoved += size
+		if err != nil {
+			return numRemoved, sizeRemoved, err
+		}
+	}
+	return numRemoved, sizeRemoved, nil
 }
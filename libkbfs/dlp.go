@@ -0,0 +1,299 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	stdpath "path"
+	"strings"
+	"time"
+
+	kbname "github.com/keybase/client/go/kbun"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// DLPAuditLogFileName is the name of a file, at the root of a team
+// TLF, that clients append a line of JSON to for each violation of
+// the folder's DLP rules that they refuse, so that the team's admins
+// can review them with ReadDLPAuditLog.  Any writer can append to
+// it, but only admins can otherwise change it.
+const DLPAuditLogFileName = ".kbfs_dlp_audit"
+
+// DLPAuditEntry records one refused violation of a DLP rule.
+type DLPAuditEntry struct {
+	Time   time.Time
+	User   kbname.NormalizedUsername
+	Device string
+	// Path is the canonical path of the refused file.
+	Path   string
+	Rule   string
+	Reason string
+}
+
+// DLPRule restricts which files may be written to a team folder.  A
+// file violates the rule if it matches any of the rule's criteria.
+// Rules are configured by the admins of the team, in the DLPRules
+// setting of the folder's settings file, and like drop folders
+// they're enforced by clients rather than by the server.
+type DLPRule struct {
+	// Name identifies the rule in violation reports.
+	Name string
+	// Extensions lists forbidden file extensions, such as ".pem".
+	// They are matched case-insensitively.
+	Extensions []string `json:",omitempty"`
+	// NamePatterns lists forbidden file names, as shell patterns
+	// matched against the base name of the file (see path.Match).
+	NamePatterns []string `json:",omitempty"`
+	// MaxSize, if non-zero, is the largest allowed file size in bytes.
+	MaxSize uint64 `json:",omitempty"`
+}
+
+func (r DLPRule) validate() error {
+	if r.Name == "" {
+		return errors.New("DLP rule has no name")
+	}
+	for _, p := range r.NamePatterns {
+		if _, err := stdpath.Match(p, ""); err != nil {
+			return errors.Errorf(
+				"DLP rule %q has an invalid pattern %q", r.Name, p)
+		}
+	}
+	return nil
+}
+
+// violation returns a description of how a file with the given name
+// and size violates this rule, or the empty string if it doesn't.
+func (r DLPRule) violation(name string, size uint64) string {
+	ext := strings.ToLower(stdpath.Ext(name))
+	for _, e := range r.Extensions {
+		if ext != "" && strings.ToLower(e) == ext {
+			return fmt.Sprintf("extension %s is not allowed", ext)
+		}
+	}
+	for _, p := range r.NamePatterns {
+		if matched, err := stdpath.Match(p, name); err == nil && matched {
+			return fmt.Sprintf("name matches %q", p)
+		}
+	}
+	if r.MaxSize > 0 && size > r.MaxSize {
+		return fmt.Sprintf("size %d exceeds %d", size, r.MaxSize)
+	}
+	return ""
+}
+
+// checkDLPRules returns the first of `rules` violated by a file with
+// the given name and size, along with a description of the violation.
+func checkDLPRules(rules []DLPRule, name string, size uint64) (
+	rule DLPRule, reason string, violated bool) {
+	for _, r := range rules {
+		if reason := r.violation(name, size); reason != "" {
+			return r, reason, true
+		}
+	}
+	return DLPRule{}, "", false
+}
+
+// checkAdminOnlyEdit returns a TeamAdminOnlyError if the entry named
+// `name` in the directory at `dirPath` is one that only the team's
+// admins can change, and the current user isn't one of them.  Those
+// are the settings file, which holds the DLP rules, and the DLP
+// audit log, unless the change only appends to it.
+func (fbo *folderBranchOps) checkAdminOnlyEdit(
+	ctx context.Context, dirPath path, name string, appending bool) error {
+	if fbo.id().Type() != tlf.SingleTeam || len(dirPath.path) != 1 {
+		return nil
+	}
+	switch {
+	case name == TlfSettingsFileName:
+	case name == DLPAuditLogFileName && !appending:
+	default:
+		return nil
+	}
+	head, _ := fbo.getHead(makeFBOLockState())
+	if head == (ImmutableRootMetadata{}) {
+		// Nothing can be written to the folder yet anyway.
+		return nil
+	}
+
+	session, err := fbo.config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return err
+	}
+	tid := head.GetTlfHandle().FirstResolvedWriter().AsTeamOrBust()
+	isAdmin, err := fbo.config.KBPKI().IsTeamAdmin(ctx, tid, session.UID)
+	if err != nil {
+		return err
+	}
+	if isAdmin {
+		return nil
+	}
+	return errors.WithStack(TeamAdminOnlyError{
+		dirPath.ChildPathNoPtr(name).CanonicalPathString()})
+}
+
+// checkDLP returns a DLPViolationError if a file named `name` in the
+// directory at `dirPath`, with the given size, would violate the DLP
+// rules of the folder.  Only team folders have DLP rules.  It's
+// called before each operation that could create a violation,
+// i.e. creating, renaming, writing to or extending a file, so that
+// the operation is refused, reported and audited once, rather than
+// failing every later sync of the folder.  The settings file and the
+// audit log are never refused, so that the rules can always be
+// changed and violations can always be audited; who can change them
+// is checked by checkAdminOnlyEdit instead.
+//
+// Only the first check reads the settings; later ones use the
+// settings as of the last time they were reloaded, so that the
+// operations don't have to wait on any block fetches.
+func (fbo *folderBranchOps) checkDLP(
+	ctx context.Context, dirPath path, name string, size uint64) error {
+	if fbo.id().Type() != tlf.SingleTeam {
+		return nil
+	}
+	settings, loaded := fbo.peekTlfSettings()
+	if !loaded {
+		head, _ := fbo.getHead(makeFBOLockState())
+		if head == (ImmutableRootMetadata{}) || !head.IsReadable() {
+			// Nothing can be written to the folder yet anyway.
+			return nil
+		}
+		var err error
+		settings, err = fbo.getCachedTlfSettings(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	if len(settings.DLPRules) == 0 {
+		return nil
+	}
+	if !dirPath.isValid() {
+		return errors.WithStack(InvalidPathError{dirPath})
+	}
+	if len(dirPath.path) == 1 &&
+		(name == TlfSettingsFileName || name == DLPAuditLogFileName) {
+		return nil
+	}
+	rule, reason, violated := checkDLPRules(settings.DLPRules, name, size)
+	if !violated {
+		return nil
+	}
+
+	p := dirPath.ChildPathNoPtr(name).CanonicalPathString()
+	fbo.log.CWarningf(ctx, "DLP rule %q refused %s: %s",
+		rule.Name, p, reason)
+	err := DLPViolationError{p, rule.Name, reason}
+	head, _ := fbo.getHead(makeFBOLockState())
+	if head != (ImmutableRootMetadata{}) {
+		h := head.GetTlfHandle()
+		fbo.config.Reporter().ReportErr(
+			ctx, h.GetCanonicalName(), h.Type(), WriteMode, err)
+	}
+	auditErr := fbo.auditDLPViolation(ctx, p, rule.Name, reason)
+	if auditErr != nil {
+		fbo.log.CWarningf(ctx, "Couldn't audit the refusal of %s: %+v",
+			p, auditErr)
+	}
+	return err
+}
+
+// auditDLPViolation appends an entry for a refused violation to the
+// folder's DLP audit log.  The entry is synced along with the rest
+// of the folder.
+func (fbo *folderBranchOps) auditDLPViolation(
+	ctx context.Context, p, rule, reason string) error {
+	session, err := fbo.config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(DLPAuditEntry{
+		Time:   fbo.config.Clock().Now(),
+		User:   session.Name,
+		Device: fbo.accessLogDeviceName(ctx, session),
+		Path:   p,
+		Rule:   rule,
+		Reason: reason,
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	data = append(data, '\n')
+
+	rootNode, _, _, err := fbo.getRootNode(ctx)
+	if err != nil {
+		return err
+	}
+	node, ei, err := fbo.Lookup(ctx, rootNode, DLPAuditLogFileName)
+	if _, ok := errors.Cause(err).(NoSuchNameError); ok {
+		// Users can't create the log themselves, since it has a
+		// reserved prefix.
+		node, ei, err = fbo.CreateFile(
+			context.WithValue(ctx, CtxAllowNameKey, DLPAuditLogFileName),
+			rootNode, DLPAuditLogFileName, false, NoExcl)
+	}
+	if err != nil {
+		return err
+	}
+	return fbo.Write(ctx, node, data, int64(ei.Size))
+}
+
+// checkDLPForFile is like checkDLP, for the existing file `file`
+// once it's `size` bytes long, after a change starting at offset
+// `off`.  It also checks the change with checkAdminOnlyEdit.
+func (fbo *folderBranchOps) checkDLPForFile(
+	ctx context.Context, file Node, off, size uint64) error {
+	filePath := fbo.nodeCache.PathFromNode(file)
+	if !filePath.hasValidParent() {
+		return nil
+	}
+	dirPath, name := *filePath.parentPath(), filePath.tailName()
+	appending := true
+	if name == DLPAuditLogFileName {
+		ei, err := fbo.Stat(ctx, file)
+		if err != nil {
+			return err
+		}
+		appending = off >= ei.Size
+	}
+	err := fbo.checkAdminOnlyEdit(ctx, dirPath, name, appending)
+	if err != nil {
+		return err
+	}
+	return fbo.checkDLP(ctx, dirPath, name, size)
+}
+
+// ReadDLPAuditLog returns the entries of the DLP audit log of the
+// team TLF with the given root node, oldest first.  Lines that can't
+// be parsed, such as a partially-written last line, are skipped.
+func ReadDLPAuditLog(
+	ctx context.Context, kbfsOps KBFSOps, rootNode Node) (
+	[]DLPAuditEntry, error) {
+	node, ei, err := kbfsOps.Lookup(ctx, rootNode, DLPAuditLogFileName)
+	if _, ok := errors.Cause(err).(NoSuchNameError); ok {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if ei.Type == Dir || ei.Type == Sym {
+		return nil, nil
+	}
+
+	buf := make([]byte, ei.Size)
+	n, err := kbfsOps.Read(CtxSkipRecentFiles(ctx), node, buf, 0)
+	if err != nil {
+		return nil, err
+	}
+	var entries []DLPAuditEntry
+	for _, line := range bytes.Split(buf[:n], []byte("\n")) {
+		var entry DLPAuditEntry
+		if json.Unmarshal(line, &entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,198 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	kbname "github.com/keybase/client/go/kbun"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDLPRulesCheck(t *testing.T) {
+	rules := []DLPRule{
+		{Name: "keys", Extensions: []string{".pem", ".KEY"}},
+		{Name: "dumps", NamePatterns: []string{"*.sql.gz", "dump-*"}},
+		{Name: "large", MaxSize: 100},
+	}
+
+	for _, tc := range []struct {
+		name string
+		size uint64
+		rule string
+	}{
+		{"notes.txt", 10, ""},
+		{"server.pem", 10, "keys"},
+		{"server.key", 10, "keys"},
+		{"pem", 10, ""},
+		{"prod.sql.gz", 10, "dumps"},
+		{"dump-2018", 10, "dumps"},
+		{"movie.mp4", 101, "large"},
+		{"movie.mp4", 100, ""},
+	} {
+		rule, _, violated := checkDLPRules(rules, tc.name, tc.size)
+		require.Equal(t, tc.rule != "", violated, tc.name)
+		require.Equal(t, tc.rule, rule.Name, tc.name)
+	}
+
+	err := TlfSettings{
+		Version:  TlfSettingsVersion,
+		DLPRules: []DLPRule{{Name: "bad", NamePatterns: []string{"["}}},
+	}.Validate()
+	require.IsType(t, InvalidTlfSettingsError{}, errors.Cause(err))
+}
+
+func TestDLPRulesRefuseTeamWrites(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+
+	name := kbname.NormalizedUsername("t1")
+	teamInfos := AddEmptyTeamsForTestOrBust(t, config, name)
+	AddTeamAdminForTestOrBust(t, config, teamInfos[0].TID, session.UID)
+	h, err := ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), string(name), tlf.SingleTeam)
+	require.NoError(t, err)
+	kbfsOps := config.KBFSOps()
+	rootNode, _, err := kbfsOps.GetOrCreateRootNode(ctx, h, MasterBranch)
+	require.NoError(t, err)
+	err = WriteTlfSettings(ctx, kbfsOps, rootNode, TlfSettings{
+		Version: TlfSettingsVersion,
+		DLPRules: []DLPRule{
+			{Name: "keys", Extensions: []string{".pem"}},
+			{Name: "small", MaxSize: 10},
+		},
+	})
+	require.NoError(t, err)
+
+	t.Log("Files can't be created with a forbidden name")
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "id.pem", false, NoExcl)
+	require.IsType(t, DLPViolationError{}, err)
+	reported := config.Reporter().AllKnownErrors()
+	require.Len(t, reported, 1)
+	require.IsType(t, DLPViolationError{}, reported[0].Error)
+
+	t.Log("Or renamed to one")
+	ok, _, err := kbfsOps.CreateFile(ctx, rootNode, "ok.txt", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Rename(ctx, rootNode, "ok.txt", rootNode, "ok.pem")
+	require.IsType(t, DLPViolationError{}, err)
+
+	t.Log("Or grown past the size limit")
+	err = kbfsOps.Write(ctx, ok, []byte("fine"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, ok, []byte("too much"), 4)
+	require.IsType(t, DLPViolationError{}, err)
+	err = kbfsOps.Truncate(ctx, ok, 11)
+	require.IsType(t, DLPViolationError{}, err)
+
+	t.Log("Refused operations don't keep the folder from syncing")
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+	_, ei, err := kbfsOps.Lookup(ctx, rootNode, "ok.txt")
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), ei.Size)
+	require.Len(t, config.Reporter().AllKnownErrors(), 4)
+
+	t.Log("Each refusal is in the audit log")
+	entries, err := ReadDLPAuditLog(ctx, kbfsOps, rootNode)
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+	require.Equal(t, "/keybase/team/t1/id.pem", entries[0].Path)
+	require.Equal(t, "keys", entries[0].Rule)
+	require.Equal(t, "/keybase/team/t1/ok.pem", entries[1].Path)
+	require.Equal(t, "/keybase/team/t1/ok.txt", entries[2].Path)
+	require.Equal(t, "small", entries[2].Rule)
+	for _, e := range entries {
+		require.Equal(t, session.Name, e.User)
+	}
+}
+
+func TestDLPRulesOnlyAdminsChangeSettings(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice", "bob")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	configBob := ConfigAsUser(config, "bob")
+	defer CheckConfigAndShutdown(ctx, t, configBob)
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+	sessionBob, err := configBob.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+
+	name := kbname.NormalizedUsername("t1")
+	teamInfos := AddEmptyTeamsForTestOrBust(t, config, name)
+	_ = AddEmptyTeamsForTestOrBust(t, configBob, name)
+	tid := teamInfos[0].TID
+	AddTeamAdminForTestOrBust(t, config, tid, session.UID)
+	AddTeamAdminForTestOrBust(t, configBob, tid, session.UID)
+	AddTeamWriterForTestOrBust(t, config, tid, sessionBob.UID)
+	AddTeamWriterForTestOrBust(t, configBob, tid, sessionBob.UID)
+
+	h, err := ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), string(name), tlf.SingleTeam)
+	require.NoError(t, err)
+	kbfsOps := config.KBFSOps()
+	rootNode, _, err := kbfsOps.GetOrCreateRootNode(ctx, h, MasterBranch)
+	require.NoError(t, err)
+	rules := TlfSettings{
+		Version:  TlfSettingsVersion,
+		DLPRules: []DLPRule{{Name: "keys", Extensions: []string{".pem"}}},
+	}
+	err = WriteTlfSettings(ctx, kbfsOps, rootNode, rules)
+	require.NoError(t, err)
+
+	t.Log("A writer who isn't an admin can't change the rules")
+	kbfsOpsBob := configBob.KBFSOps()
+	rootNodeBob, _, err := kbfsOpsBob.GetOrCreateRootNode(
+		ctx, h, MasterBranch)
+	require.NoError(t, err)
+	err = WriteTlfSettings(ctx, kbfsOpsBob, rootNodeBob, TlfSettings{
+		Version: TlfSettingsVersion,
+	})
+	require.IsType(t, TeamAdminOnlyError{}, errors.Cause(err))
+	err = kbfsOpsBob.RemoveEntry(ctx, rootNodeBob, TlfSettingsFileName)
+	require.IsType(t, TeamAdminOnlyError{}, errors.Cause(err))
+	err = kbfsOpsBob.Rename(
+		ctx, rootNodeBob, TlfSettingsFileName, rootNodeBob, "old")
+	require.IsType(t, TeamAdminOnlyError{}, errors.Cause(err))
+	settings, err := ReadTlfSettings(ctx, kbfsOpsBob, rootNodeBob)
+	require.NoError(t, err)
+	require.Equal(t, rules, settings)
+
+	t.Log("But their refusals are still audited")
+	_, _, err = kbfsOpsBob.CreateFile(ctx, rootNodeBob, "id.pem", false, NoExcl)
+	require.IsType(t, DLPViolationError{}, err)
+	err = kbfsOpsBob.SyncAll(ctx, rootNodeBob.GetFolderBranch())
+	require.NoError(t, err)
+
+	t.Log("And they can't erase the audit log")
+	audit, _, err := kbfsOpsBob.Lookup(ctx, rootNodeBob, DLPAuditLogFileName)
+	require.NoError(t, err)
+	err = kbfsOpsBob.Truncate(ctx, audit, 0)
+	require.IsType(t, TeamAdminOnlyError{}, errors.Cause(err))
+	err = kbfsOpsBob.Write(ctx, audit, []byte("{}"), 0)
+	require.IsType(t, TeamAdminOnlyError{}, errors.Cause(err))
+	err = kbfsOpsBob.RemoveEntry(ctx, rootNodeBob, DLPAuditLogFileName)
+	require.IsType(t, TeamAdminOnlyError{}, errors.Cause(err))
+
+	t.Log("The admin sees the refusal in the audit log")
+	err = kbfsOps.SyncFromServer(
+		ctx, rootNode.GetFolderBranch(), nil)
+	require.NoError(t, err)
+	entries, err := ReadDLPAuditLog(ctx, kbfsOps, rootNode)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, sessionBob.Name, entries[0].User)
+	require.Equal(t, "/keybase/team/t1/id.pem", entries[0].Path)
+	require.Equal(t, "keys", entries[0].Rule)
+
+	t.Log("And can still change the rules")
+	err = WriteTlfSettings(ctx, kbfsOps, rootNode, TlfSettings{
+		Version: TlfSettingsVersion,
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,459 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"encoding/hex"
+	stdpath "path"
+	"sort"
+	"strings"
+	"time"
+
+	kbname "github.com/keybase/client/go/kbun"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/go-codec/codec"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+const (
+	// DropEntrySuffix is the suffix of the names of the files
+	// holding drop folder submissions.
+	DropEntrySuffix = ".kbdrop"
+
+	dropEntryVersion = 1
+
+	// maxDropEntryBytes is the biggest drop folder file, sealed
+	// entry and all, that's submitted or read.  Readers read whole
+	// entries into memory, and any writer can put files in a drop
+	// folder, so this keeps one from exhausting a collector's
+	// memory.
+	maxDropEntryBytes = 32 << 20
+)
+
+// DropEntry is a file submitted to a drop folder.
+type DropEntry struct {
+	// Name is the name the submitter gave the file.
+	Name string
+	// Submitter is the writer of the verified MD revision that last
+	// wrote the file.
+	Submitter kbname.NormalizedUsername
+	// Time is the server timestamp of that revision.
+	Time time.Time
+	Data []byte
+}
+
+// dropEntryContents is the part of a DropEntry that's sealed in a
+// drop folder file.  The submitter and time aren't, since they'd be
+// self-asserted; they come from the MD history instead.
+type dropEntryContents struct {
+	Name string `codec:"n"`
+	Data []byte `codec:"d"`
+
+	codec.UnknownFieldSetHandler
+}
+
+// sealedDropEntry is the contents of a drop folder file.  The entry
+// is encrypted with a random key, which is in turn encrypted for
+// every device of every reader.
+type sealedDropEntry struct {
+	Version int                                      `codec:"v"`
+	Keys    []EncryptedTLFCryptKeyClientAndEphemeral `codec:"k"`
+	Entry   kbfscrypto.EncryptedDropEntry            `codec:"e"`
+
+	codec.UnknownFieldSetHandler
+}
+
+// sealDropEntry encrypts `entry` so that only the devices of
+// `readers` can open it.
+func sealDropEntry(ctx context.Context, config Config,
+	readers []keybase1.UID, entry dropEntryContents) ([]byte, error) {
+	var keyData [32]byte
+	if err := kbfscrypto.RandRead(keyData[:]); err != nil {
+		return nil, err
+	}
+	ePubKey, ePrivKey, err := config.Crypto().MakeRandomTLFEphemeralKeys()
+	if err != nil {
+		return nil, err
+	}
+	clientHalf := kbfscrypto.MakeTLFCryptKeyClientHalf(keyData)
+
+	var sealed sealedDropEntry
+	sealed.Version = dropEntryVersion
+	for _, uid := range readers {
+		pubKeys, err := config.KBPKI().GetCryptPublicKeys(ctx, uid)
+		if err != nil {
+			return nil, err
+		}
+		for _, pubKey := range pubKeys {
+			encryptedHalf, err := kbfscrypto.EncryptTLFCryptKeyClientHalf(
+				ePrivKey, pubKey, clientHalf)
+			if err != nil {
+				return nil, err
+			}
+			sealed.Keys = append(sealed.Keys,
+				EncryptedTLFCryptKeyClientAndEphemeral{
+					PubKey:     pubKey,
+					ClientHalf: encryptedHalf,
+					EPubKey:    ePubKey,
+				})
+		}
+	}
+
+	encodedEntry, err := config.Codec().Encode(entry)
+	if err != nil {
+		return nil, err
+	}
+	sealed.Entry, err = kbfscrypto.EncryptEncodedDropEntry(
+		encodedEntry, kbfscrypto.MakeTLFCryptKey(keyData))
+	if err != nil {
+		return nil, err
+	}
+	return config.Codec().Encode(sealed)
+}
+
+// openDropEntry decrypts the contents of a drop folder file with
+// one of this device's keys.  It returns a libkb.DecryptionError if
+// the entry wasn't sealed for this device.
+func openDropEntry(ctx context.Context, config Config, buf []byte) (
+	dropEntryContents, error) {
+	var sealed sealedDropEntry
+	if err := config.Codec().Decode(buf, &sealed); err != nil {
+		return dropEntryContents{}, err
+	}
+	if sealed.Version != dropEntryVersion {
+		return dropEntryContents{}, errors.Errorf(
+			"Unsupported drop entry version %d", sealed.Version)
+	}
+	clientHalf, _, err := config.Crypto().DecryptTLFCryptKeyClientHalfAny(
+		ctx, sealed.Keys, false)
+	if err != nil {
+		return dropEntryContents{}, err
+	}
+	encodedEntry, err := kbfscrypto.DecryptDropEntry(
+		sealed.Entry, kbfscrypto.MakeTLFCryptKey(clientHalf.Data()))
+	if err != nil {
+		return dropEntryContents{}, err
+	}
+	var entry dropEntryContents
+	if err := config.Codec().Decode(encodedEntry, &entry); err != nil {
+		return dropEntryContents{}, err
+	}
+	return entry, nil
+}
+
+// dropEntryWrite is the MD revision that last wrote a drop folder
+// file.
+type dropEntryWrite struct {
+	writer keybase1.UID
+	time   time.Time
+}
+
+// findDropEntryWrites returns, for each of the given file pointers,
+// the writer and server timestamp of the merged MD revision that
+// last wrote the file with that pointer, by walking back through
+// the verified MD history of the folder from its head.  `ptrs` maps
+// each pointer to the current name of its file.  Pointers that
+// aren't found, e.g. because they were written in unmerged
+// revisions, are left out.
+//
+// The walk stops once every file has either been found, or been
+// followed back through its renames to the revision that created
+// it, since no earlier revision can have written it.  So it only
+// reaches the start of the history for files whose creation isn't
+// in the merged history at all.
+func (fbo *folderBranchOps) findDropEntryWrites(
+	ctx context.Context, ptrs map[BlockPointer]string) (
+	map[BlockPointer]dropEntryWrite, error) {
+	writes := make(map[BlockPointer]dropEntryWrite, len(ptrs))
+	// names and pending map the files that haven't been found yet
+	// to the names they had as of the revision being looked at, and
+	// back.
+	names := make(map[BlockPointer]string, len(ptrs))
+	pending := make(map[string]BlockPointer, len(ptrs))
+	for ptr, name := range ptrs {
+		names[ptr] = name
+		pending[name] = ptr
+	}
+	lState := makeFBOLockState()
+	end := fbo.getLatestMergedRevision(lState)
+	head, _ := fbo.getHead(lState)
+	if head != (ImmutableRootMetadata{}) &&
+		head.MergedStatus() == kbfsmd.Merged {
+		end = head.Revision()
+	}
+	for end >= kbfsmd.RevisionInitial && len(pending) > 0 {
+		start := end - maxMDsAtATime + 1
+		if start < kbfsmd.RevisionInitial {
+			start = kbfsmd.RevisionInitial
+		}
+		rmds, err := getMergedMDUpdatesWithEnd(
+			ctx, fbo.config, fbo.id(), start, end, nil)
+		if err != nil {
+			return nil, err
+		}
+		for i := len(rmds) - 1; i >= 0; i-- {
+			rmd := rmds[i]
+			ops := rmd.data.Changes.Ops
+			for j := len(ops) - 1; j >= 0; j-- {
+				switch realOp := ops[j].(type) {
+				case *syncOp:
+					name, ok := names[realOp.File.Ref]
+					if !ok {
+						continue
+					}
+					writes[realOp.File.Ref] = dropEntryWrite{
+						writer: rmd.LastModifyingWriter(),
+						time:   rmd.LocalTimestamp(),
+					}
+					delete(names, realOp.File.Ref)
+					delete(pending, name)
+				case *renameOp:
+					if ptr, ok := pending[realOp.NewName]; ok {
+						delete(pending, realOp.NewName)
+						pending[realOp.OldName] = ptr
+						names[ptr] = realOp.OldName
+					}
+				case *createOp:
+					if ptr, ok := pending[realOp.NewName]; ok {
+						fbo.log.CDebugf(ctx, "No merged write for %v "+
+							"since its creation in revision %d",
+							ptr, rmd.Revision())
+						delete(names, ptr)
+						delete(pending, realOp.NewName)
+					}
+				}
+			}
+		}
+		end = start - 1
+	}
+	return writes, nil
+}
+
+// lookupDropFolder returns the node of the drop folder `dir` under
+// `rootNode`.  If `create` is true, missing directories are created;
+// otherwise a nil node is returned for a missing directory.
+func lookupDropFolder(ctx context.Context, kbfsOps KBFSOps, rootNode Node,
+	dir string, create bool) (Node, error) {
+	n := rootNode
+	for _, name := range strings.Split(dir, "/") {
+		child, ei, err := kbfsOps.Lookup(ctx, n, name)
+		switch errors.Cause(err).(type) {
+		case nil:
+			if ei.Type != Dir {
+				return nil, errors.Errorf(
+					"%s is not a directory", stdpath.Join(dir, name))
+			}
+		case NoSuchNameError:
+			if !create {
+				return nil, nil
+			}
+			child, _, err = kbfsOps.CreateDir(ctx, n, name)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, err
+		}
+		n = child
+	}
+	return n, nil
+}
+
+// SubmitToDropFolder adds a file named `name` with contents `data` to
+// the drop folder `dir`, relative to the TLF root, and waits for the
+// change to be synced.  Only the folder's collectors and the current
+// user will be able to read it.  It returns the name the submission
+// is stored under.
+func SubmitToDropFolder(ctx context.Context, config Config, rootNode Node,
+	dir, name string, data []byte) (string, error) {
+	kbfsOps := config.KBFSOps()
+	settings, err := ReadTlfSettings(ctx, kbfsOps, rootNode)
+	if err != nil {
+		return "", err
+	}
+	d, ok := settings.GetDropFolder(dir)
+	if !ok {
+		return "", errors.WithStack(NotDropFolderError{dir})
+	}
+	now := config.Clock().Now()
+	if d.Deadline != nil && !now.Before(*d.Deadline) {
+		return "", errors.WithStack(DropFolderClosedError{d.Path, *d.Deadline})
+	}
+
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return "", err
+	}
+	readers := []keybase1.UID{session.UID}
+	for _, c := range d.Collectors {
+		_, id, err := config.KBPKI().Resolve(ctx, c)
+		if err != nil {
+			return "", err
+		}
+		uid, err := id.AsUser()
+		if err != nil {
+			return "", errors.Errorf("Collector %s is not a user", c)
+		}
+		if uid != session.UID {
+			readers = append(readers, uid)
+		}
+	}
+
+	buf, err := sealDropEntry(ctx, config, readers, dropEntryContents{
+		Name: name,
+		Data: data,
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(buf) > maxDropEntryBytes {
+		return "", errors.WithStack(DropEntryTooLargeError{
+			uint64(len(buf)), maxDropEntryBytes})
+	}
+
+	// A random name keeps the submission's real name private.
+	var nameData [16]byte
+	if err := kbfscrypto.RandRead(nameData[:]); err != nil {
+		return "", err
+	}
+	storedName := hex.EncodeToString(nameData[:]) + DropEntrySuffix
+
+	dirNode, err := lookupDropFolder(ctx, kbfsOps, rootNode, d.Path, true)
+	if err != nil {
+		return "", err
+	}
+	fileNode, _, err := kbfsOps.CreateFile(
+		ctx, dirNode, storedName, false, WithExcl)
+	if err != nil {
+		return "", err
+	}
+	err = kbfsOps.Write(ctx, fileNode, buf, 0)
+	if err != nil {
+		return "", err
+	}
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	if err != nil {
+		return "", err
+	}
+	return storedName, nil
+}
+
+// ReadDropFolder returns the submissions in the drop folder `dir`,
+// relative to the TLF root, that the current device can read, in
+// submission order.  Collectors see every submission, and other
+// users only see their own.  The submitter and time of each
+// submission are taken from the verified MD revision that last wrote
+// it, rather than from the submission itself, and submissions that
+// revision puts after the folder's deadline are left out, since the
+// deadline check when submitting relies on the submitter's clock.
+// Files bigger than drop folders accept are left out too.
+func ReadDropFolder(ctx context.Context, config Config, rootNode Node,
+	dir string) ([]DropEntry, error) {
+	kbfsOps := config.KBFSOps()
+	kbfsOpsStandard, ok := kbfsOps.(*KBFSOpsStandard)
+	if !ok {
+		return nil, errors.New("Unexpected KBFSOps type")
+	}
+	fbo := kbfsOpsStandard.getOpsNoAdd(ctx, rootNode.GetFolderBranch())
+	settings, err := ReadTlfSettings(ctx, kbfsOps, rootNode)
+	if err != nil {
+		return nil, err
+	}
+	d, ok := settings.GetDropFolder(dir)
+	if !ok {
+		return nil, errors.WithStack(NotDropFolderError{dir})
+	}
+	dirNode, err := lookupDropFolder(ctx, kbfsOps, rootNode, d.Path, false)
+	if err != nil || dirNode == nil {
+		return nil, err
+	}
+	children, err := kbfsOps.GetDirChildren(ctx, dirNode)
+	if err != nil {
+		return nil, err
+	}
+
+	type openedEntry struct {
+		name     string
+		ptr      BlockPointer
+		contents dropEntryContents
+	}
+	var opened []openedEntry
+	ptrs := make(map[BlockPointer]string)
+	for name, ei := range children {
+		if ei.Type != File || !strings.HasSuffix(name, DropEntrySuffix) {
+			continue
+		}
+		if ei.Size > maxDropEntryBytes {
+			fbo.log.CDebugf(ctx, "Skipping drop entry %s: %+v", name,
+				DropEntryTooLargeError{ei.Size, maxDropEntryBytes})
+			continue
+		}
+		fileNode, _, err := kbfsOps.Lookup(ctx, dirNode, name)
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, ei.Size)
+		n, err := kbfsOps.Read(ctx, fileNode, buf, 0)
+		if err != nil {
+			return nil, err
+		}
+		contents, err := openDropEntry(ctx, config, buf[:n])
+		if _, ok := errors.Cause(err).(libkb.DecryptionError); ok {
+			// Someone else's submission.
+			continue
+		} else if err != nil {
+			fbo.log.CDebugf(
+				ctx, "Skipping unreadable drop entry %s: %+v", name, err)
+			continue
+		}
+		ptr := fbo.nodeCache.PathFromNode(fileNode).tailPointer()
+		opened = append(opened, openedEntry{name, ptr, contents})
+		ptrs[ptr] = name
+	}
+
+	writes, err := fbo.findDropEntryWrites(ctx, ptrs)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]DropEntry, 0, len(opened))
+	for _, o := range opened {
+		w, ok := writes[o.ptr]
+		if !ok {
+			fbo.log.CDebugf(ctx, "Skipping drop entry %s with no merged "+
+				"write", o.name)
+			continue
+		}
+		if d.Deadline != nil && !w.time.Before(*d.Deadline) {
+			fbo.log.CDebugf(ctx, "Skipping drop entry %s written at %s, "+
+				"after the deadline", o.name, w.time)
+			continue
+		}
+		submitter, err := config.KBPKI().GetNormalizedUsername(
+			ctx, w.writer.AsUserOrTeam())
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, DropEntry{
+			Name:      o.contents.Name,
+			Submitter: submitter,
+			Time:      w.time,
+			Data:      o.contents.Data,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].Time.Equal(entries[j].Time) {
+			return entries[i].Time.Before(entries[j].Time)
+		}
+		if entries[i].Submitter != entries[j].Submitter {
+			return entries[i].Submitter < entries[j].Submitter
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
@@ -0,0 +1,255 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDropFolderSettings(t *testing.T) {
+	s, err := ParseTlfSettings([]byte(`{
+  "Version": 1,
+  "DropFolders": [{
+    "Path": "homework/week1",
+    "Collectors": ["alice"],
+    "Deadline": "2018-06-01T00:00:00Z"
+  }]
+}`))
+	require.NoError(t, err)
+	d, ok := s.GetDropFolder("homework/week1/")
+	require.True(t, ok)
+	require.Equal(t, []string{"alice"}, d.Collectors)
+	require.Equal(t,
+		time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC), d.Deadline.UTC())
+	_, ok = s.GetDropFolder("homework")
+	require.False(t, ok)
+
+	for _, bad := range []string{
+		`{"Version": 1, "DropFolders": [{"Collectors": ["alice"]}]}`,
+		`{"Version": 1, "DropFolders": [{"Path": "a"}]}`,
+		`{"Version": 1, "DropFolders": [{"Path": "/a", "Collectors": ["x"]}]}`,
+		`{"Version": 1, "DropFolders": [{"Path": "../a", "Collectors": ["x"]}]}`,
+		`{"Version": 1, "DropFolders": [{"Path": "a/", "Collectors": ["x"]}]}`,
+		`{"Version": 1, "DropFolders": [{"Path": "a", "Collectors": [""]}]}`,
+		`{"Version": 1, "DropFolders": [{"Path": "a", "Collectors": ["x"]}, ` +
+			`{"Path": "a", "Collectors": ["y"]}]}`,
+	} {
+		_, err := ParseTlfSettings([]byte(bad))
+		require.IsType(t, InvalidTlfSettingsError{}, errors.Cause(err), bad)
+	}
+}
+
+func TestDropFolder(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(
+		t, "alice", "bob", "charlie")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	configBob := ConfigAsUser(config, "bob")
+	defer CheckConfigAndShutdown(ctx, t, configBob)
+	configCharlie := ConfigAsUser(config, "charlie")
+	defer CheckConfigAndShutdown(ctx, t, configCharlie)
+
+	const name = "alice,bob,charlie"
+	rootNode := GetRootNodeOrBust(ctx, t, config, name, tlf.Private)
+	deadline := config.Clock().Now().Add(time.Hour)
+	err := WriteTlfSettings(ctx, config.KBFSOps(), rootNode, TlfSettings{
+		Version: TlfSettingsVersion,
+		DropFolders: []DropFolder{{
+			Path:       "reports/q1",
+			Collectors: []string{"alice"},
+			Deadline:   &deadline,
+		}},
+	})
+	require.NoError(t, err)
+
+	submit := func(c Config, file, data string) string {
+		root := GetRootNodeOrBust(ctx, t, c, name, tlf.Private)
+		err := c.KBFSOps().SyncFromServer(ctx, root.GetFolderBranch(), nil)
+		require.NoError(t, err)
+		stored, err := SubmitToDropFolder(
+			ctx, c, root, "reports/q1", file, []byte(data))
+		require.NoError(t, err)
+		require.True(t, strings.HasSuffix(stored, DropEntrySuffix))
+		require.NotContains(t, stored, file)
+		return stored
+	}
+	read := func(c Config) (names []string) {
+		root := GetRootNodeOrBust(ctx, t, c, name, tlf.Private)
+		err := c.KBFSOps().SyncFromServer(ctx, root.GetFolderBranch(), nil)
+		require.NoError(t, err)
+		entries, err := ReadDropFolder(ctx, c, root, "reports/q1")
+		require.NoError(t, err)
+		for _, e := range entries {
+			names = append(names,
+				e.Submitter.String()+":"+e.Name+"="+string(e.Data))
+		}
+		return names
+	}
+
+	start := config.Clock().Now()
+	bobStored := submit(configBob, "bob.txt", "from bob")
+	submit(configCharlie, "charlie.txt", "from charlie")
+
+	t.Log("Collectors see every submission, others only their own")
+	require.Equal(t, []string{
+		"bob:bob.txt=from bob", "charlie:charlie.txt=from charlie",
+	}, read(config))
+	require.Equal(t, []string{"bob:bob.txt=from bob"}, read(configBob))
+	require.Equal(t,
+		[]string{"charlie:charlie.txt=from charlie"}, read(configCharlie))
+	entries, err := ReadDropFolder(ctx, config, rootNode, "reports/q1")
+	require.NoError(t, err)
+	for _, e := range entries {
+		require.False(t, e.Time.Before(start.Truncate(time.Second)))
+	}
+
+	t.Log("The submitter is the last writer of a submission, not " +
+		"whoever it claims to be from")
+	root := GetRootNodeOrBust(ctx, t, configCharlie, name, tlf.Private)
+	kbfsOpsCharlie := configCharlie.KBFSOps()
+	err = kbfsOpsCharlie.SyncFromServer(ctx, root.GetFolderBranch(), nil)
+	require.NoError(t, err)
+	dirNode, err := lookupDropFolder(
+		ctx, kbfsOpsCharlie, root, "reports/q1", false)
+	require.NoError(t, err)
+	fileNode, _, err := kbfsOpsCharlie.Lookup(ctx, dirNode, bobStored)
+	require.NoError(t, err)
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+	forged, err := sealDropEntry(ctx, configCharlie,
+		[]keybase1.UID{session.UID}, dropEntryContents{
+			Name: "bob.txt",
+			Data: []byte("forged"),
+		})
+	require.NoError(t, err)
+	err = kbfsOpsCharlie.Truncate(ctx, fileNode, 0)
+	require.NoError(t, err)
+	err = kbfsOpsCharlie.Write(ctx, fileNode, forged, 0)
+	require.NoError(t, err)
+	err = kbfsOpsCharlie.SyncAll(ctx, root.GetFolderBranch())
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"charlie:charlie.txt=from charlie", "charlie:bob.txt=forged",
+	}, read(config))
+
+	// writeEntry puts `buf` in the drop folder as `c`, without the
+	// checks SubmitToDropFolder makes.
+	writeEntry := func(c Config, stored string, buf []byte, size uint64) {
+		root := GetRootNodeOrBust(ctx, t, c, name, tlf.Private)
+		kbfsOps := c.KBFSOps()
+		err := kbfsOps.SyncFromServer(ctx, root.GetFolderBranch(), nil)
+		require.NoError(t, err)
+		dirNode, err := lookupDropFolder(
+			ctx, kbfsOps, root, "reports/q1", false)
+		require.NoError(t, err)
+		fileNode, _, err := kbfsOps.CreateFile(
+			ctx, dirNode, stored, false, WithExcl)
+		require.NoError(t, err)
+		err = kbfsOps.Write(ctx, fileNode, buf, 0)
+		require.NoError(t, err)
+		if size > uint64(len(buf)) {
+			err = kbfsOps.Truncate(ctx, fileNode, size)
+			require.NoError(t, err)
+		}
+		err = kbfsOps.SyncAll(ctx, root.GetFolderBranch())
+		require.NoError(t, err)
+	}
+
+	t.Log("Entries bigger than the maximum are left out")
+	big, err := sealDropEntry(ctx, configBob,
+		[]keybase1.UID{session.UID}, dropEntryContents{Name: "big.txt"})
+	require.NoError(t, err)
+	writeEntry(configBob, "big"+DropEntrySuffix, big, maxDropEntryBytes+1)
+	require.Equal(t, []string{
+		"charlie:charlie.txt=from charlie", "charlie:bob.txt=forged",
+	}, read(config))
+
+	t.Log("Entries written after the deadline are left out, even if " +
+		"the submitter's clock said otherwise")
+	lateClock := newTestClockNow()
+	lateClock.Set(deadline.Add(time.Minute))
+	configBob.SetClock(lateClock)
+	late, err := sealDropEntry(ctx, configBob,
+		[]keybase1.UID{session.UID}, dropEntryContents{
+			Name: "late.txt",
+			Data: []byte("late"),
+		})
+	require.NoError(t, err)
+	writeEntry(configBob, "late"+DropEntrySuffix, late, 0)
+	require.Equal(t, []string{
+		"charlie:charlie.txt=from charlie", "charlie:bob.txt=forged",
+	}, read(config))
+
+	t.Log("Submissions are refused after the deadline")
+	clock := newTestClockNow()
+	clock.Set(deadline)
+	config.SetClock(clock)
+	_, err = SubmitToDropFolder(
+		ctx, config, rootNode, "reports/q1", "late.txt", nil)
+	require.IsType(t, DropFolderClosedError{}, errors.Cause(err))
+
+	_, err = SubmitToDropFolder(ctx, config, rootNode, "reports", "x", nil)
+	require.IsType(t, NotDropFolderError{}, errors.Cause(err))
+	_, err = ReadDropFolder(ctx, config, rootNode, "reports")
+	require.IsType(t, NotDropFolderError{}, errors.Cause(err))
+}
+
+func TestDropFolderWritesStopAtCreation(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	kbfsOps := config.KBFSOps()
+	fb := rootNode.GetFolderBranch()
+	fbo := kbfsOps.(*KBFSOpsStandard).getOpsNoAdd(ctx, fb)
+
+	t.Log("Make enough history before the entry to span several fetches")
+	for i := 0; i < 2*maxMDsAtATime; i++ {
+		_, _, err := kbfsOps.CreateDir(ctx, rootNode, fmt.Sprintf("d%d", i))
+		require.NoError(t, err)
+		err = kbfsOps.SyncAll(ctx, fb)
+		require.NoError(t, err)
+	}
+	_, _, err := kbfsOps.CreateFile(
+		ctx, rootNode, "a"+DropEntrySuffix, false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	created := fbo.getLatestMergedRevision(makeFBOLockState())
+	_, _, err = kbfsOps.CreateDir(ctx, rootNode, "e")
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	t.Log("Rename the entry, so the walk has to follow it back")
+	err = kbfsOps.Rename(ctx, rootNode, "a"+DropEntrySuffix, rootNode,
+		"b"+DropEntrySuffix)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	t.Log("A pointer with no merged write is given up on at the " +
+		"revision that created its file")
+	mdcache := NewMDCacheStandard(defaultMDCacheCapacity)
+	config.SetMDCache(mdcache)
+	ptr := BlockPointer{ID: kbfsblock.FakeID(1)}
+	writes, err := fbo.findDropEntryWrites(
+		ctx, map[BlockPointer]string{ptr: "b" + DropEntrySuffix})
+	require.NoError(t, err)
+	require.Len(t, writes, 0)
+	id := fb.Tlf
+	_, err = mdcache.Get(id, created, kbfsmd.NullBranchID)
+	require.NoError(t, err)
+	_, err = mdcache.Get(id, kbfsmd.RevisionInitial, kbfsmd.NullBranchID)
+	require.Error(t, err)
+}
@@ -0,0 +1,203 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"crypto/sha256"
+	stdpath "path"
+	"sort"
+
+	"github.com/keybase/kbfs/kbfsblock"
+	"golang.org/x/net/context"
+)
+
+// duplicateReadSize is how much of a file is read at a time while
+// hashing its contents.
+const duplicateReadSize = 512 * 1024
+
+// DuplicateFileGroup is a set of files with identical contents.
+type DuplicateFileGroup struct {
+	Size  uint64
+	Paths []string
+	// Copies is how many distinct sets of blocks hold the contents.
+	// Files sharing a set of blocks are already reference copies of
+	// each other, and cost nothing extra to store.
+	Copies int
+	// ReclaimableBytes is how much less would be stored if every
+	// file in the group shared a single set of blocks.
+	ReclaimableBytes uint64
+}
+
+type duplicateCandidate struct {
+	path   string
+	parent Node
+	name   string
+}
+
+type duplicateFinder struct {
+	kbfsOps KBFSOps
+	bySize  map[uint64][]duplicateCandidate
+}
+
+func (d *duplicateFinder) walk(
+	ctx context.Context, dir Node, dirPath string) error {
+	children, err := d.kbfsOps.GetDirChildren(ctx, dir)
+	if err != nil {
+		return err
+	}
+	for name, ei := range children {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		p := stdpath.Join(dirPath, name)
+		switch ei.Type {
+		case Dir:
+			child, _, err := d.kbfsOps.Lookup(ctx, dir, name)
+			if err != nil {
+				return err
+			}
+			err = d.walk(ctx, child, p)
+			if err != nil {
+				return err
+			}
+		case File, Exec:
+			// Empty files don't take up any blocks.
+			if ei.Size == 0 {
+				continue
+			}
+			d.bySize[ei.Size] = append(
+				d.bySize[ei.Size], duplicateCandidate{p, dir, name})
+		}
+	}
+	return nil
+}
+
+func (d *duplicateFinder) hash(
+	ctx context.Context, n Node, size uint64) ([sha256.Size]byte, error) {
+	h := sha256.New()
+	buf := make([]byte, duplicateReadSize)
+	// Don't let the scan push everything else out of the recent
+	// files list.
+	ctx = CtxSkipRecentFiles(ctx)
+	for off := uint64(0); off < size; {
+		nRead, err := d.kbfsOps.Read(ctx, n, buf, int64(off))
+		if err != nil {
+			return [sha256.Size]byte{}, err
+		}
+		if nRead == 0 {
+			break
+		}
+		h.Write(buf[:nRead])
+		off += uint64(nRead)
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// groupBySize splits files of the same size into groups with
+// identical contents.  Files whose top blocks have the same ID share
+// every block, so only one file per top block ID is read and hashed.
+func (d *duplicateFinder) groupBySize(
+	ctx context.Context, size uint64, candidates []duplicateCandidate) (
+	[]DuplicateFileGroup, error) {
+	byID := make(map[kbfsblock.ID][]string)
+	var ids []kbfsblock.ID
+	nodes := make(map[kbfsblock.ID]Node)
+	for _, c := range candidates {
+		n, _, err := d.kbfsOps.Lookup(ctx, c.parent, c.name)
+		if err != nil {
+			return nil, err
+		}
+		md, err := d.kbfsOps.GetNodeMetadata(ctx, n)
+		if err != nil {
+			return nil, err
+		}
+		id := md.BlockInfo.ID
+		if _, ok := byID[id]; !ok {
+			ids = append(ids, id)
+			nodes[id] = n
+		}
+		byID[id] = append(byID[id], c.path)
+	}
+
+	type contents struct {
+		paths  []string
+		copies int
+	}
+	byHash := make(map[[sha256.Size]byte]*contents)
+	var order [][sha256.Size]byte
+	for _, id := range ids {
+		sum, err := d.hash(ctx, nodes[id], size)
+		if err != nil {
+			return nil, err
+		}
+		c, ok := byHash[sum]
+		if !ok {
+			c = &contents{}
+			byHash[sum] = c
+			order = append(order, sum)
+		}
+		c.paths = append(c.paths, byID[id]...)
+		c.copies++
+	}
+
+	var groups []DuplicateFileGroup
+	for _, sum := range order {
+		c := byHash[sum]
+		if len(c.paths) < 2 {
+			continue
+		}
+		sort.Strings(c.paths)
+		groups = append(groups, DuplicateFileGroup{
+			Size:             size,
+			Paths:            c.paths,
+			Copies:           c.copies,
+			ReclaimableBytes: size * uint64(c.copies-1),
+		})
+	}
+	return groups, nil
+}
+
+// FindDuplicateFiles returns the groups of files under `dir` that
+// have identical contents, with paths relative to `dir`, largest
+// potential savings first.  Only files of the same size are read.
+//
+// KBFS can't yet make one existing file refer to another's blocks,
+// so duplicates are only reported; a group with more than one copy
+// has to be deduplicated by hand.
+func FindDuplicateFiles(ctx context.Context, config Config, dir Node) (
+	[]DuplicateFileGroup, error) {
+	d := &duplicateFinder{
+		kbfsOps: config.KBFSOps(),
+		bySize:  make(map[uint64][]duplicateCandidate),
+	}
+	err := d.walk(ctx, dir, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []DuplicateFileGroup
+	for size, candidates := range d.bySize {
+		if len(candidates) < 2 {
+			continue
+		}
+		g, err := d.groupBySize(ctx, size, candidates)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, g...)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].ReclaimableBytes != groups[j].ReclaimableBytes {
+			return groups[i].ReclaimableBytes > groups[j].ReclaimableBytes
+		}
+		return groups[i].Paths[0] < groups[j].Paths[0]
+	})
+	return groups, nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindDuplicateFiles(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	kbfsOps := config.KBFSOps()
+	createFile := func(dir Node, name string, data []byte) {
+		n, _, err := kbfsOps.CreateFile(ctx, dir, name, false, NoExcl)
+		require.NoError(t, err)
+		if len(data) > 0 {
+			err = kbfsOps.Write(ctx, n, data, 0)
+			require.NoError(t, err)
+		}
+	}
+
+	dir, _, err := kbfsOps.CreateDir(ctx, rootNode, "dir")
+	require.NoError(t, err)
+	createFile(rootNode, "a", []byte("hello"))
+	createFile(dir, "b", []byte("hello"))
+	createFile(dir, "c", []byte("hello"))
+	createFile(rootNode, "same-size", []byte("world"))
+	createFile(rootNode, "longer", []byte("hello world"))
+	createFile(rootNode, "empty1", nil)
+	createFile(rootNode, "empty2", nil)
+	createFile(dir, "x", []byte("abcdefgh"))
+	createFile(rootNode, "x", []byte("abcdefgh"))
+	require.NoError(t, kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch()))
+
+	groups, err := FindDuplicateFiles(ctx, config, rootNode)
+	require.NoError(t, err)
+	require.Equal(t, []DuplicateFileGroup{
+		{
+			Size:             5,
+			Paths:            []string{"a", "dir/b", "dir/c"},
+			Copies:           3,
+			ReclaimableBytes: 10,
+		},
+		{
+			Size:             8,
+			Paths:            []string{"dir/x", "x"},
+			Copies:           2,
+			ReclaimableBytes: 8,
+		},
+	}, groups)
+
+	t.Log("Paths are relative to the given directory")
+	groups, err = FindDuplicateFiles(ctx, config, dir)
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	require.Equal(t, []string{"b", "c"}, groups[0].Paths)
+
+	t.Log("Scanning doesn't count as accessing the files")
+	files, err := kbfsOps.GetRecentFiles(
+		ctx, rootNode.GetFolderBranch(), "", 0)
+	require.NoError(t, err)
+	for _, f := range files {
+		require.True(t, f.LastRead.IsZero(), f.Path)
+	}
+}
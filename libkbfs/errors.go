@@ -6,6 +6,7 @@ package libkbfs
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	kbname "github.com/keybase/client/go/kbun"
@@ -777,6 +778,21 @@ func (e NameTooLongError) Error() string {
 		"allowed number of bytes (%d)", e.name, e.maxAllowedBytes)
 }
 
+// FileTooBigError indicates that the user tried to write a file that
+// would be bigger than KBFS's supported size.
+type FileTooBigError struct {
+	p               path
+	size            uint64
+	maxAllowedBytes uint64
+}
+
+// Error implements the error interface for FileTooBigError.
+func (e FileTooBigError) Error() string {
+	return fmt.Sprintf("File %s would have increased to %d bytes, which is "+
+		"over the supported limit of %d bytes", e.p, e.size,
+		e.maxAllowedBytes)
+}
+
 // TlfNameNotCanonical indicates that a name isn't a canonical, and
 // that another (not necessarily canonical) name should be tried.
 type TlfNameNotCanonical struct {
@@ -1044,12 +1060,14 @@ func (e cachePutCacheFullError) Error() string {
 // FileTooBigForCRError indicates that a file is too big to fit in
 // memory, and CR can't handle it.
 type FileTooBigForCRError struct {
-	p path
+	p     path
+	limit int64
 }
 
 // Error implements the error interface for FileTooBigForCRError.
 func (e FileTooBigForCRError) Error() string {
-	return fmt.Sprintf("Cannot complete CR because the file %s is too big", e.p)
+	return fmt.Sprintf("Cannot complete CR because the file %s has more "+
+		"data than the %d-byte limit", e.p, e.limit)
 }
 
 // NoMergedMDError indicates that no MDs for this folder have been
@@ -1175,3 +1193,275 @@ func (e RevGarbageCollectedError) Error() string {
 	return fmt.Sprintf("Requested revision %d has already been garbage "+
 		"collected (last GC'd rev=%d)", e.rev, e.lastGCRev)
 }
+
+// ContentScanRejectedError indicates that the configured
+// ContentScanner rejected a file, and there's no storage root to
+// quarantine it in, so it can't be synced.
+type ContentScanRejectedError struct {
+	path   string
+	reason string
+}
+
+// Error implements the Error interface for ContentScanRejectedError.
+func (e ContentScanRejectedError) Error() string {
+	return fmt.Sprintf("Content scan rejected %s: %s", e.path, e.reason)
+}
+
+// IdentifyPolicyDeniedError indicates that the configured
+// IdentifyPolicy denied access to a folder.
+type IdentifyPolicyDeniedError struct {
+	tlfPath string
+	reason  string
+}
+
+// Error implements the Error interface for IdentifyPolicyDeniedError.
+func (e IdentifyPolicyDeniedError) Error() string {
+	return fmt.Sprintf("Identify policy denied access to %s: %s",
+		e.tlfPath, e.reason)
+}
+
+// FolderFrozenError indicates that the user froze writes to a folder
+// because some of its members' proofs are broken.
+type FolderFrozenError struct {
+	tlfID tlf.ID
+	users []string
+}
+
+// Error implements the Error interface for FolderFrozenError.
+func (e FolderFrozenError) Error() string {
+	return fmt.Sprintf("Writes to folder %s are frozen because of the "+
+		"broken proofs of %s", e.tlfID, strings.Join(e.users, ", "))
+}
+
+// WritesFrozenError indicates that writes to a folder are frozen by
+// FreezeWrites, so that it can be copied in a consistent state.  The
+// write should be retried after the folder is thawed.
+type WritesFrozenError struct {
+	tlfID tlf.ID
+	// until is when the freeze lapses, or zero if it lasts until
+	// the folder is thawed.
+	until time.Time
+}
+
+// Error implements the Error interface for WritesFrozenError.
+func (e WritesFrozenError) Error() string {
+	if e.until.IsZero() {
+		return fmt.Sprintf("Writes to folder %s are frozen", e.tlfID)
+	}
+	return fmt.Sprintf("Writes to folder %s are frozen until %s",
+		e.tlfID, e.until.Format(time.RFC3339))
+}
+
+// LegalHoldViolationError indicates that a merged MD revision
+// reclaimed data that's still under the folder's legal hold.
+type LegalHoldViolationError struct {
+	tlfID tlf.ID
+	rev   kbfsmd.Revision
+	gcRev kbfsmd.Revision
+	// heldUntil is zero if the data is held forever.
+	heldUntil time.Time
+}
+
+// Error implements the Error interface for LegalHoldViolationError.
+func (e LegalHoldViolationError) Error() string {
+	until := "forever"
+	if !e.heldUntil.IsZero() {
+		until = "until " + e.heldUntil.Format(time.RFC3339)
+	}
+	return fmt.Sprintf("Revision %d of folder %s reclaims data up to "+
+		"revision %d, which is held %s", e.rev, e.tlfID, e.gcRev, until)
+}
+
+// DLPViolationError indicates that a file couldn't be synced to a
+// team folder because it violates the team's DLP policy.
+type DLPViolationError struct {
+	path   string
+	rule   string
+	reason string
+}
+
+// Error implements the Error interface for DLPViolationError.
+func (e DLPViolationError) Error() string {
+	return fmt.Sprintf("%s violates DLP rule %q: %s",
+		e.path, e.rule, e.reason)
+}
+
+// TeamAdminOnlyError indicates that a user who isn't an admin of a
+// team tried to change a file of the team's folder that only admins
+// can change, such as the folder's settings file.
+type TeamAdminOnlyError struct {
+	path string
+}
+
+// Error implements the Error interface for TeamAdminOnlyError.
+func (e TeamAdminOnlyError) Error() string {
+	return fmt.Sprintf("Only team admins can change %s", e.path)
+}
+
+// ServerSlowError indicates that an operation took longer than its
+// configured timeout while KBFS was connected to the servers, so it
+// might succeed if tried again.
+type ServerSlowError struct {
+	Class   OpClass
+	Timeout time.Duration
+}
+
+// Error implements the Error interface for ServerSlowError.
+func (e ServerSlowError) Error() string {
+	return fmt.Sprintf("The Keybase servers took longer than %s to "+
+		"respond to a %s; try again later", e.Timeout, e.Class)
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// ServerSlowError.
+func (e ServerSlowError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Name: "SERVER_SLOW",
+		Code: int(keybase1.StatusCode_SCTimeout),
+		Desc: e.Error(),
+	}
+}
+
+// OfflineError indicates that an operation took longer than its
+// configured timeout because KBFS isn't connected to the servers.
+type OfflineError struct {
+	Class OpClass
+}
+
+// Error implements the Error interface for OfflineError.
+func (e OfflineError) Error() string {
+	return fmt.Sprintf("Couldn't finish a %s because KBFS is offline", e.Class)
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// OfflineError.
+func (e OfflineError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Name: "OFFLINE",
+		Code: int(keybase1.StatusCode_SCAPINetworkError),
+		Desc: e.Error(),
+	}
+}
+
+// RevisionWaitTimeoutError indicates that this device didn't catch
+// up to the revision of a write fence in time.
+type RevisionWaitTimeoutError struct {
+	Fence   WriteFence
+	Timeout time.Duration
+}
+
+// Error implements the Error interface for RevisionWaitTimeoutError.
+func (e RevisionWaitTimeoutError) Error() string {
+	return fmt.Sprintf("Didn't reach revision %d of %s within %s",
+		e.Fence.Revision, e.Fence.Tlf, e.Timeout)
+}
+
+// ToStatus implements the keybase1.ToStatusAble interface for
+// RevisionWaitTimeoutError.
+func (e RevisionWaitTimeoutError) ToStatus() keybase1.Status {
+	return keybase1.Status{
+		Name: "REVISION_WAIT_TIMEOUT",
+		Code: int(keybase1.StatusCode_SCTimeout),
+		Desc: e.Error(),
+	}
+}
+
+// UnmergedWriteFenceError indicates that a device tried to wait for
+// a write fence that was taken on an unmerged branch.  Its revision
+// only means something on that branch, so only the device that took
+// it can wait for it, with WaitForWriteFence.
+type UnmergedWriteFenceError struct {
+	Fence WriteFence
+}
+
+// Error implements the Error interface for UnmergedWriteFenceError.
+func (e UnmergedWriteFenceError) Error() string {
+	return fmt.Sprintf("Write fence %s was taken on unmerged branch %s; "+
+		"take a new fence once its writes have been merged", e.Fence,
+		e.Fence.BranchID)
+}
+
+// InvalidTlfSettingsError indicates that a TLF settings document
+// couldn't be parsed, or has an invalid setting.
+type InvalidTlfSettingsError struct {
+	Err error
+}
+
+// Error implements the Error interface for InvalidTlfSettingsError.
+func (e InvalidTlfSettingsError) Error() string {
+	return fmt.Sprintf("Invalid TLF settings: %v", e.Err)
+}
+
+// NotDropFolderError indicates that a drop folder operation was
+// attempted on a directory that the TLF settings don't make a drop
+// folder.
+type NotDropFolderError struct {
+	Path string
+}
+
+// Error implements the Error interface for NotDropFolderError.
+func (e NotDropFolderError) Error() string {
+	return fmt.Sprintf("%s is not a drop folder", e.Path)
+}
+
+// DropFolderClosedError indicates that a submission was made to a
+// drop folder after its deadline.
+type DropFolderClosedError struct {
+	Path     string
+	Deadline time.Time
+}
+
+// Error implements the Error interface for DropFolderClosedError.
+func (e DropFolderClosedError) Error() string {
+	return fmt.Sprintf("Drop folder %s stopped accepting submissions at %s",
+		e.Path, e.Deadline.Format(time.RFC3339))
+}
+
+// DropEntryTooLargeError indicates that a drop folder submission is
+// bigger than drop folders accept.
+type DropEntryTooLargeError struct {
+	Size uint64
+	Max  uint64
+}
+
+// Error implements the Error interface for DropEntryTooLargeError.
+func (e DropEntryTooLargeError) Error() string {
+	return fmt.Sprintf("Drop folder submission of %d bytes is bigger "+
+		"than the maximum of %d bytes", e.Size, e.Max)
+}
+
+// MountBusyError indicates that the KBFS mount wasn't unmounted
+// because files are open through it.
+type MountBusyError struct {
+	MountPoint string
+	OpenFiles  []string
+}
+
+// Error implements the Error interface for MountBusyError.
+func (e MountBusyError) Error() string {
+	return fmt.Sprintf("%s is busy; %d files are open: %s",
+		e.MountPoint, len(e.OpenFiles), strings.Join(e.OpenFiles, ", "))
+}
+
+// NoMountManagerError indicates that a mount operation was requested
+// from a process that doesn't manage a mount.
+type NoMountManagerError struct{}
+
+// Error implements the Error interface for NoMountManagerError.
+func (e NoMountManagerError) Error() string {
+	return "This process doesn't manage a KBFS mount"
+}
+
+// DataRegionUnavailableError indicates that a block of a TLF that
+// must stay in a data region wasn't put, because no block server is
+// configured for that region.
+type DataRegionUnavailableError struct {
+	TlfID  tlf.ID
+	Region string
+}
+
+// Error implements the Error interface for DataRegionUnavailableError.
+func (e DataRegionUnavailableError) Error() string {
+	return fmt.Sprintf("Folder %s must keep its data in region %q, but "+
+		"no block server is configured for that region", e.TlfID, e.Region)
+}
@@ -0,0 +1,507 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	stdpath "path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/client/go/protocol/chat1"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfsedits"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+const (
+	// eventRulesFileName is the name of the file, under the storage
+	// root, where the event rules of this device are persisted.
+	eventRulesFileName = "event_rules.json"
+
+	eventRuleActionTimeout = time.Minute
+)
+
+// CtxERETagKey is the type used for unique context tags within
+// EventRuleEngine.
+type CtxERETagKey int
+
+const (
+	// CtxEREIDKey is the type of the tag for unique operation IDs
+	// within EventRuleEngine.
+	CtxEREIDKey CtxERETagKey = iota
+)
+
+// CtxEREOpID is the display name for the unique operation
+// EventRuleEngine ID tag.
+const CtxEREOpID = "EREID"
+
+// EventRuleTrigger is the kind of event that a rule reacts to.
+type EventRuleTrigger string
+
+const (
+	// EventFileCreated fires when any writer creates a file, in a
+	// folder with an edit history (so not in public folders).  It
+	// doesn't fire for a writer's very first change to a folder; see
+	// Webhook.
+	EventFileCreated EventRuleTrigger = "file_created"
+	// EventConflict fires when conflict resolution on this device
+	// renames an entry to a conflicted copy.
+	EventConflict EventRuleTrigger = "conflict"
+	// EventQuotaThreshold fires when the quota usage of the user, or
+	// of one of their teams, rises past the rule's threshold.  The
+	// first usage seen after startup only sets the baseline.
+	EventQuotaThreshold EventRuleTrigger = "quota_threshold"
+	// EventRepoPushed fires when this device pushes to a KBFS git
+	// repo.
+	EventRepoPushed EventRuleTrigger = "repo_pushed"
+	// EventProofsBroken fires when an identify finds that the proofs
+	// of some members of a folder have broken since they were
+	// tracked.  It only fires for identifies that report breaks as
+	// warnings, like those the Keybase app asks for.
+	EventProofsBroken EventRuleTrigger = "proofs_broken"
+)
+
+// defaultChatMessages are the messages chat actions send when they
+// don't set their own.
+var defaultChatMessages = map[EventRuleTrigger]string{
+	EventFileCreated: "*{{.Writer}}* added `{{.Path}}`",
+	EventConflict:    "Conflicting changes were saved as `{{.Path}}`",
+	EventQuotaThreshold: "{{if .Team}}Team{{else}}Your{{end}} storage " +
+		"is at {{.Usage}} of {{.Limit}} bytes",
+	EventRepoPushed: "*{{.Writer}}* pushed {{join .Refs \", \"}} " +
+		"to `{{.Path}}`",
+	EventProofsBroken: "The proofs of {{join .Members \", \"}} " +
+		"broke in `{{.Folder}}`",
+}
+
+// EventRuleChat is an action that sends a chat message.
+type EventRuleChat struct {
+	// Folder is the conversation's folder, like "team/acme" or
+	// "private/alice,bob".  If it's empty, the message goes to the
+	// folder the event happened in.
+	Folder string `json:",omitempty"`
+	// Channel is the name of the channel within a team folder's
+	// conversation; other folders have no channels.
+	Channel string `json:",omitempty"`
+	// Message is a text/template for the message, executed on the
+	// RuleEvent.  If it's empty, a standard message for the type of
+	// event is sent.
+	Message string `json:",omitempty"`
+}
+
+// EventRuleAction is something to do when a rule fires.  Exactly one
+// of its fields must be set.
+type EventRuleAction struct {
+	// Command is a program and its arguments, each of which is a
+	// text/template executed on the RuleEvent.  The command also gets
+	// the event in KBFS_EVENT_* environment variables.
+	Command []string `json:",omitempty"`
+	// Chat sends a chat message through the service.
+	Chat *EventRuleChat `json:",omitempty"`
+	// Log is a text/template for a line to write to the KBFS log,
+	// executed on the RuleEvent.
+	Log string `json:",omitempty"`
+}
+
+// EventRule maps events to actions.
+type EventRule struct {
+	Name string
+	On   EventRuleTrigger
+	// Pattern restricts file_created, conflict and repo_pushed rules
+	// to paths matching it, in path.Match syntax.  A pattern without a slash
+	// is matched against the base name, and any other pattern against
+	// the full path, starting with /keybase.  An empty pattern
+	// matches every path.
+	Pattern string `json:",omitempty"`
+	// Threshold is the fraction of the quota, between 0 and 1, that
+	// quota_threshold rules fire at.
+	Threshold float64 `json:",omitempty"`
+	Actions   []EventRuleAction
+}
+
+// EventRules is a list of rules, all of which are evaluated for each
+// event.
+type EventRules []EventRule
+
+// Validate returns an error if any rule is malformed.
+func (rules EventRules) Validate() error {
+	for _, r := range rules {
+		switch r.On {
+		case EventFileCreated, EventConflict, EventRepoPushed:
+			if _, err := stdpath.Match(r.Pattern, ""); err != nil {
+				return errors.Wrapf(err, "Bad pattern in rule %q", r.Name)
+			}
+		case EventProofsBroken:
+		case EventQuotaThreshold:
+			if r.Threshold <= 0 || r.Threshold > 1 {
+				return errors.Errorf(
+					"Rule %q needs a threshold in (0, 1]", r.Name)
+			}
+		default:
+			return errors.Errorf("Unknown event %q in rule %q", r.On, r.Name)
+		}
+		for _, a := range r.Actions {
+			n := 0
+			if len(a.Command) > 0 {
+				n++
+			}
+			if a.Chat != nil {
+				n++
+			}
+			if a.Log != "" {
+				n++
+			}
+			if n != 1 {
+				return errors.Errorf(
+					"Each action of rule %q needs exactly one of "+
+						"Command, Chat and Log", r.Name)
+			}
+			if a.Chat != nil && a.Chat.Folder == "" &&
+				r.On == EventQuotaThreshold {
+				return errors.Errorf(
+					"The chat action of rule %q needs a folder", r.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// RuleEvent describes an event that rules are evaluated on.  It's
+// what action templates are executed on.
+type RuleEvent struct {
+	Type EventRuleTrigger
+	// Folder is the full path of the folder the event happened in,
+	// for all but quota_threshold events.
+	Folder string
+	// Path is the full path of the entry the event is about, for
+	// file_created and conflict events, or of the repo, for
+	// repo_pushed events.
+	Path string
+	// Writer made the change, for file_created and repo_pushed
+	// events.
+	Writer string
+	// Refs are the names of the refs pushed, for repo_pushed events.
+	Refs []string
+	// Members are the users whose proofs broke, for proofs_broken
+	// events.
+	Members []string
+	// Team is the team whose quota crossed the threshold, or empty
+	// for the user's own quota.
+	Team string
+	// Usage and Limit are in bytes, for quota_threshold events.
+	Usage int64
+	Limit int64
+}
+
+func (ev RuleEvent) env() []string {
+	return []string{
+		"KBFS_EVENT_TYPE=" + string(ev.Type),
+		"KBFS_EVENT_FOLDER=" + ev.Folder,
+		"KBFS_EVENT_PATH=" + ev.Path,
+		"KBFS_EVENT_WRITER=" + ev.Writer,
+		"KBFS_EVENT_REFS=" + strings.Join(ev.Refs, " "),
+		"KBFS_EVENT_MEMBERS=" + strings.Join(ev.Members, " "),
+		"KBFS_EVENT_TEAM=" + ev.Team,
+		fmt.Sprintf("KBFS_EVENT_USAGE=%d", ev.Usage),
+		fmt.Sprintf("KBFS_EVENT_LIMIT=%d", ev.Limit),
+	}
+}
+
+func (r EventRule) matchesPath(p string) bool {
+	if r.Pattern == "" {
+		return true
+	}
+	if !strings.Contains(r.Pattern, "/") {
+		p = stdpath.Base(p)
+	}
+	ok, _ := stdpath.Match(r.Pattern, p)
+	return ok
+}
+
+// folderOfPath returns the full path of the folder containing the
+// full path `p`.
+func folderOfPath(p string) string {
+	parts := strings.SplitN(p, "/", 5)
+	if len(parts) < 4 {
+		return ""
+	}
+	return strings.Join(parts[:4], "/")
+}
+
+var ruleTemplateFuncs = template.FuncMap{"join": strings.Join}
+
+func expandRuleTemplate(text string, ev RuleEvent) (string, error) {
+	t, err := template.New("").Funcs(ruleTemplateFuncs).Parse(text)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, ev); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return buf.String(), nil
+}
+
+// EventRuleEngine evaluates the rules of a Config on the events KBFS
+// sees, and runs the actions of the rules that fire in the
+// background.  A nil engine ignores all events.
+type EventRuleEngine struct {
+	config Config
+	log    logger.Logger
+
+	lock sync.Mutex
+	// quotaUsage holds the last quota fraction seen for the user
+	// (under the empty team ID) and each of their teams.
+	quotaUsage map[keybase1.TeamID]float64
+	// actions counts the actions still running, for tests.
+	actions sync.WaitGroup
+}
+
+// NewEventRuleEngine makes an engine for the rules of `config`.
+func NewEventRuleEngine(config Config) *EventRuleEngine {
+	return &EventRuleEngine{
+		config:     config,
+		log:        config.MakeLogger("ERE"),
+		quotaUsage: make(map[keybase1.TeamID]float64),
+	}
+}
+
+// fire runs the actions of the rules for `ev` that `matches` accepts.
+func (e *EventRuleEngine) fire(ctx context.Context, ev RuleEvent,
+	matches func(EventRule) bool) {
+	for _, r := range e.config.EventRules() {
+		if r.On != ev.Type || !matches(r) {
+			continue
+		}
+		e.log.CDebugf(ctx, "Rule %q fired for %+v", r.Name, ev)
+		for _, a := range r.Actions {
+			e.actions.Add(1)
+			go func(r EventRule, a EventRuleAction) {
+				defer e.actions.Done()
+				ctx, cancel := context.WithTimeout(
+					CtxWithRandomIDReplayable(context.Background(),
+						CtxEREIDKey, CtxEREOpID, e.log),
+					eventRuleActionTimeout)
+				defer cancel()
+				if err := e.doAction(ctx, a, ev); err != nil {
+					e.log.CWarningf(ctx, "Action of rule %q failed: %+v",
+						r.Name, err)
+				}
+			}(r, a)
+		}
+	}
+}
+
+func (e *EventRuleEngine) doAction(
+	ctx context.Context, a EventRuleAction, ev RuleEvent) error {
+	switch {
+	case len(a.Command) > 0:
+		args := make([]string, len(a.Command))
+		for i, arg := range a.Command {
+			var err error
+			args[i], err = expandRuleTemplate(arg, ev)
+			if err != nil {
+				return err
+			}
+		}
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Env = append(os.Environ(), ev.env()...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return errors.Wrapf(err, "%s failed with output %q", args[0], out)
+		}
+		return nil
+	case a.Chat != nil:
+		text := a.Chat.Message
+		if text == "" {
+			text = defaultChatMessages[ev.Type]
+		}
+		msg, err := expandRuleTemplate(text, ev)
+		if err != nil {
+			return err
+		}
+		folder := a.Chat.Folder
+		if folder == "" {
+			folder = strings.TrimPrefix(ev.Folder, "/keybase/")
+		}
+		return sendChatToFolder(ctx, e.config, folder, a.Chat.Channel, msg)
+	case a.Log != "":
+		msg, err := expandRuleTemplate(a.Log, ev)
+		if err != nil {
+			return err
+		}
+		e.log.CInfof(ctx, "%s", msg)
+		return nil
+	default:
+		return errors.New("Empty action")
+	}
+}
+
+// sendChatToFolder sends `msg` to the chat conversation of `folder`,
+// like "team/acme" or "private/alice,bob", in `channel` if it's a
+// team folder.
+func sendChatToFolder(
+	ctx context.Context, config Config, folder, channel, msg string) error {
+	parts := strings.SplitN(folder, "/", 2)
+	if len(parts) != 2 {
+		return errors.Errorf("Bad chat folder %q", folder)
+	}
+	t, err := tlf.ParseTlfTypeFromPath(parts[0])
+	if err != nil {
+		return err
+	}
+	name := tlf.CanonicalName(parts[1])
+	convID, err := config.Chat().GetConversationID(
+		ctx, name, t, channel, chat1.TopicType_CHAT)
+	if err != nil {
+		return err
+	}
+	return config.Chat().SendTextMessage(ctx, name, t, convID, msg)
+}
+
+// editMessageReceived fires file_created rules for the files created
+// in a new edit notification message sent by `writer`.
+func (e *EventRuleEngine) editMessageReceived(
+	ctx context.Context, writer, message string) {
+	if e == nil || len(e.config.EventRules()) == 0 {
+		return
+	}
+	events, err := makeWebhookEvents(writer, message)
+	if err != nil {
+		e.log.CDebugf(ctx, "Couldn't parse edit message: %+v", err)
+		return
+	}
+	for _, we := range events {
+		for _, c := range we.Changes {
+			if c.Type != kbfsedits.NotificationCreate {
+				continue
+			}
+			e.fire(ctx, RuleEvent{
+				Type:   EventFileCreated,
+				Folder: folderOfPath(c.Path),
+				Path:   c.Path,
+				Writer: writer,
+			}, func(r EventRule) bool { return r.matchesPath(c.Path) })
+		}
+	}
+}
+
+// conflictedCopyMade fires conflict rules for a new conflicted copy at
+// `p`.
+func (e *EventRuleEngine) conflictedCopyMade(ctx context.Context, p string) {
+	if e == nil {
+		return
+	}
+	e.fire(ctx, RuleEvent{
+		Type:   EventConflict,
+		Folder: folderOfPath(p),
+		Path:   p,
+	}, func(r EventRule) bool { return r.matchesPath(p) })
+}
+
+// quotaUsageFetched fires the quota_threshold rules whose threshold
+// lies between the last usage seen for `tid` and `usage`.
+func (e *EventRuleEngine) quotaUsageFetched(
+	ctx context.Context, tid keybase1.TeamID, usage, limit int64) {
+	if e == nil || limit <= 0 {
+		return
+	}
+	frac := float64(usage) / float64(limit)
+	e.lock.Lock()
+	last, ok := e.quotaUsage[tid]
+	e.quotaUsage[tid] = frac
+	e.lock.Unlock()
+	if !ok || frac <= last {
+		return
+	}
+	e.fire(ctx, RuleEvent{
+		Type:  EventQuotaThreshold,
+		Team:  string(tid),
+		Usage: usage,
+		Limit: limit,
+	}, func(r EventRule) bool {
+		return r.Threshold > last && r.Threshold <= frac
+	})
+}
+
+// RepoPushed fires repo_pushed rules for a push by `writer` of `refs`
+// to the repo `repoName` in the folder `h`.
+func (e *EventRuleEngine) RepoPushed(ctx context.Context, h *TlfHandle,
+	repoName, writer string, refs []string) {
+	if e == nil {
+		return
+	}
+	folder := h.GetCanonicalPath()
+	p := stdpath.Join(folder, repoName)
+	e.fire(ctx, RuleEvent{
+		Type:   EventRepoPushed,
+		Folder: folder,
+		Path:   p,
+		Writer: writer,
+		Refs:   refs,
+	}, func(r EventRule) bool { return r.matchesPath(p) })
+}
+
+// proofsBroken fires proofs_broken rules for the members of the
+// folder `h` whose proofs newly broke.
+func (e *EventRuleEngine) proofsBroken(
+	ctx context.Context, h *TlfHandle, members []string) {
+	if e == nil {
+		return
+	}
+	e.fire(ctx, RuleEvent{
+		Type:    EventProofsBroken,
+		Folder:  h.GetCanonicalPath(),
+		Members: members,
+	}, func(EventRule) bool { return true })
+}
+
+// WaitForActions waits for the actions of the rules that have fired
+// so far to finish, or for `ctx` to be done.  Short-lived processes
+// should call it before exiting.
+func (e *EventRuleEngine) WaitForActions(ctx context.Context) error {
+	if e == nil {
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		e.actions.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// LoadEventRules reads the event rules persisted under
+// `storageRoot`.  It returns no rules if none have been set.
+func LoadEventRules(storageRoot string) (EventRules, error) {
+	var rules EventRules
+	err := ioutil.DeserializeFromJSONFile(
+		filepath.Join(storageRoot, eventRulesFileName), &rules)
+	if ioutil.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := rules.Validate(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
@@ -0,0 +1,304 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/keybase/client/go/protocol/chat1"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsedits"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestEventRulesValidate(t *testing.T) {
+	log := []EventRuleAction{{Log: "hi"}}
+	require.NoError(t, EventRules{
+		{Name: "a", On: EventFileCreated, Pattern: "*.pdf", Actions: log},
+		{Name: "b", On: EventConflict, Actions: log},
+		{Name: "c", On: EventQuotaThreshold, Threshold: 0.9, Actions: log},
+	}.Validate())
+
+	for _, r := range []EventRule{
+		{Name: "bad event", On: "deleted", Actions: log},
+		{Name: "bad pattern", On: EventFileCreated, Pattern: "[", Actions: log},
+		{Name: "no threshold", On: EventQuotaThreshold, Actions: log},
+		{Name: "big threshold", On: EventQuotaThreshold, Threshold: 2,
+			Actions: log},
+		{Name: "empty action", On: EventConflict,
+			Actions: []EventRuleAction{{}}},
+		{Name: "two actions", On: EventConflict,
+			Actions: []EventRuleAction{{Log: "hi", Command: []string{"true"}}}},
+	} {
+		require.Error(t, EventRules{r}.Validate(), r.Name)
+	}
+}
+
+func TestEventRuleMatchesPath(t *testing.T) {
+	p := "/keybase/team/acme/reports/q1.pdf"
+	for pattern, matches := range map[string]bool{
+		"":                            true,
+		"*.pdf":                       true,
+		"*.doc":                       false,
+		"q?.pdf":                      true,
+		"/keybase/team/acme/*/*.pdf":  true,
+		"/keybase/team/acme/*.pdf":    false,
+		"/keybase/team/*/reports/q1*": true,
+	} {
+		require.Equal(t, matches,
+			EventRule{Pattern: pattern}.matchesPath(p), pattern)
+	}
+}
+
+// eventRulesTestCommand returns a command action that appends a line
+// describing the event to `f`.
+func eventRulesTestCommand(f string) EventRuleAction {
+	return EventRuleAction{Command: []string{
+		"sh", "-c", `echo "$KBFS_EVENT_TYPE {{.Path}}{{.Writer}}` +
+			`{{.Usage}}/{{.Limit}}" >> "$0"`, f}}
+}
+
+func readEventRulesTestFile(t *testing.T, f string) []string {
+	buf, err := ioutil.ReadFile(f)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	require.NoError(t, err)
+	return strings.Split(strings.TrimSpace(string(buf)), "\n")
+}
+
+func TestEventRuleEngineFileCreated(t *testing.T) {
+	tempdir, err := ioutil.TempDir(os.TempDir(), "event_rules")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	out := filepath.Join(tempdir, "out")
+
+	config := MakeTestConfigOrBust(t, "alice")
+	defer CheckConfigAndShutdown(context.Background(), t, config)
+	err = config.SetEventRules(EventRules{{
+		Name:    "pdfs",
+		On:      EventFileCreated,
+		Pattern: "*.pdf",
+		Actions: []EventRuleAction{eventRulesTestCommand(out)},
+	}})
+	require.NoError(t, err)
+
+	tlfID := tlf.FakeID(1, tlf.Private)
+	var edits []kbfsedits.NotificationMessage
+	for _, name := range []string{"a.pdf", "b.txt"} {
+		for _, op := range []kbfsedits.NotificationOpType{
+			kbfsedits.NotificationCreate, kbfsedits.NotificationModify} {
+			edits = append(edits, kbfsedits.NotificationMessage{
+				Version:  kbfsedits.NotificationV2,
+				Filename: "/keybase/private/alice/" + name,
+				Type:     op,
+				Revision: 10,
+				UID:      keybase1.MakeTestUID(1),
+				FolderID: tlfID,
+			})
+		}
+	}
+	msg, err := json.Marshal(edits)
+	require.NoError(t, err)
+
+	e := config.EventRuleEngine()
+	e.editMessageReceived(context.Background(), "alice", string(msg))
+	e.actions.Wait()
+	require.Equal(t,
+		[]string{"file_created /keybase/private/alice/a.pdfalice0/0"},
+		readEventRulesTestFile(t, out))
+}
+
+func TestEventRuleEngineQuotaThreshold(t *testing.T) {
+	tempdir, err := ioutil.TempDir(os.TempDir(), "event_rules")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	out := filepath.Join(tempdir, "out")
+
+	config := MakeTestConfigOrBust(t, "alice")
+	defer CheckConfigAndShutdown(context.Background(), t, config)
+	err = config.SetEventRules(EventRules{
+		{
+			Name:      "half",
+			On:        EventQuotaThreshold,
+			Threshold: 0.5,
+			Actions:   []EventRuleAction{eventRulesTestCommand(out)},
+		},
+		{
+			Name:      "most",
+			On:        EventQuotaThreshold,
+			Threshold: 0.9,
+			Actions:   []EventRuleAction{eventRulesTestCommand(out)},
+		},
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	e := config.EventRuleEngine()
+	check := func(tid keybase1.TeamID, usage int64, expected ...string) {
+		require.NoError(t, os.RemoveAll(out))
+		e.quotaUsageFetched(ctx, tid, usage, 100)
+		e.actions.Wait()
+		require.Equal(t, expected, readEventRulesTestFile(t, out))
+	}
+
+	t.Log("The first usage is only a baseline")
+	check("", 60)
+	t.Log("Rising past a threshold fires its rule")
+	check("", 70)
+	check("", 95, "quota_threshold 95/100")
+	t.Log("Falling doesn't fire, but rising again does")
+	check("", 40)
+	check("", 40)
+	check("", 100, "quota_threshold 100/100", "quota_threshold 100/100")
+	t.Log("Teams are tracked separately")
+	tid := keybase1.MakeTestTeamID(1, false)
+	check(tid, 10)
+	check(tid, 50, "quota_threshold 50/100")
+}
+
+// eventRulesTestChat records the messages sent to regular chat
+// conversations, which chatLocal doesn't support.
+type eventRulesTestChat struct {
+	Chat
+	convID chat1.ConversationID
+	sent   []string
+}
+
+func (c *eventRulesTestChat) GetConversationID(
+	ctx context.Context, tlfName tlf.CanonicalName, tlfType tlf.Type,
+	channelName string, chatType chat1.TopicType) (
+	chat1.ConversationID, error) {
+	if chatType != chat1.TopicType_CHAT {
+		return c.Chat.GetConversationID(
+			ctx, tlfName, tlfType, channelName, chatType)
+	}
+	c.convID = chat1.ConversationID(
+		tlfType.String() + "/" + string(tlfName) + "#" + channelName)
+	return c.convID, nil
+}
+
+func (c *eventRulesTestChat) SendTextMessage(
+	ctx context.Context, tlfName tlf.CanonicalName, tlfType tlf.Type,
+	convID chat1.ConversationID, body string) error {
+	if !convID.Eq(c.convID) {
+		return c.Chat.SendTextMessage(ctx, tlfName, tlfType, convID, body)
+	}
+	c.sent = append(c.sent, string(convID)+": "+body)
+	return nil
+}
+
+func TestEventRuleEngineChat(t *testing.T) {
+	config := MakeTestConfigOrBust(t, "alice")
+	defer CheckConfigAndShutdown(context.Background(), t, config)
+	chat := &eventRulesTestChat{Chat: config.Chat()}
+	config.SetChat(chat)
+	err := config.SetEventRules(EventRules{{
+		Name: "conflicts",
+		On:   EventConflict,
+		Actions: []EventRuleAction{{Chat: &EventRuleChat{
+			Folder:  "private/alice",
+			Channel: "general",
+			Message: "Conflict at {{.Path}}",
+		}}},
+	}})
+	require.NoError(t, err)
+
+	e := config.EventRuleEngine()
+	p := "/keybase/private/alice/a.conflicted (alice's device copy)"
+	e.conflictedCopyMade(context.Background(), p)
+	e.actions.Wait()
+	require.Equal(t,
+		[]string{"private/alice#general: Conflict at " + p}, chat.sent)
+}
+
+func TestEventRuleEngineDefaultChat(t *testing.T) {
+	config := MakeTestConfigOrBust(t, "alice")
+	defer CheckConfigAndShutdown(context.Background(), t, config)
+	chat := &eventRulesTestChat{Chat: config.Chat()}
+	config.SetChat(chat)
+	err := config.SetEventRules(EventRules{
+		{
+			Name:    "pushes",
+			On:      EventRepoPushed,
+			Actions: []EventRuleAction{{Chat: &EventRuleChat{}}},
+		},
+		{
+			Name:    "releases",
+			On:      EventFileCreated,
+			Pattern: "/keybase/team/*/releases/*",
+			Actions: []EventRuleAction{{Chat: &EventRuleChat{
+				Folder: "private/alice",
+			}}},
+		},
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	h, err := ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "alice", tlf.Private)
+	require.NoError(t, err)
+	e := config.EventRuleEngine()
+	e.RepoPushed(ctx, h, "Repo", "alice", []string{"master", "v1"})
+	require.NoError(t, e.WaitForActions(ctx))
+	require.Equal(t, []string{"private/alice#: *alice* pushed master, v1 " +
+		"to `/keybase/private/alice/Repo`"}, chat.sent)
+
+	chat.sent = nil
+	msg, err := json.Marshal([]kbfsedits.NotificationMessage{{
+		Version:  kbfsedits.NotificationV2,
+		Filename: "/keybase/team/acme/releases/v1.tgz",
+		Type:     kbfsedits.NotificationCreate,
+		Revision: 10,
+		UID:      keybase1.MakeTestUID(1),
+		FolderID: tlf.FakeID(1, tlf.SingleTeam),
+	}})
+	require.NoError(t, err)
+	e.editMessageReceived(ctx, "bob", string(msg))
+	require.NoError(t, e.WaitForActions(ctx))
+	require.Equal(t, []string{"private/alice#: *bob* added " +
+		"`/keybase/team/acme/releases/v1.tgz`"}, chat.sent)
+}
+
+func TestFolderOfPath(t *testing.T) {
+	require.Equal(t, "/keybase/team/acme",
+		folderOfPath("/keybase/team/acme/a/b"))
+	require.Equal(t, "/keybase/team/acme", folderOfPath("/keybase/team/acme"))
+	require.Equal(t, "", folderOfPath("/keybase/team"))
+}
+
+func TestLoadEventRules(t *testing.T) {
+	tempdir, err := ioutil.TempDir(os.TempDir(), "event_rules")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+
+	rules, err := LoadEventRules(tempdir)
+	require.NoError(t, err)
+	require.Nil(t, rules)
+
+	f := filepath.Join(tempdir, eventRulesFileName)
+	err = ioutil.WriteFile(f, []byte(`[{"Name": "a", "On": "conflict", `+
+		`"Actions": [{"Log": "{{.Path}}"}]}]`), 0600)
+	require.NoError(t, err)
+	rules, err = LoadEventRules(tempdir)
+	require.NoError(t, err)
+	require.Equal(t, EventRules{{
+		Name:    "a",
+		On:      EventConflict,
+		Actions: []EventRuleAction{{Log: "{{.Path}}"}},
+	}}, rules)
+
+	err = ioutil.WriteFile(f, []byte(`[{"Name": "a", "On": "x"}]`), 0600)
+	require.NoError(t, err)
+	_, err = LoadEventRules(tempdir)
+	require.Error(t, err)
+}
@@ -0,0 +1,432 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	stdpath "path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// ExpiryPolicyFileName is the name of a file that makes KBFS delete
+// old files from the directory containing it, and everything below
+// it.  The file holds a maximum age, like "30d" or "12h", and
+// optionally an "enforcer <KID>" line naming the one device that
+// deletes the files in the background; see WriteExpiryPolicy.  A
+// policy file in a subdirectory overrides the one above it.
+const ExpiryPolicyFileName = ".kbfsexpiry"
+
+// maxExpiryPolicyDepth bounds where policy files are looked for when
+// no policy applies yet: in the root of a TLF and its top-level
+// directories.  This keeps the background enforcer from crawling
+// entire folders that have no policies.
+const maxExpiryPolicyDepth = 2
+
+// maxExpiryPolicySize caps how much of a policy file is read.
+const maxExpiryPolicySize = 4 * 1024
+
+// expiryEnforcerPrefix starts the line of a policy file naming its
+// enforcer.
+const expiryEnforcerPrefix = "enforcer "
+
+// ExpiryPolicy is the parsed contents of an expiry policy file.
+type ExpiryPolicy struct {
+	// MaxAge is how long files may go unmodified before they expire.
+	MaxAge time.Duration
+	// Enforcer is the KID of the device that deletes expired files
+	// in the background, if any.  Without one, expired files are
+	// only reported.
+	Enforcer keybase1.KID
+}
+
+// ParseExpiryPolicy parses the contents of an expiry policy file.
+// Blank lines and lines starting with "#" are ignored.  Durations
+// are in the format accepted by time.ParseDuration, or a whole
+// number of days like "30d".
+func ParseExpiryPolicy(data []byte) (ExpiryPolicy, error) {
+	var p ExpiryPolicy
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, expiryEnforcerPrefix):
+			kid, err := keybase1.KIDFromStringChecked(
+				strings.TrimSpace(strings.TrimPrefix(
+					line, expiryEnforcerPrefix)))
+			if err != nil || !kid.IsValid() {
+				return ExpiryPolicy{}, errors.Errorf(
+					"Invalid expiry policy enforcer %q", line)
+			}
+			p.Enforcer = kid
+		case p.MaxAge != 0:
+			return ExpiryPolicy{}, errors.Errorf(
+				"Extra expiry policy %q", line)
+		default:
+			maxAge, ok := parsePolicyDuration(line)
+			if !ok {
+				return ExpiryPolicy{}, errors.Errorf(
+					"Invalid expiry policy %q", line)
+			}
+			p.MaxAge = maxAge
+		}
+	}
+	if p.MaxAge == 0 {
+		return ExpiryPolicy{}, errors.New("Empty expiry policy")
+	}
+	return p, nil
+}
+
+// WriteExpiryPolicy writes an expiry policy file to `dir`, making
+// files below it expire once they're `maxAge` old, with the current
+// device as its enforcer.  The files are only deleted in the
+// background by that device, and only if expiry enforcement is
+// enabled on it.
+func WriteExpiryPolicy(ctx context.Context, config Config, dir Node,
+	maxAge time.Duration) error {
+	if maxAge <= 0 {
+		return errors.Errorf("Invalid maximum age %s", maxAge)
+	}
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		return err
+	}
+	data := fmt.Sprintf("%s\n%s%s\n",
+		maxAge, expiryEnforcerPrefix, session.VerifyingKey.KID())
+
+	kbfsOps := config.KBFSOps()
+	node, _, err := kbfsOps.Lookup(ctx, dir, ExpiryPolicyFileName)
+	if _, ok := errors.Cause(err).(NoSuchNameError); ok {
+		node, _, err = kbfsOps.CreateFile(
+			ctx, dir, ExpiryPolicyFileName, false, NoExcl)
+	}
+	if err != nil {
+		return err
+	}
+	err = kbfsOps.Truncate(ctx, node, 0)
+	if err != nil {
+		return err
+	}
+	err = kbfsOps.Write(ctx, node, []byte(data), 0)
+	if err != nil {
+		return err
+	}
+	return kbfsOps.SyncAll(ctx, dir.GetFolderBranch())
+}
+
+// parsePolicyDuration parses a positive duration in the format
+// accepted by time.ParseDuration, or a whole number of days like
+// "30d".
+func parsePolicyDuration(s string) (time.Duration, bool) {
+	var d time.Duration
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, false
+		}
+		d = time.Duration(days) * 24 * time.Hour
+	} else {
+		var err error
+		d, err = time.ParseDuration(s)
+		if err != nil {
+			return 0, false
+		}
+	}
+	return d, d > 0
+}
+
+// ExpiredEntry describes a file that an expiry policy says should be
+// deleted.
+type ExpiredEntry struct {
+	// Path is relative to the root of the TLF.
+	Path  string
+	Mtime time.Time
+	Size  uint64
+	// PolicyDir is the directory, relative to the root of the TLF,
+	// containing the policy that applies.
+	PolicyDir string
+	MaxAge    time.Duration
+	// Enforcer is the KID of the device that deletes the file in the
+	// background, if any.
+	Enforcer keybase1.KID `json:",omitempty"`
+
+	parent Node
+	name   string
+}
+
+type expiryWalker struct {
+	config  Config
+	log     logger.Logger
+	now     time.Time
+	expired []ExpiredEntry
+}
+
+func (w *expiryWalker) readPolicy(
+	ctx context.Context, dir Node) (ExpiryPolicy, error) {
+	kbfsOps := w.config.KBFSOps()
+	n, ei, err := kbfsOps.Lookup(ctx, dir, ExpiryPolicyFileName)
+	if err != nil {
+		return ExpiryPolicy{}, err
+	}
+	size := ei.Size
+	if size > maxExpiryPolicySize {
+		size = maxExpiryPolicySize
+	}
+	buf := make([]byte, size)
+	nRead, err := kbfsOps.Read(CtxSkipRecentFiles(ctx), n, buf, 0)
+	if err != nil {
+		return ExpiryPolicy{}, err
+	}
+	return ParseExpiryPolicy(buf[:nRead])
+}
+
+func (w *expiryWalker) walk(ctx context.Context, dir Node, dirPath string,
+	depth int, policy ExpiryPolicy, policyDir string) error {
+	kbfsOps := w.config.KBFSOps()
+	children, err := kbfsOps.GetDirChildren(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if ei, ok := children[ExpiryPolicyFileName]; ok && ei.Type != Dir {
+		dirPolicy, err := w.readPolicy(ctx, dir)
+		if err != nil {
+			// Don't let a malformed policy stop enforcement
+			// elsewhere.
+			w.log.CDebugf(ctx, "Ignoring expiry policy in %q: %+v",
+				dirPath, err)
+		} else {
+			policy = dirPolicy
+			policyDir = dirPath
+		}
+	}
+	if policy.MaxAge == 0 && depth+1 >= maxExpiryPolicyDepth {
+		return nil
+	}
+
+	names := make([]string, 0, len(children))
+	for name := range children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		ei := children[name]
+		childPath := stdpath.Join(dirPath, name)
+		switch {
+		case name == ExpiryPolicyFileName:
+		case ei.Type == Dir:
+			child, _, err := kbfsOps.Lookup(ctx, dir, name)
+			if err != nil {
+				return err
+			}
+			err = w.walk(ctx, child, childPath, depth+1, policy, policyDir)
+			if err != nil {
+				return err
+			}
+		case policy.MaxAge == 0:
+		default:
+			mtime := time.Unix(0, ei.Mtime)
+			if w.now.Sub(mtime) <= policy.MaxAge {
+				continue
+			}
+			w.expired = append(w.expired, ExpiredEntry{
+				Path:      childPath,
+				Mtime:     mtime,
+				Size:      ei.Size,
+				PolicyDir: policyDir,
+				MaxAge:    policy.MaxAge,
+				Enforcer:  policy.Enforcer,
+				parent:    dir,
+				name:      name,
+			})
+		}
+	}
+	return nil
+}
+
+// ExpireEntries finds every file under `rootNode` that is older than
+// the expiry policy covering it allows, and, unless `dryRun` is true,
+// deletes them, whatever their policies' enforcers.  It returns the
+// expired files either way.
+func ExpireEntries(ctx context.Context, config Config, rootNode Node,
+	dryRun bool) ([]ExpiredEntry, error) {
+	return expireEntries(ctx, config, rootNode, dryRun, nil)
+}
+
+// expireEntries is like ExpireEntries, but if `enforcer` is non-nil
+// it only expires files whose policies name that device as their
+// enforcer.
+func expireEntries(ctx context.Context, config Config, rootNode Node,
+	dryRun bool, enforcer *keybase1.KID) ([]ExpiredEntry, error) {
+	w := &expiryWalker{
+		config: config,
+		log:    config.MakeLogger("EXP"),
+		now:    config.Clock().Now(),
+	}
+	err := w.walk(ctx, rootNode, "", 0, ExpiryPolicy{}, "")
+	if err != nil {
+		return nil, err
+	}
+	expired := w.expired
+	if enforcer != nil {
+		expired = nil
+		for _, e := range w.expired {
+			if e.Enforcer.Equal(*enforcer) {
+				expired = append(expired, e)
+			}
+		}
+	}
+	if dryRun || len(expired) == 0 {
+		return expired, nil
+	}
+
+	kbfsOps := config.KBFSOps()
+	for _, e := range expired {
+		err := kbfsOps.RemoveEntry(ctx, e.parent, e.name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	if err != nil {
+		return nil, err
+	}
+	return expired, nil
+}
+
+// CtxExpiryTagKey is the type used for unique context tags within
+// expiryEnforcer.
+type CtxExpiryTagKey int
+
+const (
+	// CtxExpiryIDKey is the type of the tag for unique operation IDs
+	// within expiryEnforcer.
+	CtxExpiryIDKey CtxExpiryTagKey = iota
+)
+
+// CtxExpiryOpID is the display name for the unique operation
+// expiryEnforcer ID tag.
+const CtxExpiryOpID = "EXPID"
+
+// expiryEnforcer periodically applies the expiry policies that name
+// the current device as their enforcer, in the folders the current
+// user can write to that are already open or synced on this device.
+// It never initializes other folders just to look for policies.
+type expiryEnforcer struct {
+	config Config
+	log    logger.Logger
+	period time.Duration
+
+	shutdownCh chan struct{}
+	doneCh     chan struct{}
+}
+
+func newExpiryEnforcer(
+	config Config, period time.Duration) *expiryEnforcer {
+	return &expiryEnforcer{
+		config:     config,
+		log:        config.MakeLogger("EXP"),
+		period:     period,
+		shutdownCh: make(chan struct{}),
+		doneCh:     make(chan struct{}),
+	}
+}
+
+func (ee *expiryEnforcer) enforce(ctx context.Context) {
+	session, err := ee.config.KBPKI().GetCurrentSession(ctx)
+	if err != nil {
+		ee.log.CDebugf(ctx, "Not enforcing expiry policies: %+v", err)
+		return
+	}
+	enforcer := session.VerifyingKey.KID()
+	kbfsOps := ee.config.KBFSOps()
+	favs, err := kbfsOps.GetFavorites(ctx)
+	if err != nil {
+		ee.log.CDebugf(ctx, "Couldn't get favorites: %+v", err)
+		return
+	}
+	open := kbfsOps.GetOpenFolders(ctx)
+	for _, fav := range favs {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		h, err := GetHandleFromFolderNameAndType(
+			ctx, ee.config.KBPKI(), ee.config.MDOps(), fav.Name, fav.Type)
+		if err != nil {
+			ee.log.CDebugf(ctx, "Couldn't get handle for %s: %+v",
+				fav.Name, err)
+			continue
+		}
+		if !h.IsWriter(session.UID) {
+			continue
+		}
+		if _, ok := open[fav]; !ok &&
+			(h.TlfID() == tlf.NullID || !ee.config.IsSyncedTlf(h.TlfID())) {
+			continue
+		}
+		rootNode, _, err := kbfsOps.GetRootNode(ctx, h, MasterBranch)
+		if err != nil || rootNode == nil {
+			continue
+		}
+		expired, err := expireEntries(
+			ctx, ee.config, rootNode, false, &enforcer)
+		if err != nil {
+			ee.log.CDebugf(ctx, "Couldn't enforce expiry policies in %s: %+v",
+				h.GetCanonicalPath(), err)
+			continue
+		}
+		if len(expired) > 0 {
+			ee.log.CDebugf(ctx, "Deleted %d expired file(s) in %s",
+				len(expired), h.GetCanonicalPath())
+		}
+	}
+}
+
+func (ee *expiryEnforcer) loop() {
+	defer close(ee.doneCh)
+	ctx, cancel := context.WithCancel(CtxWithRandomIDReplayable(
+		context.Background(), CtxExpiryIDKey, CtxExpiryOpID, ee.log))
+	defer cancel()
+	go func() {
+		// Interrupt any enforcement in progress on shutdown.
+		<-ee.shutdownCh
+		cancel()
+	}()
+
+	ticker := time.NewTicker(ee.period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ee.enforce(ctx)
+		case <-ee.shutdownCh:
+			return
+		}
+	}
+}
+
+func (ee *expiryEnforcer) shutdown() {
+	close(ee.shutdownCh)
+	<-ee.doneCh
+}
@@ -0,0 +1,157 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExpiryPolicy(t *testing.T) {
+	p, err := ParseExpiryPolicy([]byte("# build outputs\n\n30d\n"))
+	require.NoError(t, err)
+	require.Equal(t, ExpiryPolicy{MaxAge: 30 * 24 * time.Hour}, p)
+
+	p, err = ParseExpiryPolicy([]byte("12h30m"))
+	require.NoError(t, err)
+	require.Equal(t, 12*time.Hour+30*time.Minute, p.MaxAge)
+
+	kid := kbfscrypto.MakeFakeVerifyingKeyOrBust("dev1").KID()
+	p, err = ParseExpiryPolicy([]byte("1h\nenforcer " + kid.String() + "\n"))
+	require.NoError(t, err)
+	require.Equal(t, ExpiryPolicy{MaxAge: time.Hour, Enforcer: kid}, p)
+
+	for _, bad := range []string{"", "# nothing", "xd", "-1h", "0s", "soon",
+		"1h\n2h", "1h\nenforcer zz", "enforcer " + kid.String()} {
+		_, err = ParseExpiryPolicy([]byte(bad))
+		require.Error(t, err, bad)
+	}
+}
+
+func TestExpireEntries(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	clock, _ := newTestClockAndTimeNow()
+	config.SetClock(clock)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps()
+	createFile := func(dir Node, name string, data []byte) {
+		n, _, err := kbfsOps.CreateFile(ctx, dir, name, false, NoExcl)
+		require.NoError(t, err)
+		err = kbfsOps.Write(ctx, n, data, 0)
+		require.NoError(t, err)
+	}
+
+	logs, _, err := kbfsOps.CreateDir(ctx, rootNode, "logs")
+	require.NoError(t, err)
+	sub, _, err := kbfsOps.CreateDir(ctx, logs, "sub")
+	require.NoError(t, err)
+	createFile(logs, ExpiryPolicyFileName, []byte("1h\n"))
+	createFile(logs, "old", []byte("old"))
+	createFile(sub, "deep", []byte("deep"))
+	createFile(rootNode, "keep", []byte("keep"))
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+
+	clock.Add(2 * time.Hour)
+	createFile(logs, "new", []byte("new"))
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+
+	t.Log("A dry run lists only the files older than the policy allows")
+	expired, err := ExpireEntries(ctx, config, rootNode, true)
+	require.NoError(t, err)
+	require.Len(t, expired, 2)
+	require.Equal(t, "logs/old", expired[0].Path)
+	require.Equal(t, "logs/sub/deep", expired[1].Path)
+	require.Equal(t, "logs", expired[1].PolicyDir)
+	require.Equal(t, time.Hour, expired[1].MaxAge)
+	_, _, err = kbfsOps.Lookup(ctx, logs, "old")
+	require.NoError(t, err)
+
+	t.Log("Reading the policy doesn't count as a recent access")
+	files, err := kbfsOps.GetRecentFiles(ctx, fb, ExpiryPolicyFileName, 0)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.True(t, files[0].LastRead.IsZero())
+
+	t.Log("Enforcing the policy deletes the expired files")
+	expired, err = ExpireEntries(ctx, config, rootNode, false)
+	require.NoError(t, err)
+	require.Len(t, expired, 2)
+	_, _, err = kbfsOps.Lookup(ctx, logs, "old")
+	require.IsType(t, NoSuchNameError{}, err)
+	_, _, err = kbfsOps.Lookup(ctx, sub, "deep")
+	require.IsType(t, NoSuchNameError{}, err)
+	for _, name := range []string{"new", ExpiryPolicyFileName} {
+		_, _, err = kbfsOps.Lookup(ctx, logs, name)
+		require.NoError(t, err)
+	}
+	_, _, err = kbfsOps.Lookup(ctx, rootNode, "keep")
+	require.NoError(t, err)
+
+	expired, err = ExpireEntries(ctx, config, rootNode, true)
+	require.NoError(t, err)
+	require.Len(t, expired, 0)
+}
+
+func TestExpiryEnforcerOnlyEnforcesItsPolicies(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	clock, _ := newTestClockAndTimeNow()
+	config.SetClock(clock)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps()
+	createFile := func(dir Node, name string, data []byte) {
+		n, _, err := kbfsOps.CreateFile(ctx, dir, name, false, NoExcl)
+		require.NoError(t, err)
+		err = kbfsOps.Write(ctx, n, data, 0)
+		require.NoError(t, err)
+	}
+
+	other := kbfscrypto.MakeFakeVerifyingKeyOrBust("other").KID()
+	policies := map[string][]byte{
+		"none":  []byte("1h\n"),
+		"other": []byte("1h\nenforcer " + other.String() + "\n"),
+	}
+	dirs := make(map[string]Node)
+	for _, name := range []string{"mine", "none", "other"} {
+		dir, _, err := kbfsOps.CreateDir(ctx, rootNode, name)
+		require.NoError(t, err)
+		dirs[name] = dir
+		if policy, ok := policies[name]; ok {
+			createFile(dir, ExpiryPolicyFileName, policy)
+		}
+		createFile(dir, "old", []byte("old"))
+	}
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+	err := WriteExpiryPolicy(ctx, config, dirs["mine"], time.Hour)
+	require.NoError(t, err)
+
+	clock.Add(2 * time.Hour)
+	expired, err := ExpireEntries(ctx, config, rootNode, true)
+	require.NoError(t, err)
+	require.Len(t, expired, 3)
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+	require.Equal(t, session.VerifyingKey.KID(), expired[0].Enforcer)
+
+	t.Log("The enforcer only deletes the files of the policy naming " +
+		"this device")
+	ee := newExpiryEnforcer(config, time.Hour)
+	ee.enforce(ctx)
+	_, _, err = kbfsOps.Lookup(ctx, dirs["mine"], "old")
+	require.IsType(t, NoSuchNameError{}, err)
+	for _, name := range []string{"none", "other"} {
+		_, _, err = kbfsOps.Lookup(ctx, dirs[name], "old")
+		require.NoError(t, err)
+	}
+}
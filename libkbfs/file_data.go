@@ -28,6 +28,11 @@ type fileBlockGetter func(context.Context, KeyMetadata, BlockPointer,
 type fileData struct {
 	getter fileBlockGetter
 	tree   *blockTree
+
+	// bsplit is the splitter for files of all size classes; the
+	// tree uses the one for the size class of the file.
+	bsplit    BlockSplitter
+	sizeClass BlockSizeClass
 }
 
 func newFileData(file path, chargedTo keybase1.UserOrTeamID, crypto cryptoPure,
@@ -35,6 +40,7 @@ func newFileData(file path, chargedTo keybase1.UserOrTeamID, crypto cryptoPure,
 	cacher dirtyBlockCacher, log logger.Logger) *fileData {
 	fd := &fileData{
 		getter: getter,
+		bsplit: bsplit,
 	}
 	fd.tree = &blockTree{
 		file:      file,
@@ -49,6 +55,13 @@ func newFileData(file path, chargedTo keybase1.UserOrTeamID, crypto cryptoPure,
 	return fd
 }
 
+// useSizeClass makes `fd` split the blocks of the file according to
+// the size class in its top block.
+func (fd *fileData) useSizeClass(topBlock *FileBlock) {
+	fd.sizeClass = topBlock.SizeClass
+	fd.tree.bsplit = blockSplitterForSizeClass(fd.bsplit, fd.sizeClass)
+}
+
 func (fd *fileData) rootBlockPointer() BlockPointer {
 	return fd.tree.file.tailPointer()
 }
@@ -312,6 +325,7 @@ func (fd *fileData) createIndirectBlock(
 		CommonBlock: CommonBlock{
 			IsInd: true,
 		},
+		SizeClass: fd.sizeClass,
 		IPtrs: []IndirectFilePtr{
 			{
 				BlockInfo: BlockInfo{
@@ -402,6 +416,12 @@ func (fd *fileData) write(ctx context.Context, data []byte, off Int64Offset,
 
 	fd.tree.log.CDebugf(ctx, "Writing %d bytes at off %d", n, off)
 
+	if adaptBlockSizeClass(fd.bsplit, topBlock, off) {
+		fd.tree.log.CDebugf(ctx, "Switching to size class %s for "+
+			"in-place writes", topBlock.SizeClass)
+	}
+	fd.useSizeClass(topBlock)
+
 	dirtyMap := make(map[BlockPointer]bool)
 	for nCopied < n {
 		ptr, parentBlocks, block, nextBlockOff, startOff, wasDirty, err :=
@@ -581,6 +601,7 @@ func (fd *fileData) truncateExtend(ctx context.Context, size uint64,
 	newDe DirEntry, dirtyPtrs []BlockPointer, err error) {
 	fd.tree.log.CDebugf(ctx, "truncateExtend: extending file %v to size %d",
 		fd.rootBlockPointer(), size)
+	fd.useSizeClass(topBlock)
 	switchToIndirect := !topBlock.IsInd
 	oldTopBlock := topBlock
 	if switchToIndirect {
@@ -648,7 +669,10 @@ func (fd *fileData) truncateShrink(ctx context.Context, size uint64,
 	topBlock *FileBlock, oldDe DirEntry) (
 	newDe DirEntry, dirtyPtrs []BlockPointer, unrefs []BlockInfo,
 	newlyDirtiedChildBytes int64, err error) {
-	iSize := Int64Offset(size) // TODO: deal with overflow
+	fd.useSizeClass(topBlock)
+	// The caller has already made sure `size` is at most
+	// `maxFileBytes`, so this can't overflow.
+	iSize := Int64Offset(size)
 
 	ptr, parentBlocks, block, nextBlockOff, startOff, wasDirty, err :=
 		fd.getFileBlockAtOffset(ctx, topBlock, iSize, blockWrite)
@@ -838,6 +862,7 @@ func (fd *fileData) split(ctx context.Context, id tlf.ID,
 	if !topBlock.IsInd {
 		return nil, nil
 	}
+	fd.useSizeClass(topBlock)
 
 	// For an indirect file:
 	//   1) check if each dirty block is split at the right place.
@@ -1243,16 +1268,16 @@ func (fd *fileData) undupChildrenInCopy(ctx context.Context,
 	}
 
 	// If the number of leaf blocks (len(pfr)) is likely to represent
-	// a file greater than 2 GB, abort conflict resolution.  Until
-	// disk caching is ready, we'll have to help people deal with this
-	// on a case-by-case basis.  // TODO: once the disk-backed cache
-	// is ready, make sure we use it here for both the dirty block
-	// cache and blockPutState (via some sort of "ready" block cache),
-	// so we avoid memory explosion in the case of journaling and
-	// multiple devices modifying the same large file or set of files.
-	// And then remove this check.
-	if len(pfr) > (2*1024*1024*1024)/MaxBlockSizeBytesDefault {
-		return nil, FileTooBigForCRError{fd.tree.file}
+	// a file greater than `maxFileSizeForCR`, abort conflict
+	// resolution.  Until disk caching is ready, we'll have to help
+	// people deal with this on a case-by-case basis.  // TODO: once
+	// the disk-backed cache is ready, make sure we use it here for
+	// both the dirty block cache and blockPutState (via some sort of
+	// "ready" block cache), so we avoid memory explosion in the case
+	// of journaling and multiple devices modifying the same large
+	// file or set of files.  And then remove this check.
+	if int64(len(pfr)) > maxFileSizeForCR/MaxBlockSizeBytesDefault {
+		return nil, FileTooBigForCRError{fd.tree.file, maxFileSizeForCR}
 	}
 
 	// Append the leaf block to each path, since readyHelper expects it.
@@ -9,13 +9,16 @@ import (
 	"fmt"
 	"math"
 	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/keybase/client/go/logger"
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/kbfscrypto"
 	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/net/context"
 )
@@ -869,3 +872,124 @@ func TestFileDataWriteExtendExistingFileWithGap(t *testing.T) {
 		})
 	}
 }
+
+// testFileDataReadyBlockOps readies blocks by hashing their encoded
+// contents, and fails to ready the leaf block with the contents in
+// `failOn`, if set.
+type testFileDataReadyBlockOps struct {
+	BlockOps
+	codec  kbfscodec.Codec
+	failOn []byte
+
+	lock    sync.Mutex
+	readied map[kbfsblock.ID]int
+}
+
+func (bops *testFileDataReadyBlockOps) Ready(
+	_ context.Context, _ KeyMetadata, block Block) (
+	id kbfsblock.ID, plainSize int, readyBlockData ReadyBlockData,
+	err error) {
+	if fblock, ok := block.(*FileBlock); ok && bops.failOn != nil &&
+		!fblock.IsInd && bytes.Equal(bops.failOn, fblock.Contents) {
+		return kbfsblock.ID{}, 0, ReadyBlockData{},
+			errors.New("Can't ready block")
+	}
+	id, buf := testFileDataReadyID(bops.codec, block)
+	bops.lock.Lock()
+	defer bops.lock.Unlock()
+	bops.readied[id]++
+	return id, len(buf), ReadyBlockData{buf: buf}, nil
+}
+
+func testFileDataReadyID(codec kbfscodec.Codec, block Block) (
+	kbfsblock.ID, []byte) {
+	buf, err := codec.Encode(block)
+	if err != nil {
+		panic(err)
+	}
+	id, err := kbfsblock.MakePermanentID(buf, kbfscrypto.EncryptionSecretbox)
+	if err != nil {
+		panic(err)
+	}
+	return id, buf
+}
+
+// Test that all the dirty blocks of a multi-level file are readied
+// in parallel, once each even when they share a parent, and that a
+// failure to ready one of them fails the whole batch.
+func TestFileDataReadyParallel(t *testing.T) {
+	fd, cleanBcache, dirtyBcache, df := setupFileDataTest(t, 2, 2)
+	ctx := context.Background()
+	topBlock := NewFileBlock().(*FileBlock)
+	err := dirtyBcache.Put(
+		fd.tree.file.Tlf, fd.rootBlockPointer(), MasterBranch, topBlock)
+	require.NoError(t, err)
+	// 16 leaf blocks, under three levels of indirect blocks below
+	// the top block.
+	data := make([]byte, 32)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	_, _, _, _, _, err = fd.write(ctx, data, 0, topBlock, DirEntry{}, df)
+	require.NoError(t, err)
+	block, err := dirtyBcache.Get(
+		fd.tree.file.Tlf, fd.rootBlockPointer(), MasterBranch)
+	require.NoError(t, err)
+	topBlock = block.(*FileBlock)
+	const numReadied = 16 + 8 + 4 + 2
+	codec := kbfscodec.NewMsgpack()
+
+	t.Log("A failure to ready one block fails the batch, and leaves " +
+		"the tree as it was")
+	oldIPtrs := append([]IndirectFilePtr(nil), topBlock.IPtrs...)
+	failBops := &testFileDataReadyBlockOps{
+		codec:   codec,
+		failOn:  data[10:12],
+		readied: make(map[kbfsblock.ID]int),
+	}
+	bps := newBlockPutState(1)
+	_, err = fd.ready(
+		ctx, fd.tree.file.Tlf, cleanBcache, dirtyBcache, failBops, bps,
+		topBlock, df)
+	require.Error(t, err)
+	require.Len(t, bps.blockStates, 0)
+	require.Equal(t, oldIPtrs, topBlock.IPtrs)
+
+	t.Log("Each dirty block is readied once, and its parent points " +
+		"to the readied block")
+	bops := &testFileDataReadyBlockOps{
+		codec:   codec,
+		readied: make(map[kbfsblock.ID]int),
+	}
+	oldPtrs, err := fd.ready(
+		ctx, fd.tree.file.Tlf, cleanBcache, dirtyBcache, bops, bps,
+		topBlock, df)
+	require.NoError(t, err)
+	require.Len(t, oldPtrs, numReadied)
+	require.Len(t, bps.blockStates, numReadied)
+	require.Len(t, bops.readied, numReadied)
+	for id, n := range bops.readied {
+		require.Equal(t, 1, n, "%s readied %d times", id, n)
+	}
+
+	var checkChildren func(block *FileBlock)
+	checkChildren = func(block *FileBlock) {
+		for _, iptr := range block.IPtrs {
+			child, err := cleanBcache.Get(iptr.BlockPointer)
+			require.NoError(t, err)
+			id, _ := testFileDataReadyID(codec, child)
+			require.Equal(t, id, iptr.ID)
+			require.Contains(t, oldPtrs, iptr.BlockInfo)
+			if fblock := child.(*FileBlock); fblock.IsInd {
+				checkChildren(fblock)
+			}
+		}
+	}
+	checkChildren(topBlock)
+
+	gotData := make([]byte, len(data))
+	nRead, err := fd.read(ctx, gotData, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), nRead)
+	require.Equal(t, data, gotData)
+}
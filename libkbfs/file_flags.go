@@ -0,0 +1,59 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FileFlagsFileName is the name of a file, at the root of a TLF, that
+// sets default flags for every file in the TLF, as presented by the
+// mounted file systems.  Each line names a flag:
+//   - "readonly" makes every file report as read-only, and refuses
+//     changes to file attributes, e.g. for distribution folders.
+//   - "exec" makes every file report as executable, keeps the
+//     executable bit from being cleared, and creates new files as
+//     executable, e.g. for folders of scripts.
+//
+// Blank lines and lines starting with "#" are ignored.  The flags
+// file itself, and the TLF settings file, are never affected by
+// them, so they can still be changed.
+const FileFlagsFileName = ".kbfs_file_flags"
+
+// FileFlags are the default flags of the files in a TLF.
+type FileFlags struct {
+	ReadOnly bool
+	Exec     bool
+}
+
+// ParseFileFlags parses the contents of a file flags file.
+func ParseFileFlags(data []byte) (flags FileFlags, err error) {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case line == "readonly":
+			flags.ReadOnly = true
+		case line == "exec":
+			flags.Exec = true
+		default:
+			return FileFlags{}, errors.Errorf("Unknown file flag %q", line)
+		}
+	}
+	return flags, s.Err()
+}
+
+// ForFile returns the flags that apply to the file named `name`.
+func (ff FileFlags) ForFile(name string) FileFlags {
+	if name == FileFlagsFileName || name == TlfSettingsFileName {
+		return FileFlags{}
+	}
+	return ff
+}
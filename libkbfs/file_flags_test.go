@@ -0,0 +1,25 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFileFlags(t *testing.T) {
+	flags, err := ParseFileFlags([]byte("# scripts\n\nexec\n"))
+	require.NoError(t, err)
+	require.Equal(t, FileFlags{Exec: true}, flags)
+
+	flags, err = ParseFileFlags([]byte("readonly\n exec \n"))
+	require.NoError(t, err)
+	require.Equal(t, FileFlags{ReadOnly: true, Exec: true}, flags)
+	require.Equal(t, FileFlags{}, flags.ForFile(FileFlagsFileName))
+
+	_, err = ParseFileFlags([]byte("readonly\nimmutable\n"))
+	require.Error(t, err)
+}
@@ -25,6 +25,8 @@ type fbmHelper interface {
 	getMostRecentFullyMergedMD(ctx context.Context) (
 		ImmutableRootMetadata, error)
 	finalizeGCOp(ctx context.Context, gco *GCOp) error
+	getHistoryRetention(ctx context.Context) (time.Duration, error)
+	getLegalHoldRetention(ctx context.Context) (time.Duration, error)
 }
 
 const (
@@ -105,6 +107,9 @@ type folderBlockManager struct {
 
 	// reclamationGroup tracks the outstanding quota reclamations.
 	reclamationGroup kbfssync.RepeatedWaitGroup
+	// reclaimsQuota is true if quota reclamation runs in the
+	// background for this folder-branch.
+	reclaimsQuota bool
 
 	reclamationCancelLock sync.Mutex
 	reclamationCancel     context.CancelFunc
@@ -117,6 +122,13 @@ type folderBlockManager struct {
 	lastQROldEnoughRev  kbfsmd.Revision
 	wasLastQRComplete   bool
 	lastReclamationTime time.Time
+
+	// The retention set by the folder's history retention file, and
+	// the retention of its legal hold, as of the last reclamation,
+	// or 0 if it has none.
+	historyRetentionLock sync.Mutex
+	historyRetention     time.Duration
+	legalHoldRetention   time.Duration
 }
 
 func newFolderBlockManager(
@@ -149,6 +161,7 @@ func newFolderBlockManager(
 	go fbm.archiveBlocksInBackground()
 	go fbm.deleteBlocksInBackground()
 	if fb.Branch == MasterBranch && config.Mode().QuotaReclamationEnabled() {
+		fbm.reclaimsQuota = true
 		go fbm.reclaimQuotaInBackground()
 	}
 	return fbm
@@ -736,10 +749,60 @@ func (fbm *folderBlockManager) deleteBlocksInBackground() {
 	}
 }
 
+// refreshHistoryRetention re-reads the folder's history retention
+// file and legal hold.  On error, the previously-read retentions are
+// kept.
+func (fbm *folderBlockManager) refreshHistoryRetention(ctx context.Context) {
+	retention, err := fbm.helper.getHistoryRetention(ctx)
+	if err != nil {
+		fbm.log.CDebugf(ctx, "Couldn't read the history retention: %+v", err)
+		return
+	}
+	holdRetention, err := fbm.helper.getLegalHoldRetention(ctx)
+	if err != nil {
+		fbm.log.CDebugf(ctx, "Couldn't read the legal hold: %+v", err)
+		return
+	}
+	fbm.historyRetentionLock.Lock()
+	defer fbm.historyRetentionLock.Unlock()
+	fbm.historyRetention = retention
+	fbm.legalHoldRetention = holdRetention
+}
+
+func (fbm *folderBlockManager) getHistoryRetention() time.Duration {
+	fbm.historyRetentionLock.Lock()
+	defer fbm.historyRetentionLock.Unlock()
+	return fbm.historyRetention
+}
+
+func (fbm *folderBlockManager) getLegalHoldRetention() time.Duration {
+	fbm.historyRetentionLock.Lock()
+	defer fbm.historyRetentionLock.Unlock()
+	return fbm.legalHoldRetention
+}
+
+// unrefAge returns how old a revision must be before the blocks it
+// unreferenced can be reclaimed.  The folder's history retention, if
+// set, replaces the usual minimum, and a legal hold on the folder
+// can extend it further.
+func (fbm *folderBlockManager) unrefAge() time.Duration {
+	unrefAge := fbm.config.Mode().QuotaReclamationMinUnrefAge()
+	if retention := fbm.getHistoryRetention(); retention > 0 {
+		unrefAge = retention
+	}
+	if hold := fbm.getLegalHoldRetention(); hold > unrefAge {
+		unrefAge = hold
+	}
+	return unrefAge
+}
+
 func (fbm *folderBlockManager) isOldEnough(rmd ImmutableRootMetadata) bool {
 	// Trust the server's timestamp on this MD.
 	mtime := rmd.localTimestamp
-	unrefAge := fbm.config.Mode().QuotaReclamationMinUnrefAge()
+	unrefAge := fbm.unrefAge()
+	if unrefAge == HistoryRetentionForever {
+		return false
+	}
 	return mtime.Add(unrefAge).Before(fbm.config.Clock().Now())
 }
 
@@ -782,8 +845,7 @@ func (fbm *folderBlockManager) getMostRecentOldEnoughAndGCRevisions(
 			if mostRecentOldEnoughRev == kbfsmd.RevisionUninitialized &&
 				fbm.isOldEnough(rmd) {
 				fbm.log.CDebugf(ctx, "Revision %d is older than the unref "+
-					"age %s", rmd.Revision(),
-					fbm.config.Mode().QuotaReclamationMinUnrefAge())
+					"age %s", rmd.Revision(), fbm.unrefAge())
 				mostRecentOldEnoughRev = rmd.Revision()
 			}
 
@@ -1076,6 +1138,7 @@ func (fbm *folderBlockManager) doReclamation(timer *time.Timer) (err error) {
 			head.GetTlfHandle().GetCanonicalPath())
 	}
 
+	fbm.refreshHistoryRetention(ctx)
 	if !fbm.isQRNecessary(ctx, head) {
 		// Nothing has changed since last time, or the current head is
 		// too new, so no need to do any QR.
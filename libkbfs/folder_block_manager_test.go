@@ -12,7 +12,9 @@ import (
 
 	kbname "github.com/keybase/client/go/kbun"
 	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfsmd"
 	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/net/context"
 )
 
@@ -818,3 +820,54 @@ func TestQuotaReclamationGCOpsForGCOps(t *testing.T) {
 		t.Fatalf("Last GCOp revision was unexpected: %d vs %d", g, e)
 	}
 }
+
+func TestQuotaReclamationStatus(t *testing.T) {
+	var userName kbname.NormalizedUsername = "test_user"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, userName)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	clock, now := newTestClockAndTimeNow()
+	config.SetClock(clock)
+
+	rootNode := GetRootNodeOrBust(
+		ctx, t, config, userName.String(), tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps()
+	_, _, err := kbfsOps.CreateDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+	err = kbfsOps.RemoveDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+
+	t.Log("Nothing is reclaimable until the unref age passes")
+	unrefAge := config.Mode().QuotaReclamationMinUnrefAge()
+	status, err := kbfsOps.GetQuotaReclamationStatus(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, kbfsmd.RevisionUninitialized, status.LastGCRevision)
+	require.Equal(t, kbfsmd.RevisionUninitialized, status.ReclaimableRevision)
+	require.Equal(t, uint64(0), status.ReclaimableBytes)
+	require.NotZero(t, status.PendingBytes)
+	require.True(t, now.Add(unrefAge).Equal(status.NextEligibleTime))
+	require.Equal(t, unrefAge, status.MinUnrefAge)
+
+	t.Log("Once it passes, the old revisions become reclaimable")
+	clock.Set(now.Add(2 * unrefAge))
+	_, _, err = kbfsOps.CreateDir(ctx, rootNode, "b")
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+	status, err = kbfsOps.GetQuotaReclamationStatus(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, status.HeadRevision-1, status.ReclaimableRevision)
+	require.NotZero(t, status.ReclaimableBytes)
+
+	t.Log("Collecting them leaves nothing reclaimable")
+	err = kbfsOps.ForceQuotaReclamation(ctx, fb)
+	require.NoError(t, err)
+	err = kbfsOps.SyncFromServer(ctx, fb, nil)
+	require.NoError(t, err)
+	status, err = kbfsOps.GetQuotaReclamationStatus(ctx, fb)
+	require.NoError(t, err)
+	require.True(t, status.LastGCRevision >= kbfsmd.RevisionInitial)
+	require.Equal(t, uint64(0), status.ReclaimableBytes)
+	require.True(t, clock.Now().Equal(status.LastReclamationTime))
+}
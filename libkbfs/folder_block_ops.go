@@ -236,6 +236,10 @@ type folderBlockOps struct {
 	// call PathFromNode() only under blockLock (see nodeCache
 	// comments in folder_branch_ops.go).
 	nodeCache NodeCache
+
+	// sortedDirNames speeds up paged directory listings; it's
+	// goroutine-safe.
+	sortedDirNames *sortedDirNamesCache
 }
 
 // Only exported methods of folderBlockOps should be used outside of this
@@ -958,7 +962,7 @@ func (fbo *folderBlockOps) wrapWithBlockLock(fn func()) dirCacheUndoFn {
 func (fbo *folderBlockOps) newDirDataLocked(lState *lockState,
 	dir path, chargedTo keybase1.UserOrTeamID, kmd KeyMetadata) *dirData {
 	fbo.blockLock.AssertAnyLocked(lState)
-	return newDirData(dir, chargedTo, fbo.config.Crypto(),
+	dd := newDirData(dir, chargedTo, fbo.config.Crypto(),
 		fbo.config.BlockSplitter(), kmd,
 		func(ctx context.Context, kmd KeyMetadata, ptr BlockPointer,
 			dir path, rtype blockReqType) (*DirBlock, bool, error) {
@@ -973,6 +977,8 @@ func (fbo *folderBlockOps) newDirDataLocked(lState *lockState,
 			return fbo.config.DirtyBlockCache().Put(
 				fbo.id(), ptr, dir.Branch, block)
 		}, fbo.log)
+	dd.sortedNames = fbo.sortedDirNames
+	return dd
 }
 
 // newDirDataWithLBCLocked creates a new `dirData` that reads from and
@@ -1063,6 +1069,12 @@ func (fbo *folderBlockOps) updateParentDirEntryLocked(
 		return nil, err
 	}
 	now := fbo.nowUnixNano()
+	// stale says whether a time of the directory is old enough to
+	// be worth updating.
+	period := fbo.config.DirTimesBatchPeriod().Nanoseconds()
+	stale := func(t int64) bool {
+		return period <= 0 || now-t >= period
+	}
 	pp := *dir.parentPath()
 	if pp.isValid() {
 		dd := fbo.newDirDataLocked(lState, pp, chargedTo, kmd)
@@ -1070,6 +1082,11 @@ func (fbo *folderBlockOps) updateParentDirEntryLocked(
 		if err != nil {
 			return nil, err
 		}
+		setMtime = setMtime && stale(de.Mtime)
+		setCtime = setCtime && stale(de.Ctime)
+		if !setMtime && !setCtime {
+			return func() {}, nil
+		}
 		newDe := de
 		if setMtime {
 			newDe.Mtime = now
@@ -1089,6 +1106,15 @@ func (fbo *folderBlockOps) updateParentDirEntryLocked(
 	}
 
 	// If the parent isn't a valid path, we need to update the root entry.
+	rootDe := kmd.GetRootDirEntry()
+	if fbo.dirtyRootDirEntry != nil {
+		rootDe = *fbo.dirtyRootDirEntry
+	}
+	setMtime = setMtime && stale(rootDe.Mtime)
+	setCtime = setCtime && stale(rootDe.Ctime)
+	if !setMtime && !setCtime {
+		return func() {}, nil
+	}
 	var de *DirEntry
 	if fbo.dirtyRootDirEntry == nil {
 		deCopy := kmd.GetRootDirEntry()
@@ -1413,6 +1439,18 @@ func (fbo *folderBlockOps) GetChildren(
 	return dd.getChildren(ctx)
 }
 
+// GetChildrenPage returns up to `max` of the (possibly dirty)
+// children of the given directory, sorted by name, that come after
+// `after`.
+func (fbo *folderBlockOps) GetChildrenPage(
+	ctx context.Context, lState *lockState, kmd KeyMetadata,
+	dir path, after string, max int) ([]DirChild, error) {
+	fbo.blockLock.RLock(lState)
+	defer fbo.blockLock.RUnlock(lState)
+	dd := fbo.newDirDataLocked(lState, dir, keybase1.UserOrTeamID(""), kmd)
+	return dd.getChildrenPage(ctx, after, max)
+}
+
 // GetEntries returns a map of DirEntries for the (possibly dirty)
 // children entries of the given directory.
 func (fbo *folderBlockOps) GetEntries(
@@ -1424,6 +1462,39 @@ func (fbo *folderBlockOps) GetEntries(
 	return dd.getEntries(ctx)
 }
 
+// GetCleanEntries returns a map of DirEntries for the children of the
+// given directory as of its last sync, ignoring any dirty blocks.
+// The blocks of the directory are fetched without being cached as
+// dirty, so this is safe to call without any locks held.
+func (fbo *folderBlockOps) GetCleanEntries(
+	ctx context.Context, kmd KeyMetadata, dir path) (
+	map[string]DirEntry, error) {
+	dd := newDirData(dir, keybase1.UserOrTeamID(""), fbo.config.Crypto(),
+		fbo.config.BlockSplitter(), kmd,
+		func(ctx context.Context, kmd KeyMetadata, ptr BlockPointer,
+			dir path, rtype blockReqType) (*DirBlock, bool, error) {
+			block, err := fbo.config.BlockCache().Get(ptr)
+			if err != nil {
+				block = NewDirBlock()
+				err = fbo.config.BlockOps().Get(
+					ctx, kmd, ptr, block, TransientEntry)
+				if err != nil {
+					return nil, false, err
+				}
+			}
+			dblock, ok := block.(*DirBlock)
+			if !ok {
+				return nil, false, NotDirBlockError{ptr, dir.Branch, dir}
+			}
+			return dblock, false, nil
+		},
+		func(ptr BlockPointer, block Block) error {
+			return errors.Errorf("Can't cache dirty block %v while "+
+				"reading clean entries", ptr)
+		}, fbo.log)
+	return dd.getEntries(ctx)
+}
+
 func (fbo *folderBlockOps) getEntryLocked(ctx context.Context,
 	lState *lockState, kmd KeyMetadataWithRootDirEntry, file path,
 	includeDeleted bool) (de DirEntry, err error) {
@@ -1617,6 +1688,23 @@ func (fbo *folderBlockOps) GetDirtyFileBlockRefs(lState *lockState) []BlockRef {
 	return dirtyRefs
 }
 
+// GetDirtyFileUnrefs returns the infos, as of the last sync, of the
+// given file's blocks that have been replaced by dirty writes since
+// then, including its top block.  Removing a dirty file has to
+// unreference these, since its dirty blocks will never be synced.
+func (fbo *folderBlockOps) GetDirtyFileUnrefs(
+	lState *lockState, file path) []BlockInfo {
+	fbo.blockLock.RLock(lState)
+	defer fbo.blockLock.RUnlock(lState)
+	si, ok := fbo.unrefCache[file.tailRef()]
+	if !ok {
+		return nil
+	}
+	unrefs := make([]BlockInfo, 0, len(si.unrefs)+1)
+	unrefs = append(unrefs, si.oldInfo)
+	return append(unrefs, si.unrefs...)
+}
+
 // GetDirtyDirBlockRefs returns a list of references of all known dirty
 // directories.
 func (fbo *folderBlockOps) GetDirtyDirBlockRefs(lState *lockState) []BlockRef {
@@ -2038,6 +2126,12 @@ func (fbo *folderBlockOps) writeDataLocked(
 func (fbo *folderBlockOps) Write(
 	ctx context.Context, lState *lockState, kmd KeyMetadataWithRootDirEntry,
 	file Node, data []byte, off int64) error {
+	if off >= 0 && uint64(off) > maxFileBytes-uint64(len(data)) {
+		return FileTooBigError{
+			fbo.nodeCache.PathFromNode(file),
+			uint64(off) + uint64(len(data)), maxFileBytes}
+	}
+
 	// If there is too much unflushed data, we should wait until some
 	// of it gets flush so our memory usage doesn't grow without
 	// bound.
@@ -2260,6 +2354,11 @@ func (fbo *folderBlockOps) truncateLocked(
 func (fbo *folderBlockOps) Truncate(
 	ctx context.Context, lState *lockState, kmd KeyMetadataWithRootDirEntry,
 	file Node, size uint64) error {
+	if size > maxFileBytes {
+		return FileTooBigError{
+			fbo.nodeCache.PathFromNode(file), size, maxFileBytes}
+	}
+
 	// If there is too much unflushed data, we should wait until some
 	// of it gets flush so our memory usage doesn't grow without
 	// bound.
@@ -2351,6 +2450,19 @@ func (fbo *folderBlockOps) IsDirty(lState *lockState, file path) bool {
 	return ok
 }
 
+// GetUnsyncedBytes returns the number of bytes written to the given
+// file that haven't been synced yet.
+func (fbo *folderBlockOps) GetUnsyncedBytes(
+	lState *lockState, file path) int64 {
+	fbo.blockLock.RLock(lState)
+	defer fbo.blockLock.RUnlock(lState)
+	df := fbo.dirtyFiles[file.tailPointer()]
+	if df == nil {
+		return 0
+	}
+	return df.unsyncedBytes()
+}
+
 func (fbo *folderBlockOps) clearCacheInfoLocked(lState *lockState,
 	file path) error {
 	fbo.blockLock.AssertLocked(lState)
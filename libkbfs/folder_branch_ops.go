@@ -89,6 +89,14 @@ const (
 	maxRetriesOnRecoverableErrors = 10
 	// When the number of dirty bytes exceeds this level, force a sync.
 	dirtyBytesThreshold = maxParallelBlockPuts * MaxBlockSizeBytesDefault
+	// With journaling, conflict resolution copies every data block
+	// of a conflicted file into memory, so it refuses to handle files
+	// with more data blocks than this many bytes' worth.
+	maxFileSizeForCR = int64(2 << 30) // 2 GB
+	// File offsets are signed 64-bit integers, both in the block
+	// tree and in the write ranges of sync ops, so files can't grow
+	// past this.
+	maxFileBytes = uint64(1<<63 - 1)
 	// The timeout for any background task.
 	backgroundTaskTimeout = 1 * time.Minute
 	// If it's been more than this long since our last update, check
@@ -301,6 +309,17 @@ type folderBranchOps struct {
 	identifyLock sync.Mutex
 	identifyDone bool
 	identifyTime time.Time
+	// identifySkipAudited is set once a skipped identify of this
+	// folder has been written to the identify audit log.
+	identifySkipAudited bool
+
+	// The broken proofs of the folder's members, if any.
+	identifyBreaksLock sync.RWMutex
+	identifyBreaks     *IdentifyBreaksStatus
+
+	// Set while writes are frozen by FreezeWrites.
+	writeFreezeLock sync.RWMutex
+	writeFreeze     *writeFreeze
 
 	// The current status summary for this folder
 	status *folderBranchStatusKeeper
@@ -343,6 +362,22 @@ type folderBranchOps struct {
 	editHistory  *kbfsedits.TlfHistory
 	editChannels chan editChannelActivity
 
+	// recentFiles tracks the files recently read or written on
+	// this device.
+	recentFiles *recentFiles
+
+	// siblingPrefetch notices files being opened in name order, so
+	// the ones after them can be prefetched.
+	siblingPrefetch *siblingPrefetcher
+
+	// folderStats caches the aggregate stats of the directories in
+	// this folder.
+	folderStats *folderStatsCache
+
+	// accessLog tracks the recently logged reads, if the TLF has
+	// read access logging turned on.
+	accessLog accessLog
+
 	cancelEditsLock sync.Mutex
 	// Cancels the goroutine currently waiting on edits
 	cancelEdits context.CancelFunc
@@ -353,6 +388,16 @@ type folderBranchOps struct {
 	merkleFetches      kbfssync.RepeatedWaitGroup
 	editActivity       kbfssync.RepeatedWaitGroup
 	launchEditMonitor  sync.Once
+	launchSettingsLoop sync.Once
+	launchRulesLoop    sync.Once
+
+	// settingsCache caches the settings of the TLF, and
+	// settingsReloadCh wakes up the goroutine that applies them.
+	settingsCache    tlfSettingsCache
+	settingsReloadCh chan struct{}
+	// rulesReloadCh wakes up the goroutine that reloads the ignore
+	// and sparse sync files.
+	rulesReloadCh chan struct{}
 
 	muLastGetHead sync.Mutex
 	// We record a timestamp everytime getHead or getTrustedHead is called, and
@@ -407,9 +452,12 @@ func newFolderBranchOps(
 
 	observers := newObserverList()
 
-	mdWriterLock := makeLeveledMutex(mutexLevel(fboMDWriter), &sync.Mutex{})
-	headLock := makeLeveledRWMutex(mutexLevel(fboHead), &sync.RWMutex{})
-	blockLockMu := makeLeveledRWMutex(mutexLevel(fboBlock), &sync.RWMutex{})
+	mdWriterLock := makeLeveledMutex(mutexLevel(fboMDWriter),
+		&orderCheckedMutex{name: "fbo.mdWriterLock"})
+	headLock := makeLeveledRWMutex(mutexLevel(fboHead),
+		&orderCheckedRWMutex{name: "fbo.headLock"})
+	blockLockMu := makeLeveledRWMutex(mutexLevel(fboBlock),
+		&orderCheckedRWMutex{name: "fbo.blockLock"})
 
 	forceSyncChan := make(chan struct{})
 
@@ -436,16 +484,23 @@ func newFolderBranchOps(
 			unrefCache: make(map[BlockRef]*syncInfo),
 			dirtyDirs:  make(map[BlockPointer][]BlockInfo),
 			nodeCache:  nodeCache,
+
+			sortedDirNames: newSortedDirNamesCache(),
 		},
-		nodeCache:       nodeCache,
-		log:             traceLogger{log},
-		deferLog:        traceLogger{log.CloneWithAddedDepth(1)},
-		shutdownChan:    make(chan struct{}),
-		updatePauseChan: make(chan (<-chan struct{})),
-		forceSyncChan:   forceSyncChan,
-		syncNeededChan:  make(chan struct{}, 1),
-		editHistory:     kbfsedits.NewTlfHistory(),
-		editChannels:    make(chan editChannelActivity, 100),
+		nodeCache:        nodeCache,
+		log:              traceLogger{log},
+		deferLog:         traceLogger{log.CloneWithAddedDepth(1)},
+		shutdownChan:     make(chan struct{}),
+		updatePauseChan:  make(chan (<-chan struct{})),
+		forceSyncChan:    forceSyncChan,
+		syncNeededChan:   make(chan struct{}, 1),
+		settingsReloadCh: make(chan struct{}, 1),
+		rulesReloadCh:    make(chan struct{}, 1),
+		editHistory:      kbfsedits.NewTlfHistory(),
+		editChannels:     make(chan editChannelActivity, 100),
+		recentFiles:      newRecentFiles(maxRecentFilesPerTlf),
+		siblingPrefetch:  newSiblingPrefetcher(config.MetricsRegistry()),
+		folderStats:      newFolderStatsCache(),
 	}
 	fbo.prepper = folderUpdatePrepper{
 		config:       config,
@@ -879,6 +934,22 @@ func (fbo *folderBranchOps) setHeadLocked(
 		fbo.headStatus = headTrusted
 	}
 	fbo.status.setRootMetadata(md)
+	if fbo.bType == standard && md.IsReadable() &&
+		fbo.config.IsSyncedTlf(fbo.id()) {
+		// The ignore and sparse sync files may have changed, so
+		// reload them in the background, once the locks held here
+		// are released.
+		fbo.signalRulesReload()
+	}
+	if fbo.bType == standard && md.IsReadable() {
+		_, err := GetJournalServer(fbo.config)
+		_, loaded := fbo.peekTlfSettings()
+		if err == nil || fbo.config.DataRegionsEnforced() || loaded {
+			// The data region, write-through and DLP settings may
+			// have changed.
+			fbo.signalTlfSettingsReload()
+		}
+	}
 	if isFirstHead {
 		// Start registering for updates right away, using this MD
 		// as a starting point. Only standard FBOs get updates.
@@ -1086,12 +1157,32 @@ func (fbo *folderBranchOps) identifyOnce(
 		return err
 	}
 
-	if ei.behavior.WarningInsteadOfErrorOnBrokenTracks() &&
-		len(ei.getTlfBreakAndClose().Breaks) > 0 {
+	var breaks keybase1.TLFBreak
+	if ei.behavior.WarningInsteadOfErrorOnBrokenTracks() {
+		breaks = ei.getTlfBreakAndClose()
+	}
+	if ei.behavior != keybase1.TLFIdentifyBehavior_CHAT_SKIP {
+		// Behaviors that don't report breaks fail the identify
+		// instead, so a success there means nothing is broken.
+		fbo.updateIdentifyBreaks(ctx, h, breaks)
+	}
+	if policy := fbo.config.IdentifyPolicy(); policy != nil &&
+		ei.behavior != keybase1.TLFIdentifyBehavior_CHAT_SKIP {
+		err := policy.CheckFolder(ctx, h, breaks)
+		if err != nil {
+			fbo.log.CDebugf(ctx, "Identify policy denied access: %v", err)
+			return err
+		}
+	}
+
+	if len(breaks.Breaks) > 0 {
 		fbo.log.CDebugf(ctx,
 			"Identify finished with no error but broken proof warnings")
 	} else if ei.behavior == keybase1.TLFIdentifyBehavior_CHAT_SKIP {
 		fbo.log.CDebugf(ctx, "Identify skipped")
+		if ei.auditSkip {
+			fbo.auditSkippedIdentifyLocked(ctx, h)
+		}
 	} else {
 		fbo.log.CDebugf(ctx, "Identify finished successfully")
 		fbo.identifyDone = true
@@ -1128,6 +1219,19 @@ func (fbo *folderBranchOps) GetTLFHandle(ctx context.Context, node Node) (
 	return md.GetTlfHandle(), nil
 }
 
+// headTlfPath returns the canonical path of the TLF, or "" if its
+// head hasn't been fetched yet.  Unlike getHead, it doesn't count as
+// an access of the head.
+func (fbo *folderBranchOps) headTlfPath() string {
+	lState := makeFBOLockState()
+	fbo.headLock.RLock(lState)
+	defer fbo.headLock.RUnlock(lState)
+	if fbo.head == (ImmutableRootMetadata{}) {
+		return ""
+	}
+	return string(fbo.head.GetTlfHandle().GetCanonicalPath())
+}
+
 // getMDForWriteOrRekeyLocked can fetch MDs, identify them and
 // contains the fancy logic. For reading use getMDLockedForRead.
 // Here we actually can fetch things from the server.
@@ -1436,6 +1540,10 @@ func (fbo *folderBranchOps) maybeUnembedAndPutBlocks(ctx context.Context,
 		}
 	}()
 
+	err = fbo.ensureDataRegionLoaded(ctx)
+	if err != nil {
+		return nil, err
+	}
 	ptrsToDelete, err := doBlockPuts(ctx, fbo.config.BlockServer(),
 		fbo.config.BlockCache(), fbo.config.Reporter(), fbo.log, fbo.deferLog, md.TlfID(),
 		md.GetTlfHandle().GetCanonicalName(), *bps)
@@ -1574,6 +1682,14 @@ func (fbo *folderBranchOps) initMDLocked(
 		return nil
 	}
 
+	if fbo.config.DataRegionsEnforced() {
+		// A new TLF has no settings yet, so it isn't constrained.
+		err = fbo.config.SetTlfDataRegion(fbo.id(), "")
+		if err != nil {
+			return err
+		}
+	}
+
 	if err = PutBlockCheckLimitErrs(ctx, fbo.config.BlockServer(),
 		fbo.config.Reporter(), md.TlfID(), info.BlockPointer, readyBlockData,
 		md.GetTlfHandle().GetCanonicalName()); err != nil {
@@ -1674,6 +1790,14 @@ func (fbo *folderBranchOps) checkNodeForWrite(
 	if err != nil {
 		return err
 	}
+	err = fbo.checkIdentifyBreaksFrozen()
+	if err != nil {
+		return err
+	}
+	err = fbo.checkWritesFrozen()
+	if err != nil {
+		return err
+	}
 	if !node.Readonly(ctx) {
 		return nil
 	}
@@ -1686,6 +1810,39 @@ func (fbo *folderBranchOps) checkNodeForWrite(
 	return WriteToReadonlyNodeError{p.String()}
 }
 
+// kickOffRootBlockFetch requests the root block of `md`, which
+// starts the prefetches of the blocks below it, as befits the
+// current sync state of the TLF.
+func (fbo *folderBranchOps) kickOffRootBlockFetch(
+	ctx context.Context, md ImmutableRootMetadata) {
+	// We `Get` the root block to ensure downstream prefetches
+	// occur.  Use a fresh context, in case `ctx` is canceled by
+	// the caller before we complete.
+	prefetchCtx := fbo.ctxWithFBOID(context.Background())
+	fbo.log.CDebugf(ctx,
+		"Prefetching root block with a new context: FBOID=%s",
+		prefetchCtx.Value(CtxFBOIDKey))
+	_ = fbo.config.BlockOps().BlockRetriever().Request(prefetchCtx,
+		defaultOnDemandRequestPriority, md, md.data.Dir.BlockPointer,
+		&DirBlock{}, TransientEntry)
+}
+
+// restartDeepPrefetch requests the root block of the current head
+// again if this TLF is synced, so that a deep prefetch that was held
+// back, e.g. while the device was constrained, picks up where it left
+// off.
+func (fbo *folderBranchOps) restartDeepPrefetch(ctx context.Context) {
+	if !fbo.config.IsSyncedTlf(fbo.id()) ||
+		fbo.config.Mode().PrefetchWorkers() == 0 {
+		return
+	}
+	head, _ := fbo.getHead(makeFBOLockState())
+	if head == (ImmutableRootMetadata{}) || !head.IsReadable() {
+		return
+	}
+	fbo.kickOffRootBlockFetch(ctx, head)
+}
+
 // SetInitialHeadFromServer sets the head to the given
 // ImmutableRootMetadata, which must be retrieved from the MD server.
 func (fbo *folderBranchOps) SetInitialHeadFromServer(
@@ -1699,16 +1856,7 @@ func (fbo *folderBranchOps) SetInitialHeadFromServer(
 	}()
 
 	if md.IsReadable() && fbo.config.Mode().PrefetchWorkers() > 0 {
-		// We `Get` the root block to ensure downstream prefetches
-		// occur.  Use a fresh context, in case `ctx` is canceled by
-		// the caller before we complete.
-		prefetchCtx := fbo.ctxWithFBOID(context.Background())
-		fbo.log.CDebugf(ctx,
-			"Prefetching root block with a new context: FBOID=%s",
-			prefetchCtx.Value(CtxFBOIDKey))
-		_ = fbo.config.BlockOps().BlockRetriever().Request(prefetchCtx,
-			defaultOnDemandRequestPriority, md, md.data.Dir.BlockPointer,
-			&DirBlock{}, TransientEntry)
+		fbo.kickOffRootBlockFetch(ctx, md)
 	} else {
 		fbo.log.CDebugf(ctx,
 			"Setting an unreadable head with revision=%d", md.Revision())
@@ -1986,6 +2134,118 @@ func (fbo *folderBranchOps) GetDirChildren(ctx context.Context, dir Node) (
 	return retChildren, nil
 }
 
+func (fbo *folderBranchOps) getDirChildrenPage(
+	ctx context.Context, dir Node, after string, max int) (
+	children []DirChild, err error) {
+	if dir.GetFS(ctx) != nil {
+		// FS-backed directories are small, so just page through the
+		// full listing.
+		all, err := fbo.getDirChildren(ctx, dir)
+		if err != nil {
+			return nil, err
+		}
+		for name, ei := range all {
+			if name > after {
+				children = append(children, DirChild{Name: name, EntryInfo: ei})
+			}
+		}
+		sort.Slice(children, func(i, j int) bool {
+			return children[i].Name < children[j].Name
+		})
+		if len(children) > max {
+			children = children[:max]
+		}
+		return children, nil
+	}
+
+	lState := makeFBOLockState()
+
+	dirPath, err := fbo.pathFromNodeForRead(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if fbo.nodeCache.IsUnlinked(dir) {
+		fbo.log.CDebugf(ctx, "Returning an empty children page for "+
+			"unlinked directory %v", dirPath.tailPointer())
+		return nil, nil
+	}
+
+	md, err := fbo.getMDForReadNeedIdentify(ctx, lState)
+	if err != nil {
+		return nil, err
+	}
+
+	children, err = fbo.blocks.GetChildrenPage(
+		ctx, lState, md.ReadOnly(), dirPath, after, max)
+	if err != nil {
+		return nil, err
+	}
+	// Entries with live nodes keep identifying themselves by the
+	// ref their node was made with, even after they've been synced.
+	for i, c := range children {
+		if !c.Ref.IsValid() {
+			continue
+		}
+		if n := fbo.nodeCache.Get(c.Ref); n != nil {
+			children[i].Ref = InitialNodeRef(n)
+		}
+	}
+	return children, nil
+}
+
+// GetDirChildrenPage implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) GetDirChildrenPage(
+	ctx context.Context, dir Node, after string, max int) (
+	children []DirChild, err error) {
+	fbo.log.CDebugf(ctx, "GetDirChildrenPage %s after=%q max=%d",
+		getNodeIDStr(dir), after, max)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "GetDirChildrenPage %s done, %d entries: %+v",
+			getNodeIDStr(dir), len(children), err)
+	}()
+
+	err = fbo.checkNode(dir)
+	if err != nil {
+		return nil, err
+	}
+	if max <= 0 {
+		return nil, errors.Errorf("Invalid page size %d", max)
+	}
+
+	var retChildren []DirChild
+	err = runUnlessCanceled(ctx, func() error {
+		retChildren, err = fbo.getDirChildrenPage(ctx, dir, after, max)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the first page can tell whether the directory looks
+	// empty.
+	if after == "" && len(retChildren) == 0 &&
+		dir.ShouldRetryOnDirRead(ctx) {
+		err2 := fbo.SyncFromServer(ctx, fbo.folderBranch, nil)
+		if err2 != nil {
+			fbo.log.CDebugf(ctx, "Error syncing before retry: %+v", err2)
+			return nil, nil
+		}
+
+		fbo.log.CDebugf(ctx, "Retrying GetDirChildrenPage of an empty directory")
+		err = runUnlessCanceled(ctx, func() error {
+			retChildren, err = fbo.getDirChildrenPage(ctx, dir, after, max)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return retChildren, nil
+}
+
 func (fbo *folderBranchOps) makeFakeDirEntry(
 	ctx context.Context, lState *lockState, dir Node, name string) (
 	de DirEntry, err error) {
@@ -2204,9 +2464,34 @@ func (fbo *folderBranchOps) Lookup(ctx context.Context, dir Node, name string) (
 	if err != nil {
 		return nil, EntryInfo{}, err
 	}
+	fbo.evictNodesIfNeeded(ctx)
 	return n, de.EntryInfo, nil
 }
 
+// evictNodesIfNeeded asks the observers that hold on to Nodes to
+// release the least recently used ones that aren't pinned, if the
+// node cache has grown past its limit.  It's called after lookups,
+// which are how mounts add entries to the cache.
+func (fbo *folderBranchOps) evictNodesIfNeeded(ctx context.Context) {
+	changes := fbo.nodeCache.EvictionCandidates()
+	if len(changes) == 0 {
+		return
+	}
+	fbo.log.CDebugf(ctx, "Node cache is over its limit; asking to evict "+
+		"entries from %d directories", len(changes))
+	fbo.observers.evictNodes(ctx, changes)
+}
+
+// PinNode implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) PinNode(_ context.Context, node Node) {
+	fbo.nodeCache.Pin(node)
+}
+
+// UnpinNode implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) UnpinNode(_ context.Context, node Node) {
+	fbo.nodeCache.Unpin(node)
+}
+
 // statEntry is like Stat, but it returns a DirEntry. This is used by
 // tests.
 func (fbo *folderBranchOps) statEntry(ctx context.Context, node Node) (
@@ -2323,6 +2608,31 @@ func (fbo *folderBranchOps) GetNodeMetadata(ctx context.Context, node Node) (
 	return res, nil
 }
 
+func (fbo *folderBranchOps) GetCanonicalPath(ctx context.Context, node Node) (
+	string, error) {
+	p, err := fbo.pathFromNodeForRead(node)
+	if err != nil {
+		return "", err
+	}
+	return p.CanonicalPathString(), nil
+}
+
+// GetOpenFolders implements the KBFSOps interface for
+// folderBranchOps, by returning its own folder-branch once it has a
+// head.
+func (fbo *folderBranchOps) GetOpenFolders(
+	ctx context.Context) map[Favorite]FolderBranch {
+	lState := makeFBOLockState()
+	fbo.headLock.RLock(lState)
+	defer fbo.headLock.RUnlock(lState)
+	if fbo.head == (ImmutableRootMetadata{}) {
+		return nil
+	}
+	return map[Favorite]FolderBranch{
+		fbo.head.GetTlfHandle().ToFavorite(): fbo.folderBranch,
+	}
+}
+
 // blockPutState is an internal structure to track data when putting blocks
 type blockPutState struct {
 	blockStates []blockState
@@ -2982,6 +3292,13 @@ const (
 )
 
 func checkDisallowedPrefixes(ctx context.Context, name string) error {
+	if name == KBFSIgnoreFileName || name == ExpiryPolicyFileName ||
+		name == HistoryRetentionFileName ||
+		name == FileFlagsFileName || name == TlfSettingsFileName ||
+		name == SparseSyncFileName {
+		// These config files are meant to be written by users.
+		return nil
+	}
 	for _, prefix := range disallowedPrefixes {
 		if strings.HasPrefix(name, prefix) {
 			if allowedName := ctx.Value(CtxAllowNameKey); allowedName != nil {
@@ -3121,7 +3438,10 @@ func (fbo *folderBranchOps) createEntryLocked(
 			Children: make(map[string]DirEntry),
 		}
 	} else {
-		newBlock = &FileBlock{}
+		newBlock = &FileBlock{
+			SizeClass: newFileBlockSizeClass(
+				fbo.config.BlockSplitter(), name),
+		}
 	}
 
 	// Cache update and operations until batch happens.  Make a new
@@ -3436,6 +3756,16 @@ func (fbo *folderBranchOps) CreateFile(
 		entryType = File
 	}
 
+	dirPath := fbo.nodeCache.PathFromNode(dir)
+	err = fbo.checkAdminOnlyEdit(ctx, dirPath, path, true)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	err = fbo.checkDLP(ctx, dirPath, path, 0)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+
 	// If journaling is turned on, an exclusive create may end up on a
 	// conflict branch.
 	if excl == WithExcl && TLFJournalEnabled(fbo.config, fbo.id()) {
@@ -3475,6 +3805,17 @@ func (fbo *folderBranchOps) CreateFile(
 func (fbo *folderBranchOps) notifyAndSyncOrSignal(
 	ctx context.Context, lState *lockState, undoFn dirCacheUndoFn,
 	nodesToDirty []Node, op op, md ReadOnlyRootMetadata) (err error) {
+	return fbo.notifyAndMaybeSyncOrSignal(
+		ctx, lState, undoFn, nodesToDirty, op, md, true)
+}
+
+// notifyAndMaybeSyncOrSignal is like notifyAndSyncOrSignal, but if
+// `syncOrSignal` is false, it leaves the op cached until the caller
+// syncs.
+func (fbo *folderBranchOps) notifyAndMaybeSyncOrSignal(
+	ctx context.Context, lState *lockState, undoFn dirCacheUndoFn,
+	nodesToDirty []Node, op op, md ReadOnlyRootMetadata,
+	syncOrSignal bool) (err error) {
 	fbo.dirOps = append(fbo.dirOps, cachedDirOp{op, nodesToDirty})
 	var addedNodes []Node
 	for _, n := range nodesToDirty {
@@ -3504,9 +3845,45 @@ func (fbo *folderBranchOps) notifyAndSyncOrSignal(
 		return err
 	}
 
+	if !syncOrSignal {
+		return nil
+	}
 	return fbo.syncDirUpdateOrSignal(ctx, lState)
 }
 
+// notifySetAttrAndSyncOrSignal is like notifyAndSyncOrSignal, but
+// if an unsynced op already records the same attribute change to the
+// same entry, it only sends the notification.  The new value is
+// already in the cached dir entry, so the earlier op covers it, and
+// repeated setattrs on a file don't fill up the batch of dir ops.
+func (fbo *folderBranchOps) notifySetAttrAndSyncOrSignal(
+	ctx context.Context, lState *lockState, undoFn dirCacheUndoFn,
+	file Node, sao *setAttrOp, md ReadOnlyRootMetadata) (err error) {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	pending := false
+	for _, dop := range fbo.dirOps {
+		prev, ok := dop.dirOp.(*setAttrOp)
+		if ok && prev.Attr == sao.Attr && prev.Name == sao.Name &&
+			prev.Dir.Unref == sao.Dir.Unref &&
+			len(dop.nodes) == 1 && dop.nodes[0].GetID() == file.GetID() {
+			pending = true
+			break
+		}
+	}
+	if !pending {
+		return fbo.notifyAndSyncOrSignal(
+			ctx, lState, undoFn, []Node{file}, sao, md)
+	}
+
+	defer func() {
+		if err != nil && undoFn != nil {
+			undoFn(lState)
+		}
+	}()
+	return fbo.notifyOneOp(ctx, lState, sao, md, false)
+}
+
 func (fbo *folderBranchOps) createLinkLocked(
 	ctx context.Context, lState *lockState, dir Node, fromName string,
 	toPath string) (DirEntry, error) {
@@ -3645,6 +4022,20 @@ func (fbo *folderBranchOps) unrefEntryLocked(ctx context.Context,
 		} else if err != nil {
 			return err
 		}
+		// If the file is dirty, its dirtied blocks have no encoded
+		// size yet, and some of them have never been put to the
+		// server.  Unreference the synced blocks they replaced
+		// instead.
+		if dirtyUnrefs := fbo.blocks.GetDirtyFileUnrefs(
+			lState, childPath); dirtyUnrefs != nil {
+			syncedInfos := dirtyUnrefs
+			for _, blockInfo := range blockInfos {
+				if blockInfo.EncodedSize != 0 {
+					syncedInfos = append(syncedInfos, blockInfo)
+				}
+			}
+			blockInfos = syncedInfos
+		}
 		fbo.prepper.cacheBlockInfos(blockInfos)
 		for _, blockInfo := range blockInfos {
 			unrefsToAdd[blockInfo.BlockPointer] = true
@@ -3673,6 +4064,16 @@ func (fbo *folderBranchOps) unrefEntryLocked(ctx context.Context,
 func (fbo *folderBranchOps) removeEntryLocked(ctx context.Context,
 	lState *lockState, md ReadOnlyRootMetadata, dir Node, dirPath path,
 	name string) error {
+	return fbo.removeEntryLockedMaybeSync(
+		ctx, lState, md, dir, dirPath, name, true)
+}
+
+// removeEntryLockedMaybeSync is like removeEntryLocked, but if
+// `syncOrSignal` is false, the removal stays cached until the caller
+// syncs.
+func (fbo *folderBranchOps) removeEntryLockedMaybeSync(ctx context.Context,
+	lState *lockState, md ReadOnlyRootMetadata, dir Node, dirPath path,
+	name string, syncOrSignal bool) error {
 	fbo.mdWriterLock.AssertLocked(lState)
 
 	if err := fbo.checkForUnlinkedDir(dir); err != nil {
@@ -3723,8 +4124,8 @@ func (fbo *folderBranchOps) removeEntryLocked(ctx context.Context,
 			}
 		}
 	}
-	return fbo.notifyAndSyncOrSignal(
-		ctx, lState, dirCacheUndoFn, []Node{dir}, ro, md.ReadOnly())
+	return fbo.notifyAndMaybeSyncOrSignal(ctx, lState, dirCacheUndoFn,
+		[]Node{dir}, ro, md.ReadOnly(), syncOrSignal)
 }
 
 func (fbo *folderBranchOps) removeDirLocked(ctx context.Context,
@@ -3813,6 +4214,12 @@ func (fbo *folderBranchOps) RemoveEntry(ctx context.Context, dir Node,
 		return err
 	}
 
+	err = fbo.checkAdminOnlyEdit(
+		ctx, fbo.nodeCache.PathFromNode(dir), name, false)
+	if err != nil {
+		return err
+	}
+
 	return fbo.doMDWriteWithRetryUnlessCanceled(ctx,
 		func(lState *lockState) error {
 			// Verify we have permission to write (but no need to make
@@ -3872,6 +4279,14 @@ func (fbo *folderBranchOps) renameLocked(
 		return err
 	}
 
+	if oldParent.GetID() == newParent.GetID() && oldName == newName {
+		// Renaming an entry onto itself is a no-op.  Bail out now,
+		// since the "replaced" entry is the entry itself, and its
+		// blocks must not be unreferenced below.
+		fbo.log.CDebugf(ctx, "Ignoring rename of %s onto itself", oldName)
+		return nil
+	}
+
 	// does name exist?
 	if replacedDe.IsInitialized() {
 		// Usually higher-level programs check these, but just in case.
@@ -3942,6 +4357,26 @@ func (fbo *folderBranchOps) Rename(
 		return err
 	}
 
+	oldParentPath := fbo.nodeCache.PathFromNode(oldParent)
+	newParentPath := fbo.nodeCache.PathFromNode(newParent)
+	err = fbo.checkAdminOnlyEdit(ctx, oldParentPath, oldName, false)
+	if err != nil {
+		return err
+	}
+	err = fbo.checkAdminOnlyEdit(ctx, newParentPath, newName, false)
+	if err != nil {
+		return err
+	}
+
+	// Renaming a file can give it a name that's not allowed.
+	_, ei, err := fbo.Lookup(ctx, oldParent, oldName)
+	if err == nil && (ei.Type == File || ei.Type == Exec) {
+		err = fbo.checkDLP(ctx, newParentPath, newName, ei.Size)
+	}
+	if err != nil {
+		return err
+	}
+
 	return fbo.doMDWriteWithRetryUnlessCanceled(ctx,
 		func(lState *lockState) error {
 			// only works for paths within the same topdir
@@ -4001,6 +4436,10 @@ func (fbo *folderBranchOps) Read(
 				}
 			}
 		}
+
+		// Only fetch the blocks of sparsely-synced files that are
+		// actually read.
+		fbo.markSparseSynced(filePath)
 	}
 
 	// Don't let the goroutine below write directly to the return
@@ -4021,11 +4460,19 @@ func (fbo *folderBranchOps) Read(
 		// could change until we take `blockLock` for reading.
 		bytesRead, err = fbo.blocks.Read(
 			ctx, lState, md.ReadOnly(), file, dest, off)
-		return err
+		if err != nil {
+			return err
+		}
+		if off == 0 {
+			fbo.maybePrefetchSiblings(ctx, lState, md.ReadOnly(), file)
+		}
+		return nil
 	})
 	if err != nil {
 		return 0, err
 	}
+	fbo.recordRecentFile(ctx, file, false)
+	fbo.recordAccess(ctx, file)
 	return bytesRead, nil
 }
 
@@ -4043,6 +4490,12 @@ func (fbo *folderBranchOps) Write(
 		return err
 	}
 
+	err = fbo.checkDLPForFile(
+		ctx, file, uint64(off), uint64(off)+uint64(len(data)))
+	if err != nil {
+		return err
+	}
+
 	return runUnlessCanceled(ctx, func() error {
 		lState := makeFBOLockState()
 
@@ -4062,6 +4515,7 @@ func (fbo *folderBranchOps) Write(
 
 		fbo.status.addDirtyNode(file)
 		fbo.signalWrite()
+		fbo.recordRecentFile(ctx, file, true)
 		return nil
 	})
 }
@@ -4079,6 +4533,11 @@ func (fbo *folderBranchOps) Truncate(
 		return err
 	}
 
+	err = fbo.checkDLPForFile(ctx, file, size, size)
+	if err != nil {
+		return err
+	}
+
 	return runUnlessCanceled(ctx, func() error {
 		lState := makeFBOLockState()
 
@@ -4098,6 +4557,7 @@ func (fbo *folderBranchOps) Truncate(
 
 		fbo.status.addDirtyNode(file)
 		fbo.signalWrite()
+		fbo.recordRecentFile(ctx, file, true)
 		return nil
 	})
 }
@@ -4172,8 +4632,8 @@ func (fbo *folderBranchOps) setExLocked(
 	if err != nil {
 		return err
 	}
-	return fbo.notifyAndSyncOrSignal(
-		ctx, lState, dirCacheUndoFn, []Node{file}, sao, md.ReadOnly())
+	return fbo.notifySetAttrAndSyncOrSignal(
+		ctx, lState, dirCacheUndoFn, file, sao, md.ReadOnly())
 }
 
 func (fbo *folderBranchOps) SetEx(
@@ -4220,6 +4680,13 @@ func (fbo *folderBranchOps) setMtimeLocked(
 	if err != nil {
 		return err
 	}
+	if de.Mtime == mtime.UnixNano() {
+		// Like with setex, skipping the ctime update is a POSIX
+		// violation, but it keeps timestamp-preserving rsyncs and
+		// untars from rewriting every directory.
+		fbo.log.CDebugf(ctx, "Ignoring no-op setmtime")
+		return nil
+	}
 	de.Mtime = mtime.UnixNano()
 	// setting the mtime counts as changing the file MD, so must set ctime too
 	de.Ctime = fbo.nowUnixNano()
@@ -4249,8 +4716,8 @@ func (fbo *folderBranchOps) setMtimeLocked(
 	if err != nil {
 		return err
 	}
-	return fbo.notifyAndSyncOrSignal(
-		ctx, lState, dirCacheUndoFn, []Node{file}, sao, md.ReadOnly())
+	return fbo.notifySetAttrAndSyncOrSignal(
+		ctx, lState, dirCacheUndoFn, file, sao, md.ReadOnly())
 }
 
 func (fbo *folderBranchOps) SetMtime(
@@ -4393,6 +4860,11 @@ func (fbo *folderBranchOps) syncAllLocked(
 		return err
 	}
 
+	err = fbo.scanDirtyFilesLocked(ctx, lState, md.ReadOnly(), dirtyFiles)
+	if err != nil {
+		return err
+	}
+
 	bps := newBlockPutState(0)
 	resolvedPaths := make(map[BlockPointer]path)
 	lbc := make(localBcache)
@@ -4718,6 +5190,10 @@ func (fbo *folderBranchOps) syncAllLocked(
 	}()
 
 	// Put all the blocks.
+	err = fbo.ensureDataRegionLoaded(ctx)
+	if err != nil {
+		return err
+	}
 	blocksToRemove, err = doBlockPuts(ctx, fbo.config.BlockServer(),
 		fbo.config.BlockCache(), fbo.config.Reporter(), fbo.log, fbo.deferLog, md.TlfID(),
 		md.GetTlfHandle().GetCanonicalName(), *bps)
@@ -5311,6 +5787,7 @@ func (fbo *folderBranchOps) applyMDUpdatesLocked(ctx context.Context,
 		if err := isReadableOrError(ctx, fbo.config.KBPKI(), rmd.ReadOnly()); err != nil {
 			return err
 		}
+		fbo.checkLegalHoldLocked(ctx, lState, rmd)
 
 		err := fbo.setHeadSuccessorLocked(ctx, lState, rmd, false)
 		if err != nil {
@@ -7147,6 +7624,42 @@ func (fbo *folderBranchOps) ForceFastForward(ctx context.Context) {
 	}()
 }
 
+// FolderUpdated implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) FolderUpdated(
+	ctx context.Context, tlfID tlf.ID, rev kbfsmd.Revision) (err error) {
+	fbo.log.CDebugf(ctx, "FolderUpdated %d", rev)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "FolderUpdated %d done: %+v", rev, err)
+	}()
+
+	fb := FolderBranch{tlfID, MasterBranch}
+	if fb != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, fb}
+	}
+
+	lState := makeFBOLockState()
+	if fbo.getTrustedHead(lState) == (ImmutableRootMetadata{}) {
+		// Nothing has been loaded yet, so the next access will get
+		// the newest revision anyway.
+		return nil
+	}
+	if fbo.isUnmerged(lState) {
+		// Conflict resolution will pick up the new revision.
+		return nil
+	}
+	if fbo.getLatestMergedRevision(lState) >= rev {
+		fbo.log.CDebugf(ctx, "Already up-to-date")
+		return nil
+	}
+
+	// Getting and applying the updates requires holding locks, so
+	// make sure it doesn't take too long.
+	ctx, cancel := context.WithTimeout(ctx, backgroundTaskTimeout)
+	defer cancel()
+	return fbo.getAndApplyMDUpdates(ctx, lState, nil, fbo.applyMDUpdates)
+}
+
 // InvalidateNodeAndChildren implements the KBFSOps interface for
 // folderBranchOps.
 func (fbo *folderBranchOps) InvalidateNodeAndChildren(
@@ -7338,6 +7851,8 @@ func (fbo *folderBranchOps) handleEditActivity(
 		if err != nil {
 			return nil, nil, nil, err
 		}
+		fbo.sendWebhooks(ctx, name, a.message)
+		fbo.config.EventRuleEngine().editMessageReceived(ctx, name, a.message)
 	} else {
 		fbo.log.CDebugf(ctx, "New edit channel for %s", name)
 		nextPage := fbo.getEditMessages(ctx, a.convID, name, nil)
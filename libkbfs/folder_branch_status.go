@@ -22,6 +22,7 @@ type FolderBranchStatus struct {
 	Staged              bool
 	BranchID            string
 	HeadWriter          kbname.NormalizedUsername
+	HeadDevice          string
 	DiskUsage           uint64
 	RekeyPending        bool
 	LatestKeyGeneration kbfsmd.KeyGen
@@ -31,6 +32,8 @@ type FolderBranchStatus struct {
 	MDVersion           kbfsmd.MetadataVer
 	RootBlockID         string
 	SyncEnabled         bool
+	WriteThrough        bool
+	DataRegion          string `json:",omitempty"`
 	PrefetchStatus      string
 	UsageBytes          int64
 	ArchiveBytes        int64
@@ -43,6 +46,10 @@ type FolderBranchStatus struct {
 	// They do not represent unstaged changes in your local instance.
 	DirtyPaths []string
 
+	// NodeCache summarizes the in-memory nodes held for this
+	// folder-branch.
+	NodeCache NodeCacheStats
+
 	// If we're in the staged state, these summaries show the
 	// diverging operations per-file
 	Unmerged []*crChainSummary
@@ -57,17 +64,18 @@ type FolderBranchStatus struct {
 // suitable for encoding directly as JSON.
 // TODO: implement magical status update like FolderBranchStatus
 type KBFSStatus struct {
-	CurrentUser     string
-	IsConnected     bool
-	UsageBytes      int64
-	ArchiveBytes    int64
-	LimitBytes      int64
-	GitUsageBytes   int64
-	GitArchiveBytes int64
-	GitLimitBytes   int64
-	FailingServices map[string]error
-	JournalServer   *JournalServerStatus            `json:",omitempty"`
-	DiskCacheStatus map[string]DiskBlockCacheStatus `json:",omitempty"`
+	CurrentUser       string
+	IsConnected       bool
+	UsageBytes        int64
+	ArchiveBytes      int64
+	LimitBytes        int64
+	GitUsageBytes     int64
+	GitArchiveBytes   int64
+	GitLimitBytes     int64
+	FailingServices   map[string]error
+	JournalServer     *JournalServerStatus            `json:",omitempty"`
+	DiskCacheStatus   map[string]DiskBlockCacheStatus `json:",omitempty"`
+	DeviceConstraints DeviceConstraintsStatus
 }
 
 // StatusUpdate is a dummy type used to indicate status has been updated.
@@ -168,12 +176,23 @@ func (fbsk *folderBranchStatusKeeper) rmNode(m map[NodeID]Node, n Node) bool {
 	return true
 }
 
+// addDirtyNode also pins the node in the node cache, so that it can
+// still be found by reference until it is flushed, even if it gets
+// unlinked in the meantime.
 func (fbsk *folderBranchStatusKeeper) addDirtyNode(n Node) bool {
-	return fbsk.addNode(fbsk.dirtyNodes, n)
+	added := fbsk.addNode(fbsk.dirtyNodes, n)
+	if added {
+		fbsk.nodeCache.Pin(n)
+	}
+	return added
 }
 
 func (fbsk *folderBranchStatusKeeper) rmDirtyNode(n Node) bool {
-	return fbsk.rmNode(fbsk.dirtyNodes, n)
+	removed := fbsk.rmNode(fbsk.dirtyNodes, n)
+	if removed {
+		fbsk.nodeCache.Unpin(n)
+	}
+	return removed
 }
 
 // dataMutex should be taken by the caller
@@ -186,6 +205,19 @@ func (fbsk *folderBranchStatusKeeper) convertNodesToPathsLocked(
 	return ret
 }
 
+// headDeviceName returns the name of the device that wrote the
+// current head, falling back to its verifying key.
+func (fbsk *folderBranchStatusKeeper) headDeviceName(
+	ctx context.Context) string {
+	key := fbsk.md.LastModifyingWriterVerifyingKey()
+	ui, err := fbsk.config.KeybaseService().LoadUserPlusKeys(
+		ctx, fbsk.md.LastModifyingWriter(), "")
+	if err == nil && ui.KIDNames[key.KID()] != "" {
+		return ui.KIDNames[key.KID()]
+	}
+	return key.String()
+}
+
 func (fbsk *folderBranchStatusKeeper) getStatusWithoutJournaling(
 	ctx context.Context) (
 	FolderBranchStatus, <-chan StatusUpdate, tlf.ID, error) {
@@ -207,6 +239,7 @@ func (fbsk *folderBranchStatusKeeper) getStatusWithoutJournaling(
 			return FolderBranchStatus{}, nil, tlf.NullID, err
 		}
 		fbs.HeadWriter = name
+		fbs.HeadDevice = fbsk.headDeviceName(ctx)
 		fbs.DiskUsage = fbsk.md.DiskUsage()
 		fbs.RekeyPending = fbsk.config.RekeyQueue().IsRekeyPending(fbsk.md.TlfID())
 		fbs.LatestKeyGeneration = fbsk.md.LatestKeyGeneration()
@@ -215,6 +248,8 @@ func (fbsk *folderBranchStatusKeeper) getStatusWithoutJournaling(
 		fbs.LastGCRevision = fbsk.md.data.LastGCRevision
 		fbs.MDVersion = fbsk.md.Version()
 		fbs.SyncEnabled = fbsk.config.IsSyncedTlf(fbsk.md.TlfID())
+		fbs.WriteThrough = fbsk.config.IsWriteThroughTlf(fbsk.md.TlfID())
+		fbs.DataRegion, _ = fbsk.config.TlfDataRegion(fbsk.md.TlfID())
 		prefetchStatus := fbsk.config.PrefetchStatus(ctx, fbsk.md.TlfID(),
 			fbsk.md.Data().Dir.BlockPointer)
 		fbs.PrefetchStatus = prefetchStatus.String()
@@ -256,6 +291,7 @@ func (fbsk *folderBranchStatusKeeper) getStatusWithoutJournaling(
 	}
 
 	fbs.DirtyPaths = fbsk.convertNodesToPathsLocked(fbsk.dirtyNodes)
+	fbs.NodeCache = fbsk.nodeCache.Stats()
 
 	fbs.Unmerged = fbsk.unmerged
 	fbs.Merged = fbsk.merged
@@ -31,6 +31,9 @@ func fbStatusTestInit(t *testing.T) (*gomock.Controller, *ConfigMock,
 		gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes().
 		Return(ImplicitTeamInfo{}, errors.New("No such team"))
 	nodeCache := NewMockNodeCache(mockCtrl)
+	nodeCache.EXPECT().Pin(gomock.Any()).AnyTimes()
+	nodeCache.EXPECT().Unpin(gomock.Any()).AnyTimes()
+	nodeCache.EXPECT().Stats().AnyTimes().Return(NodeCacheStats{})
 	fbsk := newFolderBranchStatusKeeper(config, nodeCache)
 	interposeDaemonKBPKI(config, "alice", "bob")
 	return mockCtrl, config, fbsk, nodeCache
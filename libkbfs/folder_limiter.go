@@ -0,0 +1,133 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sync"
+
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
+)
+
+// FolderLimits caps how much of KBFS's work each TLF can use, so
+// that when KBFS is embedded in a service like a web gateway, one hot
+// folder can't starve all the others.  Each limit applies to every
+// TLF separately; zero means unlimited.
+type FolderLimits struct {
+	// OpsPerSecond is the rate of KBFSOps calls on a TLF.
+	OpsPerSecond float64
+	// BytesPerSecond is the rate of bytes read from and written
+	// to the files of a TLF.
+	BytesPerSecond int64
+	// MaxConcurrentOps is the number of KBFSOps calls on a TLF
+	// that can run at once.
+	MaxConcurrentOps int
+}
+
+func (l FolderLimits) isUnlimited() bool {
+	return l.OpsPerSecond <= 0 && l.BytesPerSecond <= 0 &&
+		l.MaxConcurrentOps <= 0
+}
+
+// tlfLimiter holds the limiters of one TLF; each is nil if its limit
+// is unlimited.
+type tlfLimiter struct {
+	ops     *rate.Limiter
+	bytes   *rate.Limiter
+	ongoing *OngoingWorkLimiter
+}
+
+// folderLimiter enforces FolderLimits for KBFSOpsStandard.  A nil
+// *folderLimiter doesn't limit anything.
+type folderLimiter struct {
+	limits FolderLimits
+
+	lock sync.Mutex
+	tlfs map[tlf.ID]*tlfLimiter
+}
+
+func newFolderLimiter(limits FolderLimits) *folderLimiter {
+	return &folderLimiter{
+		limits: limits,
+		tlfs:   make(map[tlf.ID]*tlfLimiter),
+	}
+}
+
+func (fl *folderLimiter) getTlfLimiter(tlfID tlf.ID) *tlfLimiter {
+	fl.lock.Lock()
+	defer fl.lock.Unlock()
+	tl, ok := fl.tlfs[tlfID]
+	if ok {
+		return tl
+	}
+	tl = &tlfLimiter{}
+	if fl.limits.OpsPerSecond > 0 {
+		burst := int(fl.limits.OpsPerSecond)
+		if burst < 1 {
+			burst = 1
+		}
+		tl.ops = rate.NewLimiter(rate.Limit(fl.limits.OpsPerSecond), burst)
+	}
+	if fl.limits.BytesPerSecond > 0 {
+		// Allow up to a second's worth of bytes at once.
+		tl.bytes = rate.NewLimiter(rate.Limit(fl.limits.BytesPerSecond),
+			int(fl.limits.BytesPerSecond))
+	}
+	if fl.limits.MaxConcurrentOps > 0 {
+		tl.ongoing = NewOngoingWorkLimiter(fl.limits.MaxConcurrentOps)
+	}
+	fl.tlfs[tlfID] = tl
+	return tl
+}
+
+// waitBytes waits until `limiter` allows `n` bytes, which may be more
+// than its burst size.
+func waitBytes(ctx context.Context, limiter *rate.Limiter, n int64) error {
+	burst := int64(limiter.Burst())
+	for n > 0 {
+		chunk := n
+		if chunk > burst {
+			chunk = burst
+		}
+		err := limiter.WaitN(ctx, int(chunk))
+		if err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// begin waits until the limits allow another operation on `tlfID`,
+// which reads or writes `bytes` bytes of file data, to start.  On
+// success, it returns a function to call when the operation is done.
+func (fl *folderLimiter) begin(
+	ctx context.Context, tlfID tlf.ID, bytes int64) (func(), error) {
+	if fl == nil {
+		return func() {}, nil
+	}
+	tl := fl.getTlfLimiter(tlfID)
+	if tl.ops != nil {
+		err := tl.ops.Wait(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if tl.bytes != nil && bytes > 0 {
+		err := waitBytes(ctx, tl.bytes, bytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if tl.ongoing == nil {
+		return func() {}, nil
+	}
+	err := tl.ongoing.WaitToStart(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return tl.ongoing.Done, nil
+}
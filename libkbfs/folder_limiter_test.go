@@ -0,0 +1,86 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestFolderLimiterNil(t *testing.T) {
+	var fl *folderLimiter
+	done, err := fl.begin(context.Background(), tlf.FakeID(1, tlf.Public), 1)
+	require.NoError(t, err)
+	done()
+}
+
+func TestFolderLimiterConcurrentOps(t *testing.T) {
+	fl := newFolderLimiter(FolderLimits{MaxConcurrentOps: 1})
+	hot := tlf.FakeID(1, tlf.Public)
+	other := tlf.FakeID(2, tlf.Public)
+
+	done, err := fl.begin(context.Background(), hot, 0)
+	require.NoError(t, err)
+
+	t.Log("A second op on the same folder has to wait.")
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = fl.begin(ctx, hot, 0)
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	t.Log("Other folders aren't affected.")
+	otherDone, err := fl.begin(context.Background(), other, 0)
+	require.NoError(t, err)
+	otherDone()
+
+	done()
+	done, err = fl.begin(context.Background(), hot, 0)
+	require.NoError(t, err)
+	done()
+}
+
+func TestFolderLimiterOpRate(t *testing.T) {
+	fl := newFolderLimiter(FolderLimits{OpsPerSecond: 1})
+	hot := tlf.FakeID(1, tlf.Public)
+	other := tlf.FakeID(2, tlf.Public)
+
+	done, err := fl.begin(context.Background(), hot, 0)
+	require.NoError(t, err)
+	done()
+
+	t.Log("The next op would exceed the op rate.")
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = fl.begin(ctx, hot, 0)
+	require.Error(t, err)
+
+	t.Log("Other folders have their own rates.")
+	done, err = fl.begin(context.Background(), other, 0)
+	require.NoError(t, err)
+	done()
+}
+
+func TestFolderLimiterByteRate(t *testing.T) {
+	fl := newFolderLimiter(FolderLimits{BytesPerSecond: 1000})
+	tlfID := tlf.FakeID(1, tlf.Public)
+
+	t.Log("Reading more than a second's worth of bytes waits " +
+		"rather than fails.")
+	done, err := fl.begin(context.Background(), tlfID, 1100)
+	require.NoError(t, err)
+	done()
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = fl.begin(ctx, tlfID, 500)
+	require.Error(t, err)
+}
@@ -0,0 +1,183 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sync"
+
+	kbname "github.com/keybase/client/go/kbun"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfsmd"
+	"golang.org/x/net/context"
+)
+
+// FolderStats holds aggregate statistics about a folder, as of its
+// most recent revision.  Changes that haven't been synced yet aren't
+// included.
+type FolderStats struct {
+	// Files is the number of regular and executable files.
+	Files int64
+	// Dirs is the number of directories, not counting the root.
+	Dirs int64
+	// Symlinks is the number of symbolic links.
+	Symlinks int64
+	// TotalBytes is the sum of the logical sizes of all the files.
+	TotalBytes uint64
+	// Revision is the revision the stats were computed from.
+	Revision kbfsmd.Revision
+	// LastWriter is the user who wrote that revision.
+	LastWriter kbname.NormalizedUsername
+	// Staged is true if the folder has local changes that conflict
+	// with the merged branch and haven't been resolved yet.
+	Staged bool
+	// BranchID is the ID of the unmerged branch, if Staged is true.
+	BranchID string `json:",omitempty"`
+}
+
+// dirStats are the totals for the subtree under one directory.
+type dirStats struct {
+	files, dirs, symlinks int64
+	bytes                 uint64
+	// children are the block IDs of the subdirectories, used to keep
+	// their cache entries alive.
+	children []kbfsblock.ID
+}
+
+// folderStatsCache remembers the totals for each directory subtree,
+// keyed by the ID of the directory's top block.  Since a change
+// anywhere in a subtree changes the block IDs of all the directories
+// above it, recomputing the stats for a new revision only needs to
+// read the directories along the changed paths.
+type folderStatsCache struct {
+	lock sync.Mutex
+	dirs map[kbfsblock.ID]*dirStats
+}
+
+func newFolderStatsCache() *folderStatsCache {
+	return &folderStatsCache{
+		dirs: make(map[kbfsblock.ID]*dirStats),
+	}
+}
+
+// markLive adds `id`, and all the directories under it, to `live`.
+func (fsc *folderStatsCache) markLive(
+	id kbfsblock.ID, live map[kbfsblock.ID]bool) {
+	if live[id] {
+		return
+	}
+	live[id] = true
+	if ds, ok := fsc.dirs[id]; ok {
+		for _, c := range ds.children {
+			fsc.markLive(c, live)
+		}
+	}
+}
+
+// gcLocked drops the cached totals for directories that are no
+// longer in the tree, once there are many of them.
+func (fsc *folderStatsCache) gcLocked(root kbfsblock.ID) {
+	live := make(map[kbfsblock.ID]bool)
+	fsc.markLive(root, live)
+	if len(fsc.dirs) <= 2*len(live) {
+		return
+	}
+	for id := range fsc.dirs {
+		if !live[id] {
+			delete(fsc.dirs, id)
+		}
+	}
+}
+
+// getDirStatsLocked returns the totals for the directory at `p`,
+// using the cached totals for any unchanged subdirectories.
+func (fbo *folderBranchOps) getDirStatsLocked(
+	ctx context.Context, kmd KeyMetadata, p path) (*dirStats, error) {
+	id := p.tailPointer().ID
+	if ds, ok := fbo.folderStats.dirs[id]; ok {
+		return ds, nil
+	}
+
+	entries, err := fbo.blocks.GetCleanEntries(ctx, kmd, p)
+	if err != nil {
+		return nil, err
+	}
+	ds := &dirStats{}
+	for name, de := range entries {
+		switch de.Type {
+		case File, Exec:
+			ds.files++
+			ds.bytes += de.Size
+		case Sym:
+			ds.symlinks++
+		case Dir:
+			child, err := fbo.getDirStatsLocked(
+				ctx, kmd, p.ChildPath(name, de.BlockPointer))
+			if err != nil {
+				return nil, err
+			}
+			ds.dirs += child.dirs + 1
+			ds.files += child.files
+			ds.symlinks += child.symlinks
+			ds.bytes += child.bytes
+			ds.children = append(ds.children, de.ID)
+		}
+	}
+	fbo.folderStats.dirs[id] = ds
+	return ds, nil
+}
+
+// GetFolderStats implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) GetFolderStats(
+	ctx context.Context, folderBranch FolderBranch) (
+	stats FolderStats, err error) {
+	fbo.log.CDebugf(ctx, "GetFolderStats")
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "GetFolderStats done: %+v", err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return FolderStats{}, WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+
+	lState := makeFBOLockState()
+	md, err := fbo.getMDForReadNeedIdentify(ctx, lState)
+	if err != nil {
+		return FolderStats{}, err
+	}
+
+	rootPtr := md.data.Dir.BlockPointer
+	rootPath := path{
+		FolderBranch: fbo.folderBranch,
+		path: []pathNode{{
+			rootPtr, string(md.GetTlfHandle().GetCanonicalName())}},
+	}
+	fbo.folderStats.lock.Lock()
+	defer fbo.folderStats.lock.Unlock()
+	ds, err := fbo.getDirStatsLocked(ctx, md.ReadOnly(), rootPath)
+	if err != nil {
+		return FolderStats{}, err
+	}
+	fbo.folderStats.gcLocked(rootPtr.ID)
+
+	writer, err := fbo.config.KBPKI().GetNormalizedUsername(
+		ctx, md.LastModifyingWriter().AsUserOrTeam())
+	if err != nil {
+		return FolderStats{}, err
+	}
+	stats = FolderStats{
+		Files:      ds.files,
+		Dirs:       ds.dirs,
+		Symlinks:   ds.symlinks,
+		TotalBytes: ds.bytes,
+		Revision:   md.Revision(),
+		LastWriter: writer,
+		Staged:     md.IsUnmergedSet(),
+	}
+	if stats.Staged {
+		stats.BranchID = md.BID().String()
+	}
+	return stats, nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	kbname "github.com/keybase/client/go/kbun"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetFolderStats(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "test_user")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps()
+	aNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	bNode, _, err := kbfsOps.CreateDir(ctx, aNode, "b")
+	require.NoError(t, err)
+	cNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "c")
+	require.NoError(t, err)
+	f1, _, err := kbfsOps.CreateFile(ctx, bNode, "f1", false, NoExcl)
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps.Write(ctx, f1, []byte("hello"), 0))
+	f2, _, err := kbfsOps.CreateFile(ctx, cNode, "f2", true, NoExcl)
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps.Write(ctx, f2, []byte("world!"), 0))
+	_, err = kbfsOps.CreateLink(ctx, rootNode, "link", "a/b/f1")
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+
+	stats, err := kbfsOps.GetFolderStats(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, FolderStats{
+		Files:      2,
+		Dirs:       3,
+		Symlinks:   1,
+		TotalBytes: 11,
+		Revision:   kbfsmd.RevisionInitial + 1,
+		LastWriter: kbname.NormalizedUsername("test_user"),
+	}, stats)
+
+	t.Log("Unsynced changes aren't included")
+	require.NoError(t, kbfsOps.Write(ctx, f1, []byte(" there"), 5))
+	stats2, err := kbfsOps.GetFolderStats(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, stats, stats2)
+
+	t.Log("Only the changed directories are read again")
+	ops := kbfsOps.(*KBFSOpsStandard).getOpsByNode(ctx, rootNode)
+	cPath, err := ops.pathFromNodeForRead(cNode)
+	require.NoError(t, err)
+	cID := cPath.tailPointer().ID
+	bPath, err := ops.pathFromNodeForRead(bNode)
+	require.NoError(t, err)
+	oldBID := bPath.tailPointer().ID
+	cStats := ops.folderStats.dirs[cID]
+	require.NotNil(t, cStats)
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+
+	stats, err = kbfsOps.GetFolderStats(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, uint64(17), stats.TotalBytes)
+	require.Equal(t, kbfsmd.RevisionInitial+2, stats.Revision)
+	require.True(t, cStats == ops.folderStats.dirs[cID])
+	bPath, err = ops.pathFromNodeForRead(bNode)
+	require.NoError(t, err)
+	require.NotEqual(t, oldBID, bPath.tailPointer().ID)
+	require.Contains(t, ops.folderStats.dirs, bPath.tailPointer().ID)
+
+	t.Log("Removed directories are eventually dropped from the cache")
+	require.NoError(t, kbfsOps.RemoveEntry(ctx, bNode, "f1"))
+	require.NoError(t, kbfsOps.RemoveDir(ctx, aNode, "b"))
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+	stats, err = kbfsOps.GetFolderStats(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), stats.Files)
+	require.Equal(t, int64(2), stats.Dirs)
+	require.Equal(t, uint64(6), stats.TotalBytes)
+	require.NotContains(t, ops.folderStats.dirs, oldBID)
+}
@@ -0,0 +1,58 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"context"
+
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/go-framed-msgpack-rpc/rpc"
+	"github.com/keybase/kbfs/kbfsmd"
+	kbgitkbfs "github.com/keybase/kbfs/protocol/kbgitkbfs1"
+	"github.com/keybase/kbfs/tlf"
+)
+
+// FolderUpdatesRemote tells the main KBFS process running on this
+// device, if any, about folder revisions written by this process.
+type FolderUpdatesRemote struct {
+	kbCtx Context
+	log   logger.Logger
+}
+
+// NewFolderUpdatesRemote creates a new FolderUpdatesRemote.  It only
+// connects to the main KBFS process when there's an update to send.
+func NewFolderUpdatesRemote(
+	kbCtx Context, config logMaker) *FolderUpdatesRemote {
+	return &FolderUpdatesRemote{
+		kbCtx: kbCtx,
+		log:   config.MakeLogger("FUR"),
+	}
+}
+
+// FolderUpdated tells the main KBFS process that revision `rev` of
+// the given TLF was just written.
+func (fur *FolderUpdatesRemote) FolderUpdated(
+	ctx context.Context, tlfID tlf.ID, rev kbfsmd.Revision) (err error) {
+	fur.log.CDebugf(ctx, "FolderUpdatesRemote: FolderUpdated %s %d",
+		tlfID, rev)
+	defer func() {
+		fur.log.CDebugf(ctx, "FolderUpdatesRemote: FolderUpdated %s %d "+
+			"done (err=%+v)", tlfID, rev, err)
+	}()
+
+	conn, xp, _, err := fur.kbCtx.GetKBFSSocket(true)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	cli := rpc.NewClient(xp, KBFSErrorUnwrapper{},
+		libkb.LogTagsFromContext)
+	client := kbgitkbfs.FolderUpdatesClient{Cli: cli}
+	return client.FolderUpdated(ctx, kbgitkbfs.FolderUpdatedArg{
+		TlfID:    tlfID.Bytes(),
+		Revision: int64(rev),
+	})
+}
@@ -0,0 +1,63 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"context"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/kbfsmd"
+	kbgitkbfs "github.com/keybase/kbfs/protocol/kbgitkbfs1"
+	"github.com/keybase/kbfs/tlf"
+)
+
+type folderUpdatesServiceConfig interface {
+	logMaker
+	KBFSOps() KBFSOps
+}
+
+// FolderUpdatesService passes along folder updates made by other
+// processes on this device, like git pushes, to this KBFS instance.
+type FolderUpdatesService struct {
+	config folderUpdatesServiceConfig
+	log    logger.Logger
+}
+
+var _ kbgitkbfs.FolderUpdatesInterface = (*FolderUpdatesService)(nil)
+
+// NewFolderUpdatesService creates a new FolderUpdatesService.
+func NewFolderUpdatesService(
+	config folderUpdatesServiceConfig) *FolderUpdatesService {
+	return &FolderUpdatesService{
+		config: config,
+		log:    config.MakeLogger("FUS"),
+	}
+}
+
+// FolderUpdated implements the FolderUpdatesInterface interface for
+// FolderUpdatesService.
+func (fus *FolderUpdatesService) FolderUpdated(ctx context.Context,
+	arg kbgitkbfs.FolderUpdatedArg) error {
+	tlfID := tlf.ID{}
+	err := tlfID.UnmarshalBinary(arg.TlfID)
+	if err != nil {
+		return err
+	}
+	rev := kbfsmd.Revision(arg.Revision)
+
+	// Don't make the caller wait while the update is fetched; it
+	// might be in the middle of something, like a push, that
+	// shouldn't be held up by this instance.
+	go func() {
+		ctx := CtxWithRandomIDReplayable(
+			context.Background(), CtxFBOIDKey, CtxFBOOpID, fus.log)
+		err := fus.config.KBFSOps().FolderUpdated(ctx, tlfID, rev)
+		if err != nil {
+			fus.log.CDebugf(ctx, "Couldn't fetch revision %d of %s: %+v",
+				rev, tlfID, err)
+		}
+	}()
+	return nil
+}
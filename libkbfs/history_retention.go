@@ -0,0 +1,115 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// HistoryRetentionFileName is the name of a file, at the root of a
+// TLF, that sets how long the data unreferenced by old revisions is
+// kept before quota reclamation collects it, and so how far back
+// archived revisions can be browsed and restored.  The file holds a
+// single duration, like "7d" or "720h", or "forever".
+const HistoryRetentionFileName = ".kbfs_history_retention"
+
+// HistoryRetentionForever is the retention of a folder whose old
+// revisions are never collected.
+const HistoryRetentionForever = time.Duration(math.MaxInt64)
+
+// minHistoryRetention is the shortest retention a folder can set,
+// so that devices still reading a recent revision don't have its
+// blocks collected out from under them.
+const minHistoryRetention = 24 * time.Hour
+
+// maxHistoryRetentionSize caps how much of a retention file is read.
+const maxHistoryRetentionSize = 4 * 1024
+
+// ParseHistoryRetention parses the contents of a history retention
+// file.  Blank lines and lines starting with "#" are ignored.
+// Durations are in the format accepted by time.ParseDuration, or a
+// whole number of days like "30d"; "forever" returns
+// HistoryRetentionForever.  Retentions shorter than a day are
+// rounded up to a day.
+func ParseHistoryRetention(data []byte) (time.Duration, error) {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == "forever" {
+			return HistoryRetentionForever, nil
+		}
+		retention, ok := parsePolicyDuration(line)
+		if !ok {
+			return 0, errors.Errorf("Invalid history retention %q", line)
+		}
+		if retention < minHistoryRetention {
+			retention = minHistoryRetention
+		}
+		return retention, nil
+	}
+	return 0, errors.New("Empty history retention")
+}
+
+// getHistoryRetention returns the retention set by the TLF's
+// settings or, failing that, by its retention file, or 0 if neither
+// sets one.
+func (fbo *folderBranchOps) getHistoryRetention(
+	ctx context.Context) (time.Duration, error) {
+	settings, err := fbo.getTlfSettings(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if retention := settings.GetHistoryRetention(); retention > 0 {
+		return retention, nil
+	}
+
+	rootNode, _, _, err := fbo.getRootNode(ctx)
+	if err != nil {
+		return 0, err
+	}
+	node, ei, err := fbo.Lookup(ctx, rootNode, HistoryRetentionFileName)
+	if _, ok := err.(NoSuchNameError); ok {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if ei.Type == Dir || ei.Type == Sym {
+		return 0, nil
+	}
+
+	size := ei.Size
+	if size > maxHistoryRetentionSize {
+		size = maxHistoryRetentionSize
+	}
+	buf := make([]byte, size)
+	lState := makeFBOLockState()
+	md, err := fbo.getMDForRead(ctx, lState, mdReadNoIdentify)
+	if err != nil {
+		return 0, err
+	}
+	// Read directly from the block layer, so that this internal
+	// read doesn't show up as a recently-accessed file.
+	n, err := fbo.blocks.Read(ctx, lState, md.ReadOnly(), node, buf, 0)
+	if err != nil {
+		return 0, err
+	}
+	retention, err := ParseHistoryRetention(buf[:n])
+	if err != nil {
+		// Don't let a malformed file stop reclamation.
+		fbo.log.CDebugf(ctx, "Ignoring %s: %+v", HistoryRetentionFileName, err)
+		return 0, nil
+	}
+	return retention, nil
+}
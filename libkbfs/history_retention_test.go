@@ -0,0 +1,89 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHistoryRetention(t *testing.T) {
+	retention, err := ParseHistoryRetention([]byte("# keep a week\n\n7d\n"))
+	require.NoError(t, err)
+	require.Equal(t, 7*24*time.Hour, retention)
+
+	retention, err = ParseHistoryRetention([]byte("forever"))
+	require.NoError(t, err)
+	require.Equal(t, HistoryRetentionForever, retention)
+
+	retention, err = ParseHistoryRetention([]byte("1h"))
+	require.NoError(t, err)
+	require.Equal(t, minHistoryRetention, retention)
+
+	for _, bad := range []string{"", "# nothing", "xd", "-1h", "0s", "always"} {
+		_, err = ParseHistoryRetention([]byte(bad))
+		require.Error(t, err, bad)
+	}
+}
+
+func TestHistoryRetention(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	clock, now := newTestClockAndTimeNow()
+	config.SetClock(clock)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps()
+	retentionNode, _, err := kbfsOps.CreateFile(
+		ctx, rootNode, HistoryRetentionFileName, false, NoExcl)
+	require.NoError(t, err)
+	setRetention := func(retention string) {
+		err := kbfsOps.Truncate(ctx, retentionNode, 0)
+		require.NoError(t, err)
+		err = kbfsOps.Write(ctx, retentionNode, []byte(retention), 0)
+		require.NoError(t, err)
+		require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+	}
+
+	t.Log("A retention replaces the default unref age")
+	setRetention("3d")
+	_, _, err = kbfsOps.CreateDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+	err = kbfsOps.RemoveDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+	status, err := kbfsOps.GetQuotaReclamationStatus(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, 3*24*time.Hour, status.HistoryRetention)
+	require.Equal(t, 3*24*time.Hour, status.MinUnrefAge)
+	require.NotZero(t, status.PendingBytes)
+	require.True(t, now.Add(3*24*time.Hour).Equal(status.NextEligibleTime))
+
+	t.Log("Nothing is reclaimable when history is kept forever")
+	setRetention("forever")
+	clock.Set(now.Add(365 * 24 * time.Hour))
+	status, err = kbfsOps.GetQuotaReclamationStatus(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, HistoryRetentionForever, status.MinUnrefAge)
+	require.Equal(t, uint64(0), status.ReclaimableBytes)
+	require.NotZero(t, status.PendingBytes)
+	require.True(t, status.NextEligibleTime.IsZero())
+
+	t.Log("Removing the file restores the default")
+	err = kbfsOps.RemoveEntry(ctx, rootNode, HistoryRetentionFileName)
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+	status, err = kbfsOps.GetQuotaReclamationStatus(ctx, fb)
+	require.NoError(t, err)
+	require.Zero(t, status.HistoryRetention)
+	require.Equal(t, config.Mode().QuotaReclamationMinUnrefAge(),
+		status.MinUnrefAge)
+	require.NotZero(t, status.ReclaimableBytes)
+}
@@ -0,0 +1,188 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// IdentifyBreakResponse is how the user has chosen to respond to the
+// broken proofs of a folder's members.
+type IdentifyBreakResponse int
+
+const (
+	// IdentifyBreakPending means the user hasn't responded yet.  The
+	// folder can still be written to.
+	IdentifyBreakPending IdentifyBreakResponse = iota
+	// IdentifyBreakContinue means the user has acknowledged the
+	// breaks, and keeps using the folder as usual.
+	IdentifyBreakContinue
+	// IdentifyBreakFreeze means writes to the folder are refused
+	// until the breaks are resolved, or until the user continues.
+	IdentifyBreakFreeze
+)
+
+func (r IdentifyBreakResponse) String() string {
+	switch r {
+	case IdentifyBreakPending:
+		return "pending"
+	case IdentifyBreakContinue:
+		return "continue"
+	case IdentifyBreakFreeze:
+		return "freeze"
+	default:
+		return fmt.Sprintf("IdentifyBreakResponse(%d)", int(r))
+	}
+}
+
+// IdentifyBreaksStatus describes the broken proofs of a folder's
+// members, as of the folder's last identify.
+type IdentifyBreaksStatus struct {
+	// Breaks has an entry for each member with broken proofs.
+	Breaks []keybase1.TLFIdentifyFailure
+	// Detected is when a member's proofs were first seen broken,
+	// since the last time none were.
+	Detected time.Time
+	Response IdentifyBreakResponse
+}
+
+// brokenUsernames returns the sorted names of the users in `breaks`
+// that aren't in `old`.
+func brokenUsernames(breaks, old []keybase1.TLFIdentifyFailure) []string {
+	seen := make(map[keybase1.UID]bool, len(old))
+	for _, b := range old {
+		seen[b.User.Uid] = true
+	}
+	var names []string
+	for _, b := range breaks {
+		if !seen[b.User.Uid] {
+			names = append(names, b.User.Username)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// updateIdentifyBreaks records the broken proofs found by a
+// successful identify of the folder with handle `h`.  Members whose
+// proofs newly broke fire proofs_broken events, and need a new
+// response from the user; once no proofs are broken, the folder is
+// no longer frozen.
+func (fbo *folderBranchOps) updateIdentifyBreaks(
+	ctx context.Context, h *TlfHandle, breaks keybase1.TLFBreak) {
+	var broken []keybase1.TLFIdentifyFailure
+	for _, b := range breaks.Breaks {
+		if b.Breaks != nil {
+			broken = append(broken, b)
+		}
+	}
+
+	fbo.identifyBreaksLock.Lock()
+	defer fbo.identifyBreaksLock.Unlock()
+	old := fbo.identifyBreaks
+	if len(broken) == 0 {
+		if old != nil {
+			fbo.log.CDebugf(ctx, "Broken proofs resolved")
+			fbo.identifyBreaks = nil
+		}
+		return
+	}
+
+	var oldBreaks []keybase1.TLFIdentifyFailure
+	if old != nil {
+		oldBreaks = old.Breaks
+	}
+	newlyBroken := brokenUsernames(broken, oldBreaks)
+	if len(newlyBroken) == 0 {
+		old.Breaks = broken
+		return
+	}
+
+	fbo.log.CDebugf(ctx, "Newly broken proofs for %v", newlyBroken)
+	status := &IdentifyBreaksStatus{
+		Breaks:   broken,
+		Detected: fbo.config.Clock().Now(),
+	}
+	if old != nil {
+		status.Detected = old.Detected
+		if old.Response == IdentifyBreakFreeze {
+			// Stay frozen while the user decides again.
+			status.Response = IdentifyBreakFreeze
+		}
+	}
+	fbo.identifyBreaks = status
+	fbo.config.EventRuleEngine().proofsBroken(ctx, h, newlyBroken)
+}
+
+// checkIdentifyBreaksFrozen returns an error if the user froze writes
+// to this folder because of its members' broken proofs.
+func (fbo *folderBranchOps) checkIdentifyBreaksFrozen() error {
+	fbo.identifyBreaksLock.RLock()
+	defer fbo.identifyBreaksLock.RUnlock()
+	if fbo.identifyBreaks == nil ||
+		fbo.identifyBreaks.Response != IdentifyBreakFreeze {
+		return nil
+	}
+	return FolderFrozenError{
+		fbo.folderBranch.Tlf,
+		brokenUsernames(fbo.identifyBreaks.Breaks, nil),
+	}
+}
+
+// GetIdentifyBreaks implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) GetIdentifyBreaks(
+	ctx context.Context, folderBranch FolderBranch) (
+	status IdentifyBreaksStatus, ok bool, err error) {
+	if folderBranch != fbo.folderBranch {
+		return IdentifyBreaksStatus{}, false,
+			WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+
+	fbo.identifyBreaksLock.RLock()
+	defer fbo.identifyBreaksLock.RUnlock()
+	if fbo.identifyBreaks == nil {
+		return IdentifyBreaksStatus{}, false, nil
+	}
+	status = *fbo.identifyBreaks
+	status.Breaks = append(
+		[]keybase1.TLFIdentifyFailure(nil), fbo.identifyBreaks.Breaks...)
+	return status, true, nil
+}
+
+// RespondToIdentifyBreaks implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) RespondToIdentifyBreaks(
+	ctx context.Context, folderBranch FolderBranch,
+	response IdentifyBreakResponse) (err error) {
+	fbo.log.CDebugf(ctx, "RespondToIdentifyBreaks %s", response)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "RespondToIdentifyBreaks done: %+v", err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+	switch response {
+	case IdentifyBreakContinue, IdentifyBreakFreeze:
+	default:
+		return errors.Errorf("Can't respond to broken proofs with %s", response)
+	}
+
+	fbo.identifyBreaksLock.Lock()
+	defer fbo.identifyBreaksLock.Unlock()
+	if fbo.identifyBreaks == nil {
+		return errors.Errorf(
+			"No members of %s have broken proofs", fbo.folderBranch.Tlf)
+	}
+	fbo.identifyBreaks.Response = response
+	return nil
+}
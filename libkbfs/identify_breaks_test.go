@@ -0,0 +1,110 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentifyBreaksRespond(t *testing.T) {
+	tempdir, err := ioutil.TempDir(os.TempDir(), "identify_breaks")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	out := filepath.Join(tempdir, "out")
+
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice", "bob", "charlie")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	err = config.SetEventRules(EventRules{{
+		Name: "broken",
+		On:   EventProofsBroken,
+		Actions: []EventRuleAction{{Command: []string{
+			"sh", "-c", `echo "{{.Folder}} $KBFS_EVENT_MEMBERS" >> "$0"`,
+			out}}},
+	}})
+	require.NoError(t, err)
+
+	rootNode := GetRootNodeOrBust(
+		ctx, t, config, "alice,bob,charlie", tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps()
+	ops := kbfsOps.(*KBFSOpsStandard).getOpsByNode(ctx, rootNode)
+	h, err := ops.GetTLFHandle(ctx, rootNode)
+	require.NoError(t, err)
+
+	_, ok, err := kbfsOps.GetIdentifyBreaks(ctx, fb)
+	require.NoError(t, err)
+	require.False(t, ok)
+	err = kbfsOps.RespondToIdentifyBreaks(ctx, fb, IdentifyBreakFreeze)
+	require.Error(t, err)
+
+	breakFor := func(uid keybase1.UID, name string) keybase1.TLFIdentifyFailure {
+		return keybase1.TLFIdentifyFailure{
+			User:   keybase1.User{Uid: uid, Username: name},
+			Breaks: &keybase1.IdentifyTrackBreaks{},
+		}
+	}
+	bobBreak := breakFor(keybase1.MakeTestUID(2), "bob")
+	charlieBreak := breakFor(keybase1.MakeTestUID(3), "charlie")
+
+	t.Log("A break is recorded, and fires an event")
+	ops.updateIdentifyBreaks(ctx, h, keybase1.TLFBreak{
+		Breaks: []keybase1.TLFIdentifyFailure{bobBreak}})
+	status, ok, err := kbfsOps.GetIdentifyBreaks(ctx, fb)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, IdentifyBreakPending, status.Response)
+	require.Equal(t, []keybase1.TLFIdentifyFailure{bobBreak}, status.Breaks)
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+
+	t.Log("Freezing stops writes")
+	err = kbfsOps.RespondToIdentifyBreaks(ctx, fb, IdentifyBreakFreeze)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "b", false, NoExcl)
+	require.IsType(t, FolderFrozenError{}, err)
+
+	t.Log("The same break again doesn't change anything")
+	ops.updateIdentifyBreaks(ctx, h, keybase1.TLFBreak{
+		Breaks: []keybase1.TLFIdentifyFailure{bobBreak}})
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "b", false, NoExcl)
+	require.IsType(t, FolderFrozenError{}, err)
+
+	t.Log("Continuing allows writes again")
+	err = kbfsOps.RespondToIdentifyBreaks(ctx, fb, IdentifyBreakContinue)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "b", false, NoExcl)
+	require.NoError(t, err)
+
+	t.Log("A new break needs a new response")
+	ops.updateIdentifyBreaks(ctx, h, keybase1.TLFBreak{
+		Breaks: []keybase1.TLFIdentifyFailure{bobBreak, charlieBreak}})
+	status, ok, err = kbfsOps.GetIdentifyBreaks(ctx, fb)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, IdentifyBreakPending, status.Response)
+	require.Len(t, status.Breaks, 2)
+	err = kbfsOps.RespondToIdentifyBreaks(ctx, fb, IdentifyBreakFreeze)
+	require.NoError(t, err)
+
+	t.Log("Fixed proofs unfreeze the folder")
+	ops.updateIdentifyBreaks(ctx, h, keybase1.TLFBreak{})
+	_, ok, err = kbfsOps.GetIdentifyBreaks(ctx, fb)
+	require.NoError(t, err)
+	require.False(t, ok)
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "c", false, NoExcl)
+	require.NoError(t, err)
+
+	config.EventRuleEngine().actions.Wait()
+	p := "/keybase/private/alice,bob,charlie"
+	require.Equal(t, []string{p + " bob", p + " charlie"},
+		readEventRulesTestFile(t, out))
+}
@@ -0,0 +1,121 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// IdentifyMode is how the identifies run for the operations of one
+// mount or client behave.  It lets automated and headless users of
+// KBFS avoid the popups meant for interactive use.
+type IdentifyMode int
+
+const (
+	// IdentifyModeDefault is the usual behavior of the filesystem:
+	// identify each folder once in a while, and pop up the tracker
+	// on failures.
+	IdentifyModeDefault IdentifyMode = iota
+	// IdentifyModeStrict identifies on every access, without popups,
+	// and fails the access if any proof is broken.  Its behavior
+	// always runs identifies, so the result of an earlier identify
+	// of the folder is never reused, and every filesystem operation
+	// (e.g., each FUSE request on a mount) that needs the folder's
+	// metadata pays for a fresh identify.
+	IdentifyModeStrict
+	// IdentifyModeCLI reports identify failures the way the
+	// command-line client does.
+	IdentifyModeCLI
+	// IdentifyModeNone skips identifies, logging each folder that is
+	// accessed without one to the identify audit log.
+	IdentifyModeNone
+)
+
+func (m IdentifyMode) String() string {
+	switch m {
+	case IdentifyModeDefault:
+		return "default"
+	case IdentifyModeStrict:
+		return "strict"
+	case IdentifyModeCLI:
+		return "cli"
+	case IdentifyModeNone:
+		return "none"
+	default:
+		return fmt.Sprintf("IdentifyMode(%d)", int(m))
+	}
+}
+
+// ParseIdentifyMode parses the String() form of an IdentifyMode.
+func ParseIdentifyMode(s string) (IdentifyMode, error) {
+	for _, m := range []IdentifyMode{IdentifyModeDefault,
+		IdentifyModeStrict, IdentifyModeCLI, IdentifyModeNone} {
+		if s == m.String() {
+			return m, nil
+		}
+	}
+	return IdentifyModeDefault, errors.Errorf(
+		"Unknown identify mode %q; must be default, strict, cli or none", s)
+}
+
+// Behavior returns the identify behavior the service is asked to use
+// under `m`.
+func (m IdentifyMode) Behavior() keybase1.TLFIdentifyBehavior {
+	switch m {
+	case IdentifyModeStrict:
+		return keybase1.TLFIdentifyBehavior_KBFS_CHAT
+	case IdentifyModeCLI:
+		return keybase1.TLFIdentifyBehavior_CLI
+	case IdentifyModeNone:
+		return keybase1.TLFIdentifyBehavior_CHAT_SKIP
+	default:
+		return keybase1.TLFIdentifyBehavior_DEFAULT_KBFS
+	}
+}
+
+// WithIdentifyMode returns a context whose identifies behave as `m`
+// asks.  Since the value isn't replayable on its own, it's meant to
+// be called from the function passed to NewContextReplayable.  A
+// context that already has an identify behavior, e.g. from
+// MakeExtendedIdentify, is returned unchanged, as is any context
+// under IdentifyModeDefault.
+func WithIdentifyMode(ctx context.Context, m IdentifyMode) context.Context {
+	if m == IdentifyModeDefault {
+		return ctx
+	}
+	if _, ok := ctx.Value(ctxExtendedIdentifyKey).(*extendedIdentify); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, ctxExtendedIdentifyKey, &extendedIdentify{
+		behavior:  m.Behavior(),
+		auditSkip: m == IdentifyModeNone,
+	})
+}
+
+// identifyAuditLogModule is the logging module of the identify audit
+// log.
+const identifyAuditLogModule = "IDAUDIT"
+
+// auditSkippedIdentifyLocked logs, once per folder, that it was
+// accessed without identifying its members.
+func (fbo *folderBranchOps) auditSkippedIdentifyLocked(
+	ctx context.Context, h *TlfHandle) {
+	if fbo.identifySkipAudited {
+		return
+	}
+	fbo.identifySkipAudited = true
+	session, err := fbo.config.KBPKI().GetCurrentSession(ctx)
+	user := "unknown user"
+	if err == nil {
+		user = string(session.Name)
+	}
+	fbo.config.MakeLogger(identifyAuditLogModule).CInfof(ctx,
+		"%s accessed %s without identifying its members",
+		user, h.GetCanonicalPath())
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestParseIdentifyMode(t *testing.T) {
+	for _, m := range []IdentifyMode{IdentifyModeDefault,
+		IdentifyModeStrict, IdentifyModeCLI, IdentifyModeNone} {
+		parsed, err := ParseIdentifyMode(m.String())
+		require.NoError(t, err)
+		require.Equal(t, m, parsed)
+	}
+	_, err := ParseIdentifyMode("lenient")
+	require.Error(t, err)
+}
+
+func TestWithIdentifyMode(t *testing.T) {
+	ctx := context.Background()
+	require.Equal(t, ctx, WithIdentifyMode(ctx, IdentifyModeDefault))
+
+	ei := getExtendedIdentify(WithIdentifyMode(ctx, IdentifyModeStrict))
+	require.True(t, ei.behavior.AlwaysRunIdentify())
+	require.True(t, ei.behavior.ShouldSuppressTrackerPopups())
+	require.False(t, ei.behavior.WarningInsteadOfErrorOnBrokenTracks())
+	require.False(t, ei.auditSkip)
+
+	ei = getExtendedIdentify(WithIdentifyMode(ctx, IdentifyModeNone))
+	require.Equal(t, keybase1.TLFIdentifyBehavior_CHAT_SKIP, ei.behavior)
+	require.True(t, ei.auditSkip)
+
+	t.Log("An explicit behavior wins over the mode")
+	ctx, err := MakeExtendedIdentify(
+		ctx, keybase1.TLFIdentifyBehavior_CHAT_GUI)
+	require.NoError(t, err)
+	ei = getExtendedIdentify(WithIdentifyMode(ctx, IdentifyModeNone))
+	require.Equal(t, keybase1.TLFIdentifyBehavior_CHAT_GUI, ei.behavior)
+}
+
+func TestIdentifyModeNoneAudits(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice", "bob")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice,bob", tlf.Private)
+	ops := config.KBFSOps().(*KBFSOpsStandard).getOpsByNode(ctx, rootNode)
+	md, _ := ops.getHead(makeFBOLockState())
+	ops.identifyLock.Lock()
+	ops.identifyDone = false
+	ops.identifyLock.Unlock()
+
+	noneCtx := WithIdentifyMode(ctx, IdentifyModeNone)
+	err := ops.identifyOnce(noneCtx, md.ReadOnly())
+	require.NoError(t, err)
+	ops.identifyLock.Lock()
+	require.False(t, ops.identifyDone)
+	require.True(t, ops.identifySkipAudited)
+	ops.identifyLock.Unlock()
+
+	err = ops.identifyOnce(ctx, md.ReadOnly())
+	require.NoError(t, err)
+	ops.identifyLock.Lock()
+	require.True(t, ops.identifyDone)
+	ops.identifyLock.Unlock()
+}
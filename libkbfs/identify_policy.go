@@ -0,0 +1,216 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// IdentifyPolicies is an IdentifyPolicy that requires every one of
+// its policies to allow access, checking them in order.
+type IdentifyPolicies []IdentifyPolicy
+
+var _ IdentifyPolicy = IdentifyPolicies(nil)
+
+// CheckFolder implements the IdentifyPolicy interface for
+// IdentifyPolicies.
+func (ips IdentifyPolicies) CheckFolder(
+	ctx context.Context, h *TlfHandle, breaks keybase1.TLFBreak) error {
+	for _, ip := range ips {
+		if err := ip.CheckFolder(ctx, h, breaks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ProofCounter returns the number of verified identity proofs a user
+// has, e.g. by asking the service for the user's proofs.
+type ProofCounter func(ctx context.Context, uid keybase1.UID) (int, error)
+
+// proofCounter is implemented by KeybaseService implementations that
+// can count a user's identity proofs.
+type proofCounter interface {
+	CountProofs(ctx context.Context, uid keybase1.UID) (int, error)
+}
+
+// NewServiceProofCounter returns a ProofCounter that asks the
+// KeybaseService of `config` for a user's proofs.
+func NewServiceProofCounter(config Config) ProofCounter {
+	return func(ctx context.Context, uid keybase1.UID) (int, error) {
+		pc, ok := config.KeybaseService().(proofCounter)
+		if !ok {
+			return 0, errors.Errorf("%T can't count identity proofs",
+				config.KeybaseService())
+		}
+		return pc.CountProofs(ctx, uid)
+	}
+}
+
+// MinWriterProofsIdentifyPolicy is an IdentifyPolicy that denies
+// access to folders with a user writer who has fewer than
+// `MinProofs` identity proofs.  Team writers, and readers, aren't
+// checked.
+type MinWriterProofsIdentifyPolicy struct {
+	MinProofs   int
+	CountProofs ProofCounter
+}
+
+var _ IdentifyPolicy = MinWriterProofsIdentifyPolicy{}
+
+// CheckFolder implements the IdentifyPolicy interface for
+// MinWriterProofsIdentifyPolicy.
+func (p MinWriterProofsIdentifyPolicy) CheckFolder(
+	ctx context.Context, h *TlfHandle, _ keybase1.TLFBreak) error {
+	names := h.ResolvedUsersMap()
+	var short []string
+	for _, id := range h.ResolvedWriters() {
+		if !id.IsUser() {
+			continue
+		}
+		n, err := p.CountProofs(ctx, id.AsUserOrBust())
+		if err != nil {
+			return err
+		}
+		if n < p.MinProofs {
+			short = append(short, names[id].String())
+		}
+	}
+	if len(short) == 0 {
+		return nil
+	}
+	sort.Strings(short)
+	return IdentifyPolicyDeniedError{
+		h.GetCanonicalPath(),
+		fmt.Sprintf("writers with fewer than %d proofs: %s",
+			p.MinProofs, strings.Join(short, ", ")),
+	}
+}
+
+// BrokenTracksIdentifyPolicy is an IdentifyPolicy that denies access
+// to folders with members whose tracked proofs are broken, until the
+// current user acknowledges the breaks of each of those members.
+// Breaks are only known when the identify behavior warns about them;
+// otherwise broken tracks fail the identify anyway.
+type BrokenTracksIdentifyPolicy struct {
+	lock         sync.Mutex
+	acknowledged map[keybase1.UID]bool
+}
+
+var _ IdentifyPolicy = (*BrokenTracksIdentifyPolicy)(nil)
+
+// NewBrokenTracksIdentifyPolicy returns a new
+// BrokenTracksIdentifyPolicy, with no breaks acknowledged.
+func NewBrokenTracksIdentifyPolicy() *BrokenTracksIdentifyPolicy {
+	return &BrokenTracksIdentifyPolicy{
+		acknowledged: make(map[keybase1.UID]bool),
+	}
+}
+
+// Acknowledge allows access to folders with the given user as a
+// member, despite their broken tracks.
+func (p *BrokenTracksIdentifyPolicy) Acknowledge(uid keybase1.UID) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.acknowledged[uid] = true
+}
+
+// Unacknowledge undoes an earlier Acknowledge call for the user.
+func (p *BrokenTracksIdentifyPolicy) Unacknowledge(uid keybase1.UID) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.acknowledged, uid)
+}
+
+// CheckFolder implements the IdentifyPolicy interface for
+// BrokenTracksIdentifyPolicy.
+func (p *BrokenTracksIdentifyPolicy) CheckFolder(
+	_ context.Context, h *TlfHandle, breaks keybase1.TLFBreak) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var broken []string
+	for _, b := range breaks.Breaks {
+		if b.Breaks == nil || p.acknowledged[b.User.Uid] {
+			continue
+		}
+		broken = append(broken, b.User.Username)
+	}
+	if len(broken) == 0 {
+		return nil
+	}
+	sort.Strings(broken)
+	return IdentifyPolicyDeniedError{
+		h.GetCanonicalPath(),
+		"unacknowledged broken proofs for " + strings.Join(broken, ", "),
+	}
+}
+
+// makeIdentifyPolicy returns the identify policy asked for by
+// `params`, or nil if there is none.
+func makeIdentifyPolicy(config Config, params InitParams) IdentifyPolicy {
+	var policies IdentifyPolicies
+	if params.IdentifyMinWriterProofs > 0 {
+		policies = append(policies, MinWriterProofsIdentifyPolicy{
+			MinProofs:   params.IdentifyMinWriterProofs,
+			CountProofs: NewServiceProofCounter(config),
+		})
+	}
+	if params.IdentifyRequireBreakAcks {
+		policies = append(policies, NewBrokenTracksIdentifyPolicy())
+	}
+	switch len(policies) {
+	case 0:
+		return nil
+	case 1:
+		return policies[0]
+	default:
+		return policies
+	}
+}
+
+func findBrokenTracksIdentifyPolicy(
+	ip IdentifyPolicy) *BrokenTracksIdentifyPolicy {
+	switch p := ip.(type) {
+	case *BrokenTracksIdentifyPolicy:
+		return p
+	case IdentifyPolicies:
+		for _, sub := range p {
+			if btp := findBrokenTracksIdentifyPolicy(sub); btp != nil {
+				return btp
+			}
+		}
+	}
+	return nil
+}
+
+// AcknowledgeBrokenProofs allows access to folders with `username`
+// as a member, despite their broken tracks, if the identify policy of
+// `config` includes a BrokenTracksIdentifyPolicy.  Acknowledgements
+// only last until KBFS restarts.
+func AcknowledgeBrokenProofs(
+	ctx context.Context, config Config, username string) error {
+	policy := findBrokenTracksIdentifyPolicy(config.IdentifyPolicy())
+	if policy == nil {
+		return errors.New(
+			"the identify policy doesn't ask for broken proofs to be " +
+				"acknowledged")
+	}
+	_, id, err := config.KBPKI().Resolve(ctx, username)
+	if err != nil {
+		return err
+	}
+	if !id.IsUser() {
+		return errors.Errorf("%s is not a user", username)
+	}
+	policy.Acknowledge(id.AsUserOrBust())
+	return nil
+}
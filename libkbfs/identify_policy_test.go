@@ -0,0 +1,122 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestIdentifyPolicyMinWriterProofs(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice", "bob")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+	proofs := map[keybase1.UID]int{session.UID: 2}
+	policy := MinWriterProofsIdentifyPolicy{
+		MinProofs: 2,
+		CountProofs: func(_ context.Context, uid keybase1.UID) (int, error) {
+			return proofs[uid], nil
+		},
+	}
+	config.SetIdentifyPolicy(policy)
+
+	t.Log("A folder with only well-proven writers is allowed")
+	GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+
+	t.Log("A folder with a writer with too few proofs is denied")
+	_, err = GetRootNodeForTest(ctx, config, "alice,bob", tlf.Private)
+	require.IsType(t, IdentifyPolicyDeniedError{}, err)
+
+	t.Log("Readers aren't checked")
+	GetRootNodeOrBust(ctx, t, config, "alice#bob", tlf.Private)
+
+	t.Log("The check is repeated until it passes")
+	bobUID := keybase1.MakeTestUID(2)
+	proofs[bobUID] = 3
+	GetRootNodeOrBust(ctx, t, config, "alice,bob", tlf.Private)
+}
+
+func TestIdentifyPolicyBrokenTracks(t *testing.T) {
+	config := MakeTestConfigOrBust(t, "alice", "bob")
+	defer CheckConfigAndShutdown(context.Background(), t, config)
+	h := parseTlfHandleOrBust(
+		t, config, "alice,bob", tlf.Private, tlf.FakeID(1, tlf.Private))
+
+	bobUID := keybase1.MakeTestUID(2)
+	breaks := keybase1.TLFBreak{
+		Breaks: []keybase1.TLFIdentifyFailure{{
+			User:   keybase1.User{Uid: bobUID, Username: "bob"},
+			Breaks: &keybase1.IdentifyTrackBreaks{},
+		}},
+	}
+
+	policy := NewBrokenTracksIdentifyPolicy()
+	ctx := context.Background()
+	require.NoError(t, policy.CheckFolder(ctx, h, keybase1.TLFBreak{}))
+	err := policy.CheckFolder(ctx, h, breaks)
+	require.IsType(t, IdentifyPolicyDeniedError{}, err)
+
+	policy.Acknowledge(bobUID)
+	require.NoError(t, policy.CheckFolder(ctx, h, breaks))
+
+	policy.Unacknowledge(bobUID)
+	err = IdentifyPolicies{
+		MinWriterProofsIdentifyPolicy{
+			CountProofs: func(context.Context, keybase1.UID) (int, error) {
+				return 0, nil
+			},
+		},
+		policy,
+	}.CheckFolder(ctx, h, breaks)
+	require.IsType(t, IdentifyPolicyDeniedError{}, err)
+}
+
+func TestIdentifyPolicyFromParams(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice", "bob")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	t.Log("No policy by default")
+	require.Nil(t, makeIdentifyPolicy(config, InitParams{}))
+	err := AcknowledgeBrokenProofs(ctx, config, "bob")
+	require.Error(t, err)
+
+	t.Log("Both policies")
+	policy := makeIdentifyPolicy(config, InitParams{
+		IdentifyMinWriterProofs:  2,
+		IdentifyRequireBreakAcks: true,
+	})
+	require.IsType(t, IdentifyPolicies{}, policy)
+	config.SetIdentifyPolicy(policy)
+
+	h := parseTlfHandleOrBust(
+		t, config, "alice#bob", tlf.Private, tlf.FakeID(1, tlf.Private))
+	bobUID := keybase1.MakeTestUID(2)
+	breaks := keybase1.TLFBreak{
+		Breaks: []keybase1.TLFIdentifyFailure{{
+			User:   keybase1.User{Uid: bobUID, Username: "bob"},
+			Breaks: &keybase1.IdentifyTrackBreaks{},
+		}},
+	}
+	brokenTracks := findBrokenTracksIdentifyPolicy(policy)
+	require.NotNil(t, brokenTracks)
+	err = brokenTracks.CheckFolder(ctx, h, breaks)
+	require.IsType(t, IdentifyPolicyDeniedError{}, err)
+
+	t.Log("Acknowledging by username allows access")
+	err = AcknowledgeBrokenProofs(ctx, config, "bob")
+	require.NoError(t, err)
+	require.NoError(t, brokenTracks.CheckFolder(ctx, h, breaks))
+
+	t.Log("The local test service can't count proofs, so writers are " +
+		"denied rather than let through")
+	err = policy.CheckFolder(ctx, h, breaks)
+	require.Error(t, err)
+}
@@ -19,6 +19,9 @@ import (
 
 type extendedIdentify struct {
 	behavior keybase1.TLFIdentifyBehavior
+	// auditSkip is set when skipped identifies should be written
+	// to the identify audit log.
+	auditSkip bool
 
 	// lock guards userBreaks and tlfBreaks
 	lock       sync.Mutex
@@ -0,0 +1,129 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"golang.org/x/net/context"
+)
+
+// idleReclaimer releases the memory held by KBFS's clean caches, and
+// closes the block server connections used for reads and interactive
+// writes, once no KBFS path has been accessed for a while.  Nothing
+// is explicitly restored on the next access; the caches just refill
+// lazily as blocks and metadata get fetched again, and the
+// connections are reopened by the next request that needs them.
+//
+// Dirty data, the node cache, and permanent cache entries are never
+// dropped, since they can't be recovered from the servers.  The
+// mdserver connection is left open, since it carries the update
+// registrations for all the open TLFs, and so is the block server
+// connection for journal flushes, which go on in the background.
+// KBFS keeps no read-ahead buffers of its own for FUSE reads beyond
+// the clean block cache; the kernel's page cache isn't part of the
+// daemon's memory, and since KBFS doesn't ask the kernel to keep it,
+// it's dropped whenever a file is opened again anyway.
+type idleReclaimer struct {
+	config       Config
+	log          logger.Logger
+	idleDuration time.Duration
+
+	// active is set to 1 by every access, and cleared by the loop
+	// when it notices.  Accesses only write it when it's 0, so that
+	// they don't contend on it.
+	active int32
+
+	// The rest is only accessed by the loop goroutine.
+	lastActivity time.Time
+	reclaimed    bool
+
+	shutdownCh chan struct{}
+	doneCh     chan struct{}
+}
+
+func newIdleReclaimer(
+	config Config, idleDuration time.Duration) *idleReclaimer {
+	return &idleReclaimer{
+		config:       config,
+		log:          config.MakeLogger("IDL"),
+		idleDuration: idleDuration,
+		lastActivity: config.Clock().Now(),
+		shutdownCh:   make(chan struct{}),
+		doneCh:       make(chan struct{}),
+	}
+}
+
+// touch records that KBFS is being used.  It's called for every
+// operation, so it avoids locks and clock reads.
+func (ir *idleReclaimer) touch() {
+	if atomic.LoadInt32(&ir.active) == 0 {
+		atomic.StoreInt32(&ir.active, 1)
+	}
+}
+
+// checkIdle reclaims resources if there's been no activity within
+// the idle duration, and they haven't been reclaimed already.  It
+// returns true if it reclaimed anything.  Activity is noticed when
+// this is called, so it must be called a few times per idle
+// duration.
+func (ir *idleReclaimer) checkIdle(ctx context.Context) bool {
+	now := ir.config.Clock().Now()
+	if atomic.SwapInt32(&ir.active, 0) != 0 {
+		ir.lastActivity = now
+		if ir.reclaimed {
+			ir.log.CDebugf(ctx,
+				"Activity after idle reclamation; caches will refill")
+			ir.reclaimed = false
+		}
+	}
+	if ir.reclaimed {
+		return false
+	}
+	idleFor := now.Sub(ir.lastActivity)
+	if idleFor < ir.idleDuration {
+		return false
+	}
+
+	ir.log.CDebugf(ctx, "Idle for %s; reclaiming cached resources", idleFor)
+	if bcache, ok := ir.config.BlockCache().(*BlockCacheStandard); ok {
+		bcache.purgeTransient()
+	}
+	if mdcache, ok := ir.config.MDCache().(*MDCacheStandard); ok {
+		mdcache.purge()
+	}
+	if bserver, ok := ir.config.BlockServer().(*BlockServerRemote); ok {
+		bserver.dropIdleConnections()
+	}
+	// Give the freed memory back to the OS right away, rather than
+	// waiting for the runtime to scavenge it.
+	debug.FreeOSMemory()
+	ir.reclaimed = true
+	return true
+}
+
+func (ir *idleReclaimer) loop() {
+	defer close(ir.doneCh)
+	// Check a few times per idle period, so we don't overshoot it by
+	// too much.
+	ticker := time.NewTicker(ir.idleDuration / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ir.checkIdle(context.Background())
+		case <-ir.shutdownCh:
+			return
+		}
+	}
+}
+
+func (ir *idleReclaimer) shutdown() {
+	close(ir.shutdownCh)
+	<-ir.doneCh
+}
@@ -0,0 +1,59 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestIdleReclaimerPurgesTransientBlocks(t *testing.T) {
+	ctx := context.Background()
+	config := blockCacheTestInit(t, 100, 1<<30)
+	defer CheckConfigAndShutdown(ctx, t, config)
+	clock := newTestClockNow()
+	config.SetClock(clock)
+	bcache := config.BlockCache()
+
+	transientID := kbfsblock.FakeID(1)
+	testBcachePut(t, transientID, bcache, TransientEntry)
+	permanentID := kbfsblock.FakeID(2)
+	testBcachePut(t, permanentID, bcache, PermanentEntry)
+
+	ir := newIdleReclaimer(config, time.Minute)
+
+	t.Log("Not idle long enough yet")
+	clock.Add(30 * time.Second)
+	require.False(t, ir.checkIdle(ctx))
+	_, err := bcache.Get(BlockPointer{ID: transientID})
+	require.NoError(t, err)
+
+	t.Log("Activity, noticed by the next check, resets the idle timer")
+	ir.touch()
+	require.False(t, ir.checkIdle(ctx))
+	clock.Add(45 * time.Second)
+	require.False(t, ir.checkIdle(ctx))
+
+	t.Log("Idle long enough; only the transient block is dropped")
+	clock.Add(15 * time.Second)
+	require.True(t, ir.checkIdle(ctx))
+	testExpectedMissing(t, transientID, bcache)
+	_, err = bcache.Get(BlockPointer{ID: permanentID})
+	require.NoError(t, err)
+
+	t.Log("Nothing more to reclaim until the next activity")
+	clock.Add(time.Hour)
+	require.False(t, ir.checkIdle(ctx))
+	ir.touch()
+	testBcachePut(t, transientID, bcache, TransientEntry)
+	require.False(t, ir.checkIdle(ctx))
+	clock.Add(time.Minute)
+	require.True(t, ir.checkIdle(ctx))
+	testExpectedMissing(t, transientID, bcache)
+}
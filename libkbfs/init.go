@@ -37,6 +37,12 @@ const (
 	// InitConstrainedString is for when KBFS will use constrained
 	// resources.
 	InitConstrainedString = "constrained"
+	// InitBackupString is for when KBFS is the target of a backup
+	// tool (e.g., restic or borg).  Fsyncs keep their usual meaning,
+	// which is what these tools depend on: a file or directory that
+	// has been fsynced survives a crash, in the journal if there is
+	// one, and otherwise on the servers.
+	InitBackupString = "backup"
 )
 
 // AdditionalProtocolCreator creates an additional protocol.
@@ -48,12 +54,22 @@ type InitParams struct {
 	// Whether to print debug messages.
 	Debug bool
 
+	// Whether to track the order in which KBFS's internal locks are
+	// acquired, and log any potential lock inversions.
+	LogLockInversions bool
+
 	// If non-empty, the host:port of the block server. If empty,
 	// a default value is used depending on the run mode. Can also
 	// be "memory" for an in-memory test server or
 	// "dir:/path/to/dir" for an on-disk test server.
 	BServerAddr string
 
+	// BServerRegions, if non-empty, lists the block servers of the
+	// data regions that TLFs can be constrained to, in the format
+	// of ParseBServerRegions.  Blocks of TLFs constrained to any
+	// other region can't be put.
+	BServerRegions string
+
 	// If non-empty the host:port of the metadata server. If
 	// empty, a default value is used depending on the run mode.
 	// Can also be "memory" for an in-memory test server or
@@ -109,15 +125,97 @@ type InitParams struct {
 	// databases for things like the journal or disk cache.
 	StorageRoot string
 
+	// SharedDiskCacheDir, if non-empty, points to a local directory
+	// where the blocks of public TLFs are cached, so that other KBFS
+	// instances on this machine (e.g., for other accounts) can use
+	// them too.  Blocks of private and team TLFs never go there.
+	SharedDiskCacheDir string
+
+	// PublicReadOnly makes KBFS act as an anonymous device, even if
+	// a user is logged into the Keybase service, that can only read
+	// public folders.  Journaling is disabled.
+	PublicReadOnly bool
+
+	// DisableSiblingPrefetch turns off prefetching the next few
+	// files in a directory when files there are opened one after
+	// another in name order.
+	DisableSiblingPrefetch bool
+
+	// ClientID, if non-empty, identifies the application embedding
+	// KBFS (e.g., "kbpagesd") on every request to the block and MD
+	// servers, and in the local metrics of those requests.  See
+	// CheckClientID for the allowed format.
+	ClientID string
+
 	// BGFlushPeriod indicates how long to wait for a batch to fill up
-	// before syncing a set of changes on a TLF to the servers.
+	// before syncing a set of changes on a TLF to the servers.  Zero
+	// means the mode's default.
 	BGFlushPeriod time.Duration
 
+	// DirTimesBatchPeriod indicates how long a directory's mtime and
+	// ctime may lag behind changes to its entries, to batch their
+	// updates together.  Zero means the mode's default, and a
+	// negative value updates them on every change.
+	DirTimesBatchPeriod time.Duration
+
+	// DeviceConstraintsMode indicates which background work to
+	// pause while the device is on battery power or a metered
+	// network.  The default, DeviceConstraintsModeIgnore, doesn't
+	// check the device constraints at all.
+	DeviceConstraintsMode DeviceConstraintsMode
+
+	// JournalFlushSchedule and PrefetchSchedule, if non-empty,
+	// replace the persisted schedules restricting when journals are
+	// flushed and blocks are prefetched in the background.  See
+	// ParseSyncSchedule for the format; "always" clears a schedule.
+	JournalFlushSchedule string
+	PrefetchSchedule     string
+
+	// NotificationSinks lists where notifications go besides the
+	// GUI.  See ParseNotificationSinkSpecs for the format.
+	NotificationSinks string
+
+	// IdentifyMinWriterProofs, if positive, denies access to folders
+	// with a user writer who has fewer identity proofs than this.
+	IdentifyMinWriterProofs int
+
+	// IdentifyRequireBreakAcks denies access to folders with members
+	// whose tracked proofs are broken, until the breaks of each of
+	// those members are acknowledged (see AcknowledgeBrokenProofs).
+	IdentifyRequireBreakAcks bool
+
+	// IdleReclaimDuration indicates how long KBFS must go without
+	// any activity before it releases the memory held by its clean
+	// caches and idle block server connections.  Zero, the default,
+	// disables idle reclamation.
+	IdleReclaimDuration time.Duration
+
+	// FolderLimits caps the rate and concurrency of the operations
+	// on each TLF, e.g. when KBFS serves a web gateway.  The zero
+	// value doesn't limit anything.
+	FolderLimits FolderLimits
+
+	// AdaptiveBlockSizes, if true, makes new files pick their block
+	// sizes based on their names and write patterns; see
+	// BlockSizeClass.
+	AdaptiveBlockSizes bool
+
+	// ExpiryEnforcementPeriod indicates how often to delete files
+	// that have outlived the expiry policies of their directories,
+	// if the policies name this device as their enforcer.  Zero, the
+	// default, disables enforcement.
+	ExpiryEnforcementPeriod time.Duration
+
 	// BGFlushDirOpBatchSize indicates how many directory operations
 	// in a TLF should be batched together in a single background
-	// flush.
+	// flush.  Zero means the mode's default.
 	BGFlushDirOpBatchSize int
 
+	// OpTimeouts bounds how long each class of file system
+	// operation may take, before failing with a ServerSlowError or
+	// an OfflineError.
+	OpTimeouts OpTimeouts
+
 	// Mode describes how KBFS should initialize itself.
 	Mode string
 
@@ -202,13 +300,11 @@ func DefaultInitParams(ctx Context) InitParams {
 		},
 		TLFJournalBackgroundWorkStatus: TLFJournalBackgroundWorkEnabled,
 		StorageRoot:                    ctx.GetDataDir(),
-		BGFlushPeriod:                  bgFlushPeriodDefault,
-		BGFlushDirOpBatchSize:          bgFlushDirOpBatchSizeDefault,
 		EnableJournal:                  BoolForString(journalEnv),
 		DiskCacheMode:                  DiskCacheModeLocal,
 		DiskBlockCacheFraction:         0.10,
 		SyncBlockCacheFraction:         0.10,
-		Mode: InitDefaultString,
+		Mode:                           InitDefaultString,
 	}
 }
 
@@ -221,9 +317,18 @@ func AddFlagsWithDefaults(
 	var params InitParams
 	flags.BoolVar(&params.Debug, "debug", defaultParams.Debug,
 		"Print debug messages")
+	flags.BoolVar(&params.LogLockInversions, "log-lock-inversions",
+		defaultParams.LogLockInversions,
+		"Log potential lock inversions (slow)")
 
 	flags.StringVar(&params.BServerAddr, "bserver", defaultParams.BServerAddr,
 		"host:port of the block server, 'memory', or 'dir:/path/to/dir'")
+	flags.StringVar(&params.BServerRegions, "bserver-regions",
+		defaultParams.BServerRegions,
+		"Block servers of the data regions that folders can be "+
+			"constrained to, as a '|'-separated list of region=address, "+
+			"with addresses like -bserver's, e.g. "+
+			"'eu=bserver-0.eu.example.com:443,bserver-1.eu.example.com:443'.")
 	flags.StringVar(&params.MDServerAddr, "mdserver",
 		defaultParams.MDServerAddr,
 		"host:port of the metadata server, 'memory', or 'dir:/path/to/dir'")
@@ -263,6 +368,19 @@ func AddFlagsWithDefaults(
 			"subdirectory of -storage-root to store the cache. If 'remote', "+
 			"then it connects to the local KBFS instance and delegates disk "+
 			"cache operations to it.")
+	flags.StringVar(&params.SharedDiskCacheDir, "shared-disk-cache-dir",
+		defaultParams.SharedDiskCacheDir, "If non-empty, cache public "+
+			"folder blocks in this directory, where other KBFS instances on "+
+			"this machine can share them, instead of in -storage-root.")
+	flags.BoolVar(&params.PublicReadOnly, "public-read-only",
+		defaultParams.PublicReadOnly, "Serve public folders read-only, "+
+			"without using the logged-in user, if any.")
+	flags.BoolVar(&params.DisableSiblingPrefetch, "disable-sibling-prefetch",
+		defaultParams.DisableSiblingPrefetch, "Don't prefetch the next "+
+			"files in a directory when files there are opened in name order.")
+	flags.StringVar(&params.ClientID, "client-id", defaultParams.ClientID,
+		"If non-empty, identifies the application using KBFS to the "+
+			"block and MD servers, and in local metrics.")
 	flags.BoolVar(&params.EnableJournal, "enable-journal",
 		defaultParams.EnableJournal, "Enables write journaling for TLFs.")
 
@@ -274,11 +392,80 @@ func AddFlagsWithDefaults(
 	flags.DurationVar(&params.BGFlushPeriod, "sync-batch-period",
 		defaultParams.BGFlushPeriod,
 		"The amount of time to wait before syncing data in a TLF, if the "+
-			"batch size doesn't fill up.")
+			"batch size doesn't fill up (0 for the mode's default).")
+	flags.DurationVar(&params.DirTimesBatchPeriod, "dir-times-batch-period",
+		defaultParams.DirTimesBatchPeriod,
+		"How long a directory's mtime and ctime may lag behind changes to "+
+			"its entries, to batch their updates (0 for the mode's "+
+			"default, negative to update them on every change).")
+	params.DeviceConstraintsMode = defaultParams.DeviceConstraintsMode
+	flags.Var(&params.DeviceConstraintsMode, "device-constraints-mode",
+		"What to pause while on battery power or a metered network: "+
+			"'ignore', 'pause-prefetch', or 'pause-all' (which also pauses "+
+			"journal flushes for non-synced TLFs).  Anything but 'ignore' "+
+			"polls the OS for the power and network state every minute.")
+	flags.StringVar(&params.JournalFlushSchedule, "journal-flush-schedule",
+		defaultParams.JournalFlushSchedule,
+		"Only flush journals in the background within these windows, "+
+			"e.g. '01:00-06:00' or 'sat,sun'; 'always' clears the "+
+			"persisted schedule.")
+	flags.StringVar(&params.PrefetchSchedule, "prefetch-schedule",
+		defaultParams.PrefetchSchedule,
+		"Only prefetch blocks within these windows; same format as "+
+			"-journal-flush-schedule.")
+	flags.StringVar(&params.NotificationSinks, "notification-sinks",
+		defaultParams.NotificationSinks,
+		"Where to send notifications besides the GUI, as a comma-separated "+
+			"list of 'desktop', 'syslog' or 'chat:<folder>[#<channel>]', "+
+			"each optionally followed by '=info', '=warning' (the default) "+
+			"or '=error', e.g. 'desktop=error,chat:team/acme#kbfs'.")
+	flags.IntVar(&params.IdentifyMinWriterProofs,
+		"identify-min-writer-proofs", defaultParams.IdentifyMinWriterProofs,
+		"Deny access to folders with a user writer who has fewer identity "+
+			"proofs than this (0 disables).")
+	flags.BoolVar(&params.IdentifyRequireBreakAcks,
+		"identify-require-break-acks", defaultParams.IdentifyRequireBreakAcks,
+		"Deny access to folders with members whose tracked proofs are "+
+			"broken, until the breaks are acknowledged by writing the "+
+			"member's username to "+
+			"/keybase/.kbfs_acknowledge_broken_proofs.")
+	flags.DurationVar(&params.IdleReclaimDuration, "idle-reclaim-period",
+		defaultParams.IdleReclaimDuration,
+		"The amount of time without any activity after which cached data "+
+			"is released from memory and idle connections are closed "+
+			"(0, the default, disables).")
+	flags.Float64Var(&params.FolderLimits.OpsPerSecond,
+		"folder-ops-per-second", defaultParams.FolderLimits.OpsPerSecond,
+		"The maximum rate of operations on each folder (0 is unlimited).")
+	flags.Int64Var(&params.FolderLimits.BytesPerSecond,
+		"folder-bytes-per-second", defaultParams.FolderLimits.BytesPerSecond,
+		"The maximum rate of bytes read from and written to the files of "+
+			"each folder (0 is unlimited).")
+	flags.IntVar(&params.FolderLimits.MaxConcurrentOps,
+		"folder-max-concurrent-ops",
+		defaultParams.FolderLimits.MaxConcurrentOps,
+		"The maximum number of operations on each folder that can run "+
+			"at once (0 is unlimited).")
+	flags.BoolVar(&params.AdaptiveBlockSizes, "adaptive-block-sizes",
+		defaultParams.AdaptiveBlockSizes,
+		"Use smaller blocks for new files that are modified in place, like "+
+			"databases.")
+	flags.DurationVar(&params.ExpiryEnforcementPeriod,
+		"expiry-enforcement-period", defaultParams.ExpiryEnforcementPeriod,
+		"How often to delete files older than the "+ExpiryPolicyFileName+
+			" policies of their directories allow, for the policies that"+
+			" name this device as their enforcer (0, the default, disables).")
 	flags.IntVar((*int)(&params.BGFlushDirOpBatchSize), "sync-batch-size",
 		int(defaultParams.BGFlushDirOpBatchSize),
 		"The number of unflushed directory operations in a TLF that will "+
-			"trigger an immediate data sync.")
+			"trigger an immediate data sync (0 for the mode's default).")
+
+	flags.DurationVar(&params.OpTimeouts.Read, "read-timeout",
+		defaultParams.OpTimeouts.Read, "How long a file system operation "+
+			"that only reads may take before failing (0 for no limit).")
+	flags.DurationVar(&params.OpTimeouts.Write, "write-timeout",
+		defaultParams.OpTimeouts.Write, "How long a file system operation "+
+			"that writes may take before failing (0 for no limit).")
 
 	flags.IntVar((*int)(&params.MetadataVersion), "md-version",
 		int(defaultParams.MetadataVersion),
@@ -288,8 +475,9 @@ func AddFlagsWithDefaults(
 		"Encryption version to use when encrypting new blocks")
 	flags.StringVar(&params.Mode, "mode", defaultParams.Mode,
 		fmt.Sprintf("Overall initialization mode for KBFS, indicating how "+
-			"heavy-weight it can be (%s, %s, %s or %s)", InitDefaultString,
-			InitMinimalString, InitSingleOpString, InitConstrainedString))
+			"heavy-weight it can be (%s, %s, %s, %s or %s)",
+			InitDefaultString, InitMinimalString, InitSingleOpString,
+			InitConstrainedString, InitBackupString))
 
 	flags.Float64Var((*float64)(&params.DiskBlockCacheFraction),
 		"disk-block-cache-fraction", defaultParams.DiskBlockCacheFraction,
@@ -445,6 +633,31 @@ func makeBlockServer(config Config, bserverAddr string,
 	return NewBlockServerRemote(config, remote, rpcLogFactory), nil
 }
 
+// makeBlockServerRegions wraps `bserv` in a BlockServerRegions with
+// the regional block servers in `regionsSpec`.  Even without any
+// regional servers, this keeps the blocks of TLFs constrained to a
+// data region from being put to `bserv`.
+func makeBlockServerRegions(config *ConfigLocal, bserv BlockServer,
+	regionsSpec string, rpcLogFactory rpc.LogFactory,
+	log logger.Logger) (BlockServer, error) {
+	addrs, err := ParseBServerRegions(regionsSpec)
+	if err != nil {
+		return nil, err
+	}
+	regions := make(map[string]BlockServer, len(addrs))
+	for region, addr := range addrs {
+		log.Debug("Using bserver %s for data region %s", addr, region)
+		regionBServer, err := makeBlockServer(
+			config, addr, rpcLogFactory, log)
+		if err != nil {
+			return nil, err
+		}
+		regions[region] = regionBServer
+	}
+	return NewBlockServerRegions(
+		config, bserv, regions, config.MetricsRegistry()), nil
+}
+
 // InitLogWithPrefix sets up logging switching to a log file if
 // necessary, given a prefix and a default log path.  Returns a valid
 // logger even on error, which are non-fatal, thus errors from this
@@ -576,6 +789,38 @@ func Init(
 		ctx, kbCtx, params, keybaseServiceCn, onInterruptFn, log, "kbfs")
 }
 
+func parseSyncScheduleParam(s string) (SyncSchedule, error) {
+	if s == "always" {
+		return SyncSchedule{}, nil
+	}
+	return ParseSyncSchedule(s)
+}
+
+// setSyncSchedulesFromParams overrides any persisted sync schedules
+// that were given in `params`.
+func setSyncSchedulesFromParams(
+	ctx context.Context, config Config, params InitParams) (err error) {
+	if params.JournalFlushSchedule == "" && params.PrefetchSchedule == "" {
+		return nil
+	}
+	schedules := config.SyncSchedules()
+	if params.JournalFlushSchedule != "" {
+		schedules.JournalFlush, err = parseSyncScheduleParam(
+			params.JournalFlushSchedule)
+		if err != nil {
+			return err
+		}
+	}
+	if params.PrefetchSchedule != "" {
+		schedules.Prefetch, err = parseSyncScheduleParam(
+			params.PrefetchSchedule)
+		if err != nil {
+			return err
+		}
+	}
+	return config.SetSyncSchedules(ctx, schedules)
+}
+
 func doInit(
 	ctx context.Context, kbCtx Context, params InitParams,
 	keybaseServiceCn KeybaseServiceCn, log logger.Logger,
@@ -594,12 +839,30 @@ func doInit(
 	case InitConstrainedString:
 		log.CDebugf(ctx, "Initializing in constrained mode")
 		mode = InitConstrained
+	case InitBackupString:
+		log.CDebugf(ctx, "Initializing in backup mode")
+		mode = InitBackup
 	default:
 		return nil, fmt.Errorf("Unexpected mode: %s", params.Mode)
 	}
 
+	if err := CheckClientID(params.ClientID); err != nil {
+		return nil, err
+	}
+
+	if params.LogLockInversions {
+		EnableLockOrderLogging(log)
+	}
+
 	initMode := NewInitModeFromType(mode)
 
+	// Claim the storage root before anything opens files in it.
+	releaseStorageRoot, storageRootGuest, err := claimStorageRoot(
+		ctx, &params, log)
+	if err != nil {
+		return nil, err
+	}
+
 	config := NewConfigLocal(initMode,
 		func(module string) logger.Logger {
 			mname := logPrefix
@@ -614,6 +877,12 @@ func doInit(
 			}
 			return lg
 		}, params.StorageRoot, params.DiskCacheMode, kbCtx)
+	config.releaseStorageRoot = releaseStorageRoot
+	if params.ClientID != "" {
+		log.CDebugf(ctx, "Identifying to servers as client %q",
+			params.ClientID)
+		config.SetClientID(params.ClientID)
+	}
 
 	if params.CleanBlockCacheCapacity > 0 {
 		log.CDebugf(
@@ -637,7 +906,16 @@ func doInit(
 	if err != nil {
 		return nil, err
 	}
-	config.SetBlockSplitter(bsplitter)
+	if params.AdaptiveBlockSizes {
+		bsplitWithClasses, err := NewBlockSplitterWithSizeClasses(
+			bsplitter, 8*1024, config.Codec())
+		if err != nil {
+			return nil, err
+		}
+		config.SetBlockSplitter(bsplitWithClasses)
+	} else {
+		config.SetBlockSplitter(bsplitter)
+	}
 
 	if registry := config.MetricsRegistry(); registry != nil {
 		keyCache := config.KeyCache()
@@ -653,7 +931,18 @@ func doInit(
 	config.SetBlockCryptVersion(
 		kbfscrypto.EncryptionVer(params.BlockCryptVersion))
 	config.SetTLFValidDuration(params.TLFValidDuration)
-	config.SetBGFlushPeriod(params.BGFlushPeriod)
+	if params.BGFlushPeriod > 0 {
+		config.SetBGFlushPeriod(params.BGFlushPeriod)
+	}
+	if params.DirTimesBatchPeriod < 0 {
+		config.SetDirTimesBatchPeriod(0)
+	} else if params.DirTimesBatchPeriod > 0 {
+		config.SetDirTimesBatchPeriod(params.DirTimesBatchPeriod)
+	}
+	config.SetOpTimeouts(params.OpTimeouts)
+	if params.DisableSiblingPrefetch {
+		config.SetSiblingPrefetchEnabled(false)
+	}
 
 	kbfsLog := config.MakeLogger("")
 
@@ -675,10 +964,22 @@ func doInit(
 	config.SetChat(chat)
 
 	// Initialize KBPKI client (needed for KBFSOps and MD Server).
-	k := NewKBPKIClient(config, kbfsLog)
+	var k KBPKI = NewKBPKIClient(config, kbfsLog)
+	if params.PublicReadOnly {
+		log.CDebugf(ctx, "Serving public folders read-only, without a session")
+		k = NewKBPKIAnonymous(k)
+		config.SetPublicReadOnly(true)
+	}
 	config.SetKBPKI(k)
 
 	kbfsOps := NewKBFSOpsStandard(kbCtx, config)
+	if params.IdleReclaimDuration > 0 {
+		kbfsOps.enableIdleReclamation(params.IdleReclaimDuration)
+	}
+	if !params.FolderLimits.isUnlimited() {
+		log.CDebugf(ctx, "Limiting each folder to %+v", params.FolderLimits)
+		kbfsOps.enableFolderLimits(params.FolderLimits)
+	}
 	config.SetKBFSOps(kbfsOps)
 	config.SetNotifier(kbfsOps)
 	config.SetKeyManager(NewKeyManagerStandard(config))
@@ -690,6 +991,10 @@ func doInit(
 	config.SetKeybaseService(service)
 
 	config.SetReporter(NewReporterKBPKI(config, 10, 1000))
+	if err := RegisterNotificationSinks(
+		config, params.NotificationSinks); err != nil {
+		return nil, err
+	}
 
 	// Initialize Crypto client (needed for MD and Block servers).
 	crypto, err := keybaseServiceCn.NewCrypto(config, params, kbCtx, kbfsLog)
@@ -723,13 +1028,24 @@ func doInit(
 	if err != nil {
 		return nil, fmt.Errorf("cannot open block database: %+v", err)
 	}
+	bserv, err = makeBlockServerRegions(
+		config, bserv, params.BServerRegions, kbCtx.NewRPCLogFactory(), log)
+	if err != nil {
+		return nil, fmt.Errorf("cannot set up data regions: %+v", err)
+	}
+	config.enableDataRegions()
 	if registry := config.MetricsRegistry(); registry != nil {
 		bserv = NewBlockServerMeasured(bserv, registry)
 	}
+	// Account for data where it reaches the server, below any
+	// journal that's enabled later.
+	bserv = newBlockServerAccounting(
+		bserv, config, config.MakeLogger(""), config.MetricsRegistry())
 	config.SetBlockServer(bserv)
 
 	config.SetDiskBlockCacheFraction(params.DiskBlockCacheFraction)
 	config.SetSyncBlockCacheFraction(params.SyncBlockCacheFraction)
+	config.SetSharedDiskCacheDir(params.SharedDiskCacheDir)
 
 	err = config.MakeDiskBlockCacheIfNotExists()
 	if err != nil {
@@ -745,7 +1061,7 @@ func doInit(
 			params.DiskCacheMode.String())
 	}
 
-	if config.Mode().KBFSServiceEnabled() {
+	if config.Mode().KBFSServiceEnabled() && !storageRootGuest {
 		// Initialize kbfsService only when we run a full KBFS process.
 		// This requires the disk block cache to have been initialized, if it
 		// should be initialized.
@@ -764,12 +1080,34 @@ func doInit(
 		log.CWarningf(ctx, "Could not enable disk limiter: %+v", err)
 		return nil, err
 	}
+	if params.StorageRoot != "" {
+		err = config.EnableDiskKeyBundleCache()
+		if err != nil {
+			// Synced TLFs will just need the server for their
+			// key bundles.
+			log.CWarningf(ctx,
+				"Could not enable disk key bundle cache: %+v", err)
+		}
+		err = config.EnableDiskResolverCache()
+		if err != nil {
+			// Handles will just need the service to resolve.
+			log.CWarningf(ctx,
+				"Could not enable disk resolver cache: %+v", err)
+		}
+		err = config.EnableDiskAccessLog()
+		if err != nil {
+			// Reads just won't be logged.
+			log.CWarningf(ctx,
+				"Could not enable disk access log: %+v", err)
+		}
+	}
 	ctx10s, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 	// TODO: Don't turn on journaling if either -bserver or
 	// -mdserver point to local implementations.
-	if params.EnableJournal && config.Mode().JournalEnabled() {
-		journalRoot := filepath.Join(params.StorageRoot, "kbfs_journal")
+	if params.EnableJournal && config.Mode().JournalEnabled() &&
+		!params.PublicReadOnly {
+		journalRoot := filepath.Join(params.StorageRoot, journalStorageDirName)
 		err = config.EnableJournaling(ctx10s, journalRoot,
 			params.TLFJournalBackgroundWorkStatus)
 		if err != nil {
@@ -778,13 +1116,37 @@ func doInit(
 		log.CDebugf(ctx, "Journaling enabled")
 	}
 
-	if params.BGFlushDirOpBatchSize < 1 {
+	if params.BGFlushDirOpBatchSize < 0 {
 		return nil, fmt.Errorf(
 			"Illegal sync batch size: %d", params.BGFlushDirOpBatchSize)
+	} else if params.BGFlushDirOpBatchSize > 0 {
+		config.SetBGFlushDirOpBatchSize(params.BGFlushDirOpBatchSize)
 	}
 	log.CDebugf(ctx, "Enabling a dir op batch size of %d",
-		params.BGFlushDirOpBatchSize)
-	config.SetBGFlushDirOpBatchSize(params.BGFlushDirOpBatchSize)
+		config.BGFlushDirOpBatchSize())
+
+	if err := setSyncSchedulesFromParams(ctx, config, params); err != nil {
+		return nil, err
+	}
+
+	if policy := makeIdentifyPolicy(config, params); policy != nil {
+		log.CDebugf(ctx, "Enabling identify policy: min writer proofs=%d, "+
+			"require break acks=%t", params.IdentifyMinWriterProofs,
+			params.IdentifyRequireBreakAcks)
+		config.SetIdentifyPolicy(policy)
+	}
+
+	if params.DeviceConstraintsMode != DeviceConstraintsModeIgnore {
+		config.startDeviceConstraintsMonitor(params.DeviceConstraintsMode)
+	}
+
+	if params.ExpiryEnforcementPeriod > 0 {
+		config.startExpiryEnforcer(params.ExpiryEnforcementPeriod)
+	}
+
+	// Let users dump the internal state (e.g., during a hang) with
+	// `kill -USR1`.
+	config.startStateDumpHandler(kbCtx.GetLogDir(), log)
 
 	return config, nil
 }
@@ -5,6 +5,7 @@
 package libkbfs
 
 import (
+	"io"
 	"time"
 
 	kbname "github.com/keybase/client/go/kbun"
@@ -96,6 +97,237 @@ type syncedTlfGetterSetter interface {
 	SetTlfSyncState(tlfID tlf.ID, isSynced bool) error
 }
 
+type writeThroughTlfGetterSetter interface {
+	// IsWriteThroughTlf returns true if writes to the given TLF
+	// should skip the local journal and go straight to the
+	// servers.
+	IsWriteThroughTlf(tlfID tlf.ID) bool
+	// SetTlfWriteThroughState records, in memory, whether the
+	// given TLF is in write-through mode, as set by its settings
+	// file.  Callers should use SetTlfWriteThrough instead, which
+	// also changes the settings file and takes care of the TLF's
+	// journal.
+	SetTlfWriteThroughState(tlfID tlf.ID, writeThrough bool)
+}
+
+type tlfDataRegionGetter interface {
+	// TlfDataRegion returns the data region that the blocks of the
+	// given TLF must be put to, or "" if the TLF isn't constrained,
+	// as last known.  `loaded` is false if it hasn't been read from
+	// the TLF's settings yet in this process, in which case the
+	// region is the one persisted by an earlier process, if any.
+	TlfDataRegion(tlfID tlf.ID) (region string, loaded bool)
+	// WaitForTlfDataRegion is like TlfDataRegion, but waits until
+	// the data region has been read from the TLF's settings in this
+	// process, or until `ctx` is done.
+	WaitForTlfDataRegion(ctx context.Context, tlfID tlf.ID) (string, error)
+}
+
+type tlfDataRegionGetterSetter interface {
+	tlfDataRegionGetter
+	// DataRegionsEnforced returns true if the blocks of each TLF
+	// are routed to the block server of its data region.
+	DataRegionsEnforced() bool
+	// SetTlfDataRegion persists the data region of the given TLF,
+	// as just read from its settings, and wakes up anyone waiting
+	// for it; "" removes the constraint.
+	SetTlfDataRegion(tlfID tlf.ID, region string) error
+}
+
+type deviceConstraintsStatusGetter interface {
+	// DeviceConstraintsStatus returns the current device constraints,
+	// and which background work is paused because of them.
+	DeviceConstraintsStatus() DeviceConstraintsStatus
+}
+
+// ContentScanVerdict is the result of scanning a file's contents.
+type ContentScanVerdict int
+
+const (
+	// ContentScanClean means the file may be synced as usual.
+	ContentScanClean ContentScanVerdict = iota
+	// ContentScanRejected means the file must not leave this device.
+	ContentScanRejected
+)
+
+// ContentScanner inspects the plaintext contents of files written to
+// KBFS, before they are flushed to the servers; e.g., to scan team
+// folders for malware.
+type ContentScanner interface {
+	// ScanFile is called, before a file with unsynced changes is
+	// synced, with its canonical path and a reader for its full
+	// plaintext, as it will be after the sync.  If it returns
+	// ContentScanRejected, the contents of the file are moved into
+	// a local quarantine directory and the file is truncated,
+	// before anything leaves this device; if there's nowhere to
+	// keep them, the sync fails instead.  A non-nil error also
+	// fails the sync.
+	ScanFile(ctx context.Context, path string, contents io.Reader) (
+		verdict ContentScanVerdict, reason string, err error)
+}
+
+// BlockTransform adds a layer of encryption to the payloads of
+// blocks, on top of KBFS's own, before they leave this device; e.g.,
+// with keys held in an organization's KMS.  The ID of the key used
+// is recorded with each block, so keys can be rotated without losing
+// access to older blocks.
+type BlockTransform interface {
+	// WrapBlock transforms the encrypted payload of a block of the
+	// given TLF before it's put, and returns the ID of the key it
+	// used, which may be at most 255 bytes long.
+	WrapBlock(ctx context.Context, tlfID tlf.ID, payload []byte) (
+		wrapped []byte, keyID string, err error)
+	// UnwrapBlock undoes WrapBlock, given the ID of the key it used.
+	UnwrapBlock(ctx context.Context, tlfID tlf.ID, keyID string,
+		wrapped []byte) (payload []byte, err error)
+}
+
+// IdentifyPolicy decides whether a folder may be accessed once the
+// identifies of its members have succeeded, for organizations with
+// stricter trust requirements than the default.
+type IdentifyPolicy interface {
+	// CheckFolder is called before a folder is first accessed, with
+	// its handle and any broken tracks of its members.  Breaks are
+	// only reported when the identify behavior warns about them
+	// rather than failing.  A non-nil error denies access to the
+	// folder, and the check is repeated on the next access.
+	CheckFolder(ctx context.Context, h *TlfHandle,
+		breaks keybase1.TLFBreak) error
+}
+
+type identifyPolicyGetter interface {
+	// IdentifyPolicy returns the configured identify policy, or nil
+	// if there is none.
+	IdentifyPolicy() IdentifyPolicy
+}
+
+// StorageAccountant is told about the block data that KBFS writes to
+// and deletes from the block server for each TLF, so that
+// applications embedding KBFS can do their own accounting.  Sizes
+// are encoded block sizes, as charged against quota; metadata isn't
+// charged, and isn't reported.  The methods are called
+// synchronously from block server calls, so they must not block.
+type StorageAccountant interface {
+	// BytesWritten is called after a block of `bytes` bytes is put
+	// to the server for the given TLF.  Writes to the local journal
+	// are only reported once they're flushed to the server.
+	BytesWritten(ctx context.Context, tlfID tlf.ID, bytes int64)
+	// BytesDeleted is called after the server loses the last
+	// references to blocks of the given TLF totalling `bytes`
+	// bytes, and may delete them.
+	BytesDeleted(ctx context.Context, tlfID tlf.ID, bytes int64)
+}
+
+type storageAccountantGetter interface {
+	// StorageAccountant returns the configured storage accountant,
+	// or nil if there is none.
+	StorageAccountant() StorageAccountant
+}
+
+// OpenFile describes an open handle of a file in the local mount of
+// KBFS.
+type OpenFile struct {
+	Path string `json:"path"`
+	Tlf  tlf.ID `json:"tlf"`
+	// Pid is the ID of the process that opened the file, or 0 if
+	// the platform doesn't say.
+	Pid int `json:"pid,omitempty"`
+	// Mode is "r", "w" or "rw".
+	Mode string `json:"mode"`
+	// UnsyncedBytes is how much data written to the file hasn't been
+	// synced to the journal or the server yet, across all handles.
+	UnsyncedBytes int64 `json:"unsynced_bytes"`
+}
+
+// MountStatus describes the local mount of KBFS.
+type MountStatus struct {
+	Mounted    bool
+	MountPoint string
+	// OpenFiles are the canonical paths of the files currently open
+	// through the mount.
+	OpenFiles []string
+}
+
+// MountManager controls the local mount of KBFS in processes that
+// serve one, so that other applications can mount and unmount it
+// without platform-specific commands.  Unmounting through it doesn't
+// stop the process, so KBFS can be mounted again later.
+type MountManager interface {
+	// MountStatus returns the current state of the mount.
+	MountStatus(ctx context.Context) (MountStatus, error)
+	// Mount mounts KBFS, if it isn't mounted already.
+	Mount(ctx context.Context) error
+	// Unmount unmounts KBFS, if it's mounted.  Unless `force` is
+	// true, it fails with a MountBusyError listing the open files
+	// when there are any; otherwise they're cut off.
+	Unmount(ctx context.Context, force bool) error
+	// OpenFiles returns every handle open through the mount, sorted
+	// by path.
+	OpenFiles(ctx context.Context) ([]OpenFile, error)
+}
+
+type mountManagerGetter interface {
+	// MountManager returns the configured mount manager, or nil if
+	// this process doesn't serve a mount.
+	MountManager() MountManager
+}
+
+type contentScannerGetter interface {
+	// ContentScanner returns the configured content scanner, or nil
+	// if there is none.
+	ContentScanner() ContentScanner
+}
+
+type blockTransformGetter interface {
+	// BlockTransform returns the configured block transform, or nil
+	// if there is none.
+	BlockTransform() BlockTransform
+}
+
+type webhooksGetter interface {
+	// Webhooks returns the folders that have webhooks, and their
+	// webhooks.
+	Webhooks() Webhooks
+}
+
+type eventRulesGetter interface {
+	// EventRules returns the rules that map KBFS events to actions.
+	EventRules() EventRules
+	// EventRuleEngine returns the engine that evaluates EventRules.
+	EventRuleEngine() *EventRuleEngine
+}
+
+type publicReadOnlyGetter interface {
+	// PublicReadOnly returns true if KBFS is acting as an anonymous
+	// device, that serves only public folders, read-only.
+	PublicReadOnly() bool
+}
+
+type clientIDGetter interface {
+	// ClientID returns the identifier of the application embedding
+	// KBFS, which is attached to requests to the servers, or "" if
+	// there is none.
+	ClientID() string
+}
+
+type opTimeoutsGetter interface {
+	// OpTimeouts returns how long each class of operation coming
+	// from a file system interface may take.
+	OpTimeouts() OpTimeouts
+}
+
+type teamRenamesGetter interface {
+	// TeamRenames returns the known renames of team folders, keyed
+	// by the old team name.
+	TeamRenames() TeamRenames
+}
+
+type syncSchedulesGetter interface {
+	// SyncSchedules returns the schedules that restrict when
+	// background syncing may happen.
+	SyncSchedules() SyncSchedules
+}
+
 type blockRetrieverGetter interface {
 	BlockRetriever() BlockRetriever
 }
@@ -333,11 +565,26 @@ type KBFSOps interface {
 	// permission for the top-level folder.  This is a remote-access
 	// operation.
 	GetDirChildren(ctx context.Context, dir Node) (map[string]EntryInfo, error)
+	// GetDirChildrenPage returns up to `max` children of the
+	// directory, sorted by name, whose names sort after `after`.  A
+	// page with fewer than `max` children is the last one.  Unlike
+	// GetDirChildren, it only reads the parts of the directory
+	// holding the page, so large directories can be listed
+	// incrementally.  This is a remote-access operation.
+	GetDirChildrenPage(ctx context.Context, dir Node, after string,
+		max int) ([]DirChild, error)
 	// Lookup returns the Node and entry info associated with a
 	// given name in a directory, if the logged-in user has read
 	// permissions to the top-level folder.  The returned Node is nil
 	// if the name is a symlink.  This is a remote-access operation.
 	Lookup(ctx context.Context, dir Node, name string) (Node, EntryInfo, error)
+	// LookupAndReadSmall is like Lookup, but if the name is a
+	// regular file of at most `maxSize` bytes, it also returns the
+	// file's contents, saving the caller separate stat and read
+	// calls.  The returned data is nil if the caller must read the
+	// file itself.  This is a remote-access operation.
+	LookupAndReadSmall(ctx context.Context, dir Node, name string,
+		maxSize int64) (Node, EntryInfo, []byte, error)
 	// Stat returns the entry info associated with a
 	// given Node, if the logged-in user has read permissions to the
 	// top-level folder.  This is a remote-access operation.
@@ -373,6 +620,14 @@ type KBFSOps interface {
 	// given node, if the logged-in user has write permission to the
 	// top-level folder.  This is a remote-sync operation.
 	RemoveEntry(ctx context.Context, dir Node, name string) error
+	// RemoveTree removes the directory entry represented by the
+	// given node, along with everything under it if it's a
+	// directory, if the logged-in user has write permission to the
+	// top-level folder.  The removals are batched into a few MD
+	// revisions, and `progress`, if non-nil, is called after each
+	// one.  This is a remote-sync operation.
+	RemoveTree(ctx context.Context, dir Node, name string,
+		progress func(RemoveTreeProgress)) error
 	// Rename performs an atomic rename operation with a given
 	// top-level folder if the logged-in user has write permission to
 	// that folder, and will return an error if nodes from different
@@ -467,6 +722,76 @@ type KBFSOps interface {
 	// outstanding writes from the local device.
 	GetUpdateHistory(ctx context.Context, folderBranch FolderBranch) (
 		history TLFUpdateHistory, err error)
+	// GetHeldRevisions returns the merged revisions of the given
+	// folder whose unreferenced data is currently retained by a
+	// legal hold, newest first.
+	GetHeldRevisions(ctx context.Context, folderBranch FolderBranch) (
+		held []HeldRevision, err error)
+	// VerifySyncCache compares the sync cache contents of a synced
+	// folder against the blocks reachable from its latest merged
+	// revision, and optionally repairs any differences.
+	VerifySyncCache(ctx context.Context, folderBranch FolderBranch,
+		repair bool) (SyncCacheReport, error)
+	// FindLeakedBlockRefs cross-checks the block references recorded
+	// in the MD history of the given folder against the data
+	// reachable from its latest merged revision, and optionally
+	// releases the leaked ones to reclaim their quota.
+	FindLeakedBlockRefs(ctx context.Context, folderBranch FolderBranch,
+		release bool) (BlockRefLeakReport, error)
+	// PinFileRange fetches the given range of a file of a synced
+	// folder into the sync cache, for files that are only synced
+	// sparsely.
+	PinFileRange(ctx context.Context, file Node, off, length int64) error
+	// PinNode keeps the entry of the given node in its folder's node
+	// cache, so that the holders of its Nodes are never asked to
+	// release them when the cache is over its limit (see
+	// NodeEvictionObserver).  Mounts pin the nodes they have open
+	// handles to.  Each call must be matched by a call to UnpinNode.
+	PinNode(ctx context.Context, node Node)
+	// UnpinNode undoes one previous call to PinNode.
+	UnpinNode(ctx context.Context, node Node)
+	// GetQuotaReclamationStatus returns how much of the given
+	// folder's quota usage is held only by old revisions, and when
+	// it can be reclaimed.
+	GetQuotaReclamationStatus(ctx context.Context,
+		folderBranch FolderBranch) (QuotaReclamationStatus, error)
+	// ForceQuotaReclamation garbage collects the blocks that are
+	// held only by old enough revisions of the given folder right
+	// away, instead of waiting for the next automatic reclamation,
+	// and returns once it's done.
+	ForceQuotaReclamation(ctx context.Context,
+		folderBranch FolderBranch) error
+	// GetRecentFiles returns up to `limit` files in the given folder
+	// that were recently read or written on this device, and whose
+	// paths contain `filter`, most recent first.
+	GetRecentFiles(ctx context.Context, folderBranch FolderBranch,
+		filter string, limit int) ([]RecentFile, error)
+	// GetFolderStats returns aggregate stats about the given folder,
+	// like its file count and total size, as of its latest revision.
+	// Only the directories that changed since the last call are
+	// read.
+	GetFolderStats(ctx context.Context, folderBranch FolderBranch) (
+		FolderStats, error)
+	// GetIdentifyBreaks returns the broken proofs of the given
+	// folder's members, as of its last identify, and whether there
+	// are any.
+	GetIdentifyBreaks(ctx context.Context, folderBranch FolderBranch) (
+		status IdentifyBreaksStatus, ok bool, err error)
+	// RespondToIdentifyBreaks records the user's response to the
+	// broken proofs of the given folder's members: to continue as
+	// usual, or to freeze writes to the folder until the proofs are
+	// fixed.  Newly-broken proofs need a new response.
+	RespondToIdentifyBreaks(ctx context.Context, folderBranch FolderBranch,
+		response IdentifyBreakResponse) error
+	// FreezeWrites makes new writes to the given folder fail with a
+	// WritesFrozenError, and syncs what was already written, so
+	// that an external tool can copy the folder in a consistent
+	// state.  The freeze lapses after `timeout`, unless that's zero,
+	// in case the tool never thaws it.
+	FreezeWrites(ctx context.Context, folderBranch FolderBranch,
+		timeout time.Duration) error
+	// ThawWrites lifts a freeze made by FreezeWrites.
+	ThawWrites(ctx context.Context, folderBranch FolderBranch) error
 	// GetEditHistory returns the edit history of the TLF, clustered
 	// by writer.
 	GetEditHistory(ctx context.Context, folderBranch FolderBranch) (
@@ -474,6 +799,13 @@ type KBFSOps interface {
 
 	// GetNodeMetadata gets metadata associated with a Node.
 	GetNodeMetadata(ctx context.Context, node Node) (NodeMetadata, error)
+	// GetCanonicalPath returns the current canonical path of a
+	// Node, starting with /keybase.  This is a local-only operation.
+	GetCanonicalPath(ctx context.Context, node Node) (string, error)
+	// GetOpenFolders returns the folder-branch of each TLF that has
+	// been accessed by name since KBFS started, keyed by the name and
+	// type of the TLF.  This is a local-only operation.
+	GetOpenFolders(ctx context.Context) map[Favorite]FolderBranch
 
 	// Shutdown is called to clean up any resources associated with
 	// this KBFSOps instance.
@@ -492,6 +824,18 @@ type KBFSOps interface {
 	// newest version.  It works asynchronously, so no error is
 	// returned.
 	ForceFastForward(ctx context.Context)
+	// FolderUpdated tells KBFS that revision `rev` of the given TLF
+	// was just written by another process on this device, like a
+	// git remote helper, so that it can be fetched and any cached
+	// views of the folder refreshed without waiting for the server
+	// to announce it.  It does nothing if the TLF isn't loaded.
+	FolderUpdated(ctx context.Context, tlfID tlf.ID,
+		rev kbfsmd.Revision) error
+	// PurgeCachesPendingRekey deletes the locally-cached blocks of
+	// every loaded private folder that is still keyed for a revoked
+	// device, and requests a rekey of each.  It's meant to be offered
+	// to the user after they revoke one of their devices.
+	PurgeCachesPendingRekey(ctx context.Context) ([]PurgedFolder, error)
 	// InvalidateNodeAndChildren sends invalidation messages for the
 	// given node and all of its children that are currently in the
 	// NodeCache.  It's useful if the caller has outside knowledge of
@@ -536,6 +880,10 @@ type merkleRootGetter interface {
 type gitMetadataPutter interface {
 	PutGitMetadata(ctx context.Context, folder keybase1.Folder,
 		repoID keybase1.RepoID, metadata keybase1.GitLocalMetadata) error
+	// DeleteGitMetadata removes the repo named `repoName` in
+	// `folder` from the service's registry of repos.
+	DeleteGitMetadata(ctx context.Context, folder keybase1.Folder,
+		repoName keybase1.GitRepoName) error
 }
 
 // KeybaseService is an interface for communicating with the keybase
@@ -619,6 +967,11 @@ type KeybaseService interface {
 		desiredUser keybase1.UserVersion, desiredKey kbfscrypto.VerifyingKey,
 		desiredRole keybase1.TeamRole) (TeamInfo, error)
 
+	// LoadPGPPublicKeys returns the armored public PGP keys that the
+	// user with the specified UID has proven in their sigchain, and
+	// hasn't revoked.
+	LoadPGPPublicKeys(ctx context.Context, uid keybase1.UID) ([]string, error)
+
 	// CurrentSession returns a SessionInfo struct with all the
 	// information for the current session, or an error otherwise.
 	CurrentSession(ctx context.Context, sessionID int) (SessionInfo, error)
@@ -806,6 +1159,11 @@ type KBPKI interface {
 		verifyingKey kbfscrypto.VerifyingKey,
 		atServerTime time.Time) error
 
+	// IsTeamAdmin returns whether the given user is an owner or an
+	// admin of the given team.
+	IsTeamAdmin(ctx context.Context, tid keybase1.TeamID, uid keybase1.UID) (
+		bool, error)
+
 	// GetCryptPublicKeys gets all of a user's crypt public keys (including
 	// paper keys).
 	GetCryptPublicKeys(ctx context.Context, uid keybase1.UID) (
@@ -990,6 +1348,16 @@ type Reporter interface {
 	Shutdown()
 }
 
+// NotificationSink is somewhere ReporterKBPKI delivers notifications
+// to, like the Keybase GUI, the desktop, or syslog.
+type NotificationSink interface {
+	// Notify delivers `notification`, which has the given severity.
+	// It's called from a single goroutine, so it should return
+	// promptly; errors are only logged.
+	Notify(ctx context.Context, notification *keybase1.FSNotification,
+		severity NotificationSeverity) error
+}
+
 // MDCache gets and puts plaintext top-level metadata into the cache.
 type MDCache interface {
 	// Get gets the metadata object associated with the given TLF ID,
@@ -1935,6 +2303,17 @@ type Observer interface {
 	TlfHandleChange(ctx context.Context, newHandle *TlfHandle)
 }
 
+// NodeEvictionObserver is an optional interface for Observers, like
+// mounts, that hold on to the Nodes they're given.  When a folder's
+// node cache grows past its limit, the folder asks them to release
+// the least recently used Nodes that aren't pinned.
+type NodeEvictionObserver interface {
+	// EvictNodes asks the observer to release its Nodes for the
+	// given entries, if it can.  Each NodeChange lists, in
+	// DirUpdated, the names of entries in the directory of its Node.
+	EvictNodes(ctx context.Context, changes []NodeChange)
+}
+
 // Notifier notifies registrants of directory changes
 type Notifier interface {
 	// RegisterForChanges declares that the given Observer wants to
@@ -1991,6 +2370,18 @@ type InitMode interface {
 	// BackgroundFlushesEnabled indicates if we should periodically be
 	// flushing unsynced dirty writes to the server or journal.
 	BackgroundFlushesEnabled() bool
+	// BackgroundFlushPeriod indicates how long to wait for a batch
+	// of writes to fill up before flushing it in the background.
+	BackgroundFlushPeriod() time.Duration
+	// BackgroundFlushDirOpBatchSize indicates how many directory
+	// operations may be batched together in a single background
+	// flush.
+	BackgroundFlushDirOpBatchSize() int
+	// DirMtimeGranularity indicates how stale a directory's mtime
+	// and ctime may get before a change to the directory's entries
+	// updates them.  If it's 0, every change updates them, as POSIX
+	// requires; only modes for special workloads should relax that.
+	DirMtimeGranularity() time.Duration
 	// MetricsEnabled indicates if we should be collecting metrics.
 	MetricsEnabled() bool
 	// ConflictResolutionEnabled indicated if we should be running
@@ -2078,7 +2469,36 @@ type Config interface {
 	clockGetter
 	diskLimiterGetter
 	syncedTlfGetterSetter
+	writeThroughTlfGetterSetter
+	tlfDataRegionGetterSetter
 	initModeGetter
+	deviceConstraintsStatusGetter
+	syncSchedulesGetter
+	contentScannerGetter
+	SetContentScanner(ContentScanner)
+	blockTransformGetter
+	SetBlockTransform(BlockTransform)
+	identifyPolicyGetter
+	SetIdentifyPolicy(IdentifyPolicy)
+	storageAccountantGetter
+	SetStorageAccountant(StorageAccountant)
+	mountManagerGetter
+	SetMountManager(MountManager)
+	webhooksGetter
+	// SetWebhooks persists a new set of folder webhooks.
+	SetWebhooks(webhooks Webhooks) error
+	eventRulesGetter
+	// SetEventRules persists a new set of event rules.
+	SetEventRules(rules EventRules) error
+	publicReadOnlyGetter
+	clientIDGetter
+	opTimeoutsGetter
+	teamRenamesGetter
+	// SetTeamRenames persists a new set of known team renames.
+	SetTeamRenames(renames TeamRenames) error
+	// SetSyncSchedules persists new sync schedules, and applies them
+	// right away.
+	SetSyncSchedules(ctx context.Context, schedules SyncSchedules) error
 	Tracer
 	KBFSOps() KBFSOps
 	SetKBFSOps(KBFSOps)
@@ -2140,6 +2560,11 @@ type Config interface {
 	// be true except for during some testing.
 	DoBackgroundFlushes() bool
 	SetDoBackgroundFlushes(bool)
+	// SiblingPrefetchEnabled says whether the blocks of the next few
+	// files in a directory should be prefetched when files there
+	// are opened one after another in name order.
+	SiblingPrefetchEnabled() bool
+	SetSiblingPrefetchEnabled(bool)
 	// RekeyWithPromptWaitTime indicates how long to wait, after
 	// setting the rekey bit, before prompting for a paper key.
 	RekeyWithPromptWaitTime() time.Duration
@@ -2199,6 +2624,16 @@ type Config interface {
 	// before syncing a set of changes to the servers.
 	SetBGFlushPeriod(p time.Duration)
 
+	// DirTimesBatchPeriod returns how long a directory's mtime and
+	// ctime may lag behind changes to its entries.  Changes within
+	// the period after the last update are batched into it, instead
+	// of rewriting the directory's entry in its parent each time.
+	// Zero updates them on every change, as POSIX requires.
+	DirTimesBatchPeriod() time.Duration
+	// SetDirTimesBatchPeriod sets how long a directory's mtime and
+	// ctime may lag behind changes to its entries.
+	SetDirTimesBatchPeriod(p time.Duration)
+
 	// Shutdown is called to free config resources.
 	Shutdown(context.Context) error
 	// CheckStateOnShutdown tells the caller whether or not it is safe
@@ -2279,6 +2714,23 @@ type NodeCache interface {
 	// AddRootWrapper adds a new wrapper function that will be applied
 	// whenever a root Node is created.
 	AddRootWrapper(func(Node) Node)
+	// Pin marks the given node as in-use, so that its entry stays in
+	// the cache, and can be found by reference, even after all of its
+	// Nodes are released, and so that it's never an eviction
+	// candidate.  Each call must be matched by a call to Unpin.
+	Pin(node Node)
+	// Unpin undoes one previous call to Pin.
+	Unpin(node Node)
+	// Stats returns a summary of what the cache currently holds.
+	Stats() NodeCacheStats
+	// EvictionCandidates returns the least recently used entries that
+	// aren't pinned, as many as the cache holds beyond its limit,
+	// grouped by parent directory: each NodeChange lists the names
+	// of the entries in its Node's DirUpdated.  The cache can't drop
+	// entries whose Nodes are still held, so it's up to the holders
+	// to release them.  Returns nil when the cache is within its
+	// limit.
+	EvictionCandidates() []NodeChange
 }
 
 // fileBlockDeepCopier fetches a file block, makes a deep copy of it
@@ -17,6 +17,7 @@ import (
 	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfscrypto"
 	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/kbfssync"
 	"github.com/keybase/kbfs/tlf"
 	"github.com/pkg/errors"
 	"golang.org/x/net/context"
@@ -123,6 +124,9 @@ type JournalServer struct {
 	delegateMDOps           MDOps
 	onBranchChange          branchChangeListener
 	onMDFlush               mdFlushListener
+	// flushBudget limits the block puts of all background journal
+	// flushes; see withBackgroundFlush.
+	flushBudget *kbfssync.Semaphore
 
 	// Just protects lastQuotaError.
 	lastQuotaErrorLock sync.Mutex
@@ -132,6 +136,9 @@ type JournalServer struct {
 	lastDiskLimitErrorLock sync.Mutex
 	lastDiskLimitError     time.Time
 
+	scheduleShutdownCh   chan struct{}
+	scheduleShutdownOnce sync.Once
+
 	// Protects all fields below.
 	lock                sync.RWMutex
 	currentUID          keybase1.UID
@@ -140,6 +147,10 @@ type JournalServer struct {
 	dirtyOps            map[tlf.ID]uint
 	dirtyOpsDone        *sync.Cond
 	serverConfig        journalServerConfig
+	// deviceConstrained is true if background work should be paused
+	// for the journals of all non-synced TLFs, because the device is
+	// on battery power or a metered network.
+	deviceConstrained bool
 }
 
 func makeJournalServer(
@@ -163,13 +174,15 @@ func makeJournalServer(
 		onMDFlush:               onMDFlush,
 		tlfJournals:             make(map[tlf.ID]*tlfJournal),
 		dirtyOps:                make(map[tlf.ID]uint),
+		scheduleShutdownCh:      make(chan struct{}),
+		flushBudget:             makeBackgroundFlushBudget(),
 	}
 	jServer.dirtyOpsDone = sync.NewCond(&jServer.lock)
 	return &jServer
 }
 
 func (j *JournalServer) rootPath() string {
-	return filepath.Join(j.dir, "v1")
+	return filepath.Join(j.dir, journalStorageVersionDir())
 }
 
 func (j *JournalServer) configPath() string {
@@ -232,6 +245,9 @@ func (j *JournalServer) getTLFJournal(
 		return tlfJournal, enableAuto, enableAutoSetByUser, ok
 	}
 	tlfJournal, enableAuto, enableAutoSetByUser, ok := getJournalFn()
+	if ok && j.config.IsWriteThroughTlf(tlfID) {
+		j.disableFlushedJournal(tlfID, tlfJournal)
+	}
 	if !ok && enableAuto {
 		ctx := context.TODO() // plumb through from callers
 
@@ -256,14 +272,13 @@ func (j *JournalServer) getTLFJournal(
 		if !isWriter {
 			return nil, false
 		}
+		if j.config.IsWriteThroughTlf(tlfID) {
+			return nil, false
+		}
 
 		j.log.CDebugf(ctx, "Enabling a new journal for %s (enableAuto=%t, set by user=%t)",
 			tlfID, enableAuto, enableAutoSetByUser)
-		bws := TLFJournalBackgroundWorkEnabled
-		if j.config.Mode().Type() == InitSingleOp {
-			bws = TLFJournalSingleOpBackgroundWorkEnabled
-		}
-		err = j.Enable(ctx, tlfID, h, bws)
+		err = j.Enable(ctx, tlfID, h, journalBackgroundWorkStatus(j.config))
 		if err != nil {
 			j.log.CWarningf(ctx, "Couldn't enable journal for %s: %+v", tlfID, err)
 			return nil, false
@@ -273,6 +288,38 @@ func (j *JournalServer) getTLFJournal(
 	return tlfJournal, ok
 }
 
+// disableFlushedJournal disables the journal of a write-through TLF,
+// once everything in it has been flushed.  Until then, the journal
+// keeps being used, so that no write skips ahead of the ones still
+// waiting in it.
+func (j *JournalServer) disableFlushedJournal(
+	tlfID tlf.ID, tlfJournal *tlfJournal) {
+	blockEntryCount, mdEntryCount, err := tlfJournal.getJournalEntryCounts()
+	if err != nil || blockEntryCount > 0 || mdEntryCount > 0 {
+		// Either already disabled, or not flushed yet.
+		return
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.dirtyOps[tlfID] > 0 || j.delegateDirtyBlockCache.IsAnyDirty(tlfID) {
+		return
+	}
+	wasEnabled, err := tlfJournal.disable()
+	switch errors.Cause(err).(type) {
+	case nil:
+		if wasEnabled {
+			j.log.CDebugf(nil,
+				"Disabled flushed journal for write-through TLF %s", tlfID)
+		}
+	case errTLFJournalNotEmpty:
+		// Something was written in the meantime.
+	default:
+		j.log.CDebugf(nil, "Couldn't disable journal for %s: %+v",
+			tlfID, err)
+	}
+}
+
 func (j *JournalServer) hasTLFJournal(tlfID tlf.ID) bool {
 	j.lock.RLock()
 	defer j.lock.RUnlock()
@@ -507,6 +554,12 @@ func (j *JournalServer) EnableExistingJournals(
 				os.RemoveAll(dir)
 				continue
 			}
+			if j.config.IsWriteThroughTlf(tlfID) {
+				// Keep it until it's flushed; getTLFJournal
+				// disables it after that.
+				j.log.CDebugf(groupCtx, "Enabling journal for "+
+					"write-through TLF %s until it's flushed", tlfID)
+			}
 
 			journalCh <- journalRet{tlfID, tj}
 		}
@@ -603,18 +656,36 @@ func (j *JournalServer) enableLocked(
 		ctx, j.currentUID, j.currentVerifyingKey, tlfDir,
 		tlfID, chargedTo, tlfJournalConfigAdapter{j.config},
 		j.delegateBlockServer,
-		bws, nil, j.onBranchChange, j.onMDFlush, j.config.DiskLimiter())
+		bws, nil, j.onBranchChange, j.onMDFlush, j.config.DiskLimiter(),
+		j.flushBudget)
 	if err != nil {
 		return nil, err
 	}
 
+	if j.deviceConstrained && !j.config.IsSyncedTlf(tlfID) {
+		j.log.CDebugf(ctx, "Pausing new journal for %s due to device "+
+			"constraints", tlfID)
+		tj.pause(journalPauseDeviceConstraints)
+	}
+	if !j.config.SyncSchedules().allowsJournalFlush(
+		tlfID, j.config.Clock().Now()) {
+		j.log.CDebugf(ctx, "Pausing new journal for %s until its "+
+			"scheduled sync window", tlfID)
+		tj.pause(journalPauseSchedule)
+	}
+
 	return tj, nil
 }
 
 // Enable turns on the write journal for the given TLF.  If h is nil,
-// it will be attempted to be fetched from the remote MD server.
+// it will be attempted to be fetched from the remote MD server.  TLFs
+// in write-through mode can't have their journal enabled.
 func (j *JournalServer) Enable(ctx context.Context, tlfID tlf.ID,
 	h *TlfHandle, bws TLFJournalBackgroundWorkStatus) (err error) {
+	if j.config.IsWriteThroughTlf(tlfID) {
+		return errors.Errorf(
+			"Can't enable journal for write-through TLF %s", tlfID)
+	}
 	j.lock.Lock()
 	defer j.lock.Unlock()
 	chargedTo := j.currentUID.AsUserOrTeam()
@@ -727,6 +798,70 @@ func (j *JournalServer) ResumeBackgroundWork(ctx context.Context, tlfID tlf.ID)
 		tlfID)
 }
 
+// setDeviceConstrained pauses (if `constrained` is true) or resumes
+// the background work of the journals of all TLFs that aren't synced.
+// Explicit flushes still go through while paused.
+func (j *JournalServer) setDeviceConstrained(
+	ctx context.Context, constrained bool) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.deviceConstrained == constrained {
+		return
+	}
+	j.log.CDebugf(ctx, "Setting device constrained=%t", constrained)
+	j.deviceConstrained = constrained
+	for tlfID, tj := range j.tlfJournals {
+		if j.config.IsSyncedTlf(tlfID) {
+			continue
+		}
+		if constrained {
+			tj.pause(journalPauseDeviceConstraints)
+		} else {
+			tj.resume(journalPauseDeviceConstraints)
+		}
+	}
+}
+
+// ReleaseQuarantine deletes the local copies of the files of the
+// given TLF that the content scanner rejected.
+func (j *JournalServer) ReleaseQuarantine(
+	ctx context.Context, tlfID tlf.ID) error {
+	j.log.CDebugf(ctx, "Releasing quarantine for %s", tlfID)
+	return ReleaseContentQuarantine(j.config, tlfID)
+}
+
+// applySyncSchedules pauses the background work of each journal
+// outside of its scheduled sync windows, and resumes it inside of
+// them.
+func (j *JournalServer) applySyncSchedules(ctx context.Context) {
+	schedules := j.config.SyncSchedules()
+	now := j.config.Clock().Now()
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	for tlfID, tj := range j.tlfJournals {
+		if schedules.allowsJournalFlush(tlfID, now) {
+			tj.resume(journalPauseSchedule)
+		} else {
+			tj.pause(journalPauseSchedule)
+		}
+	}
+}
+
+func (j *JournalServer) syncScheduleLoop() {
+	ctx := CtxWithRandomIDReplayable(context.Background(), CtxJournalIDKey,
+		CtxJournalOpID, j.log)
+	ticker := time.NewTicker(syncScheduleCheckPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			j.applySyncSchedules(ctx)
+		case <-j.scheduleShutdownCh:
+			return
+		}
+	}
+}
+
 // Flush flushes the write journal for the given TLF.
 func (j *JournalServer) Flush(ctx context.Context, tlfID tlf.ID) (err error) {
 	j.log.CDebugf(ctx, "Flushing journal for %s", tlfID)
@@ -914,7 +1049,7 @@ func (j *JournalServer) Status(
 	enableAuto, enableAutoSetByUser := j.getEnableAutoLocked()
 	return JournalServerStatus{
 		RootDir:             j.rootPath(),
-		Version:             1,
+		Version:             int(journalStorageVersion),
 		CurrentUID:          j.currentUID,
 		CurrentVerifyingKey: j.currentVerifyingKey,
 		EnableAuto:          enableAuto,
@@ -992,6 +1127,7 @@ func (j *JournalServer) shutdownExistingJournals(ctx context.Context) {
 
 func (j *JournalServer) shutdown(ctx context.Context) {
 	j.log.CDebugf(ctx, "Shutting down journal")
+	j.scheduleShutdownOnce.Do(func() { close(j.scheduleShutdownCh) })
 	j.lock.Lock()
 	defer j.lock.Unlock()
 	for _, tlfJournal := range j.tlfJournals {
@@ -5,8 +5,10 @@
 package libkbfs
 
 import (
+	"fmt"
 	"math"
 	"os"
+	"runtime"
 	"sync"
 	"testing"
 	"time"
@@ -762,6 +764,88 @@ func TestJournalServerEnableAuto(t *testing.T) {
 	require.Len(t, tlfIDs, 1)
 }
 
+func TestJournalServerWriteThrough(t *testing.T) {
+	tempdir, ctx, cancel, config, _, jServer := setupJournalServerTest(t)
+	defer teardownJournalServerTest(t, tempdir, ctx, cancel, config)
+
+	ctx, err := NewContextWithCancellationDelayer(NewContextReplayable(
+		ctx, func(c context.Context) context.Context { return c }))
+	require.NoError(t, err)
+	err = jServer.EnableAuto(ctx)
+	require.NoError(t, err)
+
+	h, err := ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "test_user1", tlf.Private)
+	require.NoError(t, err)
+	tlfID := h.tlfID
+	kbfsOps := config.KBFSOps()
+	rootNode, _, err := kbfsOps.GetOrCreateRootNode(ctx, h, MasterBranch)
+	require.NoError(t, err)
+	var files int
+	writeFile := func() {
+		files++
+		n, _, err := kbfsOps.CreateFile(
+			ctx, rootNode, fmt.Sprintf("f%d", files), false, NoExcl)
+		require.NoError(t, err)
+		err = kbfsOps.Write(ctx, n, []byte{1, 2, 3}, 0)
+		require.NoError(t, err)
+		err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+		require.NoError(t, err)
+	}
+
+	t.Log("Write-through TLFs don't use a journal")
+	err = SetTlfWriteThrough(ctx, config, h, true)
+	require.NoError(t, err)
+	require.True(t, config.IsWriteThroughTlf(tlfID))
+	writeFile()
+	require.False(t, TLFJournalEnabled(config, tlfID))
+
+	t.Log("The mode is a folder setting")
+	settings, err := ReadTlfSettings(ctx, kbfsOps, rootNode)
+	require.NoError(t, err)
+	require.True(t, settings.WriteThrough)
+	err = jServer.Enable(ctx, tlfID, h, TLFJournalBackgroundWorkEnabled)
+	require.Error(t, err)
+
+	t.Log("Other TLFs do")
+	err = SetTlfWriteThrough(ctx, config, h, false)
+	require.NoError(t, err)
+	writeFile()
+	require.True(t, TLFJournalEnabled(config, tlfID))
+	settings, err = ReadTlfSettings(ctx, kbfsOps, rootNode)
+	require.NoError(t, err)
+	require.False(t, settings.WriteThrough)
+
+	t.Log("Switching to write-through flushes and disables the journal")
+	err = SetTlfWriteThrough(ctx, config, h, true)
+	require.NoError(t, err)
+	require.False(t, TLFJournalEnabled(config, tlfID))
+	writeFile()
+	require.False(t, TLFJournalEnabled(config, tlfID))
+
+	t.Log("Switching back enables it again")
+	err = SetTlfWriteThrough(ctx, config, h, false)
+	require.NoError(t, err)
+	require.True(t, TLFJournalEnabled(config, tlfID))
+
+	t.Log("A journal with unflushed writes is kept until it's flushed")
+	jServer.PauseBackgroundWork(ctx, tlfID)
+	writeFile()
+	config.SetTlfWriteThroughState(tlfID, true)
+	writeFile()
+	require.True(t, TLFJournalEnabled(config, tlfID))
+	status, _ := jServer.Status(ctx)
+	require.NotZero(t, status.UnflushedBytes)
+	jServer.ResumeBackgroundWork(ctx, tlfID)
+	err = jServer.Wait(ctx, tlfID)
+	require.NoError(t, err)
+	// The next use of the journal disables it.
+	_, ok := jServer.getTLFJournal(tlfID, nil)
+	require.True(t, ok)
+	require.False(t, TLFJournalEnabled(config, tlfID))
+	writeFile()
+}
+
 func TestJournalServerReaderTLFs(t *testing.T) {
 	tempdir, ctx, cancel, config, _, jServer := setupJournalServerTest(t)
 	defer teardownJournalServerTest(t, tempdir, ctx, cancel, config)
@@ -1004,3 +1088,90 @@ func TestJournalQuotaStatusForGitBlocks(t *testing.T) {
 	require.Equal(
 		t, int64(2000), bs.JournalTrackerStatus.QuotaStatus.QuotaBytes)
 }
+
+// flushStallingBlockServer stalls the puts of background journal
+// flushes until `unstallCh` is closed.
+type flushStallingBlockServer struct {
+	BlockServer
+	stalledCh chan<- struct{}
+	unstallCh <-chan struct{}
+}
+
+func (bs flushStallingBlockServer) Put(
+	ctx context.Context, tlfID tlf.ID, id kbfsblock.ID,
+	context kbfsblock.Context, buf []byte,
+	serverHalf kbfscrypto.BlockCryptKeyServerHalf) error {
+	if isBackgroundFlush(ctx) {
+		select {
+		case bs.stalledCh <- struct{}{}:
+		default:
+		}
+		<-bs.unstallCh
+	}
+	return bs.BlockServer.Put(ctx, tlfID, id, context, buf, serverHalf)
+}
+
+// Test that interactive operations on one TLF stay fast while the
+// journal of another TLF is busy flushing.
+func TestJournalServerInteractiveDuringFlush(t *testing.T) {
+	tempdir, ctx, cancel, config, _, jServer := setupJournalServerTest(t)
+	defer teardownJournalServerTest(t, tempdir, ctx, cancel, config)
+
+	// The latency that interactive operations must stay under.
+	const interactiveLatencySLO = 2 * time.Second
+
+	stalledCh := make(chan struct{}, 1)
+	unstallCh := make(chan struct{})
+	jServer.delegateBlockServer = flushStallingBlockServer{
+		jServer.delegateBlockServer, stalledCh, unstallCh}
+
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+	uid := session.UID.AsUserOrTeam()
+	putBlock := func(ctx context.Context, tlfID tlf.ID, b byte) (
+		kbfsblock.ID, kbfsblock.Context) {
+		bCtx := kbfsblock.MakeFirstContext(uid, keybase1.BlockType_DATA)
+		data := []byte{1, 2, 3, b}
+		bID, err := kbfsblock.MakePermanentID(
+			data, kbfscrypto.EncryptionSecretbox)
+		require.NoError(t, err)
+		serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+		require.NoError(t, err)
+		err = config.BlockServer().Put(
+			ctx, tlfID, bID, bCtx, data, serverHalf)
+		require.NoError(t, err)
+		return bID, bCtx
+	}
+
+	t.Log("Start a flush of a journaled TLF, and stall it")
+	journaledID := tlf.FakeID(2, tlf.Private)
+	err = jServer.Enable(
+		ctx, journaledID, nil, TLFJournalBackgroundWorkEnabled)
+	require.NoError(t, err)
+	putBlock(ctx, journaledID, 1)
+	select {
+	case <-stalledCh:
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	}
+	require.Equal(t,
+		int64(runtime.NumCPU()*backgroundFlushPutsPerCPU-1),
+		jServer.flushBudget.Count())
+
+	t.Log("Writes and reads of another TLF aren't held up")
+	otherID := tlf.FakeID(3, tlf.Private)
+	start := time.Now()
+	sloCtx, sloCancel := context.WithTimeout(ctx, interactiveLatencySLO)
+	defer sloCancel()
+	bID, bCtx := putBlock(sloCtx, otherID, 2)
+	_, _, err = config.BlockServer().Get(sloCtx, otherID, bID, bCtx)
+	require.NoError(t, err)
+	require.True(t, time.Since(start) < interactiveLatencySLO)
+
+	close(unstallCh)
+	err = jServer.Wait(ctx, journaledID)
+	require.NoError(t, err)
+	require.Equal(t,
+		int64(runtime.NumCPU()*backgroundFlushPutsPerCPU),
+		jServer.flushBudget.Count())
+}
@@ -770,6 +770,181 @@ func TestBasicCRFileConflict(t *testing.T) {
 	require.Equal(t, children1, children2)
 }
 
+// Tests that conflicts on a file bigger than `maxFileSizeForCR` are
+// resolved, as long as the file doesn't hold that much data.
+func TestCRFileConflictPastCRSizeLimit(t *testing.T) {
+	// simulate two users
+	var userName1, userName2 kbname.NormalizedUsername = "u1", "u2"
+	config1, _, ctx, cancel := kbfsOpsConcurInit(t, userName1, userName2)
+	defer kbfsConcurTestShutdown(t, config1, ctx, cancel)
+
+	config2 := ConfigAsUser(config1, userName2)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+
+	clock, now := newTestClockAndTimeNow()
+	config2.SetClock(clock)
+
+	name := userName1.String() + "," + userName2.String()
+
+	// user1 creates a sparse file past the limit, with data at both
+	// ends.
+	rootNode1 := GetRootNodeOrBust(ctx, t, config1, name, tlf.Private)
+	kbfsOps1 := config1.KBFSOps()
+	fileB1, _, err := kbfsOps1.CreateFile(ctx, rootNode1, "b", false, NoExcl)
+	require.NoError(t, err)
+	size := uint64(maxFileSizeForCR) + uint64(maxFileSizeForCR)/2
+	err = kbfsOps1.Truncate(ctx, fileB1, size)
+	require.NoError(t, err)
+	err = kbfsOps1.Write(ctx, fileB1, []byte{1, 2, 3}, 0)
+	require.NoError(t, err)
+	endOff := int64(size) - 3
+	err = kbfsOps1.Write(ctx, fileB1, []byte{4, 5, 6}, endOff)
+	require.NoError(t, err)
+	err = kbfsOps1.SyncAll(ctx, rootNode1.GetFolderBranch())
+	require.NoError(t, err)
+
+	// look it up on user2
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, name, tlf.Private)
+	kbfsOps2 := config2.KBFSOps()
+	fileB2, _, err := kbfsOps2.Lookup(ctx, rootNode2, "b")
+	require.NoError(t, err)
+	// Cache the data blocks before copying them during CR.
+	data := make([]byte, 3)
+	_, err = kbfsOps2.Read(ctx, fileB2, data, 0)
+	require.NoError(t, err)
+	_, err = kbfsOps2.Read(ctx, fileB2, data, endOff)
+	require.NoError(t, err)
+
+	// disable updates on user 2
+	c, err := DisableUpdatesForTesting(config2, rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+	err = DisableCRForTesting(config2, rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+
+	// Both users write to the end of the file.
+	err = kbfsOps1.Write(ctx, fileB1, []byte{7}, endOff)
+	require.NoError(t, err)
+	err = kbfsOps1.SyncAll(ctx, fileB1.GetFolderBranch())
+	require.NoError(t, err)
+	err = kbfsOps2.Write(ctx, fileB2, []byte{8}, endOff)
+	require.NoError(t, err)
+	err = kbfsOps2.SyncAll(ctx, fileB2.GetFolderBranch())
+	require.NoError(t, err)
+
+	// re-enable updates, and wait for CR to complete
+	c <- struct{}{}
+	err = RestartCRForTesting(
+		BackgroundContextWithCancellationDelayer(), config2,
+		rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+	err = kbfsOps2.SyncFromServer(ctx, rootNode2.GetFolderBranch(), nil)
+	require.NoError(t, err)
+	err = kbfsOps1.SyncFromServer(ctx, rootNode1.GetFolderBranch(), nil)
+	require.NoError(t, err)
+
+	// Both versions of the file keep their size and data.
+	cre := WriterDeviceDateConflictRenamer{}
+	for name, expected := range map[string][]byte{
+		"b": {7, 5, 6},
+		cre.ConflictRenameHelper(now, "u2", "dev1", "b"): {8, 5, 6},
+	} {
+		n, ei, err := kbfsOps1.Lookup(ctx, rootNode1, name)
+		require.NoError(t, err)
+		require.Equal(t, size, ei.Size)
+		_, err = kbfsOps1.Read(ctx, n, data, 0)
+		require.NoError(t, err)
+		require.Equal(t, []byte{1, 2, 3}, data)
+		_, err = kbfsOps1.Read(ctx, n, data, endOff)
+		require.NoError(t, err)
+		require.Equal(t, expected, data)
+	}
+}
+
+// Tests that when both users write to an existing multi-block file,
+// the child blocks the unmerged user made are unreferenced once CR
+// copies them into the conflict copy, so they don't leak.
+func TestCRFileConflictMultiblockNoLeakedRefs(t *testing.T) {
+	// simulate two users
+	var userName1, userName2 kbname.NormalizedUsername = "u1", "u2"
+	config1, _, ctx, cancel := kbfsOpsConcurInit(t, userName1, userName2)
+	defer kbfsConcurTestShutdown(t, config1, ctx, cancel)
+
+	// Make the blocks small, so the file has several children.
+	bsplit := &BlockSplitterSimple{5, 2, 100 * 1024, 0}
+	config1.SetBlockSplitter(bsplit)
+
+	config2 := ConfigAsUser(config1, userName2)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	config2.SetBlockSplitter(bsplit)
+
+	clock, now := newTestClockAndTimeNow()
+	config2.SetClock(clock)
+
+	name := userName1.String() + "," + userName2.String()
+
+	t.Log("User 1 creates a multi-block file")
+	rootNode1 := GetRootNodeOrBust(ctx, t, config1, name, tlf.Private)
+	kbfsOps1 := config1.KBFSOps()
+	fileB1, _, err := kbfsOps1.CreateFile(ctx, rootNode1, "b", false, NoExcl)
+	require.NoError(t, err)
+	data := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	err = kbfsOps1.Write(ctx, fileB1, data, 0)
+	require.NoError(t, err)
+	err = kbfsOps1.SyncAll(ctx, rootNode1.GetFolderBranch())
+	require.NoError(t, err)
+
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, name, tlf.Private)
+	kbfsOps2 := config2.KBFSOps()
+	fileB2, _, err := kbfsOps2.Lookup(ctx, rootNode2, "b")
+	require.NoError(t, err)
+
+	c, err := DisableUpdatesForTesting(config2, rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+	err = DisableCRForTesting(config2, rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+
+	t.Log("Both users write to every block of the file")
+	data1 := []byte{21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34, 35}
+	err = kbfsOps1.Write(ctx, fileB1, data1, 0)
+	require.NoError(t, err)
+	err = kbfsOps1.SyncAll(ctx, fileB1.GetFolderBranch())
+	require.NoError(t, err)
+	data2 := []byte{41, 42, 43, 44, 45, 46, 47, 48, 49, 50, 51, 52, 53, 54, 55}
+	err = kbfsOps2.Write(ctx, fileB2, data2, 0)
+	require.NoError(t, err)
+	err = kbfsOps2.SyncAll(ctx, fileB2.GetFolderBranch())
+	require.NoError(t, err)
+
+	t.Log("Resolve the conflict")
+	c <- struct{}{}
+	err = RestartCRForTesting(
+		BackgroundContextWithCancellationDelayer(), config2,
+		rootNode2.GetFolderBranch())
+	require.NoError(t, err)
+	err = kbfsOps2.SyncFromServer(ctx, rootNode2.GetFolderBranch(), nil)
+	require.NoError(t, err)
+	err = kbfsOps1.SyncFromServer(ctx, rootNode1.GetFolderBranch(), nil)
+	require.NoError(t, err)
+
+	cre := WriterDeviceDateConflictRenamer{}
+	for name, expected := range map[string][]byte{
+		"b": data1,
+		cre.ConflictRenameHelper(now, "u2", "dev1", "b"): data2,
+	} {
+		n, _, err := kbfsOps1.Lookup(ctx, rootNode1, name)
+		require.NoError(t, err)
+		buf := make([]byte, len(expected))
+		_, err = kbfsOps1.Read(ctx, n, buf, 0)
+		require.NoError(t, err)
+		require.Equal(t, expected, buf)
+	}
+
+	t.Log("The block server has no references beyond the live ones")
+	err = NewStateChecker(config1).CheckMergedState(
+		ctx, rootNode1.GetFolderBranch().Tlf)
+	require.NoError(t, err)
+}
+
 // Tests that two users can create the same file simultaneously, and
 // the unmerged user can write to it, and they will be merged into a
 // single file.
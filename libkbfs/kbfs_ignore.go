@@ -0,0 +1,215 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bufio"
+	"bytes"
+	stdpath "path"
+	"strings"
+
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+// KBFSIgnoreFileName is the name of the file, at the root of a TLF,
+// that lists entries to exclude from deep prefetching when the TLF
+// is synced.
+const KBFSIgnoreFileName = ".kbfsignore"
+
+// maxKBFSIgnoreSize caps how much of an ignore file is read.
+const maxKBFSIgnoreSize = 64 * 1024
+
+type kbfsIgnorePattern struct {
+	glob    string
+	dirOnly bool
+	negate  bool
+}
+
+// KBFSIgnore is a parsed ignore file.  It follows a subset of the
+// .gitignore syntax: blank lines and lines starting with "#" are
+// skipped, a leading "!" re-includes entries matched by an earlier
+// pattern, and a trailing "/" matches only directories.  Since
+// prefetching sees one directory entry at a time, patterns are
+// matched against entry names at any depth, and patterns containing
+// any other "/" are ignored.  The last matching pattern wins.
+type KBFSIgnore struct {
+	patterns []kbfsIgnorePattern
+}
+
+// ParseKBFSIgnore parses the contents of an ignore file.
+func ParseKBFSIgnore(data []byte) *KBFSIgnore {
+	ki := &KBFSIgnore{}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var p kbfsIgnorePattern
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		line = strings.TrimPrefix(line, "/")
+		if line == "" || strings.Contains(line, "/") {
+			continue
+		}
+		if _, err := stdpath.Match(line, ""); err != nil {
+			continue
+		}
+		p.glob = line
+		ki.patterns = append(ki.patterns, p)
+	}
+	return ki
+}
+
+// Match returns true if the entry with the given name should be
+// excluded.  It's safe to call on a nil KBFSIgnore.
+func (ki *KBFSIgnore) Match(name string, isDir bool) bool {
+	if ki == nil {
+		return false
+	}
+	matched := false
+	for _, p := range ki.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if ok, _ := stdpath.Match(p.glob, name); ok {
+			matched = !p.negate
+		}
+	}
+	return matched
+}
+
+type kbfsIgnoreGetter interface {
+	// KBFSIgnore returns the ignore rules for the given TLF, or nil
+	// if it has none.
+	KBFSIgnore(tlfID tlf.ID) *KBFSIgnore
+}
+
+type kbfsIgnoreSetter interface {
+	// SetKBFSIgnore sets the ignore rules for the given TLF.  A nil
+	// `ki` clears them.
+	SetKBFSIgnore(tlfID tlf.ID, ki *KBFSIgnore)
+}
+
+// mergeKBFSIgnore returns the rules of `first` followed by those of
+// `second`, so that the latter take precedence.  Either may be nil.
+func mergeKBFSIgnore(first, second *KBFSIgnore) *KBFSIgnore {
+	switch {
+	case first == nil:
+		return second
+	case second == nil:
+		return first
+	}
+	merged := &KBFSIgnore{}
+	merged.patterns = append(merged.patterns, first.patterns...)
+	merged.patterns = append(merged.patterns, second.patterns...)
+	return merged
+}
+
+// readRootRulesFile returns up to `maxSize` bytes of the file named
+// `name` at the root of the TLF, or nil if there's no such file.
+func (fbo *folderBranchOps) readRootRulesFile(
+	ctx context.Context, name string, maxSize uint64) ([]byte, error) {
+	rootNode, _, _, err := fbo.getRootNode(ctx)
+	if err != nil {
+		return nil, err
+	}
+	node, ei, err := fbo.Lookup(ctx, rootNode, name)
+	if _, ok := err.(NoSuchNameError); ok {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if ei.Type == Dir || ei.Type == Sym {
+		return nil, nil
+	}
+
+	size := ei.Size
+	if size > maxSize {
+		size = maxSize
+	}
+	buf := make([]byte, size)
+	lState := makeFBOLockState()
+	md, err := fbo.getMDForRead(ctx, lState, mdReadNoIdentify)
+	if err != nil {
+		return nil, err
+	}
+	// Read directly from the block layer, so that this internal
+	// read doesn't show up as a recently-accessed file.
+	n, err := fbo.blocks.Read(ctx, lState, md.ReadOnly(), node, buf, 0)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// signalRulesReload asks the background rules goroutine, starting it
+// if needed, to reload the ignore and sparse sync files of the TLF.
+// Signals that arrive while a reload is pending are coalesced into
+// it.  It never blocks.
+func (fbo *folderBranchOps) signalRulesReload() {
+	fbo.launchRulesLoop.Do(func() {
+		go fbo.rulesLoop()
+	})
+	select {
+	case fbo.rulesReloadCh <- struct{}{}:
+	default:
+		// A reload is already pending.
+	}
+}
+
+// rulesLoop reloads the ignore and sparse sync files of the TLF
+// whenever it's signaled, until shutdown.
+func (fbo *folderBranchOps) rulesLoop() {
+	for {
+		select {
+		case <-fbo.rulesReloadCh:
+		case <-fbo.shutdownChan:
+			return
+		}
+		fbo.reloadKBFSIgnore()
+		fbo.reloadSparseSync()
+	}
+}
+
+// reloadKBFSIgnore reads the ignore rules of the TLF's settings and
+// of the ignore file at its root, if there is one, and records them
+// in the config for the prefetcher to use.
+func (fbo *folderBranchOps) reloadKBFSIgnore() {
+	setter, ok := fbo.config.(kbfsIgnoreSetter)
+	if !ok {
+		return
+	}
+	err := fbo.runUnlessShutdown(func(ctx context.Context) error {
+		settings, err := fbo.getTlfSettings(ctx)
+		if err != nil {
+			return err
+		}
+		settingsIgnore := settings.GetKBFSIgnore()
+
+		data, err := fbo.readRootRulesFile(
+			ctx, KBFSIgnoreFileName, maxKBFSIgnoreSize)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			setter.SetKBFSIgnore(fbo.id(), settingsIgnore)
+			return nil
+		}
+		setter.SetKBFSIgnore(fbo.id(), mergeKBFSIgnore(
+			settingsIgnore, ParseKBFSIgnore(data)))
+		return nil
+	})
+	if err != nil {
+		fbo.log.CDebugf(nil, "Couldn't load %s: %+v", KBFSIgnoreFileName, err)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestKBFSIgnoreMatch(t *testing.T) {
+	ki := ParseKBFSIgnore([]byte(`
+# Build outputs
+*.o
+build/
+/node_modules
+!keep.o
+src/generated
+`))
+	require.True(t, ki.Match("foo.o", false))
+	require.False(t, ki.Match("keep.o", false))
+	require.True(t, ki.Match("build", true))
+	require.False(t, ki.Match("build", false))
+	require.True(t, ki.Match("node_modules", true))
+	require.False(t, ki.Match("generated", true))
+	require.False(t, ki.Match("src", true))
+
+	var nilIgnore *KBFSIgnore
+	require.False(t, nilIgnore.Match("foo.o", false))
+}
+
+type testKBFSIgnoreConfig struct {
+	*testBlockRetrievalConfig
+	ki *KBFSIgnore
+}
+
+func (c testKBFSIgnoreConfig) KBFSIgnore(_ tlf.ID) *KBFSIgnore {
+	return c.ki
+}
+
+func TestPrefetcherSkipsIgnoredEntries(t *testing.T) {
+	bg := newFakeBlockGetter(false)
+	config := testKBFSIgnoreConfig{
+		newTestBlockRetrievalConfig(t, bg, nil),
+		ParseKBFSIgnore([]byte("build/\n")),
+	}
+	q := newBlockRetrievalQueue(1, 1, config)
+	require.NotNil(t, q)
+	defer shutdownPrefetcherTest(q)
+	prefetchSyncCh := make(chan struct{})
+	q.TogglePrefetcher(true, prefetchSyncCh)
+	notifySyncCh(t, prefetchSyncCh)
+
+	kmd := makeKMD()
+	config.SetTlfSyncState(kmd.TlfID(), true)
+
+	t.Log("Initialize a dir block with a file and an ignored directory.")
+	fileA := makeFakeFileBlock(t, true)
+	rootPtr := makeRandomBlockPointer(t)
+	rootDir := &DirBlock{Children: map[string]DirEntry{
+		"a":     makeRandomDirEntry(t, File, 100, "a"),
+		"build": makeRandomDirEntry(t, Dir, 60, "build"),
+	}}
+	_, continueChRootDir := bg.setBlockToReturn(rootPtr, rootDir)
+	_, continueChFileA :=
+		bg.setBlockToReturn(rootDir.Children["a"].BlockPointer, fileA)
+	bg.setBlockToReturn(
+		rootDir.Children["build"].BlockPointer, makeFakeDirBlock(t, "x"))
+
+	var block Block = &DirBlock{}
+	ch := q.Request(context.Background(),
+		defaultOnDemandRequestPriority, kmd, rootPtr, block, TransientEntry)
+	continueChRootDir <- nil
+	err := <-ch
+	require.NoError(t, err)
+
+	notifyContinueCh(continueChFileA)
+	t.Log("Wait for prefetching to complete.")
+	// Release after prefetching rootDir
+	notifySyncCh(t, prefetchSyncCh)
+	// Release after prefetching fileA
+	notifySyncCh(t, prefetchSyncCh)
+	waitForPrefetchOrBust(t, q.Prefetcher().Shutdown())
+
+	t.Log("The ignored directory wasn't prefetched.")
+	testPrefetcherCheckGet(t, config.BlockCache(), rootPtr, rootDir,
+		FinishedPrefetch, TransientEntry)
+	testPrefetcherCheckGet(t, config.BlockCache(),
+		rootDir.Children["a"].BlockPointer, fileA, FinishedPrefetch,
+		TransientEntry)
+	_, err = config.BlockCache().Get(rootDir.Children["build"].BlockPointer)
+	require.Error(t, err)
+}
+
+func TestKBFSIgnoreReload(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps()
+	ops := kbfsOps.(*KBFSOpsStandard).getOpsByNode(ctx, rootNode)
+
+	ops.reloadKBFSIgnore()
+	require.Nil(t, config.KBFSIgnore(fb.Tlf))
+
+	n, _, err := kbfsOps.CreateFile(
+		ctx, rootNode, KBFSIgnoreFileName, false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, n, []byte("*.tmp\n"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	ops.reloadKBFSIgnore()
+	ki := config.KBFSIgnore(fb.Tlf)
+	require.NotNil(t, ki)
+	require.True(t, ki.Match("x.tmp", false))
+
+	t.Log("Reading the ignore file doesn't count as a recent access")
+	files, err := kbfsOps.GetRecentFiles(ctx, fb, "", 0)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.True(t, files[0].LastRead.IsZero())
+}
+
+func TestKBFSIgnoreReloadLoop(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps()
+	ops := kbfsOps.(*KBFSOpsStandard).getOpsByNode(ctx, rootNode)
+
+	n, _, err := kbfsOps.CreateFile(
+		ctx, rootNode, KBFSIgnoreFileName, false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, n, []byte("*.tmp\n"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	t.Log("Signals are coalesced while a reload is pending")
+	ops.launchRulesLoop.Do(func() {})
+	for i := 0; i < 3; i++ {
+		ops.signalRulesReload()
+	}
+	require.Len(t, ops.rulesReloadCh, 1)
+	require.Nil(t, config.KBFSIgnore(fb.Tlf))
+
+	t.Log("The loop applies the pending reload")
+	go ops.rulesLoop()
+	// The loop handles signals one at a time, so once it has taken
+	// both of these, it has finished the pending reload.
+	ops.rulesReloadCh <- struct{}{}
+	ops.rulesReloadCh <- struct{}{}
+	ki := config.KBFSIgnore(fb.Tlf)
+	require.NotNil(t, ki)
+	require.True(t, ki.Match("x.tmp", false))
+}
@@ -49,6 +49,12 @@ type KBFSOpsStandard struct {
 	currentStatus            kbfsCurrentStatus
 	quotaUsage               *EventuallyConsistentQuotaUsage
 	longOperationDebugDumper *ImpatientDebugDumper
+	activity                 *activityTracker
+
+	// idleReclaimer is nil unless idle reclamation is enabled.
+	idleReclaimer *idleReclaimer
+	// folderLimiter is nil unless folder limits are enabled.
+	folderLimiter *folderLimiter
 }
 
 var _ KBFSOps = (*KBFSOpsStandard)(nil)
@@ -70,12 +76,27 @@ func NewKBFSOpsStandard(appStateUpdater env.AppStateUpdater, config Config) *KBF
 		quotaUsage: NewEventuallyConsistentQuotaUsage(config, "KBFSOps"),
 		longOperationDebugDumper: NewImpatientDebugDumper(
 			config, longOperationDebugDumpDuration),
+		activity: newActivityTracker(config.Clock()),
 	}
 	kops.currentStatus.Init()
 	go kops.markForReIdentifyIfNeededLoop()
 	return kops
 }
 
+// enableIdleReclamation makes `fs` release the memory held by the
+// clean caches after `idleDuration` passes without any folder being
+// accessed.  It must be called before `fs` is used.
+func (fs *KBFSOpsStandard) enableIdleReclamation(idleDuration time.Duration) {
+	fs.idleReclaimer = newIdleReclaimer(fs.config, idleDuration)
+	go fs.idleReclaimer.loop()
+}
+
+// enableFolderLimits makes `fs` enforce `limits` on the operations on
+// each TLF.  It must be called before `fs` is used.
+func (fs *KBFSOpsStandard) enableFolderLimits(limits FolderLimits) {
+	fs.folderLimiter = newFolderLimiter(limits)
+}
+
 func (fs *KBFSOpsStandard) markForReIdentifyIfNeededLoop() {
 	maxValid := fs.config.TLFValidDuration()
 	// Tests and some users fail to set this properly.
@@ -143,6 +164,9 @@ func (fs *KBFSOpsStandard) Shutdown(ctx context.Context) error {
 	}
 
 	close(fs.reIdentifyControlChan)
+	if fs.idleReclaimer != nil {
+		fs.idleReclaimer.shutdown()
+	}
 	var errors []error
 	if err := fs.favs.Shutdown(); err != nil {
 		errors = append(errors, err)
@@ -233,6 +257,59 @@ func (fs *KBFSOpsStandard) ForceFastForward(ctx context.Context) {
 	}
 }
 
+// FolderUpdated implements the KBFSOps interface for KBFSOpsStandard.
+func (fs *KBFSOpsStandard) FolderUpdated(
+	ctx context.Context, tlfID tlf.ID, rev kbfsmd.Revision) error {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+
+	ops := fs.getOpsIfExists(ctx, FolderBranch{tlfID, MasterBranch})
+	if ops == nil {
+		return nil
+	}
+	return ops.FolderUpdated(ctx, tlfID, rev)
+}
+
+// PurgeCachesPendingRekey implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) PurgeCachesPendingRekey(ctx context.Context) (
+	[]PurgedFolder, error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+
+	fs.opsLock.RLock()
+	ops := make([]*folderBranchOps, 0, len(fs.ops))
+	for _, fbo := range fs.ops {
+		ops = append(ops, fbo)
+	}
+	fs.opsLock.RUnlock()
+
+	var purged []PurgedFolder
+	for _, fbo := range ops {
+		p, err := fbo.PurgeCachesPendingRekey(ctx)
+		if err != nil {
+			return purged, err
+		}
+		purged = append(purged, p...)
+	}
+	return purged, nil
+}
+
+// restartDeepPrefetches restarts the deep prefetches of all the
+// synced TLFs that are open.
+func (fs *KBFSOpsStandard) restartDeepPrefetches(ctx context.Context) {
+	fs.opsLock.RLock()
+	ops := make([]*folderBranchOps, 0, len(fs.ops))
+	for _, fbo := range fs.ops {
+		ops = append(ops, fbo)
+	}
+	fs.opsLock.RUnlock()
+
+	for _, fbo := range ops {
+		fbo.restartDeepPrefetch(ctx)
+	}
+}
+
 // InvalidateNodeAndChildren implements the KBFSOps interface for
 // KBFSOpsStandard.
 func (fs *KBFSOpsStandard) InvalidateNodeAndChildren(
@@ -330,6 +407,10 @@ func (fs *KBFSOpsStandard) getOpsNoAdd(
 		panic("zero FolderBranch in getOps")
 	}
 
+	if fs.idleReclaimer != nil {
+		fs.idleReclaimer.touch()
+	}
+
 	fs.opsLock.RLock()
 	if ops, ok := fs.ops[fb]; ok {
 		fs.opsLock.RUnlock()
@@ -776,26 +857,92 @@ func (fs *KBFSOpsStandard) GetDirChildren(ctx context.Context, dir Node) (
 	map[string]EntryInfo, error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	defer fs.activity.begin("GetDirChildren", dir.GetFolderBranch().Tlf)()
+
+	limitDone, err := fs.folderLimiter.begin(
+		ctx, dir.GetFolderBranch().Tlf, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer limitDone()
 
 	ops := fs.getOpsByNode(ctx, dir)
 	return ops.GetDirChildren(ctx, dir)
 }
 
+// GetDirChildrenPage implements the KBFSOps interface for
+// KBFSOpsStandard
+func (fs *KBFSOpsStandard) GetDirChildrenPage(
+	ctx context.Context, dir Node, after string, max int) (
+	[]DirChild, error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	defer fs.activity.begin("GetDirChildrenPage", dir.GetFolderBranch().Tlf)()
+
+	limitDone, err := fs.folderLimiter.begin(
+		ctx, dir.GetFolderBranch().Tlf, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer limitDone()
+
+	ops := fs.getOpsByNode(ctx, dir)
+	return ops.GetDirChildrenPage(ctx, dir, after, max)
+}
+
 // Lookup implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) Lookup(ctx context.Context, dir Node, name string) (
 	Node, EntryInfo, error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	defer fs.activity.begin("Lookup", dir.GetFolderBranch().Tlf)()
+
+	limitDone, err := fs.folderLimiter.begin(
+		ctx, dir.GetFolderBranch().Tlf, 0)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	defer limitDone()
 
 	ops := fs.getOpsByNode(ctx, dir)
 	return ops.Lookup(ctx, dir, name)
 }
 
+// LookupAndReadSmall implements the KBFSOps interface for
+// KBFSOpsStandard
+func (fs *KBFSOpsStandard) LookupAndReadSmall(
+	ctx context.Context, dir Node, name string, maxSize int64) (
+	Node, EntryInfo, []byte, error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	defer fs.activity.begin("LookupAndReadSmall", dir.GetFolderBranch().Tlf)()
+
+	limitDone, err := fs.folderLimiter.begin(
+		ctx, dir.GetFolderBranch().Tlf, maxSize)
+	if err != nil {
+		return nil, EntryInfo{}, nil, err
+	}
+	defer limitDone()
+
+	ops := fs.getOpsByNode(ctx, dir)
+	node, ei, data, err := ops.LookupAndReadSmall(ctx, dir, name, maxSize)
+	fs.activity.addBytes(dir.GetFolderBranch().Tlf, int64(len(data)), 0)
+	return node, ei, data, err
+}
+
 // Stat implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) Stat(ctx context.Context, node Node) (
 	EntryInfo, error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	defer fs.activity.begin("Stat", node.GetFolderBranch().Tlf)()
+
+	limitDone, err := fs.folderLimiter.begin(
+		ctx, node.GetFolderBranch().Tlf, 0)
+	if err != nil {
+		return EntryInfo{}, err
+	}
+	defer limitDone()
 
 	ops := fs.getOpsByNode(ctx, node)
 	return ops.Stat(ctx, node)
@@ -806,6 +953,14 @@ func (fs *KBFSOpsStandard) CreateDir(
 	ctx context.Context, dir Node, name string) (Node, EntryInfo, error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	defer fs.activity.begin("CreateDir", dir.GetFolderBranch().Tlf)()
+
+	limitDone, err := fs.folderLimiter.begin(
+		ctx, dir.GetFolderBranch().Tlf, 0)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	defer limitDone()
 
 	ops := fs.getOpsByNode(ctx, dir)
 	return ops.CreateDir(ctx, dir, name)
@@ -817,6 +972,14 @@ func (fs *KBFSOpsStandard) CreateFile(
 	Node, EntryInfo, error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	defer fs.activity.begin("CreateFile", dir.GetFolderBranch().Tlf)()
+
+	limitDone, err := fs.folderLimiter.begin(
+		ctx, dir.GetFolderBranch().Tlf, 0)
+	if err != nil {
+		return nil, EntryInfo{}, err
+	}
+	defer limitDone()
 
 	ops := fs.getOpsByNode(ctx, dir)
 	return ops.CreateFile(ctx, dir, name, isExec, excl)
@@ -828,6 +991,14 @@ func (fs *KBFSOpsStandard) CreateLink(
 	EntryInfo, error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	defer fs.activity.begin("CreateLink", dir.GetFolderBranch().Tlf)()
+
+	limitDone, err := fs.folderLimiter.begin(
+		ctx, dir.GetFolderBranch().Tlf, 0)
+	if err != nil {
+		return EntryInfo{}, err
+	}
+	defer limitDone()
 
 	ops := fs.getOpsByNode(ctx, dir)
 	return ops.CreateLink(ctx, dir, fromName, toPath)
@@ -838,6 +1009,14 @@ func (fs *KBFSOpsStandard) RemoveDir(
 	ctx context.Context, dir Node, name string) error {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	defer fs.activity.begin("RemoveDir", dir.GetFolderBranch().Tlf)()
+
+	limitDone, err := fs.folderLimiter.begin(
+		ctx, dir.GetFolderBranch().Tlf, 0)
+	if err != nil {
+		return err
+	}
+	defer limitDone()
 
 	ops := fs.getOpsByNode(ctx, dir)
 	return ops.RemoveDir(ctx, dir, name)
@@ -848,17 +1027,51 @@ func (fs *KBFSOpsStandard) RemoveEntry(
 	ctx context.Context, dir Node, name string) error {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	defer fs.activity.begin("RemoveEntry", dir.GetFolderBranch().Tlf)()
+
+	limitDone, err := fs.folderLimiter.begin(
+		ctx, dir.GetFolderBranch().Tlf, 0)
+	if err != nil {
+		return err
+	}
+	defer limitDone()
 
 	ops := fs.getOpsByNode(ctx, dir)
 	return ops.RemoveEntry(ctx, dir, name)
 }
 
+// RemoveTree implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) RemoveTree(ctx context.Context, dir Node,
+	name string, progress func(RemoveTreeProgress)) error {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+	defer fs.activity.begin("RemoveTree", dir.GetFolderBranch().Tlf)()
+
+	limitDone, err := fs.folderLimiter.begin(
+		ctx, dir.GetFolderBranch().Tlf, 0)
+	if err != nil {
+		return err
+	}
+	defer limitDone()
+
+	ops := fs.getOpsByNode(ctx, dir)
+	return ops.RemoveTree(ctx, dir, name, progress)
+}
+
 // Rename implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) Rename(
 	ctx context.Context, oldParent Node, oldName string, newParent Node,
 	newName string) error {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	defer fs.activity.begin("Rename", oldParent.GetFolderBranch().Tlf)()
+
+	limitDone, err := fs.folderLimiter.begin(
+		ctx, oldParent.GetFolderBranch().Tlf, 0)
+	if err != nil {
+		return err
+	}
+	defer limitDone()
 
 	oldFB := oldParent.GetFolderBranch()
 	newFB := newParent.GetFolderBranch()
@@ -878,9 +1091,19 @@ func (fs *KBFSOpsStandard) Read(
 	numRead int64, err error) {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	defer fs.activity.begin("Read", file.GetFolderBranch().Tlf)()
+
+	limitDone, err := fs.folderLimiter.begin(
+		ctx, file.GetFolderBranch().Tlf, int64(len(dest)))
+	if err != nil {
+		return 0, err
+	}
+	defer limitDone()
 
 	ops := fs.getOpsByNode(ctx, file)
-	return ops.Read(ctx, file, dest, off)
+	numRead, err = ops.Read(ctx, file, dest, off)
+	fs.activity.addBytes(file.GetFolderBranch().Tlf, numRead, 0)
+	return numRead, err
 }
 
 // Write implements the KBFSOps interface for KBFSOpsStandard
@@ -888,9 +1111,21 @@ func (fs *KBFSOpsStandard) Write(
 	ctx context.Context, file Node, data []byte, off int64) error {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	defer fs.activity.begin("Write", file.GetFolderBranch().Tlf)()
+
+	limitDone, err := fs.folderLimiter.begin(
+		ctx, file.GetFolderBranch().Tlf, int64(len(data)))
+	if err != nil {
+		return err
+	}
+	defer limitDone()
 
 	ops := fs.getOpsByNode(ctx, file)
-	return ops.Write(ctx, file, data, off)
+	err = ops.Write(ctx, file, data, off)
+	if err == nil {
+		fs.activity.addBytes(file.GetFolderBranch().Tlf, 0, int64(len(data)))
+	}
+	return err
 }
 
 // Truncate implements the KBFSOps interface for KBFSOpsStandard
@@ -898,6 +1133,14 @@ func (fs *KBFSOpsStandard) Truncate(
 	ctx context.Context, file Node, size uint64) error {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	defer fs.activity.begin("Truncate", file.GetFolderBranch().Tlf)()
+
+	limitDone, err := fs.folderLimiter.begin(
+		ctx, file.GetFolderBranch().Tlf, 0)
+	if err != nil {
+		return err
+	}
+	defer limitDone()
 
 	ops := fs.getOpsByNode(ctx, file)
 	return ops.Truncate(ctx, file, size)
@@ -908,6 +1151,14 @@ func (fs *KBFSOpsStandard) SetEx(
 	ctx context.Context, file Node, ex bool) error {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	defer fs.activity.begin("SetEx", file.GetFolderBranch().Tlf)()
+
+	limitDone, err := fs.folderLimiter.begin(
+		ctx, file.GetFolderBranch().Tlf, 0)
+	if err != nil {
+		return err
+	}
+	defer limitDone()
 
 	ops := fs.getOpsByNode(ctx, file)
 	return ops.SetEx(ctx, file, ex)
@@ -918,6 +1169,14 @@ func (fs *KBFSOpsStandard) SetMtime(
 	ctx context.Context, file Node, mtime *time.Time) error {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	defer fs.activity.begin("SetMtime", file.GetFolderBranch().Tlf)()
+
+	limitDone, err := fs.folderLimiter.begin(
+		ctx, file.GetFolderBranch().Tlf, 0)
+	if err != nil {
+		return err
+	}
+	defer limitDone()
 
 	ops := fs.getOpsByNode(ctx, file)
 	return ops.SetMtime(ctx, file, mtime)
@@ -928,6 +1187,14 @@ func (fs *KBFSOpsStandard) SyncAll(
 	ctx context.Context, folderBranch FolderBranch) error {
 	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
 	defer timeTrackerDone()
+	defer fs.activity.begin("SyncAll", folderBranch.Tlf)()
+
+	limitDone, err := fs.folderLimiter.begin(
+		ctx, folderBranch.Tlf, 0)
+	if err != nil {
+		return err
+	}
+	defer limitDone()
 
 	ops := fs.getOps(ctx, folderBranch, FavoritesOpAdd)
 	return ops.SyncAll(ctx, folderBranch)
@@ -994,17 +1261,18 @@ func (fs *KBFSOpsStandard) Status(ctx context.Context) (
 	}
 
 	return KBFSStatus{
-		CurrentUser:     session.Name.String(),
-		IsConnected:     fs.config.MDServer().IsConnected(),
-		UsageBytes:      usageBytes,
-		ArchiveBytes:    archiveBytes,
-		LimitBytes:      limitBytes,
-		GitUsageBytes:   gitUsageBytes,
-		GitArchiveBytes: gitArchiveBytes,
-		GitLimitBytes:   gitLimitBytes,
-		FailingServices: failures,
-		JournalServer:   jServerStatus,
-		DiskCacheStatus: dbcStatus,
+		CurrentUser:       session.Name.String(),
+		IsConnected:       fs.config.MDServer().IsConnected(),
+		UsageBytes:        usageBytes,
+		ArchiveBytes:      archiveBytes,
+		LimitBytes:        limitBytes,
+		GitUsageBytes:     gitUsageBytes,
+		GitArchiveBytes:   gitArchiveBytes,
+		GitLimitBytes:     gitLimitBytes,
+		FailingServices:   failures,
+		JournalServer:     jServerStatus,
+		DiskCacheStatus:   dbcStatus,
+		DeviceConstraints: fs.config.DeviceConstraintsStatus(),
 	}, ch, err
 }
 
@@ -1050,6 +1318,144 @@ func (fs *KBFSOpsStandard) GetUpdateHistory(ctx context.Context,
 	return ops.GetUpdateHistory(ctx, folderBranch)
 }
 
+// GetHeldRevisions implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) GetHeldRevisions(ctx context.Context,
+	folderBranch FolderBranch) (held []HeldRevision, err error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.GetHeldRevisions(ctx, folderBranch)
+}
+
+// VerifySyncCache implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) VerifySyncCache(ctx context.Context,
+	folderBranch FolderBranch, repair bool) (SyncCacheReport, error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.VerifySyncCache(ctx, folderBranch, repair)
+}
+
+// FindLeakedBlockRefs implements the KBFSOps interface for
+// KBFSOpsStandard
+func (fs *KBFSOpsStandard) FindLeakedBlockRefs(ctx context.Context,
+	folderBranch FolderBranch, release bool) (BlockRefLeakReport, error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.FindLeakedBlockRefs(ctx, folderBranch, release)
+}
+
+// PinFileRange implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) PinFileRange(
+	ctx context.Context, file Node, off, length int64) error {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+
+	ops := fs.getOpsByNode(ctx, file)
+	return ops.PinFileRange(ctx, file, off, length)
+}
+
+// PinNode implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) PinNode(ctx context.Context, node Node) {
+	ops := fs.getOpsByNode(ctx, node)
+	ops.PinNode(ctx, node)
+}
+
+// UnpinNode implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) UnpinNode(ctx context.Context, node Node) {
+	ops := fs.getOpsByNode(ctx, node)
+	ops.UnpinNode(ctx, node)
+}
+
+// GetRecentFiles implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) GetRecentFiles(ctx context.Context,
+	folderBranch FolderBranch, filter string, limit int) (
+	[]RecentFile, error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.GetRecentFiles(ctx, folderBranch, filter, limit)
+}
+
+// GetFolderStats implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) GetFolderStats(ctx context.Context,
+	folderBranch FolderBranch) (FolderStats, error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.GetFolderStats(ctx, folderBranch)
+}
+
+// GetIdentifyBreaks implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) GetIdentifyBreaks(ctx context.Context,
+	folderBranch FolderBranch) (IdentifyBreaksStatus, bool, error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.GetIdentifyBreaks(ctx, folderBranch)
+}
+
+// RespondToIdentifyBreaks implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) RespondToIdentifyBreaks(ctx context.Context,
+	folderBranch FolderBranch, response IdentifyBreakResponse) error {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.RespondToIdentifyBreaks(ctx, folderBranch, response)
+}
+
+// FreezeWrites implements the KBFSOps interface for KBFSOpsStandard.
+func (fs *KBFSOpsStandard) FreezeWrites(ctx context.Context,
+	folderBranch FolderBranch, timeout time.Duration) error {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.FreezeWrites(ctx, folderBranch, timeout)
+}
+
+// ThawWrites implements the KBFSOps interface for KBFSOpsStandard.
+func (fs *KBFSOpsStandard) ThawWrites(
+	ctx context.Context, folderBranch FolderBranch) error {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.ThawWrites(ctx, folderBranch)
+}
+
+// GetQuotaReclamationStatus implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) GetQuotaReclamationStatus(ctx context.Context,
+	folderBranch FolderBranch) (QuotaReclamationStatus, error) {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.GetQuotaReclamationStatus(ctx, folderBranch)
+}
+
+// ForceQuotaReclamation implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) ForceQuotaReclamation(ctx context.Context,
+	folderBranch FolderBranch) error {
+	timeTrackerDone := fs.longOperationDebugDumper.Begin(ctx)
+	defer timeTrackerDone()
+
+	ops := fs.getOps(ctx, folderBranch, FavoritesOpNoChange)
+	return ops.ForceQuotaReclamation(ctx, folderBranch)
+}
+
 // GetEditHistory implements the KBFSOps interface for KBFSOpsStandard
 func (fs *KBFSOpsStandard) GetEditHistory(
 	ctx context.Context, folderBranch FolderBranch) (
@@ -1068,6 +1474,26 @@ func (fs *KBFSOpsStandard) GetNodeMetadata(ctx context.Context, node Node) (
 	return ops.GetNodeMetadata(ctx, node)
 }
 
+// GetCanonicalPath implements the KBFSOps interface for KBFSOpsStandard
+func (fs *KBFSOpsStandard) GetCanonicalPath(ctx context.Context, node Node) (
+	string, error) {
+	ops := fs.getOpsByNode(ctx, node)
+	return ops.GetCanonicalPath(ctx, node)
+}
+
+// GetOpenFolders implements the KBFSOps interface for
+// KBFSOpsStandard.
+func (fs *KBFSOpsStandard) GetOpenFolders(
+	_ context.Context) map[Favorite]FolderBranch {
+	fs.opsLock.RLock()
+	defer fs.opsLock.RUnlock()
+	folders := make(map[Favorite]FolderBranch, len(fs.opsByFav))
+	for fav, ops := range fs.opsByFav {
+		folders[fav] = ops.folderBranch
+	}
+	return folders
+}
+
 func (fs *KBFSOpsStandard) findTeamByID(
 	ctx context.Context, tid keybase1.TeamID) *folderBranchOps {
 	fs.opsLock.Lock()
@@ -1205,6 +1631,9 @@ func (fs *KBFSOpsStandard) changeHandle(ctx context.Context,
 	fs.log.CDebugf(ctx, "Changing handle: %v -> %v", oldFav, newFav)
 	fs.opsByFav[newFav] = ops
 	delete(fs.opsByFav, oldFav)
+	if newFav.Type == tlf.SingleTeam && newFav.Name != oldFav.Name {
+		fs.recordTeamRenameLocked(ctx, oldFav.Name, newHandle)
+	}
 }
 
 // Notifier:
@@ -21,6 +21,7 @@ import (
 	"github.com/keybase/kbfs/kbfsblock"
 	"github.com/keybase/kbfs/kbfscrypto"
 	"github.com/keybase/kbfs/tlf"
+	pkgerrors "github.com/pkg/errors"
 	"golang.org/x/net/context"
 )
 
@@ -2358,6 +2359,61 @@ func TestKBFSOpsCancelGetFavorites(t *testing.T) {
 	testRPCWithCanceledContext(t, serverConn, f)
 }
 
+// Test that a read waiting on a block fetch returns as soon as it's
+// canceled, even if the block server never responds (e.g., because
+// it's unreachable).  This is what lets an interrupted syscall
+// return.
+func TestKBFSOpsConcurCancelStalledRead(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsConcurInit(t, "test_user")
+	// The stalling block server doesn't work with the state checker.
+	defer kbfsConcurTestShutdownNoCheck(t, config, ctx, cancel)
+
+	// Turn off transient block caching, and prefetching so the
+	// prefetcher doesn't keep the stalled fetch alive.
+	config.SetBlockCache(NewBlockCacheStandard(0, 1<<30))
+	<-config.BlockOps().TogglePrefetcher(false)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", tlf.Private)
+	kbfsOps := config.KBFSOps()
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	if err != nil {
+		t.Fatalf("Couldn't create file: %v", err)
+	}
+	err = kbfsOps.Write(ctx, fileNode, []byte{1, 2, 3}, 0)
+	if err != nil {
+		t.Fatalf("Couldn't write file: %v", err)
+	}
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	if err != nil {
+		t.Fatalf("Couldn't sync file: %v", err)
+	}
+
+	onReadStalledCh, readUnstallCh, ctxStallRead :=
+		StallBlockOp(ctx, config, StallableBlockGet, 1)
+	readCtx, readCancel := context.WithCancel(ctxStallRead)
+	defer readCancel()
+
+	readErrCh := make(chan error, 1)
+	go func() {
+		_, err := kbfsOps.Read(readCtx, fileNode, make([]byte, 3), 0)
+		readErrCh <- err
+	}()
+	<-onReadStalledCh
+
+	readCancel()
+	select {
+	case err := <-readErrCh:
+		if pkgerrors.Cause(err) != context.Canceled {
+			t.Errorf("Unexpected read error: %+v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	}
+
+	// Let the abandoned fetch finish.
+	close(readUnstallCh)
+}
+
 type stallingNodeCache struct {
 	NodeCache
 
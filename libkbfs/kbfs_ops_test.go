@@ -8,11 +8,14 @@ import (
 	"bytes"
 	"fmt"
 	"math/rand"
+	"runtime"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
 	kbname "github.com/keybase/client/go/kbun"
+	"github.com/keybase/client/go/logger"
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/go-codec/codec"
 	"github.com/keybase/kbfs/env"
@@ -2968,6 +2971,70 @@ func TestKBFSOpsBackgroundFlush(t *testing.T) {
 	}
 }
 
+type testEvictObserver struct {
+	changes []NodeChange
+}
+
+func (t *testEvictObserver) LocalChange(ctx context.Context, node Node,
+	write WriteRange) {
+	// ignore
+}
+
+func (t *testEvictObserver) BatchChanges(ctx context.Context,
+	changes []NodeChange, _ []NodeID) {
+	// ignore
+}
+
+func (t *testEvictObserver) TlfHandleChange(ctx context.Context,
+	newHandle *TlfHandle) {
+	return
+}
+
+func (t *testEvictObserver) EvictNodes(ctx context.Context,
+	changes []NodeChange) {
+	t.changes = append(t.changes, changes...)
+}
+
+// Tests that a lookup past the node cache limit asks observers to
+// evict the least-recently-used unpinned nodes.
+func TestKBFSOpsEvictNodes(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	kbfsOps := config.KBFSOps()
+	nodeA, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	nodeB, _, err := kbfsOps.CreateFile(ctx, rootNode, "b", false, NoExcl)
+	require.NoError(t, err)
+	nodeC, _, err := kbfsOps.CreateFile(ctx, rootNode, "c", false, NoExcl)
+	require.NoError(t, err)
+
+	t.Log("Shrink the cache so it is two entries over, and pin c")
+	ops := getOps(config, rootNode.GetFolderBranch().Tlf)
+	ops.nodeCache.(*nodeCacheStandard).maxEntries = 2
+	kbfsOps.PinNode(ctx, nodeC)
+	defer kbfsOps.UnpinNode(ctx, nodeC)
+
+	obs := &testEvictObserver{}
+	err = config.Notifier().RegisterForChanges(
+		[]FolderBranch{rootNode.GetFolderBranch()}, obs)
+	require.NoError(t, err)
+	defer config.Notifier().UnregisterFromChanges(
+		[]FolderBranch{rootNode.GetFolderBranch()}, obs)
+
+	t.Log("Looking up a makes b the oldest unpinned entry")
+	_, _, err = kbfsOps.Lookup(ctx, rootNode, "a")
+	require.NoError(t, err)
+	require.Len(t, obs.changes, 1)
+	require.Equal(t, rootNode.GetID(), obs.changes[0].Node.GetID())
+	require.Equal(t, []string{"b", "a"}, obs.changes[0].DirUpdated)
+
+	// Keep the nodes referenced so their entries stay in the cache.
+	runtime.KeepAlive(nodeA)
+	runtime.KeepAlive(nodeB)
+}
+
 func TestKBFSOpsWriteRenameStat(t *testing.T) {
 	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "test_user")
 	// TODO: Use kbfsTestShutdownNoMocks.
@@ -3162,6 +3229,40 @@ func TestKBFSOpsMultiBlockSyncWithArchivedBlock(t *testing.T) {
 	}
 }
 
+func TestKBFSOpsLargeFileLimits(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "test_user")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", tlf.Private)
+	kbfsOps := config.KBFSOps()
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+
+	t.Log("A sparse terabyte file can be written and synced")
+	const tb = int64(1) << 40
+	err = kbfsOps.Write(ctx, fileNode, []byte{1, 2, 3}, tb)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fileNode.GetFolderBranch())
+	require.NoError(t, err)
+	ei, err := kbfsOps.Stat(ctx, fileNode)
+	require.NoError(t, err)
+	require.Equal(t, uint64(tb+3), ei.Size)
+	buf := make([]byte, 3)
+	n, err := kbfsOps.Read(ctx, fileNode, buf, tb)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), n)
+	require.Equal(t, []byte{1, 2, 3}, buf)
+
+	t.Log("Files can't grow past the largest offset")
+	err = kbfsOps.Write(ctx, fileNode, []byte{1, 2}, int64(maxFileBytes-1))
+	require.IsType(t, FileTooBigError{}, errors.Cause(err))
+	err = kbfsOps.Truncate(ctx, fileNode, maxFileBytes+1)
+	require.IsType(t, FileTooBigError{}, errors.Cause(err))
+	ei, err = kbfsOps.Stat(ctx, fileNode)
+	require.NoError(t, err)
+	require.Equal(t, uint64(tb+3), ei.Size)
+}
+
 type corruptBlockServer struct {
 	BlockServer
 }
@@ -3365,6 +3466,42 @@ func TestForceFastForwardOnEmptyTLF(t *testing.T) {
 	}
 }
 
+func TestKBFSOpsFolderUpdated(t *testing.T) {
+	config1, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config1, ctx, cancel)
+	config2 := ConfigAsUser(config1, "alice")
+	defer CheckConfigAndShutdown(ctx, t, config2)
+
+	rootNode1 := GetRootNodeOrBust(ctx, t, config1, "alice", tlf.Private)
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, "alice", tlf.Private)
+	fb := rootNode2.GetFolderBranch()
+	unpause, err := DisableUpdatesForTesting(config2, fb)
+	require.NoError(t, err)
+	defer func() { unpause <- struct{}{} }()
+
+	t.Log("Unloaded folders are ignored")
+	err = config2.KBFSOps().FolderUpdated(
+		ctx, tlf.FakeID(1, tlf.Public), kbfsmd.RevisionInitial)
+	require.NoError(t, err)
+
+	t.Log("A new revision is fetched even with updates paused")
+	kbfsOps1 := config1.KBFSOps()
+	_, _, err = kbfsOps1.CreateFile(ctx, rootNode1, "a", false, NoExcl)
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps1.SyncAll(ctx, rootNode1.GetFolderBranch()))
+	status, _, err := kbfsOps1.FolderStatus(ctx, rootNode1.GetFolderBranch())
+	require.NoError(t, err)
+
+	err = config2.KBFSOps().FolderUpdated(ctx, fb.Tlf, status.Revision)
+	require.NoError(t, err)
+	_, _, err = config2.KBFSOps().Lookup(ctx, rootNode2, "a")
+	require.NoError(t, err)
+
+	t.Log("Old revisions are ignored")
+	err = config2.KBFSOps().FolderUpdated(ctx, fb.Tlf, kbfsmd.RevisionInitial)
+	require.NoError(t, err)
+}
+
 // Regression test for KBFS-2161.
 func TestDirtyPathsAfterRemoveDir(t *testing.T) {
 	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "test_user")
@@ -3902,3 +4039,313 @@ func TestKBFSOpsReadonlyFSNodes(t *testing.T) {
 	require.Equal(t, int64(5), n)
 	require.Equal(t, "ddata", string(data))
 }
+
+type countingPutBlockServer struct {
+	BlockServer
+	puts int64
+}
+
+func (cbs *countingPutBlockServer) Put(
+	ctx context.Context, tlfID tlf.ID, id kbfsblock.ID,
+	context kbfsblock.Context, buf []byte,
+	serverHalf kbfscrypto.BlockCryptKeyServerHalf) error {
+	atomic.AddInt64(&cbs.puts, 1)
+	return cbs.BlockServer.Put(ctx, tlfID, id, context, buf, serverHalf)
+}
+
+// makeDirWithEntries creates `name` under `parent`, holding `n` empty
+// files spread across subdirectories of at most 1000 entries each, and
+// syncs it.
+func makeDirWithEntries(ctx context.Context, t logger.TestLogBackend,
+	config Config, parent Node, name string, n int) {
+	kbfsOps := config.KBFSOps()
+	dir, _, err := kbfsOps.CreateDir(ctx, parent, name)
+	if err != nil {
+		t.Fatalf("Couldn't create dir: %+v", err)
+	}
+	const perDir = 1000
+	for i := 0; i < n; i += perDir {
+		sub, _, err := kbfsOps.CreateDir(ctx, dir, fmt.Sprintf("d%d", i))
+		if err != nil {
+			t.Fatalf("Couldn't create dir: %+v", err)
+		}
+		for j := i; j < n && j < i+perDir; j++ {
+			_, _, err := kbfsOps.CreateFile(
+				ctx, sub, fmt.Sprintf("f%d", j), false, NoExcl)
+			if err != nil {
+				t.Fatalf("Couldn't create file: %+v", err)
+			}
+		}
+		err = kbfsOps.SyncAll(ctx, dir.GetFolderBranch())
+		if err != nil {
+			t.Fatalf("Couldn't sync: %+v", err)
+		}
+	}
+}
+
+// Test that moving a directory only rewrites its old and new parents,
+// no matter how much it contains.
+func TestKBFSOpsRenameDirCostIndependentOfSize(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "test_user")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	bserver := &countingPutBlockServer{BlockServer: config.BlockServer()}
+	config.SetBlockServer(bserver)
+	// The state checker needs the original block server.
+	defer config.SetBlockServer(bserver.BlockServer)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", tlf.Private)
+	kbfsOps := config.KBFSOps()
+	dest, _, err := kbfsOps.CreateDir(ctx, rootNode, "dest")
+	require.NoError(t, err)
+	makeDirWithEntries(ctx, t, config, rootNode, "small", 1)
+	makeDirWithEntries(ctx, t, config, rootNode, "big", 3000)
+
+	renamePuts := func(name string) int64 {
+		before := atomic.LoadInt64(&bserver.puts)
+		err := kbfsOps.Rename(ctx, rootNode, name, dest, name)
+		require.NoError(t, err)
+		err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+		require.NoError(t, err)
+		return atomic.LoadInt64(&bserver.puts) - before
+	}
+	smallPuts := renamePuts("small")
+	bigPuts := renamePuts("big")
+	require.Equal(t, smallPuts, bigPuts)
+}
+
+func benchmarkRenameDirWithEntries(b *testing.B, n int) {
+	config := MakeTestConfigOrBust(noLogTB{b}, "test_user")
+	ctx := BackgroundContextWithCancellationDelayer()
+	defer CleanupCancellationDelayer(ctx)
+	defer CheckConfigAndShutdown(ctx, b, config)
+
+	rootNode := GetRootNodeOrBust(ctx, b, config, "test_user", tlf.Private)
+	kbfsOps := config.KBFSOps()
+	dest, _, err := kbfsOps.CreateDir(ctx, rootNode, "dest")
+	if err != nil {
+		b.Fatalf("Couldn't create dir: %+v", err)
+	}
+	makeDirWithEntries(ctx, b, config, rootNode, "big", n)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		src, dst := rootNode, dest
+		if i%2 == 1 {
+			src, dst = dest, rootNode
+		}
+		err := kbfsOps.Rename(ctx, src, "big", dst, "big")
+		if err != nil {
+			b.Fatalf("Couldn't rename: %+v", err)
+		}
+		err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+		if err != nil {
+			b.Fatalf("Couldn't sync: %+v", err)
+		}
+	}
+	b.StopTimer()
+}
+
+// BenchmarkRenameDirWithEntries moves a directory back and forth
+// between two parents, syncing each move.  The million-entry case
+// takes a while to set up; select it with
+// `-bench=RenameDirWithEntries/entries=1000000`.
+func BenchmarkRenameDirWithEntries(b *testing.B) {
+	for _, n := range []int{1000, 10000, 100000, 1000000} {
+		n := n // capture range variable.
+		b.Run(fmt.Sprintf("entries=%d", n), func(b *testing.B) {
+			benchmarkRenameDirWithEntries(b, n)
+		})
+	}
+}
+
+// Test that directory times follow POSIX: adding, removing or
+// renaming an entry updates the mtime and ctime of the directories
+// involved, but writing to an existing file only changes the file.
+// Build and sync tools like make and `rsync -u` rely on this.
+func TestKBFSOpsParentDirTimes(t *testing.T) {
+	var u1 kbname.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	clock, now := newTestClockAndTimeNow()
+	config.SetClock(clock)
+	// Only the backup mode and the opt-in batching coarsen
+	// directory times.
+	require.Zero(t, config.Mode().DirMtimeGranularity())
+	require.Zero(t, config.DirTimesBatchPeriod())
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps()
+	a, _, err := kbfsOps.CreateDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	b, _, err := kbfsOps.CreateDir(ctx, rootNode, "b")
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+
+	checkTimes := func(n Node, mtime, ctime time.Time) {
+		ei, err := kbfsOps.Stat(ctx, n)
+		require.NoError(t, err)
+		require.Equal(t, mtime.UnixNano(), ei.Mtime)
+		require.Equal(t, ctime.UnixNano(), ei.Ctime)
+	}
+
+	t.Log("Creating a file updates its parent")
+	clock.Add(time.Minute)
+	created := clock.Now()
+	f, _, err := kbfsOps.CreateFile(ctx, a, "f", false, NoExcl)
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+	checkTimes(a, created, created)
+	checkTimes(b, now, now)
+
+	t.Log("Writing and chmodding only update the file")
+	clock.Add(time.Minute)
+	written := clock.Now()
+	require.NoError(t, kbfsOps.Write(ctx, f, []byte("data"), 0))
+	clock.Add(time.Minute)
+	chmodded := clock.Now()
+	require.NoError(t, kbfsOps.SetEx(ctx, f, true))
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+	checkTimes(f, written, chmodded)
+	checkTimes(a, created, created)
+
+	t.Log("Renaming updates both parents, and the renamed file's ctime")
+	clock.Add(time.Minute)
+	renamed := clock.Now()
+	require.NoError(t, kbfsOps.Rename(ctx, a, "f", b, "g"))
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+	checkTimes(a, renamed, renamed)
+	checkTimes(b, renamed, renamed)
+	checkTimes(f, written, renamed)
+	checkTimes(rootNode, now, now)
+
+	t.Log("Removing updates the parent")
+	clock.Add(time.Minute)
+	removed := clock.Now()
+	require.NoError(t, kbfsOps.RemoveEntry(ctx, b, "g"))
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+	checkTimes(b, removed, removed)
+	checkTimes(a, renamed, renamed)
+
+	t.Log("Other devices see the same times")
+	config2 := ConfigAsUser(config, u1)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, u1.String(), tlf.Private)
+	b2, ei, err := config2.KBFSOps().Lookup(ctx, rootNode2, "b")
+	require.NoError(t, err)
+	require.NotNil(t, b2)
+	require.Equal(t, removed.UnixNano(), ei.Mtime)
+	require.Equal(t, removed.UnixNano(), ei.Ctime)
+}
+
+// Test that with a batch period set, changes to a directory's entries
+// only update its times once the period since the last update is
+// over, and then to the time of that change.
+func TestKBFSOpsBatchedParentDirTimes(t *testing.T) {
+	var u1 kbname.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	clock, now := newTestClockAndTimeNow()
+	config.SetClock(clock)
+	config.SetDirTimesBatchPeriod(time.Hour)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps()
+	a, _, err := kbfsOps.CreateDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+
+	checkTimes := func(n Node, mtime, ctime time.Time) {
+		ei, err := kbfsOps.Stat(ctx, n)
+		require.NoError(t, err)
+		require.Equal(t, mtime.UnixNano(), ei.Mtime)
+		require.Equal(t, ctime.UnixNano(), ei.Ctime)
+	}
+
+	t.Log("Changes within the period leave the times alone")
+	clock.Add(time.Minute)
+	_, _, err = kbfsOps.CreateFile(ctx, a, "f", false, NoExcl)
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+	clock.Add(time.Minute)
+	require.NoError(t, kbfsOps.RemoveEntry(ctx, a, "f"))
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+	checkTimes(a, now, now)
+
+	t.Log("The first change after the period updates the times")
+	clock.Add(time.Hour)
+	changed := clock.Now()
+	_, _, err = kbfsOps.CreateFile(ctx, a, "g", false, NoExcl)
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+	checkTimes(a, changed, changed)
+
+	t.Log("Turning batching off updates them on every change again")
+	config.SetDirTimesBatchPeriod(0)
+	clock.Add(time.Minute)
+	removed := clock.Now()
+	require.NoError(t, kbfsOps.RemoveEntry(ctx, a, "g"))
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+	checkTimes(a, removed, removed)
+}
+
+func TestKBFSOpsSetAttrBatching(t *testing.T) {
+	var u1 kbname.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, u1)
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, u1.String(), tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps()
+	f, _, err := kbfsOps.CreateFile(ctx, rootNode, "f", false, NoExcl)
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+
+	ops := getOps(config, fb.Tlf)
+	lState := makeFBOLockState()
+
+	t.Log("Repeated setattrs on a file only cache one op per attribute")
+	mtime := time.Unix(1500000000, 123456789)
+	for i := 0; i < 5; i++ {
+		mtime = mtime.Add(time.Nanosecond)
+		require.NoError(t, kbfsOps.SetMtime(ctx, f, &mtime))
+	}
+	require.NoError(t, kbfsOps.SetEx(ctx, f, true))
+	require.NoError(t, kbfsOps.SetEx(ctx, f, false))
+	require.Equal(t, 2, ops.getCachedDirOpsCount(lState))
+
+	ei, err := kbfsOps.Stat(ctx, f)
+	require.NoError(t, err)
+	require.Equal(t, mtime.UnixNano(), ei.Mtime)
+	require.Equal(t, File, ei.Type)
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+
+	t.Log("Setting the same mtime again is a no-op")
+	require.NoError(t, kbfsOps.SetMtime(ctx, f, &mtime))
+	require.Equal(t, 0, ops.getCachedDirOpsCount(lState))
+
+	t.Log("Other devices see the full-precision mtime")
+	config2 := ConfigAsUser(config, u1)
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, u1.String(), tlf.Private)
+	_, ei, err = config2.KBFSOps().Lookup(ctx, rootNode2, "f")
+	require.NoError(t, err)
+	require.Equal(t, mtime.UnixNano(), ei.Mtime)
+	require.Equal(t, File, ei.Type)
+}
+
+func TestFolderBranchOpsGetOpenFolders(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps()
+	ops := kbfsOps.(*KBFSOpsStandard).getOpsByNode(ctx, rootNode)
+	folders := ops.GetOpenFolders(ctx)
+	require.Equal(t, kbfsOps.GetOpenFolders(ctx), folders)
+	require.Equal(t, map[Favorite]FolderBranch{
+		{Name: "alice", Type: tlf.Private}: fb,
+	}, folders)
+}
@@ -0,0 +1,31 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import "golang.org/x/net/context"
+
+// KBPKIAnonymous is a KBPKI that never reports a current session,
+// even if a user is logged into the Keybase service.  KBFS then acts
+// as an anonymous device: it doesn't authenticate to the servers,
+// and can read public folders, but can't write to any folder or read
+// private or team folders.  Everything else is passed on to the
+// wrapped KBPKI.
+type KBPKIAnonymous struct {
+	KBPKI
+}
+
+var _ KBPKI = KBPKIAnonymous{}
+
+// NewKBPKIAnonymous returns a new KBPKIAnonymous wrapping `k`.
+func NewKBPKIAnonymous(k KBPKI) KBPKIAnonymous {
+	return KBPKIAnonymous{k}
+}
+
+// GetCurrentSession implements the KBPKI interface for
+// KBPKIAnonymous.
+func (k KBPKIAnonymous) GetCurrentSession(ctx context.Context) (
+	SessionInfo, error) {
+	return SessionInfo{}, NoCurrentSessionError{}
+}
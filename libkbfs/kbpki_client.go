@@ -6,6 +6,8 @@ package libkbfs
 
 import (
 	"fmt"
+	"io"
+	"net"
 	"time"
 
 	kbname "github.com/keybase/client/go/kbun"
@@ -15,6 +17,7 @@ import (
 	"github.com/keybase/kbfs/kbfscrypto"
 	"github.com/keybase/kbfs/kbfsmd"
 	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 )
 
@@ -49,7 +52,67 @@ func (k *KBPKIClient) GetCurrentSession(ctx context.Context) (
 // Resolve implements the KBPKI interface for KBPKIClient.
 func (k *KBPKIClient) Resolve(ctx context.Context, assertion string) (
 	kbname.NormalizedUsername, keybase1.UserOrTeamID, error) {
-	return k.serviceOwner.KeybaseService().Resolve(ctx, assertion)
+	cache := getResolverCache(k.serviceOwner)
+	var stale resolverCacheEntry
+	haveStale := false
+	if cache != nil {
+		entry, ok, fresh, err := cache.get(assertion)
+		switch {
+		case err != nil:
+			k.log.CDebugf(ctx, "Couldn't get cached resolution of %s: %+v",
+				assertion, err)
+		case fresh:
+			return entry.Name, entry.ID, nil
+		case ok:
+			stale, haveStale = entry, true
+		}
+	}
+
+	name, id, err := k.serviceOwner.KeybaseService().Resolve(ctx, assertion)
+	if err != nil {
+		// An old team name resolves to the renamed team, if we know
+		// about the rename.
+		if newName, tid, ok := k.resolveTeamRename(ctx, assertion); ok {
+			return newName, tid.AsUserOrTeam(), nil
+		}
+		if _, ok := err.(NoSuchUserError); ok && cache != nil {
+			// The assertion doesn't resolve anymore, e.g. because
+			// its proof was revoked.
+			if rmErr := cache.remove(assertion); rmErr != nil {
+				k.log.CDebugf(ctx, "Couldn't remove cached resolution "+
+					"of %s: %+v", assertion, rmErr)
+			}
+		} else if haveStale && ctx.Err() == nil &&
+			isServiceConnectivityError(err) {
+			// The service, or the servers behind it, can't be
+			// reached, so the last known resolution is the best we
+			// have.
+			k.log.CDebugf(ctx, "Using cached resolution of %s from %s "+
+				"after error: %+v", assertion, stale.Resolved, err)
+			return stale.Name, stale.ID, nil
+		}
+		return kbname.NormalizedUsername(""), keybase1.UserOrTeamID(""), err
+	}
+	if cache != nil {
+		if putErr := cache.put(assertion, name, id); putErr != nil {
+			k.log.CDebugf(ctx, "Couldn't cache resolution of %s: %+v",
+				assertion, putErr)
+		}
+	}
+	return name, id, nil
+}
+
+// isServiceConnectivityError returns true if `err` means that the
+// service couldn't be reached, or couldn't reach the Keybase servers,
+// rather than that it got an answer.
+func isServiceConnectivityError(err error) bool {
+	switch e := errors.Cause(err).(type) {
+	case libkb.AppStatusError:
+		return e.Code == libkb.SCAPINetworkError || e.Code == libkb.SCTimeout
+	case libkb.APINetError, libkb.TimeoutError, net.Error:
+		return true
+	}
+	return errors.Cause(err) == io.EOF
 }
 
 // Identify implements the KBPKI interface for KBPKIClient.
@@ -341,6 +404,18 @@ func (k *KBPKIClient) IsTeamReader(
 	return tid.IsPublic() || teamInfo.Writers[uid] || teamInfo.Readers[uid], nil
 }
 
+// IsTeamAdmin implements the KBPKI interface for KBPKIClient.
+func (k *KBPKIClient) IsTeamAdmin(
+	ctx context.Context, tid keybase1.TeamID, uid keybase1.UID) (bool, error) {
+	teamInfo, err := k.serviceOwner.KeybaseService().LoadTeamPlusKeys(
+		ctx, tid, tlf.Unknown, kbfsmd.UnspecifiedKeyGen, keybase1.UserVersion{},
+		kbfscrypto.VerifyingKey{}, keybase1.TeamRole_ADMIN)
+	if err != nil {
+		return false, err
+	}
+	return teamInfo.Admins[uid], nil
+}
+
 // GetTeamRootID implements the KBPKI interface for KBPKIClient.
 func (k *KBPKIClient) GetTeamRootID(ctx context.Context, tid keybase1.TeamID) (
 	keybase1.TeamID, error) {
@@ -398,6 +473,14 @@ func (k *KBPKIClient) PutGitMetadata(
 		ctx, folder, repoID, metadata)
 }
 
+// DeleteGitMetadata implements the KBPKI interface for KBPKIClient.
+func (k *KBPKIClient) DeleteGitMetadata(
+	ctx context.Context, folder keybase1.Folder,
+	repoName keybase1.GitRepoName) error {
+	return k.serviceOwner.KeybaseService().DeleteGitMetadata(
+		ctx, folder, repoName)
+}
+
 // GetCurrentSessionIfPossible returns the current username and UID
 // from kbpki.GetCurrentSession.  If sessionNotRequired is true
 // NoCurrentSessionError is ignored and empty username and uid will be
@@ -0,0 +1,201 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sync"
+
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	keyBundleCacheFolderName = "kbfs_key_bundles"
+
+	keyBundleCacheWriterPrefix = "w:"
+	keyBundleCacheReaderPrefix = "r:"
+)
+
+// diskKeyBundleCache is a kbfsmd.KeyBundleCache that falls back to a
+// persistent store when its in-memory delegate misses.  Only the key
+// bundles passed to `persist` make it to disk, which MDOpsStandard
+// does for the bundles of synced TLFs every time it fetches their
+// MD, including after every key rotation.  That way reading the MD
+// of a synced TLF never has to fetch its key bundles from the
+// server, as long as the MD itself is available.  Key bundles only
+// hold keys encrypted for devices, so it's safe to keep them on
+// disk.  Entries are only trusted if they still hash to the ID they
+// were stored under.
+//
+// This only covers private TLFs with key bundles.  Team TLFs don't
+// have any, since their keys come from the service, and those keys
+// are deliberately not persisted here, since unlike key bundles they
+// aren't encrypted; offline access to team TLFs relies on the
+// service's own team cache.
+type diskKeyBundleCache struct {
+	delegate kbfsmd.KeyBundleCache
+	codec    kbfscodec.Codec
+
+	lock sync.RWMutex
+	db   *levelDb // nil after shutdown
+}
+
+var _ kbfsmd.KeyBundleCache = (*diskKeyBundleCache)(nil)
+
+func newDiskKeyBundleCache(delegate kbfsmd.KeyBundleCache,
+	codec kbfscodec.Codec, db *levelDb) *diskKeyBundleCache {
+	return &diskKeyBundleCache{
+		delegate: delegate,
+		codec:    codec,
+		db:       db,
+	}
+}
+
+// get decodes the entry for `key` into `bundle`, returning false if
+// there is no such entry.
+func (c *diskKeyBundleCache) get(key string, bundle interface{}) (
+	bool, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.db == nil {
+		return false, nil
+	}
+	buf, err := c.db.Get([]byte(key), nil)
+	if errors.Cause(err) == leveldb.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	err = c.codec.Decode(buf, bundle)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// delete removes the entry for `key`, which didn't match its ID, so
+// the bundle is fetched again instead.
+func (c *diskKeyBundleCache) delete(key string) error {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.db == nil {
+		return nil
+	}
+	return c.db.Delete([]byte(key), nil)
+}
+
+// GetTLFReaderKeyBundle implements the KeyBundleCache interface for
+// diskKeyBundleCache.
+func (c *diskKeyBundleCache) GetTLFReaderKeyBundle(
+	bundleID kbfsmd.TLFReaderKeyBundleID) (
+	*kbfsmd.TLFReaderKeyBundleV3, error) {
+	rkb, err := c.delegate.GetTLFReaderKeyBundle(bundleID)
+	if rkb != nil || err != nil {
+		return rkb, err
+	}
+	var diskRKB kbfsmd.TLFReaderKeyBundleV3
+	key := keyBundleCacheReaderPrefix + bundleID.String()
+	ok, err := c.get(key, &diskRKB)
+	if !ok || err != nil {
+		return nil, err
+	}
+	id, err := kbfsmd.MakeTLFReaderKeyBundleID(c.codec, diskRKB)
+	if err != nil {
+		return nil, err
+	}
+	if id != bundleID {
+		return nil, c.delete(key)
+	}
+	c.delegate.PutTLFReaderKeyBundle(bundleID, diskRKB)
+	return &diskRKB, nil
+}
+
+// GetTLFWriterKeyBundle implements the KeyBundleCache interface for
+// diskKeyBundleCache.
+func (c *diskKeyBundleCache) GetTLFWriterKeyBundle(
+	bundleID kbfsmd.TLFWriterKeyBundleID) (
+	*kbfsmd.TLFWriterKeyBundleV3, error) {
+	wkb, err := c.delegate.GetTLFWriterKeyBundle(bundleID)
+	if wkb != nil || err != nil {
+		return wkb, err
+	}
+	var diskWKB kbfsmd.TLFWriterKeyBundleV3
+	key := keyBundleCacheWriterPrefix + bundleID.String()
+	ok, err := c.get(key, &diskWKB)
+	if !ok || err != nil {
+		return nil, err
+	}
+	id, err := kbfsmd.MakeTLFWriterKeyBundleID(c.codec, diskWKB)
+	if err != nil {
+		return nil, err
+	}
+	if id != bundleID {
+		return nil, c.delete(key)
+	}
+	c.delegate.PutTLFWriterKeyBundle(bundleID, diskWKB)
+	return &diskWKB, nil
+}
+
+// PutTLFReaderKeyBundle implements the KeyBundleCache interface for
+// diskKeyBundleCache.
+func (c *diskKeyBundleCache) PutTLFReaderKeyBundle(
+	bundleID kbfsmd.TLFReaderKeyBundleID, rkb kbfsmd.TLFReaderKeyBundleV3) {
+	c.delegate.PutTLFReaderKeyBundle(bundleID, rkb)
+}
+
+// PutTLFWriterKeyBundle implements the KeyBundleCache interface for
+// diskKeyBundleCache.
+func (c *diskKeyBundleCache) PutTLFWriterKeyBundle(
+	bundleID kbfsmd.TLFWriterKeyBundleID, wkb kbfsmd.TLFWriterKeyBundleV3) {
+	c.delegate.PutTLFWriterKeyBundle(bundleID, wkb)
+}
+
+// putIfAbsent encodes `bundle` as the entry for `key`, unless there
+// already is one.
+func (c *diskKeyBundleCache) putIfAbsent(
+	key string, bundle interface{}) error {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.db == nil {
+		return nil
+	}
+	ok, err := c.db.Has([]byte(key), nil)
+	if err != nil {
+		return errors.WithStack(err)
+	} else if ok {
+		return nil
+	}
+	buf, err := c.codec.Encode(bundle)
+	if err != nil {
+		return err
+	}
+	return c.db.Put([]byte(key), buf, nil)
+}
+
+// persist stores the given key bundles on disk, unless they're
+// already there.
+func (c *diskKeyBundleCache) persist(
+	wkbID kbfsmd.TLFWriterKeyBundleID, wkb kbfsmd.TLFWriterKeyBundleV3,
+	rkbID kbfsmd.TLFReaderKeyBundleID, rkb kbfsmd.TLFReaderKeyBundleV3) error {
+	err := c.putIfAbsent(keyBundleCacheWriterPrefix+wkbID.String(), wkb)
+	if err != nil {
+		return err
+	}
+	return c.putIfAbsent(keyBundleCacheReaderPrefix+rkbID.String(), rkb)
+}
+
+// shutdown closes the persistent store.  After it's called, the
+// cache only uses its delegate.
+func (c *diskKeyBundleCache) shutdown() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.db == nil {
+		return
+	}
+	_ = c.db.Close()
+	c.db = nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestDiskKeyBundleCache(t *testing.T) {
+	codec := kbfscodec.NewMsgpack()
+	ldb, err := openLevelDB(storage.NewMemStorage())
+	require.NoError(t, err)
+	cache := newDiskKeyBundleCache(
+		kbfsmd.NewKeyBundleCacheLRU(1<<20), codec, ldb)
+	defer cache.shutdown()
+
+	makeBundles := func(tlfByte byte) (
+		kbfsmd.TLFWriterKeyBundleID, kbfsmd.TLFWriterKeyBundleV3,
+		kbfsmd.TLFReaderKeyBundleID, kbfsmd.TLFReaderKeyBundleV3) {
+		writer := keybase1.MakeTestUID(1).AsUserOrTeam()
+		reader := keybase1.MakeTestUID(1 + uint32(tlfByte)).AsUserOrTeam()
+		h, err := tlf.MakeHandle([]keybase1.UserOrTeamID{writer},
+			[]keybase1.UserOrTeamID{reader}, nil, nil, nil)
+		require.NoError(t, err)
+		rmd, err := kbfsmd.MakeInitialRootMetadataV3(
+			tlf.FakeID(tlfByte, tlf.Private), h)
+		require.NoError(t, err)
+		extra := kbfsmd.FakeInitialRekey(rmd, h, kbfscrypto.TLFPublicKey{})
+		wkb, rkb, err := rmd.GetTLFKeyBundlesForTest(extra)
+		require.NoError(t, err)
+		return rmd.GetTLFWriterKeyBundleID(), *wkb,
+			rmd.GetTLFReaderKeyBundleID(), *rkb
+	}
+	wkbID1, wkb1, rkbID1, rkb1 := makeBundles(1)
+	wkbID2, wkb2, rkbID2, rkb2 := makeBundles(2)
+	require.NotEqual(t, rkbID1, rkbID2)
+
+	t.Log("Persisted bundles outlive the in-memory cache")
+	err = cache.persist(wkbID1, wkb1, rkbID1, rkb1)
+	require.NoError(t, err)
+	cache.PutTLFWriterKeyBundle(wkbID2, wkb2)
+	cache.PutTLFReaderKeyBundle(rkbID2, rkb2)
+	cache.delegate = kbfsmd.NewKeyBundleCacheLRU(1 << 20)
+
+	wkb, err := cache.GetTLFWriterKeyBundle(wkbID1)
+	require.NoError(t, err)
+	require.NotNil(t, wkb)
+	id, err := kbfsmd.MakeTLFWriterKeyBundleID(codec, *wkb)
+	require.NoError(t, err)
+	require.Equal(t, wkbID1, id)
+	rkb, err := cache.GetTLFReaderKeyBundle(rkbID1)
+	require.NoError(t, err)
+	require.NotNil(t, rkb)
+	rID, err := kbfsmd.MakeTLFReaderKeyBundleID(codec, *rkb)
+	require.NoError(t, err)
+	require.Equal(t, rkbID1, rID)
+
+	t.Log("Bundles that were only put aren't on disk")
+	wkb, err = cache.GetTLFWriterKeyBundle(wkbID2)
+	require.NoError(t, err)
+	require.Nil(t, wkb)
+	rkb, err = cache.GetTLFReaderKeyBundle(rkbID2)
+	require.NoError(t, err)
+	require.Nil(t, rkb)
+
+	t.Log("Persisting the same bundles again is fine")
+	err = cache.persist(wkbID1, wkb1, rkbID1, rkb1)
+	require.NoError(t, err)
+
+	t.Log("Bundles that don't match their IDs are dropped")
+	err = cache.persist(wkbID2, wkb1, rkbID2, rkb1)
+	require.NoError(t, err)
+	wkb, err = cache.GetTLFWriterKeyBundle(wkbID2)
+	require.NoError(t, err)
+	require.Nil(t, wkb)
+	rkb, err = cache.GetTLFReaderKeyBundle(rkbID2)
+	require.NoError(t, err)
+	require.Nil(t, rkb)
+	ok, err := ldb.Has([]byte(keyBundleCacheWriterPrefix+wkbID2.String()), nil)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
@@ -466,6 +466,16 @@ func (k *KeybaseDaemonLocal) LoadTeamPlusKeys(
 	return infoCopy, nil
 }
 
+// LoadPGPPublicKeys implements the KeybaseService interface for
+// KeybaseDaemonLocal.  Local users have no PGP keys.
+func (k *KeybaseDaemonLocal) LoadPGPPublicKeys(
+	ctx context.Context, uid keybase1.UID) ([]string, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
 // CreateTeamTLF implements the KBPKI interface for
 // KeybaseDaemonLocal.
 func (k *KeybaseDaemonLocal) CreateTeamTLF(
@@ -758,6 +768,28 @@ func (k *KeybaseDaemonLocal) addTeamWriterForTest(
 	return nil
 }
 
+func (k *KeybaseDaemonLocal) addTeamAdminForTest(
+	tid keybase1.TeamID, uid keybase1.UID) error {
+	err := k.addTeamWriterForTest(tid, uid)
+	if err != nil {
+		return err
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	t, err := k.localTeams.getLocalTeam(tid)
+	if err != nil {
+		return err
+	}
+
+	if t.Admins == nil {
+		t.Admins = make(map[keybase1.UID]bool)
+	}
+	t.Admins[uid] = true
+	k.localTeams[tid] = t
+	return nil
+}
+
 func (k *KeybaseDaemonLocal) removeTeamWriterForTest(
 	tid keybase1.TeamID, uid keybase1.UID) error {
 	k.lock.Lock()
@@ -937,6 +969,14 @@ func (k *KeybaseDaemonLocal) PutGitMetadata(
 	return nil
 }
 
+// DeleteGitMetadata implements the KeybaseService interface for
+// KeybaseDaemonLocal.
+func (k *KeybaseDaemonLocal) DeleteGitMetadata(
+	ctx context.Context, folder keybase1.Folder,
+	repoName keybase1.GitRepoName) error {
+	return nil
+}
+
 // Shutdown implements KeybaseDaemon for KeybaseDaemonLocal.
 func (k *KeybaseDaemonLocal) Shutdown() {
 	k.favoriteStore.Shutdown()
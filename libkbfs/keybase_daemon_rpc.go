@@ -141,7 +141,8 @@ func (k *KeybaseDaemonRPC) fillClients(client rpc.GenericClient) {
 		keybase1.FavoriteClient{Cli: client},
 		keybase1.KbfsClient{Cli: client},
 		keybase1.KbfsMountClient{Cli: client},
-		keybase1.GitClient{Cli: client})
+		keybase1.GitClient{Cli: client},
+		keybase1.ApiserverClient{Cli: client})
 }
 
 type daemonLogUI struct {
@@ -57,6 +57,7 @@ func TestKeybaseDaemonRPCGetCurrentSessionCanceled(t *testing.T) {
 type fakeKeybaseClient struct {
 	session                     SessionInfo
 	users                       map[keybase1.UID]UserInfo
+	proofs                      map[keybase1.UID]keybase1.Proofs
 	currentSessionCalled        bool
 	identifyCalled              bool
 	loadUserPlusKeysCalled      bool
@@ -131,6 +132,20 @@ func (c *fakeKeybaseClient) Call(ctx context.Context, s string, args interface{}
 		c.loadAllPublicKeysUnverified = true
 		return nil
 
+	case "keybase.1.user.loadUncheckedUserSummaries":
+		arg := args.([]interface{})[0].(keybase1.LoadUncheckedUserSummariesArg)
+		var summaries []keybase1.UserSummary
+		for _, uid := range arg.Uids {
+			if proofs, ok := c.proofs[uid]; ok {
+				summaries = append(summaries, keybase1.UserSummary{
+					Uid:    uid,
+					Proofs: proofs,
+				})
+			}
+		}
+		*res.(*[]keybase1.UserSummary) = summaries
+		return nil
+
 	case "keybase.1.kbfs.FSEditList":
 		c.editResponse = args.([]interface{})[0].(keybase1.FSEditListArg)
 		return nil
@@ -426,3 +441,29 @@ func TestKeybaseDaemonRPCEditList(t *testing.T) {
 	history := client1.editResponse.Edits
 	require.Equal(t, expectedHistory, history)
 }
+
+func TestKeybaseDaemonRPCCountProofs(t *testing.T) {
+	uid1 := keybase1.MakeTestUID(1)
+	uid2 := keybase1.MakeTestUID(2)
+	client := &fakeKeybaseClient{
+		proofs: map[keybase1.UID]keybase1.Proofs{
+			uid1: {
+				Social: []keybase1.TrackProof{
+					{ProofType: "twitter"}, {ProofType: "github"},
+				},
+				Web:        []keybase1.WebProof{{Hostname: "example.com"}},
+				PublicKeys: []keybase1.PublicKey{{}},
+			},
+		},
+	}
+	c := newKeybaseDaemonRPCWithClient(
+		nil, client, logger.NewTestLogger(t))
+	ctx := context.Background()
+
+	n, err := c.CountProofs(ctx, uid1)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+
+	_, err = c.CountProofs(ctx, uid2)
+	require.Error(t, err)
+}
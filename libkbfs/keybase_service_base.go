@@ -5,7 +5,9 @@
 package libkbfs
 
 import (
+	"encoding/json"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,6 +19,7 @@ import (
 	"github.com/keybase/kbfs/kbfsmd"
 	"github.com/keybase/kbfs/tlf"
 	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
 	"golang.org/x/net/context"
 )
 
@@ -33,6 +36,7 @@ type KeybaseServiceBase struct {
 	kbfsClient      keybase1.KbfsInterface
 	kbfsMountClient keybase1.KbfsMountInterface
 	gitClient       keybase1.GitInterface
+	apiserverClient keybase1.ApiserverInterface
 	log             logger.Logger
 
 	config     Config
@@ -103,7 +107,8 @@ func (k *KeybaseServiceBase) FillClients(
 	favoriteClient keybase1.FavoriteInterface,
 	kbfsClient keybase1.KbfsInterface,
 	kbfsMountClient keybase1.KbfsMountInterface,
-	gitClient keybase1.GitInterface) {
+	gitClient keybase1.GitInterface,
+	apiserverClient keybase1.ApiserverInterface) {
 	k.identifyClient = identifyClient
 	k.userClient = userClient
 	k.teamsClient = teamsClient
@@ -113,6 +118,7 @@ func (k *KeybaseServiceBase) FillClients(
 	k.kbfsClient = kbfsClient
 	k.kbfsMountClient = kbfsMountClient
 	k.gitClient = gitClient
+	k.apiserverClient = apiserverClient
 }
 
 type addVerifyingKeyFunc func(kbfscrypto.VerifyingKey)
@@ -309,6 +315,21 @@ func (k *KeybaseServiceBase) setCachedTeamInfo(
 	}
 }
 
+// invalidateResolutions forgets any persisted resolutions of
+// assertions to the given user or team.
+func (k *KeybaseServiceBase) invalidateResolutions(
+	ctx context.Context, id keybase1.UserOrTeamID) {
+	cache := getResolverCache(k.config)
+	if cache == nil {
+		return
+	}
+	err := cache.invalidate(id)
+	if err != nil {
+		k.log.CDebugf(ctx, "Couldn't invalidate resolutions of %s: %+v",
+			id, err)
+	}
+}
+
 func (k *KeybaseServiceBase) clearCaches() {
 	k.setCachedCurrentSession(SessionInfo{})
 	func() {
@@ -360,6 +381,7 @@ func (k *KeybaseServiceBase) KeyfamilyChanged(ctx context.Context,
 	k.log.CDebugf(ctx, "Key family for user %s changed", uid)
 	k.setCachedUserInfo(uid, UserInfo{})
 	k.clearCachedUnverifiedKeys(uid)
+	k.invalidateResolutions(ctx, uid.AsUserOrTeam())
 
 	if k.getCachedCurrentSession().UID == uid {
 		mdServer := k.config.MDServer()
@@ -653,6 +675,26 @@ func (k *KeybaseServiceBase) checkForRevokedVerifyingKey(
 	return newUserInfo, exists, nil
 }
 
+// CountProofs implements the proofCounter interface for
+// KeybaseServiceBase.  It counts the social and web proofs that the
+// service lists for the user.  They aren't checked again here; the
+// identify that runs before any IdentifyPolicy is what catches broken
+// ones.
+func (k *KeybaseServiceBase) CountProofs(
+	ctx context.Context, uid keybase1.UID) (int, error) {
+	res, err := k.userClient.LoadUncheckedUserSummaries(ctx,
+		keybase1.LoadUncheckedUserSummariesArg{Uids: []keybase1.UID{uid}})
+	if err != nil {
+		return 0, err
+	}
+	for _, summary := range res {
+		if summary.Uid.Equal(uid) {
+			return len(summary.Proofs.Social) + len(summary.Proofs.Web), nil
+		}
+	}
+	return 0, errors.Errorf("No user summary for %s", uid)
+}
+
 // LoadUserPlusKeys implements the KeybaseService interface for
 // KeybaseServiceBase.
 func (k *KeybaseServiceBase) LoadUserPlusKeys(ctx context.Context,
@@ -740,6 +782,7 @@ var allowedLoadTeamRoles = map[keybase1.TeamRole]bool{
 	keybase1.TeamRole_NONE:   true,
 	keybase1.TeamRole_WRITER: true,
 	keybase1.TeamRole_READER: true,
+	keybase1.TeamRole_ADMIN:  true,
 }
 
 // LoadTeamPlusKeys implements the KeybaseService interface for
@@ -792,6 +835,11 @@ func (k *KeybaseServiceBase) LoadTeamPlusKeys(
 			}
 		}
 
+		if satisfiesDesires && desiredRole == keybase1.TeamRole_ADMIN {
+			// The admins are only loaded when they're asked for.
+			satisfiesDesires = cachedTeamInfo.Admins != nil
+		}
+
 		if satisfiesDesires {
 			return cachedTeamInfo, nil
 		}
@@ -860,6 +908,22 @@ func (k *KeybaseServiceBase) LoadTeamPlusKeys(
 		info.RootID = rootID
 	}
 
+	// Fill in `Admins`, only if needed.
+	if desiredRole == keybase1.TeamRole_ADMIN {
+		details, err := k.teamsClient.TeamGet(
+			ctx, keybase1.TeamGetArg{Name: res.Name})
+		if err != nil {
+			return TeamInfo{}, err
+		}
+		info.Admins = make(map[keybase1.UID]bool)
+		for _, m := range details.Members.Owners {
+			info.Admins[m.Uv.Uid] = true
+		}
+		for _, m := range details.Members.Admins {
+			info.Admins[m.Uv.Uid] = true
+		}
+	}
+
 	// Fill in `LastWriters`, only if needed.
 	if desiredUser.Uid.Exists() && desiredRole == keybase1.TeamRole_WRITER &&
 		!info.Writers[desiredUser.Uid] && !desiredKey.IsNil() {
@@ -1246,6 +1310,7 @@ func (k *KeybaseServiceBase) TeamChangedByID(ctx context.Context,
 	k.setCachedTeamInfo(arg.TeamID, TeamInfo{})
 
 	if arg.Changes.Renamed {
+		k.invalidateResolutions(ctx, arg.TeamID.AsUserOrTeam())
 		k.config.KBFSOps().TeamNameChanged(ctx, arg.TeamID)
 	}
 	return nil
@@ -1263,6 +1328,7 @@ func (k *KeybaseServiceBase) TeamChangedByName(ctx context.Context,
 // KeybaseServiceBase.
 func (k *KeybaseServiceBase) TeamDeleted(ctx context.Context,
 	teamID keybase1.TeamID) error {
+	k.invalidateResolutions(ctx, teamID.AsUserOrTeam())
 	return nil
 }
 
@@ -1440,3 +1506,81 @@ func (k *KeybaseServiceBase) PutGitMetadata(
 		Metadata: metadata,
 	})
 }
+
+// DeleteGitMetadata implements the KeybaseService interface for
+// KeybaseServiceBase.
+func (k *KeybaseServiceBase) DeleteGitMetadata(
+	ctx context.Context, folder keybase1.Folder,
+	repoName keybase1.GitRepoName) error {
+	return k.gitClient.DeleteGitMetadata(ctx, keybase1.DeleteGitMetadataArg{
+		Folder:   folder,
+		RepoName: repoName,
+	})
+}
+
+// pgpLookupResult is the part of the server's user lookup response
+// that lists a user's PGP public keys.
+type pgpLookupResult struct {
+	Them struct {
+		PublicKeys struct {
+			PGPPublicKeys []string `json:"pgp_public_keys"`
+		} `json:"public_keys"`
+	} `json:"them"`
+}
+
+// LoadPGPPublicKeys implements the KeybaseService interface for
+// KeybaseServiceBase.
+func (k *KeybaseServiceBase) LoadPGPPublicKeys(
+	ctx context.Context, uid keybase1.UID) ([]string, error) {
+	// The service checks the user's sigchain, so only the PGP keys it
+	// returns are proven.  The key material itself comes from the
+	// server, and is only trusted if it matches one of those keys.
+	res, err := k.userClient.LoadUserPlusKeysV2(
+		ctx, keybase1.LoadUserPlusKeysV2Arg{Uid: uid})
+	if err != nil {
+		return nil, err
+	}
+	proven := make(map[keybase1.PGPFingerprint]bool)
+	for _, key := range res.Current.PGPKeys {
+		if key.Base.Revocation != nil {
+			continue
+		}
+		proven[key.Fingerprint] = true
+	}
+	if len(proven) == 0 {
+		return nil, nil
+	}
+
+	apiRes, err := k.apiserverClient.Get(ctx, keybase1.GetArg{
+		Endpoint: "user/lookup",
+		Args: []keybase1.StringKVPair{
+			{Key: "uid", Value: uid.String()},
+			{Key: "fields", Value: "public_keys"},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var lookup pgpLookupResult
+	err = json.Unmarshal([]byte(apiRes.Body), &lookup)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, armored := range lookup.Them.PublicKeys.PGPPublicKeys {
+		entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armored))
+		if err != nil {
+			k.log.CDebugf(ctx, "Ignoring unparseable PGP key for %s: %+v",
+				uid, err)
+			continue
+		}
+		for _, e := range entities {
+			if proven[keybase1.PGPFingerprint(e.PrimaryKey.Fingerprint)] {
+				keys = append(keys, armored)
+				break
+			}
+		}
+	}
+	return keys, nil
+}
@@ -27,6 +27,7 @@ type KeybaseServiceMeasured struct {
 	resolveImplicitTeamByIDTimer     metrics.Timer
 	loadUserPlusKeysTimer            metrics.Timer
 	loadTeamPlusKeysTimer            metrics.Timer
+	loadPGPPublicKeysTimer           metrics.Timer
 	createTeamTLFTimer               metrics.Timer
 	getTeamSettingsTimer             metrics.Timer
 	getCurrentMerkleRootTimer        metrics.Timer
@@ -38,6 +39,7 @@ type KeybaseServiceMeasured struct {
 	notifyTimer                      metrics.Timer
 	notifyPathUpdatedTimer           metrics.Timer
 	putGitMetadataTimer              metrics.Timer
+	deleteGitMetadataTimer           metrics.Timer
 }
 
 var _ KeybaseService = KeybaseServiceMeasured{}
@@ -54,6 +56,8 @@ func NewKeybaseServiceMeasured(delegate KeybaseService, r metrics.Registry) Keyb
 		"KeybaseService.ResolveImplicitTeamByID", r)
 	loadUserPlusKeysTimer := metrics.GetOrRegisterTimer("KeybaseService.LoadUserPlusKeys", r)
 	loadTeamPlusKeysTimer := metrics.GetOrRegisterTimer("KeybaseService.LoadTeamPlusKeys", r)
+	loadPGPPublicKeysTimer := metrics.GetOrRegisterTimer(
+		"KeybaseService.LoadPGPPublicKeys", r)
 	createTeamTLFTimer := metrics.GetOrRegisterTimer("KeybaseService.CreateTeamTLF", r)
 	getTeamSettingsTimer := metrics.GetOrRegisterTimer("KeybaseService.GetTeamSettings", r)
 	getCurrentMerkleRootTimer := metrics.GetOrRegisterTimer("KeybaseService.GetCurrentMerkleRoot", r)
@@ -66,6 +70,8 @@ func NewKeybaseServiceMeasured(delegate KeybaseService, r metrics.Registry) Keyb
 	notifyPathUpdatedTimer := metrics.GetOrRegisterTimer("KeybaseService.NotifyPathUpdated", r)
 	putGitMetadataTimer := metrics.GetOrRegisterTimer(
 		"KeybaseService.PutGitMetadata", r)
+	deleteGitMetadataTimer := metrics.GetOrRegisterTimer(
+		"KeybaseService.DeleteGitMetadata", r)
 	return KeybaseServiceMeasured{
 		delegate:                         delegate,
 		resolveTimer:                     resolveTimer,
@@ -75,6 +81,7 @@ func NewKeybaseServiceMeasured(delegate KeybaseService, r metrics.Registry) Keyb
 		resolveImplicitTeamByIDTimer:     resolveImplicitTeamByIDTimer,
 		loadUserPlusKeysTimer:            loadUserPlusKeysTimer,
 		loadTeamPlusKeysTimer:            loadTeamPlusKeysTimer,
+		loadPGPPublicKeysTimer:           loadPGPPublicKeysTimer,
 		createTeamTLFTimer:               createTeamTLFTimer,
 		getTeamSettingsTimer:             getTeamSettingsTimer,
 		getCurrentMerkleRootTimer:        getCurrentMerkleRootTimer,
@@ -86,6 +93,7 @@ func NewKeybaseServiceMeasured(delegate KeybaseService, r metrics.Registry) Keyb
 		notifyTimer:                      notifyTimer,
 		notifyPathUpdatedTimer:           notifyPathUpdatedTimer,
 		putGitMetadataTimer:              putGitMetadataTimer,
+		deleteGitMetadataTimer:           deleteGitMetadataTimer,
 	}
 }
 
@@ -162,6 +170,16 @@ func (k KeybaseServiceMeasured) LoadTeamPlusKeys(ctx context.Context,
 	return teamInfo, err
 }
 
+// LoadPGPPublicKeys implements the KeybaseService interface for
+// KeybaseServiceMeasured.
+func (k KeybaseServiceMeasured) LoadPGPPublicKeys(
+	ctx context.Context, uid keybase1.UID) (keys []string, err error) {
+	k.loadPGPPublicKeysTimer.Time(func() {
+		keys, err = k.delegate.LoadPGPPublicKeys(ctx, uid)
+	})
+	return keys, err
+}
+
 // CreateTeamTLF implements the KeybaseService interface for
 // KeybaseServiceMeasured.
 func (k KeybaseServiceMeasured) CreateTeamTLF(
@@ -293,6 +311,17 @@ func (k KeybaseServiceMeasured) PutGitMetadata(
 	return err
 }
 
+// DeleteGitMetadata implements the KeybaseDaemon interface for
+// KeybaseServiceMeasured.
+func (k KeybaseServiceMeasured) DeleteGitMetadata(
+	ctx context.Context, folder keybase1.Folder,
+	repoName keybase1.GitRepoName) (err error) {
+	k.deleteGitMetadataTimer.Time(func() {
+		err = k.delegate.DeleteGitMetadata(ctx, folder, repoName)
+	})
+	return err
+}
+
 // Shutdown implements the KeybaseService interface for
 // KeybaseServiceMeasured.
 func (k KeybaseServiceMeasured) Shutdown() {
@@ -0,0 +1,154 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"time"
+
+	"github.com/keybase/kbfs/kbfsmd"
+	"golang.org/x/net/context"
+)
+
+// A legal hold puts a folder into an immutable retention mode, set
+// by the LegalHoldRetention setting of the folder's settings file.
+// Deletions and overwrites still create new revisions as usual, but
+// the data unreferenced by a revision isn't garbage-collected until
+// the revision is older than the retention period.  Since the hold
+// is part of the folder, every device of every writer knows about
+// it: their quota reclamation waits for it, and merged revisions
+// that reclaim held data anyway, e.g. from clients that predate
+// holds, are flagged as LegalHoldViolationErrors when they're
+// applied.
+
+// HeldRevision describes a revision whose unreferenced data is being
+// retained because of a legal hold.
+type HeldRevision struct {
+	Revision kbfsmd.Revision
+	Date     time.Time
+	// HeldUntil is zero if the data is held forever.
+	HeldUntil time.Time
+}
+
+// legalHoldEnd returns when the data unreferenced by a revision made
+// at `date` stops being held, given the hold's retention, or false
+// if it's held forever.
+func legalHoldEnd(date time.Time, retention time.Duration) (
+	time.Time, bool) {
+	if retention == HistoryRetentionForever {
+		return time.Time{}, false
+	}
+	return date.Add(retention), true
+}
+
+// getLegalHoldRetention returns the retention period of the folder's
+// legal hold, or 0 if there's none.
+func (fbo *folderBranchOps) getLegalHoldRetention(
+	ctx context.Context) (time.Duration, error) {
+	settings, err := fbo.getCachedTlfSettings(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return settings.GetLegalHoldRetention(), nil
+}
+
+// checkLegalHoldLocked flags a merged MD that reclaims data that's
+// still under the folder's legal hold, as of the time the MD was
+// made.  The MD is still applied, since every later revision builds
+// on it, but the violation is logged and reported.  It uses the
+// settings as of the last time they were loaded, since they can't
+// be read while the head lock is held.
+func (fbo *folderBranchOps) checkLegalHoldLocked(
+	ctx context.Context, lState *lockState, rmd ImmutableRootMetadata) {
+	fbo.headLock.AssertLocked(lState)
+	settings, ok := fbo.peekTlfSettings()
+	if !ok {
+		return
+	}
+	retention := settings.GetLegalHoldRetention()
+	if retention == 0 {
+		return
+	}
+
+	for _, op := range rmd.data.Changes.Ops {
+		gcOp, ok := op.(*GCOp)
+		if !ok {
+			continue
+		}
+		latest, err := getSingleMD(
+			ctx, fbo.config, fbo.id(), kbfsmd.NullBranchID, gcOp.LatestRev,
+			kbfsmd.Merged, nil)
+		if err != nil {
+			fbo.log.CDebugf(ctx, "Couldn't check revision %d against "+
+				"the legal hold: %+v", rmd.Revision(), err)
+			continue
+		}
+		heldUntil, expires := legalHoldEnd(latest.localTimestamp, retention)
+		if expires && !heldUntil.After(rmd.localTimestamp) {
+			continue
+		}
+		err = LegalHoldViolationError{
+			fbo.id(), rmd.Revision(), gcOp.LatestRev, heldUntil}
+		fbo.log.CWarningf(ctx, "%v", err)
+		h := rmd.GetTlfHandle()
+		fbo.config.Reporter().ReportErr(
+			ctx, h.GetCanonicalName(), h.Type(), ReadMode, err)
+	}
+}
+
+// GetHeldRevisions returns the merged revisions of the given folder
+// whose unreferenced data is currently retained by a legal hold,
+// newest first.
+func (fbo *folderBranchOps) GetHeldRevisions(
+	ctx context.Context, folderBranch FolderBranch) (
+	held []HeldRevision, err error) {
+	fbo.log.CDebugf(ctx, "GetHeldRevisions")
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "GetHeldRevisions done: %+v", err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return nil, WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+	retention, err := fbo.getLegalHoldRetention(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if retention == 0 {
+		return nil, nil
+	}
+
+	lState := makeFBOLockState()
+	now := fbo.config.Clock().Now()
+	currHead := fbo.getLatestMergedRevision(lState)
+	for currHead >= kbfsmd.RevisionInitial {
+		startRev := currHead - maxMDsAtATime + 1
+		if startRev < kbfsmd.RevisionInitial {
+			startRev = kbfsmd.RevisionInitial
+		}
+		rmds, err := getMDRange(ctx, fbo.config, fbo.id(),
+			kbfsmd.NullBranchID, startRev, currHead, kbfsmd.Merged, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(rmds) == 0 {
+			break
+		}
+		for i := len(rmds) - 1; i >= 0; i-- {
+			rmd := rmds[i]
+			heldUntil, expires := legalHoldEnd(rmd.localTimestamp, retention)
+			if expires && !heldUntil.After(now) {
+				// Everything older is no longer held.
+				return held, nil
+			}
+			held = append(held, HeldRevision{
+				Revision:  rmd.Revision(),
+				Date:      rmd.localTimestamp,
+				HeldUntil: heldUntil,
+			})
+		}
+		currHead = rmds[0].Revision() - 1
+	}
+	return held, nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestLegalHoldDelaysQuotaReclamation(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	clock, now := newTestClockAndTimeNow()
+	config.SetClock(clock)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps()
+	err := WriteTlfSettings(ctx, kbfsOps, rootNode, TlfSettings{
+		Version:            TlfSettingsVersion,
+		LegalHoldRetention: "1d",
+	})
+	require.NoError(t, err)
+
+	_, _, err = kbfsOps.CreateDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	err = kbfsOps.RemoveDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	err = kbfsOps.SyncFromServer(ctx, fb, nil)
+	require.NoError(t, err)
+
+	bserverLocal, ok := config.BlockServer().(blockServerLocal)
+	require.True(t, ok)
+	ops := kbfsOps.(*KBFSOpsStandard).getOpsByNode(ctx, rootNode)
+	reclaim := func() map[kbfsblock.ID]blockRefMap {
+		ops.fbm.forceQuotaReclamation()
+		err := ops.fbm.waitForQuotaReclamations(ctx)
+		require.NoError(t, err)
+		refs, err := bserverLocal.getAllRefsForTest(ctx, fb.Tlf)
+		require.NoError(t, err)
+		return refs
+	}
+
+	// Past the usual unref age, but still under the hold: nothing
+	// can be reclaimed.
+	unrefAge := config.Mode().QuotaReclamationMinUnrefAge()
+	clock.Set(now.Add(2 * unrefAge))
+	_, _, err = kbfsOps.CreateDir(ctx, rootNode, "b")
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	// Let background archiving settle before snapshotting the refs.
+	err = ops.fbm.waitForArchives(ctx)
+	require.NoError(t, err)
+	preQRBlocks, err := bserverLocal.getAllRefsForTest(ctx, fb.Tlf)
+	require.NoError(t, err)
+	require.Equal(t, preQRBlocks, reclaim())
+
+	held, err := kbfsOps.GetHeldRevisions(ctx, fb)
+	require.NoError(t, err)
+	head := ops.getCurrMDRevision(makeFBOLockState())
+	require.Len(t, held, int(head))
+	require.Equal(t, head, held[0].Revision)
+	require.Equal(t, held[0].Date.Add(24*time.Hour), held[0].HeldUntil)
+
+	// Once the hold's retention period has passed, QR proceeds.
+	clock.Set(now.Add(48 * time.Hour))
+	_, _, err = kbfsOps.CreateDir(ctx, rootNode, "c")
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	require.NotEqual(t, preQRBlocks, reclaim())
+
+	held, err = kbfsOps.GetHeldRevisions(ctx, fb)
+	require.NoError(t, err)
+	for _, h := range held {
+		require.True(t, h.HeldUntil.After(clock.Now()))
+	}
+}
+
+// fbmHelperWithoutLegalHolds acts like a client that predates legal
+// holds, which reclaims quota without regard to them.
+type fbmHelperWithoutLegalHolds struct {
+	fbmHelper
+}
+
+func (fhwlh fbmHelperWithoutLegalHolds) getLegalHoldRetention(
+	_ context.Context) (time.Duration, error) {
+	return 0, nil
+}
+
+func TestLegalHoldFlagsOtherDevicesReclamation(t *testing.T) {
+	config1, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config1, ctx, cancel)
+	clock, now := newTestClockAndTimeNow()
+	config1.SetClock(clock)
+	config2 := ConfigAsUser(config1, "alice")
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	config2.SetClock(clock)
+
+	rootNode1 := GetRootNodeOrBust(ctx, t, config1, "alice", tlf.Private)
+	fb := rootNode1.GetFolderBranch()
+	kbfsOps1 := config1.KBFSOps()
+	err := WriteTlfSettings(ctx, kbfsOps1, rootNode1, TlfSettings{
+		Version:            TlfSettingsVersion,
+		LegalHoldRetention: "1d",
+	})
+	require.NoError(t, err)
+
+	t.Log("The hold is part of the folder, so other devices see it")
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, "alice", tlf.Private)
+	kbfsOps2 := config2.KBFSOps()
+	ops2 := kbfsOps2.(*KBFSOpsStandard).getOpsByNode(ctx, rootNode2)
+	held, err := kbfsOps2.GetHeldRevisions(ctx, fb)
+	require.NoError(t, err)
+	require.NotEmpty(t, held)
+
+	t.Log("A device that ignores the hold reclaims the data anyway")
+	ops2.fbm.helper = fbmHelperWithoutLegalHolds{ops2}
+	_, _, err = kbfsOps2.CreateDir(ctx, rootNode2, "a")
+	require.NoError(t, err)
+	err = kbfsOps2.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	err = kbfsOps2.RemoveDir(ctx, rootNode2, "a")
+	require.NoError(t, err)
+	err = kbfsOps2.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	clock.Set(now.Add(2 * config2.Mode().QuotaReclamationMinUnrefAge()))
+	ops2.fbm.forceQuotaReclamation()
+	err = ops2.fbm.waitForQuotaReclamations(ctx)
+	require.NoError(t, err)
+	head2, err := config2.MDOps().GetForTLF(ctx, fb.Tlf, nil)
+	require.NoError(t, err)
+	var gcOps int
+	for _, op := range head2.data.Changes.Ops {
+		if _, ok := op.(*GCOp); ok {
+			gcOps++
+		}
+	}
+	require.Equal(t, 1, gcOps)
+
+	t.Log("The revision is applied, but flagged as a violation")
+	err = kbfsOps1.SyncFromServer(ctx, fb, nil)
+	require.NoError(t, err)
+	ops1 := kbfsOps1.(*KBFSOpsStandard).getOpsByNode(ctx, rootNode1)
+	require.Equal(t, head2.Revision(),
+		ops1.getCurrMDRevision(makeFBOLockState()))
+	var violations int
+	for _, e := range config1.Reporter().AllKnownErrors() {
+		if _, ok := errors.Cause(e.Error).(LegalHoldViolationError); ok {
+			violations++
+		}
+	}
+	require.Equal(t, 1, violations)
+}
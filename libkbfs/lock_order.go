@@ -0,0 +1,327 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/keybase/client/go/logger"
+)
+
+// The lock-order checker complements the leveled mutexes (see
+// leveled_mutex.go), which can only check the lock hierarchy within a
+// single execution flow that explicitly passes around a lockState.
+// It instead tracks which named locks each goroutine holds, across
+// subsystems (e.g., folderBranchOps, the journal, and the block
+// retriever), and builds a global graph of "acquired while holding"
+// edges between lock names.  If acquiring a lock would add an edge
+// that closes a cycle in that graph, two execution flows take the
+// same locks in opposite orders, and could deadlock.
+//
+// Checking is off by default.  Building with the `kbfs_lockorder`
+// tag makes every inversion panic, which is meant for tests (`make
+// test-lockorder`, which CI also runs), and EnableLockOrderLogging
+// makes inversions get logged instead, which is cheap enough to turn
+// on in production while chasing a hang.  Unlocks by a goroutine
+// that doesn't hold the lock are reported the same way, since the
+// checker can't keep track of locks that are handed off.
+//
+// Locks are tracked by name rather than by instance, so two
+// instances of the same kind of lock (e.g., the journal locks of two
+// different TLFs) are treated as the same lock.  Nested acquisitions
+// of locks with the same name are ignored.
+
+type lockOrderMode int32
+
+const (
+	lockOrderOff lockOrderMode = iota
+	lockOrderLog
+	lockOrderPanic
+)
+
+var currentLockOrderMode int32 // holds a lockOrderMode
+
+func getLockOrderMode() lockOrderMode {
+	return lockOrderMode(atomic.LoadInt32(&currentLockOrderMode))
+}
+
+func setLockOrderMode(mode lockOrderMode) {
+	atomic.StoreInt32(&currentLockOrderMode, int32(mode))
+}
+
+// EnableLockOrderLogging turns on lock-order checking for the whole
+// process, and logs (rather than panics on) any potential lock
+// inversions that are detected.  It has no effect in builds where
+// inversions already cause a panic.
+func EnableLockOrderLogging(log logger.Logger) {
+	globalLockOrderChecker.setLogger(log)
+	if getLockOrderMode() == lockOrderOff {
+		setLockOrderMode(lockOrderLog)
+	}
+}
+
+// lockOrderInversionError describes two locks that have been acquired
+// in opposite orders by different execution flows.
+type lockOrderInversionError struct {
+	acquiring string
+	held      string
+	// path is the existing chain of edges from `acquiring` to `held`.
+	path []string
+}
+
+func (e lockOrderInversionError) Error() string {
+	return fmt.Sprintf("lock order inversion: %s acquired while holding "+
+		"%s, but previously locks were acquired in the order %v",
+		e.acquiring, e.held, e.path)
+}
+
+type lockOrderChecker struct {
+	lock sync.Mutex
+	// held maps a goroutine ID to the names of the locks it holds,
+	// in acquisition order.
+	held map[int64][]string
+	// edges[a][b] is true if b has been acquired while holding a.
+	edges map[string]map[string]bool
+	// reported holds errors that have already been logged.
+	reported map[[2]string]bool
+	log      logger.Logger
+}
+
+var globalLockOrderChecker = newLockOrderChecker()
+
+func newLockOrderChecker() *lockOrderChecker {
+	return &lockOrderChecker{
+		held:     make(map[int64][]string),
+		edges:    make(map[string]map[string]bool),
+		reported: make(map[[2]string]bool),
+	}
+}
+
+func (c *lockOrderChecker) setLogger(log logger.Logger) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.log = log
+}
+
+// pathLocked returns a chain of edges from `from` to `to`, or nil if
+// there is none.  c.lock must be held by the caller.
+func (c *lockOrderChecker) pathLocked(from, to string) []string {
+	visited := make(map[string]bool)
+	var search func(curr string) []string
+	search = func(curr string) []string {
+		if curr == to {
+			return []string{curr}
+		}
+		visited[curr] = true
+		for next := range c.edges[curr] {
+			if visited[next] {
+				continue
+			}
+			if p := search(next); p != nil {
+				return append([]string{curr}, p...)
+			}
+		}
+		return nil
+	}
+	return search(from)
+}
+
+// willLock records that goroutine `gid` is about to acquire the lock
+// called `name`, and returns an error if doing so inverts the order
+// in which some other flow acquired the same locks.  The lock is
+// recorded as held either way.
+func (c *lockOrderChecker) willLock(gid int64, name string) (err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, h := range c.held[gid] {
+		if h == name {
+			continue
+		}
+		if err == nil {
+			if p := c.pathLocked(name, h); p != nil {
+				err = lockOrderInversionError{name, h, p}
+			}
+		}
+		if c.edges[h] == nil {
+			c.edges[h] = make(map[string]bool)
+		}
+		c.edges[h][name] = true
+	}
+	c.held[gid] = append(c.held[gid], name)
+	return err
+}
+
+func removeLastLockName(names []string, name string) ([]string, bool) {
+	for i := len(names) - 1; i >= 0; i-- {
+		if names[i] == name {
+			return append(names[:i], names[i+1:]...), true
+		}
+	}
+	return names, false
+}
+
+// lockOrderUnlockError describes a lock that was released by a
+// goroutine that the checker doesn't think holds it.
+type lockOrderUnlockError struct {
+	name string
+	gid  int64
+}
+
+func (e lockOrderUnlockError) Error() string {
+	return fmt.Sprintf("lock %s released by goroutine %d, which doesn't "+
+		"hold it", e.name, e.gid)
+}
+
+// didUnlock records that goroutine `gid` released the lock called
+// `name`.  It returns an error if `gid` doesn't hold it, e.g. because
+// a lock was handed off to another goroutine to release.  The
+// checker can't tell which holder that release was meant for, so it
+// leaves the held locks alone, and any edges added for them from
+// then on are suspect.
+func (c *lockOrderChecker) didUnlock(gid int64, name string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	names, ok := removeLastLockName(c.held[gid], name)
+	if !ok {
+		return lockOrderUnlockError{name, gid}
+	}
+	if len(names) == 0 {
+		delete(c.held, gid)
+	} else {
+		c.held[gid] = names
+	}
+	return nil
+}
+
+// report handles an error found by the checker according to the
+// given mode.  In logging mode, each error is only logged once per
+// `key`.
+func (c *lockOrderChecker) report(
+	mode lockOrderMode, err error, key [2]string) {
+	if mode == lockOrderPanic {
+		panic(err)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.reported[key] || c.log == nil {
+		return
+	}
+	c.reported[key] = true
+	c.log.Warning("%v", err)
+}
+
+// currentGoroutineID parses the ID of the calling goroutine out of
+// its stack trace.  It's slow, and only used when checking is on.
+func currentGoroutineID() int64 {
+	var buf [64]byte
+	n := runtime.Stack(buf[:], false)
+	// The trace starts with "goroutine <id> [".
+	fields := bytes.Fields(buf[:n])
+	if len(fields) < 2 {
+		return 0
+	}
+	id, err := strconv.ParseInt(string(fields[1]), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
+func lockOrderWillLock(name string) {
+	mode := getLockOrderMode()
+	if mode == lockOrderOff || name == "" {
+		return
+	}
+	err := globalLockOrderChecker.willLock(currentGoroutineID(), name)
+	if err != nil {
+		inversion := err.(lockOrderInversionError)
+		globalLockOrderChecker.report(
+			mode, err, [2]string{inversion.acquiring, inversion.held})
+	}
+}
+
+func lockOrderDidUnlock(name string) {
+	mode := getLockOrderMode()
+	if mode == lockOrderOff || name == "" {
+		return
+	}
+	err := globalLockOrderChecker.didUnlock(currentGoroutineID(), name)
+	if err != nil {
+		globalLockOrderChecker.report(mode, err, [2]string{name, ""})
+	}
+}
+
+// orderCheckedMutex is a sync.Mutex whose acquisitions are tracked by
+// the lock-order checker under the given name.  An empty name
+// disables tracking.
+type orderCheckedMutex struct {
+	sync.Mutex
+	name string
+}
+
+var _ sync.Locker = (*orderCheckedMutex)(nil)
+
+func (m *orderCheckedMutex) Lock() {
+	lockOrderWillLock(m.name)
+	m.Mutex.Lock()
+}
+
+func (m *orderCheckedMutex) Unlock() {
+	m.Mutex.Unlock()
+	lockOrderDidUnlock(m.name)
+}
+
+// orderCheckedRWMutex is a sync.RWMutex whose acquisitions are
+// tracked by the lock-order checker under the given name.  Read and
+// write acquisitions are treated the same way.  An empty name
+// disables tracking.
+type orderCheckedRWMutex struct {
+	sync.RWMutex
+	name string
+}
+
+var _ rwLocker = (*orderCheckedRWMutex)(nil)
+
+func (m *orderCheckedRWMutex) Lock() {
+	lockOrderWillLock(m.name)
+	m.RWMutex.Lock()
+}
+
+func (m *orderCheckedRWMutex) Unlock() {
+	m.RWMutex.Unlock()
+	lockOrderDidUnlock(m.name)
+}
+
+func (m *orderCheckedRWMutex) RLock() {
+	lockOrderWillLock(m.name)
+	m.RWMutex.RLock()
+}
+
+func (m *orderCheckedRWMutex) RUnlock() {
+	m.RWMutex.RUnlock()
+	lockOrderDidUnlock(m.name)
+}
+
+type orderCheckedRLocker orderCheckedRWMutex
+
+func (r *orderCheckedRLocker) Lock() {
+	(*orderCheckedRWMutex)(r).RLock()
+}
+
+func (r *orderCheckedRLocker) Unlock() {
+	(*orderCheckedRWMutex)(r).RUnlock()
+}
+
+// RLocker returns a sync.Locker that read-locks m, just like
+// sync.RWMutex.RLocker, but with tracking.
+func (m *orderCheckedRWMutex) RLocker() sync.Locker {
+	return (*orderCheckedRLocker)(m)
+}
@@ -0,0 +1,12 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build kbfs_lockorder
+
+package libkbfs
+
+// In lock-order debug builds, every potential lock inversion panics.
+func init() {
+	setLockOrderMode(lockOrderPanic)
+}
@@ -0,0 +1,98 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLockOrderCheckerDetectsInversion(t *testing.T) {
+	c := newLockOrderChecker()
+
+	// Goroutine 1 takes a, then b, then c.
+	require.NoError(t, c.willLock(1, "a"))
+	require.NoError(t, c.willLock(1, "b"))
+	require.NoError(t, c.willLock(1, "c"))
+	require.NoError(t, c.didUnlock(1, "c"))
+	require.NoError(t, c.didUnlock(1, "b"))
+	require.NoError(t, c.didUnlock(1, "a"))
+	require.Len(t, c.held, 0)
+
+	// Goroutine 2 re-takes the same locks in the same order, and
+	// nests a lock of the same name.
+	require.NoError(t, c.willLock(2, "b"))
+	require.NoError(t, c.willLock(2, "b"))
+	require.NoError(t, c.willLock(2, "c"))
+	require.NoError(t, c.didUnlock(2, "c"))
+	require.NoError(t, c.didUnlock(2, "b"))
+	require.NoError(t, c.didUnlock(2, "b"))
+
+	// Goroutine 3 takes c then b, which inverts the b->c order.
+	require.NoError(t, c.willLock(3, "c"))
+	err := c.willLock(3, "b")
+	require.IsType(t, lockOrderInversionError{}, err)
+	inversion := err.(lockOrderInversionError)
+	require.Equal(t, "b", inversion.acquiring)
+	require.Equal(t, "c", inversion.held)
+	require.Equal(t, []string{"b", "c"}, inversion.path)
+}
+
+func TestLockOrderCheckerUnlockFromOtherGoroutine(t *testing.T) {
+	c := newLockOrderChecker()
+	require.NoError(t, c.willLock(1, "a"))
+
+	// 2 doesn't hold a, so the checker can't know whose a this is.
+	err := c.didUnlock(2, "a")
+	require.Equal(t, lockOrderUnlockError{"a", 2}, err)
+	require.Equal(t, map[int64][]string{1: {"a"}}, c.held)
+
+	require.NoError(t, c.didUnlock(1, "a"))
+	require.Len(t, c.held, 0)
+}
+
+func TestOrderCheckedRWMutexPanicsOnInversion(t *testing.T) {
+	oldMode := getLockOrderMode()
+	oldChecker := globalLockOrderChecker
+	setLockOrderMode(lockOrderPanic)
+	globalLockOrderChecker = newLockOrderChecker()
+	defer func() {
+		setLockOrderMode(oldMode)
+		globalLockOrderChecker = oldChecker
+	}()
+
+	m1 := &orderCheckedRWMutex{name: "m1"}
+	m2 := &orderCheckedMutex{name: "m2"}
+
+	m1.RLock()
+	m2.Lock()
+	m2.Unlock()
+	m1.RUnlock()
+
+	m2.Lock()
+	defer m2.Unlock()
+	require.Panics(t, func() { m1.RLocker().Lock() })
+}
+
+func TestOrderCheckedMutexPanicsOnUnlockByOtherGoroutine(t *testing.T) {
+	oldMode := getLockOrderMode()
+	oldChecker := globalLockOrderChecker
+	setLockOrderMode(lockOrderPanic)
+	globalLockOrderChecker = newLockOrderChecker()
+	defer func() {
+		setLockOrderMode(oldMode)
+		globalLockOrderChecker = oldChecker
+	}()
+
+	m := &orderCheckedMutex{name: "m"}
+	m.Lock()
+	panicCh := make(chan interface{}, 1)
+	go func() {
+		defer func() { panicCh <- recover() }()
+		m.Unlock()
+	}()
+	require.IsType(t, lockOrderUnlockError{}, <-panicCh)
+}
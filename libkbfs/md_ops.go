@@ -6,6 +6,7 @@ package libkbfs
 
 import (
 	"fmt"
+	"runtime"
 	"sync"
 	"time"
 
@@ -18,6 +19,7 @@ import (
 	"github.com/keybase/kbfs/kbfsmd"
 	"github.com/keybase/kbfs/tlf"
 	"github.com/pkg/errors"
+	metrics "github.com/rcrowley/go-metrics"
 	"golang.org/x/net/context"
 	"golang.org/x/sync/errgroup"
 )
@@ -35,6 +37,10 @@ const (
 	// started rejecting new writes based on the lack of recent merkle
 	// updates (according to `maxAllowedMerkleGap` above).
 	merkleGapEnforcementStartString = "2018-06-14T16:21:30-07:00"
+
+	// minMDsPerChainCheck is the smallest number of successor links
+	// worth checking in a goroutine of their own.
+	minMDsPerChainCheck = 1000
 )
 
 var merkleGapEnforcementStart time.Time
@@ -55,11 +61,26 @@ func init() {
 type MDOpsStandard struct {
 	config Config
 	log    logger.Logger
+
+	// gets shares the MD fetches of concurrent callers asking for
+	// the same TLF head or revision range.
+	gets *singleFlight
 }
 
 // NewMDOpsStandard returns a new MDOpsStandard
 func NewMDOpsStandard(config Config) *MDOpsStandard {
-	return &MDOpsStandard{config, config.MakeLogger("")}
+	var deduped metrics.Counter
+	if registry := config.MetricsRegistry(); registry != nil {
+		deduped = metrics.GetOrRegisterCounter(
+			"MDOps.DedupedGets", registry)
+	} else {
+		deduped = metrics.NewCounter()
+	}
+	return &MDOpsStandard{
+		config: config,
+		log:    config.MakeLogger(""),
+		gets:   newSingleFlight(deduped),
+	}
 }
 
 // convertVerifyingKeyError gives a better error when the TLF was
@@ -958,19 +979,38 @@ func (md *MDOpsStandard) processSignedMD(
 	return rmd, nil
 }
 
+// mdGetKey returns the key under which the gets of the MD of the TLF
+// `id` described by `format` and `args` are shared.
+func mdGetKey(id tlf.ID, format string, args ...interface{}) string {
+	return id.String() + " " + fmt.Sprintf(format, args...)
+}
+
 func (md *MDOpsStandard) getForTLF(ctx context.Context, id tlf.ID,
 	bid kbfsmd.BranchID, mStatus kbfsmd.MergeStatus, lockBeforeGet *keybase1.LockID) (
 	ImmutableRootMetadata, error) {
-	rmds, err := md.config.MDServer().GetForTLF(
-		ctx, id, bid, mStatus, lockBeforeGet)
+	get := func(ctx context.Context) (interface{}, error) {
+		rmds, err := md.config.MDServer().GetForTLF(
+			ctx, id, bid, mStatus, lockBeforeGet)
+		if err != nil {
+			return ImmutableRootMetadata{}, err
+		}
+		if rmds == nil {
+			// Possible if mStatus is kbfsmd.Unmerged
+			return ImmutableRootMetadata{}, nil
+		}
+		return md.processSignedMD(ctx, id, bid, rmds)
+	}
+	if lockBeforeGet != nil {
+		// Taking a lock is a side effect that can't be shared.
+		irmd, err := get(ctx)
+		return irmd.(ImmutableRootMetadata), err
+	}
+	irmd, err := md.gets.do(ctx,
+		mdGetKey(id, "head %s %s", bid, mStatus), get)
 	if err != nil {
 		return ImmutableRootMetadata{}, err
 	}
-	if rmds == nil {
-		// Possible if mStatus is kbfsmd.Unmerged
-		return ImmutableRootMetadata{}, nil
-	}
-	return md.processSignedMD(ctx, id, bid, rmds)
+	return irmd.(ImmutableRootMetadata), nil
 }
 
 // GetForTLF implements the MDOps interface for MDOpsStandard.
@@ -1019,12 +1059,16 @@ func (md *MDOpsStandard) processRange(ctx context.Context, id tlf.ID,
 	eg, groupCtx := errgroup.WithContext(ctx)
 
 	// Parallelize the MD decryption, because it could involve
-	// fetching blocks to get unembedded block changes.
-	rmdsChan := make(chan *RootMetadataSigned, len(rmdses))
-	irmdChan := make(chan ImmutableRootMetadata, len(rmdses))
+	// fetching blocks to get unembedded block changes, and the
+	// signature verification, which is CPU-bound and dominates long
+	// history scans.  Each worker stores its result in the slot of
+	// the MD it processed, so no channel is needed to collect them.
+	indexChan := make(chan int, len(rmdses))
+	processed := make([]ImmutableRootMetadata, len(rmdses))
 	var getRangeLock sync.Mutex
 	worker := func() error {
-		for rmds := range rmdsChan {
+		for i := range indexChan {
+			rmds := rmdses[i]
 			extra, err := md.getExtraMD(groupCtx, rmds.MD)
 			if err != nil {
 				return err
@@ -1044,15 +1088,21 @@ func (md *MDOpsStandard) processRange(ctx context.Context, id tlf.ID,
 			if err != nil {
 				return err
 			}
-			irmdChan <- irmd
+			processed[i] = irmd
 		}
 		return nil
 	}
 
-	numWorkers := len(rmdses)
-	if numWorkers > maxMDsAtATime {
+	// Use at least one worker per CPU for the signature checks, but
+	// never fewer than `maxMDsAtATime`, so that block fetches for
+	// unembedded changes can still overlap.
+	numWorkers := runtime.NumCPU()
+	if numWorkers < maxMDsAtATime {
 		numWorkers = maxMDsAtATime
 	}
+	if numWorkers > len(rmdses) {
+		numWorkers = len(rmdses)
+	}
 	for i := 0; i < numWorkers; i++ {
 		eg.Go(worker)
 	}
@@ -1060,23 +1110,21 @@ func (md *MDOpsStandard) processRange(ctx context.Context, id tlf.ID,
 	// Do this first, since processMetadataWithID consumes its
 	// rmds argument.
 	startRev := rmdses[0].MD.RevisionNumber()
-	rmdsCount := len(rmdses)
 
-	for _, rmds := range rmdses {
-		rmdsChan <- rmds
+	for i := range rmdses {
+		indexChan <- i
 	}
-	close(rmdsChan)
-	rmdses = nil
+	close(indexChan)
 	err := eg.Wait()
+	rmdses = nil
 	if err != nil {
 		return nil, err
 	}
-	close(irmdChan)
 
 	// Sort into slice based on revision.
-	irmds := make([]ImmutableRootMetadata, rmdsCount)
+	irmds := make([]ImmutableRootMetadata, len(processed))
 	numExpected := kbfsmd.Revision(len(irmds))
-	for irmd := range irmdChan {
+	for _, irmd := range processed {
 		i := irmd.Revision() - startRev
 		if i < 0 || i >= numExpected {
 			return nil, errors.Errorf("Unexpected revision %d; expected "+
@@ -1090,20 +1138,9 @@ func (md *MDOpsStandard) processRange(ctx context.Context, id tlf.ID,
 
 	// Now that we have all the immutable RootMetadatas, verify that
 	// the given MD objects form a valid sequence.
-	var prevIRMD ImmutableRootMetadata
-	for _, irmd := range irmds {
-		if prevIRMD != (ImmutableRootMetadata{}) {
-			err = prevIRMD.CheckValidSuccessor(
-				prevIRMD.mdID, irmd.ReadOnlyRootMetadata)
-			if err != nil {
-				return nil, MDMismatchError{
-					prevIRMD.Revision(),
-					irmd.GetTlfHandle().GetCanonicalPath(),
-					prevIRMD.TlfID(), err,
-				}
-			}
-		}
-		prevIRMD = irmd
+	err = checkMDChain(irmds)
+	if err != nil {
+		return nil, err
 	}
 
 	// TODO: in the case where lastRoot == MdID{}, should we verify
@@ -1115,19 +1152,93 @@ func (md *MDOpsStandard) processRange(ctx context.Context, id tlf.ID,
 	return irmds, nil
 }
 
+// checkMDChainLinks checks that each of `irmds[start:end]` is a valid
+// successor of the MD before it, returning the error for the lowest
+// failing revision.
+func checkMDChainLinks(irmds []ImmutableRootMetadata, start, end int) error {
+	if start == 0 {
+		start = 1
+	}
+	for i := start; i < end; i++ {
+		prevIRMD, irmd := irmds[i-1], irmds[i]
+		err := prevIRMD.CheckValidSuccessor(
+			prevIRMD.mdID, irmd.ReadOnlyRootMetadata)
+		if err != nil {
+			return MDMismatchError{
+				prevIRMD.Revision(),
+				irmd.GetTlfHandle().GetCanonicalPath(),
+				prevIRMD.TlfID(), err,
+			}
+		}
+	}
+	return nil
+}
+
+// checkMDChain checks that the given revision-ordered MDs form a
+// valid sequence.  Long chains are split across the available CPUs;
+// either way, the error for the lowest failing revision is returned.
+func checkMDChain(irmds []ImmutableRootMetadata) error {
+	numChunks := len(irmds) / minMDsPerChainCheck
+	if numChunks > runtime.NumCPU() {
+		numChunks = runtime.NumCPU()
+	}
+	if numChunks <= 1 {
+		return checkMDChainLinks(irmds, 0, len(irmds))
+	}
+
+	chunkSize := (len(irmds) + numChunks - 1) / numChunks
+	errs := make([]error, numChunks)
+	var wg sync.WaitGroup
+	for c := 0; c < numChunks; c++ {
+		start := c * chunkSize
+		end := start + chunkSize
+		if end > len(irmds) {
+			end = len(irmds)
+		}
+		wg.Add(1)
+		go func(c, start, end int) {
+			defer wg.Done()
+			errs[c] = checkMDChainLinks(irmds, start, end)
+		}(c, start, end)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (md *MDOpsStandard) getRange(ctx context.Context, id tlf.ID,
 	bid kbfsmd.BranchID, mStatus kbfsmd.MergeStatus, start, stop kbfsmd.Revision,
 	lockBeforeGet *keybase1.LockID) ([]ImmutableRootMetadata, error) {
-	rmds, err := md.config.MDServer().GetRange(
-		ctx, id, bid, mStatus, start, stop, lockBeforeGet)
-	if err != nil {
-		return nil, err
+	get := func(ctx context.Context) (interface{}, error) {
+		rmds, err := md.config.MDServer().GetRange(
+			ctx, id, bid, mStatus, start, stop, lockBeforeGet)
+		if err != nil {
+			return nil, err
+		}
+		return md.processRange(ctx, id, bid, rmds)
 	}
-	rmd, err := md.processRange(ctx, id, bid, rmds)
+	if lockBeforeGet != nil {
+		// Taking a lock is a side effect that can't be shared.
+		rmds, err := get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return rmds.([]ImmutableRootMetadata), nil
+	}
+	rmds, err := md.gets.do(ctx,
+		mdGetKey(id, "range %s %s %d-%d", bid, mStatus, start, stop),
+		get)
 	if err != nil {
 		return nil, err
 	}
-	return rmd, nil
+	// Callers may append to or reorder the result, so each gets its
+	// own slice.
+	return append([]ImmutableRootMetadata(nil),
+		rmds.([]ImmutableRootMetadata)...), nil
 }
 
 // GetRange implements the MDOps interface for MDOpsStandard.
@@ -1176,9 +1287,14 @@ func (md *MDOpsStandard) put(ctx context.Context, rmd *RootMetadata,
 	}
 
 	err = md.config.MDServer().Put(ctx, rmds, rmd.extra, lockContext, priority)
+	// Even a failed put may have made it to the server, so gets
+	// that were already in flight can't be shared with anyone
+	// reading after this.
+	md.gets.forgetPrefix(mdGetKey(rmd.TlfID(), ""))
 	if err != nil {
 		return ImmutableRootMetadata{}, err
 	}
+	recordMDUploaded(md.config, md.config.Codec(), rmds)
 
 	mdID, err := kbfsmd.MakeID(md.config.Codec(), rmds.MD)
 	if err != nil {
@@ -1227,6 +1343,7 @@ func (md *MDOpsStandard) PutUnmerged(
 // PruneBranch implements the MDOps interface for MDOpsStandard.
 func (md *MDOpsStandard) PruneBranch(
 	ctx context.Context, id tlf.ID, bid kbfsmd.BranchID) error {
+	defer md.gets.forgetPrefix(mdGetKey(id, ""))
 	return md.config.MDServer().PruneBranch(ctx, id, bid)
 }
 
@@ -1333,6 +1450,7 @@ func (md *MDOpsStandard) getExtraMD(ctx context.Context, brmd kbfsmd.RootMetadat
 			rkbID, tlf, err2)
 	}
 	if wkb != nil && rkb != nil {
+		md.maybePersistKeyBundles(ctx, tlf, wkbID, *wkb, rkbID, *rkb)
 		return kbfsmd.NewExtraMetadataV3(*wkb, *rkb, false, false), nil
 	}
 	if wkb != nil {
@@ -1351,5 +1469,24 @@ func (md *MDOpsStandard) getExtraMD(ctx context.Context, brmd kbfsmd.RootMetadat
 	// Cache the results.
 	kbcache.PutTLFWriterKeyBundle(wkbID, *wkb)
 	kbcache.PutTLFReaderKeyBundle(rkbID, *rkb)
+	md.maybePersistKeyBundles(ctx, tlf, wkbID, *wkb, rkbID, *rkb)
 	return kbfsmd.NewExtraMetadataV3(*wkb, *rkb, false, false), nil
 }
+
+// maybePersistKeyBundles stores the given key bundles on disk if the
+// TLF is synced, so that its MD can be read without fetching them
+// from the server again.
+func (md *MDOpsStandard) maybePersistKeyBundles(ctx context.Context,
+	tlfID tlf.ID, wkbID kbfsmd.TLFWriterKeyBundleID,
+	wkb kbfsmd.TLFWriterKeyBundleV3, rkbID kbfsmd.TLFReaderKeyBundleID,
+	rkb kbfsmd.TLFReaderKeyBundleV3) {
+	dkbc, ok := md.config.KeyBundleCache().(*diskKeyBundleCache)
+	if !ok || !md.config.IsSyncedTlf(tlfID) {
+		return
+	}
+	err := dkbc.persist(wkbID, wkb, rkbID, rkb)
+	if err != nil {
+		md.log.CDebugf(ctx, "Error persisting key bundles for TLF %s: %+v",
+			tlfID, err)
+	}
+}
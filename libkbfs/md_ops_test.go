@@ -235,7 +235,7 @@ func testMDOpsGetIDForHandlePublicSuccess(
 	require.Equal(t, id, id2)
 }
 
-func expectGetKeyBundles(ctx context.Context, config *ConfigMock, extra kbfsmd.ExtraMetadata) {
+func expectGetKeyBundles(ctx interface{}, config *ConfigMock, extra kbfsmd.ExtraMetadata) {
 	if extraV3, ok := extra.(*kbfsmd.ExtraMetadataV3); ok {
 		wkb := extraV3.GetWriterKeyBundle()
 		rkb := extraV3.GetReaderKeyBundle()
@@ -501,9 +501,9 @@ func testMDOpsGetSuccess(t *testing.T, ver kbfsmd.MetadataVer) {
 	// Do this before setting tlfHandle to nil.
 	verifyMDForPrivate(config, rmds)
 
-	config.mockMdserv.EXPECT().GetForTLF(ctx, rmds.MD.TlfID(), kbfsmd.NullBranchID,
+	config.mockMdserv.EXPECT().GetForTLF(gomock.Any(), rmds.MD.TlfID(), kbfsmd.NullBranchID,
 		kbfsmd.Merged, nil).Return(rmds, nil)
-	expectGetKeyBundles(ctx, config, extra)
+	expectGetKeyBundles(gomock.Any(), config, extra)
 
 	// Do this first, since rmds is consumed.
 	expectedMD := rmds.MD
@@ -522,9 +522,9 @@ func testMDOpsGetBlankSigFailure(t *testing.T, ver kbfsmd.MetadataVer) {
 	rmds.SigInfo = kbfscrypto.SignatureInfo{}
 
 	verifyMDForPrivate(config, rmds)
-	config.mockMdserv.EXPECT().GetForTLF(ctx, rmds.MD.TlfID(), kbfsmd.NullBranchID,
+	config.mockMdserv.EXPECT().GetForTLF(gomock.Any(), rmds.MD.TlfID(), kbfsmd.NullBranchID,
 		kbfsmd.Merged, nil).Return(rmds, nil)
-	expectGetKeyBundles(ctx, config, extra)
+	expectGetKeyBundles(gomock.Any(), config, extra)
 
 	if _, err := config.MDOps().GetForTLF(ctx, rmds.MD.TlfID(), nil); err == nil {
 		t.Error("Got no error on get")
@@ -539,7 +539,7 @@ func testMDOpsGetFailGet(t *testing.T, ver kbfsmd.MetadataVer) {
 	err := errors.New("Fake fail")
 
 	// only the get happens, no verify needed with a blank sig
-	config.mockMdserv.EXPECT().GetForTLF(ctx, id, kbfsmd.NullBranchID,
+	config.mockMdserv.EXPECT().GetForTLF(gomock.Any(), id, kbfsmd.NullBranchID,
 		kbfsmd.Merged, nil).Return(nil, err)
 
 	if _, err2 := config.MDOps().GetForTLF(ctx, id, nil); err2 != err {
@@ -557,9 +557,9 @@ func testMDOpsGetFailIDCheck(t *testing.T, ver kbfsmd.MetadataVer) {
 
 	id2 := tlf.FakeID(2, tlf.Public)
 
-	config.mockMdserv.EXPECT().GetForTLF(ctx, id2, kbfsmd.NullBranchID,
+	config.mockMdserv.EXPECT().GetForTLF(gomock.Any(), id2, kbfsmd.NullBranchID,
 		kbfsmd.Merged, nil).Return(rmds, nil)
-	expectGetKeyBundles(ctx, config, extra)
+	expectGetKeyBundles(gomock.Any(), config, extra)
 
 	if _, err := config.MDOps().GetForTLF(ctx, id2, nil); err == nil {
 		t.Errorf("Got no error on bad id check test")
@@ -1394,6 +1394,125 @@ func testMDOpsVerifyRemovedUserWrite(t *testing.T, ver kbfsmd.MetadataVer) {
 
 }
 
+func makeIRMDChainForTest(t *testing.T, count int, breaks ...int) (
+	irmds []ImmutableRootMetadata) {
+	config := MakeTestConfigOrBust(t, "alice")
+	defer CheckConfigAndShutdown(context.Background(), t, config)
+	id := tlf.FakeID(1, tlf.Public)
+	h := parseTlfHandleOrBust(t, config, "alice", tlf.Public, id)
+	key := kbfscrypto.MakeFakeVerifyingKeyOrBust("fake key")
+
+	broken := make(map[int]bool, len(breaks))
+	for _, i := range breaks {
+		broken[i] = true
+	}
+	prevID := kbfsmd.FakeID(1)
+	for i := 0; i < count; i++ {
+		rmd, err := makeInitialRootMetadata(config.MetadataVersion(), id, h)
+		require.NoError(t, err)
+		addFakeRMDData(t, config.Codec(), rmd, h)
+		rmd.SetRevision(kbfsmd.Revision(i + 1))
+		rmd.SetPrevRoot(prevID)
+		if broken[i] {
+			rmd.SetPrevRoot(kbfsmd.FakeID(2))
+		}
+		mdID, err := kbfsmd.MakeID(config.Codec(), rmd.bareMd)
+		require.NoError(t, err)
+		irmds = append(irmds,
+			MakeImmutableRootMetadata(rmd, key, mdID, time.Now(), true))
+		prevID = mdID
+	}
+	return irmds
+}
+
+func TestCheckMDChain(t *testing.T) {
+	count := 4*minMDsPerChainCheck + 1
+	irmds := makeIRMDChainForTest(t, count)
+	require.NoError(t, checkMDChain(irmds))
+
+	t.Log("The lowest broken link is reported, even across chunks")
+	irmds = makeIRMDChainForTest(
+		t, count, count-1, 3*minMDsPerChainCheck, minMDsPerChainCheck+1)
+	err := checkMDChain(irmds)
+	require.IsType(t, MDMismatchError{}, err)
+	require.Equal(t, kbfsmd.Revision(minMDsPerChainCheck+1),
+		err.(MDMismatchError).Revision)
+
+	t.Log("Short chains are checked the same way")
+	irmds = makeIRMDChainForTest(t, 5, 3)
+	err = checkMDChain(irmds)
+	require.IsType(t, MDMismatchError{}, err)
+	require.Equal(t, kbfsmd.Revision(3), err.(MDMismatchError).Revision)
+}
+
+func benchmarkProcessRange(b *testing.B, count int) {
+	config := MakeTestConfigOrBust(b, "alice")
+	defer CheckConfigAndShutdown(context.Background(), b, config)
+	ctx := context.Background()
+	id := tlf.FakeID(1, tlf.Public)
+	h := parseTlfHandleOrBust(b, config, "alice", tlf.Public, id)
+	codec := config.Codec()
+
+	// Sign the MDs once, and decode fresh copies for each run, since
+	// processing consumes them.
+	bufs := make([][]byte, count)
+	prevID := kbfsmd.FakeID(1)
+	for i := range bufs {
+		rmd, err := makeInitialRootMetadata(config.MetadataVersion(), id, h)
+		require.NoError(b, err)
+		rmd.SetRevision(kbfsmd.Revision(i + 100))
+		rmd.SetPrevRoot(prevID)
+		rmd.SetLastModifyingWriter(h.FirstResolvedWriter().AsUserOrBust())
+		rmd.SetLastModifyingUser(h.FirstResolvedWriter().AsUserOrBust())
+		buf, err := codec.Encode(PrivateMetadata{})
+		require.NoError(b, err)
+		rmd.SetSerializedPrivateMetadata(buf)
+		err = rmd.bareMd.SignWriterMetadataInternally(
+			ctx, codec, config.Crypto())
+		require.NoError(b, err)
+		rmds, err := SignBareRootMetadata(
+			ctx, codec, config.Crypto(), config.Crypto(), rmd.bareMd,
+			time.Now())
+		require.NoError(b, err)
+		prevID, err = kbfsmd.MakeID(codec, rmds.MD)
+		require.NoError(b, err)
+		bufs[i], err = kbfsmd.EncodeRootMetadataSigned(
+			codec, &rmds.RootMetadataSigned)
+		require.NoError(b, err)
+	}
+
+	mdOps := config.MDOps().(*MDOpsStandard)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		rmdses := make([]*RootMetadataSigned, count)
+		for j, buf := range bufs {
+			var err error
+			rmdses[j], err = DecodeRootMetadataSigned(
+				codec, id, config.MetadataVersion(),
+				config.MetadataVersion(), buf, time.Now())
+			require.NoError(b, err)
+		}
+		b.StartTimer()
+		irmds, err := mdOps.processRange(
+			ctx, id, kbfsmd.NullBranchID, rmdses)
+		require.NoError(b, err)
+		require.Len(b, irmds, count)
+	}
+}
+
+// BenchmarkProcessRange measures verifying a folder's history.  The
+// largest size takes a while to set up, so select sizes with e.g.
+// `-bench 'ProcessRange/count=1000$'`.
+func BenchmarkProcessRange(b *testing.B) {
+	for _, count := range []int{10, 1000, 10000, 100000} {
+		count := count // capture range variable.
+		b.Run(fmt.Sprintf("count=%d", count), func(b *testing.B) {
+			benchmarkProcessRange(b, count)
+		})
+	}
+}
+
 func TestMDOps(t *testing.T) {
 	tests := []func(*testing.T, kbfsmd.MetadataVer){
 		testMDOpsGetIDForHandlePublicSuccess,
@@ -45,6 +45,14 @@ func NewMDCacheStandard(capacity int) *MDCacheStandard {
 	return &MDCacheStandard{lru: mdLRU, idLRU: idLRU}
 }
 
+// purge drops all cached metadata objects.
+func (md *MDCacheStandard) purge() {
+	md.lock.Lock()
+	defer md.lock.Unlock()
+	md.lru.Purge()
+	md.idLRU.Purge()
+}
+
 // Get implements the MDCache interface for MDCacheStandard.
 func (md *MDCacheStandard) Get(tlf tlf.ID, rev kbfsmd.Revision, bid kbfsmd.BranchID) (
 	ImmutableRootMetadata, error) {
@@ -107,7 +107,8 @@ func NewMDServerRemote(config Config, srvRemote rpc.Remote,
 
 	mdServer.authToken = kbfscrypto.NewAuthToken(config.Crypto(),
 		kbfsmd.ServerTokenServer, kbfsmd.ServerTokenExpireIn,
-		"libkbfs_mdserver_remote", VersionString(), mdServer)
+		authTokenSubmoduleName("libkbfs_mdserver_remote", config.ClientID()),
+		VersionString(), mdServer)
 	constBackoff := backoff.NewConstantBackOff(RPCReconnectInterval)
 	mdServer.connOpts = rpc.ConnectionOpts{
 		WrapErrorFunc:                 libkb.WrapError,
@@ -153,7 +154,15 @@ func (md *MDServerRemote) initNewConnection() {
 		kbfsmd.ServerErrorUnwrapper{}, md, md.rpcLogFactory,
 		logger.LogOutputWithDepthAdder{Logger: md.config.MakeLogger("")},
 		rpc.DefaultMaxFrameLength, md.connOpts)
-	md.client = keybase1.MetadataClient{Cli: md.conn.GetClient()}
+	md.client = keybase1.MetadataClient{Cli: md.wrapClient(md.conn.GetClient())}
+}
+
+// wrapClient tags requests made through client with the client ID,
+// if there is one.
+func (md *MDServerRemote) wrapClient(
+	client rpc.GenericClient) rpc.GenericClient {
+	return wrapClientWithID(client, md.config.ClientID(), "MDServer",
+		md.config.MetricsRegistry())
 }
 
 const reconnectTimeout = 30 * time.Second
@@ -208,7 +217,7 @@ func (md *MDServerRemote) OnConnect(ctx context.Context,
 	}
 
 	// reset auth -- using md.client here would cause problematic recursion.
-	c := keybase1.MetadataClient{Cli: client}
+	c := keybase1.MetadataClient{Cli: md.wrapClient(client)}
 	pingIntervalSeconds, err := md.resetAuth(ctx, c)
 	switch err.(type) {
 	case nil:
@@ -893,7 +902,7 @@ func (md *MDServerRemote) RegisterForUpdate(ctx context.Context, id tlf.ID,
 		}
 		// Use this instead of md.client since we're already
 		// inside a DoCommand().
-		c := keybase1.MetadataClient{Cli: rawClient}
+		c := keybase1.MetadataClient{Cli: md.wrapClient(rawClient)}
 		err = c.RegisterForUpdates(ctx, arg)
 		if err != nil {
 			func() {
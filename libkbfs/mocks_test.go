@@ -1439,6 +1439,19 @@ func (mr *MockKBFSOpsMockRecorder) GetDirChildren(ctx, dir interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDirChildren", reflect.TypeOf((*MockKBFSOps)(nil).GetDirChildren), ctx, dir)
 }
 
+// GetDirChildrenPage mocks base method
+func (m *MockKBFSOps) GetDirChildrenPage(ctx context.Context, dir Node, after string, max int) ([]DirChild, error) {
+	ret := m.ctrl.Call(m, "GetDirChildrenPage", ctx, dir, after, max)
+	ret0, _ := ret[0].([]DirChild)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDirChildrenPage indicates an expected call of GetDirChildrenPage
+func (mr *MockKBFSOpsMockRecorder) GetDirChildrenPage(ctx, dir, after, max interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDirChildrenPage", reflect.TypeOf((*MockKBFSOps)(nil).GetDirChildrenPage), ctx, dir, after, max)
+}
+
 // Lookup mocks base method
 func (m *MockKBFSOps) Lookup(ctx context.Context, dir Node, name string) (Node, EntryInfo, error) {
 	ret := m.ctrl.Call(m, "Lookup", ctx, dir, name)
@@ -1453,6 +1466,21 @@ func (mr *MockKBFSOpsMockRecorder) Lookup(ctx, dir, name interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Lookup", reflect.TypeOf((*MockKBFSOps)(nil).Lookup), ctx, dir, name)
 }
 
+// LookupAndReadSmall mocks base method
+func (m *MockKBFSOps) LookupAndReadSmall(ctx context.Context, dir Node, name string, maxSize int64) (Node, EntryInfo, []byte, error) {
+	ret := m.ctrl.Call(m, "LookupAndReadSmall", ctx, dir, name, maxSize)
+	ret0, _ := ret[0].(Node)
+	ret1, _ := ret[1].(EntryInfo)
+	ret2, _ := ret[2].([]byte)
+	ret3, _ := ret[3].(error)
+	return ret0, ret1, ret2, ret3
+}
+
+// LookupAndReadSmall indicates an expected call of LookupAndReadSmall
+func (mr *MockKBFSOpsMockRecorder) LookupAndReadSmall(ctx, dir, name, maxSize interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LookupAndReadSmall", reflect.TypeOf((*MockKBFSOps)(nil).LookupAndReadSmall), ctx, dir, name, maxSize)
+}
+
 // Stat mocks base method
 func (m *MockKBFSOps) Stat(ctx context.Context, node Node) (EntryInfo, error) {
 	ret := m.ctrl.Call(m, "Stat", ctx, node)
@@ -1531,6 +1559,18 @@ func (mr *MockKBFSOpsMockRecorder) RemoveEntry(ctx, dir, name interface{}) *gomo
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveEntry", reflect.TypeOf((*MockKBFSOps)(nil).RemoveEntry), ctx, dir, name)
 }
 
+// RemoveTree mocks base method
+func (m *MockKBFSOps) RemoveTree(ctx context.Context, dir Node, name string, progress func(RemoveTreeProgress)) error {
+	ret := m.ctrl.Call(m, "RemoveTree", ctx, dir, name, progress)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveTree indicates an expected call of RemoveTree
+func (mr *MockKBFSOpsMockRecorder) RemoveTree(ctx, dir, name, progress interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveTree", reflect.TypeOf((*MockKBFSOps)(nil).RemoveTree), ctx, dir, name, progress)
+}
+
 // Rename mocks base method
 func (m *MockKBFSOps) Rename(ctx context.Context, oldParent Node, oldName string, newParent Node, newName string) error {
 	ret := m.ctrl.Call(m, "Rename", ctx, oldParent, oldName, newParent, newName)
@@ -1691,6 +1731,178 @@ func (mr *MockKBFSOpsMockRecorder) GetUpdateHistory(ctx, folderBranch interface{
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUpdateHistory", reflect.TypeOf((*MockKBFSOps)(nil).GetUpdateHistory), ctx, folderBranch)
 }
 
+// GetHeldRevisions mocks base method
+func (m *MockKBFSOps) GetHeldRevisions(ctx context.Context, folderBranch FolderBranch) ([]HeldRevision, error) {
+	ret := m.ctrl.Call(m, "GetHeldRevisions", ctx, folderBranch)
+	ret0, _ := ret[0].([]HeldRevision)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetHeldRevisions indicates an expected call of GetHeldRevisions
+func (mr *MockKBFSOpsMockRecorder) GetHeldRevisions(ctx, folderBranch interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHeldRevisions", reflect.TypeOf((*MockKBFSOps)(nil).GetHeldRevisions), ctx, folderBranch)
+}
+
+// VerifySyncCache mocks base method
+func (m *MockKBFSOps) VerifySyncCache(ctx context.Context, folderBranch FolderBranch, repair bool) (SyncCacheReport, error) {
+	ret := m.ctrl.Call(m, "VerifySyncCache", ctx, folderBranch, repair)
+	ret0, _ := ret[0].(SyncCacheReport)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// VerifySyncCache indicates an expected call of VerifySyncCache
+func (mr *MockKBFSOpsMockRecorder) VerifySyncCache(ctx, folderBranch, repair interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VerifySyncCache", reflect.TypeOf((*MockKBFSOps)(nil).VerifySyncCache), ctx, folderBranch, repair)
+}
+
+// FindLeakedBlockRefs mocks base method
+func (m *MockKBFSOps) FindLeakedBlockRefs(ctx context.Context, folderBranch FolderBranch, release bool) (BlockRefLeakReport, error) {
+	ret := m.ctrl.Call(m, "FindLeakedBlockRefs", ctx, folderBranch, release)
+	ret0, _ := ret[0].(BlockRefLeakReport)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FindLeakedBlockRefs indicates an expected call of FindLeakedBlockRefs
+func (mr *MockKBFSOpsMockRecorder) FindLeakedBlockRefs(ctx, folderBranch, release interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FindLeakedBlockRefs", reflect.TypeOf((*MockKBFSOps)(nil).FindLeakedBlockRefs), ctx, folderBranch, release)
+}
+
+// PinFileRange mocks base method
+func (m *MockKBFSOps) PinFileRange(ctx context.Context, file Node, off, length int64) error {
+	ret := m.ctrl.Call(m, "PinFileRange", ctx, file, off, length)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PinFileRange indicates an expected call of PinFileRange
+func (mr *MockKBFSOpsMockRecorder) PinFileRange(ctx, file, off, length interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PinFileRange", reflect.TypeOf((*MockKBFSOps)(nil).PinFileRange), ctx, file, off, length)
+}
+
+// GetRecentFiles mocks base method
+func (m *MockKBFSOps) GetRecentFiles(ctx context.Context, folderBranch FolderBranch, filter string, limit int) ([]RecentFile, error) {
+	ret := m.ctrl.Call(m, "GetRecentFiles", ctx, folderBranch, filter, limit)
+	ret0, _ := ret[0].([]RecentFile)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRecentFiles indicates an expected call of GetRecentFiles
+func (mr *MockKBFSOpsMockRecorder) GetRecentFiles(ctx, folderBranch, filter, limit interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRecentFiles", reflect.TypeOf((*MockKBFSOps)(nil).GetRecentFiles), ctx, folderBranch, filter, limit)
+}
+
+// PinNode mocks base method
+func (m *MockKBFSOps) PinNode(ctx context.Context, node Node) {
+	m.ctrl.Call(m, "PinNode", ctx, node)
+}
+
+// PinNode indicates an expected call of PinNode
+func (mr *MockKBFSOpsMockRecorder) PinNode(ctx, node interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PinNode", reflect.TypeOf((*MockKBFSOps)(nil).PinNode), ctx, node)
+}
+
+// UnpinNode mocks base method
+func (m *MockKBFSOps) UnpinNode(ctx context.Context, node Node) {
+	m.ctrl.Call(m, "UnpinNode", ctx, node)
+}
+
+// UnpinNode indicates an expected call of UnpinNode
+func (mr *MockKBFSOpsMockRecorder) UnpinNode(ctx, node interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnpinNode", reflect.TypeOf((*MockKBFSOps)(nil).UnpinNode), ctx, node)
+}
+
+// GetFolderStats mocks base method
+func (m *MockKBFSOps) GetFolderStats(ctx context.Context, folderBranch FolderBranch) (FolderStats, error) {
+	ret := m.ctrl.Call(m, "GetFolderStats", ctx, folderBranch)
+	ret0, _ := ret[0].(FolderStats)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetFolderStats indicates an expected call of GetFolderStats
+func (mr *MockKBFSOpsMockRecorder) GetFolderStats(ctx, folderBranch interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetFolderStats", reflect.TypeOf((*MockKBFSOps)(nil).GetFolderStats), ctx, folderBranch)
+}
+
+// GetIdentifyBreaks mocks base method
+func (m *MockKBFSOps) GetIdentifyBreaks(ctx context.Context, folderBranch FolderBranch) (IdentifyBreaksStatus, bool, error) {
+	ret := m.ctrl.Call(m, "GetIdentifyBreaks", ctx, folderBranch)
+	ret0, _ := ret[0].(IdentifyBreaksStatus)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetIdentifyBreaks indicates an expected call of GetIdentifyBreaks
+func (mr *MockKBFSOpsMockRecorder) GetIdentifyBreaks(ctx, folderBranch interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetIdentifyBreaks", reflect.TypeOf((*MockKBFSOps)(nil).GetIdentifyBreaks), ctx, folderBranch)
+}
+
+// RespondToIdentifyBreaks mocks base method
+func (m *MockKBFSOps) RespondToIdentifyBreaks(ctx context.Context, folderBranch FolderBranch, response IdentifyBreakResponse) error {
+	ret := m.ctrl.Call(m, "RespondToIdentifyBreaks", ctx, folderBranch, response)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RespondToIdentifyBreaks indicates an expected call of RespondToIdentifyBreaks
+func (mr *MockKBFSOpsMockRecorder) RespondToIdentifyBreaks(ctx, folderBranch, response interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RespondToIdentifyBreaks", reflect.TypeOf((*MockKBFSOps)(nil).RespondToIdentifyBreaks), ctx, folderBranch, response)
+}
+
+// FreezeWrites mocks base method
+func (m *MockKBFSOps) FreezeWrites(ctx context.Context, folderBranch FolderBranch, timeout time.Duration) error {
+	ret := m.ctrl.Call(m, "FreezeWrites", ctx, folderBranch, timeout)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// FreezeWrites indicates an expected call of FreezeWrites
+func (mr *MockKBFSOpsMockRecorder) FreezeWrites(ctx, folderBranch, timeout interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FreezeWrites", reflect.TypeOf((*MockKBFSOps)(nil).FreezeWrites), ctx, folderBranch, timeout)
+}
+
+// ThawWrites mocks base method
+func (m *MockKBFSOps) ThawWrites(ctx context.Context, folderBranch FolderBranch) error {
+	ret := m.ctrl.Call(m, "ThawWrites", ctx, folderBranch)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ThawWrites indicates an expected call of ThawWrites
+func (mr *MockKBFSOpsMockRecorder) ThawWrites(ctx, folderBranch interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ThawWrites", reflect.TypeOf((*MockKBFSOps)(nil).ThawWrites), ctx, folderBranch)
+}
+
+// GetQuotaReclamationStatus mocks base method
+func (m *MockKBFSOps) GetQuotaReclamationStatus(ctx context.Context, folderBranch FolderBranch) (QuotaReclamationStatus, error) {
+	ret := m.ctrl.Call(m, "GetQuotaReclamationStatus", ctx, folderBranch)
+	ret0, _ := ret[0].(QuotaReclamationStatus)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetQuotaReclamationStatus indicates an expected call of GetQuotaReclamationStatus
+func (mr *MockKBFSOpsMockRecorder) GetQuotaReclamationStatus(ctx, folderBranch interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetQuotaReclamationStatus", reflect.TypeOf((*MockKBFSOps)(nil).GetQuotaReclamationStatus), ctx, folderBranch)
+}
+
+// ForceQuotaReclamation mocks base method
+func (m *MockKBFSOps) ForceQuotaReclamation(ctx context.Context, folderBranch FolderBranch) error {
+	ret := m.ctrl.Call(m, "ForceQuotaReclamation", ctx, folderBranch)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ForceQuotaReclamation indicates an expected call of ForceQuotaReclamation
+func (mr *MockKBFSOpsMockRecorder) ForceQuotaReclamation(ctx, folderBranch interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForceQuotaReclamation", reflect.TypeOf((*MockKBFSOps)(nil).ForceQuotaReclamation), ctx, folderBranch)
+}
+
 // GetEditHistory mocks base method
 func (m *MockKBFSOps) GetEditHistory(ctx context.Context, folderBranch FolderBranch) (keybase1.FSFolderEditHistory, error) {
 	ret := m.ctrl.Call(m, "GetEditHistory", ctx, folderBranch)
@@ -1717,6 +1929,31 @@ func (mr *MockKBFSOpsMockRecorder) GetNodeMetadata(ctx, node interface{}) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNodeMetadata", reflect.TypeOf((*MockKBFSOps)(nil).GetNodeMetadata), ctx, node)
 }
 
+// GetCanonicalPath mocks base method
+func (m *MockKBFSOps) GetCanonicalPath(ctx context.Context, node Node) (string, error) {
+	ret := m.ctrl.Call(m, "GetCanonicalPath", ctx, node)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCanonicalPath indicates an expected call of GetCanonicalPath
+func (mr *MockKBFSOpsMockRecorder) GetCanonicalPath(ctx, node interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCanonicalPath", reflect.TypeOf((*MockKBFSOps)(nil).GetCanonicalPath), ctx, node)
+}
+
+// GetOpenFolders mocks base method
+func (m *MockKBFSOps) GetOpenFolders(ctx context.Context) map[Favorite]FolderBranch {
+	ret := m.ctrl.Call(m, "GetOpenFolders", ctx)
+	ret0, _ := ret[0].(map[Favorite]FolderBranch)
+	return ret0
+}
+
+// GetOpenFolders indicates an expected call of GetOpenFolders
+func (mr *MockKBFSOpsMockRecorder) GetOpenFolders(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOpenFolders", reflect.TypeOf((*MockKBFSOps)(nil).GetOpenFolders), ctx)
+}
+
 // Shutdown mocks base method
 func (m *MockKBFSOps) Shutdown(ctx context.Context) error {
 	ret := m.ctrl.Call(m, "Shutdown", ctx)
@@ -1769,6 +2006,31 @@ func (mr *MockKBFSOpsMockRecorder) ForceFastForward(ctx interface{}) *gomock.Cal
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForceFastForward", reflect.TypeOf((*MockKBFSOps)(nil).ForceFastForward), ctx)
 }
 
+// FolderUpdated mocks base method
+func (m *MockKBFSOps) FolderUpdated(ctx context.Context, tlfID tlf.ID, rev kbfsmd.Revision) error {
+	ret := m.ctrl.Call(m, "FolderUpdated", ctx, tlfID, rev)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// FolderUpdated indicates an expected call of FolderUpdated
+func (mr *MockKBFSOpsMockRecorder) FolderUpdated(ctx, tlfID, rev interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FolderUpdated", reflect.TypeOf((*MockKBFSOps)(nil).FolderUpdated), ctx, tlfID, rev)
+}
+
+// PurgeCachesPendingRekey mocks base method
+func (m *MockKBFSOps) PurgeCachesPendingRekey(ctx context.Context) ([]PurgedFolder, error) {
+	ret := m.ctrl.Call(m, "PurgeCachesPendingRekey", ctx)
+	ret0, _ := ret[0].([]PurgedFolder)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PurgeCachesPendingRekey indicates an expected call of PurgeCachesPendingRekey
+func (mr *MockKBFSOpsMockRecorder) PurgeCachesPendingRekey(ctx interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeCachesPendingRekey", reflect.TypeOf((*MockKBFSOps)(nil).PurgeCachesPendingRekey), ctx)
+}
+
 // InvalidateNodeAndChildren mocks base method
 func (m *MockKBFSOps) InvalidateNodeAndChildren(ctx context.Context, node Node) error {
 	ret := m.ctrl.Call(m, "InvalidateNodeAndChildren", ctx, node)
@@ -1919,6 +2181,18 @@ func (mr *MockgitMetadataPutterMockRecorder) PutGitMetadata(ctx, folder, repoID,
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PutGitMetadata", reflect.TypeOf((*MockgitMetadataPutter)(nil).PutGitMetadata), ctx, folder, repoID, metadata)
 }
 
+// DeleteGitMetadata mocks base method
+func (m *MockgitMetadataPutter) DeleteGitMetadata(ctx context.Context, folder keybase1.Folder, repoName keybase1.GitRepoName) error {
+	ret := m.ctrl.Call(m, "DeleteGitMetadata", ctx, folder, repoName)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteGitMetadata indicates an expected call of DeleteGitMetadata
+func (mr *MockgitMetadataPutterMockRecorder) DeleteGitMetadata(ctx, folder, repoName interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteGitMetadata", reflect.TypeOf((*MockgitMetadataPutter)(nil).DeleteGitMetadata), ctx, folder, repoName)
+}
+
 // MockKeybaseService is a mock of KeybaseService interface
 type MockKeybaseService struct {
 	ctrl     *gomock.Controller
@@ -1980,6 +2254,18 @@ func (mr *MockKeybaseServiceMockRecorder) PutGitMetadata(ctx, folder, repoID, me
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PutGitMetadata", reflect.TypeOf((*MockKeybaseService)(nil).PutGitMetadata), ctx, folder, repoID, metadata)
 }
 
+// DeleteGitMetadata mocks base method
+func (m *MockKeybaseService) DeleteGitMetadata(ctx context.Context, folder keybase1.Folder, repoName keybase1.GitRepoName) error {
+	ret := m.ctrl.Call(m, "DeleteGitMetadata", ctx, folder, repoName)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteGitMetadata indicates an expected call of DeleteGitMetadata
+func (mr *MockKeybaseServiceMockRecorder) DeleteGitMetadata(ctx, folder, repoName interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteGitMetadata", reflect.TypeOf((*MockKeybaseService)(nil).DeleteGitMetadata), ctx, folder, repoName)
+}
+
 // Resolve mocks base method
 func (m *MockKeybaseService) Resolve(ctx context.Context, assertion string) (kbun.NormalizedUsername, keybase1.UserOrTeamID, error) {
 	ret := m.ctrl.Call(m, "Resolve", ctx, assertion)
@@ -2098,6 +2384,19 @@ func (mr *MockKeybaseServiceMockRecorder) LoadTeamPlusKeys(ctx, tid, tlfType, de
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadTeamPlusKeys", reflect.TypeOf((*MockKeybaseService)(nil).LoadTeamPlusKeys), ctx, tid, tlfType, desiredKeyGen, desiredUser, desiredKey, desiredRole)
 }
 
+// LoadPGPPublicKeys mocks base method
+func (m *MockKeybaseService) LoadPGPPublicKeys(ctx context.Context, uid keybase1.UID) ([]string, error) {
+	ret := m.ctrl.Call(m, "LoadPGPPublicKeys", ctx, uid)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LoadPGPPublicKeys indicates an expected call of LoadPGPPublicKeys
+func (mr *MockKeybaseServiceMockRecorder) LoadPGPPublicKeys(ctx, uid interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadPGPPublicKeys", reflect.TypeOf((*MockKeybaseService)(nil).LoadPGPPublicKeys), ctx, uid)
+}
+
 // CurrentSession mocks base method
 func (m *MockKeybaseService) CurrentSession(ctx context.Context, sessionID int) (SessionInfo, error) {
 	ret := m.ctrl.Call(m, "CurrentSession", ctx, sessionID)
@@ -2871,6 +3170,18 @@ func (mr *MockKBPKIMockRecorder) PutGitMetadata(ctx, folder, repoID, metadata in
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PutGitMetadata", reflect.TypeOf((*MockKBPKI)(nil).PutGitMetadata), ctx, folder, repoID, metadata)
 }
 
+// DeleteGitMetadata mocks base method
+func (m *MockKBPKI) DeleteGitMetadata(ctx context.Context, folder keybase1.Folder, repoName keybase1.GitRepoName) error {
+	ret := m.ctrl.Call(m, "DeleteGitMetadata", ctx, folder, repoName)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteGitMetadata indicates an expected call of DeleteGitMetadata
+func (mr *MockKBPKIMockRecorder) DeleteGitMetadata(ctx, folder, repoName interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteGitMetadata", reflect.TypeOf((*MockKBPKI)(nil).DeleteGitMetadata), ctx, folder, repoName)
+}
+
 // HasVerifyingKey mocks base method
 func (m *MockKBPKI) HasVerifyingKey(ctx context.Context, uid keybase1.UID, verifyingKey kbfscrypto.VerifyingKey, atServerTime time.Time) error {
 	ret := m.ctrl.Call(m, "HasVerifyingKey", ctx, uid, verifyingKey, atServerTime)
@@ -2883,6 +3194,19 @@ func (mr *MockKBPKIMockRecorder) HasVerifyingKey(ctx, uid, verifyingKey, atServe
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HasVerifyingKey", reflect.TypeOf((*MockKBPKI)(nil).HasVerifyingKey), ctx, uid, verifyingKey, atServerTime)
 }
 
+// IsTeamAdmin mocks base method
+func (m *MockKBPKI) IsTeamAdmin(ctx context.Context, tid keybase1.TeamID, uid keybase1.UID) (bool, error) {
+	ret := m.ctrl.Call(m, "IsTeamAdmin", ctx, tid, uid)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IsTeamAdmin indicates an expected call of IsTeamAdmin
+func (mr *MockKBPKIMockRecorder) IsTeamAdmin(ctx, tid, uid interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsTeamAdmin", reflect.TypeOf((*MockKBPKI)(nil).IsTeamAdmin), ctx, tid, uid)
+}
+
 // GetCryptPublicKeys mocks base method
 func (m *MockKBPKI) GetCryptPublicKeys(ctx context.Context, uid keybase1.UID) ([]kbfscrypto.CryptPublicKey, error) {
 	ret := m.ctrl.Call(m, "GetCryptPublicKeys", ctx, uid)
@@ -6743,6 +7067,42 @@ func (mr *MockInitModeMockRecorder) BackgroundFlushesEnabled() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BackgroundFlushesEnabled", reflect.TypeOf((*MockInitMode)(nil).BackgroundFlushesEnabled))
 }
 
+// BackgroundFlushPeriod mocks base method
+func (m *MockInitMode) BackgroundFlushPeriod() time.Duration {
+	ret := m.ctrl.Call(m, "BackgroundFlushPeriod")
+	ret0, _ := ret[0].(time.Duration)
+	return ret0
+}
+
+// BackgroundFlushPeriod indicates an expected call of BackgroundFlushPeriod
+func (mr *MockInitModeMockRecorder) BackgroundFlushPeriod() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BackgroundFlushPeriod", reflect.TypeOf((*MockInitMode)(nil).BackgroundFlushPeriod))
+}
+
+// BackgroundFlushDirOpBatchSize mocks base method
+func (m *MockInitMode) BackgroundFlushDirOpBatchSize() int {
+	ret := m.ctrl.Call(m, "BackgroundFlushDirOpBatchSize")
+	ret0, _ := ret[0].(int)
+	return ret0
+}
+
+// BackgroundFlushDirOpBatchSize indicates an expected call of BackgroundFlushDirOpBatchSize
+func (mr *MockInitModeMockRecorder) BackgroundFlushDirOpBatchSize() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BackgroundFlushDirOpBatchSize", reflect.TypeOf((*MockInitMode)(nil).BackgroundFlushDirOpBatchSize))
+}
+
+// DirMtimeGranularity mocks base method
+func (m *MockInitMode) DirMtimeGranularity() time.Duration {
+	ret := m.ctrl.Call(m, "DirMtimeGranularity")
+	ret0, _ := ret[0].(time.Duration)
+	return ret0
+}
+
+// DirMtimeGranularity indicates an expected call of DirMtimeGranularity
+func (mr *MockInitModeMockRecorder) DirMtimeGranularity() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DirMtimeGranularity", reflect.TypeOf((*MockInitMode)(nil).DirMtimeGranularity))
+}
+
 // MetricsEnabled mocks base method
 func (m *MockInitMode) MetricsEnabled() bool {
 	ret := m.ctrl.Call(m, "MetricsEnabled")
@@ -7264,6 +7624,344 @@ func (mr *MockConfigMockRecorder) IsSyncedTlf(tlfID interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsSyncedTlf", reflect.TypeOf((*MockConfig)(nil).IsSyncedTlf), tlfID)
 }
 
+// IsWriteThroughTlf mocks base method
+func (m *MockConfig) IsWriteThroughTlf(tlfID tlf.ID) bool {
+	ret := m.ctrl.Call(m, "IsWriteThroughTlf", tlfID)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// IsWriteThroughTlf indicates an expected call of IsWriteThroughTlf
+func (mr *MockConfigMockRecorder) IsWriteThroughTlf(tlfID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IsWriteThroughTlf", reflect.TypeOf((*MockConfig)(nil).IsWriteThroughTlf), tlfID)
+}
+
+// SetTlfWriteThroughState mocks base method
+func (m *MockConfig) SetTlfWriteThroughState(tlfID tlf.ID, writeThrough bool) {
+	m.ctrl.Call(m, "SetTlfWriteThroughState", tlfID, writeThrough)
+}
+
+// SetTlfWriteThroughState indicates an expected call of SetTlfWriteThroughState
+func (mr *MockConfigMockRecorder) SetTlfWriteThroughState(tlfID, writeThrough interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetTlfWriteThroughState", reflect.TypeOf((*MockConfig)(nil).SetTlfWriteThroughState), tlfID, writeThrough)
+}
+
+// DataRegionsEnforced mocks base method
+func (m *MockConfig) DataRegionsEnforced() bool {
+	ret := m.ctrl.Call(m, "DataRegionsEnforced")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// DataRegionsEnforced indicates an expected call of DataRegionsEnforced
+func (mr *MockConfigMockRecorder) DataRegionsEnforced() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DataRegionsEnforced", reflect.TypeOf((*MockConfig)(nil).DataRegionsEnforced))
+}
+
+// TlfDataRegion mocks base method
+func (m *MockConfig) TlfDataRegion(tlfID tlf.ID) (string, bool) {
+	ret := m.ctrl.Call(m, "TlfDataRegion", tlfID)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(bool)
+	return ret0, ret1
+}
+
+// TlfDataRegion indicates an expected call of TlfDataRegion
+func (mr *MockConfigMockRecorder) TlfDataRegion(tlfID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TlfDataRegion", reflect.TypeOf((*MockConfig)(nil).TlfDataRegion), tlfID)
+}
+
+// WaitForTlfDataRegion mocks base method
+func (m *MockConfig) WaitForTlfDataRegion(ctx context.Context, tlfID tlf.ID) (string, error) {
+	ret := m.ctrl.Call(m, "WaitForTlfDataRegion", ctx, tlfID)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// WaitForTlfDataRegion indicates an expected call of WaitForTlfDataRegion
+func (mr *MockConfigMockRecorder) WaitForTlfDataRegion(ctx, tlfID interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WaitForTlfDataRegion", reflect.TypeOf((*MockConfig)(nil).WaitForTlfDataRegion), ctx, tlfID)
+}
+
+// SetTlfDataRegion mocks base method
+func (m *MockConfig) SetTlfDataRegion(tlfID tlf.ID, region string) error {
+	ret := m.ctrl.Call(m, "SetTlfDataRegion", tlfID, region)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetTlfDataRegion indicates an expected call of SetTlfDataRegion
+func (mr *MockConfigMockRecorder) SetTlfDataRegion(tlfID, region interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetTlfDataRegion", reflect.TypeOf((*MockConfig)(nil).SetTlfDataRegion), tlfID, region)
+}
+
+// DeviceConstraintsStatus mocks base method
+func (m *MockConfig) DeviceConstraintsStatus() DeviceConstraintsStatus {
+	ret := m.ctrl.Call(m, "DeviceConstraintsStatus")
+	ret0, _ := ret[0].(DeviceConstraintsStatus)
+	return ret0
+}
+
+// DeviceConstraintsStatus indicates an expected call of DeviceConstraintsStatus
+func (mr *MockConfigMockRecorder) DeviceConstraintsStatus() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeviceConstraintsStatus", reflect.TypeOf((*MockConfig)(nil).DeviceConstraintsStatus))
+}
+
+// ContentScanner mocks base method
+func (m *MockConfig) ContentScanner() ContentScanner {
+	ret := m.ctrl.Call(m, "ContentScanner")
+	ret0, _ := ret[0].(ContentScanner)
+	return ret0
+}
+
+// ContentScanner indicates an expected call of ContentScanner
+func (mr *MockConfigMockRecorder) ContentScanner() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ContentScanner", reflect.TypeOf((*MockConfig)(nil).ContentScanner))
+}
+
+// SetContentScanner mocks base method
+func (m *MockConfig) SetContentScanner(arg0 ContentScanner) {
+	m.ctrl.Call(m, "SetContentScanner", arg0)
+}
+
+// SetContentScanner indicates an expected call of SetContentScanner
+func (mr *MockConfigMockRecorder) SetContentScanner(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetContentScanner", reflect.TypeOf((*MockConfig)(nil).SetContentScanner), arg0)
+}
+
+// BlockTransform mocks base method
+func (m *MockConfig) BlockTransform() BlockTransform {
+	ret := m.ctrl.Call(m, "BlockTransform")
+	ret0, _ := ret[0].(BlockTransform)
+	return ret0
+}
+
+// BlockTransform indicates an expected call of BlockTransform
+func (mr *MockConfigMockRecorder) BlockTransform() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BlockTransform", reflect.TypeOf((*MockConfig)(nil).BlockTransform))
+}
+
+// SetBlockTransform mocks base method
+func (m *MockConfig) SetBlockTransform(arg0 BlockTransform) {
+	m.ctrl.Call(m, "SetBlockTransform", arg0)
+}
+
+// SetBlockTransform indicates an expected call of SetBlockTransform
+func (mr *MockConfigMockRecorder) SetBlockTransform(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetBlockTransform", reflect.TypeOf((*MockConfig)(nil).SetBlockTransform), arg0)
+}
+
+// IdentifyPolicy mocks base method
+func (m *MockConfig) IdentifyPolicy() IdentifyPolicy {
+	ret := m.ctrl.Call(m, "IdentifyPolicy")
+	ret0, _ := ret[0].(IdentifyPolicy)
+	return ret0
+}
+
+// IdentifyPolicy indicates an expected call of IdentifyPolicy
+func (mr *MockConfigMockRecorder) IdentifyPolicy() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IdentifyPolicy", reflect.TypeOf((*MockConfig)(nil).IdentifyPolicy))
+}
+
+// SetIdentifyPolicy mocks base method
+func (m *MockConfig) SetIdentifyPolicy(arg0 IdentifyPolicy) {
+	m.ctrl.Call(m, "SetIdentifyPolicy", arg0)
+}
+
+// SetIdentifyPolicy indicates an expected call of SetIdentifyPolicy
+func (mr *MockConfigMockRecorder) SetIdentifyPolicy(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetIdentifyPolicy", reflect.TypeOf((*MockConfig)(nil).SetIdentifyPolicy), arg0)
+}
+
+// StorageAccountant mocks base method
+func (m *MockConfig) StorageAccountant() StorageAccountant {
+	ret := m.ctrl.Call(m, "StorageAccountant")
+	ret0, _ := ret[0].(StorageAccountant)
+	return ret0
+}
+
+// StorageAccountant indicates an expected call of StorageAccountant
+func (mr *MockConfigMockRecorder) StorageAccountant() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StorageAccountant", reflect.TypeOf((*MockConfig)(nil).StorageAccountant))
+}
+
+// SetStorageAccountant mocks base method
+func (m *MockConfig) SetStorageAccountant(arg0 StorageAccountant) {
+	m.ctrl.Call(m, "SetStorageAccountant", arg0)
+}
+
+// SetStorageAccountant indicates an expected call of SetStorageAccountant
+func (mr *MockConfigMockRecorder) SetStorageAccountant(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetStorageAccountant", reflect.TypeOf((*MockConfig)(nil).SetStorageAccountant), arg0)
+}
+
+// MountManager mocks base method
+func (m *MockConfig) MountManager() MountManager {
+	ret := m.ctrl.Call(m, "MountManager")
+	ret0, _ := ret[0].(MountManager)
+	return ret0
+}
+
+// MountManager indicates an expected call of MountManager
+func (mr *MockConfigMockRecorder) MountManager() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MountManager", reflect.TypeOf((*MockConfig)(nil).MountManager))
+}
+
+// SetMountManager mocks base method
+func (m *MockConfig) SetMountManager(arg0 MountManager) {
+	m.ctrl.Call(m, "SetMountManager", arg0)
+}
+
+// SetMountManager indicates an expected call of SetMountManager
+func (mr *MockConfigMockRecorder) SetMountManager(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetMountManager", reflect.TypeOf((*MockConfig)(nil).SetMountManager), arg0)
+}
+
+// OpTimeouts mocks base method
+func (m *MockConfig) OpTimeouts() OpTimeouts {
+	ret := m.ctrl.Call(m, "OpTimeouts")
+	ret0, _ := ret[0].(OpTimeouts)
+	return ret0
+}
+
+// OpTimeouts indicates an expected call of OpTimeouts
+func (mr *MockConfigMockRecorder) OpTimeouts() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OpTimeouts", reflect.TypeOf((*MockConfig)(nil).OpTimeouts))
+}
+
+// PublicReadOnly mocks base method
+func (m *MockConfig) PublicReadOnly() bool {
+	ret := m.ctrl.Call(m, "PublicReadOnly")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// PublicReadOnly indicates an expected call of PublicReadOnly
+func (mr *MockConfigMockRecorder) PublicReadOnly() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PublicReadOnly", reflect.TypeOf((*MockConfig)(nil).PublicReadOnly))
+}
+
+// ClientID mocks base method
+func (m *MockConfig) ClientID() string {
+	ret := m.ctrl.Call(m, "ClientID")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// ClientID indicates an expected call of ClientID
+func (mr *MockConfigMockRecorder) ClientID() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClientID", reflect.TypeOf((*MockConfig)(nil).ClientID))
+}
+
+// Webhooks mocks base method
+func (m *MockConfig) Webhooks() Webhooks {
+	ret := m.ctrl.Call(m, "Webhooks")
+	ret0, _ := ret[0].(Webhooks)
+	return ret0
+}
+
+// Webhooks indicates an expected call of Webhooks
+func (mr *MockConfigMockRecorder) Webhooks() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Webhooks", reflect.TypeOf((*MockConfig)(nil).Webhooks))
+}
+
+// SetWebhooks mocks base method
+func (m *MockConfig) SetWebhooks(webhooks Webhooks) error {
+	ret := m.ctrl.Call(m, "SetWebhooks", webhooks)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetWebhooks indicates an expected call of SetWebhooks
+func (mr *MockConfigMockRecorder) SetWebhooks(webhooks interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetWebhooks", reflect.TypeOf((*MockConfig)(nil).SetWebhooks), webhooks)
+}
+
+// EventRules mocks base method
+func (m *MockConfig) EventRules() EventRules {
+	ret := m.ctrl.Call(m, "EventRules")
+	ret0, _ := ret[0].(EventRules)
+	return ret0
+}
+
+// EventRules indicates an expected call of EventRules
+func (mr *MockConfigMockRecorder) EventRules() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EventRules", reflect.TypeOf((*MockConfig)(nil).EventRules))
+}
+
+// EventRuleEngine mocks base method
+func (m *MockConfig) EventRuleEngine() *EventRuleEngine {
+	ret := m.ctrl.Call(m, "EventRuleEngine")
+	ret0, _ := ret[0].(*EventRuleEngine)
+	return ret0
+}
+
+// EventRuleEngine indicates an expected call of EventRuleEngine
+func (mr *MockConfigMockRecorder) EventRuleEngine() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EventRuleEngine", reflect.TypeOf((*MockConfig)(nil).EventRuleEngine))
+}
+
+// SetEventRules mocks base method
+func (m *MockConfig) SetEventRules(rules EventRules) error {
+	ret := m.ctrl.Call(m, "SetEventRules", rules)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetEventRules indicates an expected call of SetEventRules
+func (mr *MockConfigMockRecorder) SetEventRules(rules interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetEventRules", reflect.TypeOf((*MockConfig)(nil).SetEventRules), rules)
+}
+
+// TeamRenames mocks base method
+func (m *MockConfig) TeamRenames() TeamRenames {
+	ret := m.ctrl.Call(m, "TeamRenames")
+	ret0, _ := ret[0].(TeamRenames)
+	return ret0
+}
+
+// TeamRenames indicates an expected call of TeamRenames
+func (mr *MockConfigMockRecorder) TeamRenames() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TeamRenames", reflect.TypeOf((*MockConfig)(nil).TeamRenames))
+}
+
+// SetTeamRenames mocks base method
+func (m *MockConfig) SetTeamRenames(renames TeamRenames) error {
+	ret := m.ctrl.Call(m, "SetTeamRenames", renames)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetTeamRenames indicates an expected call of SetTeamRenames
+func (mr *MockConfigMockRecorder) SetTeamRenames(renames interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetTeamRenames", reflect.TypeOf((*MockConfig)(nil).SetTeamRenames), renames)
+}
+
+// SyncSchedules mocks base method
+func (m *MockConfig) SyncSchedules() SyncSchedules {
+	ret := m.ctrl.Call(m, "SyncSchedules")
+	ret0, _ := ret[0].(SyncSchedules)
+	return ret0
+}
+
+// SyncSchedules indicates an expected call of SyncSchedules
+func (mr *MockConfigMockRecorder) SyncSchedules() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SyncSchedules", reflect.TypeOf((*MockConfig)(nil).SyncSchedules))
+}
+
+// SetSyncSchedules mocks base method
+func (m *MockConfig) SetSyncSchedules(ctx context.Context, schedules SyncSchedules) error {
+	ret := m.ctrl.Call(m, "SetSyncSchedules", ctx, schedules)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetSyncSchedules indicates an expected call of SetSyncSchedules
+func (mr *MockConfigMockRecorder) SetSyncSchedules(ctx, schedules interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetSyncSchedules", reflect.TypeOf((*MockConfig)(nil).SetSyncSchedules), ctx, schedules)
+}
+
 // SetTlfSyncState mocks base method
 func (m *MockConfig) SetTlfSyncState(tlfID tlf.ID, isSynced bool) error {
 	ret := m.ctrl.Call(m, "SetTlfSyncState", tlfID, isSynced)
@@ -7900,6 +8598,28 @@ func (mr *MockConfigMockRecorder) SetDoBackgroundFlushes(arg0 interface{}) *gomo
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetDoBackgroundFlushes", reflect.TypeOf((*MockConfig)(nil).SetDoBackgroundFlushes), arg0)
 }
 
+// SiblingPrefetchEnabled mocks base method
+func (m *MockConfig) SiblingPrefetchEnabled() bool {
+	ret := m.ctrl.Call(m, "SiblingPrefetchEnabled")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// SiblingPrefetchEnabled indicates an expected call of SiblingPrefetchEnabled
+func (mr *MockConfigMockRecorder) SiblingPrefetchEnabled() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SiblingPrefetchEnabled", reflect.TypeOf((*MockConfig)(nil).SiblingPrefetchEnabled))
+}
+
+// SetSiblingPrefetchEnabled mocks base method
+func (m *MockConfig) SetSiblingPrefetchEnabled(arg0 bool) {
+	m.ctrl.Call(m, "SetSiblingPrefetchEnabled", arg0)
+}
+
+// SetSiblingPrefetchEnabled indicates an expected call of SetSiblingPrefetchEnabled
+func (mr *MockConfigMockRecorder) SetSiblingPrefetchEnabled(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetSiblingPrefetchEnabled", reflect.TypeOf((*MockConfig)(nil).SetSiblingPrefetchEnabled), arg0)
+}
+
 // RekeyWithPromptWaitTime mocks base method
 func (m *MockConfig) RekeyWithPromptWaitTime() time.Duration {
 	ret := m.ctrl.Call(m, "RekeyWithPromptWaitTime")
@@ -8076,6 +8796,28 @@ func (mr *MockConfigMockRecorder) SetBGFlushPeriod(p interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetBGFlushPeriod", reflect.TypeOf((*MockConfig)(nil).SetBGFlushPeriod), p)
 }
 
+// DirTimesBatchPeriod mocks base method
+func (m *MockConfig) DirTimesBatchPeriod() time.Duration {
+	ret := m.ctrl.Call(m, "DirTimesBatchPeriod")
+	ret0, _ := ret[0].(time.Duration)
+	return ret0
+}
+
+// DirTimesBatchPeriod indicates an expected call of DirTimesBatchPeriod
+func (mr *MockConfigMockRecorder) DirTimesBatchPeriod() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DirTimesBatchPeriod", reflect.TypeOf((*MockConfig)(nil).DirTimesBatchPeriod))
+}
+
+// SetDirTimesBatchPeriod mocks base method
+func (m *MockConfig) SetDirTimesBatchPeriod(p time.Duration) {
+	m.ctrl.Call(m, "SetDirTimesBatchPeriod", p)
+}
+
+// SetDirTimesBatchPeriod indicates an expected call of SetDirTimesBatchPeriod
+func (mr *MockConfigMockRecorder) SetDirTimesBatchPeriod(p interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetDirTimesBatchPeriod", reflect.TypeOf((*MockConfig)(nil).SetDirTimesBatchPeriod), p)
+}
+
 // Shutdown mocks base method
 func (m *MockConfig) Shutdown(arg0 context.Context) error {
 	ret := m.ctrl.Call(m, "Shutdown", arg0)
@@ -8299,6 +9041,50 @@ func (mr *MockNodeCacheMockRecorder) AddRootWrapper(arg0 interface{}) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddRootWrapper", reflect.TypeOf((*MockNodeCache)(nil).AddRootWrapper), arg0)
 }
 
+// Pin mocks base method
+func (m *MockNodeCache) Pin(node Node) {
+	m.ctrl.Call(m, "Pin", node)
+}
+
+// Pin indicates an expected call of Pin
+func (mr *MockNodeCacheMockRecorder) Pin(node interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Pin", reflect.TypeOf((*MockNodeCache)(nil).Pin), node)
+}
+
+// Unpin mocks base method
+func (m *MockNodeCache) Unpin(node Node) {
+	m.ctrl.Call(m, "Unpin", node)
+}
+
+// Unpin indicates an expected call of Unpin
+func (mr *MockNodeCacheMockRecorder) Unpin(node interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Unpin", reflect.TypeOf((*MockNodeCache)(nil).Unpin), node)
+}
+
+// Stats mocks base method
+func (m *MockNodeCache) Stats() NodeCacheStats {
+	ret := m.ctrl.Call(m, "Stats")
+	ret0, _ := ret[0].(NodeCacheStats)
+	return ret0
+}
+
+// Stats indicates an expected call of Stats
+func (mr *MockNodeCacheMockRecorder) Stats() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stats", reflect.TypeOf((*MockNodeCache)(nil).Stats))
+}
+
+// EvictionCandidates mocks base method
+func (m *MockNodeCache) EvictionCandidates() []NodeChange {
+	ret := m.ctrl.Call(m, "EvictionCandidates")
+	ret0, _ := ret[0].([]NodeChange)
+	return ret0
+}
+
+// EvictionCandidates indicates an expected call of EvictionCandidates
+func (mr *MockNodeCacheMockRecorder) EvictionCandidates() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EvictionCandidates", reflect.TypeOf((*MockNodeCache)(nil).EvictionCandidates))
+}
+
 // MockcrAction is a mock of crAction interface
 type MockcrAction struct {
 	ctrl     *gomock.Controller
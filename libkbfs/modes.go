@@ -23,6 +23,8 @@ func NewInitModeFromType(t InitModeType) InitMode {
 		return modeSingleOp{modeDefault{}}
 	case InitConstrained:
 		return modeConstrained{modeDefault{}}
+	case InitBackup:
+		return modeBackup{modeDefault{}}
 	default:
 		panic(fmt.Sprintf("Unknown mode: %s", t))
 	}
@@ -31,6 +33,10 @@ func NewInitModeFromType(t InitModeType) InitMode {
 const (
 	defaultQRPeriod      = 1 * time.Hour
 	defaultQRMinUnrefAge = 2 * 7 * 24 * time.Hour // 2 weeks
+
+	backupBGFlushPeriod         = 10 * time.Second
+	backupBGFlushDirOpBatchSize = 1000
+	backupDirMtimeGranularity   = 10 * time.Minute
 )
 
 // Default mode:
@@ -70,6 +76,18 @@ func (md modeDefault) BackgroundFlushesEnabled() bool {
 	return true
 }
 
+func (md modeDefault) BackgroundFlushPeriod() time.Duration {
+	return bgFlushPeriodDefault
+}
+
+func (md modeDefault) BackgroundFlushDirOpBatchSize() int {
+	return bgFlushDirOpBatchSizeDefault
+}
+
+func (md modeDefault) DirMtimeGranularity() time.Duration {
+	return 0
+}
+
 func (md modeDefault) MetricsEnabled() bool {
 	return true
 }
@@ -184,6 +202,18 @@ func (mm modeMinimal) BackgroundFlushesEnabled() bool {
 	return false
 }
 
+func (mm modeMinimal) BackgroundFlushPeriod() time.Duration {
+	return bgFlushPeriodDefault
+}
+
+func (mm modeMinimal) BackgroundFlushDirOpBatchSize() int {
+	return bgFlushDirOpBatchSizeDefault
+}
+
+func (mm modeMinimal) DirMtimeGranularity() time.Duration {
+	return 0
+}
+
 func (mm modeMinimal) MetricsEnabled() bool {
 	return false
 }
@@ -412,6 +442,53 @@ func (mc modeConstrained) LocalHTTPServerEnabled() bool {
 	return true
 }
 
+// Backup mode:
+
+type modeBackup struct {
+	InitMode
+}
+
+func (mb modeBackup) Type() InitModeType {
+	return InitBackup
+}
+
+func (mb modeBackup) PrefetchWorkers() int {
+	// Backup tools write far more than they read, and their reads
+	// are scattered, so read-ahead only wastes bandwidth.
+	return 0
+}
+
+func (mb modeBackup) BackgroundFlushPeriod() time.Duration {
+	// Let big files accumulate more dirty data between background
+	// flushes; the tools fsync each file once it's complete anyway.
+	return backupBGFlushPeriod
+}
+
+func (mb modeBackup) BackgroundFlushDirOpBatchSize() int {
+	return backupBGFlushDirOpBatchSize
+}
+
+func (mb modeBackup) DirMtimeGranularity() time.Duration {
+	// Backup repositories add files to the same few directories
+	// all the time, and nothing looks at their mtimes.  This is
+	// the only mode that relaxes POSIX directory times.
+	return backupDirMtimeGranularity
+}
+
+func (mb modeBackup) TLFEditHistoryEnabled() bool {
+	return false
+}
+
+func (mb modeBackup) SendEditNotificationsEnabled() bool {
+	// A backup writes thousands of pack files that nobody wants
+	// to hear about.
+	return false
+}
+
+func (mb modeBackup) LocalHTTPServerEnabled() bool {
+	return false
+}
+
 // Wrapper for tests.
 
 type modeTest struct {
@@ -17,6 +17,9 @@ type nodeCore struct {
 	pathNode *pathNode
 	parent   Node
 	cache    *nodeCacheStandard
+	// initialRef is the ref of the entry when this node was made;
+	// unlike pathNode, it isn't updated when the entry is synced.
+	initialRef BlockRef
 	// used only when parent is nil (the object has been unlinked)
 	cachedPath path
 	cachedDe   DirEntry
@@ -29,11 +32,25 @@ func newNodeCore(ptr BlockPointer, name string, parent Node,
 			BlockPointer: ptr,
 			Name:         name,
 		},
-		parent: parent,
-		cache:  cache,
+		parent:     parent,
+		cache:      cache,
+		initialRef: ptr.Ref(),
 	}
 }
 
+// InitialNodeRef returns the block reference that the entry of `n`
+// had when the node was first made.  It stays the same while the
+// node is in use, even as the entry is renamed or written to, and a
+// node made later for an unchanged entry gets the same one.  It
+// returns the zero BlockRef for nodes not made by a NodeCache.
+func InitialNodeRef(n Node) BlockRef {
+	ns, ok := n.Unwrap().(*nodeStandard)
+	if !ok {
+		return BlockRef{}
+	}
+	return ns.core.initialRef
+}
+
 func (c *nodeCore) ParentID() NodeID {
 	if c.parent == nil {
 		return nil
@@ -6,12 +6,43 @@ package libkbfs
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 )
 
+// maxNodeCacheEntries is the number of entries the node cache holds
+// before it starts asking the holders of the least recently used
+// Nodes that aren't pinned to release them; see EvictionCandidates.
+// Entries are always dropped as soon as their last Node is released,
+// unless they're pinned, so the cache can only shrink as the holders
+// release their Nodes.
+const maxNodeCacheEntries = 50000
+
+// nodeCacheEntryOverheadBytes is a rough estimate of the memory used
+// by a single node cache entry, not counting its name.
+const nodeCacheEntryOverheadBytes = 256
+
 type nodeCacheEntry struct {
 	core     *nodeCore
 	refCount int
+	// pinCount is non-zero while some caller (e.g., a file with
+	// unflushed writes, or an open handle) needs this entry to stay
+	// in the cache.  A pin keeps the entry from being forgotten once
+	// its Nodes are released, and from being evicted.
+	pinCount int
+	// lastUsed orders the entries by when they were last looked up.
+	lastUsed uint64
+}
+
+// NodeCacheStats summarizes the contents of a NodeCache.  It is
+// suitable for encoding directly as JSON.
+type NodeCacheStats struct {
+	NumNodes    int
+	NumPinned   int
+	NumUnlinked int
+	// ApproxBytes is a rough estimate of the memory used by the
+	// cache entries themselves.
+	ApproxBytes int64
 }
 
 // nodeCacheStandard implements the NodeCache interface by tracking
@@ -23,6 +54,13 @@ type nodeCacheStandard struct {
 	lock         sync.RWMutex
 	nodes        map[BlockRef]*nodeCacheEntry
 	rootWrappers []func(Node) Node
+	maxEntries   int
+	useCount     uint64
+	// nextEvictionCheck is the number of entries at which
+	// EvictionCandidates next looks for entries to evict, so that it
+	// doesn't go through all of them on every call while the cache
+	// is over its limit.
+	nextEvictionCheck int
 }
 
 var _ NodeCache = (*nodeCacheStandard)(nil)
@@ -31,6 +69,7 @@ func newNodeCacheStandard(fb FolderBranch) *nodeCacheStandard {
 	return &nodeCacheStandard{
 		folderBranch: fb,
 		nodes:        make(map[BlockRef]*nodeCacheEntry),
+		maxEntries:   maxNodeCacheEntries,
 	}
 }
 
@@ -47,9 +86,18 @@ func (ncs *nodeCacheStandard) forgetLocked(core *nodeCore) {
 	}
 
 	entry.refCount--
-	if entry.refCount <= 0 {
-		delete(ncs.nodes, ref)
+	ncs.maybeDropLocked(ref, entry)
+}
+
+// maybeDropLocked removes the entry for `ref` from the cache if it's
+// neither referenced by any Node nor pinned.  lock must be held for
+// writing by the caller.
+func (ncs *nodeCacheStandard) maybeDropLocked(
+	ref BlockRef, entry *nodeCacheEntry) {
+	if entry.refCount > 0 || entry.pinCount > 0 {
+		return
 	}
+	delete(ncs.nodes, ref)
 }
 
 // should be called only by nodeStandardFinalizer().
@@ -97,6 +145,12 @@ func (ncs *nodeCacheStandard) makeNodeStandardForEntryLocked(
 	return makeNodeStandard(entry.core)
 }
 
+// lock must be held for writing by the caller
+func (ncs *nodeCacheStandard) touchLocked(entry *nodeCacheEntry) {
+	ncs.useCount++
+	entry.lastUsed = ncs.useCount
+}
+
 // GetOrCreate implements the NodeCache interface for nodeCacheStandard.
 func (ncs *nodeCacheStandard) GetOrCreate(
 	ptr BlockPointer, name string, parent Node) (n Node, err error) {
@@ -130,6 +184,7 @@ func (ncs *nodeCacheStandard) GetOrCreate(
 		if parent != nil && entry.core.parent == nil {
 			delete(ncs.nodes, ptr.Ref())
 		} else {
+			ncs.touchLocked(entry)
 			return ncs.makeNodeStandardForEntryLocked(entry), nil
 		}
 	}
@@ -146,6 +201,7 @@ func (ncs *nodeCacheStandard) GetOrCreate(
 		core: newNodeCore(ptr, name, parent, ncs),
 	}
 	ncs.nodes[ptr.Ref()] = entry
+	ncs.touchLocked(entry)
 	return ncs.makeNodeStandardForEntryLocked(entry), nil
 }
 
@@ -175,6 +231,7 @@ func (ncs *nodeCacheStandard) Get(ref BlockRef) (n Node) {
 	if !ok {
 		return nil
 	}
+	ncs.touchLocked(entry)
 	return ncs.makeNodeStandardForEntryLocked(entry)
 }
 
@@ -435,3 +492,103 @@ func (ncs *nodeCacheStandard) AddRootWrapper(f func(Node) Node) {
 	defer ncs.lock.Unlock()
 	ncs.rootWrappers = append(ncs.rootWrappers, f)
 }
+
+// lock must be held for writing by the caller
+func (ncs *nodeCacheStandard) entryForNodeLocked(node Node) *nodeCacheEntry {
+	ns, ok := node.Unwrap().(*nodeStandard)
+	if !ok {
+		return nil
+	}
+	entry, ok := ncs.nodes[ns.core.pathNode.Ref()]
+	if !ok || entry.core != ns.core {
+		return nil
+	}
+	return entry
+}
+
+// Pin implements the NodeCache interface for nodeCacheStandard.
+func (ncs *nodeCacheStandard) Pin(node Node) {
+	ncs.lock.Lock()
+	defer ncs.lock.Unlock()
+	entry := ncs.entryForNodeLocked(node)
+	if entry == nil {
+		return
+	}
+	entry.pinCount++
+}
+
+// Unpin implements the NodeCache interface for nodeCacheStandard.
+func (ncs *nodeCacheStandard) Unpin(node Node) {
+	ncs.lock.Lock()
+	defer ncs.lock.Unlock()
+	entry := ncs.entryForNodeLocked(node)
+	if entry == nil || entry.pinCount == 0 {
+		return
+	}
+	entry.pinCount--
+	ncs.maybeDropLocked(entry.core.pathNode.Ref(), entry)
+}
+
+// Stats implements the NodeCache interface for nodeCacheStandard.
+func (ncs *nodeCacheStandard) Stats() (stats NodeCacheStats) {
+	ncs.lock.RLock()
+	defer ncs.lock.RUnlock()
+	for _, entry := range ncs.nodes {
+		stats.NumNodes++
+		if entry.pinCount > 0 {
+			stats.NumPinned++
+		}
+		if entry.core.cachedPath.isValid() {
+			stats.NumUnlinked++
+		}
+		stats.ApproxBytes += nodeCacheEntryOverheadBytes +
+			int64(len(entry.core.pathNode.Name))
+	}
+	return stats
+}
+
+// EvictionCandidates implements the NodeCache interface for
+// nodeCacheStandard.
+func (ncs *nodeCacheStandard) EvictionCandidates() (changes []NodeChange) {
+	ncs.lock.Lock()
+	defer ncs.lock.Unlock()
+	over := len(ncs.nodes) - ncs.maxEntries
+	if over <= 0 {
+		ncs.nextEvictionCheck = 0
+		return nil
+	}
+	if len(ncs.nodes) < ncs.nextEvictionCheck {
+		return nil
+	}
+	ncs.nextEvictionCheck = len(ncs.nodes) + ncs.maxEntries/10 + 1
+
+	entries := make([]*nodeCacheEntry, 0, len(ncs.nodes))
+	for _, entry := range ncs.nodes {
+		// Roots and unlinked entries aren't in any directory they
+		// could be evicted from.
+		if entry.pinCount > 0 || entry.core.parent == nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].lastUsed < entries[j].lastUsed
+	})
+	if len(entries) > over {
+		entries = entries[:over]
+	}
+
+	parentIndices := make(map[NodeID]int)
+	for _, entry := range entries {
+		parent := entry.core.parent
+		i, ok := parentIndices[parent.GetID()]
+		if !ok {
+			i = len(changes)
+			parentIndices[parent.GetID()] = i
+			changes = append(changes, NodeChange{Node: parent})
+		}
+		changes[i].DirUpdated = append(
+			changes[i].DirUpdated, entry.core.pathNode.Name)
+	}
+	return changes
+}
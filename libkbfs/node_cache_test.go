@@ -5,6 +5,7 @@
 package libkbfs
 
 import (
+	"fmt"
 	"runtime"
 	"testing"
 
@@ -551,3 +552,82 @@ func TestNodeCacheAllNodeChildren(t *testing.T) {
 	child1Children = ncs.AllNodeChildren(childNode1)
 	require.Len(t, child1Children, 1)
 }
+
+func TestNodeCachePinAndEvict(t *testing.T) {
+	ncs := newNodeCacheStandard(FolderBranch{tlf.FakeID(0, tlf.Private), ""})
+	ncs.maxEntries = 4
+
+	parentPtr := BlockPointer{ID: kbfsblock.FakeID(0)}
+	parentNode, err := ncs.GetOrCreate(parentPtr, "parent", nil)
+	require.NoError(t, err)
+
+	var children []Node
+	var childPaths []path
+	for i := byte(1); i <= 6; i++ {
+		childPtr := BlockPointer{ID: kbfsblock.FakeID(i)}
+		child, err := ncs.GetOrCreate(
+			childPtr, fmt.Sprintf("child%d", i), parentNode)
+		require.NoError(t, err)
+		children = append(children, child)
+		childPaths = append(childPaths, ncs.PathFromNode(child))
+	}
+	unlink := func(i int) {
+		ref := children[i].(*nodeStandard).core.pathNode.Ref()
+		ncs.Unlink(ref, childPaths[i], DirEntry{})
+	}
+	release := func(i int) {
+		ncs.forget(children[i].(*nodeStandard).core)
+	}
+	cached := func(i int) bool {
+		// Don't use `Get`, which would hold another node.
+		_, ok := ncs.nodes[childPaths[i].tailPointer().Ref()]
+		return ok
+	}
+
+	stats := ncs.Stats()
+	require.Equal(t, 7, stats.NumNodes)
+	require.Equal(t, 0, stats.NumUnlinked)
+
+	t.Log("The least recently used unpinned entries are eviction " +
+		"candidates, down to the limit.")
+	ncs.Pin(children[0])
+	require.Equal(t, 1, ncs.Stats().NumPinned)
+	n := ncs.Get(childPaths[1].tailPointer().Ref())
+	ncs.forget(n.(*nodeStandard).core)
+	changes := ncs.EvictionCandidates()
+	require.Len(t, changes, 1)
+	require.Equal(t, parentNode.GetID(), changes[0].Node.GetID())
+	require.Equal(t, []string{"child3", "child4", "child5"},
+		changes[0].DirUpdated)
+
+	t.Log("They aren't listed again until the cache grows further.")
+	require.Nil(t, ncs.EvictionCandidates())
+
+	t.Log("Released entries are dropped right away, even unlinked ones.")
+	release(2)
+	require.False(t, cached(2))
+	unlink(3)
+	require.Equal(t, 1, ncs.Stats().NumUnlinked)
+	require.Equal(t, childPaths[3], ncs.PathFromNode(children[3]))
+	release(3)
+	require.False(t, cached(3))
+	release(4)
+	require.Nil(t, ncs.EvictionCandidates())
+
+	t.Log("A pin keeps an entry cached after its last node is released, " +
+		"even once it's unlinked.")
+	unlink(0)
+	release(0)
+	require.True(t, cached(0))
+	n = ncs.Get(childPaths[0].tailPointer().Ref())
+	require.NotNil(t, n)
+	require.Equal(t, childPaths[0], ncs.PathFromNode(n))
+	ncs.forget(n.(*nodeStandard).core)
+	ncs.Unpin(children[0])
+	require.False(t, cached(0))
+
+	stats = ncs.Stats()
+	require.Equal(t, 3, stats.NumNodes)
+	require.Equal(t, 0, stats.NumPinned)
+	require.Equal(t, 0, stats.NumUnlinked)
+}
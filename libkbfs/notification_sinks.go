@@ -0,0 +1,272 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// GUINotificationSinkName is the name of the sink that sends
+// notifications to the Keybase GUI through the service.  It's
+// registered by NewReporterKBPKI.
+const GUINotificationSinkName = "gui"
+
+// NotificationSeverity is how much a notification deserves the
+// user's attention.  Sinks are registered with the lowest severity
+// they get.
+type NotificationSeverity int
+
+const (
+	// NotificationInfo is for progress and file change
+	// notifications.
+	NotificationInfo NotificationSeverity = iota
+	// NotificationWarning is for things the user may need to act on
+	// soon, like rekeys and quota warnings.
+	NotificationWarning
+	// NotificationError is for failed operations.
+	NotificationError
+)
+
+func (s NotificationSeverity) String() string {
+	switch s {
+	case NotificationInfo:
+		return "info"
+	case NotificationWarning:
+		return "warning"
+	case NotificationError:
+		return "error"
+	default:
+		return fmt.Sprintf("NotificationSeverity(%d)", int(s))
+	}
+}
+
+// ParseNotificationSeverity parses the String() form of a
+// NotificationSeverity.
+func ParseNotificationSeverity(s string) (NotificationSeverity, error) {
+	for _, severity := range []NotificationSeverity{
+		NotificationInfo, NotificationWarning, NotificationError} {
+		if s == severity.String() {
+			return severity, nil
+		}
+	}
+	return 0, errors.Errorf("Unknown notification severity %q", s)
+}
+
+// notificationSeverity returns the severity of `n`.
+func notificationSeverity(n *keybase1.FSNotification) NotificationSeverity {
+	switch {
+	case n.StatusCode == keybase1.FSStatusCode_ERROR &&
+		n.ErrorType == keybase1.FSErrorType_OVER_QUOTA:
+		return NotificationWarning
+	case n.StatusCode == keybase1.FSStatusCode_ERROR:
+		return NotificationError
+	case n.NotificationType == keybase1.FSNotificationType_REKEYING:
+		return NotificationWarning
+	default:
+		return NotificationInfo
+	}
+}
+
+// notificationText returns a short title and a one-line body
+// describing `n`, for sinks that show notifications to people.
+func notificationText(n *keybase1.FSNotification) (title, body string) {
+	switch {
+	case n.NotificationType == keybase1.FSNotificationType_CONNECTION:
+		if n.StatusCode == keybase1.FSStatusCode_ERROR {
+			return "KBFS", "Disconnected from the Keybase servers"
+		}
+		return "KBFS", "Connected to the Keybase servers"
+	case n.StatusCode == keybase1.FSStatusCode_ERROR:
+		title = "KBFS error"
+		if n.ErrorType == keybase1.FSErrorType_OVER_QUOTA {
+			title = "KBFS warning"
+		}
+		if n.Filename == "" {
+			return title, n.Status
+		}
+		return title, n.Filename + ": " + n.Status
+	}
+
+	what := strings.ToLower(
+		strings.Replace(n.NotificationType.String(), "_", " ", -1))
+	if n.StatusCode == keybase1.FSStatusCode_FINISH &&
+		n.NotificationType == keybase1.FSNotificationType_REKEYING {
+		what = "finished rekeying"
+	}
+	body = strings.ToUpper(what[:1]) + what[1:]
+	if n.Filename != "" {
+		body += " " + n.Filename
+	}
+	return "KBFS", body
+}
+
+// guiNotificationSink sends the notifications that the Keybase GUI
+// shows to the service.
+type guiNotificationSink struct {
+	config Config
+}
+
+var _ NotificationSink = guiNotificationSink{}
+
+// Notify implements the NotificationSink interface for
+// guiNotificationSink.
+func (s guiNotificationSink) Notify(ctx context.Context,
+	n *keybase1.FSNotification, _ NotificationSeverity) error {
+	nt := n.NotificationType
+	// Only these notifications are used in frontend:
+	// https://github.com/keybase/client/blob/0d63795105f64289ba4ef20fbefe56aad91bc7e9/shared/util/kbfs-notifications.js#L142-L154
+	if nt != keybase1.FSNotificationType_REKEYING &&
+		nt != keybase1.FSNotificationType_INITIALIZED &&
+		nt != keybase1.FSNotificationType_CONNECTION &&
+		n.StatusCode != keybase1.FSStatusCode_ERROR {
+		return nil
+	}
+	return s.config.KeybaseService().Notify(ctx, n)
+}
+
+// desktopNotificationSink shows notifications natively on the desktop
+// the daemon runs on, without going through the GUI.
+type desktopNotificationSink struct{}
+
+var _ NotificationSink = desktopNotificationSink{}
+
+// Notify implements the NotificationSink interface for
+// desktopNotificationSink.
+func (desktopNotificationSink) Notify(ctx context.Context,
+	n *keybase1.FSNotification, _ NotificationSeverity) error {
+	title, body := notificationText(n)
+	return showDesktopNotification(ctx, title, body)
+}
+
+// chatNotificationSink posts notifications to a chat conversation.
+type chatNotificationSink struct {
+	config Config
+	// folder is like "team/acme" or "private/alice".
+	folder  string
+	channel string
+}
+
+var _ NotificationSink = chatNotificationSink{}
+
+// Notify implements the NotificationSink interface for
+// chatNotificationSink.
+func (s chatNotificationSink) Notify(ctx context.Context,
+	n *keybase1.FSNotification, _ NotificationSeverity) error {
+	_, body := notificationText(n)
+	return sendChatToFolder(ctx, s.config, s.folder, s.channel, body)
+}
+
+// NotificationSinkSpec describes a sink to register, as given to
+// -notification-sinks.
+type NotificationSinkSpec struct {
+	// Kind is "desktop", "syslog" or "chat".
+	Kind string
+	// Folder and Channel are where chat sinks post, like
+	// "team/acme" and "kbfs".
+	Folder  string
+	Channel string
+	// MinSeverity is the lowest severity the sink gets.
+	MinSeverity NotificationSeverity
+}
+
+// Name returns the name to register the sink under.
+func (spec NotificationSinkSpec) Name() string {
+	if spec.Kind != "chat" {
+		return spec.Kind
+	}
+	name := "chat:" + spec.Folder
+	if spec.Channel != "" {
+		name += "#" + spec.Channel
+	}
+	return name
+}
+
+// ParseNotificationSinkSpecs parses a comma-separated list of sinks,
+// each like "kind[=severity]", where kind is "desktop", "syslog", or
+// "chat:<folder>[#<channel>]".  The severity defaults to "warning".
+func ParseNotificationSinkSpecs(s string) ([]NotificationSinkSpec, error) {
+	var specs []NotificationSinkSpec
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		spec := NotificationSinkSpec{MinSeverity: NotificationWarning}
+		if i := strings.LastIndex(field, "="); i >= 0 {
+			var err error
+			spec.MinSeverity, err = ParseNotificationSeverity(field[i+1:])
+			if err != nil {
+				return nil, err
+			}
+			field = field[:i]
+		}
+		switch {
+		case field == "desktop" || field == "syslog":
+			spec.Kind = field
+		case strings.HasPrefix(field, "chat:"):
+			spec.Kind = "chat"
+			spec.Folder = strings.TrimPrefix(field, "chat:")
+			if i := strings.Index(spec.Folder, "#"); i >= 0 {
+				spec.Channel = spec.Folder[i+1:]
+				spec.Folder = spec.Folder[:i]
+			}
+			if !strings.Contains(spec.Folder, "/") {
+				return nil, errors.Errorf(
+					"Bad chat folder %q; it should look like team/acme",
+					spec.Folder)
+			}
+		default:
+			return nil, errors.Errorf("Unknown notification sink %q", field)
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// makeNotificationSink returns the sink described by `spec`.
+func makeNotificationSink(
+	config Config, spec NotificationSinkSpec) (NotificationSink, error) {
+	switch spec.Kind {
+	case "desktop":
+		return desktopNotificationSink{}, nil
+	case "syslog":
+		return newSyslogNotificationSink()
+	case "chat":
+		return chatNotificationSink{config, spec.Folder, spec.Channel}, nil
+	default:
+		return nil, errors.Errorf("Unknown notification sink %q", spec.Kind)
+	}
+}
+
+// RegisterNotificationSinks registers the sinks described by `s`, in
+// the format of ParseNotificationSinkSpecs, with the reporter of
+// `config`, which must be a ReporterKBPKI.
+func RegisterNotificationSinks(config Config, s string) error {
+	specs, err := ParseNotificationSinkSpecs(s)
+	if err != nil {
+		return err
+	}
+	if len(specs) == 0 {
+		return nil
+	}
+	r, ok := config.Reporter().(*ReporterKBPKI)
+	if !ok {
+		return errors.Errorf(
+			"Reporter %T doesn't support notification sinks", config.Reporter())
+	}
+	for _, spec := range specs {
+		sink, err := makeNotificationSink(config, spec)
+		if err != nil {
+			return err
+		}
+		r.RegisterNotificationSink(spec.Name(), sink, spec.MinSeverity)
+	}
+	return nil
+}
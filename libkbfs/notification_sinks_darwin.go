@@ -0,0 +1,29 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build darwin
+
+package libkbfs
+
+import (
+	"os/exec"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// showDesktopNotification shows a notification in the macOS
+// Notification Center.  The text is passed as arguments to the
+// script, so that it needs no quoting.
+func showDesktopNotification(ctx context.Context, title, body string) error {
+	out, err := exec.CommandContext(ctx, "osascript",
+		"-e", "on run argv",
+		"-e", "display notification (item 2 of argv) "+
+			"with title (item 1 of argv)",
+		"-e", "end run", title, body).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "osascript failed with output %q", out)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package libkbfs
+
+import (
+	"os/exec"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// showDesktopNotification shows a notification through the
+// freedesktop.org notification service of the user's session.
+func showDesktopNotification(ctx context.Context, title, body string) error {
+	out, err := exec.CommandContext(
+		ctx, "notify-send", "--app-name=Keybase", title, body).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "notify-send failed with output %q", out)
+	}
+	return nil
+}
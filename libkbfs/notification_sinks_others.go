@@ -0,0 +1,19 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build !linux,!darwin
+
+package libkbfs
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// showDesktopNotification fails on platforms where we don't know how
+// to show notifications without the GUI.
+func showDesktopNotification(_ context.Context, _, _ string) error {
+	return errors.New("Desktop notifications aren't supported " +
+		"on this platform")
+}
@@ -0,0 +1,46 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build !windows
+
+package libkbfs
+
+import (
+	"log/syslog"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// syslogNotificationSink writes notifications to the local syslog
+// daemon, which on systemd machines forwards them to the journal.
+type syslogNotificationSink struct {
+	w *syslog.Writer
+}
+
+var _ NotificationSink = syslogNotificationSink{}
+
+func newSyslogNotificationSink() (NotificationSink, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_USER, "kbfs")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return syslogNotificationSink{w}, nil
+}
+
+// Notify implements the NotificationSink interface for
+// syslogNotificationSink.
+func (s syslogNotificationSink) Notify(_ context.Context,
+	n *keybase1.FSNotification, severity NotificationSeverity) error {
+	_, body := notificationText(n)
+	switch severity {
+	case NotificationError:
+		return s.w.Err(body)
+	case NotificationWarning:
+		return s.w.Warning(body)
+	default:
+		return s.w.Info(body)
+	}
+}
@@ -0,0 +1,14 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build windows
+
+package libkbfs
+
+import "github.com/pkg/errors"
+
+// newSyslogNotificationSink fails, since Windows has no syslog.
+func newSyslogNotificationSink() (NotificationSink, error) {
+	return nil, errors.New("Syslog isn't supported on Windows")
+}
@@ -0,0 +1,91 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+type testNotificationSink struct {
+	ch chan *keybase1.FSNotification
+}
+
+func (s testNotificationSink) Notify(_ context.Context,
+	n *keybase1.FSNotification, _ NotificationSeverity) error {
+	s.ch <- n
+	return nil
+}
+
+func TestParseNotificationSinkSpecs(t *testing.T) {
+	specs, err := ParseNotificationSinkSpecs(
+		"desktop=error, syslog,chat:team/acme#kbfs=info")
+	require.NoError(t, err)
+	require.Equal(t, []NotificationSinkSpec{
+		{Kind: "desktop", MinSeverity: NotificationError},
+		{Kind: "syslog", MinSeverity: NotificationWarning},
+		{Kind: "chat", Folder: "team/acme", Channel: "kbfs",
+			MinSeverity: NotificationInfo},
+	}, specs)
+	require.Equal(t, "chat:team/acme#kbfs", specs[2].Name())
+
+	_, err = ParseNotificationSinkSpecs("desktop=loud")
+	require.Error(t, err)
+	_, err = ParseNotificationSinkSpecs("pager")
+	require.Error(t, err)
+	_, err = ParseNotificationSinkSpecs("chat:acme")
+	require.Error(t, err)
+}
+
+func TestNotificationText(t *testing.T) {
+	title, body := notificationText(&keybase1.FSNotification{
+		Filename:         "/keybase/private/alice",
+		StatusCode:       keybase1.FSStatusCode_START,
+		NotificationType: keybase1.FSNotificationType_REKEYING,
+	})
+	require.Equal(t, "KBFS", title)
+	require.Equal(t, "Rekeying /keybase/private/alice", body)
+
+	title, body = notificationText(&keybase1.FSNotification{
+		Filename:   "/keybase/private/alice",
+		Status:     "Access denied",
+		StatusCode: keybase1.FSStatusCode_ERROR,
+	})
+	require.Equal(t, "KBFS error", title)
+	require.Equal(t, "/keybase/private/alice: Access denied", body)
+}
+
+func TestReporterKBPKINotificationSinks(t *testing.T) {
+	config := MakeTestConfigOrBust(t, "alice")
+	defer CheckConfigAndShutdown(context.Background(), t, config)
+	r := NewReporterKBPKI(config, 10, 10)
+	defer r.Shutdown()
+	require.Equal(t, map[string]NotificationSeverity{
+		GUINotificationSinkName: NotificationInfo,
+	}, r.NotificationSinks())
+	r.UnregisterNotificationSink(GUINotificationSinkName)
+
+	all := testNotificationSink{make(chan *keybase1.FSNotification, 10)}
+	errs := testNotificationSink{make(chan *keybase1.FSNotification, 10)}
+	r.RegisterNotificationSink("all", all, NotificationInfo)
+	r.RegisterNotificationSink("errors", errs, NotificationError)
+
+	ctx := context.Background()
+	info := connectionNotification(connectionStatusConnected)
+	r.Notify(ctx, info)
+	errN := connectionNotification(connectionStatusDisconnected)
+	r.Notify(ctx, errN)
+	require.Equal(t, info, <-all.ch)
+	require.Equal(t, errN, <-all.ch)
+	require.Equal(t, errN, <-errs.ch)
+	select {
+	case n := <-errs.ch:
+		t.Fatalf("Unexpected notification %+v", n)
+	default:
+	}
+}
@@ -65,3 +65,14 @@ func (ol *observerList) tlfHandleChange(
 		o.TlfHandleChange(ctx, newHandle)
 	}
 }
+
+func (ol *observerList) evictNodes(
+	ctx context.Context, changes []NodeChange) {
+	ol.lock.RLock()
+	defer ol.lock.RUnlock()
+	for _, o := range ol.observers {
+		if neo, ok := o.(NodeEvictionObserver); ok {
+			neo.EvictNodes(ctx, changes)
+		}
+	}
+}
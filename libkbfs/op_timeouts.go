@@ -0,0 +1,97 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// OpClass is a class of file system operations that share a timeout.
+type OpClass int
+
+const (
+	// OpClassRead is for operations that only look at files and
+	// directories.
+	OpClassRead OpClass = iota
+	// OpClassWrite is for operations that change them.
+	OpClassWrite
+)
+
+func (c OpClass) String() string {
+	switch c {
+	case OpClassRead:
+		return "read"
+	case OpClassWrite:
+		return "write"
+	default:
+		return fmt.Sprintf("OpClass(%d)", int(c))
+	}
+}
+
+// OpTimeouts bounds how long the operations of each class coming
+// from a file system interface may take.  Zero means no timeout.
+type OpTimeouts struct {
+	Read  time.Duration
+	Write time.Duration
+}
+
+type ctxOpTimeoutKeyType int
+
+const (
+	// ctxOpTimeoutKey holds the opTimeout of a context made by
+	// OpTimeouts.WithTimeout.
+	ctxOpTimeoutKey ctxOpTimeoutKeyType = iota
+)
+
+type opTimeout struct {
+	class   OpClass
+	timeout time.Duration
+}
+
+// WithTimeout returns a context that expires once the timeout of
+// `class` has passed, if there is one.  The caller must call the
+// returned cancel function once the operation is done.
+func (ot OpTimeouts) WithTimeout(ctx context.Context, class OpClass) (
+	context.Context, context.CancelFunc) {
+	timeout := ot.Read
+	if class == OpClassWrite {
+		timeout = ot.Write
+	}
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	ctx = context.WithValue(ctx, ctxOpTimeoutKey, opTimeout{class, timeout})
+	return context.WithTimeout(ctx, timeout)
+}
+
+// ClassifyOpTimeout turns `err` into a ServerSlowError or an
+// OfflineError if it was caused by the operation running out of the
+// time given to it by OpTimeouts.WithTimeout, depending on whether
+// KBFS is connected to the servers.  Any other error is returned
+// unchanged.
+func ClassifyOpTimeout(
+	ctx context.Context, config Config, err error) error {
+	if err == nil {
+		return nil
+	}
+	ot, ok := ctx.Value(ctxOpTimeoutKey).(opTimeout)
+	if !ok || ctx.Err() != context.DeadlineExceeded {
+		return err
+	}
+	cause := errors.Cause(err)
+	if _, isTimeout := cause.(TimeoutError); !isTimeout &&
+		cause != context.DeadlineExceeded {
+		return err
+	}
+	if mdServer := config.MDServer(); mdServer != nil &&
+		!mdServer.IsConnected() {
+		return OfflineError{ot.class}
+	}
+	return ServerSlowError{ot.class, ot.timeout}
+}
@@ -0,0 +1,50 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestClassifyOpTimeout(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	config := NewMockConfig(mockCtrl)
+	mdServer := NewMockMDServer(mockCtrl)
+	config.EXPECT().MDServer().AnyTimes().Return(mdServer)
+
+	ot := OpTimeouts{Read: time.Nanosecond}
+	ctx, cancel := ot.WithTimeout(context.Background(), OpClassRead)
+	defer cancel()
+	<-ctx.Done()
+
+	t.Log("Timeouts while connected mean the server is slow")
+	mdServer.EXPECT().IsConnected().Return(true)
+	err := ClassifyOpTimeout(
+		ctx, config, pkgerrors.WithStack(context.DeadlineExceeded))
+	require.Equal(t, ServerSlowError{OpClassRead, time.Nanosecond}, err)
+
+	t.Log("Timeouts while disconnected mean KBFS is offline")
+	mdServer.EXPECT().IsConnected().Return(false)
+	err = ClassifyOpTimeout(ctx, config, TimeoutError{})
+	require.Equal(t, OfflineError{OpClassRead}, err)
+
+	t.Log("Other errors are left alone")
+	otherErr := errors.New("other")
+	require.Equal(t, otherErr, ClassifyOpTimeout(ctx, config, otherErr))
+
+	t.Log("So are timeouts of operations without one")
+	ctx, cancel = ot.WithTimeout(context.Background(), OpClassWrite)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded,
+		ClassifyOpTimeout(ctx, config, context.DeadlineExceeded))
+}
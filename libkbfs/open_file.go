@@ -0,0 +1,27 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"errors"
+
+	"golang.org/x/net/context"
+)
+
+// GetUnsyncedBytes returns the number of bytes written to the file
+// at `node` that haven't been synced yet.
+func GetUnsyncedBytes(
+	ctx context.Context, config Config, node Node) (int64, error) {
+	kbfsOps, ok := config.KBFSOps().(*KBFSOpsStandard)
+	if !ok {
+		return 0, errors.New("KBFSOps doesn't track unsynced bytes")
+	}
+	ops := kbfsOps.getOpsByNode(ctx, node)
+	file, err := ops.pathFromNodeForRead(node)
+	if err != nil {
+		return 0, err
+	}
+	return ops.blocks.GetUnsyncedBytes(makeFBOLockState(), file), nil
+}
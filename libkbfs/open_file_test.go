@@ -0,0 +1,37 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetUnsyncedBytes(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	kbfsOps := config.KBFSOps()
+	a, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	unsynced, err := GetUnsyncedBytes(ctx, config, a)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), unsynced)
+
+	err = kbfsOps.Write(ctx, a, []byte("hello"), 0)
+	require.NoError(t, err)
+	unsynced, err = GetUnsyncedBytes(ctx, config, a)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), unsynced)
+
+	err = kbfsOps.SyncAll(ctx, a.GetFolderBranch())
+	require.NoError(t, err)
+	unsynced, err = GetUnsyncedBytes(ctx, config, a)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), unsynced)
+}
@@ -32,6 +32,7 @@ type prefetcherConfig interface {
 	logMaker
 	blockCacher
 	diskBlockCacheGetter
+	clockGetter
 }
 
 type prefetchRequest struct {
@@ -344,8 +345,28 @@ func (p *blockPrefetcher) prefetchDirectDirBlock(ctx context.Context,
 	sort.Sort(dirEntries)
 	startingPriority :=
 		p.calculatePriority(dirEntryPrefetchPriority, kmd.TlfID())
+	var ignore, sparse *KBFSIgnore
+	if kig, ok := p.config.(kbfsIgnoreGetter); ok && isDeepSync {
+		ignore = kig.KBFSIgnore(kmd.TlfID())
+	}
+	ssg, ok := p.config.(sparseSyncGetter)
+	if ok && isDeepSync {
+		sparse = ssg.SparseSync(kmd.TlfID())
+	}
 	totalChildEntries := 0
 	for i, entry := range dirEntries.dirEntries {
+		if ignore.Match(entry.entryName, entry.Type == Dir) {
+			p.log.CDebugf(ctx, "Skipping deep prefetch for ignored entry %s",
+				entry.entryName)
+			continue
+		}
+		if entry.Type != Dir && sparse.Match(entry.entryName, false) {
+			// Only the parts of the file that get read are synced.
+			p.log.CDebugf(ctx, "Skipping deep prefetch for sparsely-synced "+
+				"entry %s", entry.entryName)
+			ssg.addSparseSyncedBlocks(kmd.TlfID(), entry.BlockPointer.ID)
+			continue
+		}
 		// Prioritize small files
 		priority := startingPriority - i
 		var block Block
@@ -692,11 +713,42 @@ func (p *blockPrefetcher) ProcessBlockForPrefetch(ctx context.Context,
 	ptr BlockPointer, block Block, kmd KeyMetadata, priority int,
 	lifetime BlockCacheLifetime, prefetchStatus PrefetchStatus) {
 	isDeepSync := p.config.IsSyncedTlf(kmd.TlfID())
+	if dcg, ok := p.config.(deviceConstraintsStatusGetter); ok &&
+		isDeepSync && dcg.DeviceConstraintsStatus().PrefetchPaused {
+		// Fall back to regular prefetching until the device is no
+		// longer constrained.
+		isDeepSync = false
+	}
 	req := &prefetchRequest{ptr, block.NewEmpty(), kmd, priority, lifetime,
 		prefetchStatus, isDeepSync}
+	outsideSchedule := false
+	if ssg, ok := p.config.(syncSchedulesGetter); ok {
+		outsideSchedule = !ssg.SyncSchedules().allowsPrefetch(
+			p.config.Clock().Now())
+	}
+	ssg, ok := p.config.(sparseSyncGetter)
+	isSparse := ok && ssg.isSparseSyncedBlock(kmd.TlfID(), ptr.ID)
+	if isSparse {
+		// The children of a block of a sparsely-synced file are
+		// in the same file, and only get synced once they're read.
+		if fblock, ok := block.(*FileBlock); ok && fblock.IsInd {
+			ids := make([]kbfsblock.ID, 0, len(fblock.IPtrs))
+			for _, iptr := range fblock.IPtrs {
+				ids = append(ids, iptr.ID)
+			}
+			ssg.addSparseSyncedBlocks(kmd.TlfID(), ids...)
+		}
+	}
 	if prefetchStatus == FinishedPrefetch {
 		// Finished prefetches can always be short circuited.
 		// If we're here, then FinishedPrefetch is already cached.
+	} else if outsideSchedule || isSparse {
+		// Outside of the prefetch schedule, or for sparsely-synced
+		// files, cache the block without prefetching anything under
+		// it.
+		p.retriever.PutInCaches(ctx, ptr, kmd.TlfID(), block, lifetime,
+			prefetchStatus)
+		return
 	} else if priority < lowestTriggerPrefetchPriority {
 		// Only high priority requests can trigger prefetches. Leave the
 		// prefetchStatus unchanged, but cache anyway.
@@ -0,0 +1,154 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"time"
+
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// QuotaReclamationStatus describes how much of a folder's quota usage
+// is held only by old revisions, and when it can be reclaimed.  The
+// blocks unreferenced by a revision can be garbage collected once the
+// revision is older than MinUnrefAge.
+type QuotaReclamationStatus struct {
+	HeadRevision kbfsmd.Revision
+	// LastGCRevision is the latest revision whose unreferenced blocks
+	// have already been collected.
+	LastGCRevision kbfsmd.Revision
+	// MinUnrefAge includes any extension from a legal hold.  It's
+	// HistoryRetentionForever if old revisions are never collected.
+	MinUnrefAge time.Duration
+	// HistoryRetention is the retention set by the folder's history
+	// retention file, or 0 if it has none.
+	HistoryRetention time.Duration
+	// ReclaimableRevision is the latest revision that's old enough
+	// to be collected, or kbfsmd.RevisionUninitialized if none is.
+	ReclaimableRevision kbfsmd.Revision
+	// ReclaimableBytes is how much the revisions up to
+	// ReclaimableRevision unreferenced, and can be collected now.
+	ReclaimableBytes uint64
+	// PendingBytes is how much the newer revisions unreferenced,
+	// which can't be collected yet.
+	PendingBytes uint64
+	// NextEligibleTime is when the oldest of the newer revisions
+	// becomes old enough to be collected, or zero if there are none or
+	// they are kept forever.
+	NextEligibleTime time.Time
+	// LastReclamationTime is when this device last collected blocks
+	// from the folder, or zero if it hasn't.
+	LastReclamationTime time.Time
+	// AutomaticPeriod is how often this device collects blocks on
+	// its own, or zero if it never does.
+	AutomaticPeriod time.Duration
+}
+
+func (fbm *folderBlockManager) getQuotaReclamationStatus(
+	ctx context.Context) (status QuotaReclamationStatus, err error) {
+	head, err := fbm.helper.getMostRecentFullyMergedMD(ctx)
+	if err != nil {
+		return QuotaReclamationStatus{}, err
+	}
+	if head == (ImmutableRootMetadata{}) {
+		return QuotaReclamationStatus{}, errors.New("Folder has no head")
+	}
+	fbm.refreshHistoryRetention(ctx)
+
+	status = QuotaReclamationStatus{
+		HeadRevision:        head.Revision(),
+		LastGCRevision:      kbfsmd.RevisionUninitialized,
+		MinUnrefAge:         fbm.unrefAge(),
+		HistoryRetention:    fbm.getHistoryRetention(),
+		ReclaimableRevision: kbfsmd.RevisionUninitialized,
+	}
+	if head.data.LastGCRevision >= kbfsmd.RevisionInitial {
+		status.LastGCRevision = head.data.LastGCRevision
+	}
+	status.LastReclamationTime, _ = fbm.getLastQRData()
+	if fbm.reclaimsQuota {
+		status.AutomaticPeriod = fbm.config.Mode().QuotaReclamationPeriod()
+	}
+
+	// Walk backwards from the head, until reaching the revisions
+	// that were already collected.
+	currHead := head.Revision()
+	for currHead > status.LastGCRevision {
+		startRev := currHead - maxMDsAtATime + 1
+		if startRev <= status.LastGCRevision {
+			startRev = status.LastGCRevision + 1
+		}
+		if startRev < kbfsmd.RevisionInitial {
+			startRev = kbfsmd.RevisionInitial
+		}
+		rmds, err := getMDRange(ctx, fbm.config, fbm.id, kbfsmd.NullBranchID,
+			startRev, currHead, kbfsmd.Merged, nil)
+		if err != nil {
+			return QuotaReclamationStatus{}, err
+		}
+		if len(rmds) == 0 {
+			break
+		}
+		for i := len(rmds) - 1; i >= 0; i-- {
+			rmd := rmds[i]
+			if fbm.isOldEnough(rmd) {
+				if status.ReclaimableRevision ==
+					kbfsmd.RevisionUninitialized {
+					status.ReclaimableRevision = rmd.Revision()
+				}
+				status.ReclaimableBytes += rmd.UnrefBytes()
+				continue
+			}
+			status.PendingBytes += rmd.UnrefBytes()
+			if status.MinUnrefAge == HistoryRetentionForever {
+				continue
+			}
+			// Revisions only get older going backwards, so the last
+			// pending one seen is the oldest.
+			status.NextEligibleTime = rmd.localTimestamp.Add(
+				status.MinUnrefAge)
+		}
+		currHead = rmds[0].Revision() - 1
+	}
+	return status, nil
+}
+
+// GetQuotaReclamationStatus implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) GetQuotaReclamationStatus(
+	ctx context.Context, folderBranch FolderBranch) (
+	status QuotaReclamationStatus, err error) {
+	fbo.log.CDebugf(ctx, "GetQuotaReclamationStatus")
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "GetQuotaReclamationStatus done: %+v", err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return QuotaReclamationStatus{},
+			WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+	return fbo.fbm.getQuotaReclamationStatus(ctx)
+}
+
+// ForceQuotaReclamation implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) ForceQuotaReclamation(
+	ctx context.Context, folderBranch FolderBranch) (err error) {
+	fbo.log.CDebugf(ctx, "ForceQuotaReclamation")
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "ForceQuotaReclamation done: %+v", err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+	if !fbo.fbm.reclaimsQuota {
+		return errors.New("Quota reclamation isn't enabled for this folder")
+	}
+	fbo.fbm.forceQuotaReclamation()
+	return fbo.fbm.waitForQuotaReclamations(ctx)
+}
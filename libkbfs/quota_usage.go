@@ -86,7 +86,6 @@ func (q *EventuallyConsistentQuotaUsage) getAndCache(
 	}
 
 	q.mu.Lock()
-	defer q.mu.Unlock()
 	q.cached.limitBytes = quotaInfo.Limit
 	q.cached.gitLimitBytes = quotaInfo.GitLimit
 	if quotaInfo.Total != nil {
@@ -99,7 +98,10 @@ func (q *EventuallyConsistentQuotaUsage) getAndCache(
 		q.cached.usageBytes = 0
 	}
 	q.cached.timestamp = q.config.Clock().Now()
+	usage, limit := q.cached.usageBytes, q.cached.limitBytes
+	q.mu.Unlock()
 
+	q.config.EventRuleEngine().quotaUsageFetched(ctx, q.tid, usage, limit)
 	return nil
 }
 
@@ -0,0 +1,409 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// A raw folder export is a directory holding a folder exactly as the
+// servers store it:
+//
+//	folder.json         a RawFolderInfo
+//	md                  the encoded, signed head of the folder
+//	blocks/<ID>         each encrypted block reachable from the head
+//	blocks/<ID>.half    the server half of each block's key
+//
+// Nothing in it can be read without the folder's crypt keys, which
+// can be exported separately, e.g. in an escrow bundle.
+const (
+	rawFolderInfoName       = "folder.json"
+	rawFolderMDName         = "md"
+	rawFolderBlocksDir      = "blocks"
+	rawFolderServerHalfExt  = ".half"
+	rawFolderExportFileMode = 0600
+)
+
+// RawFolderInfo describes a raw folder export.
+type RawFolderInfo struct {
+	Folder    string
+	TlfID     tlf.ID
+	MDVersion kbfsmd.MetadataVer
+	Revision  kbfsmd.Revision
+}
+
+// rawBlockGetter returns an encrypted block and its server half.
+type rawBlockGetter func(ptr BlockPointer) (
+	[]byte, kbfscrypto.BlockCryptKeyServerHalf, error)
+
+// rawFolderWalker decrypts every block reachable from the root of a
+// folder, writing the plaintext files under `outDir` if it's set.
+// It needs nothing but the folder's keys, a way to get its encrypted
+// blocks, and the block transform they were put with, if any.
+type rawFolderWalker struct {
+	codec     kbfscodec.Codec
+	crypto    CryptoCommon
+	tlfID     tlf.ID
+	keys      []kbfscrypto.TLFCryptKey
+	transform BlockTransform
+	getBlock  rawBlockGetter
+	outDir    string
+}
+
+func newRawFolderWalker(tlfID tlf.ID, keys []kbfscrypto.TLFCryptKey,
+	transform BlockTransform, getBlock rawBlockGetter,
+	outDir string) *rawFolderWalker {
+	codec := kbfscodec.NewMsgpack()
+	RegisterOps(codec)
+	return &rawFolderWalker{
+		codec:     codec,
+		crypto:    MakeCryptoCommon(codec, nil),
+		tlfID:     tlfID,
+		keys:      keys,
+		transform: transform,
+		getBlock:  getBlock,
+		outDir:    outDir,
+	}
+}
+
+func (w *rawFolderWalker) key(keyGen kbfsmd.KeyGen) (
+	kbfscrypto.TLFCryptKey, error) {
+	if keyGen == kbfsmd.PublicKeyGen {
+		return kbfscrypto.PublicTLFCryptKey, nil
+	}
+	i := int(keyGen - kbfsmd.FirstValidKeyGen)
+	if keyGen < kbfsmd.FirstValidKeyGen || i >= len(w.keys) {
+		return kbfscrypto.TLFCryptKey{}, errors.Errorf(
+			"No key for key generation %d", keyGen)
+	}
+	return w.keys[i], nil
+}
+
+func (w *rawFolderWalker) decryptPrivateMetadata(
+	rmds *kbfsmd.RootMetadataSigned) (PrivateMetadata, error) {
+	var pmd PrivateMetadata
+	serialized := rmds.MD.GetSerializedPrivateMetadata()
+	if rmds.MD.TypeForKeying() == tlf.PublicKeying {
+		err := w.codec.Decode(serialized, &pmd)
+		return pmd, err
+	}
+	var encryptedPMD kbfscrypto.EncryptedPrivateMetadata
+	if err := w.codec.Decode(serialized, &encryptedPMD); err != nil {
+		return PrivateMetadata{}, err
+	}
+	k, err := w.key(rmds.MD.LatestKeyGeneration())
+	if err != nil {
+		return PrivateMetadata{}, err
+	}
+	return w.crypto.DecryptPrivateMetadata(encryptedPMD, k)
+}
+
+func (w *rawFolderWalker) readBlock(
+	ctx context.Context, ptr BlockPointer, block Block) error {
+	buf, serverHalf, err := w.getBlock(ptr)
+	if err != nil {
+		return err
+	}
+	if err := kbfsblock.VerifyID(buf, ptr.ID); err != nil {
+		return err
+	}
+	payload, err := unwrapBlockPayload(ctx, w.transform, w.tlfID, buf)
+	if err != nil {
+		return err
+	}
+	var encryptedBlock kbfscrypto.EncryptedBlock
+	if err := w.codec.Decode(payload, &encryptedBlock); err != nil {
+		return err
+	}
+	k, err := w.key(ptr.KeyGen)
+	if err != nil {
+		return err
+	}
+	return w.crypto.DecryptBlock(encryptedBlock, k, serverHalf, block)
+}
+
+func (w *rawFolderWalker) dirChildren(ctx context.Context, ptr BlockPointer) (
+	map[string]DirEntry, error) {
+	block := NewDirBlock().(*DirBlock)
+	if err := w.readBlock(ctx, ptr, block); err != nil {
+		return nil, err
+	}
+	if !block.IsInd {
+		return block.Children, nil
+	}
+	children := make(map[string]DirEntry)
+	for _, iptr := range block.IPtrs {
+		c, err := w.dirChildren(ctx, iptr.BlockPointer)
+		if err != nil {
+			return nil, err
+		}
+		for name, de := range c {
+			children[name] = de
+		}
+	}
+	return children, nil
+}
+
+func (w *rawFolderWalker) walkDir(
+	ctx context.Context, ptr BlockPointer, dirPath string) error {
+	children, err := w.dirChildren(ctx, ptr)
+	if err != nil {
+		return err
+	}
+	for name, de := range children {
+		if name == "" || name == "." || name == ".." ||
+			strings.ContainsAny(name, `/\`) {
+			return errors.Errorf("Invalid name %q in %q", name, dirPath)
+		}
+		p := filepath.Join(dirPath, name)
+		switch de.Type {
+		case Dir:
+			if w.outDir != "" {
+				if err := os.Mkdir(filepath.Join(w.outDir, p), 0755); err != nil {
+					return err
+				}
+			}
+			if err := w.walkDir(ctx, de.BlockPointer, p); err != nil {
+				return err
+			}
+		case File, Exec:
+			if err := w.walkFile(ctx, de, p); err != nil {
+				return err
+			}
+		case Sym:
+			if w.outDir != "" {
+				err := os.Symlink(de.SymPath, filepath.Join(w.outDir, p))
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if w.outDir != "" {
+			mtime := time.Unix(0, de.Mtime)
+			err := os.Chtimes(filepath.Join(w.outDir, p), mtime, mtime)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *rawFolderWalker) writeFileBlocks(ctx context.Context,
+	ptr BlockPointer, off int64, f *os.File) error {
+	block := NewFileBlock().(*FileBlock)
+	if err := w.readBlock(ctx, ptr, block); err != nil {
+		return err
+	}
+	if !block.IsInd {
+		if f == nil {
+			return nil
+		}
+		_, err := f.WriteAt(block.Contents, off)
+		return err
+	}
+	for _, iptr := range block.IPtrs {
+		err := w.writeFileBlocks(ctx, iptr.BlockPointer, int64(iptr.Off), f)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *rawFolderWalker) walkFile(
+	ctx context.Context, de DirEntry, p string) (err error) {
+	var f *os.File
+	if w.outDir != "" {
+		mode := os.FileMode(0644)
+		if de.Type == Exec {
+			mode = 0755
+		}
+		f, err = os.OpenFile(filepath.Join(w.outDir, p),
+			os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			closeErr := f.Close()
+			if err == nil {
+				err = closeErr
+			}
+		}()
+	}
+	if err := w.writeFileBlocks(ctx, de.BlockPointer, 0, f); err != nil {
+		return err
+	}
+	if f == nil {
+		return nil
+	}
+	// Restore any hole at the end of the file.
+	return f.Truncate(int64(de.Size))
+}
+
+// ExportRawFolder writes the head of the folder with handle `h`, and
+// every block reachable from it, to `dir`, still encrypted.  The
+// export can be turned back into plaintext files by
+// DecryptRawFolder, without Keybase or its servers, given the
+// folder's keys.
+func ExportRawFolder(
+	ctx context.Context, config Config, h *TlfHandle, dir string) error {
+	keys, tlfID, err := config.KBFSOps().GetTLFCryptKeys(ctx, h)
+	if err != nil {
+		return err
+	}
+	rmds, err := config.MDServer().GetForTLF(
+		ctx, tlfID, kbfsmd.NullBranchID, kbfsmd.Merged, nil)
+	if err != nil {
+		return err
+	}
+	if rmds == nil {
+		return errors.Errorf("%s has no metadata", h.GetCanonicalPath())
+	}
+	encodedMD, err := kbfsmd.EncodeRootMetadataSigned(
+		config.Codec(), &rmds.RootMetadataSigned)
+	if err != nil {
+		return err
+	}
+
+	blocksDir := filepath.Join(dir, rawFolderBlocksDir)
+	if err := os.MkdirAll(blocksDir, 0700); err != nil {
+		return err
+	}
+	info, err := json.MarshalIndent(RawFolderInfo{
+		Folder:    h.GetCanonicalPath(),
+		TlfID:     tlfID,
+		MDVersion: rmds.Version(),
+		Revision:  rmds.MD.RevisionNumber(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, rawFolderInfoName), info,
+		rawFolderExportFileMode)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, rawFolderMDName), encodedMD,
+		rawFolderExportFileMode)
+	if err != nil {
+		return err
+	}
+
+	bserver := config.BlockServer()
+	getBlock := func(ptr BlockPointer) (
+		[]byte, kbfscrypto.BlockCryptKeyServerHalf, error) {
+		buf, serverHalf, err := bserver.Get(ctx, tlfID, ptr.ID, ptr.Context)
+		if err != nil {
+			return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+		}
+		p := filepath.Join(blocksDir, ptr.ID.String())
+		err = ioutil.WriteFile(p, buf, rawFolderExportFileMode)
+		if err != nil {
+			return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+		}
+		halfData := serverHalf.Data()
+		err = ioutil.WriteFile(p+rawFolderServerHalfExt, halfData[:],
+			rawFolderExportFileMode)
+		if err != nil {
+			return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+		}
+		return buf, serverHalf, nil
+	}
+	// Decrypt the folder as it's exported, to find every block.
+	w := newRawFolderWalker(
+		tlfID, keys, config.BlockTransform(), getBlock, "")
+	pmd, err := w.decryptPrivateMetadata(&rmds.RootMetadataSigned)
+	if err != nil {
+		return err
+	}
+	return w.walkDir(ctx, pmd.Dir.BlockPointer, "")
+}
+
+// DecryptRawFolder reconstructs the plaintext files of the raw folder
+// export in `rawDir`, writing them under `outDir`, which must not
+// exist yet.  `keys` are the folder's crypt keys, in order of key
+// generation starting with kbfsmd.FirstValidKeyGen; public folders
+// need none.  `transform` must be the block transform the folder's
+// blocks were put with, if any; wrapped blocks can't be decrypted
+// without it.  It doesn't need a Config, or any connection to
+// Keybase.
+//
+// Block IDs are checked against the blocks' contents, so a block
+// can't be swapped without notice, but the head's signature isn't
+// checked, since that requires looking up the writer's keys.
+func DecryptRawFolder(ctx context.Context, rawDir string,
+	keys []kbfscrypto.TLFCryptKey, transform BlockTransform,
+	outDir string) (RawFolderInfo, error) {
+	infoData, err := ioutil.ReadFile(filepath.Join(rawDir, rawFolderInfoName))
+	if err != nil {
+		return RawFolderInfo{}, err
+	}
+	var info RawFolderInfo
+	if err := json.Unmarshal(infoData, &info); err != nil {
+		return RawFolderInfo{}, err
+	}
+	encodedMD, err := ioutil.ReadFile(filepath.Join(rawDir, rawFolderMDName))
+	if err != nil {
+		return RawFolderInfo{}, err
+	}
+
+	blocksDir := filepath.Join(rawDir, rawFolderBlocksDir)
+	getBlock := func(ptr BlockPointer) (
+		[]byte, kbfscrypto.BlockCryptKeyServerHalf, error) {
+		p := filepath.Join(blocksDir, ptr.ID.String())
+		buf, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+		}
+		halfData, err := ioutil.ReadFile(p + rawFolderServerHalfExt)
+		if err != nil {
+			return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+		}
+		var half [32]byte
+		if len(halfData) != len(half) {
+			return nil, kbfscrypto.BlockCryptKeyServerHalf{}, errors.Errorf(
+				"Invalid server half for block %s", ptr.ID)
+		}
+		copy(half[:], halfData)
+		return buf, kbfscrypto.MakeBlockCryptKeyServerHalf(half), nil
+	}
+	w := newRawFolderWalker(info.TlfID, keys, transform, getBlock, outDir)
+	rmds, err := kbfsmd.DecodeRootMetadataSigned(
+		w.codec, info.TlfID, info.MDVersion, defaultClientMetadataVer,
+		encodedMD)
+	if err != nil {
+		return RawFolderInfo{}, err
+	}
+	if rmds.MD.TlfID() != info.TlfID {
+		return RawFolderInfo{}, errors.Errorf(
+			"Metadata is for %s, not %s", rmds.MD.TlfID(), info.TlfID)
+	}
+	pmd, err := w.decryptPrivateMetadata(rmds)
+	if err != nil {
+		return RawFolderInfo{}, err
+	}
+	if err := os.Mkdir(outDir, 0755); err != nil {
+		return RawFolderInfo{}, err
+	}
+	if err := w.walkDir(ctx, pmd.Dir.BlockPointer, ""); err != nil {
+		return RawFolderInfo{}, err
+	}
+	return info, nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportAndDecryptRawFolder(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	tempdir, err := ioutil.TempDir(os.TempDir(), "raw_folder")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		assert.NoError(t, err)
+	}()
+
+	// Use small blocks, so the file is split into indirect blocks.
+	bsplit, err := NewBlockSplitterSimple(20, 8*1024, config.Codec())
+	require.NoError(t, err)
+	config.SetBlockSplitter(bsplit)
+	// Wrap the blocks, as an organization with its own key
+	// management service would.
+	transform := xorBlockTransform{"k"}
+	config.SetBlockTransform(transform)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	kbfsOps := config.KBFSOps()
+	dirNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	fileNode, _, err := kbfsOps.CreateFile(ctx, dirNode, "b", true, NoExcl)
+	require.NoError(t, err)
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	err = kbfsOps.Write(ctx, fileNode, data, 0)
+	require.NoError(t, err)
+	_, err = kbfsOps.CreateLink(ctx, rootNode, "link", "a/b")
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+
+	h, err := ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "alice", tlf.Private)
+	require.NoError(t, err)
+	rawDir := filepath.Join(tempdir, "raw")
+	err = ExportRawFolder(ctx, config, h, rawDir)
+	require.NoError(t, err)
+	keys, _, err := kbfsOps.GetTLFCryptKeys(ctx, h)
+	require.NoError(t, err)
+
+	t.Log("The export can't be read without the folder's keys")
+	wrongKey, err := kbfscrypto.MakeRandomTLFCryptKey()
+	require.NoError(t, err)
+	_, err = DecryptRawFolder(ctx, rawDir,
+		[]kbfscrypto.TLFCryptKey{wrongKey}, transform,
+		filepath.Join(tempdir, "wrong"))
+	require.Error(t, err)
+
+	t.Log("Or without the block transform the blocks were put with")
+	_, err = DecryptRawFolder(
+		ctx, rawDir, keys, nil, filepath.Join(tempdir, "untransformed"))
+	require.Error(t, err)
+
+	outDir := filepath.Join(tempdir, "out")
+	info, err := DecryptRawFolder(ctx, rawDir, keys, transform, outDir)
+	require.NoError(t, err)
+	require.Equal(t, rootNode.GetFolderBranch().Tlf, info.TlfID)
+	require.Equal(t, "/keybase/private/alice", info.Folder)
+
+	restored, err := ioutil.ReadFile(filepath.Join(outDir, "a", "b"))
+	require.NoError(t, err)
+	require.Equal(t, data, restored)
+	fi, err := ioutil.Stat(filepath.Join(outDir, "a", "b"))
+	require.NoError(t, err)
+	require.NotZero(t, fi.Mode()&0100)
+	target, err := os.Readlink(filepath.Join(outDir, "link"))
+	require.NoError(t, err)
+	require.Equal(t, "a/b", target)
+}
@@ -0,0 +1,144 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// maxRecentFilesPerTlf bounds how many recently-accessed files are
+// remembered for each TLF.
+const maxRecentFilesPerTlf = 200
+
+// RecentFile describes a file that was recently read or written on
+// this device.
+type RecentFile struct {
+	Path        string
+	LastRead    time.Time `json:",omitempty"`
+	LastWritten time.Time `json:",omitempty"`
+}
+
+// LastAccess returns the later of the file's last read and write
+// times.
+func (rf RecentFile) LastAccess() time.Time {
+	if rf.LastWritten.After(rf.LastRead) {
+		return rf.LastWritten
+	}
+	return rf.LastRead
+}
+
+// recentFiles is a bounded MRU list of the files accessed in a TLF.
+// It isn't a full index; it only knows about files this device has
+// touched since the TLF was loaded.
+type recentFiles struct {
+	lock  sync.Mutex
+	max   int
+	order *list.List // of *RecentFile, most recent first
+	files map[string]*list.Element
+}
+
+func newRecentFiles(max int) *recentFiles {
+	return &recentFiles{
+		max:   max,
+		order: list.New(),
+		files: make(map[string]*list.Element),
+	}
+}
+
+func (rf *recentFiles) record(p string, isWrite bool, now time.Time) {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+	e, ok := rf.files[p]
+	if ok {
+		rf.order.MoveToFront(e)
+	} else {
+		e = rf.order.PushFront(&RecentFile{Path: p})
+		rf.files[p] = e
+	}
+	f := e.Value.(*RecentFile)
+	if isWrite {
+		f.LastWritten = now
+	} else {
+		f.LastRead = now
+	}
+
+	for rf.order.Len() > rf.max {
+		oldest := rf.order.Back()
+		rf.order.Remove(oldest)
+		delete(rf.files, oldest.Value.(*RecentFile).Path)
+	}
+}
+
+// get returns up to `limit` files whose paths contain `filter`
+// (case-insensitively), most recently accessed first.  A non-positive
+// `limit` returns all matches.
+func (rf *recentFiles) get(filter string, limit int) []RecentFile {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+	filter = strings.ToLower(filter)
+	var res []RecentFile
+	for e := rf.order.Front(); e != nil; e = e.Next() {
+		if limit > 0 && len(res) == limit {
+			break
+		}
+		f := e.Value.(*RecentFile)
+		if filter != "" &&
+			!strings.Contains(strings.ToLower(f.Path), filter) {
+			continue
+		}
+		res = append(res, *f)
+	}
+	return res
+}
+
+type ctxRecentFilesTagKey int
+
+const (
+	// ctxSkipRecentFilesKey marks reads and writes that KBFS makes
+	// on its own behalf, which shouldn't count as recent accesses.
+	ctxSkipRecentFilesKey ctxRecentFilesTagKey = iota
+)
+
+// CtxSkipRecentFiles returns a context under which file accesses
+// aren't recorded as recent.
+func CtxSkipRecentFiles(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxSkipRecentFilesKey, true)
+}
+
+// recordRecentFile notes that `file` was just read or written.
+func (fbo *folderBranchOps) recordRecentFile(
+	ctx context.Context, file Node, isWrite bool) {
+	if ctx.Value(ctxSkipRecentFilesKey) != nil {
+		return
+	}
+	p, err := fbo.pathFromNodeForRead(file)
+	if err != nil || !p.isValid() {
+		return
+	}
+	fbo.recentFiles.record(
+		p.CanonicalPathString(), isWrite, fbo.config.Clock().Now())
+}
+
+// GetRecentFiles returns up to `limit` files in this folder that were
+// recently read or written on this device, and whose paths contain
+// `filter`, most recent first.
+func (fbo *folderBranchOps) GetRecentFiles(
+	ctx context.Context, folderBranch FolderBranch, filter string,
+	limit int) (files []RecentFile, err error) {
+	fbo.log.CDebugf(ctx, "GetRecentFiles %q %d", filter, limit)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "GetRecentFiles done: %+v", err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return nil, WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+	return fbo.recentFiles.get(filter, limit), nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecentFilesBounded(t *testing.T) {
+	rf := newRecentFiles(3)
+	now := time.Unix(1, 0)
+	for i := 0; i < 5; i++ {
+		rf.record(fmt.Sprintf("/f%d", i), false, now.Add(time.Duration(i)))
+	}
+	rf.record("/f2", true, now.Add(10))
+
+	files := rf.get("", 0)
+	require.Len(t, files, 3)
+	require.Equal(t, "/f2", files[0].Path)
+	require.Equal(t, now.Add(10), files[0].LastWritten)
+	require.Equal(t, now.Add(2), files[0].LastRead)
+	require.Equal(t, "/f4", files[1].Path)
+	require.Equal(t, "/f3", files[2].Path)
+
+	require.Len(t, rf.get("", 1), 1)
+	files = rf.get("F3", 0)
+	require.Len(t, files, 1)
+	require.Equal(t, "/f3", files[0].Path)
+}
+
+func TestRecentFilesReadWrite(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	clock, now := newTestClockAndTimeNow()
+	config.SetClock(clock)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps()
+	a, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	b, _, err := kbfsOps.CreateFile(ctx, rootNode, "b", false, NoExcl)
+	require.NoError(t, err)
+
+	err = kbfsOps.Write(ctx, a, []byte("hello"), 0)
+	require.NoError(t, err)
+	clock.Add(time.Minute)
+	err = kbfsOps.Truncate(ctx, b, 1)
+	require.NoError(t, err)
+	clock.Add(time.Minute)
+	buf := make([]byte, 5)
+	_, err = kbfsOps.Read(ctx, a, buf, 0)
+	require.NoError(t, err)
+
+	files, err := kbfsOps.GetRecentFiles(ctx, fb, "", 0)
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	require.Equal(t, "/keybase/private/alice/a", files[0].Path)
+	require.Equal(t, now, files[0].LastWritten)
+	require.Equal(t, now.Add(2*time.Minute), files[0].LastRead)
+	require.Equal(t, now.Add(2*time.Minute), files[0].LastAccess())
+	require.Equal(t, "/keybase/private/alice/b", files[1].Path)
+
+	files, err = kbfsOps.GetRecentFiles(ctx, fb, "/b", 0)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.Equal(t, now.Add(time.Minute), files[0].LastWritten)
+
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+}
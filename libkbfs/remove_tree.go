@@ -0,0 +1,232 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
+)
+
+// removeTreeBatchSize is how many entries RemoveTree removes before
+// syncing them out in a new MD revision.  It's a var so tests can
+// change it.
+var removeTreeBatchSize = 1000
+
+const (
+	// removeTreeScanWorkers is how many directories RemoveTree reads
+	// in parallel while scanning the tree to be removed.
+	removeTreeScanWorkers = 10
+)
+
+// RemoveTreeProgress describes how far along a RemoveTree is.
+type RemoveTreeProgress struct {
+	// EntriesTotal is the number of entries being removed, including
+	// the top of the tree.
+	EntriesTotal int
+	// EntriesRemoved is the number of entries that have been removed
+	// and synced so far.
+	EntriesRemoved int
+}
+
+// removeTreeEntry is an entry found while scanning the tree to be
+// removed.
+type removeTreeEntry struct {
+	name     string
+	de       DirEntry
+	children []*removeTreeEntry
+}
+
+func (e *removeTreeEntry) count() int {
+	n := 1
+	for _, c := range e.children {
+		n += c.count()
+	}
+	return n
+}
+
+// scanTreeForRemoval fills in the children of `root`, whose path is
+// `p`, recursively.  Directories are read in parallel, and the top
+// blocks of files are fetched along the way so they are cached by
+// the time the files are unreferenced.
+func (fbo *folderBranchOps) scanTreeForRemoval(
+	ctx context.Context, kmd KeyMetadata, p path,
+	root *removeTreeEntry) error {
+	eg, groupCtx := errgroup.WithContext(ctx)
+	sem := make(chan struct{}, removeTreeScanWorkers)
+	var scan func(p path, e *removeTreeEntry)
+	scan = func(p path, e *removeTreeEntry) {
+		eg.Go(func() error {
+			select {
+			case sem <- struct{}{}:
+			case <-groupCtx.Done():
+				return groupCtx.Err()
+			}
+			defer func() { <-sem }()
+
+			lState := makeFBOLockState()
+			if e.de.Type != Dir {
+				_, err := fbo.blocks.GetIndirectFileBlockInfos(
+					groupCtx, lState, kmd, p)
+				if isRecoverableBlockErrorForRemoval(err) {
+					// unrefEntryLocked will skip the file's blocks.
+					return nil
+				}
+				return err
+			}
+
+			entries, err := fbo.blocks.GetEntries(groupCtx, lState, kmd, p)
+			if isRecoverableBlockErrorForRemoval(err) {
+				msg := fmt.Sprintf("Recoverable block error encountered "+
+					"for scanTreeForRemoval(%v); continuing", p)
+				fbo.log.CWarningf(groupCtx, "%s", msg)
+				fbo.log.CDebugf(groupCtx, "%s (err=%v)", msg, err)
+			} else if err != nil {
+				return err
+			}
+			for name, de := range entries {
+				e.children = append(
+					e.children, &removeTreeEntry{name: name, de: de})
+			}
+			sort.Slice(e.children, func(i, j int) bool {
+				return e.children[i].name < e.children[j].name
+			})
+			for _, c := range e.children {
+				if c.de.Type == Sym {
+					continue
+				}
+				scan(p.ChildPath(c.name, c.de.BlockPointer), c)
+			}
+			return nil
+		})
+	}
+	scan(p, root)
+	return eg.Wait()
+}
+
+func (fbo *folderBranchOps) removeTreeLocked(ctx context.Context,
+	lState *lockState, dir Node, name string,
+	progress func(RemoveTreeProgress)) error {
+	fbo.mdWriterLock.AssertLocked(lState)
+
+	// Verify we have permission to write (but don't make a successor yet).
+	md, err := fbo.getMDForWriteLockedForFilename(ctx, lState, "")
+	if err != nil {
+		return err
+	}
+
+	dirPath, err := fbo.pathFromNodeForMDWriteLocked(lState, dir)
+	if err != nil {
+		return err
+	}
+
+	de, err := fbo.blocks.GetEntry(
+		ctx, lState, md.ReadOnly(), dirPath.ChildPathNoPtr(name))
+	if _, notExists := errors.Cause(err).(NoSuchNameError); notExists {
+		return NoSuchNameError{name}
+	} else if err != nil {
+		return err
+	}
+
+	root := &removeTreeEntry{name: name, de: de}
+	if de.Type == Dir {
+		err = fbo.scanTreeForRemoval(ctx, md.ReadOnly(),
+			dirPath.ChildPath(name, de.BlockPointer), root)
+		if err != nil {
+			return err
+		}
+	}
+
+	p := RemoveTreeProgress{EntriesTotal: root.count()}
+	report := func() {
+		if progress != nil {
+			progress(p)
+		}
+	}
+	report()
+
+	pending := 0
+	sync := func() error {
+		if pending == 0 {
+			return nil
+		}
+		err := fbo.syncAllLocked(ctx, lState, NoExcl)
+		if err != nil {
+			return err
+		}
+		md, err = fbo.getMDForWriteLockedForFilename(ctx, lState, "")
+		if err != nil {
+			return err
+		}
+		p.EntriesRemoved += pending
+		pending = 0
+		report()
+		return nil
+	}
+
+	// Remove the tree bottom-up.  A directory's pointer can't change
+	// before we start removing its children, so the node we make for
+	// it stays valid across the syncs in between.
+	var remove func(parent Node, e *removeTreeEntry) error
+	remove = func(parent Node, e *removeTreeEntry) error {
+		if len(e.children) > 0 {
+			n, err := fbo.nodeCache.GetOrCreate(
+				e.de.BlockPointer, e.name, parent)
+			if err != nil {
+				return err
+			}
+			for _, c := range e.children {
+				if err := remove(n, c); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		parentPath, err := fbo.pathFromNodeForMDWriteLocked(lState, parent)
+		if err != nil {
+			return err
+		}
+		err = fbo.removeEntryLockedMaybeSync(
+			ctx, lState, md.ReadOnly(), parent, parentPath, e.name, false)
+		if err != nil {
+			return err
+		}
+		pending++
+		if pending >= removeTreeBatchSize {
+			return sync()
+		}
+		return nil
+	}
+	if err := remove(dir, root); err != nil {
+		return err
+	}
+	return sync()
+}
+
+// RemoveTree implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) RemoveTree(ctx context.Context, dir Node,
+	name string, progress func(RemoveTreeProgress)) (err error) {
+	fbo.log.CDebugf(ctx, "RemoveTree %s %s", getNodeIDStr(dir), name)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "RemoveTree %s %s done: %+v",
+			getNodeIDStr(dir), name, err)
+	}()
+
+	err = fbo.checkNodeForWrite(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	return fbo.doMDWriteWithRetryUnlessCanceled(ctx,
+		func(lState *lockState) error {
+			return fbo.removeTreeLocked(ctx, lState, dir, name, progress)
+		})
+}
@@ -0,0 +1,76 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveTree(t *testing.T) {
+	defer func(n int) {
+		removeTreeBatchSize = n
+	}(removeTreeBatchSize)
+	removeTreeBatchSize = 10
+
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "test_user")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", tlf.Private)
+	kbfsOps := config.KBFSOps()
+	aNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "keep", false, NoExcl)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		dNode, _, err := kbfsOps.CreateDir(ctx, aNode, fmt.Sprintf("d%d", i))
+		require.NoError(t, err)
+		for j := 0; j < 5; j++ {
+			fNode, _, err := kbfsOps.CreateFile(
+				ctx, dNode, fmt.Sprintf("f%d", j), false, NoExcl)
+			require.NoError(t, err)
+			require.NoError(t, kbfsOps.Write(ctx, fNode, []byte{byte(j)}, 0))
+		}
+	}
+	_, err = kbfsOps.CreateLink(ctx, aNode, "link", "d0/f0")
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch()))
+
+	ops := kbfsOps.(*KBFSOpsStandard).getOpsByNode(ctx, rootNode)
+	lState := makeFBOLockState()
+	before := ops.getCurrMDRevision(lState)
+
+	var progress []RemoveTreeProgress
+	err = kbfsOps.RemoveTree(ctx, rootNode, "a",
+		func(p RemoveTreeProgress) {
+			progress = append(progress, p)
+		})
+	require.NoError(t, err)
+
+	// 1 + 3 dirs + 15 files + 1 link, in batches of 10.
+	require.Equal(t, []RemoveTreeProgress{
+		{EntriesTotal: 20, EntriesRemoved: 0},
+		{EntriesTotal: 20, EntriesRemoved: 10},
+		{EntriesTotal: 20, EntriesRemoved: 20},
+	}, progress)
+	require.Equal(t, before+2, ops.getCurrMDRevision(lState))
+
+	children, err := kbfsOps.GetDirChildren(ctx, rootNode)
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	require.Contains(t, children, "keep")
+	_, _, err = kbfsOps.Lookup(ctx, rootNode, "a")
+	require.IsType(t, NoSuchNameError{}, err)
+
+	t.Log("A single file can be removed too")
+	err = kbfsOps.RemoveTree(ctx, rootNode, "keep", nil)
+	require.NoError(t, err)
+	children, err = kbfsOps.GetDirChildren(ctx, rootNode)
+	require.NoError(t, err)
+	require.Len(t, children, 0)
+}
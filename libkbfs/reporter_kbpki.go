@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/keybase/client/go/logger"
@@ -74,7 +75,9 @@ var noErrorNames = map[string]bool{
 
 // ReporterKBPKI implements the Notify function of the Reporter
 // interface in addition to embedding ReporterSimple for error
-// tracking.  Notify will make RPCs to the keybase daemon.
+// tracking.  Notify passes notifications on to the registered
+// NotificationSinks, which by default is only the GUI, through the
+// keybase daemon.
 type ReporterKBPKI struct {
 	*ReporterSimple
 	config           Config
@@ -83,6 +86,14 @@ type ReporterKBPKI struct {
 	notifyPathBuffer chan string
 	notifySyncBuffer chan *keybase1.FSPathSyncStatus
 	canceler         func()
+
+	sinksLock sync.RWMutex
+	sinks     map[string]registeredNotificationSink
+}
+
+type registeredNotificationSink struct {
+	sink        NotificationSink
+	minSeverity NotificationSeverity
 }
 
 // NewReporterKBPKI creates a new ReporterKBPKI.
@@ -94,6 +105,10 @@ func NewReporterKBPKI(config Config, maxErrors, bufSize int) *ReporterKBPKI {
 		notifyBuffer:     make(chan *keybase1.FSNotification, bufSize),
 		notifyPathBuffer: make(chan string, 1),
 		notifySyncBuffer: make(chan *keybase1.FSPathSyncStatus, 1),
+		sinks: map[string]registeredNotificationSink{
+			GUINotificationSinkName: {
+				guiNotificationSink{config}, NotificationInfo},
+		},
 	}
 	var ctx context.Context
 	ctx, r.canceler = context.WithCancel(context.Background())
@@ -197,6 +212,58 @@ func (r *ReporterKBPKI) ReportErr(ctx context.Context,
 	}
 }
 
+// RegisterNotificationSink starts sending notifications of at least
+// `minSeverity` to `sink`, replacing any sink already registered
+// under `name`.
+func (r *ReporterKBPKI) RegisterNotificationSink(name string,
+	sink NotificationSink, minSeverity NotificationSeverity) {
+	r.sinksLock.Lock()
+	defer r.sinksLock.Unlock()
+	r.sinks[name] = registeredNotificationSink{sink, minSeverity}
+}
+
+// UnregisterNotificationSink stops sending notifications to the sink
+// registered under `name`, if any.
+func (r *ReporterKBPKI) UnregisterNotificationSink(name string) {
+	r.sinksLock.Lock()
+	defer r.sinksLock.Unlock()
+	delete(r.sinks, name)
+}
+
+// NotificationSinks returns the names of the registered sinks, with
+// the lowest severity each one gets.
+func (r *ReporterKBPKI) NotificationSinks() map[string]NotificationSeverity {
+	r.sinksLock.RLock()
+	defer r.sinksLock.RUnlock()
+	sinks := make(map[string]NotificationSeverity, len(r.sinks))
+	for name, rs := range r.sinks {
+		sinks[name] = rs.minSeverity
+	}
+	return sinks
+}
+
+// dispatch sends `notification` to every sink that wants it.
+func (r *ReporterKBPKI) dispatch(
+	ctx context.Context, notification *keybase1.FSNotification) {
+	severity := notificationSeverity(notification)
+	sinks := make(map[string]NotificationSink)
+	func() {
+		r.sinksLock.RLock()
+		defer r.sinksLock.RUnlock()
+		for name, rs := range r.sinks {
+			if severity >= rs.minSeverity {
+				sinks[name] = rs.sink
+			}
+		}
+	}()
+	for name, sink := range sinks {
+		if err := sink.Notify(ctx, notification, severity); err != nil {
+			r.log.CDebugf(ctx, "ReporterDaemon: error sending "+
+				"notification to %s: %s", name, err)
+		}
+	}
+}
+
 // Notify implements the Reporter interface for ReporterKBPKI.
 //
 // TODO: might be useful to get the debug tags out of ctx and store
@@ -250,7 +317,8 @@ func (r *ReporterKBPKI) Shutdown() {
 const reporterSendInterval = time.Second
 
 // send takes notifications out of notifyBuffer, notifyPathBuffer, and
-// notifySyncBuffer and sends them to the keybase daemon.
+// notifySyncBuffer, and sends them to the notification sinks and the
+// keybase daemon respectively.
 func (r *ReporterKBPKI) send(ctx context.Context) {
 	sendTicker := time.NewTicker(reporterSendInterval)
 	defer sendTicker.Stop()
@@ -261,23 +329,9 @@ func (r *ReporterKBPKI) send(ctx context.Context) {
 			if !ok {
 				return
 			}
-			nt := notification.NotificationType
-			st := notification.StatusCode
-			// Only these notifications are used in frontend:
-			// https://github.com/keybase/client/blob/0d63795105f64289ba4ef20fbefe56aad91bc7e9/shared/util/kbfs-notifications.js#L142-L154
-			if nt != keybase1.FSNotificationType_REKEYING &&
-				nt != keybase1.FSNotificationType_INITIALIZED &&
-				nt != keybase1.FSNotificationType_CONNECTION &&
-				st != keybase1.FSStatusCode_ERROR {
-				continue
-			}
 			// Send them right away rather than staging it and waiting for the
 			// ticker, since each of them can be distinct from each other.
-			if err := r.config.KeybaseService().Notify(ctx,
-				notification); err != nil {
-				r.log.CDebugf(ctx, "ReporterDaemon: error sending "+
-					"notification: %s", err)
-			}
+			r.dispatch(ctx, notification)
 		case <-sendTicker.C:
 			select {
 			case path, ok := <-r.notifyPathBuffer:
@@ -0,0 +1,179 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sync"
+	"time"
+
+	kbname "github.com/keybase/client/go/kbun"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/go-codec/codec"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	resolverCacheFolderName = "kbfs_resolver"
+
+	// resolverCacheTTL is how long a cached resolution is trusted
+	// without asking the service again.  Older resolutions are only
+	// used when the service can't be reached.
+	resolverCacheTTL = 1 * time.Hour
+	// resolverCacheMaxStaleness is how old a cached resolution can
+	// get before it's not used at all, even when the service can't
+	// be reached, so that a revoked proof isn't trusted forever.
+	resolverCacheMaxStaleness = 7 * 24 * time.Hour
+)
+
+// resolverCacheGetter is implemented by configs that can persist
+// the resolutions of assertions.
+type resolverCacheGetter interface {
+	// resolverCache returns nil if resolutions aren't persisted.
+	resolverCache() *diskResolverCache
+}
+
+// getResolverCache returns the persistent resolver cache of `owner`,
+// or nil if it doesn't have one.
+func getResolverCache(owner interface{}) *diskResolverCache {
+	rcg, ok := owner.(resolverCacheGetter)
+	if !ok {
+		return nil
+	}
+	return rcg.resolverCache()
+}
+
+// resolverCacheEntry is a persisted resolution of an assertion.
+type resolverCacheEntry struct {
+	Name kbname.NormalizedUsername
+	ID   keybase1.UserOrTeamID
+	// Resolved is when the service last resolved the assertion.
+	Resolved time.Time
+
+	codec.UnknownFieldSetHandler
+}
+
+// diskResolverCache persistently remembers how the service resolved
+// the user and team assertions in folder handles, so that folders
+// that were accessed before can be opened without a round trip to
+// the service, or without the service at all when it's unreachable.
+//
+// Entries are dropped once the service says the assertion doesn't
+// resolve anymore, and entries for a user or team are dropped when
+// the service notifies us that the user's keys or the team's name
+// changed, since that may have revoked the proofs behind a social
+// assertion.
+type diskResolverCache struct {
+	codec       kbfscodec.Codec
+	clockGetter clockGetter
+
+	lock sync.RWMutex
+	db   *levelDb // nil after shutdown
+}
+
+func newDiskResolverCache(codec kbfscodec.Codec, clockGetter clockGetter,
+	db *levelDb) *diskResolverCache {
+	return &diskResolverCache{
+		codec:       codec,
+		clockGetter: clockGetter,
+		db:          db,
+	}
+}
+
+// get returns the cached resolution of `assertion`, and whether it's
+// still fresh enough to be used without asking the service.
+// Resolutions older than resolverCacheMaxStaleness aren't returned.
+func (c *diskResolverCache) get(assertion string) (
+	entry resolverCacheEntry, ok, fresh bool, err error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.db == nil {
+		return resolverCacheEntry{}, false, false, nil
+	}
+	buf, err := c.db.Get([]byte(assertion), nil)
+	if errors.Cause(err) == leveldb.ErrNotFound {
+		return resolverCacheEntry{}, false, false, nil
+	} else if err != nil {
+		return resolverCacheEntry{}, false, false, err
+	}
+	err = c.codec.Decode(buf, &entry)
+	if err != nil {
+		return resolverCacheEntry{}, false, false, err
+	}
+	age := c.clockGetter.Clock().Now().Sub(entry.Resolved)
+	if age >= resolverCacheMaxStaleness {
+		return resolverCacheEntry{}, false, false, nil
+	}
+	return entry, true, age < resolverCacheTTL, nil
+}
+
+// put records that `assertion` resolved to the given user or team
+// just now.
+func (c *diskResolverCache) put(assertion string,
+	name kbname.NormalizedUsername, id keybase1.UserOrTeamID) error {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.db == nil {
+		return nil
+	}
+	buf, err := c.codec.Encode(resolverCacheEntry{
+		Name:     name,
+		ID:       id,
+		Resolved: c.clockGetter.Clock().Now(),
+	})
+	if err != nil {
+		return err
+	}
+	return c.db.Put([]byte(assertion), buf, nil)
+}
+
+// remove forgets the resolution of `assertion`.
+func (c *diskResolverCache) remove(assertion string) error {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.db == nil {
+		return nil
+	}
+	return errors.WithStack(c.db.Delete([]byte(assertion), nil))
+}
+
+// invalidate forgets every assertion that resolved to `id`.
+func (c *diskResolverCache) invalidate(id keybase1.UserOrTeamID) error {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.db == nil {
+		return nil
+	}
+	iter := c.db.NewIterator(nil, nil)
+	defer iter.Release()
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		var entry resolverCacheEntry
+		err := c.codec.Decode(iter.Value(), &entry)
+		if err != nil || entry.ID == id {
+			batch.Delete(append([]byte(nil), iter.Key()...))
+		}
+	}
+	if err := iter.Error(); err != nil {
+		return errors.WithStack(err)
+	}
+	if batch.Len() == 0 {
+		return nil
+	}
+	return errors.WithStack(c.db.Write(batch, nil))
+}
+
+// shutdown closes the persistent store.  After it's called, nothing
+// is cached.
+func (c *diskResolverCache) shutdown() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.db == nil {
+		return
+	}
+	_ = c.db.Close()
+	c.db = nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"errors"
+	"testing"
+
+	kbname "github.com/keybase/client/go/kbun"
+	"github.com/keybase/client/go/libkb"
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"golang.org/x/net/context"
+)
+
+type flakyResolveService struct {
+	KeybaseService
+	offline  bool
+	err      error
+	resolves int
+}
+
+func (s *flakyResolveService) Resolve(ctx context.Context, assertion string) (
+	kbname.NormalizedUsername, keybase1.UserOrTeamID, error) {
+	s.resolves++
+	if s.offline {
+		return "", "", libkb.AppStatusError{
+			Code: libkb.SCAPINetworkError,
+			Desc: "offline",
+		}
+	}
+	if s.err != nil {
+		return "", "", s.err
+	}
+	return s.KeybaseService.Resolve(ctx, assertion)
+}
+
+type resolverCacheTestOwner struct {
+	service *flakyResolveService
+	cache   *diskResolverCache
+}
+
+func (o resolverCacheTestOwner) KeybaseService() KeybaseService {
+	return o.service
+}
+
+func (o resolverCacheTestOwner) resolverCache() *diskResolverCache {
+	return o.cache
+}
+
+func TestDiskResolverCache(t *testing.T) {
+	codec := kbfscodec.NewMsgpack()
+	ldb, err := openLevelDB(storage.NewMemStorage())
+	require.NoError(t, err)
+	clock := newTestClockGetter()
+	cache := newDiskResolverCache(codec, clock, ldb)
+	defer cache.shutdown()
+
+	currentUID := keybase1.MakeTestUID(1)
+	users := MakeLocalUsers([]kbname.NormalizedUsername{"alice", "bob"})
+	service := &flakyResolveService{
+		KeybaseService: NewKeybaseDaemonMemory(currentUID, users, nil, codec),
+	}
+	c := NewKBPKIClient(
+		resolverCacheTestOwner{service, cache}, logger.NewTestLogger(t))
+	ctx := context.Background()
+
+	t.Log("A fresh resolution doesn't need the service")
+	name, id, err := c.Resolve(ctx, "alice")
+	require.NoError(t, err)
+	require.Equal(t, kbname.NormalizedUsername("alice"), name)
+	service.offline = true
+	name2, id2, err := c.Resolve(ctx, "alice")
+	require.NoError(t, err)
+	require.Equal(t, name, name2)
+	require.Equal(t, id, id2)
+	require.Equal(t, 1, service.resolves)
+
+	t.Log("A stale resolution is only used when the service fails")
+	clock.TestClock().Add(resolverCacheTTL)
+	_, id2, err = c.Resolve(ctx, "alice")
+	require.NoError(t, err)
+	require.Equal(t, id, id2)
+	require.Equal(t, 2, service.resolves)
+	service.offline = false
+	_, _, err = c.Resolve(ctx, "alice")
+	require.NoError(t, err)
+	require.Equal(t, 3, service.resolves)
+	_, _, err = c.Resolve(ctx, "alice")
+	require.NoError(t, err)
+	require.Equal(t, 3, service.resolves)
+
+	t.Log("Other errors aren't papered over with a stale resolution")
+	clock.TestClock().Add(resolverCacheTTL)
+	service.err = errors.New("rate limited")
+	_, _, err = c.Resolve(ctx, "alice")
+	require.Equal(t, service.err, err)
+	service.err = nil
+
+	t.Log("Resolutions that are too old aren't used at all")
+	clock.TestClock().Add(resolverCacheMaxStaleness)
+	service.offline = true
+	_, _, err = c.Resolve(ctx, "alice")
+	require.True(t, isServiceConnectivityError(err))
+	service.offline = false
+	_, _, err = c.Resolve(ctx, "alice")
+	require.NoError(t, err)
+
+	t.Log("An assertion that stops resolving is forgotten")
+	err = cache.put("carol", "carol", keybase1.MakeTestUID(3).AsUserOrTeam())
+	require.NoError(t, err)
+	clock.TestClock().Add(resolverCacheTTL)
+	_, _, err = c.Resolve(ctx, "carol")
+	require.IsType(t, NoSuchUserError{}, err)
+	_, ok, _, err := cache.get("carol")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	t.Log("Invalidating a user forgets all of its resolutions")
+	_, bobID, err := c.Resolve(ctx, "bob")
+	require.NoError(t, err)
+	err = cache.put("bob@twitter", "bob", bobID)
+	require.NoError(t, err)
+	err = cache.invalidate(bobID)
+	require.NoError(t, err)
+	for _, assertion := range []string{"bob", "bob@twitter"} {
+		_, ok, _, err = cache.get(assertion)
+		require.NoError(t, err)
+		require.False(t, ok)
+	}
+	_, ok, _, err = cache.get("alice")
+	require.NoError(t, err)
+	require.True(t, ok)
+}
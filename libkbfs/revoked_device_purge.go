@@ -0,0 +1,105 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+)
+
+// PurgedFolder describes a folder whose locally-cached data was purged
+// because its keys still need to be rotated away from a revoked
+// device.
+type PurgedFolder struct {
+	Name  string
+	TlfID tlf.ID
+	// BlocksRemoved and BytesRemoved count what was deleted from the
+	// on-disk block caches.
+	BlocksRemoved int
+	BytesRemoved  int64
+	// RekeyRequested is true if a rekey was kicked off afterwards, to
+	// check that the keys get rotated.
+	RekeyRequested bool
+}
+
+// isKeyRotationPending returns true if the latest known revision of
+// this private folder is still keyed for a device that has since been
+// revoked, or if a rekey of the folder is otherwise outstanding.
+func (fbo *folderBranchOps) isKeyRotationPending(
+	ctx context.Context, head ImmutableRootMetadata) (bool, error) {
+	if head.TypeForKeying() != tlf.PrivateKeying {
+		return false, nil
+	}
+	if head.IsRekeySet() || fbo.config.RekeyQueue().IsRekeyPending(fbo.id()) {
+		return true, nil
+	}
+
+	writers, readers, err := head.getUserDevicePublicKeys()
+	if err != nil {
+		return false, err
+	}
+	for _, keys := range []kbfsmd.UserDevicePublicKeys{writers, readers} {
+		for uid, deviceKeys := range keys {
+			current, err := fbo.config.KBPKI().GetCryptPublicKeys(ctx, uid)
+			if err != nil {
+				return false, err
+			}
+			currentKeys := make(map[kbfscrypto.CryptPublicKey]bool, len(current))
+			for _, k := range current {
+				currentKeys[k] = true
+			}
+			for k := range deviceKeys {
+				if !currentKeys[k] {
+					fbo.log.CDebugf(ctx, "Folder is still keyed for "+
+						"revoked device %s of user %s", k, uid)
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
+// PurgeCachesPendingRekey deletes this folder's blocks from the
+// on-disk caches if its keys still need to be rotated, and then
+// requests a rekey.  It returns nothing if no purge was needed.
+func (fbo *folderBranchOps) PurgeCachesPendingRekey(ctx context.Context) (
+	purged []PurgedFolder, err error) {
+	fbo.log.CDebugf(ctx, "PurgeCachesPendingRekey")
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "PurgeCachesPendingRekey done: %d %+v",
+			len(purged), err)
+	}()
+
+	lState := makeFBOLockState()
+	head := fbo.getTrustedHead(lState)
+	if head == (ImmutableRootMetadata{}) {
+		return nil, nil
+	}
+	pending, err := fbo.isKeyRotationPending(ctx, head)
+	if err != nil || !pending {
+		return nil, err
+	}
+
+	p := PurgedFolder{
+		Name:  string(head.GetTlfHandle().GetCanonicalName()),
+		TlfID: fbo.id(),
+	}
+	if dbc, ok := fbo.config.DiskBlockCache().(*diskBlockCacheWrapped); ok {
+		p.BlocksRemoved, p.BytesRemoved, err =
+			dbc.deleteTLFBlocks(ctx, fbo.id())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if fbo.folderBranch.Branch == MasterBranch {
+		fbo.RequestRekey(ctx, fbo.id())
+		p.RekeyRequested = true
+	}
+	return []PurgedFolder{p}, nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPurgeCachesPendingRekey(t *testing.T) {
+	config, uid, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	tempdir, err := ioutil.TempDir(os.TempDir(), "purge_pending_rekey")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		assert.NoError(t, err)
+	}()
+	err = config.EnableDiskLimiter(tempdir)
+	require.NoError(t, err)
+	config.diskCacheMode = DiskCacheModeLocal
+	err = config.MakeDiskBlockCacheIfNotExists()
+	require.NoError(t, err)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps()
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+
+	t.Log("Nothing to purge while the keys are up to date")
+	purged, err := kbfsOps.PurgeCachesPendingRekey(ctx)
+	require.NoError(t, err)
+	require.Len(t, purged, 0)
+
+	t.Log("Key the folder for a second device")
+	AddDeviceForLocalUserOrBust(t, config, uid)
+	_, err = RequestRekeyAndWaitForOneFinishEvent(ctx, kbfsOps, fb.Tlf)
+	require.NoError(t, err)
+
+	dbc := config.DiskBlockCache().(*diskBlockCacheWrapped)
+	err = dbc.Put(ctx, fb.Tlf, kbfsblock.FakeID(1), []byte{1, 2, 3},
+		kbfscrypto.MakeBlockCryptKeyServerHalf([32]byte{1}))
+	require.NoError(t, err)
+
+	t.Log("Once the second device is revoked, the cache is purged")
+	RevokeDeviceForLocalUserOrBust(t, config, uid, 1)
+	purged, err = kbfsOps.PurgeCachesPendingRekey(ctx)
+	require.NoError(t, err)
+	require.Len(t, purged, 1)
+	require.Equal(t, fb.Tlf, purged[0].TlfID)
+	require.Equal(t, "alice", purged[0].Name)
+	require.True(t, purged[0].BlocksRemoved >= 1)
+	require.True(t, purged[0].RekeyRequested)
+	ids, err := dbc.workingSetCache.getBlockIDsForTLF(ctx, fb.Tlf)
+	require.NoError(t, err)
+	require.Len(t, ids, 0)
+
+	t.Log("After the rekey, there's nothing left to purge")
+	_, err = RequestRekeyAndWaitForOneFinishEvent(ctx, kbfsOps, fb.Tlf)
+	require.NoError(t, err)
+	purged, err = kbfsOps.PurgeCachesPendingRekey(ctx)
+	require.NoError(t, err)
+	require.Len(t, purged, 0)
+}
@@ -248,7 +248,15 @@ func (md *RootMetadata) MakeSuccessor(
 	handleCopy := md.tlfHandle.deepCopy()
 
 	newMd := makeRootMetadata(brmdCopy, extraCopy, handleCopy)
-	if err := kbfscodec.Update(codec, &newMd.data, md.data); err != nil {
+	data := md.data
+	if isReadableAndWriter {
+		// The block changes are cleared below anyway, so don't pay to
+		// copy them.  They can be arbitrarily large (e.g., after
+		// populating a huge directory), and would otherwise make the
+		// next write cost time proportional to the previous one.
+		data.Changes = BlockChanges{}
+	}
+	if err := kbfscodec.Update(codec, &newMd.data, data); err != nil {
 		return nil, err
 	}
 
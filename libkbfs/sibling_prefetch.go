@@ -0,0 +1,195 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sort"
+	"sync"
+
+	metrics "github.com/rcrowley/go-metrics"
+	"golang.org/x/net/context"
+)
+
+const (
+	// siblingPrefetchMinRun is how many files in a directory must be
+	// opened in name order before the next ones are prefetched.
+	siblingPrefetchMinRun = 3
+	// siblingPrefetchCount is how many of the following files are
+	// prefetched once a run is noticed.
+	siblingPrefetchCount = 3
+	// maxSiblingPrefetchDirs bounds how many directories' access
+	// patterns are remembered for each TLF.
+	maxSiblingPrefetchDirs = 20
+	// siblingPrefetchPriority is high enough for the prefetched file
+	// blocks to trigger prefetches of their own child blocks.
+	siblingPrefetchPriority = lowestTriggerPrefetchPriority
+)
+
+// siblingAccess is what's remembered about the files opened in one
+// directory.
+type siblingAccess struct {
+	last string
+	run  int
+	// prefetched holds the files prefetched, but not yet opened.
+	prefetched map[string]bool
+}
+
+// siblingPrefetcher notices when files in a directory are opened one
+// after another in name order, like a photo viewer or media player
+// stepping through a folder, and picks the next few files to
+// prefetch.
+type siblingPrefetcher struct {
+	lock  sync.Mutex
+	dirs  map[NodeID]*siblingAccess
+	order []NodeID // oldest first
+
+	prefetched metrics.Counter
+	used       metrics.Counter
+	wasted     metrics.Counter
+}
+
+func newSiblingPrefetcher(registry metrics.Registry) *siblingPrefetcher {
+	sp := &siblingPrefetcher{
+		dirs: make(map[NodeID]*siblingAccess),
+	}
+	if registry != nil {
+		sp.prefetched = metrics.GetOrRegisterCounter(
+			"SiblingPrefetch.Prefetched", registry)
+		sp.used = metrics.GetOrRegisterCounter(
+			"SiblingPrefetch.Used", registry)
+		sp.wasted = metrics.GetOrRegisterCounter(
+			"SiblingPrefetch.Wasted", registry)
+	} else {
+		sp.prefetched = metrics.NewCounter()
+		sp.used = metrics.NewCounter()
+		sp.wasted = metrics.NewCounter()
+	}
+	return sp
+}
+
+func (sp *siblingPrefetcher) wasteLocked(sa *siblingAccess, name string) {
+	delete(sa.prefetched, name)
+	sp.wasted.Inc(1)
+}
+
+// noteOpen records that the file `name` in directory `dir` was
+// opened, and returns true if the files after it should be
+// prefetched.
+func (sp *siblingPrefetcher) noteOpen(dir NodeID, name string) bool {
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+	sa, ok := sp.dirs[dir]
+	if !ok {
+		sa = &siblingAccess{prefetched: make(map[string]bool)}
+		sp.dirs[dir] = sa
+		sp.order = append(sp.order, dir)
+		if len(sp.order) > maxSiblingPrefetchDirs {
+			oldest := sp.order[0]
+			sp.order = sp.order[1:]
+			for n := range sp.dirs[oldest].prefetched {
+				sp.wasteLocked(sp.dirs[oldest], n)
+			}
+			delete(sp.dirs, oldest)
+		}
+	}
+	if name == sa.last {
+		return false
+	}
+
+	if sa.prefetched[name] {
+		delete(sa.prefetched, name)
+		sp.used.Inc(1)
+	}
+	if sa.last != "" && name > sa.last {
+		sa.run++
+	} else {
+		sa.run = 1
+	}
+	sa.last = name
+	for n := range sa.prefetched {
+		// Anything skipped over, or left behind by a jump backwards,
+		// won't be opened as part of this run.
+		if sa.run == 1 || n < name {
+			sp.wasteLocked(sa, n)
+		}
+	}
+	return sa.run >= siblingPrefetchMinRun
+}
+
+// pick chooses up to siblingPrefetchCount of the files in `entries`
+// that follow `name`, skipping ones already prefetched, and records
+// them as prefetched.
+func (sp *siblingPrefetcher) pick(dir NodeID, name string,
+	entries map[string]DirEntry) (names []string) {
+	var next []string
+	for n, de := range entries {
+		if n > name && (de.Type == File || de.Type == Exec) {
+			next = append(next, n)
+		}
+	}
+	sort.Strings(next)
+	if len(next) > siblingPrefetchCount {
+		next = next[:siblingPrefetchCount]
+	}
+
+	sp.lock.Lock()
+	defer sp.lock.Unlock()
+	sa, ok := sp.dirs[dir]
+	if !ok {
+		return nil
+	}
+	for _, n := range next {
+		if sa.prefetched[n] {
+			continue
+		}
+		sa.prefetched[n] = true
+		sp.prefetched.Inc(1)
+		names = append(names, n)
+	}
+	return names
+}
+
+// maybePrefetchSiblings notes that `file` was just opened for
+// reading, and if it continues a run of files opened in name order,
+// prefetches the blocks of the next few files in its directory.
+func (fbo *folderBranchOps) maybePrefetchSiblings(ctx context.Context,
+	lState *lockState, kmd KeyMetadata, file Node) {
+	if !fbo.config.SiblingPrefetchEnabled() {
+		return
+	}
+	filePath, err := fbo.pathFromNodeForRead(file)
+	if err != nil || !filePath.hasValidParent() {
+		return
+	}
+	parentPath := *filePath.parentPath()
+	dir := fbo.nodeCache.Get(parentPath.tailPointer().Ref())
+	if dir == nil {
+		return
+	}
+	name := filePath.tailName()
+	if !fbo.siblingPrefetch.noteOpen(dir.GetID(), name) {
+		return
+	}
+
+	entries, err := fbo.blocks.GetEntries(ctx, lState, kmd, parentPath)
+	if err != nil {
+		fbo.log.CDebugf(ctx, "Couldn't get siblings of %s to prefetch: %+v",
+			name, err)
+		return
+	}
+	names := fbo.siblingPrefetch.pick(dir.GetID(), name, entries)
+	if len(names) == 0 {
+		return
+	}
+	fbo.log.CDebugf(ctx, "Prefetching the files after %s: %v", name, names)
+	// Use a fresh context, in case `ctx` is canceled by the caller
+	// before the prefetches complete.
+	prefetchCtx := fbo.ctxWithFBOID(context.Background())
+	for _, n := range names {
+		_ = fbo.config.BlockOps().BlockRetriever().Request(prefetchCtx,
+			siblingPrefetchPriority, kmd, entries[n].BlockPointer,
+			NewFileBlock(), TransientEntry)
+	}
+}
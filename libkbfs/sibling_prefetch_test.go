@@ -0,0 +1,136 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSiblingPrefetcherPattern(t *testing.T) {
+	sp := newSiblingPrefetcher(nil)
+	dir := NodeID(&nodeCore{})
+	entries := make(map[string]DirEntry)
+	for _, n := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		entries[n] = DirEntry{EntryInfo: EntryInfo{Type: File}}
+	}
+	entries["cc"] = DirEntry{EntryInfo: EntryInfo{Type: Dir}}
+
+	require.False(t, sp.noteOpen(dir, "a"))
+	require.False(t, sp.noteOpen(dir, "b"))
+	t.Log("Reading the same file again doesn't count")
+	require.False(t, sp.noteOpen(dir, "b"))
+	require.True(t, sp.noteOpen(dir, "c"))
+	require.Equal(t, []string{"d", "e", "f"}, sp.pick(dir, "c", entries))
+
+	t.Log("Only new files get prefetched as the run goes on")
+	require.True(t, sp.noteOpen(dir, "d"))
+	require.Equal(t, []string{"g"}, sp.pick(dir, "d", entries))
+	require.Equal(t, int64(4), sp.prefetched.Count())
+	require.Equal(t, int64(1), sp.used.Count())
+
+	t.Log("Skipped files are wasted")
+	require.True(t, sp.noteOpen(dir, "f"))
+	require.Equal(t, int64(2), sp.used.Count())
+	require.Equal(t, int64(1), sp.wasted.Count())
+
+	t.Log("Going backwards ends the run")
+	require.False(t, sp.noteOpen(dir, "a"))
+	require.Equal(t, int64(2), sp.wasted.Count())
+}
+
+func TestSiblingPrefetch(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "test_user")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", tlf.Private)
+	kbfsOps := config.KBFSOps()
+	dirNode, _, err := kbfsOps.CreateDir(ctx, rootNode, "photos")
+	require.NoError(t, err)
+	// Big enough to need several blocks each.
+	data := make([]byte, 200<<10)
+	var fileNodes []Node
+	for i := 0; i < 6; i++ {
+		n, _, err := kbfsOps.CreateFile(
+			ctx, dirNode, fmt.Sprintf("%03d.jpg", i), false, NoExcl)
+		require.NoError(t, err)
+		data[0] = byte(i)
+		require.NoError(t, kbfsOps.Write(ctx, n, data, 0))
+		require.NoError(t, kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch()))
+		fileNodes = append(fileNodes, n)
+	}
+
+	ops := kbfsOps.(*KBFSOpsStandard).getOpsByNode(ctx, rootNode)
+	lState := makeFBOLockState()
+	md, err := ops.getMDForReadNeedIdentify(ctx, lState)
+	require.NoError(t, err)
+	var lastInfos []BlockInfo
+	for _, n := range fileNodes[3:] {
+		p, err := ops.pathFromNodeForRead(n)
+		require.NoError(t, err)
+		infos, err := ops.blocks.GetIndirectFileBlockInfos(
+			ctx, lState, md.ReadOnly(), p)
+		require.NoError(t, err)
+		require.True(t, len(infos) > 1)
+		lastInfos = append(lastInfos, infos...)
+	}
+
+	t.Log("Open the first few files on a fresh device, in order")
+	config2 := ConfigAsUser(config, "test_user")
+	defer CheckConfigAndShutdown(ctx, t, config2)
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, "test_user", tlf.Private)
+	kbfsOps2 := config2.KBFSOps()
+	dirNode2, _, err := kbfsOps2.Lookup(ctx, rootNode2, "photos")
+	require.NoError(t, err)
+	buf := make([]byte, 10)
+	for i := 0; i < 3; i++ {
+		n, _, err := kbfsOps2.Lookup(ctx, dirNode2, fmt.Sprintf("%03d.jpg", i))
+		require.NoError(t, err)
+		_, err = kbfsOps2.Read(ctx, n, buf, 0)
+		require.NoError(t, err)
+	}
+
+	t.Log("The rest of the files get prefetched")
+	ops2 := kbfsOps2.(*KBFSOpsStandard).getOpsByNode(ctx, rootNode2)
+	require.Equal(t, int64(3), ops2.siblingPrefetch.prefetched.Count())
+	for _, info := range lastInfos {
+		for {
+			_, err := config2.BlockCache().Get(info.BlockPointer)
+			if err == nil {
+				break
+			}
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-ctx.Done():
+				t.Fatal(ctx.Err())
+			}
+		}
+	}
+}
+
+func TestSiblingPrefetchDisabled(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "test_user")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	config.SetSiblingPrefetchEnabled(false)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", tlf.Private)
+	kbfsOps := config.KBFSOps()
+	for i := 0; i < 5; i++ {
+		n, _, err := kbfsOps.CreateFile(
+			ctx, rootNode, fmt.Sprintf("%d", i), false, NoExcl)
+		require.NoError(t, err)
+		require.NoError(t, kbfsOps.Write(ctx, n, []byte{1}, 0))
+		_, err = kbfsOps.Read(ctx, n, make([]byte, 1), 0)
+		require.NoError(t, err)
+	}
+
+	ops := kbfsOps.(*KBFSOpsStandard).getOpsByNode(ctx, rootNode)
+	require.Equal(t, int64(0), ops.siblingPrefetch.prefetched.Count())
+	require.NoError(t, kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch()))
+}
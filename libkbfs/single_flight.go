@@ -0,0 +1,148 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+	"golang.org/x/net/context"
+)
+
+// singleFlightCall is a call to singleFlight.do that is in progress,
+// or finished.
+type singleFlightCall struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+
+	// waiters is the number of callers still waiting for the
+	// call, protected by singleFlight.lock.  When it drops to
+	// zero, the call is canceled.
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// singleFlight lets concurrent callers asking for the same thing
+// share one call, rather than each making their own, e.g. when
+// several processes stat the same cold path at once.
+type singleFlight struct {
+	// deduped counts the callers that waited for another caller's
+	// call rather than making their own.
+	deduped metrics.Counter
+
+	lock  sync.Mutex
+	calls map[string]*singleFlightCall
+}
+
+// ctxSingleFlightKeyType is the type of the context key marking
+// contexts that calls of a singleFlight run under.
+type ctxSingleFlightKeyType int
+
+const ctxSingleFlightKey ctxSingleFlightKeyType = iota
+
+// detachedContext has the values of the context it wraps, like log
+// tags, but none of its deadline or cancellation.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (deadline time.Time, ok bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func newSingleFlight(deduped metrics.Counter) *singleFlight {
+	return &singleFlight{
+		deduped: deduped,
+		calls:   make(map[string]*singleFlightCall),
+	}
+}
+
+// do calls `fn` and returns its results, unless a call for `key` is
+// already in progress, in which case it waits for that call's
+// results instead.  The call doesn't belong to any one caller: it
+// runs in the background, under a context with the values of the
+// caller that started it but not its deadline, and it is only
+// canceled once every caller waiting for it has given up.
+//
+// Calls made from within `fn`, as told by the context passed to it,
+// run directly under that context and never wait for other calls,
+// since two calls waiting for each other would never finish.
+func (sf *singleFlight) do(ctx context.Context, key string,
+	fn func(context.Context) (interface{}, error)) (interface{}, error) {
+	if ctx.Value(ctxSingleFlightKey) == sf {
+		return fn(ctx)
+	}
+	sf.lock.Lock()
+	c, ok := sf.calls[key]
+	if ok {
+		c.waiters++
+		sf.lock.Unlock()
+		sf.deduped.Inc(1)
+	} else {
+		callCtx, cancel := context.WithCancel(context.WithValue(
+			detachedContext{ctx}, ctxSingleFlightKey, sf))
+		c = &singleFlightCall{
+			done:    make(chan struct{}),
+			waiters: 1,
+			cancel:  cancel,
+		}
+		sf.calls[key] = c
+		sf.lock.Unlock()
+
+		go func() {
+			val, err := fn(callCtx)
+			sf.lock.Lock()
+			defer sf.lock.Unlock()
+			if sf.calls[key] == c {
+				delete(sf.calls, key)
+			}
+			c.val, c.err = val, err
+			close(c.done)
+			cancel()
+		}()
+	}
+
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+		sf.lock.Lock()
+		defer sf.lock.Unlock()
+		c.waiters--
+		if c.waiters == 0 {
+			// Nobody wants the results anymore, and nobody new
+			// can join the call once it's forgotten.
+			if sf.calls[key] == c {
+				delete(sf.calls, key)
+			}
+			c.cancel()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// forgetPrefix makes callers that come after it make new calls for
+// the keys starting with `prefix`, rather than wait for the calls
+// already in progress, e.g. because the data they fetch has changed.
+func (sf *singleFlight) forgetPrefix(prefix string) {
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
+	for key := range sf.calls {
+		if strings.HasPrefix(key, prefix) {
+			delete(sf.calls, key)
+		}
+	}
+}
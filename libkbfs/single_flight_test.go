@@ -0,0 +1,156 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+// waitForDeduped waits until `n` callers of `sf` have joined another
+// caller's call.
+func waitForDeduped(t *testing.T, sf *singleFlight, n int64) {
+	for i := 0; sf.deduped.Count() < n; i++ {
+		require.True(t, i < 1000, "Callers never joined the call")
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSingleFlightShares(t *testing.T) {
+	sf := newSingleFlight(metrics.NewCounter())
+	ctx := context.Background()
+
+	release := make(chan struct{})
+	calls := 0
+	fn := func(context.Context) (interface{}, error) {
+		calls++
+		<-release
+		return calls, nil
+	}
+	results := make(chan interface{}, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			val, err := sf.do(ctx, "a", fn)
+			require.NoError(t, err)
+			results <- val
+		}()
+	}
+	waitForDeduped(t, sf, 2)
+	close(release)
+	for i := 0; i < 3; i++ {
+		require.Equal(t, 1, <-results)
+	}
+
+	t.Log("A finished call isn't shared with later callers")
+	val, err := sf.do(ctx, "a", fn)
+	require.NoError(t, err)
+	require.Equal(t, 2, val)
+}
+
+func TestSingleFlightCanceledCaller(t *testing.T) {
+	sf := newSingleFlight(metrics.NewCounter())
+	ctx := context.Background()
+	cancelCtx, cancel := context.WithCancel(ctx)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := sf.do(cancelCtx, "a", func(ctx context.Context) (interface{}, error) {
+			close(started)
+			select {
+			case <-release:
+				return "shared", nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		})
+		errCh <- err
+	}()
+	<-started
+
+	valCh := make(chan interface{}, 1)
+	go func() {
+		val, err := sf.do(ctx, "a", func(context.Context) (interface{}, error) {
+			return "mine", nil
+		})
+		require.NoError(t, err)
+		valCh <- val
+	}()
+	waitForDeduped(t, sf, 1)
+	cancel()
+	require.Equal(t, context.Canceled, <-errCh)
+
+	t.Log("The call keeps going for the caller still waiting")
+	close(release)
+	require.Equal(t, "shared", <-valCh)
+}
+
+func TestSingleFlightAllCallersCanceled(t *testing.T) {
+	sf := newSingleFlight(metrics.NewCounter())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+
+	callErrCh := make(chan error, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := sf.do(ctx, "a", func(ctx context.Context) (interface{}, error) {
+			_, hasDeadline := ctx.Deadline()
+			require.False(t, hasDeadline)
+			<-ctx.Done()
+			callErrCh <- ctx.Err()
+			return nil, ctx.Err()
+		})
+		errCh <- err
+	}()
+	cancel()
+	require.Equal(t, context.Canceled, <-errCh)
+	t.Log("The call is canceled once nobody is waiting for it")
+	require.Equal(t, context.Canceled, <-callErrCh)
+}
+
+func TestSingleFlightForgetPrefix(t *testing.T) {
+	sf := newSingleFlight(metrics.NewCounter())
+	ctx := context.Background()
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go func() {
+		_, _ = sf.do(ctx, "tlf1 head", func(context.Context) (interface{}, error) {
+			close(started)
+			<-release
+			return "stale", nil
+		})
+	}()
+	<-started
+	sf.forgetPrefix("tlf1 ")
+
+	val, err := sf.do(ctx, "tlf1 head", func(context.Context) (interface{}, error) {
+		return "fresh", nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "fresh", val)
+	require.Equal(t, int64(0), sf.deduped.Count())
+	close(release)
+}
+
+func TestSingleFlightNested(t *testing.T) {
+	sf := newSingleFlight(metrics.NewCounter())
+	ctx := context.Background()
+
+	t.Log("A call for a key in progress, made from within that call, " +
+		"doesn't wait for itself")
+	val, err := sf.do(ctx, "a", func(ctx context.Context) (interface{}, error) {
+		return sf.do(ctx, "a", func(context.Context) (interface{}, error) {
+			return "nested", nil
+		})
+	})
+	require.NoError(t, err)
+	require.Equal(t, "nested", val)
+	require.Equal(t, int64(0), sf.deduped.Count())
+}
@@ -0,0 +1,90 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"golang.org/x/net/context"
+)
+
+// SmallReadMaxSizeDefault is the default size limit for files that
+// callers read with LookupAndReadSmall, like config files.
+const SmallReadMaxSizeDefault = 64 << 10
+
+// lookupAndReadSmall looks up `name` in `dir` and, if it's a regular
+// file of at most `maxSize` bytes, reads all of it, using the same
+// MD for both steps.
+func (fbo *folderBranchOps) lookupAndReadSmall(
+	ctx context.Context, dir Node, name string, maxSize int64) (
+	node Node, de DirEntry, data []byte, err error) {
+	node, de, err = fbo.lookup(ctx, dir, name)
+	if err != nil {
+		return nil, DirEntry{}, nil, err
+	}
+	if (de.Type != File && de.Type != Exec) || de.Size > uint64(maxSize) {
+		return node, de, nil, nil
+	}
+	if fsFile := node.GetFile(ctx); fsFile != nil {
+		// Leave FS-backed files to the regular read path.
+		fsFile.Close()
+		return node, de, nil, nil
+	}
+
+	lState := makeFBOLockState()
+	md, err := fbo.getMDForReadNeedIdentify(ctx, lState)
+	if err != nil {
+		return nil, DirEntry{}, nil, err
+	}
+	data = make([]byte, de.Size)
+	n, err := fbo.blocks.Read(ctx, lState, md.ReadOnly(), node, data, 0)
+	if err != nil {
+		return nil, DirEntry{}, nil, err
+	}
+	return node, de, data[:n], nil
+}
+
+// LookupAndReadSmall implements the KBFSOps interface for
+// folderBranchOps.
+func (fbo *folderBranchOps) LookupAndReadSmall(
+	ctx context.Context, dir Node, name string, maxSize int64) (
+	node Node, ei EntryInfo, data []byte, err error) {
+	fbo.log.CDebugf(ctx, "LookupAndReadSmall %s %s %d",
+		getNodeIDStr(dir), name, maxSize)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "LookupAndReadSmall %s %s done: "+
+			"%v (n=%d) %+v", getNodeIDStr(dir), name,
+			getNodeIDStr(node), len(data), err)
+	}()
+
+	err = fbo.checkNode(dir)
+	if err != nil {
+		return nil, EntryInfo{}, nil, err
+	}
+
+	if dir.ShouldRetryOnDirRead(ctx) {
+		// The lookup might need a retry, which only the regular
+		// path knows how to do.
+		node, ei, err = fbo.Lookup(ctx, dir, name)
+		return node, ei, nil, err
+	}
+
+	// It's racy for the goroutine to write directly to the return
+	// params, so use new ones.
+	var n Node
+	var de DirEntry
+	var buf []byte
+	err = runUnlessCanceled(ctx, func() error {
+		var err error
+		n, de, buf, err = fbo.lookupAndReadSmall(ctx, dir, name, maxSize)
+		return err
+	})
+	if err != nil {
+		return nil, EntryInfo{}, nil, err
+	}
+	if buf != nil {
+		fbo.recordRecentFile(ctx, n, false)
+		fbo.recordAccess(ctx, n)
+	}
+	return n, de.EntryInfo, buf, nil
+}
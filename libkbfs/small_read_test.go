@@ -0,0 +1,60 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupAndReadSmall(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "test_user")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "test_user", tlf.Private)
+	kbfsOps := config.KBFSOps()
+	fileNode, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps.Write(ctx, fileNode, []byte("hello"), 0))
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "empty", false, NoExcl)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateDir(ctx, rootNode, "d")
+	require.NoError(t, err)
+
+	t.Log("Unsynced data is returned")
+	n, ei, data, err := kbfsOps.LookupAndReadSmall(ctx, rootNode, "a", 10)
+	require.NoError(t, err)
+	require.Equal(t, fileNode, n)
+	require.Equal(t, uint64(5), ei.Size)
+	require.Equal(t, "hello", string(data))
+
+	require.NoError(t, kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch()))
+	_, _, data, err = kbfsOps.LookupAndReadSmall(ctx, rootNode, "a", 10)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+
+	t.Log("Empty files have empty, non-nil data")
+	_, _, data, err = kbfsOps.LookupAndReadSmall(ctx, rootNode, "empty", 10)
+	require.NoError(t, err)
+	require.NotNil(t, data)
+	require.Len(t, data, 0)
+
+	t.Log("Big files and directories are only looked up")
+	n, ei, data, err = kbfsOps.LookupAndReadSmall(ctx, rootNode, "a", 4)
+	require.NoError(t, err)
+	require.Equal(t, fileNode, n)
+	require.Equal(t, uint64(5), ei.Size)
+	require.Nil(t, data)
+	n, ei, data, err = kbfsOps.LookupAndReadSmall(ctx, rootNode, "d", 10)
+	require.NoError(t, err)
+	require.NotNil(t, n)
+	require.Equal(t, Dir, ei.Type)
+	require.Nil(t, data)
+
+	_, _, _, err = kbfsOps.LookupAndReadSmall(ctx, rootNode, "b", 10)
+	require.IsType(t, NoSuchNameError{}, err)
+}
@@ -0,0 +1,172 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// SparseSyncFileName is the name of the file, at the root of a TLF,
+// that lists the files to sync sparsely when the TLF is synced, in
+// the same syntax as KBFSIgnoreFileName.  Rather than being
+// prefetched in full, a sparsely-synced file only has the blocks
+// that are actually read kept in the sync cache, along with any
+// ranges pinned with KBFSOps.PinFileRange, e.g. the start of each
+// video in a folder of huge videos, for instant playback.
+const SparseSyncFileName = ".kbfs_sparse_sync"
+
+// pinFileRangeChunkSize is how much of a pinned range is read at
+// once.
+const pinFileRangeChunkSize = 1 << 20
+
+type sparseSyncGetter interface {
+	// SparseSync returns the rules for which files of the given
+	// TLF are synced sparsely, or nil if it has none.
+	SparseSync(tlfID tlf.ID) *KBFSIgnore
+	// isSparseSyncedBlock returns true if the given block belongs
+	// to a sparsely-synced file of the given TLF, so fetching it
+	// mustn't trigger prefetches.
+	isSparseSyncedBlock(tlfID tlf.ID, id kbfsblock.ID) bool
+	// addSparseSyncedBlocks records blocks of sparsely-synced
+	// files of the given TLF.
+	addSparseSyncedBlocks(tlfID tlf.ID, ids ...kbfsblock.ID)
+}
+
+type sparseSyncSetter interface {
+	// SetSparseSync sets the sparse sync rules for the given TLF,
+	// and forgets the blocks recorded under the old ones.  A nil
+	// `rules` clears them.
+	SetSparseSync(tlfID tlf.ID, rules *KBFSIgnore)
+}
+
+// isSparseSynced returns true if the file at `p` is synced sparsely.
+func (fbo *folderBranchOps) isSparseSynced(p path) bool {
+	ssg, ok := fbo.config.(sparseSyncGetter)
+	if !ok || !fbo.config.IsSyncedTlf(fbo.id()) {
+		return false
+	}
+	return ssg.SparseSync(fbo.id()).Match(p.tailName(), false)
+}
+
+// markSparseSynced records the top block of the file at `p`, if it's
+// synced sparsely, so that reading it only fetches the blocks that
+// are read.
+func (fbo *folderBranchOps) markSparseSynced(p path) {
+	if !fbo.isSparseSynced(p) {
+		return
+	}
+	fbo.config.(sparseSyncGetter).addSparseSyncedBlocks(
+		fbo.id(), p.tailPointer().ID)
+}
+
+// reloadSparseSync reads the sparse sync file at the root of the
+// TLF, if there is one, and records its rules in the config for the
+// prefetcher to use.
+func (fbo *folderBranchOps) reloadSparseSync() {
+	setter, ok := fbo.config.(sparseSyncSetter)
+	if !ok {
+		return
+	}
+	err := fbo.runUnlessShutdown(func(ctx context.Context) error {
+		data, err := fbo.readRootRulesFile(
+			ctx, SparseSyncFileName, maxKBFSIgnoreSize)
+		if err != nil {
+			return err
+		}
+		var rules *KBFSIgnore
+		if data != nil {
+			rules = ParseKBFSIgnore(data)
+		}
+		if ssg, ok := fbo.config.(sparseSyncGetter); ok &&
+			sameKBFSIgnore(ssg.SparseSync(fbo.id()), rules) {
+			return nil
+		}
+		setter.SetSparseSync(fbo.id(), rules)
+		return nil
+	})
+	if err != nil {
+		fbo.log.CDebugf(nil, "Couldn't load %s: %+v", SparseSyncFileName, err)
+	}
+}
+
+// sameKBFSIgnore returns true if `a` and `b` have the same patterns.
+func sameKBFSIgnore(a, b *KBFSIgnore) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if len(a.patterns) != len(b.patterns) {
+		return false
+	}
+	for i := range a.patterns {
+		if a.patterns[i] != b.patterns[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// PinFileRange fetches the blocks holding the `length` bytes of
+// `file` starting at `off` into the sync cache, for files of synced
+// TLFs that are synced sparsely.  Files that are synced in full are
+// kept in the sync cache anyway.
+func (fbo *folderBranchOps) PinFileRange(
+	ctx context.Context, file Node, off, length int64) (err error) {
+	fbo.log.CDebugf(ctx, "PinFileRange %s %d %d", getNodeIDStr(file),
+		off, length)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "PinFileRange %s %d %d done: %+v",
+			getNodeIDStr(file), off, length, err)
+	}()
+
+	err = fbo.checkNode(file)
+	if err != nil {
+		return err
+	}
+	if !fbo.config.IsSyncedTlf(fbo.id()) {
+		return errors.Errorf("Folder %s is not synced", fbo.id())
+	}
+	if off < 0 || length < 0 {
+		return errors.Errorf("Invalid range %d+%d", off, length)
+	}
+	filePath, err := fbo.pathFromNodeForRead(file)
+	if err != nil {
+		return err
+	}
+	fbo.markSparseSynced(filePath)
+
+	lState := makeFBOLockState()
+	md, err := fbo.getMDForReadNeedIdentify(ctx, lState)
+	if err != nil {
+		return err
+	}
+	chunkSize := int64(pinFileRangeChunkSize)
+	if length < chunkSize {
+		chunkSize = length
+	}
+	buf := make([]byte, chunkSize)
+	for length > 0 {
+		n := chunkSize
+		if length < n {
+			n = length
+		}
+		// Read directly from the block layer, so that pinning
+		// doesn't show up as a recently-accessed file.
+		read, err := fbo.blocks.Read(
+			ctx, lState, md.ReadOnly(), file, buf[:n], off)
+		if err != nil {
+			return err
+		}
+		if read < n {
+			// Past the end of the file.
+			return nil
+		}
+		off += read
+		length -= read
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSparseSync(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	tempdir, err := ioutil.TempDir(os.TempDir(), "sparse_sync")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		assert.NoError(t, err)
+	}()
+	err = config.EnableDiskLimiter(tempdir)
+	require.NoError(t, err)
+	config.diskCacheMode = DiskCacheModeLocal
+	err = config.MakeDiskBlockCacheIfNotExists()
+	require.NoError(t, err)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps()
+	ops := kbfsOps.(*KBFSOpsStandard).getOpsByNode(ctx, rootNode)
+
+	t.Log("Pinning needs a synced folder.")
+	video, _, err := kbfsOps.CreateFile(ctx, rootNode, "v.mp4", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, video, []byte("hello"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.PinFileRange(ctx, video, 0, 5)
+	require.Error(t, err)
+
+	err = config.SetTlfSyncState(fb.Tlf, true)
+	require.NoError(t, err)
+	n, _, err := kbfsOps.CreateFile(
+		ctx, rootNode, SparseSyncFileName, false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, n, []byte("*.mp4\n"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	ops.reloadSparseSync()
+	rules := config.SparseSync(fb.Tlf)
+	require.NotNil(t, rules)
+	require.True(t, rules.Match("v.mp4", false))
+	require.False(t, rules.Match("v.txt", false))
+
+	t.Log("The sync cache doesn't need to hold the sparsely-synced file.")
+	videoPath, err := ops.pathFromNodeForRead(video)
+	require.NoError(t, err)
+	videoID := videoPath.tailPointer().ID
+	report, err := kbfsOps.VerifySyncCache(ctx, fb, false)
+	require.NoError(t, err)
+	require.NotContains(t, report.Missing, videoID)
+
+	t.Log("Pinning a range of it marks it as sparsely synced, so that " +
+		"fetching its blocks doesn't prefetch the rest of it.")
+	config.ResetCaches()
+	err = kbfsOps.PinFileRange(ctx, video, 0, 5)
+	require.NoError(t, err)
+	require.True(t, config.isSparseSyncedBlock(fb.Tlf, videoID))
+
+	t.Log("Removing the rules forgets the sparsely-synced blocks.")
+	err = kbfsOps.RemoveEntry(ctx, rootNode, SparseSyncFileName)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	ops.reloadSparseSync()
+	require.Nil(t, config.SparseSync(fb.Tlf))
+	require.False(t, config.isSparseSyncedBlock(fb.Tlf, videoID))
+}
@@ -106,7 +106,7 @@ func (sc *StateChecker) findAllBlocksInPath(ctx context.Context,
 func (sc *StateChecker) getLastGCData(ctx context.Context,
 	tlfID tlf.ID) (time.Time, kbfsmd.Revision) {
 	config, ok := sc.config.(*ConfigLocal)
-	if !ok {
+	if !ok || config.allKnownConfigsForTesting == nil {
 		return time.Time{}, kbfsmd.RevisionUninitialized
 	}
 
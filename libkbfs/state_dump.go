@@ -0,0 +1,221 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime/pprof"
+	"time"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// stateDumpFolderTimeout is how long DumpState waits for the status
+// of any single folder-branch.  A folder that is stuck (e.g.,
+// because of a deadlock) shouldn't prevent the rest of the state
+// from being dumped.
+const stateDumpFolderTimeout = 10 * time.Second
+
+func writeStateDumpSection(w io.Writer, title string, v interface{}) error {
+	_, err := fmt.Fprintf(w, "==== %s ====\n", title)
+	if err != nil {
+		return err
+	}
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		_, err = fmt.Fprintf(w, "Couldn't encode: %+v\n\n", err)
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n\n", buf)
+	return err
+}
+
+func (fs *KBFSOpsStandard) dumpFolderState(
+	ctx context.Context, w io.Writer, fb FolderBranch,
+	ops *folderBranchOps) error {
+	type statusResult struct {
+		status FolderBranchStatus
+		err    error
+	}
+	ctx, cancel := context.WithTimeout(ctx, stateDumpFolderTimeout)
+	defer cancel()
+	resCh := make(chan statusResult, 1)
+	go func() {
+		status, _, err := ops.FolderStatus(ctx, fb)
+		resCh <- statusResult{status, err}
+	}()
+
+	title := fmt.Sprintf("Folder %s (branch %s)", fb.Tlf, fb.Branch)
+	select {
+	case res := <-resCh:
+		if res.err != nil {
+			return writeStateDumpSection(w, title, res.err.Error())
+		}
+		return writeStateDumpSection(w, title, res.status)
+	case <-ctx.Done():
+		return writeStateDumpSection(w, title,
+			"Timed out getting the status; this folder may be stuck")
+	}
+}
+
+// DumpState writes a snapshot of the internal state of KBFS to `w`:
+// the stack of every goroutine (showing which locks and requests
+// are being waited on), the overall KBFS and journal status, and
+// the status of every initialized folder-branch, including its
+// journal and conflict resolution state.  It is meant for debugging
+// hangs that can't be reproduced, and so it tries hard not to block
+// on any single stuck component.
+func (fs *KBFSOpsStandard) DumpState(ctx context.Context, w io.Writer) error {
+	_, err := fmt.Fprintf(w, "KBFS state dump at %s (version %s)\n\n",
+		fs.config.Clock().Now().Format(time.RFC3339Nano), VersionString())
+	if err != nil {
+		return err
+	}
+
+	// Dump goroutines first, so they're captured even if something
+	// below gets stuck.
+	_, err = fmt.Fprintf(w, "==== Goroutines ====\n")
+	if err != nil {
+		return err
+	}
+	err = pprof.Lookup("goroutine").WriteTo(w, 2)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "\n")
+	if err != nil {
+		return err
+	}
+
+	status, _, err := fs.Status(ctx)
+	if err != nil {
+		err = writeStateDumpSection(w, "KBFS status", err.Error())
+	} else {
+		err = writeStateDumpSection(w, "KBFS status", status)
+	}
+	if err != nil {
+		return err
+	}
+
+	fs.opsLock.RLock()
+	ops := make(map[FolderBranch]*folderBranchOps, len(fs.ops))
+	for fb, fbo := range fs.ops {
+		ops[fb] = fbo
+	}
+	fs.opsLock.RUnlock()
+
+	for fb, fbo := range ops {
+		err := fs.dumpFolderState(ctx, w, fb, fbo)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dumpStateToDir writes a state dump for the given config into a new,
+// timestamped file in `dir`, and returns the name of that file.
+func dumpStateToDir(
+	ctx context.Context, config Config, dir string) (string, error) {
+	kbfsOps, ok := config.KBFSOps().(*KBFSOpsStandard)
+	if !ok {
+		return "", errors.New("KBFSOps doesn't support state dumps")
+	}
+
+	err := ioutil.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Join(dir, fmt.Sprintf("kbfs_state.%s.txt",
+		config.Clock().Now().Format("20060102T150405.000000000")))
+	f, err := ioutil.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	err = kbfsOps.DumpState(ctx, f)
+	if err != nil {
+		return "", err
+	}
+	return name, f.Sync()
+}
+
+// stateDumpTimeout bounds how long a single state dump, triggered by
+// a signal, can take.
+const stateDumpTimeout = 1 * time.Minute
+
+// stateDumpHandler writes a state dump into a directory every time
+// the process receives one of `stateDumpSignals`.
+type stateDumpHandler struct {
+	config     Config
+	dir        string
+	log        logger.Logger
+	sigCh      chan os.Signal
+	shutdownCh chan struct{}
+	doneCh     chan struct{}
+}
+
+func newStateDumpHandler(
+	config Config, dir string, log logger.Logger) *stateDumpHandler {
+	return &stateDumpHandler{
+		config:     config,
+		dir:        dir,
+		log:        log,
+		sigCh:      make(chan os.Signal, 1),
+		shutdownCh: make(chan struct{}),
+		doneCh:     make(chan struct{}),
+	}
+}
+
+func (sdh *stateDumpHandler) dump() {
+	ctx, cancel := context.WithTimeout(
+		context.Background(), stateDumpTimeout)
+	defer cancel()
+	go func() {
+		// Interrupt the dump on shutdown.
+		select {
+		case <-sdh.shutdownCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	name, err := dumpStateToDir(ctx, sdh.config, sdh.dir)
+	if err != nil {
+		sdh.log.CWarningf(ctx, "Couldn't dump state: %+v", err)
+		return
+	}
+	sdh.log.CInfof(ctx, "Dumped state to %s", name)
+}
+
+func (sdh *stateDumpHandler) start() {
+	signal.Notify(sdh.sigCh, stateDumpSignals...)
+	go sdh.loop()
+}
+
+func (sdh *stateDumpHandler) loop() {
+	defer close(sdh.doneCh)
+	for {
+		select {
+		case <-sdh.sigCh:
+			sdh.dump()
+		case <-sdh.shutdownCh:
+			return
+		}
+	}
+}
+
+func (sdh *stateDumpHandler) shutdown() {
+	signal.Stop(sdh.sigCh)
+	close(sdh.shutdownCh)
+	<-sdh.doneCh
+}
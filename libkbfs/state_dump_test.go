@@ -0,0 +1,82 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKBFSOpsDumpState(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	h, err := ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "alice", tlf.Private)
+	require.NoError(t, err)
+	rootNode, _, err := config.KBFSOps().GetOrCreateRootNode(
+		ctx, h, MasterBranch)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	kbfsOps := config.KBFSOps().(*KBFSOpsStandard)
+	err = kbfsOps.DumpState(ctx, &buf)
+	require.NoError(t, err)
+	dump := buf.String()
+	require.Contains(t, dump, "==== Goroutines ====")
+	require.Contains(t, dump, "==== KBFS status ====")
+	require.Contains(t, dump,
+		"==== Folder "+rootNode.GetFolderBranch().Tlf.String())
+
+	t.Log("Dump to a file")
+	tempdir, err := ioutil.TempDir(os.TempDir(), "state_dump")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		require.NoError(t, err)
+	}()
+	name, err := dumpStateToDir(ctx, config, tempdir)
+	require.NoError(t, err)
+	fileDump, err := ioutil.ReadFile(name)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(string(fileDump), "KBFS state dump"))
+}
+
+func TestStateDumpHandler(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	tempdir, err := ioutil.TempDir(os.TempDir(), "state_dump")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		require.NoError(t, err)
+	}()
+
+	sdh := newStateDumpHandler(config, tempdir, config.MakeLogger(""))
+	go sdh.loop()
+	// The channel holds one pending signal, so the third send can
+	// only finish once the first dump is done.
+	sdh.sigCh <- os.Interrupt
+	sdh.sigCh <- os.Interrupt
+	sdh.sigCh <- os.Interrupt
+
+	t.Log("Shutting down stops the handler")
+	sdh.shutdown()
+	select {
+	case <-sdh.doneCh:
+	default:
+		t.Fatal("Handler still running after shutdown")
+	}
+	fis, err := ioutil.ReadDir(tempdir)
+	require.NoError(t, err)
+	require.NotEmpty(t, fis)
+}
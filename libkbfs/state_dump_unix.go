@@ -0,0 +1,16 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build !windows
+
+package libkbfs
+
+import (
+	"os"
+	"syscall"
+)
+
+// stateDumpSignals are the signals that make the process write a
+// state dump.
+var stateDumpSignals = []os.Signal{syscall.SIGUSR1}
@@ -0,0 +1,12 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build windows
+
+package libkbfs
+
+import "os"
+
+// stateDumpSignals is empty on Windows, which has no SIGUSR1.
+var stateDumpSignals []os.Signal
@@ -0,0 +1,740 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/keybase/go-codec/codec"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// StorageEncoding is how a stored file, or leveldb record, is
+// encoded.
+type StorageEncoding string
+
+const (
+	// StorageEncodingMsgpack is the msgpack encoding of kbfscodec.
+	StorageEncodingMsgpack StorageEncoding = "msgpack"
+	// StorageEncodingJSON is indented JSON.
+	StorageEncodingJSON StorageEncoding = "json"
+	// StorageEncodingText is a single line of text, without a
+	// trailing newline.
+	StorageEncodingText StorageEncoding = "text"
+	// StorageEncodingRaw is opaque binary data.
+	StorageEncodingRaw StorageEncoding = "raw"
+)
+
+// StorageFormatField describes a field of a structure stored on
+// disk.
+type StorageFormatField struct {
+	// Name is the name of the Go field.
+	Name string
+	// Key is the key of the field in the encoded structure.
+	Key string
+	// Type is the Go type of the field.
+	Type string
+	// OmitEmpty is set if the field is left out when it has its
+	// zero value.
+	OmitEmpty bool `json:",omitempty"`
+}
+
+// StorageFormatFile describes a kind of file in a storage
+// directory, or a kind of record in a leveldb database in it.
+type StorageFormatFile struct {
+	// Path is the path of the file, relative to the root of the
+	// storage directory.  Variable parts are in angle brackets.
+	Path string
+	// RecordKey, if set, means the path is a leveldb database,
+	// and describes the keys of its records; the rest of the
+	// fields describe their values.
+	RecordKey string `json:",omitempty"`
+	// Encoding is how the file, or the record value, is encoded.
+	Encoding StorageEncoding
+	// Type is the Go type stored in the file, if any.
+	Type string `json:",omitempty"`
+	// Fields are the fields of Type, if it's a structure.
+	Fields []StorageFormatField `json:",omitempty"`
+	// Optional is set if the file may be missing.
+	Optional bool `json:",omitempty"`
+	// Doc describes the file.
+	Doc string
+}
+
+// StorageFormat describes the layout and encoding of a storage
+// directory.
+type StorageFormat struct {
+	// Name names the storage directory.
+	Name string
+	// Dirs are the names of the storage directories, under the
+	// storage root, that have this format.
+	Dirs []string
+	// Version is the current version of the format.
+	Version uint64
+	// Files are the kinds of files in the storage directory.
+	Files []StorageFormatFile
+}
+
+const (
+	// journalStorageVersion is the version of the layout of the
+	// journal directory, which is the name of its subdirectory.
+	journalStorageVersion uint64 = 1
+	// journalStorageDirName is the name of the journal directory
+	// under the storage root.
+	journalStorageDirName = "kbfs_journal"
+)
+
+func journalStorageVersionDir() string {
+	return fmt.Sprintf("v%d", journalStorageVersion)
+}
+
+// storageFormatFields describes the fields of `t`, as encoded with
+// `encoding`.  The fields of embedded structures other than the
+// unknown field handler are flattened, like both encoders do.
+func storageFormatFields(
+	t reflect.Type, encoding StorageEncoding) (fields []StorageFormatField) {
+	tagName := "codec"
+	if encoding == StorageEncodingJSON {
+		tagName = "json"
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			if f.Type == reflect.TypeOf(codec.UnknownFieldSetHandler{}) {
+				continue
+			}
+			if f.Type.Kind() == reflect.Struct {
+				fields = append(
+					fields, storageFormatFields(f.Type, encoding)...)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			// Unexported.
+			continue
+		}
+		tag := strings.Split(f.Tag.Get(tagName), ",")
+		if tag[0] == "-" {
+			continue
+		}
+		field := StorageFormatField{
+			Name: f.Name,
+			Key:  f.Name,
+			Type: f.Type.String(),
+		}
+		if tag[0] != "" {
+			field.Key = tag[0]
+		}
+		for _, opt := range tag[1:] {
+			if opt == "omitempty" {
+				field.OmitEmpty = true
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// makeStorageFormatFile describes a file at `path` that holds
+// `value`, which may be nil for files that don't hold a Go type.
+func makeStorageFormatFile(path string, encoding StorageEncoding,
+	value interface{}, optional bool, doc string) StorageFormatFile {
+	f := StorageFormatFile{
+		Path:     path,
+		Encoding: encoding,
+		Optional: optional,
+		Doc:      doc,
+	}
+	if value != nil {
+		t := reflect.TypeOf(value)
+		f.Type = t.String()
+		if t.Kind() == reflect.Struct {
+			f.Fields = storageFormatFields(t, encoding)
+		}
+	}
+	return f
+}
+
+func diskJournalStorageFormatFiles(dir string, entry interface{},
+	name, entryDoc string) []StorageFormatFile {
+	return []StorageFormatFile{
+		makeStorageFormatFile(dir+"/EARLIEST", StorageEncodingText, nil,
+			true, "The ordinal of the earliest entry of the "+name+
+				", as 16 hex digits.  Missing if the journal is empty."),
+		makeStorageFormatFile(dir+"/LATEST", StorageEncodingText, nil,
+			true, "The ordinal of the latest entry of the "+name+
+				", as 16 hex digits.  Missing if the journal is empty."),
+		makeStorageFormatFile(dir+"/<ordinal>", StorageEncodingMsgpack,
+			entry, true, entryDoc+"  Named by its ordinal."),
+	}
+}
+
+// JournalStorageFormat returns the format of the journal directory.
+func JournalStorageFormat() StorageFormat {
+	v := journalStorageVersionDir()
+	tlfDir := v + "/<device>-<tlf>"
+	files := []StorageFormatFile{
+		makeStorageFormatFile(v+"/config.json", StorageEncodingJSON,
+			journalServerConfig{}, true,
+			"The settings of the journal of the logged-in device."),
+		makeStorageFormatFile(tlfDir+"/info.json", StorageEncodingJSON,
+			tlfJournalInfo{}, false,
+			"Identifies the journal of one TLF on one device.  The "+
+				"directory is named by the first 36 characters of the "+
+				"verifying key of the device and the first 16 "+
+				"characters of the TLF ID."),
+		makeStorageFormatFile(tlfDir+"/block_aggregate_info",
+			StorageEncodingMsgpack, blockAggregateInfo{}, true,
+			"Counts of the stored and unflushed block data."),
+	}
+	files = append(files, diskJournalStorageFormatFiles(
+		tlfDir+"/block_journal", blockJournalEntry{}, "block journal",
+		"A block operation that hasn't been flushed yet.")...)
+	files = append(files, diskJournalStorageFormatFiles(
+		tlfDir+"/gc_block_journal", blockJournalEntry{},
+		"deferred GC journal",
+		"A flushed block operation whose garbage collection is "+
+			"deferred until the MDs that use it are flushed.")...)
+	blockDir := tlfDir + "/blocks/<block ID[:4]>/<block ID[4:34]>"
+	files = append(files,
+		makeStorageFormatFile(blockDir+"/"+idFilename,
+			StorageEncodingText, nil, false,
+			"The full block ID, as a string."),
+		makeStorageFormatFile(blockDir+"/data", StorageEncodingRaw, nil,
+			true, "The encrypted block, which hashes to the block ID."),
+		makeStorageFormatFile(blockDir+"/ksh", StorageEncodingRaw,
+			kbfscrypto.BlockCryptKeyServerHalf{}, true,
+			"The server half of the block key, in binary form.  "+
+				"Present whenever data is."),
+		makeStorageFormatFile(blockDir+"/refs", StorageEncodingMsgpack,
+			blockJournalInfo{}, true,
+			"The references to the block, and whether it was flushed."),
+	)
+	files = append(files, diskJournalStorageFormatFiles(
+		tlfDir+"/md_journal", mdIDJournalEntry{}, "MD journal",
+		"The ID of an MD that hasn't been flushed yet, in revision "+
+			"order.")...)
+	mdDir := tlfDir + "/mds/<MD ID[:4]>/<MD ID[4:34]>"
+	files = append(files,
+		makeStorageFormatFile(mdDir+"/data", StorageEncodingMsgpack,
+			kbfsmd.RootMetadataV3{}, false,
+			"An MD, which hashes to the MD ID.  It's a "+
+				"kbfsmd.RootMetadataV2 instead if its version is "+
+				"older than kbfsmd.SegregatedKeyBundlesVer."),
+		makeStorageFormatFile(mdDir+"/info.json", StorageEncodingJSON,
+			mdInfo{}, false, "When the MD was put, and its version."),
+		makeStorageFormatFile(tlfDir+"/wkbv3/<bundle ID[:34]>",
+			StorageEncodingMsgpack, kbfsmd.TLFWriterKeyBundleV3{}, true,
+			"A writer key bundle of the MDs."),
+		makeStorageFormatFile(tlfDir+"/rkbv3/<bundle ID[:34]>",
+			StorageEncodingMsgpack, kbfsmd.TLFReaderKeyBundleV3{}, true,
+			"A reader key bundle of the MDs."),
+	)
+	return StorageFormat{
+		Name:    "Journal",
+		Dirs:    []string{journalStorageDirName},
+		Version: journalStorageVersion,
+		Files:   files,
+	}
+}
+
+// DiskCacheStorageFormat returns the format of the disk block cache
+// directories.
+func DiskCacheStorageFormat() StorageFormat {
+	v := fmt.Sprintf("v%d", currentDiskBlockCacheVersion)
+	blocks := makeStorageFormatFile(v+"/"+blockDbFilename,
+		StorageEncodingMsgpack, diskBlockCacheEntry{}, false,
+		"The cached blocks.")
+	blocks.RecordKey = "<block ID, in binary form>"
+	meta := makeStorageFormatFile(v+"/"+metaDbFilename,
+		StorageEncodingMsgpack, DiskBlockCacheMetadata{}, false,
+		"The LRU and prefetch state of each cached block.")
+	meta.RecordKey = "<block ID, in binary form>"
+	tlfs := makeStorageFormatFile(v+"/"+tlfDbFilename,
+		StorageEncodingRaw, nil, false,
+		"An index of the cached blocks of each TLF.  The values "+
+			"are empty.")
+	tlfs.RecordKey = "<TLF ID, in binary form><block ID, in binary form>"
+	return StorageFormat{
+		Name:    "Disk block cache",
+		Dirs:    []string{workingSetCacheFolderName, syncCacheFolderName},
+		Version: currentDiskBlockCacheVersion,
+		Files: []StorageFormatFile{
+			makeStorageFormatFile(diskCacheVersionFilename,
+				StorageEncodingText, nil, false,
+				"The version of the cache, in decimal."),
+			blocks, meta, tlfs,
+		},
+	}
+}
+
+// StorageFormats returns the formats of every storage directory
+// that WriteStorageFormatDoc documents.
+func StorageFormats() []StorageFormat {
+	return []StorageFormat{JournalStorageFormat(), DiskCacheStorageFormat()}
+}
+
+// WriteStorageFormatDoc writes a Markdown description of the given
+// formats to `w`.
+func WriteStorageFormatDoc(w io.Writer, formats []StorageFormat) error {
+	var b bytes.Buffer
+	b.WriteString("# KBFS storage formats\n\n")
+	b.WriteString("Generated from the code by `kbfstool storage-format " +
+		"doc`; don't edit.\n")
+	for _, format := range formats {
+		fmt.Fprintf(&b, "\n## %s (version %d)\n\n", format.Name,
+			format.Version)
+		dirs := make([]string, 0, len(format.Dirs))
+		for _, d := range format.Dirs {
+			dirs = append(dirs, "`"+d+"`")
+		}
+		fmt.Fprintf(&b, "Stored in %s under the storage root.\n",
+			strings.Join(dirs, " and "))
+		for _, f := range format.Files {
+			fmt.Fprintf(&b, "\n### `%s`\n\n%s\n\n", f.Path, f.Doc)
+			if f.RecordKey != "" {
+				fmt.Fprintf(&b, "- leveldb database, keyed by `%s`\n",
+					f.RecordKey)
+			}
+			fmt.Fprintf(&b, "- encoding: %s\n", f.Encoding)
+			if f.Type != "" {
+				fmt.Fprintf(&b, "- type: `%s`\n", f.Type)
+			}
+			if f.Optional {
+				b.WriteString("- optional\n")
+			}
+			if len(f.Fields) == 0 {
+				continue
+			}
+			b.WriteString("\n| Field | Key | Type | Omitted if empty |\n")
+			b.WriteString("|---|---|---|---|\n")
+			for _, field := range f.Fields {
+				omit := ""
+				if field.OmitEmpty {
+					omit = "yes"
+				}
+				fmt.Fprintf(&b, "| %s | `%s` | `%s` | %s |\n",
+					field.Name, field.Key, field.Type, omit)
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// StorageProblem is a way in which a file in a storage directory
+// doesn't match its format.
+type StorageProblem struct {
+	// Path is relative to the validated directory.
+	Path string
+	Err  error
+}
+
+func (p StorageProblem) String() string {
+	return fmt.Sprintf("%s: %v", p.Path, p.Err)
+}
+
+type storageValidator struct {
+	codec    kbfscodec.Codec
+	root     string
+	problems []StorageProblem
+}
+
+func (v *storageValidator) addProblem(path string, err error) {
+	rel, relErr := filepath.Rel(v.root, path)
+	if relErr != nil {
+		rel = path
+	}
+	v.problems = append(
+		v.problems, StorageProblem{filepath.ToSlash(rel), err})
+}
+
+// decodeFile decodes the file at `path` into `objPtr`, and returns
+// whether that worked.  Missing files are only a problem if they
+// aren't optional.
+func (v *storageValidator) decodeFile(path string, encoding StorageEncoding,
+	objPtr interface{}, optional bool) bool {
+	var err error
+	switch encoding {
+	case StorageEncodingJSON:
+		err = ioutil.DeserializeFromJSONFile(path, objPtr)
+	case StorageEncodingMsgpack:
+		err = kbfscodec.DeserializeFromFile(v.codec, path, objPtr)
+	default:
+		panic(fmt.Sprintf("Can't decode %s", encoding))
+	}
+	if ioutil.IsNotExist(err) {
+		if !optional {
+			v.addProblem(path, errors.New("missing"))
+		}
+		return false
+	} else if err != nil {
+		v.addProblem(path, err)
+		return false
+	}
+	return true
+}
+
+// readDir lists the entries of the directory at `path`, which may be
+// missing if it's optional.
+func (v *storageValidator) readDir(path string, optional bool) []string {
+	fileInfos, err := ioutil.ReadDir(path)
+	if ioutil.IsNotExist(err) {
+		if !optional {
+			v.addProblem(path, errors.New("missing"))
+		}
+		return nil
+	} else if err != nil {
+		v.addProblem(path, err)
+		return nil
+	}
+	names := make([]string, 0, len(fileInfos))
+	for _, fi := range fileInfos {
+		names = append(names, fi.Name())
+	}
+	return names
+}
+
+// validateDiskJournal checks the diskJournal in `dir`, and returns
+// its valid entries in order.
+func (v *storageValidator) validateDiskJournal(
+	dir string, entryType reflect.Type) (entries []interface{}) {
+	names := v.readDir(dir, true)
+	if names == nil {
+		return nil
+	}
+
+	readOrdinal := func(name string) (journalOrdinal, bool) {
+		path := filepath.Join(dir, name)
+		buf, err := ioutil.ReadFile(path)
+		if ioutil.IsNotExist(err) {
+			return 0, false
+		} else if err != nil {
+			v.addProblem(path, err)
+			return 0, false
+		}
+		o, err := makeJournalOrdinal(string(buf))
+		if err != nil {
+			v.addProblem(path, err)
+			return 0, false
+		}
+		return o, true
+	}
+	earliest, earliestOK := readOrdinal("EARLIEST")
+	latest, latestOK := readOrdinal("LATEST")
+	if earliestOK != latestOK {
+		v.addProblem(dir, errors.New(
+			"only one of EARLIEST and LATEST is present"))
+		return nil
+	}
+	if earliestOK && earliest > latest {
+		v.addProblem(dir, errors.Errorf(
+			"EARLIEST %s is after LATEST %s", earliest, latest))
+		return nil
+	}
+
+	for _, name := range names {
+		if name == "EARLIEST" || name == "LATEST" {
+			continue
+		}
+		if _, err := makeJournalOrdinal(name); err != nil {
+			v.addProblem(filepath.Join(dir, name), err)
+		}
+	}
+
+	if !earliestOK {
+		return nil
+	}
+	for o := earliest; o <= latest; o++ {
+		entry := reflect.New(entryType)
+		ok := v.decodeFile(filepath.Join(dir, o.String()),
+			StorageEncodingMsgpack, entry.Interface(), false)
+		if ok {
+			entries = append(entries, entry.Elem().Interface())
+		}
+	}
+	return entries
+}
+
+func (v *storageValidator) validateBlocks(dir string) {
+	for _, name := range v.readDir(dir, true) {
+		for _, subName := range v.readDir(filepath.Join(dir, name), false) {
+			blockDir := filepath.Join(dir, name, subName)
+			idPath := filepath.Join(blockDir, idFilename)
+			idBytes, err := ioutil.ReadFile(idPath)
+			if err != nil {
+				v.addProblem(idPath, err)
+				continue
+			}
+			id, err := kbfsblock.IDFromString(string(idBytes))
+			if err != nil {
+				v.addProblem(idPath, err)
+				continue
+			}
+			if !strings.HasPrefix(id.String(), name+subName) {
+				v.addProblem(idPath, errors.Errorf(
+					"ID %s doesn't match the directory", id))
+			}
+
+			dataPath := filepath.Join(blockDir, "data")
+			data, err := ioutil.ReadFile(dataPath)
+			hasData := err == nil
+			if hasData {
+				if err := kbfsblock.VerifyID(data, id); err != nil {
+					v.addProblem(dataPath, err)
+				}
+			} else if !ioutil.IsNotExist(err) {
+				v.addProblem(dataPath, err)
+			}
+
+			kshPath := filepath.Join(blockDir, "ksh")
+			buf, err := ioutil.ReadFile(kshPath)
+			if err == nil {
+				var serverHalf kbfscrypto.BlockCryptKeyServerHalf
+				if err := serverHalf.UnmarshalBinary(buf); err != nil {
+					v.addProblem(kshPath, err)
+				}
+			} else if !ioutil.IsNotExist(err) || hasData {
+				v.addProblem(kshPath, errors.New("missing"))
+			}
+
+			var info blockJournalInfo
+			v.decodeFile(filepath.Join(blockDir, "refs"),
+				StorageEncodingMsgpack, &info, true)
+		}
+	}
+}
+
+func (v *storageValidator) validateMDs(
+	dir string, tlfID tlf.ID, entries []interface{}) {
+	for _, e := range entries {
+		id := e.(mdIDJournalEntry).ID
+		idStr := id.String()
+		mdDir := filepath.Join(dir, "mds", idStr[:4], idStr[4:34])
+		var info mdInfo
+		if !v.decodeFile(filepath.Join(mdDir, "info.json"),
+			StorageEncodingJSON, &info, false) {
+			continue
+		}
+		dataPath := filepath.Join(mdDir, "data")
+		data, err := ioutil.ReadFile(dataPath)
+		if err != nil {
+			v.addProblem(dataPath, err)
+			continue
+		}
+		rmd, err := kbfsmd.DecodeRootMetadata(
+			v.codec, tlfID, info.Version, defaultClientMetadataVer, data)
+		if err != nil {
+			v.addProblem(dataPath, err)
+			continue
+		}
+		mdID, err := kbfsmd.MakeID(v.codec, rmd)
+		if err != nil {
+			v.addProblem(dataPath, err)
+			continue
+		}
+		if mdID != id {
+			v.addProblem(dataPath, errors.Errorf(
+				"MD hashes to %s instead of %s", mdID, id))
+		}
+	}
+
+	for _, name := range v.readDir(filepath.Join(dir, "wkbv3"), true) {
+		var wkb kbfsmd.TLFWriterKeyBundleV3
+		v.decodeFile(filepath.Join(dir, "wkbv3", name),
+			StorageEncodingMsgpack, &wkb, false)
+	}
+	for _, name := range v.readDir(filepath.Join(dir, "rkbv3"), true) {
+		var rkb kbfsmd.TLFReaderKeyBundleV3
+		v.decodeFile(filepath.Join(dir, "rkbv3", name),
+			StorageEncodingMsgpack, &rkb, false)
+	}
+}
+
+var tlfJournalDirRegexp = regexp.MustCompile(`^[0-9a-f]{36}-[0-9a-f]{16}$`)
+
+func (v *storageValidator) validateTLFJournal(dir string) {
+	name := filepath.Base(dir)
+	if !tlfJournalDirRegexp.MatchString(name) {
+		v.addProblem(dir, errors.New("not named like a TLF journal"))
+		return
+	}
+	var info tlfJournalInfo
+	if !v.decodeFile(getTLFJournalInfoFilePath(dir), StorageEncodingJSON,
+		&info, false) {
+		return
+	}
+	expectedName := fmt.Sprintf("%s-%s", info.VerifyingKey.String()[:36],
+		info.TlfID.String()[:16])
+	if name != expectedName {
+		v.addProblem(dir, errors.Errorf(
+			"info.json doesn't match the directory; expected %s",
+			expectedName))
+	}
+
+	var aggregateInfo blockAggregateInfo
+	v.decodeFile(aggregateInfoPath(dir), StorageEncodingMsgpack,
+		&aggregateInfo, true)
+	v.validateDiskJournal(blockJournalDir(dir),
+		reflect.TypeOf(blockJournalEntry{}))
+	v.validateDiskJournal(deferredGCBlockJournalDir(dir),
+		reflect.TypeOf(blockJournalEntry{}))
+	v.validateBlocks(blockJournalStoreDir(dir))
+	mdEntries := v.validateDiskJournal(mdJournalPath(dir),
+		reflect.TypeOf(mdIDJournalEntry{}))
+	v.validateMDs(dir, info.TlfID, mdEntries)
+}
+
+// ValidateJournalDir checks the journal directory `dir`, usually
+// `kbfs_journal` under the storage root, against
+// JournalStorageFormat, and returns every problem it finds.  The
+// journal must not be in use.  It returns an error only if `dir`
+// can't be read at all.
+func ValidateJournalDir(codec kbfscodec.Codec, dir string) (
+	[]StorageProblem, error) {
+	if _, err := ioutil.ReadDir(dir); err != nil {
+		return nil, err
+	}
+	v := &storageValidator{codec: codec, root: dir}
+	root := filepath.Join(dir, journalStorageVersionDir())
+	var config journalServerConfig
+	v.decodeFile(filepath.Join(root, "config.json"), StorageEncodingJSON,
+		&config, true)
+	fileInfos, err := ioutil.ReadDir(root)
+	if err != nil && !ioutil.IsNotExist(err) {
+		return nil, err
+	}
+	for _, fi := range fileInfos {
+		if fi.Name() == "config.json" {
+			continue
+		}
+		path := filepath.Join(root, fi.Name())
+		if !fi.IsDir() {
+			v.addProblem(path, errors.New("unexpected file"))
+			continue
+		}
+		v.validateTLFJournal(path)
+	}
+	return v.problems, nil
+}
+
+// validateLevelDB opens the leveldb database at `path` read-only,
+// and calls `check` on each of its records.
+func (v *storageValidator) validateLevelDB(
+	path string, check func(key, value []byte) error) {
+	stor, err := storage.OpenFile(path, true)
+	if err != nil {
+		v.addProblem(path, err)
+		return
+	}
+	defer stor.Close()
+	db, err := leveldb.Open(stor, &opt.Options{ReadOnly: true})
+	if err != nil {
+		v.addProblem(path, err)
+		return
+	}
+	defer db.Close()
+	iter := db.NewIterator(nil, nil)
+	defer iter.Release()
+	for iter.Next() {
+		if err := check(iter.Key(), iter.Value()); err != nil {
+			v.addProblem(path, errors.Wrapf(err, "record %x", iter.Key()))
+		}
+	}
+	if err := iter.Error(); err != nil {
+		v.addProblem(path, err)
+	}
+}
+
+// ValidateDiskCacheDir checks the disk block cache directory `dir`,
+// e.g. `kbfs_block_cache` under the storage root, against
+// DiskCacheStorageFormat, and returns every problem it finds.  The
+// cache must not be in use.  It returns an error only if `dir` can't
+// be read at all.
+func ValidateDiskCacheDir(codec kbfscodec.Codec, dir string) (
+	[]StorageProblem, error) {
+	if _, err := ioutil.ReadDir(dir); err != nil {
+		return nil, err
+	}
+	v := &storageValidator{codec: codec, root: dir}
+	versionPath := filepath.Join(dir, diskCacheVersionFilename)
+	buf, err := ioutil.ReadFile(versionPath)
+	if err != nil {
+		v.addProblem(versionPath, err)
+		return v.problems, nil
+	}
+	version, err := strconv.ParseUint(string(buf), 10, strconv.IntSize)
+	if err != nil {
+		v.addProblem(versionPath, err)
+		return v.problems, nil
+	}
+	if version != currentDiskBlockCacheVersion {
+		v.addProblem(versionPath, errors.Errorf(
+			"version %d isn't the current version %d",
+			version, currentDiskBlockCacheVersion))
+		return v.problems, nil
+	}
+
+	versionDir := versionPathFromVersion(dir, version)
+	blockIDs := make(map[kbfsblock.ID]bool)
+	v.validateLevelDB(filepath.Join(versionDir, blockDbFilename),
+		func(key, value []byte) error {
+			id, err := kbfsblock.IDFromBytes(key)
+			if err != nil {
+				return err
+			}
+			var entry diskBlockCacheEntry
+			if err := codec.Decode(value, &entry); err != nil {
+				return err
+			}
+			blockIDs[id] = true
+			return kbfsblock.VerifyID(entry.Buf, id)
+		})
+	v.validateLevelDB(filepath.Join(versionDir, metaDbFilename),
+		func(key, value []byte) error {
+			id, err := kbfsblock.IDFromBytes(key)
+			if err != nil {
+				return err
+			}
+			var md DiskBlockCacheMetadata
+			if err := codec.Decode(value, &md); err != nil {
+				return err
+			}
+			if !blockIDs[id] {
+				return errors.Errorf("no cached block for %s", id)
+			}
+			return nil
+		})
+	v.validateLevelDB(filepath.Join(versionDir, tlfDbFilename),
+		func(key, value []byte) error {
+			tlfIDLen := len(tlf.NullID.Bytes())
+			if len(key) <= tlfIDLen {
+				return errors.New("key is too short")
+			}
+			var tlfID tlf.ID
+			if err := tlfID.UnmarshalBinary(key[:tlfIDLen]); err != nil {
+				return err
+			}
+			_, err := kbfsblock.IDFromBytes(key[tlfIDLen:])
+			return err
+		})
+	return v.problems, nil
+}
@@ -0,0 +1,206 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"flag"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+var updateStorageFormatDoc = flag.Bool("update-storage-format-doc", false,
+	"Regenerate storage_format.md from the code")
+
+const storageFormatDocFile = "storage_format.md"
+
+// TestStorageFormatDoc checks that the checked-in description of the
+// storage formats matches the code, so that changing a stored
+// structure without updating the description fails.
+func TestStorageFormatDoc(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteStorageFormatDoc(&buf, StorageFormats())
+	require.NoError(t, err)
+
+	if *updateStorageFormatDoc {
+		err = ioutil.WriteFile(storageFormatDocFile, buf.Bytes(), 0644)
+		require.NoError(t, err)
+	}
+	doc, err := ioutil.ReadFile(storageFormatDocFile)
+	require.NoError(t, err)
+	require.Equal(t, string(doc), buf.String(),
+		"The storage formats changed; if that's on purpose, bump "+
+			"the format version if needed, and run "+
+			"`go test -run TestStorageFormatDoc "+
+			"-update-storage-format-doc`")
+}
+
+func TestStorageFormatFields(t *testing.T) {
+	format := JournalStorageFormat()
+	var entryFile StorageFormatFile
+	for _, f := range format.Files {
+		if f.Path == "v1/<device>-<tlf>/block_journal/<ordinal>" {
+			entryFile = f
+		}
+	}
+	require.Equal(t, "libkbfs.blockJournalEntry", entryFile.Type)
+	require.Contains(t, entryFile.Fields, StorageFormatField{
+		Name:      "Ignore",
+		Key:       "Ignore",
+		Type:      "bool",
+		OmitEmpty: true,
+	})
+	for _, f := range entryFile.Fields {
+		require.NotEqual(t, "UnknownFieldSetHandler", f.Name)
+	}
+
+	fields := storageFormatFields(
+		reflect.TypeOf(blockJournalInfo{}), StorageEncodingMsgpack)
+	require.Equal(t, []StorageFormatField{
+		{Name: "Refs", Key: "Refs", Type: "libkbfs.blockRefMap"},
+		{Name: "Flushed", Key: "f", Type: "bool", OmitEmpty: true},
+	}, fields)
+}
+
+func TestValidateJournalDir(t *testing.T) {
+	tempdir, ctx, cancel, config, _, jServer := setupJournalServerTest(t)
+	defer teardownJournalServerTest(t, tempdir, ctx, cancel, config)
+	jServer.delegateBlockServer = shutdownOnlyBlockServer{}
+
+	tlfID := tlf.FakeID(2, tlf.Private)
+	err := jServer.Enable(ctx, tlfID, nil, TLFJournalBackgroundWorkPaused)
+	require.NoError(t, err)
+
+	h, err := ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "test_user1", tlf.Private)
+	require.NoError(t, err)
+	id := h.ResolvedWriters()[0]
+
+	bCtx := kbfsblock.MakeFirstContext(id, keybase1.BlockType_DATA)
+	data := []byte{1, 2, 3, 4}
+	bID, err := kbfsblock.MakePermanentID(data, kbfscrypto.EncryptionSecretbox)
+	require.NoError(t, err)
+	serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+	require.NoError(t, err)
+	err = config.BlockServer().Put(ctx, tlfID, bID, bCtx, data, serverHalf)
+	require.NoError(t, err)
+
+	rmd, err := makeInitialRootMetadata(config.MetadataVersion(), tlfID, h)
+	require.NoError(t, err)
+	rekeyDone, _, err := config.KeyManager().Rekey(ctx, rmd, false)
+	require.NoError(t, err)
+	require.True(t, rekeyDone)
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+	_, err = config.MDOps().Put(ctx, rmd, session.VerifyingKey,
+		nil, keybase1.MDPriorityNormal)
+	require.NoError(t, err)
+
+	problems, err := ValidateJournalDir(config.Codec(), tempdir)
+	require.NoError(t, err)
+	require.Len(t, problems, 0, "%v", problems)
+
+	t.Log("Corrupt the block data and the block journal.")
+	tlfJournal, ok := jServer.getTLFJournal(tlfID, nil)
+	require.True(t, ok)
+	dataPath := tlfJournal.blockJournal.s.dataPath(bID)
+	err = ioutil.WriteFile(dataPath, []byte{4, 3, 2, 1}, 0600)
+	require.NoError(t, err)
+	entryPath := filepath.Join(blockJournalDir(tlfJournal.dir),
+		firstValidJournalOrdinal.String())
+	err = ioutil.WriteFile(entryPath, []byte("garbage"), 0600)
+	require.NoError(t, err)
+
+	problems, err = ValidateJournalDir(config.Codec(), tempdir)
+	require.NoError(t, err)
+	require.Len(t, problems, 2, "%v", problems)
+	paths := []string{problems[0].Path, problems[1].Path}
+	rel := func(p string) string {
+		r, err := filepath.Rel(tempdir, p)
+		require.NoError(t, err)
+		return filepath.ToSlash(r)
+	}
+	require.Contains(t, paths, rel(dataPath))
+	require.Contains(t, paths, rel(entryPath))
+}
+
+func TestValidateDiskCacheDir(t *testing.T) {
+	tempdir, err := ioutil.TempDir("", "disk_cache_format")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		require.NoError(t, err)
+	}()
+	codec := kbfscodec.NewMsgpack()
+
+	err = ioutil.WriteFile(filepath.Join(tempdir, diskCacheVersionFilename),
+		[]byte(strconv.FormatUint(currentDiskBlockCacheVersion, 10)), 0600)
+	require.NoError(t, err)
+	versionDir := versionPathFromVersion(tempdir, currentDiskBlockCacheVersion)
+	put := func(dbName string, key []byte, value interface{}) {
+		db, err := leveldb.OpenFile(filepath.Join(versionDir, dbName), nil)
+		require.NoError(t, err)
+		defer db.Close()
+		var buf []byte
+		if value != nil {
+			buf, err = codec.Encode(value)
+			require.NoError(t, err)
+		}
+		err = db.Put(key, buf, nil)
+		require.NoError(t, err)
+	}
+
+	tlfID := tlf.FakeID(1, tlf.Private)
+	data := []byte{1, 2, 3, 4}
+	bID, err := kbfsblock.MakePermanentID(data, kbfscrypto.EncryptionSecretbox)
+	require.NoError(t, err)
+	serverHalf, err := kbfscrypto.MakeRandomBlockCryptKeyServerHalf()
+	require.NoError(t, err)
+	put(blockDbFilename, bID.Bytes(),
+		diskBlockCacheEntry{Buf: data, ServerHalf: serverHalf})
+	put(metaDbFilename, bID.Bytes(), DiskBlockCacheMetadata{
+		TlfID:     tlfID,
+		LRUTime:   legacyEncodedTime{time.Now()},
+		BlockSize: uint32(len(data)),
+	})
+	put(tlfDbFilename, append(tlfID.Bytes(), bID.Bytes()...), nil)
+
+	problems, err := ValidateDiskCacheDir(codec, tempdir)
+	require.NoError(t, err)
+	require.Len(t, problems, 0, "%v", problems)
+
+	t.Log("Metadata without a block, and a short TLF key, are problems.")
+	otherID := kbfsblock.FakeID(2)
+	put(metaDbFilename, otherID.Bytes(), DiskBlockCacheMetadata{
+		TlfID: tlfID,
+	})
+	put(tlfDbFilename, tlfID.Bytes(), nil)
+	problems, err = ValidateDiskCacheDir(codec, tempdir)
+	require.NoError(t, err)
+	require.Len(t, problems, 2, "%v", problems)
+	require.Equal(t, "v1/"+metaDbFilename, problems[0].Path)
+	require.Equal(t, "v1/"+tlfDbFilename, problems[1].Path)
+
+	t.Log("An unknown version stops the validation.")
+	err = ioutil.WriteFile(filepath.Join(tempdir, diskCacheVersionFilename),
+		[]byte("2"), 0600)
+	require.NoError(t, err)
+	problems, err = ValidateDiskCacheDir(codec, tempdir)
+	require.NoError(t, err)
+	require.Len(t, problems, 1, "%v", problems)
+	require.Equal(t, diskCacheVersionFilename, problems[0].Path)
+}
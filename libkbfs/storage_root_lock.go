@@ -0,0 +1,132 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// Two processes embedding libkbfs must never use the same storage
+// root at once: its disk caches, journals and config databases
+// assume a single writer.  So the first process to start with a
+// given storage root owns it, for as long as it holds a lock on
+// storageRootLockFileName.  Any other process that starts with the
+// same storage root while it's owned becomes a guest:
+//
+// * It reaches the owner's disk cache through the owner's KBFS
+//   service, the same way the git remote helper does, instead of
+//   opening the cache itself.
+// * It keeps its own databases in a private directory inside the
+//   storage root, which is removed when it shuts down.
+// * It doesn't journal, since a guest's journal could be left
+//   unflushed in a directory that nothing else will look at.
+// * It doesn't serve the KBFS RPC service, whose socket belongs to
+//   the owner.
+//
+// A guest owns its private directory, so an owner starting up can
+// tell which of them were left behind by guests that have exited.
+
+const (
+	// storageRootLockFileName is the name of the file, within a
+	// storage root, that its owner holds locked.
+	storageRootLockFileName = "kbfs.lock"
+	// storageRootGuestDirPrefix is the prefix of the names of the
+	// private directories of guests.
+	storageRootGuestDirPrefix = "kbfs_guest"
+)
+
+// errStorageRootLocked is returned by lockFileExclusive when another
+// process holds the lock.
+var errStorageRootLocked = errors.New(
+	"The storage root is in use by another process")
+
+// claimStorageRoot takes ownership of the storage root in `params`
+// or, if another process already owns it, changes `params` to make
+// this process a guest, in which case `guest` is true.  The returned
+// function releases the storage root, and removes a guest's private
+// directory.
+func claimStorageRoot(
+	ctx context.Context, params *InitParams, log logger.Logger) (
+	release func(), guest bool, err error) {
+	root := params.StorageRoot
+	if root == "" {
+		return func() {}, false, nil
+	}
+	err = ioutil.MkdirAll(root, 0700)
+	if err != nil {
+		return nil, false, err
+	}
+
+	lock, err := lockFileExclusive(
+		filepath.Join(root, storageRootLockFileName))
+	switch errors.Cause(err) {
+	case nil:
+		removeAbandonedGuestDirs(ctx, root, log)
+		return func() { lock.Close() }, false, nil
+	case errStorageRootLocked:
+	default:
+		return nil, false, err
+	}
+
+	guestDir, err := ioutil.TempDir(root, storageRootGuestDirPrefix)
+	if err != nil {
+		return nil, false, err
+	}
+	guestLock, err := lockFileExclusive(
+		filepath.Join(guestDir, storageRootLockFileName))
+	if err != nil {
+		ioutil.RemoveAll(guestDir)
+		return nil, false, err
+	}
+	log.CWarningf(ctx, "Storage root %s is in use by another process; "+
+		"using %s, the other process's disk cache, and no journal",
+		root, guestDir)
+	params.StorageRoot = guestDir
+	if params.DiskCacheMode == DiskCacheModeLocal {
+		params.DiskCacheMode = DiskCacheModeRemote
+	}
+	params.EnableJournal = false
+	return func() {
+		guestLock.Close()
+		ioutil.RemoveAll(guestDir)
+	}, true, nil
+}
+
+// removeAbandonedGuestDirs removes the private directories of guests
+// of the storage root at `root` that are no longer running.
+func removeAbandonedGuestDirs(
+	ctx context.Context, root string, log logger.Logger) {
+	fis, err := ioutil.ReadDir(root)
+	if err != nil {
+		log.CDebugf(ctx, "Couldn't list %s: %+v", root, err)
+		return
+	}
+	for _, fi := range fis {
+		if !fi.IsDir() ||
+			!strings.HasPrefix(fi.Name(), storageRootGuestDirPrefix) {
+			continue
+		}
+		dir := filepath.Join(root, fi.Name())
+		var lock io.Closer
+		lock, err = lockFileExclusive(
+			filepath.Join(dir, storageRootLockFileName))
+		if err != nil {
+			// Still in use, or not ours to remove.
+			continue
+		}
+		lock.Close()
+		log.CDebugf(ctx, "Removing abandoned guest directory %s", dir)
+		if err := ioutil.RemoveAll(dir); err != nil {
+			log.CDebugf(ctx, "Couldn't remove %s: %+v", dir, err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestClaimStorageRoot(t *testing.T) {
+	ctx := context.Background()
+	log := logger.NewTestLogger(t)
+	root, err := ioutil.TempDir(os.TempDir(), "storage_root_lock")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(root)
+		require.NoError(t, err)
+	}()
+
+	t.Log("The first claim owns the storage root")
+	ownerParams := InitParams{
+		StorageRoot:   root,
+		DiskCacheMode: DiskCacheModeLocal,
+		EnableJournal: true,
+	}
+	releaseOwner, guest, err := claimStorageRoot(ctx, &ownerParams, log)
+	require.NoError(t, err)
+	require.False(t, guest)
+	require.Equal(t, root, ownerParams.StorageRoot)
+	require.Equal(t, DiskCacheModeLocal, ownerParams.DiskCacheMode)
+	require.True(t, ownerParams.EnableJournal)
+
+	t.Log("A second claim becomes a guest")
+	guestParams := InitParams{
+		StorageRoot:   root,
+		DiskCacheMode: DiskCacheModeLocal,
+		EnableJournal: true,
+	}
+	releaseGuest, guest, err := claimStorageRoot(ctx, &guestParams, log)
+	require.NoError(t, err)
+	require.True(t, guest)
+	require.Equal(t, root, filepath.Dir(guestParams.StorageRoot))
+	require.Equal(t, DiskCacheModeRemote, guestParams.DiskCacheMode)
+	require.False(t, guestParams.EnableJournal)
+
+	t.Log("A guest's directory is removed when it releases it")
+	releaseGuest()
+	_, err = ioutil.Stat(guestParams.StorageRoot)
+	require.True(t, ioutil.IsNotExist(err))
+
+	t.Log("The next owner removes directories left behind by guests")
+	releaseGuest, _, err = claimStorageRoot(ctx, &guestParams, log)
+	require.NoError(t, err)
+	// Simulate a guest exiting without cleaning up.
+	abandonedDir := filepath.Join(root, storageRootGuestDirPrefix+"abandoned")
+	err = ioutil.Mkdir(abandonedDir, 0700)
+	require.NoError(t, err)
+	releaseOwner()
+	ownerParams.StorageRoot = root
+	releaseOwner, guest, err = claimStorageRoot(ctx, &ownerParams, log)
+	require.NoError(t, err)
+	require.False(t, guest)
+	_, err = ioutil.Stat(abandonedDir)
+	require.True(t, ioutil.IsNotExist(err))
+	_, err = ioutil.Stat(guestParams.StorageRoot)
+	require.NoError(t, err, "A running guest's directory was removed")
+
+	releaseGuest()
+	releaseOwner()
+}
@@ -0,0 +1,34 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build !windows
+
+package libkbfs
+
+import (
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// lockFileExclusive creates the file at `path` if needed, and locks
+// it.  The lock is released when the returned file is closed, or
+// when the process exits.
+func lockFileExclusive(path string) (io.Closer, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		f.Close()
+		return nil, errors.WithStack(errStorageRootLocked)
+	} else if err != nil {
+		f.Close()
+		return nil, errors.WithStack(err)
+	}
+	return f, nil
+}
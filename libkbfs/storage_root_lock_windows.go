@@ -0,0 +1,38 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build windows
+
+package libkbfs
+
+import (
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// errorSharingViolation is ERROR_SHARING_VIOLATION.
+const errorSharingViolation syscall.Errno = 32
+
+// lockFileExclusive creates the file at `path` if needed, and opens
+// it without sharing, so that no other process can open it.  The
+// lock is released when the returned file is closed, or when the
+// process exits.
+func lockFileExclusive(path string) (io.Closer, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	h, err := syscall.CreateFile(p,
+		syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil,
+		syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err == errorSharingViolation {
+		return nil, errors.WithStack(errStorageRootLocked)
+	} else if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return os.NewFile(uintptr(h), path), nil
+}
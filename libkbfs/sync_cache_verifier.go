@@ -0,0 +1,268 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// SyncCacheReport summarizes how the sync cache contents for a synced
+// TLF compare with the blocks reachable from its latest revision.
+type SyncCacheReport struct {
+	Revision kbfsmd.Revision
+	// Reachable is the number of distinct blocks reachable from the
+	// root of Revision.
+	Reachable int
+	// Missing lists reachable blocks that aren't in the sync cache.
+	Missing []kbfsblock.ID `json:",omitempty"`
+	// Stale lists cached blocks whose contents don't match their ID.
+	Stale []kbfsblock.ID `json:",omitempty"`
+	// Orphaned lists cached blocks that are no longer reachable.
+	Orphaned []kbfsblock.ID `json:",omitempty"`
+	// Retained lists cached blocks that are no longer reachable
+	// from Revision, but still are from older revisions that
+	// haven't been garbage-collected yet.  They're kept in the
+	// cache, so those revisions can still be read offline.
+	Retained []kbfsblock.ID `json:",omitempty"`
+	// Repaired is true if the problems above have been fixed.
+	Repaired bool
+}
+
+type syncCacheVerifier struct {
+	fbo       *folderBranchOps
+	kmd       KeyMetadata
+	cache     *DiskBlockCacheLocal
+	repair    bool
+	report    SyncCacheReport
+	reachable map[kbfsblock.ID]bool
+	// sparse matches the files that are synced sparsely, whose
+	// blocks needn't all be cached.
+	sparse *KBFSIgnore
+}
+
+// checkBlock compares the cached copy of `ptr` against its ID, and
+// returns the verified contents of the block.  Only the sync cache
+// itself is consulted, so that checking doesn't change what's
+// cached.  Blocks that are missing or stale are fetched directly from
+// the block server, and are only put into the cache when repairing.
+func (v *syncCacheVerifier) checkBlock(
+	ctx context.Context, ptr BlockPointer) (
+	[]byte, kbfscrypto.BlockCryptKeyServerHalf, error) {
+	tlfID := v.fbo.id()
+	buf, serverHalf, _, err := v.cache.Get(ctx, tlfID, ptr.ID)
+	switch errors.Cause(err).(type) {
+	case nil:
+		if kbfsblock.VerifyID(buf, ptr.ID) == nil {
+			return buf, serverHalf, nil
+		}
+		v.report.Stale = append(v.report.Stale, ptr.ID)
+		if v.repair {
+			_, _, err := v.cache.Delete(ctx, []kbfsblock.ID{ptr.ID})
+			if err != nil {
+				return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+			}
+		}
+	case NoSuchBlockError:
+		v.report.Missing = append(v.report.Missing, ptr.ID)
+	default:
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+	}
+
+	buf, serverHalf, err = v.fbo.config.BlockServer().Get(
+		ctx, tlfID, ptr.ID, ptr.Context)
+	if err != nil {
+		return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+	}
+	if v.repair {
+		err = v.cache.Put(ctx, tlfID, ptr.ID, buf, serverHalf)
+		if err != nil {
+			return nil, kbfscrypto.BlockCryptKeyServerHalf{}, err
+		}
+	}
+	return buf, serverHalf, nil
+}
+
+// walk checks every block reachable from `rootPtr`, which points to a
+// directory.
+func (v *syncCacheVerifier) walk(
+	ctx context.Context, rootPtr BlockPointer) error {
+	type pending struct {
+		ptr   BlockPointer
+		isDir bool
+	}
+	stack := []pending{{rootPtr, true}}
+	for len(stack) > 0 {
+		p := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if v.reachable[p.ptr.ID] {
+			continue
+		}
+		v.reachable[p.ptr.ID] = true
+		buf, serverHalf, err := v.checkBlock(ctx, p.ptr)
+		if err != nil {
+			return err
+		}
+
+		var block BlockWithPtrs
+		if p.isDir {
+			block = NewDirBlockWithPtrs(false)
+		} else {
+			block = NewFileBlockWithPtrs(false)
+		}
+		// Decode the block directly, rather than through the block
+		// ops, which would put it into the caches.
+		err = assembleBlock(ctx, v.fbo.config.keyGetter(),
+			v.fbo.config.Codec(), v.fbo.config.cryptoPure(),
+			v.fbo.config.BlockTransform(), v.kmd, p.ptr, block, buf,
+			serverHalf)
+		if err != nil {
+			return err
+		}
+
+		if block.IsIndirect() {
+			for i := 0; i < block.NumIndirectPtrs(); i++ {
+				info, _ := block.IndirectPtr(i)
+				stack = append(stack, pending{info.BlockPointer, p.isDir})
+			}
+			continue
+		}
+		if db, ok := block.(*DirBlock); ok {
+			for name, de := range db.Children {
+				if de.Type == Sym ||
+					(de.Type != Dir && v.sparse.Match(name, false)) {
+					continue
+				}
+				stack = append(stack, pending{de.BlockPointer, de.Type == Dir})
+			}
+		}
+	}
+	return nil
+}
+
+// getRetainedBlocks returns the blocks that were unreferenced by the
+// merged revisions after the last garbage collection of the TLF, up
+// to `head`.  The server still has them, and older revisions still
+// reference them.
+func (v *syncCacheVerifier) getRetainedBlocks(
+	ctx context.Context, head ImmutableRootMetadata) (
+	map[kbfsblock.ID]bool, error) {
+	retained := make(map[kbfsblock.ID]bool)
+	start := head.data.LastGCRevision + 1
+	if start < kbfsmd.RevisionInitial {
+		start = kbfsmd.RevisionInitial
+	}
+	if start > head.Revision() {
+		return retained, nil
+	}
+	rmds, err := getMergedMDUpdatesWithEnd(
+		ctx, v.fbo.config, v.fbo.id(), start, head.Revision(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, rmd := range rmds {
+		for _, op := range rmd.data.Changes.Ops {
+			for _, ptr := range op.Unrefs() {
+				retained[ptr.ID] = true
+			}
+			for _, update := range op.allUpdates() {
+				retained[update.Unref.ID] = true
+			}
+		}
+	}
+	return retained, nil
+}
+
+// VerifySyncCache compares the sync cache contents for this TLF
+// against the blocks reachable from its latest merged revision.  If
+// `repair` is true, missing and stale blocks are re-fetched from the
+// server, and orphaned blocks are removed from the cache; otherwise
+// the cache is left as it is.  Blocks still referenced by revisions
+// that haven't been garbage-collected are never removed.
+func (fbo *folderBranchOps) VerifySyncCache(
+	ctx context.Context, folderBranch FolderBranch, repair bool) (
+	report SyncCacheReport, err error) {
+	fbo.log.CDebugf(ctx, "VerifySyncCache repair=%t", repair)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "VerifySyncCache done: %+v", err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return SyncCacheReport{}, WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+	if !fbo.config.IsSyncedTlf(fbo.id()) {
+		return SyncCacheReport{}, errors.Errorf(
+			"Folder %s is not synced", fbo.id())
+	}
+	dbc, ok := fbo.config.DiskBlockCache().(*diskBlockCacheWrapped)
+	if !ok {
+		return SyncCacheReport{}, errors.New("No local disk block cache")
+	}
+	cache := dbc.getSyncCache()
+	if cache == nil {
+		return SyncCacheReport{}, errors.New("Sync cache is not enabled")
+	}
+
+	// Make sure we're checking against the latest server state.
+	err = fbo.SyncFromServer(ctx, folderBranch, nil)
+	if err != nil {
+		return SyncCacheReport{}, err
+	}
+	lState := makeFBOLockState()
+	head := fbo.getTrustedHead(lState)
+	if head == (ImmutableRootMetadata{}) {
+		return SyncCacheReport{}, errors.New("No head to verify against")
+	}
+
+	v := &syncCacheVerifier{
+		fbo:       fbo,
+		kmd:       head,
+		cache:     cache,
+		repair:    repair,
+		reachable: make(map[kbfsblock.ID]bool),
+	}
+	if ssg, ok := fbo.config.(sparseSyncGetter); ok {
+		v.sparse = ssg.SparseSync(fbo.id())
+	}
+	v.report.Revision = head.Revision()
+	err = v.walk(ctx, head.data.Dir.BlockPointer)
+	if err != nil {
+		return SyncCacheReport{}, err
+	}
+	v.report.Reachable = len(v.reachable)
+
+	cached, err := cache.getBlockIDsForTLF(ctx, fbo.id())
+	if err != nil {
+		return SyncCacheReport{}, err
+	}
+	retained, err := v.getRetainedBlocks(ctx, head)
+	if err != nil {
+		return SyncCacheReport{}, err
+	}
+	for _, id := range cached {
+		switch {
+		case v.reachable[id]:
+		case retained[id]:
+			v.report.Retained = append(v.report.Retained, id)
+		default:
+			v.report.Orphaned = append(v.report.Orphaned, id)
+		}
+	}
+	if repair && len(v.report.Orphaned) > 0 {
+		_, _, err := cache.Delete(ctx, v.report.Orphaned)
+		if err != nil {
+			return SyncCacheReport{}, err
+		}
+	}
+	v.report.Repaired = repair
+	fbo.log.CDebugf(ctx, "Sync cache for revision %d: %d reachable, "+
+		"%d missing, %d stale, %d orphaned, %d retained",
+		v.report.Revision, v.report.Reachable, len(v.report.Missing),
+		len(v.report.Stale), len(v.report.Orphaned), len(v.report.Retained))
+	return v.report, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/keybase/kbfs/kbfsblock"
+	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifySyncCache(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	tempdir, err := ioutil.TempDir(os.TempDir(), "verify_sync_cache")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(tempdir)
+		assert.NoError(t, err)
+	}()
+	err = config.EnableDiskLimiter(tempdir)
+	require.NoError(t, err)
+	config.diskCacheMode = DiskCacheModeLocal
+	err = config.MakeDiskBlockCacheIfNotExists()
+	require.NoError(t, err)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	err = config.SetTlfSyncState(fb.Tlf, true)
+	require.NoError(t, err)
+
+	kbfsOps := config.KBFSOps()
+	dir, _, err := kbfsOps.CreateDir(ctx, rootNode, "a")
+	require.NoError(t, err)
+	file, _, err := kbfsOps.CreateFile(ctx, dir, "b", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, file, []byte("hello"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+
+	t.Log("Nothing was fetched into the sync cache yet.")
+	syncCache := config.DiskBlockCache().(*diskBlockCacheWrapped).getSyncCache()
+	report, err := kbfsOps.VerifySyncCache(ctx, fb, false)
+	require.NoError(t, err)
+	require.Equal(t, 3, report.Reachable)
+	require.Len(t, report.Missing, 3)
+	require.False(t, report.Repaired)
+
+	t.Log("Only verifying doesn't fill the cache.")
+	report, err = kbfsOps.VerifySyncCache(ctx, fb, false)
+	require.NoError(t, err)
+	require.Len(t, report.Missing, 3)
+
+	t.Log("Add an orphaned block, and corrupt one of the real ones.")
+	orphan := kbfsblock.FakeID(1)
+	err = syncCache.Put(ctx, fb.Tlf, orphan, []byte{1, 2, 3},
+		kbfscrypto.MakeBlockCryptKeyServerHalf([32]byte{1}))
+	require.NoError(t, err)
+	stale := report.Missing[0]
+	err = syncCache.Put(ctx, fb.Tlf, stale, []byte{4, 5, 6},
+		kbfscrypto.MakeBlockCryptKeyServerHalf([32]byte{2}))
+	require.NoError(t, err)
+
+	report, err = kbfsOps.VerifySyncCache(ctx, fb, true)
+	require.NoError(t, err)
+	require.Len(t, report.Missing, 2)
+	require.Equal(t, []kbfsblock.ID{stale}, report.Stale)
+	require.Equal(t, []kbfsblock.ID{orphan}, report.Orphaned)
+	require.True(t, report.Repaired)
+
+	t.Log("After the repair, the cache matches the server.")
+	report, err = kbfsOps.VerifySyncCache(ctx, fb, false)
+	require.NoError(t, err)
+	require.Equal(t, 3, report.Reachable)
+	require.Len(t, report.Missing, 0)
+	require.Len(t, report.Stale, 0)
+	require.Len(t, report.Orphaned, 0)
+
+	t.Log("Blocks of older revisions that weren't collected are kept.")
+	err = kbfsOps.Write(ctx, file, []byte("world"), 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, fb)
+	require.NoError(t, err)
+	report, err = kbfsOps.VerifySyncCache(ctx, fb, true)
+	require.NoError(t, err)
+	require.Len(t, report.Orphaned, 0)
+	require.Len(t, report.Retained, 3)
+	for _, id := range report.Retained {
+		_, _, _, err := syncCache.Get(ctx, fb.Tlf, id)
+		require.NoError(t, err)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+)
+
+const (
+	// syncSchedulesFileName is the name of the file, under the
+	// storage root, where the sync schedules are persisted.
+	syncSchedulesFileName = "sync_schedules.json"
+	// syncScheduleCheckPeriod is how often the journal schedules
+	// are re-evaluated.  Windows have minute granularity.
+	syncScheduleCheckPeriod = time.Minute
+	minutesPerDay           = 24 * 60
+)
+
+// SyncWindow is a recurring period of the week, in local time.
+type SyncWindow struct {
+	// Days lists the days of the week on which the window starts.
+	// If empty, the window recurs every day.
+	Days []time.Weekday `json:",omitempty"`
+	// StartMinute and EndMinute are minutes since midnight.  If
+	// EndMinute is less than StartMinute, the window wraps past
+	// midnight into the next day.
+	StartMinute int
+	EndMinute   int
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func parseMinuteOfDay(s string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil {
+		return 0, errors.Errorf("Invalid time of day %q", s)
+	}
+	minute := h*60 + m
+	if h < 0 || m < 0 || m >= 60 || minute > minutesPerDay {
+		return 0, errors.Errorf("Invalid time of day %q", s)
+	}
+	return minute, nil
+}
+
+// ParseSyncWindow parses a window of the form "[days] [HH:MM-HH:MM]",
+// where days is a comma-separated list of three-letter day names,
+// e.g. "01:00-06:00", "sat,sun", or "mon,wed 22:00-02:00".  At least
+// one of the two parts must be present.
+func ParseSyncWindow(s string) (SyncWindow, error) {
+	w := SyncWindow{StartMinute: 0, EndMinute: minutesPerDay}
+	fields := strings.Fields(strings.ToLower(s))
+	if len(fields) == 0 || len(fields) > 2 {
+		return SyncWindow{}, errors.Errorf("Invalid sync window %q", s)
+	}
+	if !strings.Contains(fields[0], ":") {
+		for _, day := range strings.Split(fields[0], ",") {
+			wd, ok := weekdayNames[day]
+			if !ok {
+				return SyncWindow{}, errors.Errorf("Invalid day %q", day)
+			}
+			w.Days = append(w.Days, wd)
+		}
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return w, nil
+	}
+	times := strings.Split(fields[0], "-")
+	if len(times) != 2 {
+		return SyncWindow{}, errors.Errorf("Invalid time range %q", fields[0])
+	}
+	var err error
+	if w.StartMinute, err = parseMinuteOfDay(times[0]); err != nil {
+		return SyncWindow{}, err
+	}
+	if w.EndMinute, err = parseMinuteOfDay(times[1]); err != nil {
+		return SyncWindow{}, err
+	}
+	return w, nil
+}
+
+func (w SyncWindow) startsOn(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+func (w SyncWindow) contains(t time.Time) bool {
+	t = t.Local()
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if w.StartMinute <= w.EndMinute {
+		return w.startsOn(day) &&
+			minute >= w.StartMinute && minute < w.EndMinute
+	}
+	// The window wraps past midnight, so it might have started
+	// either today or yesterday.
+	yesterday := (day + 6) % 7
+	return (w.startsOn(day) && minute >= w.StartMinute) ||
+		(w.startsOn(yesterday) && minute < w.EndMinute)
+}
+
+// SyncSchedule restricts background work to a set of windows.  An
+// empty schedule allows the work at any time.
+type SyncSchedule struct {
+	Windows []SyncWindow `json:",omitempty"`
+}
+
+// ParseSyncSchedule parses a semicolon-separated list of windows
+// (see ParseSyncWindow).  An empty string results in an empty
+// schedule.
+func ParseSyncSchedule(s string) (SyncSchedule, error) {
+	var sched SyncSchedule
+	for _, ws := range strings.Split(s, ";") {
+		if strings.TrimSpace(ws) == "" {
+			continue
+		}
+		w, err := ParseSyncWindow(ws)
+		if err != nil {
+			return SyncSchedule{}, err
+		}
+		sched.Windows = append(sched.Windows, w)
+	}
+	return sched, nil
+}
+
+func (s SyncSchedule) allows(t time.Time) bool {
+	if len(s.Windows) == 0 {
+		return true
+	}
+	for _, w := range s.Windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// SyncSchedules holds the schedules for all kinds of scheduled
+// background work.  It is suitable for encoding directly as JSON.
+type SyncSchedules struct {
+	// JournalFlush restricts when journals are flushed in the
+	// background.  Explicit flushes are always allowed.
+	JournalFlush SyncSchedule
+	// JournalFlushByTLF overrides JournalFlush for specific TLFs.
+	JournalFlushByTLF map[tlf.ID]SyncSchedule `json:",omitempty"`
+	// Prefetch restricts when blocks are prefetched.
+	Prefetch SyncSchedule
+}
+
+func (ss SyncSchedules) allowsJournalFlush(tlfID tlf.ID, t time.Time) bool {
+	if sched, ok := ss.JournalFlushByTLF[tlfID]; ok {
+		return sched.allows(t)
+	}
+	return ss.JournalFlush.allows(t)
+}
+
+func (ss SyncSchedules) allowsPrefetch(t time.Time) bool {
+	return ss.Prefetch.allows(t)
+}
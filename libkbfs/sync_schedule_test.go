@@ -0,0 +1,110 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSyncWindow(t *testing.T) {
+	w, err := ParseSyncWindow("01:00-06:00")
+	require.NoError(t, err)
+	require.Equal(t, SyncWindow{StartMinute: 60, EndMinute: 360}, w)
+
+	w, err = ParseSyncWindow("sat,sun")
+	require.NoError(t, err)
+	require.Equal(t, SyncWindow{
+		Days:        []time.Weekday{time.Saturday, time.Sunday},
+		StartMinute: 0,
+		EndMinute:   minutesPerDay,
+	}, w)
+
+	w, err = ParseSyncWindow("Fri 22:30-02:00")
+	require.NoError(t, err)
+	require.Equal(t, SyncWindow{
+		Days:        []time.Weekday{time.Friday},
+		StartMinute: 22*60 + 30,
+		EndMinute:   120,
+	}, w)
+
+	for _, bad := range []string{"", "funday", "01:00", "25:00-26:00",
+		"01:60-02:00", "mon 01:00-02:00 extra"} {
+		_, err := ParseSyncWindow(bad)
+		require.Error(t, err, bad)
+	}
+}
+
+func TestSyncScheduleAllows(t *testing.T) {
+	// 2018-06-01 is a Friday.
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2018, 6, day, hour, min, 0, 0, time.Local)
+	}
+
+	var empty SyncSchedule
+	require.True(t, empty.allows(at(1, 12, 0)))
+
+	nightly, err := ParseSyncSchedule("01:00-06:00")
+	require.NoError(t, err)
+	require.False(t, nightly.allows(at(1, 0, 59)))
+	require.True(t, nightly.allows(at(1, 1, 0)))
+	require.True(t, nightly.allows(at(1, 5, 59)))
+	require.False(t, nightly.allows(at(1, 6, 0)))
+
+	weekendsAndFridayNight, err := ParseSyncSchedule(
+		"sat,sun; fri 22:00-02:00")
+	require.NoError(t, err)
+	require.False(t, weekendsAndFridayNight.allows(at(1, 21, 59)))
+	require.True(t, weekendsAndFridayNight.allows(at(1, 23, 0)))
+	require.True(t, weekendsAndFridayNight.allows(at(2, 1, 0)))
+	require.True(t, weekendsAndFridayNight.allows(at(3, 23, 59)))
+	require.False(t, weekendsAndFridayNight.allows(at(4, 0, 0)))
+}
+
+func TestJournalServerSyncSchedules(t *testing.T) {
+	tempdir, ctx, cancel, config, _, jServer := setupJournalServerTest(t)
+	defer teardownJournalServerTest(t, tempdir, ctx, cancel, config)
+
+	// 2018-06-01 is a Friday.
+	clock := newTestClockNow()
+	clock.Set(time.Date(2018, 6, 1, 12, 0, 0, 0, time.Local))
+	config.SetClock(clock)
+
+	tlfID1 := tlf.FakeID(2, tlf.Private)
+	err := jServer.Enable(ctx, tlfID1, nil, TLFJournalBackgroundWorkEnabled)
+	require.NoError(t, err)
+	tj1, ok := jServer.getTLFJournal(tlfID1, nil)
+	require.True(t, ok)
+	getPauseType := func(tj *tlfJournal) tlfJournalPauseType {
+		tj.pauseLock.Lock()
+		defer tj.pauseLock.Unlock()
+		return tj.pauseType
+	}
+
+	nightly, err := ParseSyncSchedule("01:00-06:00")
+	require.NoError(t, err)
+	tlfID2 := tlf.FakeID(3, tlf.Private)
+	err = config.SetSyncSchedules(ctx, SyncSchedules{
+		JournalFlush:      nightly,
+		JournalFlushByTLF: map[tlf.ID]SyncSchedule{tlfID2: {}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, journalPauseSchedule, getPauseType(tj1))
+
+	t.Log("A new journal with an overridden schedule isn't paused")
+	err = jServer.Enable(ctx, tlfID2, nil, TLFJournalBackgroundWorkEnabled)
+	require.NoError(t, err)
+	tj2, ok := jServer.getTLFJournal(tlfID2, nil)
+	require.True(t, ok)
+	require.Equal(t, tlfJournalPauseType(0), getPauseType(tj2))
+
+	t.Log("Entering the window resumes the journal")
+	clock.Set(time.Date(2018, 6, 2, 1, 30, 0, 0, time.Local))
+	jServer.applySyncSchedules(ctx)
+	require.Equal(t, tlfJournalPauseType(0), getPauseType(tj1))
+}
@@ -0,0 +1,137 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"strings"
+	"time"
+
+	kbname "github.com/keybase/client/go/kbun"
+	"github.com/keybase/client/go/protocol/keybase1"
+	"golang.org/x/net/context"
+)
+
+const (
+	// teamRenamesFileName is the name of the file, under the storage
+	// root, where the known team renames are persisted.
+	teamRenamesFileName = "team_renames.json"
+)
+
+// TeamRename records that a team was renamed while this device had
+// its folder loaded, so that paths using the old name can be
+// redirected to the folder.
+type TeamRename struct {
+	OldName kbname.NormalizedUsername
+	// NewName is the name the team was renamed to.  The team may
+	// have been renamed again since.
+	NewName kbname.NormalizedUsername
+	TeamID  keybase1.TeamID
+	Time    time.Time
+}
+
+// TeamRenames maps old team names to the renames away from them.
+type TeamRenames map[kbname.NormalizedUsername]TeamRename
+
+// teamRenameTracker is implemented by KBPKIClient owners that keep
+// track of team renames, and can notify users when an old team name
+// is redirected.
+type teamRenameTracker interface {
+	teamRenamesGetter
+	Reporter() Reporter
+}
+
+// teamNameFromAssertion returns the team name in a team assertion,
+// like "team:t1" or "t1@team".
+func teamNameFromAssertion(assertion string) (string, bool) {
+	switch {
+	case strings.HasPrefix(assertion, "team:"):
+		return strings.TrimPrefix(assertion, "team:"), true
+	case strings.HasSuffix(assertion, "@team"):
+		return strings.TrimSuffix(assertion, "@team"), true
+	default:
+		return "", false
+	}
+}
+
+// teamRenameNotification creates FSNotifications for an old team
+// folder name that was redirected to the team's current name.
+func teamRenameNotification(
+	oldName, newName kbname.NormalizedUsername) *keybase1.FSNotification {
+	return &keybase1.FSNotification{
+		FolderType:       keybase1.FolderType_TEAM,
+		Filename:         BuildCanonicalPath(SingleTeamPathType, string(newName)),
+		StatusCode:       keybase1.FSStatusCode_FINISH,
+		NotificationType: keybase1.FSNotificationType_FILE_RENAMED,
+		Params: map[string]string{
+			errorParamRenameOldFilename: BuildCanonicalPath(
+				SingleTeamPathType, string(oldName)),
+		},
+	}
+}
+
+// recordTeamRenameLocked remembers that the team folder formerly
+// named `oldName` now has the handle `newHandle`.
+func (fs *KBFSOpsStandard) recordTeamRenameLocked(
+	ctx context.Context, oldName string, newHandle *TlfHandle) {
+	tid, err := newHandle.FirstResolvedWriter().AsTeam()
+	if err != nil {
+		fs.log.CWarningf(ctx, "Renamed team folder has no team: %+v", err)
+		return
+	}
+	newName := kbname.NormalizedUsername(newHandle.GetCanonicalName())
+	renames := make(TeamRenames)
+	for name, r := range fs.config.TeamRenames() {
+		// A name the team has taken back isn't a redirect anymore.
+		if name == newName {
+			continue
+		}
+		renames[name] = r
+	}
+	renames[kbname.NormalizedUsername(oldName)] = TeamRename{
+		OldName: kbname.NormalizedUsername(oldName),
+		NewName: newName,
+		TeamID:  tid,
+		Time:    fs.config.Clock().Now(),
+	}
+	fs.log.CDebugf(ctx, "Recording team rename: %s -> %s", oldName, newName)
+	err = fs.config.SetTeamRenames(renames)
+	if err != nil {
+		fs.log.CWarningf(ctx, "Couldn't save team renames: %+v", err)
+	}
+}
+
+// resolveTeamRename resolves a team assertion whose name no longer
+// exists, if the owner knows that the team was renamed.  It returns
+// the team's current name, which makes the old name resolve as a
+// non-canonical alias of the renamed folder.
+func (k *KBPKIClient) resolveTeamRename(
+	ctx context.Context, assertion string) (
+	kbname.NormalizedUsername, keybase1.TeamID, bool) {
+	tracker, ok := k.serviceOwner.(teamRenameTracker)
+	if !ok {
+		return "", "", false
+	}
+	oldName, ok := teamNameFromAssertion(assertion)
+	if !ok {
+		return "", "", false
+	}
+	rename, ok := tracker.TeamRenames()[kbname.NewNormalizedUsername(oldName)]
+	if !ok {
+		return "", "", false
+	}
+	// The team may have been renamed again since, so look up its
+	// current name.
+	newName, err := k.GetNormalizedUsername(
+		ctx, rename.TeamID.AsUserOrTeam())
+	if err != nil {
+		k.log.CDebugf(ctx, "Couldn't get the current name of renamed "+
+			"team %s: %+v", rename.TeamID, err)
+		return "", "", false
+	}
+	k.log.CDebugf(ctx, "Redirecting renamed team %s to %s", oldName, newName)
+	tracker.Reporter().Notify(
+		ctx, teamRenameNotification(rename.OldName, newName))
+	return newName, rename.TeamID, true
+}
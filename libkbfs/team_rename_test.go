@@ -0,0 +1,82 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	kbname "github.com/keybase/client/go/kbun"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTeamRenameRedirect(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+
+	name := kbname.NormalizedUsername("t1")
+	teamInfos := AddEmptyTeamsForTestOrBust(t, config, name)
+	tid := teamInfos[0].TID
+	AddTeamWriterForTestOrBust(t, config, tid, session.UID)
+
+	h, err := ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), string(name), tlf.SingleTeam)
+	require.NoError(t, err)
+	kbfsOps := config.KBFSOps()
+	rootNode, _, err := kbfsOps.GetOrCreateRootNode(ctx, h, MasterBranch)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+
+	t.Log("Without a known rename, the old name doesn't resolve")
+	_, err = ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "t0", tlf.SingleTeam)
+	require.Error(t, err)
+
+	t.Log("Rename the team, and tell the folder about it")
+	// Notify the folder directly, rather than through KBFSOps, which
+	// would do it in the background.
+	daemon := config.KeybaseService().(*KeybaseDaemonLocal)
+	daemon.changeTeamNameForTestOrBust("t1", "t2")
+	ops := getOps(config, rootNode.GetFolderBranch().Tlf)
+	ops.TeamNameChanged(ctx, tid)
+	renames := config.TeamRenames()
+	require.Len(t, renames, 1)
+	require.Equal(t, kbname.NormalizedUsername("t2"), renames["t1"].NewName)
+	require.Equal(t, tid, renames["t1"].TeamID)
+
+	t.Log("The old name is now a non-canonical alias of the new one")
+	_, err = ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "t1", tlf.SingleTeam)
+	require.Equal(t, TlfNameNotCanonical{"t1", "t2"}, errors.Cause(err))
+	h2, err := GetHandleFromFolderNameAndType(
+		ctx, config.KBPKI(), config.MDOps(), "t1", tlf.SingleTeam)
+	require.NoError(t, err)
+	require.Equal(t, tlf.CanonicalName("t2"), h2.GetCanonicalName())
+	require.Equal(t, rootNode.GetFolderBranch().Tlf, h2.tlfID)
+
+	t.Log("A second rename redirects both old names to the latest")
+	daemon.changeTeamNameForTestOrBust("t2", "t3")
+	ops.TeamNameChanged(ctx, tid)
+	require.Len(t, config.TeamRenames(), 2)
+	for _, old := range []string{"t1", "t2"} {
+		_, err = ParseTlfHandle(
+			ctx, config.KBPKI(), config.MDOps(), old, tlf.SingleTeam)
+		require.Equal(t, TlfNameNotCanonical{old, "t3"}, errors.Cause(err))
+	}
+
+	t.Log("Taking an old name back removes its redirect")
+	daemon.changeTeamNameForTestOrBust("t3", "t1")
+	ops.TeamNameChanged(ctx, tid)
+	renames = config.TeamRenames()
+	require.Len(t, renames, 2)
+	require.NotContains(t, renames, kbname.NormalizedUsername("t1"))
+	require.Equal(t, kbname.NormalizedUsername("t1"), renames["t3"].NewName)
+}
@@ -32,6 +32,10 @@ const (
 	// EnvTestBServerAddr is the environment variable name for a block
 	// server address.
 	EnvTestBServerAddr = "KEYBASE_TEST_BSERVER_ADDR"
+	// EnvTestBServerProfile is the environment variable name for the
+	// name of a network profile (see NetworkProfileByName) to shape
+	// the test block server's traffic with.
+	EnvTestBServerProfile = "KEYBASE_TEST_BSERVER_PROFILE"
 	// TempdirServerAddr is the special value of the
 	// EnvTest{B,MD}ServerAddr environment value to signify that
 	// an on-disk implementation of the {b,md}server should be
@@ -73,26 +77,35 @@ func MakeTestBlockServerOrBust(t logger.TestLogBackend,
 	config blockServerRemoteConfig,
 	rpcLogFactory rpc.LogFactory) BlockServer {
 	// see if a local remote server is specified
+	var blockServer BlockServer
 	bserverAddr := os.Getenv(EnvTestBServerAddr)
 	switch {
 	case bserverAddr == TempdirServerAddr:
 		var err error
-		blockServer, err := NewBlockServerTempDir(config.Codec(), config.MakeLogger(""))
+		blockServer, err = NewBlockServerTempDir(config.Codec(), config.MakeLogger(""))
 		if err != nil {
 			t.Fatal(err)
 		}
-		return blockServer
 
 	case len(bserverAddr) != 0:
 		remote, err := rpc.ParsePrioritizedRoundRobinRemote(bserverAddr)
 		if err != nil {
 			t.Fatal(err)
 		}
-		return NewBlockServerRemote(config, remote, rpcLogFactory)
+		blockServer = NewBlockServerRemote(config, remote, rpcLogFactory)
 
 	default:
-		return NewBlockServerMemory(config.MakeLogger(""))
+		blockServer = NewBlockServerMemory(config.MakeLogger(""))
 	}
+
+	if profileName := os.Getenv(EnvTestBServerProfile); profileName != "" {
+		profile, err := NetworkProfileByName(profileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blockServer = NewBlockServerShaped(blockServer, profile, 1)
+	}
+	return blockServer
 }
 
 // TODO: Put the below code (also duplicated in kbfs-server) somewhere
@@ -240,6 +253,7 @@ func ConfigAsUserWithMode(config *ConfigLocal,
 	loggedInUser kbname.NormalizedUsername, mode InitModeType) *ConfigLocal {
 	c := newConfigForTest(mode, config.loggerFn)
 	c.SetMetadataVersion(config.MetadataVersion())
+	c.SetDataVersion(config.DataVersion())
 	c.SetRekeyWithPromptWaitTime(config.RekeyWithPromptWaitTime())
 
 	kbfsOps := NewKBFSOpsStandard(env.EmptyAppStateUpdater{}, c)
@@ -495,6 +509,27 @@ func AddTeamWriterForTestOrBust(t logger.TestLogBackend, config Config,
 	}
 }
 
+// AddTeamAdminForTest makes the given user a team admin.
+func AddTeamAdminForTest(
+	config Config, tid keybase1.TeamID, uid keybase1.UID) error {
+	kbd, ok := config.KeybaseService().(*KeybaseDaemonLocal)
+	if !ok {
+		return errors.New("Bad keybase daemon")
+	}
+
+	return kbd.addTeamAdminForTest(tid, uid)
+}
+
+// AddTeamAdminForTestOrBust is like AddTeamAdminForTest, but
+// dies if there's an error.
+func AddTeamAdminForTestOrBust(t logger.TestLogBackend, config Config,
+	tid keybase1.TeamID, uid keybase1.UID) {
+	err := AddTeamAdminForTest(config, tid, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 // RemoveTeamWriterForTest removes the given user from a team.
 func RemoveTeamWriterForTest(
 	config Config, tid keybase1.TeamID, uid keybase1.UID) error {
@@ -86,6 +86,16 @@ const (
 	// by the journal.  TODO: make this configurable, so that users
 	// can choose how much bandwidth is used by the journal.
 	maxJournalBlockFlushBatchSize = 25
+	// Maximum number of block bytes that can be flushed in a single
+	// batch by the journal.  Since all the data of a batch is read
+	// into memory, and a batch is only removed from the journal once
+	// all of it has been put, this keeps batches of large blocks from
+	// using too much memory, and bounds how much an interrupted flush
+	// has to put again when it resumes.  It's well below
+	// maxJournalBlockFlushBatchSize blocks of the maximum size, so
+	// batches of small blocks are bounded by count, and batches of
+	// large blocks by bytes.
+	maxJournalBlockFlushBatchBytes = int64(4 << 20)
 	// This will be the final entry for unflushed paths if there are
 	// too many revisions to process at once.
 	incompleteUnflushedPathsMarker = "..."
@@ -157,6 +167,8 @@ type tlfJournalPauseType int
 const (
 	journalPauseConflict tlfJournalPauseType = 1 << iota
 	journalPauseCommand
+	journalPauseDeviceConstraints
+	journalPauseSchedule
 )
 
 func (bws TLFJournalBackgroundWorkStatus) String() string {
@@ -247,6 +259,9 @@ type tlfJournal struct {
 	// blockJournal.getStoredBytes() and
 	// blockJournal.getStoredFiles() until shutdown.
 	diskLimiter DiskLimiter
+	// flushBudget is shared by the background flushes of all
+	// journals; see withBackgroundFlush.  May be nil.
+	flushBudget *kbfssync.Semaphore
 
 	// All the channels below are used as simple on/off
 	// signals. They're buffered for one object, and all sends are
@@ -283,7 +298,7 @@ type tlfJournal struct {
 	//
 	// TODO: Consider using https://github.com/pkg/singlefile
 	// instead.
-	journalLock sync.RWMutex
+	journalLock orderCheckedRWMutex
 	// both of these are nil after shutdown() is called.
 	blockJournal   *blockJournal
 	mdJournal      *mdJournal
@@ -351,7 +366,8 @@ func makeTLFJournal(
 	config tlfJournalConfig, delegateBlockServer BlockServer,
 	bws TLFJournalBackgroundWorkStatus, bwDelegate tlfJournalBWDelegate,
 	onBranchChange branchChangeListener, onMDFlush mdFlushListener,
-	diskLimiter DiskLimiter) (*tlfJournal, error) {
+	diskLimiter DiskLimiter, flushBudget *kbfssync.Semaphore) (
+	*tlfJournal, error) {
 	if uid == keybase1.UID("") {
 		return nil, errors.New("Empty user")
 	}
@@ -426,6 +442,7 @@ func makeTLFJournal(
 	// user's.
 
 	j := &tlfJournal{
+		journalLock:          orderCheckedRWMutex{name: "tlfJournal.journalLock"},
 		uid:                  uid,
 		key:                  key,
 		tlfID:                tlfID,
@@ -439,6 +456,7 @@ func makeTLFJournal(
 		onMDFlush:            onMDFlush,
 		forcedSquashByBytes:  ForcedBranchSquashBytesThresholdDefault,
 		diskLimiter:          diskLimiter,
+		flushBudget:          flushBudget,
 		hasWorkCh:            make(chan struct{}, 1),
 		needPauseCh:          make(chan struct{}, 1),
 		needResumeCh:         make(chan struct{}, 1),
@@ -702,6 +720,10 @@ func (j *tlfJournal) doBackgroundWorkLoop(
 func (j *tlfJournal) doBackgroundWork(ctx context.Context) <-chan error {
 	errCh := make(chan error, 1)
 	// TODO: Handle panics.
+	// Mark the flush as background work, so it stays out of the way
+	// of interactive operations.  Explicit flushes (e.g., from
+	// fsync) don't go through here, since someone is waiting on them.
+	ctx = withBackgroundFlush(ctx, j.flushBudget)
 	go func() {
 		defer j.wg.Done()
 		errCh <- j.flush(ctx)
@@ -1031,7 +1053,7 @@ func (j *tlfJournal) getNextBlockEntriesToFlush(
 	}
 
 	return j.blockJournal.getNextEntriesToFlush(ctx, end,
-		maxJournalBlockFlushBatchSize)
+		maxJournalBlockFlushBatchSize, maxJournalBlockFlushBatchBytes)
 }
 
 func (j *tlfJournal) removeFlushedBlockEntries(ctx context.Context,
@@ -1518,6 +1540,9 @@ func (j *tlfJournal) flushOneMDOp(ctx context.Context,
 		rmds.MD.TlfID(), mdID, rmds.MD.RevisionNumber(), rmds.MD.BID())
 	pushErr := mdServer.Put(ctx, rmds, extra,
 		flushCtx.lockContextForPut, flushCtx.priorityForPut)
+	if g, ok := j.config.(kbfsOpsGetter); ok && pushErr == nil {
+		recordMDUploaded(g, j.config.Codec(), rmds)
+	}
 	if isRevisionConflict(pushErr) {
 		headMdID, err := getMdID(ctx, mdServer, j.config.Codec(),
 			rmds.MD.TlfID(), rmds.MD.BID(), rmds.MD.MergedStatus(),
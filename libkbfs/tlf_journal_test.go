@@ -279,7 +279,7 @@ func setupTLFJournalTest(
 		math.MaxInt64, math.MaxInt64, math.MaxInt64)
 	tlfJournal, err = makeTLFJournal(ctx, uid, verifyingKey,
 		tempdir, config.tlfID, uid.AsUserOrTeam(), config, delegateBlockServer,
-		bwStatus, delegate, nil, nil, diskLimitSemaphore)
+		bwStatus, delegate, nil, nil, diskLimitSemaphore, nil)
 	require.NoError(t, err)
 
 	switch bwStatus {
@@ -435,6 +435,40 @@ func testTLFJournalBlockOpBasic(t *testing.T, ver kbfsmd.MetadataVer) {
 	require.False(t, converted)
 }
 
+func testTLFJournalBlockOpFlushByteLimit(
+	t *testing.T, ver kbfsmd.MetadataVer) {
+	tempdir, config, ctx, cancel, tlfJournal, delegate :=
+		setupTLFJournalTest(t, ver, TLFJournalBackgroundWorkPaused)
+	defer teardownTLFJournalTest(
+		tempdir, config, ctx, cancel, tlfJournal, delegate)
+
+	// Fill the journal with more maximum-size blocks than fit in one
+	// batch's bytes, but fewer than its count.
+	blockSize := int64(MaxBlockSizeBytesDefault)
+	perBatch := int(maxJournalBlockFlushBatchBytes / blockSize)
+	require.True(t, perBatch < maxJournalBlockFlushBatchSize)
+	for i := 0; i < perBatch+1; i++ {
+		data := make([]byte, blockSize)
+		data[0] = byte(i)
+		putBlock(ctx, t, config, tlfJournal, data)
+	}
+
+	blockEnd, _, err := tlfJournal.getJournalEnds(ctx)
+	require.NoError(t, err)
+	entries, b, _, err := tlfJournal.getNextBlockEntriesToFlush(
+		ctx, blockEnd)
+	require.NoError(t, err)
+	require.Equal(t, perBatch, entries.length())
+	require.Equal(t, maxJournalBlockFlushBatchBytes, b)
+
+	numFlushed, _, _, err := tlfJournal.flushBlockEntries(ctx, blockEnd)
+	require.NoError(t, err)
+	require.Equal(t, perBatch, numFlushed)
+	numFlushed, _, _, err = tlfJournal.flushBlockEntries(ctx, blockEnd)
+	require.NoError(t, err)
+	require.Equal(t, 1, numFlushed)
+}
+
 func testTLFJournalBlockOpBusyPause(t *testing.T, ver kbfsmd.MetadataVer) {
 	tempdir, config, ctx, cancel, tlfJournal, delegate :=
 		setupTLFJournalTest(t, ver, TLFJournalBackgroundWorkEnabled)
@@ -1862,6 +1896,7 @@ func TestTLFJournal(t *testing.T) {
 		testTLFJournalPauseResume,
 		testTLFJournalPauseShutdown,
 		testTLFJournalBlockOpBasic,
+		testTLFJournalBlockOpFlushByteLimit,
 		testTLFJournalBlockOpBusyPause,
 		testTLFJournalBlockOpBusyShutdown,
 		testTLFJournalSecondBlockOpWhileBusy,
@@ -0,0 +1,512 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"encoding/json"
+	stdpath "path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// TlfSettingsFileName is the name of a file, at the root of a TLF,
+// holding a JSON-encoded TlfSettings document.  Since it's stored in
+// the TLF like any other file, every change to it is a new revision
+// of the TLF, and all devices apply the settings of the revision
+// they're at.  In team TLFs, clients only let the team's admins
+// change it.
+const TlfSettingsFileName = ".kbfs_settings"
+
+// TlfSettingsVersion is the only version of the settings document
+// this code understands.  Documents with other versions are rejected
+// as a whole, rather than partially applied.
+const TlfSettingsVersion = 1
+
+// maxTlfSettingsSize caps how much of a settings file is read.
+const maxTlfSettingsSize = 64 * 1024
+
+// TlfSettings are the folder-wide settings of a TLF.  Each setting
+// uses the syntax of the older single-purpose file at the root of
+// the TLF that it corresponds to, and is combined with that file as
+// described below.
+type TlfSettings struct {
+	// Version must be TlfSettingsVersion.
+	Version int
+	// Ignore lists .kbfsignore patterns, one per element, for
+	// entries to exclude from deep prefetching.  Patterns in the
+	// .kbfsignore file are applied after these, so they can
+	// override them.
+	Ignore []string `json:",omitempty"`
+	// HistoryRetention, if set, replaces the contents of the
+	// .kbfs_history_retention file.
+	HistoryRetention string `json:",omitempty"`
+	// FileFlags lists .kbfs_file_flags flags, which are added to the
+	// flags in that file.
+	FileFlags []string `json:",omitempty"`
+	// TrashUnlinks asks mounts that follow folder settings for
+	// unlinks to move unlinked files into the TLF's .trash
+	// directory, rather than removing them.
+	TrashUnlinks bool `json:",omitempty"`
+	// TrashRetention, if set, is how long entries stay in the TLF's
+	// .trash directory before they're purged, in the format of
+	// HistoryRetention; the default is DefaultTrashRetention.
+	TrashRetention string `json:",omitempty"`
+	// DropFolders lists the directories of the TLF that are one-way
+	// drop folders.
+	DropFolders []DropFolder `json:",omitempty"`
+	// DataRegion, if set, is the data region that the TLF's blocks
+	// must be put to.  Clients refuse to put its blocks to a block
+	// server outside that region; see BlockServerRegions.
+	DataRegion string `json:",omitempty"`
+	// WriteThrough asks clients to skip their local journal for the
+	// TLF, so that syncs only return once the servers have the
+	// data; see SetTlfWriteThrough.
+	WriteThrough bool `json:",omitempty"`
+	// DLPRules restrict which files may be written to the TLF; see
+	// DLPRule.
+	DLPRules []DLPRule `json:",omitempty"`
+	// LegalHoldRetention, if set, puts the TLF under a legal hold:
+	// the data unreferenced by a revision can't be reclaimed until
+	// the revision is older than this, in the format of
+	// HistoryRetention.  See GetHeldRevisions.
+	LegalHoldRetention string `json:",omitempty"`
+	// AccessLog turns on read access logging: the devices of the
+	// TLF's writers, and of the team members who opt in with
+	// SetAccessLogOptIn, keep a device-local log of the files they
+	// read; see GetAccessLog.
+	AccessLog bool `json:",omitempty"`
+}
+
+// DropFolder makes a directory of a TLF a one-way drop folder, where
+// writers can add files, but can't list or read each other's files.
+// Each submission is stored under a random name, encrypted with its
+// own key, which is only given to the collectors and the submitter.
+// This is enforced by clients rather than by the server: a writer
+// can still see how many submissions there are and how big they are,
+// and can delete them.
+type DropFolder struct {
+	// Path is the directory, relative to the TLF root, using "/"
+	// as the separator.
+	Path string
+	// Collectors are the users who can read every submission.
+	Collectors []string
+	// Deadline, if set, is when the folder stops accepting
+	// submissions.
+	Deadline *time.Time `json:",omitempty"`
+}
+
+func (d DropFolder) validate() error {
+	if d.Path == "" || stdpath.IsAbs(d.Path) ||
+		stdpath.Clean(d.Path) != d.Path || d.Path == "." ||
+		d.Path == ".." || strings.HasPrefix(d.Path, "../") {
+		return errors.Errorf("invalid drop folder path %q", d.Path)
+	}
+	if len(d.Collectors) == 0 {
+		return errors.Errorf("drop folder %q has no collectors", d.Path)
+	}
+	for _, c := range d.Collectors {
+		if c == "" {
+			return errors.Errorf(
+				"drop folder %q has an empty collector", d.Path)
+		}
+	}
+	return nil
+}
+
+// Validate returns an InvalidTlfSettingsError describing the first
+// invalid setting, if any.
+func (s TlfSettings) Validate() error {
+	if s.Version != TlfSettingsVersion {
+		return errors.WithStack(InvalidTlfSettingsError{errors.Errorf(
+			"unsupported version %d", s.Version)})
+	}
+	for _, p := range s.Ignore {
+		if len(ParseKBFSIgnore([]byte(p)).patterns) != 1 {
+			return errors.WithStack(InvalidTlfSettingsError{errors.Errorf(
+				"invalid ignore pattern %q", p)})
+		}
+	}
+	if s.HistoryRetention != "" {
+		if _, err := ParseHistoryRetention(
+			[]byte(s.HistoryRetention)); err != nil {
+			return errors.WithStack(InvalidTlfSettingsError{err})
+		}
+	}
+	_, err := ParseFileFlags([]byte(strings.Join(s.FileFlags, "\n")))
+	if err != nil {
+		return errors.WithStack(InvalidTlfSettingsError{err})
+	}
+	if s.TrashRetention != "" {
+		if _, err := ParseHistoryRetention(
+			[]byte(s.TrashRetention)); err != nil {
+			return errors.WithStack(InvalidTlfSettingsError{err})
+		}
+	}
+	if s.LegalHoldRetention != "" {
+		if _, err := ParseHistoryRetention(
+			[]byte(s.LegalHoldRetention)); err != nil {
+			return errors.WithStack(InvalidTlfSettingsError{err})
+		}
+	}
+	paths := make(map[string]bool, len(s.DropFolders))
+	for _, d := range s.DropFolders {
+		if err := d.validate(); err != nil {
+			return errors.WithStack(InvalidTlfSettingsError{err})
+		}
+		if paths[d.Path] {
+			return errors.WithStack(InvalidTlfSettingsError{errors.Errorf(
+				"duplicate drop folder %q", d.Path)})
+		}
+		paths[d.Path] = true
+	}
+	if s.DataRegion != "" {
+		if err := CheckDataRegion(s.DataRegion); err != nil {
+			return errors.WithStack(InvalidTlfSettingsError{err})
+		}
+	}
+	for _, r := range s.DLPRules {
+		if err := r.validate(); err != nil {
+			return errors.WithStack(InvalidTlfSettingsError{err})
+		}
+	}
+	return nil
+}
+
+// GetKBFSIgnore returns the ignore rules of the settings, or nil if
+// there are none.
+func (s TlfSettings) GetKBFSIgnore() *KBFSIgnore {
+	if len(s.Ignore) == 0 {
+		return nil
+	}
+	return ParseKBFSIgnore([]byte(strings.Join(s.Ignore, "\n")))
+}
+
+// GetHistoryRetention returns the history retention of the settings,
+// or 0 if they don't set one.
+func (s TlfSettings) GetHistoryRetention() time.Duration {
+	if s.HistoryRetention == "" {
+		return 0
+	}
+	retention, err := ParseHistoryRetention([]byte(s.HistoryRetention))
+	if err != nil {
+		return 0
+	}
+	return retention
+}
+
+// DefaultTrashRetention is how long entries stay in the trash of a
+// TLF whose settings don't set a TrashRetention.
+const DefaultTrashRetention = 7 * 24 * time.Hour
+
+// GetTrashRetention returns how long entries stay in the trash of
+// the TLF, or HistoryRetentionForever if they're never purged.
+func (s TlfSettings) GetTrashRetention() time.Duration {
+	if s.TrashRetention == "" {
+		return DefaultTrashRetention
+	}
+	retention, err := ParseHistoryRetention([]byte(s.TrashRetention))
+	if err != nil {
+		return DefaultTrashRetention
+	}
+	return retention
+}
+
+// GetLegalHoldRetention returns the retention period of the TLF's
+// legal hold, or 0 if it isn't under one.
+func (s TlfSettings) GetLegalHoldRetention() time.Duration {
+	if s.LegalHoldRetention == "" {
+		return 0
+	}
+	retention, err := ParseHistoryRetention([]byte(s.LegalHoldRetention))
+	if err != nil {
+		return 0
+	}
+	return retention
+}
+
+// GetFileFlags returns the file flags of the settings.
+func (s TlfSettings) GetFileFlags() FileFlags {
+	flags, err := ParseFileFlags([]byte(strings.Join(s.FileFlags, "\n")))
+	if err != nil {
+		return FileFlags{}
+	}
+	return flags
+}
+
+// GetDropFolder returns the drop folder at `p`, relative to the TLF
+// root, if there is one.
+func (s TlfSettings) GetDropFolder(p string) (DropFolder, bool) {
+	p = stdpath.Clean(p)
+	for _, d := range s.DropFolders {
+		if d.Path == p {
+			return d, true
+		}
+	}
+	return DropFolder{}, false
+}
+
+// ParseTlfSettings parses and validates the contents of a settings
+// file.  Unknown fields are an error, so that a misspelled setting
+// isn't silently ignored.
+func ParseTlfSettings(data []byte) (TlfSettings, error) {
+	var s TlfSettings
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&s); err != nil {
+		return TlfSettings{}, errors.WithStack(InvalidTlfSettingsError{err})
+	}
+	if err := s.Validate(); err != nil {
+		return TlfSettings{}, err
+	}
+	return s, nil
+}
+
+// EncodeTlfSettings validates `s` and returns it encoded as the
+// contents of a settings file.
+func EncodeTlfSettings(s TlfSettings) ([]byte, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return append(data, '\n'), nil
+}
+
+// ReadTlfSettings reads the settings of the TLF with the given root
+// node.  A TLF without a settings file has the zero TlfSettings, and
+// an invalid settings file results in an error.
+func ReadTlfSettings(
+	ctx context.Context, kbfsOps KBFSOps, rootNode Node) (
+	TlfSettings, error) {
+	node, ei, err := kbfsOps.Lookup(ctx, rootNode, TlfSettingsFileName)
+	if _, ok := errors.Cause(err).(NoSuchNameError); ok {
+		return TlfSettings{}, nil
+	} else if err != nil {
+		return TlfSettings{}, err
+	}
+	if ei.Type == Dir || ei.Type == Sym {
+		return TlfSettings{}, nil
+	}
+
+	size := ei.Size
+	if size > maxTlfSettingsSize {
+		size = maxTlfSettingsSize
+	}
+	buf := make([]byte, size)
+	n, err := kbfsOps.Read(CtxSkipRecentFiles(ctx), node, buf, 0)
+	if err != nil {
+		return TlfSettings{}, err
+	}
+	return ParseTlfSettings(buf[:n])
+}
+
+// WriteTlfSettings validates `s` and replaces the settings file of
+// the TLF with the given root node with it, waiting until the change
+// has been synced and applied.
+func WriteTlfSettings(
+	ctx context.Context, kbfsOps KBFSOps, rootNode Node,
+	s TlfSettings) error {
+	data, err := EncodeTlfSettings(s)
+	if err != nil {
+		return err
+	}
+	node, _, err := kbfsOps.Lookup(ctx, rootNode, TlfSettingsFileName)
+	if _, ok := errors.Cause(err).(NoSuchNameError); ok {
+		node, _, err = kbfsOps.CreateFile(
+			ctx, rootNode, TlfSettingsFileName, false, NoExcl)
+	}
+	if err != nil {
+		return err
+	}
+	err = kbfsOps.Truncate(ctx, node, 0)
+	if err != nil {
+		return err
+	}
+	err = kbfsOps.Write(ctx, node, data, 0)
+	if err != nil {
+		return err
+	}
+	fb := rootNode.GetFolderBranch()
+	err = kbfsOps.SyncAll(ctx, fb)
+	if err != nil {
+		return err
+	}
+	// Apply the new settings right away on this device, rather than
+	// waiting for the background reload.
+	if kbfsOpsStandard, ok := kbfsOps.(*KBFSOpsStandard); ok {
+		_, err = kbfsOpsStandard.getOpsNoAdd(ctx, fb).getCachedTlfSettings(ctx)
+	}
+	return err
+}
+
+// getTlfSettings returns the settings of the TLF.  Errors reading
+// the settings are returned, but an invalid settings file is only
+// logged, and treated as if there were no settings.
+func (fbo *folderBranchOps) getTlfSettings(
+	ctx context.Context) (TlfSettings, error) {
+	rootNode, _, _, err := fbo.getRootNode(ctx)
+	if err != nil {
+		return TlfSettings{}, err
+	}
+	s, err := ReadTlfSettings(ctx, fbo, rootNode)
+	if _, ok := errors.Cause(err).(InvalidTlfSettingsError); ok {
+		fbo.log.CDebugf(ctx, "Ignoring %s: %+v", TlfSettingsFileName, err)
+		return TlfSettings{}, nil
+	}
+	return s, err
+}
+
+// tlfSettingsCache holds the last settings read by a folderBranchOps,
+// along with the pointer of the settings file they were read from,
+// so that the file is only read again once it changes.
+type tlfSettingsCache struct {
+	lock     sync.Mutex
+	loaded   bool
+	ptr      BlockPointer
+	settings TlfSettings
+	// snapshot holds a copy of `settings` once they're loaded, which
+	// can be read without waiting for a load in progress.
+	snapshot atomic.Value
+}
+
+// getCachedTlfSettings returns the settings of the TLF, like
+// getTlfSettings, but only reads the settings file if it changed
+// since the last call.  Changed settings are applied before they're
+// returned, and only cached once they've been applied, so that a
+// failure is retried on the next call.
+func (fbo *folderBranchOps) getCachedTlfSettings(ctx context.Context) (
+	TlfSettings, error) {
+	rootNode, _, _, err := fbo.getRootNode(ctx)
+	if err != nil {
+		return TlfSettings{}, err
+	}
+	var ptr BlockPointer
+	node, _, err := fbo.Lookup(ctx, rootNode, TlfSettingsFileName)
+	switch errors.Cause(err).(type) {
+	case nil:
+		ptr = fbo.nodeCache.PathFromNode(node).tailPointer()
+	case NoSuchNameError:
+	default:
+		return TlfSettings{}, err
+	}
+
+	c := &fbo.settingsCache
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.loaded && c.ptr == ptr {
+		return c.settings, nil
+	}
+	settings, err := fbo.getTlfSettings(ctx)
+	if err != nil {
+		return TlfSettings{}, err
+	}
+	err = fbo.applyTlfSettings(ctx, c.settings, settings)
+	if err != nil {
+		return TlfSettings{}, err
+	}
+	c.loaded = true
+	c.ptr = ptr
+	c.settings = settings
+	c.snapshot.Store(settings)
+	return settings, nil
+}
+
+// peekTlfSettings returns the settings of the TLF as of the last time
+// they were loaded, without reading anything, and false if they were
+// never loaded.  Once they have been, they're reloaded in the
+// background every time the head changes.
+func (fbo *folderBranchOps) peekTlfSettings() (TlfSettings, bool) {
+	settings, ok := fbo.settingsCache.snapshot.Load().(TlfSettings)
+	return settings, ok
+}
+
+// applyTlfSettings applies the changes from the `old` settings of
+// the TLF (the default ones, the first time) to `settings`.
+func (fbo *folderBranchOps) applyTlfSettings(
+	ctx context.Context, old, settings TlfSettings) error {
+	if fbo.config.DataRegionsEnforced() {
+		region, loaded := fbo.config.TlfDataRegion(fbo.id())
+		if !loaded || region != settings.DataRegion {
+			fbo.log.CDebugf(ctx, "Data region is %q", settings.DataRegion)
+			err := fbo.config.SetTlfDataRegion(
+				fbo.id(), settings.DataRegion)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return fbo.applyWriteThrough(ctx, old, settings)
+}
+
+// reloadTlfSettings reads the settings of the TLF again, if the
+// settings file changed, and applies the changes.
+func (fbo *folderBranchOps) reloadTlfSettings(ctx context.Context) error {
+	_, err := fbo.getCachedTlfSettings(ctx)
+	return err
+}
+
+// GetCachedTlfSettings returns the settings of the given folder,
+// reading its settings file only if it changed since the settings
+// were last read.  That makes it cheap enough to call on every
+// operation that depends on a setting.  An invalid settings file is
+// treated as if there were no settings.
+func GetCachedTlfSettings(ctx context.Context, config Config,
+	folderBranch FolderBranch) (TlfSettings, error) {
+	kbfsOps, ok := config.KBFSOps().(*KBFSOpsStandard)
+	if !ok {
+		return TlfSettings{}, errors.New("Unexpected KBFSOps type")
+	}
+	return kbfsOps.getOpsNoAdd(ctx, folderBranch).getCachedTlfSettings(ctx)
+}
+
+// tlfSettingsRetryPeriod is how long to wait before reading the
+// settings of a TLF again, after an error.
+const tlfSettingsRetryPeriod = 30 * time.Second
+
+// signalTlfSettingsReload asks the background settings goroutine,
+// starting it if needed, to check whether the settings of the TLF
+// changed.  It never blocks.
+func (fbo *folderBranchOps) signalTlfSettingsReload() {
+	fbo.launchSettingsLoop.Do(func() {
+		go fbo.tlfSettingsLoop()
+	})
+	select {
+	case fbo.settingsReloadCh <- struct{}{}:
+	default:
+		// A check is already pending.
+	}
+}
+
+// tlfSettingsLoop applies the settings of the TLF whenever they
+// change, until shutdown.
+func (fbo *folderBranchOps) tlfSettingsLoop() {
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-fbo.settingsReloadCh:
+		case <-retry:
+		case <-fbo.shutdownChan:
+			return
+		}
+		retry = nil
+		err := fbo.runUnlessShutdown(fbo.reloadTlfSettings)
+		switch errors.Cause(err).(type) {
+		case nil:
+		case ShutdownHappenedError:
+			return
+		default:
+			fbo.log.CDebugf(nil, "Couldn't apply the TLF settings: %+v", err)
+			retry = time.After(tlfSettingsRetryPeriod)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTlfSettings(t *testing.T) {
+	s, err := ParseTlfSettings([]byte(`{
+  "Version": 1,
+  "Ignore": ["*.o", "build/"],
+  "HistoryRetention": "30d",
+  "FileFlags": ["exec"]
+}`))
+	require.NoError(t, err)
+	require.Equal(t, 30*24*time.Hour, s.GetHistoryRetention())
+	require.Equal(t, FileFlags{Exec: true}, s.GetFileFlags())
+	ki := s.GetKBFSIgnore()
+	require.True(t, ki.Match("a.o", false))
+	require.True(t, ki.Match("build", true))
+	require.False(t, ki.Match("build", false))
+
+	data, err := EncodeTlfSettings(s)
+	require.NoError(t, err)
+	s2, err := ParseTlfSettings(data)
+	require.NoError(t, err)
+	require.Equal(t, s, s2)
+
+	for _, bad := range []string{
+		`{"Ignore": ["*.o"]}`,
+		`{"Version": 2}`,
+		`{"Version": 1, "Retention": "30d"}`,
+		`{"Version": 1, "Ignore": ["a/b"]}`,
+		`{"Version": 1, "HistoryRetention": "often"}`,
+		`{"Version": 1, "FileFlags": ["sticky"]}`,
+		`{"Version": 1`,
+	} {
+		_, err := ParseTlfSettings([]byte(bad))
+		require.IsType(t, InvalidTlfSettingsError{}, errors.Cause(err), bad)
+	}
+	_, err = EncodeTlfSettings(TlfSettings{Version: 1, Ignore: []string{""}})
+	require.IsType(t, InvalidTlfSettingsError{}, errors.Cause(err))
+}
+
+func TestTlfSettings(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps()
+
+	s, err := ReadTlfSettings(ctx, kbfsOps, rootNode)
+	require.NoError(t, err)
+	require.Equal(t, TlfSettings{}, s)
+
+	t.Log("Settings are written only if they're valid")
+	err = WriteTlfSettings(ctx, kbfsOps, rootNode, TlfSettings{
+		Version:          TlfSettingsVersion,
+		HistoryRetention: "never",
+	})
+	require.IsType(t, InvalidTlfSettingsError{}, errors.Cause(err))
+	_, _, err = kbfsOps.Lookup(ctx, rootNode, TlfSettingsFileName)
+	require.IsType(t, NoSuchNameError{}, errors.Cause(err))
+
+	settings := TlfSettings{
+		Version:          TlfSettingsVersion,
+		HistoryRetention: "3d",
+		Ignore:           []string{"*.tmp"},
+	}
+	err = WriteTlfSettings(ctx, kbfsOps, rootNode, settings)
+	require.NoError(t, err)
+	s, err = ReadTlfSettings(ctx, kbfsOps, rootNode)
+	require.NoError(t, err)
+	require.Equal(t, settings, s)
+
+	t.Log("The settings' retention takes precedence over the file's")
+	retentionNode, _, err := kbfsOps.CreateFile(
+		ctx, rootNode, HistoryRetentionFileName, false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, retentionNode, []byte("forever"), 0)
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+	status, err := kbfsOps.GetQuotaReclamationStatus(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, 3*24*time.Hour, status.HistoryRetention)
+
+	t.Log("Invalid settings are ignored")
+	settingsNode, _, err := kbfsOps.Lookup(ctx, rootNode, TlfSettingsFileName)
+	require.NoError(t, err)
+	err = kbfsOps.Truncate(ctx, settingsNode, 0)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, settingsNode, []byte(`{"Version": 9}`), 0)
+	require.NoError(t, err)
+	require.NoError(t, kbfsOps.SyncAll(ctx, fb))
+	_, err = ReadTlfSettings(ctx, kbfsOps, rootNode)
+	require.IsType(t, InvalidTlfSettingsError{}, errors.Cause(err))
+	status, err = kbfsOps.GetQuotaReclamationStatus(ctx, fb)
+	require.NoError(t, err)
+	require.Equal(t, HistoryRetentionForever, status.HistoryRetention)
+}
+
+func TestMergeKBFSIgnore(t *testing.T) {
+	first := ParseKBFSIgnore([]byte("*.o"))
+	second := ParseKBFSIgnore([]byte("!keep.o"))
+	require.Nil(t, mergeKBFSIgnore(nil, nil))
+	require.Equal(t, first, mergeKBFSIgnore(first, nil))
+	merged := mergeKBFSIgnore(first, second)
+	require.True(t, merged.Match("a.o", false))
+	require.False(t, merged.Match("keep.o", false))
+}
@@ -0,0 +1,272 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/keybase/kbfs/kbfscodec"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// TopTlfStatus is the activity of one TLF, as shown by `kbfstool
+// top`.  The byte counters are totals since KBFS started; rates come
+// from comparing two statuses.
+type TopTlfStatus struct {
+	Tlf          tlf.ID
+	Name         string `json:",omitempty"`
+	BytesRead    int64
+	BytesWritten int64
+	// JournalUnflushedBytes and JournalUnflushedRevisions are the
+	// backlog of the TLF's journal, if it has one.
+	JournalUnflushedBytes     int64 `json:",omitempty"`
+	JournalUnflushedRevisions int64 `json:",omitempty"`
+	// BlockBytesUploaded and MDBytesUploaded are the encrypted
+	// bytes of blocks and metadata put to the servers for the TLF
+	// since KBFS started, including blocks that were put again.
+	BlockBytesUploaded int64 `json:",omitempty"`
+	MDBytesUploaded    int64 `json:",omitempty"`
+}
+
+// WriteAmplification returns the ratio of the bytes uploaded for the
+// TLF to the bytes written to its files, or 0 if none were written.
+// It's the cost of a workload: encryption, padding, metadata and
+// re-uploaded blocks all make it bigger than 1, while deduplicated
+// and still-unflushed data make it smaller.
+func (ts TopTlfStatus) WriteAmplification() float64 {
+	if ts.BytesWritten == 0 {
+		return 0
+	}
+	return float64(ts.BlockBytesUploaded+ts.MDBytesUploaded) /
+		float64(ts.BytesWritten)
+}
+
+// TopActiveOp is a KBFS operation that hasn't returned yet.
+type TopActiveOp struct {
+	Name    string
+	Tlf     tlf.ID
+	Started time.Time
+}
+
+// TopCacheStatus counts the lookups in a block cache.
+type TopCacheStatus struct {
+	Hits   int64
+	Misses int64
+}
+
+// TopStatus is a snapshot of what KBFS is doing, for `kbfstool top`.
+type TopStatus struct {
+	Time       time.Time
+	Tlfs       []TopTlfStatus
+	BlockCache TopCacheStatus
+	DiskCache  TopCacheStatus
+	ActiveOps  []TopActiveOp
+}
+
+type topTlfCounters struct {
+	read, written              int64
+	blocksUploaded, mdUploaded int64
+}
+
+// activityTracker counts the bytes read and written per TLF by
+// KBFSOpsStandard, and which of its operations are running.
+type activityTracker struct {
+	clock Clock
+
+	lock     sync.Mutex
+	nextOpID uint64
+	ops      map[uint64]TopActiveOp
+	tlfs     map[tlf.ID]*topTlfCounters
+}
+
+func newActivityTracker(clock Clock) *activityTracker {
+	return &activityTracker{
+		clock: clock,
+		ops:   make(map[uint64]TopActiveOp),
+		tlfs:  make(map[tlf.ID]*topTlfCounters),
+	}
+}
+
+// begin records that the operation `name` on `tlfID` started, and
+// returns a function to call when it's done.
+func (a *activityTracker) begin(name string, tlfID tlf.ID) func() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	id := a.nextOpID
+	a.nextOpID++
+	a.ops[id] = TopActiveOp{name, tlfID, a.clock.Now()}
+	return func() {
+		a.lock.Lock()
+		defer a.lock.Unlock()
+		delete(a.ops, id)
+	}
+}
+
+func (a *activityTracker) getCountersLocked(
+	tlfID tlf.ID) *topTlfCounters {
+	c, ok := a.tlfs[tlfID]
+	if !ok {
+		c = &topTlfCounters{}
+		a.tlfs[tlfID] = c
+	}
+	return c
+}
+
+func (a *activityTracker) addBytes(tlfID tlf.ID, read, written int64) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	c := a.getCountersLocked(tlfID)
+	c.read += read
+	c.written += written
+}
+
+func (a *activityTracker) addUploaded(
+	tlfID tlf.ID, blockBytes, mdBytes int64) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	c := a.getCountersLocked(tlfID)
+	c.blocksUploaded += blockBytes
+	c.mdUploaded += mdBytes
+}
+
+type kbfsOpsGetter interface {
+	KBFSOps() KBFSOps
+}
+
+// recordUploaded counts `blockBytes` and `mdBytes` as uploaded to the
+// servers for `tlfID`, if the KBFSOps of `config` tracks activity.
+// It must be called where data reaches the servers, below any
+// journal.
+func recordUploaded(
+	config kbfsOpsGetter, tlfID tlf.ID, blockBytes, mdBytes int64) {
+	if fs, ok := config.KBFSOps().(*KBFSOpsStandard); ok {
+		fs.activity.addUploaded(tlfID, blockBytes, mdBytes)
+	}
+}
+
+// recordMDUploaded counts the encoded size of `rmds` as uploaded to
+// the MD server, if the KBFSOps of `config` tracks activity.
+func recordMDUploaded(
+	config kbfsOpsGetter, codec kbfscodec.Codec, rmds *RootMetadataSigned) {
+	if _, ok := config.KBFSOps().(*KBFSOpsStandard); !ok {
+		return
+	}
+	buf, err := kbfsmd.EncodeRootMetadataSigned(
+		codec, &rmds.RootMetadataSigned)
+	if err != nil {
+		// The put already encoded it, so this can't really happen.
+		return
+	}
+	recordUploaded(config, rmds.MD.TlfID(), 0, int64(len(buf)))
+}
+
+// snapshot returns the byte counters by TLF, and the running
+// operations, oldest first.
+func (a *activityTracker) snapshot() (
+	map[tlf.ID]topTlfCounters, []TopActiveOp) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	tlfs := make(map[tlf.ID]topTlfCounters, len(a.tlfs))
+	for tlfID, c := range a.tlfs {
+		tlfs[tlfID] = *c
+	}
+	ops := make([]TopActiveOp, 0, len(a.ops))
+	for _, op := range a.ops {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].Started.Before(ops[j].Started)
+	})
+	return tlfs, ops
+}
+
+// GetTopStatus returns a snapshot of the per-TLF activity, journal
+// backlogs, cache hits and running operations of `config`.
+func GetTopStatus(ctx context.Context, config Config) (TopStatus, error) {
+	kbfsOps, ok := config.KBFSOps().(*KBFSOpsStandard)
+	if !ok {
+		return TopStatus{}, errors.Errorf(
+			"KBFSOps %T doesn't track activity", config.KBFSOps())
+	}
+	counters, ops := kbfsOps.activity.snapshot()
+	status := TopStatus{
+		Time:      config.Clock().Now(),
+		ActiveOps: ops,
+	}
+
+	tlfs := make(map[tlf.ID]*TopTlfStatus, len(counters))
+	getTlf := func(tlfID tlf.ID) *TopTlfStatus {
+		ts, ok := tlfs[tlfID]
+		if !ok {
+			ts = &TopTlfStatus{Tlf: tlfID}
+			tlfs[tlfID] = ts
+		}
+		return ts
+	}
+	for tlfID, c := range counters {
+		ts := getTlf(tlfID)
+		ts.BytesRead = c.read
+		ts.BytesWritten = c.written
+		ts.BlockBytesUploaded = c.blocksUploaded
+		ts.MDBytesUploaded = c.mdUploaded
+	}
+	if jServer, err := GetJournalServer(config); err == nil {
+		_, tlfIDs := jServer.Status(ctx)
+		for _, tlfID := range tlfIDs {
+			js, err := jServer.JournalStatus(tlfID)
+			if err != nil {
+				// The journal may have just been disabled.
+				continue
+			}
+			if js.UnflushedBytes == 0 &&
+				js.RevisionStart == kbfsmd.RevisionUninitialized {
+				continue
+			}
+			ts := getTlf(tlfID)
+			ts.JournalUnflushedBytes = js.UnflushedBytes
+			if js.RevisionStart != kbfsmd.RevisionUninitialized {
+				ts.JournalUnflushedRevisions =
+					int64(js.RevisionEnd-js.RevisionStart) + 1
+			}
+		}
+	}
+	for tlfID, ts := range tlfs {
+		ts.Name = kbfsOps.tlfNameForTop(tlfID)
+		status.Tlfs = append(status.Tlfs, *ts)
+	}
+	sort.Slice(status.Tlfs, func(i, j int) bool {
+		return status.Tlfs[i].Tlf.String() < status.Tlfs[j].Tlf.String()
+	})
+
+	if bc, ok := config.BlockCache().(*BlockCacheStandard); ok {
+		hits, misses := bc.lookupCounts()
+		status.BlockCache = TopCacheStatus{int64(hits), int64(misses)}
+	}
+	if dbc := config.DiskBlockCache(); dbc != nil {
+		for _, s := range dbc.Status(ctx) {
+			status.DiskCache.Hits += s.Hits.Count
+			status.DiskCache.Misses += s.Misses.Count
+		}
+	}
+	return status, nil
+}
+
+// tlfNameForTop returns the canonical path of the TLF with the given
+// ID, if it's been accessed since KBFS started.
+func (fs *KBFSOpsStandard) tlfNameForTop(tlfID tlf.ID) string {
+	fs.opsLock.RLock()
+	defer fs.opsLock.RUnlock()
+	for fb, ops := range fs.ops {
+		if fb.Tlf == tlfID && fb.Branch == MasterBranch {
+			return ops.headTlfPath()
+		}
+	}
+	return ""
+}
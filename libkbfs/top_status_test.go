@@ -0,0 +1,125 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetTopStatus(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	// Count uploaded blocks like Init does.
+	config.SetBlockServer(newBlockServerAccounting(
+		config.BlockServer(), config, config.MakeLogger(""), nil))
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	kbfsOps := config.KBFSOps()
+	n, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, n, []byte("hello"), 0)
+	require.NoError(t, err)
+	buf := make([]byte, 3)
+	_, err = kbfsOps.Read(ctx, n, buf, 0)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+
+	tlfID := rootNode.GetFolderBranch().Tlf
+	done := kbfsOps.(*KBFSOpsStandard).activity.begin("Test", tlfID)
+	status, err := GetTopStatus(ctx, config)
+	require.NoError(t, err)
+	require.Len(t, status.Tlfs, 1)
+	ts := status.Tlfs[0]
+	require.True(t, ts.BlockBytesUploaded > 0)
+	require.True(t, ts.MDBytesUploaded > 0)
+	require.True(t, ts.WriteAmplification() > 1)
+	ts.BlockBytesUploaded = 0
+	ts.MDBytesUploaded = 0
+	require.Equal(t, TopTlfStatus{
+		Tlf:          tlfID,
+		Name:         "/keybase/private/alice",
+		BytesRead:    3,
+		BytesWritten: 5,
+	}, ts)
+	require.Len(t, status.ActiveOps, 1)
+	require.Equal(t, "Test", status.ActiveOps[0].Name)
+	require.Equal(t, tlfID, status.ActiveOps[0].Tlf)
+
+	done()
+	status, err = GetTopStatus(ctx, config)
+	require.NoError(t, err)
+	require.Len(t, status.ActiveOps, 0)
+}
+
+func TestGetTopStatusPagedAndTreeOps(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	kbfsOps := config.KBFSOps()
+	activity := kbfsOps.(*KBFSOpsStandard).activity
+	tlfID := rootNode.GetFolderBranch().Tlf
+	n, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, n, []byte("hello"), 0)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateDir(ctx, rootNode, "d")
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+
+	opsBegun := func() uint64 {
+		activity.lock.Lock()
+		defer activity.lock.Unlock()
+		return activity.nextOpID
+	}
+
+	t.Log("Paged listings and small reads are tracked")
+	before := opsBegun()
+	_, err = kbfsOps.GetDirChildrenPage(ctx, rootNode, "", 1)
+	require.NoError(t, err)
+	require.Equal(t, before+1, opsBegun())
+	_, _, data, err := kbfsOps.LookupAndReadSmall(ctx, rootNode, "a", 10)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), data)
+	require.Equal(t, before+2, opsBegun())
+
+	t.Log("RemoveTree is active while it runs")
+	var active []TopActiveOp
+	err = kbfsOps.RemoveTree(ctx, rootNode, "d",
+		func(RemoveTreeProgress) {
+			if active != nil {
+				return
+			}
+			status, err := GetTopStatus(ctx, config)
+			require.NoError(t, err)
+			active = status.ActiveOps
+		})
+	require.NoError(t, err)
+	require.Len(t, active, 1)
+	require.Equal(t, "RemoveTree", active[0].Name)
+	require.Equal(t, tlfID, active[0].Tlf)
+
+	status, err := GetTopStatus(ctx, config)
+	require.NoError(t, err)
+	require.Len(t, status.ActiveOps, 0)
+	require.Len(t, status.Tlfs, 1)
+	require.Equal(t, int64(5), status.Tlfs[0].BytesRead)
+}
+
+func TestTopTlfStatusWriteAmplification(t *testing.T) {
+	require.Equal(t, float64(0), TopTlfStatus{}.WriteAmplification())
+	ts := TopTlfStatus{
+		BytesWritten:       100,
+		BlockBytesUploaded: 150,
+		MDBytesUploaded:    50,
+	}
+	require.Equal(t, float64(2), ts.WriteAmplification())
+}
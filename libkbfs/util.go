@@ -171,6 +171,21 @@ func GetHandleFromFolderNameAndType(
 	}
 }
 
+// GetHandleFromTlfID returns the TlfHandle of the TLF with the given
+// ID, as of its latest revision.  Unlike a folder name, the ID stays
+// the same when the TLF's users or team are renamed.
+func GetHandleFromTlfID(
+	ctx context.Context, mdOps MDOps, id tlf.ID) (*TlfHandle, error) {
+	head, err := mdOps.GetForTLF(ctx, id, nil)
+	if err != nil {
+		return nil, err
+	}
+	if head == (ImmutableRootMetadata{}) {
+		return nil, errors.Errorf("No TLF found with ID %s", id)
+	}
+	return head.GetTlfHandle(), nil
+}
+
 // getHandleFromFolderName returns a TLFHandle given a folder
 // name (e.g., "u1,u2#u3") and a public/private bool.  DEPRECATED.
 func getHandleFromFolderName(
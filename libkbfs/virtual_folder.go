@@ -0,0 +1,189 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"context"
+	"sync"
+
+	"github.com/keybase/client/go/logger"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+// This file contains the Node wrappers that expose a VirtualFolder
+// within each TLF.  It breaks down like this:
+//
+// * `virtualFolderWrapper.wrap()` is installed as a root node
+//   wrapper, and wraps the root node for each TLF in a
+//   `virtualFolderRootNode`.
+// * `virtualFolderRootNode` allows the folder's name to be
+//   auto-created when it is looked up, asks the VirtualFolder for the
+//   filesystem backing it, and wraps it both as a `ReadonlyNode` and
+//   a `virtualDirNode`.
+// * `virtualDirNode` returns its part of that filesystem from
+//   `GetFS()`, and wraps subdirectories in `virtualDirNode` and file
+//   entries in `virtualFileNode`.
+// * `virtualFileNode` opens its file when `GetFile()` is called.
+
+// VirtualFolder is a read-only subtree that appears within the root
+// of TLFs, but whose contents come from some other source rather than
+// from the TLF's own data.  For example, it could present a view of
+// the TLF's history, or status about it.
+type VirtualFolder interface {
+	// Name returns the name of the subtree's root directory within
+	// each TLF.
+	Name() string
+	// GetFS returns the filesystem serving the subtree within the
+	// TLF whose root node is `tlfRoot`.  It is called at most once
+	// per TLF, the first time the subtree is looked up.  If it
+	// returns a nil filesystem, the subtree doesn't exist in that
+	// TLF.  Only the read methods of the filesystem will be used.
+	GetFS(ctx context.Context, tlfRoot Node) (billy.Filesystem, error)
+}
+
+// AddVirtualFolder makes `vf` available in every TLF that is first
+// accessed after this call.
+func AddVirtualFolder(config Config, vf VirtualFolder) {
+	w := virtualFolderWrapper{vf: vf, log: config.MakeLogger("")}
+	config.AddRootNodeWrapper(w.wrap)
+}
+
+type virtualFileNode struct {
+	Node
+	vfw  virtualFolderWrapper
+	fs   billy.Filesystem
+	name string
+}
+
+var _ Node = (*virtualFileNode)(nil)
+
+// GetFile implements the Node interface for virtualFileNode.
+func (vfn virtualFileNode) GetFile(ctx context.Context) billy.File {
+	f, err := vfn.fs.Open(vfn.name)
+	if err != nil {
+		vfn.vfw.log.CDebugf(ctx, "Error opening %s in %s: %+v",
+			vfn.name, vfn.vfw.vf.Name(), err)
+		return nil
+	}
+	return f
+}
+
+type virtualDirNode struct {
+	Node
+	vfw virtualFolderWrapper
+	fs  billy.Filesystem
+}
+
+var _ Node = (*virtualDirNode)(nil)
+
+// GetFS implements the Node interface for virtualDirNode.
+func (vdn virtualDirNode) GetFS(_ context.Context) billy.Filesystem {
+	return vdn.fs
+}
+
+// WrapChild implements the Node interface for virtualDirNode.
+func (vdn virtualDirNode) WrapChild(child Node) Node {
+	child = vdn.Node.WrapChild(child)
+	name := child.GetBasename()
+	fi, err := vdn.fs.Lstat(name)
+	if err != nil {
+		vdn.vfw.log.CDebugf(nil, "Error getting type of %s in %s: %+v",
+			name, vdn.vfw.vf.Name(), err)
+		return child
+	}
+	if fi.IsDir() {
+		childFS, err := vdn.fs.Chroot(name)
+		if err != nil {
+			vdn.vfw.log.CDebugf(nil, "Error getting FS for %s in %s: %+v",
+				name, vdn.vfw.vf.Name(), err)
+			return child
+		}
+		return &virtualDirNode{
+			Node: child,
+			vfw:  vdn.vfw,
+			fs:   childFS,
+		}
+	}
+	return &virtualFileNode{
+		Node: child,
+		vfw:  vdn.vfw,
+		fs:   vdn.fs,
+		name: name,
+	}
+}
+
+// virtualFolderRootNode is a Node wrapper around a TLF root node,
+// that causes the virtual folder to be created when it is accessed.
+type virtualFolderRootNode struct {
+	Node
+	vfw virtualFolderWrapper
+
+	lock sync.RWMutex
+	fs   billy.Filesystem
+}
+
+var _ Node = (*virtualFolderRootNode)(nil)
+
+// ShouldCreateMissedLookup implements the Node interface for
+// virtualFolderRootNode.
+func (vrn *virtualFolderRootNode) ShouldCreateMissedLookup(
+	ctx context.Context, name string) (bool, context.Context, EntryType, string) {
+	if name != vrn.vfw.vf.Name() {
+		return vrn.Node.ShouldCreateMissedLookup(ctx, name)
+	}
+
+	vrn.lock.Lock()
+	defer vrn.lock.Unlock()
+	if vrn.fs == nil {
+		// Get the FS here, where we know the NodeCache won't be
+		// locked, in case the VirtualFolder needs to use KBFSOps.
+		fs, err := vrn.vfw.vf.GetFS(ctx, vrn)
+		if err != nil {
+			vrn.vfw.log.CDebugf(ctx, "Error getting FS for %s: %+v", name, err)
+			return vrn.Node.ShouldCreateMissedLookup(ctx, name)
+		}
+		if fs == nil {
+			return vrn.Node.ShouldCreateMissedLookup(ctx, name)
+		}
+		vrn.fs = fs
+	}
+	return true, ctx, FakeDir, ""
+}
+
+// WrapChild implements the Node interface for virtualFolderRootNode.
+func (vrn *virtualFolderRootNode) WrapChild(child Node) Node {
+	child = vrn.Node.WrapChild(child)
+	if child.GetBasename() != vrn.vfw.vf.Name() {
+		return child
+	}
+
+	vrn.lock.RLock()
+	defer vrn.lock.RUnlock()
+	if vrn.fs == nil {
+		vrn.vfw.log.CDebugf(nil, "FS for %s not available on WrapChild",
+			child.GetBasename())
+		return child
+	}
+
+	return &virtualDirNode{
+		Node: &ReadonlyNode{Node: child},
+		vfw:  vrn.vfw,
+		fs:   vrn.fs,
+	}
+}
+
+// virtualFolderWrapper wraps TLF root nodes so that they contain a
+// VirtualFolder.
+type virtualFolderWrapper struct {
+	vf  VirtualFolder
+	log logger.Logger
+}
+
+func (vfw virtualFolderWrapper) wrap(node Node) Node {
+	return &virtualFolderRootNode{
+		Node: node,
+		vfw:  vfw,
+	}
+}
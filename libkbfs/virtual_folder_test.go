@@ -0,0 +1,94 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+
+	kbname "github.com/keybase/client/go/kbun"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	billy "gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+)
+
+type testVirtualFolder struct {
+	fs    billy.Filesystem
+	calls int
+}
+
+func (tvf *testVirtualFolder) Name() string {
+	return ".kbfs_test_virtual"
+}
+
+func (tvf *testVirtualFolder) GetFS(
+	_ context.Context, tlfRoot Node) (billy.Filesystem, error) {
+	tvf.calls++
+	if tlfRoot.GetFolderBranch().Tlf.Type() != tlf.Private {
+		return nil, nil
+	}
+	return tvf.fs, nil
+}
+
+func TestVirtualFolder(t *testing.T) {
+	var u1 kbname.NormalizedUsername = "u1"
+	config, _, ctx, cancel := kbfsOpsConcurInit(t, u1)
+	defer kbfsConcurTestShutdown(t, config, ctx, cancel)
+
+	fs := memfs.New()
+	err := fs.MkdirAll("a", 0700)
+	require.NoError(t, err)
+	f, err := fs.Create("a/b")
+	require.NoError(t, err)
+	_, err = f.Write([]byte("bdata"))
+	require.NoError(t, err)
+	err = f.Close()
+	require.NoError(t, err)
+	vf := &testVirtualFolder{fs: fs}
+	AddVirtualFolder(config, vf)
+
+	kbfsOps := config.KBFSOps()
+	h, err := ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), string(u1), tlf.Private)
+	require.NoError(t, err)
+	rootNode, _, err := kbfsOps.GetOrCreateRootNode(ctx, h, MasterBranch)
+	require.NoError(t, err)
+
+	t.Log("The virtual folder is served from its filesystem")
+	vfNode, _, err := kbfsOps.Lookup(ctx, rootNode, vf.Name())
+	require.NoError(t, err)
+	children, err := kbfsOps.GetDirChildren(ctx, vfNode)
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	aNode, _, err := kbfsOps.Lookup(ctx, vfNode, "a")
+	require.NoError(t, err)
+	bNode, _, err := kbfsOps.Lookup(ctx, aNode, "b")
+	require.NoError(t, err)
+	data := make([]byte, 5)
+	n, err := kbfsOps.Read(ctx, bNode, data, 0)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), n)
+	require.Equal(t, "bdata", string(data))
+
+	t.Log("The filesystem is only requested once per TLF")
+	_, _, err = kbfsOps.Lookup(ctx, rootNode, vf.Name())
+	require.NoError(t, err)
+	require.Equal(t, 1, vf.calls)
+
+	t.Log("The virtual folder is read-only")
+	_, _, err = kbfsOps.CreateFile(ctx, aNode, "c", false, NoExcl)
+	require.IsType(t, WriteToReadonlyNodeError{}, errors.Cause(err))
+
+	t.Log("The virtual folder doesn't exist where it has no filesystem")
+	h, err = ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), string(u1), tlf.Public)
+	require.NoError(t, err)
+	rootNode, _, err = kbfsOps.GetOrCreateRootNode(ctx, h, MasterBranch)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.Lookup(ctx, rootNode, vf.Name())
+	require.IsType(t, NoSuchNameError{}, errors.Cause(err))
+}
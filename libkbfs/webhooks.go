@@ -0,0 +1,251 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/keybase/kbfs/kbfsedits"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+const (
+	// webhooksFileName is the name of the file, under the storage
+	// root, where the webhooks of this device are persisted.
+	webhooksFileName = "webhooks.json"
+
+	// WebhookSignatureHeader is the HTTP header carrying the
+	// signature of a webhook's body, for webhooks with a secret.
+	// It's "sha256=" followed by the hex-encoded HMAC-SHA256 of the
+	// body, keyed by the secret.
+	WebhookSignatureHeader = "X-Kbfs-Signature"
+	// WebhookDeliveryHeader is the HTTP header carrying an ID that's
+	// the same for every attempt to deliver the same event, so
+	// receivers can drop duplicates.
+	WebhookDeliveryHeader = "X-Kbfs-Delivery"
+
+	webhookMaxAttempts = 6
+)
+
+var (
+	// webhookFirstRetryDelay is how long to wait before retrying a
+	// failed delivery for the first time.  Each later retry waits
+	// twice as long as the one before.
+	webhookFirstRetryDelay = 2 * time.Second
+
+	webhookClient = &http.Client{Timeout: 30 * time.Second}
+)
+
+// Webhook asks for a summary of each change made to a folder to be
+// POSTed to a URL, as a JSON-encoded WebhookEvent.  Webhooks are set
+// per device, and fire for changes by any writer, so a change is
+// reported once by every device that has a webhook for the folder.
+//
+// Webhooks are driven by the folder's edit history, so public
+// folders don't send them.  Neither does a writer's very first change
+// to a folder, since it arrives as part of a new edit channel's
+// history rather than as a live notification.
+type Webhook struct {
+	URL string
+	// Secret, if set, is the key used to sign each request in the
+	// WebhookSignatureHeader header.
+	Secret string `json:",omitempty"`
+}
+
+// Webhooks maps folders to their webhooks.
+type Webhooks map[tlf.ID][]Webhook
+
+// Validate returns an error if the webhook can't be used.
+func (w Webhook) Validate() error {
+	req, err := http.NewRequest("POST", w.URL, nil)
+	if err != nil {
+		return errors.Wrapf(err, "Invalid webhook URL %q", w.URL)
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return errors.Errorf("Webhook URL %q isn't http or https", w.URL)
+	}
+	return nil
+}
+
+// WebhookChange is a single change to an entry in a WebhookEvent.
+type WebhookChange struct {
+	// Path is the full path of the changed entry, starting with
+	// /keybase.
+	Path string
+	Type kbfsedits.NotificationOpType
+	// OldPath is where the entry was before, for renames.
+	OldPath string `json:",omitempty"`
+}
+
+// WebhookEvent summarizes the changes one writer made to a TLF in one
+// revision.
+type WebhookEvent struct {
+	TlfID    tlf.ID
+	Writer   string
+	Revision kbfsmd.Revision
+	// Time is the server-reported time of the change.
+	Time    time.Time
+	Changes []WebhookChange
+}
+
+func (e WebhookEvent) deliveryID() string {
+	return fmt.Sprintf("%s-%d-%s", e.TlfID, e.Revision, e.Writer)
+}
+
+// makeWebhookEvents turns an edit notification message sent by
+// `writer` into events, one per revision, in revision order.
+func makeWebhookEvents(writer, message string) ([]WebhookEvent, error) {
+	var edits []kbfsedits.NotificationMessage
+	if err := json.Unmarshal([]byte(message), &edits); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	byRev := make(map[kbfsmd.Revision]*WebhookEvent)
+	for _, edit := range edits {
+		if edit.Version != kbfsedits.NotificationV2 {
+			continue
+		}
+		e, ok := byRev[edit.Revision]
+		if !ok {
+			e = &WebhookEvent{
+				TlfID:    edit.FolderID,
+				Writer:   writer,
+				Revision: edit.Revision,
+				Time:     edit.Time,
+			}
+			byRev[edit.Revision] = e
+		}
+		c := WebhookChange{Path: edit.Filename, Type: edit.Type}
+		if edit.Params != nil {
+			c.OldPath = edit.Params.OldFilename
+		}
+		e.Changes = append(e.Changes, c)
+	}
+	events := make([]WebhookEvent, 0, len(byRev))
+	for _, e := range byRev {
+		events = append(events, *e)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Revision < events[j].Revision
+	})
+	return events, nil
+}
+
+// signWebhookBody returns the value of the WebhookSignatureHeader
+// header for `body`.
+func signWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// errWebhookRejected is returned for deliveries that shouldn't be
+// retried.
+type errWebhookRejected struct {
+	status int
+}
+
+func (e errWebhookRejected) Error() string {
+	return fmt.Sprintf("Webhook rejected the event with status %d", e.status)
+}
+
+func postWebhookEvent(ctx context.Context, client *http.Client, w Webhook,
+	e WebhookEvent, body []byte) error {
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookDeliveryHeader, e.deliveryID())
+	if w.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, signWebhookBody(w.Secret, body))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode >= 500:
+		return errors.Errorf("Webhook failed with status %d", resp.StatusCode)
+	default:
+		return errWebhookRejected{resp.StatusCode}
+	}
+}
+
+// deliverWebhookEvent POSTs `e` to `w`, retrying with exponential
+// backoff on network errors and server errors, until it succeeds, the
+// webhook rejects it, it runs out of attempts, or `ctx` is canceled.
+func deliverWebhookEvent(ctx context.Context, client *http.Client,
+	w Webhook, e WebhookEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	delay := webhookFirstRetryDelay
+	for attempt := 1; ; attempt++ {
+		err = postWebhookEvent(ctx, client, w, e, body)
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(errWebhookRejected); ok ||
+			attempt == webhookMaxAttempts {
+			return err
+		}
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// sendWebhooks delivers the changes in a new edit notification
+// message sent by `writer` to this folder's webhooks, in the
+// background.  Deliveries stop early if `ctx` is canceled.
+func (fbo *folderBranchOps) sendWebhooks(
+	ctx context.Context, writer, message string) {
+	hooks := fbo.config.Webhooks()[fbo.id()]
+	if len(hooks) == 0 {
+		return
+	}
+	events, err := makeWebhookEvents(writer, message)
+	if err != nil {
+		fbo.log.CWarningf(ctx, "Couldn't parse edit message for webhooks: "+
+			"%+v", err)
+		return
+	}
+	for _, w := range hooks {
+		if err := w.Validate(); err != nil {
+			fbo.log.CWarningf(ctx, "Skipping webhook: %+v", err)
+			continue
+		}
+		go func(w Webhook) {
+			for _, e := range events {
+				err := deliverWebhookEvent(ctx, webhookClient, w, e)
+				if err != nil {
+					fbo.log.CWarningf(ctx,
+						"Couldn't deliver revision %d to webhook %s: %+v",
+						e.Revision, w.URL, err)
+				}
+			}
+		}(w)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsedits"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestMakeWebhookEvents(t *testing.T) {
+	tlfID := tlf.FakeID(1, tlf.Private)
+	now := time.Unix(1, 0).UTC()
+	edits := []kbfsedits.NotificationMessage{
+		{
+			Version:  kbfsedits.NotificationV2,
+			Filename: "/keybase/private/alice/b",
+			Type:     kbfsedits.NotificationCreate,
+			Time:     now,
+			Revision: 11,
+			UID:      keybase1.MakeTestUID(1),
+			FolderID: tlfID,
+		},
+		{
+			Version:  kbfsedits.NotificationV2,
+			Filename: "/keybase/private/alice/a",
+			Type:     kbfsedits.NotificationCreate,
+			Time:     now,
+			Revision: 10,
+			UID:      keybase1.MakeTestUID(1),
+			FolderID: tlfID,
+		},
+		{
+			Version:  kbfsedits.NotificationV2,
+			Filename: "/keybase/private/alice/c",
+			Type:     kbfsedits.NotificationRename,
+			Time:     now,
+			Revision: 11,
+			UID:      keybase1.MakeTestUID(1),
+			FolderID: tlfID,
+			Params: &kbfsedits.NotificationParams{
+				OldFilename: "/keybase/private/alice/d",
+			},
+		},
+	}
+	msg, err := json.Marshal(edits)
+	require.NoError(t, err)
+
+	events, err := makeWebhookEvents("alice", string(msg))
+	require.NoError(t, err)
+	require.Equal(t, []WebhookEvent{
+		{
+			TlfID:    tlfID,
+			Writer:   "alice",
+			Revision: 10,
+			Time:     now,
+			Changes: []WebhookChange{{
+				Path: "/keybase/private/alice/a",
+				Type: kbfsedits.NotificationCreate,
+			}},
+		},
+		{
+			TlfID:    tlfID,
+			Writer:   "alice",
+			Revision: 11,
+			Time:     now,
+			Changes: []WebhookChange{
+				{
+					Path: "/keybase/private/alice/b",
+					Type: kbfsedits.NotificationCreate,
+				},
+				{
+					Path:    "/keybase/private/alice/c",
+					Type:    kbfsedits.NotificationRename,
+					OldPath: "/keybase/private/alice/d",
+				},
+			},
+		},
+	}, events)
+
+	_, err = makeWebhookEvents("alice", "not json")
+	require.Error(t, err)
+}
+
+func TestWebhookValidate(t *testing.T) {
+	require.NoError(t, Webhook{URL: "http://localhost:8080/hook"}.Validate())
+	require.NoError(t, Webhook{URL: "https://example.com/hook"}.Validate())
+	require.Error(t, Webhook{URL: "ftp://example.com/hook"}.Validate())
+	require.Error(t, Webhook{URL: "::"}.Validate())
+}
+
+func TestDeliverWebhookEvent(t *testing.T) {
+	defer func(d time.Duration) {
+		webhookFirstRetryDelay = d
+	}(webhookFirstRetryDelay)
+	webhookFirstRetryDelay = time.Millisecond
+
+	e := WebhookEvent{
+		TlfID:    tlf.FakeID(1, tlf.Private),
+		Writer:   "alice",
+		Revision: kbfsmd.Revision(10),
+	}
+	body, err := json.Marshal(e)
+	require.NoError(t, err)
+
+	var statuses []int
+	var gotBodies []string
+	var gotSigs, gotIDs []string
+	s := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			b, err := ioutil.ReadAll(req.Body)
+			require.NoError(t, err)
+			gotBodies = append(gotBodies, string(b))
+			gotSigs = append(gotSigs, req.Header.Get(WebhookSignatureHeader))
+			gotIDs = append(gotIDs, req.Header.Get(WebhookDeliveryHeader))
+			status := statuses[0]
+			statuses = statuses[1:]
+			w.WriteHeader(status)
+		}))
+	defer s.Close()
+	ctx := context.Background()
+	w := Webhook{URL: s.URL, Secret: "shh"}
+
+	t.Log("Server errors and rate limits are retried")
+	statuses = []int{http.StatusServiceUnavailable,
+		http.StatusTooManyRequests, http.StatusOK}
+	err = deliverWebhookEvent(ctx, s.Client(), w, e)
+	require.NoError(t, err)
+	require.Len(t, gotBodies, 3)
+	for i := range gotBodies {
+		require.Equal(t, string(body), gotBodies[i])
+		require.Equal(t, signWebhookBody("shh", body), gotSigs[i])
+		require.Equal(t, gotIDs[0], gotIDs[i])
+	}
+	require.True(t, strings.HasPrefix(gotSigs[0], "sha256="))
+
+	t.Log("Other client errors aren't")
+	gotBodies = nil
+	statuses = []int{http.StatusNotFound}
+	err = deliverWebhookEvent(ctx, s.Client(), w, e)
+	require.IsType(t, errWebhookRejected{}, err)
+	require.Len(t, gotBodies, 1)
+
+	t.Log("Give up eventually")
+	gotBodies = nil
+	statuses = make([]int, webhookMaxAttempts)
+	for i := range statuses {
+		statuses[i] = http.StatusInternalServerError
+	}
+	err = deliverWebhookEvent(ctx, s.Client(), w, e)
+	require.Error(t, err)
+	require.Len(t, gotBodies, webhookMaxAttempts)
+
+	t.Log("No signature without a secret")
+	gotSigs = nil
+	statuses = []int{http.StatusOK}
+	err = deliverWebhookEvent(ctx, s.Client(), Webhook{URL: s.URL}, e)
+	require.NoError(t, err)
+	require.Equal(t, []string{""}, gotSigs)
+}
+
+func TestWebhookOnWrite(t *testing.T) {
+	eventCh := make(chan WebhookEvent, 10)
+	s := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			var e WebhookEvent
+			err := json.NewDecoder(req.Body).Decode(&e)
+			require.NoError(t, err)
+			eventCh <- e
+		}))
+	defer s.Close()
+
+	config := MakeTestConfigOrBust(t, "alice")
+	defer CheckConfigAndShutdown(context.Background(), t, config)
+	ctx, cancel := context.WithTimeout(
+		context.Background(), 10*time.Second)
+	defer cancel()
+	ctx, err := NewContextWithCancellationDelayer(NewContextReplayable(
+		ctx, func(c context.Context) context.Context { return c }))
+	require.NoError(t, err)
+
+	h, err := ParseTlfHandle(
+		ctx, config.KBPKI(), config.MDOps(), "alice", tlf.Private)
+	require.NoError(t, err)
+	kbfsOps := config.KBFSOps()
+	rootNode, _, err := kbfsOps.GetOrCreateRootNode(ctx, h, MasterBranch)
+	require.NoError(t, err)
+	err = config.SetWebhooks(Webhooks{
+		rootNode.GetFolderBranch().Tlf: {{URL: s.URL}},
+	})
+	require.NoError(t, err)
+
+	t.Log("The first write sets up alice's edit channel")
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+	err = kbfsOps.SyncFromServer(ctx, rootNode.GetFolderBranch(), nil)
+	require.NoError(t, err)
+
+	t.Log("Later writes fire the webhook")
+	_, _, err = kbfsOps.CreateFile(ctx, rootNode, "b", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+
+	select {
+	case e := <-eventCh:
+		require.Equal(t, rootNode.GetFolderBranch().Tlf, e.TlfID)
+		require.Equal(t, "alice", e.Writer)
+		require.NotEmpty(t, e.Changes)
+		require.Equal(t, WebhookChange{
+			Path: "/keybase/private/alice/b",
+			Type: kbfsedits.NotificationCreate,
+		}, e.Changes[0])
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	}
+}
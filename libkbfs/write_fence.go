@@ -0,0 +1,238 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// writeFencePollInterval is how often WaitForWriteFence re-checks
+// whether the local writes have been merged, and the mdserver's head.
+const writeFencePollInterval = 500 * time.Millisecond
+
+// writeFenceClockCheckInterval bounds how long a poll sleeps before
+// checking the config's clock again.
+const writeFenceClockCheckInterval = 10 * time.Millisecond
+
+// WriteFence identifies a point in a TLF's history, as of some local
+// writes.  Once the mdserver's merged head reaches the fence's
+// revision, those writes are durable and visible to other devices.
+// If the writes were made on an unmerged branch, the fence's revision
+// is only meaningful on that branch, and the writes are visible once
+// conflict resolution has merged them.
+type WriteFence struct {
+	Tlf      tlf.ID
+	Revision kbfsmd.Revision
+	// BranchID is the unmerged branch the writes were made on, or
+	// kbfsmd.NullBranchID.
+	BranchID kbfsmd.BranchID
+}
+
+// String implements the fmt.Stringer interface for WriteFence.  The
+// result can be parsed with ParseWriteFence.
+func (wf WriteFence) String() string {
+	if wf.BranchID == kbfsmd.NullBranchID {
+		return fmt.Sprintf("%s:%d", wf.Tlf, wf.Revision)
+	}
+	return fmt.Sprintf("%s:%d:%s", wf.Tlf, wf.Revision, wf.BranchID)
+}
+
+// ParseWriteFence parses a token returned by WriteFence.String.
+func ParseWriteFence(s string) (WriteFence, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 && len(parts) != 3 {
+		return WriteFence{}, errors.Errorf("Invalid write fence %q", s)
+	}
+	tlfID, err := tlf.ParseID(parts[0])
+	if err != nil {
+		return WriteFence{}, err
+	}
+	rev, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return WriteFence{}, errors.Errorf("Invalid write fence %q", s)
+	}
+	bid := kbfsmd.NullBranchID
+	if len(parts) == 3 {
+		bid, err = kbfsmd.ParseBranchID(parts[2])
+		if err != nil {
+			return WriteFence{}, err
+		}
+	}
+	return WriteFence{tlfID, kbfsmd.Revision(rev), bid}, nil
+}
+
+// waitForPollInterval waits for writeFencePollInterval to pass,
+// according to the config's clock.
+func waitForPollInterval(ctx context.Context, config Config) error {
+	clock := config.Clock()
+	deadline := clock.Now().Add(writeFencePollInterval)
+	for {
+		remaining := deadline.Sub(clock.Now())
+		if remaining <= 0 {
+			return nil
+		}
+		if remaining > writeFenceClockCheckInterval {
+			remaining = writeFenceClockCheckInterval
+		}
+		timer := time.NewTimer(remaining)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// GetWriteFence syncs any outstanding local writes in the given
+// folder, and returns a fence that WaitForWriteFence can use to wait
+// for those writes to become visible at the mdserver.
+func GetWriteFence(
+	ctx context.Context, config Config, folderBranch FolderBranch) (
+	WriteFence, error) {
+	kbfsOps := config.KBFSOps()
+	err := kbfsOps.SyncAll(ctx, folderBranch)
+	if err != nil {
+		return WriteFence{}, err
+	}
+	status, _, err := kbfsOps.FolderStatus(ctx, folderBranch)
+	if err != nil {
+		return WriteFence{}, err
+	}
+	bid := kbfsmd.NullBranchID
+	if status.Staged {
+		bid, err = kbfsmd.ParseBranchID(status.BranchID)
+		if err != nil {
+			return WriteFence{}, err
+		}
+	}
+	return WriteFence{folderBranch.Tlf, status.Revision, bid}, nil
+}
+
+// WaitForWriteFence blocks until the writes covered by `fence` have
+// been flushed from the local journal, any conflicts they caused have
+// been resolved, and the mdserver's merged head has reached the
+// fence's revision (or, for a fence taken on an unmerged branch, the
+// revision that resolved it).
+func WaitForWriteFence(
+	ctx context.Context, config Config, fence WriteFence) error {
+	fb := FolderBranch{fence.Tlf, MasterBranch}
+	for {
+		merged, status, err := writesMerged(ctx, config, fb)
+		if err != nil {
+			return err
+		}
+		if merged {
+			rev := fence.Revision
+			if fence.BranchID != kbfsmd.NullBranchID {
+				// Unmerged revision numbers don't say anything about
+				// the merged branch, but the local head now holds the
+				// resolved writes.
+				rev = status.Revision
+			}
+			return waitForMergedRevision(ctx, config, fence.Tlf, rev)
+		}
+
+		err = waitForPollInterval(ctx, config)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// writesMerged flushes the TLF's journal, if it has one, and returns
+// whether all the local writes to the TLF are now on the merged
+// branch, along with the TLF's status.
+func writesMerged(ctx context.Context, config Config, fb FolderBranch) (
+	bool, FolderBranchStatus, error) {
+	jServer, err := GetJournalServer(config)
+	if err == nil {
+		err := jServer.WaitForCompleteFlush(ctx, fb.Tlf)
+		if err != nil {
+			return false, FolderBranchStatus{}, err
+		}
+		if jStatus, err := jServer.JournalStatus(fb.Tlf); err == nil &&
+			jStatus.BranchID != kbfsmd.NullBranchID.String() {
+			// The journal was converted to a branch, and conflict
+			// resolution hasn't finished with it yet.
+			return false, FolderBranchStatus{}, nil
+		}
+	}
+
+	status, _, err := config.KBFSOps().FolderStatus(ctx, fb)
+	if err != nil {
+		return false, FolderBranchStatus{}, err
+	}
+	return !status.Staged, status, nil
+}
+
+// waitForMergedRevision blocks until the mdserver's merged head for
+// the given TLF has reached `rev`.
+func waitForMergedRevision(ctx context.Context, config Config,
+	tlfID tlf.ID, rev kbfsmd.Revision) error {
+	for {
+		rmds, err := config.MDServer().GetForTLF(
+			ctx, tlfID, kbfsmd.NullBranchID, kbfsmd.Merged, nil)
+		if err != nil {
+			return err
+		}
+		if rmds != nil && rmds.MD.RevisionNumber() >= rev {
+			return nil
+		}
+
+		err = waitForPollInterval(ctx, config)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// WaitForRevision blocks until this device's view of the fence's TLF
+// reflects at least the fence's revision, so that a subsequent read
+// sees the writes covered by a fence from another device.  It gives
+// up with a RevisionWaitTimeoutError after `timeout`, unless that's
+// zero.  Fences taken on an unmerged branch are rejected with an
+// UnmergedWriteFenceError, since the merged revision that will hold
+// their writes isn't known until conflict resolution is done.
+func WaitForRevision(ctx context.Context, config Config, fence WriteFence,
+	timeout time.Duration) (err error) {
+	if fence.BranchID != kbfsmd.NullBranchID {
+		return errors.WithStack(UnmergedWriteFenceError{fence})
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+		defer func() {
+			if errors.Cause(err) == context.DeadlineExceeded {
+				err = RevisionWaitTimeoutError{fence, timeout}
+			}
+		}()
+	}
+
+	err = waitForMergedRevision(ctx, config, fence.Tlf, fence.Revision)
+	if err != nil {
+		return err
+	}
+
+	// The server has the revision; now catch up to it locally, if
+	// this device already has the TLF open.  Otherwise the first
+	// read will fetch the latest revision anyway.
+	fb := FolderBranch{fence.Tlf, MasterBranch}
+	kbfsOps := config.KBFSOps()
+	status, _, err := kbfsOps.FolderStatus(ctx, fb)
+	if err == nil && status.Revision >= fence.Revision {
+		return nil
+	}
+	return kbfsOps.SyncFromServer(ctx, fb, nil)
+}
@@ -0,0 +1,137 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+func TestWriteFence(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps()
+	a, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, a, []byte("hello"), 0)
+	require.NoError(t, err)
+
+	fence, err := GetWriteFence(ctx, config, fb)
+	require.NoError(t, err)
+	require.Equal(t, fb.Tlf, fence.Tlf)
+	parsed, err := ParseWriteFence(fence.String())
+	require.NoError(t, err)
+	require.Equal(t, fence, parsed)
+
+	err = WaitForWriteFence(ctx, config, fence)
+	require.NoError(t, err)
+
+	t.Log("A fence past the server's head blocks")
+	fence.Revision++
+	waitCtx, waitCancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer waitCancel()
+	err = WaitForWriteFence(waitCtx, config, fence)
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	_, err = ParseWriteFence("garbage")
+	require.Error(t, err)
+}
+
+func TestWriteFenceWaitsForConflictResolution(t *testing.T) {
+	config1, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config1, ctx, cancel)
+	config2 := ConfigAsUser(config1, "alice")
+	defer CheckConfigAndShutdown(ctx, t, config2)
+
+	rootNode1 := GetRootNodeOrBust(ctx, t, config1, "alice", tlf.Private)
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, "alice", tlf.Private)
+	fb := rootNode1.GetFolderBranch()
+
+	t.Log("Make a conflicting write on the first device")
+	c, err := DisableUpdatesForTesting(config1, fb)
+	require.NoError(t, err)
+	err = DisableCRForTesting(config1, fb)
+	require.NoError(t, err)
+	_, _, err = config2.KBFSOps().CreateFile(
+		ctx, rootNode2, "b", false, NoExcl)
+	require.NoError(t, err)
+	err = config2.KBFSOps().SyncAll(ctx, fb)
+	require.NoError(t, err)
+	_, _, err = config1.KBFSOps().CreateFile(
+		ctx, rootNode1, "a", false, NoExcl)
+	require.NoError(t, err)
+	fence, err := GetWriteFence(ctx, config1, fb)
+	require.NoError(t, err)
+	require.NotEqual(t, kbfsmd.NullBranchID, fence.BranchID)
+	parsed, err := ParseWriteFence(fence.String())
+	require.NoError(t, err)
+	require.Equal(t, fence, parsed)
+
+	t.Log("Other devices can't wait for an unmerged fence")
+	err = WaitForRevision(ctx, config2, fence, 10*time.Second)
+	require.Equal(t, UnmergedWriteFenceError{fence}, errors.Cause(err))
+
+	t.Log("The fence isn't reached while the writes are unmerged")
+	waitCtx, waitCancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer waitCancel()
+	err = WaitForWriteFence(waitCtx, config1, fence)
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	t.Log("Once conflict resolution is done, the fence is reached")
+	c <- struct{}{}
+	err = RestartCRForTesting(
+		BackgroundContextWithCancellationDelayer(), config1, fb)
+	require.NoError(t, err)
+	err = WaitForWriteFence(ctx, config1, fence)
+	require.NoError(t, err)
+	err = config2.KBFSOps().SyncFromServer(ctx, fb, nil)
+	require.NoError(t, err)
+	_, _, err = config2.KBFSOps().Lookup(ctx, rootNode2, "a")
+	require.NoError(t, err)
+}
+
+func TestWaitForRevision(t *testing.T) {
+	config1, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config1, ctx, cancel)
+	config2 := ConfigAsUser(config1, "alice")
+	defer CheckConfigAndShutdown(ctx, t, config2)
+
+	rootNode1 := GetRootNodeOrBust(ctx, t, config1, "alice", tlf.Private)
+	rootNode2 := GetRootNodeOrBust(ctx, t, config2, "alice", tlf.Private)
+	fb := rootNode1.GetFolderBranch()
+
+	t.Log("Keep the second device from seeing the write on its own")
+	c, err := DisableUpdatesForTesting(config2, fb)
+	require.NoError(t, err)
+
+	_, _, err = config1.KBFSOps().CreateFile(
+		ctx, rootNode1, "a", false, NoExcl)
+	require.NoError(t, err)
+	fence, err := GetWriteFence(ctx, config1, fb)
+	require.NoError(t, err)
+	err = WaitForWriteFence(ctx, config1, fence)
+	require.NoError(t, err)
+
+	c <- struct{}{}
+	err = WaitForRevision(ctx, config2, fence, 10*time.Second)
+	require.NoError(t, err)
+	_, _, err = config2.KBFSOps().Lookup(ctx, rootNode2, "a")
+	require.NoError(t, err)
+
+	t.Log("A fence past the server's head times out")
+	fence.Revision++
+	err = WaitForRevision(ctx, config2, fence, 10*time.Millisecond)
+	require.Equal(t, RevisionWaitTimeoutError{fence, 10 * time.Millisecond},
+		err)
+}
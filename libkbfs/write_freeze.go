@@ -0,0 +1,93 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// writeFreeze records that writes to a folder were frozen, so an
+// external tool can copy it in a consistent state.
+type writeFreeze struct {
+	// until is when the freeze lapses, or zero if it lasts until
+	// the folder is thawed.
+	until time.Time
+}
+
+// checkWritesFrozen returns an error if writes to this folder are
+// frozen by FreezeWrites.
+func (fbo *folderBranchOps) checkWritesFrozen() error {
+	fbo.writeFreezeLock.RLock()
+	defer fbo.writeFreezeLock.RUnlock()
+	if fbo.writeFreeze == nil {
+		return nil
+	}
+	until := fbo.writeFreeze.until
+	if !until.IsZero() && !fbo.config.Clock().Now().Before(until) {
+		return nil
+	}
+	return WritesFrozenError{fbo.folderBranch.Tlf, until}
+}
+
+// FreezeWrites implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) FreezeWrites(ctx context.Context,
+	folderBranch FolderBranch, timeout time.Duration) (err error) {
+	fbo.log.CDebugf(ctx, "FreezeWrites %s", timeout)
+	defer func() {
+		fbo.deferLog.CDebugf(ctx, "FreezeWrites done: %+v", err)
+	}()
+
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+
+	freeze := &writeFreeze{}
+	if timeout > 0 {
+		freeze.until = fbo.config.Clock().Now().Add(timeout)
+	}
+	fbo.writeFreezeLock.Lock()
+	fbo.writeFreeze = freeze
+	fbo.writeFreezeLock.Unlock()
+
+	// Writes that started before the freeze may still dirty the
+	// folder while it's being synced, so sync until it stays clean.
+	lState := makeFBOLockState()
+	for {
+		err := fbo.SyncAll(ctx, folderBranch)
+		if err != nil {
+			fbo.thawWrites(freeze)
+			return err
+		}
+		if fbo.blocks.GetState(lState) == cleanState {
+			return nil
+		}
+		fbo.log.CDebugf(ctx, "Still dirty after syncing; syncing again")
+	}
+}
+
+// thawWrites lifts `freeze`, if it's still in effect.
+func (fbo *folderBranchOps) thawWrites(freeze *writeFreeze) {
+	fbo.writeFreezeLock.Lock()
+	defer fbo.writeFreezeLock.Unlock()
+	if fbo.writeFreeze == freeze {
+		fbo.writeFreeze = nil
+	}
+}
+
+// ThawWrites implements the KBFSOps interface for folderBranchOps.
+func (fbo *folderBranchOps) ThawWrites(
+	ctx context.Context, folderBranch FolderBranch) error {
+	fbo.log.CDebugf(ctx, "ThawWrites")
+	if folderBranch != fbo.folderBranch {
+		return WrongOpsError{fbo.folderBranch, folderBranch}
+	}
+
+	fbo.writeFreezeLock.Lock()
+	defer fbo.writeFreezeLock.Unlock()
+	fbo.writeFreeze = nil
+	return nil
+}
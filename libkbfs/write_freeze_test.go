@@ -0,0 +1,57 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keybase/kbfs/tlf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFreezeWrites(t *testing.T) {
+	config, _, ctx, cancel := kbfsOpsInitNoMocks(t, "alice")
+	defer kbfsTestShutdownNoMocks(t, config, ctx, cancel)
+	clock := newTestClockNow()
+	config.SetClock(clock)
+
+	rootNode := GetRootNodeOrBust(ctx, t, config, "alice", tlf.Private)
+	fb := rootNode.GetFolderBranch()
+	kbfsOps := config.KBFSOps()
+	a, _, err := kbfsOps.CreateFile(ctx, rootNode, "a", false, NoExcl)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, a, []byte("hello"), 0)
+	require.NoError(t, err)
+
+	t.Log("Freezing syncs what was written, and refuses new writes")
+	err = kbfsOps.FreezeWrites(ctx, fb, 0)
+	require.NoError(t, err)
+	unsynced, err := GetUnsyncedBytes(ctx, config, a)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), unsynced)
+	err = kbfsOps.Write(ctx, a, []byte("world"), 5)
+	require.IsType(t, WritesFrozenError{}, err)
+	_, _, err = kbfsOps.CreateDir(ctx, rootNode, "b")
+	require.IsType(t, WritesFrozenError{}, err)
+	data := make([]byte, 5)
+	n, err := kbfsOps.Read(ctx, a, data, 0)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data[:n]))
+
+	err = kbfsOps.ThawWrites(ctx, fb)
+	require.NoError(t, err)
+	err = kbfsOps.Write(ctx, a, []byte("world"), 5)
+	require.NoError(t, err)
+
+	t.Log("A freeze with a timeout lapses on its own")
+	err = kbfsOps.FreezeWrites(ctx, fb, time.Minute)
+	require.NoError(t, err)
+	_, _, err = kbfsOps.CreateDir(ctx, rootNode, "b")
+	require.IsType(t, WritesFrozenError{}, err)
+	clock.Add(time.Minute)
+	_, _, err = kbfsOps.CreateDir(ctx, rootNode, "b")
+	require.NoError(t, err)
+}
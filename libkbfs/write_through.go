@@ -0,0 +1,133 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package libkbfs
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// journalBackgroundWorkStatus returns the background work status to
+// use for journals enabled on behalf of the user.
+func journalBackgroundWorkStatus(config Config) TLFJournalBackgroundWorkStatus {
+	if config.Mode().Type() == InitSingleOp {
+		return TLFJournalSingleOpBackgroundWorkEnabled
+	}
+	return TLFJournalBackgroundWorkEnabled
+}
+
+// SetTlfWriteThrough turns write-through mode on or off for the TLF
+// with the given handle, by changing the WriteThrough setting in its
+// settings file.  In write-through mode, writes to the TLF skip the
+// local journal, so that syncs only return once the servers have the
+// data.  That's useful for folders like shared drop boxes, where
+// other users seeing the writes right away matters more than making
+// them durable locally while offline.
+//
+// Turning it on flushes and disables the TLF's journal, if it has
+// one, before the setting is written.  Turning it off enables that
+// journal again, or lets a new one be enabled on the next write, if
+// journals are enabled automatically.  Other devices pick up the
+// setting from the settings file.
+func SetTlfWriteThrough(ctx context.Context, config Config, h *TlfHandle,
+	writeThrough bool) error {
+	kbfsOps := config.KBFSOps()
+	rootNode, _, err := kbfsOps.GetOrCreateRootNode(ctx, h, MasterBranch)
+	if err != nil {
+		return err
+	}
+	tlfID := rootNode.GetFolderBranch().Tlf
+	settings, err := ReadTlfSettings(ctx, kbfsOps, rootNode)
+	if err != nil {
+		return err
+	}
+	settings.Version = TlfSettingsVersion
+	settings.WriteThrough = writeThrough
+
+	jServer, err := GetJournalServer(config)
+	hasJournal := err == nil && jServer.hasTLFJournal(tlfID)
+	if !writeThrough {
+		// The journal is still disabled, so this goes straight to
+		// the servers.
+		err = WriteTlfSettings(ctx, kbfsOps, rootNode, settings)
+		if err != nil {
+			return err
+		}
+		config.SetTlfWriteThroughState(tlfID, false)
+		if !hasJournal {
+			return nil
+		}
+		return jServer.Enable(
+			ctx, tlfID, h, journalBackgroundWorkStatus(config))
+	}
+
+	// Keep a new journal from being enabled while the existing one
+	// is flushed.
+	config.SetTlfWriteThroughState(tlfID, true)
+	if hasJournal {
+		err = kbfsOps.SyncAll(ctx, rootNode.GetFolderBranch())
+		if err == nil {
+			err = jServer.Flush(ctx, tlfID)
+		}
+		if _, ok := errors.Cause(err).(errTLFJournalDisabled); ok {
+			// The journal server already disabled it, once it was
+			// flushed.
+			err = nil
+		}
+		if err == nil {
+			_, err = jServer.Disable(ctx, tlfID)
+		}
+		if err != nil {
+			config.SetTlfWriteThroughState(tlfID, false)
+			return err
+		}
+	}
+
+	// Only record the setting once writes really skip the journal.
+	err = WriteTlfSettings(ctx, kbfsOps, rootNode, settings)
+	if err != nil {
+		config.SetTlfWriteThroughState(tlfID, false)
+		if hasJournal {
+			if enableErr := jServer.Enable(ctx, tlfID, h,
+				journalBackgroundWorkStatus(config)); enableErr != nil {
+				jServer.log.CWarningf(ctx,
+					"Couldn't re-enable journal for %s: %+v",
+					tlfID, enableErr)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// applyWriteThrough records a change to the write-through setting
+// of the TLF, from `old` to `settings`, in the config.  Only changes
+// are applied, so that a settings file read before a local call to
+// SetTlfWriteThrough doesn't undo it.  Once the mode is on, the
+// journal server disables the TLF's journal as soon as it's flushed;
+// once it's off again, a journal disabled that way is enabled again.
+func (fbo *folderBranchOps) applyWriteThrough(
+	ctx context.Context, old, settings TlfSettings) error {
+	if old.WriteThrough == settings.WriteThrough ||
+		fbo.config.IsWriteThroughTlf(fbo.id()) == settings.WriteThrough {
+		return nil
+	}
+	fbo.log.CDebugf(ctx, "Write-through changed to %t",
+		settings.WriteThrough)
+	fbo.config.SetTlfWriteThroughState(fbo.id(), settings.WriteThrough)
+	if settings.WriteThrough {
+		return nil
+	}
+
+	jServer, err := GetJournalServer(fbo.config)
+	if err != nil || !jServer.hasTLFJournal(fbo.id()) {
+		return nil
+	}
+	lState := makeFBOLockState()
+	head, _ := fbo.getHead(lState)
+	return jServer.Enable(
+		ctx, fbo.id(), head.GetTlfHandle(),
+		journalBackgroundWorkStatus(fbo.config))
+}
@@ -0,0 +1,59 @@
+// Auto-generated by avdl-compiler v1.3.9 (https://github.com/keybase/node-avdl-compiler)
+//   Input file: kbgitkbfs-avdl/folder_updates.avdl
+
+package kbgitkbfs1
+
+import (
+	"github.com/keybase/go-framed-msgpack-rpc/rpc"
+	context "golang.org/x/net/context"
+)
+
+type FolderUpdatedArg struct {
+	TlfID    []byte `codec:"tlfID" json:"tlfID"`
+	Revision int64  `codec:"revision" json:"revision"`
+}
+
+// FolderUpdatesInterface lets other processes on the same device tell
+// KBFS about folder revisions they have written.
+type FolderUpdatesInterface interface {
+	// FolderUpdated tells KBFS that the given revision of a folder was
+	// just written, so it can be fetched without waiting for the
+	// server to announce it.
+	FolderUpdated(context.Context, FolderUpdatedArg) error
+}
+
+func FolderUpdatesProtocol(i FolderUpdatesInterface) rpc.Protocol {
+	return rpc.Protocol{
+		Name: "kbgitkbfs.1.FolderUpdates",
+		Methods: map[string]rpc.ServeHandlerDescription{
+			"FolderUpdated": {
+				MakeArg: func() interface{} {
+					ret := make([]FolderUpdatedArg, 1)
+					return &ret
+				},
+				Handler: func(ctx context.Context, args interface{}) (ret interface{}, err error) {
+					typedArgs, ok := args.(*[]FolderUpdatedArg)
+					if !ok {
+						err = rpc.NewTypeError((*[]FolderUpdatedArg)(nil), args)
+						return
+					}
+					err = i.FolderUpdated(ctx, (*typedArgs)[0])
+					return
+				},
+				MethodType: rpc.MethodCall,
+			},
+		},
+	}
+}
+
+type FolderUpdatesClient struct {
+	Cli rpc.GenericClient
+}
+
+// FolderUpdated tells KBFS that the given revision of a folder was
+// just written, so it can be fetched without waiting for the
+// server to announce it.
+func (c FolderUpdatesClient) FolderUpdated(ctx context.Context, __arg FolderUpdatedArg) (err error) {
+	err = c.Cli.Call(ctx, "kbgitkbfs.1.FolderUpdates.FolderUpdated", []interface{}{__arg}, nil)
+	return
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	stdpath "path"
+	"strings"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// SimpleFSGetAccessLog returns the reads of files under the KBFS
+// path `path` that this device logged, whose paths contain `filter`,
+// most recent first.
+// Entry paths are relative to the root of the TLF.
+func (k *SimpleFS) SimpleFSGetAccessLog(
+	ctx context.Context, path keybase1.Path, filter string) (
+	entries []libkbfs.AccessLogEntry, err error) {
+	ctx, err = k.startSyncOp(ctx, "GetAccessLog", path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	_, _, restOfPath, finalElem, err := remoteTlfAndPath(path)
+	if err != nil {
+		return nil, err
+	}
+	tlfHandle, err := k.getTlfHandle(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	rootNode, _, err := k.config.KBFSOps().GetRootNode(
+		ctx, tlfHandle, libkbfs.MasterBranch)
+	if err != nil {
+		return nil, err
+	}
+	if rootNode == nil {
+		return nil, nil
+	}
+	all, err := libkbfs.GetAccessLog(ctx, k.config, rootNode, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := stdpath.Join(restOfPath, finalElem)
+	if dir == "" {
+		return all, nil
+	}
+	for _, e := range all {
+		if e.Path == dir || strings.HasPrefix(e.Path, dir+"/") {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// SimpleFSSetAccessLogOptIn sets whether this device logs the user's
+// reads of the team TLF at `path`, when the TLF's settings turn read
+// access logging on.
+func (k *SimpleFS) SimpleFSSetAccessLogOptIn(
+	ctx context.Context, path keybase1.Path, optIn bool) (err error) {
+	ctx, err = k.startSyncOp(ctx, "SetAccessLogOptIn", path)
+	if err != nil {
+		return err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	tlfHandle, err := k.getTlfHandle(ctx, path)
+	if err != nil {
+		return err
+	}
+	rootNode, _, err := k.config.KBFSOps().GetOrCreateRootNode(
+		ctx, tlfHandle, libkbfs.MasterBranch)
+	if err != nil {
+		return err
+	}
+	return libkbfs.SetAccessLogOptIn(
+		ctx, k.config, rootNode.GetFolderBranch().Tlf, optIn)
+}
@@ -0,0 +1,412 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// BatchOpType is the kind of a single step in a batch.
+type BatchOpType int
+
+const (
+	// BatchOpWrite replaces the contents of Dest with Data.
+	BatchOpWrite BatchOpType = iota
+	// BatchOpCopy copies the file at Src to Dest.
+	BatchOpCopy
+	// BatchOpMove moves the file at Src to Dest, which may be in a
+	// different folder.
+	BatchOpMove
+	// BatchOpRemove removes the file or empty directory at Dest.
+	BatchOpRemove
+	// BatchOpMkdir creates the directory at Dest.
+	BatchOpMkdir
+)
+
+func (t BatchOpType) String() string {
+	switch t {
+	case BatchOpWrite:
+		return "write"
+	case BatchOpCopy:
+		return "copy"
+	case BatchOpMove:
+		return "move"
+	case BatchOpRemove:
+		return "remove"
+	case BatchOpMkdir:
+		return "mkdir"
+	default:
+		return fmt.Sprintf("BatchOpType(%d)", int(t))
+	}
+}
+
+// BatchOp is a single step in a batch.  Src is only used by copies
+// and moves, and Data only by writes.
+type BatchOp struct {
+	Type BatchOpType
+	Src  keybase1.Path
+	Dest keybase1.Path
+	Data []byte
+}
+
+// BatchStepStatus says what happened to a single step in a batch.
+type BatchStepStatus int
+
+const (
+	// BatchStepPending means the step was never attempted, because
+	// an earlier step failed.
+	BatchStepPending BatchStepStatus = iota
+	// BatchStepDone means the step was performed and remains in
+	// effect.
+	BatchStepDone
+	// BatchStepFailed means the step failed, and any partial effects
+	// of it were undone.
+	BatchStepFailed
+	// BatchStepRolledBack means the step was performed, but was
+	// undone because a later step failed.
+	BatchStepRolledBack
+	// BatchStepRollbackFailed means the step was at least partially
+	// performed, and undoing it failed, so its effects may remain.
+	BatchStepRollbackFailed
+)
+
+func (s BatchStepStatus) String() string {
+	switch s {
+	case BatchStepPending:
+		return "pending"
+	case BatchStepDone:
+		return "done"
+	case BatchStepFailed:
+		return "failed"
+	case BatchStepRolledBack:
+		return "rolled back"
+	case BatchStepRollbackFailed:
+		return "rollback failed"
+	default:
+		return fmt.Sprintf("BatchStepStatus(%d)", int(s))
+	}
+}
+
+// BatchStepResult reports the outcome of a single step in a batch.
+type BatchStepResult struct {
+	Op     BatchOp
+	Status BatchStepStatus
+	// Err is the error that made the step fail, or that prevented it
+	// from being rolled back.
+	Err string `json:",omitempty"`
+}
+
+// BatchResult reports the outcome of every step in a batch, in order.
+type BatchResult struct {
+	Steps []BatchStepResult
+}
+
+var errBatchDirUnsupported = simpleFSError{
+	"Only files can be copied or moved in a batch"}
+
+// batchSnapshot records the state of a path before a batch step
+// changed it, so the step can be undone.
+type batchSnapshot struct {
+	path    keybase1.Path
+	existed bool
+	isDir   bool
+	// backup is the name, in the same directory as `path`, that the
+	// original file was moved to, or "" if it was left in place.
+	backup string
+}
+
+// batchBackupName returns a hidden, unique name to move `name` aside
+// to while a batch is in progress.
+func batchBackupName(name string) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "." + name + ".batch-" + base64.URLEncoding.EncodeToString(b), nil
+}
+
+// snapshotForBatch records the state of `p`.  If `moveAside` is set
+// and `p` is a file, the file is renamed to a backup name rather than
+// copied, which keeps its contents, exec bit and mtime intact for
+// the undo, and leaves `p` free for the step to replace.
+func (k *SimpleFS) snapshotForBatch(
+	ctx context.Context, p keybase1.Path, moveAside bool) (
+	batchSnapshot, error) {
+	s := batchSnapshot{path: p}
+	fs, finalElem, err := k.getFS(ctx, p)
+	if err != nil {
+		return batchSnapshot{}, err
+	}
+	fi, err := fs.Lstat(finalElem)
+	switch {
+	case os.IsNotExist(err):
+		return s, nil
+	case err != nil:
+		return batchSnapshot{}, err
+	}
+	s.existed = true
+	s.isDir = fi.IsDir()
+	if s.isDir || !moveAside {
+		return s, nil
+	}
+	backup, err := batchBackupName(finalElem)
+	if err != nil {
+		return batchSnapshot{}, err
+	}
+	if err := fs.Rename(finalElem, backup); err != nil {
+		return batchSnapshot{}, err
+	}
+	s.backup = backup
+	if err := syncBatchFS(fs); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// syncBatchFS flushes the changes to the folder behind `fs`, so each
+// step that completes is durable on its own.
+func syncBatchFS(fs billy.Filesystem) error {
+	if kbfs, ok := fs.(*libfs.FS); ok {
+		return kbfs.SyncAll()
+	}
+	return nil
+}
+
+func writeBatchFile(
+	ctx context.Context, fs billy.Filesystem, name string,
+	src io.Reader) error {
+	f, err := fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	err = copyWithCancellation(ctx, f, src)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (k *SimpleFS) restoreBatchSnapshot(
+	ctx context.Context, s batchSnapshot) error {
+	fs, finalElem, err := k.getFS(ctx, s.path)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !s.existed:
+		err = fs.Remove(finalElem)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	case s.isDir:
+		err = fs.MkdirAll(finalElem, 0755)
+	case s.backup != "":
+		err = fs.Remove(finalElem)
+		if err == nil || os.IsNotExist(err) {
+			err = fs.Rename(s.backup, finalElem)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return syncBatchFS(fs)
+}
+
+// discardBatchSnapshot removes the backup of a step that remains in
+// effect.
+func (k *SimpleFS) discardBatchSnapshot(
+	ctx context.Context, s batchSnapshot) error {
+	if s.backup == "" {
+		return nil
+	}
+	fs, _, err := k.getFS(ctx, s.path)
+	if err != nil {
+		return err
+	}
+	if err := fs.Remove(s.backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return syncBatchFS(fs)
+}
+
+// openBatchSource opens the file at `p` for a copy or move.
+func (k *SimpleFS) openBatchSource(
+	ctx context.Context, p keybase1.Path) (billy.File, error) {
+	fs, finalElem, err := k.getFS(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := fs.Lstat(finalElem)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, errBatchDirUnsupported
+	}
+	return fs.Open(finalElem)
+}
+
+// doBatchOp performs one step, returning snapshots of everything it
+// may have changed, in the order they were taken.  The snapshots are
+// returned even on failure, so partial effects can be undone.
+func (k *SimpleFS) doBatchOp(ctx context.Context, op BatchOp) (
+	snaps []batchSnapshot, err error) {
+	var src io.Reader
+	switch op.Type {
+	case BatchOpCopy:
+		f, err := k.openBatchSource(ctx, op.Src)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		src = f
+	case BatchOpMove:
+		// Moving the source aside is what removes it; the backup is
+		// deleted once the whole batch succeeds.
+		srcSnap, err := k.snapshotForBatch(ctx, op.Src, true)
+		if srcSnap.existed {
+			snaps = append(snaps, srcSnap)
+		}
+		if err != nil {
+			return snaps, err
+		}
+		if !srcSnap.existed {
+			return nil, os.ErrNotExist
+		} else if srcSnap.isDir {
+			return nil, errBatchDirUnsupported
+		}
+		srcFS, _, err := k.getFS(ctx, op.Src)
+		if err != nil {
+			return snaps, err
+		}
+		f, err := srcFS.Open(srcSnap.backup)
+		if err != nil {
+			return snaps, err
+		}
+		defer f.Close()
+		src = f
+	case BatchOpWrite:
+		src = bytes.NewReader(op.Data)
+	case BatchOpRemove, BatchOpMkdir:
+	default:
+		return nil, errors.Errorf("Unknown batch op type %s", op.Type)
+	}
+
+	destSnap, err := k.snapshotForBatch(
+		ctx, op.Dest, op.Type != BatchOpMkdir)
+	snaps = append(snaps, destSnap)
+	if err != nil {
+		return snaps, err
+	}
+
+	fs, finalElem, err := k.getFS(ctx, op.Dest)
+	if err != nil {
+		return snaps, err
+	}
+	switch op.Type {
+	case BatchOpWrite, BatchOpCopy, BatchOpMove:
+		err = writeBatchFile(ctx, fs, finalElem, src)
+	case BatchOpRemove:
+		// A file was already moved aside by the snapshot.
+		if destSnap.backup == "" {
+			err = fs.Remove(finalElem)
+		}
+	case BatchOpMkdir:
+		err = fs.MkdirAll(finalElem, 0755)
+	}
+	if err != nil {
+		return snaps, err
+	}
+	if err := syncBatchFS(fs); err != nil {
+		return snaps, err
+	}
+	return snaps, nil
+}
+
+// rollbackBatchStep undoes a step by restoring its snapshots in
+// reverse order.
+func (k *SimpleFS) rollbackBatchStep(
+	ctx context.Context, snaps []batchSnapshot) error {
+	for i := len(snaps) - 1; i >= 0; i-- {
+		if err := k.restoreBatchSnapshot(ctx, snaps[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SimpleFSBatch performs `ops` in order, possibly across several
+// folders.  Each step is flushed as soon as it completes.  If a step
+// fails, it and every completed step before it are undone, in
+// reverse order, and the first failure is returned.  Files that a
+// step replaces or removes are kept under hidden backup names in the
+// same directory until the batch finishes.  This is best
+// effort: other writers may observe the intermediate states, and a
+// failed rollback leaves its step in place, which the result reports
+// for every step.
+func (k *SimpleFS) SimpleFSBatch(ctx context.Context, ops []BatchOp) (
+	res BatchResult, err error) {
+	ctx, err = k.startSyncOp(ctx, "Batch", ops)
+	if err != nil {
+		return BatchResult{}, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	res.Steps = make([]BatchStepResult, len(ops))
+	undos := make([][]batchSnapshot, len(ops))
+	for i, op := range ops {
+		res.Steps[i].Op = op
+	}
+
+	for i, op := range ops {
+		snaps, stepErr := k.doBatchOp(ctx, op)
+		undos[i] = snaps
+		if stepErr == nil {
+			res.Steps[i].Status = BatchStepDone
+			continue
+		}
+
+		k.log.CDebugf(ctx, "Batch step %d (%s) failed: %+v", i, op.Type, stepErr)
+		res.Steps[i].Status = BatchStepFailed
+		res.Steps[i].Err = stepErr.Error()
+		for j := i; j >= 0; j-- {
+			rbErr := k.rollbackBatchStep(ctx, undos[j])
+			switch {
+			case rbErr != nil && j == i:
+				res.Steps[j].Status = BatchStepRollbackFailed
+				res.Steps[j].Err = fmt.Sprintf(
+					"%v; rollback failed: %v", stepErr, rbErr)
+			case rbErr != nil:
+				res.Steps[j].Status = BatchStepRollbackFailed
+				res.Steps[j].Err = rbErr.Error()
+			case j < i:
+				res.Steps[j].Status = BatchStepRolledBack
+			}
+		}
+		return res, errors.Wrapf(stepErr, "Batch step %d (%s)", i, op.Type)
+	}
+
+	for _, snaps := range undos {
+		for _, s := range snaps {
+			if err := k.discardBatchSnapshot(ctx, s); err != nil {
+				k.log.CDebugf(ctx, "Couldn't remove batch backup %s: %+v",
+					s.backup, err)
+			}
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"strings"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+var errCloneNotFolder = simpleFSError{"Only whole folders can be cloned"}
+
+// SimpleFSCloneFolder creates a new folder at `dst` from the TLF at
+// `src`.  An archived `src` path clones the folder as of that
+// revision; otherwise the current head is cloned.  `dst` may be the
+// root of another TLF, which must be empty, or a new directory to
+// create.
+func (k *SimpleFS) SimpleFSCloneFolder(
+	ctx context.Context, src, dst keybase1.Path) (
+	stats libkbfs.CloneStats, err error) {
+	ctx, err = k.startSyncOp(ctx, "CloneFolder", src)
+	if err != nil {
+		return libkbfs.CloneStats{}, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	_, _, restOfPath, finalElem, err := remoteTlfAndPath(src)
+	if err != nil {
+		return libkbfs.CloneStats{}, err
+	}
+	if restOfPath != "" || finalElem != "" {
+		return libkbfs.CloneStats{}, errCloneNotFolder
+	}
+	srcHandle, err := k.getTlfHandle(ctx, src)
+	if err != nil {
+		return libkbfs.CloneStats{}, err
+	}
+	branch, err := k.branchNameFromPath(ctx, srcHandle, src)
+	if err != nil {
+		return libkbfs.CloneStats{}, err
+	}
+	rev, ok := branch.RevisionIfSpecified()
+	if !ok {
+		rev = kbfsmd.RevisionUninitialized
+	}
+
+	dstNode, err := k.makeCloneDestination(ctx, dst)
+	if err != nil {
+		return libkbfs.CloneStats{}, err
+	}
+	return libkbfs.CloneFolder(ctx, k.config, srcHandle, rev, dstNode)
+}
+
+// makeCloneDestination returns the node for the root of the TLF at
+// `dst`, creating the TLF if needed, or creates the directory at
+// `dst` if it's under a TLF root.
+func (k *SimpleFS) makeCloneDestination(
+	ctx context.Context, dst keybase1.Path) (libkbfs.Node, error) {
+	pt, err := dst.PathType()
+	if err != nil {
+		return nil, err
+	}
+	if pt != keybase1.PathType_KBFS {
+		return nil, simpleFSError{"Clones can only be made in KBFS"}
+	}
+	_, _, restOfPath, finalElem, err := remoteTlfAndPath(dst)
+	if err != nil {
+		return nil, err
+	}
+	dstHandle, err := k.getTlfHandle(ctx, dst)
+	if err != nil {
+		return nil, err
+	}
+	kbfsOps := k.config.KBFSOps()
+	node, _, err := kbfsOps.GetOrCreateRootNode(
+		ctx, dstHandle, libkbfs.MasterBranch)
+	if err != nil {
+		return nil, err
+	}
+	if finalElem == "" {
+		return node, nil
+	}
+	if restOfPath != "" {
+		for _, elem := range strings.Split(restOfPath, "/") {
+			node, _, err = kbfsOps.Lookup(ctx, node, elem)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	node, _, err = kbfsOps.CreateDir(ctx, node, finalElem)
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
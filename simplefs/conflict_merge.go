@@ -0,0 +1,40 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+var errMergeNotFolder = simpleFSError{"Only whole folders can be merged"}
+
+// SimpleFSMergeConflictedFolder merges the contents of the conflicted
+// folder at `path`, e.g. "/private/alice,bob (conflicted copy
+// 2016-03-14)", into the folder it was split from.  With `dryRun`,
+// nothing is written and the returned report previews the merge.
+func (k *SimpleFS) SimpleFSMergeConflictedFolder(
+	ctx context.Context, path keybase1.Path, dryRun bool) (
+	report libkbfs.ConflictMergeReport, err error) {
+	ctx, err = k.startSyncOp(ctx, "MergeConflictedFolder", path)
+	if err != nil {
+		return libkbfs.ConflictMergeReport{}, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	_, _, restOfPath, finalElem, err := remoteTlfAndPath(path)
+	if err != nil {
+		return libkbfs.ConflictMergeReport{}, err
+	}
+	if restOfPath != "" || finalElem != "" {
+		return libkbfs.ConflictMergeReport{}, errMergeNotFolder
+	}
+	h, err := k.getTlfHandle(ctx, path)
+	if err != nil {
+		return libkbfs.ConflictMergeReport{}, err
+	}
+	return libkbfs.MergeConflictedFolder(ctx, k.config, h, dryRun)
+}
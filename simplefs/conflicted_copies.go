@@ -0,0 +1,112 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+var errConflictedCopiesNotKBFS = simpleFSError{
+	"Conflicted copies can only be found in KBFS directories"}
+
+// getConflictsFS returns the KBFS filesystem rooted at `path`.
+func (k *SimpleFS) getConflictsFS(
+	ctx context.Context, path keybase1.Path) (*libfs.FS, error) {
+	fs, finalElem, err := k.getFS(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	kbfs, ok := fs.(*libfs.FS)
+	if !ok {
+		return nil, errConflictedCopiesNotKBFS
+	}
+	if finalElem != "" {
+		kbfs, err = kbfs.ChrootAsLibFS(finalElem)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return kbfs, nil
+}
+
+// SimpleFSFindConflictedCopies returns the conflicted copies of files
+// under the KBFS directory `path`, each paired with its original,
+// with paths relative to `path`.
+func (k *SimpleFS) SimpleFSFindConflictedCopies(
+	ctx context.Context, path keybase1.Path) (
+	copies []libkbfs.ConflictedCopy, err error) {
+	ctx, err = k.startSyncOp(ctx, "FindConflictedCopies", path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	kbfs, err := k.getConflictsFS(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return libkbfs.FindConflictedCopies(ctx, k.config, kbfs.RootNode())
+}
+
+// SimpleFSDiffConflictedCopy returns the difference between the
+// conflicted copy `copyPath`, relative to the KBFS directory `path`,
+// and its original.
+func (k *SimpleFS) SimpleFSDiffConflictedCopy(
+	ctx context.Context, path keybase1.Path, copyPath string) (
+	diff libkbfs.ConflictedCopyDiff, err error) {
+	ctx, err = k.startSyncOp(ctx, "DiffConflictedCopy", path)
+	if err != nil {
+		return libkbfs.ConflictedCopyDiff{}, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	kbfs, err := k.getConflictsFS(ctx, path)
+	if err != nil {
+		return libkbfs.ConflictedCopyDiff{}, err
+	}
+	copies, err := libkbfs.FindConflictedCopies(
+		ctx, k.config, kbfs.RootNode())
+	if err != nil {
+		return libkbfs.ConflictedCopyDiff{}, err
+	}
+	for _, cc := range copies {
+		if cc.Path == copyPath {
+			return libkbfs.DiffConflictedCopy(
+				ctx, k.config, kbfs.RootNode(), cc)
+		}
+	}
+	return libkbfs.ConflictedCopyDiff{}, simpleFSError{
+		copyPath + " is not a conflicted copy"}
+}
+
+// SimpleFSResolveConflictedCopies resolves all the conflicted copies
+// under the KBFS directory `path` at once, and returns what was done.
+// With `dryRun`, nothing is changed, and the returned actions preview
+// the resolution.
+func (k *SimpleFS) SimpleFSResolveConflictedCopies(
+	ctx context.Context, path keybase1.Path,
+	resolution libkbfs.ConflictResolution, dryRun bool) (
+	actions []libkbfs.ConflictedCopyAction, err error) {
+	ctx, err = k.startSyncOp(ctx, "ResolveConflictedCopies", path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	kbfs, err := k.getConflictsFS(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	copies, err := libkbfs.FindConflictedCopies(
+		ctx, k.config, kbfs.RootNode())
+	if err != nil {
+		return nil, err
+	}
+	return libkbfs.ResolveConflictedCopies(
+		ctx, k.config, kbfs.RootNode(), copies, resolution, dryRun)
+}
@@ -0,0 +1,295 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"os"
+	"sort"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// DryRunEntry describes one path that a destructive operation would
+// affect.
+type DryRunEntry struct {
+	Path  keybase1.Path
+	Bytes int64
+	IsDir bool
+	// Overwrite is true if the operation would replace an existing
+	// entry at Path.
+	Overwrite bool
+	// Conflict, if non-empty, explains why the operation might not
+	// do what the caller expects for this entry, e.g. because the
+	// entry was changed remotely or is newer than its replacement.
+	Conflict string `json:",omitempty"`
+}
+
+// DryRunResult lists everything a destructive operation would
+// affect, without the operation actually being performed.
+type DryRunResult struct {
+	Entries    []DryRunEntry
+	TotalBytes int64
+}
+
+func (r *DryRunResult) add(e DryRunEntry) {
+	r.Entries = append(r.Entries, e)
+	r.TotalBytes += e.Bytes
+}
+
+type dryRunStat struct {
+	path keybase1.Path
+	fi   os.FileInfo
+}
+
+func dryRunKey(p keybase1.Path) string {
+	pt, err := p.PathType()
+	if err != nil {
+		return ""
+	}
+	if pt == keybase1.PathType_LOCAL {
+		return p.Local()
+	}
+	raw, err := rawPathFromKbfsPath(p)
+	if err != nil {
+		return ""
+	}
+	return raw
+}
+
+// statSubtree returns the stat info for `root` and, if `recursive` is
+// true, for everything under it, sorted by path.
+func (k *SimpleFS) statSubtree(
+	ctx context.Context, root keybase1.Path, recursive bool) (
+	[]dryRunStat, error) {
+	var res []dryRunStat
+	paths := []keybase1.Path{root}
+	for len(paths) > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		p := paths[len(paths)-1]
+		paths = paths[:len(paths)-1]
+		fs, finalElem, err := k.getFS(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		fi, err := fs.Lstat(finalElem)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, dryRunStat{p, fi})
+		if !recursive || !fi.IsDir() {
+			continue
+		}
+		fis, err := fs.ReadDir(finalElem)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range fis {
+			paths = append(paths, pathAppend(p, child.Name()))
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return dryRunKey(res[i].path) < dryRunKey(res[j].path)
+	})
+	return res, nil
+}
+
+// syncFromServerForDryRun makes sure the local view of the folder
+// containing `path` reflects the latest remote version, so that the
+// dry run reports what would really happen.
+func (k *SimpleFS) syncFromServerForDryRun(
+	ctx context.Context, path keybase1.Path) error {
+	pt, err := path.PathType()
+	if err != nil {
+		return err
+	}
+	if pt != keybase1.PathType_KBFS {
+		return nil
+	}
+	fb, _, err := k.getFolderBranchFromPath(ctx, path)
+	if err != nil {
+		return err
+	}
+	if fb == (libkbfs.FolderBranch{}) {
+		return nil
+	}
+	return k.config.KBFSOps().SyncFromServer(ctx, fb, nil)
+}
+
+func (k *SimpleFS) dryRunRemove(
+	ctx context.Context, path keybase1.Path, recursive bool,
+	res *DryRunResult) error {
+	// Compare what the caller could see before syncing with the
+	// latest remote state, to flag anything that changed remotely.
+	before, err := k.statSubtree(ctx, path, recursive)
+	if err != nil {
+		return err
+	}
+	err = k.syncFromServerForDryRun(ctx, path)
+	if err != nil {
+		return err
+	}
+	after, err := k.statSubtree(ctx, path, true)
+	if err != nil {
+		return err
+	}
+
+	beforeMtimes := make(map[string]int64, len(before))
+	for _, s := range before {
+		beforeMtimes[dryRunKey(s.path)] = s.fi.ModTime().UnixNano()
+	}
+	if !recursive {
+		e := DryRunEntry{
+			Path:  path,
+			Bytes: after[0].fi.Size(),
+			IsDir: after[0].fi.IsDir(),
+		}
+		if e.IsDir {
+			e.Bytes = 0
+			if len(after) > 1 {
+				e.Conflict = "directory is not empty"
+			}
+		}
+		res.add(e)
+		return nil
+	}
+
+	for _, s := range after {
+		e := DryRunEntry{Path: s.path, IsDir: s.fi.IsDir()}
+		if !e.IsDir {
+			e.Bytes = s.fi.Size()
+		}
+		mtime, ok := beforeMtimes[dryRunKey(s.path)]
+		switch {
+		case !ok:
+			e.Conflict = "added remotely"
+		case mtime != s.fi.ModTime().UnixNano():
+			e.Conflict = "changed remotely"
+		}
+		res.add(e)
+	}
+	return nil
+}
+
+func (k *SimpleFS) dryRunCopy(
+	ctx context.Context, src, dest keybase1.Path, recursive bool,
+	res *DryRunResult) error {
+	for _, p := range []keybase1.Path{src, dest} {
+		if err := k.syncFromServerForDryRun(ctx, p); err != nil {
+			return err
+		}
+	}
+	srcStats, err := k.statSubtree(ctx, src, recursive)
+	if err != nil {
+		return err
+	}
+	srcKey := dryRunKey(src)
+	for _, s := range srcStats {
+		destPath := dest
+		if rel := dryRunKey(s.path)[len(srcKey):]; rel != "" {
+			destPath = pathAppend(dest, rel)
+		}
+		e := DryRunEntry{Path: destPath, IsDir: s.fi.IsDir()}
+		if !e.IsDir {
+			e.Bytes = s.fi.Size()
+		}
+
+		destFS, finalElem, err := k.getFS(ctx, destPath)
+		if err != nil {
+			return err
+		}
+		destFI, err := destFS.Lstat(finalElem)
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			return err
+		case destFI.IsDir() != s.fi.IsDir():
+			e.Overwrite = true
+			e.Conflict = "destination has a different type"
+		case destFI.IsDir():
+			// Directories are merged, not replaced.
+		default:
+			e.Overwrite = true
+			if destFI.ModTime().After(s.fi.ModTime()) {
+				e.Conflict = "destination is newer than source"
+			}
+		}
+		res.add(e)
+	}
+	return nil
+}
+
+// SimpleFSRemoveDryRun reports what `SimpleFSRemove` would remove for
+// `path`, without removing anything.  If `recursive` is true, the
+// whole subtree under `path` is reported.  Entries that were changed
+// remotely since they were last seen locally are marked with a
+// conflict.
+func (k *SimpleFS) SimpleFSRemoveDryRun(
+	ctx context.Context, path keybase1.Path, recursive bool) (
+	res DryRunResult, err error) {
+	ctx, err = k.startSyncOp(ctx, "RemoveDryRun", path)
+	if err != nil {
+		return DryRunResult{}, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	err = k.dryRunRemove(ctx, path, recursive, &res)
+	if err != nil {
+		return DryRunResult{}, err
+	}
+	return res, nil
+}
+
+// SimpleFSCopyDryRun reports which destination entries `SimpleFSCopy`
+// (or `SimpleFSCopyRecursive`, if `recursive` is true) would create
+// or overwrite, without copying anything.  Overwrites of destination
+// files that are newer than their source are marked with a conflict.
+func (k *SimpleFS) SimpleFSCopyDryRun(
+	ctx context.Context, src, dest keybase1.Path, recursive bool) (
+	res DryRunResult, err error) {
+	ctx, err = k.startSyncOp(ctx, "CopyDryRun", pathPair{src, dest})
+	if err != nil {
+		return DryRunResult{}, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	err = k.dryRunCopy(ctx, src, dest, recursive, &res)
+	if err != nil {
+		return DryRunResult{}, err
+	}
+	return res, nil
+}
+
+// SimpleFSMoveDryRun reports what `SimpleFSMove` would write and
+// remove, without moving anything.  The result lists the destination
+// entries first, followed by the source entries that would be
+// removed.
+func (k *SimpleFS) SimpleFSMoveDryRun(
+	ctx context.Context, src, dest keybase1.Path) (
+	res DryRunResult, err error) {
+	ctx, err = k.startSyncOp(ctx, "MoveDryRun", pathPair{src, dest})
+	if err != nil {
+		return DryRunResult{}, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	// Mirror `SimpleFSMove`, which is a non-recursive copy followed
+	// by a remove.
+	err = k.dryRunCopy(ctx, src, dest, false, &res)
+	if err != nil {
+		return DryRunResult{}, err
+	}
+	err = k.dryRunRemove(ctx, src, false, &res)
+	if err != nil {
+		return DryRunResult{}, err
+	}
+	return res, nil
+}
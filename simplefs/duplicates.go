@@ -0,0 +1,44 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libfs"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+var errDuplicatesNotKBFS = simpleFSError{
+	"Duplicates can only be found in KBFS directories"}
+
+// SimpleFSFindDuplicates returns the groups of files under the KBFS
+// directory `path` that have identical contents, with paths relative
+// to `path`.
+func (k *SimpleFS) SimpleFSFindDuplicates(
+	ctx context.Context, path keybase1.Path) (
+	groups []libkbfs.DuplicateFileGroup, err error) {
+	ctx, err = k.startSyncOp(ctx, "FindDuplicates", path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	fs, finalElem, err := k.getFS(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	kbfs, ok := fs.(*libfs.FS)
+	if !ok {
+		return nil, errDuplicatesNotKBFS
+	}
+	if finalElem != "" {
+		kbfs, err = kbfs.ChrootAsLibFS(finalElem)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return libkbfs.FindDuplicateFiles(ctx, k.config, kbfs.RootNode())
+}
@@ -0,0 +1,57 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// SimpleFSFreezeWrites quiesces the TLF containing `path` so that an
+// external backup tool can copy it in a crash-consistent state: what
+// was written is synced, and new writes fail (with EAGAIN through the
+// mount) until `SimpleFSThawWrites` is called, or until `timeout`
+// passes, unless it's zero.
+func (k *SimpleFS) SimpleFSFreezeWrites(ctx context.Context,
+	path keybase1.Path, timeout time.Duration) (err error) {
+	ctx, err = k.startSyncOp(ctx, "FreezeWrites", path)
+	if err != nil {
+		return err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	fb, _, err := k.getFolderBranchFromPath(ctx, path)
+	if err != nil {
+		return err
+	}
+	if fb == (libkbfs.FolderBranch{}) {
+		// The TLF doesn't exist yet, so there's nothing to freeze.
+		return nil
+	}
+	return k.config.KBFSOps().FreezeWrites(ctx, fb, timeout)
+}
+
+// SimpleFSThawWrites lets writes to the TLF containing `path` resume
+// after `SimpleFSFreezeWrites`.
+func (k *SimpleFS) SimpleFSThawWrites(
+	ctx context.Context, path keybase1.Path) (err error) {
+	ctx, err = k.startSyncOp(ctx, "ThawWrites", path)
+	if err != nil {
+		return err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	fb, _, err := k.getFolderBranchFromPath(ctx, path)
+	if err != nil {
+		return err
+	}
+	if fb == (libkbfs.FolderBranch{}) {
+		return nil
+	}
+	return k.config.KBFSOps().ThawWrites(ctx, fb)
+}
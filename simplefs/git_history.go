@@ -0,0 +1,104 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	stdpath "path"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libgit"
+	"golang.org/x/net/context"
+)
+
+var errNotAutogitPath = simpleFSError{"Not a path within an autogit repo view"}
+
+// autogitPathParts splits a remote `path` under the autogit view of a
+// TLF into the repo name, the branch and the subdirectory it names.
+func autogitPathParts(path keybase1.Path) (
+	repoName, branch, subdir string, err error) {
+	_, _, restOfPath, finalElem, err := remoteTlfAndPath(path)
+	if err != nil {
+		return "", "", "", err
+	}
+	repoName, branchRef, subdir, ok := libgit.ParseAutogitPath(
+		stdpath.Join(restOfPath, finalElem))
+	if !ok {
+		return "", "", "", errNotAutogitPath
+	}
+	return repoName, string(branchRef), subdir, nil
+}
+
+// SimpleFSGitLastCommits returns the last commit to change each entry
+// of the directory at `path`, which must be within the autogit view
+// of a repo (e.g., "/keybase/team/t/.kbfs_autogit/repo/dir").  If
+// `rev` is empty, the branch named by the path is used, or master if
+// there is none.
+func (k *SimpleFS) SimpleFSGitLastCommits(
+	ctx context.Context, path keybase1.Path, rev string) (
+	lastCommits []libgit.EntryLastCommit, err error) {
+	ctx, err = k.startSyncOp(ctx, "GitLastCommits", path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	repoName, branch, subdir, err := autogitPathParts(path)
+	if err != nil {
+		return nil, err
+	}
+	if rev == "" {
+		rev = branch
+	}
+	h, err := k.getTlfHandle(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return libgit.LastCommitsForDir(ctx, k.config, h, repoName, rev, subdir)
+}
+
+// SimpleFSGitDiff returns the differences between revisions `fromRev`
+// and `toRev` of the repo whose autogit view contains `path`.
+func (k *SimpleFS) SimpleFSGitDiff(
+	ctx context.Context, path keybase1.Path, fromRev, toRev string) (
+	diffs []libgit.FileDiff, err error) {
+	ctx, err = k.startSyncOp(ctx, "GitDiff", path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	repoName, _, _, err := autogitPathParts(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := k.getTlfHandle(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return libgit.DiffRevisions(ctx, k.config, h, repoName, fromRev, toRev)
+}
+
+// SimpleFSGitListRefs returns the branches and tags of the repo whose
+// autogit view contains `path`, along with whether each one was
+// signed by a PGP key proven by a member of the folder.
+func (k *SimpleFS) SimpleFSGitListRefs(
+	ctx context.Context, path keybase1.Path) (
+	refs []libgit.RefInfo, err error) {
+	ctx, err = k.startSyncOp(ctx, "GitListRefs", path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	repoName, _, _, err := autogitPathParts(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := k.getTlfHandle(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return libgit.ListRefsWithVerification(ctx, k.config, h, repoName)
+}
@@ -0,0 +1,68 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"github.com/keybase/go-framed-msgpack-rpc/rpc"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// IdentifyModeRPCTag is the RPC tag that carries the identify mode
+// of a single SimpleFS call.  RPC tags travel with each request, so
+// the mode applies only to the call it's sent with, and not to other
+// clients sharing the same SimpleFS instance.
+const IdentifyModeRPCTag = "kbfs-identify-mode"
+
+// WithIdentifyMode returns a context whose SimpleFS calls ask for
+// their identifies to behave as `mode` asks, e.g.
+// libkbfs.IdentifyModeNone for an automated job that shouldn't
+// trigger identify popups.
+func WithIdentifyMode(
+	ctx context.Context, mode libkbfs.IdentifyMode) context.Context {
+	return rpc.AddRpcTagsToContext(
+		ctx, rpc.CtxRpcTags{IdentifyModeRPCTag: mode.String()})
+}
+
+// identifyModeFromContext returns the identify mode the caller asked
+// for in `ctx`, or an error if it isn't a valid one.
+func identifyModeFromContext(
+	ctx context.Context) (libkbfs.IdentifyMode, error) {
+	tags, ok := rpc.RpcTagsFromContext(ctx)
+	if !ok {
+		return libkbfs.IdentifyModeDefault, nil
+	}
+	mode, ok := tags[IdentifyModeRPCTag].(string)
+	if !ok {
+		return libkbfs.IdentifyModeDefault, nil
+	}
+	return libkbfs.ParseIdentifyMode(mode)
+}
+
+// copyIdentifyMode returns `to` with the identify mode asked for in
+// `from`, for contexts that outlive the call that asked for it.
+func copyIdentifyMode(to, from context.Context) context.Context {
+	tags, ok := rpc.RpcTagsFromContext(from)
+	if !ok {
+		return to
+	}
+	mode, ok := tags[IdentifyModeRPCTag]
+	if !ok {
+		return to
+	}
+	return rpc.AddRpcTagsToContext(
+		to, rpc.CtxRpcTags{IdentifyModeRPCTag: mode})
+}
+
+// SimpleFSGetIdentifyMode returns how identifies behave for this
+// call, as asked for with WithIdentifyMode.
+func (k *SimpleFS) SimpleFSGetIdentifyMode(
+	ctx context.Context) (string, error) {
+	mode, err := identifyModeFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	return mode.String(), nil
+}
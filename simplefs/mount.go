@@ -0,0 +1,107 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+func (k *SimpleFS) mountManager() (libkbfs.MountManager, error) {
+	mm := k.config.MountManager()
+	if mm == nil {
+		return nil, libkbfs.NoMountManagerError{}
+	}
+	return mm, nil
+}
+
+// SimpleFSGetMountStatus returns whether KBFS is mounted by this
+// process, where, and which files are open through the mount.
+func (k *SimpleFS) SimpleFSGetMountStatus(ctx context.Context) (
+	status libkbfs.MountStatus, err error) {
+	ctx, err = k.startSyncOp(ctx, "GetMountStatus", nil)
+	if err != nil {
+		return libkbfs.MountStatus{}, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	mm, err := k.mountManager()
+	if err != nil {
+		return libkbfs.MountStatus{}, err
+	}
+	return mm.MountStatus(ctx)
+}
+
+// SimpleFSMount mounts KBFS at its configured mount point, if it
+// isn't mounted already.
+func (k *SimpleFS) SimpleFSMount(ctx context.Context) (err error) {
+	ctx, err = k.startSyncOp(ctx, "Mount", nil)
+	if err != nil {
+		return err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	mm, err := k.mountManager()
+	if err != nil {
+		return err
+	}
+	return mm.Mount(ctx)
+}
+
+// SimpleFSUnmount unmounts KBFS, leaving the process running so it
+// can be mounted again.  Unless `force` is true, it fails with a
+// `libkbfs.MountBusyError` listing the open files if there are any,
+// so the caller can ask the user before cutting them off.
+func (k *SimpleFS) SimpleFSUnmount(ctx context.Context, force bool) (
+	err error) {
+	ctx, err = k.startSyncOp(ctx, "Unmount", force)
+	if err != nil {
+		return err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	mm, err := k.mountManager()
+	if err != nil {
+		return err
+	}
+	return mm.Unmount(ctx, force)
+}
+
+// SimpleFSListOpenFiles returns the handles open through the mount
+// for files in the TLF containing `path`, to help find what's
+// blocking an unmount or keeping the TLF's journal busy.
+func (k *SimpleFS) SimpleFSListOpenFiles(
+	ctx context.Context, path keybase1.Path) (
+	openFiles []libkbfs.OpenFile, err error) {
+	ctx, err = k.startSyncOp(ctx, "ListOpenFiles", path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	mm, err := k.mountManager()
+	if err != nil {
+		return nil, err
+	}
+	fb, _, err := k.getFolderBranchFromPath(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if fb == (libkbfs.FolderBranch{}) {
+		// The TLF doesn't exist yet, so nothing in it can be open.
+		return nil, nil
+	}
+	all, err := mm.OpenFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, of := range all {
+		if of.Tlf == fb.Tlf {
+			openFiles = append(openFiles, of)
+		}
+	}
+	return openFiles, nil
+}
@@ -0,0 +1,309 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"sort"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/ioutil"
+	"golang.org/x/net/context"
+)
+
+// simpleFSOpsFileName is the name of the file, under the storage
+// root, where unfinished resumable operations are saved.
+const simpleFSOpsFileName = "simplefs_ops.json"
+
+var errOpInterrupted = simpleFSError{
+	"The operation was interrupted by a restart; resume or cancel it"}
+var errOpNotPausable = simpleFSError{"The operation can't be paused"}
+
+// OpState is the state of an operation in the SimpleFS operation
+// registry.
+type OpState int
+
+const (
+	// OpRunning means the operation is in progress.
+	OpRunning OpState = iota
+	// OpPaused means the operation is waiting to be resumed.
+	OpPaused
+	// OpDone means the operation finished successfully, but nobody
+	// has waited on it yet.
+	OpDone
+	// OpFailed means the operation finished with an error, but
+	// nobody has waited on it yet.
+	OpFailed
+	// OpInterrupted means the operation was running when the
+	// service last stopped, and won't continue until it's resumed.
+	OpInterrupted
+)
+
+func (s OpState) String() string {
+	switch s {
+	case OpRunning:
+		return "running"
+	case OpPaused:
+		return "paused"
+	case OpDone:
+		return "done"
+	case OpFailed:
+		return "failed"
+	case OpInterrupted:
+		return "interrupted"
+	default:
+		return "unknown"
+	}
+}
+
+// OpInfo describes an operation in the SimpleFS operation registry.
+type OpInfo struct {
+	OpID     keybase1.OpID
+	Desc     keybase1.OpDescription
+	State    OpState
+	Progress keybase1.OpProgress
+	// Pausable is true if the operation can be paused, and resumed
+	// after a restart.
+	Pausable bool
+	// Err is the error the operation failed with, if any.
+	Err string
+}
+
+// resumableOp is what's saved about a copy, move or remove operation,
+// so that it can be restarted from scratch later.  All of them are
+// safe to repeat.
+type resumableOp struct {
+	OpID keybase1.OpID
+	Desc keybase1.OpDescription
+	// Recursive distinguishes a recursive copy or remove from a
+	// plain one, since both have the same description.
+	Recursive bool `json:",omitempty"`
+}
+
+func (k *SimpleFS) resumableCallback(op resumableOp) (
+	keybase1.AsyncOps, func(context.Context) error, error) {
+	opType, err := op.Desc.AsyncOp()
+	if err != nil {
+		return 0, nil, err
+	}
+	switch opType {
+	case keybase1.AsyncOps_COPY:
+		args := op.Desc.Copy()
+		if op.Recursive {
+			return opType, func(ctx context.Context) error {
+				return k.doCopyRecursive(ctx, op.OpID, args.Src, args.Dest)
+			}, nil
+		}
+		return opType, func(ctx context.Context) error {
+			return k.doCopy(ctx, op.OpID, args.Src, args.Dest)
+		}, nil
+	case keybase1.AsyncOps_MOVE:
+		args := op.Desc.Move()
+		return opType, func(ctx context.Context) error {
+			return k.doMove(ctx, op.OpID, args.Src, args.Dest)
+		}, nil
+	case keybase1.AsyncOps_REMOVE:
+		args := op.Desc.Remove()
+		return opType, func(ctx context.Context) error {
+			if err := k.checkOp(ctx, op.OpID); err != nil {
+				return err
+			}
+			if op.Recursive {
+				return k.doRemoveRecursive(ctx, op.OpID, args.Path)
+			}
+			return k.doRemove(ctx, args.Path)
+		}, nil
+	default:
+		return 0, nil, simpleFSError{"Unresumable operation type " +
+			opType.String()}
+	}
+}
+
+// startResumable starts `op` in the background, and saves it until
+// it finishes or is canceled.
+func (k *SimpleFS) startResumable(ctx context.Context, op resumableOp) error {
+	opType, callback, err := k.resumableCallback(op)
+	if err != nil {
+		return err
+	}
+	return k.startAsyncOp(ctx, op.OpID, opType, op.Desc, &op, callback)
+}
+
+// checkOp returns an error if the operation `opID` has been canceled,
+// and blocks while it's paused.  Long operations should call it
+// regularly.
+func (k *SimpleFS) checkOp(ctx context.Context, opID keybase1.OpID) error {
+	for {
+		var resumeCh chan struct{}
+		k.lock.RLock()
+		if w, ok := k.inProgress[opID]; ok {
+			resumeCh = w.resumeCh
+		}
+		k.lock.RUnlock()
+		if resumeCh == nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+				return nil
+			}
+		}
+		select {
+		case <-resumeCh:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (k *SimpleFS) saveResumableOpsLocked() {
+	if k.opsFile == "" {
+		return
+	}
+	ws := make([]*inprogress, 0, len(k.inProgress))
+	for _, w := range k.inProgress {
+		if w.resumable != nil {
+			ws = append(ws, w)
+		}
+	}
+	sort.Slice(ws, func(i, j int) bool {
+		return ws[i].started.Before(ws[j].started)
+	})
+	ops := make([]resumableOp, 0, len(ws))
+	for _, w := range ws {
+		ops = append(ops, *w.resumable)
+	}
+	err := ioutil.SerializeToJSONFile(ops, k.opsFile)
+	if err != nil {
+		k.log.Warning("Couldn't save the unfinished operations: %+v", err)
+	}
+}
+
+// loadResumableOps restores the operations that were unfinished when
+// the service last stopped, as interrupted.
+func (k *SimpleFS) loadResumableOps() {
+	var ops []resumableOp
+	err := ioutil.DeserializeFromJSONFile(k.opsFile, &ops)
+	if err != nil {
+		if !ioutil.IsNotExist(err) {
+			k.log.Warning("Couldn't load the unfinished operations: %+v", err)
+		}
+		return
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	now := k.config.Clock().Now()
+	for i := range ops {
+		op := ops[i]
+		opType, err := op.Desc.AsyncOp()
+		if err != nil {
+			k.log.Warning("Ignoring unfinished operation %X: %+v", op.OpID, err)
+			continue
+		}
+		k.inProgress[op.OpID] = &inprogress{
+			desc:      op.Desc,
+			cancel:    func() {},
+			done:      make(chan error, 1),
+			progress:  keybase1.OpProgress{OpType: opType},
+			started:   now,
+			state:     OpInterrupted,
+			resumable: &op,
+		}
+	}
+}
+
+// SimpleFSListOps returns all the operations in the registry: those
+// in progress, paused or interrupted, and finished ones that haven't
+// been waited on yet.  They're sorted by start time.
+func (k *SimpleFS) SimpleFSListOps(_ context.Context) ([]OpInfo, error) {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	type opAndStart struct {
+		info    OpInfo
+		started int64
+	}
+	ops := make([]opAndStart, 0, len(k.inProgress))
+	for opid, w := range k.inProgress {
+		info := OpInfo{
+			OpID:     opid,
+			Desc:     w.desc,
+			State:    w.state,
+			Progress: w.progress,
+			Pausable: w.resumable != nil,
+		}
+		if w.err != nil {
+			info.Err = w.err.Error()
+		}
+		ops = append(ops, opAndStart{info, w.started.UnixNano()})
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].started < ops[j].started
+	})
+	infos := make([]OpInfo, len(ops))
+	for i, op := range ops {
+		infos[i] = op.info
+	}
+	return infos, nil
+}
+
+// SimpleFSPauseOp pauses the copy, move or remove operation `opid`.
+// It stops at the next safe point, and holds its place until it's
+// resumed or canceled.
+func (k *SimpleFS) SimpleFSPauseOp(
+	ctx context.Context, opid keybase1.OpID) (err error) {
+	ctx, err = k.startSyncOp(ctx, "PauseOp", opid)
+	if err != nil {
+		return err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	w, ok := k.inProgress[opid]
+	if !ok {
+		return errNoSuchHandle
+	}
+	if w.resumable == nil {
+		return errOpNotPausable
+	}
+	if w.state == OpRunning {
+		w.state = OpPaused
+		w.resumeCh = make(chan struct{})
+	}
+	return nil
+}
+
+// SimpleFSResumeOp resumes the paused operation `opid`.  An operation
+// interrupted by a restart is started over; copies, moves and
+// removes are all safe to repeat.
+func (k *SimpleFS) SimpleFSResumeOp(
+	ctx context.Context, opid keybase1.OpID) (err error) {
+	ctx, err = k.startSyncOp(ctx, "ResumeOp", opid)
+	if err != nil {
+		return err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	k.lock.Lock()
+	w, ok := k.inProgress[opid]
+	if !ok {
+		k.lock.Unlock()
+		return errNoSuchHandle
+	}
+	switch w.state {
+	case OpPaused:
+		w.state = OpRunning
+		close(w.resumeCh)
+		w.resumeCh = nil
+		k.lock.Unlock()
+		return nil
+	case OpInterrupted:
+		op := *w.resumable
+		k.lock.Unlock()
+		return k.startResumable(ctx, op)
+	default:
+		k.lock.Unlock()
+		return nil
+	}
+}
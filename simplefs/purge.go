@@ -0,0 +1,25 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// SimpleFSPurgeCachesPendingRekey deletes this device's cached data
+// for every loaded folder that is still keyed for a revoked device,
+// and requests a rekey of each.  The UI should offer this after the
+// user revokes one of their other devices.
+func (k *SimpleFS) SimpleFSPurgeCachesPendingRekey(ctx context.Context) (
+	purged []libkbfs.PurgedFolder, err error) {
+	ctx, err = k.startSyncOp(ctx, "PurgeCachesPendingRekey", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	return k.config.KBFSOps().PurgeCachesPendingRekey(ctx)
+}
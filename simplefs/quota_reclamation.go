@@ -0,0 +1,67 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+var errQuotaReclamationNoFolder = simpleFSError{
+	"The folder has no data to reclaim yet"}
+
+// SimpleFSQuotaReclamationStatus returns how much of the quota usage
+// of the folder containing `path` is held only by old revisions, and
+// when it can be reclaimed.
+func (k *SimpleFS) SimpleFSQuotaReclamationStatus(
+	ctx context.Context, path keybase1.Path) (
+	status libkbfs.QuotaReclamationStatus, err error) {
+	ctx, err = k.startSyncOp(ctx, "QuotaReclamationStatus", path)
+	if err != nil {
+		return libkbfs.QuotaReclamationStatus{}, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	fb, _, err := k.getFolderBranchFromPath(ctx, path)
+	if err != nil {
+		return libkbfs.QuotaReclamationStatus{}, err
+	}
+	if fb == (libkbfs.FolderBranch{}) {
+		return libkbfs.QuotaReclamationStatus{}, errQuotaReclamationNoFolder
+	}
+	return k.config.KBFSOps().GetQuotaReclamationStatus(ctx, fb)
+}
+
+// SimpleFSForceQuotaReclamation reclaims the quota held only by old
+// enough revisions of the folder containing `path` right away, so
+// users near their quota don't have to wait for the next automatic
+// reclamation.  It returns the status after reclaiming.
+func (k *SimpleFS) SimpleFSForceQuotaReclamation(
+	ctx context.Context, path keybase1.Path) (
+	status libkbfs.QuotaReclamationStatus, err error) {
+	ctx, err = k.startSyncOp(ctx, "ForceQuotaReclamation", path)
+	if err != nil {
+		return libkbfs.QuotaReclamationStatus{}, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	fb, _, err := k.getFolderBranchFromPath(ctx, path)
+	if err != nil {
+		return libkbfs.QuotaReclamationStatus{}, err
+	}
+	if fb == (libkbfs.FolderBranch{}) {
+		return libkbfs.QuotaReclamationStatus{}, errQuotaReclamationNoFolder
+	}
+	kbfsOps := k.config.KBFSOps()
+	if err := kbfsOps.ForceQuotaReclamation(ctx, fb); err != nil {
+		return libkbfs.QuotaReclamationStatus{}, err
+	}
+	// Pick up the gc op written by the reclamation.
+	if err := kbfsOps.SyncFromServer(ctx, fb, nil); err != nil {
+		return libkbfs.QuotaReclamationStatus{}, err
+	}
+	return kbfsOps.GetQuotaReclamationStatus(ctx, fb)
+}
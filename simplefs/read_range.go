@@ -0,0 +1,168 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"github.com/keybase/client/go/protocol/keybase1"
+	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
+	billy "gopkg.in/src-d/go-billy.v4"
+)
+
+// maxConcurrentRangeReads bounds how many ranges of one call are
+// read at once.
+const maxConcurrentRangeReads = 8
+
+// maxRangeReadSize bounds the size of a single range.
+const maxRangeReadSize = 64 << 20
+
+// maxReadRanges bounds how many ranges one call can read.
+const maxReadRanges = 1024
+
+// maxReadRangesTotalSize bounds the total size of the ranges one
+// call reads, after clipping them to the end of the file.  All the
+// results are held in memory until the reply is sent, so together
+// with the limits above this keeps one request from making the
+// service allocate an arbitrary amount of memory.
+const maxReadRangesTotalSize = 64 << 20
+
+var errBadReadRange = simpleFSError{"Invalid read range"}
+
+var errReadRangesTooLarge = simpleFSError{
+	"Too many bytes or ranges requested in one read"}
+
+// ReadRange is a byte range of a file to read.
+type ReadRange struct {
+	Offset int64
+	Size   int
+}
+
+// readRanges reads `ranges` of `f`, which is `fileSize` bytes long,
+// concurrently.  Ranges are clipped to the end of the file, so a
+// range past the end returns no data rather than an error.
+func readRanges(ctx context.Context, f billy.File, fileSize int64,
+	ranges []ReadRange) ([]keybase1.FileContent, error) {
+	if len(ranges) > maxReadRanges {
+		return nil, errReadRangesTooLarge
+	}
+	var total int64
+	for _, r := range ranges {
+		if r.Offset < 0 || r.Size < 0 || r.Size > maxRangeReadSize {
+			return nil, errBadReadRange
+		}
+		end := r.Offset + int64(r.Size)
+		if end > fileSize {
+			end = fileSize
+		}
+		if end > r.Offset {
+			total += end - r.Offset
+		}
+	}
+	if total > maxReadRangesTotalSize {
+		return nil, errReadRangesTooLarge
+	}
+
+	results := make([]keybase1.FileContent, len(ranges))
+	sem := make(chan struct{}, maxConcurrentRangeReads)
+	eg, ctx := errgroup.WithContext(ctx)
+	for i, r := range ranges {
+		i, r := i, r
+		end := r.Offset + int64(r.Size)
+		if end > fileSize {
+			end = fileSize
+		}
+		if end <= r.Offset {
+			results[i] = keybase1.FileContent{Data: []byte{}}
+			continue
+		}
+		eg.Go(func() error {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			defer func() { <-sem }()
+			buf := make([]byte, end-r.Offset)
+			n, err := f.ReadAt(buf, r.Offset)
+			if err != nil {
+				return err
+			}
+			results[i] = keybase1.FileContent{Data: buf[:n]}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// SimpleFSReadRanges reads the byte ranges `ranges` of the file at
+// `path`, without needing a handle, and returns their contents in
+// the same order.  Up to maxConcurrentRangeReads ranges are read
+// at once.
+func (k *SimpleFS) SimpleFSReadRanges(
+	ctx context.Context, path keybase1.Path, ranges []ReadRange) (
+	contents []keybase1.FileContent, err error) {
+	ctx, err = k.startSyncOp(ctx, "ReadRanges", path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	fs, finalElem, err := k.getFS(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := fs.Stat(finalElem)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, simpleFSError{"Can't read a directory"}
+	}
+	f, err := fs.Open(finalElem)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readRanges(ctx, f, fi.Size(), ranges)
+}
+
+// SimpleFSReadAt reads `size` bytes at `offset` of the file opened
+// as `opid`.  Unlike SimpleFSRead, it doesn't use or move the
+// handle's offset, so many reads of one handle can be outstanding at
+// once.  Reads past the end of the file return the data up to the
+// end.
+func (k *SimpleFS) SimpleFSReadAt(
+	ctx context.Context, opid keybase1.OpID, offset int64, size int) (
+	content keybase1.FileContent, err error) {
+	ctx, err = k.startSyncOp(ctx, "ReadAt", opid)
+	if err != nil {
+		return keybase1.FileContent{}, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	k.lock.RLock()
+	h, ok := k.handles[opid]
+	k.lock.RUnlock()
+	if !ok || h.file == nil {
+		return keybase1.FileContent{}, errNoSuchHandle
+	}
+	fs, finalElem, err := k.getFS(ctx, h.path)
+	if err != nil {
+		return keybase1.FileContent{}, err
+	}
+	fi, err := fs.Stat(finalElem)
+	if err != nil {
+		return keybase1.FileContent{}, err
+	}
+	contents, err := readRanges(
+		ctx, h.file, fi.Size(), []ReadRange{{offset, size}})
+	if err != nil {
+		return keybase1.FileContent{}, err
+	}
+	return contents[0], nil
+}
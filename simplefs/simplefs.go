@@ -10,6 +10,7 @@ import (
 	"io"
 	"os"
 	stdpath "path"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -19,6 +20,7 @@ import (
 	"github.com/keybase/client/go/logger"
 	"github.com/keybase/client/go/protocol/keybase1"
 	"github.com/keybase/kbfs/env"
+	"github.com/keybase/kbfs/fsrpc"
 	"github.com/keybase/kbfs/kbfscrypto"
 	"github.com/keybase/kbfs/kbfsmd"
 	"github.com/keybase/kbfs/libfs"
@@ -28,6 +30,7 @@ import (
 	"golang.org/x/sync/errgroup"
 	"gopkg.in/src-d/go-billy.v4"
 	"gopkg.in/src-d/go-billy.v4/osfs"
+	billyutil "gopkg.in/src-d/go-billy.v4/util"
 )
 
 const (
@@ -43,6 +46,10 @@ const (
 	ctxIDKey ctxTagKey = iota
 )
 
+// tlfIDDirName is the top-level directory of remote paths that name
+// their TLF by ID, like /tlfid/<id>/x or /tlfid/<id>@rev=42/x.
+const tlfIDDirName = "tlfid"
+
 // simpleFSError wraps errors for SimpleFS
 type simpleFSError struct {
 	reason string
@@ -63,6 +70,7 @@ func (e simpleFSError) ToStatus() keybase1.Status {
 var errOnlyRemotePathSupported = simpleFSError{"Only remote paths are supported for this operation"}
 var errInvalidRemotePath = simpleFSError{"Invalid remote path"}
 var errNoSuchHandle = simpleFSError{"No such handle"}
+var errOnlyPublicFolders = simpleFSError{"Only public folders are available in public read-only mode"}
 var errNoResult = simpleFSError{"Async result not found"}
 
 type newFSFunc func(
@@ -97,6 +105,10 @@ type SimpleFS struct {
 	// inProgress is for keeping state of operations in progress,
 	// values are removed by SimpleFSWait (or SimpleFSCancel).
 	inProgress map[keybase1.OpID]*inprogress
+	// opsFile is where resumable operations are saved, so they
+	// survive a restart, or empty if they aren't saved.  Constant
+	// after construction.
+	opsFile string
 
 	subscribeLock     sync.RWMutex
 	subscribeCurrPath string
@@ -110,6 +122,15 @@ type inprogress struct {
 	cancel   context.CancelFunc
 	done     chan error
 	progress keybase1.OpProgress
+	started  time.Time
+	state    OpState
+	err      error
+	// resumeCh is non-nil while the op is paused, and is closed
+	// when it's resumed.
+	resumeCh chan struct{}
+	// resumable is non-nil for ops that can be paused and resumed,
+	// until they finish.
+	resumable *resumableOp
 }
 
 type handle struct {
@@ -117,6 +138,8 @@ type handle struct {
 	async  interface{}
 	path   keybase1.Path
 	cancel context.CancelFunc
+	// stream is non-nil once the handle is in streaming write mode.
+	stream *writeStream
 }
 
 // make sure the interface is implemented
@@ -128,7 +151,7 @@ func newSimpleFS(appStateUpdater env.AppStateUpdater, config libkbfs.Config) *Si
 	if err != nil {
 		log.Fatalf("initializing localHTTPServer error: %v", err)
 	}
-	return &SimpleFS{
+	k := &SimpleFS{
 		config:          config,
 		handles:         map[keybase1.OpID]*handle{},
 		inProgress:      map[keybase1.OpID]*inprogress{},
@@ -137,6 +160,11 @@ func newSimpleFS(appStateUpdater env.AppStateUpdater, config libkbfs.Config) *Si
 		idd:             libkbfs.NewImpatientDebugDumperForForcedDumps(config),
 		localHTTPServer: localHTTPServer,
 	}
+	if root := config.StorageRoot(); root != "" {
+		k.opsFile = filepath.Join(root, simpleFSOpsFileName)
+		k.loadResumableOps()
+	}
+	return k
 }
 
 // NewSimpleFS creates a new SimpleFS instance.
@@ -145,6 +173,16 @@ func NewSimpleFS(appStateUpdater env.AppStateUpdater, config libkbfs.Config) key
 }
 
 func (k *SimpleFS) makeContext(ctx context.Context) context.Context {
+	mode, err := identifyModeFromContext(ctx)
+	if err != nil {
+		// Fall back to the default, which still identifies.
+		k.log.CWarningf(ctx, "Ignoring the requested identify mode: %+v", err)
+	} else if mode != libkbfs.IdentifyModeDefault {
+		ctx = libkbfs.NewContextReplayable(
+			ctx, func(c context.Context) context.Context {
+				return libkbfs.WithIdentifyMode(c, mode)
+			})
+	}
 	return libkbfs.CtxWithRandomIDReplayable(ctx, ctxIDKey, ctxOpID, k.log)
 }
 
@@ -156,15 +194,53 @@ func rawPathFromKbfsPath(path keybase1.Path) (string, error) {
 
 	switch pt {
 	case keybase1.PathType_KBFS:
-		return stdpath.Clean(path.Kbfs()), nil
+		return cleanKbfsPath(path.Kbfs()), nil
 	case keybase1.PathType_KBFS_ARCHIVED:
-		return stdpath.Clean(path.KbfsArchived().Path), nil
+		return cleanKbfsPath(path.KbfsArchived().Path), nil
 	default:
 		return "", errOnlyRemotePathSupported
 	}
 }
 
-// remoteTlfAndPath decodes a remote path for us.
+// cleanKbfsPath cleans a raw KBFS path, turning a TLF ID URL (see
+// fsrpc.TLFIDURLPrefix) into a path under /tlfid.
+func cleanKbfsPath(raw string) string {
+	if strings.HasPrefix(raw, fsrpc.TLFIDURLPrefix) {
+		raw = stdpath.Join(
+			"/", tlfIDDirName, strings.TrimPrefix(raw, fsrpc.TLFIDURLPrefix))
+	}
+	return stdpath.Clean(raw)
+}
+
+// tlfIDFromPath returns the TLF ID, and the revision if any, of a
+// remote path that names its TLF by ID, like
+// /tlfid/<id>@rev=42/x.  `ok` is false for paths that name their TLF
+// by name.
+func tlfIDFromPath(path keybase1.Path) (
+	id tlf.ID, rev kbfsmd.Revision, ok bool, err error) {
+	raw, err := rawPathFromKbfsPath(path)
+	if err != nil {
+		return tlf.NullID, kbfsmd.RevisionUninitialized, false, err
+	}
+	ps := strings.Split(strings.TrimPrefix(raw, "/"), `/`)
+	if ps[0] != tlfIDDirName {
+		return tlf.NullID, kbfsmd.RevisionUninitialized, false, nil
+	}
+	if len(ps) < 2 {
+		return tlf.NullID, kbfsmd.RevisionUninitialized, false,
+			errInvalidRemotePath
+	}
+	id, rev, err = fsrpc.ParseTLFIDElem(ps[1])
+	if err != nil {
+		return tlf.NullID, kbfsmd.RevisionUninitialized, false,
+			errInvalidRemotePath
+	}
+	return id, rev, true, nil
+}
+
+// remoteTlfAndPath decodes a remote path for us.  For paths that name
+// their TLF by ID, `tlfName` is the ID element; use getTlfHandle to
+// look up the TLF of any remote path.
 func remoteTlfAndPath(path keybase1.Path) (
 	t tlf.Type, tlfName, middlePath, finalElem string, err error) {
 	raw, err := rawPathFromKbfsPath(path)
@@ -184,6 +260,12 @@ func remoteTlfAndPath(path keybase1.Path) (
 		t = tlf.Public
 	case ps[0] == `team`:
 		t = tlf.SingleTeam
+	case ps[0] == tlfIDDirName:
+		id, _, _, err := tlfIDFromPath(path)
+		if err != nil {
+			return tlf.Private, "", "", "", err
+		}
+		t = id.Type()
 	default:
 		return tlf.Private, "", "", "", errInvalidRemotePath
 	}
@@ -194,6 +276,28 @@ func remoteTlfAndPath(path keybase1.Path) (
 	return t, ps[1], middlePath, finalElem, nil
 }
 
+// getTlfHandle returns the handle of the TLF that the remote `path`
+// is in, whether it names the TLF by name or by ID.
+func (k *SimpleFS) getTlfHandle(
+	ctx context.Context, path keybase1.Path) (*libkbfs.TlfHandle, error) {
+	t, tlfName, _, _, err := remoteTlfAndPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if k.config.PublicReadOnly() && t != tlf.Public {
+		return nil, errOnlyPublicFolders
+	}
+	id, _, ok, err := tlfIDFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return libkbfs.GetHandleFromTlfID(ctx, k.config.MDOps(), id)
+	}
+	return libkbfs.GetHandleFromFolderNameAndType(
+		ctx, k.config.KBPKI(), k.config.MDOps(), tlfName, t)
+}
+
 func (k *SimpleFS) branchNameFromPath(
 	ctx context.Context, tlfHandle *libkbfs.TlfHandle, path keybase1.Path) (
 	libkbfs.BranchName, error) {
@@ -201,10 +305,21 @@ func (k *SimpleFS) branchNameFromPath(
 	if err != nil {
 		return "", err
 	}
+	_, rev, _, err := tlfIDFromPath(path)
+	if err != nil {
+		return "", err
+	}
 	switch pt {
 	case keybase1.PathType_KBFS:
+		if rev != kbfsmd.RevisionUninitialized {
+			return libkbfs.MakeRevBranchName(rev), nil
+		}
 		return libkbfs.MasterBranch, nil
 	case keybase1.PathType_KBFS_ARCHIVED:
+		if rev != kbfsmd.RevisionUninitialized {
+			return "", simpleFSError{
+				"An archived path can't also name a revision"}
+		}
 		archivedParam := path.KbfsArchived().ArchivedParam
 		archivedType, err := archivedParam.KBFSArchivedType()
 		if err != nil {
@@ -252,12 +367,11 @@ func (k *SimpleFS) getFS(ctx context.Context, path keybase1.Path) (
 	}
 	switch pt {
 	case keybase1.PathType_KBFS, keybase1.PathType_KBFS_ARCHIVED:
-		t, tlfName, restOfPath, finalElem, err := remoteTlfAndPath(path)
+		_, _, restOfPath, finalElem, err := remoteTlfAndPath(path)
 		if err != nil {
 			return nil, "", err
 		}
-		tlfHandle, err := libkbfs.GetHandleFromFolderNameAndType(
-			ctx, k.config.KBPKI(), k.config.MDOps(), tlfName, t)
+		tlfHandle, err := k.getTlfHandle(ctx, path)
 		if err != nil {
 			return nil, "", err
 		}
@@ -370,16 +484,22 @@ func (k *SimpleFS) setResult(opid keybase1.OpID, val interface{}) {
 }
 
 func (k *SimpleFS) startOp(ctx context.Context, opid keybase1.OpID,
-	opType keybase1.AsyncOps, desc keybase1.OpDescription) (
-	context.Context, error) {
+	opType keybase1.AsyncOps, desc keybase1.OpDescription,
+	resumable *resumableOp) (context.Context, error) {
 	ctx = k.makeContext(ctx)
 	ctx, cancel := context.WithCancel(ctx)
 	k.lock.Lock()
 	k.inProgress[opid] = &inprogress{
-		desc,
-		cancel,
-		make(chan error, 1),
-		keybase1.OpProgress{OpType: opType},
+		desc:      desc,
+		cancel:    cancel,
+		done:      make(chan error, 1),
+		progress:  keybase1.OpProgress{OpType: opType},
+		started:   k.config.Clock().Now(),
+		state:     OpRunning,
+		resumable: resumable,
+	}
+	if resumable != nil {
+		k.saveResumableOpsLocked()
 	}
 	k.lock.Unlock()
 	// ignore error, this is just for logging.
@@ -393,6 +513,16 @@ func (k *SimpleFS) doneOp(ctx context.Context, opid keybase1.OpID, err error) {
 	w, ok := k.inProgress[opid]
 	if ok {
 		w.progress.EndEstimate = keybase1.ToTime(k.config.Clock().Now())
+		w.err = err
+		if err != nil {
+			w.state = OpFailed
+		} else {
+			w.state = OpDone
+		}
+		if w.resumable != nil {
+			w.resumable = nil
+			k.saveResumableOpsLocked()
+		}
 	}
 	k.lock.Unlock()
 	if ok {
@@ -409,7 +539,15 @@ func (k *SimpleFS) startAsync(
 	ctx context.Context, opid keybase1.OpID, opType keybase1.AsyncOps,
 	desc keybase1.OpDescription,
 	callback func(context.Context) error) error {
-	ctxAsync, e0 := k.startOp(context.Background(), opid, opType, desc)
+	return k.startAsyncOp(ctx, opid, opType, desc, nil, callback)
+}
+
+func (k *SimpleFS) startAsyncOp(
+	ctx context.Context, opid keybase1.OpID, opType keybase1.AsyncOps,
+	desc keybase1.OpDescription, resumable *resumableOp,
+	callback func(context.Context) error) error {
+	ctxAsync, e0 := k.startOp(
+		context.Background(), opid, opType, desc, resumable)
 	if e0 != nil {
 		return e0
 	}
@@ -506,12 +644,7 @@ func isFiltered(filter keybase1.ListFilter, name string) bool {
 func (k *SimpleFS) getFolderBranchFromPath(
 	ctx context.Context, path keybase1.Path) (
 	libkbfs.FolderBranch, string, error) {
-	t, tlfName, _, _, err := remoteTlfAndPath(path)
-	if err != nil {
-		return libkbfs.FolderBranch{}, "", err
-	}
-	tlfHandle, err := libkbfs.GetHandleFromFolderNameAndType(
-		ctx, k.config.KBPKI(), k.config.MDOps(), tlfName, t)
+	tlfHandle, err := k.getTlfHandle(ctx, path)
 	if err != nil {
 		return libkbfs.FolderBranch{}, "", err
 	}
@@ -839,11 +972,22 @@ func recursiveByteAndFileCount(fs billy.Filesystem) (
 }
 
 func copyWithCancellation(ctx context.Context, dst io.Writer, src io.Reader) error {
-	for {
+	return copyWithCheck(dst, src, func() error {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
+			return nil
+		}
+	})
+}
+
+// copyWithCheck copies `src` to `dst` in chunks, calling `check`
+// before each one and stopping if it returns an error.
+func copyWithCheck(dst io.Writer, src io.Reader, check func() error) error {
+	for {
+		if err := check(); err != nil {
+			return err
 		}
 		_, err := io.CopyN(dst, src, 64*1024)
 		if err == io.EOF {
@@ -922,10 +1066,10 @@ func (k *SimpleFS) doCopyFromSource(
 	}
 	defer dst.Close()
 
-	return copyWithCancellation(
-		ctx,
+	return copyWithCheck(
 		&progressWriter{k, opID, dst},
 		&progressReader{k, opID, src},
+		func() error { return k.checkOp(ctx, opID) },
 	)
 }
 
@@ -953,22 +1097,22 @@ func (k *SimpleFS) doCopy(
 
 // SimpleFSCopy - Begin copy of file or directory
 func (k *SimpleFS) SimpleFSCopy(ctx context.Context, arg keybase1.SimpleFSCopyArg) error {
-	return k.startAsync(ctx, arg.OpID, keybase1.AsyncOps_COPY,
-		keybase1.NewOpDescriptionWithCopy(
+	return k.startResumable(ctx, resumableOp{
+		OpID: arg.OpID,
+		Desc: keybase1.NewOpDescriptionWithCopy(
 			keybase1.CopyArgs{OpID: arg.OpID, Src: arg.Src, Dest: arg.Dest}),
-		func(ctx context.Context) (err error) {
-			return k.doCopy(ctx, arg.OpID, arg.Src, arg.Dest)
-		})
+	})
 }
 
 // SimpleFSSymlink starts making a symlink of a file or directory
 func (k *SimpleFS) SimpleFSSymlink(ctx context.Context, arg keybase1.SimpleFSSymlinkArg) (err error) {
 	// This is not async.
-	ctx, err = k.startSyncOp(ctx, "Symlink", arg)
+	ctx, cancel, err := k.startTimedSyncOp(ctx, "Symlink", libkbfs.OpClassWrite, arg)
 	if err != nil {
 		return err
 	}
-	defer func() { k.doneSyncOp(ctx, err) }()
+	defer cancel()
+	defer func() { err = k.doneSyncOp(ctx, err) }()
 
 	dstFS, finalDstElem, err := k.getFS(ctx, arg.Link)
 	if err != nil {
@@ -1001,12 +1145,53 @@ func pathAppend(p keybase1.Path, leaf string) keybase1.Path {
 // SimpleFSCopyRecursive - Begin recursive copy of directory
 func (k *SimpleFS) SimpleFSCopyRecursive(ctx context.Context,
 	arg keybase1.SimpleFSCopyRecursiveArg) error {
-	return k.startAsync(ctx, arg.OpID, keybase1.AsyncOps_COPY,
-		keybase1.NewOpDescriptionWithCopy(
+	return k.startResumable(ctx, resumableOp{
+		OpID: arg.OpID,
+		Desc: keybase1.NewOpDescriptionWithCopy(
 			keybase1.CopyArgs{OpID: arg.OpID, Src: arg.Src, Dest: arg.Dest}),
-		func(ctx context.Context) (err error) {
-			// Get the full byte/file count.
-			srcFS, finalSrcElem, err := k.getFS(ctx, arg.Src)
+		Recursive: true,
+	})
+}
+
+func (k *SimpleFS) doCopyRecursive(ctx context.Context,
+	opID keybase1.OpID, srcPath, destPath keybase1.Path) (err error) {
+	// Get the full byte/file count.
+	srcFS, finalSrcElem, err := k.getFS(ctx, srcPath)
+	if err != nil {
+		return err
+	}
+	srcFI, err := srcFS.Stat(finalSrcElem)
+	if err != nil {
+		return err
+	}
+	if srcFI.IsDir() {
+		chrootFS, err := srcFS.Chroot(srcFI.Name())
+		if err != nil {
+			return err
+		}
+		bytes, files, err := recursiveByteAndFileCount(chrootFS)
+		if err != nil {
+			return err
+		}
+		// Add one to files to account for the src dir itself.
+		k.setProgressTotals(opID, bytes, files+1)
+	} else {
+		// No need for recursive.
+		return k.doCopy(ctx, opID, srcPath, destPath)
+	}
+
+	var paths = []pathPair{{src: srcPath, dest: destPath}}
+	for len(paths) > 0 {
+		if err := k.checkOp(ctx, opID); err != nil {
+			return err
+		}
+
+		// wrap in a function for defers.
+		err = func() error {
+			path := paths[len(paths)-1]
+			paths = paths[:len(paths)-1]
+
+			srcFS, finalSrcElem, err := k.getFS(ctx, path.src)
 			if err != nil {
 				return err
 			}
@@ -1014,68 +1199,30 @@ func (k *SimpleFS) SimpleFSCopyRecursive(ctx context.Context,
 			if err != nil {
 				return err
 			}
+			err = k.doCopyFromSource(
+				ctx, opID, srcFS, srcFI, path.dest)
+			if err != nil {
+				return err
+			}
+
+			// TODO symlinks
 			if srcFI.IsDir() {
-				chrootFS, err := srcFS.Chroot(srcFI.Name())
+				fis, err := srcFS.ReadDir(srcFI.Name())
 				if err != nil {
 					return err
 				}
-				bytes, files, err := recursiveByteAndFileCount(chrootFS)
-				if err != nil {
-					return err
-				}
-				// Add one to files to account for the src dir itself.
-				k.setProgressTotals(arg.OpID, bytes, files+1)
-			} else {
-				// No need for recursive.
-				return k.doCopy(ctx, arg.OpID, arg.Src, arg.Dest)
-			}
-
-			var paths = []pathPair{{src: arg.Src, dest: arg.Dest}}
-			for len(paths) > 0 {
-				select {
-				case <-ctx.Done():
-					return ctx.Err()
-				default:
+				for _, fi := range fis {
+					paths = append(paths, pathPair{
+						src:  pathAppend(path.src, fi.Name()),
+						dest: pathAppend(path.dest, fi.Name()),
+					})
 				}
-
-				// wrap in a function for defers.
-				err = func() error {
-					path := paths[len(paths)-1]
-					paths = paths[:len(paths)-1]
-
-					srcFS, finalSrcElem, err := k.getFS(ctx, path.src)
-					if err != nil {
-						return err
-					}
-					srcFI, err := srcFS.Stat(finalSrcElem)
-					if err != nil {
-						return err
-					}
-					err = k.doCopyFromSource(
-						ctx, arg.OpID, srcFS, srcFI, path.dest)
-					if err != nil {
-						return err
-					}
-
-					// TODO symlinks
-					if srcFI.IsDir() {
-						fis, err := srcFS.ReadDir(srcFI.Name())
-						if err != nil {
-							return err
-						}
-						for _, fi := range fis {
-							paths = append(paths, pathPair{
-								src:  pathAppend(path.src, fi.Name()),
-								dest: pathAppend(path.dest, fi.Name()),
-							})
-						}
-					}
-					return nil
-				}()
 			}
+			return nil
+		}()
+	}
 
-			return err
-		})
+	return err
 }
 
 func (k *SimpleFS) doRemove(ctx context.Context, path keybase1.Path) error {
@@ -1086,23 +1233,53 @@ func (k *SimpleFS) doRemove(ctx context.Context, path keybase1.Path) error {
 	return fs.Remove(finalElem)
 }
 
+// doRemoveRecursive removes `path` along with everything under it.
+// Trees in KBFS are removed in a few large batches, with the file
+// progress of `opID` updated after each one.
+func (k *SimpleFS) doRemoveRecursive(ctx context.Context,
+	opID keybase1.OpID, path keybase1.Path) error {
+	fs, finalElem, err := k.getFS(ctx, path)
+	if err != nil {
+		return err
+	}
+	kbfsFS, ok := fs.(*libfs.FS)
+	if !ok {
+		return billyutil.RemoveAll(fs, finalElem)
+	}
+	var removed int
+	return kbfsFS.RemoveTree(finalElem, func(p libkbfs.RemoveTreeProgress) {
+		if p.EntriesRemoved == 0 {
+			k.setProgressTotals(opID, 0, int64(p.EntriesTotal))
+		}
+		k.updateWriteProgress(opID, 0, int64(p.EntriesRemoved-removed))
+		removed = p.EntriesRemoved
+	})
+}
+
 // SimpleFSMove - Begin move of file or directory, from/to KBFS only
 func (k *SimpleFS) SimpleFSMove(ctx context.Context, arg keybase1.SimpleFSMoveArg) error {
-	return k.startAsync(ctx, arg.OpID, keybase1.AsyncOps_MOVE,
-		keybase1.NewOpDescriptionWithMove(
+	return k.startResumable(ctx, resumableOp{
+		OpID: arg.OpID,
+		Desc: keybase1.NewOpDescriptionWithMove(
 			keybase1.MoveArgs{
 				OpID: arg.OpID, Src: arg.Src, Dest: arg.Dest,
 			}),
-		func(ctx context.Context) (err error) {
-			// TODO: Make this a proper rename within a single TLF.
-			// (See `SimpleFSRename` below.)  Also even copy+deletes
-			// should be resursive I think.
-			err = k.doCopy(ctx, arg.OpID, arg.Src, arg.Dest)
-			if err != nil {
-				return err
-			}
-			return k.doRemove(ctx, arg.Src)
-		})
+	})
+}
+
+func (k *SimpleFS) doMove(ctx context.Context, opID keybase1.OpID,
+	srcPath, destPath keybase1.Path) error {
+	// TODO: Make this a proper rename within a single TLF.
+	// (See `SimpleFSRename` below.)  Also even copy+deletes
+	// should be resursive I think.
+	err := k.doCopy(ctx, opID, srcPath, destPath)
+	if err != nil {
+		return err
+	}
+	if err := k.checkOp(ctx, opID); err != nil {
+		return err
+	}
+	return k.doRemove(ctx, srcPath)
 }
 
 func (k *SimpleFS) startSyncOp(ctx context.Context, name string, logarg interface{}) (context.Context, error) {
@@ -1110,6 +1287,20 @@ func (k *SimpleFS) startSyncOp(ctx context.Context, name string, logarg interfac
 	k.log.CDebugf(ctx, "start sync %s %v", name, logarg)
 	return k.startOpWrapContext(ctx)
 }
+
+// startTimedSyncOp is like startSyncOp, but the operation may only
+// take as long as the configured timeout for `class` allows.
+func (k *SimpleFS) startTimedSyncOp(ctx context.Context, name string,
+	class libkbfs.OpClass, logarg interface{}) (
+	context.Context, context.CancelFunc, error) {
+	ctx, cancel := k.config.OpTimeouts().WithTimeout(ctx, class)
+	ctx, err := k.startSyncOp(ctx, name, logarg)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return ctx, cancel, nil
+}
 func (k *SimpleFS) startOpWrapContext(outer context.Context) (context.Context, error) {
 	return libkbfs.NewContextWithCancellationDelayer(libkbfs.NewContextReplayable(
 		outer, func(c context.Context) context.Context {
@@ -1117,46 +1308,63 @@ func (k *SimpleFS) startOpWrapContext(outer context.Context) (context.Context, e
 		}))
 }
 
-func (k *SimpleFS) doneSyncOp(ctx context.Context, err error) {
+// doneSyncOp finishes an operation started by startSyncOp, and
+// returns `err`, turned into a more specific error if the operation
+// ran out of time.
+func (k *SimpleFS) doneSyncOp(ctx context.Context, err error) error {
 	k.log.CDebugf(ctx, "done sync op, status=%v", err)
 	if ctx != nil {
+		err = libkbfs.ClassifyOpTimeout(ctx, k.config, err)
 		libkbfs.CleanupCancellationDelayer(ctx)
 	}
+	return err
 }
 
 // SimpleFSRename - Rename file or directory, KBFS side only
 func (k *SimpleFS) SimpleFSRename(ctx context.Context, arg keybase1.SimpleFSRenameArg) (err error) {
 	// This is not async.
-	ctx, err = k.startSyncOp(ctx, "Rename", arg)
+	ctx, cancel, err := k.startTimedSyncOp(ctx, "Rename", libkbfs.OpClassWrite, arg)
 	if err != nil {
 		return err
 	}
-	defer func() { k.doneSyncOp(ctx, err) }()
+	defer cancel()
+	defer func() { err = k.doneSyncOp(ctx, err) }()
 
 	// Get root FS, to be shared by both src and dst.
-	t, tlfName, restOfSrcPath, finalSrcElem, err := remoteTlfAndPath(arg.Src)
+	_, _, restOfSrcPath, finalSrcElem, err := remoteTlfAndPath(arg.Src)
 	if err != nil {
 		return err
 	}
-	tlfHandle, err := libkbfs.GetHandleFromFolderNameAndType(
-		ctx, k.config.KBPKI(), k.config.MDOps(), tlfName, t)
+	tlfHandle, err := k.getTlfHandle(ctx, arg.Src)
+	if err != nil {
+		return err
+	}
+	branch, err := k.branchNameFromPath(ctx, tlfHandle, arg.Src)
 	if err != nil {
 		return err
 	}
 	fs, err := libfs.NewFS(
-		ctx, k.config, tlfHandle, libkbfs.MasterBranch, "", "",
+		ctx, k.config, tlfHandle, branch, "", "",
 		keybase1.MDPriorityNormal)
 	if err != nil {
 		return err
 	}
 
 	// Make sure src and dst share the same TLF.
-	tDst, tlfNameDst, restOfDstPath, finalDstElem, err :=
-		remoteTlfAndPath(arg.Dest)
+	_, _, restOfDstPath, finalDstElem, err := remoteTlfAndPath(arg.Dest)
+	if err != nil {
+		return err
+	}
+	dstHandle, err := k.getTlfHandle(ctx, arg.Dest)
 	if err != nil {
 		return err
 	}
-	if tDst != t || tlfName != tlfNameDst {
+	dstBranch, err := k.branchNameFromPath(ctx, dstHandle, arg.Dest)
+	if err != nil {
+		return err
+	}
+	if dstHandle.GetCanonicalPath() != tlfHandle.GetCanonicalPath() ||
+		dstBranch != branch {
 		return simpleFSError{"Cannot rename across top-level folders"}
 	}
 
@@ -1170,11 +1378,16 @@ func (k *SimpleFS) SimpleFSRename(ctx context.Context, arg keybase1.SimpleFSRena
 // or create a directory
 // Files must be closed afterwards.
 func (k *SimpleFS) SimpleFSOpen(ctx context.Context, arg keybase1.SimpleFSOpenArg) (err error) {
-	ctx, err = k.startSyncOp(ctx, "Open", arg)
+	class := libkbfs.OpClassRead
+	if arg.Flags&keybase1.OpenFlags_WRITE != 0 {
+		class = libkbfs.OpClassWrite
+	}
+	ctx, cancelTimeout, err := k.startTimedSyncOp(ctx, "Open", class, arg)
 	if err != nil {
 		return err
 	}
-	defer func() { k.doneSyncOp(ctx, err) }()
+	defer cancelTimeout()
+	defer func() { err = k.doneSyncOp(ctx, err) }()
 
 	fs, finalElem, err := k.getFS(ctx, arg.Dest)
 	if err != nil {
@@ -1202,7 +1415,8 @@ func (k *SimpleFS) SimpleFSOpen(ctx context.Context, arg keybase1.SimpleFSOpenAr
 	var cancel context.CancelFunc = func() {}
 	if libfs, ok := fs.(*libfs.FS); ok {
 		var fsCtx context.Context
-		fsCtx, cancel = context.WithCancel(k.makeContext(context.Background()))
+		fsCtx, cancel = context.WithCancel(k.makeContext(
+			copyIdentifyMode(context.Background(), ctx)))
 		fsCtx, err := k.startOpWrapContext(fsCtx)
 		if err != nil {
 			return err
@@ -1227,11 +1441,12 @@ func (k *SimpleFS) SimpleFSOpen(ctx context.Context, arg keybase1.SimpleFSOpenAr
 
 // SimpleFSSetStat - Set/clear file bits - only executable for now
 func (k *SimpleFS) SimpleFSSetStat(ctx context.Context, arg keybase1.SimpleFSSetStatArg) (err error) {
-	ctx, err = k.startSyncOp(ctx, "SetStat", arg)
+	ctx, cancel, err := k.startTimedSyncOp(ctx, "SetStat", libkbfs.OpClassWrite, arg)
 	if err != nil {
 		return err
 	}
-	defer func() { k.doneSyncOp(ctx, err) }()
+	defer cancel()
+	defer func() { err = k.doneSyncOp(ctx, err) }()
 
 	fs, finalElem, err := k.getFS(ctx, arg.Dest)
 	if err != nil {
@@ -1263,23 +1478,32 @@ func (k *SimpleFS) SimpleFSSetStat(ctx context.Context, arg keybase1.SimpleFSSet
 
 func (k *SimpleFS) startReadWriteOp(
 	ctx context.Context, opid keybase1.OpID, opType keybase1.AsyncOps,
-	desc keybase1.OpDescription) (context.Context, error) {
-	ctx, err := k.startSyncOp(ctx, desc.AsyncOp__.String(), desc)
+	desc keybase1.OpDescription) (
+	context.Context, context.CancelFunc, error) {
+	class := libkbfs.OpClassWrite
+	if opType == keybase1.AsyncOps_READ {
+		class = libkbfs.OpClassRead
+	}
+	ctx, cancel, err := k.startTimedSyncOp(
+		ctx, desc.AsyncOp__.String(), class, desc)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	k.lock.Lock()
 	k.inProgress[opid] = &inprogress{
-		desc,
-		func() {},
-		make(chan error, 1),
-		keybase1.OpProgress{OpType: opType},
+		desc:     desc,
+		cancel:   func() {},
+		done:     make(chan error, 1),
+		progress: keybase1.OpProgress{OpType: opType},
+		started:  k.config.Clock().Now(),
+		state:    OpRunning,
 	}
 	k.lock.Unlock()
-	return ctx, err
+	return ctx, cancel, nil
 }
 
-func (k *SimpleFS) doneReadWriteOp(ctx context.Context, opID keybase1.OpID, err error) {
+func (k *SimpleFS) doneReadWriteOp(
+	ctx context.Context, opID keybase1.OpID, err error) error {
 	k.lock.Lock()
 	// Read/write ops never set the end estimate since the progress is
 	// just deleted immediately.
@@ -1287,8 +1511,10 @@ func (k *SimpleFS) doneReadWriteOp(ctx context.Context, opID keybase1.OpID, err
 	k.lock.Unlock()
 	k.log.CDebugf(ctx, "doneReadWriteOp, status=%v", err)
 	if ctx != nil {
+		err = libkbfs.ClassifyOpTimeout(ctx, k.config, err)
 		libkbfs.CleanupCancellationDelayer(ctx)
 	}
+	return err
 }
 
 // SimpleFSRead - Read (possibly partial) contents of open file,
@@ -1311,10 +1537,12 @@ func (k *SimpleFS) SimpleFSRead(ctx context.Context,
 			Offset: arg.Offset,
 			Size:   arg.Size,
 		})
-	ctx, err = k.startReadWriteOp(ctx, arg.OpID, keybase1.AsyncOps_READ, opDesc)
+	ctx, cancel, err := k.startReadWriteOp(
+		ctx, arg.OpID, keybase1.AsyncOps_READ, opDesc)
 	if err != nil {
 		return keybase1.FileContent{}, err
 	}
+	defer cancel()
 	k.setProgressTotals(arg.OpID, int64(arg.Size), 1)
 	defer func() {
 		if err == nil {
@@ -1322,7 +1550,7 @@ func (k *SimpleFS) SimpleFSRead(ctx context.Context,
 		}
 	}()
 
-	defer func() { k.doneReadWriteOp(ctx, arg.OpID, err) }()
+	defer func() { err = k.doneReadWriteOp(ctx, arg.OpID, err) }()
 
 	// Print this so we can correlate the ID in
 	k.log.CDebugf(ctx, "Starting read for OpID=%X, offset=%d, size=%d",
@@ -1348,7 +1576,8 @@ func (k *SimpleFS) SimpleFSRead(ctx context.Context,
 
 // SimpleFSWrite - Append content to opened file.
 // May be repeated until OpID is closed.
-func (k *SimpleFS) SimpleFSWrite(ctx context.Context, arg keybase1.SimpleFSWriteArg) error {
+func (k *SimpleFS) SimpleFSWrite(
+	ctx context.Context, arg keybase1.SimpleFSWriteArg) (err error) {
 	ctx = k.makeContext(ctx)
 	k.lock.RLock()
 	h, ok := k.handles[arg.OpID]
@@ -1362,12 +1591,13 @@ func (k *SimpleFS) SimpleFSWrite(ctx context.Context, arg keybase1.SimpleFSWrite
 			OpID: arg.OpID, Path: h.path, Offset: arg.Offset,
 		})
 
-	ctx, err := k.startReadWriteOp(
+	ctx, cancel, err := k.startReadWriteOp(
 		ctx, arg.OpID, keybase1.AsyncOps_WRITE, opDesc)
 	if err != nil {
 		return err
 	}
-	defer func() { k.doneReadWriteOp(ctx, arg.OpID, err) }()
+	defer cancel()
+	defer func() { err = k.doneReadWriteOp(ctx, arg.OpID, err) }()
 
 	k.setProgressTotals(arg.OpID, int64(len(arg.Content)), 1)
 	defer func() {
@@ -1392,14 +1622,27 @@ func (k *SimpleFS) SimpleFSWrite(ctx context.Context, arg keybase1.SimpleFSWrite
 // SimpleFSRemove - Remove file or directory from filesystem
 func (k *SimpleFS) SimpleFSRemove(ctx context.Context,
 	arg keybase1.SimpleFSRemoveArg) error {
-	return k.startAsync(ctx, arg.OpID, keybase1.AsyncOps_REMOVE,
-		keybase1.NewOpDescriptionWithRemove(
+	return k.startResumable(ctx, resumableOp{
+		OpID: arg.OpID,
+		Desc: keybase1.NewOpDescriptionWithRemove(
 			keybase1.RemoveArgs{
 				OpID: arg.OpID, Path: arg.Path,
 			}),
-		func(ctx context.Context) (err error) {
-			return k.doRemove(ctx, arg.Path)
-		})
+	})
+}
+
+// SimpleFSRemoveRecursive - Remove a file, or a directory and
+// everything under it, from the filesystem
+func (k *SimpleFS) SimpleFSRemoveRecursive(ctx context.Context,
+	arg keybase1.SimpleFSRemoveArg) error {
+	return k.startResumable(ctx, resumableOp{
+		OpID: arg.OpID,
+		Desc: keybase1.NewOpDescriptionWithRemove(
+			keybase1.RemoveArgs{
+				OpID: arg.OpID, Path: arg.Path,
+			}),
+		Recursive: true,
+	})
 }
 
 // SimpleFSStat - Get info about file
@@ -1410,11 +1653,12 @@ func (k *SimpleFS) SimpleFSStat(ctx context.Context, arg keybase1.SimpleFSStatAr
 			return keybase1.Dirent{}, err
 		}
 	}
-	ctx, err = k.startSyncOp(ctx, "Stat", arg.Path)
+	ctx, cancel, err := k.startTimedSyncOp(ctx, "Stat", libkbfs.OpClassRead, arg.Path)
 	if err != nil {
 		return keybase1.Dirent{}, err
 	}
-	defer func() { k.doneSyncOp(ctx, err) }()
+	defer cancel()
+	defer func() { err = k.doneSyncOp(ctx, err) }()
 
 	fs, finalElem, err := k.getFS(ctx, arg.Path)
 	if err != nil {
@@ -1693,15 +1937,21 @@ func (k *SimpleFS) SimpleFSMakeOpid(_ context.Context) (keybase1.OpID, error) {
 
 // SimpleFSClose - Close removes a handle associated with Open / List.
 func (k *SimpleFS) SimpleFSClose(ctx context.Context, opid keybase1.OpID) (err error) {
-	ctx, err = k.startSyncOp(ctx, "Close", opid)
+	ctx, cancel, err := k.startTimedSyncOp(ctx, "Close", libkbfs.OpClassWrite, opid)
 	if err != nil {
 		return err
 	}
-	defer func() { k.doneSyncOp(ctx, err) }()
+	defer cancel()
+	defer func() { err = k.doneSyncOp(ctx, err) }()
 
 	k.lock.Lock()
 	defer k.lock.Unlock()
-	delete(k.inProgress, opid)
+	if w, ok := k.inProgress[opid]; ok {
+		delete(k.inProgress, opid)
+		if w.resumable != nil {
+			k.saveResumableOpsLocked()
+		}
+	}
 	h, ok := k.handles[opid]
 	if !ok {
 		return errNoSuchHandle
@@ -1730,6 +1980,9 @@ func (k *SimpleFS) SimpleFSCancel(_ context.Context, opid keybase1.OpID) error {
 	}
 	delete(k.inProgress, opid)
 	w.cancel()
+	if w.resumable != nil {
+		k.saveResumableOpsLocked()
+	}
 	return nil
 }
 
@@ -1790,11 +2043,15 @@ func (k *SimpleFS) SimpleFSWait(ctx context.Context, opid keybase1.OpID) error {
 	ctx = k.makeContext(ctx)
 	k.lock.RLock()
 	w, ok := k.inProgress[opid]
+	interrupted := ok && w.state == OpInterrupted
 	k.lock.RUnlock()
 	k.log.CDebugf(ctx, "Wait %X -> %v, %v", opid, w, ok)
 	if !ok {
 		return errNoSuchHandle
 	}
+	if interrupted {
+		return errOpInterrupted
+	}
 
 	err, ok := <-w.done
 
@@ -5,6 +5,7 @@
 package simplefs
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io/ioutil"
@@ -17,11 +18,16 @@ import (
 
 	"github.com/keybase/client/go/libkb"
 	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/go-framed-msgpack-rpc/rpc"
 	"github.com/keybase/kbfs/env"
 	"github.com/keybase/kbfs/kbfscrypto"
+	"github.com/keybase/kbfs/kbfsmd"
 	"github.com/keybase/kbfs/libfs"
 	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
 	billy "gopkg.in/src-d/go-billy.v4"
 )
 
@@ -1039,3 +1045,824 @@ func TestGetRevisions(t *testing.T) {
 	checkRevisions(2, newestRev, keybase1.RevisionSpanType_DEFAULT)
 	checkRevisions(2, newestRev, keybase1.RevisionSpanType_LAST_FIVE)
 }
+
+func TestDryRun(t *testing.T) {
+	ctx := context.Background()
+	sfs := newSimpleFS(env.EmptyAppStateUpdater{}, libkbfs.MakeTestConfigOrBust(t, "jdoe"))
+	defer closeSimpleFS(ctx, t, sfs)
+
+	dirPath := keybase1.NewPathWithKbfs(`/private/jdoe/dir`)
+	writeRemoteDir(ctx, t, sfs, dirPath)
+	writeRemoteFile(ctx, t, sfs, pathAppend(dirPath, "a"), []byte("foo"))
+	writeRemoteFile(ctx, t, sfs, pathAppend(dirPath, "b"), []byte("barbaz"))
+	syncFS(ctx, t, sfs, "/private/jdoe")
+
+	t.Log("Recursive remove reports the whole subtree")
+	res, err := sfs.SimpleFSRemoveDryRun(ctx, dirPath, true)
+	require.NoError(t, err)
+	require.Len(t, res.Entries, 3)
+	require.Equal(t, dirPath, res.Entries[0].Path)
+	require.True(t, res.Entries[0].IsDir)
+	require.Equal(t, pathAppend(dirPath, "a"), res.Entries[1].Path)
+	require.Equal(t, int64(3), res.Entries[1].Bytes)
+	require.Equal(t, pathAppend(dirPath, "b"), res.Entries[2].Path)
+	require.Equal(t, int64(9), res.TotalBytes)
+	for _, e := range res.Entries {
+		require.Empty(t, e.Conflict)
+	}
+
+	t.Log("Non-recursive remove of a non-empty dir is flagged")
+	res, err = sfs.SimpleFSRemoveDryRun(ctx, dirPath, false)
+	require.NoError(t, err)
+	require.Len(t, res.Entries, 1)
+	require.Equal(t, "directory is not empty", res.Entries[0].Conflict)
+
+	t.Log("Copying an older local file over a newer remote one")
+	tempdir, err := ioutil.TempDir("", "simpleFstest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	localFile := filepath.Join(tempdir, "a")
+	err = ioutil.WriteFile(localFile, []byte("older"), 0600)
+	require.NoError(t, err)
+	old := time.Now().Add(-time.Hour)
+	err = os.Chtimes(localFile, old, old)
+	require.NoError(t, err)
+	srcPath := keybase1.NewPathWithLocal(filepath.ToSlash(localFile))
+	res, err = sfs.SimpleFSCopyDryRun(
+		ctx, srcPath, pathAppend(dirPath, "a"), false)
+	require.NoError(t, err)
+	require.Len(t, res.Entries, 1)
+	require.True(t, res.Entries[0].Overwrite)
+	require.Equal(t,
+		"destination is newer than source", res.Entries[0].Conflict)
+
+	t.Log("Move reports the destination write and the source removal")
+	res, err = sfs.SimpleFSMoveDryRun(
+		ctx, pathAppend(dirPath, "b"), pathAppend(dirPath, "c"))
+	require.NoError(t, err)
+	require.Len(t, res.Entries, 2)
+	require.Equal(t, pathAppend(dirPath, "c"), res.Entries[0].Path)
+	require.False(t, res.Entries[0].Overwrite)
+	require.Equal(t, pathAppend(dirPath, "b"), res.Entries[1].Path)
+
+	t.Log("Nothing was actually changed")
+	require.Equal(t, "foo",
+		string(readRemoteFile(ctx, t, sfs, pathAppend(dirPath, "a"))))
+	require.Equal(t, "barbaz",
+		string(readRemoteFile(ctx, t, sfs, pathAppend(dirPath, "b"))))
+}
+
+func TestRemoveRecursive(t *testing.T) {
+	ctx := context.Background()
+	sfs := newSimpleFS(env.EmptyAppStateUpdater{}, libkbfs.MakeTestConfigOrBust(t, "jdoe"))
+	defer closeSimpleFS(ctx, t, sfs)
+
+	dirPath := keybase1.NewPathWithKbfs(`/private/jdoe/dir`)
+	writeRemoteDir(ctx, t, sfs, dirPath)
+	writeRemoteDir(ctx, t, sfs, pathAppend(dirPath, "sub"))
+	writeRemoteFile(ctx, t, sfs, pathAppend(dirPath, "a"), []byte("foo"))
+	writeRemoteFile(
+		ctx, t, sfs, pathAppend(dirPath, "sub/b"), []byte("barbaz"))
+	syncFS(ctx, t, sfs, "/private/jdoe")
+
+	opid, err := sfs.SimpleFSMakeOpid(ctx)
+	require.NoError(t, err)
+	err = sfs.SimpleFSRemoveRecursive(ctx, keybase1.SimpleFSRemoveArg{
+		OpID: opid,
+		Path: dirPath,
+	})
+	require.NoError(t, err)
+	err = sfs.SimpleFSWait(ctx, opid)
+	require.NoError(t, err)
+	_, err = sfs.SimpleFSStat(ctx, keybase1.SimpleFSStatArg{Path: dirPath})
+	require.Error(t, err)
+
+	t.Log("Local trees can be removed too")
+	tempdir, err := ioutil.TempDir("", "simpleFstest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	localDir := filepath.Join(tempdir, "dir")
+	err = os.MkdirAll(filepath.Join(localDir, "sub"), 0700)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(
+		filepath.Join(localDir, "sub", "a"), []byte("foo"), 0600)
+	require.NoError(t, err)
+	opid, err = sfs.SimpleFSMakeOpid(ctx)
+	require.NoError(t, err)
+	err = sfs.SimpleFSRemoveRecursive(ctx, keybase1.SimpleFSRemoveArg{
+		OpID: opid,
+		Path: keybase1.NewPathWithLocal(filepath.ToSlash(localDir)),
+	})
+	require.NoError(t, err)
+	err = sfs.SimpleFSWait(ctx, opid)
+	require.NoError(t, err)
+	_, err = os.Stat(localDir)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestCreateTeamFolderFromTemplate(t *testing.T) {
+	ctx := context.Background()
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	sfs := newSimpleFS(env.EmptyAppStateUpdater{}, config)
+	defer closeSimpleFS(ctx, t, sfs)
+
+	teamInfos := libkbfs.AddEmptyTeamsForTestOrBust(t, config, "t1")
+	session, err := config.KBPKI().GetCurrentSession(ctx)
+	require.NoError(t, err)
+	libkbfs.AddTeamWriterForTestOrBust(
+		t, config, teamInfos[0].TID, session.UID)
+
+	templatePath := keybase1.NewPathWithKbfs(`/private/jdoe/template`)
+	writeRemoteDir(ctx, t, sfs, templatePath)
+	writeRemoteFile(
+		ctx, t, sfs, pathAppend(templatePath, "README"), []byte("hi"))
+	docsPath := pathAppend(templatePath, "docs")
+	writeRemoteDir(ctx, t, sfs, docsPath)
+	writeRemoteFile(
+		ctx, t, sfs, pathAppend(docsPath, "policy"), []byte("rules"))
+	syncFS(ctx, t, sfs, "/private/jdoe")
+
+	err = sfs.SimpleFSCreateTeamFolderFromTemplate(ctx, "t1", templatePath)
+	require.NoError(t, err)
+
+	teamPath := keybase1.NewPathWithKbfs(`/team/t1`)
+	require.Equal(t, []byte("hi"), readRemoteFile(
+		ctx, t, sfs, pathAppend(teamPath, "README")))
+	require.Equal(t, []byte("rules"), readRemoteFile(
+		ctx, t, sfs, pathAppend(pathAppend(teamPath, "docs"), "policy")))
+
+	t.Log("The whole template was written in one revision")
+	h, err := libkbfs.GetHandleFromFolderNameAndType(
+		ctx, config.KBPKI(), config.MDOps(), "t1", tlf.SingleTeam)
+	require.NoError(t, err)
+	rootNode, _, err := config.KBFSOps().GetOrCreateRootNode(
+		ctx, h, libkbfs.MasterBranch)
+	require.NoError(t, err)
+	status, _, err := config.KBFSOps().FolderStatus(
+		ctx, rootNode.GetFolderBranch())
+	require.NoError(t, err)
+	require.Equal(t, kbfsmd.Revision(2), status.Revision)
+
+	t.Log("A non-empty team folder can't be populated again")
+	err = sfs.SimpleFSCreateTeamFolderFromTemplate(ctx, "t1", templatePath)
+	require.Equal(t, errTeamFolderNotEmpty, err)
+
+	t.Log("The template must be a directory")
+	err = sfs.SimpleFSCreateTeamFolderFromTemplate(
+		ctx, "t1", pathAppend(templatePath, "README"))
+	require.Equal(t, errTemplateNotDir, err)
+}
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+	sfs := newSimpleFS(env.EmptyAppStateUpdater{}, libkbfs.MakeTestConfigOrBust(t, "jdoe"))
+	defer closeSimpleFS(ctx, t, sfs)
+
+	privPath := keybase1.NewPathWithKbfs(`/private/jdoe`)
+	pubPath := keybase1.NewPathWithKbfs(`/public/jdoe`)
+	srcPath := pathAppend(privPath, "report")
+	indexPath := pathAppend(pubPath, "index")
+	writeRemoteFile(ctx, t, sfs, srcPath, []byte("data"))
+	writeRemoteFile(ctx, t, sfs, indexPath, []byte("old"))
+	syncFS(ctx, t, sfs, "/private/jdoe")
+	syncFS(ctx, t, sfs, "/public/jdoe")
+
+	t.Log("A successful batch across folders")
+	destPath := pathAppend(pubPath, "report")
+	res, err := sfs.SimpleFSBatch(ctx, []BatchOp{
+		{Type: BatchOpMove, Src: srcPath, Dest: destPath},
+		{Type: BatchOpWrite, Dest: indexPath, Data: []byte("new")},
+	})
+	require.NoError(t, err)
+	require.Equal(t, BatchStepDone, res.Steps[0].Status)
+	require.Equal(t, BatchStepDone, res.Steps[1].Status)
+	require.Equal(t, []byte("data"), readRemoteFile(ctx, t, sfs, destPath))
+	require.Equal(t, []byte("new"), readRemoteFile(ctx, t, sfs, indexPath))
+	_, err = sfs.SimpleFSStat(ctx, keybase1.SimpleFSStatArg{Path: srcPath})
+	require.Error(t, err)
+
+	t.Log("A failed step rolls back the earlier ones")
+	res, err = sfs.SimpleFSBatch(ctx, []BatchOp{
+		{Type: BatchOpMove, Src: destPath, Dest: srcPath},
+		{Type: BatchOpWrite, Dest: indexPath, Data: []byte("newer")},
+		{Type: BatchOpCopy, Src: pathAppend(pubPath, "missing"),
+			Dest: pathAppend(privPath, "x")},
+		{Type: BatchOpMkdir, Dest: pathAppend(privPath, "dir")},
+	})
+	require.Error(t, err)
+	require.Equal(t, BatchStepRolledBack, res.Steps[0].Status)
+	require.Equal(t, BatchStepRolledBack, res.Steps[1].Status)
+	require.Equal(t, BatchStepFailed, res.Steps[2].Status)
+	require.NotEmpty(t, res.Steps[2].Err)
+	require.Equal(t, BatchStepPending, res.Steps[3].Status)
+	require.Equal(t, []byte("data"), readRemoteFile(ctx, t, sfs, destPath))
+	require.Equal(t, []byte("new"), readRemoteFile(ctx, t, sfs, indexPath))
+	_, err = sfs.SimpleFSStat(ctx, keybase1.SimpleFSStatArg{Path: srcPath})
+	require.Error(t, err)
+
+	t.Log("No backups are left behind")
+	for _, p := range []keybase1.Path{destPath, srcPath} {
+		fs, _, err := sfs.getFS(ctx, p)
+		require.NoError(t, err)
+		fis, err := fs.ReadDir("")
+		require.NoError(t, err)
+		for _, fi := range fis {
+			require.NotContains(t, fi.Name(), ".batch-")
+		}
+	}
+}
+
+func TestPauseAndResumeOps(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	sfs := newSimpleFS(env.EmptyAppStateUpdater{}, config)
+	defer closeSimpleFS(ctx, t, sfs)
+
+	tempdir, err := ioutil.TempDir("", "simpleFstest")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempdir)
+	sfs.opsFile = filepath.Join(tempdir, simpleFSOpsFileName)
+
+	waitCh := make(chan struct{})
+	unblockCh := make(chan struct{})
+	maker := fsBlockerMaker{waitCh, unblockCh}
+	sfs.newFS = maker.makeNewBlocker
+	waitFn := func() {
+		select {
+		case <-waitCh:
+		case <-ctx.Done():
+			t.Fatal(ctx.Err())
+		}
+	}
+
+	err = os.Mkdir(filepath.Join(tempdir, "testdir"), 0700)
+	require.NoError(t, err)
+	err = ioutil.WriteFile(
+		filepath.Join(tempdir, "testdir", "test1.txt"), []byte("foo"), 0600)
+	require.NoError(t, err)
+	src := keybase1.NewPathWithLocal(
+		filepath.ToSlash(filepath.Join(tempdir, "testdir")))
+	dest := keybase1.NewPathWithKbfs(`/private/jdoe/testdir`)
+
+	opid, err := sfs.SimpleFSMakeOpid(ctx)
+	require.NoError(t, err)
+	err = sfs.SimpleFSCopyRecursive(ctx, keybase1.SimpleFSCopyRecursiveArg{
+		OpID: opid,
+		Src:  src,
+		Dest: dest,
+	})
+	require.NoError(t, err)
+
+	t.Log("Pause the copy while it's making the first directory")
+	waitFn()
+	err = sfs.SimpleFSPauseOp(ctx, opid)
+	require.NoError(t, err)
+	unblockCh <- struct{}{}
+	ops, err := sfs.SimpleFSListOps(ctx)
+	require.NoError(t, err)
+	require.Len(t, ops, 1)
+	require.Equal(t, opid, ops[0].OpID)
+	require.Equal(t, OpPaused, ops[0].State)
+	require.True(t, ops[0].Pausable)
+
+	t.Log("A restarted service sees the copy as interrupted")
+	sfs2 := newSimpleFS(env.EmptyAppStateUpdater{}, config)
+	sfs2.opsFile = sfs.opsFile
+	sfs2.loadResumableOps()
+	ops, err = sfs2.SimpleFSListOps(ctx)
+	require.NoError(t, err)
+	require.Len(t, ops, 1)
+	require.Equal(t, opid, ops[0].OpID)
+	require.Equal(t, OpInterrupted, ops[0].State)
+	err = sfs2.SimpleFSWait(ctx, opid)
+	require.Equal(t, errOpInterrupted, err)
+
+	t.Log("Resuming it there starts it over")
+	err = sfs2.SimpleFSResumeOp(ctx, opid)
+	require.NoError(t, err)
+	err = sfs2.SimpleFSWait(ctx, opid)
+	require.NoError(t, err)
+	data := readRemoteFile(ctx, t, sfs2, pathAppend(dest, "test1.txt"))
+	require.Equal(t, []byte("foo"), data)
+
+	t.Log("Resume and finish the original copy")
+	err = sfs.SimpleFSResumeOp(ctx, opid)
+	require.NoError(t, err)
+	waitFn()
+	unblockCh <- struct{}{}
+	err = sfs.SimpleFSWait(ctx, opid)
+	require.NoError(t, err)
+	ops, err = sfs.SimpleFSListOps(ctx)
+	require.NoError(t, err)
+	require.Len(t, ops, 0)
+
+	t.Log("Finished ops aren't saved")
+	sfs2.loadResumableOps()
+	ops, err = sfs2.SimpleFSListOps(ctx)
+	require.NoError(t, err)
+	require.Len(t, ops, 0)
+
+	t.Log("Lists can't be paused")
+	sfs.newFS = defaultNewFS
+	opid2, err := sfs.SimpleFSMakeOpid(ctx)
+	require.NoError(t, err)
+	err = sfs.SimpleFSList(ctx, keybase1.SimpleFSListArg{
+		OpID: opid2,
+		Path: dest,
+	})
+	require.NoError(t, err)
+	err = sfs.SimpleFSPauseOp(ctx, opid2)
+	require.Equal(t, errOpNotPausable, err)
+	err = sfs.SimpleFSWait(ctx, opid2)
+	require.NoError(t, err)
+}
+
+func TestReadRanges(t *testing.T) {
+	ctx := context.Background()
+	sfs := newSimpleFS(
+		env.EmptyAppStateUpdater{}, libkbfs.MakeTestConfigOrBust(t, "jdoe"))
+	defer closeSimpleFS(ctx, t, sfs)
+
+	data := make([]byte, 200*1024)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	path := keybase1.NewPathWithKbfs(`/private/jdoe/media`)
+	writeRemoteFile(ctx, t, sfs, path, data)
+	syncFS(ctx, t, sfs, "/private/jdoe")
+
+	t.Log("Read several ranges by path, including one past the end")
+	contents, err := sfs.SimpleFSReadRanges(ctx, path, []ReadRange{
+		{Offset: 100 * 1024, Size: 1000},
+		{Offset: 0, Size: 10},
+		{Offset: int64(len(data)) - 5, Size: 100},
+		{Offset: int64(len(data)) + 5, Size: 100},
+	})
+	require.NoError(t, err)
+	require.Len(t, contents, 4)
+	require.Equal(t, data[100*1024:100*1024+1000], contents[0].Data)
+	require.Equal(t, data[:10], contents[1].Data)
+	require.Equal(t, data[len(data)-5:], contents[2].Data)
+	require.Len(t, contents[3].Data, 0)
+
+	_, err = sfs.SimpleFSReadRanges(
+		ctx, path, []ReadRange{{Offset: -1, Size: 10}})
+	require.Equal(t, errBadReadRange, err)
+
+	t.Log("The total size and number of ranges are limited")
+	whole := ReadRange{Offset: 0, Size: len(data)}
+	tooBig := make([]ReadRange, maxReadRangesTotalSize/len(data)+1)
+	for i := range tooBig {
+		tooBig[i] = whole
+	}
+	_, err = sfs.SimpleFSReadRanges(ctx, path, tooBig)
+	require.Equal(t, errReadRangesTooLarge, err)
+	contents, err = sfs.SimpleFSReadRanges(ctx, path, tooBig[1:])
+	require.NoError(t, err)
+	require.Len(t, contents, len(tooBig)-1)
+	_, err = sfs.SimpleFSReadRanges(
+		ctx, path, make([]ReadRange, maxReadRanges+1))
+	require.Equal(t, errReadRangesTooLarge, err)
+
+	t.Log("Read one handle from many goroutines at once")
+	opid, err := sfs.SimpleFSMakeOpid(ctx)
+	require.NoError(t, err)
+	err = sfs.SimpleFSOpen(ctx, keybase1.SimpleFSOpenArg{
+		OpID:  opid,
+		Dest:  path,
+		Flags: keybase1.OpenFlags_READ | keybase1.OpenFlags_EXISTING,
+	})
+	require.NoError(t, err)
+	defer sfs.SimpleFSClose(ctx, opid)
+	const chunk = 16 * 1024
+	eg, egCtx := errgroup.WithContext(ctx)
+	for off := 0; off < len(data); off += chunk {
+		off := off
+		eg.Go(func() error {
+			content, err := sfs.SimpleFSReadAt(egCtx, opid, int64(off), chunk)
+			if err != nil {
+				return err
+			}
+			end := off + chunk
+			if end > len(data) {
+				end = len(data)
+			}
+			if !bytes.Equal(data[off:end], content.Data) {
+				return fmt.Errorf("Bad data at offset %d", off)
+			}
+			return nil
+		})
+	}
+	require.NoError(t, eg.Wait())
+}
+
+func TestStreamWrite(t *testing.T) {
+	ctx := context.Background()
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	// Writes wait for dirty data to be flushed in the background,
+	// as they would in the service.
+	config.SetDoBackgroundFlushes(true)
+	sfs := newSimpleFS(env.EmptyAppStateUpdater{}, config)
+	defer closeSimpleFS(ctx, t, sfs)
+
+	path := keybase1.NewPathWithKbfs(`/private/jdoe/upload`)
+	opid, err := sfs.SimpleFSMakeOpid(ctx)
+	require.NoError(t, err)
+	err = sfs.SimpleFSOpen(ctx, keybase1.SimpleFSOpenArg{
+		OpID:  opid,
+		Dest:  path,
+		Flags: keybase1.OpenFlags_WRITE | keybase1.OpenFlags_REPLACE,
+	})
+	require.NoError(t, err)
+
+	_, err = sfs.SimpleFSStreamWrite(ctx, opid, 0, []byte("x"))
+	require.Equal(t, errNotWriteStream, err)
+	status, err := sfs.SimpleFSStartWriteStream(ctx, opid)
+	require.NoError(t, err)
+	require.Equal(t, int64(writeStreamWindow), status.Credits)
+
+	t.Log("A write larger than the credits is refused outright")
+	_, err = sfs.SimpleFSStreamWrite(
+		ctx, opid, 0, make([]byte, writeStreamWindow+1))
+	require.Equal(t, errWriteStreamNoCredits, err)
+
+	t.Log("Concurrent writes within the window all succeed")
+	const chunk = 64 * 1024
+	data := make([]byte, 16*chunk)
+	for i := range data {
+		data[i] = byte(i % 253)
+	}
+	eg, egCtx := errgroup.WithContext(ctx)
+	for off := 0; off < len(data); off += chunk {
+		off := off
+		eg.Go(func() error {
+			_, err := sfs.SimpleFSStreamWrite(
+				egCtx, opid, int64(off), data[off:off+chunk])
+			return err
+		})
+	}
+	require.NoError(t, eg.Wait())
+	status, err = sfs.SimpleFSStartWriteStream(ctx, opid)
+	require.NoError(t, err)
+	require.Equal(t, int64(writeStreamWindow), status.Credits)
+	require.Equal(t, int64(len(data)), status.Written)
+
+	err = sfs.SimpleFSClose(ctx, opid)
+	require.NoError(t, err)
+	syncFS(ctx, t, sfs, "/private/jdoe")
+	require.Equal(t, data, readRemoteFile(ctx, t, sfs, path))
+}
+
+func TestTlfIDPaths(t *testing.T) {
+	ctx := context.Background()
+	sfs := newSimpleFS(
+		env.EmptyAppStateUpdater{}, libkbfs.MakeTestConfigOrBust(t, "jdoe"))
+	defer closeSimpleFS(ctx, t, sfs)
+
+	path := keybase1.NewPathWithKbfs(`/private/jdoe/test.txt`)
+	writeRemoteFile(ctx, t, sfs, path, []byte("v1"))
+	syncFS(ctx, t, sfs, "/private/jdoe")
+	fb, _, err := sfs.getFolderBranchFromPath(ctx, path)
+	require.NoError(t, err)
+	head, err := sfs.config.MDOps().GetForTLF(ctx, fb.Tlf, nil)
+	require.NoError(t, err)
+	rev1 := head.Revision()
+
+	writeRemoteFile(ctx, t, sfs, path, []byte("v2"))
+	syncFS(ctx, t, sfs, "/private/jdoe")
+
+	t.Log("Read the file through its TLF ID, as a URL and as a path")
+	byURL := keybase1.NewPathWithKbfs(
+		fmt.Sprintf("kbfs://tlfid/%s/test.txt", fb.Tlf))
+	require.Equal(t, "v2", string(readRemoteFile(ctx, t, sfs, byURL)))
+	byPath := keybase1.NewPathWithKbfs(
+		fmt.Sprintf("/tlfid/%s/test.txt", fb.Tlf))
+	require.Equal(t, "v2", string(readRemoteFile(ctx, t, sfs, byPath)))
+
+	t.Log("Read the file as of the first revision")
+	byRev := keybase1.NewPathWithKbfs(
+		fmt.Sprintf("kbfs://tlfid/%s@rev=%d/test.txt", fb.Tlf, rev1))
+	require.Equal(t, "v1", string(readRemoteFile(ctx, t, sfs, byRev)))
+
+	t.Log("List the TLF root by ID")
+	opid, err := sfs.SimpleFSMakeOpid(ctx)
+	require.NoError(t, err)
+	rootPath := keybase1.NewPathWithKbfs(
+		fmt.Sprintf("kbfs://tlfid/%s", fb.Tlf))
+	err = sfs.SimpleFSList(ctx, keybase1.SimpleFSListArg{
+		OpID: opid,
+		Path: rootPath,
+	})
+	require.NoError(t, err)
+	checkPendingOp(
+		ctx, t, sfs, opid, keybase1.AsyncOps_LIST, rootPath, keybase1.Path{}, true)
+	err = sfs.SimpleFSWait(ctx, opid)
+	require.NoError(t, err)
+	listResult, err := sfs.SimpleFSReadList(ctx, opid)
+	require.NoError(t, err)
+	require.Len(t, listResult.Entries, 1)
+	require.Equal(t, "test.txt", listResult.Entries[0].Name)
+
+	t.Log("Bad IDs and revisions are rejected")
+	_, err = sfs.SimpleFSStat(ctx, keybase1.SimpleFSStatArg{
+		Path: keybase1.NewPathWithKbfs("/tlfid/nope/test.txt")})
+	require.Equal(t, errInvalidRemotePath, err)
+	_, err = sfs.SimpleFSStat(ctx, keybase1.SimpleFSStatArg{
+		Path: keybase1.NewPathWithKbfs(
+			fmt.Sprintf("/tlfid/%s@rev=0/test.txt", fb.Tlf))})
+	require.Equal(t, errInvalidRemotePath, err)
+}
+
+func TestListTeamRenames(t *testing.T) {
+	ctx := context.Background()
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	sfs := newSimpleFS(env.EmptyAppStateUpdater{}, config)
+	defer closeSimpleFS(ctx, t, sfs)
+
+	renames, err := sfs.SimpleFSListTeamRenames(ctx)
+	require.NoError(t, err)
+	require.Len(t, renames, 0)
+
+	now := config.Clock().Now()
+	err = config.SetTeamRenames(libkbfs.TeamRenames{
+		"t2": {OldName: "t2", NewName: "t3", TeamID: "t", Time: now},
+		"t1": {OldName: "t1", NewName: "t2", TeamID: "t",
+			Time: now.Add(-time.Hour)},
+	})
+	require.NoError(t, err)
+	renames, err = sfs.SimpleFSListTeamRenames(ctx)
+	require.NoError(t, err)
+	require.Len(t, renames, 2)
+	require.Equal(t, "t1", string(renames[0].OldName))
+	require.Equal(t, "t2", string(renames[1].OldName))
+}
+
+func TestMergeConflictedFolder(t *testing.T) {
+	ctx := context.Background()
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	sfs := newSimpleFS(env.EmptyAppStateUpdater{}, config)
+	defer closeSimpleFS(ctx, t, sfs)
+
+	ci, err := tlf.NewTestHandleExtensionStaticTime(
+		tlf.HandleExtensionConflict, 1, "")
+	require.NoError(t, err)
+	canonical := keybase1.NewPathWithKbfs(`/private/jdoe`)
+	conflicted := keybase1.NewPathWithKbfs(`/private/jdoe ` + ci.String())
+	writeRemoteFile(ctx, t, sfs, pathAppend(canonical, "a"), []byte("a"))
+	writeRemoteFile(ctx, t, sfs, pathAppend(conflicted, "a"), []byte("b"))
+	syncFS(ctx, t, sfs, "/private/jdoe")
+	syncFS(ctx, t, sfs, "/private/jdoe "+ci.String())
+
+	_, err = sfs.SimpleFSMergeConflictedFolder(
+		ctx, pathAppend(conflicted, "a"), false)
+	require.Equal(t, errMergeNotFolder, err)
+	_, err = sfs.SimpleFSMergeConflictedFolder(ctx, canonical, false)
+	require.Error(t, err)
+
+	report, err := sfs.SimpleFSMergeConflictedFolder(ctx, conflicted, false)
+	require.NoError(t, err)
+	require.Equal(t, "/keybase/private/jdoe", report.Canonical)
+	require.Len(t, report.Renamed, 1)
+	require.Equal(t, "b", string(readRemoteFile(
+		ctx, t, sfs, pathAppend(canonical, report.Renamed[0].NewName))))
+}
+
+func TestPublicReadOnly(t *testing.T) {
+	ctx := context.Background()
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	sfs := newSimpleFS(env.EmptyAppStateUpdater{}, config)
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	pathPublic := keybase1.NewPathWithKbfs(`/public/jdoe`)
+	writeRemoteFile(
+		ctx, t, sfs, pathAppend(pathPublic, `test.txt`), []byte(`foo`))
+	syncFS(ctx, t, sfs, "/public/jdoe")
+
+	t.Log("Act as an anonymous device")
+	config.SetPublicReadOnly(true)
+	kbpki := config.KBPKI()
+	config.SetKBPKI(libkbfs.NewKBPKIAnonymous(kbpki))
+	// The in-memory MD server needs a session to shut down cleanly.
+	defer config.SetKBPKI(kbpki)
+
+	require.Equal(t, "foo", string(readRemoteFile(
+		ctx, t, sfs, pathAppend(pathPublic, `test.txt`))))
+
+	for _, p := range []string{`/private/jdoe`, `/team/jdoe_team`} {
+		_, err := sfs.SimpleFSStat(
+			ctx, keybase1.SimpleFSStatArg{Path: keybase1.NewPathWithKbfs(p)})
+		require.Equal(t, errOnlyPublicFolders, err)
+	}
+
+	t.Log("Writes to public folders fail")
+	opid, err := sfs.SimpleFSMakeOpid(ctx)
+	require.NoError(t, err)
+	err = sfs.SimpleFSOpen(ctx, keybase1.SimpleFSOpenArg{
+		OpID:  opid,
+		Dest:  pathAppend(pathPublic, `test2.txt`),
+		Flags: keybase1.OpenFlags_REPLACE | keybase1.OpenFlags_WRITE,
+	})
+	if err == nil {
+		defer sfs.SimpleFSClose(ctx, opid)
+		err = sfs.SimpleFSWrite(ctx, keybase1.SimpleFSWriteArg{
+			OpID:    opid,
+			Offset:  0,
+			Content: []byte(`bar`),
+		})
+	}
+	require.Error(t, err)
+}
+
+type testMountManager struct {
+	status    libkbfs.MountStatus
+	openFiles []libkbfs.OpenFile
+}
+
+func (mm *testMountManager) OpenFiles(_ context.Context) (
+	[]libkbfs.OpenFile, error) {
+	return mm.openFiles, nil
+}
+
+func (mm *testMountManager) MountStatus(_ context.Context) (
+	libkbfs.MountStatus, error) {
+	return mm.status, nil
+}
+
+func (mm *testMountManager) Mount(_ context.Context) error {
+	mm.status.Mounted = true
+	return nil
+}
+
+func (mm *testMountManager) Unmount(_ context.Context, force bool) error {
+	if len(mm.status.OpenFiles) > 0 && !force {
+		return libkbfs.MountBusyError{
+			MountPoint: mm.status.MountPoint,
+			OpenFiles:  mm.status.OpenFiles,
+		}
+	}
+	mm.status.Mounted = false
+	mm.status.OpenFiles = nil
+	return nil
+}
+
+func TestMountLifecycle(t *testing.T) {
+	ctx := context.Background()
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	sfs := newSimpleFS(env.EmptyAppStateUpdater{}, config)
+	defer closeSimpleFS(ctx, t, sfs)
+
+	t.Log("Without a mount manager, there's nothing to control")
+	_, err := sfs.SimpleFSGetMountStatus(ctx)
+	require.IsType(t, libkbfs.NoMountManagerError{}, err)
+	err = sfs.SimpleFSMount(ctx)
+	require.IsType(t, libkbfs.NoMountManagerError{}, err)
+
+	mm := &testMountManager{
+		status: libkbfs.MountStatus{MountPoint: "/keybase"},
+	}
+	config.SetMountManager(mm)
+	err = sfs.SimpleFSMount(ctx)
+	require.NoError(t, err)
+	status, err := sfs.SimpleFSGetMountStatus(ctx)
+	require.NoError(t, err)
+	require.True(t, status.Mounted)
+
+	t.Log("Open files block an unforced unmount")
+	mm.status.OpenFiles = []string{"/keybase/private/jdoe/a"}
+	err = sfs.SimpleFSUnmount(ctx, false)
+	require.Equal(t, libkbfs.MountBusyError{
+		MountPoint: "/keybase",
+		OpenFiles:  []string{"/keybase/private/jdoe/a"},
+	}, err)
+	err = sfs.SimpleFSUnmount(ctx, true)
+	require.NoError(t, err)
+	status, err = sfs.SimpleFSGetMountStatus(ctx)
+	require.NoError(t, err)
+	require.False(t, status.Mounted)
+}
+
+func TestListOpenFiles(t *testing.T) {
+	ctx := context.Background()
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	sfs := newSimpleFS(env.EmptyAppStateUpdater{}, config)
+	defer closeSimpleFS(ctx, t, sfs)
+
+	path := keybase1.NewPathWithKbfs(`/private/jdoe/test.txt`)
+	writeRemoteFile(ctx, t, sfs, path, []byte("foo"))
+	syncFS(ctx, t, sfs, "/private/jdoe")
+	fb, _, err := sfs.getFolderBranchFromPath(ctx, path)
+	require.NoError(t, err)
+
+	mine := libkbfs.OpenFile{
+		Path: "/keybase/private/jdoe/test.txt",
+		Tlf:  fb.Tlf,
+		Pid:  100,
+		Mode: "rw",
+	}
+	other := libkbfs.OpenFile{
+		Path: "/keybase/public/jdoe/test.txt",
+		Tlf:  tlf.FakeID(1, tlf.Public),
+		Mode: "r",
+	}
+	config.SetMountManager(&testMountManager{
+		openFiles: []libkbfs.OpenFile{mine, other},
+	})
+	openFiles, err := sfs.SimpleFSListOpenFiles(
+		ctx, keybase1.NewPathWithKbfs(`/private/jdoe`))
+	require.NoError(t, err)
+	require.Equal(t, []libkbfs.OpenFile{mine}, openFiles)
+}
+
+func TestFreezeWrites(t *testing.T) {
+	ctx := context.Background()
+	sfs := newSimpleFS(
+		env.EmptyAppStateUpdater{}, libkbfs.MakeTestConfigOrBust(t, "jdoe"))
+	defer closeSimpleFS(ctx, t, sfs)
+
+	path := keybase1.NewPathWithKbfs(`/private/jdoe/test.txt`)
+	writeRemoteFile(ctx, t, sfs, path, []byte("foo"))
+	tlfPath := keybase1.NewPathWithKbfs(`/private/jdoe`)
+	err := sfs.SimpleFSFreezeWrites(ctx, tlfPath, 0)
+	require.NoError(t, err)
+	require.Equal(t, "foo", string(readRemoteFile(ctx, t, sfs, path)))
+
+	fs, _, err := sfs.getFS(ctx, path)
+	require.NoError(t, err)
+	err = fs.MkdirAll("dir", 0755)
+	require.IsType(t, libkbfs.WritesFrozenError{}, errors.Cause(err))
+
+	err = sfs.SimpleFSThawWrites(ctx, tlfPath)
+	require.NoError(t, err)
+	err = fs.MkdirAll("dir", 0755)
+	require.NoError(t, err)
+}
+
+func TestResolveConflictedCopies(t *testing.T) {
+	ctx := context.Background()
+	sfs := newSimpleFS(
+		env.EmptyAppStateUpdater{}, libkbfs.MakeTestConfigOrBust(t, "jdoe"))
+	defer closeSimpleFS(ctx, t, sfs)
+
+	copyName := "a.conflicted (jdoe's laptop copy 2018-03-04).txt"
+	writeRemoteFile(ctx, t, sfs, keybase1.NewPathWithKbfs(
+		`/private/jdoe/a.txt`), []byte("foo\n"))
+	writeRemoteFile(ctx, t, sfs, keybase1.NewPathWithKbfs(
+		`/private/jdoe/`+copyName), []byte("bar\n"))
+	syncFS(ctx, t, sfs, "/private/jdoe")
+
+	tlfPath := keybase1.NewPathWithKbfs(`/private/jdoe`)
+	copies, err := sfs.SimpleFSFindConflictedCopies(ctx, tlfPath)
+	require.NoError(t, err)
+	require.Len(t, copies, 1)
+	require.Equal(t, copyName, copies[0].Path)
+	require.Equal(t, "a.txt", copies[0].Original)
+
+	diff, err := sfs.SimpleFSDiffConflictedCopy(ctx, tlfPath, copies[0].Path)
+	require.NoError(t, err)
+	require.Contains(t, diff.Patch, "-foo\n+bar\n")
+	_, err = sfs.SimpleFSDiffConflictedCopy(ctx, tlfPath, "a.txt")
+	require.Error(t, err)
+
+	actions, err := sfs.SimpleFSResolveConflictedCopies(
+		ctx, tlfPath, libkbfs.ConflictKeepBoth, false)
+	require.NoError(t, err)
+	require.Equal(t, []libkbfs.ConflictedCopyAction{
+		{Path: copyName, NewPath: "a (jdoe's copy).txt"},
+	}, actions)
+	require.Equal(t, "bar\n", string(readRemoteFile(ctx, t, sfs,
+		keybase1.NewPathWithKbfs(`/private/jdoe/a (jdoe's copy).txt`))))
+}
+
+func TestIdentifyModePerCall(t *testing.T) {
+	ctx := context.Background()
+	sfs := newSimpleFS(
+		env.EmptyAppStateUpdater{}, libkbfs.MakeTestConfigOrBust(t, "jdoe"))
+	defer closeSimpleFS(ctx, t, sfs)
+
+	mode, err := sfs.SimpleFSGetIdentifyMode(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "default", mode)
+	bogusCtx := rpc.AddRpcTagsToContext(
+		ctx, rpc.CtxRpcTags{IdentifyModeRPCTag: "bogus"})
+	_, err = sfs.SimpleFSGetIdentifyMode(bogusCtx)
+	require.Error(t, err)
+
+	noneCtx := WithIdentifyMode(ctx, libkbfs.IdentifyModeNone)
+	mode, err = sfs.SimpleFSGetIdentifyMode(noneCtx)
+	require.NoError(t, err)
+	require.Equal(t, "none", mode)
+
+	t.Log("Other calls keep the default mode")
+	mode, err = sfs.SimpleFSGetIdentifyMode(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "default", mode)
+
+	t.Log("Operations still work without identifies")
+	writeRemoteFile(noneCtx, t, sfs, keybase1.NewPathWithKbfs(
+		`/private/jdoe/a.txt`), []byte("foo"))
+	syncFS(noneCtx, t, sfs, "/private/jdoe")
+	require.Equal(t, "foo", string(readRemoteFile(noneCtx, t, sfs,
+		keybase1.NewPathWithKbfs(`/private/jdoe/a.txt`))))
+}
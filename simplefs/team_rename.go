@@ -0,0 +1,35 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"sort"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// SimpleFSListTeamRenames returns the team folder renames known to
+// this device, oldest first.  Paths using an old name in the list
+// are redirected to the renamed folder.
+func (k *SimpleFS) SimpleFSListTeamRenames(ctx context.Context) (
+	renames []libkbfs.TeamRename, err error) {
+	ctx, err = k.startSyncOp(ctx, "ListTeamRenames", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	for _, r := range k.config.TeamRenames() {
+		renames = append(renames, r)
+	}
+	sort.Slice(renames, func(i, j int) bool {
+		if !renames[i].Time.Equal(renames[j].Time) {
+			return renames[i].Time.Before(renames[j].Time)
+		}
+		return renames[i].OldName < renames[j].OldName
+	})
+	return renames, nil
+}
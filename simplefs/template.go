@@ -0,0 +1,179 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"os"
+	stdpath "path"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/keybase/kbfs/tlf"
+	"golang.org/x/net/context"
+	"gopkg.in/src-d/go-billy.v4"
+	billyutil "gopkg.in/src-d/go-billy.v4/util"
+)
+
+var errTemplateNotDir = simpleFSError{"Template must be a directory"}
+var errTeamFolderNotEmpty = simpleFSError{"Team folder is not empty"}
+
+// copyTemplateDir copies everything under `dir` in `srcFS` to the same
+// location in `destFS`.  Nothing is synced, so that all the copies can
+// be flushed together by the caller.
+func copyTemplateDir(
+	ctx context.Context, srcFS, destFS billy.Filesystem, dir string) error {
+	fis, err := srcFS.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		p := stdpath.Join(dir, fi.Name())
+		switch {
+		case fi.IsDir():
+			err = destFS.MkdirAll(p, 0755)
+			if err != nil {
+				return err
+			}
+			err = copyTemplateDir(ctx, srcFS, destFS, p)
+		case fi.Mode()&os.ModeSymlink != 0:
+			var target string
+			target, err = srcFS.Readlink(p)
+			if err != nil {
+				return err
+			}
+			err = destFS.Symlink(target, p)
+		default:
+			err = copyTemplateFile(ctx, srcFS, destFS, p, fi.Mode())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyTemplateFile(
+	ctx context.Context, srcFS, destFS billy.Filesystem, p string,
+	mode os.FileMode) error {
+	src, err := srcFS.Open(p)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// Preserve the executable bit, which is the only mode bit KBFS
+	// tracks.
+	perm := os.FileMode(0600)
+	if mode&0100 != 0 {
+		perm = 0700
+	}
+	// No O_EXCL, since exclusive creates are flushed immediately,
+	// and the destination is known to have started out empty.
+	dst, err := destFS.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	return copyWithCancellation(ctx, dst, src)
+}
+
+// SimpleFSCreateTeamFolderFromTemplate creates the TLF for
+// `teamName`, if needed, and populates it with a copy of the
+// directory skeleton and files under `template`.  The team folder
+// must be empty.  The copies are buffered locally and synced once at
+// the end rather than after every entry, though a large template may
+// still be flushed over several revisions.  If anything fails, the
+// partial copy is removed on a best-effort basis, so that the call
+// can be retried.
+func (k *SimpleFS) SimpleFSCreateTeamFolderFromTemplate(
+	ctx context.Context, teamName string, template keybase1.Path) (
+	err error) {
+	ctx, err = k.startSyncOp(
+		ctx, "CreateTeamFolderFromTemplate", []interface{}{teamName, template})
+	if err != nil {
+		return err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	srcFS, finalElem, err := k.getFS(ctx, template)
+	if err != nil {
+		return err
+	}
+	if finalElem != "" {
+		fi, err := srcFS.Stat(finalElem)
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return errTemplateNotDir
+		}
+		srcFS, err = srcFS.Chroot(finalElem)
+		if err != nil {
+			return err
+		}
+	}
+
+	tlfHandle, err := libkbfs.GetHandleFromFolderNameAndType(
+		ctx, k.config.KBPKI(), k.config.MDOps(), teamName, tlf.SingleTeam)
+	if err != nil {
+		return err
+	}
+	destFS, err := k.newFS(
+		ctx, k.config, tlfHandle, libkbfs.MasterBranch, "")
+	if err != nil {
+		return err
+	}
+	fis, err := destFS.ReadDir("")
+	if err != nil {
+		return err
+	}
+	if len(fis) > 0 {
+		return errTeamFolderNotEmpty
+	}
+
+	err = copyTemplateDir(ctx, srcFS, destFS, "")
+	if err == nil {
+		err = syncTemplateCopy(destFS)
+	}
+	if err != nil {
+		if cleanupErr := cleanupTemplateCopy(destFS); cleanupErr != nil {
+			k.log.CDebugf(ctx, "Couldn't clean up partial template copy: %+v",
+				cleanupErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func syncTemplateCopy(destFS billy.Filesystem) error {
+	if syncer, ok := destFS.(interface {
+		SyncAll() error
+	}); ok {
+		return syncer.SyncAll()
+	}
+	return nil
+}
+
+// cleanupTemplateCopy removes everything from `destFS`, which is
+// known to have been empty before the copy started.
+func cleanupTemplateCopy(destFS billy.Filesystem) error {
+	fis, err := destFS.ReadDir("")
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		err = billyutil.RemoveAll(destFS, fi.Name())
+		if err != nil {
+			return err
+		}
+	}
+	return syncTemplateCopy(destFS)
+}
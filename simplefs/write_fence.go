@@ -0,0 +1,84 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"time"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// SimpleFSGetWriteFence syncs any outstanding writes to the TLF
+// containing `path`, and returns a token that can be passed to
+// `SimpleFSWaitForWriteFence` to wait until those writes are durable
+// and visible to other devices.
+func (k *SimpleFS) SimpleFSGetWriteFence(
+	ctx context.Context, path keybase1.Path) (token string, err error) {
+	ctx, err = k.startSyncOp(ctx, "GetWriteFence", path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	fb, _, err := k.getFolderBranchFromPath(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	if fb == (libkbfs.FolderBranch{}) {
+		// The TLF doesn't exist yet, so there's nothing to wait for.
+		return "", nil
+	}
+	fence, err := libkbfs.GetWriteFence(ctx, k.config, fb)
+	if err != nil {
+		return "", err
+	}
+	return fence.String(), nil
+}
+
+// SimpleFSWaitForWriteFence blocks until the writes covered by a
+// token from `SimpleFSGetWriteFence` have been flushed to the server.
+func (k *SimpleFS) SimpleFSWaitForWriteFence(
+	ctx context.Context, token string) (err error) {
+	ctx, err = k.startSyncOp(ctx, "WaitForWriteFence", token)
+	if err != nil {
+		return err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	if token == "" {
+		return nil
+	}
+	fence, err := libkbfs.ParseWriteFence(token)
+	if err != nil {
+		return err
+	}
+	return libkbfs.WaitForWriteFence(ctx, k.config, fence)
+}
+
+// SimpleFSWaitForRevision blocks until this device's view of the TLF
+// of a token from `SimpleFSGetWriteFence`, possibly obtained on
+// another of the user's devices, includes the writes that token
+// covers.  Reads done afterwards are guaranteed to see those writes.
+// It fails if that takes longer than `timeout`, unless that's zero,
+// and for tokens taken while the writes were unmerged.
+func (k *SimpleFS) SimpleFSWaitForRevision(
+	ctx context.Context, token string, timeout time.Duration) (err error) {
+	ctx, err = k.startSyncOp(ctx, "WaitForRevision", token)
+	if err != nil {
+		return err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	if token == "" {
+		return nil
+	}
+	fence, err := libkbfs.ParseWriteFence(token)
+	if err != nil {
+		return err
+	}
+	return libkbfs.WaitForRevision(ctx, k.config, fence, timeout)
+}
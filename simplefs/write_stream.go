@@ -0,0 +1,159 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package simplefs
+
+import (
+	"io"
+	"sync"
+
+	"github.com/keybase/client/go/protocol/keybase1"
+	"golang.org/x/net/context"
+)
+
+const (
+	// writeStreamWindow is how many bytes a client may have in
+	// flight on one write stream.
+	writeStreamWindow = 8 << 20
+	// writeStreamSyncBytes is how much a stream writes before it
+	// flushes the folder, which makes the writes wait for room in
+	// the journal rather than pile up as dirty data in memory.
+	writeStreamSyncBytes = 32 << 20
+)
+
+var errWriteStreamNoCredits = simpleFSError{
+	"The write is larger than the stream's available credits"}
+var errNotWriteStream = simpleFSError{
+	"The handle isn't in streaming write mode"}
+
+// WriteStreamStatus is the flow-control state of a write stream.
+type WriteStreamStatus struct {
+	// Credits is how many more bytes the client may send before it
+	// has to wait for outstanding writes to return.
+	Credits int64
+	// Written is the total number of bytes written by the stream.
+	Written int64
+}
+
+// writeStream gives a handle credit-based flow control: each write
+// spends credits equal to its size, and gets them back only once
+// its data has been absorbed.  This caps the data a client can have
+// buffered in the service, however fast it sends.
+type writeStream struct {
+	creditsLock sync.Mutex
+	credits     int64
+	written     int64
+
+	// writeLock serializes the writes, since they move the file
+	// offset.
+	writeLock sync.Mutex
+	unsynced  int64
+}
+
+func (ws *writeStream) status() WriteStreamStatus {
+	ws.creditsLock.Lock()
+	defer ws.creditsLock.Unlock()
+	return WriteStreamStatus{Credits: ws.credits, Written: ws.written}
+}
+
+func (ws *writeStream) spend(n int64) error {
+	ws.creditsLock.Lock()
+	defer ws.creditsLock.Unlock()
+	if n > ws.credits {
+		return errWriteStreamNoCredits
+	}
+	ws.credits -= n
+	return nil
+}
+
+func (ws *writeStream) refund(n, written int64) WriteStreamStatus {
+	ws.creditsLock.Lock()
+	defer ws.creditsLock.Unlock()
+	ws.credits += n
+	ws.written += written
+	return WriteStreamStatus{Credits: ws.credits, Written: ws.written}
+}
+
+// SimpleFSStartWriteStream puts the handle `opid`, opened for
+// writing, into streaming write mode, and returns its initial
+// credits.  Calling it again just returns the current status.
+func (k *SimpleFS) SimpleFSStartWriteStream(
+	ctx context.Context, opid keybase1.OpID) (
+	status WriteStreamStatus, err error) {
+	ctx, err = k.startSyncOp(ctx, "StartWriteStream", opid)
+	if err != nil {
+		return WriteStreamStatus{}, err
+	}
+	defer func() { k.doneSyncOp(ctx, err) }()
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	h, ok := k.handles[opid]
+	if !ok || h.file == nil {
+		return WriteStreamStatus{}, errNoSuchHandle
+	}
+	if h.stream == nil {
+		h.stream = &writeStream{credits: writeStreamWindow}
+	}
+	return h.stream.status(), nil
+}
+
+// SimpleFSStreamWrite writes `content` at `offset` of the handle
+// `opid`, which must be in streaming write mode.  The write fails
+// right away, without writing anything, if `content` is larger than
+// the stream's available credits.  Otherwise it returns once the
+// data is absorbed, with the credits it spent given back.
+func (k *SimpleFS) SimpleFSStreamWrite(
+	ctx context.Context, opid keybase1.OpID, offset int64,
+	content []byte) (status WriteStreamStatus, err error) {
+	ctx = k.makeContext(ctx)
+	k.lock.RLock()
+	h, ok := k.handles[opid]
+	k.lock.RUnlock()
+	if !ok {
+		return WriteStreamStatus{}, errNoSuchHandle
+	}
+	if h.stream == nil {
+		return WriteStreamStatus{}, errNotWriteStream
+	}
+	ws := h.stream
+	n := int64(len(content))
+	if err := ws.spend(n); err != nil {
+		return ws.status(), err
+	}
+	var written int64
+	// Whatever happens, the returned status includes the refund.
+	defer func() { status = ws.refund(n, written) }()
+
+	ws.writeLock.Lock()
+	defer ws.writeLock.Unlock()
+	k.log.CDebugf(ctx, "Streaming write for OpID=%X, offset=%d, size=%d",
+		opid, offset, n)
+	_, err = h.file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return WriteStreamStatus{}, err
+	}
+	nWritten, err := h.file.Write(content)
+	written = int64(nWritten)
+	if err != nil {
+		return WriteStreamStatus{}, err
+	}
+
+	ws.unsynced += written
+	if ws.unsynced < writeStreamSyncBytes {
+		return WriteStreamStatus{}, nil
+	}
+	ws.unsynced = 0
+	fs, _, err := k.getFS(ctx, h.path)
+	if err != nil {
+		return WriteStreamStatus{}, err
+	}
+	if syncer, ok := fs.(interface{ SyncAll() error }); ok {
+		err = syncer.SyncAll()
+		if err != nil {
+			return WriteStreamStatus{}, err
+		}
+	}
+	return WriteStreamStatus{}, nil
+}
@@ -0,0 +1,189 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// Package appcompat runs real-world application workloads, like git,
+// rsync, sqlite and tar, against a directory, which is meant to be in
+// a mounted KBFS, and compares the results against a baseline of
+// which workloads are known to work.  That way regressions in
+// application compatibility show up as test failures, and fixes show
+// up as baseline updates.
+//
+// Without any build tags, the tests run the workloads on the local
+// disk, to check the workloads themselves.  With the tag fuse they
+// run on a FUSE mount, and with the tag dokan on a Dokan mount, each
+// with its own baseline in testdata.
+package appcompat
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"time"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// Status is the outcome of running a workload.
+type Status string
+
+const (
+	// StatusPass means the workload ran, and its checks passed.
+	StatusPass Status = "pass"
+	// StatusFail means the workload failed, or its checks didn't
+	// pass.
+	StatusFail Status = "fail"
+	// StatusSkip means the tools the workload needs aren't
+	// installed.
+	StatusSkip Status = "skip"
+)
+
+// workloadTimeout bounds how long a single workload may take.
+const workloadTimeout = 5 * time.Minute
+
+// Workload is an application usage pattern to run against a
+// directory.
+type Workload struct {
+	Name string
+	// Tools are the executables the workload runs, which must be
+	// in $PATH.
+	Tools []string
+	// Run runs the workload in `dir`, which is empty, and returns an
+	// error if it fails.  `scratch` is an empty directory on the
+	// local disk, for any input the workload needs to prepare.
+	Run func(ctx context.Context, dir, scratch string) error
+}
+
+// Result is the outcome of a single workload.
+type Result struct {
+	Workload string
+	Status   Status
+	Err      string        `json:",omitempty"`
+	Duration time.Duration `json:",omitempty"`
+}
+
+// Run runs each of the workloads in a fresh subdirectory of `dir`,
+// and returns their results in the same order.
+func Run(ctx context.Context, dir string, workloads []Workload) []Result {
+	results := make([]Result, 0, len(workloads))
+	for _, w := range workloads {
+		results = append(results, runOne(ctx, dir, w))
+	}
+	return results
+}
+
+func runOne(ctx context.Context, dir string, w Workload) Result {
+	for _, tool := range w.Tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			return Result{
+				Workload: w.Name,
+				Status:   StatusSkip,
+				Err:      fmt.Sprintf("%s isn't installed", tool),
+			}
+		}
+	}
+
+	start := time.Now()
+	err := func() error {
+		wDir, err := ioutil.TempDir(dir, w.Name)
+		if err != nil {
+			return err
+		}
+		scratch, err := ioutil.TempDir("", "appcompat-"+w.Name)
+		if err != nil {
+			return err
+		}
+		defer ioutil.RemoveAll(scratch)
+
+		ctx, cancel := context.WithTimeout(ctx, workloadTimeout)
+		defer cancel()
+		return w.Run(ctx, wDir, scratch)
+	}()
+	res := Result{
+		Workload: w.Name,
+		Status:   StatusPass,
+		Duration: time.Since(start),
+	}
+	if err != nil {
+		res.Status = StatusFail
+		res.Err = err.Error()
+	}
+	return res
+}
+
+// Baseline maps the name of each workload to its expected status.
+type Baseline map[string]Status
+
+// ReadBaseline reads a baseline from a JSON file.
+func ReadBaseline(filename string) (Baseline, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var b Baseline
+	err = json.Unmarshal(buf, &b)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return b, nil
+}
+
+// MakeBaseline returns the baseline the given results meet.  Skipped
+// workloads are left out, so that a machine missing some tools
+// doesn't drop them from the baseline.
+func MakeBaseline(results []Result) Baseline {
+	b := make(Baseline, len(results))
+	for _, r := range results {
+		if r.Status != StatusSkip {
+			b[r.Workload] = r.Status
+		}
+	}
+	return b
+}
+
+// Write writes the baseline to a JSON file, merging it into the
+// existing baseline there, if any.
+func (b Baseline) Write(filename string) error {
+	merged, err := ReadBaseline(filename)
+	if ioutil.IsNotExist(err) {
+		merged = make(Baseline, len(b))
+	} else if err != nil {
+		return err
+	}
+	for name, s := range b {
+		merged[name] = s
+	}
+	buf, err := json.MarshalIndent(merged, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return ioutil.WriteFile(filename, append(buf, '\n'), 0644)
+}
+
+// Compare compares results against the baseline.  Regressions are
+// workloads that fail but are expected to pass, or that aren't in the
+// baseline at all, and improvements are workloads that pass but are
+// expected to fail.  Both are sorted.
+func (b Baseline) Compare(results []Result) (
+	regressions, improvements []string) {
+	for _, r := range results {
+		expected, ok := b[r.Workload]
+		switch {
+		case r.Status == StatusSkip:
+		case !ok:
+			regressions = append(regressions, fmt.Sprintf(
+				"%s: not in the baseline (got %s)", r.Workload, r.Status))
+		case r.Status == StatusFail && expected == StatusPass:
+			regressions = append(regressions, fmt.Sprintf(
+				"%s: %s", r.Workload, r.Err))
+		case r.Status == StatusPass && expected == StatusFail:
+			improvements = append(improvements, r.Workload)
+		}
+	}
+	sort.Strings(regressions)
+	sort.Strings(improvements)
+	return regressions, improvements
+}
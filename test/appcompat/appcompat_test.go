@@ -0,0 +1,91 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package appcompat
+
+import (
+	"flag"
+	"path/filepath"
+	"testing"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+var updateBaseline = flag.Bool("update-baseline", false,
+	"Write the results of the workloads to the baseline, instead of "+
+		"comparing against it.")
+
+// testWorkloads runs the default workloads in `dir`, and compares the
+// results against the baseline for `engine`.
+func testWorkloads(t *testing.T, engine, dir string) {
+	results := Run(context.Background(), dir, DefaultWorkloads)
+	for _, r := range results {
+		t.Logf("%s: %s (%s) %s", r.Workload, r.Status, r.Duration, r.Err)
+	}
+
+	filename := filepath.Join("testdata", "baseline_"+engine+".json")
+	if *updateBaseline {
+		err := MakeBaseline(results).Write(filename)
+		require.NoError(t, err)
+		return
+	}
+
+	baseline, err := ReadBaseline(filename)
+	require.NoError(t, err)
+	regressions, improvements := baseline.Compare(results)
+	for _, name := range improvements {
+		t.Logf("%s now passes; run with -update-baseline to record it",
+			name)
+	}
+	if len(regressions) > 0 {
+		t.Errorf("Regressions against %s:", filename)
+		for _, r := range regressions {
+			t.Errorf("  %s", r)
+		}
+	}
+}
+
+func TestLocalWorkloads(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping app workloads in short mode")
+	}
+	dir, err := ioutil.TempDir("", "appcompat")
+	require.NoError(t, err)
+	defer func() {
+		err := ioutil.RemoveAll(dir)
+		require.NoError(t, err)
+	}()
+	testWorkloads(t, "local", dir)
+}
+
+func TestBaselineCompare(t *testing.T) {
+	baseline := Baseline{
+		"a": StatusPass,
+		"b": StatusFail,
+		"c": StatusPass,
+		"d": StatusFail,
+	}
+	results := []Result{
+		{Workload: "a", Status: StatusFail, Err: "broken"},
+		{Workload: "b", Status: StatusPass},
+		{Workload: "c", Status: StatusSkip},
+		{Workload: "d", Status: StatusFail},
+		{Workload: "e", Status: StatusPass},
+	}
+	regressions, improvements := baseline.Compare(results)
+	require.Equal(t, []string{
+		"a: broken",
+		"e: not in the baseline (got pass)",
+	}, regressions)
+	require.Equal(t, []string{"b"}, improvements)
+
+	require.Equal(t, Baseline{
+		"a": StatusFail,
+		"b": StatusPass,
+		"d": StatusFail,
+		"e": StatusPass,
+	}, MakeBaseline(results))
+}
@@ -0,0 +1,46 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build dokan
+
+package appcompat
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/keybase/client/go/logger"
+	"github.com/keybase/kbfs/dokan"
+	"github.com/keybase/kbfs/libdokan"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+// dokanDriveLetter is the drive the workloads mount KBFS on.  The
+// test package mounts its users from T: onwards.
+const dokanDriveLetter = 'S'
+
+func TestDokanWorkloads(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	filesys, err := libdokan.NewFS(ctx, config, logger.NewTestLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mnt, err := dokan.Mount(&dokan.Config{
+		FileSystem: filesys,
+		Path:       string([]byte{dokanDriveLetter, ':'}),
+		MountFlags: libdokan.DefaultMountFlags,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dokan.Unmount(mnt.Dir)
+
+	testWorkloads(t, "dokan", filepath.Join(mnt.Dir, "private", "jdoe"))
+}
@@ -0,0 +1,50 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// +build fuse
+
+package appcompat
+
+import (
+	"path/filepath"
+	"testing"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"bazil.org/fuse/fs/fstestutil"
+	"github.com/keybase/kbfs/libfuse"
+	"github.com/keybase/kbfs/libkbfs"
+	"golang.org/x/net/context"
+)
+
+func TestFuseWorkloads(t *testing.T) {
+	ctx := libkbfs.BackgroundContextWithCancellationDelayer()
+	config := libkbfs.MakeTestConfigOrBust(t, "jdoe")
+	defer libkbfs.CheckConfigAndShutdown(ctx, t, config)
+
+	filesys := libfuse.NewFS(config, nil, false, libfuse.PlatformParams{})
+	fn := func(mnt *fstestutil.Mount) fs.FS {
+		filesys.SetFuseConn(mnt.Server, mnt.Conn)
+		return filesys
+	}
+	options := libfuse.GetPlatformSpecificMountOptionsForTest()
+	mnt, err := fstestutil.MountedFuncT(t, fn, &fs.Config{
+		WithContext: func(ctx context.Context, req fuse.Request) context.Context {
+			return filesys.WithContext(ctx)
+		},
+	}, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mnt.Close()
+
+	// The FUSE serve loop is terminated by unmounting the file
+	// system, and canceling the context stops notification
+	// processing.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	filesys.LaunchNotificationProcessor(ctx)
+
+	testWorkloads(t, "fuse", filepath.Join(mnt.Dir, "private", "jdoe"))
+}
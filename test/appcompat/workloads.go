@@ -0,0 +1,396 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package appcompat
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/keybase/kbfs/ioutil"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// DefaultWorkloads are the workloads run against every mount.
+var DefaultWorkloads = []Workload{
+	{Name: "git", Tools: []string{"git"}, Run: runGit},
+	{Name: "rsync", Tools: []string{"rsync"}, Run: runRsync},
+	{Name: "sqlite-rollback", Tools: []string{"sqlite3"}, Run: runSqliteRollback},
+	{Name: "sqlite-wal", Tools: []string{"sqlite3"}, Run: runSqliteWAL},
+	{Name: "tar", Tools: []string{"tar"}, Run: runTar},
+	{Name: "office-save", Run: runOfficeSave},
+	{Name: "atomic-replace", Run: runAtomicReplace},
+}
+
+// command runs a tool in `dir`, and returns its standard output.  The
+// error includes the standard error of the tool.
+func command(ctx context.Context, dir, name string, args ...string) (
+	string, error) {
+	return commandWithInput(ctx, dir, "", name, args...)
+}
+
+// commandWithInput is like command, but feeds `input` to the standard
+// input of the tool.
+func commandWithInput(ctx context.Context, dir, input, name string,
+	args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return "", errors.Errorf("%s %s: %v: %s", name,
+			strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// makeTree fills `dir` with a small tree of files, including an
+// executable and an empty directory.
+func makeTree(dir string) error {
+	files := map[string]string{
+		"README":          "appcompat\n",
+		"a/b/c.txt":       strings.Repeat("c", 100000),
+		"a/d.txt":         "d\n",
+		"bin/run.sh":      "#!/bin/sh\necho run\n",
+		"unicode/ü ñ.txt": "unicode\n",
+	}
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		err := ioutil.MkdirAll(filepath.Dir(p), 0755)
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(0644)
+		if strings.HasSuffix(name, ".sh") {
+			mode = 0755
+		}
+		err = ioutil.WriteFile(p, []byte(data), mode)
+		if err != nil {
+			return err
+		}
+	}
+	return ioutil.MkdirAll(filepath.Join(dir, "empty"), 0755)
+}
+
+// compareTrees returns an error if the two trees don't have the same
+// directories, file contents and executable bits.
+func compareTrees(expected, actual string) error {
+	seen := make(map[string]bool)
+	err := filepath.Walk(expected, func(
+		p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(expected, p)
+		if err != nil {
+			return err
+		}
+		seen[rel] = true
+		afi, err := ioutil.Lstat(filepath.Join(actual, rel))
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() != afi.IsDir() {
+			return errors.Errorf("%s: directory mismatch", rel)
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		if fi.Mode()&0100 != afi.Mode()&0100 {
+			return errors.Errorf("%s: mode %s, expected %s",
+				rel, afi.Mode(), fi.Mode())
+		}
+		eBuf, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		aBuf, err := ioutil.ReadFile(filepath.Join(actual, rel))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(eBuf, aBuf) {
+			return errors.Errorf("%s: contents differ", rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return filepath.Walk(actual, func(
+		p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(actual, p)
+		if err != nil {
+			return err
+		}
+		if !seen[rel] {
+			return errors.Errorf("%s: unexpected entry", rel)
+		}
+		return nil
+	})
+}
+
+// runGit makes a repository with a few commits and branches, packs
+// it and checks its integrity.
+func runGit(ctx context.Context, dir, scratch string) error {
+	git := func(args ...string) (string, error) {
+		args = append([]string{
+			"-c", "user.name=appcompat", "-c", "user.email=appcompat@keybase.io",
+			"-c", "commit.gpgsign=false",
+		}, args...)
+		return command(ctx, dir, "git", args...)
+	}
+	if _, err := git("init", "-q", "."); err != nil {
+		return err
+	}
+	if err := makeTree(dir); err != nil {
+		return err
+	}
+	if _, err := git("add", "-A"); err != nil {
+		return err
+	}
+	if _, err := git("commit", "-q", "-m", "first"); err != nil {
+		return err
+	}
+	if _, err := git("checkout", "-q", "-b", "branch"); err != nil {
+		return err
+	}
+	err := ioutil.WriteFile(
+		filepath.Join(dir, "a", "d.txt"), []byte("branch\n"), 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := git("commit", "-q", "-a", "-m", "second"); err != nil {
+		return err
+	}
+	if _, err := git("gc", "-q"); err != nil {
+		return err
+	}
+	if _, err := git("fsck", "--full", "--strict"); err != nil {
+		return err
+	}
+	out, err := git("rev-list", "--count", "HEAD")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(out) != "2" {
+		return errors.Errorf("%q commits, expected 2", strings.TrimSpace(out))
+	}
+	if out, err = git("status", "--porcelain"); err != nil {
+		return err
+	} else if out != "" {
+		return errors.Errorf("unexpected changes: %s", out)
+	}
+	return nil
+}
+
+// runRsync copies a tree in, then changes it and copies only the
+// changes, which rsync writes to temporary files and renames into
+// place.
+func runRsync(ctx context.Context, dir, scratch string) error {
+	src := filepath.Join(scratch, "src")
+	if err := makeTree(src); err != nil {
+		return err
+	}
+	for i := 0; i < 2; i++ {
+		_, err := command(ctx, scratch, "rsync", "-a", "--delete",
+			src+string(filepath.Separator), dir)
+		if err != nil {
+			return err
+		}
+		if err := compareTrees(src, dir); err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(filepath.Join(src, "a", "b", "c.txt"),
+			[]byte(strings.Repeat("changed", 10000)), 0644)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.RemoveAll(filepath.Join(src, "unicode")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runSqlite(ctx context.Context, dir, journalMode string) error {
+	sql := fmt.Sprintf("PRAGMA journal_mode=%s;"+
+		"CREATE TABLE t (k INTEGER PRIMARY KEY, v TEXT);", journalMode)
+	for i := 0; i < 100; i++ {
+		sql += fmt.Sprintf(
+			"BEGIN; INSERT INTO t (v) VALUES ('%s'); COMMIT;",
+			strings.Repeat("v", i*100))
+	}
+	sql += "DELETE FROM t WHERE k % 2 = 0; VACUUM;"
+	_, err := commandWithInput(ctx, dir, sql, "sqlite3", "test.db")
+	if err != nil {
+		return err
+	}
+	out, err := command(ctx, dir, "sqlite3", "test.db",
+		"PRAGMA integrity_check; SELECT COUNT(*) FROM t;")
+	if err != nil {
+		return err
+	}
+	if out != "ok\n50\n" {
+		return errors.Errorf("unexpected check output %q", out)
+	}
+	return nil
+}
+
+// runSqliteRollback uses a database with a rollback journal, which
+// sqlite creates and deletes next to the database around every
+// transaction.
+func runSqliteRollback(ctx context.Context, dir, scratch string) error {
+	return runSqlite(ctx, dir, "DELETE")
+}
+
+// runSqliteWAL uses a database with a write-ahead log, which needs
+// shared memory mappings of a file next to the database.
+func runSqliteWAL(ctx context.Context, dir, scratch string) error {
+	return runSqlite(ctx, dir, "WAL")
+}
+
+// runTar extracts an archive into the directory, then archives the
+// extracted tree again and checks both copies.
+func runTar(ctx context.Context, dir, scratch string) error {
+	src := filepath.Join(scratch, "src")
+	if err := makeTree(src); err != nil {
+		return err
+	}
+	archive := filepath.Join(scratch, "in.tar")
+	_, err := command(ctx, src, "tar", "-cf", archive, ".")
+	if err != nil {
+		return err
+	}
+	out := filepath.Join(dir, "out")
+	if err := ioutil.Mkdir(out, 0755); err != nil {
+		return err
+	}
+	if _, err := command(ctx, out, "tar", "-xpf", archive); err != nil {
+		return err
+	}
+	if err := compareTrees(src, out); err != nil {
+		return err
+	}
+
+	rearchive := filepath.Join(dir, "out.tar")
+	if _, err := command(ctx, out, "tar", "-cf", rearchive, "."); err != nil {
+		return err
+	}
+	check := filepath.Join(scratch, "check")
+	if err := ioutil.Mkdir(check, 0755); err != nil {
+		return err
+	}
+	if _, err := command(ctx, check, "tar", "-xpf", rearchive); err != nil {
+		return err
+	}
+	return compareTrees(src, check)
+}
+
+// runOfficeSave saves a document like Microsoft Office does: it takes
+// a lock file, writes the new version to a temporary file, renames
+// the original out of the way, renames the temporary file into place,
+// and then deletes the original and the lock file.
+func runOfficeSave(ctx context.Context, dir, scratch string) error {
+	doc := filepath.Join(dir, "report.docx")
+	if err := ioutil.WriteFile(doc, []byte("version 0"), 0644); err != nil {
+		return err
+	}
+	for i := 1; i <= 3; i++ {
+		lock := filepath.Join(dir, "~$report.docx")
+		f, err := ioutil.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write([]byte("appcompat")); err != nil {
+			_ = f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+
+		tmp := filepath.Join(dir, fmt.Sprintf("~WRD%04d.tmp", i))
+		data := []byte(fmt.Sprintf("version %d", i))
+		if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+			return err
+		}
+		backup := filepath.Join(dir, fmt.Sprintf("~WRL%04d.tmp", i))
+		if err := ioutil.Rename(doc, backup); err != nil {
+			return err
+		}
+		if err := ioutil.Rename(tmp, doc); err != nil {
+			return err
+		}
+		if err := ioutil.Remove(backup); err != nil {
+			return err
+		}
+		if err := ioutil.Remove(lock); err != nil {
+			return err
+		}
+
+		buf, err := ioutil.ReadFile(doc)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(buf, data) {
+			return errors.Errorf("read %q after save %d", buf, i)
+		}
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(fis) != 1 {
+		return errors.Errorf("%d entries left after saving, expected 1",
+			len(fis))
+	}
+	return nil
+}
+
+// runAtomicReplace saves a file like most editors do, by writing a
+// temporary file, syncing it and renaming it over the original.
+func runAtomicReplace(ctx context.Context, dir, scratch string) error {
+	name := filepath.Join(dir, "config.json")
+	for i := 0; i < 10; i++ {
+		data := []byte(fmt.Sprintf(`{"version": %d}`, i))
+		tmp := name + ".tmp"
+		f, err := ioutil.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(data); err != nil {
+			_ = f.Close()
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			_ = f.Close()
+			return errors.WithStack(err)
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		if err := ioutil.Rename(tmp, name); err != nil {
+			return err
+		}
+		buf, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(buf, data) {
+			return errors.Errorf("read %q after replace %d", buf, i)
+		}
+	}
+	return nil
+}
@@ -6,6 +6,10 @@
 // go test -test.bench=. -benchmem
 // go test -test.bench=. -benchmem -tags fuse
 // go test -test.bench=. -benchmem -tags dokan
+//
+// To run them against a realistic network instead of an instant
+// in-memory block server, set KEYBASE_TEST_BSERVER_PROFILE to lan,
+// broadband or mobile.
 
 package test
 
@@ -361,3 +361,33 @@ func TestCrRemoveMultilevelMultiblockDir(t *testing.T) {
 func TestCrDoubleResolutionMultiblock(t *testing.T) {
 	testCrDoubleResolution(t, 20)
 }
+
+// bob and alice both write to the same multi-block file
+func TestCrConflictWriteMultiblockFile(t *testing.T) {
+	test(t,
+		blockSize(20), users("alice", "bob"),
+		as(alice,
+			write("a/b", ntimesString(15, "0123456789")),
+		),
+		as(bob,
+			// Cache the whole file before copying it during CR.
+			read("a/b", ntimesString(15, "0123456789")),
+			disableUpdates(),
+		),
+		as(alice,
+			pwriteBS("a/b", []byte("x"), 147),
+		),
+		as(bob, noSync(),
+			pwriteBS("a/b", []byte("y"), 147),
+			reenableUpdates(),
+			lsdir("a/", m{"b$": "FILE", crnameEsc("b", bob): "FILE"}),
+			preadBS("a/b", []byte("x89"), 147),
+			preadBS(crname("a/b", bob), []byte("y89"), 147),
+		),
+		as(alice,
+			lsdir("a/", m{"b$": "FILE", crnameEsc("b", bob): "FILE"}),
+			preadBS("a/b", []byte("x89"), 147),
+			preadBS(crname("a/b", bob), []byte("y89"), 147),
+		),
+	)
+}
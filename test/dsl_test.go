@@ -45,6 +45,7 @@ type opt struct {
 	tlfTime                  string
 	tlfRelTime               string
 	users                    map[kbname.NormalizedUsername]User
+	clientVers               map[kbname.NormalizedUsername]clientGeneration
 	stallers                 map[kbname.NormalizedUsername]*libkbfs.NaïveStaller
 	tb                       testing.TB
 	initOnce                 sync.Once
@@ -153,11 +154,31 @@ func (el errorList) Error() string {
 }
 
 func (o *opt) close() {
+	// Only the first user to shut down checks the folder state, so
+	// shut down users acting like older clients last; they may not be
+	// able to read everything.
+	usernames := make([]kbname.NormalizedUsername, 0, len(o.users))
+	for u := range o.users {
+		usernames = append(usernames, u)
+	}
+	sort.Slice(usernames, func(i, j int) bool {
+		vi, iOld := o.clientVers[usernames[i]]
+		vj, jOld := o.clientVers[usernames[j]]
+		switch {
+		case iOld != jOld:
+			return jOld
+		case vi.mdVer != vj.mdVer:
+			return vi.mdVer > vj.mdVer
+		default:
+			return vi.dataVer > vj.dataVer
+		}
+	})
+
 	var el []error
 	// Make sure Shutdown is called properly for every user, even
 	// if any of the calls fail.
-	for _, user := range o.users {
-		err := o.engine.Shutdown(user)
+	for _, u := range usernames {
+		err := o.engine.Shutdown(o.users[u])
 		if err != nil {
 			el = append(el, err)
 		}
@@ -413,6 +434,25 @@ func changeTeamName(oldName, newName string) optionOp {
 	}
 }
 
+// clientVersions makes the given user act like a client that only
+// understands metadata up to mdVer and data up to dataVer.  Since it
+// initializes the users, it must come after all other test options.
+func clientVersions(user username, mdVer kbfsmd.MetadataVer,
+	dataVer libkbfs.DataVer) optionOp {
+	return func(o *opt) {
+		o.runInitOnce()
+		o.tb.Logf("clientVersions: %s -> md=%s, data=%d",
+			user, mdVer, dataVer)
+		u := kbname.NewNormalizedUsername(string(user))
+		err := o.engine.SetClientVersions(o.users[u], mdVer, dataVer)
+		o.expectSuccess("clientVersions", err)
+		if o.clientVers == nil {
+			o.clientVers = make(map[kbname.NormalizedUsername]clientGeneration)
+		}
+		o.clientVers[u] = clientGeneration{mdVer, dataVer}
+	}
+}
+
 type fileOp struct {
 	operation   func(*ctx) error
 	flags       fileOpFlags
@@ -997,6 +1037,19 @@ func checkUnflushedPaths(expectedPaths []string) fileOp {
 	}, IsInit, fmt.Sprintf("checkUnflushedPaths(%s)", expectedPaths)}
 }
 
+func checkMDVersion(expected kbfsmd.MetadataVer) fileOp {
+	return fileOp{func(c *ctx) error {
+		ver, err := c.engine.GetMDVersion(c.user, c.tlfName, c.tlfType)
+		if err != nil {
+			return err
+		}
+		if ver != expected {
+			return fmt.Errorf("Expected MD version %s, got %s", expected, ver)
+		}
+		return nil
+	}, IsInit, fmt.Sprintf("checkMDVersion(%s)", expected)}
+}
+
 func checkPrevRevisions(filepath string, counts []uint8) fileOp {
 	return fileOp{func(c *ctx) error {
 		file, _, err := c.getNode(filepath, noCreate, resolveAllSyms)
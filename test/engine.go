@@ -141,6 +141,21 @@ type Engine interface {
 	// ForceQuotaReclamation starts quota reclamation by the given
 	// user in the TLF corresponding to the given node.
 	ForceQuotaReclamation(u User, tlfName string, t tlf.Type) (err error)
+	// CheckState checks, as the given user, that the server-side
+	// state of the given TLF is consistent: the blocks referenced by
+	// its MD history are exactly the blocks the block server has
+	// references for, and the disk usage recorded in the latest MD
+	// adds up to their sizes.  The user must be fully synced.
+	CheckState(u User, tlfName string, t tlf.Type) (err error)
+	// SetClientVersions makes the given user act like a client that
+	// writes new metadata using mdVer, and that can't read metadata
+	// newer than mdVer or data newer than dataVer.
+	SetClientVersions(u User, mdVer kbfsmd.MetadataVer,
+		dataVer libkbfs.DataVer) (err error)
+	// GetMDVersion returns the metadata version of the latest
+	// revision of the given TLF, as read by the given user.
+	GetMDVersion(u User, tlfName string, t tlf.Type) (
+		ver kbfsmd.MetadataVer, err error)
 	// AddNewAssertion makes newAssertion, which should be a
 	// single assertion that doesn't already resolve to anything,
 	// resolve to the same UID as oldAssertion, which should be an
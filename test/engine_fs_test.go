@@ -366,6 +366,34 @@ func (*fsEngine) ForceQuotaReclamation(user User, tlfName string, t tlf.Type) (e
 		[]byte("x"), 0644)
 }
 
+// CheckState implements the Engine interface.
+func (*fsEngine) CheckState(user User, tlfName string, t tlf.Type) error {
+	u := user.(*fsUser)
+	ctx := context.Background()
+	dir, err := getRootNode(ctx, u.config, tlfName, t)
+	if err != nil {
+		return err
+	}
+	return libkbfs.NewStateChecker(u.config).CheckMergedState(
+		ctx, dir.GetFolderBranch().Tlf)
+}
+
+// SetClientVersions implements the Engine interface.
+func (*fsEngine) SetClientVersions(user User, mdVer kbfsmd.MetadataVer,
+	dataVer libkbfs.DataVer) error {
+	u := user.(*fsUser)
+	u.config.SetMetadataVersion(mdVer)
+	u.config.SetDataVersion(dataVer)
+	return nil
+}
+
+// GetMDVersion implements the Engine interface.
+func (*fsEngine) GetMDVersion(user User, tlfName string, t tlf.Type) (
+	kbfsmd.MetadataVer, error) {
+	u := user.(*fsUser)
+	return getMDVersion(context.Background(), u.config, tlfName, t)
+}
+
 // AddNewAssertion implements the Engine interface.
 func (e *fsEngine) AddNewAssertion(user User, oldAssertion, newAssertion string) error {
 	u := user.(*fsUser)
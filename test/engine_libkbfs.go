@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -26,7 +27,8 @@ import (
 // LibKBFS implements the Engine interface for direct test harness usage of libkbfs.
 type LibKBFS struct {
 	// hack: hold references on behalf of the test harness
-	refs map[libkbfs.Config]map[libkbfs.Node]bool
+	refsLock sync.Mutex
+	refs     map[libkbfs.Config]map[libkbfs.Node]bool
 	// channels used to re-enable updates if disabled
 	updateChannels map[libkbfs.Config]map[libkbfs.FolderBranch]chan<- struct{}
 	// test object, for logging.
@@ -267,7 +269,7 @@ func (k *LibKBFS) getRootDir(
 		return nil, err
 	}
 
-	k.refs[config][dir.(libkbfs.Node)] = true
+	k.addRef(config, dir.(libkbfs.Node))
 	return dir, nil
 }
 
@@ -329,6 +331,15 @@ func (k *LibKBFS) GetRootDirAtRelTimeString(
 		u, tlfName, t, libkbfs.MakeRevBranchName(rev), expectedCanonicalTlfName)
 }
 
+// addRef holds a reference to the given node, so that it stays in
+// the node cache until the user is shut down.  Ops may run
+// concurrently, so this takes refsLock.
+func (k *LibKBFS) addRef(config libkbfs.Config, n libkbfs.Node) {
+	k.refsLock.Lock()
+	defer k.refsLock.Unlock()
+	k.refs[config][n] = true
+}
+
 // CreateDir implements the Engine interface.
 func (k *LibKBFS) CreateDir(u User, parentDir Node, name string) (dir Node, err error) {
 	config := u.(*libkbfs.ConfigLocal)
@@ -339,7 +350,7 @@ func (k *LibKBFS) CreateDir(u User, parentDir Node, name string) (dir Node, err
 	if err != nil {
 		return dir, err
 	}
-	k.refs[config][dir.(libkbfs.Node)] = true
+	k.addRef(config, dir.(libkbfs.Node))
 	return dir, nil
 }
 
@@ -354,7 +365,7 @@ func (k *LibKBFS) CreateFile(u User, parentDir Node, name string) (file Node, er
 	if err != nil {
 		return file, err
 	}
-	k.refs[config][file.(libkbfs.Node)] = true
+	k.addRef(config, file.(libkbfs.Node))
 	return file, nil
 }
 
@@ -368,7 +379,7 @@ func (k *LibKBFS) CreateFileExcl(u User, parentDir Node, name string) (file Node
 	if err != nil {
 		return nil, err
 	}
-	k.refs[config][file.(libkbfs.Node)] = true
+	k.addRef(config, file.(libkbfs.Node))
 	return file, nil
 }
 
@@ -470,7 +481,7 @@ func (k *LibKBFS) Lookup(u User, parentDir Node, name string) (file Node, symPat
 		return file, symPath, err
 	}
 	if file != nil {
-		k.refs[config][file.(libkbfs.Node)] = true
+		k.addRef(config, file.(libkbfs.Node))
 	}
 	if ei.Type == libkbfs.Sym {
 		symPath = ei.SymPath
@@ -593,6 +604,23 @@ func getRootNode(ctx context.Context, config libkbfs.Config, tlfName string,
 	return dir, nil
 }
 
+// getMDVersion returns the metadata version of the latest revision
+// of the given TLF.
+func getMDVersion(ctx context.Context, config libkbfs.Config,
+	tlfName string, t tlf.Type) (kbfsmd.MetadataVer, error) {
+	dir, err := getRootNode(ctx, config, tlfName, t)
+	if err != nil {
+		return kbfsmd.FirstValidMetadataVer, err
+	}
+
+	md, err := config.MDOps().GetForTLF(
+		ctx, dir.GetFolderBranch().Tlf, nil)
+	if err != nil {
+		return kbfsmd.FirstValidMetadataVer, err
+	}
+	return md.Version(), nil
+}
+
 // DisableUpdatesForTesting implements the Engine interface.
 func (k *LibKBFS) DisableUpdatesForTesting(u User, tlfName string, t tlf.Type) (err error) {
 	config := u.(*libkbfs.ConfigLocal)
@@ -690,6 +718,40 @@ func (k *LibKBFS) ForceQuotaReclamation(u User, tlfName string, t tlf.Type) (err
 		config, dir.GetFolderBranch())
 }
 
+// CheckState implements the Engine interface.
+func (k *LibKBFS) CheckState(u User, tlfName string, t tlf.Type) (err error) {
+	config := u.(*libkbfs.ConfigLocal)
+
+	ctx, cancel := k.newContext(u)
+	defer cancel()
+	dir, err := getRootNode(ctx, config, tlfName, t)
+	if err != nil {
+		return err
+	}
+
+	return libkbfs.NewStateChecker(config).CheckMergedState(
+		ctx, dir.GetFolderBranch().Tlf)
+}
+
+// SetClientVersions implements the Engine interface.
+func (k *LibKBFS) SetClientVersions(u User, mdVer kbfsmd.MetadataVer,
+	dataVer libkbfs.DataVer) (err error) {
+	config := u.(*libkbfs.ConfigLocal)
+	config.SetMetadataVersion(mdVer)
+	config.SetDataVersion(dataVer)
+	return nil
+}
+
+// GetMDVersion implements the Engine interface.
+func (k *LibKBFS) GetMDVersion(u User, tlfName string, t tlf.Type) (
+	ver kbfsmd.MetadataVer, err error) {
+	config := u.(*libkbfs.ConfigLocal)
+
+	ctx, cancel := k.newContext(u)
+	defer cancel()
+	return getMDVersion(ctx, config, tlfName, t)
+}
+
 // AddNewAssertion implements the Engine interface.
 func (k *LibKBFS) AddNewAssertion(u User, oldAssertion, newAssertion string) error {
 	config := u.(*libkbfs.ConfigLocal)
@@ -871,8 +933,10 @@ func (k *LibKBFS) TogglePrefetch(u User, enable bool) error {
 func (k *LibKBFS) Shutdown(u User) error {
 	config := u.(*libkbfs.ConfigLocal)
 	// drop references
+	k.refsLock.Lock()
 	k.refs[config] = make(map[libkbfs.Node]bool)
 	delete(k.refs, config)
+	k.refsLock.Unlock()
 	// clear update channels
 	k.updateChannels[config] = make(map[libkbfs.FolderBranch]chan<- struct{})
 	delete(k.updateChannels, config)
@@ -74,6 +74,26 @@ func TestRmMultiblockFile(t *testing.T) {
 	)
 }
 
+// bob removes a multiblock file written by alice, while he has
+// unsynced writes to it (checks that state is cleaned up)
+func TestRmDirtyMultiblockFile(t *testing.T) {
+	test(t,
+		blockSize(20), users("alice", "bob"),
+		as(alice,
+			write("a/b", ntimesString(15, "0123456789")),
+		),
+		as(bob,
+			read("a/b", ntimesString(15, "0123456789")),
+			pwriteBSSync("a/b", []byte(ntimesString(5, "abcdefghij")),
+				100, false),
+			rm("a/b"),
+		),
+		as(alice,
+			lsdir("a/", m{}),
+		),
+	)
+}
+
 // bob renames something over a multiblock file written by alice
 // (checks that state is cleaned up)
 func TestRenameOverMultiblockFile(t *testing.T) {
@@ -0,0 +1,244 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// perfcompare runs the KBFS benchmarks in the test package against
+// two builds of kbfs, over the same simulated network, and reports
+// which benchmarks got significantly faster or slower.
+//
+// Each build is a git revision, checked out in its own worktree and
+// compiled into a test binary.  The binaries then take turns running
+// each benchmark once, `-count` times over, so that drift in the load
+// of the machine hits both builds alike.  The samples of each build
+// are compared with the Mann-Whitney U test, and the command fails if
+// any benchmark regressed significantly by more than `-threshold`
+// percent, so it can gate releases.  Users who see a slowdown can run
+// it between two releases and attach the report to a bug.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+)
+
+var (
+	oldRev = flag.String("old", "", "The git revision of the baseline build")
+	newRev = flag.String("new", "HEAD",
+		"The git revision of the build to compare against the baseline")
+	bench = flag.String("bench", ".",
+		"Run only the benchmarks matching this regexp")
+	benchTime = flag.String("benchtime", "",
+		"The -benchtime to run each benchmark with")
+	count = flag.Int("count", 10,
+		"The number of times to run each benchmark on each build")
+	profile = flag.String("profile", "broadband",
+		"The simulated network for both builds (lan, broadband, mobile, "+
+			"or none for an instant in-memory block server)")
+	tags = flag.String("tags", "",
+		"Build tags for the test binaries, e.g. fuse")
+	alpha = flag.Float64("alpha", 0.05,
+		"The significance level; differences with a higher p-value "+
+			"are reported as ~")
+	threshold = flag.Float64("threshold", 5,
+		"The smallest significant slowdown, in percent, that fails "+
+			"the comparison")
+	jsonOut = flag.String("json", "",
+		"Also write the comparisons as JSON to this file")
+	keep = flag.Bool("keep", false,
+		"Keep the worktrees and test binaries, and print where they are")
+)
+
+const usageStr = `Usage:
+  perfcompare -old <revision> [-new <revision>] [flags]
+
+Runs the benchmarks of the kbfs test package against both revisions
+and reports the differences, e.g.:
+
+  perfcompare -old v1.0.40 -new v1.0.41 -bench 'Write|Read' -count 20
+
+Flags:
+`
+
+// Report is the JSON form of a comparison.
+type Report struct {
+	Old         string
+	New         string
+	Profile     string
+	Count       int
+	Comparisons []Comparison
+}
+
+func runCmd(dir string, env []string, stdout io.Writer,
+	name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = stdout
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "%s %s: %s",
+			name, strings.Join(args, " "), stderr.String())
+	}
+	return nil
+}
+
+// build checks out `rev` of the repo at `repoDir` into a worktree
+// under `tmpDir`, and compiles the test package there into a test
+// binary, whose path it returns.
+func build(repoDir, tmpDir, name, rev string) (string, error) {
+	worktree := filepath.Join(tmpDir, name)
+	err := runCmd(repoDir, nil, nil,
+		"git", "worktree", "add", "--detach", worktree, rev)
+	if err != nil {
+		return "", err
+	}
+	// Put the binary next to the package sources, since the
+	// benchmarks may look for files relative to them.
+	binary := filepath.Join(worktree, "test", "kbfstest.test")
+	args := []string{"test", "-c", "-o", binary}
+	if *tags != "" {
+		args = append(args, "-tags", *tags)
+	}
+	args = append(args, "./test")
+	fmt.Fprintf(os.Stderr, "Building %s (%s)...\n", name, rev)
+	if err := runCmd(worktree, nil, nil, "go", args...); err != nil {
+		return "", err
+	}
+	return binary, nil
+}
+
+// runBench runs the benchmarks once with the given test binary, and
+// adds the results to `samples`.
+func runBench(binary string, samples Samples) error {
+	args := []string{
+		"-test.run", "XXX", "-test.bench", *bench, "-test.benchmem",
+		"-test.count", "1",
+	}
+	if *benchTime != "" {
+		args = append(args, "-test.benchtime", *benchTime)
+	}
+	var env []string
+	if *profile != "none" {
+		env = append(env, libkbfs.EnvTestBServerProfile+"="+*profile)
+	}
+	var out bytes.Buffer
+	err := runCmd(filepath.Dir(binary), env, &out, binary, args...)
+	if err != nil {
+		return err
+	}
+	return samples.ParseBenchOutput(&out)
+}
+
+func compare() (regressions int, err error) {
+	repoDir, err := os.Getwd()
+	if err != nil {
+		return 0, err
+	}
+	tmpDir, err := ioutil.TempDir("", "kbfs_perfcompare")
+	if err != nil {
+		return 0, err
+	}
+	if *keep {
+		fmt.Fprintf(os.Stderr, "Keeping builds in %s\n", tmpDir)
+	} else {
+		defer func() {
+			_ = runCmd(repoDir, nil, nil, "git", "worktree", "prune")
+		}()
+		defer os.RemoveAll(tmpDir)
+	}
+
+	oldBinary, err := build(repoDir, tmpDir, "old", *oldRev)
+	if err != nil {
+		return 0, err
+	}
+	newBinary, err := build(repoDir, tmpDir, "new", *newRev)
+	if err != nil {
+		return 0, err
+	}
+
+	oldSamples, newSamples := make(Samples), make(Samples)
+	for i := 0; i < *count; i++ {
+		fmt.Fprintf(os.Stderr, "Run %d of %d...\n", i+1, *count)
+		// Alternate which build goes first, so that neither
+		// always runs on a warmer machine.
+		first, second := oldBinary, newBinary
+		firstSamples, secondSamples := oldSamples, newSamples
+		if i%2 == 1 {
+			first, second = second, first
+			firstSamples, secondSamples = secondSamples, firstSamples
+		}
+		if err := runBench(first, firstSamples); err != nil {
+			return 0, err
+		}
+		if err := runBench(second, secondSamples); err != nil {
+			return 0, err
+		}
+	}
+
+	comparisons := Compare(oldSamples, newSamples, *alpha, *threshold)
+	if len(comparisons) == 0 {
+		return 0, errors.Errorf("No benchmarks matching %q ran on both "+
+			"builds", *bench)
+	}
+	if err := WriteReport(os.Stdout, *oldRev, *newRev, comparisons); err != nil {
+		return 0, err
+	}
+	if *jsonOut != "" {
+		report := Report{
+			Old:         *oldRev,
+			New:         *newRev,
+			Profile:     *profile,
+			Count:       *count,
+			Comparisons: comparisons,
+		}
+		buf, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return 0, err
+		}
+		if err := ioutil.WriteFile(*jsonOut, buf, 0644); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, c := range comparisons {
+		if c.Regression {
+			regressions++
+		}
+	}
+	return regressions, nil
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usageStr)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *oldRev == "" || len(flag.Args()) > 0 || *count < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	regressions, err := compare()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "perfcompare: %+v\n", err)
+		os.Exit(2)
+	}
+	if regressions > 0 {
+		fmt.Fprintf(os.Stderr, "%d significant regression(s) of more "+
+			"than %g%%\n", regressions, *threshold)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Samples holds every measurement of each benchmark, by benchmark
+// name and then by unit (e.g., "ns/op" or "allocs/op").
+type Samples map[string]map[string][]float64
+
+func (s Samples) add(name, unit string, value float64) {
+	units, ok := s[name]
+	if !ok {
+		units = make(map[string][]float64)
+		s[name] = units
+	}
+	units[unit] = append(units[unit], value)
+}
+
+// ParseBenchOutput adds the results in the output of `go test
+// -bench` to `s`.  The GOMAXPROCS suffix of benchmark names is
+// dropped, so that runs on different machines can be compared.
+func (s Samples) ParseBenchOutput(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") {
+			continue
+		}
+		name := fields[0]
+		if i := strings.LastIndex(name, "-"); i > 0 {
+			if _, err := strconv.Atoi(name[i+1:]); err == nil {
+				name = name[:i]
+			}
+		}
+		if _, err := strconv.Atoi(fields[1]); err != nil {
+			// Not a result line, e.g. a log line.
+			continue
+		}
+		for i := 2; i+1 < len(fields); i += 2 {
+			value, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return fmt.Errorf("Bad value %q for %s", fields[i], name)
+			}
+			s.add(name, fields[i+1], value)
+		}
+	}
+	return scanner.Err()
+}
+
+// higherIsBetter returns true if bigger values of `unit` mean better
+// performance.
+func higherIsBetter(unit string) bool {
+	return strings.HasSuffix(unit, "/s")
+}
+
+func mean(xs []float64) float64 {
+	sum := 0.0
+	for _, x := range xs {
+		sum += x
+	}
+	return sum / float64(len(xs))
+}
+
+func stddev(xs []float64) float64 {
+	if len(xs) < 2 {
+		return 0
+	}
+	m := mean(xs)
+	sum := 0.0
+	for _, x := range xs {
+		sum += (x - m) * (x - m)
+	}
+	return math.Sqrt(sum / float64(len(xs)-1))
+}
+
+// mannWhitneyU returns the two-sided p-value of the Mann-Whitney U
+// test of whether `a` and `b` come from the same distribution.  It
+// doesn't assume the samples are normally distributed, which
+// benchmark timings rarely are.  It uses the normal approximation,
+// with a correction for ties, which is good enough for the handful
+// of samples per side that benchmark runs produce, as long as there
+// are at least a few.
+func mannWhitneyU(a, b []float64) float64 {
+	n1, n2 := float64(len(a)), float64(len(b))
+	if n1 == 0 || n2 == 0 {
+		return 1
+	}
+	type obs struct {
+		value float64
+		fromA bool
+	}
+	all := make([]obs, 0, len(a)+len(b))
+	for _, x := range a {
+		all = append(all, obs{x, true})
+	}
+	for _, x := range b {
+		all = append(all, obs{x, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].value < all[j].value })
+
+	// Assign average ranks to ties, and sum up the ranks of `a`.
+	rankSumA := 0.0
+	tieTerm := 0.0
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].value == all[i].value {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		t := float64(j - i)
+		tieTerm += t*t*t - t
+		for k := i; k < j; k++ {
+			if all[k].fromA {
+				rankSumA += rank
+			}
+		}
+		i = j
+	}
+
+	u := rankSumA - n1*(n1+1)/2
+	n := n1 + n2
+	meanU := n1 * n2 / 2
+	varU := n1 * n2 / 12 * ((n + 1) - tieTerm/(n*(n-1)))
+	if varU <= 0 {
+		// Every value is the same.
+		return 1
+	}
+	// Continuity correction.
+	z := (math.Abs(u-meanU) - 0.5) / math.Sqrt(varU)
+	if z < 0 {
+		z = 0
+	}
+	return math.Erfc(z / math.Sqrt2)
+}
+
+// Comparison compares one measurement of a benchmark between the old
+// and new builds.
+type Comparison struct {
+	Name    string
+	Unit    string
+	OldMean float64
+	OldSD   float64
+	NewMean float64
+	NewSD   float64
+	// Delta is the relative change of the mean, in percent.
+	Delta float64
+	// P is the p-value of the difference.
+	P float64
+	// Significant is set if P is below the significance level.
+	Significant bool
+	// Regression is set if the difference is significant, and the
+	// new build is worse by more than the threshold.
+	Regression bool
+	// Improvement is set if the difference is significant, and the
+	// new build is better by more than the threshold.
+	Improvement bool
+}
+
+// Compare compares every measurement that both `oldSamples` and
+// `newSamples` have.  Differences with a p-value below `alpha` are
+// significant, and significant differences of more than `threshold`
+// percent are regressions or improvements.
+func Compare(oldSamples, newSamples Samples, alpha, threshold float64) (
+	comparisons []Comparison) {
+	for name, oldUnits := range oldSamples {
+		for unit, oldValues := range oldUnits {
+			newValues, ok := newSamples[name][unit]
+			if !ok {
+				continue
+			}
+			c := Comparison{
+				Name:    name,
+				Unit:    unit,
+				OldMean: mean(oldValues),
+				OldSD:   stddev(oldValues),
+				NewMean: mean(newValues),
+				NewSD:   stddev(newValues),
+				P:       mannWhitneyU(oldValues, newValues),
+			}
+			if c.OldMean != 0 {
+				c.Delta = (c.NewMean - c.OldMean) / c.OldMean * 100
+			}
+			c.Significant = c.P < alpha
+			worse := c.Delta
+			if higherIsBetter(unit) {
+				worse = -worse
+			}
+			c.Regression = c.Significant && worse > threshold
+			c.Improvement = c.Significant && -worse > threshold
+			comparisons = append(comparisons, c)
+		}
+	}
+	sort.Slice(comparisons, func(i, j int) bool {
+		if comparisons[i].Name != comparisons[j].Name {
+			return comparisons[i].Name < comparisons[j].Name
+		}
+		return comparisons[i].Unit < comparisons[j].Unit
+	})
+	return comparisons
+}
+
+// WriteReport writes a table of the comparisons to `w`.
+func WriteReport(w io.Writer, oldName, newName string,
+	comparisons []Comparison) error {
+	_, err := fmt.Fprintf(w, "%-32s %-10s %14s %14s %9s %7s\n",
+		"benchmark", "unit", oldName, newName, "delta", "p")
+	if err != nil {
+		return err
+	}
+	for _, c := range comparisons {
+		delta := "~"
+		if c.Significant {
+			delta = fmt.Sprintf("%+.1f%%", c.Delta)
+		}
+		mark := ""
+		switch {
+		case c.Regression:
+			mark = "  REGRESSION"
+		case c.Improvement:
+			mark = "  improvement"
+		}
+		_, err := fmt.Fprintf(w, "%-32s %-10s %14s %14s %9s %7.3f%s\n",
+			strings.TrimPrefix(c.Name, "Benchmark"), c.Unit,
+			formatMeasurement(c.OldMean, c.OldSD),
+			formatMeasurement(c.NewMean, c.NewSD), delta, c.P, mark)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatMeasurement(m, sd float64) string {
+	if m == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("%.4g±%.0f%%", m, sd/m*100)
+}
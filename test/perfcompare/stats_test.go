@@ -0,0 +1,123 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testBenchOutput = `goos: linux
+goarch: amd64
+pkg: github.com/keybase/kbfs/test
+BenchmarkWriteSeq-8   	     100	  12000000 ns/op	   8.50 MB/s	  40000 B/op	     300 allocs/op
+BenchmarkReadSeq-8    	     200	   6000000 ns/op	  17.00 MB/s	  20000 B/op	     150 allocs/op
+--- BENCH: BenchmarkReadSeq-8
+	benchmark_test.go:42: 1 entries
+BenchmarkWriteSeq-8   	     100	  13000000 ns/op	   7.80 MB/s	  40000 B/op	     300 allocs/op
+PASS
+ok  	github.com/keybase/kbfs/test	5.123s
+`
+
+func TestParseBenchOutput(t *testing.T) {
+	s := make(Samples)
+	err := s.ParseBenchOutput(strings.NewReader(testBenchOutput))
+	require.NoError(t, err)
+	require.Equal(t, Samples{
+		"BenchmarkWriteSeq": {
+			"ns/op":     {12000000, 13000000},
+			"MB/s":      {8.5, 7.8},
+			"B/op":      {40000, 40000},
+			"allocs/op": {300, 300},
+		},
+		"BenchmarkReadSeq": {
+			"ns/op":     {6000000},
+			"MB/s":      {17},
+			"B/op":      {20000},
+			"allocs/op": {150},
+		},
+	}, s)
+
+	err = s.ParseBenchOutput(strings.NewReader(
+		"BenchmarkBad-8 10 fast ns/op\n"))
+	require.Error(t, err)
+}
+
+func TestMannWhitneyU(t *testing.T) {
+	same := []float64{1, 1, 1, 1, 1}
+	require.Equal(t, 1.0, mannWhitneyU(same, same))
+	require.Equal(t, 1.0, mannWhitneyU(nil, same))
+
+	// Completely separated samples of 10 each: the exact two-sided
+	// p-value is about 0.00018, and the normal approximation is
+	// close to it.
+	a := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	b := []float64{11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+	p := mannWhitneyU(a, b)
+	require.InDelta(t, 0.00018, p, 0.0002)
+	require.Equal(t, p, mannWhitneyU(b, a))
+
+	// Interleaved samples aren't significantly different.
+	c := []float64{1, 3, 5, 7, 9, 11, 13, 15, 17, 19}
+	d := []float64{2, 4, 6, 8, 10, 12, 14, 16, 18, 20}
+	require.True(t, mannWhitneyU(c, d) > 0.5)
+}
+
+func TestCompare(t *testing.T) {
+	oldSamples := Samples{
+		"BenchmarkWrite": {
+			"ns/op": {100, 101, 99, 100, 102, 98, 100, 101},
+			"MB/s":  {10, 10.1, 9.9, 10, 10.2, 9.8, 10, 10.1},
+		},
+		"BenchmarkRead": {
+			"ns/op": {50, 51, 49, 50, 52, 48, 50, 51},
+		},
+		"BenchmarkOnlyOld": {
+			"ns/op": {1, 1, 1},
+		},
+	}
+	newSamples := Samples{
+		"BenchmarkWrite": {
+			"ns/op": {120, 121, 119, 120, 122, 118, 120, 121},
+			"MB/s":  {8, 8.1, 7.9, 8, 8.2, 7.8, 8, 8.1},
+		},
+		"BenchmarkRead": {
+			"ns/op": {40, 51, 59, 50, 42, 58, 50, 51},
+		},
+	}
+	comparisons := Compare(oldSamples, newSamples, 0.05, 5)
+	require.Len(t, comparisons, 3)
+
+	require.Equal(t, "BenchmarkRead", comparisons[0].Name)
+	require.False(t, comparisons[0].Significant)
+	require.False(t, comparisons[0].Regression)
+
+	require.Equal(t, "MB/s", comparisons[1].Unit)
+	require.True(t, comparisons[1].Regression)
+	require.InDelta(t, -20, comparisons[1].Delta, 0.5)
+
+	require.Equal(t, "ns/op", comparisons[2].Unit)
+	require.True(t, comparisons[2].Regression)
+	require.InDelta(t, 20, comparisons[2].Delta, 0.5)
+
+	// The same slowdown under a bigger threshold is significant,
+	// but not a regression.
+	comparisons = Compare(oldSamples, newSamples, 0.05, 25)
+	require.True(t, comparisons[2].Significant)
+	require.False(t, comparisons[2].Regression)
+
+	// And an improvement the other way around.
+	comparisons = Compare(newSamples, oldSamples, 0.05, 5)
+	require.True(t, comparisons[2].Improvement)
+
+	var buf bytes.Buffer
+	err := WriteReport(&buf, "v1", "v2", comparisons)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "improvement")
+	require.Contains(t, buf.String(), "Write")
+}
@@ -330,6 +330,28 @@ func TestRenameInRoot(t *testing.T) {
 	)
 }
 
+// Renaming a file onto itself must leave its blocks referenced, even
+// after later changes to the same directory.
+func TestRenameOntoSelf(t *testing.T) {
+	test(t,
+		users("alice", "bob"),
+		as(alice,
+			mkfile("a", "hello"),
+			mkfile("b", "goodbye"),
+		),
+		as(alice,
+			rename("a", "a"),
+		),
+		as(alice,
+			rm("b"),
+		),
+		as(bob,
+			lsdir("", m{"a$": "FILE"}),
+			read("a", "hello"),
+		),
+	)
+}
+
 func TestRenameAcrossDirs(t *testing.T) {
 	test(t,
 		users("alice", "bob"),
@@ -0,0 +1,518 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// These tests drive random ops against a TLF, looking for races and
+// for bugs that break the TLF's invariants.  By default they run
+// briefly, on a single device and worker, as part of the normal
+// tests; for a real stress run, use something like:
+//
+//   go test -run TestStress -stress-ops=5000 -stress-runs=10 -stress-seed=0
+//
+// Passing -stress-devices and -stress-workers adds conflicts and
+// concurrent ops to the mix.  Those runs can still trip over
+// conflict resolution races, so they're not on by default.
+//
+// A failed run logs its seed, which can be passed back in with
+// -stress-seed to replay the same schedule of ops (though not the
+// same interleaving of their goroutines).
+
+package test
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	kbname "github.com/keybase/client/go/kbun"
+	"github.com/keybase/kbfs/libkbfs"
+	"github.com/pkg/errors"
+)
+
+var (
+	stressOps = flag.Int("stress-ops", 200,
+		"number of ops each stress test run drives, across all devices")
+	stressRuns = flag.Int("stress-runs", 1,
+		"number of stress test runs, each with the next seed")
+	stressDevices = flag.Int("stress-devices", 1,
+		"number of devices in each stress test run")
+	stressWorkers = flag.Int("stress-workers", 1,
+		"maximum number of goroutines each device runs ops on at once")
+	stressSeed = flag.Int64("stress-seed", 1,
+		"seed of the first stress test run; 0 picks one from the time")
+)
+
+const (
+	// stressBlockSize is small so that files quickly get indirect
+	// blocks.
+	stressBlockSize = 512
+	// stressMaxOpsPerWorker bounds the ops each worker runs in a
+	// round.
+	stressMaxOpsPerWorker = 10
+	// stressMaxDepth is how deep the directories ops pick paths in
+	// go.
+	stressMaxDepth = 2
+	// stressQRAge is enough time for quota reclamation to pick up
+	// blocks unreferenced before it, in tests.
+	stressQRAge = 2 * time.Minute
+	// stressQRPasses is how many times quota reclamation is forced
+	// in a round.  Each pass only reclaims a bounded number of
+	// pointers, and the state checker assumes the last one finished.
+	stressQRPasses = 10
+	// stressSyncAttempts bounds the attempts to sync a device, and
+	// the passes over all the devices, at the end of a round.
+	stressSyncAttempts = 5
+)
+
+var (
+	stressDirNames  = []string{"d0", "d1", "d2"}
+	stressFileNames = []string{"f0", "f1", "f2", "f3"}
+)
+
+// stressRun drives the ops of a single stress test run.  Ops happen
+// in rounds.  In each round some of the devices go offline, so their
+// ops conflict with the others', and then every device runs a random
+// batch of ops on a random number of concurrent workers, with the
+// virtual clock advancing between some of them.  At the end of a
+// round, the offline devices come back and resolve their conflicts,
+// quota reclamation sometimes runs, and then the invariants are
+// checked: every device must see the same tree, and the server-side
+// state of the TLF must be consistent, with no orphaned blocks and a
+// disk usage that matches the blocks referenced.
+//
+// All the randomness comes from the seed, and is drawn up front for
+// each round, so the schedule of ops doesn't depend on how their
+// goroutines interleave.
+type stressRun struct {
+	*opt
+	r       *rand.Rand
+	devices []kbname.NormalizedUsername
+}
+
+// stressRound is the plan for a single round of a stress run.
+type stressRound struct {
+	offline map[kbname.NormalizedUsername]bool
+	// workers holds, for each device, the ops each of its workers
+	// runs in order.
+	workers map[kbname.NormalizedUsername][][]fileOp
+	numOps  int
+	qr      bool
+}
+
+func stressDeviceNames(n int) []username {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("dev%d", i)
+	}
+	// TLF names list the writers in sorted order.
+	sort.Strings(names)
+	devices := make([]username, n)
+	for i, name := range names {
+		devices[i] = username(name)
+	}
+	return devices
+}
+
+func stressTlfName(devices []username) string {
+	var name string
+	for i, d := range devices {
+		if i > 0 {
+			name += ","
+		}
+		name += string(d)
+	}
+	return name
+}
+
+// TestStress runs the stress test over the configured seeds.
+func TestStress(t *testing.T) {
+	seed := *stressSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	devices := stressDeviceNames(*stressDevices)
+	for i := 0; i < *stressRuns; i++ {
+		runSeed := seed + int64(i)
+		t.Run(fmt.Sprintf("seed=%d", runSeed), func(t *testing.T) {
+			test(t,
+				blockSize(stressBlockSize),
+				users(devices...),
+				inPrivateTlf(stressTlfName(devices)),
+				stress(runSeed, *stressOps),
+			)
+		})
+	}
+}
+
+// stress runs a stress test with the given seed, until at least
+// numOps ops have run.
+func stress(seed int64, numOps int) optionOp {
+	return func(o *opt) {
+		o.runInitOnce()
+		o.tb.Logf("Stress run with seed %d (rerun with -stress-seed=%d)",
+			seed, seed)
+		s := &stressRun{
+			opt:     o,
+			r:       rand.New(rand.NewSource(seed)),
+			devices: o.usernames,
+		}
+		// Create the TLF before any device goes offline.
+		for _, d := range s.devices {
+			_, err := runFileOp(s.newCtx(d), initRoot())
+			o.expectSuccess(fmt.Sprintf("Init %s", d), err)
+		}
+		for round, done := 0, 0; done < numOps; round++ {
+			plan := s.planRound(numOps - done)
+			o.tb.Logf("Stress round %d: %d ops, offline=%v, qr=%t",
+				round, plan.numOps, plan.offline, plan.qr)
+			if err := s.runRound(plan); err != nil {
+				o.tb.Fatalf("Stress round %d (seed %d): %+v", round, seed, err)
+			}
+			if err := s.checkInvariants(); err != nil {
+				o.tb.Fatalf("Stress round %d (seed %d) broke an invariant: %+v",
+					round, seed, err)
+			}
+			done += plan.numOps
+		}
+	}
+}
+
+func (s *stressRun) newCtx(d kbname.NormalizedUsername) *ctx {
+	return &ctx{
+		opt:      s.opt,
+		user:     s.users[d],
+		username: d,
+		staller:  s.stallers[d],
+		// Devices with updates disabled can't sync from the
+		// server, so syncing is left to the end of the round.
+		noSyncInit: true,
+	}
+}
+
+func (s *stressRun) randomPath(leaves []string) string {
+	var p string
+	for depth := s.r.Intn(stressMaxDepth + 1); depth > 0; depth-- {
+		p += stressDirNames[s.r.Intn(len(stressDirNames))] + "/"
+	}
+	return p + leaves[s.r.Intn(len(leaves))]
+}
+
+func (s *stressRun) randomBytes(maxLen int) []byte {
+	b := make([]byte, 1+s.r.Intn(maxLen))
+	// Only the schedule needs to be reproducible, so it doesn't
+	// matter that this shares the generator.
+	_, _ = s.r.Read(b)
+	return b
+}
+
+func (s *stressRun) randomOp() fileOp {
+	file := s.randomPath(stressFileNames)
+	switch n := s.r.Intn(100); {
+	case n < 35:
+		return pwriteBSSync(file, s.randomBytes(4*stressBlockSize),
+			int64(s.r.Intn(4*stressBlockSize)), s.r.Intn(2) == 0)
+	case n < 45:
+		return truncate(file, uint64(s.r.Intn(4*stressBlockSize)))
+	case n < 55:
+		return mkdir(s.randomPath(stressDirNames))
+	case n < 70:
+		return rm(file)
+	case n < 77:
+		return rmdir(s.randomPath(stressDirNames))
+	case n < 87:
+		return rename(file, s.randomPath(stressFileNames))
+	case n < 92:
+		return setex(file, s.r.Intn(2) == 0)
+	default:
+		return addTime(time.Duration(s.r.Int63n(int64(10 * time.Minute))))
+	}
+}
+
+func (s *stressRun) planRound(maxOps int) stressRound {
+	plan := stressRound{
+		offline: make(map[kbname.NormalizedUsername]bool),
+		workers: make(map[kbname.NormalizedUsername][][]fileOp),
+		qr:      s.r.Intn(4) == 0,
+	}
+	for _, d := range s.devices {
+		// A lone device has nothing to conflict with.
+		if len(s.devices) > 1 && s.r.Intn(3) == 0 {
+			plan.offline[d] = true
+		}
+		numWorkers := 1 + s.r.Intn(*stressWorkers)
+		for i := 0; i < numWorkers && plan.numOps < maxOps; i++ {
+			numOps := 1 + s.r.Intn(stressMaxOpsPerWorker)
+			if numOps > maxOps-plan.numOps {
+				numOps = maxOps - plan.numOps
+			}
+			ops := make([]fileOp, numOps)
+			for j := range ops {
+				ops[j] = s.randomOp()
+			}
+			plan.workers[d] = append(plan.workers[d], ops)
+			plan.numOps += numOps
+		}
+	}
+	return plan
+}
+
+// isExpectedStressError returns whether err is one that a random op
+// can legitimately get, like trying to remove a file that another
+// op already removed.
+func isExpectedStressError(err error) bool {
+	switch errors.Cause(err).(type) {
+	case libkbfs.NoSuchNameError, libkbfs.NameExistsError,
+		libkbfs.DirNotEmptyError:
+		return true
+	}
+	if os.IsNotExist(err) || os.IsExist(err) {
+		return true
+	}
+	switch e := errors.Cause(err).(type) {
+	case *os.PathError:
+		return e.Err == syscall.ENOTEMPTY
+	case *os.LinkError:
+		return e.Err == syscall.ENOTEMPTY
+	}
+	return false
+}
+
+func (s *stressRun) runWorker(d kbname.NormalizedUsername, ops []fileOp) error {
+	c := s.newCtx(d)
+	for _, fop := range ops {
+		desc, err := runFileOp(c, fop)
+		if err != nil && !isExpectedStressError(err) {
+			return errors.Wrapf(err, "%s", desc)
+		}
+	}
+	return nil
+}
+
+func (s *stressRun) runRound(plan stressRound) error {
+	for _, d := range s.devices {
+		if !plan.offline[d] {
+			continue
+		}
+		if _, err := s.syncFromServer(d); err != nil {
+			return err
+		}
+		err := s.engine.DisableUpdatesForTesting(
+			s.users[d], s.tlfName, s.tlfType)
+		if err != nil {
+			return err
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, plan.numOps)
+	for _, d := range s.devices {
+		for _, ops := range plan.workers[d] {
+			wg.Add(1)
+			go func(d kbname.NormalizedUsername, ops []fileOp) {
+				defer wg.Done()
+				if err := s.runWorker(d, ops); err != nil {
+					errs <- errors.Wrapf(err, "%s", d)
+				}
+			}(d, ops)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return err
+	}
+
+	for _, d := range s.devices {
+		err := s.engine.SyncAll(s.users[d], s.tlfName, s.tlfType)
+		if err != nil {
+			return err
+		}
+	}
+	for _, d := range s.devices {
+		if !plan.offline[d] {
+			continue
+		}
+		err := s.engine.ReenableUpdates(s.users[d], s.tlfName, s.tlfType)
+		if err != nil {
+			return err
+		}
+		if _, err := s.syncFromServer(d); err != nil {
+			return err
+		}
+	}
+	if err := s.syncDevices(); err != nil {
+		return err
+	}
+	if plan.qr {
+		s.clock.Add(stressQRAge)
+		d := s.devices[s.r.Intn(len(s.devices))]
+		for i := 0; i < stressQRPasses; i++ {
+			err := s.engine.ForceQuotaReclamation(
+				s.users[d], s.tlfName, s.tlfType)
+			if err != nil {
+				return err
+			}
+			// Syncing waits for the reclamation to finish.
+			if _, err := s.syncFromServer(d); err != nil {
+				return err
+			}
+		}
+		if err := s.syncDevices(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncDevices syncs all the devices from the server, until they've
+// all caught up with each other's conflict resolutions.
+func (s *stressRun) syncDevices() error {
+	for pass := 0; ; pass++ {
+		restarted := false
+		for _, d := range s.devices {
+			r, err := s.syncFromServer(d)
+			if err != nil {
+				return err
+			}
+			restarted = restarted || r
+		}
+		// Once every device is merged, one more pass without any
+		// new resolutions catches them all up.
+		if pass > 0 && !restarted {
+			return nil
+		}
+		if pass == stressSyncAttempts {
+			return errors.Errorf(
+				"Devices still resolving conflicts after %d passes", pass)
+		}
+	}
+}
+
+// syncFromServer syncs the given device from the server.  While
+// devices race to resolve their conflicts, one's resolution can lose
+// to another's, and then it stays staged until something restarts
+// conflict resolution, which cycling its updates does.  It returns
+// whether it had to do that.
+func (s *stressRun) syncFromServer(d kbname.NormalizedUsername) (
+	restarted bool, err error) {
+	u := s.users[d]
+	var syncErr error
+	for i := 0; i < stressSyncAttempts; i++ {
+		syncErr = s.engine.SyncFromServer(u, s.tlfName, s.tlfType)
+		if syncErr == nil {
+			return restarted, nil
+		}
+		s.tb.Logf("Syncing %s failed, attempt %d: %+v", d, i+1, syncErr)
+		err = s.engine.DisableUpdatesForTesting(u, s.tlfName, s.tlfType)
+		if err != nil {
+			return restarted, err
+		}
+		err = s.engine.ReenableUpdates(u, s.tlfName, s.tlfType)
+		if err != nil {
+			return restarted, err
+		}
+		restarted = true
+	}
+	return restarted, errors.Wrapf(syncErr, "Syncing %s", d)
+}
+
+// snapshot returns the type and contents of every entry in the tree,
+// as seen by the given device.
+func (s *stressRun) snapshot(d kbname.NormalizedUsername) (
+	map[string]string, error) {
+	c := s.newCtx(d)
+	if _, err := runFileOp(c, initRoot()); err != nil {
+		return nil, err
+	}
+	tree := make(map[string]string)
+	var walk func(dir Node, prefix string) error
+	walk = func(dir Node, prefix string) error {
+		children, err := s.engine.GetDirChildrenTypes(c.user, dir)
+		if err != nil {
+			return err
+		}
+		for name, ty := range children {
+			p := prefix + name
+			n, symPath, err := s.engine.Lookup(c.user, dir, name)
+			if err != nil {
+				return err
+			}
+			switch ty {
+			case "DIR":
+				tree[p] = ty
+				if err := walk(n, p+"/"); err != nil {
+					return err
+				}
+			case "SYM":
+				tree[p] = ty + ":" + symPath
+			default:
+				contents, err := s.readAll(c, n)
+				if err != nil {
+					return err
+				}
+				tree[p] = ty + ":" + string(contents)
+			}
+		}
+		return nil
+	}
+	if err := walk(c.rootNode, ""); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+func (s *stressRun) readAll(c *ctx, file Node) ([]byte, error) {
+	var contents []byte
+	buf := make([]byte, 4*stressBlockSize)
+	for {
+		n, err := s.engine.ReadFile(c.user, file, int64(len(contents)), buf)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return contents, nil
+		}
+		contents = append(contents, buf[:n]...)
+	}
+}
+
+func (s *stressRun) checkInvariants() error {
+	first := s.devices[0]
+	expected, err := s.snapshot(first)
+	if err != nil {
+		return err
+	}
+	for _, d := range s.devices[1:] {
+		tree, err := s.snapshot(d)
+		if err != nil {
+			return err
+		}
+		if reflect.DeepEqual(tree, expected) {
+			continue
+		}
+		var diffs []string
+		for p, e := range expected {
+			if g, ok := tree[p]; !ok {
+				diffs = append(diffs, fmt.Sprintf("%s: missing", p))
+			} else if g != e {
+				diffs = append(diffs, fmt.Sprintf("%s: differs", p))
+			}
+		}
+		for p := range tree {
+			if _, ok := expected[p]; !ok {
+				diffs = append(diffs, fmt.Sprintf("%s: extra", p))
+			}
+		}
+		sort.Strings(diffs)
+		return errors.Errorf("%s didn't converge with %s: %v",
+			d, first, diffs)
+	}
+	return s.engine.CheckState(s.users[first], s.tlfName, s.tlfType)
+}
@@ -0,0 +1,191 @@
+// Copyright 2018 Keybase Inc. All rights reserved.
+// Use of this source code is governed by a BSD
+// license that can be found in the LICENSE file.
+
+// These tests check that folders written by older clients keep
+// working once a client is upgraded, and that older clients fail
+// cleanly on anything written in a format they don't understand.
+//
+// Older clients are emulated by lowering the versions a client will
+// read and write (see clientVersions()); the rest of the client code
+// is the current one.  That means an emulated older client can
+// still produce blocks in newer formats (e.g., a directory split
+// across several blocks), so tests should keep older clients'
+// writes within the formats they understand.
+
+package test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/keybase/kbfs/kbfsmd"
+	"github.com/keybase/kbfs/libkbfs"
+)
+
+// clientGeneration is the set of formats a generation of clients
+// understands.
+type clientGeneration struct {
+	mdVer   kbfsmd.MetadataVer
+	dataVer libkbfs.DataVer
+}
+
+func (g clientGeneration) String() string {
+	return fmt.Sprintf("%s,DataVer(%d)", g.mdVer, g.dataVer)
+}
+
+// privateMDVer returns the version of the MD a client of this
+// generation writes for a plain private folder, i.e. one without
+// unresolved assertions, conflicts or teams.
+func (g clientGeneration) privateMDVer() kbfsmd.MetadataVer {
+	if g.mdVer < kbfsmd.SegregatedKeyBundlesVer {
+		return kbfsmd.PreExtraMetadataVer
+	}
+	return kbfsmd.SegregatedKeyBundlesVer
+}
+
+// clientGenerations lists client generations from oldest to newest;
+// the last one is the current client.
+var clientGenerations = []clientGeneration{
+	{kbfsmd.PreExtraMetadataVer, libkbfs.ChildHolesDataVer},
+	{kbfsmd.InitialExtraMetadataVer, libkbfs.AtLeastTwoLevelsOfChildrenDataVer},
+	{kbfsmd.SegregatedKeyBundlesVer, libkbfs.AtLeastTwoLevelsOfChildrenDataVer},
+	{kbfsmd.ImplicitTeamsVer, libkbfs.IndirectDirsDataVer},
+}
+
+// generation makes the given user act like a client of generation g.
+func generation(user username, g clientGeneration) optionOp {
+	return clientVersions(user, g.mdVer, g.dataVer)
+}
+
+// testOverUpgrades runs the test built by makeOps once for every
+// pair of client generations where from is older than to.
+func testOverUpgrades(
+	t *testing.T, makeOps func(from, to clientGeneration) []optionOp) {
+	for i, from := range clientGenerations {
+		for _, to := range clientGenerations[i+1:] {
+			from, to := from, to // capture range variables.
+			t.Run(fmt.Sprintf("%s->%s", from, to), func(t *testing.T) {
+				runOneTestOrBenchmark(t, from.mdVer, makeOps(from, to)...)
+			})
+		}
+	}
+}
+
+// alice and bob both use an old client; alice upgrades, reads
+// everything back, and converts the folder by writing to it.  bob
+// keeps working on the old client until the folder's MD is too new
+// for him.
+func TestUpgradeReadWriteConvert(t *testing.T) {
+	testOverUpgrades(t, func(from, to clientGeneration) []optionOp {
+		ops := []optionOp{
+			users("alice", "bob"),
+			generation(alice, from),
+			generation(bob, from),
+			as(alice,
+				mkfile("a/b", "hello"),
+				mkdir("a/c"),
+				link("a/d", "b"),
+				setex("a/b", true),
+				mkfile("e", "world"),
+				checkMDVersion(from.privateMDVer()),
+			),
+			generation(alice, to),
+			as(alice,
+				lsdir("a/", m{"b$": "EXEC", "c$": "DIR", "d$": "SYM"}),
+				read("a/b", "hello"),
+				read("a/d", "hello"),
+				read("e", "world"),
+				// Reading alone doesn't convert anything.
+				checkMDVersion(from.privateMDVer()),
+			),
+			as(bob,
+				write("a/b", "hello again"),
+			),
+			as(alice,
+				read("a/b", "hello again"),
+				write("a/c/f", "new"),
+				rename("e", "a/e"),
+				rm("a/d"),
+				checkMDVersion(to.privateMDVer()),
+			),
+		}
+		if to.privateMDVer() > from.mdVer {
+			return append(ops,
+				as(bob,
+					expectError(initRoot(), "The metadata for folder"),
+					noSync(),
+				),
+			)
+		}
+		return append(ops,
+			as(bob,
+				lsdir("a/", m{"b$": "EXEC", "c$": "DIR", "e$": "FILE"}),
+				read("a/c/f", "new"),
+				read("a/e", "world"),
+				write("a/c/g", "old"),
+			),
+			as(alice,
+				read("a/c/g", "old"),
+			),
+		)
+	})
+}
+
+// alice's newer client splits a directory across several blocks,
+// which bob's older client can't read.  Both use the same MD
+// version, so only the data version differs.
+func TestUpgradeNewDataVersion(t *testing.T) {
+	current := clientGenerations[len(clientGenerations)-1]
+	for _, old := range clientGenerations {
+		if old.dataVer >= libkbfs.IndirectDirsDataVer {
+			continue
+		}
+		old := old // capture range variable.
+		t.Run(old.String(), func(t *testing.T) {
+			runOneTestOrBenchmark(t, old.mdVer,
+				blockSize(20), users("alice", "bob"),
+				clientVersions(alice, old.mdVer, current.dataVer),
+				generation(bob, old),
+				as(alice,
+					mkfile("a/b", "b"),
+					mkfile("a/c", "c"),
+					mkfile("a/d", "d"),
+					mkfile("a/e", "e"),
+					mkfile("a/f", "f"),
+				),
+				as(bob,
+					lsdir("", m{"a$": "DIR"}),
+					expectError(lsdir("a/", m{}), "The data at path"),
+				),
+			)
+		})
+	}
+}
+
+// alice's current client writes to an implicit team folder, whose MD
+// bob's older client can't read.
+func TestUpgradeImplicitTeamMDVersion(t *testing.T) {
+	for _, old := range clientGenerations {
+		if old.mdVer >= kbfsmd.ImplicitTeamsVer {
+			continue
+		}
+		old := old // capture range variable.
+		t.Run(old.String(), func(t *testing.T) {
+			runOneTestOrBenchmark(t, kbfsmd.ImplicitTeamsVer,
+				users("alice", "bob"),
+				implicitTeam("alice,bob", ""),
+				inPrivateTlf("alice,bob"),
+				generation(bob, old),
+				as(alice,
+					mkfile("a", "hello"),
+					checkMDVersion(kbfsmd.ImplicitTeamsVer),
+				),
+				as(bob,
+					expectError(initRoot(), "The metadata for folder"),
+					noSync(),
+				),
+			)
+		})
+	}
+}